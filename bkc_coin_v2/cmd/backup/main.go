@@ -0,0 +1,404 @@
+// Command backup takes, verifies and restores database backups. Logical
+// dumps go through pg_dump/pg_restore, physical backups and point-in-time
+// recovery through wal-g (configured by its usual WALG_* / AWS_* variables).
+// Every restore ends with the accounting invariants (db.CheckInvariants) and
+// a ledger comparison against the manifest written next to the dump.
+//
+// Nightly logical dump, then prove it restores:
+//
+//	go run ./cmd/backup dump -db "$DATABASE_URL" -out /backups/bkc-$(date +%F).dump
+//	go run ./cmd/backup verify -dump /backups/bkc-2025-06-01.dump -admin-db postgres://postgres@localhost/postgres
+//
+// Physical base backup (run on the database host; WAL archiving must use
+// archive_command = 'wal-g wal-push %p'):
+//
+//	go run ./cmd/backup walg-push -pgdata /var/lib/postgresql/16/main -retain 7
+//
+// Restore a dump into an empty database:
+//
+//	go run ./cmd/backup restore -dump /backups/bkc-2025-06-01.dump -db postgres://.../bkc_restored
+//
+// Point-in-time recovery, e.g. to just before a bad deploy at 14:05 UTC:
+//
+//  1. Stop the app and Postgres; move the old data directory aside.
+//  2. go run ./cmd/backup pitr -pgdata /var/lib/postgresql/16/main -target-time 2025-06-01T14:04:00Z
+//  3. Start Postgres; it replays WAL up to the target and promotes.
+//  4. go run ./cmd/backup validate -db "$DATABASE_URL" -target-time 2025-06-01T14:04:00Z
+//  5. Start the app only if validate exits 0.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// manifest is written to <dump>.json and checked by verify and restore.
+type manifest struct {
+	CreatedAt time.Time        `json:"created_at"`
+	File      string           `json:"file"`
+	SHA256    string           `json:"sha256"`
+	Size      int64            `json:"size"`
+	Ledger    db.LedgerSummary `json:"ledger"`
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	var err error
+	switch cmd {
+	case "dump":
+		err = runDump(ctx, args)
+	case "verify":
+		err = runVerify(ctx, args)
+	case "restore":
+		err = runRestore(ctx, args)
+	case "validate":
+		err = runValidate(ctx, args)
+	case "walg-push":
+		err = runWalgPush(ctx, args)
+	case "pitr":
+		err = runPITR(ctx, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("backup %s: %v", cmd, err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup dump|verify|restore|validate|walg-push|pitr [flags] (see -h of each)")
+	os.Exit(2)
+}
+
+// runDump dumps the database with pg_dump from an exported snapshot, so the
+// manifest's ledger summary describes exactly the rows in the dump.
+func runDump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	dbURL := fs.String("db", os.Getenv("DATABASE_URL"), "database to dump")
+	out := fs.String("out", "", "output file (custom format)")
+	_ = fs.Parse(args)
+	if *dbURL == "" || *out == "" {
+		return errors.New("-db and -out are required")
+	}
+
+	conn, err := pgx.Connect(ctx, *dbURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+	var snapshot string
+	if err := tx.QueryRow(ctx, `SELECT pg_export_snapshot()`).Scan(&snapshot); err != nil {
+		return err
+	}
+	summary, err := db.LedgerSummaryTx(ctx, tx, 0)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("dumping to %s (snapshot %s, %d ledger rows)", *out, snapshot, summary.Rows)
+	if err := run(ctx, "pg_dump", "--format=custom", "--no-owner", "--snapshot="+snapshot, "--file="+*out, "--dbname="+*dbURL); err != nil {
+		return err
+	}
+	sum, size, err := fileSHA256(*out)
+	if err != nil {
+		return err
+	}
+	m := manifest{CreatedAt: time.Now().UTC(), File: filepath.Base(*out), SHA256: sum, Size: size, Ledger: summary}
+	raw, _ := json.MarshalIndent(m, "", "  ")
+	if err := os.WriteFile(*out+".json", raw, 0o644); err != nil {
+		return err
+	}
+	log.Printf("ok: %s (%d bytes, sha256 %s)", *out, size, sum)
+	return nil
+}
+
+// runVerify restores a dump into a scratch database, checks it and drops it.
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dump := fs.String("dump", "", "dump file written by backup dump")
+	adminURL := fs.String("admin-db", "", "connection allowed to CREATE/DROP DATABASE (e.g. .../postgres)")
+	keep := fs.Bool("keep", false, "keep the scratch database")
+	_ = fs.Parse(args)
+	if *dump == "" || *adminURL == "" {
+		return errors.New("-dump and -admin-db are required")
+	}
+	m, err := readManifest(*dump)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("bkc_verify_%d", time.Now().Unix())
+	scratchURL, err := withDatabase(*adminURL, name)
+	if err != nil {
+		return err
+	}
+	admin, err := pgx.Connect(ctx, *adminURL)
+	if err != nil {
+		return err
+	}
+	defer admin.Close(context.Background())
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return err
+	}
+	if !*keep {
+		defer func() {
+			if _, err := admin.Exec(context.Background(), "DROP DATABASE IF EXISTS "+pgx.Identifier{name}.Sanitize()+" WITH (FORCE)"); err != nil {
+				log.Printf("drop %s: %v", name, err)
+			}
+		}()
+	}
+
+	log.Printf("restoring %s into scratch database %s", *dump, name)
+	if err := run(ctx, "pg_restore", "--no-owner", "--no-privileges", "--exit-on-error", "--dbname="+scratchURL, *dump); err != nil {
+		return err
+	}
+	return validate(ctx, scratchURL, m, time.Time{})
+}
+
+// runRestore loads a dump into a target database and validates it. The
+// target must be empty unless -clean is given.
+func runRestore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dump := fs.String("dump", "", "dump file written by backup dump")
+	dbURL := fs.String("db", "", "target database")
+	clean := fs.Bool("clean", false, "drop existing objects in the target first")
+	_ = fs.Parse(args)
+	if *dump == "" || *dbURL == "" {
+		return errors.New("-dump and -db are required")
+	}
+	m, err := readManifest(*dump)
+	if err != nil {
+		return err
+	}
+	if !*clean {
+		d, err := db.Connect(ctx, *dbURL)
+		if err != nil {
+			return err
+		}
+		var exists bool
+		err = d.Pool.QueryRow(ctx, `SELECT to_regclass('public.ledger') IS NOT NULL`).Scan(&exists)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.New("target already has a ledger; use an empty database or -clean")
+		}
+	}
+
+	restoreArgs := []string{"--no-owner", "--no-privileges", "--exit-on-error", "--dbname=" + *dbURL}
+	if *clean {
+		restoreArgs = append(restoreArgs, "--clean", "--if-exists")
+	}
+	log.Printf("restoring %s", *dump)
+	if err := run(ctx, "pg_restore", append(restoreArgs, *dump)...); err != nil {
+		return err
+	}
+	return validate(ctx, *dbURL, m, time.Time{})
+}
+
+// runValidate checks a live or recovered database. With -target-time the
+// ledger must not contain rows after the recovery target.
+func runValidate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	dbURL := fs.String("db", os.Getenv("DATABASE_URL"), "database to check")
+	target := fs.String("target-time", "", "recovery target (RFC 3339), optional")
+	_ = fs.Parse(args)
+	if *dbURL == "" {
+		return errors.New("-db is required")
+	}
+	var at time.Time
+	if *target != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *target); err != nil {
+			return fmt.Errorf("bad -target-time: %w", err)
+		}
+	}
+	return validate(ctx, *dbURL, nil, at)
+}
+
+func validate(ctx context.Context, dbURL string, m *manifest, target time.Time) error {
+	d, err := db.Connect(ctx, dbURL)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	var failed bool
+	violations, err := d.CheckInvariants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range violations {
+		failed = true
+		fmt.Printf("FAIL %s: %d row(s) %s\n", v.Name, v.Count, v.Sample)
+	}
+	summary, err := d.LedgerSummary(ctx, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ledger: %d rows, max id %d, minted %d, checksum %s\n", summary.Rows, summary.MaxID, summary.Minted, summary.Checksum)
+	if m != nil && (summary.Rows != m.Ledger.Rows || summary.MaxID != m.Ledger.MaxID || summary.Checksum != m.Ledger.Checksum) {
+		failed = true
+		fmt.Printf("FAIL ledger differs from manifest: %d rows, max id %d, checksum %s\n", m.Ledger.Rows, m.Ledger.MaxID, m.Ledger.Checksum)
+	}
+	if !target.IsZero() && summary.MaxTS != nil && summary.MaxTS.After(target) {
+		failed = true
+		fmt.Printf("FAIL ledger has rows after the target time (last %s)\n", summary.MaxTS.UTC().Format(time.RFC3339))
+	}
+	if failed {
+		return errors.New("validation failed")
+	}
+	fmt.Println("ok: invariants hold")
+	return nil
+}
+
+// runWalgPush takes a physical base backup and prunes old ones.
+func runWalgPush(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("walg-push", flag.ExitOnError)
+	pgdata := fs.String("pgdata", os.Getenv("PGDATA"), "Postgres data directory")
+	retain := fs.Int("retain", 0, "keep this many full backups (0 = no pruning)")
+	_ = fs.Parse(args)
+	if *pgdata == "" {
+		return errors.New("-pgdata is required")
+	}
+	if err := run(ctx, "wal-g", "backup-push", *pgdata); err != nil {
+		return err
+	}
+	if *retain > 0 {
+		if err := run(ctx, "wal-g", "delete", "retain", "FULL", fmt.Sprint(*retain), "--confirm"); err != nil {
+			return err
+		}
+	}
+	return run(ctx, "wal-g", "backup-list")
+}
+
+// runPITR fetches a base backup into an empty data directory and configures
+// recovery up to the target time. Postgres is started by the operator.
+func runPITR(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("pitr", flag.ExitOnError)
+	pgdata := fs.String("pgdata", os.Getenv("PGDATA"), "empty Postgres data directory")
+	backup := fs.String("backup", "LATEST", "wal-g backup name")
+	target := fs.String("target-time", "", "recovery target (RFC 3339)")
+	_ = fs.Parse(args)
+	if *pgdata == "" || *target == "" {
+		return errors.New("-pgdata and -target-time are required")
+	}
+	at, err := time.Parse(time.RFC3339, *target)
+	if err != nil {
+		return fmt.Errorf("bad -target-time: %w", err)
+	}
+	if entries, err := os.ReadDir(*pgdata); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty; move the old data directory aside", *pgdata)
+	}
+
+	if err := run(ctx, "wal-g", "backup-fetch", *pgdata, *backup); err != nil {
+		return err
+	}
+	conf := fmt.Sprintf("\n# backup pitr %s\nrestore_command = 'wal-g wal-fetch \"%%f\" \"%%p\"'\nrecovery_target_time = '%s'\nrecovery_target_action = 'promote'\n",
+		time.Now().UTC().Format(time.RFC3339), at.UTC().Format("2006-01-02 15:04:05+00"))
+	f, err := os.OpenFile(filepath.Join(*pgdata, "postgresql.auto.conf"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(conf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*pgdata, "recovery.signal"), nil, 0o600); err != nil {
+		return err
+	}
+	log.Printf("ok: start Postgres, then run: backup validate -db <url> -target-time %s", *target)
+	return nil
+}
+
+func run(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// readManifest loads <dump>.json and checks the dump against it. A dump
+// without a manifest is restored without the ledger comparison.
+func readManifest(dump string) (*manifest, error) {
+	raw, err := os.ReadFile(dump + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("no manifest for %s: skipping checksum and ledger comparison", dump)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	sum, _, err := fileSHA256(dump)
+	if err != nil {
+		return nil, err
+	}
+	if sum != m.SHA256 {
+		return nil, fmt.Errorf("%s: sha256 %s does not match manifest %s", dump, sum, m.SHA256)
+	}
+	return &m, nil
+}
+
+// withDatabase returns connURL pointing at another database.
+func withDatabase(connURL, name string) (string, error) {
+	u, err := url.Parse(connURL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", errors.New("-admin-db must be a postgres:// URL")
+	}
+	u.Path = "/" + strings.TrimPrefix(name, "/")
+	return u.String(), nil
+}
@@ -0,0 +1,284 @@
+// Command loadgen simulates many users tapping against the tap endpoint and
+// reports throughput, latency percentiles and, when -db is given, how many
+// Postgres row writes and WAL bytes each accepted tap request cost.
+//
+//	go run ./cmd/loadgen -url http://localhost:8080 -users 500 -rate 5 -duration 1m \
+//	    -bot-token "$BOT_TOKEN" -db "$DATABASE_URL"
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bkc_coin_v2/internal/telegram"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type options struct {
+	baseURL  string
+	path     string
+	users    int
+	userBase int64
+	rate     float64
+	batch    int64
+	duration time.Duration
+	rampUp   time.Duration
+	timeout  time.Duration
+	botToken string
+	dbURL    string
+	settle   time.Duration
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.baseURL, "url", "http://localhost:8080", "server base URL")
+	flag.StringVar(&o.path, "path", "/api/v1/tap/process", "tap endpoint path")
+	flag.IntVar(&o.users, "users", 100, "number of simulated users")
+	flag.Int64Var(&o.userBase, "user-base", 9_000_000_000, "Telegram id of the first simulated user")
+	flag.Float64Var(&o.rate, "rate", 2, "tap requests per second per user")
+	flag.Int64Var(&o.batch, "taps", 1, "taps per request (multi-touch batch)")
+	flag.DurationVar(&o.duration, "duration", 30*time.Second, "test duration")
+	flag.DurationVar(&o.rampUp, "ramp-up", 5*time.Second, "time over which users start")
+	flag.DurationVar(&o.timeout, "timeout", 10*time.Second, "per-request timeout")
+	flag.StringVar(&o.botToken, "bot-token", os.Getenv("BOT_TOKEN"), "bot token used to sign initData")
+	flag.StringVar(&o.dbURL, "db", "", "Postgres URL for write amplification stats (optional)")
+	flag.DurationVar(&o.settle, "settle", 5*time.Second, "wait after the run for async flushes before reading DB stats")
+	flag.Parse()
+
+	if o.users <= 0 || o.rate <= 0 || o.batch <= 0 || o.duration <= 0 {
+		log.Fatal("users, rate, taps and duration must be > 0")
+	}
+	if o.botToken == "" {
+		log.Fatal("-bot-token (or BOT_TOKEN) is required to sign initData")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var pool *pgxpool.Pool
+	var before dbStats
+	if o.dbURL != "" {
+		var err error
+		if pool, err = pgxpool.New(ctx, o.dbURL); err != nil {
+			log.Fatalf("db: %v", err)
+		}
+		defer pool.Close()
+		if before, err = readDBStats(ctx, pool); err != nil {
+			log.Fatalf("db stats: %v", err)
+		}
+	}
+
+	log.Printf("loadgen: %d users × %.2f req/s × %d taps for %s against %s%s",
+		o.users, o.rate, o.batch, o.duration, o.baseURL, o.path)
+	res := run(ctx, o)
+	res.print(o)
+
+	if pool != nil {
+		time.Sleep(o.settle)
+		after, err := readDBStats(context.Background(), pool)
+		if err != nil {
+			log.Fatalf("db stats: %v", err)
+		}
+		printWriteAmplification(before, after, res.ok.Load(), res.ok.Load()*o.batch)
+	}
+}
+
+type result struct {
+	ok, failed, errors atomic.Int64
+	status             sync.Map // int -> *atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	elapsed   time.Duration
+}
+
+func (r *result) record(code int, d time.Duration, err error) {
+	if err != nil {
+		r.errors.Add(1)
+	} else {
+		c, _ := r.status.LoadOrStore(code, new(atomic.Int64))
+		c.(*atomic.Int64).Add(1)
+		if code >= 200 && code < 300 {
+			r.ok.Add(1)
+		} else {
+			r.failed.Add(1)
+		}
+	}
+	r.mu.Lock()
+	r.latencies = append(r.latencies, d)
+	r.mu.Unlock()
+}
+
+func run(ctx context.Context, o options) *result {
+	ctx, cancel := context.WithTimeout(ctx, o.duration)
+	defer cancel()
+
+	client := &http.Client{
+		Timeout: o.timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        o.users,
+			MaxIdleConnsPerHost: o.users,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	res := &result{}
+	interval := time.Duration(float64(time.Second) / o.rate)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.users; i++ {
+		userID := o.userBase + int64(i)
+		delay := time.Duration(0)
+		if o.users > 1 {
+			delay = o.rampUp * time.Duration(i) / time.Duration(o.users)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			initData := telegram.SignWebAppInitData(telegram.AuthUser{
+				ID:        userID,
+				Username:  fmt.Sprintf("load%d", userID),
+				FirstName: "Load",
+			}, o.botToken, time.Now())
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					code, d, err := tap(ctx, client, o, userID, initData)
+					if ctx.Err() != nil {
+						return // cut off by the end of the run, not a server error
+					}
+					res.record(code, d, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	return res
+}
+
+func tap(ctx context.Context, client *http.Client, o options, userID int64, initData string) (int, time.Duration, error) {
+	body, _ := json.Marshal(map[string]any{
+		"user_id":   userID,
+		"taps":      o.batch,
+		"timestamp": time.Now().UnixMilli(),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+o.path, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Init-Data", initData)
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(started), err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(started), nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func (r *result) print(o options) {
+	r.mu.Lock()
+	lat := append([]time.Duration(nil), r.latencies...)
+	r.mu.Unlock()
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	total := int64(len(lat))
+	secs := r.elapsed.Seconds()
+	fmt.Printf("\nrequests: %d in %s (%.1f req/s, %.1f taps/s accepted)\n",
+		total, r.elapsed.Round(time.Millisecond), float64(total)/secs, float64(r.ok.Load()*o.batch)/secs)
+	fmt.Printf("ok: %d  non-2xx: %d  transport errors: %d\n", r.ok.Load(), r.failed.Load(), r.errors.Load())
+
+	var codes []int
+	r.status.Range(func(k, _ any) bool {
+		codes = append(codes, k.(int))
+		return true
+	})
+	sort.Ints(codes)
+	for _, c := range codes {
+		n, _ := r.status.Load(c)
+		fmt.Printf("  HTTP %d: %d\n", c, n.(*atomic.Int64).Load())
+	}
+	fmt.Printf("latency p50=%s p90=%s p99=%s max=%s\n",
+		percentile(lat, 0.50).Round(time.Microsecond),
+		percentile(lat, 0.90).Round(time.Microsecond),
+		percentile(lat, 0.99).Round(time.Microsecond),
+		percentile(lat, 1).Round(time.Microsecond))
+}
+
+// dbStats are cumulative Postgres counters for the current database.
+type dbStats struct {
+	rowWrites int64 // inserted + updated + deleted tuples
+	commits   int64
+	walBytes  int64 // 0 when pg_stat_wal is unavailable (PG < 14)
+}
+
+func readDBStats(ctx context.Context, pool *pgxpool.Pool) (dbStats, error) {
+	var s dbStats
+	err := pool.QueryRow(ctx, `
+SELECT tup_inserted + tup_updated + tup_deleted, xact_commit
+FROM pg_stat_database
+WHERE datname = current_database()
+`).Scan(&s.rowWrites, &s.commits)
+	if err != nil {
+		return s, err
+	}
+	// Optional: needs PG14+ and pg_monitor rights.
+	_ = pool.QueryRow(ctx, `SELECT wal_bytes::bigint FROM pg_stat_wal`).Scan(&s.walBytes)
+	return s, nil
+}
+
+func printWriteAmplification(before, after dbStats, requests, taps int64) {
+	rows := after.rowWrites - before.rowWrites
+	commits := after.commits - before.commits
+	wal := after.walBytes - before.walBytes
+	fmt.Printf("\nDB: %d row writes, %d commits", rows, commits)
+	if wal > 0 {
+		fmt.Printf(", %d WAL bytes", wal)
+	}
+	fmt.Println()
+	if requests == 0 {
+		return
+	}
+	fmt.Printf("write amplification: %.3f rows/request, %.4f rows/tap, %.3f commits/request",
+		float64(rows)/float64(requests), float64(rows)/float64(taps), float64(commits)/float64(requests))
+	if wal > 0 {
+		fmt.Printf(", %.1f WAL bytes/request", float64(wal)/float64(requests))
+	}
+	fmt.Println()
+	fmt.Println("(pg_stat_database counters are database-wide and refreshed lazily; run on an otherwise idle database)")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 
 	"bkc_coin_v2/internal/config"
 	"bkc_coin_v2/internal/database"
+	"bkc_coin_v2/internal/faults"
 	"bkc_coin_v2/internal/games"
 	"bkc_coin_v2/internal/monitoring"
 	"bkc_coin_v2/internal/payments"
@@ -24,6 +26,20 @@ import (
 )
 
 func main() {
+	// --mock-chains: TON/Solana/Helius заменяются детерминированными заглушками,
+	// чтобы прогонять весь цикл платежа в staging и тестах без ключей
+	mockChains := flag.Bool("mock-chains", false, "use deterministic fake chain clients instead of TON/Solana/Helius")
+	mockDelay := flag.Duration("mock-confirm-delay", 5*time.Second, "mock chains: time until a payment confirms")
+	mockFailureRate := flag.Float64("mock-failure-rate", 0, "mock chains: share of payment checks failing with an RPC error (0..1)")
+	mockDropRate := flag.Float64("mock-drop-rate", 0, "mock chains: share of orders whose payment never arrives (0..1)")
+	mockSeed := flag.Int64("mock-seed", 1, "mock chains: seed for failure injection")
+	network := flag.String("network", "", "payments network: mainnet or sandbox (Solana devnet + TON testnet); default $PAYMENTS_NETWORK")
+	faultRules := flag.String("faults", "", "inject failures, e.g. 'solana.rpc=delay:2s,fail:0.3' (sandbox network only)")
+	flag.Parse()
+	if *mockFailureRate < 0 || *mockFailureRate > 1 || *mockDropRate < 0 || *mockDropRate > 1 {
+		log.Fatalf("--mock-failure-rate and --mock-drop-rate must be in 0..1")
+	}
+
 	// Загружаем переменные окружения
 	err := godotenv.Load()
 	if err != nil {
@@ -47,21 +63,74 @@ func main() {
 	gameManager := games.NewUnifiedGameManager(db, cfg.Games)
 
 	// Инициализация платежной системы
-	paymentManager := payments.NewMultiChainPaymentManager(db, cfg.Payments)
-
-	// Инициализация Helius
-	heliusConfig := payments.HeliusConfig{
-		APIKey:     "f983dbf9-7518-4337-985d-d8ea68b16e64",
-		AdminWallet: os.Getenv("SOLANA_ADMIN_WALLET"),
+	paymentConfig := cfg.Payments
+	if paymentConfig.HeliusAPIKey == "" {
+		paymentConfig.HeliusAPIKey = "f983dbf9-7518-4337-985d-d8ea68b16e64"
 	}
-	
-	helius, err := payments.NewHeliusIntegration(heliusConfig, paymentManager)
+	if *network == "" {
+		*network = os.Getenv("PAYMENTS_NETWORK")
+	}
+	if *network != "" {
+		paymentConfig.Network = *network
+	}
+	if paymentConfig.Network == payments.NetworkSandbox {
+		paymentConfig.Sandbox = payments.SandboxSettingsFromEnv()
+	}
+	activeNetwork, err := paymentConfig.ActiveNetwork()
 	if err != nil {
-		log.Printf("Warning: Failed to initialize Helius: %v", err)
-	} else {
+		log.Fatalf("Invalid payments network config: %v", err)
+	}
+	log.Printf("Payments network: %s (Solana %s, TON %s)", activeNetwork.Name, activeNetwork.SolanaCluster, activeNetwork.TONNetwork)
+	if *mockChains {
+		paymentConfig.MockChains = &payments.MockChainConfig{
+			ConfirmDelay: *mockDelay,
+			FailureRate:  *mockFailureRate,
+			DropRate:     *mockDropRate,
+			Seed:         *mockSeed,
+		}
+		log.Printf("⚠️ Mock chains enabled: confirm delay %s, failure rate %.2f, drop rate %.2f", *mockDelay, *mockFailureRate, *mockDropRate)
+	}
+	if *faultRules != "" {
+		if !activeNetwork.IsSandbox() {
+			log.Fatalf("--faults needs --network sandbox")
+		}
+		rules, err := faults.Parse(*faultRules)
+		if err != nil {
+			log.Fatalf("--faults: %v", err)
+		}
+		faults.SetGlobal(rules)
+		faults.Enable()
+		log.Printf("⚠️ Fault injection enabled: %s", *faultRules)
+	}
+	// Фоновые циклы (мониторинг платежей, Helius) живут до остановки сервера
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	paymentManager := payments.NewMultiChainPaymentManager(db, paymentConfig)
+	paymentManager.StartMonitoring(background)
+
+	// Инициализация Helius (в режиме заглушек не нужен)
+	var helius *payments.HeliusIntegration
+	if !*mockChains {
+		adminWallet := os.Getenv("SOLANA_ADMIN_WALLET")
+		if activeNetwork.IsSandbox() {
+			adminWallet = activeNetwork.SolanaMasterAddress
+		}
+		heliusConfig := payments.HeliusConfig{
+			APIKey:      activeNetwork.HeliusAPIKey,
+			AdminWallet: adminWallet,
+			Host:        activeNetwork.HeliusHost,
+		}
+		helius, err = payments.NewHeliusIntegration(heliusConfig, paymentManager)
+		if err != nil {
+			log.Printf("Warning: Failed to initialize Helius: %v", err)
+			helius = nil
+		}
+	}
+	if helius != nil {
 		// Запускаем WebSocket слушатель
 		go func() {
-			if err := helius.StartWebSocketListener(); err != nil {
+			if err := helius.StartWebSocketListener(background); err != nil {
 				log.Printf("Helius WebSocket error: %v", err)
 			}
 		}()
@@ -144,6 +213,7 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
+	stopBackground()
 
 	// Останавливаем Helius
 	if helius != nil {
@@ -0,0 +1,154 @@
+// Command simulate runs the reserve economy (rate curve, emission cap, fee
+// burns) against synthetic user cohorts for N days and prints the supply,
+// price and health trajectory, so parameter changes can be compared before
+// they are deployed.
+//
+//	go run ./cmd/simulate -days 180 -users 5000 -max-users 200000 -growth logistic \
+//	    -emission-cap 20000000 -burn-bp 2000 -format csv > run.csv
+//
+// Cohorts default to a casual/grinder/whale mix; -cohorts points to a JSON
+// array of Cohort objects to replace it.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+	var (
+		p        params
+		every    int
+		format   string
+		cohortsF string
+	)
+	flag.IntVar(&p.Days, "days", 180, "simulated days")
+	flag.Int64Var(&p.TotalSupply, "supply", 1_000_000_000, "TOTAL_SUPPLY")
+	flag.Int64Var(&p.AdminPct, "admin-pct", 30, "ADMIN_ALLOCATION_PCT (the rest is the reserve)")
+	flag.Int64Var(&p.StartRate, "start-rate", 1000, "START_RATE_COINS_PER_USD")
+	flag.Int64Var(&p.MinRate, "min-rate", 500, "MIN_RATE_COINS_PER_USD")
+	flag.Int64Var(&p.EmissionCap, "emission-cap", 0, "DAILY_EMISSION_CAP (0 = unlimited)")
+	flag.Int64Var(&p.TapLimit, "tap-limit", 3000, "TAP_DAILY_LIMIT")
+	flag.Int64Var(&p.MarketFeeBP, "fee-bp", 250, "NFT_MARKET_FEE_BP charged on market volume")
+	flag.Int64Var(&p.BurnBP, "burn-bp", 0, "share of fees burned by the burn schedule, basis points")
+	flag.IntVar(&p.BurnEveryDays, "burn-every", 7, "burn schedule period in days")
+	flag.Float64Var(&p.AlertDays, "alert-days", 30, "RESERVE_RUNWAY_ALERT_DAYS (runway below it is critical)")
+	flag.Float64Var(&p.Users, "users", 1000, "users on day 0")
+	flag.Float64Var(&p.MaxUsers, "max-users", 100_000, "user cap (logistic carrying capacity; 0 = none)")
+	flag.StringVar(&p.Growth, "growth", GrowthLogistic, "user growth curve: linear|exp|logistic")
+	flag.Float64Var(&p.GrowthRate, "growth-rate", 0.05, "daily growth rate (linear: share of day-0 users)")
+	flag.StringVar(&cohortsF, "cohorts", "", "JSON file with user cohorts")
+	flag.IntVar(&every, "every", 1, "print every Nth day (the last day is always printed)")
+	flag.StringVar(&format, "format", "table", "output: table|csv|json")
+	flag.Parse()
+
+	if p.Days <= 0 || p.TotalSupply <= 0 || p.AdminPct < 0 || p.AdminPct > 100 || every <= 0 {
+		log.Fatal("days, supply and every must be > 0, admin-pct 0..100")
+	}
+	if p.MinRate <= 0 || p.MinRate > p.StartRate {
+		log.Fatal("rates must satisfy 0 < min-rate <= start-rate")
+	}
+	if p.EmissionCap < 0 || p.TapLimit < 0 || p.MarketFeeBP < 0 || p.BurnBP < 0 || p.BurnBP > 10_000 {
+		log.Fatal("emission-cap, tap-limit and fee-bp must be >= 0, burn-bp 0..10000")
+	}
+	switch p.Growth {
+	case GrowthLinear, GrowthExponential, GrowthLogistic:
+	default:
+		log.Fatalf("unknown growth curve %q", p.Growth)
+	}
+	cohorts, err := loadCohorts(cohortsF)
+	if err != nil {
+		log.Fatalf("cohorts: %v", err)
+	}
+
+	days := simulate(p, cohorts)
+	rows := make([]dayStats, 0, len(days)/every+1)
+	for i, d := range days {
+		if d.Day%every == 0 || i == len(days)-1 {
+			rows = append(rows, d)
+		}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			log.Fatal(err)
+		}
+	case "csv":
+		if err := writeCSV(rows); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		printTable(rows)
+		printSummary(days)
+	}
+}
+
+var csvHeader = []string{"day", "users", "active", "minted", "capped", "bought", "sold", "fees", "burned",
+	"reserve", "circulating", "total_supply", "coins_per_usd", "usd_per_coin", "treasury_usd", "net_per_day", "runway_days", "health"}
+
+func writeCSV(rows []dayStats) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(csvHeader); err != nil {
+		return err
+	}
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, d := range rows {
+		if err := w.Write([]string{strconv.Itoa(d.Day), i(d.Users), i(d.Active), i(d.Minted), i(d.Capped), i(d.Bought), i(d.Sold),
+			i(d.Fees), i(d.Burned), i(d.Reserve), i(d.Circulating), i(d.TotalSupply), i(d.CoinsPerUSD), f(d.USDPerCoin),
+			f(d.TreasuryUSD), i(d.NetPerDay), f(d.RunwayDays), d.Health}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printTable(rows []dayStats) {
+	fmt.Printf("%5s %9s %9s %12s %12s %12s %14s %14s %7s %10s %9s\n",
+		"day", "users", "active", "minted", "sold", "burned", "reserve", "circulating", "c/usd", "runway", "health")
+	for _, d := range rows {
+		runway := "-"
+		if d.RunwayDays >= 0 {
+			runway = fmt.Sprintf("%.1fd", d.RunwayDays)
+		}
+		fmt.Printf("%5d %9d %9d %12d %12d %12d %14d %14d %7d %10s %9s\n",
+			d.Day, d.Users, d.Active, d.Minted, d.Sold, d.Burned, d.Reserve, d.Circulating, d.CoinsPerUSD, runway, d.Health)
+	}
+}
+
+func printSummary(days []dayStats) {
+	if len(days) == 0 {
+		return
+	}
+	var minted, capped, burned int64
+	firstCritical, exhausted := 0, 0
+	for _, d := range days {
+		minted += d.Minted
+		capped += d.Capped
+		burned += d.Burned
+		if d.Health == "critical" && firstCritical == 0 {
+			firstCritical = d.Day
+		}
+		if d.Health == "exhausted" && exhausted == 0 {
+			exhausted = d.Day
+		}
+	}
+	last := days[len(days)-1]
+	fmt.Printf("\nminted %d, refused by cap %d, burned %d; final rate %d coins/USD, treasury $%.2f\n",
+		minted, capped, burned, last.CoinsPerUSD, last.TreasuryUSD)
+	if firstCritical > 0 {
+		fmt.Printf("runway critical from day %d\n", firstCritical)
+	}
+	if exhausted > 0 {
+		fmt.Printf("reserve exhausted on day %d\n", exhausted)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Cohort is a group of synthetic users with the same behaviour. Values are
+// daily averages; the model is deterministic (expected values, no noise).
+type Cohort struct {
+	Name       string  `json:"name"`
+	Share      float64 `json:"share"`       // share of new users joining this cohort
+	Active     float64 `json:"active"`      // share of the cohort active on a given day
+	TapShare   float64 `json:"tap_share"`   // share of the daily tap limit an active user uses
+	SellShare  float64 `json:"sell_share"`  // share of holdings cashed out per day
+	TradeBP    int64   `json:"trade_bp"`    // holdings traded on the markets per day, basis points
+	DepositUSD float64 `json:"deposit_usd"` // USD bought per active user per day
+	Churn      float64 `json:"churn"`       // share of the cohort leaving per day
+}
+
+var defaultCohorts = []Cohort{
+	{Name: "casual", Share: 0.70, Active: 0.30, TapShare: 0.20, SellShare: 0.01, TradeBP: 50, Churn: 0.020},
+	{Name: "grinder", Share: 0.25, Active: 0.80, TapShare: 0.90, SellShare: 0.05, TradeBP: 200, Churn: 0.010},
+	{Name: "whale", Share: 0.05, Active: 0.60, TapShare: 0.40, SellShare: 0.005, TradeBP: 500, DepositUSD: 2, Churn: 0.005},
+}
+
+func loadCohorts(path string) ([]Cohort, error) {
+	if path == "" {
+		return defaultCohorts, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []Cohort
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var share float64
+	for _, c := range out {
+		if c.Share < 0 || c.Active < 0 || c.Active > 1 || c.TapShare < 0 || c.TapShare > 1 ||
+			c.SellShare < 0 || c.SellShare > 1 || c.Churn < 0 || c.Churn > 1 || c.TradeBP < 0 || c.DepositUSD < 0 {
+			return nil, fmt.Errorf("cohort %q: shares must be in 0..1", c.Name)
+		}
+		share += c.Share
+	}
+	if len(out) == 0 || math.Abs(share-1) > 1e-6 {
+		return nil, errors.New("cohort shares must sum to 1")
+	}
+	return out, nil
+}
+
+// Growth curves for the user base.
+const (
+	GrowthLinear      = "linear"
+	GrowthExponential = "exp"
+	GrowthLogistic    = "logistic"
+)
+
+// params are the economy settings under test (same meaning as the env config).
+type params struct {
+	Days          int
+	TotalSupply   int64
+	AdminPct      int64
+	StartRate     int64
+	MinRate       int64
+	EmissionCap   int64 // 0 = unlimited
+	TapLimit      int64
+	MarketFeeBP   int64
+	BurnBP        int64 // share of fees burned by the weekly schedule
+	BurnEveryDays int
+	AlertDays     float64
+
+	Users      float64
+	MaxUsers   float64
+	Growth     string
+	GrowthRate float64
+}
+
+// dayStats is one row of the trajectory.
+type dayStats struct {
+	Day         int     `json:"day"`
+	Users       int64   `json:"users"`
+	Active      int64   `json:"active"`
+	Minted      int64   `json:"minted"`
+	Capped      int64   `json:"capped"` // taps refused by the emission cap
+	Bought      int64   `json:"bought"` // coins issued for deposits
+	Sold        int64   `json:"sold"`   // coins cashed out back into the reserve
+	Fees        int64   `json:"fees"`
+	Burned      int64   `json:"burned"`
+	Reserve     int64   `json:"reserve"`
+	Circulating int64   `json:"circulating"`
+	TotalSupply int64   `json:"total_supply"`
+	CoinsPerUSD int64   `json:"coins_per_usd"`
+	USDPerCoin  float64 `json:"usd_per_coin"`
+	TreasuryUSD float64 `json:"treasury_usd"` // deposits minus cash-outs
+	NetPerDay   int64   `json:"net_per_day"`  // 7-day average reserve change
+	RunwayDays  float64 `json:"runway_days"`  // -1 = not draining
+	Health      string  `json:"health"`       // ok|watch|critical|exhausted
+}
+
+type cohortState struct {
+	Cohort
+	users    float64
+	holdings float64
+}
+
+// simulate runs the economy for p.Days days and returns one row per day.
+func simulate(p params, cohorts []Cohort) []dayStats {
+	curve := db.RateCurve{StartRate: p.StartRate, MinRate: p.MinRate}
+	reserve := p.TotalSupply * (100 - p.AdminPct) / 100
+	curve.InitialReserve = reserve
+	total := p.TotalSupply
+
+	states := make([]cohortState, len(cohorts))
+	for i, c := range cohorts {
+		states[i] = cohortState{Cohort: c, users: p.Users * c.Share}
+	}
+
+	var (
+		treasury   float64
+		pendingFee int64
+		history    []int64 // reserve at the end of each day
+		out        = make([]dayStats, 0, p.Days)
+	)
+	history = append(history, reserve)
+
+	for day := 1; day <= p.Days; day++ {
+		st := dayStats{Day: day}
+
+		joined := newUsers(p, totalUsers(states))
+		em := db.Emission{Cap: p.EmissionCap}
+
+		// Taps first: they drain the reserve and count against the emission cap.
+		var wanted float64
+		for i := range states {
+			s := &states[i]
+			s.users += joined * s.Share
+			s.users -= s.users * s.Churn
+			wanted += s.users * s.Active * s.TapShare * float64(p.TapLimit)
+		}
+		mintable := int64(wanted)
+		if left := em.Remaining(); left >= 0 && mintable > left {
+			st.Capped = mintable - left
+			mintable = left
+		}
+		if mintable > reserve {
+			mintable = reserve
+		}
+		em.Minted += mintable
+		reserve -= mintable
+		st.Minted = mintable
+
+		for i := range states {
+			s := &states[i]
+			active := s.users * s.Active
+			st.Users += int64(s.users)
+			st.Active += int64(active)
+			if wanted > 0 {
+				s.holdings += float64(mintable) * active * s.TapShare * float64(p.TapLimit) / wanted
+			}
+
+			// Deposits buy coins on the curve.
+			if usd := active * s.DepositUSD; usd > 0 {
+				coins := curve.CoinsForUSD(reserve, usd)
+				if coins > reserve {
+					coins = reserve
+				}
+				reserve -= coins
+				s.holdings += float64(coins)
+				st.Bought += coins
+				treasury += usd
+			}
+
+			// Cash-outs go back into the reserve at the spot rate.
+			if sold := int64(s.holdings * s.SellShare); sold > 0 {
+				rate := curve.RateAt(reserve)
+				s.holdings -= float64(sold)
+				reserve += sold
+				st.Sold += sold
+				if rate > 0 {
+					treasury -= float64(sold) / float64(rate)
+				}
+			}
+
+			// Market fees move from holders to the reserve.
+			fee := int64(s.holdings * float64(s.TradeBP) / 10_000 * float64(p.MarketFeeBP) / 10_000)
+			s.holdings -= float64(fee)
+			reserve += fee
+			st.Fees += fee
+		}
+
+		// The burn schedule burns a share of the period's fees out of the reserve.
+		pendingFee += st.Fees
+		if p.BurnBP > 0 && p.BurnEveryDays > 0 && day%p.BurnEveryDays == 0 {
+			burn := pendingFee * p.BurnBP / 10_000
+			if burn > reserve {
+				burn = reserve
+			}
+			reserve -= burn
+			total -= burn
+			st.Burned = burn
+			pendingFee = 0
+		}
+
+		history = append(history, reserve)
+		st.Reserve = reserve
+		st.TotalSupply = total
+		st.Circulating = total - reserve
+		st.CoinsPerUSD = curve.RateAt(reserve)
+		if st.CoinsPerUSD > 0 {
+			st.USDPerCoin = 1 / float64(st.CoinsPerUSD)
+		}
+		st.TreasuryUSD = treasury
+
+		window := min(7, len(history)-1)
+		st.NetPerDay = (reserve - history[len(history)-1-window]) / int64(window)
+		st.RunwayDays = -1
+		if st.NetPerDay < 0 {
+			st.RunwayDays = float64(reserve) / float64(-st.NetPerDay)
+		}
+		st.Health = health(reserve, st.RunwayDays, p.AlertDays)
+		out = append(out, st)
+	}
+	return out
+}
+
+func totalUsers(states []cohortState) float64 {
+	var n float64
+	for _, s := range states {
+		n += s.users
+	}
+	return n
+}
+
+// newUsers is how many users join in a day given the current base.
+func newUsers(p params, current float64) float64 {
+	var n float64
+	switch p.Growth {
+	case GrowthLinear:
+		n = p.Users * p.GrowthRate
+	case GrowthExponential:
+		n = current * p.GrowthRate
+	default: // logistic
+		if p.MaxUsers > 0 {
+			n = p.GrowthRate * current * (1 - current/p.MaxUsers)
+		}
+	}
+	if p.MaxUsers > 0 && current+n > p.MaxUsers {
+		n = p.MaxUsers - current
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func health(reserve int64, runway, alertDays float64) string {
+	switch {
+	case reserve <= 0:
+		return "exhausted"
+	case runway < 0:
+		return "ok"
+	case runway < alertDays:
+		return "critical"
+	case runway < 3*alertDays:
+		return "watch"
+	}
+	return "ok"
+}
@@ -0,0 +1,227 @@
+// Package admission protects the tap hot path from overload. A Controller
+// samples the persistence backlog (memtap pending users or the fasttap stream
+// lag) and the latency of the last Postgres flush, and moves between three
+// levels: normal, degraded (accept taps, ask clients to batch more and flush
+// less often) and shedding (answer 202 and ask the client to retry later).
+package admission
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Level int32
+
+const (
+	LevelNormal Level = iota
+	LevelDegraded
+	LevelShedding
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDegraded:
+		return "degraded"
+	case LevelShedding:
+		return "shedding"
+	default:
+		return "normal"
+	}
+}
+
+// Signals is one sample of the load on the tap pipeline.
+type Signals struct {
+	QueueDepth int64
+	DBLatency  time.Duration
+}
+
+// Probe reads the current Signals; errors keep the previous level.
+type Probe func(ctx context.Context) (Signals, error)
+
+// Config holds the thresholds. A zero threshold disables that signal.
+// A level is left only once every signal drops below Hysteresis x threshold,
+// so the controller does not flap around a single value.
+type Config struct {
+	DegradeDepth   int64
+	ShedDepth      int64
+	DegradeLatency time.Duration
+	ShedLatency    time.Duration
+	Hysteresis     float64 // default 0.8
+
+	SampleEvery time.Duration // default 250ms
+	RetryAfter  time.Duration // hint for shed requests, default 2s
+	BatchWindow time.Duration // hint for degraded requests, default 1s
+
+	// OnChange is called from the sampling goroutine after a level change.
+	OnChange func(from, to Level)
+}
+
+type Controller struct {
+	cfg   Config
+	probe Probe
+
+	level    atomic.Int32
+	depth    atomic.Int64
+	latency  atomic.Int64
+	admitted atomic.Int64
+	degraded atomic.Int64
+	shed     atomic.Int64
+
+	decisions *prometheus.CounterVec
+	levelG    prometheus.Gauge
+}
+
+// New returns a controller. reg may be nil to skip Prometheus registration.
+func New(cfg Config, probe Probe, reg prometheus.Registerer) *Controller {
+	if cfg.Hysteresis <= 0 || cfg.Hysteresis > 1 {
+		cfg.Hysteresis = 0.8
+	}
+	if cfg.SampleEvery <= 0 {
+		cfg.SampleEvery = 250 * time.Millisecond
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 2 * time.Second
+	}
+	if cfg.BatchWindow <= 0 {
+		cfg.BatchWindow = time.Second
+	}
+	c := &Controller{
+		cfg:   cfg,
+		probe: probe,
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bkc_tap_admission_total",
+			Help: "Tap requests by admission decision (admitted, degraded, shed).",
+		}, []string{"decision"}),
+		levelG: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bkc_tap_admission_level",
+			Help: "Current tap admission level: 0 normal, 1 degraded, 2 shedding.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(c.decisions, c.levelG)
+	}
+	return c
+}
+
+// Run samples the probe until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	if c == nil || c.probe == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(c.cfg.SampleEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s, err := c.probe(ctx)
+			if err != nil {
+				log.Printf("admission: probe: %v", err)
+				continue
+			}
+			c.Observe(s)
+		}
+	}()
+}
+
+// Observe applies one sample and returns the resulting level.
+func (c *Controller) Observe(s Signals) Level {
+	c.depth.Store(s.QueueDepth)
+	c.latency.Store(int64(s.DBLatency))
+
+	from := Level(c.level.Load())
+	to := c.next(from, s)
+	if to != from {
+		c.level.Store(int32(to))
+		c.levelG.Set(float64(to))
+		log.Printf("admission: tap level %s -> %s (queue=%d db=%s)", from, to, s.QueueDepth, s.DBLatency.Round(time.Millisecond))
+		if c.cfg.OnChange != nil {
+			c.cfg.OnChange(from, to)
+		}
+	}
+	return to
+}
+
+func (c *Controller) next(cur Level, s Signals) Level {
+	shed := c.over(s, c.cfg.ShedDepth, c.cfg.ShedLatency, 1)
+	degrade := c.over(s, c.cfg.DegradeDepth, c.cfg.DegradeLatency, 1)
+	switch {
+	case shed:
+		return LevelShedding
+	case cur == LevelShedding && c.over(s, c.cfg.ShedDepth, c.cfg.ShedLatency, c.cfg.Hysteresis):
+		return LevelShedding
+	case degrade:
+		return LevelDegraded
+	case cur >= LevelDegraded && c.over(s, c.cfg.DegradeDepth, c.cfg.DegradeLatency, c.cfg.Hysteresis):
+		return LevelDegraded
+	}
+	return LevelNormal
+}
+
+func (c *Controller) over(s Signals, depth int64, latency time.Duration, factor float64) bool {
+	if depth > 0 && float64(s.QueueDepth) >= float64(depth)*factor {
+		return true
+	}
+	if latency > 0 && float64(s.DBLatency) >= float64(latency)*factor {
+		return true
+	}
+	return false
+}
+
+// Decision is what the tap endpoint should do with one request.
+type Decision struct {
+	Level      Level
+	Admit      bool
+	RetryAfter time.Duration // set when shed
+	BatchHint  time.Duration // set when degraded
+}
+
+// Admit decides one request and counts it. A nil controller admits everything.
+func (c *Controller) Admit() Decision {
+	if c == nil {
+		return Decision{Level: LevelNormal, Admit: true}
+	}
+	switch l := Level(c.level.Load()); l {
+	case LevelShedding:
+		c.shed.Add(1)
+		c.decisions.WithLabelValues("shed").Inc()
+		return Decision{Level: l, RetryAfter: c.cfg.RetryAfter}
+	case LevelDegraded:
+		c.degraded.Add(1)
+		c.decisions.WithLabelValues("degraded").Inc()
+		return Decision{Level: l, Admit: true, BatchHint: c.cfg.BatchWindow}
+	default:
+		c.admitted.Add(1)
+		c.decisions.WithLabelValues("admitted").Inc()
+		return Decision{Level: l, Admit: true}
+	}
+}
+
+func (c *Controller) Level() Level {
+	if c == nil {
+		return LevelNormal
+	}
+	return Level(c.level.Load())
+}
+
+func (c *Controller) Stats() map[string]any {
+	if c == nil {
+		return map[string]any{"enabled": false}
+	}
+	return map[string]any{
+		"enabled":        true,
+		"level":          c.Level().String(),
+		"queue_depth":    c.depth.Load(),
+		"db_latency_ms":  time.Duration(c.latency.Load()).Milliseconds(),
+		"admitted_total": c.admitted.Load(),
+		"degraded_total": c.degraded.Load(),
+		"shed_total":     c.shed.Load(),
+	}
+}
@@ -0,0 +1,86 @@
+package admission
+
+import (
+	"testing"
+	"time"
+)
+
+func testController(changes *[]Level) *Controller {
+	return New(Config{
+		DegradeDepth:   1000,
+		ShedDepth:      5000,
+		DegradeLatency: 500 * time.Millisecond,
+		ShedLatency:    2 * time.Second,
+		OnChange:       func(_, to Level) { *changes = append(*changes, to) },
+	}, nil, nil)
+}
+
+func TestControllerLevels(t *testing.T) {
+	var changes []Level
+	c := testController(&changes)
+
+	steps := []struct {
+		s    Signals
+		want Level
+	}{
+		{Signals{QueueDepth: 10}, LevelNormal},
+		{Signals{QueueDepth: 1200}, LevelDegraded},
+		{Signals{QueueDepth: 900}, LevelDegraded}, // above 0.8 x 1000
+		{Signals{QueueDepth: 700}, LevelNormal},
+		{Signals{DBLatency: 3 * time.Second}, LevelShedding},
+		{Signals{DBLatency: 1700 * time.Millisecond}, LevelShedding}, // above 0.8 x 2s
+		{Signals{DBLatency: 1500 * time.Millisecond}, LevelDegraded},
+		{Signals{QueueDepth: 6000, DBLatency: time.Millisecond}, LevelShedding},
+		{Signals{}, LevelNormal},
+	}
+	for i, st := range steps {
+		if got := c.Observe(st.s); got != st.want {
+			t.Fatalf("step %d %+v: level %s, want %s", i, st.s, got, st.want)
+		}
+	}
+	want := []Level{LevelDegraded, LevelNormal, LevelShedding, LevelDegraded, LevelShedding, LevelNormal}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestControllerAdmit(t *testing.T) {
+	var changes []Level
+	c := testController(&changes)
+
+	if d := c.Admit(); !d.Admit || d.BatchHint != 0 {
+		t.Fatalf("normal: %+v", d)
+	}
+	c.Observe(Signals{QueueDepth: 2000})
+	if d := c.Admit(); !d.Admit || d.BatchHint != time.Second {
+		t.Fatalf("degraded: %+v", d)
+	}
+	c.Observe(Signals{QueueDepth: 9000})
+	if d := c.Admit(); d.Admit || d.RetryAfter != 2*time.Second {
+		t.Fatalf("shedding: %+v", d)
+	}
+	st := c.Stats()
+	if st["admitted_total"] != int64(1) || st["degraded_total"] != int64(1) || st["shed_total"] != int64(1) {
+		t.Fatalf("stats = %v", st)
+	}
+
+	var nilC *Controller
+	if d := nilC.Admit(); !d.Admit {
+		t.Fatalf("nil controller must admit")
+	}
+}
+
+func TestControllerDisabledSignal(t *testing.T) {
+	c := New(Config{ShedDepth: 100}, nil, nil)
+	if got := c.Observe(Signals{DBLatency: time.Hour}); got != LevelNormal {
+		t.Fatalf("latency thresholds unset, got %s", got)
+	}
+	if got := c.Observe(Signals{QueueDepth: 100}); got != LevelShedding {
+		t.Fatalf("got %s, want shedding", got)
+	}
+}
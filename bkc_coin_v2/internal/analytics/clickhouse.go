@@ -0,0 +1,137 @@
+// Package analytics copies the append-only event tables (ledger, user events,
+// webapp client events) from Postgres to an analytics store, so heavy
+// reporting queries never run against the OLTP database.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sink is an analytics store. Insert must tolerate a batch being sent twice
+// (the exporter is at-least-once).
+type Sink interface {
+	EnsureSchema(ctx context.Context) error
+	Insert(ctx context.Context, table string, rows []any) error
+}
+
+// schema is applied on every start; each statement must be idempotent. Add
+// columns with ADD COLUMN IF NOT EXISTS, never edit an applied statement.
+// ReplacingMergeTree keyed by the Postgres id collapses re-sent rows.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS {db}.ledger (
+  id Int64,
+  event_id Nullable(String),
+  ts DateTime64(3, 'UTC'),
+  kind LowCardinality(String),
+  from_id Nullable(Int64),
+  to_id Nullable(Int64),
+  amount Int64,
+  meta String
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(ts)
+ORDER BY (kind, id)`,
+	`CREATE TABLE IF NOT EXISTS {db}.user_events (
+  event_id Int64,
+  user_id Int64,
+  kind LowCardinality(String),
+  payload String,
+  created_at DateTime64(3, 'UTC')
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(created_at)
+ORDER BY (kind, event_id)`,
+	`CREATE TABLE IF NOT EXISTS {db}.client_events (
+  id Int64,
+  user_id Int64,
+  session_id String,
+  type LowCardinality(String),
+  name LowCardinality(String),
+  screen LowCardinality(String),
+  props String,
+  client_ts DateTime64(3, 'UTC'),
+  received_at DateTime64(3, 'UTC')
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(client_ts)
+ORDER BY (type, user_id, id)`,
+}
+
+// ClickHouse writes to ClickHouse over its HTTP interface.
+type ClickHouse struct {
+	url      string
+	database string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func NewClickHouse(baseURL, database, user, password string) *ClickHouse {
+	return &ClickHouse{
+		url:      strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		database: database,
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *ClickHouse) exec(ctx context.Context, query string, body []byte) error {
+	// RFC 3339 timestamps from encoding/json need best_effort parsing.
+	q := url.Values{"date_time_input_format": {"best_effort"}}
+	if body == nil {
+		body = []byte(query)
+	} else {
+		q.Set("query", query)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("clickhouse: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (c *ClickHouse) EnsureSchema(ctx context.Context) error {
+	if err := c.exec(ctx, "CREATE DATABASE IF NOT EXISTS "+c.database, nil); err != nil {
+		return err
+	}
+	for _, stmt := range schema {
+		if err := c.exec(ctx, strings.ReplaceAll(stmt, "{db}", c.database), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert sends rows as JSONEachRow.
+func (c *ClickHouse) Insert(ctx context.Context, table string, rows []any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return c.exec(ctx, fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", c.database, table), buf.Bytes())
+}
@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClickHouseInsert(t *testing.T) {
+	var query, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		if r.Header.Get("X-ClickHouse-User") != "etl" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClickHouse(srv.URL+"/", "bkc", "etl", "secret")
+	rows := []any{eventRow{EventID: 1, Kind: "a", Payload: "{}"}, eventRow{EventID: 2, Kind: "b", Payload: `{"x":1}`}}
+	if err := c.Insert(context.Background(), "user_events", rows); err != nil {
+		t.Fatal(err)
+	}
+	if query != "INSERT INTO bkc.user_events FORMAT JSONEachRow" {
+		t.Fatalf("query %q", query)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"payload":"{\"x\":1}"`) {
+		t.Fatalf("body %q", body)
+	}
+
+	bad := NewClickHouse(srv.URL, "bkc", "", "")
+	if err := bad.EnsureSchema(context.Background()); err == nil {
+		t.Fatal("expected error on 403")
+	}
+}
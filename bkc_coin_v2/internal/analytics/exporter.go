@@ -0,0 +1,173 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// ledgerRow and eventRow are the wire shape of the ClickHouse tables: JSON
+// columns are sent as strings.
+type ledgerRow struct {
+	ID      int64     `json:"id"`
+	EventID *string   `json:"event_id"`
+	TS      time.Time `json:"ts"`
+	Kind    string    `json:"kind"`
+	FromID  *int64    `json:"from_id"`
+	ToID    *int64    `json:"to_id"`
+	Amount  int64     `json:"amount"`
+	Meta    string    `json:"meta"`
+}
+
+type eventRow struct {
+	EventID   int64     `json:"event_id"`
+	UserID    int64     `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Payload   string    `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type clientEventRow struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	SessionID  string    `json:"session_id"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Screen     string    `json:"screen"`
+	Props      string    `json:"props"`
+	ClientTS   time.Time `json:"client_ts"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Exporter copies new ledger rows, user events and client events to a Sink in batches,
+// tracking progress per stream in analytics_export. A batch is committed to
+// the cursor only after the sink accepted it, so delivery is at-least-once.
+type Exporter struct {
+	db    *db.DB
+	sink  Sink
+	batch int
+}
+
+func NewExporter(d *db.DB, sink Sink, batch int) *Exporter {
+	if batch <= 0 {
+		batch = 5_000
+	}
+	return &Exporter{db: d, sink: sink, batch: batch}
+}
+
+// EnsureSchema creates or migrates the analytics tables.
+func (e *Exporter) EnsureSchema(ctx context.Context) error {
+	return e.sink.EnsureSchema(ctx)
+}
+
+// Export sends one batch per stream. Run from the analytics_export job.
+func (e *Exporter) Export(ctx context.Context) error {
+	if err := e.exportLedger(ctx); err != nil {
+		return err
+	}
+	if err := e.exportUserEvents(ctx); err != nil {
+		return err
+	}
+	return e.exportClientEvents(ctx)
+}
+
+func (e *Exporter) exportLedger(ctx context.Context) error {
+	after, err := e.db.ExportCursor(ctx, db.ExportLedger)
+	if err != nil {
+		return err
+	}
+	rows, err := e.db.LedgerSince(ctx, after, e.batch)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	out := make([]any, 0, len(rows))
+	for _, r := range rows {
+		meta := string(r.Meta)
+		if meta == "" {
+			meta = "{}"
+		}
+		out = append(out, ledgerRow{
+			ID: r.ID, EventID: r.EventID, TS: r.TS.UTC(), Kind: r.Kind,
+			FromID: r.FromID, ToID: r.ToID, Amount: r.Amount, Meta: meta,
+		})
+	}
+	if err := e.sink.Insert(ctx, db.ExportLedger, out); err != nil {
+		return err
+	}
+	return e.db.SetExportCursor(ctx, db.ExportLedger, rows[len(rows)-1].ID)
+}
+
+func (e *Exporter) exportUserEvents(ctx context.Context) error {
+	after, err := e.db.ExportCursor(ctx, db.ExportUserEvents)
+	if err != nil {
+		return err
+	}
+	events, err := e.db.UserEventsSince(ctx, after, e.batch)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	out := make([]any, 0, len(events))
+	for _, ev := range events {
+		out = append(out, eventRow{
+			EventID: ev.EventID, UserID: ev.UserID, Kind: ev.Kind,
+			Payload: encodePayload(ev.Payload), CreatedAt: ev.CreatedAt.UTC(),
+		})
+	}
+	if err := e.sink.Insert(ctx, db.ExportUserEvents, out); err != nil {
+		return err
+	}
+	return e.db.SetExportCursor(ctx, db.ExportUserEvents, events[len(events)-1].EventID)
+}
+
+// exportClientEvents also deletes the exported rows: Postgres only buffers
+// them. A crash between the two leaves rows behind the cursor, removed by
+// the next run.
+func (e *Exporter) exportClientEvents(ctx context.Context) error {
+	after, err := e.db.ExportCursor(ctx, db.ExportClientEvents)
+	if err != nil {
+		return err
+	}
+	events, err := e.db.ClientEventsSince(ctx, after, e.batch)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return e.db.DeleteClientEvents(ctx, after)
+	}
+	out := make([]any, 0, len(events))
+	for _, ev := range events {
+		props := "{}"
+		if len(ev.Props) > 0 {
+			b, err := json.Marshal(ev.Props)
+			if err != nil {
+				return err
+			}
+			props = string(b)
+		}
+		out = append(out, clientEventRow{
+			ID: ev.ID, UserID: ev.UserID, SessionID: ev.SessionID, Type: ev.Type, Name: ev.Name,
+			Screen: ev.Screen, Props: props, ClientTS: ev.ClientTS.UTC(), ReceivedAt: ev.ReceivedAt.UTC(),
+		})
+	}
+	if err := e.sink.Insert(ctx, db.ExportClientEvents, out); err != nil {
+		return err
+	}
+	last := events[len(events)-1].ID
+	if err := e.db.SetExportCursor(ctx, db.ExportClientEvents, last); err != nil {
+		return err
+	}
+	return e.db.DeleteClientEvents(ctx, last)
+}
+
+func encodePayload(p map[string]any) string {
+	if len(p) == 0 {
+		return "{}"
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// ActivityHandler serves the deal activity feed: P2P loan requests and
+// answers, offers on the user's NFTs, gig escrow and disputes, with unread
+// counts per section.
+type ActivityHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewActivityHandler(cfg config.Config, d *db.DB) *ActivityHandler {
+	return &ActivityHandler{cfg: cfg, db: d}
+}
+
+func (h *ActivityHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/activity", h.list)
+	mux.HandleFunc("GET /api/v1/activity/unread", h.unread)
+	mux.HandleFunc("POST /api/v1/activity/read", h.markRead)
+}
+
+// list supports ?section=loans|offers|escrow|disputes, ?before=<event_id>
+// for the next page and ?limit=. The unread counts come along.
+func (h *ActivityHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListActivity(r.Context(), u.ID, r.URL.Query().Get("section"), queryInt64(r, "before", 0), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	unread, err := h.db.CountUnreadActivity(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "unread": unread})
+}
+
+func (h *ActivityHandler) unread(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	unread, err := h.db.CountUnreadActivity(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"unread": unread})
+}
+
+// markRead: {"up_to": <event_id>, "section": "loans"}; without a section
+// the whole feed.
+func (h *ActivityHandler) markRead(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		UpTo    int64  `json:"up_to"`
+		Section string `json:"section"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	n, err := h.db.MarkActivityRead(r.Context(), u.ID, req.Section, req.UpTo)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	unread, err := h.db.CountUnreadActivity(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"marked": n, "unread": unread})
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type adminCtxKey struct{}
+
+// requestAdmin returns the admin AdminMiddleware let through.
+func requestAdmin(r *http.Request) (db.Admin, bool) {
+	a, ok := r.Context().Value(adminCtxKey{}).(db.Admin)
+	return a, ok
+}
+
+// adminAny marks admin routes every admin may call.
+const adminAny = "any"
+
+// adminRoutePermissions map admin route prefixes to the permission they
+// need; the first match wins. Admin routes not listed here, and the admin
+// management routes, are for super admins only.
+var adminRoutePermissions = []struct {
+	prefix string
+	perm   string
+}{
+	{"/api/v1/admin/deposits", db.PermApproveDeposits},
+	{"/api/v1/admin/withdrawals", db.PermApproveDeposits},
+	{"/api/v1/admin/stabilization/interventions", db.PermMint},
+	{"/api/v1/admin/vesting", db.PermMint},
+	{"/api/v1/admin/burn-schedules", db.PermMint},
+	{"/api/v1/admin/economy/", db.PermConfigureEconomy},
+	{"/api/v1/admin/reserve/", db.PermConfigureEconomy},
+	{"/api/v1/admin/stabilization", db.PermConfigureEconomy},
+	{"/api/v1/admin/tap/", db.PermConfigureEconomy},
+	{"/api/v1/admin/nft/", db.PermConfigureEconomy},
+	{"/api/v1/admin/games/", db.PermConfigureEconomy},
+	{"/api/v1/admin/bonus", db.PermConfigureEconomy},
+	{"/api/v1/admin/experiments", db.PermConfigureEconomy},
+	{"/api/v1/admin/analytics/", db.PermConfigureEconomy},
+	{"/api/v1/admin/disputes", db.PermResolveDisputes},
+	{"/api/v1/admin/p2p/", db.PermResolveDisputes},
+	{"/api/v1/admin/listings/", db.PermModerateListings},
+	{"/api/v1/admin/market/categories", db.PermConfigureEconomy},
+	{"/api/v1/admin/params", db.PermConfigureEconomy},
+}
+
+// adminRoutePermission is the permission path needs; "" = super admin.
+func adminRoutePermission(path string) string {
+	if path == "/api/v1/admin/me" {
+		return adminAny
+	}
+	for _, p := range adminRoutePermissions {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.perm
+		}
+	}
+	if strings.HasPrefix(path, "/api/v1/admin/users/") && strings.HasSuffix(path, "/tap-plan") {
+		return db.PermConfigureEconomy
+	}
+	return ""
+}
+
+// AdminMiddleware guards /api/v1/admin/: the caller must be in the admins
+// table with the route's permission. authAdmin then reads the admin from the
+// request context.
+func AdminMiddleware(cfg config.Config, d *db.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/v1/admin/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			u, ok := telegram.VerifyWebAppInitData(r.Header.Get(InitDataHeader), cfg.BotToken)
+			if !ok {
+				defaultErrorHandler.HandleError(w, r, NewUnauthorizedError("unauthorized"))
+				return
+			}
+			a, err := d.GetAdmin(r.Context(), u.ID)
+			if err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) {
+					log.Printf("api: admin lookup user=%d: %v", u.ID, err)
+				}
+				defaultErrorHandler.HandleError(w, r, NewForbiddenError("admin only"))
+				return
+			}
+			if perm := adminRoutePermission(r.URL.Path); perm != adminAny && !a.Can(perm) {
+				if perm == "" {
+					perm = "super admin"
+				}
+				defaultErrorHandler.HandleError(w, r, NewForbiddenError("needs "+perm))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCtxKey{}, a)))
+		})
+	}
+}
+
+// AdminsHandler lets super admins grant and revoke admin permissions.
+type AdminsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewAdminsHandler(cfg config.Config, d *db.DB) *AdminsHandler {
+	return &AdminsHandler{cfg: cfg, db: d}
+}
+
+func (h *AdminsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/admins", h.list)
+	mux.HandleFunc("PUT /api/v1/admin/admins/{id}", h.set)
+	mux.HandleFunc("DELETE /api/v1/admin/admins/{id}", h.remove)
+	mux.HandleFunc("GET /api/v1/admin/me", h.me)
+}
+
+func (h *AdminsHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	admins, err := h.db.ListAdmins(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"admins": admins, "permissions": db.AdminPermissions})
+}
+
+func (h *AdminsHandler) set(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Permissions []string `json:"permissions"`
+		Super       bool     `json:"super"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a, err := h.db.SetAdmin(r.Context(), admin.ID, id, req.Permissions, req.Super)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set by %d: permissions=%v super=%v", a.UserID, admin.ID, a.Permissions, a.Super)
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *AdminsHandler) remove(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RemoveAdmin(r.Context(), admin.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d removed by %d", id, admin.ID)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// me is reachable by any admin (see adminRoutePermission) so the admin UI
+// can show only what the caller may use.
+func (h *AdminsHandler) me(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	a, _ := requestAdmin(r)
+	writeJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// AffiliatesHandler serves the affiliate cabinet (campaign links, players,
+// monthly statements) and the admin enrollment. Payouts of the commission go
+// through WithdrawalsHandler (POST /api/v1/affiliates/payouts).
+type AffiliatesHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewAffiliatesHandler(cfg config.Config, d *db.DB, p *params.Service) *AffiliatesHandler {
+	return &AffiliatesHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *AffiliatesHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/affiliates/me", h.me)
+	mux.HandleFunc("POST /api/v1/affiliates/campaigns", h.createCampaign)
+	mux.HandleFunc("GET /api/v1/affiliates/statements", h.statements)
+
+	mux.HandleFunc("GET /api/v1/admin/affiliates", h.adminList)
+	mux.HandleFunc("PUT /api/v1/admin/affiliates/{user_id}", h.adminSet)
+	mux.HandleFunc("GET /api/v1/admin/affiliates/{user_id}/statements", h.adminStatements)
+}
+
+// campaignView adds the bot start payload: the link is
+// https://t.me/<bot>?start=<start_payload>.
+type campaignView struct {
+	db.AffiliateCampaign
+	StartPayload string `json:"start_payload"`
+}
+
+func (h *AffiliatesHandler) me(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	a, campaigns, err := h.db.GetAffiliate(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	views := make([]campaignView, 0, len(campaigns))
+	for _, c := range campaigns {
+		views = append(views, campaignView{AffiliateCampaign: c, StartPayload: db.AffiliateLinkPrefix + c.Code})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"affiliate":  a,
+		"campaigns":  views,
+		"min_payout": h.params.Int64(r.Context(), db.ParamAffiliateMinPayout),
+	})
+}
+
+func (h *AffiliatesHandler) createCampaign(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"` // empty = random
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c, err := h.db.CreateAffiliateCampaign(r.Context(), u.ID, req.Code, req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaignView{AffiliateCampaign: c, StartPayload: db.AffiliateLinkPrefix + c.Code})
+}
+
+func (h *AffiliatesHandler) statements(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	h.writeStatements(w, r, u.ID)
+}
+
+func (h *AffiliatesHandler) writeStatements(w http.ResponseWriter, r *http.Request, affiliateID int64) {
+	statements, err := h.db.ListAffiliateStatements(r.Context(), affiliateID, int(queryInt64(r, "limit", 24)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"statements": statements})
+}
+
+func (h *AffiliatesHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	affiliates, err := h.db.ListAffiliates(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"affiliates": affiliates})
+}
+
+func (h *AffiliatesHandler) adminSet(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		ShareBP int64  `json:"share_bp"`
+		Status  string `json:"status"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Status == "" {
+		req.Status = db.AffiliateActive
+	}
+	a, err := h.db.SetAffiliate(r.Context(), admin.ID, userID, req.ShareBP, req.Status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set affiliate %d share=%dbp status=%s", admin.ID, userID, req.ShareBP, req.Status)
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *AffiliatesHandler) adminStatements(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	h.writeStatements(w, r, userID)
+}
+
+// BuildStatements closes last month (UTC) for every affiliate; a month is
+// closed once. Run from the affiliate_statements job.
+func (h *AffiliatesHandler) BuildStatements(ctx context.Context) error {
+	month := time.Now().UTC().AddDate(0, -1, 0)
+	n, err := h.db.BuildAffiliateStatements(ctx, month)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: affiliate statements %s: %d", month.Format("2006-01"), n)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// AdminAlertNotifier posts an alert to the admin Telegram channel with an
+// acknowledge button.
+type AdminAlertNotifier interface {
+	SendAdminAlert(ctx context.Context, a db.AdminAlert) error
+}
+
+// AlertsHandler watches for critical conditions and pushes them to the admin
+// channel, throttled per kind (see db.AlertPolicy).
+type AlertsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	notify AdminAlertNotifier
+}
+
+func NewAlertsHandler(cfg config.Config, d *db.DB, notify AdminAlertNotifier) *AlertsHandler {
+	return &AlertsHandler{cfg: cfg, db: d, notify: notify}
+}
+
+func (h *AlertsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/alerts", h.list)
+	mux.HandleFunc("POST /api/v1/admin/alerts/{id}/ack", h.ack)
+}
+
+func (h *AlertsHandler) policy() db.AlertPolicy {
+	return db.AlertPolicy{
+		Repeat:  time.Duration(h.cfg.AlertRepeatMinutes) * time.Minute,
+		AckMute: time.Duration(h.cfg.AlertAckMuteHours) * time.Hour,
+	}
+}
+
+func (h *AlertsHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	alerts, err := h.db.ListAdminAlerts(r.Context(), r.URL.Query().Get("status") != "all", int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	health, err := h.db.EconomyHealth(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alerts": alerts, "economy_health": health})
+}
+
+func (h *AlertsHandler) ack(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	alertID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	a, err := h.db.AckAdminAlert(r.Context(), admin.ID, alertID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alert": a})
+}
+
+// SendReserveAlert lets the reserve_forecast job raise its low-runway alert
+// through the admin channel (EconomyHandler's ReserveAlertNotifier).
+func (h *AlertsHandler) SendReserveAlert(ctx context.Context, f db.ReserveForecast) error {
+	return h.raise(ctx, db.AlertReserveRunway, fmt.Sprintf(
+		"Резерв: осталось ~%.1f дн. (свободно %d BKC, изменение %d BKC/день за %d дн.). Эмиссия %d BKC/день, сжигание %d BKC/день.",
+		f.RunwayDays, f.Available, f.NetPerDay, f.WindowDays, f.EmittedPerDay, f.BurnedPerDay))
+}
+
+func (h *AlertsHandler) raise(ctx context.Context, kind, message string) error {
+	a, send, err := h.db.RaiseAdminAlert(ctx, kind, message, h.policy())
+	if err != nil || !send || h.notify == nil {
+		return err
+	}
+	if err := h.notify.SendAdminAlert(ctx, a); err != nil {
+		log.Printf("api: admin alert %s: %v", kind, err)
+	}
+	return nil
+}
+
+// CheckAlerts raises an alert for every condition that holds and resolves
+// the rest. The reserve runway is raised by the reserve_forecast job and only
+// resolved here. Run from the alerts job.
+func (h *AlertsHandler) CheckAlerts(ctx context.Context) error {
+	var active []string
+
+	forecasts, err := h.db.ListReserveForecasts(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if len(forecasts) > 0 {
+		f := forecasts[0]
+		if limit := float64(h.cfg.ReserveRunwayAlertDays); limit > 0 && f.RunwayDays >= 0 && f.RunwayDays < limit {
+			active = append(active, db.AlertReserveRunway)
+		}
+	}
+
+	if h.cfg.AlertPaymentFailures > 0 {
+		window := time.Duration(h.cfg.AlertPaymentWindowMinutes) * time.Minute
+		n, err := h.db.PaymentFailuresSince(ctx, time.Now().Add(-window))
+		if err != nil {
+			return err
+		}
+		if n >= h.cfg.AlertPaymentFailures {
+			active = append(active, db.AlertPaymentFailures)
+			if err := h.raise(ctx, db.AlertPaymentFailures, fmt.Sprintf(
+				"Ошибки подтверждения платежей: %d за %d мин.", n, h.cfg.AlertPaymentWindowMinutes)); err != nil {
+				return err
+			}
+		}
+	}
+
+	health, err := h.db.EconomyHealth(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Score < float64(h.cfg.AlertEconomyHealthMin) {
+		active = append(active, db.AlertEconomyHealth)
+		if err := h.raise(ctx, db.AlertEconomyHealth, fmt.Sprintf(
+			"Здоровье экономики %.0f/100 (резерв ~%.1f дн., курс за 24ч %+.1f%%).",
+			health.Score, health.RunwayDays, health.RateChange24h*100)); err != nil {
+			return err
+		}
+	}
+
+	rtp, err := h.checkGameRTP(ctx)
+	if err != nil {
+		return err
+	}
+	active = append(active, rtp...)
+
+	stale, err := h.db.StaleJobs(ctx, h.cfg.AlertHeartbeatMisses)
+	if err != nil {
+		return err
+	}
+	for _, j := range stale {
+		kind := db.AlertHeartbeat + ":" + j.Name
+		active = append(active, kind)
+		msg := fmt.Sprintf("Воркер %s: ни одного успешного запуска (интервал %d с).", j.Name, j.Interval)
+		if j.LastOKAt != nil {
+			msg = fmt.Sprintf("Воркер %s: нет успешного запуска %d мин. (интервал %d с).", j.Name, int64(time.Since(*j.LastOKAt)/time.Minute), j.Interval)
+		}
+		if j.LastError != "" {
+			msg += " Последняя ошибка: " + j.LastError
+		}
+		if err := h.raise(ctx, kind, msg); err != nil {
+			return err
+		}
+	}
+
+	_, err = h.db.ResolveAdminAlerts(ctx, active)
+	return err
+}
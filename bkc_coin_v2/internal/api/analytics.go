@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// AnalyticsHandler serves product analytics to admins: signup cohort
+// retention, computed by the retention job.
+type AnalyticsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewAnalyticsHandler(cfg config.Config, d *db.DB) *AnalyticsHandler {
+	return &AnalyticsHandler{cfg: cfg, db: d}
+}
+
+func (h *AnalyticsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/analytics/retention", h.retention)
+}
+
+// retention lists cohorts for ?from=&to= (YYYY-MM-DD, the last 60 days by
+// default) with a summary weighted by cohort size.
+func (h *AnalyticsHandler) retention(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -60)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeError(w, r, NewInvalidRequestError("bad "+name+": want YYYY-MM-DD"))
+			return
+		}
+		*t = parsed
+	}
+	if from.After(to) || to.Sub(from) > 366*24*time.Hour {
+		writeError(w, r, NewInvalidRequestError("from..to must be at most a year"))
+		return
+	}
+	cohorts, err := h.db.ListRetention(r.Context(), from, to)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"days":    db.RetentionDays,
+		"cohorts": cohorts,
+		"summary": db.SummarizeRetention(cohorts),
+	})
+}
+
+// ComputeRetention refreshes the open cohorts. Run from the retention job.
+func (h *AnalyticsHandler) ComputeRetention(ctx context.Context) error {
+	n, err := h.db.ComputeRetention(ctx, time.Now())
+	if n > 0 {
+		log.Printf("api: retention: %d cohorts computed", n)
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKeyHeader carries a user API key instead of Telegram initData.
+const APIKeyHeader = "X-API-Key"
+
+type apiKeyCtxKey struct{}
+
+// requestAPIKey returns the API key a request was authenticated with.
+func requestAPIKey(r *http.Request) (db.APIKey, bool) {
+	k, ok := r.Context().Value(apiKeyCtxKey{}).(db.APIKey)
+	return k, ok
+}
+
+// apiKeyTradePrefixes are the routes a trade key may call with a write method.
+var apiKeyTradePrefixes = []string{
+	"/api/v1/nft/market",
+	"/api/v1/nft/offers",
+	"/api/v1/exchange/",
+}
+
+// apiKeyDeniedPrefixes are never reachable with a key: account security and
+// admin routes need a real session.
+var apiKeyDeniedPrefixes = []string{
+	"/api/v1/admin/",
+	"/api/v1/api-keys",
+	"/api/v1/2fa",
+	"/api/v1/sessions",
+}
+
+// apiKeyAllows reports whether a key with scope may call method on path.
+func apiKeyAllows(scope, method, path string) bool {
+	for _, p := range apiKeyDeniedPrefixes {
+		if strings.HasPrefix(path, p) {
+			return false
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return scope == db.APIKeyRead || scope == db.APIKeyTrade
+	}
+	if scope != db.APIKeyTrade {
+		return false
+	}
+	for _, p := range apiKeyTradePrefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// windowLimiter is a fixed one-minute window per key (API key id, client
+// IP), kept in memory.
+type windowLimiter[K comparable] struct {
+	mu      sync.Mutex
+	windows map[K]limiterWindow
+}
+
+type limiterWindow struct {
+	start time.Time
+	count int64
+}
+
+func newWindowLimiter[K comparable]() *windowLimiter[K] {
+	return &windowLimiter[K]{windows: map[K]limiterWindow{}}
+}
+
+// allow counts a request of key and returns the seconds to wait when over
+// limit requests per minute.
+func (l *windowLimiter[K]) allow(key K, limit int64, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := now.Truncate(time.Minute)
+	w := l.windows[key]
+	if !w.start.Equal(start) {
+		if len(l.windows) > 10_000 {
+			for k, old := range l.windows {
+				if old.start.Before(start) {
+					delete(l.windows, k)
+				}
+			}
+		}
+		w = limiterWindow{start: start}
+	}
+	if w.count >= limit {
+		return false, int(start.Add(time.Minute).Sub(now)/time.Second) + 1
+	}
+	w.count++
+	l.windows[key] = w
+	return true, 0
+}
+
+// APIKeyMiddleware authenticates requests carrying X-API-Key, enforces the
+// key's scope and rate limit, and lets authUser resolve the key's owner.
+// Requests without the header pass through untouched.
+func APIKeyMiddleware(d *db.DB) func(http.Handler) http.Handler {
+	limiter := newWindowLimiter[int64]()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := strings.TrimSpace(r.Header.Get(APIKeyHeader))
+			if raw == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			k, err := d.AuthAPIKey(r.Context(), raw)
+			if err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) {
+					log.Printf("api: api key auth: %v", err)
+				}
+				defaultErrorHandler.HandleError(w, r, NewUnauthorizedError("bad api key"))
+				return
+			}
+			if !apiKeyAllows(k.Scope, r.Method, r.URL.Path) {
+				defaultErrorHandler.HandleError(w, r, NewForbiddenError("api key scope does not allow this request"))
+				return
+			}
+			if ok, retry := limiter.allow(k.KeyID, k.RatePerMin, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(retry))
+				defaultErrorHandler.HandleError(w, r, NewRateLimitError(retry))
+				return
+			}
+			// The key is the only credential of the request.
+			r.Header.Del(InitDataHeader)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, k)))
+		})
+	}
+}
+
+// APIKeysHandler lets users manage their API keys. Keys are managed from a
+// Telegram session only, never with another key.
+type APIKeysHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	stepUp *StepUp
+}
+
+func NewAPIKeysHandler(cfg config.Config, d *db.DB, stepUp *StepUp) *APIKeysHandler {
+	return &APIKeysHandler{cfg: cfg, db: d, stepUp: stepUp}
+}
+
+func (h *APIKeysHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/api-keys", h.list)
+	mux.HandleFunc("POST /api/v1/api-keys", h.create)
+	mux.HandleFunc("POST /api/v1/api-keys/{id}/revoke", h.revoke)
+}
+
+func (h *APIKeysHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	keys, err := h.db.ListAPIKeys(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+// create issues a key; the plaintext key is in this response only. Trade keys
+// move coins and need a second factor.
+func (h *APIKeysHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       string `json:"name"`
+		Scope      string `json:"scope"`
+		RatePerMin int64  `json:"rate_per_minute"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.RatePerMin == 0 {
+		req.RatePerMin = h.cfg.APIKeyDefaultRate
+	}
+	if req.RatePerMin < 0 || req.RatePerMin > h.cfg.APIKeyMaxRate {
+		writeError(w, r, NewInvalidRequestError("bad rate_per_minute"))
+		return
+	}
+	if req.Scope == db.APIKeyTrade && !h.stepUp.Verify(w, r, u.ID, db.StepUpAPIKey, 0) {
+		return
+	}
+	k, key, err := h.db.CreateAPIKey(r.Context(), u.ID, req.Name, req.Scope, req.RatePerMin, h.cfg.APIKeyMaxPerUser)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": k, "secret": key})
+}
+
+func (h *APIKeysHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RevokeAPIKey(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// BetLimitPolicy is the bet sizing policy from config; the games manager
+// enforces it on bet placement.
+func BetLimitPolicy(cfg config.Config) db.BetLimitPolicy {
+	return db.BetLimitPolicy{
+		MaxBet:           cfg.BetMax,
+		BalancePercent:   cfg.BetBalancePercent,
+		PlanCaps:         cfg.BetPlanCaps,
+		NewAccountMaxBet: cfg.BetNewAccountMax,
+		NewAccountDays:   cfg.BetNewAccountDays,
+		BotMaxBet:        cfg.BotMaxBet,
+	}
+}
+
+// BetLimitsHandler tells players their maximum bet, so the client can cap
+// the bet input before the server refuses it.
+type BetLimitsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewBetLimitsHandler(cfg config.Config, d *db.DB) *BetLimitsHandler {
+	return &BetLimitsHandler{cfg: cfg, db: d}
+}
+
+func (h *BetLimitsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/games/limits", h.limits)
+}
+
+func (h *BetLimitsHandler) limits(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	l, err := h.db.GetBetLimit(r.Context(), u.ID, BetLimitPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
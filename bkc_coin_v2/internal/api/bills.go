@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// BillsHandler serves shared bills: a total split into shares that
+// participants pay in; a fully funded bill is paid to the payee, one still
+// short at its deadline is refunded by the bill_expiry job.
+type BillsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewBillsHandler(cfg config.Config, d *db.DB, p *params.Service) *BillsHandler {
+	return &BillsHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *BillsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/bills", h.list)
+	mux.HandleFunc("POST /api/v1/bills", h.create)
+	mux.HandleFunc("GET /api/v1/bills/{id}", h.get)
+	mux.HandleFunc("POST /api/v1/bills/{id}/pay", h.pay)
+}
+
+func (h *BillsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	bills, err := h.db.ListBills(r.Context(), u.ID, int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bills": bills})
+}
+
+// create opens a bill; payee_id defaults to the creator.
+func (h *BillsHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title    string         `json:"title"`
+		PayeeID  int64          `json:"payee_id"`
+		Deadline time.Time      `json:"deadline"`
+		Shares   []db.BillShare `json:"shares"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.PayeeID == 0 {
+		req.PayeeID = u.ID
+	}
+	if req.Deadline.After(time.Now().Add(time.Duration(h.params.Int64(r.Context(), db.ParamBillMaxDays)) * 24 * time.Hour)) {
+		writeError(w, r, NewInvalidRequestError("deadline too far"))
+		return
+	}
+	for i := range req.Shares {
+		req.Shares[i].PaidAt = nil
+	}
+	b, err := h.db.CreateBill(r.Context(), u.ID, req.PayeeID, req.Title, req.Shares, req.Deadline, int(h.params.Int64(r.Context(), db.ParamBillMaxParticipants)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (h *BillsHandler) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	b, err := h.db.GetBill(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// pay pays the caller's share of the bill.
+func (h *BillsHandler) pay(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	b, err := h.db.PayBillShare(r.Context(), u.ID, id, VelocityPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if b.Status == db.BillCompleted {
+		log.Printf("api: bill %d funded, %d paid to user %d", b.BillID, b.Total, b.PayeeID)
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// ExpireBills refunds bills left short at their deadline. Run from the
+// bill_expiry job.
+func (h *BillsHandler) ExpireBills(ctx context.Context) error {
+	n, err := h.db.ExpireBills(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: bills refunded: %d", n)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// BonusesHandler serves bonus promo claims, the user's bonus balances and
+// the admin campaigns and abuse review. Bets spend bonuses in the games
+// settlement (db.BonusStakeTx).
+type BonusesHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewBonusesHandler(cfg config.Config, d *db.DB) *BonusesHandler {
+	return &BonusesHandler{cfg: cfg, db: d}
+}
+
+func (h *BonusesHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/bonuses", h.listMine)
+	mux.HandleFunc("POST /api/v1/bonuses/claim", h.claim)
+
+	mux.HandleFunc("GET /api/v1/admin/bonus-campaigns", h.adminCampaigns)
+	mux.HandleFunc("POST /api/v1/admin/bonus-campaigns", h.adminCreateCampaign)
+	mux.HandleFunc("PUT /api/v1/admin/bonus-campaigns/{id}/active", h.adminSetActive)
+	mux.HandleFunc("GET /api/v1/admin/bonuses", h.adminList)
+	mux.HandleFunc("POST /api/v1/admin/bonuses/{id}/review", h.adminReview)
+}
+
+// listMine returns the user's bonuses and what the open ones hold.
+func (h *BonusesHandler) listMine(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	bonuses, err := h.db.ListUserBonuses(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var balance, freeBets int64
+	for _, b := range bonuses {
+		if b.Status == db.BonusActive || b.Status == db.BonusReview {
+			balance += b.Balance
+			freeBets += b.FreeBet
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bonuses": bonuses, "bonus_balance": balance, "free_bets": freeBets})
+}
+
+func (h *BonusesHandler) claim(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	b, err := h.db.ClaimBonus(r.Context(), u.ID, req.Code, getClientIP(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if b.Status == db.BonusReview {
+		log.Printf("api: bonus %d of user %d held for review: %v", b.BonusID, u.ID, b.Flags)
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (h *BonusesHandler) adminCampaigns(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	campaigns, err := h.db.ListBonusCampaigns(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"campaigns": campaigns})
+}
+
+func (h *BonusesHandler) adminCreateCampaign(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.BonusCampaign
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c, err := h.db.CreateBonusCampaign(r.Context(), admin.ID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d created bonus campaign %s (%s)", admin.ID, c.Code, c.Kind)
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *BonusesHandler) adminSetActive(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Active bool `json:"active"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetBonusCampaignActive(r.Context(), id, req.Active); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set bonus campaign %d active=%t", admin.ID, id, req.Active)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// adminList is the review queue by default; ?status= (or "all") widens it.
+func (h *BonusesHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.BonusReview
+	} else if status == "all" {
+		status = ""
+	}
+	bonuses, err := h.db.ListBonuses(r.Context(), status, int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bonuses": bonuses})
+}
+
+func (h *BonusesHandler) adminReview(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Verdict string `json:"verdict"` // release | forfeit
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ReviewBonus(r.Context(), admin.ID, id, req.Verdict); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d bonus %d: %s", admin.ID, id, req.Verdict)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ExpireBonuses returns expired bonuses to the reserve. Run from the
+// bonus_expiry job.
+func (h *BonusesHandler) ExpireBonuses(ctx context.Context) error {
+	n, err := h.db.ExpireBonuses(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: bonuses expired: %d", n)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// BotsHandler scores game players for bot behaviour, serves the admin review
+// list and lets flagged players lift the CAPTCHA requirement.
+type BotsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	client *http.Client
+}
+
+func NewBotsHandler(cfg config.Config, d *db.DB) *BotsHandler {
+	return &BotsHandler{cfg: cfg, db: d, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (h *BotsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/games/bots", h.list)
+	mux.HandleFunc("POST /api/v1/admin/games/bots/{user_id}/review", h.review)
+	mux.HandleFunc("POST /api/v1/games/captcha", h.captcha)
+}
+
+func (h *BotsHandler) policy() db.BotPolicy {
+	p := db.BotPolicy{
+		Window:       time.Duration(h.cfg.BotWindowHours) * time.Hour,
+		MinBets:      h.cfg.BotMinBets,
+		LimitScore:   h.cfg.BotLimitScore,
+		CaptchaScore: h.cfg.BotCaptchaScore,
+		CaptchaValid: time.Duration(h.cfg.CaptchaValidHours) * time.Hour,
+	}
+	// Without a CAPTCHA provider the challenge could never be passed.
+	if h.cfg.CaptchaSecret == "" {
+		p.CaptchaScore = 0
+	}
+	return p
+}
+
+// list is the review queue: open scores from the limit score up by default,
+// ?status=all|open|cleared|confirmed and ?min_score= to widen it.
+func (h *BotsHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.BotOpen
+	} else if status == "all" {
+		status = ""
+	}
+	scores, err := h.db.ListBotScores(r.Context(), status, queryInt64(r, "min_score", h.cfg.BotLimitScore), int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"players": scores})
+}
+
+func (h *BotsHandler) review(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Verdict string `json:"verdict"` // cleared | confirmed
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ReviewBotScore(r.Context(), admin.ID, userID, req.Verdict); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d marked user %d as %s", admin.ID, userID, req.Verdict)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// captcha checks a solved challenge with the provider's siteverify and lifts
+// the requirement.
+func (h *BotsHandler) captcha(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	if h.cfg.CaptchaSecret == "" {
+		writeError(w, r, NewNotFoundError("captcha is not configured"))
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(req.Token) == "" {
+		writeError(w, r, NewInvalidRequestError("bad token"))
+		return
+	}
+	passed, err := h.verifyCaptcha(r.Context(), req.Token)
+	if err != nil {
+		log.Printf("api: captcha verify user=%d: %v", u.ID, err)
+		writeError(w, r, &APIError{Code: ErrCodeServiceUnavailable, Message: "captcha check unavailable", Timestamp: time.Now()})
+		return
+	}
+	if !passed {
+		writeError(w, r, &APIError{Code: ErrCodeCaptchaRequired, Message: "captcha failed", Timestamp: time.Now()})
+		return
+	}
+	if err := h.db.PassBotCaptcha(r.Context(), u.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// verifyCaptcha posts the token to a Turnstile/hCaptcha compatible siteverify.
+func (h *BotsHandler) verifyCaptcha(ctx context.Context, token string) (bool, error) {
+	form := url.Values{"secret": {h.cfg.CaptchaSecret}, "response": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.CaptchaVerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Success, nil
+}
+
+// ScoreBots rescores game players and applies the automated actions. Run
+// from the game_bots job.
+func (h *BotsHandler) ScoreBots(ctx context.Context) error {
+	n, err := h.db.ScoreGameBots(ctx, time.Now().UTC(), h.policy())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: game bots: %d players flagged", n)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Bundles: several owned NFTs and market items sold together at one price.
+
+func (h *MarketHandler) listBundles(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.ListBundles(r.Context(), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *MarketHandler) getBundle(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	b, err := h.db.GetBundle(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// createBundle takes components as {"kind":"nft","nft_id":..,"qty":..} or
+// {"kind":"item","listing_id":..}; items must be the seller's active listings.
+func (h *MarketHandler) createBundle(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title      string               `json:"title"`
+		PriceCoins int64                `json:"price_coins"`
+		Components []db.BundleComponent `json:"components"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	b, err := h.db.CreateBundle(r.Context(), u.ID, req.Title, req.PriceCoins, req.Components)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// buyBundle buys every component or nothing.
+func (h *MarketHandler) buyBundle(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	b, err := h.db.BuyBundle(r.Context(), u.ID, id, h.params.Int64(r.Context(), db.ParamNFTMarketFeeBP))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: user %d bought bundle %d for %d", u.ID, id, b.PriceCoins)
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (h *MarketHandler) cancelBundle(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelBundle(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// ConfigProfileHandler moves the runtime configuration between environments:
+// export it as a JSON profile on one, dry-run it on the other to see the diff
+// and a token, then apply it with that token in one transaction.
+// Super admins only.
+type ConfigProfileHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewConfigProfileHandler(cfg config.Config, d *db.DB) *ConfigProfileHandler {
+	return &ConfigProfileHandler{cfg: cfg, db: d}
+}
+
+func (h *ConfigProfileHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/config-profile", h.export)
+	mux.HandleFunc("POST /api/v1/admin/config-profile/dry-run", h.dryRun)
+	mux.HandleFunc("POST /api/v1/admin/config-profile", h.apply)
+}
+
+func (h *ConfigProfileHandler) export(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	p, err := h.db.ExportConfigProfile(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"profile": p})
+}
+
+// dryRun: {"profile":{...as exported...}}.
+func (h *ConfigProfileHandler) dryRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		Profile db.ConfigProfile `json:"profile"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	plan, err := h.db.PlanConfigProfile(r.Context(), req.Profile)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"plan": plan})
+}
+
+// apply: {"profile":{...},"token":"<from the dry run>"}.
+func (h *ConfigProfileHandler) apply(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Profile db.ConfigProfile `json:"profile"`
+		Token   string           `json:"token"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Token == "" {
+		writeError(w, r, NewInvalidRequestError("confirm with the dry-run token"))
+		return
+	}
+	plan, err := h.db.ApplyConfigProfile(r.Context(), admin.ID, req.Profile, req.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: config profile %s applied by admin %d (%d changes)", plan.Token, admin.ID, len(plan.Changes))
+	writeJSON(w, http.StatusOK, map[string]any{"plan": plan})
+}
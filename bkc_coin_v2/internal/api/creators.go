@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// CreatorsHandler serves creator profile pages (tips go through
+// TipsHandler) and the public goal widget embedded on external sites.
+type CreatorsHandler struct {
+	cfg     config.Config
+	db      *db.DB
+	limiter *windowLimiter[string]
+}
+
+func NewCreatorsHandler(cfg config.Config, d *db.DB) *CreatorsHandler {
+	return &CreatorsHandler{cfg: cfg, db: d, limiter: newWindowLimiter[string]()}
+}
+
+func (h *CreatorsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/creators/{user_id}", h.get)
+	mux.HandleFunc("PUT /api/v1/creators/me", h.save)
+	mux.HandleFunc("GET /api/v1/public/creators/{user_id}/goal", h.widget)
+}
+
+func (h *CreatorsHandler) get(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authUser(w, r, h.cfg); !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	p, err := h.db.GetCreatorProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *CreatorsHandler) save(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.CreatorProfileUpdate
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p, err := h.db.SaveCreatorProfile(r.Context(), u.ID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// widget returns the goal progress without auth, for any origin; it is
+// rate-limited per client IP and cacheable for a short while.
+func (h *CreatorsHandler) widget(w http.ResponseWriter, r *http.Request) {
+	if ok, retry := h.limiter.allow(getClientIP(r), h.cfg.CreatorWidgetRatePerMin, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, r, NewRateLimitError(retry))
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	name, g, err := h.db.GetTipGoal(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"creator_id":   userID,
+		"display_name": name,
+		"goal":         g,
+	})
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/shadow"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RatePublisher receives internal rate updates for live charts
+// (games.WebSocketEngine implements it).
+type RatePublisher interface {
+	PushRate(at time.Time, usdPerCoin float64, change24h float64)
+}
+
+// ReserveAlertNotifier tells the admin the reserve runway is running short
+// (implemented by the bot).
+type ReserveAlertNotifier interface {
+	SendReserveAlert(ctx context.Context, f db.ReserveForecast) error
+}
+
+// Low-runway alerts repeat at most this often while the runway stays short.
+const reserveAlertEvery = 24 * time.Hour
+
+// EconomyHandler serves the internal BKC rate and its history.
+type EconomyHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	rates  db.QuoteRates
+	chart  RatePublisher
+	alerts ReserveAlertNotifier
+
+	runwayG prometheus.Gauge
+	netG    prometheus.Gauge
+
+	overdueC       prometheus.Counter
+	overdueFailedC prometheus.Counter
+}
+
+func NewEconomyHandler(cfg config.Config, d *db.DB, rates db.QuoteRates, chart RatePublisher, alerts ReserveAlertNotifier, reg prometheus.Registerer) *EconomyHandler {
+	h := &EconomyHandler{
+		cfg:    cfg,
+		db:     d,
+		rates:  rates,
+		chart:  chart,
+		alerts: alerts,
+		runwayG: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bkc_reserve_runway_days",
+			Help: "Projected days until the unreserved reserve is exhausted (-1 = not draining).",
+		}),
+		netG: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bkc_reserve_net_per_day",
+			Help: "Recent reserve change per day in BKC (negative while draining).",
+		}),
+		overdueC: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bkc_bank_loans_overdue_total",
+			Help: "Bank loans marked overdue by the bank_loans_overdue job.",
+		}),
+		overdueFailedC: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bkc_bank_loans_overdue_failed_total",
+			Help: "Overdue bank loans the job failed to mark (retried on a later run).",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(h.runwayG, h.netG, h.overdueC, h.overdueFailedC)
+	}
+	return h
+}
+
+func (h *EconomyHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/economy/rate", h.rate)
+	mux.HandleFunc("GET /api/v1/economy/rate/history", h.rateHistory)
+	mux.HandleFunc("GET /api/v1/economy/rate/quote", h.rateQuote)
+	mux.HandleFunc("GET /api/v1/economy/emission", h.emission)
+	mux.HandleFunc("GET /api/v1/economy/burns", h.burns)
+	mux.HandleFunc("GET /api/v1/economy/burns/reports", h.burnReports)
+	mux.HandleFunc("GET /api/v1/admin/burn-schedules", h.adminBurnSchedules)
+	mux.HandleFunc("POST /api/v1/admin/burn-schedules", h.adminCreateBurnSchedule)
+	mux.HandleFunc("PUT /api/v1/admin/burn-schedules/{id}", h.adminUpdateBurnSchedule)
+	mux.HandleFunc("GET /api/v1/admin/reserve/forecast", h.adminReserveForecast)
+	mux.HandleFunc("GET /api/v1/admin/economy/shadow", h.adminShadowReport)
+	mux.HandleFunc("GET /api/v1/admin/stabilization", h.adminStabilization)
+	mux.HandleFunc("POST /api/v1/admin/stabilization/interventions", h.adminIntervene)
+}
+
+func (h *EconomyHandler) rate(w http.ResponseWriter, r *http.Request) {
+	s, err := h.db.GetSystem(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rate := s.CoinsPerUSD()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"coins_per_usd":  rate,
+		"usd_per_coin":   usdPerCoin(rate),
+		"reserve_supply": s.ReserveSupply,
+		"start_rate":     s.StartRateCoinsUSD,
+		"min_rate":       s.MinRateCoinsUSD,
+	})
+}
+
+// rateHistory returns coins-per-USD candles: ?interval=1m|5m|15m|1h|4h|1d&limit=&to=<unix>.
+func (h *EconomyHandler) rateHistory(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("interval"))
+	if name == "" {
+		name = "1h"
+	}
+	interval, ok := db.RateIntervals[name]
+	if !ok {
+		writeError(w, r, errors.New("bad interval"))
+		return
+	}
+	var to time.Time
+	if ts := queryInt64(r, "to", 0); ts > 0 {
+		to = time.Unix(ts, 0).UTC()
+	}
+	candles, err := h.db.RateCandles(r.Context(), interval, to, int(queryInt64(r, "limit", 200)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"interval": name, "candles": candles})
+}
+
+// rateQuote previews how many BKC a payment buys on the rate curve:
+// ?currency=USD|USDT|TON&amount=12.5. Deposits, CryptoPay invoices and payment
+// orders are priced the same way.
+func (h *EconomyHandler) rateQuote(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(strings.TrimSpace(r.URL.Query().Get("amount")), 64)
+	if err != nil {
+		writeError(w, r, errors.New("bad amount"))
+		return
+	}
+	q, err := h.db.QuoteCoins(r.Context(), r.URL.Query().Get("currency"), amount, h.rates)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+// emission reports today's minted coins against the global daily cap.
+func (h *EconomyHandler) emission(w http.ResponseWriter, r *http.Request) {
+	em, err := h.db.GetEmission(r.Context(), time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"emission": em, "remaining": em.Remaining()})
+}
+
+// burns reports cumulative burns by ledger kind and the resulting supply.
+func (h *EconomyHandler) burns(w http.ResponseWriter, r *http.Request) {
+	totals, err := h.db.BurnTotals(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s, err := h.db.GetSystem(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var burned int64
+	for _, t := range totals {
+		burned += t.Amount
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"by_kind":        totals,
+		"total_burned":   burned,
+		"total_supply":   s.TotalSupply,
+		"reserve_supply": s.ReserveSupply,
+	})
+}
+
+// burnReports is the public log of scheduled fee burns: ?limit=.
+func (h *EconomyHandler) burnReports(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.db.ListBurnReports(r.Context(), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reports": reports})
+}
+
+type burnScheduleRequest struct {
+	Name       string     `json:"name"`
+	FeeKinds   []string   `json:"fee_kinds"`
+	BurnBP     int64      `json:"burn_bp"`
+	PeriodDays int64      `json:"period_days"`
+	Enabled    *bool      `json:"enabled"`
+	FirstRunAt *time.Time `json:"first_run_at"`
+}
+
+func (req burnScheduleRequest) schedule() db.BurnSchedule {
+	s := db.BurnSchedule{Name: req.Name, FeeKinds: req.FeeKinds, BurnBP: req.BurnBP, PeriodDays: req.PeriodDays, Enabled: true}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+	return s
+}
+
+func (h *EconomyHandler) adminBurnSchedules(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	list, err := h.db.ListBurnSchedules(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": list, "fee_kinds": db.BurnFeeKinds})
+}
+
+// adminCreateBurnSchedule adds a schedule, e.g. burn 20% (burn_bp=2000) of
+// weekly fees (period_days=7).
+func (h *EconomyHandler) adminCreateBurnSchedule(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req burnScheduleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var first time.Time
+	if req.FirstRunAt != nil {
+		first = req.FirstRunAt.UTC()
+	}
+	s, err := h.db.CreateBurnSchedule(r.Context(), admin.ID, req.schedule(), first)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": s})
+}
+
+func (h *EconomyHandler) adminUpdateBurnSchedule(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req burnScheduleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s, err := h.db.UpdateBurnSchedule(r.Context(), admin.ID, id, req.schedule())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": s})
+}
+
+// adminReserveForecast returns a fresh runway projection and the recorded
+// history: ?window_days=&limit=.
+// adminShadowReport compares the candidate tap reward formula with what was
+// paid over the last days (ECONOMY_SHADOW).
+func (h *EconomyHandler) adminShadowReport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	days := queryInt64(r, "days", 14)
+	if days < 1 || days > 90 {
+		writeError(w, r, NewInvalidRequestError("bad days"))
+		return
+	}
+	engine := r.URL.Query().Get("engine")
+	if engine == "" {
+		engine = shadow.Engine
+	}
+	since := time.Now().UTC().AddDate(0, 0, -int(days-1))
+	report, err := h.db.TapShadowReport(r.Context(), engine, since, int(queryInt64(r, "top", 20)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": h.cfg.EconomyShadow, "report": report})
+}
+
+func (h *EconomyHandler) adminReserveForecast(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	f, err := h.db.ForecastReserve(r.Context(), queryInt64(r, "window_days", h.cfg.ReserveForecastWindowDays), time.Time{})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	history, err := h.db.ListReserveForecasts(r.Context(), int(queryInt64(r, "limit", 48)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"forecast":   f,
+		"alert_days": h.cfg.ReserveRunwayAlertDays,
+		"history":    history,
+	})
+}
+
+// adminStabilization reports the stabilization fund and its interventions: ?limit=.
+func (h *EconomyHandler) adminStabilization(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	rep, err := h.db.StabilizationReport(r.Context(), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// adminIntervene spends the stabilization fund:
+// {"kind":"buy_support"|"reserve_topup","amount":1000000,"reason":"..."}.
+func (h *EconomyHandler) adminIntervene(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind   string `json:"kind"`
+		Amount int64  `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	it, err := h.db.Intervene(r.Context(), admin.ID, req.Kind, req.Amount, req.Reason)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: stabilization %s %d by admin %d", it.Kind, it.Amount, admin.ID)
+	writeJSON(w, http.StatusOK, map[string]any{"intervention": it})
+}
+
+// ForecastReserve records the runway projection, updates the metrics and
+// alerts the admin when the runway drops below RESERVE_RUNWAY_ALERT_DAYS.
+// Run from the reserve_forecast job.
+func (h *EconomyHandler) ForecastReserve(ctx context.Context) error {
+	f, err := h.db.ForecastReserve(ctx, h.cfg.ReserveForecastWindowDays, time.Time{})
+	if err != nil {
+		return err
+	}
+	h.runwayG.Set(f.RunwayDays)
+	h.netG.Set(float64(f.NetPerDay))
+
+	limit := float64(h.cfg.ReserveRunwayAlertDays)
+	if h.alerts != nil && limit > 0 && f.RunwayDays >= 0 && f.RunwayDays < limit {
+		last, ok, err := h.db.LastReserveAlert(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok || f.At.Sub(last) >= reserveAlertEvery {
+			if err := h.alerts.SendReserveAlert(ctx, f); err != nil {
+				log.Printf("api: reserve alert: %v", err)
+			} else {
+				f.Alerted = true
+			}
+		}
+	}
+	return h.db.RecordReserveForecast(ctx, f)
+}
+
+// MarkOverdueLoans marks the bank loans past due, resuming from the last
+// checkpoint, and counts the outcome. Run from the bank_loans_overdue job on
+// the leader only.
+func (h *EconomyHandler) MarkOverdueLoans(ctx context.Context) error {
+	run, err := h.db.RunOverdueBankLoans(ctx, time.Time{}, 0)
+	h.overdueC.Add(float64(run.Processed))
+	h.overdueFailedC.Add(float64(run.Failed))
+	if run.Failed > 0 {
+		log.Printf("api: overdue loans: %d failed, last: %s", run.Failed, run.LastError)
+	}
+	if err == nil && !run.Exhausted {
+		log.Printf("api: overdue loans: stopped after %d batches, resuming next run", run.Batches)
+	}
+	return err
+}
+
+// SampleRate records the current rate and pushes it to the live chart.
+// Run from the rate_sample job.
+func (h *EconomyHandler) SampleRate(ctx context.Context) error {
+	cur, err := h.db.RecordRateSample(ctx)
+	if err != nil {
+		return err
+	}
+	if h.chart == nil {
+		return nil
+	}
+	var change float64
+	prev, ok, err := h.db.RateAt(ctx, cur.At.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if ok && prev.CoinsPerUSD > 0 {
+		// The coin gets dearer as coins-per-USD falls.
+		p0, p1 := usdPerCoin(prev.CoinsPerUSD), usdPerCoin(cur.CoinsPerUSD)
+		change = (p1 - p0) / p0 * 100
+	}
+	h.chart.PushRate(cur.At, usdPerCoin(cur.CoinsPerUSD), change)
+	return nil
+}
+
+func usdPerCoin(coinsPerUSD int64) float64 {
+	if coinsPerUSD <= 0 {
+		return 0
+	}
+	return 1 / float64(coinsPerUSD)
+}
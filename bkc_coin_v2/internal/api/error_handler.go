@@ -29,6 +29,10 @@ const (
 	ErrCodeEnergyDepleted   ErrorCode = "ENERGY_DEPLETED"
 	ErrCodeDailyLimit      ErrorCode = "DAILY_LIMIT"
 	ErrCodeMaintenance     ErrorCode = "MAINTENANCE"
+	ErrCodeConflict        ErrorCode = "CONFLICT"
+	ErrCodeStepUpRequired  ErrorCode = "STEP_UP_REQUIRED"
+	ErrCodeRequestSignature ErrorCode = "REQUEST_SIGNATURE_REQUIRED"
+	ErrCodeCaptchaRequired ErrorCode = "CAPTCHA_REQUIRED"
 )
 
 // APIError represents a structured API error
@@ -193,11 +197,11 @@ func (eh *ErrorHandler) getStatusCodeForError(code ErrorCode) int {
 		return http.StatusBadRequest
 	case ErrCodeUnauthorized:
 		return http.StatusUnauthorized
-	case ErrCodeForbidden:
+	case ErrCodeForbidden, ErrCodeStepUpRequired, ErrCodeRequestSignature, ErrCodeCaptchaRequired:
 		return http.StatusForbidden
 	case ErrCodeNotFound:
 		return http.StatusNotFound
-	case ErrCodeDuplicateEntry:
+	case ErrCodeDuplicateEntry, ErrCodeConflict:
 		return http.StatusConflict
 	case ErrCodeRateLimit, ErrCodeEnergyDepleted, ErrCodeDailyLimit:
 		return http.StatusTooManyRequests
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// EventsHandler exposes the per-user notification feed and takes the
+// webapp's analytics events.
+type EventsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewEventsHandler(cfg config.Config, d *db.DB) *EventsHandler {
+	return &EventsHandler{cfg: cfg, db: d}
+}
+
+func (h *EventsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/events", h.list)
+	mux.HandleFunc("POST /api/v1/events/read", h.markRead)
+	mux.HandleFunc("POST /api/v1/events", h.ingest)
+}
+
+// list supports ?after=<event_id> for polling and ?limit=.
+func (h *EventsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListUserEvents(r.Context(), u.ID, queryInt64(r, "after", 0), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *EventsHandler) markRead(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		UpTo int64 `json:"up_to"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.MarkUserEventsRead(r.Context(), u.ID, req.UpTo); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ingest takes a batch of up to 100 client analytics events. Invalid events
+// are reported by index and the rest stored; users outside the sample, or
+// every user while ClickHouse is off, get sampled=false and nothing stored.
+func (h *EventsHandler) ingest(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Events []db.ClientEvent `json:"events"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Events) == 0 || len(req.Events) > db.MaxClientEventBatch {
+		writeError(w, r, NewInvalidRequestError("events must hold 1..100 events"))
+		return
+	}
+	if h.cfg.ClickHouseURL == "" || !db.ClientEventSampled(u.ID, h.cfg.AnalyticsSampleBP) {
+		writeJSON(w, http.StatusAccepted, map[string]any{"accepted": 0, "sampled": false})
+		return
+	}
+	n, rejected, err := h.db.InsertClientEvents(r.Context(), u.ID, req.Events)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"accepted": n, "sampled": true, "rejected": rejected})
+}
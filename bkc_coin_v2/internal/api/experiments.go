@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// ExperimentsHandler serves the admin API of A/B experiments and the
+// user's own variant for client-side branching.
+type ExperimentsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewExperimentsHandler(cfg config.Config, d *db.DB) *ExperimentsHandler {
+	return &ExperimentsHandler{cfg: cfg, db: d}
+}
+
+func (h *ExperimentsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/experiments/{key}", h.variant)
+
+	mux.HandleFunc("GET /api/v1/admin/experiments", h.adminList)
+	mux.HandleFunc("POST /api/v1/admin/experiments", h.adminCreate)
+	mux.HandleFunc("GET /api/v1/admin/experiments/{key}", h.adminReport)
+	mux.HandleFunc("POST /api/v1/admin/experiments/{key}/start", h.adminStart)
+	mux.HandleFunc("POST /api/v1/admin/experiments/{key}/stop", h.adminStop)
+}
+
+// experimentParam is the user's value of param in a running experiment, or
+// def when the experiment is not running or the variant leaves it unset.
+// Errors fall back to def: an experiment never fails the request.
+func experimentParam(ctx context.Context, d *db.DB, key string, userID int64, param string, def int64) int64 {
+	v, ok, err := d.ExposeExperiment(ctx, key, userID)
+	if err != nil {
+		log.Printf("api: experiment %s: %v", key, err)
+		return def
+	}
+	if !ok {
+		return def
+	}
+	if p, ok := v.Params[param]; ok {
+		return p
+	}
+	return def
+}
+
+// variant returns the caller's variant, logging the exposure; running is
+// false (and variant empty) when the experiment is not running.
+func (h *ExperimentsHandler) variant(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	v, running, err := h.db.ExposeExperiment(r.Context(), r.PathValue("key"), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": r.PathValue("key"), "running": running, "variant": v.Name, "params": v.Params})
+}
+
+func (h *ExperimentsHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListExperiments(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *ExperimentsHandler) adminCreate(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.Experiment
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	e, err := h.db.CreateExperiment(r.Context(), admin.ID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// adminReport returns the experiment with exposures and guardrail metrics
+// per variant.
+func (h *ExperimentsHandler) adminReport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	rep, err := h.db.ExperimentReport(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+func (h *ExperimentsHandler) adminStart(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	if err := h.db.StartExperiment(r.Context(), admin.ID, r.PathValue("key")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *ExperimentsHandler) adminStop(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.StopExperiment(r.Context(), admin.ID, r.PathValue("key"), req.Reason); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// CheckGuardrails stops experiments that breach a guardrail (job).
+func (h *ExperimentsHandler) CheckGuardrails(ctx context.Context) error {
+	n, err := h.db.CheckExperimentGuardrails(ctx)
+	if n > 0 {
+		log.Printf("api: experiments stopped by guardrails: %d", n)
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/faults"
+)
+
+// FaultsHandler lets staging set the process-wide fault rules, which apply to
+// background jobs and to requests without the X-BKC-Fault header. Routes are
+// registered only when FAULT_INJECTION is on (never in production).
+type FaultsHandler struct {
+	cfg config.Config
+}
+
+func NewFaultsHandler(cfg config.Config) *FaultsHandler {
+	return &FaultsHandler{cfg: cfg}
+}
+
+func (h *FaultsHandler) RegisterRoutes(mux *http.ServeMux) {
+	if !h.cfg.FaultInjection {
+		return
+	}
+	mux.HandleFunc("GET /api/v1/admin/faults", h.get)
+	mux.HandleFunc("PUT /api/v1/admin/faults", h.set)
+}
+
+func (h *FaultsHandler) get(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rules": faults.Global()})
+}
+
+func (h *FaultsHandler) set(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Rules string `json:"rules"` // package faults syntax; empty clears
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rules, err := faults.Parse(req.Rules)
+	if err != nil {
+		writeError(w, r, NewInvalidRequestError(err.Error()))
+		return
+	}
+	faults.SetGlobal(rules)
+	log.Printf("api: admin %d set fault rules %q", admin.ID, req.Rules)
+	writeJSON(w, http.StatusOK, map[string]any{"rules": rules})
+}
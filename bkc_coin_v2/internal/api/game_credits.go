@@ -0,0 +1,116 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// GameCreditsHandler converts BKC to game credits and back at the rates an
+// admin sets. Credits are staked in games only (games.BetFromCredits); there
+// is no transfer or withdrawal of credits.
+type GameCreditsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewGameCreditsHandler(cfg config.Config, d *db.DB) *GameCreditsHandler {
+	return &GameCreditsHandler{cfg: cfg, db: d}
+}
+
+func (h *GameCreditsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/game-credits", h.get)
+	mux.HandleFunc("POST /api/v1/game-credits/buy", h.buy)
+	mux.HandleFunc("POST /api/v1/game-credits/sell", h.sell)
+
+	mux.HandleFunc("GET /api/v1/admin/economy/game-credits", h.adminGet)
+	mux.HandleFunc("PUT /api/v1/admin/economy/game-credits", h.adminSet)
+}
+
+func (h *GameCreditsHandler) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	out, err := h.db.GetGameCredits(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// buy spends {"bkc": n} of the balance on credits.
+func (h *GameCreditsHandler) buy(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		BKC int64 `json:"bkc"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	out, err := h.db.BuyGameCredits(r.Context(), u.ID, req.BKC)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// sell converts {"credits": n} back to BKC; only whole BKC are paid.
+func (h *GameCreditsHandler) sell(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Credits int64 `json:"credits"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	out, err := h.db.SellGameCredits(r.Context(), u.ID, req.Credits)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *GameCreditsHandler) adminGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	rates, err := h.db.GetGameCreditRates(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+func (h *GameCreditsHandler) adminSet(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.GameCreditRates
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rates, err := h.db.SetGameCreditRates(r.Context(), admin.ID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set game credit rates buy=%d sell=%d limit=%d enabled=%t", admin.ID, rates.BuyRate, rates.SellRate, rates.SellDailyLimit, rates.Enabled)
+	writeJSON(w, http.StatusOK, rates)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// GiftsHandler lets users lock BKC as a gift behind a one-time claim code
+// and claim gifts sent to them. The bot claims ?start=gift_<code> links too.
+type GiftsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+	stepUp *StepUp
+}
+
+func NewGiftsHandler(cfg config.Config, d *db.DB, p *params.Service, stepUp *StepUp) *GiftsHandler {
+	return &GiftsHandler{cfg: cfg, db: d, params: p, stepUp: stepUp}
+}
+
+func (h *GiftsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/gifts", h.list)
+	mux.HandleFunc("POST /api/v1/gifts", h.create)
+	mux.HandleFunc("POST /api/v1/gifts/claim", h.claim)
+}
+
+func (h *GiftsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	gifts, err := h.db.ListGifts(r.Context(), u.ID, int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"gifts": gifts})
+}
+
+// create locks {"amount", "message"}; the response carries the claim code
+// and its start payload (https://t.me/<bot>?start=<start_payload>) once.
+// Anyone holding the code can claim it, so it needs the same second factor
+// as a transfer of the amount.
+func (h *GiftsHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount  int64  `json:"amount"`
+		Message string `json:"message"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Amount < h.params.Int64(r.Context(), db.ParamGiftMinAmount) || req.Amount > h.params.Int64(r.Context(), db.ParamGiftMaxAmount) {
+		writeError(w, r, NewInvalidRequestError("gift amount out of range"))
+		return
+	}
+	if req.Amount >= h.cfg.StepUpTransferCoins && !h.stepUp.Verify(w, r, u.ID, db.StepUpTransfer, req.Amount) {
+		return
+	}
+	g, code, err := h.db.CreateGift(r.Context(), u.ID, req.Amount, req.Message, time.Duration(h.cfg.GiftTTLHours)*time.Hour, VelocityPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"gift":          g,
+		"code":          code,
+		"start_payload": db.GiftLinkPrefix + code,
+	})
+}
+
+// claim takes {"code"}: the bare code or the whole start payload.
+func (h *GiftsHandler) claim(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	g, err := h.db.ClaimGift(r.Context(), u.ID, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// ExpireGifts refunds unclaimed gifts past their expiry. Run from the
+// gift_expiry job.
+func (h *GiftsHandler) ExpireGifts(ctx context.Context) error {
+	n, err := h.db.ExpireGifts(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: gifts refunded: %d", n)
+	}
+	return nil
+}
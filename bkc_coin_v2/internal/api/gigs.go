@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// GigsHandler serves freelance services: listings of the services market
+// category, milestone contracts with escrow and the admin dispute queue.
+type GigsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewGigsHandler(cfg config.Config, d *db.DB, p *params.Service) *GigsHandler {
+	return &GigsHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *GigsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/services", h.listServices)
+	mux.HandleFunc("POST /api/v1/services", h.createService)
+
+	mux.HandleFunc("GET /api/v1/gigs", h.list)
+	mux.HandleFunc("POST /api/v1/gigs", h.create)
+	mux.HandleFunc("GET /api/v1/gigs/{id}", h.get)
+	mux.HandleFunc("POST /api/v1/gigs/milestones/{id}/fund", h.fund)
+	mux.HandleFunc("POST /api/v1/gigs/milestones/{id}/deliver", h.deliver)
+	mux.HandleFunc("POST /api/v1/gigs/milestones/{id}/release", h.release)
+	mux.HandleFunc("POST /api/v1/gigs/milestones/{id}/dispute", h.dispute)
+
+	mux.HandleFunc("GET /api/v1/admin/disputes", h.adminDisputes)
+	mux.HandleFunc("POST /api/v1/admin/disputes/{id}/resolve", h.adminResolve)
+}
+
+func (h *GigsHandler) listServices(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authUser(w, r, h.cfg); !ok {
+		return
+	}
+	listings, err := h.db.ListServiceListings(r.Context(), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"listings": listings})
+}
+
+// createService lists a service; price_coins is the indicative rate, the
+// actual amounts are set per milestone when the gig is hired. The listing
+// goes live after moderation.
+func (h *GigsHandler) createService(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		PriceCoins  int64  `json:"price_coins"`
+		Contact     string `json:"contact"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	l, err := h.db.CreateMarketListing(r.Context(), u.ID, req.Title, req.Description, db.ServicesCategory, nil, req.PriceCoins, req.Contact, h.params.Int64(r.Context(), db.ParamMarketListingFee), moderationPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+func (h *GigsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	gigs, err := h.db.ListGigs(r.Context(), u.ID, int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"gigs": gigs})
+}
+
+func (h *GigsHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		ListingID  int64                  `json:"listing_id"`
+		Milestones []db.GigMilestoneInput `json:"milestones"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	g, err := h.db.CreateGig(r.Context(), u.ID, req.ListingID, req.Milestones, int(h.params.Int64(r.Context(), db.ParamGigMaxMilestones)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (h *GigsHandler) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	g, err := h.db.GetGig(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// milestoneAction runs a buyer or seller action on the path milestone.
+func (h *GigsHandler) milestoneAction(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context, userID, milestoneID int64) (db.GigMilestone, error)) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	m, err := fn(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *GigsHandler) fund(w http.ResponseWriter, r *http.Request) {
+	h.milestoneAction(w, r, h.db.FundGigMilestone)
+}
+
+func (h *GigsHandler) deliver(w http.ResponseWriter, r *http.Request) {
+	h.milestoneAction(w, r, h.db.DeliverGigMilestone)
+}
+
+func (h *GigsHandler) release(w http.ResponseWriter, r *http.Request) {
+	h.milestoneAction(w, r, h.db.ReleaseGigMilestone)
+}
+
+func (h *GigsHandler) dispute(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	dp, err := h.db.DisputeGigMilestone(r.Context(), u.ID, id, req.Reason)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dp)
+}
+
+// adminDisputes lists disputes by ?status= (open by default, "all" for any).
+func (h *GigsHandler) adminDisputes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = db.DisputeOpen
+	case "all":
+		status = ""
+	}
+	list, err := h.db.ListDisputes(r.Context(), status, int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"disputes": list})
+}
+
+func (h *GigsHandler) adminResolve(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Resolution string `json:"resolution"` // release | refund | split
+		SellerBP   int64  `json:"seller_bp"`  // split only
+		Note       string `json:"note"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	dp, err := h.db.ResolveDispute(r.Context(), admin.ID, id, req.Resolution, req.SellerBP, req.Note)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d resolved dispute %d: %s seller_bp=%d", admin.ID, id, dp.Resolution, dp.SellerBP)
+	writeJSON(w, http.StatusOK, dp)
+}
+
+// ExpireGigMilestones applies milestone deadlines and the review window.
+// Run from the gig_deadlines job.
+func (h *GigsHandler) ExpireGigMilestones(ctx context.Context) error {
+	n, err := h.db.ExpireGigMilestones(ctx, time.Now().UTC(), time.Duration(h.cfg.GigReviewDays)*24*time.Hour)
+	if n > 0 {
+		log.Printf("api: gig milestones settled by deadline: %d", n)
+	}
+	return err
+}
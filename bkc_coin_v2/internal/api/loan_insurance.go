@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// LoanInsurancePolicy is the P2P loan insurance pricing from config.
+func LoanInsurancePolicy(cfg config.Config) db.InsurancePolicy {
+	return db.InsurancePolicy{
+		PremiumBP:  cfg.P2PInsurancePremiumBP,
+		CoverageBP: cfg.P2PInsuranceCoverBP,
+		GraceDays:  cfg.P2PDefaultGraceDays,
+
+		MinRepaid:   cfg.P2PInsureMinRepaid,
+		BorrowerCap: cfg.P2PInsureBorrowerCap,
+		PairCap:     cfg.P2PInsurePairCap,
+	}
+}
+
+// LoanInsuranceHandler serves the P2P loan insurance pool: borrowers insure
+// their fresh loans, lenders declare defaults and collect, and anyone can
+// see how solvent the pool is.
+type LoanInsuranceHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewLoanInsuranceHandler(cfg config.Config, d *db.DB) *LoanInsuranceHandler {
+	return &LoanInsuranceHandler{cfg: cfg, db: d}
+}
+
+func (h *LoanInsuranceHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/p2p/insurance", h.stats)
+	mux.HandleFunc("GET /api/v1/p2p/credit-score", h.creditScore)
+	mux.HandleFunc("GET /api/v1/p2p/loans/{id}/insurance", h.quote)
+	mux.HandleFunc("POST /api/v1/p2p/loans/{id}/insure", h.insure)
+	mux.HandleFunc("POST /api/v1/p2p/loans/{id}/default", h.declareDefault)
+}
+
+// stats is public: the pool balance, premiums, payouts and solvency.
+func (h *LoanInsuranceHandler) stats(w http.ResponseWriter, r *http.Request) {
+	s, err := h.db.LoanInsuranceStats(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pool":        s,
+		"premium_bp":  h.cfg.P2PInsurancePremiumBP,
+		"coverage_bp": h.cfg.P2PInsuranceCoverBP,
+		"grace_days":  h.cfg.P2PDefaultGraceDays,
+		"min_repaid":  h.cfg.P2PInsureMinRepaid,
+	})
+}
+
+func (h *LoanInsuranceHandler) creditScore(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	cs, err := h.db.BorrowerCreditScore(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// quote prices insurance of the caller's loan.
+func (h *LoanInsuranceHandler) quote(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	q, err := h.db.QuoteLoanInsurance(r.Context(), u.ID, id, LoanInsurancePolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+// insure: {"max_premium"}, the quoted premium; 0 accepts any.
+func (h *LoanInsuranceHandler) insure(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		MaxPremium int64 `json:"max_premium"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q, err := h.db.InsureP2PLoan(r.Context(), u.ID, id, req.MaxPremium, LoanInsurancePolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+// declareDefault is the lender closing a loan left unpaid past the grace
+// period.
+func (h *LoanInsuranceHandler) declareDefault(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	res, err := h.db.DefaultP2PLoan(r.Context(), u.ID, id, LoanInsurancePolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// LoanOffersHandler serves standing P2P lending offers: lenders publish
+// terms once and borrowers take a loan within them without waiting for an
+// approval. Lenders also get their portfolio analytics here.
+type LoanOffersHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewLoanOffersHandler(cfg config.Config, d *db.DB) *LoanOffersHandler {
+	return &LoanOffersHandler{cfg: cfg, db: d}
+}
+
+func (h *LoanOffersHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/p2p/offers", h.list)
+	mux.HandleFunc("GET /api/v1/p2p/offers/mine", h.mine)
+	mux.HandleFunc("POST /api/v1/p2p/offers", h.create)
+	mux.HandleFunc("POST /api/v1/p2p/offers/{id}/close", h.close)
+	mux.HandleFunc("POST /api/v1/p2p/offers/{id}/take", h.take)
+	mux.HandleFunc("GET /api/v1/p2p/portfolio", h.portfolio)
+
+	mux.HandleFunc("GET /api/v1/admin/p2p/lenders/{id}/portfolio", h.adminPortfolio)
+}
+
+// list returns active offers, lowest rate first; ?amount= keeps those that
+// lend it.
+func (h *LoanOffersHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authUser(w, r, h.cfg); !ok {
+		return
+	}
+	offers, err := h.db.ListP2PLoanOffers(r.Context(), queryInt64(r, "amount", 0), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"offers": offers})
+}
+
+func (h *LoanOffersHandler) mine(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	offers, err := h.db.ListLenderLoanOffers(r.Context(), u.ID, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"offers": offers})
+}
+
+// create: {"min_amount","max_amount","interest_bp","term_days",
+// "collateral_bp","max_exposure"}.
+func (h *LoanOffersHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.P2PLoanOffer
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.LenderID = u.ID
+	o, err := h.db.CreateP2PLoanOffer(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *LoanOffersHandler) close(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	o, err := h.db.CloseP2PLoanOffer(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// take: {"amount"}; the loan is active at once.
+func (h *LoanOffersHandler) take(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount int64 `json:"amount"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	loan, err := h.db.TakeP2PLoanOffer(r.Context(), u.ID, id, req.Amount)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loan)
+}
+
+// portfolio sums up the caller's loans as a lender.
+func (h *LoanOffersHandler) portfolio(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	p, err := h.db.LenderPortfolio(r.Context(), u.ID, h.cfg.P2PConcentrationBP)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *LoanOffersHandler) adminPortfolio(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	p, err := h.db.LenderPortfolio(r.Context(), id, h.cfg.P2PConcentrationBP)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
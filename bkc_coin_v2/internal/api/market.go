@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+	"bkc_coin_v2/internal/templates"
+)
+
+// MarketHandler serves market listings filed under the managed category
+// tree: browsing with counts, listing creation, bundles and the admin
+// category CRUD.
+type MarketHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewMarketHandler(cfg config.Config, d *db.DB, p *params.Service) *MarketHandler {
+	return &MarketHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *MarketHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/market/categories", h.categories)
+	mux.HandleFunc("GET /api/v1/market/categories/{slug}/listings", h.categoryListings)
+	mux.HandleFunc("POST /api/v1/market/listings", h.createListing)
+	mux.HandleFunc("GET /api/v1/market/price-suggestion", h.priceSuggestion)
+	mux.HandleFunc("GET /api/v1/market/bundles", h.listBundles)
+	mux.HandleFunc("POST /api/v1/market/bundles", h.createBundle)
+	mux.HandleFunc("GET /api/v1/market/bundles/{id}", h.getBundle)
+	mux.HandleFunc("POST /api/v1/market/bundles/{id}/buy", h.buyBundle)
+	mux.HandleFunc("POST /api/v1/market/bundles/{id}/cancel", h.cancelBundle)
+
+	mux.HandleFunc("GET /api/v1/admin/market/categories", h.adminList)
+	mux.HandleFunc("PUT /api/v1/admin/market/categories/{slug}", h.adminSave)
+	mux.HandleFunc("DELETE /api/v1/admin/market/categories/{slug}", h.adminDelete)
+}
+
+// categories returns the category tree with names in ?lang= (else
+// Accept-Language) and active listing counts.
+func (h *MarketHandler) categories(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = r.Header.Get("Accept-Language")
+	}
+	tree, err := h.db.MarketCategoryTree(r.Context(), templates.NormalizeLang(lang))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"categories": tree})
+}
+
+func (h *MarketHandler) categoryListings(w http.ResponseWriter, r *http.Request) {
+	listings, err := h.db.ListCategoryListings(r.Context(), r.PathValue("slug"), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"listings": listings})
+}
+
+// createListing files a listing under a category; it goes live after
+// moderation.
+func (h *MarketHandler) createListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title       string            `json:"title"`
+		Description string            `json:"description"`
+		Category    string            `json:"category"`
+		Attributes  map[string]string `json:"attributes"`
+		PriceCoins  int64             `json:"price_coins"`
+		Contact     string            `json:"contact"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	l, err := h.db.CreateMarketListing(r.Context(), u.ID, req.Title, req.Description, req.Category, req.Attributes, req.PriceCoins, req.Contact, h.params.Int64(r.Context(), db.ParamMarketListingFee), moderationPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		db.MarketListing
+		Market *db.PriceSuggestion `json:"market,omitempty"`
+	}{l, marketCheck(r.Context(), h.db, h.cfg, db.PriceKindCategory, l.Category, l.PriceCoins)})
+}
+
+// priceSuggestion returns the market price for ?category= or ?nft_id= from
+// recent sales; with ?price= it also says whether that price is above market.
+func (h *MarketHandler) priceSuggestion(w http.ResponseWriter, r *http.Request) {
+	kind, ref := db.PriceKindCategory, r.URL.Query().Get("category")
+	if id := queryInt64(r, "nft_id", 0); id > 0 {
+		kind, ref = db.PriceKindNFT, strconv.FormatInt(id, 10)
+	}
+	s, err := h.db.SuggestPrice(r.Context(), kind, ref, priceSince(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if price := queryInt64(r, "price", 0); price > 0 {
+		s.Check(price, h.cfg.PriceAboveMarketPct)
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// priceSince is the start of the comparable sales window.
+func priceSince(cfg config.Config) time.Time {
+	return time.Now().UTC().AddDate(0, 0, -int(cfg.PriceSuggestionDays))
+}
+
+// marketCheck compares a new listing's price with the market, for the
+// listing response; nil if the market price is unavailable.
+func marketCheck(ctx context.Context, d *db.DB, cfg config.Config, kind, ref string, price int64) *db.PriceSuggestion {
+	s, err := d.SuggestPrice(ctx, kind, ref, priceSince(cfg))
+	if err != nil {
+		log.Printf("api: price suggestion %s %s: %v", kind, ref, err)
+		return nil
+	}
+	s.Check(price, cfg.PriceAboveMarketPct)
+	return &s
+}
+
+func (h *MarketHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	cats, err := h.db.ListMarketCategories(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"categories": cats, "languages": templates.Languages, "default_lang": templates.DefaultLang})
+}
+
+// adminSave creates or updates the path category.
+func (h *MarketHandler) adminSave(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var c db.MarketCategory
+	if err := decodeJSON(w, r, &c); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c.Slug = r.PathValue("slug")
+	out, err := h.db.SaveMarketCategory(r.Context(), c)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *MarketHandler) adminDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	if err := h.db.DeleteMarketCategory(r.Context(), r.PathValue("slug")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
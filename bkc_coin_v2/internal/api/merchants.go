@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// MerchantsHandler serves the merchant point of sale: payment requests shown
+// as a QR code of a Mini App link, the payer's confirm-and-pay, daily
+// settlement reports and the admin registration of merchants.
+type MerchantsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewMerchantsHandler(cfg config.Config, d *db.DB, p *params.Service) *MerchantsHandler {
+	return &MerchantsHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *MerchantsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/merchant/me", h.me)
+	mux.HandleFunc("POST /api/v1/merchant/orders", h.createOrder)
+	mux.HandleFunc("GET /api/v1/merchant/orders", h.listOrders)
+	mux.HandleFunc("POST /api/v1/merchant/orders/{id}/cancel", h.cancelOrder)
+	mux.HandleFunc("GET /api/v1/merchant/reports", h.reports)
+
+	mux.HandleFunc("GET /api/v1/pay/{code}", h.getPayment)
+	mux.HandleFunc("POST /api/v1/pay/{code}", h.pay)
+
+	mux.HandleFunc("GET /api/v1/admin/merchants", h.adminList)
+	mux.HandleFunc("PUT /api/v1/admin/merchants/{user_id}", h.adminSet)
+}
+
+// payLink is the Mini App link encoded in the QR code: a t.me direct link
+// with startapp=pay_<code> when MINI_APP_LINK is set, else the web app URL.
+func (h *MerchantsHandler) payLink(code string) string {
+	param := db.MerchantPayPrefix + code
+	if h.cfg.MiniAppLink != "" {
+		return h.cfg.MiniAppLink + "?startapp=" + param
+	}
+	base := strings.TrimSpace(h.cfg.WebappURL)
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "pay=" + url.QueryEscape(param)
+}
+
+type merchantOrderView struct {
+	db.MerchantOrder
+	Link string `json:"link"` // QR code content
+}
+
+func (h *MerchantsHandler) me(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	m, err := h.db.GetMerchant(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *MerchantsHandler) createOrder(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount      int64  `json:"amount"`
+		Description string `json:"description"`
+		ExternalRef string `json:"external_ref"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o, err := h.db.CreateMerchantOrder(r.Context(), u.ID, req.Amount, req.Description, req.ExternalRef, time.Duration(h.cfg.MerchantOrderTTLMinutes)*time.Minute)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, merchantOrderView{MerchantOrder: o, Link: h.payLink(o.Code)})
+}
+
+func (h *MerchantsHandler) listOrders(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	orders, err := h.db.ListMerchantOrders(r.Context(), u.ID, r.URL.Query().Get("status"), int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orders": orders})
+}
+
+func (h *MerchantsHandler) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelMerchantOrder(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *MerchantsHandler) reports(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	reports, err := h.db.ListMerchantReports(r.Context(), u.ID, int(queryInt64(r, "limit", 30)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reports": reports})
+}
+
+// getPayment is the payer's confirmation screen for a scanned code.
+func (h *MerchantsHandler) getPayment(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authUser(w, r, h.cfg); !ok {
+		return
+	}
+	o, name, err := h.db.GetMerchantOrder(r.Context(), r.PathValue("code"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"order_id":    o.OrderID,
+		"merchant_id": o.MerchantID,
+		"merchant":    name,
+		"amount":      o.Amount,
+		"description": o.Description,
+		"status":      o.Status,
+		"expires_at":  o.ExpiresAt,
+	})
+}
+
+func (h *MerchantsHandler) pay(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	o, err := h.db.PayMerchantOrder(r.Context(), u.ID, r.PathValue("code"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": o.OrderID, "amount": o.Amount, "status": o.Status, "paid_at": o.PaidAt})
+}
+
+func (h *MerchantsHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	merchants, err := h.db.ListMerchants(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"merchants": merchants})
+}
+
+// adminSet registers or updates a merchant; fee_bp defaults to
+// MERCHANT_FEE_BP and status to active.
+func (h *MerchantsHandler) adminSet(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Name   string `json:"name"`
+		FeeBP  *int64 `json:"fee_bp"`
+		Status string `json:"status"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	feeBP := h.params.Int64(r.Context(), db.ParamMerchantFeeBP)
+	if req.FeeBP != nil {
+		feeBP = *req.FeeBP
+	}
+	if req.Status == "" {
+		req.Status = db.MerchantActive
+	}
+	m, err := h.db.SetMerchant(r.Context(), admin.ID, userID, req.Name, feeBP, req.Status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set merchant %d fee=%dbp status=%s", admin.ID, userID, feeBP, req.Status)
+	writeJSON(w, http.StatusOK, m)
+}
+
+// SettleMerchants expires stale payment requests and closes yesterday's
+// (UTC) settlement reports. Run from the merchant_reports job.
+func (h *MerchantsHandler) SettleMerchants(ctx context.Context) error {
+	now := time.Now().UTC()
+	expired, err := h.db.ExpireMerchantOrders(ctx, now)
+	if err != nil {
+		return err
+	}
+	day := now.AddDate(0, 0, -1)
+	n, err := h.db.BuildMerchantReports(ctx, day)
+	if err != nil {
+		return err
+	}
+	if expired > 0 || n > 0 {
+		log.Printf("api: merchant orders expired: %d, reports for %s: %d", expired, day.Format("2006-01-02"), n)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// MergeHandler is the admin tool for merging duplicate accounts (e.g. after a
+// Telegram account migration): a dry run returns the diff and a token, and the
+// merge itself requires that token plus an explicit irreversible flag.
+type MergeHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewMergeHandler(cfg config.Config, d *db.DB) *MergeHandler {
+	return &MergeHandler{cfg: cfg, db: d}
+}
+
+func (h *MergeHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/merges", h.list)
+	mux.HandleFunc("POST /api/v1/admin/merges/dry-run", h.dryRun)
+	mux.HandleFunc("POST /api/v1/admin/merges", h.merge)
+}
+
+func (h *MergeHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	list, err := h.db.ListAccountMerges(r.Context(), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"merges": list})
+}
+
+// dryRun: {"from_id":1,"to_id":2}.
+func (h *MergeHandler) dryRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		FromID int64 `json:"from_id"`
+		ToID   int64 `json:"to_id"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	plan, err := h.db.PlanMerge(r.Context(), req.FromID, req.ToID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"plan": plan})
+}
+
+// merge: {"from_id":1,"to_id":2,"token":"<from the dry run>","irreversible":true}.
+func (h *MergeHandler) merge(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		FromID       int64  `json:"from_id"`
+		ToID         int64  `json:"to_id"`
+		Token        string `json:"token"`
+		Irreversible bool   `json:"irreversible"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !req.Irreversible || req.Token == "" {
+		writeError(w, r, NewInvalidRequestError("confirm with the dry-run token and irreversible=true"))
+		return
+	}
+	m, err := h.db.MergeUsers(r.Context(), admin.ID, req.FromID, req.ToID, req.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: account %d merged into %d by admin %d (merge %d)", m.FromID, m.ToID, admin.ID, m.MergeID)
+	writeJSON(w, http.StatusOK, map[string]any{"merge": m})
+}
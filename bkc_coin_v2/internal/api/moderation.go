@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// ModerationHandler serves the market listing moderation queue and runs
+// the automated screening of new listings.
+type ModerationHandler struct {
+	cfg        config.Config
+	db         *db.DB
+	classifier db.ImageClassifier // nil when MODERATION_CLASSIFIER_URL is unset
+}
+
+func NewModerationHandler(cfg config.Config, d *db.DB) *ModerationHandler {
+	h := &ModerationHandler{cfg: cfg, db: d}
+	if cfg.ModerationClassifierURL != "" {
+		h.classifier = &httpClassifier{url: cfg.ModerationClassifierURL, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return h
+}
+
+func (h *ModerationHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/listings/pending", h.pending)
+	mux.HandleFunc("POST /api/v1/admin/listings/{id}/moderate", h.moderate)
+}
+
+// moderationPolicy is the listing moderation policy from config; listing
+// creation needs it for offender throttling.
+func moderationPolicy(cfg config.Config) db.ModerationPolicy {
+	return db.ModerationPolicy{
+		BannedWords:      cfg.ModerationBannedWords,
+		NudityMax:        float64(cfg.ModerationNudityMaxPct) / 100,
+		PriceAnomalyX:    cfg.ModerationPriceAnomalyX,
+		AboveMarketPct:   cfg.PriceAboveMarketPct,
+		PriceWindow:      time.Duration(cfg.PriceSuggestionDays) * 24 * time.Hour,
+		ScreenDelay:      time.Duration(cfg.ModerationScreenDelayMinutes) * time.Minute,
+		OffenderRejects:  cfg.ModerationOffenderRejects,
+		OffenderWindow:   time.Duration(cfg.ModerationOffenderDays) * 24 * time.Hour,
+		OffenderCooldown: time.Duration(cfg.ModerationOffenderCooldownHours) * time.Hour,
+	}
+}
+
+func (h *ModerationHandler) pending(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListModerationQueue(r.Context(), moderationPolicy(h.cfg).OffenderWindow, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// moderate approves or rejects a pending listing; a rejection needs a reason.
+func (h *ModerationHandler) moderate(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Approve bool   `json:"approve"`
+		Reason  string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ModerateListing(r.Context(), admin.ID, id, req.Approve, req.Reason); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d moderated listing %d approve=%v", admin.ID, id, req.Approve)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ScreenListings runs the automated checks on new listings. Run from the
+// listing_screening job.
+func (h *ModerationHandler) ScreenListings(ctx context.Context) error {
+	n, err := h.db.ScreenListings(ctx, time.Now().UTC(), moderationPolicy(h.cfg), h.classifier)
+	if n > 0 {
+		log.Printf("api: listings screened: %d", n)
+	}
+	return err
+}
+
+// httpClassifier posts the image body to MODERATION_CLASSIFIER_URL and reads
+// {"nudity": 0..1} back.
+type httpClassifier struct {
+	url    string
+	client *http.Client
+}
+
+func (c *httpClassifier) NudityScore(ctx context.Context, mime string, data []byte) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", mime)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("classifier: status %d", resp.StatusCode)
+	}
+	var out struct {
+		Nudity float64 `json:"nudity"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	return out.Nudity, nil
+}
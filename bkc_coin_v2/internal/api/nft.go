@@ -0,0 +1,277 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// NFTHandler serves the NFT shop catalog, attributes, rarity, effects, staking,
+// the secondary market, offers, rentals, wishlists and drops.
+type NFTHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+	rates  db.QuoteRates // converts USDT/TON listing prices; nil disables them
+}
+
+func NewNFTHandler(cfg config.Config, d *db.DB, p *params.Service, rates db.QuoteRates) *NFTHandler {
+	return &NFTHandler{cfg: cfg, db: d, params: p, rates: rates}
+}
+
+func (h *NFTHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/nft", h.list)
+	mux.HandleFunc("GET /api/v1/nft/{id}", h.get)
+	mux.HandleFunc("PUT /api/v1/admin/nft/{id}/attributes", h.setAttributes)
+	mux.HandleFunc("POST /api/v1/admin/nft/rarity/recompute", h.recomputeRarity)
+	mux.HandleFunc("PUT /api/v1/admin/nft/{id}/effects", h.setEffects)
+	mux.HandleFunc("GET /api/v1/nft/effects", h.myEffects)
+
+	mux.HandleFunc("POST /api/v1/nft/{id}/stake", h.stake)
+	mux.HandleFunc("GET /api/v1/nft/stakes", h.listStakes)
+	mux.HandleFunc("POST /api/v1/nft/stakes/claim", h.claimStakes)
+	mux.HandleFunc("POST /api/v1/nft/stakes/{id}/claim", h.claimStakes)
+	mux.HandleFunc("POST /api/v1/nft/stakes/{id}/unstake", h.unstake)
+
+	mux.HandleFunc("GET /api/v1/nft/market", h.listListings)
+	mux.HandleFunc("POST /api/v1/nft/market", h.createListing)
+	mux.HandleFunc("POST /api/v1/nft/market/{id}/checkout", h.reserveListing)
+	mux.HandleFunc("DELETE /api/v1/nft/market/{id}/checkout", h.releaseListing)
+	mux.HandleFunc("POST /api/v1/nft/market/{id}/buy", h.buyListing)
+	mux.HandleFunc("POST /api/v1/nft/market/{id}/cancel", h.cancelListing)
+	mux.HandleFunc("GET /api/v1/nft/{id}/history", h.priceHistory)
+	mux.HandleFunc("PUT /api/v1/admin/nft/{id}/royalty", h.setRoyalty)
+
+	mux.HandleFunc("GET /api/v1/nft/offers", h.listOffers)
+	mux.HandleFunc("POST /api/v1/nft/offers", h.createOffer)
+	mux.HandleFunc("POST /api/v1/nft/offers/{id}/accept", h.acceptOffer)
+	mux.HandleFunc("POST /api/v1/nft/offers/{id}/counter", h.counterOffer)
+	mux.HandleFunc("POST /api/v1/nft/offers/{id}/decline", h.declineOffer)
+	mux.HandleFunc("POST /api/v1/nft/offers/{id}/cancel", h.cancelOffer)
+
+	mux.HandleFunc("GET /api/v1/nft/rentals", h.listRentalOffers)
+	mux.HandleFunc("POST /api/v1/nft/rentals", h.createRentalOffer)
+	mux.HandleFunc("POST /api/v1/nft/rentals/{id}/rent", h.rent)
+	mux.HandleFunc("POST /api/v1/nft/rentals/{id}/cancel", h.cancelRentalOffer)
+	mux.HandleFunc("GET /api/v1/nft/rentals/mine", h.myRentals)
+	mux.HandleFunc("GET /api/v1/nft/rentals/earnings", h.rentalEarnings)
+
+	mux.HandleFunc("GET /api/v1/nft/wishlist", h.wishlist)
+	mux.HandleFunc("POST /api/v1/nft/{id}/wish", h.wish)
+	mux.HandleFunc("DELETE /api/v1/nft/{id}/wish", h.unwish)
+	mux.HandleFunc("GET /api/v1/admin/nft/demand", h.demand)
+	mux.HandleFunc("POST /api/v1/admin/nft/{id}/restock", h.restock)
+
+	mux.HandleFunc("GET /api/v1/nft/drops", h.listDrops)
+	mux.HandleFunc("POST /api/v1/nft/drops/{id}/join", h.joinDrop)
+	mux.HandleFunc("GET /api/v1/nft/drops/{id}/orders", h.dropOrders)
+	mux.HandleFunc("POST /api/v1/admin/nft/drops", h.createDrop)
+	mux.HandleFunc("PUT /api/v1/admin/nft/drops/{id}/allowlist", h.setDropAllowlist)
+	mux.HandleFunc("POST /api/v1/admin/nft/drops/{id}/cancel", h.cancelDrop)
+
+	mux.HandleFunc("GET /api/v1/admin/nft/{id}/supply", h.supplyStatus)
+	mux.HandleFunc("PUT /api/v1/admin/nft/{id}/supply-shards", h.shardSupply)
+}
+
+// list supports ?collection=&tier=&sort=rarity|price_asc|price_desc|new&limit=
+// and repeated ?trait=type:value filters.
+func (h *NFTHandler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := db.NFTFilter{
+		Collection: q.Get("collection"),
+		Tier:       q.Get("tier"),
+		Sort:       q.Get("sort"),
+		Limit:      queryInt64(r, "limit", 50),
+	}
+	for _, raw := range q["trait"] {
+		t, v, ok := strings.Cut(raw, ":")
+		if !ok {
+			writeError(w, r, NewInvalidRequestError("trait must be type:value"))
+			return
+		}
+		f.Traits = append(f.Traits, db.NFTAttribute{TraitType: t, Value: v})
+	}
+	items, err := h.db.ListNFTsFiltered(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *NFTHandler) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	n, err := h.db.GetNFT(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+func (h *NFTHandler) setAttributes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Collection string            `json:"collection"`
+		Attributes []db.NFTAttribute `json:"attributes"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetNFTAttributes(r.Context(), id, req.Collection, req.Attributes); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	n, err := h.db.GetNFT(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+func (h *NFTHandler) recomputeRarity(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		Collection string `json:"collection"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.RecomputeNFTRarity(r.Context(), req.Collection); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *NFTHandler) setEffects(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var eff db.NFTEffects
+	if err := decodeJSON(w, r, &eff); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetNFTEffects(r.Context(), id, eff); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	n, err := h.db.GetNFT(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+// myEffects returns the combined effects of every NFT the caller owns.
+func (h *NFTHandler) myEffects(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	eff, err := h.db.ResolveUserNFTEffects(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, eff)
+}
+
+func (h *NFTHandler) stake(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	nftID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Qty int64 `json:"qty"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+	st, err := h.db.StakeNFT(r.Context(), u.ID, nftID, req.Qty, h.params.Int64(r.Context(), db.ParamNFTStakeDailyReward), h.cfg.NFTStakeLockDays)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *NFTHandler) listStakes(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListNFTStakesByUser(r.Context(), u.ID, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// claimStakes claims one stake (/stakes/{id}/claim) or all of them (/stakes/claim).
+func (h *NFTHandler) claimStakes(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var stakeID int64
+	if r.PathValue("id") != "" {
+		if stakeID, ok = pathInt64(w, r, "id"); !ok {
+			return
+		}
+	}
+	paid, err := h.db.ClaimNFTStakeRewards(r.Context(), u.ID, stakeID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"claimed": paid})
+}
+
+func (h *NFTHandler) unstake(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	stakeID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.UnstakeNFT(r.Context(), u.ID, stakeID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
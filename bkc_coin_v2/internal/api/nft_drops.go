@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Drops: scheduled shop NFT sales. Joining queues an order; the nft_drops
+// job fills the queue in arrival order.
+
+func (h *NFTHandler) listDrops(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.ListNFTDrops(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *NFTHandler) joinDrop(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Qty int64 `json:"qty"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+	priceBP := experimentParam(r.Context(), h.db, db.ExperimentDropPrice, u.ID, "price_bp", 10_000)
+	o, err := h.db.JoinNFTDrop(r.Context(), u.ID, id, req.Qty, priceBP)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// dropOrders returns the caller's orders in a drop with queue positions.
+func (h *NFTHandler) dropOrders(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	items, err := h.db.ListNFTDropOrders(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createDrop schedules a drop; allow_plan (e.g. "gold") and the allowlist
+// set with setDropAllowlist limit who may join.
+func (h *NFTHandler) createDrop(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		NFTID      int64      `json:"nft_id"`
+		StartsAt   time.Time  `json:"starts_at"`
+		EndsAt     *time.Time `json:"ends_at"`
+		PerUserCap int64      `json:"per_user_cap"`
+		AllowPlan  string     `json:"allow_plan"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	d, err := h.db.CreateNFTDrop(r.Context(), admin.ID, db.NFTDrop{
+		NFTID:      req.NFTID,
+		StartsAt:   req.StartsAt,
+		EndsAt:     req.EndsAt,
+		PerUserCap: req.PerUserCap,
+		AllowPlan:  req.AllowPlan,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d scheduled drop %d of nft %d at %s", admin.ID, d.DropID, d.NFTID, d.StartsAt.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, d)
+}
+
+// setDropAllowlist replaces the drop's user allowlist; an empty list turns
+// it off.
+func (h *NFTHandler) setDropAllowlist(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		UserIDs []int64 `json:"user_ids"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetNFTDropAllowlist(r.Context(), id, req.UserIDs); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "count": len(req.UserIDs)})
+}
+
+func (h *NFTHandler) cancelDrop(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelNFTDrop(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d cancelled drop %d", admin.ID, id)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ProcessDrops fills queued drop orders. Run from the nft_drops job.
+func (h *NFTHandler) ProcessDrops(ctx context.Context) error {
+	n, err := h.db.ProcessNFTDrops(ctx, time.Time{})
+	if n > 0 {
+		log.Printf("api: nft drop orders filled: %d", n)
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Secondary market: users resell owned NFTs to each other.
+
+func (h *NFTHandler) listListings(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.ListNFTListings(r.Context(), queryInt64(r, "nft_id", 0), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createListing accepts either price_coins, or currency=USDT|TON with quote_price
+// in 1e-6 units (1.5 USDT = 1500000), converted to BKC when bought. The
+// response compares the price with recent sales of the NFT.
+func (h *NFTHandler) createListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		NFTID      int64  `json:"nft_id"`
+		Qty        int64  `json:"qty"`
+		PriceCoins int64  `json:"price_coins"`
+		Currency   string `json:"currency"`
+		QuotePrice int64  `json:"quote_price"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+	var l db.NFTListing
+	var err error
+	if req.Currency == "" || strings.EqualFold(req.Currency, db.QuoteBKC) {
+		l, err = h.db.CreateNFTListing(r.Context(), u.ID, req.NFTID, req.Qty, req.PriceCoins)
+	} else {
+		l, err = h.db.CreateQuotedNFTListing(r.Context(), u.ID, req.NFTID, req.Qty, req.Currency, req.QuotePrice, h.rates)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		db.NFTListing
+		Market *db.PriceSuggestion `json:"market,omitempty"` // above_market flags an overpriced listing
+	}{l, marketCheck(r.Context(), h.db, h.cfg, db.PriceKindNFT, strconv.FormatInt(l.NFTID, 10), l.PriceCoins)})
+}
+
+// reserveListing starts checkout on a single-copy listing: it is held for
+// this buyer for a couple of minutes, or 409 if another buyer holds it.
+func (h *NFTHandler) reserveListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	until, err := h.db.ReserveNFTListing(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"listing_id": id, "reserved_until": until})
+}
+
+func (h *NFTHandler) releaseListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.ReleaseNFTListing(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *NFTHandler) buyListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Qty int64 `json:"qty"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+	sale, err := h.db.BuyNFTListing(r.Context(), u.ID, id, req.Qty, h.params.Int64(r.Context(), db.ParamNFTMarketFeeBP), h.rates)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sale)
+}
+
+func (h *NFTHandler) cancelListing(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelNFTListing(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *NFTHandler) priceHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	items, err := h.db.ListNFTSales(r.Context(), id, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *NFTHandler) setRoyalty(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		CreatorID int64 `json:"creator_id"`
+		RoyaltyBP int64 `json:"royalty_bp"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.RoyaltyBP > db.MaxNFTRoyaltyBP {
+		writeError(w, r, NewInvalidRequestError("royalty_bp is too high"))
+		return
+	}
+	if err := h.db.SetNFTRoyalty(r.Context(), id, req.CreatorID, req.RoyaltyBP); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
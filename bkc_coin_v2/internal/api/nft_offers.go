@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Offers: buyers lock funds behind a price; sellers accept, counter or decline.
+
+func (h *NFTHandler) listOffers(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	incoming := r.URL.Query().Get("role") == "incoming"
+	items, err := h.db.ListNFTOffers(r.Context(), u.ID, incoming, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *NFTHandler) createOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		NFTID          int64 `json:"nft_id"`
+		ListingID      int64 `json:"listing_id"`
+		Qty            int64 `json:"qty"`
+		PriceCoins     int64 `json:"price_coins"`
+		ExpiresInHours int64 `json:"expires_in_hours"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+	if req.ExpiresInHours <= 0 {
+		req.ExpiresInHours = 24
+	}
+	o, err := h.db.CreateNFTOffer(r.Context(), u.ID, req.NFTID, req.ListingID, req.Qty, req.PriceCoins, time.Duration(req.ExpiresInHours)*time.Hour)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *NFTHandler) acceptOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	sale, err := h.db.AcceptNFTOffer(r.Context(), u.ID, id, h.params.Int64(r.Context(), db.ParamNFTMarketFeeBP))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sale)
+}
+
+func (h *NFTHandler) counterOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		PriceCoins int64 `json:"price_coins"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o, err := h.db.CounterNFTOffer(r.Context(), u.ID, id, req.PriceCoins)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *NFTHandler) declineOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.DeclineNFTOffer(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *NFTHandler) cancelOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelNFTOffer(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
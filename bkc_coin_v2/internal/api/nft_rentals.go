@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Rentals: owners rent out the effects of an NFT for a number of days.
+
+func (h *NFTHandler) listRentalOffers(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.ListNFTRentalOffers(r.Context(), queryInt64(r, "nft_id", 0), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createRentalOffer puts one copy up for rent; max_days defaults to
+// NFT_RENTAL_MAX_DAYS.
+func (h *NFTHandler) createRentalOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		NFTID     int64 `json:"nft_id"`
+		FeePerDay int64 `json:"fee_per_day"`
+		MinDays   int64 `json:"min_days"`
+		MaxDays   int64 `json:"max_days"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o, err := h.db.CreateNFTRentalOffer(r.Context(), u.ID, req.NFTID, req.FeePerDay, req.MinDays, req.MaxDays, h.params.Int64(r.Context(), db.ParamNFTRentalMaxDays))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *NFTHandler) rent(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Days int64 `json:"days"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rental, err := h.db.RentNFT(r.Context(), u.ID, id, req.Days, h.params.Int64(r.Context(), db.ParamNFTRentalFeeBP))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rental)
+}
+
+func (h *NFTHandler) cancelRentalOffer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelNFTRentalOffer(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// myRentals lists the user's rentals as renter and as owner.
+func (h *NFTHandler) myRentals(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListNFTRentals(r.Context(), u.ID, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// rentalEarnings reports the owner's rental income over ?days= (default 30).
+func (h *NFTHandler) rentalEarnings(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	days := queryInt64(r, "days", 30)
+	if days <= 0 || days > 366 {
+		days = 30
+	}
+	rep, err := h.db.NFTRentalEarnings(r.Context(), u.ID, time.Now().UTC().AddDate(0, 0, -int(days)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// ExpireRentals books the return of ended rentals. Run from the nft_rentals
+// job.
+func (h *NFTHandler) ExpireRentals(ctx context.Context) error {
+	n, err := h.db.ExpireNFTRentals(ctx, time.Now().UTC())
+	if n > 0 {
+		log.Printf("api: nft rentals returned: %d", n)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Sharded supply: admins shard the stock of NFTs expected to sell out in a
+// rush so buyers don't queue on one row.
+
+func (h *NFTHandler) supplyStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	st, err := h.db.GetNFTSupplyStatus(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *NFTHandler) shardSupply(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Shards int64 `json:"shards"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ShardNFTSupply(r.Context(), id, req.Shards); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d set nft %d supply shards to %d", admin.ID, id, req.Shards)
+	st, err := h.db.GetNFTSupplyStatus(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *NFTHandler) SyncSupply(ctx context.Context) error {
+	_, err := h.db.SyncNFTSupply(ctx)
+	return err
+}
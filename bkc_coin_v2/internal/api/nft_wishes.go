@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Wishlist: users wish for shop NFTs; admins see the demand and restock.
+
+func (h *NFTHandler) wishlist(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListNFTWishes(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *NFTHandler) wish(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.AddNFTWish(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *NFTHandler) unwish(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RemoveNFTWish(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// demand ranks wished-for NFTs by wishes per copy left; new_wishes counts
+// the last ?days= (default 7).
+func (h *NFTHandler) demand(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	days := queryInt64(r, "days", 7)
+	if days <= 0 || days > 366 {
+		days = 7
+	}
+	items, err := h.db.NFTDemandReport(r.Context(), time.Now().UTC().AddDate(0, 0, -int(days)), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// restock adds copies to a shop NFT; wishers are notified by the nft_wishes job.
+func (h *NFTHandler) restock(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Qty int64 `json:"qty"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	left, err := h.db.RestockNFT(r.Context(), admin.ID, id, req.Qty)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d restocked nft %d by %d", admin.ID, id, req.Qty)
+	writeJSON(w, http.StatusOK, map[string]any{"nft_id": id, "supply_left": left})
+}
+
+// NotifyWishes tells wishers about restocks and new drops. Run from the
+// nft_wishes job.
+func (h *NFTHandler) NotifyWishes(ctx context.Context) error {
+	n, err := h.db.NotifyNFTWishes(ctx)
+	if n > 0 {
+		log.Printf("api: nft wish notifications: %d", n)
+	}
+	return err
+}
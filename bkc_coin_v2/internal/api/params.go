@@ -0,0 +1,83 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// ParamsHandler lets admins tune fees and limits (db.ParamDefs) at runtime
+// instead of redeploying with a new config.
+type ParamsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewParamsHandler(cfg config.Config, d *db.DB, p *params.Service) *ParamsHandler {
+	return &ParamsHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *ParamsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/params", h.list)
+	mux.HandleFunc("PUT /api/v1/admin/params/{key}", h.set)
+	mux.HandleFunc("DELETE /api/v1/admin/params/{key}", h.reset)
+}
+
+func (h *ParamsHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	list, err := h.params.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"params": list})
+}
+
+// set: {"value":2500}.
+func (h *ParamsHandler) set(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Value *int64 `json:"value"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Value == nil {
+		writeError(w, r, NewInvalidRequestError("value required"))
+		return
+	}
+	p, err := h.db.SetParam(r.Context(), admin.ID, r.PathValue("key"), *req.Value)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.params.Invalidate()
+	log.Printf("api: param %s = %d by %d", p.Key, p.Value, admin.ID)
+	writeJSON(w, http.StatusOK, p)
+}
+
+// reset drops the override: the param goes back to its config default.
+func (h *ParamsHandler) reset(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	if err := h.db.ResetParam(r.Context(), admin.ID, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.params.Invalidate()
+	log.Printf("api: param %s reset by %d", key, admin.ID)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// PatternsHandler serves the admin queue of suspicious ledger patterns.
+type PatternsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewPatternsHandler(cfg config.Config, d *db.DB) *PatternsHandler {
+	return &PatternsHandler{cfg: cfg, db: d}
+}
+
+func (h *PatternsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/patterns", h.list)
+	mux.HandleFunc("POST /api/v1/admin/patterns/{id}/freeze", h.freeze)
+	mux.HandleFunc("POST /api/v1/admin/patterns/{id}/dismiss", h.dismiss)
+}
+
+func (h *PatternsHandler) policy() db.PatternPolicy {
+	return db.PatternPolicy{
+		SmallTransfer: h.cfg.PatternSmallTransfer,
+		FanInMin:      h.cfg.PatternFanInMin,
+	}
+}
+
+func (h *PatternsHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.PatternOpen
+	} else if status == "all" {
+		status = ""
+	}
+	alerts, err := h.db.ListPatternAlerts(r.Context(), status, int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alerts": alerts})
+}
+
+// freeze is the one-click action: every account in the alert is frozen.
+func (h *PatternsHandler) freeze(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	alertID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	frozen, err := h.db.FreezePatternAlert(r.Context(), admin.ID, alertID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: admin %d froze pattern alert %d (%d BKC)", admin.ID, alertID, frozen)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "frozen": frozen})
+}
+
+func (h *PatternsHandler) dismiss(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	alertID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.DismissPatternAlert(r.Context(), admin.ID, alertID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ScanLedger mines yesterday's (UTC) transfers for suspicious patterns; each
+// day is scanned once. Run from the ledger_patterns job.
+func (h *PatternsHandler) ScanLedger(ctx context.Context) error {
+	day := time.Now().UTC().AddDate(0, 0, -1)
+	n, err := h.db.ScanLedgerPatterns(ctx, day, h.policy())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("api: ledger patterns %s: %d alerts", day.Format(time.DateOnly), n)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// ProfileHandler serves the user's profile with level and XP progression.
+type ProfileHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewProfileHandler(cfg config.Config, d *db.DB, p *params.Service) *ProfileHandler {
+	return &ProfileHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *ProfileHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/profile", h.profile)
+	mux.HandleFunc("GET /api/v1/levels", h.levels)
+	mux.HandleFunc("GET /api/v1/leaderboard", h.leaderboard)
+}
+
+func (h *ProfileHandler) policy(ctx context.Context) db.LevelPolicy {
+	return db.LevelPolicy{
+		Thresholds:     h.cfg.LevelXPThresholds,
+		RewardPerLevel: h.params.Int64(ctx, db.ParamLevelUpReward),
+		XPPerTap:       h.cfg.XPPerTap,
+		XPPerPurchase:  h.cfg.XPPerPurchase,
+		XPPerGameWin:   h.cfg.XPPerGameWin,
+	}
+}
+
+func (h *ProfileHandler) profile(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	st, err := h.db.GetUser(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	lvl, err := h.db.GetLevel(r.Context(), u.ID, h.policy(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ups, err := h.db.ListLevelUps(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user_id":         st.UserID,
+		"username":        st.Username,
+		"first_name":      st.FirstName,
+		"balance":         st.Balance,
+		"frozen_balance":  st.FrozenBalance,
+		"taps_total":      st.TapsTotal,
+		"referrals_count": st.ReferralsCount,
+		"level":           lvl,
+		"level_ups":       ups,
+	})
+}
+
+// levels is public: the XP thresholds, rewards and XP sources.
+func (h *ProfileHandler) levels(w http.ResponseWriter, r *http.Request) {
+	p := h.policy(r.Context())
+	type row struct {
+		Level  int64 `json:"level"`
+		XP     int64 `json:"xp"`
+		Reward int64 `json:"reward"`
+	}
+	rows := []row{{Level: 1}}
+	for i, xp := range p.Thresholds {
+		l := int64(i) + 2
+		rows = append(rows, row{Level: l, XP: xp, Reward: p.Reward(l)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"levels":          rows,
+		"xp_per_tap":      p.XPPerTap,
+		"xp_per_purchase": p.XPPerPurchase,
+		"xp_per_game_win": p.XPPerGameWin,
+	})
+}
+
+// leaderboard is the top users by XP without users who hid themselves in
+// their settings, plus the caller's own rank.
+func (h *ProfileHandler) leaderboard(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	top, err := h.db.Leaderboard(r.Context(), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rank, err := h.db.LeaderboardRank(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"leaderboard": top, "my_rank": rank})
+}
+
+// SyncLevels converts new activity into XP and pays pending level-up
+// rewards. Run from the levels job.
+func (h *ProfileHandler) SyncLevels(ctx context.Context) error {
+	n, err := h.db.SyncXP(ctx, h.policy(ctx))
+	if err != nil {
+		return err
+	}
+	paid, err := h.db.PayLevelRewards(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 || paid > 0 {
+		log.Printf("api: levels: %d users gained xp, %d rewards paid", n, paid)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// PromotionsHandler serves paid listing promotions and the featured rotation.
+type PromotionsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewPromotionsHandler(cfg config.Config, d *db.DB) *PromotionsHandler {
+	return &PromotionsHandler{cfg: cfg, db: d}
+}
+
+func (h *PromotionsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/promotions/featured", h.featured)
+	mux.HandleFunc("POST /api/v1/promotions/{id}/click", h.click)
+
+	mux.HandleFunc("GET /api/v1/promotions", h.list)
+	mux.HandleFunc("POST /api/v1/promotions", h.create)
+	mux.HandleFunc("POST /api/v1/promotions/{id}/cancel", h.cancel)
+}
+
+// featured returns the current featured slots: ?kind=market|nft&limit=
+// Every returned slot is charged one impression.
+func (h *PromotionsHandler) featured(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.FeaturedListings(r.Context(), r.URL.Query().Get("kind"), queryInt64(r, "limit", 5))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *PromotionsHandler) click(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RecordPromotionClick(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *PromotionsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListMyPromotions(r.Context(), u.ID, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *PromotionsHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind      string `json:"kind"` // market|nft
+		ListingID int64  `json:"listing_id"`
+		Days      int64  `json:"days"`
+		Budget    int64  `json:"budget"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p, err := h.db.CreateListingPromotion(r.Context(), u.ID, req.Kind, req.ListingID, req.Days, req.Budget, h.cfg.PromoCostPerImpression)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *PromotionsHandler) cancel(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelListingPromotion(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
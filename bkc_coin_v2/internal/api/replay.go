@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Headers of a signed webapp request.
+const (
+	RequestChallengeHeader = "X-Request-Challenge" // challenge_id from POST /api/v1/request-challenges
+	RequestNonceHeader     = "X-Request-Nonce"     // client random, unique per challenge
+	RequestSignatureHeader = "X-Request-Signature" // hex HMAC-SHA256, see requestSignature
+)
+
+// replayProtectedRoutes are the money actions that must be signed
+// (path.Match patterns, POST only).
+var replayProtectedRoutes = []string{
+	"/api/v1/wallet/transfer",
+	"/api/v1/withdrawals",
+	"/api/v1/affiliates/payouts",
+	"/api/v1/nft/market/*/buy",
+	"/api/v1/nft/offers",
+	"/api/v1/nft/offers/*/accept",
+	"/api/v1/nft/offers/*/counter",
+	"/api/v1/promotions",
+	"/api/v1/vesting/*/withdraw",
+	"/api/v1/gifts",
+	"/api/v1/tips",
+	"/api/v1/tips/recurring",
+	"/api/v1/bills/*/pay",
+	"/api/v1/gigs/milestones/*/fund",
+	"/api/v1/gigs/milestones/*/release",
+	"/api/v1/pay/*",
+	"/api/v1/game-credits/buy",
+	"/api/v1/game-credits/sell",
+	"/api/v1/market/bundles/*/buy",
+	"/api/v1/nft/rentals/*/rent",
+	"/api/v1/nft/drops/*/join",
+	"/api/v1/p2p/offers/*/take",
+	"/api/v1/p2p/loans/*/insure",
+}
+
+var requestNonceRe = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+func replayProtected(method, p string) bool {
+	if method != http.MethodPost {
+		return false
+	}
+	for _, pattern := range replayProtectedRoutes {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSignature is what the client sends in X-Request-Signature:
+//
+//	hex(HMAC-SHA256(secret, method "\n" request_uri "\n" nonce "\n" hex(sha256(body))))
+func requestSignature(secret []byte, method, uri, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReplayGuard makes webapp money requests single-use: on top of the
+// Telegram auth they carry a signature over the request made with a
+// short-lived server challenge, and a client nonce that is accepted once.
+// Requests authenticated with an API key are not affected.
+type ReplayGuard struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewReplayGuard(cfg config.Config, d *db.DB) *ReplayGuard {
+	return &ReplayGuard{cfg: cfg, db: d}
+}
+
+func (g *ReplayGuard) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/request-challenges", g.createChallenge)
+}
+
+// createChallenge issues a challenge; the client signs any number of money
+// requests with it until it expires, each with a new nonce.
+func (g *ReplayGuard) createChallenge(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, g.cfg)
+	if !ok {
+		return
+	}
+	c, err := g.db.CreateRequestChallenge(r.Context(), u.ID, time.Duration(g.cfg.RequestChallengeTTLSeconds)*time.Second)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (g *ReplayGuard) reject(w http.ResponseWriter, r *http.Request, msg string) {
+	defaultErrorHandler.HandleError(w, r, &APIError{Code: ErrCodeRequestSignature, Message: msg, Timestamp: time.Now()})
+}
+
+// Middleware checks the signature and nonce of protected requests.
+func (g *ReplayGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.cfg.ReplayProtection || !replayProtected(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Key requests arrive without initData (see APIKeyMiddleware); anything
+		// else unauthenticated is rejected by the handler.
+		u, ok := telegram.VerifyWebAppInitData(r.Header.Get(InitDataHeader), g.cfg.BotToken)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		challengeID, err := strconv.ParseInt(r.Header.Get(RequestChallengeHeader), 10, 64)
+		nonce := r.Header.Get(RequestNonceHeader)
+		sig, sigErr := hex.DecodeString(r.Header.Get(RequestSignatureHeader))
+		if err != nil || challengeID <= 0 || !requestNonceRe.MatchString(nonce) || sigErr != nil {
+			g.reject(w, r, "signed request required")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeError(w, r, NewInvalidRequestError("body too large"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		secret, err := g.db.RequestChallengeSecret(r.Context(), u.ID, challengeID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			g.reject(w, r, "challenge expired")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		want, _ := hex.DecodeString(requestSignature(secret, r.Method, r.URL.RequestURI(), nonce, body))
+		if !hmac.Equal(sig, want) {
+			g.reject(w, r, "bad request signature")
+			return
+		}
+		fresh, err := g.db.UseRequestNonce(r.Context(), challengeID, nonce)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !fresh {
+			log.Printf("api: replayed request user=%d %s challenge=%d", u.ID, r.URL.Path, challengeID)
+			g.reject(w, r, "request already used")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PruneChallenges deletes expired challenges. Run from the request_challenges job.
+func (g *ReplayGuard) PruneChallenges(ctx context.Context) error {
+	_, err := g.db.PruneRequestChallenges(ctx)
+	return err
+}
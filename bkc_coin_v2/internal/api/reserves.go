@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/reserves"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ReservesHandler publishes signed proof-of-reserves reports and lets a user
+// fetch the Merkle proof that their balance is included in one.
+type ReservesHandler struct {
+	cfg     config.Config
+	db      *db.DB
+	rates   db.QuoteRates
+	key     ed25519.PrivateKey // nil = reports are not generated
+	sources []reserves.Source
+}
+
+func NewReservesHandler(cfg config.Config, d *db.DB, rates db.QuoteRates) *ReservesHandler {
+	h := &ReservesHandler{cfg: cfg, db: d, rates: rates}
+	if cfg.ReservesSigningKey != "" {
+		key, err := reserves.KeyFromHex(cfg.ReservesSigningKey)
+		if err != nil {
+			panic(err)
+		}
+		h.key = key
+	}
+	if cfg.ReservesTONAddress != "" {
+		h.sources = append(h.sources, reserves.TON{
+			APIURL:  cfg.ReservesTONAPIURL,
+			APIKey:  cfg.ReservesTONAPIKey,
+			Address: cfg.ReservesTONAddress,
+			Jetton:  cfg.ReservesTONUSDTJetton,
+		})
+	}
+	if cfg.ReservesSolanaOwner != "" {
+		h.sources = append(h.sources, reserves.Solana{
+			RPCURL: cfg.ReservesSolanaRPCURL,
+			Owner:  cfg.ReservesSolanaOwner,
+			Mint:   cfg.ReservesSolanaUSDTMint,
+		})
+	}
+	return h
+}
+
+// Enabled reports whether a signing key is configured.
+func (h *ReservesHandler) Enabled() bool { return h.key != nil }
+
+func (h *ReservesHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/reserves", h.latest)
+	mux.HandleFunc("GET /api/v1/reserves/history", h.history)
+	mux.HandleFunc("GET /api/v1/reserves/proof", h.proof)
+	mux.HandleFunc("GET /api/v1/reserves/{id}", h.get)
+}
+
+func (h *ReservesHandler) write(w http.ResponseWriter, r *http.Request, id int64) {
+	rep, err := h.db.GetReserveReport(r.Context(), id)
+	if id == 0 && errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, r, &APIError{Code: ErrCodeServiceUnavailable, Message: "no reserves report yet", Timestamp: time.Now()})
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// latest is the newest report. Anyone can check it: signature is ed25519
+// over the exact bytes of report, made with public_key.
+func (h *ReservesHandler) latest(w http.ResponseWriter, r *http.Request) {
+	h.write(w, r, 0)
+}
+
+func (h *ReservesHandler) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	h.write(w, r, id)
+}
+
+func (h *ReservesHandler) history(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.db.ListReserveReports(r.Context(), int(queryInt64(r, "limit", 30)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, map[string]any{"reports": reports})
+}
+
+// proof returns what the user needs to check their inclusion in a report
+// (report_id, latest by default): their leaf inputs, salt and path to the
+// root. See package reserves for the hashing.
+func (h *ReservesHandler) proof(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	rep, err := h.db.GetReserveReport(r.Context(), queryInt64(r, "report_id", 0))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	idx, coins, secret, err := h.db.ReserveLeafOf(r.Context(), rep.ReportID, u.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, r, NewNotFoundError("no balance in this report, or its proofs have expired"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	siblings, err := h.db.ReserveSiblings(r.Context(), rep.ReportID, idx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	salt := reserves.UserSalt(secret, u.ID)
+	leaf := reserves.Leaf(salt, u.ID, coins)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"report_id":   rep.ReportID,
+		"user_id":     u.ID,
+		"coins":       coins,
+		"salt":        hex.EncodeToString(salt),
+		"index":       idx,
+		"leaf":        hex.EncodeToString(leaf[:]),
+		"path":        reserves.Path(idx, siblings),
+		"merkle_root": rep.MerkleRoot,
+	})
+}
+
+// GenerateReport builds, signs and stores a report once the latest one is
+// RESERVES_INTERVAL_HOURS old. Run from the reserves job.
+func (h *ReservesHandler) GenerateReport(ctx context.Context) error {
+	if h.key == nil {
+		return nil
+	}
+	last, err := h.db.GetReserveReport(ctx, 0)
+	if err == nil && time.Since(last.GeneratedAt) < time.Duration(h.cfg.ReservesIntervalHours)*time.Hour {
+		return nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	rep := reserves.Report{GeneratedAt: time.Now().UTC(), Assets: []reserves.Asset{}}
+	for _, src := range h.sources {
+		assets, err := src.Balances(ctx)
+		if err != nil {
+			return err
+		}
+		for _, a := range assets {
+			rate, err := h.rates.USDPerUnit(ctx, a.Currency)
+			if err != nil {
+				return fmt.Errorf("reserves: %s rate: %w", a.Currency, err)
+			}
+			a.USD = a.Amount * rate
+			rep.Assets = append(rep.Assets, a)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	var leaves []db.ReserveLeaf
+	var hashes []byte
+	totals, err := h.db.ReserveLiabilities(ctx, func(l db.ReserveLeaf) error {
+		leaf := reserves.Leaf(reserves.UserSalt(secret, l.UserID), l.UserID, l.Coins)
+		leaves = append(leaves, l)
+		hashes = append(hashes, leaf[:]...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	levels := reserves.Levels(hashes)
+	rep.Liabilities = reserves.Liabilities{
+		Users:       totals.Users,
+		Coins:       totals.Coins,
+		Frozen:      totals.Frozen,
+		CoinsPerUSD: totals.CoinsPerUSD,
+		MerkleRoot:  hex.EncodeToString(levels[len(levels)-1]),
+	}
+	rep.Fill()
+
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	id, err := h.db.SaveReserveReport(ctx, db.ReserveReport{
+		GeneratedAt: rep.GeneratedAt,
+		Report:      body,
+		Signature:   base64.StdEncoding.EncodeToString(ed25519.Sign(h.key, body)),
+		PublicKey:   hex.EncodeToString(h.key.Public().(ed25519.PublicKey)),
+		MerkleRoot:  rep.Liabilities.MerkleRoot,
+	}, secret, leaves, levels, int(h.cfg.ReservesKeepReports))
+	if err != nil {
+		return err
+	}
+	log.Printf("api: reserves report %d: assets $%.2f, liabilities $%.2f (%d users), ratio %.3f", id, rep.AssetsUSD, rep.Liabilities.USD, rep.Liabilities.Users, rep.Ratio)
+	return nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// InitDataHeader carries Telegram WebApp initData for authenticated requests.
+const InitDataHeader = "X-Telegram-Init-Data"
+
+var defaultErrorHandler = NewErrorHandler(log.Default())
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON reads a JSON request body (max 1MB) into v.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err := dec.Decode(v); err != nil {
+		return NewInvalidRequestError("invalid json body")
+	}
+	return nil
+}
+
+// writeError converts db/domain errors into structured API errors.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *APIError
+	var velocityErr *db.VelocityError
+	var betErr *db.BetLimitError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, pgx.ErrNoRows):
+		apiErr = NewNotFoundError("not found")
+	case errors.Is(err, db.ErrNotEnough):
+		apiErr = NewInsufficientFundsError("not enough")
+	case errors.Is(err, db.ErrForbidden):
+		apiErr = NewForbiddenError("forbidden")
+	case errors.Is(err, db.ErrAlreadyExists):
+		apiErr = &APIError{Code: ErrCodeDuplicateEntry, Message: "already exists", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrLocked):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "locked", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrEmissionCap):
+		apiErr = &APIError{Code: ErrCodeDailyLimit, Message: "daily emission cap reached", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrSaleLimit):
+		apiErr = &APIError{Code: ErrCodeDailyLimit, Message: "daily sale limit reached", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrListingThrottled):
+		apiErr = &APIError{Code: ErrCodeRateLimit, Message: "too many rejected listings, try again later", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrCreditsSellLimit):
+		apiErr = &APIError{Code: ErrCodeDailyLimit, Message: "daily credits sell limit reached", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrGiftUnavailable):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "gift already claimed or expired", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrBillClosed):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "bill closed", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrOrderClosed):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "order closed", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrGigState):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "milestone not in a state for this action", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrDropOnly):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "nft is sold through a drop", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrNotAllowlisted):
+		apiErr = NewForbiddenError("not on the drop allowlist")
+	case errors.Is(err, db.ErrReserved):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "reserved by another buyer", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrLoanOfferClosed):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "loan offer closed", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrLenderExposure):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "the lender's exposure cap is reached", Timestamp: time.Now()}
+	case errors.As(err, &velocityErr):
+		code := ErrCodeDailyLimit
+		if velocityErr.Rule == db.VelocityNewAccount {
+			code = ErrCodeForbidden
+		}
+		apiErr = &APIError{Code: code, Message: "transfer limit reached", Timestamp: time.Now(), Details: map[string]interface{}{
+			"rule":    velocityErr.Rule,
+			"limit":   velocityErr.Limit,
+			"current": velocityErr.Current,
+		}}
+	case errors.As(err, &betErr):
+		apiErr = &APIError{Code: ErrCodeValidationError, Message: "bet above your limit", Timestamp: time.Now(), Details: map[string]interface{}{
+			"rule":    betErr.Rule,
+			"max_bet": betErr.Limit,
+			"amount":  betErr.Amount,
+		}}
+	case errors.Is(err, db.ErrCaptchaRequired):
+		apiErr = &APIError{Code: ErrCodeCaptchaRequired, Message: "solve the captcha to keep playing", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrMergePlanChanged):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "merge plan changed, run the dry run again", Timestamp: time.Now()}
+	case errors.Is(err, db.ErrConfigProfileChanged):
+		apiErr = &APIError{Code: ErrCodeConflict, Message: "config profile plan changed, run the dry run again", Timestamp: time.Now()}
+	case strings.HasPrefix(err.Error(), "bad "):
+		apiErr = NewInvalidRequestError(err.Error())
+	default:
+		apiErr = NewInternalError("internal error")
+		log.Printf("api: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	defaultErrorHandler.HandleError(w, r, apiErr)
+}
+
+// authUser verifies Telegram initData from the request and writes 401 on failure.
+// A request authenticated by APIKeyMiddleware acts as the key's owner.
+func authUser(w http.ResponseWriter, r *http.Request, cfg config.Config) (telegram.AuthUser, bool) {
+	if k, ok := requestAPIKey(r); ok {
+		return telegram.AuthUser{ID: k.UserID}, true
+	}
+	u, ok := telegram.VerifyWebAppInitData(r.Header.Get(InitDataHeader), cfg.BotToken)
+	if !ok {
+		defaultErrorHandler.HandleError(w, r, NewUnauthorizedError("bad init data"))
+		return telegram.AuthUser{}, false
+	}
+	return u, true
+}
+
+// authAdmin is authUser restricted to admins; AdminMiddleware has already
+// checked the route's permission.
+func authAdmin(w http.ResponseWriter, r *http.Request, cfg config.Config) (telegram.AuthUser, bool) {
+	u, ok := authUser(w, r, cfg)
+	if !ok {
+		return telegram.AuthUser{}, false
+	}
+	a, isAdmin := requestAdmin(r)
+	if _, viaKey := requestAPIKey(r); viaKey || !isAdmin || a.UserID != u.ID {
+		defaultErrorHandler.HandleError(w, r, NewForbiddenError("admin only"))
+		return telegram.AuthUser{}, false
+	}
+	return u, true
+}
+
+// pathInt64 parses a positive int64 path value ({name} in the route pattern).
+func pathInt64(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	n, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil || n <= 0 {
+		defaultErrorHandler.HandleError(w, r, NewInvalidRequestError("bad "+name))
+		return 0, false
+	}
+	return n, true
+}
+
+func queryInt64(r *http.Request, name string, def int64) int64 {
+	v := strings.TrimSpace(r.URL.Query().Get(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
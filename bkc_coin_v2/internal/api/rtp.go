@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// rtpBreach is the first window with enough volume whose RTP is out of the
+// configured bounds.
+func rtpBreach(cfg config.Config, windows []db.GameRTP) (db.GameRTP, bool) {
+	for _, r := range windows {
+		if r.Wagered >= cfg.GameRTPMinWagered && (r.RTP < cfg.GameRTPMin || r.RTP > cfg.GameRTPMax) {
+			return r, true
+		}
+	}
+	return db.GameRTP{}, false
+}
+
+// checkGameRTP raises an alert for every game whose realized RTP left the
+// bounds (a payout bug or an exploit) and, with GAME_RTP_AUTO_HALT, turns the
+// game off. The alert of a halted game stays open until an admin turns it
+// back on; its windows then count from that moment. Returns the active kinds.
+func (h *AlertsHandler) checkGameRTP(ctx context.Context) ([]string, error) {
+	switches, err := h.db.ListModuleSwitches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]db.ModuleSwitch{}
+	for _, s := range switches {
+		state[s.Module] = s
+	}
+
+	var active []string
+	now := time.Now()
+	for _, b := range db.GameBooks {
+		kind := db.AlertGameRTP + ":" + b.Game
+		s := state[b.Module]
+		if s.Disabled {
+			if s.UpdatedBy == nil {
+				active = append(active, kind)
+			}
+			continue
+		}
+		var floor time.Time
+		if s.UpdatedAt != nil {
+			floor = *s.UpdatedAt
+		}
+		windows, err := h.db.GameRTPWindows(ctx, b.Game, now, floor)
+		if err != nil {
+			return nil, err
+		}
+		r, bad := rtpBreach(h.cfg, windows)
+		if !bad {
+			continue
+		}
+		active = append(active, kind)
+		msg := fmt.Sprintf("Игра %s: RTP %.1f%% за %d ч (поставлено %d BKC, выплачено %d BKC, ставок %d), допустимо %.0f–%.0f%%.",
+			b.Game, r.RTP*100, r.WindowHours, r.Wagered, r.Paid, r.Bets, h.cfg.GameRTPMin*100, h.cfg.GameRTPMax*100)
+		if h.cfg.GameRTPAutoHalt {
+			if _, err := h.db.SetModuleSwitch(ctx, 0, db.ModuleSwitch{Module: b.Module, Disabled: true}); err != nil {
+				return nil, err
+			}
+			log.Printf("api: game %s halted: rtp=%.4f over %dh wagered=%d", b.Game, r.RTP, r.WindowHours, r.Wagered)
+			msg += " Игра остановлена; включить: PUT /api/v1/admin/switches/" + b.Module
+		}
+		if err := h.raise(ctx, kind, msg); err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+// GameRTPHandler serves the RTP dashboard of the games.
+type GameRTPHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewGameRTPHandler(cfg config.Config, d *db.DB) *GameRTPHandler {
+	return &GameRTPHandler{cfg: cfg, db: d}
+}
+
+func (h *GameRTPHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/games/rtp", h.summary)
+	mux.HandleFunc("GET /api/v1/admin/games/{game}/rtp", h.series)
+}
+
+// summary returns every game's RTP over the rolling windows, its switch and
+// the alert bounds.
+func (h *GameRTPHandler) summary(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	switches, err := h.db.ListModuleSwitches(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	disabled := map[string]bool{}
+	for _, s := range switches {
+		disabled[s.Module] = s.Disabled
+	}
+	now := time.Now()
+	games := []map[string]any{}
+	for _, b := range db.GameBooks {
+		windows, err := h.db.GameRTPWindows(r.Context(), b.Game, now, time.Time{})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		_, breach := rtpBreach(h.cfg, windows)
+		games = append(games, map[string]any{
+			"game":     b.Game,
+			"module":   b.Module,
+			"disabled": disabled[b.Module],
+			"breach":   breach,
+			"windows":  windows,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"games": games,
+		"bounds": map[string]any{
+			"min":         h.cfg.GameRTPMin,
+			"max":         h.cfg.GameRTPMax,
+			"min_wagered": h.cfg.GameRTPMinWagered,
+			"auto_halt":   h.cfg.GameRTPAutoHalt,
+		},
+	})
+}
+
+// series is the RTP chart of one game: ?hours= back (default a week) in
+// ?bucket_minutes= buckets (default an hour).
+func (h *GameRTPHandler) series(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	hours := queryInt64(r, "hours", 7*24)
+	bucket := queryInt64(r, "bucket_minutes", 60)
+	if hours < 1 || hours > 90*24 || bucket < 1 || hours*60/bucket > 5_000 {
+		writeError(w, r, NewInvalidRequestError("bad hours or bucket_minutes"))
+		return
+	}
+	game := r.PathValue("game")
+	if _, ok := db.GameBookOf(game); !ok {
+		writeError(w, r, NewNotFoundError("unknown game"))
+		return
+	}
+	points, err := h.db.GameRTPSeries(r.Context(), game, time.Now().Add(-time.Duration(hours)*time.Hour), time.Duration(bucket)*time.Minute)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"game": game, "bucket_minutes": bucket, "points": points})
+}
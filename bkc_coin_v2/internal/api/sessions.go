@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/geo"
+	"bkc_coin_v2/internal/telegram"
+)
+
+// SessionsHandler lets users see their active devices and revoke them.
+type SessionsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewSessionsHandler(cfg config.Config, d *db.DB) *SessionsHandler {
+	return &SessionsHandler{cfg: cfg, db: d}
+}
+
+func (h *SessionsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/sessions", h.list)
+	mux.HandleFunc("POST /api/v1/sessions/{id}/revoke", h.revoke)
+	mux.HandleFunc("POST /api/v1/sessions/revoke-all", h.revokeAll)
+}
+
+// sessionKey identifies the session of a request: the hash of its initData.
+func sessionKey(r *http.Request) string {
+	initData := r.Header.Get(InitDataHeader)
+	if initData == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(initData))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionMiddleware records every authenticated request against its session
+// and rejects requests from revoked sessions before they reach a handler.
+// The location of each new session is remembered for withdrawal risk checks.
+// Requests without valid initData pass through; handlers still authenticate them.
+func SessionMiddleware(cfg config.Config, d *db.DB, g geo.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := telegram.VerifyWebAppInitData(r.Header.Get(InitDataHeader), cfg.BotToken)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ip := getClientIP(r)
+			live, created, err := d.TouchSession(r.Context(), u.ID, sessionKey(r), ip, r.UserAgent(), u.AuthDate)
+			if err != nil {
+				// Do not lock users out when the sessions table is unavailable.
+				log.Printf("api: session touch user=%d: %v", u.ID, err)
+			} else if !live {
+				defaultErrorHandler.HandleError(w, r, NewUnauthorizedError("session revoked"))
+				return
+			}
+			if created && g != nil {
+				go recordLoginGeo(d, g, u.ID, ip)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func recordLoginGeo(d *db.DB, g geo.Resolver, userID int64, ip string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := g.Lookup(ctx, ip)
+	if err != nil {
+		log.Printf("api: geo lookup user=%d: %v", userID, err)
+		return
+	}
+	if err := d.RecordLoginGeo(ctx, userID, info.Country, info.ASN); err != nil {
+		log.Printf("api: record login geo user=%d: %v", userID, err)
+	}
+}
+
+func (h *SessionsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListSessions(r.Context(), u.ID, sessionKey(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *SessionsHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RevokeSession(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// revokeAll logs out every other device; the calling session stays signed in.
+func (h *SessionsHandler) revokeAll(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	n, err := h.db.RevokeAllSessions(r.Context(), u.ID, sessionKey(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": n})
+}
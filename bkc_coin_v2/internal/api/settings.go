@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SettingsHandler serves the user's preferences: language override,
+// notification channels, leaderboard visibility, default payment chain and
+// the periodic Telegram digest.
+type SettingsHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewSettingsHandler(cfg config.Config, d *db.DB) *SettingsHandler {
+	return &SettingsHandler{cfg: cfg, db: d}
+}
+
+func (h *SettingsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/settings", h.get)
+	mux.HandleFunc("PATCH /api/v1/settings", h.update)
+	mux.HandleFunc("GET /api/v1/settings/digest", h.getDigest)
+	mux.HandleFunc("PUT /api/v1/settings/digest", h.setDigest)
+	mux.HandleFunc("DELETE /api/v1/settings/digest", h.deleteDigest)
+}
+
+// settingsOptions lists the accepted values so clients can build the settings form.
+func settingsOptions() map[string]any {
+	return map[string]any{
+		"languages":       templates.Languages,
+		"notify_channels": db.NotifyChannels,
+		"notifications":   templates.Keys,
+		"payment_chains":  db.PaymentChains,
+		"digest_sections": db.DigestSections,
+	}
+}
+
+func (h *SettingsHandler) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	s, err := h.db.GetUserSettings(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": s, "options": settingsOptions()})
+}
+
+// update changes only the fields present in the body; "" resets language
+// and default_chain.
+func (h *SettingsHandler) update(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.SettingsUpdate
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s, err := h.db.UpdateUserSettings(r.Context(), u.ID, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": s})
+}
+
+// getDigest returns subscribed=false, not 404, for a user without a digest.
+func (h *SettingsHandler) getDigest(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	s, err := h.db.GetDigestSubscription(r.Context(), u.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeJSON(w, http.StatusOK, map[string]any{"subscribed": false})
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"subscribed": true, "digest": s})
+}
+
+// setDigest subscribes or reschedules: sections, period_days (1 daily, 7
+// weekly) and hour_utc.
+func (h *SettingsHandler) setDigest(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Sections   []string `json:"sections"`
+		PeriodDays int64    `json:"period_days"`
+		HourUTC    *int64   `json:"hour_utc"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s := db.DigestSubscription{UserID: u.ID, Sections: req.Sections, PeriodDays: req.PeriodDays, HourUTC: 9}
+	if s.PeriodDays == 0 {
+		s.PeriodDays = 1
+	}
+	if req.HourUTC != nil {
+		s.HourUTC = *req.HourUTC
+	}
+	s, err := h.db.SetDigestSubscription(r.Context(), s)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"subscribed": true, "digest": s})
+}
+
+func (h *SettingsHandler) deleteDigest(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	if _, err := h.db.DeleteDigestSubscription(r.Context(), u.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"subscribed": false})
+}
+
+// QueueDigests queues the digests that are due; the notifications job sends
+// them. Run from the digests job.
+func (h *SettingsHandler) QueueDigests(ctx context.Context) error {
+	n, err := h.db.QueueDigests(ctx, time.Now())
+	if n > 0 {
+		log.Printf("api: digests: %d queued", n)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StatsHandler serves public token stats for trackers and the website. The
+// figures come from the snapshot refreshed by the public_stats job, are
+// cacheable for PUBLIC_STATS_MAX_AGE and rate-limited per client IP.
+type StatsHandler struct {
+	cfg     config.Config
+	db      *db.DB
+	limiter *windowLimiter[string]
+}
+
+func NewStatsHandler(cfg config.Config, d *db.DB) *StatsHandler {
+	return &StatsHandler{cfg: cfg, db: d, limiter: newWindowLimiter[string]()}
+}
+
+func (h *StatsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/public/stats", h.stats)
+	mux.HandleFunc("GET /api/v1/public/stats/{metric}", h.metric)
+}
+
+// load applies the rate limit and cache headers and returns the snapshot;
+// false means the response was already written.
+func (h *StatsHandler) load(w http.ResponseWriter, r *http.Request) (db.PublicStats, bool) {
+	if ok, retry := h.limiter.allow(getClientIP(r), h.cfg.PublicStatsRatePerMin, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, r, NewRateLimitError(retry))
+		return db.PublicStats{}, false
+	}
+	s, err := h.db.GetPublicStats(r.Context())
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, r, &APIError{Code: ErrCodeServiceUnavailable, Message: "stats not generated yet", Timestamp: time.Now()})
+		return s, false
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return s, false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d", h.cfg.PublicStatsMaxAge, h.cfg.PublicStatsMaxAge))
+	w.Header().Set("Last-Modified", s.GeneratedAt.UTC().Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !s.GeneratedAt.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return s, false
+	}
+	return s, true
+}
+
+func (h *StatsHandler) stats(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// metric returns one figure as a plain number, the format token trackers
+// poll for supply endpoints.
+func (h *StatsHandler) metric(w http.ResponseWriter, r *http.Request) {
+	var pick func(db.PublicStats) int64
+	switch r.PathValue("metric") {
+	case "total_supply":
+		pick = func(s db.PublicStats) int64 { return s.TotalSupply }
+	case "circulating_supply":
+		pick = func(s db.PublicStats) int64 { return s.CirculatingSupply }
+	case "burned":
+		pick = func(s db.PublicStats) int64 { return s.Burned }
+	case "holders":
+		pick = func(s db.PublicStats) int64 { return s.Holders }
+	case "volume_24h":
+		pick = func(s db.PublicStats) int64 { return s.Volume24h }
+	default:
+		writeError(w, r, NewNotFoundError("unknown metric"))
+		return
+	}
+	s, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(strconv.FormatInt(pick(s), 10)))
+}
+
+// RefreshStats recomputes the public snapshot. Run from the public_stats job.
+func (h *StatsHandler) RefreshStats(ctx context.Context) error {
+	_, err := h.db.RefreshPublicStats(ctx)
+	return err
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// StorefrontHandler serves seller storefronts, vanity handles, seller reviews
+// and handle moderation.
+type StorefrontHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewStorefrontHandler(cfg config.Config, d *db.DB) *StorefrontHandler {
+	return &StorefrontHandler{cfg: cfg, db: d}
+}
+
+func (h *StorefrontHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/stores/{handle}", h.getByHandle)
+	mux.HandleFunc("GET /api/v1/sellers/{id}/store", h.getBySeller)
+	mux.HandleFunc("PUT /api/v1/store", h.save)
+	mux.HandleFunc("POST /api/v1/store/reviews", h.review)
+
+	mux.HandleFunc("GET /api/v1/admin/stores/pending", h.pending)
+	mux.HandleFunc("POST /api/v1/admin/stores/{id}/moderate", h.moderate)
+	mux.HandleFunc("PUT /api/v1/admin/stores/{id}/badges", h.setBadges)
+	mux.HandleFunc("POST /api/v1/admin/handles/reserved", h.reserveHandle)
+	mux.HandleFunc("DELETE /api/v1/admin/handles/reserved/{handle}", h.releaseHandle)
+}
+
+func (h *StorefrontHandler) getByHandle(w http.ResponseWriter, r *http.Request) {
+	sf, err := h.db.GetStorefrontByHandle(r.Context(), r.PathValue("handle"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sf)
+}
+
+func (h *StorefrontHandler) getBySeller(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	sf, err := h.db.GetStorefront(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sf)
+}
+
+// save creates or updates the caller's storefront; a new handle awaits moderation.
+func (h *StorefrontHandler) save(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sf, err := h.db.SaveStorefront(r.Context(), u.ID, req.Handle, req.DisplayName, req.Bio)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sf)
+}
+
+func (h *StorefrontHandler) review(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind    string `json:"kind"` // market|nft
+		RefID   int64  `json:"ref_id"`
+		Stars   int64  `json:"stars"`
+		Comment string `json:"comment"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rv, err := h.db.ReviewSeller(r.Context(), u.ID, req.Kind, req.RefID, req.Stars, req.Comment)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+func (h *StorefrontHandler) pending(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListStorefrontsForModeration(r.Context(), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *StorefrontHandler) moderate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Approve bool   `json:"approve"`
+		Reason  string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ModerateStorefrontHandle(r.Context(), id, req.Approve, req.Reason); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *StorefrontHandler) setBadges(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Verified bool `json:"verified"`
+		Premium  bool `json:"premium"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetStorefrontBadges(r.Context(), id, req.Verified, req.Premium); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *StorefrontHandler) reserveHandle(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		Handle string `json:"handle"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ReserveHandle(r.Context(), req.Handle, req.Reason); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *StorefrontHandler) releaseHandle(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	if err := h.db.ReleaseHandle(r.Context(), r.PathValue("handle")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/templates"
+)
+
+// moduleRoutes are the user routes each module switch closes. Maintenance
+// closes every /api/v1/ route except admin, public and status ones.
+var moduleRoutes = map[string][]string{
+	db.ModuleGames:       {"/api/v1/games"},
+	db.ModuleGameCrash:   {"/api/v1/games/crash"},
+	db.ModuleWithdrawals: {"/api/v1/withdrawals"},
+	db.ModuleMarketplace: {"/api/v1/nft/market", "/api/v1/nft/offers", "/api/v1/nft/rentals", "/api/v1/stores", "/api/v1/store", "/api/v1/sellers", "/api/v1/promotions", "/api/v1/market"},
+}
+
+var maintenanceExempt = []string{"/api/v1/admin/", "/api/v1/public/", "/api/v1/status"}
+
+// defaultSwitchMessages are shown when the admin gave no text.
+var defaultSwitchMessages = map[string]string{
+	"ru": "Раздел временно недоступен, идут технические работы. Попробуйте позже.",
+	"en": "This section is temporarily unavailable for maintenance. Please try again later.",
+}
+
+// switchesTTL is how stale the switches of one instance may be.
+const switchesTTL = 5 * time.Second
+
+// SwitchesHandler turns modules off at runtime: a localized 503 with the
+// ETA instead of the module's routes, toggled from the admin API or forced by
+// DISABLED_MODULES.
+type SwitchesHandler struct {
+	cfg config.Config
+	db  *db.DB
+
+	mu       sync.Mutex
+	switches map[string]db.ModuleSwitch // disabled modules; nil = not loaded
+	loadedAt time.Time
+	loading  bool
+}
+
+func NewSwitchesHandler(cfg config.Config, d *db.DB) *SwitchesHandler {
+	for _, m := range cfg.DisabledModules {
+		if !slices.Contains(db.Modules, m) {
+			panic("DISABLED_MODULES: unknown module " + m)
+		}
+	}
+	return &SwitchesHandler{cfg: cfg, db: d}
+}
+
+func (h *SwitchesHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/status", h.status)
+	mux.HandleFunc("GET /api/v1/admin/switches", h.list)
+	mux.HandleFunc("PUT /api/v1/admin/switches/{module}", h.set)
+}
+
+// current returns the disabled modules. One request at a time reloads them
+// while the others use the last known state; a failed reload keeps it too,
+// so an incident in the database does not reopen modules.
+func (h *SwitchesHandler) current(r *http.Request) map[string]db.ModuleSwitch {
+	h.mu.Lock()
+	cached := h.switches
+	if cached != nil && (h.loading || time.Since(h.loadedAt) < switchesTTL) {
+		h.mu.Unlock()
+		return cached
+	}
+	h.loading = true
+	h.mu.Unlock()
+
+	all, err := h.db.ListModuleSwitches(r.Context())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loading = false
+	h.loadedAt = time.Now()
+	if err != nil {
+		log.Printf("api: module switches: %v", err)
+		if h.switches == nil {
+			h.switches = h.forced(nil)
+		}
+		return h.switches
+	}
+	h.switches = h.forced(all)
+	return h.switches
+}
+
+// forced adds the modules DISABLED_MODULES keeps off to the disabled ones.
+func (h *SwitchesHandler) forced(all []db.ModuleSwitch) map[string]db.ModuleSwitch {
+	out := map[string]db.ModuleSwitch{}
+	for _, s := range all {
+		if s.Disabled {
+			out[s.Module] = s
+		}
+	}
+	for _, m := range h.cfg.DisabledModules {
+		if _, ok := out[m]; !ok {
+			out[m] = db.ModuleSwitch{Module: m, Disabled: true}
+		}
+	}
+	return out
+}
+
+// moduleOf is the disabled module closing path, if any.
+func moduleOf(disabled map[string]db.ModuleSwitch, path string) (db.ModuleSwitch, bool) {
+	if !strings.HasPrefix(path, "/api/v1/") {
+		return db.ModuleSwitch{}, false
+	}
+	if s, ok := disabled[db.ModuleMaintenance]; ok && !slices.ContainsFunc(maintenanceExempt, func(p string) bool { return strings.HasPrefix(path, p) }) {
+		return s, true
+	}
+	for m, prefixes := range moduleRoutes {
+		s, ok := disabled[m]
+		if !ok {
+			continue
+		}
+		for _, p := range prefixes {
+			if path == p || strings.HasPrefix(path, p+"/") {
+				return s, true
+			}
+		}
+	}
+	return db.ModuleSwitch{}, false
+}
+
+// switchMessage picks the message in lang, then the default language, then
+// the built-in text.
+func switchMessage(s db.ModuleSwitch, lang string) string {
+	for _, l := range []string{lang, templates.DefaultLang} {
+		if m := s.Messages[l]; m != "" {
+			return m
+		}
+	}
+	if m := defaultSwitchMessages[lang]; m != "" {
+		return m
+	}
+	return defaultSwitchMessages[templates.DefaultLang]
+}
+
+// Middleware answers requests to disabled modules with 503.
+func (h *SwitchesHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, off := moduleOf(h.current(r), r.URL.Path)
+		if !off {
+			next.ServeHTTP(w, r)
+			return
+		}
+		details := map[string]interface{}{"module": s.Module}
+		if s.ETA != nil {
+			details["eta"] = s.ETA.UTC()
+			if wait := time.Until(*s.ETA); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			}
+		}
+		lang := templates.NormalizeLang(r.Header.Get("Accept-Language"))
+		defaultErrorHandler.HandleError(w, r, &APIError{Code: ErrCodeMaintenance, Message: switchMessage(s, lang), Details: details, Timestamp: time.Now()})
+	})
+}
+
+// status lets clients show a banner before the user hits a closed module.
+func (h *SwitchesHandler) status(w http.ResponseWriter, r *http.Request) {
+	lang := templates.NormalizeLang(r.URL.Query().Get("lang"))
+	current := h.current(r)
+	disabled := []map[string]any{}
+	for _, m := range db.Modules {
+		s, ok := current[m]
+		if !ok {
+			continue
+		}
+		disabled = append(disabled, map[string]any{"module": m, "message": switchMessage(s, lang), "eta": s.ETA})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"disabled": disabled})
+}
+
+func (h *SwitchesHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	all, err := h.db.ListModuleSwitches(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"switches": all, "forced": h.cfg.DisabledModules})
+}
+
+func (h *SwitchesHandler) set(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Disabled bool              `json:"disabled"`
+		Messages map[string]string `json:"messages"`
+		ETA      *time.Time        `json:"eta"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s, err := h.db.SetModuleSwitch(r.Context(), admin.ID, db.ModuleSwitch{
+		Module:   r.PathValue("module"),
+		Disabled: req.Disabled,
+		Messages: req.Messages,
+		ETA:      req.ETA,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.mu.Lock()
+	h.switches = nil
+	h.mu.Unlock()
+	log.Printf("api: module %s disabled=%v by %d", s.Module, s.Disabled, admin.ID)
+	writeJSON(w, http.StatusOK, s)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/admission"
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/fasttap"
+	"bkc_coin_v2/internal/memtap"
+)
+
+// TapHandler serves the tap hot path through memtap (in-process aggregation)
+// or fasttap (Redis + stream worker), guarded by the admission controller.
+type TapHandler struct {
+	cfg   config.Config
+	db    *db.DB
+	mem   *memtap.Engine
+	fast  *fasttap.Engine
+	admit *admission.Controller
+}
+
+func NewTapHandler(cfg config.Config, d *db.DB, mem *memtap.Engine, fast *fasttap.Engine, admit *admission.Controller) *TapHandler {
+	return &TapHandler{cfg: cfg, db: d, mem: mem, fast: fast, admit: admit}
+}
+
+func (h *TapHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/tap/process", h.process)
+	mux.HandleFunc("GET /api/v1/admin/tap/stats", h.adminStats)
+	mux.HandleFunc("POST /api/v1/admin/users/{id}/tap-plan", h.adminSetPlan)
+}
+
+type tapResponse struct {
+	Accepted       bool   `json:"accepted"`
+	Gained         int64  `json:"gained"`
+	Reason         string `json:"reason,omitempty"`
+	Energy         int64  `json:"energy"`
+	EnergyMax      int64  `json:"energy_max"`
+	DailyLimit     int64  `json:"daily_limit"` // 0 = unlimited
+	DailyTapped    int64  `json:"daily_tapped"`
+	DailyExtra     int64  `json:"daily_extra"`
+	DailyRemaining int64  `json:"daily_remaining"`
+	// BatchMs asks the client to accumulate taps at least this long before the
+	// next request (set while the pipeline is degraded).
+	BatchMs int64 `json:"batch_ms,omitempty"`
+	// RetryAfterMs is set when the request was shed; the client keeps its taps
+	// and resubmits them after this delay.
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+func (h *TapHandler) process(w http.ResponseWriter, r *http.Request) {
+	// Admission runs first: under a tap storm even auth and JSON decoding are
+	// work we would rather not do.
+	dec := h.admit.Admit()
+	if !dec.Admit {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((dec.RetryAfter+time.Second-1)/time.Second), 10))
+		writeJSON(w, http.StatusAccepted, tapResponse{Reason: "overloaded", RetryAfterMs: dec.RetryAfter.Milliseconds()})
+		return
+	}
+
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Taps int64 `json:"taps"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	var res tapResponse
+	switch {
+	case h.mem.Enabled():
+		out, err := h.mem.Tap(r.Context(), u.ID, u.Username, u.FirstName, req.Taps, now)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		res = tapResponse{Gained: out.Gained, Reason: out.Reason, Energy: out.Energy, EnergyMax: out.EnergyMax, DailyLimit: out.DailyLimit,
+			DailyTapped: out.DailyTapped, DailyExtra: out.DailyExtra, DailyRemaining: out.DailyRemaining}
+	case h.fast.Enabled():
+		if err := h.fast.EnsureUserCached(r.Context(), u.ID, u.Username, u.FirstName, now); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		out, err := h.fast.Tap(r.Context(), u.ID, req.Taps, now)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		res = tapResponse{Gained: out.Gained, Reason: out.Reason, Energy: out.Energy, EnergyMax: out.EnergyMax, DailyLimit: out.DailyLimit,
+			DailyTapped: out.DailyTapped, DailyExtra: out.DailyExtra, DailyRemaining: out.DailyRemaining}
+	default:
+		writeError(w, r, &APIError{Code: ErrCodeServiceUnavailable, Message: "tap engine disabled", Timestamp: now})
+		return
+	}
+	res.Accepted = true
+	res.BatchMs = dec.BatchHint.Milliseconds()
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *TapHandler) adminStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	out := map[string]any{
+		"admission": h.admit.Stats(),
+		"memtap":    h.mem.Stats(),
+	}
+	if h.fast.Enabled() {
+		out["fasttap"] = h.fast.QueueStats(r.Context())
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// adminSetPlan assigns a plan-based daily tap limit (empty plan removes it).
+func (h *TapHandler) adminSetPlan(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Plan          string     `json:"plan"`
+		DailyTapLimit int64      `json:"daily_tap_limit"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	plan, err := h.db.SetUserPlan(r.Context(), admin.ID, userID, req.Plan, req.DailyTapLimit, req.ExpiresAt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Refresh cached engines so the new limit applies to the next tap.
+	limit, err := h.db.GetUserTapLimit(r.Context(), userID, h.cfg.TapDailyLimit, time.Now().UTC())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.mem.SetDailyLimit(userID, limit)
+	if err := h.fast.SetDailyLimit(r.Context(), userID, limit); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"plan": plan, "effective": limit})
+}
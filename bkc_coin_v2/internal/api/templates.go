@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/templates"
+)
+
+// UserNotifier delivers a rendered notification to a user.
+type UserNotifier interface {
+	SendNotification(ctx context.Context, userID int64, subject, body string) error
+}
+
+// TemplatesHandler manages localized notification templates and sends the
+// notifications queued from the ledger.
+type TemplatesHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	notify UserNotifier
+}
+
+func NewTemplatesHandler(cfg config.Config, d *db.DB, notify UserNotifier) *TemplatesHandler {
+	return &TemplatesHandler{cfg: cfg, db: d, notify: notify}
+}
+
+func (h *TemplatesHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/admin/templates", h.list)
+	mux.HandleFunc("GET /api/v1/admin/templates/{key}/{lang}/versions", h.versions)
+	mux.HandleFunc("PUT /api/v1/admin/templates/{key}/{lang}", h.save)
+	mux.HandleFunc("POST /api/v1/admin/templates/{key}/{lang}/preview", h.preview)
+	mux.HandleFunc("GET /api/v1/admin/notifications", h.notifications)
+}
+
+// list shows, for every key and language, the template users currently get.
+func (h *TemplatesHandler) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var out []templates.Template
+	for _, key := range templates.Keys {
+		for _, lang := range templates.Languages {
+			t, err := h.db.ResolveNotificationTemplate(r.Context(), key, lang)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			out = append(out, t)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"templates": out, "languages": templates.Languages, "default_lang": templates.DefaultLang})
+}
+
+func (h *TemplatesHandler) versions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	versions, err := h.db.NotificationTemplateVersions(r.Context(), r.PathValue("key"), r.PathValue("lang"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+}
+
+func (h *TemplatesHandler) save(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	t, err := h.db.SaveNotificationTemplate(r.Context(), templates.Template{
+		Key:     r.PathValue("key"),
+		Lang:    r.PathValue("lang"),
+		Subject: req.Subject,
+		Body:    req.Body,
+	}, admin.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	log.Printf("api: template %s/%s v%d saved by %d", t.Key, t.Lang, t.Version, admin.ID)
+	writeJSON(w, http.StatusOK, map[string]any{"template": t})
+}
+
+// preview renders a draft (subject/body given) or a stored version (version
+// given, 0 = built-in) with sample variables, overridden by vars.
+func (h *TemplatesHandler) preview(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		Subject *string        `json:"subject"`
+		Body    *string        `json:"body"`
+		Version *int64         `json:"version"`
+		Vars    map[string]any `json:"vars"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	key, lang := r.PathValue("key"), r.PathValue("lang")
+	var t templates.Template
+	switch {
+	case req.Body != nil:
+		t = templates.Template{Key: key, Lang: lang, Body: *req.Body}
+		if req.Subject != nil {
+			t.Subject = *req.Subject
+		}
+		if err := templates.Validate(t); err != nil {
+			writeError(w, r, NewInvalidRequestError(err.Error()))
+			return
+		}
+	case req.Version != nil:
+		s, err := h.db.NotificationTemplateVersion(r.Context(), key, lang, *req.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		t = s.Template
+	default:
+		var err error
+		if t, err = h.db.ResolveNotificationTemplate(r.Context(), key, lang); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	vars := templates.Sample(key)
+	for k, v := range req.Vars {
+		vars[k] = v
+	}
+	subject, body, err := templates.Render(t, vars)
+	if err != nil {
+		writeError(w, r, NewInvalidRequestError(err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"template": t, "vars": vars, "subject": subject, "body": body})
+}
+
+// notifications is the audit trail: what was sent to whom, in which
+// language and template version.
+func (h *TemplatesHandler) notifications(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListNotifications(r.Context(), queryInt64(r, "user_id", 0), int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"notifications": items})
+}
+
+// SendNotifications queues notifications for new ledger rows, then renders
+// and sends the due ones. Run from the notifications job.
+func (h *TemplatesHandler) SendNotifications(ctx context.Context) error {
+	queued, err := h.db.QueueNotifications(ctx)
+	if err != nil {
+		return err
+	}
+	if h.notify == nil {
+		return nil
+	}
+	due, err := h.db.ClaimNotifications(ctx, 100)
+	if err != nil {
+		return err
+	}
+	var sent int
+	for _, n := range due {
+		t, err := h.db.ResolveNotificationTemplate(ctx, n.Key, n.Lang)
+		if err != nil {
+			return err
+		}
+		subject, body, err := templates.Render(t, n.Vars)
+		if err == nil {
+			err = h.notify.SendNotification(ctx, n.UserID, subject, body)
+		}
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		} else {
+			sent++
+		}
+		if err := h.db.RecordNotification(ctx, n.NotificationID, t.Lang, t.Version, msg, h.cfg.NotificationMaxAttempts); err != nil {
+			return err
+		}
+	}
+	if queued > 0 || len(due) > 0 {
+		log.Printf("api: notifications: %d queued, %d/%d sent", queued, sent, len(due))
+	}
+	return nil
+}
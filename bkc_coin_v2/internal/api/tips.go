@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/params"
+)
+
+// TipsHandler serves tips to creators, recurring tips and the per-creator
+// supporter leaderboard. The ledger rows of tips are written in batches by
+// the tip_ledger job.
+type TipsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+}
+
+func NewTipsHandler(cfg config.Config, d *db.DB, p *params.Service) *TipsHandler {
+	return &TipsHandler{cfg: cfg, db: d, params: p}
+}
+
+func (h *TipsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/tips", h.list)
+	mux.HandleFunc("POST /api/v1/tips", h.send)
+	mux.HandleFunc("GET /api/v1/tips/leaderboard/{user_id}", h.leaderboard)
+	mux.HandleFunc("GET /api/v1/tips/recurring", h.listRecurring)
+	mux.HandleFunc("POST /api/v1/tips/recurring", h.createRecurring)
+	mux.HandleFunc("DELETE /api/v1/tips/recurring/{id}", h.cancelRecurring)
+}
+
+// policy is the current tip policy.
+func (h *TipsHandler) policy(ctx context.Context) db.TipPolicy {
+	return db.TipPolicy{
+		FeeFreeMax: h.params.Int64(ctx, db.ParamTipFeeFreeMax),
+		FeeBP:      h.params.Int64(ctx, db.ParamTipFeeBP),
+		MaxAmount:  h.params.Int64(ctx, db.ParamTipMaxAmount),
+		Velocity:   VelocityPolicy(h.cfg),
+	}
+}
+
+func (h *TipsHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	tips, err := h.db.ListTips(r.Context(), u.ID, int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tips": tips, "fee_free_max": h.params.Int64(r.Context(), db.ParamTipFeeFreeMax), "fee_bp": h.params.Int64(r.Context(), db.ParamTipFeeBP)})
+}
+
+func (h *TipsHandler) send(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		ToID    int64  `json:"to_id"`
+		Amount  int64  `json:"amount"`
+		Message string `json:"message"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	t, err := h.db.SendTip(r.Context(), u.ID, req.ToID, req.Amount, req.Message, h.policy(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// leaderboard ranks the creator's supporters over ?days= (30 by default,
+// 0 for all time).
+func (h *TipsHandler) leaderboard(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authUser(w, r, h.cfg); !ok {
+		return
+	}
+	creatorID, ok := pathInt64(w, r, "user_id")
+	if !ok {
+		return
+	}
+	var since time.Time
+	if days := queryInt64(r, "days", 30); days > 0 {
+		since = time.Now().UTC().AddDate(0, 0, -int(days))
+	}
+	entries, err := h.db.TipLeaderboard(r.Context(), creatorID, since, int(queryInt64(r, "limit", 20)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"supporters": entries})
+}
+
+func (h *TipsHandler) listRecurring(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	list, err := h.db.ListRecurringTips(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"recurring": list})
+}
+
+func (h *TipsHandler) createRecurring(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		ToID   int64  `json:"to_id"`
+		Amount int64  `json:"amount"`
+		Period string `json:"period"` // daily | weekly | monthly
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rt, err := h.db.CreateRecurringTip(r.Context(), u.ID, req.ToID, req.Amount, req.Period, h.policy(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rt)
+}
+
+func (h *TipsHandler) cancelRecurring(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.CancelRecurringTip(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// BookTips writes pending tips to the ledger. Run from the tip_ledger job.
+func (h *TipsHandler) BookTips(ctx context.Context) error {
+	_, err := h.db.BookTips(ctx)
+	return err
+}
+
+// RunRecurringTips pays the recurring tips that are due. Run from the
+// recurring_tips job.
+func (h *TipsHandler) RunRecurringTips(ctx context.Context) error {
+	n, err := h.db.RunRecurringTips(ctx, time.Now().UTC(), h.policy(ctx))
+	if n > 0 {
+		log.Printf("api: recurring tips paid: %d", n)
+	}
+	return err
+}
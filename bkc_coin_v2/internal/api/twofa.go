@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/totp"
+)
+
+// Headers carrying the second factor of a step-up protected request.
+const (
+	TwoFACodeHeader      = "X-2FA-Code"      // TOTP or backup code
+	TwoFAChallengeHeader = "X-2FA-Challenge" // approved Telegram challenge id
+)
+
+// StepUpNotifier delivers Telegram confirmation requests (implemented by the bot).
+type StepUpNotifier interface {
+	SendStepUpConfirmation(ctx context.Context, userID, challengeID int64, action string, amount int64) error
+}
+
+// StepUp gates sensitive operations (withdrawals, wallet changes, large
+// transfers) behind a second factor: a TOTP/backup code, or a challenge the
+// user approved in the Telegram bot.
+type StepUp struct {
+	cfg    config.Config
+	db     *db.DB
+	notify StepUpNotifier
+}
+
+func NewStepUp(cfg config.Config, d *db.DB, notify StepUpNotifier) *StepUp {
+	return &StepUp{cfg: cfg, db: d, notify: notify}
+}
+
+// Verify checks the request's second factor for action and writes 403
+// STEP_UP_REQUIRED when it is missing or invalid.
+func (s *StepUp) Verify(w http.ResponseWriter, r *http.Request, userID int64, action string, amount int64) bool {
+	if code := r.Header.Get(TwoFACodeHeader); code != "" {
+		ok, err := s.db.VerifySecondFactor(r.Context(), userID, code)
+		if err != nil {
+			writeError(w, r, err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	if raw := r.Header.Get(TwoFAChallengeHeader); raw != "" {
+		if id, err := strconv.ParseInt(raw, 10, 64); err == nil && id > 0 {
+			ok, err := s.db.ConsumeStepUpChallenge(r.Context(), userID, id, action, amount)
+			if err != nil {
+				writeError(w, r, err)
+				return false
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	defaultErrorHandler.HandleError(w, r, &APIError{
+		Code:      ErrCodeStepUpRequired,
+		Message:   "second factor required",
+		Details:   map[string]interface{}{"action": action, "amount": amount},
+		Timestamp: time.Now(),
+	})
+	return false
+}
+
+// Gate wraps a route that always needs a second factor for action.
+func (s *StepUp) Gate(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := authUser(w, r, s.cfg)
+		if !ok {
+			return
+		}
+		if !s.Verify(w, r, u.ID, action, 0) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// TwoFAHandler serves TOTP enrollment and Telegram step-up challenges.
+type TwoFAHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	stepUp *StepUp
+}
+
+func NewTwoFAHandler(cfg config.Config, d *db.DB, stepUp *StepUp) *TwoFAHandler {
+	return &TwoFAHandler{cfg: cfg, db: d, stepUp: stepUp}
+}
+
+func (h *TwoFAHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/2fa", h.status)
+	mux.HandleFunc("POST /api/v1/2fa/totp/enroll", h.enroll)
+	mux.HandleFunc("POST /api/v1/2fa/totp/confirm", h.confirm)
+	mux.HandleFunc("POST /api/v1/2fa/totp/disable", h.disable)
+	mux.HandleFunc("POST /api/v1/2fa/challenges", h.createChallenge)
+	mux.HandleFunc("GET /api/v1/2fa/challenges/{id}", h.getChallenge)
+}
+
+func (h *TwoFAHandler) status(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	st, err := h.db.GetTwoFactorStatus(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *TwoFAHandler) enroll(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	secret, err := h.db.BeginTOTPEnrollment(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"secret":      secret,
+		"otpauth_uri": totp.URI("BKC Coin", strconv.FormatInt(u.ID, 10), secret),
+	})
+}
+
+func (h *TwoFAHandler) confirm(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	codes, err := h.db.ConfirmTOTPEnrollment(r.Context(), u.ID, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Backup codes are shown only once.
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "backup_codes": codes})
+}
+
+func (h *TwoFAHandler) disable(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.DisableTOTP(r.Context(), u.ID, req.Code); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// createChallenge sends a confirm/decline prompt to the user's Telegram chat.
+// The client polls the challenge and then retries the action with X-2FA-Challenge.
+func (h *TwoFAHandler) createChallenge(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+		Amount int64  `json:"amount"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	switch req.Action {
+	case db.StepUpWithdraw, db.StepUpWalletChange, db.StepUpTransfer, db.StepUpVesting, db.StepUpAPIKey:
+	default:
+		writeError(w, r, NewInvalidRequestError("bad action"))
+		return
+	}
+	if h.stepUp.notify == nil {
+		writeError(w, r, NewServiceUnavailableError("telegram confirmation unavailable"))
+		return
+	}
+	c, err := h.db.CreateStepUpChallenge(r.Context(), u.ID, req.Action, req.Amount)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.stepUp.notify.SendStepUpConfirmation(r.Context(), u.ID, c.ChallengeID, c.Action, c.Amount); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *TwoFAHandler) getChallenge(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := h.db.GetStepUpChallenge(r.Context(), u.ID, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
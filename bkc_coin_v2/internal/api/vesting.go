@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// VestingHandler serves the admin allocation vesting schedules: admin setup,
+// beneficiary withdrawals (second factor required) and a public summary.
+type VestingHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	stepUp *StepUp
+}
+
+func NewVestingHandler(cfg config.Config, d *db.DB, stepUp *StepUp) *VestingHandler {
+	return &VestingHandler{cfg: cfg, db: d, stepUp: stepUp}
+}
+
+func (h *VestingHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/economy/vesting", h.summary)
+	mux.HandleFunc("GET /api/v1/vesting", h.mine)
+	mux.HandleFunc("POST /api/v1/vesting/{id}/withdraw", h.withdraw)
+	mux.HandleFunc("GET /api/v1/admin/vesting", h.adminList)
+	mux.HandleFunc("POST /api/v1/admin/vesting", h.adminCreate)
+}
+
+// summary is public: locked, vested and withdrawn totals of the admin allocation.
+func (h *VestingHandler) summary(w http.ResponseWriter, r *http.Request) {
+	sum, list, err := h.db.VestingTotals(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"summary": sum, "schedules": list})
+}
+
+func (h *VestingHandler) mine(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	list, err := h.db.ListVestings(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": list})
+}
+
+func (h *VestingHandler) withdraw(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount int64 `json:"amount"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Amount <= 0 {
+		writeError(w, r, NewInvalidRequestError("bad amount"))
+		return
+	}
+	if !h.stepUp.Verify(w, r, u.ID, db.StepUpVesting, req.Amount) {
+		return
+	}
+	v, err := h.db.WithdrawVesting(r.Context(), u.ID, id, req.Amount)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": v})
+}
+
+func (h *VestingHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	sum, list, err := h.db.VestingTotals(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"summary": sum, "schedules": list})
+}
+
+// adminCreate locks part of the admin allocation, e.g. a 3-month cliff and 12
+// monthly tranches: {"beneficiary_id":1,"amount":120000000,"cliff_months":3,"period_months":12}.
+func (h *VestingHandler) adminCreate(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		BeneficiaryID int64      `json:"beneficiary_id"`
+		Amount        int64      `json:"amount"`
+		StartAt       *time.Time `json:"start_at"`
+		CliffMonths   int64      `json:"cliff_months"`
+		PeriodMonths  int64      `json:"period_months"`
+		Note          string     `json:"note"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var start time.Time
+	if req.StartAt != nil {
+		start = req.StartAt.UTC()
+	}
+	v, err := h.db.CreateVesting(r.Context(), admin.ID, req.BeneficiaryID, req.Amount, start, req.CliffMonths, req.PeriodMonths, req.Note)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedule": v})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// WalletHandler serves user-to-user transfers and the deposit wallet settings.
+// Large transfers and wallet changes need a second factor (see StepUp).
+type WalletHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	stepUp *StepUp
+}
+
+func NewWalletHandler(cfg config.Config, d *db.DB, stepUp *StepUp) *WalletHandler {
+	return &WalletHandler{cfg: cfg, db: d, stepUp: stepUp}
+}
+
+func (h *WalletHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/wallet/transfer", h.transfer)
+	mux.HandleFunc("GET /api/v1/wallet/limits", h.limits)
+	mux.HandleFunc("GET /api/v1/admin/velocity/alerts", h.velocityAlerts)
+	mux.HandleFunc("PUT /api/v1/admin/users/{id}/kyc", h.setKYCTier)
+	mux.HandleFunc("GET /api/v1/admin/deposits", h.adminDeposits)
+	mux.HandleFunc("POST /api/v1/admin/deposits/{id}/process", h.adminProcessDeposit)
+	mux.HandleFunc("GET /api/v1/admin/deposit-wallets", h.getDepositWallets)
+	mux.HandleFunc("PUT /api/v1/admin/deposit-wallets", h.stepUp.Gate(db.StepUpWalletChange, h.setDepositWallets))
+}
+
+// VelocityPolicy is the transfer velocity policy from config; gifts are
+// held to it too.
+func VelocityPolicy(cfg config.Config) db.VelocityPolicy {
+	return db.VelocityPolicy{
+		DailyVolume:         cfg.TransferDailyVolume,
+		DailyCounterparties: cfg.TransferDailyCounterparties,
+		NewAccountCooldown:  time.Duration(cfg.NewAccountCooldownHours) * time.Hour,
+		KYCMultiplier:       cfg.VelocityKYCMultiplier,
+	}
+}
+
+func (h *WalletHandler) transfer(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		ToID   int64 `json:"to_id"`
+		Amount int64 `json:"amount"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.ToID <= 0 || req.ToID == u.ID || req.Amount <= 0 {
+		writeError(w, r, NewInvalidRequestError("bad params"))
+		return
+	}
+	if req.Amount >= h.cfg.StepUpTransferCoins && !h.stepUp.Verify(w, r, u.ID, db.StepUpTransfer, req.Amount) {
+		return
+	}
+	if err := h.db.Transfer(r.Context(), u.ID, req.ToID, req.Amount, VelocityPolicy(h.cfg)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// limits shows the user's transfer usage against the velocity limits.
+func (h *WalletHandler) limits(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	usage, err := h.db.GetVelocityUsage(r.Context(), u.ID, VelocityPolicy(h.cfg))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func (h *WalletHandler) velocityAlerts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	alerts, err := h.db.ListVelocityAlerts(r.Context(), queryInt64(r, "user_id", 0), int(queryInt64(r, "limit", 100)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alerts": alerts})
+}
+
+func (h *WalletHandler) setKYCTier(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	userID, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Tier int64 `json:"tier"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.SetKYCTier(r.Context(), admin.ID, userID, req.Tier); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *WalletHandler) getDepositWallets(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	wallets, err := h.db.GetDepositWallets(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"wallets": wallets})
+}
+
+func (h *WalletHandler) setDepositWallets(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	var req struct {
+		Wallets map[string]string `json:"wallets"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Wallets) == 0 {
+		writeError(w, r, NewInvalidRequestError("bad params"))
+		return
+	}
+	if err := h.db.SetDepositWallets(r.Context(), req.Wallets); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// adminDeposits lists manual top-ups by status (pending by default).
+func (h *WalletHandler) adminDeposits(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListDeposits(r.Context(), r.URL.Query().Get("status"), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deposits": items})
+}
+
+func (h *WalletHandler) adminProcessDeposit(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Approve bool `json:"approve"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.db.ProcessDeposit(r.Context(), id, admin.ID, req.Approve); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	dep, err := h.db.GetDeposit(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dep)
+}
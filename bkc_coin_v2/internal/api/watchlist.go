@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// WatchlistHandler serves the user's watchlist and saved marketplace searches.
+type WatchlistHandler struct {
+	cfg config.Config
+	db  *db.DB
+}
+
+func NewWatchlistHandler(cfg config.Config, d *db.DB) *WatchlistHandler {
+	return &WatchlistHandler{cfg: cfg, db: d}
+}
+
+func (h *WatchlistHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/watchlist", h.list)
+	mux.HandleFunc("POST /api/v1/watchlist", h.add)
+	mux.HandleFunc("DELETE /api/v1/watchlist/{kind}/{id}", h.remove)
+
+	mux.HandleFunc("GET /api/v1/searches", h.listSearches)
+	mux.HandleFunc("POST /api/v1/searches", h.createSearch)
+	mux.HandleFunc("DELETE /api/v1/searches/{id}", h.deleteSearch)
+}
+
+func (h *WatchlistHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListWatchlist(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *WatchlistHandler) add(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind     string `json:"kind"` // nft|listing
+		TargetID int64  `json:"target_id"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	item, err := h.db.AddWatch(r.Context(), u.ID, req.Kind, req.TargetID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (h *WatchlistHandler) remove(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.RemoveWatch(r.Context(), u.ID, r.PathValue("kind"), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *WatchlistHandler) listSearches(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListSavedSearches(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *WatchlistHandler) createSearch(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req db.SavedSearch
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.UserID = u.ID
+	s, err := h.db.CreateSavedSearch(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (h *WatchlistHandler) deleteSearch(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteSavedSearch(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/faults"
+)
+
+// Headers of a webhook delivery. The signature is
+// hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookEventHeader     = "X-BKC-Event"
+	WebhookDeliveryHeader  = "X-BKC-Delivery"
+	WebhookTimestampHeader = "X-BKC-Timestamp"
+	WebhookSignatureHeader = "X-BKC-Signature"
+)
+
+const webhookBatch = 100
+
+// WebhooksHandler is the developer portal: webhook subscriptions, their
+// delivery log, and the sender run by the webhooks job.
+type WebhooksHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	client *http.Client
+}
+
+func NewWebhooksHandler(cfg config.Config, d *db.DB) *WebhooksHandler {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !cfg.WebhookAllowPrivate {
+		// Checked on the resolved address, so DNS cannot point a hook inside.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &WebhooksHandler{cfg: cfg, db: d, client: client}
+}
+
+func (h *WebhooksHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/developer/events", h.eventTypes)
+	mux.HandleFunc("GET /api/v1/developer/webhooks", h.list)
+	mux.HandleFunc("POST /api/v1/developer/webhooks", h.create)
+	mux.HandleFunc("DELETE /api/v1/developer/webhooks/{id}", h.delete)
+	mux.HandleFunc("GET /api/v1/developer/webhooks/{id}/deliveries", h.deliveries)
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast())
+}
+
+// checkWebhookURL accepts https URLs that do not name a local host.
+func (h *WebhooksHandler) checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil {
+		return errors.New("bad url")
+	}
+	if h.cfg.WebhookAllowPrivate {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return errors.New("bad url: scheme")
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return errors.New("bad url: https required")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return errors.New("bad url: local host")
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return errors.New("bad url: private address")
+	}
+	return nil
+}
+
+func (h *WebhooksHandler) eventTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"events":           db.WebhookEventTypes,
+		"signature_header": WebhookSignatureHeader,
+		"max_attempts":     h.cfg.WebhookMaxAttempts,
+	})
+}
+
+func (h *WebhooksHandler) list(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	subs, err := h.db.ListWebhooks(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhooks": subs})
+}
+
+// create registers a webhook; the signing secret is in this response only.
+func (h *WebhooksHandler) create(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if err := h.checkWebhookURL(req.URL); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sub, err := h.db.CreateWebhook(r.Context(), u.ID, req.URL, req.Events, h.cfg.WebhookMaxPerUser)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (h *WebhooksHandler) delete(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteWebhook(r.Context(), u.ID, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *WebhooksHandler) deliveries(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	items, err := h.db.ListWebhookDeliveries(r.Context(), u.ID, id, int(queryInt64(r, "limit", 50)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": items})
+}
+
+// signWebhook signs body for delivery at ts.
+func signWebhook(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhooks relays new ledger events to subscriptions and sends due
+// deliveries; any 2xx response counts as delivered. Run from the webhooks job.
+func (h *WebhooksHandler) DeliverWebhooks(ctx context.Context) error {
+	queued, err := h.db.RelayWebhookEvents(ctx)
+	if err != nil {
+		return err
+	}
+	due, err := h.db.ClaimWebhookDeliveries(ctx, webhookBatch)
+	if err != nil {
+		return err
+	}
+	var delivered int
+	for _, d := range due {
+		status, sendErr := h.send(ctx, d)
+		ok := sendErr == nil && status >= 200 && status < 300
+		msg := ""
+		if sendErr != nil {
+			msg = sendErr.Error()
+		} else if !ok {
+			msg = "http " + strconv.Itoa(status)
+		}
+		if err := h.db.RecordWebhookAttempt(ctx, d.DeliveryID, status, msg, ok, h.cfg.WebhookMaxAttempts); err != nil {
+			return err
+		}
+		if ok {
+			delivered++
+		}
+	}
+	if queued > 0 || len(due) > 0 {
+		log.Printf("api: webhooks: %d queued, %d/%d delivered", queued, delivered, len(due))
+	}
+	return nil
+}
+
+func (h *WebhooksHandler) send(ctx context.Context, d db.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(d.Payload)
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bkc-webhooks/1")
+	req.Header.Set(WebhookEventHeader, d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, d.EventID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(WebhookSignatureHeader, signWebhook(d.Secret, ts, body))
+	if err := faults.Inject(ctx, faults.WebhookDeliver); err != nil {
+		return 0, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if err := faults.InjectAfter(ctx, faults.WebhookDeliver); err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
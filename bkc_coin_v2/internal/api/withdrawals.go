@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/geo"
+	"bkc_coin_v2/internal/params"
+)
+
+// WithdrawalHoldNotifier asks the user to confirm a risk-held withdrawal
+// (implemented by the bot). Holds are confirmed only out of band, never via
+// the API, so a hijacked WebApp session cannot release them.
+type WithdrawalHoldNotifier interface {
+	SendWithdrawalHold(ctx context.Context, userID int64, w db.Withdrawal) error
+}
+
+// WithdrawalsHandler serves withdrawal requests and the admin queues.
+type WithdrawalsHandler struct {
+	cfg    config.Config
+	db     *db.DB
+	params *params.Service
+	stepUp *StepUp
+	geo    geo.Resolver
+	notify WithdrawalHoldNotifier
+}
+
+func NewWithdrawalsHandler(cfg config.Config, d *db.DB, p *params.Service, stepUp *StepUp, g geo.Resolver, notify WithdrawalHoldNotifier) *WithdrawalsHandler {
+	return &WithdrawalsHandler{cfg: cfg, db: d, params: p, stepUp: stepUp, geo: g, notify: notify}
+}
+
+func (h *WithdrawalsHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/withdrawals", h.create)
+	mux.HandleFunc("GET /api/v1/withdrawals", h.listMine)
+	mux.HandleFunc("GET /api/v1/withdrawals/quota", h.quota)
+	mux.HandleFunc("GET /api/v1/login-geos", h.loginGeos)
+	mux.HandleFunc("POST /api/v1/affiliates/payouts", h.affiliatePayout)
+
+	mux.HandleFunc("GET /api/v1/admin/withdrawals", h.adminList)
+	mux.HandleFunc("POST /api/v1/admin/withdrawals/{id}/override", h.adminOverride)
+	mux.HandleFunc("POST /api/v1/admin/withdrawals/{id}/process", h.adminProcess)
+}
+
+func (h *WithdrawalsHandler) policy() db.RiskPolicy {
+	return db.RiskPolicy{HoldScore: int(h.cfg.WithdrawRiskHoldScore), LargeAmount: h.cfg.WithdrawRiskLargeAmount}
+}
+
+func (h *WithdrawalsHandler) salePolicy(ctx context.Context) db.SalePolicy {
+	return db.SalePolicy{DailyLimit: h.params.Int64(ctx, db.ParamSaleDailyLimit), TaxBP: h.cfg.SaleTaxBP, TaxBurnBP: h.cfg.SaleTaxBurnBP}
+}
+
+// quota returns today's sale counter and the sale tax withdrawals pay.
+func (h *WithdrawalsHandler) quota(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	q, err := h.db.GetSaleQuota(r.Context(), u.ID, h.salePolicy(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"quota": q, "sale_tax_bp": h.cfg.SaleTaxBP})
+}
+
+func (h *WithdrawalsHandler) create(w http.ResponseWriter, r *http.Request) {
+	h.request(w, r, false)
+}
+
+// affiliatePayout withdraws affiliate commission through the same risk, hold
+// and step-up checks as a regular withdrawal.
+func (h *WithdrawalsHandler) affiliatePayout(w http.ResponseWriter, r *http.Request) {
+	h.request(w, r, true)
+}
+
+func (h *WithdrawalsHandler) request(w http.ResponseWriter, r *http.Request, affiliate bool) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount  int64  `json:"amount"`
+		Address string `json:"address"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Amount <= 0 || req.Address == "" {
+		writeError(w, r, NewInvalidRequestError("bad params"))
+		return
+	}
+	if affiliate && req.Amount < h.params.Int64(r.Context(), db.ParamAffiliateMinPayout) {
+		writeError(w, r, NewInvalidRequestError("amount below affiliate min payout"))
+		return
+	}
+	if !h.stepUp.Verify(w, r, u.ID, db.StepUpWithdraw, req.Amount) {
+		return
+	}
+
+	ip := getClientIP(r)
+	var loc geo.Info
+	if h.geo != nil {
+		var err error
+		if loc, err = h.geo.Lookup(r.Context(), ip); err != nil {
+			// Scored as an unknown location rather than blocking the request.
+			log.Printf("api: geo lookup user=%d: %v", u.ID, err)
+		}
+	}
+	wd, err := h.db.RequestWithdrawal(r.Context(), db.WithdrawalRequest{
+		UserID:     u.ID,
+		Amount:     req.Amount,
+		Address:    req.Address,
+		IP:         ip,
+		Country:    loc.Country,
+		ASN:        loc.ASN,
+		SessionKey: sessionKey(r),
+		Affiliate:  affiliate,
+	}, h.policy(), h.salePolicy(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if wd.Status == "held" && h.notify != nil {
+		if err := h.notify.SendWithdrawalHold(r.Context(), u.ID, wd); err != nil {
+			log.Printf("api: withdrawal hold notify id=%d: %v", wd.WithdrawalID, err)
+		}
+	}
+	writeJSON(w, http.StatusOK, wd)
+}
+
+func (h *WithdrawalsHandler) listMine(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListMyWithdrawals(r.Context(), u.ID, queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *WithdrawalsHandler) loginGeos(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	items, err := h.db.ListLoginGeos(r.Context(), u.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *WithdrawalsHandler) adminList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authAdmin(w, r, h.cfg); !ok {
+		return
+	}
+	items, err := h.db.ListWithdrawals(r.Context(), r.URL.Query().Get("status"), queryInt64(r, "limit", 50))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// adminOverride releases a held withdrawal to the payout queue or rejects it.
+func (h *WithdrawalsHandler) adminOverride(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Release bool `json:"release"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	wd, err := h.db.OverrideWithdrawalHold(r.Context(), admin.ID, id, req.Release)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wd)
+}
+
+func (h *WithdrawalsHandler) adminProcess(w http.ResponseWriter, r *http.Request) {
+	admin, ok := authAdmin(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	id, ok := pathInt64(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Approve bool `json:"approve"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	wd, err := h.db.ProcessWithdrawal(r.Context(), admin.ID, id, req.Approve)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wd)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/templates"
+	"bkc_coin_v2/internal/wsauth"
+)
+
+// WSTokenHandler trades the webapp's initData for a short-lived token to
+// open a WebSocket with (see wsauth); the socket takes the user from it.
+type WSTokenHandler struct {
+	cfg    config.Config
+	secret []byte
+}
+
+func NewWSTokenHandler(cfg config.Config) *WSTokenHandler {
+	return &WSTokenHandler{cfg: cfg, secret: wsauth.Secret(cfg.WSTokenSecret, cfg.BotToken)}
+}
+
+func (h *WSTokenHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/ws-token", h.issue)
+}
+
+func (h *WSTokenHandler) issue(w http.ResponseWriter, r *http.Request) {
+	u, ok := authUser(w, r, h.cfg)
+	if !ok {
+		return
+	}
+	exp := time.Now().Add(time.Duration(h.cfg.WSTokenTTLSeconds) * time.Second)
+	token := wsauth.Sign(h.secret, wsauth.Claims{
+		UserID:    u.ID,
+		Username:  u.Username,
+		Lang:      templates.NormalizeLang(r.Header.Get("Accept-Language")),
+		ExpiresAt: exp.Unix(),
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"token": token, "expires_at": exp.UTC()})
+}
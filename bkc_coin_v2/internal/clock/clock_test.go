@@ -0,0 +1,31 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManual(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManual(start)
+	if !m.Now().Equal(start) {
+		t.Fatalf("now %v, want %v", m.Now(), start)
+	}
+	if got := m.Advance(36 * time.Hour); !got.Equal(start.Add(36*time.Hour)) || !m.Now().Equal(got) {
+		t.Fatalf("advance: %v, now %v", got, m.Now())
+	}
+	m.Set(start)
+	if !m.Now().Equal(start) {
+		t.Fatalf("set: now %v", m.Now())
+	}
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != System {
+		t.Fatal("nil clock is not the system clock")
+	}
+	m := NewManual(time.Unix(0, 0))
+	if Or(m) != Clock(m) {
+		t.Fatal("clock replaced")
+	}
+}
@@ -13,6 +13,9 @@ type Config struct {
 	BotToken       string
 	AdminID        int64
 	DatabaseURL    string
+	DBDriver       string
+	DBEmbeddedDir  string
+	PGBinDir       string
 	RedisURL       string
 	PublicBaseURL  string
 	WebappURL      string
@@ -24,6 +27,7 @@ type Config struct {
 	RunBot         bool
 	RunOverdue     bool
 	RunFasttap     bool
+	RunJobs        bool
 
 	TotalSupply          int64
 	AdminAllocationPct   int64
@@ -34,17 +38,181 @@ type Config struct {
 	BankLoan30DInterestBP int64
 	BankLoanMaxAmount     int64
 	P2PRecallMinDays      int64
+	P2PConcentrationBP    int64
+	P2PInsurancePremiumBP int64
+	P2PInsuranceCoverBP   int64
+	P2PDefaultGraceDays   int64
+	P2PInsureMinRepaid    int64
+	P2PInsureBorrowerCap  int64
+	P2PInsurePairCap      int64
 	MarketListingFeeCoins int64
 
+	NFTStakeDailyReward int64
+	NFTStakeLockDays    int64
+	NFTMarketFeeBP      int64
+	NFTRentalFeeBP      int64
+	NFTRentalMaxDays    int64
+
+	PromoCostPerImpression int64
+
+	StepUpTransferCoins int64
+
+	TransferDailyVolume         int64
+	TransferDailyCounterparties int64
+	NewAccountCooldownHours     int64
+	VelocityKYCMultiplier       int64
+
+	PatternSmallTransfer int64
+	PatternFanInMin      int64
+
+	APIKeyMaxPerUser  int64
+	APIKeyDefaultRate int64
+	APIKeyMaxRate     int64
+
+	WebhookMaxPerUser   int64
+	WebhookMaxAttempts  int64
+	WebhookAllowPrivate bool
+
+	NotificationMaxAttempts int64
+
+	AlertChatID               int64
+	AlertRepeatMinutes        int64
+	AlertAckMuteHours         int64
+	AlertPaymentFailures      int64
+	AlertPaymentWindowMinutes int64
+	AlertEconomyHealthMin     int64
+	AlertHeartbeatMisses      int64
+
+	GameRTPMin        float64
+	GameRTPMax        float64
+	GameRTPMinWagered int64
+	GameRTPAutoHalt   bool
+
+	BetMax            int64
+	BetBalancePercent int64
+	BetPlanCaps       map[string]int64
+	BetNewAccountMax  int64
+	BetNewAccountDays int64
+
+	BotWindowHours    int64
+	BotMinBets        int64
+	BotLimitScore     int64
+	BotCaptchaScore   int64
+	BotMaxBet         int64
+	CaptchaSecret     string
+	CaptchaVerifyURL  string
+	CaptchaValidHours int64
+
+	AffiliateMinPayout int64
+
+	GameCreditsBuyRate        int64
+	GameCreditsSellRate       int64
+	GameCreditsSellDailyLimit int64
+
+	GiftMinAmount int64
+	GiftMaxAmount int64
+	GiftTTLHours  int64
+
+	BillMaxParticipants int64
+	BillMaxDays         int64
+
+	MerchantFeeBP           int64
+	MerchantOrderTTLMinutes int64
+	MiniAppLink             string
+
+	TipFeeFreeMax int64
+	TipFeeBP      int64
+	TipMaxAmount  int64
+
+	CreatorWidgetRatePerMin int64
+
+	GigMaxMilestones int64
+	GigReviewDays    int64
+
+	ModerationBannedWords           []string
+	ModerationNudityMaxPct          int64
+	ModerationPriceAnomalyX         int64
+	ModerationScreenDelayMinutes    int64
+	ModerationOffenderRejects       int64
+	ModerationOffenderDays          int64
+	ModerationOffenderCooldownHours int64
+	ModerationClassifierURL         string
+
+	PriceSuggestionDays int64
+	PriceAboveMarketPct int64
+
+	DBQueryTimeoutMs int64
+	DBSlowQueryMs    int64
+
+	Environment    string
+	FaultInjection bool
+	FaultRules     string
+
+	ClickHouseURL      string
+	ClickHouseDatabase string
+	ClickHouseUser     string
+	ClickHousePassword string
+	AnalyticsBatch     int64
+	AnalyticsSampleBP  int64
+
+	GeoIPURL                string
+	WithdrawRiskHoldScore   int64
+	WithdrawRiskLargeAmount int64
+
 	EnergyMax         int64
 	EnergyRegenPerSec float64
 	TapMaxPerRequest  int64
 	TapMaxMultiTouch  int64
 
+	TapDegradeQueueDepth  int64
+	TapShedQueueDepth     int64
+	TapDegradeDBLatencyMs int64
+	TapShedDBLatencyMs    int64
+	TapShedRetryAfterMs   int64
+	TapDegradeBatchMs     int64
+
 	TapDailyLimit           int64
+	DailyEmissionCap        int64
 	ExtraTapsPackSize       int64
 	ExtraTapsPackPriceCoins int64
 
+	SaleDailyLimit int64
+	SaleTaxBP      int64
+	SaleTaxBurnBP  int64
+
+	ReserveForecastWindowDays int64
+	ReserveRunwayAlertDays    int64
+
+	EconomyShadow bool
+
+	PublicStatsRatePerMin int64
+	PublicStatsMaxAge     int64
+
+	DisabledModules []string
+
+	ReplayProtection           bool
+	RequestChallengeTTLSeconds int64
+
+	WSTokenSecret     string
+	WSTokenTTLSeconds int64
+
+	ReservesSigningKey     string
+	ReservesIntervalHours  int64
+	ReservesKeepReports    int64
+	ReservesTONAPIURL      string
+	ReservesTONAPIKey      string
+	ReservesTONAddress     string
+	ReservesTONUSDTJetton  string
+	ReservesSolanaRPCURL   string
+	ReservesSolanaOwner    string
+	ReservesSolanaUSDTMint string
+
+	LevelXPThresholds []int64 // XP needed for level 2, 3, ...
+	LevelUpReward     int64
+	XPPerTap          int64
+	XPPerPurchase     int64
+	XPPerGameWin      int64
+
 	EnergyBoost1HPriceCoins      int64
 	EnergyBoost1HRegenMultiplier float64
 	EnergyBoost1HMaxMultiplier   float64
@@ -143,6 +311,37 @@ func envFloat64(key string, def float64) float64 {
 	return n
 }
 
+// envInt64List parses a comma-separated list; any bad item falls back to def.
+func envInt64List(key string, def []int64) []int64 {
+	parts := parseCSV(os.Getenv(key))
+	if len(parts) == 0 {
+		return def
+	}
+	out := make([]int64, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return def
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// envInt64Map читает "ключ:число,ключ:число"; неверная пара — паника.
+func envInt64Map(key string) map[string]int64 {
+	out := map[string]int64{}
+	for _, p := range parseCSV(os.Getenv(key)) {
+		k, v, ok := strings.Cut(p, ":")
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if !ok || err != nil || n < 0 || strings.TrimSpace(k) == "" {
+			panic(key + ": bad pair " + p)
+		}
+		out[strings.ToLower(strings.TrimSpace(k))] = n
+	}
+	return out
+}
+
 func envBool(key string, def bool) bool {
 	val := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
 	if val == "" {
@@ -189,7 +388,10 @@ func Load() Config {
 
 	cfg := Config{
 		BotToken:       mustEnv("BOT_TOKEN"),
-		DatabaseURL:    normalizeDatabaseURL(mustEnv("DATABASE_URL")),
+		DatabaseURL:    normalizeDatabaseURL(os.Getenv("DATABASE_URL")),
+		DBDriver:       strings.ToLower(strings.TrimSpace(os.Getenv("DB_DRIVER"))), // postgres | embedded (свой Postgres для локальной разработки, см. internal/devdb)
+		DBEmbeddedDir:  strings.TrimSpace(os.Getenv("DB_EMBEDDED_DIR")),
+		PGBinDir:       strings.TrimSpace(os.Getenv("PG_BIN")), // каталог initdb/postgres, если их нет в PATH
 		RedisURL:       normalizeRedisURL(os.Getenv("REDIS_URL")),
 		PublicBaseURL:  publicBase,
 		WebappURL:      webappURL,
@@ -201,6 +403,7 @@ func Load() Config {
 		RunBot:         envBool("RUN_BOT", true),
 		RunOverdue:     envBool("RUN_OVERDUE_WORKER", true),
 		RunFasttap:     envBool("RUN_FASTTAP_WORKER", true),
+		RunJobs:        envBool("RUN_JOBS", true),
 
 		AdminID:              envInt64("ADMIN_ID", 0),
 		TotalSupply:          envInt64("TOTAL_SUPPLY", 1_000_000_000), // 1 миллиард BKC
@@ -212,17 +415,213 @@ func Load() Config {
 		BankLoan30DInterestBP: envInt64("BANK_LOAN_30D_INTEREST_BP", 3500), // 35%
 		BankLoanMaxAmount:     envInt64("BANK_LOAN_MAX_AMOUNT", 2_000_000),
 		P2PRecallMinDays:      envInt64("P2P_RECALL_MIN_DAYS", 5),
+		P2PConcentrationBP:    envInt64("P2P_CONCENTRATION_BP", 2_500),      // доля одного заемщика в портфеле, выше — предупреждение
+		P2PInsurancePremiumBP: envInt64("P2P_INSURANCE_PREMIUM_BP", 300),    // страховой взнос при хорошем кредитном рейтинге, 0 — страховки нет
+		P2PInsuranceCoverBP:   envInt64("P2P_INSURANCE_COVER_BP", 8_000),    // какую долю непокрытого залогом долга пул вернет кредитору
+		P2PDefaultGraceDays:   envInt64("P2P_DEFAULT_GRACE_DAYS", 3),        // сколько дней после срока ждать до объявления дефолта
+		P2PInsureMinRepaid:    envInt64("P2P_INSURE_MIN_REPAID", 3),         // займов, вовремя погашенных заемщиком, до права страховать
+		P2PInsureBorrowerCap:  envInt64("P2P_INSURE_BORROWER_CAP", 200_000), // предел выплат пула по дефолтам одного заемщика, 0 — без предела
+		P2PInsurePairCap:      envInt64("P2P_INSURE_PAIR_CAP", 50_000),      // то же для пары кредитор–заемщик
 		MarketListingFeeCoins: envInt64("MARKET_LISTING_FEE_COINS", 2_000),
 
+		NFTStakeDailyReward: envInt64("NFT_STAKE_DAILY_REWARD", 50), // за 1 common NFT в день
+		NFTStakeLockDays:    envInt64("NFT_STAKE_LOCK_DAYS", 7),
+		NFTMarketFeeBP:      envInt64("NFT_MARKET_FEE_BP", 250), // 2.5% комиссия платформы
+		NFTRentalFeeBP:      envInt64("NFT_RENTAL_FEE_BP", 500), // 5% с аренды NFT
+		NFTRentalMaxDays:    envInt64("NFT_RENTAL_MAX_DAYS", 30),
+
+		PromoCostPerImpression: envInt64("PROMO_COST_PER_IMPRESSION", 2), // BKC за показ в "featured"
+
+		StepUpTransferCoins: envInt64("STEP_UP_TRANSFER_COINS", 100_000), // перевод от этой суммы требует 2FA
+
+		// Лимиты скорости переводов (AML); 0 = без лимита
+		TransferDailyVolume:         envInt64("TRANSFER_DAILY_VOLUME", 0),         // BKC за скользящие 24ч
+		TransferDailyCounterparties: envInt64("TRANSFER_DAILY_COUNTERPARTIES", 0), // разных получателей в сутки (UTC)
+		NewAccountCooldownHours:     envInt64("NEW_ACCOUNT_COOLDOWN_HOURS", 0),    // новый аккаунт не может переводить
+		VelocityKYCMultiplier:       envInt64("VELOCITY_KYC_MULTIPLIER", 10),      // множитель лимитов для KYC уровня 1; уровень 2 без лимитов
+
+		// Поиск схем в леджере (раз в сутки): дробление на мелкие переводы и круговые переводы
+		PatternSmallTransfer: envInt64("PATTERN_SMALL_TRANSFER", 1_000), // "мелкий" перевод, BKC
+		PatternFanInMin:      envInt64("PATTERN_FAN_IN_MIN", 20),        // столько мелких переводов одному получателю за сутки = алерт; 0 = выкл
+
+		// API-ключи пользователей (боты): лимит ключей и запросов в минуту на ключ
+		APIKeyMaxPerUser:  envInt64("API_KEY_MAX_PER_USER", 5),
+		APIKeyDefaultRate: envInt64("API_KEY_DEFAULT_RATE", 60),
+		APIKeyMaxRate:     envInt64("API_KEY_MAX_RATE", 600),
+
+		// Вебхуки разработчиков: лимит подписок, число попыток доставки
+		WebhookMaxPerUser:   envInt64("WEBHOOK_MAX_PER_USER", 5),
+		WebhookMaxAttempts:  envInt64("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookAllowPrivate: envBool("WEBHOOK_ALLOW_PRIVATE", false), // разрешить http и локальные адреса (только для разработки)
+
+		// Уведомления пользователям по шаблонам (зачисление, просрочка, эскроу): число попыток отправки
+		NotificationMaxAttempts: envInt64("NOTIFICATION_MAX_ATTEMPTS", 5),
+
+		// Алерты админу в Telegram: канал, повтор без подтверждения, тишина после /ack
+		AlertChatID:               envInt64("ALERT_CHAT_ID", 0), // 0 = личка ADMIN_ID
+		AlertRepeatMinutes:        envInt64("ALERT_REPEAT_MINUTES", 30),
+		AlertAckMuteHours:         envInt64("ALERT_ACK_MUTE_HOURS", 6),
+		AlertPaymentFailures:      envInt64("ALERT_PAYMENT_FAILURES", 5), // столько ошибок подтверждения платежей за окно = алерт; 0 = выкл
+		AlertPaymentWindowMinutes: envInt64("ALERT_PAYMENT_WINDOW_MINUTES", 15),
+		AlertEconomyHealthMin:     envInt64("ALERT_ECONOMY_HEALTH_MIN", 30), // здоровье экономики ниже = алерт
+		AlertHeartbeatMisses:      envInt64("ALERT_HEARTBEAT_MISSES", 3),    // столько пропущенных интервалов воркера = алерт
+
+		// Мониторинг RTP игр (выплачено / поставлено) по окнам 1ч, 24ч, 7д: выход за границы = алерт и пауза игры
+		GameRTPMin:        envFloat64("GAME_RTP_MIN", 0.80),
+		GameRTPMax:        envFloat64("GAME_RTP_MAX", 1.05),
+		GameRTPMinWagered: envInt64("GAME_RTP_MIN_WAGERED", 100_000), // окно с меньшим оборотом не оценивается (шум)
+		GameRTPAutoHalt:   envBool("GAME_RTP_AUTO_HALT", true),       // false = только алерт
+
+		// Лимит одной ставки = min(BET_MAX, % баланса, лимит тарифа); новым аккаунтам — от BET_NEW_ACCOUNT_MAX с ростом до общего за BET_NEW_ACCOUNT_DAYS; 0 = без правила
+		BetMax:            envInt64("BET_MAX", 1_000_000),
+		BetBalancePercent: envInt64("BET_BALANCE_PERCENT", 25),
+		BetPlanCaps:       envInt64Map("BET_PLAN_CAPS"), // "base:50000,vip:500000"; base = без тарифа
+		BetNewAccountMax:  envInt64("BET_NEW_ACCOUNT_MAX", 1_000),
+		BetNewAccountDays: envInt64("BET_NEW_ACCOUNT_DAYS", 7),
+
+		// Проверка игроков на ботов (job game_bots): оценка 0..100 за окно; от BOT_LIMIT_SCORE ставки режутся до BOT_MAX_BET, от BOT_CAPTCHA_SCORE нужна капча
+		BotWindowHours:    envInt64("BOT_WINDOW_HOURS", 72),
+		BotMinBets:        envInt64("BOT_MIN_BETS", 100), // меньше ставок за окно — не оцениваем
+		BotLimitScore:     envInt64("BOT_LIMIT_SCORE", 50),
+		BotCaptchaScore:   envInt64("BOT_CAPTCHA_SCORE", 70), // без CAPTCHA_SECRET капча не требуется
+		BotMaxBet:         envInt64("BOT_MAX_BET", 1_000),
+		CaptchaSecret:     strings.TrimSpace(os.Getenv("CAPTCHA_SECRET")),
+		CaptchaVerifyURL:  strings.TrimSpace(os.Getenv("CAPTCHA_VERIFY_URL")), // siteverify Turnstile / hCaptcha
+		CaptchaValidHours: envInt64("CAPTCHA_VALID_HOURS", 24),                // решенная капча не спрашивается повторно столько часов
+
+		AffiliateMinPayout: envInt64("AFFILIATE_MIN_PAYOUT", 10_000), // минимальная выплата партнерской комиссии
+
+		// Начальные курсы игровых кредитов; дальше их меняет админ
+		GameCreditsBuyRate:        envInt64("GAME_CREDITS_BUY_RATE", 100),  // кредитов за 1 BKC
+		GameCreditsSellRate:       envInt64("GAME_CREDITS_SELL_RATE", 105), // кредитов за 1 BKC при обратном обмене (спред)
+		GameCreditsSellDailyLimit: envInt64("GAME_CREDITS_SELL_DAILY_LIMIT", 100_000),
+
+		// Подарки BKC по ссылке; невостребованный подарок возвращается отправителю
+		GiftMinAmount: envInt64("GIFT_MIN_AMOUNT", 100),
+		GiftMaxAmount: envInt64("GIFT_MAX_AMOUNT", 1_000_000),
+		GiftTTLHours:  envInt64("GIFT_TTL_HOURS", 72),
+
+		// Совместные счета: доли участников, возврат при недоборе к сроку
+		BillMaxParticipants: envInt64("BILL_MAX_PARTICIPANTS", 20),
+		BillMaxDays:         envInt64("BILL_MAX_DAYS", 30), // максимальный срок сбора
+
+		// Прием BKC магазинами: QR со ссылкой в Mini App, комиссия в резерв
+		MerchantFeeBP:           envInt64("MERCHANT_FEE_BP", 100), // комиссия нового магазина по умолчанию (1%)
+		MerchantOrderTTLMinutes: envInt64("MERCHANT_ORDER_TTL_MINUTES", 30),
+		MiniAppLink:             strings.TrimRight(strings.TrimSpace(os.Getenv("MINI_APP_LINK")), "/"), // https://t.me/<bot>/<app>; пусто = WEBAPP_URL
+
+		// Чаевые авторам: мелкие без комиссии, в леджер пачками
+		TipFeeFreeMax: envInt64("TIP_FEE_FREE_MAX", 1_000), // чаевые до этой суммы без комиссии
+		TipFeeBP:      envInt64("TIP_FEE_BP", 100),
+		TipMaxAmount:  envInt64("TIP_MAX_AMOUNT", 100_000),
+		// Виджет цели для внешних сайтов: лимит запросов с одного IP в минуту
+		CreatorWidgetRatePerMin: envInt64("CREATOR_WIDGET_RATE_PER_MIN", 60),
+
+		// Фриланс-услуги: этапы в эскроу; сданный этап без ответа покупателя оплачивается через GIG_REVIEW_DAYS
+		GigMaxMilestones: envInt64("GIG_MAX_MILESTONES", 10),
+		GigReviewDays:    envInt64("GIG_REVIEW_DAYS", 3),
+
+		// Модерация объявлений: автоматические флаги, остальное решают модераторы
+		ModerationBannedWords:           parseCSV(os.Getenv("MODERATION_BANNED_WORDS")),
+		ModerationNudityMaxPct:          envInt64("MODERATION_NUDITY_MAX_PCT", 80),      // оценка классификатора выше = флаг
+		ModerationPriceAnomalyX:         envInt64("MODERATION_PRICE_ANOMALY_X", 10),     // цена дальше чем в X раз от медианы категории = флаг; 0 = выкл
+		ModerationScreenDelayMinutes:    envInt64("MODERATION_SCREEN_DELAY_MINUTES", 2), // время на загрузку фото до проверки
+		ModerationOffenderRejects:       envInt64("MODERATION_OFFENDER_REJECTS", 3),
+		ModerationOffenderDays:          envInt64("MODERATION_OFFENDER_DAYS", 30),
+		ModerationOffenderCooldownHours: envInt64("MODERATION_OFFENDER_COOLDOWN_HOURS", 24),        // нарушитель публикует не чаще раза в столько часов
+		ModerationClassifierURL:         strings.TrimSpace(os.Getenv("MODERATION_CLASSIFIER_URL")), // пусто = фото не проверяются
+
+		// Рекомендуемая цена: медиана продаж категории / NFT за PRICE_SUGGESTION_DAYS дней
+		PriceSuggestionDays: envInt64("PRICE_SUGGESTION_DAYS", 30),
+		PriceAboveMarketPct: envInt64("PRICE_ABOVE_MARKET_PCT", 50), // дороже медианы на столько % = флаг; 0 = выкл
+
+		// Запросы к Postgres: таймаут одного запроса и порог лога медленных (bkc_db_query_seconds по методам); 0 = выкл
+		DBQueryTimeoutMs: envInt64("DB_QUERY_TIMEOUT_MS", 10_000),
+		DBSlowQueryMs:    envInt64("DB_SLOW_QUERY_MS", 500),
+
+		// Инъекция сбоев (заголовок X-BKC-Fault, /api/v1/admin/faults) — только вне production
+		Environment:    strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV"))), // production | staging | dev
+		FaultInjection: envBool("FAULT_INJECTION", false),
+		FaultRules:     strings.TrimSpace(os.Getenv("FAULTS")), // напр. db.commit=fail_after:0.2; webhook.deliver=delay:2s
+
+		// Выгрузка ledger и событий в ClickHouse для аналитики; пустой URL = выкл
+		ClickHouseURL:      strings.TrimSpace(os.Getenv("CLICKHOUSE_URL")),      // напр. http://clickhouse:8123
+		ClickHouseDatabase: strings.TrimSpace(os.Getenv("CLICKHOUSE_DATABASE")), // по умолчанию bkc
+		ClickHouseUser:     strings.TrimSpace(os.Getenv("CLICKHOUSE_USER")),
+		ClickHousePassword: os.Getenv("CLICKHOUSE_PASSWORD"),
+		AnalyticsBatch:     envInt64("ANALYTICS_BATCH", 5_000),      // строк за один INSERT
+		AnalyticsSampleBP:  envInt64("ANALYTICS_SAMPLE_BP", 10_000), // доля пользователей, чьи клиентские события пишутся (10000 = все)
+
+		GeoIPURL:                strings.TrimSpace(os.Getenv("GEOIP_URL")), // напр. http://ip-api.com/json/%s?fields=status,countryCode,as
+		WithdrawRiskHoldScore:   envInt64("WITHDRAW_RISK_HOLD_SCORE", 50),  // с этого риска вывод ждёт подтверждения
+		WithdrawRiskLargeAmount: envInt64("WITHDRAW_RISK_LARGE_AMOUNT", 500_000),
+
 		EnergyMax:         envInt64("ENERGY_MAX", 300),
 		EnergyRegenPerSec: envFloat64("ENERGY_REGEN_PER_SEC", 1.0),
 		TapMaxPerRequest:  envInt64("TAP_MAX_PER_REQUEST", 500),
 		TapMaxMultiTouch:  envInt64("TAP_MAX_MULTITOUCH", 13),
 
-		TapDailyLimit:           envInt64("TAP_DAILY_LIMIT", 100_000),
+		// Защита тапа от перегрузки: очередь на запись в Postgres и задержка сброса
+		TapDegradeQueueDepth:  envInt64("TAP_DEGRADE_QUEUE_DEPTH", 50_000),
+		TapShedQueueDepth:     envInt64("TAP_SHED_QUEUE_DEPTH", 200_000),
+		TapDegradeDBLatencyMs: envInt64("TAP_DEGRADE_DB_LATENCY_MS", 1_000),
+		TapShedDBLatencyMs:    envInt64("TAP_SHED_DB_LATENCY_MS", 5_000),
+		TapShedRetryAfterMs:   envInt64("TAP_SHED_RETRY_AFTER_MS", 2_000), // подсказка клиенту при 202
+		TapDegradeBatchMs:     envInt64("TAP_DEGRADE_BATCH_MS", 1_000),    // клиент копит тапы дольше
+
+		TapDailyLimit:           envInt64("TAP_DAILY_LIMIT", MAX_DAILY_TAPS), // базовый лимит; планы из user_plans его переопределяют
+		DailyEmissionCap:        envInt64("DAILY_EMISSION_CAP", 0),           // BKC в сутки (UTC) на все бесплатные начисления; 0 = без лимита
 		ExtraTapsPackSize:       envInt64("EXTRA_TAPS_PACK_SIZE", 13_000),
 		ExtraTapsPackPriceCoins: envInt64("EXTRA_TAPS_PACK_PRICE_COINS", 15_000),
 
+		// Продажи (вывод, обменные объявления): дневной лимит на пользователя и налог
+		SaleDailyLimit: envInt64("SALE_DAILY_LIMIT", 0),     // BKC в сутки (UTC); 0 = без лимита
+		SaleTaxBP:      envInt64("SALE_TAX_BP", 0),          // налог с продажи
+		SaleTaxBurnBP:  envInt64("SALE_TAX_BURN_BP", 5_000), // доля налога на сжигание, остальное в стабфонд
+
+		// Прогноз резерва: окно тренда и порог алерта админу (0 = без алертов)
+		ReserveForecastWindowDays: envInt64("RESERVE_FORECAST_WINDOW_DAYS", 7),
+		ReserveRunwayAlertDays:    envInt64("RESERVE_RUNWAY_ALERT_DAYS", 30),
+
+		// Теневой расчет новой формулы награды за тапы (memtap): балансы не меняются, только отчет
+		EconomyShadow: envBool("ECONOMY_SHADOW", false),
+
+		// Публичная статистика для трекеров и сайта: лимит запросов с IP в минуту, кэш (сек)
+		PublicStatsRatePerMin: envInt64("PUBLIC_STATS_RATE_PER_MIN", 60),
+		PublicStatsMaxAge:     envInt64("PUBLIC_STATS_MAX_AGE", 300),
+
+		// Модули, выключенные флагом независимо от админки: maintenance,games,withdrawals,marketplace
+		DisabledModules: parseCSV(strings.TrimSpace(os.Getenv("DISABLED_MODULES"))),
+
+		// Защита денежных запросов мини-аппа от повтора: подпись challenge + одноразовый nonce
+		ReplayProtection:           envBool("REPLAY_PROTECTION", true),
+		RequestChallengeTTLSeconds: envInt64("REQUEST_CHALLENGE_TTL_SECONDS", 120),
+
+		// Токен подключения к WebSocket (игры, график): секрет подписи (пусто = из BOT_TOKEN) и срок жизни, с
+		WSTokenSecret:     strings.TrimSpace(os.Getenv("WS_TOKEN_SECRET")),
+		WSTokenTTLSeconds: envInt64("WS_TOKEN_TTL_SECONDS", 60),
+
+		// Proof of reserves: ключ подписи (hex seed ed25519; пусто = отчеты не строятся),
+		// период (ч), сколько последних отчетов хранят дерево для пруфов пользователей
+		ReservesSigningKey:    strings.TrimSpace(os.Getenv("RESERVES_SIGNING_KEY")),
+		ReservesIntervalHours: envInt64("RESERVES_INTERVAL_HOURS", 24),
+		ReservesKeepReports:   envInt64("RESERVES_KEEP_REPORTS", 30),
+		// Кошельки казны: TON (tonapi) и USDT на Solana; пустой адрес = сеть не учитывается
+		ReservesTONAPIURL:      strings.TrimSpace(os.Getenv("RESERVES_TON_API_URL")), // по умолчанию https://tonapi.io/v2
+		ReservesTONAPIKey:      strings.TrimSpace(os.Getenv("RESERVES_TON_API_KEY")),
+		ReservesTONAddress:     strings.TrimSpace(os.Getenv("RESERVES_TON_ADDRESS")),
+		ReservesTONUSDTJetton:  strings.TrimSpace(os.Getenv("RESERVES_TON_USDT_JETTON")), // пусто = только TON
+		ReservesSolanaRPCURL:   strings.TrimSpace(os.Getenv("RESERVES_SOLANA_RPC_URL")),  // по умолчанию mainnet-beta
+		ReservesSolanaOwner:    strings.TrimSpace(os.Getenv("RESERVES_SOLANA_OWNER")),
+		ReservesSolanaUSDTMint: strings.TrimSpace(os.Getenv("RESERVES_SOLANA_USDT_MINT")), // по умолчанию USDT
+
+		// Уровни: пороги XP (для уровней 2, 3, ...) и источники XP
+		LevelXPThresholds: envInt64List("LEVEL_XP_THRESHOLDS", []int64{1_000, 3_000, 7_000, 15_000, 30_000, 60_000, 120_000, 250_000, 500_000}),
+		LevelUpReward:     envInt64("LEVEL_UP_REWARD", 1_000), // BKC из резерва за уровень, умножается на номер уровня
+		XPPerTap:          envInt64("XP_PER_TAP", 1),
+		XPPerPurchase:     envInt64("XP_PER_PURCHASE", 200), // пополнение, покупка NFT или на маркете
+		XPPerGameWin:      envInt64("XP_PER_GAME_WIN", 50),
+
 		EnergyBoost1HPriceCoins:      envInt64("ENERGY_BOOST_1H_PRICE_COINS", 25_000),
 		EnergyBoost1HRegenMultiplier: envFloat64("ENERGY_BOOST_1H_REGEN_MULT", 5.0),
 		EnergyBoost1HMaxMultiplier:   envFloat64("ENERGY_BOOST_1H_MAX_MULT", 5.0),
@@ -274,9 +673,198 @@ func Load() Config {
 	if cfg.TapDailyLimit < 0 {
 		panic("TAP_DAILY_LIMIT must be >= 0")
 	}
+	if cfg.DailyEmissionCap < 0 {
+		panic("DAILY_EMISSION_CAP must be >= 0")
+	}
+	if cfg.SaleDailyLimit < 0 {
+		panic("SALE_DAILY_LIMIT must be >= 0")
+	}
+	if cfg.SaleTaxBP < 0 || cfg.SaleTaxBP > 5_000 {
+		panic("SALE_TAX_BP must be in 0..5000")
+	}
+	if cfg.SaleTaxBurnBP < 0 || cfg.SaleTaxBurnBP > 10_000 {
+		panic("SALE_TAX_BURN_BP must be in 0..10000")
+	}
+	if cfg.ReserveForecastWindowDays <= 0 || cfg.ReserveForecastWindowDays > 90 {
+		panic("RESERVE_FORECAST_WINDOW_DAYS must be in 1..90")
+	}
+	if cfg.ReserveRunwayAlertDays < 0 {
+		panic("RESERVE_RUNWAY_ALERT_DAYS must be >= 0")
+	}
+	for i, xp := range cfg.LevelXPThresholds {
+		if xp <= 0 || (i > 0 && xp <= cfg.LevelXPThresholds[i-1]) {
+			panic("LEVEL_XP_THRESHOLDS must be positive and increasing")
+		}
+	}
+	if cfg.LevelUpReward < 0 || cfg.XPPerTap < 0 || cfg.XPPerPurchase < 0 || cfg.XPPerGameWin < 0 {
+		panic("LEVEL_UP_REWARD and XP_PER_* must be >= 0")
+	}
 	if cfg.ExtraTapsPackSize < 0 || cfg.ExtraTapsPackPriceCoins < 0 {
 		panic("EXTRA_TAPS_* must be >= 0")
 	}
+	if cfg.NFTStakeDailyReward < 0 || cfg.NFTStakeLockDays < 0 {
+		panic("NFT_STAKE_* must be >= 0")
+	}
+	if cfg.NFTMarketFeeBP < 0 || cfg.NFTMarketFeeBP > 5_000 {
+		panic("NFT_MARKET_FEE_BP must be in 0..5000")
+	}
+	if cfg.NFTRentalFeeBP < 0 || cfg.NFTRentalFeeBP > 5_000 {
+		panic("NFT_RENTAL_FEE_BP must be in 0..5000")
+	}
+	if cfg.NFTRentalMaxDays <= 0 {
+		panic("NFT_RENTAL_MAX_DAYS must be > 0")
+	}
+	if cfg.PromoCostPerImpression <= 0 {
+		panic("PROMO_COST_PER_IMPRESSION must be > 0")
+	}
+	if cfg.TransferDailyVolume < 0 || cfg.TransferDailyCounterparties < 0 || cfg.NewAccountCooldownHours < 0 {
+		panic("TRANSFER_DAILY_* and NEW_ACCOUNT_COOLDOWN_HOURS must be >= 0")
+	}
+	if cfg.WebhookMaxPerUser < 0 || cfg.WebhookMaxAttempts < 1 {
+		panic("WEBHOOK_MAX_PER_USER must be >= 0 and WEBHOOK_MAX_ATTEMPTS >= 1")
+	}
+	if cfg.APIKeyMaxPerUser < 0 || cfg.APIKeyDefaultRate <= 0 || cfg.APIKeyMaxRate < cfg.APIKeyDefaultRate {
+		panic("API_KEY_MAX_PER_USER must be >= 0, API_KEY_DEFAULT_RATE > 0 and <= API_KEY_MAX_RATE")
+	}
+	if cfg.Environment == "" {
+		cfg.Environment = "production"
+	}
+	if cfg.FaultInjection && cfg.Environment == "production" {
+		panic("FAULT_INJECTION is not allowed with APP_ENV=production")
+	}
+	switch cfg.DBDriver {
+	case "":
+		cfg.DBDriver = "postgres"
+		fallthrough
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			log.Printf("missing env: DATABASE_URL, using default")
+		}
+	case "embedded":
+		if cfg.Environment == "production" {
+			panic("DB_DRIVER=embedded is not allowed with APP_ENV=production")
+		}
+		if cfg.DBEmbeddedDir == "" {
+			cfg.DBEmbeddedDir = ".bkc/pgdata"
+		}
+	default:
+		panic("DB_DRIVER must be postgres or embedded")
+	}
+	if cfg.PublicStatsRatePerMin < 1 || cfg.PublicStatsMaxAge < 0 {
+		panic("PUBLIC_STATS_RATE_PER_MIN must be >= 1 and PUBLIC_STATS_MAX_AGE >= 0")
+	}
+	if cfg.ReservesTONAPIURL == "" {
+		cfg.ReservesTONAPIURL = "https://tonapi.io/v2"
+	}
+	if cfg.ReservesSolanaRPCURL == "" {
+		cfg.ReservesSolanaRPCURL = "https://api.mainnet-beta.solana.com"
+	}
+	if cfg.ReservesSolanaUSDTMint == "" {
+		cfg.ReservesSolanaUSDTMint = "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB"
+	}
+	if cfg.RequestChallengeTTLSeconds < 10 {
+		panic("REQUEST_CHALLENGE_TTL_SECONDS must be >= 10")
+	}
+	if cfg.WSTokenTTLSeconds < 10 {
+		panic("WS_TOKEN_TTL_SECONDS must be >= 10")
+	}
+	if cfg.ReservesIntervalHours < 1 {
+		panic("RESERVES_INTERVAL_HOURS must be >= 1")
+	}
+	if cfg.NotificationMaxAttempts < 1 {
+		panic("NOTIFICATION_MAX_ATTEMPTS must be >= 1")
+	}
+	if cfg.AlertChatID == 0 {
+		cfg.AlertChatID = cfg.AdminID
+	}
+	if cfg.AlertRepeatMinutes <= 0 || cfg.AlertAckMuteHours <= 0 || cfg.AlertPaymentWindowMinutes <= 0 || cfg.AlertHeartbeatMisses < 1 {
+		panic("ALERT_REPEAT_MINUTES, ALERT_ACK_MUTE_HOURS, ALERT_PAYMENT_WINDOW_MINUTES must be > 0 and ALERT_HEARTBEAT_MISSES >= 1")
+	}
+	if cfg.AlertPaymentFailures < 0 || cfg.AlertEconomyHealthMin < 0 || cfg.AlertEconomyHealthMin > 100 {
+		panic("ALERT_PAYMENT_FAILURES must be >= 0 and ALERT_ECONOMY_HEALTH_MIN in 0..100")
+	}
+	if cfg.GameRTPMin < 0 || cfg.GameRTPMax <= cfg.GameRTPMin || cfg.GameRTPMinWagered < 1 {
+		panic("GAME_RTP_MIN must be >= 0, GAME_RTP_MAX > GAME_RTP_MIN and GAME_RTP_MIN_WAGERED >= 1")
+	}
+	if cfg.BetMax < 0 || cfg.BetBalancePercent < 0 || cfg.BetBalancePercent > 100 || cfg.BetNewAccountMax < 0 || cfg.BetNewAccountDays < 0 {
+		panic("BET_MAX, BET_NEW_ACCOUNT_MAX, BET_NEW_ACCOUNT_DAYS must be >= 0 and BET_BALANCE_PERCENT in 0..100")
+	}
+	if cfg.BotWindowHours < 0 || cfg.BotMinBets < 0 || cfg.BotLimitScore < 0 || cfg.BotCaptchaScore < 0 || cfg.BotMaxBet < 0 || cfg.CaptchaValidHours < 0 {
+		panic("BOT_* and CAPTCHA_VALID_HOURS must be >= 0")
+	}
+	if cfg.AffiliateMinPayout < 1 {
+		panic("AFFILIATE_MIN_PAYOUT must be >= 1")
+	}
+	if cfg.GameCreditsBuyRate < 1 || cfg.GameCreditsSellRate < cfg.GameCreditsBuyRate || cfg.GameCreditsSellDailyLimit < 0 {
+		panic("GAME_CREDITS_BUY_RATE must be >= 1, GAME_CREDITS_SELL_RATE >= GAME_CREDITS_BUY_RATE and GAME_CREDITS_SELL_DAILY_LIMIT >= 0")
+	}
+	if cfg.GiftMinAmount < 1 || cfg.GiftMaxAmount < cfg.GiftMinAmount || cfg.GiftTTLHours < 1 {
+		panic("GIFT_MIN_AMOUNT and GIFT_TTL_HOURS must be >= 1 and GIFT_MAX_AMOUNT >= GIFT_MIN_AMOUNT")
+	}
+	if cfg.BillMaxParticipants < 1 || cfg.BillMaxDays < 1 {
+		panic("BILL_MAX_PARTICIPANTS and BILL_MAX_DAYS must be >= 1")
+	}
+	if cfg.MerchantFeeBP < 0 || cfg.MerchantFeeBP > 1_000 || cfg.MerchantOrderTTLMinutes < 1 {
+		panic("MERCHANT_FEE_BP must be in 0..1000 and MERCHANT_ORDER_TTL_MINUTES >= 1")
+	}
+	if cfg.TipFeeFreeMax < 0 || cfg.TipFeeBP < 0 || cfg.TipFeeBP > 1_000 || cfg.TipMaxAmount < 1 {
+		panic("TIP_FEE_FREE_MAX must be >= 0, TIP_FEE_BP in 0..1000 and TIP_MAX_AMOUNT >= 1")
+	}
+	if cfg.CreatorWidgetRatePerMin < 1 {
+		panic("CREATOR_WIDGET_RATE_PER_MIN must be >= 1")
+	}
+	if cfg.GigMaxMilestones < 1 || cfg.GigReviewDays < 1 {
+		panic("GIG_MAX_MILESTONES and GIG_REVIEW_DAYS must be >= 1")
+	}
+	if cfg.ModerationNudityMaxPct < 0 || cfg.ModerationNudityMaxPct > 100 || cfg.ModerationPriceAnomalyX < 0 || cfg.ModerationScreenDelayMinutes < 0 ||
+		cfg.ModerationOffenderRejects < 0 || cfg.ModerationOffenderDays < 1 || cfg.ModerationOffenderCooldownHours < 0 {
+		panic("MODERATION_NUDITY_MAX_PCT must be in 0..100, MODERATION_OFFENDER_DAYS >= 1 and the other MODERATION_* values >= 0")
+	}
+	if cfg.PriceSuggestionDays < 1 || cfg.PriceAboveMarketPct < 0 {
+		panic("PRICE_SUGGESTION_DAYS must be >= 1 and PRICE_ABOVE_MARKET_PCT >= 0")
+	}
+	if cfg.CaptchaVerifyURL == "" {
+		cfg.CaptchaVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	}
+	if cfg.ClickHouseDatabase == "" {
+		cfg.ClickHouseDatabase = "bkc"
+	}
+	if cfg.AnalyticsBatch <= 0 {
+		panic("ANALYTICS_BATCH must be > 0")
+	}
+	if cfg.AnalyticsSampleBP < 0 || cfg.AnalyticsSampleBP > 10_000 {
+		panic("ANALYTICS_SAMPLE_BP must be in 0..10000")
+	}
+	if cfg.PatternSmallTransfer < 0 || cfg.PatternFanInMin < 0 {
+		panic("PATTERN_* must be >= 0")
+	}
+	if cfg.VelocityKYCMultiplier < 1 {
+		panic("VELOCITY_KYC_MULTIPLIER must be >= 1")
+	}
+	if cfg.StepUpTransferCoins <= 0 {
+		panic("STEP_UP_TRANSFER_COINS must be > 0")
+	}
+	if cfg.WithdrawRiskHoldScore <= 0 || cfg.WithdrawRiskLargeAmount <= 0 {
+		panic("WITHDRAW_RISK_HOLD_SCORE and WITHDRAW_RISK_LARGE_AMOUNT must be > 0")
+	}
+	if cfg.TapDegradeQueueDepth < 0 || cfg.TapShedQueueDepth < 0 || cfg.TapDegradeDBLatencyMs < 0 || cfg.TapShedDBLatencyMs < 0 {
+		panic("TAP_DEGRADE_* and TAP_SHED_* must be >= 0 (0 disables)")
+	}
+	if cfg.P2PConcentrationBP < 0 || cfg.P2PConcentrationBP > 10_000 {
+		panic("P2P_CONCENTRATION_BP must be in 0..10000 (0 disables)")
+	}
+	if cfg.P2PInsurancePremiumBP < 0 || cfg.P2PInsurancePremiumBP > 10_000 || cfg.P2PInsuranceCoverBP < 0 || cfg.P2PInsuranceCoverBP > 10_000 {
+		panic("P2P_INSURANCE_PREMIUM_BP and P2P_INSURANCE_COVER_BP must be in 0..10000 (0 disables)")
+	}
+	if cfg.P2PDefaultGraceDays < 0 {
+		panic("P2P_DEFAULT_GRACE_DAYS must be >= 0")
+	}
+	if cfg.P2PInsureMinRepaid < 0 || cfg.P2PInsureBorrowerCap < 0 || cfg.P2PInsurePairCap < 0 {
+		panic("P2P_INSURE_MIN_REPAID, P2P_INSURE_BORROWER_CAP and P2P_INSURE_PAIR_CAP must be >= 0")
+	}
+	if cfg.DBQueryTimeoutMs < 0 || cfg.DBSlowQueryMs < 0 {
+		panic("DB_QUERY_TIMEOUT_MS and DB_SLOW_QUERY_MS must be >= 0 (0 disables)")
+	}
 
 	return cfg
 }
@@ -158,6 +158,31 @@ func VerifyWebhookSignature(appToken string, rawBody []byte, headerSignature str
 	return hmac.Equal([]byte(expected), []byte(headerSignature))
 }
 
+// ErrBadSignature is a webhook whose signature does not match the body.
+var ErrBadSignature = errors.New("cryptopay: bad webhook signature")
+
+// ParseWebhook verifies and decodes a webhook body. Only signed invoice_paid
+// updates for a known-looking invoice are returned; anything else is an
+// error, so a malformed or forged body never reaches the database.
+func ParseWebhook(appToken string, rawBody []byte, headerSignature string) (WebhookUpdate, error) {
+	if !VerifyWebhookSignature(appToken, rawBody, headerSignature) {
+		return WebhookUpdate{}, ErrBadSignature
+	}
+	var u WebhookUpdate
+	if err := json.Unmarshal(rawBody, &u); err != nil {
+		return WebhookUpdate{}, fmt.Errorf("cryptopay: webhook body: %w", err)
+	}
+	if u.UpdateType != "invoice_paid" {
+		return WebhookUpdate{}, fmt.Errorf("cryptopay: unknown update_type %q", u.UpdateType)
+	}
+	if u.Payload.InvoiceID <= 0 || strings.TrimSpace(u.Payload.Status) == "" {
+		return WebhookUpdate{}, errors.New("cryptopay: webhook without invoice")
+	}
+	return u, nil
+}
+
+// ParseAmountInt returns the whole part of a non-negative amount string, or
+// 0 if it is not one (negative, out of range or not a number).
 func ParseAmountInt(amount string) int64 {
 	amount = strings.TrimSpace(amount)
 	if amount == "" {
@@ -168,6 +193,9 @@ func ParseAmountInt(amount string) int64 {
 		parts := strings.SplitN(amount, ".", 2)
 		amount = parts[0]
 	}
-	n, _ := strconv.ParseInt(amount, 10, 64)
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
 	return n
 }
@@ -0,0 +1,68 @@
+package cryptopay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const fuzzToken = "12345:AAtesttoken"
+
+func sign(token string, body []byte) string {
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run with: go test ./internal/cryptopay -fuzz FuzzParseWebhook
+func FuzzParseWebhook(f *testing.F) {
+	f.Add([]byte(`{"update_id":1,"update_type":"invoice_paid","request_date":"2024-01-01T00:00:00.000Z","payload":{"invoice_id":42,"status":"paid","amount":"10.5"}}`), true)
+	f.Add([]byte(`{"update_type":"invoice_paid","payload":{"invoice_id":-1,"status":"paid"}}`), true)
+	f.Add([]byte(`{"update_type":"invoice_paid","payload":{"invoice_id":1e30}}`), true)
+	f.Add([]byte(`{"update_type":"invoice_paid","payload":"x"}`), false)
+	f.Add([]byte(`[]`), true)
+	f.Add([]byte{}, true)
+	f.Fuzz(func(t *testing.T, body []byte, signed bool) {
+		sig := "0000"
+		if signed {
+			sig = sign(fuzzToken, body)
+		}
+		u, err := ParseWebhook(fuzzToken, body, sig)
+		if !signed && err == nil {
+			t.Fatal("unsigned webhook accepted")
+		}
+		if err != nil {
+			return
+		}
+		if u.UpdateType != "invoice_paid" || u.Payload.InvoiceID <= 0 || strings.TrimSpace(u.Payload.Status) == "" {
+			t.Fatalf("accepted %+v", u)
+		}
+		if ParseAmountInt(u.Payload.Amount) < 0 {
+			t.Fatalf("negative amount from %q", u.Payload.Amount)
+		}
+		// The same body under another token is forged.
+		if _, err := ParseWebhook(fuzzToken+"x", body, sig); err == nil {
+			t.Fatal("webhook accepted under another token")
+		}
+	})
+}
+
+func FuzzParseAmountInt(f *testing.F) {
+	for _, s := range []string{"10", "10.99", " 7 ", "-5", "99999999999999999999", "1e3", ".5", "", "0x10"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		n := ParseAmountInt(s)
+		if n < 0 {
+			t.Fatalf("%q: %d", s, n)
+		}
+		whole, _, _ := strings.Cut(strings.TrimSpace(s), ".")
+		if want, err := strconv.ParseInt(whole, 10, 64); err == nil && want >= 0 && n != want {
+			t.Fatalf("%q: %d, want %d", s, n, want)
+		}
+	})
+}
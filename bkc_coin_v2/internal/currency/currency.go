@@ -0,0 +1,132 @@
+// Package currency holds the canonical decimals of BKC and of the assets
+// payments accept, and converts between display amounts and integer base
+// units (nanotons, micro-USDT). Amounts cross into base units here and
+// nowhere else, so a chain URL or a balance never gets the wrong exponent.
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Asset is a currency with its decimals: one display unit is 10^Decimals
+// base units.
+type Asset struct {
+	Code     string `json:"code"`
+	Decimals int    `json:"decimals"`
+	BaseUnit string `json:"base_unit"`
+}
+
+var (
+	// BKC balances are whole coins.
+	BKC = Asset{Code: "BKC", Decimals: 0, BaseUnit: "BKC"}
+	// TON amounts on chain are nanotons.
+	TON = Asset{Code: "TON", Decimals: 9, BaseUnit: "nanoton"}
+	// USDT has 6 decimals both as a TON jetton and as a Solana SPL token.
+	USDT = Asset{Code: "USDT", Decimals: 6, BaseUnit: "micro-USDT"}
+)
+
+// Assets lists every known asset.
+var Assets = []Asset{BKC, TON, USDT}
+
+// Lookup finds an asset by code, case-insensitively.
+func Lookup(code string) (Asset, bool) {
+	for _, a := range Assets {
+		if strings.EqualFold(a.Code, code) {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// ForChain is the asset paid on a payment chain: ton, ton_usdt or solana_usdt.
+func ForChain(chain string) (Asset, bool) {
+	switch chain {
+	case "ton":
+		return TON, true
+	case "ton_usdt", "solana_usdt":
+		return USDT, true
+	}
+	return Asset{}, false
+}
+
+// scale is 10^Decimals.
+func (a Asset) scale() int64 {
+	s := int64(1)
+	for i := 0; i < a.Decimals; i++ {
+		s *= 10
+	}
+	return s
+}
+
+// Parse reads a non-negative decimal display amount ("1.5") into base
+// units. More fractional digits than the asset has is an error, not a
+// rounding.
+func (a Asset) Parse(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("bad %s amount %q", a.Code, s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if strings.TrimLeft(whole, "0123456789") != "" || strings.TrimLeft(frac, "0123456789") != "" {
+		return 0, fmt.Errorf("bad %s amount %q", a.Code, s)
+	}
+	if len(frac) > a.Decimals {
+		return 0, fmt.Errorf("bad %s amount %q: at most %d decimals", a.Code, s, a.Decimals)
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/a.scale() {
+		return 0, fmt.Errorf("bad %s amount %q: too large", a.Code, s)
+	}
+	var f int64
+	if frac != "" {
+		f, _ = strconv.ParseInt(frac+strings.Repeat("0", a.Decimals-len(frac)), 10, 64)
+	}
+	units := w*a.scale() + f
+	if units < 0 {
+		return 0, fmt.Errorf("bad %s amount %q: too large", a.Code, s)
+	}
+	return units, nil
+}
+
+// Format writes base units as a display amount with no trailing zeros:
+// TON.Format(1_500_000_000) is "1.5".
+func (a Asset) Format(units int64) string {
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	s := strconv.FormatInt(units, 10)
+	if a.Decimals == 0 {
+		return sign + s
+	}
+	if len(s) <= a.Decimals {
+		s = strings.Repeat("0", a.Decimals-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-a.Decimals], strings.TrimRight(s[len(s)-a.Decimals:], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// FromFloat converts a float display amount, as older APIs carry it, to
+// base units, rounding to the nearest unit: int64(0.3*1e9) would be one
+// nanoton short.
+func (a Asset) FromFloat(v float64) (int64, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+		return 0, errors.New("bad " + a.Code + " amount")
+	}
+	return a.Parse(strconv.FormatFloat(v, 'f', a.Decimals, 64))
+}
+
+// ToFloat converts base units to a float display amount, for older APIs.
+func (a Asset) ToFloat(units int64) float64 {
+	return float64(units) / float64(a.scale())
+}
@@ -0,0 +1,75 @@
+package currency
+
+import "testing"
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		a     Asset
+		in    string
+		units int64
+		out   string
+	}{
+		{TON, "1.5", 1_500_000_000, "1.5"},
+		{TON, "0.000000001", 1, "0.000000001"},
+		{TON, ".25", 250_000_000, "0.25"},
+		{TON, "12", 12_000_000_000, "12"},
+		{USDT, "10.000001", 10_000_001, "10.000001"},
+		{USDT, "0.10", 100_000, "0.1"},
+		{BKC, "1000", 1000, "1000"},
+	}
+	for _, c := range cases {
+		got, err := c.a.Parse(c.in)
+		if err != nil || got != c.units {
+			t.Fatalf("%s.Parse(%q) = %d, %v; want %d", c.a.Code, c.in, got, err, c.units)
+		}
+		if s := c.a.Format(got); s != c.out {
+			t.Fatalf("%s.Format(%d) = %q, want %q", c.a.Code, got, s, c.out)
+		}
+	}
+	for _, bad := range []struct {
+		a  Asset
+		in string
+	}{
+		{USDT, "1.0000001"}, // 7 decimals
+		{BKC, "1.5"},
+		{TON, "-1"},
+		{TON, "1e9"},
+		{TON, ""},
+		{TON, "."},
+		{TON, "9223372037"}, // overflows int64 nanotons
+	} {
+		if v, err := bad.a.Parse(bad.in); err == nil {
+			t.Errorf("%s.Parse(%q) = %d, want error", bad.a.Code, bad.in, v)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	// int64(0.3 * 1e9) is 299999999: the rounding is the point.
+	if got, err := TON.FromFloat(0.3); err != nil || got != 300_000_000 {
+		t.Fatalf("TON.FromFloat(0.3) = %d, %v", got, err)
+	}
+	if got, err := USDT.FromFloat(19.99); err != nil || got != 19_990_000 {
+		t.Fatalf("USDT.FromFloat(19.99) = %d, %v", got, err)
+	}
+	if _, err := TON.FromFloat(-1); err == nil {
+		t.Fatal("negative amount accepted")
+	}
+	if f := USDT.ToFloat(2_500_000); f != 2.5 {
+		t.Fatalf("USDT.ToFloat = %v", f)
+	}
+}
+
+func TestForChain(t *testing.T) {
+	for chain, want := range map[string]Asset{"ton": TON, "ton_usdt": USDT, "solana_usdt": USDT} {
+		if a, ok := ForChain(chain); !ok || a != want {
+			t.Errorf("ForChain(%q) = %v, %v", chain, a, ok)
+		}
+	}
+	if _, ok := ForChain("btc"); ok {
+		t.Error("btc is not a payment chain")
+	}
+	if a, ok := Lookup("usdt"); !ok || a != USDT {
+		t.Errorf("Lookup(usdt) = %v, %v", a, ok)
+	}
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Property tests for the accounting invariants. Random sequences of
+// transfers, credits, burns, bank loans, freezes and gig escrow run through
+// the real DB layer next to a model of what every user should hold; each
+// operation must succeed exactly when the model can afford it. After every
+// sequence the database must agree with the model and
+//   - no balance, frozen balance or the reserve is negative,
+//   - user holdings + escrow + reserve moved only by what was burned,
+//   - each user's balance moved exactly by their ledger rows.
+//
+// A failure prints its seed; BKC_PROP_SEED=<seed> replays the same run.
+
+const (
+	propBase      = 9_300_600_000
+	propUsers     = 4 // the last one sells the gig
+	propSeed      = 500
+	propSequences = 20
+	propSteps     = 40
+)
+
+type propEscrow struct {
+	buyer, milestoneID, amount int64
+}
+
+type propRun struct {
+	t       *testing.T
+	d       *DB
+	rng     *rand.Rand
+	ids     []int64
+	listing int64
+
+	balance, frozen map[int64]int64
+	loans           map[int64]BankLoan
+	escrow          []propEscrow
+	reserve, burned int64 // reserve delta, coins burned
+	log             []string
+}
+
+func (p *propRun) user() int64   { return p.ids[p.rng.IntN(len(p.ids))] }
+func (p *propRun) buyer() int64  { return p.ids[p.rng.IntN(len(p.ids)-1)] }
+func (p *propRun) seller() int64 { return p.ids[len(p.ids)-1] }
+
+func (p *propRun) fatalf(format string, args ...any) {
+	p.t.Helper()
+	tail := p.log[max(0, len(p.log)-10):]
+	p.t.Fatalf("%s\nlast operations:\n  %s", fmt.Sprintf(format, args...), strings.Join(tail, "\n  "))
+}
+
+// expect checks that op succeeded exactly when the model said it could.
+func (p *propRun) expect(op string, ok bool, err error) bool {
+	p.t.Helper()
+	p.log = append(p.log, fmt.Sprintf("%s -> %v", op, err))
+	switch {
+	case ok && err != nil:
+		p.fatalf("%s: %v, model allows it", op, err)
+	case !ok && err == nil:
+		p.fatalf("%s succeeded, model refuses it", op)
+	case !ok && !errors.Is(err, ErrNotEnough) && !errors.Is(err, ErrAlreadyExists):
+		p.fatalf("%s: %v", op, err)
+	}
+	return ok
+}
+
+func (p *propRun) step(ctx context.Context) {
+	p.t.Helper()
+	switch p.rng.IntN(10) {
+	case 0: // credit from the reserve
+		u, a := p.user(), 1+p.rng.Int64N(500)
+		if p.expect(fmt.Sprintf("credit %d %d", u, a), true, p.d.CreditFromReserve(ctx, u, a, "prop_credit", nil)) {
+			p.balance[u] += a
+			p.reserve -= a
+		}
+	case 1, 2: // transfer
+		from, to, a := p.user(), p.user(), 1+p.rng.Int64N(400)
+		if from == to {
+			return
+		}
+		if p.expect(fmt.Sprintf("transfer %d->%d %d", from, to, a), p.balance[from] >= a, p.d.Transfer(ctx, from, to, a, VelocityPolicy{})) {
+			p.balance[from] -= a
+			p.balance[to] += a
+		}
+	case 3: // burn
+		u, a := p.user(), 1+p.rng.Int64N(300)
+		if p.expect(fmt.Sprintf("burn %d %d", u, a), p.balance[u] >= a, p.d.Burn(ctx, u, a, "prop", nil)) {
+			p.balance[u] -= a
+			p.burned += a
+		}
+	case 4: // freeze or unfreeze
+		u, a := p.user(), 1+p.rng.Int64N(300)
+		if p.rng.IntN(2) == 0 {
+			if p.expect(fmt.Sprintf("freeze %d %d", u, a), p.balance[u] >= a, p.d.FreezeBalance(ctx, u, a)) {
+				p.balance[u] -= a
+				p.frozen[u] += a
+			}
+			return
+		}
+		if p.expect(fmt.Sprintf("unfreeze %d %d", u, a), p.frozen[u] >= a, p.d.UnfreezeBalance(ctx, u, a)) {
+			p.balance[u] += a
+			p.frozen[u] -= a
+		}
+	case 5: // bank loan or repayment
+		u := p.user()
+		if l, ok := p.loans[u]; ok {
+			if p.expect(fmt.Sprintf("repay %d loan %d", u, l.LoanID), p.balance[u] >= l.TotalDue, p.d.RepayBankLoan(ctx, u, l.LoanID)) {
+				p.balance[u] -= l.TotalDue
+				p.reserve += l.TotalDue
+				delete(p.loans, u)
+			}
+			return
+		}
+		principal := 100 + p.rng.Int64N(900)
+		l, err := p.d.CreateBankLoan(ctx, u, principal, 500, 7)
+		if p.expect(fmt.Sprintf("loan %d %d", u, principal), true, err) {
+			p.balance[u] += principal
+			p.reserve -= principal
+			p.loans[u] = l
+		}
+	case 6, 7: // hire the seller and fund a milestone into escrow
+		b, a := p.buyer(), 1+p.rng.Int64N(400)
+		gig, err := p.d.CreateGig(ctx, b, p.listing, []GigMilestoneInput{{Title: "prop", Amount: a, Deadline: time.Now().Add(24 * time.Hour)}}, 3)
+		if err != nil {
+			p.fatalf("gig %d: %v", b, err)
+		}
+		m := gig.Milestones[0].MilestoneID
+		_, err = p.d.FundGigMilestone(ctx, b, m)
+		if p.expect(fmt.Sprintf("fund %d milestone %d %d", b, m, a), p.balance[b] >= a, err) {
+			p.balance[b] -= a
+			p.escrow = append(p.escrow, propEscrow{b, m, a})
+		}
+	case 8, 9: // settle a funded milestone: release it or split it by dispute
+		if len(p.escrow) == 0 {
+			return
+		}
+		i := p.rng.IntN(len(p.escrow))
+		e := p.escrow[i]
+		p.escrow = slices.Delete(p.escrow, i, i+1)
+		toSeller := e.amount
+		if p.rng.IntN(2) == 0 {
+			_, err := p.d.ReleaseGigMilestone(ctx, e.buyer, e.milestoneID)
+			p.expect(fmt.Sprintf("release milestone %d", e.milestoneID), true, err)
+		} else {
+			dp, err := p.d.DisputeGigMilestone(ctx, e.buyer, e.milestoneID, "prop")
+			p.expect(fmt.Sprintf("dispute milestone %d", e.milestoneID), true, err)
+			bp := 1 + p.rng.Int64N(9_999)
+			_, err = p.d.ResolveDispute(ctx, 1, dp.DisputeID, DisputeSplit, bp, "")
+			p.expect(fmt.Sprintf("split milestone %d at %d bp", e.milestoneID, bp), true, err)
+			toSeller, _ = disputeSellerShare(e.amount, DisputeSplit, bp)
+		}
+		p.balance[p.seller()] += toSeller
+		p.balance[e.buyer] += e.amount - toSeller
+	}
+}
+
+type propTotals struct {
+	holdings, escrow, reserve, reserved, totalSupply int64
+}
+
+func (p *propRun) totals(ctx context.Context) propTotals {
+	p.t.Helper()
+	var s propTotals
+	if err := p.d.Pool.QueryRow(ctx, `
+SELECT (SELECT COALESCE(SUM(balance + frozen_balance), 0) FROM users WHERE user_id = ANY($1)),
+       (SELECT COALESCE(SUM(m.amount), 0) FROM gig_milestones m JOIN gig_contracts c ON c.contract_id = m.contract_id
+        WHERE c.buyer_id = ANY($1) AND m.status IN ('funded','delivered','disputed')),
+       reserve_supply, reserved_supply, total_supply
+FROM system_state WHERE id=1
+`, p.ids).Scan(&s.holdings, &s.escrow, &s.reserve, &s.reserved, &s.totalSupply); err != nil {
+		p.t.Fatal(err)
+	}
+	return s
+}
+
+// check compares the database with the model and the invariants.
+func (p *propRun) check(ctx context.Context, start propTotals) {
+	p.t.Helper()
+	for _, id := range p.ids {
+		u, err := p.d.GetUser(ctx, id)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		if u.Balance != p.balance[id] || u.FrozenBalance != p.frozen[id] {
+			p.fatalf("user %d: balance %d frozen %d, model %d and %d", id, u.Balance, u.FrozenBalance, p.balance[id], p.frozen[id])
+		}
+		if u.Balance < 0 || u.FrozenBalance < 0 {
+			p.fatalf("user %d: balance %d frozen %d", id, u.Balance, u.FrozenBalance)
+		}
+		checkLedger(p.t, p.d, id, propSeed)
+	}
+	end := p.totals(ctx)
+	var escrow int64
+	for _, e := range p.escrow {
+		escrow += e.amount
+	}
+	if end.escrow != escrow {
+		p.fatalf("escrow %d, model %d", end.escrow, escrow)
+	}
+	if end.reserve < 0 || end.reserved != start.reserved {
+		p.fatalf("reserve %d reserved %d (was %d)", end.reserve, end.reserved, start.reserved)
+	}
+	if end.reserve-start.reserve != p.reserve || start.totalSupply-end.totalSupply != p.burned {
+		p.fatalf("reserve moved %d, supply %d; model %d and %d", end.reserve-start.reserve, end.totalSupply-start.totalSupply, p.reserve, -p.burned)
+	}
+	moved := (end.holdings + end.escrow + end.reserve) - (start.holdings + start.escrow + start.reserve)
+	if moved != end.totalSupply-start.totalSupply {
+		p.fatalf("coins not conserved: holdings+escrow+reserve moved %d, supply %d", moved, end.totalSupply-start.totalSupply)
+	}
+	vs, err := p.d.CheckInvariants(ctx)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	for _, v := range vs {
+		// Only the checks these operations can break; the rest may trip on
+		// other tests' leftovers.
+		switch v.Name {
+		case "reserve_bounds", "frozen_balance_non_negative", "ledger_amount_non_negative", "gig_escrow_booked":
+			p.fatalf("invariant %s: %d (%s)", v.Name, v.Count, v.Sample)
+		}
+	}
+}
+
+func TestAccountingProperties(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	seed := uint64(time.Now().UnixNano())
+	if s := os.Getenv("BKC_PROP_SEED"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed = v
+	}
+	t.Logf("BKC_PROP_SEED=%d", seed)
+	rng := rand.New(rand.NewPCG(seed, 0))
+
+	sys := moneySystem(t, d)
+	if err := d.SetDailyEmissionCap(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.SetDailyEmissionCap(context.Background(), sys.DailyEmissionCap) })
+
+	ids := make([]int64, propUsers)
+	for i := range ids {
+		ids[i] = propBase + int64(i)
+	}
+	sequences := propSequences
+	if testing.Short() {
+		sequences = 3
+	}
+	for seq := 0; seq < sequences; seq++ {
+		seedMoneyUsers(t, d, propSeed, ids...)
+		p := &propRun{
+			t: t, d: d, rng: rng, ids: ids,
+			balance: map[int64]int64{}, frozen: map[int64]int64{}, loans: map[int64]BankLoan{},
+			log: []string{fmt.Sprintf("seed %d sequence %d", seed, seq)},
+		}
+		for _, id := range ids {
+			p.balance[id] = propSeed
+		}
+		if err := d.Pool.QueryRow(ctx, `
+INSERT INTO market_listings(seller_id, title, description, category, price_coins, contact)
+VALUES($1, 'prop gig', 'prop', $2, 100, '@prop')
+RETURNING listing_id
+`, p.seller(), ServicesCategory).Scan(&p.listing); err != nil {
+			t.Fatal(err)
+		}
+		start := p.totals(ctx)
+		for step := 0; step < propSteps; step++ {
+			p.step(ctx)
+		}
+		p.check(ctx, start)
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// The activity feed is the part of user_events about a user's deals: P2P
+// loans, offers on their NFTs, gig escrow and disputes. It reads the same
+// events as the notification feed, so a change shows up in both in the
+// transaction that made it; reading it marks only its own events read.
+
+// Activity sections.
+const (
+	ActivityLoans    = "loans"
+	ActivityOffers   = "offers"
+	ActivityEscrow   = "escrow"
+	ActivityDisputes = "disputes"
+)
+
+// activityKinds are the user event kinds in the feed, by section.
+var activityKinds = map[string][]string{
+	ActivityLoans: {"p2p_loan_requested", "p2p_loan_taken", "p2p_loan_accepted", "p2p_loan_rejected", "p2p_loan_repaid",
+		"p2p_loan_recalled", "p2p_loan_defaulted"},
+	ActivityOffers: {"nft_offer_received", "nft_offer_countered", "nft_offer_accepted", "nft_offer_declined",
+		"nft_offer_cancelled", "nft_offer_expired"},
+	ActivityEscrow:   {"gig_funded", "gig_delivered", "gig_settled"},
+	ActivityDisputes: {"gig_disputed", "dispute_resolved"},
+}
+
+// activitySection returns the section of an event kind, "" if it is not
+// in the feed.
+func activitySection(kind string) string {
+	for s, kinds := range activityKinds {
+		if slices.Contains(kinds, kind) {
+			return s
+		}
+	}
+	return ""
+}
+
+// activityKindsOf returns the kinds of section, every feed kind for "".
+func activityKindsOf(section string) ([]string, error) {
+	if section != "" {
+		kinds, ok := activityKinds[section]
+		if !ok {
+			return nil, errors.New("bad section")
+		}
+		return kinds, nil
+	}
+	var all []string
+	for _, kinds := range activityKinds {
+		all = append(all, kinds...)
+	}
+	slices.Sort(all)
+	return all, nil
+}
+
+// ActivityItem is an event in the activity feed.
+type ActivityItem struct {
+	UserEvent
+	Section string `json:"section"`
+}
+
+// ActivityUnread counts unread feed events by section.
+type ActivityUnread struct {
+	Loans    int64 `json:"loans"`
+	Offers   int64 `json:"offers"`
+	Escrow   int64 `json:"escrow"`
+	Disputes int64 `json:"disputes"`
+	Total    int64 `json:"total"`
+}
+
+func (u *ActivityUnread) add(section string, n int64) {
+	switch section {
+	case ActivityLoans:
+		u.Loans += n
+	case ActivityOffers:
+		u.Offers += n
+	case ActivityEscrow:
+		u.Escrow += n
+	case ActivityDisputes:
+		u.Disputes += n
+	default:
+		return
+	}
+	u.Total += n
+}
+
+// ListActivity returns the user's feed events older than beforeID (0 = the
+// newest), newest first; section narrows it to one section.
+func (d *DB) ListActivity(ctx context.Context, userID int64, section string, beforeID, limit int64) ([]ActivityItem, error) {
+	kinds, err := activityKindsOf(section)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT event_id, user_id, kind, payload, read_at, created_at
+FROM user_events
+WHERE user_id=$1 AND kind = ANY($2) AND ($3::bigint = 0 OR event_id < $3)
+ORDER BY event_id DESC
+LIMIT $4
+`, userID, kinds, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ActivityItem{}
+	for rows.Next() {
+		var it ActivityItem
+		if err := rows.Scan(&it.EventID, &it.UserID, &it.Kind, &it.Payload, &it.ReadAt, &it.CreatedAt); err != nil {
+			return nil, err
+		}
+		it.Section = activitySection(it.Kind)
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// CountUnreadActivity returns the unread feed events of the user.
+func (d *DB) CountUnreadActivity(ctx context.Context, userID int64) (ActivityUnread, error) {
+	kinds, _ := activityKindsOf("")
+	rows, err := d.Pool.Query(ctx, `
+SELECT kind, COUNT(*)
+FROM user_events
+WHERE user_id=$1 AND read_at IS NULL AND kind = ANY($2)
+GROUP BY kind
+`, userID, kinds)
+	if err != nil {
+		return ActivityUnread{}, err
+	}
+	defer rows.Close()
+	var out ActivityUnread
+	for rows.Next() {
+		var kind string
+		var n int64
+		if err := rows.Scan(&kind, &n); err != nil {
+			return ActivityUnread{}, err
+		}
+		out.add(activitySection(kind), n)
+	}
+	return out, rows.Err()
+}
+
+// MarkActivityRead marks the feed events up to and including upToID read,
+// those of section only if it is set, and returns how many it marked.
+func (d *DB) MarkActivityRead(ctx context.Context, userID int64, section string, upToID int64) (int64, error) {
+	kinds, err := activityKindsOf(section)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE user_events SET read_at=now()
+WHERE user_id=$1 AND kind = ANY($2) AND event_id <= $3 AND read_at IS NULL
+`, userID, kinds, upToID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestActivityKinds(t *testing.T) {
+	seen := map[string]string{}
+	for section, kinds := range activityKinds {
+		for _, k := range kinds {
+			if other, ok := seen[k]; ok {
+				t.Fatalf("%s in %s and %s", k, section, other)
+			}
+			seen[k] = section
+			if activitySection(k) != section {
+				t.Fatalf("%s: section %q", k, activitySection(k))
+			}
+		}
+	}
+	if activitySection("tip_received") != "" {
+		t.Fatal("tips are not activity")
+	}
+	if _, err := activityKindsOf("tips"); err == nil {
+		t.Fatal("unknown section accepted")
+	}
+	if all, _ := activityKindsOf(""); len(all) != len(seen) {
+		t.Fatalf("all sections: %d kinds, want %d", len(all), len(seen))
+	}
+}
+
+func TestActivityFeed(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const borrower, lender = 9_300_900_001, 9_300_900_002
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM p2p_loans WHERE borrower_id=$1`, borrower)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, borrower, lender)
+	t.Cleanup(cleanup)
+
+	loan, err := d.CreateP2PLoanRequest(ctx, borrower, lender, 100, 1_000, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := d.ListActivity(ctx, lender, "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != "p2p_loan_requested" || items[0].Section != ActivityLoans {
+		t.Fatalf("lender feed: %+v", items)
+	}
+	if u, err := d.CountUnreadActivity(ctx, lender); err != nil || u.Loans != 1 || u.Total != 1 {
+		t.Fatalf("unread %+v, %v", u, err)
+	}
+	if items, err := d.ListActivity(ctx, lender, ActivityOffers, 0, 10); err != nil || len(items) != 0 {
+		t.Fatalf("offers: %+v, %v", items, err)
+	}
+
+	if err := d.RejectP2PLoan(ctx, lender, loan.LoanID); err != nil {
+		t.Fatal(err)
+	}
+	items, err = d.ListActivity(ctx, borrower, ActivityLoans, 0, 10)
+	if err != nil || len(items) != 1 || items[0].Kind != "p2p_loan_rejected" {
+		t.Fatalf("borrower feed: %+v, %v", items, err)
+	}
+
+	if n, err := d.MarkActivityRead(ctx, lender, "", items[0].EventID); err != nil || n != 1 {
+		t.Fatalf("marked %d, %v", n, err)
+	}
+	if u, err := d.CountUnreadActivity(ctx, lender); err != nil || u.Total != 0 {
+		t.Fatalf("unread after read: %+v, %v", u, err)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Admin permissions. A super admin has all of them and also manages admins.
+const (
+	PermApproveDeposits  = "approve_deposits"
+	PermResolveDisputes  = "resolve_disputes"
+	PermMint             = "mint"
+	PermConfigureEconomy = "configure_economy"
+	PermModerateListings = "moderate_listings"
+)
+
+var AdminPermissions = []string{PermApproveDeposits, PermResolveDisputes, PermMint, PermConfigureEconomy, PermModerateListings}
+
+type Admin struct {
+	UserID      int64     `json:"user_id"`
+	Permissions []string  `json:"permissions"`
+	Super       bool      `json:"super"`
+	GrantedBy   *int64    `json:"granted_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Can reports whether the admin holds perm; "" asks for super admin.
+func (a Admin) Can(perm string) bool {
+	return a.Super || (perm != "" && slices.Contains(a.Permissions, perm))
+}
+
+const adminCols = `user_id, permissions, super, granted_by, created_at, updated_at`
+
+func scanAdmin(row pgx.Row) (Admin, error) {
+	var a Admin
+	err := row.Scan(&a.UserID, &a.Permissions, &a.Super, &a.GrantedBy, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// EnsureSuperAdmin makes the configured admin a super admin, so the first
+// deployment with the admins table keeps working.
+func (d *DB) EnsureSuperAdmin(ctx context.Context, userID int64) error {
+	if userID <= 0 {
+		return nil
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO admins(user_id, super) VALUES($1, true)
+ON CONFLICT (user_id) DO UPDATE SET super=true, updated_at=now() WHERE NOT admins.super
+`, userID)
+	return err
+}
+
+// GetAdmin returns pgx.ErrNoRows for users who are not admins.
+func (d *DB) GetAdmin(ctx context.Context, userID int64) (Admin, error) {
+	return scanAdmin(d.Pool.QueryRow(ctx, `SELECT `+adminCols+` FROM admins WHERE user_id=$1`, userID))
+}
+
+// AdminCan reports whether userID is an admin holding perm ("" = super admin).
+func (d *DB) AdminCan(ctx context.Context, userID int64, perm string) (bool, error) {
+	a, err := d.GetAdmin(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return a.Can(perm), nil
+}
+
+func (d *DB) ListAdmins(ctx context.Context) ([]Admin, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT `+adminCols+` FROM admins ORDER BY super DESC, user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Admin
+	for rows.Next() {
+		a, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// SetAdmin grants userID exactly perms (and super admin if super). The last
+// super admin cannot be demoted.
+func (d *DB) SetAdmin(ctx context.Context, byID, userID int64, perms []string, super bool) (Admin, error) {
+	if userID <= 0 {
+		return Admin{}, errors.New("bad user_id")
+	}
+	perms = dedupe(perms)
+	for _, p := range perms {
+		if !slices.Contains(AdminPermissions, p) {
+			return Admin{}, errors.New("bad permission")
+		}
+	}
+	var out Admin
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE user_id=$1)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return pgx.ErrNoRows
+		}
+		if !super {
+			if err := keepLastSuperAdmin(ctx, tx, userID); err != nil {
+				return err
+			}
+		}
+		var err error
+		out, err = scanAdmin(tx.QueryRow(ctx, `
+INSERT INTO admins(user_id, permissions, super, granted_by) VALUES($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE SET permissions=EXCLUDED.permissions, super=EXCLUDED.super, granted_by=EXCLUDED.granted_by, updated_at=now()
+RETURNING `+adminCols, userID, perms, super, byID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_set', $1, $2, 0, $3::jsonb)`,
+			byID, userID, toJSON(map[string]any{"permissions": perms, "super": super}))
+		return err
+	})
+	return out, err
+}
+
+// RemoveAdmin revokes all admin rights of userID.
+func (d *DB) RemoveAdmin(ctx context.Context, byID, userID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := keepLastSuperAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM admins WHERE user_id=$1`, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_remove', $1, $2, 0, '{}'::jsonb)`, byID, userID)
+		return err
+	})
+}
+
+// keepLastSuperAdmin fails if userID is the only super admin. The super
+// admin rows stay locked until the transaction ends, so two concurrent
+// demotions cannot both pass.
+func keepLastSuperAdmin(ctx context.Context, tx pgx.Tx, userID int64) error {
+	rows, err := tx.Query(ctx, `SELECT user_id FROM admins WHERE super ORDER BY user_id FOR UPDATE`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var supers []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		supers = append(supers, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(supers) == 1 && supers[0] == userID {
+		return errors.New("bad request: cannot demote the last super admin")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAdminCan(t *testing.T) {
+	a := Admin{Permissions: []string{PermMint}}
+	if !a.Can(PermMint) || a.Can(PermConfigureEconomy) || a.Can("") {
+		t.Fatalf("admin with mint: %+v", a)
+	}
+	super := Admin{Super: true}
+	for _, p := range append(AdminPermissions, "") {
+		if !super.Can(p) {
+			t.Fatalf("super admin cannot %q", p)
+		}
+	}
+}
+
+func TestSetAdmin(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const superID, userID = 993_201, 993_202
+	for _, id := range []int64{superID, userID} {
+		if _, err := d.EnsureUser(ctx, id, "", "", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM admins WHERE user_id = ANY($1)`, []int64{superID, userID})
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id = ANY($1)`, []int64{superID, userID})
+	})
+	if err := d.EnsureSuperAdmin(ctx, superID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.SetAdmin(ctx, superID, userID, []string{"launch_rockets"}, false); err == nil {
+		t.Fatal("unknown permission accepted")
+	}
+	a, err := d.SetAdmin(ctx, superID, userID, []string{PermApproveDeposits, PermApproveDeposits}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Permissions) != 1 || a.Super || a.GrantedBy == nil || *a.GrantedBy != superID {
+		t.Fatalf("granted %+v", a)
+	}
+	if ok, err := d.AdminCan(ctx, userID, PermApproveDeposits); err != nil || !ok {
+		t.Fatalf("can approve deposits: %v, %v", ok, err)
+	}
+	if ok, _ := d.AdminCan(ctx, userID, PermMint); ok {
+		t.Fatal("deposit admin can mint")
+	}
+
+	if err := d.RemoveAdmin(ctx, superID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.AdminCan(ctx, userID, PermApproveDeposits); ok {
+		t.Fatal("removed admin still can approve deposits")
+	}
+
+	// Other super admins (ADMIN_ID of the test database) may exist; demote
+	// them for the check only when superID is the only one.
+	var supers int
+	if err := d.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM admins WHERE super`).Scan(&supers); err != nil {
+		t.Fatal(err)
+	}
+	if supers == 1 {
+		if err := d.RemoveAdmin(ctx, superID, superID); err == nil {
+			t.Fatal("last super admin removed")
+		}
+	}
+}
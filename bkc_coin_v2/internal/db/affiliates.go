@@ -0,0 +1,409 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Affiliates are partners (streamers) enrolled by an admin. Players who sign
+// up through one of their campaign links (?start=aff_<code>) are attributed
+// to them for good; every month the affiliate earns ShareBP of what those
+// players brought in: the game rake (stakes minus wins) and the fees they
+// paid. Commissions accrue on the affiliate balance and leave through the
+// withdrawal subsystem (WithdrawalRequest.Affiliate).
+
+// AffiliateLinkPrefix starts a campaign code in a bot start payload.
+const AffiliateLinkPrefix = "aff_"
+
+// Affiliate statuses.
+const (
+	AffiliateActive    = "active"
+	AffiliateSuspended = "suspended" // no new players, no new commission
+)
+
+// MaxAffiliateShareBP caps the revenue share at 50%.
+const MaxAffiliateShareBP = 5_000
+
+// AffiliateFeeKinds are ledger kinds of fees a player pays (from_id) that
+// count as revenue for the affiliate.
+var AffiliateFeeKinds = []string{"nft_market_fee", "market_listing_fee_burn", "sale_tax_fund", "sale_tax_burn"}
+
+var affiliateCodeRe = regexp.MustCompile(`^[a-z0-9_-]{3,32}$`)
+
+// Affiliate is a partner's terms and unpaid commission.
+type Affiliate struct {
+	UserID    int64     `json:"user_id"`
+	ShareBP   int64     `json:"share_bp"`
+	Status    string    `json:"status"`
+	Balance   int64     `json:"balance"`
+	Players   int64     `json:"players"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AffiliateCampaign is one tracked link.
+type AffiliateCampaign struct {
+	CampaignID int64     `json:"campaign_id"`
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	Players    int64     `json:"players"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AffiliateStatement is the commission of one month.
+type AffiliateStatement struct {
+	Month      time.Time                `json:"month"`
+	Players    int64                    `json:"players"` // attributed players active in the month
+	GameRake   int64                    `json:"game_rake"`
+	Fees       int64                    `json:"fees"`
+	ShareBP    int64                    `json:"share_bp"`
+	Commission int64                    `json:"commission"`
+	Campaigns  []AffiliateCampaignMonth `json:"campaigns"`
+	CreatedAt  time.Time                `json:"created_at"`
+}
+
+// AffiliateCampaignMonth is a campaign's part of a statement.
+type AffiliateCampaignMonth struct {
+	CampaignID int64 `json:"campaign_id"`
+	Players    int64 `json:"players"`
+	GameRake   int64 `json:"game_rake"`
+	Fees       int64 `json:"fees"`
+}
+
+// affiliateCommission is the commission on a month: a month where players
+// won more than they lost adds no rake (the loss is not carried over).
+func affiliateCommission(rake, fees, shareBP int64) int64 {
+	return (max(rake, 0) + fees) * shareBP / 10_000
+}
+
+func monthUTC(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// SetAffiliate enrolls userID as an affiliate or changes the terms.
+func (d *DB) SetAffiliate(ctx context.Context, adminID, userID, shareBP int64, status string) (Affiliate, error) {
+	if shareBP < 0 || shareBP > MaxAffiliateShareBP {
+		return Affiliate{}, errors.New("bad share_bp")
+	}
+	if status != AffiliateActive && status != AffiliateSuspended {
+		return Affiliate{}, errors.New("bad status")
+	}
+	var a Affiliate
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+INSERT INTO affiliates(user_id, share_bp, status, created_by) VALUES($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE SET share_bp = EXCLUDED.share_bp, status = EXCLUDED.status
+RETURNING user_id, share_bp, status, balance, created_at
+`, userID, shareBP, status, adminID).Scan(&a.UserID, &a.ShareBP, &a.Status, &a.Balance, &a.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_affiliate', $1, $2, 0, $3::jsonb)`,
+			adminID, userID, toJSON(map[string]any{"share_bp": shareBP, "status": status}))
+		return err
+	})
+	return a, err
+}
+
+// ListAffiliates returns every affiliate with the number of players.
+func (d *DB) ListAffiliates(ctx context.Context) ([]Affiliate, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT a.user_id, a.share_bp, a.status, a.balance, a.created_at,
+       (SELECT COUNT(*) FROM affiliate_referrals r WHERE r.affiliate_id = a.user_id)
+FROM affiliates a
+ORDER BY a.created_at
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Affiliate{}
+	for rows.Next() {
+		var a Affiliate
+		if err := rows.Scan(&a.UserID, &a.ShareBP, &a.Status, &a.Balance, &a.CreatedAt, &a.Players); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// GetAffiliate returns the affiliate and its campaigns; pgx.ErrNoRows if
+// userID is not an affiliate.
+func (d *DB) GetAffiliate(ctx context.Context, userID int64) (Affiliate, []AffiliateCampaign, error) {
+	var a Affiliate
+	err := d.Pool.QueryRow(ctx, `
+SELECT user_id, share_bp, status, balance, created_at,
+       (SELECT COUNT(*) FROM affiliate_referrals r WHERE r.affiliate_id = $1)
+FROM affiliates WHERE user_id = $1
+`, userID).Scan(&a.UserID, &a.ShareBP, &a.Status, &a.Balance, &a.CreatedAt, &a.Players)
+	if err != nil {
+		return Affiliate{}, nil, err
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT c.campaign_id, c.code, c.name, c.created_at,
+       (SELECT COUNT(*) FROM affiliate_referrals r WHERE r.campaign_id = c.campaign_id)
+FROM affiliate_campaigns c
+WHERE c.affiliate_id = $1
+ORDER BY c.campaign_id
+`, userID)
+	if err != nil {
+		return Affiliate{}, nil, err
+	}
+	defer rows.Close()
+	campaigns := []AffiliateCampaign{}
+	for rows.Next() {
+		var c AffiliateCampaign
+		if err := rows.Scan(&c.CampaignID, &c.Code, &c.Name, &c.CreatedAt, &c.Players); err != nil {
+			return Affiliate{}, nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return a, campaigns, rows.Err()
+}
+
+// CreateAffiliateCampaign adds a tracked link; an empty code gets a random one.
+func (d *DB) CreateAffiliateCampaign(ctx context.Context, affiliateID int64, code, name string) (AffiliateCampaign, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	name = strings.TrimSpace(name)
+	if code == "" {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return AffiliateCampaign{}, err
+		}
+		code = hex.EncodeToString(b)
+	}
+	if !affiliateCodeRe.MatchString(code) {
+		return AffiliateCampaign{}, errors.New("bad code: 3-32 characters of a-z, 0-9, _ and -")
+	}
+	if name == "" || len(name) > 64 {
+		return AffiliateCampaign{}, errors.New("bad name")
+	}
+	var c AffiliateCampaign
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		if err := tx.QueryRow(ctx, `SELECT status FROM affiliates WHERE user_id = $1`, affiliateID).Scan(&status); err != nil {
+			return err
+		}
+		if status != AffiliateActive {
+			return ErrForbidden
+		}
+		tag, err := tx.Exec(ctx, `
+INSERT INTO affiliate_campaigns(affiliate_id, code, name) VALUES($1, $2, $3)
+ON CONFLICT (code) DO NOTHING
+`, affiliateID, code, name)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrAlreadyExists
+		}
+		return tx.QueryRow(ctx, `SELECT campaign_id, code, name, created_at FROM affiliate_campaigns WHERE code = $1`, code).
+			Scan(&c.CampaignID, &c.Code, &c.Name, &c.CreatedAt)
+	})
+	return c, err
+}
+
+// AttributeAffiliate attributes a new player to the campaign of a start
+// payload (aff_<code>). Returns the affiliate, or 0 when the payload is not
+// an active campaign or the player is already attributed or is the affiliate.
+func (d *DB) AttributeAffiliate(ctx context.Context, userID int64, payload string) (int64, error) {
+	code, ok := strings.CutPrefix(strings.TrimSpace(payload), AffiliateLinkPrefix)
+	if !ok || !affiliateCodeRe.MatchString(code) {
+		return 0, nil
+	}
+	var affiliateID int64
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO affiliate_referrals(referred_id, affiliate_id, campaign_id)
+SELECT $1, c.affiliate_id, c.campaign_id
+FROM affiliate_campaigns c JOIN affiliates a ON a.user_id = c.affiliate_id
+WHERE c.code = $2 AND a.status = 'active' AND a.user_id <> $1
+ON CONFLICT (referred_id) DO NOTHING
+RETURNING affiliate_id
+`, userID, code).Scan(&affiliateID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return affiliateID, err
+}
+
+// BuildAffiliateStatements closes the month of month (UTC) once: every
+// active affiliate gets a statement and its commission, paid from the
+// reserve within today's emission, is added to the affiliate balance; on
+// ErrEmissionCap the month stays open for the next run. Returns the
+// statements built (0 if the month was already closed).
+func (d *DB) BuildAffiliateStatements(ctx context.Context, month time.Time) (int64, error) {
+	from := monthUTC(month)
+	to := from.AddDate(0, 1, 0)
+	var kinds, classes []string
+	for _, b := range GameBooks {
+		for _, k := range b.Stakes {
+			kinds, classes = append(kinds, k), append(classes, "stake")
+		}
+		for _, k := range b.Wins {
+			kinds, classes = append(kinds, k), append(classes, "win")
+		}
+	}
+	for _, k := range AffiliateFeeKinds {
+		kinds, classes = append(kinds, k), append(classes, "fee")
+	}
+
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO affiliate_months(month) VALUES($1) ON CONFLICT DO NOTHING`, from)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+
+		rows, err := tx.Query(ctx, `
+WITH book AS (
+  SELECT * FROM unnest($3::text[], $4::text[]) AS b(kind, class)
+), player AS (
+  SELECT r.affiliate_id, r.campaign_id, r.referred_id,
+         COALESCE(SUM(l.amount) FILTER (WHERE b.class = 'stake' AND l.from_id = r.referred_id), 0)
+       - COALESCE(SUM(l.amount) FILTER (WHERE b.class = 'win' AND l.to_id = r.referred_id), 0) AS rake,
+         COALESCE(SUM(l.amount) FILTER (WHERE b.class = 'fee' AND l.from_id = r.referred_id), 0) AS fees
+  FROM affiliate_referrals r
+  JOIN affiliates a ON a.user_id = r.affiliate_id AND a.status = 'active'
+  JOIN ledger l ON (l.from_id = r.referred_id OR l.to_id = r.referred_id) AND l.ts >= $1 AND l.ts < $2
+  JOIN book b ON b.kind = l.kind
+  GROUP BY r.affiliate_id, r.campaign_id, r.referred_id
+)
+SELECT p.affiliate_id, a.share_bp, p.campaign_id, COUNT(*), SUM(p.rake)::bigint, SUM(p.fees)::bigint
+FROM player p JOIN affiliates a ON a.user_id = p.affiliate_id
+GROUP BY p.affiliate_id, a.share_bp, p.campaign_id
+ORDER BY p.affiliate_id, p.campaign_id
+`, from, to, kinds, classes)
+		if err != nil {
+			return err
+		}
+		var statements []*AffiliateStatement
+		var owners []int64
+		for rows.Next() {
+			var affiliateID, shareBP int64
+			var c AffiliateCampaignMonth
+			if err := rows.Scan(&affiliateID, &shareBP, &c.CampaignID, &c.Players, &c.GameRake, &c.Fees); err != nil {
+				rows.Close()
+				return err
+			}
+			if len(owners) == 0 || owners[len(owners)-1] != affiliateID {
+				owners = append(owners, affiliateID)
+				statements = append(statements, &AffiliateStatement{Month: from, ShareBP: shareBP, Campaigns: []AffiliateCampaignMonth{}})
+			}
+			s := statements[len(statements)-1]
+			s.Players += c.Players
+			s.GameRake += c.GameRake
+			s.Fees += c.Fees
+			s.Campaigns = append(s.Campaigns, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var total int64
+		for _, s := range statements {
+			s.Commission = affiliateCommission(s.GameRake, s.Fees, s.ShareBP)
+			total += s.Commission
+		}
+		if total > 0 {
+			var reserve, reserved int64
+			if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+				return err
+			}
+			if reserve-reserved < total {
+				return ErrNotEnough
+			}
+			if err := chargeEmissionTx(ctx, tx, total); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply - $1, updated_at = now() WHERE id=1`, total); err != nil {
+				return err
+			}
+		}
+		for i, s := range statements {
+			affiliateID := owners[i]
+			if _, err := tx.Exec(ctx, `
+INSERT INTO affiliate_statements(affiliate_id, month, players, game_rake, fees, share_bp, commission, campaigns)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8::jsonb)
+`, affiliateID, from, s.Players, s.GameRake, s.Fees, s.ShareBP, s.Commission, toJSON(s.Campaigns)); err != nil {
+				return err
+			}
+			if s.Commission == 0 {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `UPDATE affiliates SET balance = balance + $1 WHERE user_id = $2`, s.Commission, affiliateID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('affiliate_commission', NULL, $1, $2, $3::jsonb)`,
+				affiliateID, s.Commission, toJSON(map[string]any{"month": from.Format("2006-01"), "game_rake": s.GameRake, "fees": s.Fees, "share_bp": s.ShareBP})); err != nil {
+				return err
+			}
+			if err := addUserEventTx(ctx, tx, affiliateID, "affiliate_statement", map[string]any{"month": from.Format("2006-01"), "commission": s.Commission}); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(ctx, `UPDATE affiliate_months SET statements = $2 WHERE month = $1`, from, len(statements))
+		n = int64(len(statements))
+		return err
+	})
+	return n, err
+}
+
+// ListAffiliateStatements returns the affiliate's statements, newest first.
+func (d *DB) ListAffiliateStatements(ctx context.Context, affiliateID int64, limit int) ([]AffiliateStatement, error) {
+	if limit <= 0 || limit > 120 {
+		limit = 24
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT month, players, game_rake, fees, share_bp, commission, campaigns, created_at
+FROM affiliate_statements
+WHERE affiliate_id = $1
+ORDER BY month DESC
+LIMIT $2
+`, affiliateID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AffiliateStatement{}
+	for rows.Next() {
+		var s AffiliateStatement
+		if err := rows.Scan(&s.Month, &s.Players, &s.GameRake, &s.Fees, &s.ShareBP, &s.Commission, &s.Campaigns, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// releaseAffiliateBalanceTx moves amount of the affiliate's commission to
+// the user balance, for a withdrawal in the same transaction.
+func releaseAffiliateBalanceTx(ctx context.Context, tx pgx.Tx, userID, amount int64) error {
+	var balance int64
+	if err := tx.QueryRow(ctx, `SELECT balance FROM affiliates WHERE user_id = $1 FOR UPDATE`, userID).Scan(&balance); err != nil {
+		return err
+	}
+	if balance < amount {
+		return ErrNotEnough
+	}
+	if _, err := tx.Exec(ctx, `UPDATE affiliates SET balance = balance - $1 WHERE user_id = $2`, amount, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id = $2`, amount, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('affiliate_payout', NULL, $1, $2, $3::jsonb)`,
+		userID, amount, toJSON(map[string]any{"amount": amount}))
+	return err
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAffiliateCommission(t *testing.T) {
+	cases := []struct {
+		rake, fees, bp, want int64
+	}{
+		{10_000, 0, 2_500, 2_500},
+		{10_000, 2_000, 2_500, 3_000},
+		{-5_000, 2_000, 2_500, 500}, // players won: only fees count
+		{10_000, 2_000, 0, 0},
+		{3, 0, 5_000, 1},
+	}
+	for _, c := range cases {
+		if got := affiliateCommission(c.rake, c.fees, c.bp); got != c.want {
+			t.Fatalf("commission(%d, %d, %d) = %d, want %d", c.rake, c.fees, c.bp, got, c.want)
+		}
+	}
+}
+
+func TestMonthUTC(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*3600)
+	got := monthUTC(time.Date(2026, 3, 1, 1, 0, 0, 0, msk))
+	if want := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("month %v, want %v", got, want)
+	}
+}
+
+func TestAffiliateCode(t *testing.T) {
+	for _, code := range []string{"stream1", "my_channel"} {
+		if !affiliateCodeRe.MatchString(code) {
+			t.Fatalf("%q rejected", code)
+		}
+	}
+	for _, code := range []string{"", "a b", "x/y"} {
+		if affiliateCodeRe.MatchString(code) {
+			t.Fatalf("%q accepted", code)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Admin alert kinds. Heartbeat alerts are per job: AlertHeartbeat + ":" + name,
+// RTP alerts per game: AlertGameRTP + ":" + game.
+const (
+	AlertReserveRunway   = "reserve_runway"
+	AlertPaymentFailures = "payment_failures"
+	AlertEconomyHealth   = "economy_health"
+	AlertHeartbeat       = "heartbeat"
+	AlertGameRTP         = "game_rtp"
+)
+
+// AdminAlert is an open or past alert for the admin channel. One alert per
+// kind stays open until its condition clears; repeats only bump Count.
+type AdminAlert struct {
+	AlertID    int64      `json:"alert_id"`
+	Kind       string     `json:"kind"`
+	Message    string     `json:"message"`
+	Count      int64      `json:"count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+	AckedAt    *time.Time `json:"acked_at,omitempty"`
+	AckedBy    *int64     `json:"acked_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// AlertPolicy throttles an open alert: unacknowledged it is re-sent every
+// Repeat, acknowledged it stays quiet for AckMute and then asks again.
+type AlertPolicy struct {
+	Repeat  time.Duration
+	AckMute time.Duration
+}
+
+// due reports whether an open alert should go out at now.
+func (p AlertPolicy) due(a AdminAlert, now time.Time) bool {
+	if a.SentAt == nil {
+		return true
+	}
+	if a.AckedAt != nil {
+		return !a.AckedAt.Add(p.AckMute).After(now)
+	}
+	return !a.SentAt.Add(p.Repeat).After(now)
+}
+
+const adminAlertCols = `alert_id, kind, message, count, created_at, sent_at, acked_at, acked_by, resolved_at`
+
+func scanAdminAlert(row pgx.Row) (AdminAlert, error) {
+	var a AdminAlert
+	err := row.Scan(&a.AlertID, &a.Kind, &a.Message, &a.Count, &a.CreatedAt, &a.SentAt, &a.AckedAt, &a.AckedBy, &a.ResolvedAt)
+	return a, err
+}
+
+// RaiseAdminAlert records that the condition of kind holds and reports
+// whether the alert must be sent now. A send clears an expired ack, so the
+// admin is asked to acknowledge again.
+func (d *DB) RaiseAdminAlert(ctx context.Context, kind, message string, p AlertPolicy) (AdminAlert, bool, error) {
+	kind = strings.TrimSpace(kind)
+	if kind == "" {
+		return AdminAlert{}, false, errors.New("bad kind")
+	}
+	var a AdminAlert
+	var send bool
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		a, err = scanAdminAlert(tx.QueryRow(ctx, `
+INSERT INTO admin_alerts(kind, message) VALUES($1, $2)
+ON CONFLICT (kind) WHERE resolved_at IS NULL
+DO UPDATE SET message=EXCLUDED.message, count=admin_alerts.count + 1
+RETURNING `+adminAlertCols, kind, message))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if !p.due(a, now) {
+			return nil
+		}
+		send = true
+		return tx.QueryRow(ctx, `
+UPDATE admin_alerts SET sent_at=$2, acked_at=NULL, acked_by=NULL
+WHERE alert_id=$1
+RETURNING sent_at
+`, a.AlertID, now).Scan(&a.SentAt)
+	})
+	if err != nil {
+		return AdminAlert{}, false, err
+	}
+	if send {
+		a.AckedAt, a.AckedBy = nil, nil
+	}
+	return a, send, nil
+}
+
+// ResolveAdminAlerts closes every open alert whose kind is not in active.
+func (d *DB) ResolveAdminAlerts(ctx context.Context, active []string) (int64, error) {
+	if active == nil {
+		active = []string{}
+	}
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE admin_alerts SET resolved_at=now()
+WHERE resolved_at IS NULL AND NOT (kind = ANY($1::text[]))
+`, active)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// AckAdminAlert acknowledges an open alert.
+func (d *DB) AckAdminAlert(ctx context.Context, adminID, alertID int64) (AdminAlert, error) {
+	return scanAdminAlert(d.Pool.QueryRow(ctx, `
+UPDATE admin_alerts SET acked_at=now(), acked_by=$2
+WHERE alert_id=$1 AND resolved_at IS NULL
+RETURNING `+adminAlertCols, alertID, adminID))
+}
+
+// ListAdminAlerts returns alerts, open ones first, newest first.
+func (d *DB) ListAdminAlerts(ctx context.Context, openOnly bool, limit int) ([]AdminAlert, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+adminAlertCols+`
+FROM admin_alerts
+WHERE NOT $1 OR resolved_at IS NULL
+ORDER BY resolved_at IS NULL DESC, alert_id DESC
+LIMIT $2
+`, openOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]AdminAlert, 0)
+	for rows.Next() {
+		a, err := scanAdminAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// JobHeartbeat is the last run of a background job (see jobs.SetHeartbeat).
+type JobHeartbeat struct {
+	Name      string     `json:"name"`
+	Interval  int64      `json:"interval_seconds"`
+	LastRunAt time.Time  `json:"last_run_at"`
+	LastOKAt  *time.Time `json:"last_ok_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// RecordJobRun stores the outcome of one job run.
+func (d *DB) RecordJobRun(ctx context.Context, name string, interval time.Duration, runErr error) error {
+	var msg *string
+	if runErr != nil {
+		s := runErr.Error()
+		if len(s) > 500 {
+			s = s[:500]
+		}
+		msg = &s
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO job_heartbeats(name, interval_seconds, first_run_at, last_run_at, last_ok_at, last_error)
+VALUES($1, $2, now(), now(), CASE WHEN $3::text IS NULL THEN now() END, $3)
+ON CONFLICT (name) DO UPDATE SET
+  interval_seconds=EXCLUDED.interval_seconds,
+  last_run_at=EXCLUDED.last_run_at,
+  last_ok_at=COALESCE(EXCLUDED.last_ok_at, job_heartbeats.last_ok_at),
+  last_error=EXCLUDED.last_error
+`, name, int64(interval/time.Second), msg)
+	return err
+}
+
+// StaleJobs returns jobs without a successful run in misses intervals.
+func (d *DB) StaleJobs(ctx context.Context, misses int64) ([]JobHeartbeat, error) {
+	if misses < 1 {
+		misses = 1
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT name, interval_seconds, last_run_at, last_ok_at, COALESCE(last_error, '')
+FROM job_heartbeats
+WHERE COALESCE(last_ok_at, first_run_at) < now() - (interval_seconds * $1) * interval '1 second'
+ORDER BY name
+`, misses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []JobHeartbeat
+	for rows.Next() {
+		var j JobHeartbeat
+		if err := rows.Scan(&j.Name, &j.Interval, &j.LastRunAt, &j.LastOKAt, &j.LastError); err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// RecordPaymentFailure logs a payment confirmation that could not be applied.
+func (d *DB) RecordPaymentFailure(ctx context.Context, source, ref string, cause error) error {
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	_, err := d.Pool.Exec(ctx, `INSERT INTO payment_failures(source, ref, reason) VALUES($1, $2, $3)`, source, ref, reason)
+	return err
+}
+
+// PaymentFailuresSince counts payment confirmation failures after since.
+func (d *DB) PaymentFailuresSince(ctx context.Context, since time.Time) (int64, error) {
+	var n int64
+	err := d.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM payment_failures WHERE created_at > $1`, since).Scan(&n)
+	return n, err
+}
+
+// EconomyHealth is a 0..100 score of the internal economy: 100 is a reserve
+// that is not draining and a steady rate.
+type EconomyHealth struct {
+	Score         float64   `json:"score"`
+	RunwayDays    float64   `json:"runway_days"`     // -1 = not draining
+	RateChange24h float64   `json:"rate_change_24h"` // fraction, signed
+	At            time.Time `json:"at"`
+}
+
+// healthScore takes up to 60 points off for a runway under half a year and
+// 2 points per percent of rate move beyond 5% a day.
+func healthScore(runwayDays, rateChange float64) float64 {
+	score := 100.0
+	if runwayDays >= 0 && runwayDays < 180 {
+		score -= (180 - runwayDays) / 180 * 60
+	}
+	if move := math.Abs(rateChange); move > 0.05 {
+		score -= (move - 0.05) * 200
+	}
+	return math.Max(0, math.Min(100, score))
+}
+
+// EconomyHealth scores the last reserve forecast and the 24h rate move.
+func (d *DB) EconomyHealth(ctx context.Context) (EconomyHealth, error) {
+	h := EconomyHealth{RunwayDays: -1, At: time.Now().UTC()}
+	forecasts, err := d.ListReserveForecasts(ctx, 1)
+	if err != nil {
+		return EconomyHealth{}, err
+	}
+	if len(forecasts) > 0 {
+		h.RunwayDays = forecasts[0].RunwayDays
+	}
+	cur, ok, err := d.RateAt(ctx, h.At)
+	if err != nil {
+		return EconomyHealth{}, err
+	}
+	if ok {
+		prev, ok, err := d.RateAt(ctx, cur.At.Add(-24*time.Hour))
+		if err != nil {
+			return EconomyHealth{}, err
+		}
+		if ok && prev.CoinsPerUSD > 0 && cur.CoinsPerUSD > 0 {
+			// Price in USD is 1/coins-per-USD.
+			h.RateChange24h = float64(prev.CoinsPerUSD)/float64(cur.CoinsPerUSD) - 1
+		}
+	}
+	h.Score = healthScore(h.RunwayDays, h.RateChange24h)
+	return h, nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAlertPolicyDue(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+	p := AlertPolicy{Repeat: 30 * time.Minute, AckMute: 6 * time.Hour}
+	cases := []struct {
+		name string
+		a    AdminAlert
+		want bool
+	}{
+		{"new", AdminAlert{}, true},
+		{"recently sent", AdminAlert{SentAt: ago(10 * time.Minute)}, false},
+		{"repeat due", AdminAlert{SentAt: ago(30 * time.Minute)}, true},
+		{"acked, muted", AdminAlert{SentAt: ago(2 * time.Hour), AckedAt: ago(time.Hour)}, false},
+		{"acked, mute over", AdminAlert{SentAt: ago(8 * time.Hour), AckedAt: ago(7 * time.Hour)}, true},
+	}
+	for _, c := range cases {
+		if got := p.due(c.a, now); got != c.want {
+			t.Errorf("%s: due=%v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHealthScore(t *testing.T) {
+	cases := []struct {
+		runway, change, want float64
+	}{
+		{-1, 0, 100},
+		{365, 0.03, 100},
+		{90, 0, 70},
+		{0, 0, 40},
+		{-1, -0.20, 70},
+		{30, 0.30, 0},
+	}
+	for _, c := range cases {
+		if got := healthScore(c.runway, c.change); got < c.want-0.01 || got > c.want+0.01 {
+			t.Errorf("healthScore(%v, %v) = %.2f, want %.2f", c.runway, c.change, got, c.want)
+		}
+	}
+}
+
+func TestAdminAlertLifecycle(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const kind = "test_alert"
+	t.Cleanup(func() { _, _ = d.Pool.Exec(context.Background(), `DELETE FROM admin_alerts WHERE kind=$1`, kind) })
+	p := AlertPolicy{Repeat: time.Hour, AckMute: time.Hour}
+
+	a, send, err := d.RaiseAdminAlert(ctx, kind, "first", p)
+	if err != nil || !send {
+		t.Fatalf("first raise: send=%v err=%v", send, err)
+	}
+	again, send, err := d.RaiseAdminAlert(ctx, kind, "second", p)
+	if err != nil || send {
+		t.Fatalf("throttled raise: send=%v err=%v", send, err)
+	}
+	if again.AlertID != a.AlertID || again.Count != 2 || again.Message != "second" {
+		t.Fatalf("repeat: %+v", again)
+	}
+	if _, err := d.AckAdminAlert(ctx, 1, a.AlertID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ResolveAdminAlerts(ctx, []string{"other"}); err != nil {
+		t.Fatal(err)
+	}
+	next, send, err := d.RaiseAdminAlert(ctx, kind, "back", p)
+	if err != nil || !send || next.AlertID == a.AlertID {
+		t.Fatalf("after resolve: %+v send=%v err=%v", next, send, err)
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Analytics export streams: append-only tables copied to the analytics store.
+const (
+	ExportLedger       = "ledger"
+	ExportUserEvents   = "user_events"
+	ExportClientEvents = "client_events" // rows are deleted once exported
+)
+
+// LedgerRow is a ledger entry as exported for analytics.
+type LedgerRow struct {
+	ID      int64           `json:"id"`
+	EventID *string         `json:"event_id"`
+	TS      time.Time       `json:"ts"`
+	Kind    string          `json:"kind"`
+	FromID  *int64          `json:"from_id"`
+	ToID    *int64          `json:"to_id"`
+	Amount  int64           `json:"amount"`
+	Meta    json.RawMessage `json:"meta"`
+}
+
+// ExportCursor returns the last row id of stream already exported.
+func (d *DB) ExportCursor(ctx context.Context, stream string) (int64, error) {
+	var id int64
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO analytics_export(stream) VALUES($1)
+ON CONFLICT (stream) DO UPDATE SET stream=EXCLUDED.stream
+RETURNING last_id
+`, stream).Scan(&id)
+	return id, err
+}
+
+// SetExportCursor records that stream is exported up to lastID.
+func (d *DB) SetExportCursor(ctx context.Context, stream string, lastID int64) error {
+	_, err := d.Pool.Exec(ctx, `UPDATE analytics_export SET last_id=$2, updated_at=now() WHERE stream=$1 AND last_id < $2`, stream, lastID)
+	return err
+}
+
+// Export reads only rows older than a minute: ids are assigned before commit,
+// so a younger gap may still be filled and the cursor must not pass it.
+
+// LedgerSince returns settled ledger rows after afterID in id order.
+func (d *DB) LedgerSince(ctx context.Context, afterID int64, limit int) ([]LedgerRow, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT id, event_id, ts, kind, from_id, to_id, amount, meta
+FROM ledger
+WHERE id > $1 AND ts < now() - interval '1 minute'
+ORDER BY id
+LIMIT $2
+`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LedgerRow
+	for rows.Next() {
+		var r LedgerRow
+		if err := rows.Scan(&r.ID, &r.EventID, &r.TS, &r.Kind, &r.FromID, &r.ToID, &r.Amount, &r.Meta); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// UserEventsSince returns settled user events after afterID in id order.
+func (d *DB) UserEventsSince(ctx context.Context, afterID int64, limit int) ([]UserEvent, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT event_id, user_id, kind, payload, read_at, created_at
+FROM user_events
+WHERE event_id > $1 AND created_at < now() - interval '1 minute'
+ORDER BY event_id
+LIMIT $2
+`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UserEvent
+	for rows.Next() {
+		var e UserEvent
+		if err := rows.Scan(&e.EventID, &e.UserID, &e.Kind, &e.Payload, &e.ReadAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// API key scopes.
+const (
+	APIKeyRead  = "read"  // GET requests only
+	APIKeyTrade = "trade" // read plus trading on the markets
+)
+
+// APIKeyPrefix starts every key, so leaked keys are easy to recognise.
+const APIKeyPrefix = "bkc_"
+
+// APIKey is a user's key for programmatic access. Only its hash is stored;
+// the key itself is returned once, by CreateAPIKey.
+type APIKey struct {
+	KeyID      int64      `json:"key_id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters, to tell keys apart
+	Scope      string     `json:"scope"`
+	RatePerMin int64      `json:"rate_per_minute"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey issues a key; maxKeys caps the user's active keys. Returns the
+// key record and the plaintext key.
+func (d *DB) CreateAPIKey(ctx context.Context, userID int64, name, scope string, ratePerMin, maxKeys int64) (APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if userID <= 0 || name == "" || len(name) > 64 || ratePerMin <= 0 {
+		return APIKey{}, "", errors.New("bad params")
+	}
+	if scope != APIKeyRead && scope != APIKeyTrade {
+		return APIKey{}, "", errors.New("bad scope")
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKeyPrefix + hex.EncodeToString(buf)
+	out := APIKey{UserID: userID, Name: name, Prefix: key[:len(APIKeyPrefix)+6], Scope: scope, RatePerMin: ratePerMin}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		// The user row serialises concurrent creations against maxKeys.
+		if _, err := lockSpendableTx(ctx, tx, userID); err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM api_keys WHERE user_id=$1 AND revoked_at IS NULL`, userID).Scan(&active); err != nil {
+			return err
+		}
+		if maxKeys > 0 && active >= maxKeys {
+			return errors.New("bad params: too many api keys")
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO api_keys(user_id, name, prefix, key_hash, scope, rate_per_minute)
+VALUES($1,$2,$3,$4,$5,$6)
+RETURNING key_id, created_at
+`, userID, out.Name, out.Prefix, hashAPIKey(key), scope, ratePerMin).Scan(&out.KeyID, &out.CreatedAt)
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return out, key, nil
+}
+
+// AuthAPIKey resolves a plaintext key to its active record and marks it
+// used. Fails with pgx.ErrNoRows for unknown or revoked keys.
+func (d *DB) AuthAPIKey(ctx context.Context, key string) (APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return APIKey{}, pgx.ErrNoRows
+	}
+	// Keys of a merged-away account stop working with it.
+	var k APIKey
+	err := d.Pool.QueryRow(ctx, `
+UPDATE api_keys k SET last_used_at=now()
+FROM users u
+WHERE k.key_hash=$1 AND k.revoked_at IS NULL AND u.user_id=k.user_id AND u.merged_into IS NULL
+RETURNING k.key_id, k.user_id, k.name, k.prefix, k.scope, k.rate_per_minute, k.last_used_at, k.revoked_at, k.created_at
+`, hashAPIKey(key)).Scan(&k.KeyID, &k.UserID, &k.Name, &k.Prefix, &k.Scope, &k.RatePerMin, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	return k, err
+}
+
+// ListAPIKeys returns the user's keys, newest first.
+func (d *DB) ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT key_id, user_id, name, prefix, scope, rate_per_minute, last_used_at, revoked_at, created_at
+FROM api_keys
+WHERE user_id=$1
+ORDER BY key_id DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.KeyID, &k.UserID, &k.Name, &k.Prefix, &k.Scope, &k.RatePerMin, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// RevokeAPIKey disables one of the user's keys.
+func (d *DB) RevokeAPIKey(ctx context.Context, userID, keyID int64) error {
+	tag, err := d.Pool.Exec(ctx, `UPDATE api_keys SET revoked_at=now() WHERE key_id=$1 AND user_id=$2 AND revoked_at IS NULL`, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
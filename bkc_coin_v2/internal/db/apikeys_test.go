@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestAPIKeys(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 992_001
+	if _, err := d.EnsureUser(ctx, userID, "", "", 100); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM api_keys WHERE user_id=$1`, userID)
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM users WHERE user_id=$1`, userID)
+	})
+
+	if _, _, err := d.CreateAPIKey(ctx, userID, "bot", "admin", 60, 2); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+	k, secret, err := d.CreateAPIKey(ctx, userID, "bot", APIKeyTrade, 60, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.CreateAPIKey(ctx, userID, "reader", APIKeyRead, 60, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.CreateAPIKey(ctx, userID, "third", APIKeyRead, 60, 2); err == nil {
+		t.Fatal("key limit not enforced")
+	}
+
+	got, err := d.AuthAPIKey(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.KeyID != k.KeyID || got.UserID != userID || got.Scope != APIKeyTrade || got.LastUsedAt == nil {
+		t.Fatalf("auth: %+v", got)
+	}
+	if _, err := d.AuthAPIKey(ctx, secret+"x"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("wrong key: %v", err)
+	}
+
+	if err := d.RevokeAPIKey(ctx, userID, k.KeyID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AuthAPIKey(ctx, secret); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("revoked key: %v", err)
+	}
+	if err := d.RevokeAPIKey(ctx, userID, k.KeyID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("double revoke: %v", err)
+	}
+}
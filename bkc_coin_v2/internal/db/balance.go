@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Spending rules: only users.balance is spendable. frozen_balance holds coins
+// locked by offers, promotions and freezes and is never taken by a regular
+// debit, and a negative balance (overdue loan penalty) leaves nothing to spend.
+// Every debit locks the user row first, so a concurrent freeze and spend
+// cannot both pass the check.
+
+// Spendable is the part of balance a debit may take.
+func Spendable(balance int64) int64 {
+	return max(balance, 0)
+}
+
+// SpendableBalance returns what userID can spend right now.
+func (d *DB) SpendableBalance(ctx context.Context, userID int64) (int64, error) {
+	var bal int64
+	if err := d.Pool.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1`, userID).Scan(&bal); err != nil {
+		return 0, err
+	}
+	return Spendable(bal), nil
+}
+
+// lockSpendableTx locks the user row and returns its spendable balance.
+func lockSpendableTx(ctx context.Context, tx pgx.Tx, userID int64) (int64, error) {
+	var bal int64
+	if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&bal); err != nil {
+		return 0, err
+	}
+	return Spendable(bal), nil
+}
+
+// debitSpendableTx takes amount from the user's spendable balance or fails
+// with ErrNotEnough.
+func debitSpendableTx(ctx context.Context, tx pgx.Tx, userID, amount int64) error {
+	spendable, err := lockSpendableTx(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if spendable < amount {
+		return ErrNotEnough
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, amount, userID)
+	return err
+}
+
+// lockUsersTx locks several user rows in id order, so two transactions moving
+// coins between the same users in opposite directions cannot deadlock. Fails
+// with pgx.ErrNoRows if any user does not exist.
+func lockUsersTx(ctx context.Context, tx pgx.Tx, userIDs ...int64) error {
+	ids := slices.Clone(userIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	rows, err := tx.Query(ctx, `SELECT user_id FROM users WHERE user_id = ANY($1) ORDER BY user_id FOR UPDATE`, ids)
+	if err != nil {
+		return err
+	}
+	var n int
+	for rows.Next() {
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n != len(ids) {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestSpendable(t *testing.T) {
+	for _, c := range []struct{ bal, want int64 }{{100, 100}, {0, 0}, {-50, 0}} {
+		if got := Spendable(c.bal); got != c.want {
+			t.Fatalf("Spendable(%d) = %d, want %d", c.bal, got, c.want)
+		}
+	}
+}
+
+// TestFreezeSpendRace runs freezes and transfers against the same balance
+// concurrently: the coins may be frozen or spent, never both.
+func TestFreezeSpendRace(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID, otherID = 990_101, 990_102
+	for _, id := range []int64{userID, otherID} {
+		if _, err := d.EnsureUser(ctx, id, "", "", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM users WHERE user_id IN ($1,$2)`, userID, otherID)
+	})
+
+	for round := 0; round < 20; round++ {
+		if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=1000, frozen_balance=0 WHERE user_id=$1`, userID); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				switch i {
+				case 0:
+					errs[i] = d.FreezeBalance(ctx, userID, 600)
+				case 1:
+					errs[i] = d.Transfer(ctx, userID, otherID, 600, VelocityPolicy{})
+				case 2:
+					// Opposite direction: must not deadlock with case 1.
+					errs[i] = d.Transfer(ctx, otherID, userID, 1, VelocityPolicy{})
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i, err := range errs[:2] {
+			if err != nil && !errors.Is(err, ErrNotEnough) {
+				t.Fatalf("op %d: %v", i, err)
+			}
+		}
+		if errs[0] == nil && errs[1] == nil {
+			t.Fatal("the same coins were frozen and spent")
+		}
+		u, err := d.GetUser(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Balance < 0 || u.FrozenBalance < 0 {
+			t.Fatalf("round %d: balance=%d frozen=%d", round, u.Balance, u.FrozenBalance)
+		}
+	}
+}
+
+// TestDebitsRespectSpendable checks that direct debits leave frozen coins and
+// a negative balance alone.
+func TestDebitsRespectSpendable(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 990_103
+	if _, err := d.EnsureUser(ctx, userID, "", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM ledger WHERE from_id=$1`, userID)
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM users WHERE user_id=$1`, userID)
+	})
+
+	for _, c := range []struct{ bal, frozen int64 }{{-50, 0}, {10, 500}} {
+		if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=$1, frozen_balance=$2 WHERE user_id=$3`, c.bal, c.frozen, userID); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Burn(ctx, userID, 20, "test", nil); !errors.Is(err, ErrNotEnough) {
+			t.Fatalf("balance=%d frozen=%d: burn err %v", c.bal, c.frozen, err)
+		}
+		u, err := d.GetUser(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Balance != c.bal || u.FrozenBalance != c.frozen {
+			t.Fatalf("balance=%d frozen=%d after a failed burn", u.Balance, u.FrozenBalance)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OverdueLoansJob names the overdue bank loans job, its lease and checkpoint.
+const OverdueLoansJob = "bank_loans_overdue"
+
+// overdueLoansBatch is how many loans one batch locks and marks.
+const overdueLoansBatch = 500
+
+// OverdueLoansRun sums up one RunOverdueBankLoans call.
+type OverdueLoansRun struct {
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+	Batches   int64 `json:"batches"`
+	// Exhausted is false when the run stopped early; the next one resumes
+	// from the checkpoint.
+	Exhausted bool   `json:"exhausted"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// overdueCursor is a loan in (due_at, loan_id) order; checkpointed, the last
+// one a run looked at.
+type overdueCursor struct {
+	DueAt  time.Time `json:"due_at"`
+	LoanID int64     `json:"loan_id"`
+}
+
+// RunOverdueBankLoans marks active loans due by now (zero = d.Clock) as
+// overdue, batch by batch, until none are left or ctx is done. Each loan is
+// its own transaction: the debt is taken from the user's balance, which may
+// go negative, and returned to the reserve. A loan that fails stays active.
+//
+// After every batch the cursor is checkpointed, so a run cut short by its
+// timeout or a restart resumes where it stopped rather than retrying the
+// same failing loans first; once the run reaches the end the checkpoint is
+// cleared and the next run starts over, retrying the failures. A run that
+// looked at any loan leaves a 'bank_loan_overdue_run' ledger entry with the
+// counts.
+func (d *DB) RunOverdueBankLoans(ctx context.Context, now time.Time, batch int) (OverdueLoansRun, error) {
+	if now.IsZero() {
+		now = d.now()
+	}
+	if batch <= 0 {
+		batch = overdueLoansBatch
+	}
+	var run OverdueLoansRun
+	var cur overdueCursor
+	if _, err := d.jobCheckpoint(ctx, OverdueLoansJob, &cur); err != nil {
+		return run, err
+	}
+	for ctx.Err() == nil {
+		loans, err := d.overdueLoanBatch(ctx, now, cur, batch)
+		if err != nil {
+			return run, d.finishOverdueRun(ctx, run, err)
+		}
+		if len(loans) == 0 {
+			run.Exhausted = true
+			break
+		}
+		run.Batches++
+		for _, l := range loans {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := d.markLoanOverdue(ctx, l.LoanID, now); err != nil {
+				run.Failed++
+				run.LastError = err.Error()
+			} else {
+				run.Processed++
+			}
+			cur = l
+		}
+		if err := d.saveJobCheckpoint(context.WithoutCancel(ctx), OverdueLoansJob, cur); err != nil {
+			return run, d.finishOverdueRun(ctx, run, err)
+		}
+		if len(loans) < batch && ctx.Err() == nil {
+			run.Exhausted = true
+			break
+		}
+	}
+	return run, d.finishOverdueRun(ctx, run, nil)
+}
+
+// finishOverdueRun clears the checkpoint of an exhausted run and records the
+// summary; runErr, if any, is returned over their errors.
+func (d *DB) finishOverdueRun(ctx context.Context, run OverdueLoansRun, runErr error) error {
+	// The deadline may have ended the run: the bookkeeping still goes in.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	var err error
+	if run.Exhausted {
+		err = d.saveJobCheckpoint(ctx, OverdueLoansJob, nil)
+	}
+	if run.Processed+run.Failed > 0 {
+		if _, e := d.Pool.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bank_loan_overdue_run', NULL, NULL, 0, $1::jsonb)`,
+			toJSON(run)); e != nil && err == nil {
+			err = e
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return err
+}
+
+// overdueLoanBatch returns the next active loans due by now after cur.
+func (d *DB) overdueLoanBatch(ctx context.Context, now time.Time, cur overdueCursor, batch int) ([]overdueCursor, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT due_at, loan_id
+FROM bank_loans
+WHERE status='active' AND due_at <= $1 AND (due_at, loan_id) > ($2, $3)
+ORDER BY due_at ASC, loan_id ASC
+LIMIT $4
+`, now, cur.DueAt, cur.LoanID, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []overdueCursor
+	for rows.Next() {
+		var l overdueCursor
+		if err := rows.Scan(&l.DueAt, &l.LoanID); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (d *DB) markLoanOverdue(ctx context.Context, loanID int64, now time.Time) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var userID int64
+		var totalDue int64
+		var status string
+		if err := tx.QueryRow(ctx, `
+SELECT user_id, total_due, status
+FROM bank_loans
+WHERE loan_id=$1
+FOR UPDATE
+`, loanID).Scan(&userID, &totalDue, &status); err != nil {
+			return err
+		}
+		if strings.ToLower(strings.TrimSpace(status)) != "active" {
+			return nil
+		}
+
+		// Apply penalty (balance can go negative)
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, totalDue, userID); err != nil {
+			return err
+		}
+		// Return the debt to reserve to keep reserve accounting consistent even if user goes negative.
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, totalDue); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bank_loans SET status='overdue', closed_at=$1 WHERE loan_id=$2`, now, loanID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bank_loan_overdue', $1, NULL, $2, $3::jsonb)`,
+			userID, totalDue, toJSON(map[string]any{"loan_id": loanID, "ts": now.Unix()}),
+		)
+		return err
+	})
+}
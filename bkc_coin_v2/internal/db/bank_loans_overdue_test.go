@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+func TestRunOverdueBankLoans(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	ids := []int64{9_300_800_001, 9_300_800_002, 9_300_800_003, 9_300_800_004, 9_300_800_005}
+	seedMoneyUsers(t, d, 1_000, ids...)
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM job_checkpoints WHERE name=$1`, OverdueLoansJob)
+	})
+	var first BankLoan
+	for i, id := range ids {
+		l, err := d.CreateBankLoan(ctx, id, 100, 1_000, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = l
+		}
+		clk.Advance(time.Second) // distinct due dates, in id order
+	}
+	status := func(id int64) string {
+		t.Helper()
+		loans, err := d.ListBankLoansByUser(ctx, id, 1)
+		if err != nil || len(loans) != 1 {
+			t.Fatalf("loans %+v, %v", loans, err)
+		}
+		return loans[0].Status
+	}
+
+	// Resume after the first loan, as if an earlier run had stopped there.
+	if err := d.saveJobCheckpoint(ctx, OverdueLoansJob, overdueCursor{DueAt: first.DueAt, LoanID: first.LoanID}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(8 * 24 * time.Hour)
+	run, err := d.RunOverdueBankLoans(ctx, time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Exhausted || run.Processed < 4 || run.Batches < 2 {
+		t.Fatalf("run: %+v", run)
+	}
+	if s := status(ids[0]); s != "active" {
+		t.Fatalf("loan before the checkpoint: %s", s)
+	}
+	for _, id := range ids[1:] {
+		if s := status(id); s != "overdue" {
+			t.Fatalf("user %d: %s", id, s)
+		}
+	}
+	var cur overdueCursor
+	if ok, err := d.jobCheckpoint(ctx, OverdueLoansJob, &cur); err != nil || ok {
+		t.Fatalf("checkpoint left after an exhausted run: %+v, %v", cur, err)
+	}
+
+	// The next run starts over and picks up the skipped loan.
+	run, err = d.RunOverdueBankLoans(ctx, time.Time{}, 2)
+	if err != nil || run.Processed < 1 {
+		t.Fatalf("run: %+v, %v", run, err)
+	}
+	if s := status(ids[0]); s != "overdue" {
+		t.Fatalf("first loan: %s", s)
+	}
+	var summaries int
+	if err := d.Pool.QueryRow(ctx, `SELECT count(*) FROM ledger WHERE kind='bank_loan_overdue_run' AND created_at > now() - interval '1 minute'`).Scan(&summaries); err != nil {
+		t.Fatal(err)
+	}
+	if summaries < 2 {
+		t.Fatalf("%d run summaries", summaries)
+	}
+	for _, id := range ids {
+		checkLedger(t, d, id, 1_000)
+	}
+}
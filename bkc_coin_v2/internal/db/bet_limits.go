@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBetLimit matches every *BetLimitError.
+var ErrBetLimit = errors.New("bet limit")
+
+// Bet limit rules (BetLimit.Rule): which cap set the maximum bet.
+const (
+	BetRuleConfig     = "config"
+	BetRuleBalance    = "balance"
+	BetRulePlan       = "plan"
+	BetRuleNewAccount = "new_account"
+	BetRuleBot        = "bot"
+	BetRuleBonus      = "bonus" // the campaign's max bet on bets the bonus balance pays for (bonuses.go)
+)
+
+// BetLimitPolicy caps a single bet. Zero disables a rule.
+type BetLimitPolicy struct {
+	MaxBet           int64            // hard cap for everyone
+	BalancePercent   int64            // share of the balance one bet may take
+	PlanCaps         map[string]int64 // by user plan; "base" = users without a plan
+	NewAccountMaxBet int64            // cap on the first day of an account
+	NewAccountDays   int64            // the new account cap grows linearly to the others over these days
+	BotMaxBet        int64            // cap for players with a bot action (bots.go)
+}
+
+// BetLimit is a user's maximum bet right now and the rule that set it.
+type BetLimit struct {
+	MaxBet  int64  `json:"max_bet"` // 0 = unlimited
+	Rule    string `json:"rule,omitempty"`
+	Balance int64  `json:"balance"`
+	Plan    string `json:"plan"`
+
+	CaptchaRequired bool `json:"captcha_required,omitempty"`
+}
+
+// BetLimitError is a bet refused by the limits.
+type BetLimitError struct {
+	Rule   string
+	Limit  int64
+	Amount int64
+}
+
+func (e *BetLimitError) Error() string {
+	return fmt.Sprintf("bet limit %s: max bet %d, got %d", e.Rule, e.Limit, e.Amount)
+}
+
+func (e *BetLimitError) Is(target error) bool { return target == ErrBetLimit }
+
+// limit computes the maximum bet of a user with balance, plan, account age
+// and bot action; KYC-verified users skip the new account ramp.
+func (p BetLimitPolicy) limit(balance int64, plan string, kycTier int64, age time.Duration, botAction string) BetLimit {
+	out := BetLimit{Balance: balance, Plan: plan}
+	apply := func(rule string, v int64) {
+		if out.MaxBet == 0 || v < out.MaxBet {
+			out.MaxBet, out.Rule = v, rule
+		}
+	}
+	if p.MaxBet > 0 {
+		apply(BetRuleConfig, p.MaxBet)
+	}
+	if c := p.PlanCaps[plan]; c > 0 {
+		apply(BetRulePlan, c)
+	}
+	if p.BalancePercent > 0 {
+		apply(BetRuleBalance, max(balance*p.BalancePercent/100, 1))
+	}
+	if p.NewAccountMaxBet > 0 && p.NewAccountDays > 0 && kycTier < KYCBasic {
+		ramp := time.Duration(p.NewAccountDays) * 24 * time.Hour
+		if age < ramp {
+			c := p.NewAccountMaxBet
+			if out.MaxBet > c {
+				c += int64(float64(out.MaxBet-c) * float64(age) / float64(ramp))
+			}
+			apply(BetRuleNewAccount, c)
+		}
+	}
+	if p.BotMaxBet > 0 && botAction != "" && botAction != BotActionNone {
+		apply(BetRuleBot, p.BotMaxBet)
+	}
+	return out
+}
+
+// betLimit reads what the policy needs about the user.
+func betLimit(ctx context.Context, q rowQuerier, userID int64, p BetLimitPolicy, now time.Time) (BetLimit, error) {
+	var balance, tier int64
+	var plan, botAction string
+	var captcha bool
+	var createdAt time.Time
+	err := q.QueryRow(ctx, `
+SELECT u.balance, u.kyc_tier, u.created_at, COALESCE(p.plan, 'base'),
+       COALESCE(b.action, 'none'), COALESCE(b.captcha_required, false)
+FROM users u
+LEFT JOIN user_plans p ON p.user_id = u.user_id AND (p.expires_at IS NULL OR p.expires_at > $2)
+LEFT JOIN bot_scores b ON b.user_id = u.user_id
+WHERE u.user_id = $1
+`, userID, now).Scan(&balance, &tier, &createdAt, &plan, &botAction, &captcha)
+	if err != nil {
+		return BetLimit{}, err
+	}
+	l := p.limit(balance, plan, tier, now.Sub(createdAt), botAction)
+	l.CaptchaRequired = captcha
+	return l, nil
+}
+
+// GetBetLimit returns the user's maximum bet under p.
+func (d *DB) GetBetLimit(ctx context.Context, userID int64, p BetLimitPolicy) (BetLimit, error) {
+	return betLimit(ctx, d.Pool, userID, p, time.Now().UTC())
+}
+
+// CheckBetLimit refuses a bet of amount above the user's maximum with a
+// *BetLimitError, and any bet with ErrCaptchaRequired while the bot check
+// wants a CAPTCHA. Call it in the bet transaction after locking the user row,
+// so the balance it sees is the one the bet is taken from.
+func CheckBetLimit(ctx context.Context, q rowQuerier, userID, amount int64, p BetLimitPolicy) error {
+	l, err := betLimit(ctx, q, userID, p, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if l.CaptchaRequired {
+		return ErrCaptchaRequired
+	}
+	if l.MaxBet > 0 && amount > l.MaxBet {
+		return &BetLimitError{Rule: l.Rule, Limit: l.MaxBet, Amount: amount}
+	}
+	return nil
+}
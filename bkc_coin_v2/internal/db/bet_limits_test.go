@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBetLimitPolicy(t *testing.T) {
+	p := BetLimitPolicy{
+		MaxBet:           100_000,
+		BalancePercent:   25,
+		PlanCaps:         map[string]int64{"base": 50_000, "vip": 500_000},
+		NewAccountMaxBet: 1_000,
+		NewAccountDays:   10,
+	}
+	old := 30 * 24 * time.Hour
+	cases := []struct {
+		name    string
+		balance int64
+		plan    string
+		tier    int64
+		age     time.Duration
+		want    int64
+		rule    string
+	}{
+		{"plan cap", 1_000_000, "base", KYCNone, old, 50_000, BetRulePlan},
+		{"config below plan", 10_000_000, "vip", KYCNone, old, 100_000, BetRuleConfig},
+		{"share of balance", 40_000, "vip", KYCNone, old, 10_000, BetRuleBalance},
+		{"tiny balance still bets", 2, "vip", KYCNone, old, 1, BetRuleBalance},
+		{"first day", 1_000_000, "base", KYCNone, 0, 1_000, BetRuleNewAccount},
+		{"half the ramp", 1_000_000, "base", KYCNone, 5 * 24 * time.Hour, 25_500, BetRuleNewAccount},
+		{"kyc skips the ramp", 1_000_000, "base", KYCBasic, 0, 50_000, BetRulePlan},
+	}
+	for _, c := range cases {
+		l := p.limit(c.balance, c.plan, c.tier, c.age, BotActionNone)
+		if l.MaxBet != c.want || l.Rule != c.rule {
+			t.Fatalf("%s: max bet %d (%s), want %d (%s)", c.name, l.MaxBet, l.Rule, c.want, c.rule)
+		}
+	}
+
+	p.BotMaxBet = 500
+	if l := p.limit(1_000_000, "base", KYCBasic, old, BotActionLimit); l.MaxBet != 500 || l.Rule != BetRuleBot {
+		t.Fatalf("bot: max bet %d (%s), want 500 (%s)", l.MaxBet, l.Rule, BetRuleBot)
+	}
+
+	if l := (BetLimitPolicy{}).limit(1_000, "base", KYCNone, 0, BotActionCaptcha); l.MaxBet != 0 {
+		t.Fatalf("empty policy limits bets to %d", l.MaxBet)
+	}
+	var err error = &BetLimitError{Rule: BetRuleBalance, Limit: 10, Amount: 11}
+	if !errors.Is(err, ErrBetLimit) {
+		t.Fatal("BetLimitError does not match ErrBetLimit")
+	}
+}
@@ -0,0 +1,348 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Shared bills: the creator splits a total into shares owed by several users
+// and names a payee (for a group NFT purchase, the member who buys). Each
+// participant pays their share into the bill, where it is held; the payment
+// that funds the bill in full pays the total out to the payee. A bill still
+// short at its deadline refunds every paid share.
+
+// Bill statuses.
+const (
+	BillOpen      = "open"
+	BillCompleted = "completed"
+	BillRefunded  = "refunded"
+)
+
+const (
+	maxBillTitle    = 120 // runes
+	billExpireBatch = 500
+)
+
+// ErrBillClosed is a payment into a bill that is completed, refunded or past
+// its deadline.
+var ErrBillClosed = errors.New("bill closed")
+
+// Bill is a total split into shares.
+type Bill struct {
+	BillID    int64       `json:"bill_id"`
+	CreatorID int64       `json:"creator_id"`
+	PayeeID   int64       `json:"payee_id"`
+	Title     string      `json:"title"`
+	Total     int64       `json:"total"`
+	Funded    int64       `json:"funded"`
+	Status    string      `json:"status"`
+	Deadline  time.Time   `json:"deadline"`
+	Shares    []BillShare `json:"shares"`
+	ClosedAt  *time.Time  `json:"closed_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// BillShare is what one participant owes and whether it is paid.
+type BillShare struct {
+	UserID int64      `json:"user_id"`
+	Amount int64      `json:"amount"`
+	PaidAt *time.Time `json:"paid_at,omitempty"`
+}
+
+const billCols = `bill_id, creator_id, payee_id, title, total, funded, status, deadline, closed_at, created_at`
+
+func scanBill(row pgx.Row) (Bill, error) {
+	var b Bill
+	err := row.Scan(&b.BillID, &b.CreatorID, &b.PayeeID, &b.Title, &b.Total, &b.Funded, &b.Status, &b.Deadline, &b.ClosedAt, &b.CreatedAt)
+	return b, err
+}
+
+// validateBillShares checks maxParticipants distinct users with positive
+// shares and returns the total.
+func validateBillShares(shares []BillShare, maxParticipants int) (int64, error) {
+	if len(shares) == 0 || len(shares) > maxParticipants {
+		return 0, errors.New("bad shares: participant count")
+	}
+	seen := make(map[int64]bool, len(shares))
+	var total int64
+	for _, s := range shares {
+		if s.UserID <= 0 || s.Amount <= 0 || seen[s.UserID] {
+			return 0, errors.New("bad shares")
+		}
+		seen[s.UserID] = true
+		total += s.Amount
+	}
+	return total, nil
+}
+
+// CreateBill opens a bill from creatorID paid out to payeeID once every
+// share is paid. Participants get a bill_share event.
+func (d *DB) CreateBill(ctx context.Context, creatorID, payeeID int64, title string, shares []BillShare, deadline time.Time, maxParticipants int) (Bill, error) {
+	title = strings.TrimSpace(title)
+	if creatorID <= 0 || payeeID <= 0 || !deadline.After(d.now()) {
+		return Bill{}, errors.New("bad params")
+	}
+	if title == "" || !utf8.ValidString(title) || utf8.RuneCountInString(title) > maxBillTitle {
+		return Bill{}, errors.New("bad title")
+	}
+	total, err := validateBillShares(shares, maxParticipants)
+	if err != nil {
+		return Bill{}, err
+	}
+	var out Bill
+	err = d.WithTx(ctx, func(tx pgx.Tx) error {
+		ids := []int64{creatorID, payeeID}
+		for _, s := range shares {
+			ids = append(ids, s.UserID)
+		}
+		// Every participant and the payee must exist.
+		if err := lockUsersTx(ctx, tx, ids...); err != nil {
+			return err
+		}
+		var err error
+		out, err = scanBill(tx.QueryRow(ctx, `
+INSERT INTO bills(creator_id, payee_id, title, total, deadline)
+VALUES($1, $2, $3, $4, $5)
+RETURNING `+billCols, creatorID, payeeID, title, total, deadline))
+		if err != nil {
+			return err
+		}
+		for _, s := range shares {
+			if _, err := tx.Exec(ctx, `INSERT INTO bill_shares(bill_id, user_id, amount) VALUES($1, $2, $3)`, out.BillID, s.UserID, s.Amount); err != nil {
+				return err
+			}
+			if s.UserID == creatorID {
+				continue
+			}
+			if err := addUserEventTx(ctx, tx, s.UserID, "bill_share", map[string]any{
+				"bill_id": out.BillID, "title": title, "amount": s.Amount, "creator_id": creatorID, "deadline": deadline,
+			}); err != nil {
+				return err
+			}
+		}
+		out.Shares = shares
+		return nil
+	})
+	return out, err
+}
+
+func billSharesTx(ctx context.Context, tx pgx.Tx, billID int64) ([]BillShare, error) {
+	rows, err := tx.Query(ctx, `SELECT user_id, amount, paid_at FROM bill_shares WHERE bill_id=$1 ORDER BY user_id`, billID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BillShare{}
+	for rows.Next() {
+		var s BillShare
+		if err := rows.Scan(&s.UserID, &s.Amount, &s.PaidAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GetBill returns a bill with its shares; only the creator, the payee and
+// participants may see it (pgx.ErrNoRows otherwise).
+func (d *DB) GetBill(ctx context.Context, userID, billID int64) (Bill, error) {
+	var out Bill
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = scanBill(tx.QueryRow(ctx, `
+SELECT `+billCols+` FROM bills b
+WHERE bill_id=$1 AND (creator_id=$2 OR payee_id=$2 OR EXISTS(SELECT 1 FROM bill_shares WHERE bill_id=b.bill_id AND user_id=$2))
+`, billID, userID))
+		if err != nil {
+			return err
+		}
+		out.Shares, err = billSharesTx(ctx, tx, billID)
+		return err
+	})
+	return out, err
+}
+
+// ListBills returns bills the user created, receives or has a share in,
+// newest first, without shares.
+func (d *DB) ListBills(ctx context.Context, userID int64, limit int) ([]Bill, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+billCols+` FROM bills b
+WHERE creator_id=$1 OR payee_id=$1 OR EXISTS(SELECT 1 FROM bill_shares WHERE bill_id=b.bill_id AND user_id=$1)
+ORDER BY bill_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Bill{}
+	for rows.Next() {
+		b, err := scanBill(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// PayBillShare pays the user's share. The payment that funds the bill pays
+// the total to the payee and completes it. pgx.ErrNoRows if the user has no
+// share, ErrAlreadyExists if it is paid, ErrBillClosed past the deadline.
+// A share is a transfer to the payee, so vp applies as to Transfer.
+func (d *DB) PayBillShare(ctx context.Context, userID, billID int64, vp VelocityPolicy) (Bill, error) {
+	var out Bill
+	var payeeID, share int64 // of a payment refused by vp
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		b, err := scanBill(tx.QueryRow(ctx, `SELECT `+billCols+` FROM bills WHERE bill_id=$1 FOR UPDATE`, billID))
+		if err != nil {
+			return err
+		}
+		if b.Status != BillOpen || !d.now().Before(b.Deadline) {
+			return ErrBillClosed
+		}
+		var amount int64
+		var paidAt *time.Time
+		if err := tx.QueryRow(ctx, `SELECT amount, paid_at FROM bill_shares WHERE bill_id=$1 AND user_id=$2 FOR UPDATE`, billID, userID).Scan(&amount, &paidAt); err != nil {
+			return err
+		}
+		if paidAt != nil {
+			return ErrAlreadyExists
+		}
+		if err := lockUsersTx(ctx, tx, userID, b.PayeeID); err != nil {
+			return err
+		}
+		if err := checkVelocityTx(ctx, tx, userID, b.PayeeID, amount, vp); err != nil {
+			payeeID, share = b.PayeeID, amount
+			return err
+		}
+		if err := debitSpendableTx(ctx, tx, userID, amount); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bill_shares SET paid_at=now() WHERE bill_id=$1 AND user_id=$2`, billID, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bill_pay', $1, NULL, $2, $3::jsonb)`,
+			userID, amount, toJSON(map[string]any{"bill_id": billID})); err != nil {
+			return err
+		}
+		status := BillOpen
+		if b.Funded+amount == b.Total {
+			status = BillCompleted
+		}
+		out, err = scanBill(tx.QueryRow(ctx, `
+UPDATE bills SET funded = funded + $2, status = $3, closed_at = CASE WHEN $3 = 'completed' THEN now() END WHERE bill_id=$1
+RETURNING `+billCols, billID, amount, status))
+		if err != nil {
+			return err
+		}
+		if status == BillCompleted {
+			if err := payOutBillTx(ctx, tx, out); err != nil {
+				return err
+			}
+		}
+		out.Shares, err = billSharesTx(ctx, tx, billID)
+		return err
+	})
+	var ve *VelocityError
+	if errors.As(err, &ve) {
+		if aerr := d.recordVelocityAlert(ctx, userID, payeeID, share, ve); aerr != nil {
+			return Bill{}, aerr
+		}
+	}
+	return out, err
+}
+
+// payOutBillTx credits the funded total to the payee.
+func payOutBillTx(ctx context.Context, tx pgx.Tx, b Bill) error {
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, b.Total, b.PayeeID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bill_payout', NULL, $1, $2, $3::jsonb)`,
+		b.PayeeID, b.Total, toJSON(map[string]any{"bill_id": b.BillID})); err != nil {
+		return err
+	}
+	payload := map[string]any{"bill_id": b.BillID, "title": b.Title, "total": b.Total}
+	if err := addUserEventTx(ctx, tx, b.PayeeID, "bill_completed", payload); err != nil {
+		return err
+	}
+	if b.CreatorID == b.PayeeID {
+		return nil
+	}
+	return addUserEventTx(ctx, tx, b.CreatorID, "bill_completed", payload)
+}
+
+// ExpireBills refunds the paid shares of open bills past their deadline;
+// funded keeps what had been paid.
+func (d *DB) ExpireBills(ctx context.Context, now time.Time) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT bill_id FROM bills
+WHERE status='open' AND deadline <= $1
+ORDER BY bill_id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`, now, billExpireBatch)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			shares, err := billSharesTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			var payers []int64
+			for _, s := range shares {
+				if s.PaidAt != nil {
+					payers = append(payers, s.UserID)
+				}
+			}
+			if err := lockUsersTx(ctx, tx, payers...); err != nil {
+				return err
+			}
+			for _, s := range shares {
+				if s.PaidAt == nil {
+					continue
+				}
+				if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, s.Amount, s.UserID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bill_refund', NULL, $1, $2, $3::jsonb)`,
+					s.UserID, s.Amount, toJSON(map[string]any{"bill_id": id})); err != nil {
+					return err
+				}
+				if err := addUserEventTx(ctx, tx, s.UserID, "bill_refunded", map[string]any{"bill_id": id, "amount": s.Amount}); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(ctx, `UPDATE bills SET status='refunded', closed_at=$2 WHERE bill_id=$1`, id, now); err != nil {
+				return err
+			}
+		}
+		n = int64(len(ids))
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateBillShares(t *testing.T) {
+	total, err := validateBillShares([]BillShare{{UserID: 1, Amount: 300}, {UserID: 2, Amount: 200}}, 3)
+	if err != nil || total != 500 {
+		t.Fatalf("total %d, err %v, want 500", total, err)
+	}
+	for name, shares := range map[string][]BillShare{
+		"empty":     nil,
+		"too many":  {{UserID: 1, Amount: 1}, {UserID: 2, Amount: 1}, {UserID: 3, Amount: 1}, {UserID: 4, Amount: 1}},
+		"duplicate": {{UserID: 1, Amount: 10}, {UserID: 1, Amount: 10}},
+		"zero":      {{UserID: 1, Amount: 0}},
+		"no user":   {{UserID: 0, Amount: 10}},
+	} {
+		if _, err := validateBillShares(shares, 3); err == nil {
+			t.Fatalf("%s accepted", name)
+		}
+	}
+}
+
+func TestBillPayoutAndRefund(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const payee, alice, bob = 9_301_500_001, 9_301_500_002, 9_301_500_003
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM bill_shares WHERE bill_id IN (SELECT bill_id FROM bills WHERE payee_id=$1)`, payee)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM bills WHERE payee_id=$1`, payee)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, payee, alice, bob)
+	t.Cleanup(cleanup)
+	shares := []BillShare{{UserID: alice, Amount: 300}, {UserID: bob, Amount: 200}}
+	deadline := time.Now().Add(time.Hour)
+
+	b, err := d.CreateBill(ctx, payee, payee, "dinner", shares, deadline, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayBillShare(ctx, alice, b.BillID, VelocityPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayBillShare(ctx, alice, b.BillID, VelocityPolicy{}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("paid twice: %v", err)
+	}
+	if got := checkLedger(t, d, payee, 1_000); got != 1_000 {
+		t.Fatalf("payee paid out early: %d", got)
+	}
+	b, err = d.PayBillShare(ctx, bob, b.BillID, VelocityPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Status != BillCompleted || b.Funded != 500 {
+		t.Fatalf("bill: %+v", b)
+	}
+	if got := checkLedger(t, d, payee, 1_000); got != 1_500 {
+		t.Fatalf("payee balance %d", got)
+	}
+
+	// A bill short of its total at the deadline pays back what was paid.
+	b, err = d.CreateBill(ctx, payee, payee, "tickets", shares, deadline, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayBillShare(ctx, alice, b.BillID, VelocityPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExpireBills(ctx, deadline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayBillShare(ctx, bob, b.BillID, VelocityPolicy{}); !errors.Is(err, ErrBillClosed) {
+		t.Fatalf("payment into a refunded bill: %v", err)
+	}
+	if b, err = d.GetBill(ctx, payee, b.BillID); err != nil || b.Status != BillRefunded {
+		t.Fatalf("bill %+v, err %v", b, err)
+	}
+	if got := checkLedger(t, d, alice, 1_000); got != 1_000-300 {
+		t.Fatalf("alice balance %d", got)
+	}
+	if got := checkLedger(t, d, bob, 1_000); got != 1_000-200 {
+		t.Fatalf("bob balance %d", got)
+	}
+	if got := checkLedger(t, d, payee, 1_000); got != 1_500 {
+		t.Fatalf("payee balance after the refund %d", got)
+	}
+}
+
+func TestBillVelocity(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const payee, payer = 9_301_500_011, 9_301_500_012
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM bill_shares WHERE bill_id IN (SELECT bill_id FROM bills WHERE payee_id=$1)`, payee)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM bills WHERE payee_id=$1`, payee)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM velocity_alerts WHERE user_id=$1`, payer)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, payee, payer)
+	t.Cleanup(cleanup)
+
+	// A one-share bill is a transfer to the payee and shares its limits.
+	vp := VelocityPolicy{DailyVolume: 150}
+	if err := d.Transfer(ctx, payer, payee, 100, vp); err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.CreateBill(ctx, payee, payee, "rent", []BillShare{{UserID: payer, Amount: 100}}, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayBillShare(ctx, payer, b.BillID, vp); !errors.Is(err, ErrVelocity) {
+		t.Fatalf("bill payment over the limit: %v", err)
+	}
+	alerts, err := d.ListVelocityAlerts(ctx, payer, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0].ToID != payee {
+		t.Fatalf("alerts: %+v", alerts)
+	}
+	if got := checkLedger(t, d, payer, 1_000); got != 900 {
+		t.Fatalf("payer balance %d", got)
+	}
+}
@@ -0,0 +1,735 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Bonus promos are claimed by code. A deposit-match bonus waits (pending)
+// for the next approved deposit and grants MatchBP of it up to MaxBonus; a
+// free bet grants one stake of FreeBet. Granted coins leave the reserve for
+// the user's bonus balance, which is kept apart from users.balance and can
+// only be staked in games, after the real balance. Every bet at MinOdds or
+// above counts towards the wagering requirement (WagerX times the bonus, or
+// the free bet winnings); once it is met and no bonus bet is open, the bonus
+// balance moves to the real balance. An unwagered bonus expires back to the
+// reserve.
+
+// Bonus kinds.
+const (
+	BonusDepositMatch = "deposit_match"
+	BonusFreeBet      = "free_bet"
+)
+
+// Bonus statuses.
+const (
+	BonusPending   = "pending" // deposit match claimed, waiting for a deposit
+	BonusActive    = "active"
+	BonusReview    = "review"    // abuse flags: frozen until an admin decides
+	BonusCompleted = "completed" // wagered, the rest moved to the real balance
+	BonusLost      = "lost"      // bonus balance played out before it was wagered
+	BonusExpired   = "expired"
+	BonusForfeited = "forfeited"
+)
+
+// Abuse flags of a claim; a flagged bonus goes to review instead of active.
+const (
+	BonusFlagSharedIP = "shared_ip" // another claimant of the campaign used the same IP
+	BonusFlagHunting  = "hunting"   // earlier bonuses expired without a single bet
+)
+
+const (
+	maxBonusMatchBP    = 50_000 // 500%
+	maxBonusWagerX     = 100
+	maxBonusValidDays  = 365
+	bonusHuntingClaims = 2
+	bonusExpireBatch   = 1_000
+)
+
+var bonusCodeRe = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// BonusCampaign is a promo users claim by code.
+type BonusCampaign struct {
+	CampaignID int64      `json:"campaign_id"`
+	Code       string     `json:"code"`
+	Kind       string     `json:"kind"`
+	MatchBP    int64      `json:"match_bp,omitempty"`
+	MaxBonus   int64      `json:"max_bonus,omitempty"`
+	MinDeposit int64      `json:"min_deposit,omitempty"`
+	FreeBet    int64      `json:"free_bet,omitempty"`
+	WagerX     int64      `json:"wager_x"`
+	MinOdds    float64    `json:"min_odds"`          // bets at lower odds do not count towards wagering
+	MaxBet     int64      `json:"max_bet,omitempty"` // cap on bets the bonus balance pays for
+	ValidDays  int64      `json:"valid_days"`
+	MaxClaims  int64      `json:"max_claims,omitempty"`
+	Claims     int64      `json:"claims"`
+	Active     bool       `json:"active"`
+	StartsAt   time.Time  `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (c BonusCampaign) validate() error {
+	switch {
+	case !bonusCodeRe.MatchString(c.Code):
+		return errors.New("bad code")
+	case c.Kind == BonusDepositMatch && (c.MatchBP <= 0 || c.MatchBP > maxBonusMatchBP || c.MaxBonus <= 0 || c.MinDeposit < 0):
+		return errors.New("bad deposit match terms")
+	case c.Kind == BonusFreeBet && c.FreeBet <= 0:
+		return errors.New("bad free_bet")
+	case c.Kind != BonusDepositMatch && c.Kind != BonusFreeBet:
+		return errors.New("bad kind")
+	case c.WagerX < 0 || c.WagerX > maxBonusWagerX:
+		return errors.New("bad wager_x")
+	case c.MinOdds < 1:
+		return errors.New("bad min_odds")
+	case c.MaxBet < 0 || c.MaxClaims < 0:
+		return errors.New("bad limits")
+	case c.ValidDays < 1 || c.ValidDays > maxBonusValidDays:
+		return errors.New("bad valid_days")
+	case c.EndsAt != nil && !c.EndsAt.After(c.StartsAt):
+		return errors.New("bad ends_at")
+	}
+	return nil
+}
+
+// depositMatch is the bonus a deposit earns; 0 below MinDeposit.
+func depositMatch(c BonusCampaign, deposit int64) int64 {
+	if deposit <= 0 || deposit < c.MinDeposit {
+		return 0
+	}
+	return min(deposit*c.MatchBP/10_000, c.MaxBonus)
+}
+
+// bonusShortfall is the part of a stake the real balance cannot pay.
+func bonusShortfall(stake, spendable int64) int64 {
+	return stake - min(stake, Spendable(spendable))
+}
+
+// bonusWinShare is the part of a win that goes back to the bonus balance: a
+// free bet keeps only the winnings, a mixed stake splits the win pro rata.
+func bonusWinShare(win, stake, bonusStake int64, freeBet bool) int64 {
+	if win <= 0 || stake <= 0 {
+		return 0
+	}
+	if freeBet {
+		return max(win-stake, 0)
+	}
+	return win * bonusStake / stake
+}
+
+// UserBonus is a claimed bonus.
+type UserBonus struct {
+	BonusID       int64      `json:"bonus_id"`
+	UserID        int64      `json:"user_id"`
+	CampaignID    int64      `json:"campaign_id"`
+	Code          string     `json:"code"`
+	Kind          string     `json:"kind"`
+	Granted       int64      `json:"granted"`
+	Balance       int64      `json:"balance"`
+	FreeBet       int64      `json:"free_bet"`
+	WagerRequired int64      `json:"wager_required"`
+	Wagered       int64      `json:"wagered"`
+	MinOdds       float64    `json:"min_odds"`
+	MaxBet        int64      `json:"max_bet,omitempty"`
+	Status        string     `json:"status"`
+	Flags         []string   `json:"flags"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	ClosedAt      *time.Time `json:"closed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+const bonusCampaignCols = `campaign_id, code, kind, match_bp, max_bonus, min_deposit, free_bet, wager_x, min_odds, max_bet,
+  valid_days, max_claims, claims, active, starts_at, ends_at, created_at`
+
+func scanBonusCampaign(row pgx.Row) (BonusCampaign, error) {
+	var c BonusCampaign
+	err := row.Scan(&c.CampaignID, &c.Code, &c.Kind, &c.MatchBP, &c.MaxBonus, &c.MinDeposit, &c.FreeBet, &c.WagerX, &c.MinOdds, &c.MaxBet,
+		&c.ValidDays, &c.MaxClaims, &c.Claims, &c.Active, &c.StartsAt, &c.EndsAt, &c.CreatedAt)
+	return c, err
+}
+
+const userBonusCols = `b.bonus_id, b.user_id, b.campaign_id, c.code, b.kind, b.granted, b.balance, b.free_bet, b.wager_required, b.wagered,
+  c.min_odds, c.max_bet, b.status, b.flags, b.expires_at, b.closed_at, b.created_at`
+
+func scanUserBonus(row pgx.Row) (UserBonus, error) {
+	var b UserBonus
+	err := row.Scan(&b.BonusID, &b.UserID, &b.CampaignID, &b.Code, &b.Kind, &b.Granted, &b.Balance, &b.FreeBet, &b.WagerRequired, &b.Wagered,
+		&b.MinOdds, &b.MaxBet, &b.Status, &b.Flags, &b.ExpiresAt, &b.ClosedAt, &b.CreatedAt)
+	return b, err
+}
+
+// CreateBonusCampaign adds a campaign; ErrAlreadyExists if the code is taken.
+func (d *DB) CreateBonusCampaign(ctx context.Context, adminID int64, c BonusCampaign) (BonusCampaign, error) {
+	if c.StartsAt.IsZero() {
+		c.StartsAt = time.Now().UTC()
+	}
+	if err := c.normalize(); err != nil {
+		return BonusCampaign{}, err
+	}
+	return insertBonusCampaign(ctx, d.Pool, adminID, c)
+}
+
+// normalize fills the defaults of an admin-supplied campaign, drops the terms
+// of the other kind and validates it. StartsAt must be set.
+func (c *BonusCampaign) normalize() error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	if c.MinOdds == 0 {
+		c.MinOdds = 1
+	}
+	if c.Kind == BonusFreeBet {
+		c.MatchBP, c.MaxBonus, c.MinDeposit = 0, 0, 0
+	} else {
+		c.FreeBet = 0
+	}
+	return c.validate()
+}
+
+func insertBonusCampaign(ctx context.Context, q rowQuerier, adminID int64, c BonusCampaign) (BonusCampaign, error) {
+	out, err := scanBonusCampaign(q.QueryRow(ctx, `
+INSERT INTO bonus_campaigns(code, kind, match_bp, max_bonus, min_deposit, free_bet, wager_x, min_odds, max_bet, valid_days, max_claims, starts_at, ends_at, created_by)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+ON CONFLICT (code) DO NOTHING
+RETURNING `+bonusCampaignCols,
+		c.Code, c.Kind, c.MatchBP, c.MaxBonus, c.MinDeposit, c.FreeBet, c.WagerX, c.MinOdds, c.MaxBet, c.ValidDays, c.MaxClaims, c.StartsAt, c.EndsAt, adminID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return BonusCampaign{}, ErrAlreadyExists
+	}
+	return out, err
+}
+
+// ListBonusCampaigns returns every campaign, newest first.
+func (d *DB) ListBonusCampaigns(ctx context.Context) ([]BonusCampaign, error) {
+	return listBonusCampaigns(ctx, d.Pool)
+}
+
+func listBonusCampaigns(ctx context.Context, q rowsQuerier) ([]BonusCampaign, error) {
+	rows, err := q.Query(ctx, `SELECT `+bonusCampaignCols+` FROM bonus_campaigns ORDER BY campaign_id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BonusCampaign{}
+	for rows.Next() {
+		c, err := scanBonusCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SetBonusCampaignActive stops or resumes claims; claimed bonuses run on.
+func (d *DB) SetBonusCampaignActive(ctx context.Context, campaignID int64, active bool) error {
+	tag, err := d.Pool.Exec(ctx, `UPDATE bonus_campaigns SET active = $2 WHERE campaign_id = $1`, campaignID, active)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimBonus claims the campaign of code for userID from ip. A free bet is
+// granted at once, a deposit match waits for the next deposit. Claims with
+// abuse flags are held for review. ErrAlreadyExists if already claimed.
+func (d *DB) ClaimBonus(ctx context.Context, userID int64, code, ip string) (UserBonus, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !bonusCodeRe.MatchString(code) {
+		return UserBonus{}, errors.New("bad code")
+	}
+	var bonusID int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		c, err := scanBonusCampaign(tx.QueryRow(ctx, `SELECT `+bonusCampaignCols+` FROM bonus_campaigns WHERE code = $1 FOR UPDATE`, code))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if !c.Active || now.Before(c.StartsAt) || (c.EndsAt != nil && !now.Before(*c.EndsAt)) || (c.MaxClaims > 0 && c.Claims >= c.MaxClaims) {
+			return errors.New("bad code: the promo is not running")
+		}
+
+		flags := []string{}
+		var sharedIP bool
+		if err := tx.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM user_bonuses WHERE campaign_id = $1 AND claim_ip = $2 AND claim_ip <> '' AND user_id <> $3)
+`, c.CampaignID, ip, userID).Scan(&sharedIP); err != nil {
+			return err
+		}
+		if sharedIP {
+			flags = append(flags, BonusFlagSharedIP)
+		}
+		var idle int64
+		if err := tx.QueryRow(ctx, `
+SELECT COUNT(*) FROM user_bonuses WHERE user_id = $1 AND status = 'expired' AND wagered = 0 AND granted > 0
+`, userID).Scan(&idle); err != nil {
+			return err
+		}
+		if idle >= bonusHuntingClaims {
+			flags = append(flags, BonusFlagHunting)
+		}
+
+		status := BonusPending
+		if c.Kind == BonusFreeBet {
+			status = BonusActive
+			if len(flags) > 0 {
+				status = BonusReview
+			}
+		}
+		err = tx.QueryRow(ctx, `
+INSERT INTO user_bonuses(user_id, campaign_id, kind, status, flags, claim_ip, expires_at)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id, campaign_id) DO NOTHING
+RETURNING bonus_id
+`, userID, c.CampaignID, c.Kind, status, flags, ip, now.AddDate(0, 0, int(c.ValidDays))).Scan(&bonusID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bonus_campaigns SET claims = claims + 1 WHERE campaign_id = $1`, c.CampaignID); err != nil {
+			return err
+		}
+		if c.Kind != BonusFreeBet {
+			return nil
+		}
+		if err := grantBonusTx(ctx, tx, bonusID, userID, c.FreeBet, map[string]any{"code": c.Code, "free_bet": true}); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE user_bonuses SET granted = $2, free_bet = $2 WHERE bonus_id = $1`, bonusID, c.FreeBet)
+		return err
+	})
+	if err != nil {
+		return UserBonus{}, err
+	}
+	return scanUserBonus(d.Pool.QueryRow(ctx, `
+SELECT `+userBonusCols+` FROM user_bonuses b JOIN bonus_campaigns c ON c.campaign_id = b.campaign_id WHERE b.bonus_id = $1
+`, bonusID))
+}
+
+// grantBonusTx moves amount from the reserve to the bonus; it counts
+// against today's emission.
+func grantBonusTx(ctx context.Context, tx pgx.Tx, bonusID, userID, amount int64, meta map[string]any) error {
+	var reserve, reserved int64
+	if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+		return err
+	}
+	if reserve-reserved < amount {
+		return ErrNotEnough
+	}
+	if err := chargeEmissionTx(ctx, tx, amount); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply - $1, updated_at = now() WHERE id=1`, amount); err != nil {
+		return err
+	}
+	meta["bonus_id"] = bonusID
+	if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bonus_grant', NULL, $1, $2, $3::jsonb)`,
+		userID, amount, toJSON(meta)); err != nil {
+		return err
+	}
+	return addUserEventTx(ctx, tx, userID, "bonus_granted", map[string]any{"bonus_id": bonusID, "amount": amount})
+}
+
+// returnBonusTx moves what is left of a closed bonus back to the reserve.
+func returnBonusTx(ctx context.Context, tx pgx.Tx, bonusID, userID, amount int64, kind string) error {
+	if amount <= 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at = now() WHERE id=1`, amount); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES($1, $2, NULL, $3, $4::jsonb)`,
+		kind, userID, amount, toJSON(map[string]any{"bonus_id": bonusID}))
+	return err
+}
+
+// grantDepositMatchTx grants the user's oldest pending deposit match on an
+// approved deposit of coins. A deposit below the minimum, or a reserve or
+// daily emission that cannot pay the bonus, leaves it pending; the deposit
+// itself never fails.
+func grantDepositMatchTx(ctx context.Context, tx pgx.Tx, userID int64, depositRef string, coins int64) error {
+	var bonusID int64
+	var flags []string
+	var c BonusCampaign
+	err := tx.QueryRow(ctx, `
+SELECT b.bonus_id, b.flags, c.code, c.match_bp, c.max_bonus, c.min_deposit, c.wager_x, c.valid_days
+FROM user_bonuses b JOIN bonus_campaigns c ON c.campaign_id = b.campaign_id
+WHERE b.user_id = $1 AND b.status = 'pending' AND b.expires_at > now()
+ORDER BY b.created_at
+LIMIT 1
+FOR UPDATE OF b
+`, userID).Scan(&bonusID, &flags, &c.Code, &c.MatchBP, &c.MaxBonus, &c.MinDeposit, &c.WagerX, &c.ValidDays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	amount := depositMatch(c, coins)
+	if amount == 0 {
+		return nil
+	}
+	err = grantBonusTx(ctx, tx, bonusID, userID, amount, map[string]any{"code": c.Code, "deposit": depositRef, "deposit_coins": coins})
+	if errors.Is(err, ErrNotEnough) || errors.Is(err, ErrEmissionCap) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status := BonusActive
+	if len(flags) > 0 {
+		status = BonusReview
+	}
+	if _, err := tx.Exec(ctx, `
+UPDATE user_bonuses
+SET granted = $2, balance = $2, wager_required = $2 * $3, status = $4, deposit_ref = $5, expires_at = now() + make_interval(days => $6::int)
+WHERE bonus_id = $1
+`, bonusID, amount, c.WagerX, status, depositRef, c.ValidDays); err != nil {
+		return err
+	}
+	return completeBonusTx(ctx, tx, bonusID)
+}
+
+// BonusStake is the part of a bet the bonus pays for.
+type BonusStake struct {
+	BonusID int64
+	Bonus   int64 // from the bonus balance or the free bet; the rest comes from the real balance
+	FreeBet bool
+}
+
+// BonusStakeTx funds a game bet of amount at odds. A free bet pays the whole
+// stake, which must equal the free bet; otherwise the bonus balance pays what
+// the spendable real balance cannot (ErrNotEnough if neither can). Every bet
+// but a free bet counts towards wagering. The game settles the bet with
+// SettleBonusBetTx or SettleLostBonusBetsTx under the same betRef.
+func BonusStakeTx(ctx context.Context, tx pgx.Tx, userID int64, betRef string, amount int64, odds float64, freeBet bool, spendable int64) (BonusStake, error) {
+	var out BonusStake
+	if freeBet {
+		var stake int64
+		err := tx.QueryRow(ctx, `
+SELECT bonus_id, free_bet FROM user_bonuses
+WHERE user_id = $1 AND status = 'active' AND free_bet > 0 AND expires_at > now()
+ORDER BY created_at
+LIMIT 1
+FOR UPDATE
+`, userID).Scan(&out.BonusID, &stake)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return out, errors.New("bad free_bet: no free bet available")
+		}
+		if err != nil {
+			return out, err
+		}
+		if amount != stake {
+			return out, fmt.Errorf("bad amount: the free bet is %d", stake)
+		}
+		if _, err := tx.Exec(ctx, `UPDATE user_bonuses SET free_bet = 0 WHERE bonus_id = $1`, out.BonusID); err != nil {
+			return out, err
+		}
+		out.Bonus, out.FreeBet = amount, true
+	} else if need := bonusShortfall(amount, spendable); need > 0 {
+		var balance, maxBet int64
+		err := tx.QueryRow(ctx, `
+SELECT b.bonus_id, b.balance, c.max_bet
+FROM user_bonuses b JOIN bonus_campaigns c ON c.campaign_id = b.campaign_id
+WHERE b.user_id = $1 AND b.status = 'active' AND b.balance > 0 AND b.expires_at > now()
+ORDER BY b.created_at
+LIMIT 1
+FOR UPDATE OF b
+`, userID).Scan(&out.BonusID, &balance, &maxBet)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && balance < need) {
+			return out, ErrNotEnough
+		}
+		if err != nil {
+			return out, err
+		}
+		if maxBet > 0 && amount > maxBet {
+			return out, &BetLimitError{Rule: BetRuleBonus, Limit: maxBet, Amount: amount}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE user_bonuses SET balance = balance - $2 WHERE bonus_id = $1`, out.BonusID, need); err != nil {
+			return out, err
+		}
+		out.Bonus = need
+	}
+
+	if out.Bonus > 0 {
+		if _, err := tx.Exec(ctx, `
+INSERT INTO bonus_bets(bet_ref, bonus_id, user_id, stake, bonus_stake, free_bet) VALUES($1, $2, $3, $4, $5, $6)
+`, betRef, out.BonusID, userID, amount, out.Bonus, out.FreeBet); err != nil {
+			return out, err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bonus_stake', $1, NULL, $2, $3::jsonb)`,
+			userID, out.Bonus, toJSON(map[string]any{"bonus_id": out.BonusID, "bet_ref": betRef, "free_bet": out.FreeBet})); err != nil {
+			return out, err
+		}
+	}
+	if out.FreeBet {
+		return out, nil
+	}
+	return out, wagerBonusTx(ctx, tx, userID, amount, odds)
+}
+
+// wagerBonusTx counts a bet towards the oldest bonus still being wagered,
+// unless its odds are below the campaign's MinOdds.
+func wagerBonusTx(ctx context.Context, tx pgx.Tx, userID, amount int64, odds float64) error {
+	var bonusID int64
+	err := tx.QueryRow(ctx, `
+UPDATE user_bonuses b SET wagered = LEAST(b.wagered + $2, b.wager_required)
+FROM bonus_campaigns c
+WHERE b.bonus_id = (
+  SELECT bonus_id FROM user_bonuses
+  WHERE user_id = $1 AND status = 'active' AND wagered < wager_required
+  ORDER BY created_at
+  LIMIT 1
+) AND c.campaign_id = b.campaign_id AND $3 >= c.min_odds
+RETURNING b.bonus_id
+`, userID, amount, odds).Scan(&bonusID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return completeBonusTx(ctx, tx, bonusID)
+}
+
+// SettleBonusBetTx settles a bonus-funded bet that won win (its whole
+// payout): the bonus share of it goes to the bonus balance and is returned,
+// the caller credits the rest to the real balance. Free bet winnings add
+// WagerX times themselves to the requirement. 0 for a bet without a bonus.
+func SettleBonusBetTx(ctx context.Context, tx pgx.Tx, betRef string, win int64) (int64, error) {
+	var bonusID, userID, stake, bonusStake int64
+	var freeBet bool
+	err := tx.QueryRow(ctx, `
+SELECT bonus_id, user_id, stake, bonus_stake, free_bet FROM bonus_bets WHERE bet_ref = $1 AND settled_at IS NULL FOR UPDATE
+`, betRef).Scan(&bonusID, &userID, &stake, &bonusStake, &freeBet)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE bonus_bets SET settled_at = now() WHERE bet_ref = $1`, betRef); err != nil {
+		return 0, err
+	}
+	share := bonusWinShare(win, stake, bonusStake, freeBet)
+	if share > 0 {
+		if _, err := tx.Exec(ctx, `
+UPDATE user_bonuses b
+SET balance = b.balance + $2, wager_required = b.wager_required + CASE WHEN $3 THEN $2 * c.wager_x ELSE 0 END
+FROM bonus_campaigns c
+WHERE b.bonus_id = $1 AND c.campaign_id = b.campaign_id
+`, bonusID, share, freeBet); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bonus_win', NULL, $1, $2, $3::jsonb)`,
+			userID, share, toJSON(map[string]any{"bonus_id": bonusID, "bet_ref": betRef})); err != nil {
+			return 0, err
+		}
+	}
+	return share, completeBonusTx(ctx, tx, bonusID)
+}
+
+// SettleLostBonusBetsTx settles lost bets; a bonus with nothing left to play
+// is closed as lost.
+func SettleLostBonusBetsTx(ctx context.Context, tx pgx.Tx, betRefs []string) error {
+	if len(betRefs) == 0 {
+		return nil
+	}
+	rows, err := tx.Query(ctx, `
+UPDATE bonus_bets SET settled_at = now() WHERE bet_ref = ANY($1) AND settled_at IS NULL RETURNING bonus_id
+`, betRefs)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := completeBonusTx(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeBonusTx closes an active bonus without open bets: wagered, its
+// balance moves to the real balance; played out, it is lost.
+func completeBonusTx(ctx context.Context, tx pgx.Tx, bonusID int64) error {
+	var userID, balance, freeBet, required, wagered int64
+	var status string
+	var open bool
+	if err := tx.QueryRow(ctx, `
+SELECT user_id, balance, free_bet, wager_required, wagered, status,
+       EXISTS(SELECT 1 FROM bonus_bets WHERE bonus_id = $1 AND settled_at IS NULL)
+FROM user_bonuses WHERE bonus_id = $1 FOR UPDATE
+`, bonusID).Scan(&userID, &balance, &freeBet, &required, &wagered, &status, &open); err != nil {
+		return err
+	}
+	if status != BonusActive || freeBet > 0 || open {
+		return nil
+	}
+	switch {
+	case wagered >= required:
+		if balance > 0 {
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id = $2`, balance, userID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bonus_convert', NULL, $1, $2, $3::jsonb)`,
+				userID, balance, toJSON(map[string]any{"bonus_id": bonusID})); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE user_bonuses SET status = 'completed', balance = 0, closed_at = now() WHERE bonus_id = $1`, bonusID); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, userID, "bonus_completed", map[string]any{"bonus_id": bonusID, "amount": balance})
+	case balance == 0:
+		_, err := tx.Exec(ctx, `UPDATE user_bonuses SET status = 'lost', closed_at = now() WHERE bonus_id = $1`, bonusID)
+		return err
+	}
+	return nil
+}
+
+// ListUserBonuses returns the user's bonuses, open ones first.
+func (d *DB) ListUserBonuses(ctx context.Context, userID int64) ([]UserBonus, error) {
+	return d.listBonuses(ctx, `b.user_id = $1`, `b.closed_at IS NOT NULL, b.created_at DESC`, userID)
+}
+
+// ListBonuses returns bonuses with status ("" = any) for the admin, newest
+// first.
+func (d *DB) ListBonuses(ctx context.Context, status string, limit int) ([]UserBonus, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return d.listBonuses(ctx, `($1 = '' OR b.status = $1)`, fmt.Sprintf(`b.bonus_id DESC LIMIT %d`, limit), status)
+}
+
+func (d *DB) listBonuses(ctx context.Context, where, order string, arg any) ([]UserBonus, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+userBonusCols+`
+FROM user_bonuses b JOIN bonus_campaigns c ON c.campaign_id = b.campaign_id
+WHERE `+where+`
+ORDER BY `+order, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []UserBonus{}
+	for rows.Next() {
+		b, err := scanUserBonus(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ReviewBonus decides a bonus held for review, or forfeits any open one:
+// "release" lets it be played, "forfeit" returns what is left to the reserve.
+// A bonus with open bets cannot be forfeited.
+func (d *DB) ReviewBonus(ctx context.Context, adminID, bonusID int64, verdict string) error {
+	if verdict != "release" && verdict != "forfeit" {
+		return errors.New("bad verdict")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var userID, left int64
+		var status string
+		var open bool
+		if err := tx.QueryRow(ctx, `
+SELECT user_id, balance + free_bet, status, EXISTS(SELECT 1 FROM bonus_bets WHERE bonus_id = $1 AND settled_at IS NULL)
+FROM user_bonuses WHERE bonus_id = $1 FOR UPDATE
+`, bonusID).Scan(&userID, &left, &status, &open); err != nil {
+			return err
+		}
+		if verdict == "release" {
+			if status != BonusReview {
+				return errors.New("bad status: the bonus is not held")
+			}
+			if _, err := tx.Exec(ctx, `UPDATE user_bonuses SET status = 'active', reviewed_by = $2 WHERE bonus_id = $1`, bonusID, adminID); err != nil {
+				return err
+			}
+			return completeBonusTx(ctx, tx, bonusID)
+		}
+		if status != BonusPending && status != BonusActive && status != BonusReview {
+			return errors.New("bad status: the bonus is closed")
+		}
+		if open {
+			return errors.New("bad status: the bonus has open bets")
+		}
+		if err := returnBonusTx(ctx, tx, bonusID, userID, left, "bonus_forfeit"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+UPDATE user_bonuses SET status = 'forfeited', balance = 0, free_bet = 0, reviewed_by = $2, closed_at = now() WHERE bonus_id = $1
+`, bonusID, adminID)
+		return err
+	})
+}
+
+// ExpireBonuses closes open bonuses past their expiry that have no open
+// bets and returns what is left of them to the reserve.
+func (d *DB) ExpireBonuses(ctx context.Context, now time.Time) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT bonus_id, user_id, balance + free_bet
+FROM user_bonuses b
+WHERE status IN ('pending','active','review') AND expires_at <= $1
+  AND NOT EXISTS(SELECT 1 FROM bonus_bets WHERE bonus_id = b.bonus_id AND settled_at IS NULL)
+ORDER BY bonus_id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`, now, bonusExpireBatch)
+		if err != nil {
+			return err
+		}
+		type expired struct{ bonusID, userID, left int64 }
+		var list []expired
+		for rows.Next() {
+			var e expired
+			if err := rows.Scan(&e.bonusID, &e.userID, &e.left); err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, e := range list {
+			if err := returnBonusTx(ctx, tx, e.bonusID, e.userID, e.left, "bonus_expire"); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `
+UPDATE user_bonuses SET status = 'expired', balance = 0, free_bet = 0, closed_at = $2 WHERE bonus_id = $1
+`, e.bonusID, now); err != nil {
+				return err
+			}
+		}
+		n = int64(len(list))
+		return nil
+	})
+	return n, err
+}
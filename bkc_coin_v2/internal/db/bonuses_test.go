@@ -0,0 +1,86 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepositMatch(t *testing.T) {
+	c := BonusCampaign{MatchBP: 10_000, MaxBonus: 50_000, MinDeposit: 1_000}
+	cases := []struct{ deposit, want int64 }{
+		{999, 0},
+		{1_000, 1_000},
+		{20_000, 20_000},
+		{80_000, 50_000}, // capped
+	}
+	for _, tc := range cases {
+		if got := depositMatch(c, tc.deposit); got != tc.want {
+			t.Fatalf("match of %d = %d, want %d", tc.deposit, got, tc.want)
+		}
+	}
+	c.MatchBP = 5_000
+	if got := depositMatch(c, 10_000); got != 5_000 {
+		t.Fatalf("50%% match of 10000 = %d", got)
+	}
+}
+
+func TestBonusShortfall(t *testing.T) {
+	cases := []struct{ stake, spendable, want int64 }{
+		{100, 500, 0},
+		{100, 100, 0},
+		{100, 30, 70},
+		{100, -20, 100}, // a negative balance pays nothing
+	}
+	for _, tc := range cases {
+		if got := bonusShortfall(tc.stake, tc.spendable); got != tc.want {
+			t.Fatalf("shortfall(%d, %d) = %d, want %d", tc.stake, tc.spendable, got, tc.want)
+		}
+	}
+}
+
+func TestBonusWinShare(t *testing.T) {
+	cases := []struct {
+		win, stake, bonusStake int64
+		freeBet                bool
+		want                   int64
+	}{
+		{0, 100, 100, false, 0},
+		{250, 100, 100, false, 250},
+		{250, 100, 40, false, 100},
+		{250, 100, 100, true, 150}, // a free bet keeps only the winnings
+		{80, 100, 100, true, 0},
+	}
+	for _, tc := range cases {
+		if got := bonusWinShare(tc.win, tc.stake, tc.bonusStake, tc.freeBet); got != tc.want {
+			t.Fatalf("share(%d, %d, %d, %t) = %d, want %d", tc.win, tc.stake, tc.bonusStake, tc.freeBet, got, tc.want)
+		}
+	}
+}
+
+func TestBonusCampaignValidate(t *testing.T) {
+	now := time.Now()
+	ok := []BonusCampaign{
+		{Code: "WELCOME100", Kind: BonusDepositMatch, MatchBP: 10_000, MaxBonus: 50_000, WagerX: 30, MinOdds: 1.5, ValidDays: 14, StartsAt: now},
+		{Code: "FREEBET", Kind: BonusFreeBet, FreeBet: 500, WagerX: 10, MinOdds: 1, ValidDays: 7, StartsAt: now},
+	}
+	for _, c := range ok {
+		if err := c.validate(); err != nil {
+			t.Fatalf("%s: %v", c.Code, err)
+		}
+	}
+	bad := ok[0]
+	bad.MaxBonus = 0
+	if bad.validate() == nil {
+		t.Fatal("deposit match without max bonus accepted")
+	}
+	bad = ok[1]
+	bad.MinOdds = 0.5
+	if bad.validate() == nil {
+		t.Fatal("min odds below 1 accepted")
+	}
+	bad = ok[1]
+	bad.Code = "free bet"
+	if bad.validate() == nil {
+		t.Fatal("bad code accepted")
+	}
+}
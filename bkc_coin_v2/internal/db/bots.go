@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrCaptchaRequired refuses bets until the user passes a CAPTCHA.
+var ErrCaptchaRequired = errors.New("captcha required")
+
+// Automated actions on a bot score.
+const (
+	BotActionNone    = "none"
+	BotActionLimit   = "limit"   // bets capped at BotPolicy.MaxBet
+	BotActionCaptcha = "captcha" // capped and a CAPTCHA before betting
+)
+
+// Bot review statuses.
+const (
+	BotOpen      = "open"
+	BotCleared   = "cleared"   // a human: no automated actions
+	BotConfirmed = "confirmed" // a bot: at least limited whatever the score
+)
+
+// BotPolicy tunes bot scoring of game players over the last Window.
+type BotPolicy struct {
+	Window       time.Duration
+	MinBets      int64 // players with fewer bets are not scored
+	LimitScore   int64 // from this score bets are capped
+	CaptchaScore int64 // from this score bets need a CAPTCHA (0 = never)
+	CaptchaValid time.Duration
+}
+
+// BotSignals are the behavioural facts a player is scored on.
+type BotSignals struct {
+	Bets          int64   `json:"bets"`
+	Cashouts      int64   `json:"cashouts"`        // manual cashouts with a measured latency
+	LatencyMeanMs float64 `json:"latency_mean_ms"` // from the round's launch to the cashout
+	LatencyCV     float64 `json:"latency_cv"`      // stddev / mean; humans are noisy
+	ActiveHours   int64   `json:"active_hours"`    // hours of the window with a bet
+	WindowHours   int64   `json:"window_hours"`
+	TopPattern    int64   `json:"top_pattern"` // bets with the most common amount and auto cashout
+}
+
+const botMinCashouts = 20
+
+// botScore rates signals 0..100: uniform or inhumanly fast cashouts up to 40,
+// round-the-clock play up to 30 and repeating the same bet up to 30.
+func botScore(s BotSignals) (int64, []string) {
+	var score int64
+	reasons := []string{}
+	add := func(points int64, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+	if s.Cashouts >= botMinCashouts && s.LatencyMeanMs > 0 {
+		var lat int64
+		switch {
+		case s.LatencyCV <= 0.05:
+			lat = 30
+		case s.LatencyCV <= 0.15:
+			lat = 15
+		}
+		if lat > 0 {
+			add(lat, "latency_uniform")
+		}
+		if s.LatencyMeanMs < 200 {
+			add(min(40-lat, 20), "latency_fast")
+		}
+	}
+	if s.WindowHours > 0 {
+		switch share := float64(s.ActiveHours) / float64(s.WindowHours); {
+		case share >= 0.75:
+			add(30, "always_on")
+		case share >= 0.5:
+			add(15, "long_sessions")
+		}
+	}
+	if s.Bets > 0 {
+		switch share := float64(s.TopPattern) / float64(s.Bets); {
+		case share >= 0.9:
+			add(30, "identical_bets")
+		case share >= 0.75:
+			add(15, "repetitive_bets")
+		}
+	}
+	return min(score, 100), reasons
+}
+
+// botAction is the automated action for a score.
+func (p BotPolicy) botAction(score int64) string {
+	switch {
+	case p.CaptchaScore > 0 && score >= p.CaptchaScore:
+		return BotActionCaptcha
+	case p.LimitScore > 0 && score >= p.LimitScore:
+		return BotActionLimit
+	}
+	return BotActionNone
+}
+
+// BotScore is a player's latest score and what is done about it.
+type BotScore struct {
+	UserID          int64      `json:"user_id"`
+	Score           int64      `json:"score"`
+	Reasons         []string   `json:"reasons"`
+	Signals         BotSignals `json:"signals"`
+	Action          string     `json:"action"`
+	CaptchaRequired bool       `json:"captcha_required"`
+	CaptchaPassedAt *time.Time `json:"captcha_passed_at,omitempty"`
+	Status          string     `json:"status"`
+	ReviewedBy      *int64     `json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	ScoredAt        time.Time  `json:"scored_at"`
+}
+
+const botScanLimit = 10_000
+
+// ScoreGameBots rescores every player with at least MinBets crash bets in
+// the window and applies the automated actions; players no longer scored
+// lose them unless an admin confirmed them. Returns the players at or above
+// LimitScore.
+func (d *DB) ScoreGameBots(ctx context.Context, now time.Time, p BotPolicy) (int64, error) {
+	if p.Window <= 0 {
+		return 0, nil
+	}
+	since := now.Add(-p.Window)
+	var flagged int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+WITH bets AS (
+  SELECT from_id AS user_id, amount, meta->>'auto_cashout' AS auto, ts
+  FROM ledger
+  WHERE kind = 'crash_bet' AND ts >= $1 AND ts < $2 AND from_id IS NOT NULL
+), players AS (
+  SELECT user_id, COUNT(*) AS bets, COUNT(DISTINCT date_trunc('hour', ts)) AS hours
+  FROM bets GROUP BY user_id HAVING COUNT(*) >= $3
+), top AS (
+  SELECT DISTINCT ON (user_id) user_id, n
+  FROM (SELECT user_id, COUNT(*) AS n FROM bets GROUP BY user_id, amount, auto) x
+  ORDER BY user_id, n DESC
+), lat AS (
+  SELECT to_id AS user_id, COUNT(*) AS n, AVG((meta->>'latency_ms')::float8) AS mean,
+         COALESCE(stddev_samp((meta->>'latency_ms')::float8), 0) AS sd
+  FROM ledger
+  WHERE kind = 'crash_win' AND ts >= $1 AND ts < $2 AND meta ? 'latency_ms'
+  GROUP BY to_id
+)
+SELECT pl.user_id, pl.bets, pl.hours, t.n, COALESCE(l.n, 0), COALESCE(l.mean, 0), COALESCE(l.sd, 0)
+FROM players pl
+JOIN top t ON t.user_id = pl.user_id
+LEFT JOIN lat l ON l.user_id = pl.user_id
+ORDER BY pl.bets DESC
+LIMIT $4
+`, since, now, max(p.MinBets, 1), botScanLimit)
+		if err != nil {
+			return err
+		}
+		type scored struct {
+			userID int64
+			s      BotSignals
+		}
+		var players []scored
+		for rows.Next() {
+			var userID int64
+			var sd float64
+			s := BotSignals{WindowHours: int64(p.Window / time.Hour)}
+			if err := rows.Scan(&userID, &s.Bets, &s.ActiveHours, &s.TopPattern, &s.Cashouts, &s.LatencyMeanMs, &sd); err != nil {
+				rows.Close()
+				return err
+			}
+			if s.LatencyMeanMs > 0 {
+				s.LatencyCV = sd / s.LatencyMeanMs
+			}
+			players = append(players, scored{userID, s})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		passedAfter := now.Add(-p.CaptchaValid)
+		for _, pl := range players {
+			score, reasons := botScore(pl.s)
+			action := p.botAction(score)
+			if _, err := tx.Exec(ctx, `
+INSERT INTO bot_scores(user_id, score, reasons, signals, action, captcha_required, scored_at)
+VALUES($1, $2, $3, $4::jsonb, $5, $5 = 'captcha', $6)
+ON CONFLICT (user_id) DO UPDATE SET
+  score = EXCLUDED.score, reasons = EXCLUDED.reasons, signals = EXCLUDED.signals, scored_at = EXCLUDED.scored_at,
+  action = CASE
+    WHEN bot_scores.status = 'cleared' THEN 'none'
+    WHEN bot_scores.status = 'confirmed' AND EXCLUDED.action = 'none' THEN 'limit'
+    ELSE EXCLUDED.action END,
+  captcha_required = bot_scores.status <> 'cleared' AND EXCLUDED.action = 'captcha'
+    AND (bot_scores.captcha_passed_at IS NULL OR bot_scores.captcha_passed_at < $7)
+`, pl.userID, score, reasons, toJSON(pl.s), action, now, passedAfter); err != nil {
+				return err
+			}
+			if p.LimitScore > 0 && score >= p.LimitScore {
+				flagged++
+			}
+		}
+
+		_, err = tx.Exec(ctx, `
+UPDATE bot_scores SET action = 'none', captcha_required = false
+WHERE scored_at < $1 AND status = 'open' AND action <> 'none'
+`, now)
+		return err
+	})
+	return flagged, err
+}
+
+// ListBotScores returns the review list: players with the given status
+// ("" = any) scoring at least minScore, highest first.
+func (d *DB) ListBotScores(ctx context.Context, status string, minScore int64, limit int) ([]BotScore, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT user_id, score, reasons, signals, action, captcha_required, captcha_passed_at, status, reviewed_by, reviewed_at, scored_at
+FROM bot_scores
+WHERE ($1 = '' OR status = $1) AND score >= $2
+ORDER BY score DESC, user_id
+LIMIT $3
+`, status, minScore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BotScore{}
+	for rows.Next() {
+		var b BotScore
+		if err := rows.Scan(&b.UserID, &b.Score, &b.Reasons, &b.Signals, &b.Action, &b.CaptchaRequired, &b.CaptchaPassedAt,
+			&b.Status, &b.ReviewedBy, &b.ReviewedAt, &b.ScoredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ReviewBotScore records an admin verdict: cleared lifts the automated
+// actions for good, confirmed keeps the player limited whatever the score.
+func (d *DB) ReviewBotScore(ctx context.Context, adminID, userID int64, verdict string) error {
+	if verdict != BotCleared && verdict != BotConfirmed {
+		return errors.New("bad verdict")
+	}
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE bot_scores SET status = $3, reviewed_by = $2, reviewed_at = now(),
+  action = CASE WHEN $3 = 'cleared' THEN 'none' WHEN action = 'none' THEN 'limit' ELSE action END,
+  captcha_required = captcha_required AND $3 <> 'cleared'
+WHERE user_id = $1
+`, userID, adminID, verdict)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// PassBotCaptcha lifts the CAPTCHA requirement after a solved challenge.
+func (d *DB) PassBotCaptcha(ctx context.Context, userID int64) error {
+	_, err := d.Pool.Exec(ctx, `
+UPDATE bot_scores SET captcha_required = false, captcha_passed_at = now() WHERE user_id = $1
+`, userID)
+	return err
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBotScore(t *testing.T) {
+	human := BotSignals{Bets: 300, Cashouts: 120, LatencyMeanMs: 2_400, LatencyCV: 0.6, ActiveHours: 20, WindowHours: 72, TopPattern: 90}
+	if s, reasons := botScore(human); s != 0 || len(reasons) != 0 {
+		t.Fatalf("human scored %d %v", s, reasons)
+	}
+
+	bot := BotSignals{Bets: 3_000, Cashouts: 900, LatencyMeanMs: 120, LatencyCV: 0.02, ActiveHours: 70, WindowHours: 72, TopPattern: 2_950}
+	s, reasons := botScore(bot)
+	if s != 100 {
+		t.Fatalf("bot scored %d %v, want 100", s, reasons)
+	}
+	for _, r := range []string{"latency_uniform", "latency_fast", "always_on", "identical_bets"} {
+		if !slices.Contains(reasons, r) {
+			t.Fatalf("bot reasons %v miss %s", reasons, r)
+		}
+	}
+
+	// Too few cashouts: latency is not judged.
+	few := bot
+	few.Cashouts = botMinCashouts - 1
+	if s, _ := botScore(few); s != 60 {
+		t.Fatalf("few cashouts scored %d, want 60", s)
+	}
+
+	p := BotPolicy{LimitScore: 50, CaptchaScore: 70}
+	for score, want := range map[int64]string{0: BotActionNone, 49: BotActionNone, 50: BotActionLimit, 70: BotActionCaptcha, 100: BotActionCaptcha} {
+		if got := p.botAction(score); got != want {
+			t.Fatalf("action(%d) = %s, want %s", score, got, want)
+		}
+	}
+	if got := (BotPolicy{LimitScore: 50}).botAction(100); got != BotActionLimit {
+		t.Fatalf("no captcha score: action %s, want %s", got, BotActionLimit)
+	}
+}
@@ -0,0 +1,405 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Bundles sell several NFTs and market items of one seller together at one
+// price. Listing a bundle reserves its components: NFT copies count as
+// listed (like an NFT listing) and market listings move to status
+// 'bundled'. A purchase moves every component in one transaction or none.
+// The price is split over the components by their reference value (item
+// price, NFT mint price times copies) and each component gets its own
+// ledger lines; NFT components pay royalty and the market fee like an NFT
+// sale.
+
+// Bundle component kinds.
+const (
+	BundleNFT  = "nft"
+	BundleItem = "item" // a market listing
+)
+
+const (
+	minBundleComponents = 2
+	maxBundleComponents = 10
+)
+
+// Bundle is a multi-component listing.
+type Bundle struct {
+	BundleID   int64             `json:"bundle_id"`
+	SellerID   int64             `json:"seller_id"`
+	Title      string            `json:"title"`
+	PriceCoins int64             `json:"price_coins"`
+	Status     string            `json:"status"` // active | sold | cancelled
+	BuyerID    *int64            `json:"buyer_id,omitempty"`
+	Components []BundleComponent `json:"components"`
+	CreatedAt  time.Time         `json:"created_at"`
+	SoldAt     *time.Time        `json:"sold_at,omitempty"`
+}
+
+// BundleComponent is one NFT (NFTID, Qty) or market item (ListingID).
+type BundleComponent struct {
+	Kind      string `json:"kind"`
+	NFTID     int64  `json:"nft_id,omitempty"`
+	Qty       int64  `json:"qty,omitempty"`
+	ListingID int64  `json:"listing_id,omitempty"`
+	Title     string `json:"title"`
+	Share     int64  `json:"share"` // part of the bundle price
+}
+
+// validateBundle checks the components; NFT quantity defaults to one.
+func validateBundle(title string, price int64, comps []BundleComponent) error {
+	if strings.TrimSpace(title) == "" || len(title) > 120 || price <= 0 {
+		return errors.New("bad params")
+	}
+	if len(comps) < minBundleComponents || len(comps) > maxBundleComponents {
+		return errors.New("bad components: count")
+	}
+	seen := map[[2]int64]bool{}
+	for i := range comps {
+		c := &comps[i]
+		var key [2]int64
+		switch c.Kind {
+		case BundleNFT:
+			if c.Qty == 0 {
+				c.Qty = 1
+			}
+			if c.NFTID <= 0 || c.Qty < 0 || c.ListingID != 0 {
+				return errors.New("bad components")
+			}
+			key = [2]int64{0, c.NFTID}
+		case BundleItem:
+			if c.ListingID <= 0 || c.NFTID != 0 {
+				return errors.New("bad components")
+			}
+			c.Qty = 0
+			key = [2]int64{1, c.ListingID}
+		default:
+			return errors.New("bad components: kind")
+		}
+		if seen[key] {
+			return errors.New("bad components: duplicate")
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// allocateBundlePrice splits price over components by weight, rounding
+// down; the last component takes the remainder. Zero weights split evenly.
+func allocateBundlePrice(price int64, weights []int64) []int64 {
+	var total int64
+	for _, w := range weights {
+		total += max(w, 0)
+	}
+	out := make([]int64, len(weights))
+	rest := price
+	for i, w := range weights {
+		if i == len(weights)-1 {
+			out[i] = rest
+			break
+		}
+		if total > 0 {
+			out[i] = price * max(w, 0) / total
+		} else {
+			out[i] = price / int64(len(weights))
+		}
+		rest -= out[i]
+	}
+	return out
+}
+
+// CreateBundle lists the seller's components together at price.
+func (d *DB) CreateBundle(ctx context.Context, sellerID int64, title string, price int64, comps []BundleComponent) (Bundle, error) {
+	title = strings.TrimSpace(title)
+	if err := validateBundle(title, price, comps); err != nil {
+		return Bundle{}, err
+	}
+	out := Bundle{SellerID: sellerID, Title: title, PriceCoins: price, Status: "active"}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		weights := make([]int64, len(comps))
+		for i := range comps {
+			c := &comps[i]
+			switch c.Kind {
+			case BundleNFT:
+				var owned, staked, listed, mintPrice int64
+				if err := tx.QueryRow(ctx, `
+SELECT o.qty, o.staked_qty, o.listed_qty, n.price_coins, n.title
+FROM nft_owns o JOIN nfts n ON n.nft_id = o.nft_id
+WHERE o.user_id=$1 AND o.nft_id=$2
+FOR UPDATE OF o
+`, sellerID, c.NFTID).Scan(&owned, &staked, &listed, &mintPrice, &c.Title); err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return ErrNotEnough
+					}
+					return err
+				}
+				if owned-staked-listed < c.Qty {
+					return ErrNotEnough
+				}
+				if _, err := tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=listed_qty+$1 WHERE user_id=$2 AND nft_id=$3`, c.Qty, sellerID, c.NFTID); err != nil {
+					return err
+				}
+				weights[i] = mintPrice * c.Qty
+			case BundleItem:
+				var owner int64
+				var status string
+				if err := tx.QueryRow(ctx, `SELECT seller_id, status, title, price_coins FROM market_listings WHERE listing_id=$1 FOR UPDATE`, c.ListingID).
+					Scan(&owner, &status, &c.Title, &weights[i]); err != nil {
+					return err
+				}
+				if owner != sellerID {
+					return ErrForbidden
+				}
+				if status != ListingActive {
+					return errors.New("bad components: item not active")
+				}
+				if _, err := tx.Exec(ctx, `UPDATE market_listings SET status='bundled' WHERE listing_id=$1`, c.ListingID); err != nil {
+					return err
+				}
+			}
+		}
+		for i, share := range allocateBundlePrice(price, weights) {
+			comps[i].Share = share
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO bundle_listings (seller_id, title, price_coins) VALUES ($1, $2, $3)
+RETURNING bundle_id, created_at
+`, sellerID, title, price).Scan(&out.BundleID, &out.CreatedAt); err != nil {
+			return err
+		}
+		for i, c := range comps {
+			if _, err := tx.Exec(ctx, `
+INSERT INTO bundle_items (bundle_id, seq, kind, nft_id, qty, listing_id, title, share)
+VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), $7, $8)
+`, out.BundleID, i+1, c.Kind, c.NFTID, c.Qty, c.ListingID, c.Title, c.Share); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Bundle{}, err
+	}
+	out.Components = comps
+	return out, nil
+}
+
+// bundleQuerier is a pool or a transaction.
+type bundleQuerier interface {
+	rowQuerier
+	effectsQuerier
+}
+
+func loadBundleTx(ctx context.Context, q bundleQuerier, bundleID int64, lock bool) (Bundle, error) {
+	var b Bundle
+	sql := `SELECT bundle_id, seller_id, title, price_coins, status, buyer_id, created_at, sold_at FROM bundle_listings WHERE bundle_id=$1`
+	if lock {
+		sql += ` FOR UPDATE`
+	}
+	if err := q.QueryRow(ctx, sql, bundleID).Scan(&b.BundleID, &b.SellerID, &b.Title, &b.PriceCoins, &b.Status, &b.BuyerID, &b.CreatedAt, &b.SoldAt); err != nil {
+		return Bundle{}, err
+	}
+	rows, err := q.Query(ctx, `
+SELECT kind, COALESCE(nft_id, 0), qty, COALESCE(listing_id, 0), title, share
+FROM bundle_items WHERE bundle_id=$1 ORDER BY seq
+`, bundleID)
+	if err != nil {
+		return Bundle{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c BundleComponent
+		if err := rows.Scan(&c.Kind, &c.NFTID, &c.Qty, &c.ListingID, &c.Title, &c.Share); err != nil {
+			return Bundle{}, err
+		}
+		b.Components = append(b.Components, c)
+	}
+	return b, rows.Err()
+}
+
+// GetBundle returns a bundle with its components.
+func (d *DB) GetBundle(ctx context.Context, bundleID int64) (Bundle, error) {
+	return loadBundleTx(ctx, d.Pool, bundleID, false)
+}
+
+// ListBundles returns active bundles, newest first, without components.
+func (d *DB) ListBundles(ctx context.Context, limit int64) ([]Bundle, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT bundle_id, seller_id, title, price_coins, status, created_at
+FROM bundle_listings WHERE status='active'
+ORDER BY bundle_id DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Bundle{}
+	for rows.Next() {
+		var b Bundle
+		if err := rows.Scan(&b.BundleID, &b.SellerID, &b.Title, &b.PriceCoins, &b.Status, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// CancelBundle withdraws an active bundle and frees its components.
+func (d *DB) CancelBundle(ctx context.Context, sellerID, bundleID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		b, err := loadBundleTx(ctx, tx, bundleID, true)
+		if err != nil {
+			return err
+		}
+		if b.SellerID != sellerID {
+			return ErrForbidden
+		}
+		if b.Status != "active" {
+			return ErrLocked
+		}
+		for _, c := range b.Components {
+			switch c.Kind {
+			case BundleNFT:
+				_, err = tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=GREATEST(listed_qty-$1, 0) WHERE user_id=$2 AND nft_id=$3`, c.Qty, sellerID, c.NFTID)
+			case BundleItem:
+				_, err = tx.Exec(ctx, `UPDATE market_listings SET status='active' WHERE listing_id=$1 AND status='bundled'`, c.ListingID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(ctx, `UPDATE bundle_listings SET status='cancelled' WHERE bundle_id=$1`, bundleID)
+		return err
+	})
+}
+
+// BuyBundle buys every component of a bundle or nothing. feeBP is the NFT
+// market fee, applied to NFT components.
+func (d *DB) BuyBundle(ctx context.Context, buyerID, bundleID, feeBP int64) (Bundle, error) {
+	var out Bundle
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		b, err := loadBundleTx(ctx, tx, bundleID, true)
+		if err != nil {
+			return err
+		}
+		if b.Status != "active" {
+			return ErrLocked
+		}
+		if b.SellerID == buyerID {
+			return ErrForbidden
+		}
+		if err := lockUsersTx(ctx, tx, buyerID, b.SellerID); err != nil {
+			return err
+		}
+		if err := debitSpendableTx(ctx, tx, buyerID, b.PriceCoins); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		eff, err := resolveUserNFTEffects(ctx, tx, b.SellerID)
+		if err != nil {
+			return err
+		}
+		nftFeeBP := ApplyFeeDiscount(feeBP, eff.FeeDiscountBP)
+		for i, c := range b.Components {
+			meta := map[string]any{"bundle_id": bundleID, "seq": i + 1, "kind": c.Kind, "share": c.Share}
+			proceeds := c.Share
+			switch c.Kind {
+			case BundleNFT:
+				meta["nft_id"], meta["qty"] = c.NFTID, c.Qty
+				if proceeds, err = settleBundleNFTTx(ctx, tx, b, c, buyerID, nftFeeBP, now, meta); err != nil {
+					return err
+				}
+			case BundleItem:
+				meta["listing_id"] = c.ListingID
+				tag, err := tx.Exec(ctx, `UPDATE market_listings SET status='sold', sold_at=$1, buyer_id=$2 WHERE listing_id=$3 AND status='bundled'`, now, buyerID, c.ListingID)
+				if err != nil {
+					return err
+				}
+				if tag.RowsAffected() == 0 {
+					return ErrNotEnough
+				}
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, proceeds, b.SellerID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bundle_buy', $1, $2, $3, $4::jsonb)`,
+				buyerID, b.SellerID, proceeds, toJSON(meta)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE bundle_listings SET status='sold', buyer_id=$2, sold_at=$3 WHERE bundle_id=$1`, bundleID, buyerID, now); err != nil {
+			return err
+		}
+		b.Status, b.BuyerID, b.SoldAt = "sold", &buyerID, &now
+		out = b
+		return addUserEventTx(ctx, tx, b.SellerID, "bundle_sold", map[string]any{"bundle_id": bundleID, "buyer_id": buyerID, "price": b.PriceCoins})
+	})
+	return out, err
+}
+
+// settleBundleNFTTx moves the copies of an NFT component to the buyer, pays
+// the royalty and fee out of its share and records the sale. It returns the
+// seller's proceeds.
+func settleBundleNFTTx(ctx context.Context, tx pgx.Tx, b Bundle, c BundleComponent, buyerID, feeBP int64, now time.Time, meta map[string]any) (int64, error) {
+	var creatorID *int64
+	var royaltyBP int64
+	if err := tx.QueryRow(ctx, `SELECT creator_id, royalty_bp FROM nfts WHERE nft_id=$1`, c.NFTID).Scan(&creatorID, &royaltyBP); err != nil {
+		return 0, err
+	}
+	if creatorID == nil || *creatorID == b.SellerID {
+		royaltyBP = 0
+	}
+	royalty, fee, proceeds := splitNFTSale(c.Share, royaltyBP, feeBP)
+
+	tag, err := tx.Exec(ctx, `
+UPDATE nft_owns SET qty=qty-$1, listed_qty=listed_qty-$1
+WHERE user_id=$2 AND nft_id=$3 AND listed_qty >= $1 AND qty >= $1
+`, c.Qty, b.SellerID, c.NFTID)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, ErrNotEnough
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $2, $3)
+ON CONFLICT (user_id, nft_id) DO UPDATE SET qty = nft_owns.qty + EXCLUDED.qty
+`, buyerID, c.NFTID, c.Qty); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO nft_sales (nft_id, seller_id, buyer_id, qty, price_coins, royalty, fee, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`, c.NFTID, b.SellerID, buyerID, c.Qty, c.Share/c.Qty, royalty, fee, now); err != nil {
+		return 0, err
+	}
+	if royalty > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, royalty, *creatorID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_royalty', $1, $2, $3, $4::jsonb)`,
+			buyerID, *creatorID, royalty, toJSON(meta)); err != nil {
+			return 0, err
+		}
+	}
+	if fee > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, fee); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_market_fee', $1, NULL, $2, $3::jsonb)`,
+			buyerID, fee, toJSON(meta)); err != nil {
+			return 0, err
+		}
+	}
+	return proceeds, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocateBundlePrice(t *testing.T) {
+	cases := []struct {
+		price   int64
+		weights []int64
+		want    []int64
+	}{
+		{1_000, []int64{300, 700}, []int64{300, 700}},
+		{500, []int64{300, 700}, []int64{150, 350}},
+		{100, []int64{1, 1, 1}, []int64{33, 33, 34}}, // remainder to the last
+		{100, []int64{0, 0}, []int64{50, 50}},        // no reference values
+		{10, []int64{0, 5}, []int64{0, 10}},
+	}
+	for _, c := range cases {
+		got := allocateBundlePrice(c.price, c.weights)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("price %d weights %v: got %v, want %v", c.price, c.weights, got, c.want)
+		}
+	}
+}
+
+func TestValidateBundle(t *testing.T) {
+	nft := BundleComponent{Kind: BundleNFT, NFTID: 1}
+	item := BundleComponent{Kind: BundleItem, ListingID: 7}
+	if err := validateBundle("set", 100, []BundleComponent{nft, item}); err != nil {
+		t.Fatalf("valid bundle: %v", err)
+	}
+	comps := []BundleComponent{nft, item}
+	_ = validateBundle("set", 100, comps)
+	if comps[0].Qty != 1 {
+		t.Fatalf("nft qty defaults to 1, got %d", comps[0].Qty)
+	}
+	bad := [][]BundleComponent{
+		{nft},                      // too few
+		{nft, nft},                 // duplicate
+		{nft, {Kind: "coin"}},      // unknown kind
+		{item, {Kind: BundleItem}}, // missing listing
+	}
+	for _, comps := range bad {
+		if err := validateBundle("set", 100, comps); err == nil {
+			t.Fatalf("%+v: want error", comps)
+		}
+	}
+	if err := validateBundle("set", 0, []BundleComponent{nft, item}); err == nil {
+		t.Fatal("zero price: want error")
+	}
+}
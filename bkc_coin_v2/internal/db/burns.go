@@ -0,0 +1,389 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// BurnFeeKinds are the ledger kinds of fee revenue paid into the reserve that
+// a burn schedule may burn a share of.
+var BurnFeeKinds = []string{"nft_market_fee", "promo_revenue"}
+
+const maxBurnPeriodDays = 90
+
+// BurnTotal is the cumulative amount burned under one ledger kind.
+type BurnTotal struct {
+	Kind   string    `json:"kind"`
+	Count  int64     `json:"count"`
+	Amount int64     `json:"amount"`
+	LastAt time.Time `json:"last_at"`
+}
+
+// BurnSchedule burns BurnBP of the fees collected in each period from the
+// reserve. The period ending at NextRunAt is burned once NextRunAt passes.
+type BurnSchedule struct {
+	ScheduleID int64     `json:"schedule_id"`
+	Name       string    `json:"name"`
+	FeeKinds   []string  `json:"fee_kinds"`
+	BurnBP     int64     `json:"burn_bp"`
+	PeriodDays int64     `json:"period_days"`
+	Enabled    bool      `json:"enabled"`
+	NextRunAt  time.Time `json:"next_run_at"`
+	CreatedBy  int64     `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// BurnReport is the public record of one scheduled burn.
+type BurnReport struct {
+	ReportID    int64     `json:"report_id"`
+	ScheduleID  int64     `json:"schedule_id"`
+	Schedule    string    `json:"schedule"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Fees        int64     `json:"fees"`
+	BurnBP      int64     `json:"burn_bp"`
+	Burned      int64     `json:"burned"` // less than Fees*BurnBP when the reserve ran short
+	LedgerID    int64     `json:"ledger_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BurnTotals sums every burn in the ledger by kind.
+func (d *DB) BurnTotals(ctx context.Context) ([]BurnTotal, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT kind, COUNT(*), COALESCE(SUM(amount), 0), MAX(ts)
+FROM ledger
+WHERE kind LIKE '%burn'
+GROUP BY kind
+ORDER BY kind
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]BurnTotal, 0, 4)
+	for rows.Next() {
+		var t BurnTotal
+		if err := rows.Scan(&t.Kind, &t.Count, &t.Amount, &t.LastAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// ListBurnReports returns the latest scheduled burns, newest first.
+func (d *DB) ListBurnReports(ctx context.Context, limit int) ([]BurnReport, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT r.report_id, r.schedule_id, s.name, r.period_start, r.period_end, r.fees, r.burn_bp, r.burned, COALESCE(r.ledger_id, 0), r.created_at
+FROM burn_reports r
+JOIN burn_schedules s ON s.schedule_id = r.schedule_id
+ORDER BY r.created_at DESC, r.report_id DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]BurnReport, 0, limit)
+	for rows.Next() {
+		var r BurnReport
+		if err := rows.Scan(&r.ReportID, &r.ScheduleID, &r.Schedule, &r.PeriodStart, &r.PeriodEnd, &r.Fees, &r.BurnBP, &r.Burned, &r.LedgerID, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+const burnScheduleColumns = `schedule_id, name, fee_kinds, burn_bp, period_days, enabled, next_run_at, COALESCE(created_by, 0), created_at, updated_at`
+
+func scanBurnSchedule(row pgx.Row) (BurnSchedule, error) {
+	var s BurnSchedule
+	err := row.Scan(&s.ScheduleID, &s.Name, &s.FeeKinds, &s.BurnBP, &s.PeriodDays, &s.Enabled, &s.NextRunAt, &s.CreatedBy, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// ListBurnSchedules returns every schedule, enabled or not.
+func (d *DB) ListBurnSchedules(ctx context.Context) ([]BurnSchedule, error) {
+	return listBurnSchedules(ctx, d.Pool)
+}
+
+func listBurnSchedules(ctx context.Context, q rowsQuerier) ([]BurnSchedule, error) {
+	rows, err := q.Query(ctx, `SELECT `+burnScheduleColumns+` FROM burn_schedules ORDER BY schedule_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []BurnSchedule
+	for rows.Next() {
+		s, err := scanBurnSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// normalize validates an admin-supplied schedule. Fee kinds default to all of
+// BurnFeeKinds.
+func (s *BurnSchedule) normalize() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > 64 {
+		return errors.New("bad name")
+	}
+	if s.BurnBP <= 0 || s.BurnBP > 10_000 {
+		return errors.New("bad burn_bp")
+	}
+	if s.PeriodDays <= 0 || s.PeriodDays > maxBurnPeriodDays {
+		return errors.New("bad period_days")
+	}
+	if len(s.FeeKinds) == 0 {
+		s.FeeKinds = append([]string(nil), BurnFeeKinds...)
+	}
+	seen := make(map[string]bool, len(s.FeeKinds))
+	kinds := s.FeeKinds[:0]
+	for _, k := range s.FeeKinds {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !isBurnFeeKind(k) {
+			return errors.New("bad fee kind")
+		}
+		if !seen[k] {
+			seen[k] = true
+			kinds = append(kinds, k)
+		}
+	}
+	s.FeeKinds = kinds
+	return nil
+}
+
+func isBurnFeeKind(kind string) bool {
+	for _, k := range BurnFeeKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateBurnSchedule adds a schedule. The first period ends at firstRunAt
+// (zero = one period from now).
+func (d *DB) CreateBurnSchedule(ctx context.Context, adminID int64, s BurnSchedule, firstRunAt time.Time) (BurnSchedule, error) {
+	if err := s.normalize(); err != nil {
+		return BurnSchedule{}, err
+	}
+	if firstRunAt.IsZero() {
+		firstRunAt = time.Now().UTC().Add(time.Duration(s.PeriodDays) * 24 * time.Hour)
+	}
+	var out BurnSchedule
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = scanBurnSchedule(tx.QueryRow(ctx, `
+INSERT INTO burn_schedules(name, fee_kinds, burn_bp, period_days, enabled, next_run_at, created_by)
+VALUES($1,$2,$3,$4,$5,$6,$7)
+RETURNING `+burnScheduleColumns, s.Name, s.FeeKinds, s.BurnBP, s.PeriodDays, s.Enabled, firstRunAt, adminID))
+		if err != nil {
+			return err
+		}
+		return logBurnScheduleTx(ctx, tx, adminID, "create", out)
+	})
+	if err != nil {
+		return BurnSchedule{}, err
+	}
+	return out, nil
+}
+
+// UpdateBurnSchedule replaces a schedule's settings. Already burned periods
+// are not revisited; changes apply from the next run.
+func (d *DB) UpdateBurnSchedule(ctx context.Context, adminID, scheduleID int64, s BurnSchedule) (BurnSchedule, error) {
+	if err := s.normalize(); err != nil {
+		return BurnSchedule{}, err
+	}
+	var out BurnSchedule
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = scanBurnSchedule(tx.QueryRow(ctx, `
+UPDATE burn_schedules
+SET name=$2, fee_kinds=$3, burn_bp=$4, period_days=$5, enabled=$6, updated_at=now()
+WHERE schedule_id=$1
+RETURNING `+burnScheduleColumns, scheduleID, s.Name, s.FeeKinds, s.BurnBP, s.PeriodDays, s.Enabled))
+		if err != nil {
+			return err
+		}
+		return logBurnScheduleTx(ctx, tx, adminID, "update", out)
+	})
+	if err != nil {
+		return BurnSchedule{}, err
+	}
+	return out, nil
+}
+
+func logBurnScheduleTx(ctx context.Context, tx pgx.Tx, adminID int64, action string, s BurnSchedule) error {
+	_, err := tx.Exec(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('admin_burn_schedule', $1, NULL, 0, $2::jsonb)
+`, adminID, toJSON(map[string]any{
+		"action":      action,
+		"schedule_id": s.ScheduleID,
+		"fee_kinds":   s.FeeKinds,
+		"burn_bp":     s.BurnBP,
+		"period_days": s.PeriodDays,
+		"enabled":     s.Enabled,
+	}))
+	return err
+}
+
+// RunDueBurnSchedules burns every period whose end has passed. Each period is
+// its own transaction and is recorded once in burn_reports; a schedule that
+// fell behind catches up one period at a time. Run from the burn_schedule job.
+func (d *DB) RunDueBurnSchedules(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT schedule_id
+FROM burn_schedules
+WHERE enabled AND next_run_at <= $1
+ORDER BY next_run_at
+LIMIT 100
+`, now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var burned int64
+	for _, id := range ids {
+		for i := 0; i < maxBurnPeriodDays; i++ {
+			n, due, err := d.runBurnPeriod(ctx, id, now)
+			if err != nil {
+				return burned, err
+			}
+			burned += n
+			if !due {
+				break
+			}
+		}
+	}
+	return burned, nil
+}
+
+// runBurnPeriod burns the schedule's next period if it is due. due reports
+// whether a period was processed.
+func (d *DB) runBurnPeriod(ctx context.Context, scheduleID int64, now time.Time) (int64, bool, error) {
+	var burned int64
+	var due bool
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		// system_state first, like every reserve mutation.
+		var reserve, reserved int64
+		if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+			return err
+		}
+		s, err := scanBurnSchedule(tx.QueryRow(ctx, `SELECT `+burnScheduleColumns+` FROM burn_schedules WHERE schedule_id=$1 FOR UPDATE`, scheduleID))
+		if err != nil {
+			return err
+		}
+		if !s.Enabled || s.NextRunAt.After(now) {
+			return nil
+		}
+		due = true
+		end := s.NextRunAt
+		start := end.Add(-time.Duration(s.PeriodDays) * 24 * time.Hour)
+		// Continue from the last burned period so a changed period_days
+		// neither skips nor double-counts fees.
+		var last *time.Time
+		if err := tx.QueryRow(ctx, `SELECT MAX(period_end) FROM burn_reports WHERE schedule_id=$1`, s.ScheduleID).Scan(&last); err != nil {
+			return err
+		}
+		if last != nil && last.Before(end) {
+			start = *last
+		}
+
+		var fees int64
+		if err := tx.QueryRow(ctx, `
+SELECT COALESCE(SUM(amount), 0)
+FROM ledger
+WHERE kind = ANY($1) AND ts >= $2 AND ts < $3
+`, s.FeeKinds, start, end).Scan(&fees); err != nil {
+			return err
+		}
+		burned = burnShare(fees, s.BurnBP, reserve-reserved)
+
+		var ledgerID *int64
+		if burned > 0 {
+			if _, err := tx.Exec(ctx, `
+UPDATE system_state
+SET reserve_supply=reserve_supply-$1, total_supply=GREATEST(total_supply-$1, 0), updated_at=now()
+WHERE id=1
+`, burned); err != nil {
+				return err
+			}
+			var id int64
+			if err := tx.QueryRow(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('scheduled_fee_burn', NULL, NULL, $1, $2::jsonb)
+RETURNING id
+`, burned, toJSON(map[string]any{
+				"schedule_id":  s.ScheduleID,
+				"fees":         fees,
+				"burn_bp":      s.BurnBP,
+				"period_start": start,
+				"period_end":   end,
+			})).Scan(&id); err != nil {
+				return err
+			}
+			ledgerID = &id
+		}
+		if _, err := tx.Exec(ctx, `
+INSERT INTO burn_reports(schedule_id, period_start, period_end, fees, burn_bp, burned, ledger_id)
+VALUES($1,$2,$3,$4,$5,$6,$7)
+`, s.ScheduleID, start, end, fees, s.BurnBP, burned, ledgerID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+UPDATE burn_schedules
+SET next_run_at = next_run_at + make_interval(days => period_days), updated_at=now()
+WHERE schedule_id=$1
+`, s.ScheduleID)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return burned, due, nil
+}
+
+// burnShare is bp basis points of fees, capped at what the reserve can spare.
+func burnShare(fees, bp, available int64) int64 {
+	n := interestFromBP(fees, bp)
+	if n > available {
+		n = available
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBurnShare(t *testing.T) {
+	cases := []struct {
+		fees, bp, available, want int64
+	}{
+		{0, 2_000, 1_000, 0},
+		{10_000, 2_000, 1_000_000, 2_000},
+		{10_000, 10_000, 1_000_000, 10_000},
+		{10_000, 2_000, 500, 500}, // reserve ran short
+		{10_000, 2_000, -5, 0},
+	}
+	for _, c := range cases {
+		if got := burnShare(c.fees, c.bp, c.available); got != c.want {
+			t.Fatalf("burnShare(%d, %d, %d) = %d, want %d", c.fees, c.bp, c.available, got, c.want)
+		}
+	}
+}
+
+func TestBurnScheduleNormalize(t *testing.T) {
+	s := BurnSchedule{Name: " weekly ", BurnBP: 2_000, PeriodDays: 7}
+	if err := s.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "weekly" || len(s.FeeKinds) != len(BurnFeeKinds) {
+		t.Fatalf("defaults not applied: %+v", s)
+	}
+	s.FeeKinds = []string{"NFT_MARKET_FEE", "nft_market_fee"}
+	if err := s.normalize(); err != nil || len(s.FeeKinds) != 1 {
+		t.Fatalf("dedupe: %v %v", err, s.FeeKinds)
+	}
+	for _, bad := range []BurnSchedule{
+		{Name: "x", BurnBP: 0, PeriodDays: 7},
+		{Name: "x", BurnBP: 10_001, PeriodDays: 7},
+		{Name: "x", BurnBP: 100, PeriodDays: 0},
+		{Name: "x", BurnBP: 100, PeriodDays: 7, FeeKinds: []string{"tap"}},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Fatalf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestRunDueBurnSchedules(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	if _, err := d.EnsureSystemState(ctx, 1_000_000_000, 1, 0, 1_000_000_000, 1_000, 500, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().UTC().Add(-time.Minute)
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO ledger(kind, amount, ts) VALUES('promo_revenue', 10000, $1)`, end.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := d.CreateBurnSchedule(ctx, 1, BurnSchedule{Name: "test", FeeKinds: []string{"promo_revenue"}, BurnBP: 10_000, PeriodDays: 1, Enabled: true}, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM burn_reports WHERE schedule_id=$1`, s.ScheduleID)
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM burn_schedules WHERE schedule_id=$1`, s.ScheduleID)
+	})
+	before, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RunDueBurnSchedules(ctx, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// A second run must not burn the same period again.
+	if _, err := d.RunDueBurnSchedules(ctx, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	var reports, burned int64
+	if err := d.Pool.QueryRow(ctx, `SELECT COUNT(*), COALESCE(SUM(burned), 0) FROM burn_reports WHERE schedule_id=$1`, s.ScheduleID).Scan(&reports, &burned); err != nil {
+		t.Fatal(err)
+	}
+	if reports != 1 || burned < 10_000 {
+		t.Fatalf("reports=%d burned=%d", reports, burned)
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ReserveSupply-after.ReserveSupply != burned {
+		t.Fatalf("reserve fell by %d, burned %d", before.ReserveSupply-after.ReserveSupply, burned)
+	}
+}
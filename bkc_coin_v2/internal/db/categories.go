@@ -0,0 +1,350 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Market categories form a tree managed by admins. A listing must be filed
+// under an active category and carry the category's required attributes; a
+// category may override the listing fee. Names are per language
+// (templates.Languages) and fall back to the default language, then the slug.
+
+var categorySlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+const (
+	maxCategoryAttrs = 10
+	maxListingAttrs  = 20
+)
+
+// MarketCategory is one node of the category tree.
+type MarketCategory struct {
+	Slug          string            `json:"slug"`
+	Parent        string            `json:"parent,omitempty"`
+	Names         map[string]string `json:"names"`
+	ListingFee    *int64            `json:"listing_fee,omitempty"` // nil = MARKET_LISTING_FEE_COINS
+	RequiredAttrs []string          `json:"required_attrs"`
+	SortOrder     int64             `json:"sort_order"`
+	Active        bool              `json:"active"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// CategoryNode is a localized category for browsing; Listings counts the
+// active listings of the category and its subcategories.
+type CategoryNode struct {
+	Slug          string          `json:"slug"`
+	Name          string          `json:"name"`
+	ListingFee    *int64          `json:"listing_fee,omitempty"`
+	RequiredAttrs []string        `json:"required_attrs"`
+	Listings      int64           `json:"listings"`
+	Children      []*CategoryNode `json:"children"`
+}
+
+func (c *MarketCategory) validate() error {
+	c.Slug = strings.ToLower(strings.TrimSpace(c.Slug))
+	c.Parent = strings.ToLower(strings.TrimSpace(c.Parent))
+	if !categorySlugRe.MatchString(c.Slug) || (c.Parent != "" && !categorySlugRe.MatchString(c.Parent)) || c.Parent == c.Slug {
+		return errors.New("bad slug")
+	}
+	for lang, name := range c.Names {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(templates.Languages, lang) || name == "" || len(name) > 64 {
+			return errors.New("bad names")
+		}
+		c.Names[lang] = name
+	}
+	if c.Names[templates.DefaultLang] == "" {
+		return errors.New("bad names: default language required")
+	}
+	if c.ListingFee != nil && *c.ListingFee < 0 {
+		return errors.New("bad listing_fee")
+	}
+	if len(c.RequiredAttrs) > maxCategoryAttrs {
+		return errors.New("bad required_attrs")
+	}
+	attrs := make([]string, 0, len(c.RequiredAttrs))
+	for _, a := range c.RequiredAttrs {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if !categorySlugRe.MatchString(a) {
+			return errors.New("bad required_attrs")
+		}
+		if !slices.Contains(attrs, a) {
+			attrs = append(attrs, a)
+		}
+	}
+	c.RequiredAttrs = attrs
+	return nil
+}
+
+// categoryName is the name in lang, then the default language, then the slug.
+func categoryName(c MarketCategory, lang string) string {
+	for _, l := range []string{lang, templates.DefaultLang} {
+		if n := c.Names[l]; n != "" {
+			return n
+		}
+	}
+	return c.Slug
+}
+
+// normalizeListingAttrs trims a listing's attributes and drops empty ones.
+func normalizeListingAttrs(attrs map[string]string) (map[string]string, error) {
+	if len(attrs) > maxListingAttrs {
+		return nil, errors.New("bad attributes")
+	}
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if !categorySlugRe.MatchString(k) || len(v) > 200 {
+			return nil, errors.New("bad attributes")
+		}
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// missingAttrs returns the required attributes attrs lacks or leaves empty.
+func missingAttrs(required []string, attrs map[string]string) []string {
+	var out []string
+	for _, a := range required {
+		if strings.TrimSpace(attrs[a]) == "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// buildCategoryTree nests active categories under their parents, ordered by
+// sort order then slug, with listing counts rolled up to the ancestors.
+// Categories under an inactive or missing parent are left out.
+func buildCategoryTree(cats []MarketCategory, counts map[string]int64, lang string) []*CategoryNode {
+	cats = slices.Clone(cats)
+	slices.SortFunc(cats, func(a, b MarketCategory) int {
+		return cmp.Or(cmp.Compare(a.SortOrder, b.SortOrder), strings.Compare(a.Slug, b.Slug))
+	})
+	children := map[string][]MarketCategory{}
+	for _, c := range cats {
+		if c.Active {
+			children[c.Parent] = append(children[c.Parent], c)
+		}
+	}
+	var build func(parent string) []*CategoryNode
+	build = func(parent string) []*CategoryNode {
+		out := []*CategoryNode{}
+		for _, c := range children[parent] {
+			n := &CategoryNode{Slug: c.Slug, Name: categoryName(c, lang), ListingFee: c.ListingFee, RequiredAttrs: c.RequiredAttrs, Listings: counts[c.Slug]}
+			n.Children = build(c.Slug)
+			for _, ch := range n.Children {
+				n.Listings += ch.Listings
+			}
+			out = append(out, n)
+		}
+		return out
+	}
+	return build("")
+}
+
+const marketCategoryCols = `slug, COALESCE(parent_slug, ''), names, listing_fee, required_attrs, sort_order, active, updated_at`
+
+func scanMarketCategory(row pgx.Row) (MarketCategory, error) {
+	var c MarketCategory
+	err := row.Scan(&c.Slug, &c.Parent, &c.Names, &c.ListingFee, &c.RequiredAttrs, &c.SortOrder, &c.Active, &c.UpdatedAt)
+	return c, err
+}
+
+// ListMarketCategories returns every category, inactive ones included.
+func (d *DB) ListMarketCategories(ctx context.Context) ([]MarketCategory, error) {
+	return listMarketCategories(ctx, d.Pool)
+}
+
+func listMarketCategories(ctx context.Context, q rowsQuerier) ([]MarketCategory, error) {
+	rows, err := q.Query(ctx, `SELECT `+marketCategoryCols+` FROM market_categories ORDER BY sort_order, slug`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MarketCategory{}
+	for rows.Next() {
+		c, err := scanMarketCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SaveMarketCategory creates or updates a category. The parent must exist
+// and must not be the category itself or one of its descendants.
+func (d *DB) SaveMarketCategory(ctx context.Context, c MarketCategory) (MarketCategory, error) {
+	if err := c.validate(); err != nil {
+		return MarketCategory{}, err
+	}
+	var out MarketCategory
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := lockMarketCategoriesTx(ctx, tx); err != nil {
+			return err
+		}
+		var err error
+		out, err = saveMarketCategoryTx(ctx, tx, c)
+		return err
+	})
+	if err != nil {
+		return MarketCategory{}, err
+	}
+	return out, nil
+}
+
+// lockMarketCategoriesTx serializes changes to the tree until the commit.
+func lockMarketCategoriesTx(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('market_categories'))`)
+	return err
+}
+
+// saveMarketCategoryTx upserts a validated category; the caller holds
+// lockMarketCategoriesTx.
+func saveMarketCategoryTx(ctx context.Context, tx pgx.Tx, c MarketCategory) (MarketCategory, error) {
+	if c.Parent != "" {
+		var found, cycle bool
+		err := tx.QueryRow(ctx, `
+WITH RECURSIVE up AS (
+  SELECT slug, parent_slug FROM market_categories WHERE slug=$1
+  UNION ALL
+  SELECT c.slug, c.parent_slug FROM market_categories c JOIN up ON c.slug = up.parent_slug
+)
+SELECT EXISTS(SELECT 1 FROM up WHERE slug=$1), EXISTS(SELECT 1 FROM up WHERE slug=$2)
+`, c.Parent, c.Slug).Scan(&found, &cycle)
+		if err != nil {
+			return MarketCategory{}, err
+		}
+		if !found {
+			return MarketCategory{}, errors.New("bad parent")
+		}
+		if cycle {
+			return MarketCategory{}, errors.New("bad parent: cycle")
+		}
+	}
+	return scanMarketCategory(tx.QueryRow(ctx, `
+INSERT INTO market_categories (slug, parent_slug, names, listing_fee, required_attrs, sort_order, active)
+VALUES ($1, NULLIF($2, ''), $3::jsonb, $4, $5, $6, $7)
+ON CONFLICT (slug) DO UPDATE
+SET parent_slug=EXCLUDED.parent_slug, names=EXCLUDED.names, listing_fee=EXCLUDED.listing_fee,
+    required_attrs=EXCLUDED.required_attrs, sort_order=EXCLUDED.sort_order, active=EXCLUDED.active, updated_at=now()
+RETURNING `+marketCategoryCols, c.Slug, c.Parent, toJSON(c.Names), c.ListingFee, c.RequiredAttrs, c.SortOrder, c.Active))
+}
+
+// DeleteMarketCategory removes a category without subcategories or
+// listings; ErrLocked otherwise (deactivate it instead).
+func (d *DB) DeleteMarketCategory(ctx context.Context, slug string) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := lockMarketCategoriesTx(ctx, tx); err != nil {
+			return err
+		}
+		var used bool
+		if err := tx.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM market_categories WHERE parent_slug=$1) OR EXISTS(SELECT 1 FROM market_listings WHERE category=$1)
+`, slug).Scan(&used); err != nil {
+			return err
+		}
+		if used {
+			return ErrLocked
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM market_categories WHERE slug=$1`, slug)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// MarketCategoryTree returns the active categories in lang with listing counts.
+func (d *DB) MarketCategoryTree(ctx context.Context, lang string) ([]*CategoryNode, error) {
+	cats, err := d.ListMarketCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.Pool.Query(ctx, `SELECT category, COUNT(*) FROM market_listings WHERE status='active' GROUP BY category`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int64{}
+	for rows.Next() {
+		var slug string
+		var n int64
+		if err := rows.Scan(&slug, &n); err != nil {
+			return nil, err
+		}
+		counts[slug] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buildCategoryTree(cats, counts, lang), nil
+}
+
+// ListCategoryListings returns active listings of a category and its
+// subcategories, newest first.
+func (d *DB) ListCategoryListings(ctx context.Context, slug string, limit int64) ([]MarketListing, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+WITH RECURSIVE sub AS (
+  SELECT slug FROM market_categories WHERE slug=$1 AND active
+  UNION ALL
+  SELECT c.slug FROM market_categories c JOIN sub ON c.parent_slug = sub.slug WHERE c.active
+)
+SELECT l.listing_id, l.seller_id, l.title, l.description, l.category, l.price_coins, l.contact, l.status, l.created_at, l.sold_at, l.buyer_id,
+       (SELECT image_id FROM market_listing_images WHERE listing_id=l.listing_id ORDER BY created_at ASC LIMIT 1) AS image_id,
+       l.attributes
+FROM market_listings l
+WHERE l.status='active' AND l.category IN (SELECT slug FROM sub)
+ORDER BY l.created_at DESC
+LIMIT $2
+`, slug, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MarketListing{}
+	for rows.Next() {
+		var l MarketListing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.Title, &l.Description, &l.Category, &l.PriceCoins, &l.Contact, &l.Status, &l.CreatedAt, &l.SoldAt, &l.BuyerID, &l.ImageID, &l.Attributes); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// listingCategoryTx checks a new listing against its category and returns
+// the category's listing fee override, if any.
+func listingCategoryTx(ctx context.Context, tx pgx.Tx, slug string, attrs map[string]string) (*int64, error) {
+	var fee *int64
+	var required []string
+	err := tx.QueryRow(ctx, `SELECT listing_fee, required_attrs FROM market_categories WHERE slug=$1 AND active`, slug).Scan(&fee, &required)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("bad category")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingAttrs(required, attrs); len(missing) > 0 {
+		return nil, errors.New("bad attributes: missing " + strings.Join(missing, ", "))
+	}
+	return fee, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMarketCategoryValidate(t *testing.T) {
+	c := MarketCategory{Slug: " Phones ", Parent: "electronics", Names: map[string]string{"ru": " Телефоны ", "en": "Phones"}, RequiredAttrs: []string{"Brand", "brand", "condition"}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Slug != "phones" || c.Names["ru"] != "Телефоны" || !slices.Equal(c.RequiredAttrs, []string{"brand", "condition"}) {
+		t.Fatalf("not normalized: %+v", c)
+	}
+	fee := int64(-1)
+	for name, bad := range map[string]MarketCategory{
+		"no default name": {Slug: "a", Names: map[string]string{"en": "A"}},
+		"unknown lang":    {Slug: "a", Names: map[string]string{"ru": "A", "de": "A"}},
+		"bad slug":        {Slug: "a b", Names: map[string]string{"ru": "A"}},
+		"own parent":      {Slug: "a", Parent: "a", Names: map[string]string{"ru": "A"}},
+		"negative fee":    {Slug: "a", Names: map[string]string{"ru": "A"}, ListingFee: &fee},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Fatalf("%s: want error", name)
+		}
+	}
+}
+
+func TestMissingAttrs(t *testing.T) {
+	got := missingAttrs([]string{"brand", "size", "color"}, map[string]string{"brand": "Acme", "size": " "})
+	if !slices.Equal(got, []string{"size", "color"}) {
+		t.Fatalf("missing %q", got)
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	cats := []MarketCategory{
+		{Slug: "other", Names: map[string]string{"ru": "Другое"}, SortOrder: 1000, Active: true},
+		{Slug: "electronics", Names: map[string]string{"ru": "Электроника", "en": "Electronics"}, Active: true},
+		{Slug: "phones", Parent: "electronics", Names: map[string]string{"ru": "Телефоны"}, Active: true},
+		{Slug: "laptops", Parent: "electronics", Names: map[string]string{"ru": "Ноутбуки"}, Active: false},
+		{Slug: "cases", Parent: "laptops", Names: map[string]string{"ru": "Чехлы"}, Active: true}, // under an inactive parent
+	}
+	tree := buildCategoryTree(cats, map[string]int64{"electronics": 1, "phones": 4, "laptops": 7, "other": 2}, "en")
+	if len(tree) != 2 || tree[0].Slug != "electronics" || tree[1].Slug != "other" {
+		t.Fatalf("roots %+v", tree)
+	}
+	el := tree[0]
+	if el.Name != "Electronics" || el.Listings != 5 || len(el.Children) != 1 || el.Children[0].Slug != "phones" {
+		t.Fatalf("electronics %+v", el)
+	}
+	if el.Children[0].Name != "Телефоны" { // falls back to the default language
+		t.Fatalf("phones name %q", el.Children[0].Name)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Client analytics events: screen views, button taps and funnel steps sent
+// by the webapp in batches. client_events is only a buffer for the
+// analytics_export job, which copies the rows to ClickHouse and deletes what
+// it copied; funnels are built there.
+
+const (
+	ClientScreenView = "screen_view" // name: screen
+	ClientButtonTap  = "button_tap"  // name: button; screen: where it was tapped
+	ClientFunnelStep = "funnel_step" // name: step; props.flow: purchase, game, ...
+
+	MaxClientEventBatch = 100
+	maxClientEventProps = 16
+	maxClientPropValue  = 256
+	clientEventPast     = 24 * time.Hour  // older client timestamps are rejected
+	clientEventSkew     = 5 * time.Minute // clock skew allowed into the future
+)
+
+var clientIdentRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,63}$`)
+
+// ClientEvent is one analytics event as sent by the webapp. TS is the
+// client's unix time in milliseconds; 0 means when it was received.
+type ClientEvent struct {
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Screen    string            `json:"screen,omitempty"`
+	SessionID string            `json:"session_id,omitempty"`
+	TS        int64             `json:"ts,omitempty"`
+	Props     map[string]string `json:"props,omitempty"`
+}
+
+// ClientEventRow is a stored client event as exported for analytics.
+type ClientEventRow struct {
+	ID         int64
+	UserID     int64
+	SessionID  string
+	Type       string
+	Name       string
+	Screen     string
+	Props      map[string]string
+	ClientTS   time.Time
+	ReceivedAt time.Time
+}
+
+// validateClientEvent checks ev against the event schema and returns its
+// client time.
+func validateClientEvent(ev ClientEvent, now time.Time) (time.Time, error) {
+	switch ev.Type {
+	case ClientScreenView, ClientFunnelStep:
+	case ClientButtonTap:
+		if ev.Screen == "" {
+			return time.Time{}, errors.New("bad event: button_tap needs screen")
+		}
+	default:
+		return time.Time{}, fmt.Errorf("bad event: unknown type %q", ev.Type)
+	}
+	if !clientIdentRe.MatchString(ev.Name) {
+		return time.Time{}, errors.New("bad event: name")
+	}
+	if ev.Screen != "" && !clientIdentRe.MatchString(ev.Screen) {
+		return time.Time{}, errors.New("bad event: screen")
+	}
+	if len(ev.SessionID) > 64 {
+		return time.Time{}, errors.New("bad event: session_id")
+	}
+	if len(ev.Props) > maxClientEventProps {
+		return time.Time{}, errors.New("bad event: too many props")
+	}
+	for k, v := range ev.Props {
+		if !clientIdentRe.MatchString(k) || len(v) > maxClientPropValue {
+			return time.Time{}, fmt.Errorf("bad event: prop %q", k)
+		}
+	}
+	if ev.Type == ClientFunnelStep && !clientIdentRe.MatchString(ev.Props["flow"]) {
+		return time.Time{}, errors.New("bad event: funnel_step needs props.flow")
+	}
+	if ev.TS == 0 {
+		return now, nil
+	}
+	ts := time.UnixMilli(ev.TS).UTC()
+	if ts.Before(now.Add(-clientEventPast)) || ts.After(now.Add(clientEventSkew)) {
+		return time.Time{}, errors.New("bad event: ts out of range")
+	}
+	return ts, nil
+}
+
+// ClientEventSampled reports whether userID's events are kept at sampleBP.
+// Sampling is per user, so a kept user's funnels stay complete.
+func ClientEventSampled(userID, sampleBP int64) bool {
+	if sampleBP >= 10_000 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strconv.FormatInt(userID, 10)))
+	return int64(h.Sum32()%10_000) < sampleBP
+}
+
+// ClientEventError is an event of a batch that failed validation.
+type ClientEventError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// InsertClientEvents stores the valid events of a batch and reports the
+// rest; one bad event does not drop the batch.
+func (d *DB) InsertClientEvents(ctx context.Context, userID int64, events []ClientEvent) (int64, []ClientEventError, error) {
+	if userID <= 0 || len(events) == 0 || len(events) > MaxClientEventBatch {
+		return 0, nil, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	var sessions, types, names, screens, props []string
+	var stamps []time.Time
+	rejected := []ClientEventError{}
+	for i, ev := range events {
+		ts, err := validateClientEvent(ev, now)
+		if err != nil {
+			rejected = append(rejected, ClientEventError{Index: i, Error: err.Error()})
+			continue
+		}
+		sessions = append(sessions, ev.SessionID)
+		types = append(types, ev.Type)
+		names = append(names, ev.Name)
+		screens = append(screens, ev.Screen)
+		if ev.Props == nil {
+			ev.Props = map[string]string{}
+		}
+		props = append(props, toJSON(ev.Props))
+		stamps = append(stamps, ts)
+	}
+	if len(types) == 0 {
+		return 0, rejected, nil
+	}
+	tag, err := d.Pool.Exec(ctx, `
+INSERT INTO client_events (user_id, session_id, type, name, screen, props, client_ts, received_at)
+SELECT $1, e.session_id, e.type, e.name, e.screen, e.props::jsonb, e.client_ts, $8
+FROM unnest($2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::timestamptz[])
+  AS e(session_id, type, name, screen, props, client_ts)
+`, userID, sessions, types, names, screens, props, stamps, now)
+	if err != nil {
+		return 0, nil, err
+	}
+	return tag.RowsAffected(), rejected, nil
+}
+
+// ClientEventsSince returns settled client events after afterID in id order.
+func (d *DB) ClientEventsSince(ctx context.Context, afterID int64, limit int) ([]ClientEventRow, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT id, user_id, session_id, type, name, screen, props, client_ts, received_at
+FROM client_events
+WHERE id > $1 AND received_at < now() - interval '1 minute'
+ORDER BY id
+LIMIT $2
+`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ClientEventRow
+	for rows.Next() {
+		var e ClientEventRow
+		if err := rows.Scan(&e.ID, &e.UserID, &e.SessionID, &e.Type, &e.Name, &e.Screen, &e.Props, &e.ClientTS, &e.ReceivedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// DeleteClientEvents drops exported client events up to lastID.
+func (d *DB) DeleteClientEvents(ctx context.Context, lastID int64) error {
+	_, err := d.Pool.Exec(ctx, `DELETE FROM client_events WHERE id <= $1`, lastID)
+	return err
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateClientEvent(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ok := []ClientEvent{
+		{Type: ClientScreenView, Name: "shop"},
+		{Type: ClientButtonTap, Name: "buy", Screen: "nft.detail", Props: map[string]string{"nft_id": "42"}},
+		{Type: ClientFunnelStep, Name: "checkout", Props: map[string]string{"flow": "purchase"}, TS: now.Add(-time.Hour).UnixMilli()},
+	}
+	for _, ev := range ok {
+		if _, err := validateClientEvent(ev, now); err != nil {
+			t.Fatalf("%+v: %v", ev, err)
+		}
+	}
+	bad := []ClientEvent{
+		{Type: "purchase", Name: "shop"},
+		{Type: ClientScreenView, Name: "Shop"},
+		{Type: ClientButtonTap, Name: "buy"},
+		{Type: ClientFunnelStep, Name: "checkout"},
+		{Type: ClientScreenView, Name: "shop", Props: map[string]string{"k": strings.Repeat("x", 257)}},
+		{Type: ClientScreenView, Name: "shop", TS: now.Add(-25 * time.Hour).UnixMilli()},
+		{Type: ClientScreenView, Name: "shop", TS: now.Add(time.Hour).UnixMilli()},
+	}
+	for _, ev := range bad {
+		if _, err := validateClientEvent(ev, now); err == nil {
+			t.Fatalf("%+v: expected error", ev)
+		}
+	}
+	if ts, _ := validateClientEvent(ClientEvent{Type: ClientScreenView, Name: "shop"}, now); !ts.Equal(now) {
+		t.Fatalf("ts %v, want receive time", ts)
+	}
+}
+
+func TestClientEventSampled(t *testing.T) {
+	var kept int
+	for id := int64(1); id <= 10_000; id++ {
+		if ClientEventSampled(id, 2_500) {
+			kept++
+		}
+		if ClientEventSampled(id, 2_500) != ClientEventSampled(id, 2_500) {
+			t.Fatal("sampling is not stable per user")
+		}
+		if !ClientEventSampled(id, 10_000) || ClientEventSampled(id, 0) {
+			t.Fatal("0 and 10000 must drop and keep everyone")
+		}
+	}
+	if kept < 2_000 || kept > 3_000 {
+		t.Fatalf("kept %d of 10000 at 25%%", kept)
+	}
+}
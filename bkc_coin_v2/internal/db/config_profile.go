@@ -0,0 +1,670 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A config profile is the runtime configuration admins set through the API
+// (game credit rates, module switches, fee and limit params, burn schedules,
+// market categories and bonus campaigns), exported from one environment and applied to another,
+// typically staging to production. Whatever is specific to an environment
+// stays out: ids, timestamps, who changed what, maintenance ETAs, the next
+// burn run and the deposit wallets.
+//
+// A section missing from a profile (null) is left as it is. A section that is
+// present is the whole section: categories and bonus campaigns it does not
+// list are deactivated, burn schedules disabled, modules it does not list are
+// switched on and params it does not list go back to their default. Nothing
+// else is deleted. Campaign terms cannot change once
+// users may have claimed them, so a campaign whose terms differ from the
+// profile's is a conflict, not a change.
+
+const configProfileVersion = 1
+
+// Config profile sections.
+const (
+	ProfileGameCredits      = "game_credits"
+	ProfileModuleSwitches   = "module_switches"
+	ProfileParams           = "params"
+	ProfileBurnSchedules    = "burn_schedules"
+	ProfileMarketCategories = "market_categories"
+	ProfileBonusCampaigns   = "bonus_campaigns"
+)
+
+// Config change actions.
+const (
+	ConfigCreate  = "create"
+	ConfigUpdate  = "update"
+	ConfigDisable = "disable"
+	ConfigReset   = "reset"
+)
+
+// ErrConfigProfileChanged means the configuration changed between the dry
+// run and the import; the admin has to review a fresh plan.
+var ErrConfigProfileChanged = errors.New("config profile plan changed")
+
+// ConfigProfile is an exported configuration. ModuleSwitches ignore ETA and
+// the updated_* fields, GameCredits the updated_* fields.
+type ConfigProfile struct {
+	Version          int                    `json:"version"`
+	ExportedAt       *time.Time             `json:"exported_at,omitempty"`
+	GameCredits      *GameCreditRates       `json:"game_credits"`
+	ModuleSwitches   []ModuleSwitch         `json:"module_switches"`
+	Params           map[string]int64       `json:"params"` // overrides only
+	BurnSchedules    []ProfileBurnSchedule  `json:"burn_schedules"`
+	MarketCategories []ProfileCategory      `json:"market_categories"`
+	BonusCampaigns   []ProfileBonusCampaign `json:"bonus_campaigns"`
+}
+
+// ProfileBurnSchedule is a burn schedule in a profile, keyed by name.
+type ProfileBurnSchedule struct {
+	Name       string   `json:"name"`
+	FeeKinds   []string `json:"fee_kinds"`
+	BurnBP     int64    `json:"burn_bp"`
+	PeriodDays int64    `json:"period_days"`
+	Enabled    bool     `json:"enabled"`
+}
+
+// ProfileCategory is a market category in a profile, keyed by slug.
+type ProfileCategory struct {
+	Slug          string            `json:"slug"`
+	Parent        string            `json:"parent,omitempty"`
+	Names         map[string]string `json:"names"`
+	ListingFee    *int64            `json:"listing_fee,omitempty"`
+	RequiredAttrs []string          `json:"required_attrs"`
+	SortOrder     int64             `json:"sort_order"`
+	Active        bool              `json:"active"`
+}
+
+// ProfileBonusCampaign is a bonus campaign in a profile, keyed by code. A
+// new campaign without StartsAt starts when the profile is applied; an
+// existing one is then matched on the other terms only.
+type ProfileBonusCampaign struct {
+	Code       string     `json:"code"`
+	Kind       string     `json:"kind"`
+	MatchBP    int64      `json:"match_bp,omitempty"`
+	MaxBonus   int64      `json:"max_bonus,omitempty"`
+	MinDeposit int64      `json:"min_deposit,omitempty"`
+	FreeBet    int64      `json:"free_bet,omitempty"`
+	WagerX     int64      `json:"wager_x"`
+	MinOdds    float64    `json:"min_odds"`
+	MaxBet     int64      `json:"max_bet,omitempty"`
+	ValidDays  int64      `json:"valid_days"`
+	MaxClaims  int64      `json:"max_claims,omitempty"`
+	Active     bool       `json:"active"`
+	StartsAt   *time.Time `json:"starts_at,omitempty"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+}
+
+// ConfigChange is one row of the dry-run diff. From is empty for a create,
+// To for a reset.
+type ConfigChange struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Action  string `json:"action"`
+	From    any    `json:"from,omitempty"`
+	To      any    `json:"to"`
+}
+
+// ConfigProfilePlan is what applying a profile would change. Conflicts block
+// the import; Token must be passed back to ApplyConfigProfile to confirm
+// exactly this plan.
+type ConfigProfilePlan struct {
+	Changes   []ConfigChange `json:"changes"`
+	Conflicts []string       `json:"conflicts"`
+	Token     string         `json:"token"`
+}
+
+// profileTime is t as Postgres stores it, so that exported and imported
+// times compare equal.
+func profileTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := t.UTC().Truncate(time.Microsecond)
+	return &v
+}
+
+func profileBurnSchedule(s BurnSchedule) ProfileBurnSchedule {
+	kinds := slices.Clone(s.FeeKinds)
+	slices.Sort(kinds)
+	return ProfileBurnSchedule{Name: s.Name, FeeKinds: kinds, BurnBP: s.BurnBP, PeriodDays: s.PeriodDays, Enabled: s.Enabled}
+}
+
+func profileCategory(c MarketCategory) ProfileCategory {
+	if c.Names == nil {
+		c.Names = map[string]string{}
+	}
+	if c.RequiredAttrs == nil {
+		c.RequiredAttrs = []string{}
+	}
+	return ProfileCategory{Slug: c.Slug, Parent: c.Parent, Names: c.Names, ListingFee: c.ListingFee,
+		RequiredAttrs: c.RequiredAttrs, SortOrder: c.SortOrder, Active: c.Active}
+}
+
+func (c ProfileCategory) category() MarketCategory {
+	return MarketCategory{Slug: c.Slug, Parent: c.Parent, Names: c.Names, ListingFee: c.ListingFee,
+		RequiredAttrs: c.RequiredAttrs, SortOrder: c.SortOrder, Active: c.Active}
+}
+
+func profileBonusCampaign(c BonusCampaign) ProfileBonusCampaign {
+	return ProfileBonusCampaign{Code: c.Code, Kind: c.Kind, MatchBP: c.MatchBP, MaxBonus: c.MaxBonus, MinDeposit: c.MinDeposit,
+		FreeBet: c.FreeBet, WagerX: c.WagerX, MinOdds: c.MinOdds, MaxBet: c.MaxBet, ValidDays: c.ValidDays, MaxClaims: c.MaxClaims,
+		Active: c.Active, StartsAt: profileTime(&c.StartsAt), EndsAt: profileTime(c.EndsAt)}
+}
+
+func (c ProfileBonusCampaign) campaign() BonusCampaign {
+	out := BonusCampaign{Code: c.Code, Kind: c.Kind, MatchBP: c.MatchBP, MaxBonus: c.MaxBonus, MinDeposit: c.MinDeposit,
+		FreeBet: c.FreeBet, WagerX: c.WagerX, MinOdds: c.MinOdds, MaxBet: c.MaxBet, ValidDays: c.ValidDays, MaxClaims: c.MaxClaims,
+		Active: c.Active, EndsAt: c.EndsAt}
+	if c.StartsAt != nil {
+		out.StartsAt = *c.StartsAt
+	}
+	return out
+}
+
+func profileError(section, key string, err error) error {
+	return fmt.Errorf("bad %s %q: %w", section, key, err)
+}
+
+// normalize validates an uploaded profile with the rules of the single-item
+// admin endpoints and brings it to the form exportConfigProfile produces.
+func (p *ConfigProfile) normalize() error {
+	if p.Version != configProfileVersion {
+		return fmt.Errorf("bad profile version %d, want %d", p.Version, configProfileVersion)
+	}
+	p.ExportedAt = nil
+	if r := p.GameCredits; r != nil {
+		r.UpdatedBy, r.UpdatedAt = nil, nil
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+
+	seen := map[string]bool{}
+	dup := func(section, key string) error {
+		k := section + "\x00" + key
+		if seen[k] {
+			return fmt.Errorf("bad %s: %q is listed twice", section, key)
+		}
+		seen[k] = true
+		return nil
+	}
+	for i := range p.ModuleSwitches {
+		s := &p.ModuleSwitches[i]
+		s.ETA, s.UpdatedBy, s.UpdatedAt = nil, nil, nil
+		if err := s.normalize(); err != nil {
+			return profileError(ProfileModuleSwitches, s.Module, err)
+		}
+		if err := dup(ProfileModuleSwitches, s.Module); err != nil {
+			return err
+		}
+	}
+	for key, v := range p.Params {
+		def, ok := ParamDefOf(key)
+		if !ok {
+			return profileError(ProfileParams, key, errors.New("bad param"))
+		}
+		if err := def.Check(v); err != nil {
+			return profileError(ProfileParams, key, err)
+		}
+	}
+	for i, ps := range p.BurnSchedules {
+		s := BurnSchedule{Name: ps.Name, FeeKinds: ps.FeeKinds, BurnBP: ps.BurnBP, PeriodDays: ps.PeriodDays, Enabled: ps.Enabled}
+		if err := s.normalize(); err != nil {
+			return profileError(ProfileBurnSchedules, ps.Name, err)
+		}
+		p.BurnSchedules[i] = profileBurnSchedule(s)
+		if err := dup(ProfileBurnSchedules, s.Name); err != nil {
+			return err
+		}
+	}
+	for i, pc := range p.MarketCategories {
+		c := pc.category()
+		if err := c.validate(); err != nil {
+			return profileError(ProfileMarketCategories, pc.Slug, err)
+		}
+		p.MarketCategories[i] = profileCategory(c)
+		if err := dup(ProfileMarketCategories, c.Slug); err != nil {
+			return err
+		}
+	}
+	for i, pc := range p.BonusCampaigns {
+		pc.StartsAt, pc.EndsAt = profileTime(pc.StartsAt), profileTime(pc.EndsAt)
+		c := pc.campaign()
+		if err := c.normalize(); err != nil {
+			return profileError(ProfileBonusCampaigns, pc.Code, err)
+		}
+		out := profileBonusCampaign(c)
+		out.StartsAt = pc.StartsAt
+		p.BonusCampaigns[i] = out
+		if err := dup(ProfileBonusCampaigns, c.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileQuerier is a pool or a transaction.
+type profileQuerier interface {
+	rowQuerier
+	rowsQuerier
+}
+
+func exportConfigProfile(ctx context.Context, q profileQuerier) (ConfigProfile, error) {
+	p := ConfigProfile{Version: configProfileVersion}
+	rates, err := getGameCreditRates(ctx, q)
+	switch {
+	case err == nil:
+		rates.UpdatedBy, rates.UpdatedAt = nil, nil
+		p.GameCredits = &rates
+	case !errors.Is(err, pgx.ErrNoRows):
+		return ConfigProfile{}, err
+	}
+
+	switches, err := listModuleSwitches(ctx, q)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	p.ModuleSwitches = make([]ModuleSwitch, 0, len(switches))
+	for _, s := range switches {
+		p.ModuleSwitches = append(p.ModuleSwitches, ModuleSwitch{Module: s.Module, Disabled: s.Disabled, Messages: s.Messages})
+	}
+
+	params, err := listParams(ctx, q)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	p.Params = make(map[string]int64, len(params))
+	for _, v := range params {
+		p.Params[v.Key] = v.Value
+	}
+
+	schedules, err := listBurnSchedules(ctx, q)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	p.BurnSchedules = make([]ProfileBurnSchedule, 0, len(schedules))
+	for _, s := range schedules {
+		p.BurnSchedules = append(p.BurnSchedules, profileBurnSchedule(s))
+	}
+
+	cats, err := listMarketCategories(ctx, q)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	p.MarketCategories = make([]ProfileCategory, 0, len(cats))
+	for _, c := range cats {
+		p.MarketCategories = append(p.MarketCategories, profileCategory(c))
+	}
+
+	campaigns, err := listBonusCampaigns(ctx, q)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	p.BonusCampaigns = make([]ProfileBonusCampaign, 0, len(campaigns))
+	for _, c := range campaigns {
+		p.BonusCampaigns = append(p.BonusCampaigns, profileBonusCampaign(c))
+	}
+	return p, nil
+}
+
+// ExportConfigProfile returns the current configuration as a profile.
+func (d *DB) ExportConfigProfile(ctx context.Context) (ConfigProfile, error) {
+	p, err := exportConfigProfile(ctx, d.Pool)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	p.ExportedAt = &now
+	return p, nil
+}
+
+// diffConfigProfile is the plan that takes cur (as exported) to want (as
+// normalized). It depends on nothing else, so the same inputs always give the
+// same token.
+func diffConfigProfile(cur, want ConfigProfile) ConfigProfilePlan {
+	p := ConfigProfilePlan{Changes: []ConfigChange{}, Conflicts: []string{}}
+	change := func(section, key, action string, from, to any) {
+		p.Changes = append(p.Changes, ConfigChange{Section: section, Key: key, Action: action, From: from, To: to})
+	}
+
+	if want.GameCredits != nil {
+		switch {
+		case cur.GameCredits == nil:
+			p.Conflicts = append(p.Conflicts, "game credit rates are not set up in this environment yet")
+		case *cur.GameCredits != *want.GameCredits:
+			change(ProfileGameCredits, "rates", ConfigUpdate, *cur.GameCredits, *want.GameCredits)
+		}
+	}
+
+	if want.ModuleSwitches != nil {
+		listed := make(map[string]ModuleSwitch, len(want.ModuleSwitches))
+		for _, s := range want.ModuleSwitches {
+			listed[s.Module] = s
+		}
+		for _, c := range cur.ModuleSwitches {
+			w, ok := listed[c.Module]
+			if !ok {
+				w = ModuleSwitch{Module: c.Module, Messages: map[string]string{}}
+			}
+			if c.Disabled != w.Disabled || !maps.Equal(c.Messages, w.Messages) {
+				change(ProfileModuleSwitches, c.Module, ConfigUpdate, c, w)
+			}
+		}
+	}
+
+	if want.Params != nil {
+		for _, def := range ParamDefs {
+			c, had := cur.Params[def.Key]
+			w, has := want.Params[def.Key]
+			switch {
+			case has && !had:
+				change(ProfileParams, def.Key, ConfigCreate, nil, w)
+			case has && c != w:
+				change(ProfileParams, def.Key, ConfigUpdate, c, w)
+			case had && !has:
+				change(ProfileParams, def.Key, ConfigReset, c, nil)
+			}
+		}
+	}
+
+	if want.BurnSchedules != nil {
+		byName := map[string][]ProfileBurnSchedule{}
+		for _, s := range cur.BurnSchedules {
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+		for _, w := range want.BurnSchedules {
+			have := byName[w.Name]
+			switch {
+			case len(have) > 1:
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("%d burn schedules are named %q", len(have), w.Name))
+			case len(have) == 0:
+				change(ProfileBurnSchedules, w.Name, ConfigCreate, nil, w)
+			case !reflect.DeepEqual(have[0], w):
+				change(ProfileBurnSchedules, w.Name, ConfigUpdate, have[0], w)
+			}
+			delete(byName, w.Name)
+		}
+		for _, c := range cur.BurnSchedules {
+			if _, left := byName[c.Name]; left && c.Enabled {
+				off := c
+				off.Enabled = false
+				change(ProfileBurnSchedules, c.Name, ConfigDisable, c, off)
+				delete(byName, c.Name)
+			}
+		}
+	}
+
+	if want.MarketCategories != nil {
+		have := make(map[string]ProfileCategory, len(cur.MarketCategories))
+		parents := make(map[string]string, len(cur.MarketCategories)+len(want.MarketCategories))
+		for _, c := range cur.MarketCategories {
+			have[c.Slug] = c
+			parents[c.Slug] = c.Parent
+		}
+		for _, w := range want.MarketCategories {
+			parents[w.Slug] = w.Parent
+		}
+		listed := make(map[string]bool, len(want.MarketCategories))
+		for _, w := range want.MarketCategories {
+			listed[w.Slug] = true
+			if _, ok := parents[w.Parent]; w.Parent != "" && !ok {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("market category %q: parent %q does not exist", w.Slug, w.Parent))
+			} else if categoryDepth(parents, w.Slug) < 0 {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("market category %q: parents form a cycle", w.Slug))
+			}
+			c, ok := have[w.Slug]
+			switch {
+			case !ok:
+				change(ProfileMarketCategories, w.Slug, ConfigCreate, nil, w)
+			case !reflect.DeepEqual(c, w):
+				change(ProfileMarketCategories, w.Slug, ConfigUpdate, c, w)
+			}
+		}
+		for _, c := range cur.MarketCategories {
+			if !listed[c.Slug] && c.Active {
+				off := c
+				off.Active = false
+				change(ProfileMarketCategories, c.Slug, ConfigDisable, c, off)
+			}
+		}
+	}
+
+	if want.BonusCampaigns != nil {
+		have := make(map[string]ProfileBonusCampaign, len(cur.BonusCampaigns))
+		for _, c := range cur.BonusCampaigns {
+			have[c.Code] = c
+		}
+		listed := make(map[string]bool, len(want.BonusCampaigns))
+		for _, w := range want.BonusCampaigns {
+			listed[w.Code] = true
+			c, ok := have[w.Code]
+			if !ok {
+				change(ProfileBonusCampaigns, w.Code, ConfigCreate, nil, w)
+				continue
+			}
+			terms := c
+			terms.Active = w.Active
+			if w.StartsAt == nil {
+				terms.StartsAt = nil
+			}
+			if !reflect.DeepEqual(terms, w) {
+				p.Conflicts = append(p.Conflicts, fmt.Sprintf("bonus campaign %s: terms differ and cannot be changed", w.Code))
+			} else if c.Active != w.Active {
+				change(ProfileBonusCampaigns, w.Code, ConfigUpdate, c, w)
+			}
+		}
+		for _, c := range cur.BonusCampaigns {
+			if !listed[c.Code] && c.Active {
+				off := c
+				off.Active = false
+				change(ProfileBonusCampaigns, c.Code, ConfigDisable, c, off)
+			}
+		}
+	}
+	return p
+}
+
+// categoryDepth is how many ancestors slug has in the parent map, or -1 if
+// they form a cycle.
+func categoryDepth(parents map[string]string, slug string) int {
+	depth := 0
+	for p := parents[slug]; p != ""; p = parents[p] {
+		depth++
+		if p == slug || depth > len(parents) {
+			return -1
+		}
+	}
+	return depth
+}
+
+// token fingerprints everything the import would change.
+func (p ConfigProfilePlan) token() string {
+	sum := sha256.Sum256([]byte(toJSON(p.Changes) + "|" + strings.Join(p.Conflicts, "|")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func planConfigProfileTx(ctx context.Context, tx pgx.Tx, want ConfigProfile) (ConfigProfilePlan, error) {
+	cur, err := exportConfigProfile(ctx, tx)
+	if err != nil {
+		return ConfigProfilePlan{}, err
+	}
+	p := diffConfigProfile(cur, want)
+	p.Token = p.token()
+	return p, nil
+}
+
+// PlanConfigProfile is the dry run: what applying p would change.
+func (d *DB) PlanConfigProfile(ctx context.Context, p ConfigProfile) (ConfigProfilePlan, error) {
+	if err := p.normalize(); err != nil {
+		return ConfigProfilePlan{}, err
+	}
+	var plan ConfigProfilePlan
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		plan, err = planConfigProfileTx(ctx, tx, p)
+		return err
+	})
+	return plan, err
+}
+
+// ApplyConfigProfile applies p in one transaction: either every change of
+// the plan is made or none. token must match a fresh plan, so nothing changed
+// since the admin reviewed the dry run. Each change is audited as if made
+// through its own endpoint, and the import as a whole as admin_config_profile.
+func (d *DB) ApplyConfigProfile(ctx context.Context, adminID int64, p ConfigProfile, token string) (ConfigProfilePlan, error) {
+	if err := p.normalize(); err != nil {
+		return ConfigProfilePlan{}, err
+	}
+	var plan ConfigProfilePlan
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('config_profile'))`); err != nil {
+			return err
+		}
+		if err := lockMarketCategoriesTx(ctx, tx); err != nil {
+			return err
+		}
+		var err error
+		if plan, err = planConfigProfileTx(ctx, tx, p); err != nil {
+			return err
+		}
+		if len(plan.Conflicts) > 0 {
+			return fmt.Errorf("bad profile: %s", strings.Join(plan.Conflicts, "; "))
+		}
+		if token == "" || token != plan.Token {
+			return ErrConfigProfileChanged
+		}
+		if err := applyConfigChangesTx(ctx, tx, adminID, plan.Changes); err != nil {
+			return err
+		}
+		sections := map[string]int{}
+		for _, c := range plan.Changes {
+			sections[c.Section]++
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_config_profile', $1, NULL, 0, $2::jsonb)`,
+			adminID, toJSON(map[string]any{"token": plan.Token, "changes": len(plan.Changes), "sections": sections}))
+		return err
+	})
+	if err != nil {
+		return ConfigProfilePlan{}, err
+	}
+	return plan, nil
+}
+
+// applyConfigChangesTx makes the changes of a conflict-free plan. Categories
+// go parents first, so every parent exists and no intermediate tree has a
+// cycle.
+func applyConfigChangesTx(ctx context.Context, tx pgx.Tx, adminID int64, changes []ConfigChange) error {
+	var cats []ProfileCategory
+	for _, c := range changes {
+		var err error
+		switch c.Section {
+		case ProfileGameCredits:
+			err = setGameCreditRatesTx(ctx, tx, adminID, c.To.(GameCreditRates))
+		case ProfileModuleSwitches:
+			s := c.To.(ModuleSwitch)
+			err = setModuleSwitchTx(ctx, tx, adminID, &s)
+		case ProfileParams:
+			if c.Action == ConfigReset {
+				err = resetParamTx(ctx, tx, adminID, c.Key)
+			} else {
+				_, err = setParamTx(ctx, tx, adminID, c.Key, c.To.(int64))
+			}
+		case ProfileBurnSchedules:
+			err = applyBurnScheduleTx(ctx, tx, adminID, c.Action, c.To.(ProfileBurnSchedule))
+		case ProfileMarketCategories:
+			cats = append(cats, c.To.(ProfileCategory))
+		case ProfileBonusCampaigns:
+			err = applyBonusCampaignTx(ctx, tx, adminID, c.Action, c.To.(ProfileBonusCampaign))
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", c.Section, c.Key, err)
+		}
+	}
+	if len(cats) == 0 {
+		return nil
+	}
+
+	existing, err := listMarketCategories(ctx, tx)
+	if err != nil {
+		return err
+	}
+	parents := make(map[string]string, len(existing)+len(cats))
+	for _, c := range existing {
+		parents[c.Slug] = c.Parent
+	}
+	for _, c := range cats {
+		parents[c.Slug] = c.Parent
+	}
+	sort.SliceStable(cats, func(i, j int) bool {
+		return categoryDepth(parents, cats[i].Slug) < categoryDepth(parents, cats[j].Slug)
+	})
+	for _, c := range cats {
+		if _, err := saveMarketCategoryTx(ctx, tx, c.category()); err != nil {
+			return fmt.Errorf("%s %s: %w", ProfileMarketCategories, c.Slug, err)
+		}
+	}
+	return nil
+}
+
+func applyBurnScheduleTx(ctx context.Context, tx pgx.Tx, adminID int64, action string, s ProfileBurnSchedule) error {
+	var rows pgx.Rows
+	var err error
+	if action == ConfigCreate {
+		rows, err = tx.Query(ctx, `
+INSERT INTO burn_schedules(name, fee_kinds, burn_bp, period_days, enabled, next_run_at, created_by)
+VALUES($1, $2, $3, $4, $5, now() + make_interval(days => $4::int), $6)
+RETURNING `+burnScheduleColumns, s.Name, s.FeeKinds, s.BurnBP, s.PeriodDays, s.Enabled, adminID)
+	} else {
+		rows, err = tx.Query(ctx, `
+UPDATE burn_schedules
+SET fee_kinds=$2, burn_bp=$3, period_days=$4, enabled=$5, updated_at=now()
+WHERE name=$1
+RETURNING `+burnScheduleColumns, s.Name, s.FeeKinds, s.BurnBP, s.PeriodDays, s.Enabled)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (BurnSchedule, error) { return scanBurnSchedule(row) })
+	if err != nil {
+		return err
+	}
+	for _, b := range out {
+		if err := logBurnScheduleTx(ctx, tx, adminID, action, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyBonusCampaignTx(ctx context.Context, tx pgx.Tx, adminID int64, action string, c ProfileBonusCampaign) error {
+	if action == ConfigCreate {
+		bc := c.campaign()
+		if bc.StartsAt.IsZero() {
+			bc.StartsAt = time.Now().UTC()
+		}
+		if _, err := insertBonusCampaign(ctx, tx, adminID, bc); err != nil {
+			return err
+		}
+		if !c.Active {
+			_, err := tx.Exec(ctx, `UPDATE bonus_campaigns SET active = false WHERE code = $1`, c.Code)
+			return err
+		}
+		return nil
+	}
+	_, err := tx.Exec(ctx, `UPDATE bonus_campaigns SET active = $2 WHERE code = $1`, c.Code, c.Active)
+	return err
+}
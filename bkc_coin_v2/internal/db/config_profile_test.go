@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigProfile(t *testing.T) {
+	fee := int64(5)
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	cur := ConfigProfile{
+		Version:     configProfileVersion,
+		GameCredits: &GameCreditRates{BuyRate: 10, SellRate: 12, Enabled: true},
+		ModuleSwitches: []ModuleSwitch{
+			{Module: ModuleGames, Messages: map[string]string{}},
+			{Module: ModuleWithdrawals, Disabled: true, Messages: map[string]string{"en": "soon"}},
+		},
+		Params:        map[string]int64{ParamTipFeeBP: 100, ParamGiftMinAmount: 5},
+		BurnSchedules: []ProfileBurnSchedule{{Name: "weekly", FeeKinds: []string{"nft_market_fee"}, BurnBP: 100, PeriodDays: 7, Enabled: true}},
+		MarketCategories: []ProfileCategory{
+			{Slug: "art", Names: map[string]string{"en": "Art"}, RequiredAttrs: []string{}, Active: true},
+			{Slug: "old", Names: map[string]string{"en": "Old"}, RequiredAttrs: []string{}, Active: true},
+		},
+		BonusCampaigns: []ProfileBonusCampaign{{Code: "SPRING", Kind: BonusFreeBet, FreeBet: 50, MinOdds: 1, ValidDays: 7, Active: true, StartsAt: &start}},
+	}
+	want := ConfigProfile{
+		Version:        configProfileVersion,
+		GameCredits:    &GameCreditRates{BuyRate: 10, SellRate: 15, Enabled: true},
+		ModuleSwitches: []ModuleSwitch{{Module: ModuleGames, Messages: map[string]string{}}},
+		Params:         map[string]int64{ParamTipFeeBP: 200, ParamMarketListingFee: 10},
+		BurnSchedules:  []ProfileBurnSchedule{},
+		MarketCategories: []ProfileCategory{
+			{Slug: "photo", Parent: "art", Names: map[string]string{"en": "Photo"}, ListingFee: &fee, RequiredAttrs: []string{}, Active: true},
+			{Slug: "art", Names: map[string]string{"en": "Art"}, RequiredAttrs: []string{}, Active: true},
+		},
+		BonusCampaigns: []ProfileBonusCampaign{{Code: "SPRING", Kind: BonusFreeBet, FreeBet: 50, MinOdds: 1, ValidDays: 7}},
+	}
+	p := diffConfigProfile(cur, want)
+	if len(p.Conflicts) != 0 {
+		t.Fatalf("conflicts: %v", p.Conflicts)
+	}
+	var got []string
+	for _, c := range p.Changes {
+		got = append(got, c.Section+" "+c.Key+" "+c.Action)
+	}
+	if g := strings.Join(got, ", "); g != "game_credits rates update, module_switches withdrawals update, "+
+		"params market.listing_fee create, params tip.fee_bp update, params gift.min_amount reset, burn_schedules weekly disable, "+
+		"market_categories photo create, market_categories old disable, bonus_campaigns SPRING update" {
+		t.Fatalf("changes: %s", g)
+	}
+	if diffConfigProfile(cur, cur).token() != (ConfigProfilePlan{Changes: []ConfigChange{}, Conflicts: []string{}}).token() {
+		t.Fatal("a profile differs from itself")
+	}
+	if p.token() == diffConfigProfile(cur, cur).token() {
+		t.Fatal("token ignores changes")
+	}
+
+	// Absent sections are left alone.
+	if p := diffConfigProfile(cur, ConfigProfile{Version: configProfileVersion}); len(p.Changes)+len(p.Conflicts) != 0 {
+		t.Fatalf("empty profile: %+v", p)
+	}
+
+	bad := want
+	bad.MarketCategories = []ProfileCategory{
+		{Slug: "a", Parent: "b", Names: map[string]string{"en": "A"}, RequiredAttrs: []string{}},
+		{Slug: "b", Parent: "a", Names: map[string]string{"en": "B"}, RequiredAttrs: []string{}},
+		{Slug: "c", Parent: "missing", Names: map[string]string{"en": "C"}, RequiredAttrs: []string{}},
+	}
+	bad.BonusCampaigns = []ProfileBonusCampaign{{Code: "SPRING", Kind: BonusFreeBet, FreeBet: 60, MinOdds: 1, ValidDays: 7, Active: true}}
+	if p := diffConfigProfile(cur, bad); len(p.Conflicts) != 4 {
+		t.Fatalf("conflicts: %v", p.Conflicts)
+	}
+}
+
+func TestConfigProfileNormalize(t *testing.T) {
+	for name, p := range map[string]ConfigProfile{
+		"version":   {Version: 2},
+		"rates":     {Version: 1, GameCredits: &GameCreditRates{BuyRate: 10, SellRate: 5}},
+		"module":    {Version: 1, ModuleSwitches: []ModuleSwitch{{Module: "casino"}}},
+		"duplicate": {Version: 1, BurnSchedules: []ProfileBurnSchedule{{Name: "w", BurnBP: 1, PeriodDays: 1}, {Name: " w ", BurnBP: 2, PeriodDays: 1}}},
+		"category":  {Version: 1, MarketCategories: []ProfileCategory{{Slug: "Bad Slug", Names: map[string]string{"en": "x"}}}},
+		"param":     {Version: 1, Params: map[string]int64{ParamTipFeeBP: 5_000}},
+		"campaign":  {Version: 1, BonusCampaigns: []ProfileBonusCampaign{{Code: "x", Kind: BonusFreeBet, FreeBet: 1, ValidDays: 1}}},
+	} {
+		if err := p.normalize(); err == nil || !strings.HasPrefix(err.Error(), "bad ") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestApplyConfigProfile(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM burn_schedules WHERE name = 'profile test'`)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM market_categories WHERE slug IN ('profile-child', 'profile-root')`)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM bonus_campaigns WHERE code = 'PROFILE_TEST'`)
+	})
+
+	p, err := d.ExportConfigProfile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := d.PlanConfigProfile(ctx, p); err != nil || len(plan.Changes)+len(plan.Conflicts) != 0 {
+		t.Fatalf("export does not round-trip: %+v, %v", plan, err)
+	}
+
+	p.GameCredits, p.ModuleSwitches, p.Params = nil, nil, nil
+	p.BurnSchedules = append(p.BurnSchedules, ProfileBurnSchedule{Name: "profile test", BurnBP: 250, PeriodDays: 7, Enabled: true})
+	// The child comes first: the import orders categories itself.
+	p.MarketCategories = append(p.MarketCategories,
+		ProfileCategory{Slug: "profile-child", Parent: "profile-root", Names: map[string]string{"en": "Child"}, Active: true},
+		ProfileCategory{Slug: "profile-root", Names: map[string]string{"en": "Root"}, Active: true})
+	p.BonusCampaigns = append(p.BonusCampaigns, ProfileBonusCampaign{Code: "profile_test", Kind: BonusFreeBet, FreeBet: 10, ValidDays: 3, Active: true})
+
+	plan, err := d.PlanConfigProfile(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 4 || len(plan.Conflicts) != 0 {
+		t.Fatalf("plan: %+v", plan)
+	}
+	if _, err := d.ApplyConfigProfile(ctx, 1, p, "stale"); !errors.Is(err, ErrConfigProfileChanged) {
+		t.Fatalf("stale token: %v", err)
+	}
+	if _, err := d.ApplyConfigProfile(ctx, 1, p, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := d.PlanConfigProfile(ctx, p); err != nil || len(again.Changes) != 0 {
+		t.Fatalf("applied profile still differs: %+v, %v", again, err)
+	}
+
+	cats, err := d.ListMarketCategories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var child MarketCategory
+	for _, c := range cats {
+		if c.Slug == "profile-child" {
+			child = c
+		}
+	}
+	if child.Parent != "profile-root" || !child.Active {
+		t.Fatalf("child: %+v", child)
+	}
+	var next time.Time
+	if err := d.Pool.QueryRow(ctx, `SELECT next_run_at FROM burn_schedules WHERE name = 'profile test'`).Scan(&next); err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(next); until < 6*24*time.Hour || until > 8*24*time.Hour {
+		t.Fatalf("next run in %v", until)
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Creator profiles are the public pages tips are sent from: a bio, links and
+// an optional tip goal. Goal progress is what the creator was tipped since
+// the goal was set; changing the goal amount or title starts it over.
+
+const (
+	maxCreatorLinks   = 8
+	creatorTopTippers = 5
+)
+
+// CreatorLink is a link on a creator profile.
+type CreatorLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// TipGoal is a creator's goal and how far the tips got.
+type TipGoal struct {
+	Title     string    `json:"title"`
+	Amount    int64     `json:"amount"`
+	Raised    int64     `json:"raised"`
+	Percent   int64     `json:"percent"` // 0..100
+	Tips      int64     `json:"tips"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// CreatorProfile is a creator page with the goal and top supporters.
+type CreatorProfile struct {
+	UserID      int64         `json:"user_id"`
+	DisplayName string        `json:"display_name"`
+	Bio         string        `json:"bio"`
+	Links       []CreatorLink `json:"links"`
+	Goal        *TipGoal      `json:"goal,omitempty"`
+	Supporters  []TipperEntry `json:"supporters"` // top of the last 30 days
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// CreatorProfileUpdate is what a creator saves; a zero GoalAmount removes
+// the goal.
+type CreatorProfileUpdate struct {
+	DisplayName string        `json:"display_name"`
+	Bio         string        `json:"bio"`
+	Links       []CreatorLink `json:"links"`
+	GoalTitle   string        `json:"goal_title"`
+	GoalAmount  int64         `json:"goal_amount"`
+}
+
+// goalPercent is raised as a share of amount, capped at 100.
+func goalPercent(raised, amount int64) int64 {
+	if amount <= 0 {
+		return 0
+	}
+	return min(raised*100/amount, 100)
+}
+
+func (u *CreatorProfileUpdate) validate() error {
+	u.DisplayName = strings.TrimSpace(u.DisplayName)
+	u.Bio = strings.TrimSpace(u.Bio)
+	u.GoalTitle = strings.TrimSpace(u.GoalTitle)
+	if u.DisplayName == "" || len(u.DisplayName) > 64 || len(u.Bio) > 1000 || len(u.GoalTitle) > 120 {
+		return errors.New("bad params")
+	}
+	if u.GoalAmount < 0 || (u.GoalAmount > 0 && u.GoalTitle == "") {
+		return errors.New("bad goal")
+	}
+	if len(u.Links) > maxCreatorLinks {
+		return errors.New("bad links")
+	}
+	for i, l := range u.Links {
+		l.Title = strings.TrimSpace(l.Title)
+		l.URL = strings.TrimSpace(l.URL)
+		p, err := url.Parse(l.URL)
+		if err != nil || (p.Scheme != "https" && p.Scheme != "http") || p.Host == "" || len(l.URL) > 300 || len(l.Title) > 64 {
+			return errors.New("bad links")
+		}
+		u.Links[i] = l
+	}
+	return nil
+}
+
+// SaveCreatorProfile creates or updates the user's creator profile.
+func (d *DB) SaveCreatorProfile(ctx context.Context, userID int64, u CreatorProfileUpdate) (CreatorProfile, error) {
+	if userID <= 0 {
+		return CreatorProfile{}, errors.New("bad params")
+	}
+	if err := u.validate(); err != nil {
+		return CreatorProfile{}, err
+	}
+	if u.Links == nil {
+		u.Links = []CreatorLink{}
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO creator_profiles (user_id, display_name, bio, links, goal_title, goal_amount, goal_started_at)
+VALUES ($1, $2, $3, $4::jsonb, $5, $6, now())
+ON CONFLICT (user_id) DO UPDATE
+SET display_name = EXCLUDED.display_name,
+    bio = EXCLUDED.bio,
+    links = EXCLUDED.links,
+    goal_started_at = CASE WHEN creator_profiles.goal_title = EXCLUDED.goal_title AND creator_profiles.goal_amount = EXCLUDED.goal_amount
+      THEN creator_profiles.goal_started_at ELSE now() END,
+    goal_title = EXCLUDED.goal_title,
+    goal_amount = EXCLUDED.goal_amount,
+    updated_at = now()
+`, userID, u.DisplayName, u.Bio, toJSON(u.Links), u.GoalTitle, u.GoalAmount)
+	if err != nil {
+		return CreatorProfile{}, err
+	}
+	return d.GetCreatorProfile(ctx, userID)
+}
+
+// GetCreatorProfile returns the creator page of userID; pgx.ErrNoRows if
+// the user has none.
+func (d *DB) GetCreatorProfile(ctx context.Context, userID int64) (CreatorProfile, error) {
+	p := CreatorProfile{UserID: userID}
+	var goalTitle string
+	var goalAmount int64
+	var goalStarted time.Time
+	err := d.Pool.QueryRow(ctx, `
+SELECT display_name, bio, links, goal_title, goal_amount, goal_started_at, updated_at
+FROM creator_profiles WHERE user_id=$1
+`, userID).Scan(&p.DisplayName, &p.Bio, &p.Links, &goalTitle, &goalAmount, &goalStarted, &p.UpdatedAt)
+	if err != nil {
+		return CreatorProfile{}, err
+	}
+	if p.Links == nil {
+		p.Links = []CreatorLink{}
+	}
+	if goalAmount > 0 {
+		g := TipGoal{Title: goalTitle, Amount: goalAmount, StartedAt: goalStarted}
+		if err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(SUM(amount), 0), COUNT(*) FROM tips WHERE to_id=$1 AND created_at >= $2
+`, userID, goalStarted).Scan(&g.Raised, &g.Tips); err != nil {
+			return CreatorProfile{}, err
+		}
+		g.Percent = goalPercent(g.Raised, g.Amount)
+		p.Goal = &g
+	}
+	p.Supporters, err = d.TipLeaderboard(ctx, userID, time.Now().UTC().AddDate(0, 0, -30), creatorTopTippers)
+	if err != nil {
+		return CreatorProfile{}, err
+	}
+	return p, nil
+}
+
+// GetTipGoal returns only the goal progress, for the embeddable widget;
+// pgx.ErrNoRows if the creator has no profile or no goal.
+func (d *DB) GetTipGoal(ctx context.Context, userID int64) (string, TipGoal, error) {
+	var name string
+	var g TipGoal
+	err := d.Pool.QueryRow(ctx, `
+SELECT p.display_name, p.goal_title, p.goal_amount, p.goal_started_at,
+       COALESCE(SUM(t.amount), 0), COUNT(t.tip_id)
+FROM creator_profiles p
+LEFT JOIN tips t ON t.to_id = p.user_id AND t.created_at >= p.goal_started_at
+WHERE p.user_id=$1 AND p.goal_amount > 0
+GROUP BY p.user_id
+`, userID).Scan(&name, &g.Title, &g.Amount, &g.StartedAt, &g.Raised, &g.Tips)
+	if err != nil {
+		return "", TipGoal{}, err
+	}
+	g.Percent = goalPercent(g.Raised, g.Amount)
+	return name, g, nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import "testing"
+
+func TestGoalPercent(t *testing.T) {
+	cases := []struct{ raised, amount, want int64 }{
+		{0, 1_000, 0},
+		{333, 1_000, 33},
+		{1_500, 1_000, 100}, // capped
+		{10, 0, 0},
+	}
+	for _, c := range cases {
+		if got := goalPercent(c.raised, c.amount); got != c.want {
+			t.Fatalf("percent(%d, %d) = %d, want %d", c.raised, c.amount, got, c.want)
+		}
+	}
+}
+
+func TestCreatorProfileValidate(t *testing.T) {
+	u := CreatorProfileUpdate{DisplayName: " Anna ", Links: []CreatorLink{{Title: " yt ", URL: "https://youtube.com/@anna"}}, GoalTitle: "Mic", GoalAmount: 5_000}
+	if err := u.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if u.DisplayName != "Anna" || u.Links[0].Title != "yt" {
+		t.Fatalf("not trimmed: %+v", u)
+	}
+	for name, bad := range map[string]CreatorProfileUpdate{
+		"no name":         {},
+		"goal no title":   {DisplayName: "a", GoalAmount: 10},
+		"javascript link": {DisplayName: "a", Links: []CreatorLink{{URL: "javascript:alert(1)"}}},
+		"negative goal":   {DisplayName: "a", GoalTitle: "x", GoalAmount: -1},
+	} {
+		if bad.validate() == nil {
+			t.Fatalf("%s accepted", name)
+		}
+	}
+}
@@ -4,15 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
 	"time"
 
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/faults"
+
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DB struct {
 	Pool *pgxpool.Pool
+	// Clock dates loans and gig deadlines; nil is the wall clock. Tests set
+	// a clock.Manual to reach due dates without waiting.
+	Clock clock.Clock
+}
+
+// now is the current time on d.Clock, in UTC.
+func (d *DB) now() time.Time {
+	return clock.Or(d.Clock).Now().UTC()
 }
 
 type SystemState struct {
@@ -26,6 +38,7 @@ type SystemState struct {
 	MinRateCoinsUSD   int64
 	ReferralStep      int64
 	ReferralBonus     int64
+	DailyEmissionCap  int64 // 0 = unlimited
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 }
@@ -48,19 +61,28 @@ type UserState struct {
 }
 
 type NFT struct {
-	NFTID      int64     `json:"nft_id"`
-	Title      string    `json:"title"`
-	ImageURL   string    `json:"image_url"`
-	PriceCoins int64     `json:"price_coins"`
-	SupplyLeft int64     `json:"supply_left"`
-	CreatedAt  time.Time `json:"created_at"`
+	NFTID       int64          `json:"nft_id"`
+	Title       string         `json:"title"`
+	ImageURL    string         `json:"image_url"`
+	PriceCoins  int64          `json:"price_coins"`
+	SupplyLeft  int64          `json:"supply_left"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Collection  string         `json:"collection"`
+	RarityScore float64        `json:"rarity_score"`
+	RarityRank  int64          `json:"rarity_rank"`
+	RarityTier  string         `json:"rarity_tier"`
+	Attributes  []NFTAttribute `json:"attributes,omitempty"`
+	Effects     NFTEffects     `json:"effects"`
+	Wishes      int64          `json:"wishes"` // users with it on their wishlist; GetNFT only
 }
 
 type UserNFT struct {
-	NFTID    int64  `json:"nft_id"`
-	Title    string `json:"title"`
-	ImageURL string `json:"image_url"`
-	Qty      int64  `json:"qty"`
+	NFTID      int64  `json:"nft_id"`
+	Title      string `json:"title"`
+	ImageURL   string `json:"image_url"`
+	Qty        int64  `json:"qty"`
+	Staked     int64  `json:"staked"`
+	RarityTier string `json:"rarity_tier"`
 }
 
 type CryptoPayInvoice struct {
@@ -115,6 +137,12 @@ type P2PLoan struct {
 	AcceptedAt *time.Time `json:"accepted_at"`
 	DueAt      *time.Time `json:"due_at"`
 	ClosedAt   *time.Time `json:"closed_at"`
+	OfferID    *int64     `json:"offer_id,omitempty"`   // taken from a standing offer
+	Collateral int64      `json:"collateral,omitempty"` // frozen from the borrower until the loan closes
+	Premium    int64      `json:"premium,omitempty"`    // insurance bought by the borrower
+	CoverageBP int64      `json:"coverage_bp,omitempty"`
+	// InsurancePaid is what the pool paid the lender on default.
+	InsurancePaid int64 `json:"insurance_paid,omitempty"`
 }
 
 type MarketListing struct {
@@ -130,6 +158,8 @@ type MarketListing struct {
 	SoldAt      *time.Time `json:"sold_at"`
 	BuyerID     *int64     `json:"buyer_id"`
 	ImageID     *int64     `json:"image_id,omitempty"`
+
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 type MarketListingImage struct {
@@ -177,16 +207,7 @@ type DailyTapAggregate struct {
 }
 
 func Connect(ctx context.Context, databaseURL string) (*DB, error) {
-	cfg, err := pgxpool.ParseConfig(databaseURL)
-	if err != nil {
-		return nil, err
-	}
-	cfg.MaxConns = 5
-	pool, err := pgxpool.NewWithConfig(ctx, cfg)
-	if err != nil {
-		return nil, err
-	}
-	return &DB{Pool: pool}, nil
+	return ConnectWith(ctx, databaseURL, QueryConfig{})
 }
 
 func (d *DB) Close() {
@@ -196,6 +217,7 @@ func (d *DB) Close() {
 }
 
 func (d *DB) Migrate(ctx context.Context) error {
+	ctx = WithoutQueryTimeout(ctx)
 	sql := `
 CREATE TABLE IF NOT EXISTS system_state (
   id INT PRIMARY KEY DEFAULT 1,
@@ -337,7 +359,7 @@ CREATE TABLE IF NOT EXISTS p2p_loans (
   total_due BIGINT NOT NULL,
   interest_bp INT NOT NULL,
   term_days INT NOT NULL,
-  status TEXT NOT NULL DEFAULT 'requested', -- requested|active|rejected|cancelled|repaid
+  status TEXT NOT NULL DEFAULT 'requested', -- requested|active|rejected|cancelled|repaid|defaulted
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   accepted_at TIMESTAMPTZ,
   due_at TIMESTAMPTZ,
@@ -346,6 +368,30 @@ CREATE TABLE IF NOT EXISTS p2p_loans (
 CREATE INDEX IF NOT EXISTS p2p_loans_lender_idx ON p2p_loans(lender_id, status, created_at DESC);
 CREATE INDEX IF NOT EXISTS p2p_loans_borrower_idx ON p2p_loans(borrower_id, status, created_at DESC);
 
+-- P2P lending offers: standing terms a borrower takes without waiting for the lender
+CREATE TABLE IF NOT EXISTS p2p_loan_offers (
+  offer_id BIGSERIAL PRIMARY KEY,
+  lender_id BIGINT NOT NULL,
+  min_amount BIGINT NOT NULL,
+  max_amount BIGINT NOT NULL,
+  interest_bp INT NOT NULL,
+  term_days INT NOT NULL,
+  collateral_bp INT NOT NULL DEFAULT 0, -- of the principal, frozen from the borrower until the loan closes
+  max_exposure BIGINT NOT NULL,         -- the lender's active principal after which the offer stops lending
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS p2p_loan_offers_lender_idx ON p2p_loan_offers(lender_id, offer_id DESC);
+CREATE INDEX IF NOT EXISTS p2p_loan_offers_active_idx ON p2p_loan_offers(interest_bp, offer_id) WHERE active;
+ALTER TABLE p2p_loans ADD COLUMN IF NOT EXISTS offer_id BIGINT;
+ALTER TABLE p2p_loans ADD COLUMN IF NOT EXISTS collateral BIGINT NOT NULL DEFAULT 0;
+-- Loan insurance: the borrower's premium goes to the loan_insurance_pool system account,
+-- which pays the lender coverage_bp of the uncollateralized principal on default
+ALTER TABLE p2p_loans ADD COLUMN IF NOT EXISTS premium BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE p2p_loans ADD COLUMN IF NOT EXISTS coverage_bp INT NOT NULL DEFAULT 0;
+ALTER TABLE p2p_loans ADD COLUMN IF NOT EXISTS insurance_paid BIGINT NOT NULL DEFAULT 0;
+
 -- Marketplace (bazaar)
 CREATE TABLE IF NOT EXISTS market_listings (
   listing_id BIGSERIAL PRIMARY KEY,
@@ -389,6 +435,1259 @@ CREATE TABLE IF NOT EXISTS deposit_wallets (
   address TEXT NOT NULL,
   updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
+
+-- NFT attributes / rarity
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS collection TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS rarity_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS rarity_rank BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS rarity_tier TEXT NOT NULL DEFAULT 'common';
+CREATE INDEX IF NOT EXISTS nfts_collection_rarity_idx ON nfts(collection, rarity_score DESC);
+
+CREATE TABLE IF NOT EXISTS nft_attributes (
+  nft_id BIGINT NOT NULL REFERENCES nfts(nft_id) ON DELETE CASCADE,
+  trait_type TEXT NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (nft_id, trait_type)
+);
+CREATE INDEX IF NOT EXISTS nft_attributes_trait_idx ON nft_attributes(trait_type, value);
+
+-- NFT staking (owned NFTs locked for daily rewards from reserve)
+ALTER TABLE nft_owns ADD COLUMN IF NOT EXISTS staked_qty BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS nft_stakes (
+  stake_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  nft_id BIGINT NOT NULL,
+  qty BIGINT NOT NULL,
+  daily_reward BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'active', -- active|unstaked
+  lock_until TIMESTAMPTZ NOT NULL,
+  accrued BIGINT NOT NULL DEFAULT 0,
+  claimed BIGINT NOT NULL DEFAULT 0,
+  accrued_until TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  closed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS nft_stakes_user_idx ON nft_stakes(user_id, status, created_at DESC);
+CREATE INDEX IF NOT EXISTS nft_stakes_accrual_idx ON nft_stakes(status, accrued_until);
+
+-- Utility NFT effects (tap multiplier, energy max boost, fee discount)
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS effects JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- Secondary NFT market (user -> user resale with creator royalty)
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS creator_id BIGINT;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS royalty_bp BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE nft_owns ADD COLUMN IF NOT EXISTS listed_qty BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS nft_listings (
+  listing_id BIGSERIAL PRIMARY KEY,
+  seller_id BIGINT NOT NULL,
+  nft_id BIGINT NOT NULL REFERENCES nfts(nft_id),
+  qty BIGINT NOT NULL,
+  qty_left BIGINT NOT NULL,
+  price_coins BIGINT NOT NULL, -- per copy
+  status TEXT NOT NULL DEFAULT 'active', -- active|sold|cancelled
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS nft_listings_active_idx ON nft_listings(status, nft_id, price_coins);
+CREATE INDEX IF NOT EXISTS nft_listings_seller_idx ON nft_listings(seller_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS nft_sales (
+  sale_id BIGSERIAL PRIMARY KEY,
+  listing_id BIGINT,
+  nft_id BIGINT NOT NULL,
+  seller_id BIGINT,
+  buyer_id BIGINT NOT NULL,
+  qty BIGINT NOT NULL,
+  price_coins BIGINT NOT NULL, -- per copy
+  royalty BIGINT NOT NULL DEFAULT 0,
+  fee BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS nft_sales_nft_idx ON nft_sales(nft_id, created_at DESC);
+
+-- Per-user notification events (outbox read by the client and the bot)
+CREATE TABLE IF NOT EXISTS user_events (
+  event_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  kind TEXT NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+  read_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS user_events_user_idx ON user_events(user_id, event_id DESC);
+CREATE INDEX IF NOT EXISTS user_events_unread_idx ON user_events(user_id, kind) WHERE read_at IS NULL;
+
+-- NFT offers: buyer funds are frozen until accept/decline/cancel/expiry
+CREATE TABLE IF NOT EXISTS nft_offers (
+  offer_id BIGSERIAL PRIMARY KEY,
+  buyer_id BIGINT NOT NULL,
+  seller_id BIGINT, -- NULL for an open offer on any holder's copy
+  nft_id BIGINT NOT NULL REFERENCES nfts(nft_id),
+  listing_id BIGINT REFERENCES nft_listings(listing_id),
+  qty BIGINT NOT NULL,
+  price_coins BIGINT NOT NULL, -- per copy, locked amount = price_coins*qty
+  counter_price BIGINT,
+  status TEXT NOT NULL DEFAULT 'open', -- open|countered|accepted|declined|cancelled|expired
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS nft_offers_buyer_idx ON nft_offers(buyer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS nft_offers_seller_idx ON nft_offers(seller_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS nft_offers_nft_idx ON nft_offers(nft_id, status);
+CREATE INDEX IF NOT EXISTS nft_offers_expiry_idx ON nft_offers(status, expires_at);
+
+-- Watchlist and saved searches
+CREATE TABLE IF NOT EXISTS watchlist (
+  user_id BIGINT NOT NULL,
+  kind TEXT NOT NULL, -- nft|listing
+  target_id BIGINT NOT NULL,
+  last_price BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, kind, target_id)
+);
+
+CREATE TABLE IF NOT EXISTS saved_searches (
+  search_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name TEXT NOT NULL,
+  scope TEXT NOT NULL, -- market|nft
+  query TEXT NOT NULL DEFAULT '',
+  category TEXT NOT NULL DEFAULT '',
+  collection TEXT NOT NULL DEFAULT '',
+  tier TEXT NOT NULL DEFAULT '',
+  nft_id BIGINT NOT NULL DEFAULT 0,
+  min_price BIGINT NOT NULL DEFAULT 0,
+  max_price BIGINT NOT NULL DEFAULT 0,
+  last_seen_id BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS saved_searches_user_idx ON saved_searches(user_id);
+
+-- NFT listings priced in USDT/TON (quote_price in 1e-6 units), converted to BKC at checkout
+ALTER TABLE nft_listings ADD COLUMN IF NOT EXISTS quote_currency TEXT NOT NULL DEFAULT 'BKC';
+ALTER TABLE nft_listings ADD COLUMN IF NOT EXISTS quote_price BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE nft_sales ADD COLUMN IF NOT EXISTS quote_currency TEXT;
+ALTER TABLE nft_sales ADD COLUMN IF NOT EXISTS quote_price BIGINT;
+ALTER TABLE nft_sales ADD COLUMN IF NOT EXISTS quote_rate DOUBLE PRECISION; -- BKC per unit at checkout
+
+-- Paid listing promotions (featured rotation); budget is frozen and spent per impression
+CREATE TABLE IF NOT EXISTS listing_promotions (
+  promo_id BIGSERIAL PRIMARY KEY,
+  seller_id BIGINT NOT NULL,
+  kind TEXT NOT NULL, -- market|nft
+  listing_id BIGINT NOT NULL,
+  budget BIGINT NOT NULL,
+  daily_budget BIGINT NOT NULL,
+  cost_per_impression BIGINT NOT NULL,
+  spent BIGINT NOT NULL DEFAULT 0,
+  settled BIGINT NOT NULL DEFAULT 0, -- spent coins already moved to reserve
+  spend_day DATE,
+  day_spent BIGINT NOT NULL DEFAULT 0,
+  impressions BIGINT NOT NULL DEFAULT 0,
+  clicks BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'active', -- active|ended|cancelled
+  starts_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ends_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS listing_promotions_active_idx ON listing_promotions(kind, status, ends_at);
+CREATE INDEX IF NOT EXISTS listing_promotions_seller_idx ON listing_promotions(seller_id, created_at DESC);
+
+-- Seller storefronts with moderated vanity handles, reserved handles and buyer reviews
+CREATE TABLE IF NOT EXISTS storefronts (
+  seller_id BIGINT PRIMARY KEY,
+  handle TEXT NOT NULL, -- lowercase [a-z0-9_]
+  handle_status TEXT NOT NULL DEFAULT 'pending', -- pending|approved|rejected
+  display_name TEXT NOT NULL DEFAULT '',
+  bio TEXT NOT NULL DEFAULT '',
+  verified BOOLEAN NOT NULL DEFAULT false,
+  premium BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS storefronts_handle_uniq ON storefronts(handle) WHERE handle_status <> 'rejected';
+CREATE INDEX IF NOT EXISTS storefronts_moderation_idx ON storefronts(handle_status, updated_at);
+
+CREATE TABLE IF NOT EXISTS reserved_handles (
+  handle TEXT PRIMARY KEY,
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS seller_reviews (
+  review_id BIGSERIAL PRIMARY KEY,
+  seller_id BIGINT NOT NULL,
+  buyer_id BIGINT NOT NULL,
+  kind TEXT NOT NULL, -- market|nft
+  ref_id BIGINT NOT NULL, -- market listing_id or nft sale_id
+  stars SMALLINT NOT NULL,
+  comment TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (buyer_id, kind, ref_id)
+);
+CREATE INDEX IF NOT EXISTS seller_reviews_seller_idx ON seller_reviews(seller_id, created_at DESC);
+
+-- WebApp sessions (one per signed initData) for device list and revocation
+CREATE TABLE IF NOT EXISTS sessions (
+  session_id BIGSERIAL PRIMARY KEY,
+  session_key TEXT NOT NULL UNIQUE, -- sha256 of initData
+  user_id BIGINT NOT NULL,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions(user_id, last_seen_at DESC);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_before TIMESTAMPTZ; -- "log out everywhere"
+
+-- Two-factor authentication: TOTP secrets, one-time backup codes, Telegram step-up challenges
+CREATE TABLE IF NOT EXISTS user_2fa (
+  user_id BIGINT PRIMARY KEY,
+  secret TEXT NOT NULL, -- base32 TOTP secret
+  enabled BOOLEAN NOT NULL DEFAULT false,
+  last_step BIGINT NOT NULL DEFAULT 0, -- last accepted TOTP time step (replay guard)
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  enabled_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS user_backup_codes (
+  user_id BIGINT NOT NULL,
+  code_hash TEXT NOT NULL, -- sha256
+  used_at TIMESTAMPTZ,
+  PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS stepup_challenges (
+  challenge_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  action TEXT NOT NULL, -- withdraw|wallet_change|transfer
+  amount BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'pending', -- pending|approved|denied|used
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS stepup_challenges_user_idx ON stepup_challenges(user_id, created_at DESC);
+
+-- Login geos and risk-held withdrawals
+CREATE TABLE IF NOT EXISTS login_geos (
+  user_id BIGINT NOT NULL,
+  country TEXT NOT NULL DEFAULT '',
+  asn BIGINT NOT NULL DEFAULT 0,
+  logins BIGINT NOT NULL DEFAULT 1,
+  first_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, country, asn)
+);
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+  withdrawal_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  address TEXT NOT NULL,
+  status TEXT NOT NULL, -- held|pending|approved|rejected|cancelled
+  ip TEXT NOT NULL DEFAULT '',
+  country TEXT NOT NULL DEFAULT '',
+  asn BIGINT NOT NULL DEFAULT 0,
+  risk_score INT NOT NULL DEFAULT 0,
+  risk_reasons TEXT[] NOT NULL DEFAULT '{}',
+  confirmed_at TIMESTAMPTZ,
+  reviewed_by BIGINT,
+  reviewed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS withdrawals_status_idx ON withdrawals(status, created_at);
+CREATE INDEX IF NOT EXISTS withdrawals_user_idx ON withdrawals(user_id, created_at DESC);
+
+-- Plan-based daily tap limits (overrides TAP_DAILY_LIMIT while active)
+CREATE TABLE IF NOT EXISTS user_plans (
+  user_id BIGINT PRIMARY KEY,
+  plan TEXT NOT NULL,
+  daily_tap_limit BIGINT NOT NULL CHECK (daily_tap_limit >= 0), -- 0 = unlimited
+  expires_at TIMESTAMPTZ,
+  updated_by BIGINT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Internal BKC rate history (sampled from system_state, see RecordRateSample)
+CREATE TABLE IF NOT EXISTS rate_samples (
+  id BIGSERIAL PRIMARY KEY,
+  ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+  coins_per_usd BIGINT NOT NULL,
+  reserve_supply BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_samples_ts_idx ON rate_samples(ts);
+
+-- Global daily emission cap (free mints: taps, rewards, referral and staking payouts)
+ALTER TABLE system_state ADD COLUMN IF NOT EXISTS daily_emission_cap BIGINT NOT NULL DEFAULT 0; -- 0 = unlimited
+CREATE TABLE IF NOT EXISTS daily_emission (
+  day DATE PRIMARY KEY,
+  minted BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Burns: every burn is a ledger row whose kind ends in 'burn'
+CREATE INDEX IF NOT EXISTS ledger_burn_idx ON ledger(kind, ts) WHERE kind LIKE '%burn';
+CREATE TABLE IF NOT EXISTS burn_schedules (
+  schedule_id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  fee_kinds TEXT[] NOT NULL,
+  burn_bp BIGINT NOT NULL CHECK (burn_bp > 0 AND burn_bp <= 10000),
+  period_days INT NOT NULL CHECK (period_days > 0),
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  next_run_at TIMESTAMPTZ NOT NULL,
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS burn_reports (
+  report_id BIGSERIAL PRIMARY KEY,
+  schedule_id BIGINT NOT NULL REFERENCES burn_schedules(schedule_id),
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end TIMESTAMPTZ NOT NULL,
+  fees BIGINT NOT NULL,
+  burn_bp BIGINT NOT NULL,
+  burned BIGINT NOT NULL,
+  ledger_id BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (schedule_id, period_start)
+);
+CREATE INDEX IF NOT EXISTS burn_reports_created_idx ON burn_reports(created_at DESC);
+
+-- Reserve runway forecasts (job reserve_forecast)
+CREATE TABLE IF NOT EXISTS reserve_forecasts (
+  forecast_id BIGSERIAL PRIMARY KEY,
+  ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+  available BIGINT NOT NULL,
+  emitted_per_day BIGINT NOT NULL,
+  burned_per_day BIGINT NOT NULL,
+  net_per_day BIGINT NOT NULL,
+  runway_days DOUBLE PRECISION NOT NULL, -- -1 = reserve is not draining
+  alerted BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS reserve_forecasts_ts_idx ON reserve_forecasts(ts DESC);
+
+-- Admin allocation vesting: cliff + monthly unlocks out of system_state.admin_allocated
+CREATE TABLE IF NOT EXISTS admin_vesting (
+  vesting_id BIGSERIAL PRIMARY KEY,
+  beneficiary_id BIGINT NOT NULL REFERENCES users(user_id),
+  total BIGINT NOT NULL CHECK (total > 0),
+  withdrawn BIGINT NOT NULL DEFAULT 0 CHECK (withdrawn >= 0 AND withdrawn <= total),
+  start_at TIMESTAMPTZ NOT NULL,
+  cliff_months INT NOT NULL CHECK (cliff_months >= 0),
+  period_months INT NOT NULL CHECK (period_months > 0),
+  note TEXT NOT NULL DEFAULT '',
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS admin_vesting_beneficiary_idx ON admin_vesting(beneficiary_id);
+
+-- Sale limits and tax on liquidity exits (withdrawals, exchange listings)
+ALTER TABLE user_daily ADD COLUMN IF NOT EXISTS sold BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS sale_tax BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS tax_burn BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS sale_day DATE;
+
+-- System accounts (stabilization fund) and fund interventions
+CREATE TABLE IF NOT EXISTS system_accounts (
+  account TEXT PRIMARY KEY,
+  balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO system_accounts(account) VALUES('stabilization_fund') ON CONFLICT (account) DO NOTHING;
+INSERT INTO system_accounts(account) VALUES('loan_insurance_pool') ON CONFLICT (account) DO NOTHING;
+CREATE TABLE IF NOT EXISTS stabilization_interventions (
+  intervention_id BIGSERIAL PRIMARY KEY,
+  kind TEXT NOT NULL, -- buy_support|reserve_topup
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  reason TEXT NOT NULL DEFAULT '',
+  admin_id BIGINT,
+  rate_before BIGINT NOT NULL,
+  rate_after BIGINT NOT NULL,
+  fund_after BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS stabilization_interventions_created_idx ON stabilization_interventions(created_at DESC);
+
+-- Levels and XP
+ALTER TABLE users ADD COLUMN IF NOT EXISTS level BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS xp BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS xp_taps BIGINT NOT NULL DEFAULT 0; -- taps_total already converted to XP
+CREATE INDEX IF NOT EXISTS users_xp_taps_idx ON users(user_id) WHERE taps_total > xp_taps;
+CREATE TABLE IF NOT EXISTS level_ups (
+  user_id BIGINT NOT NULL,
+  level BIGINT NOT NULL,
+  reward BIGINT NOT NULL DEFAULT 0,
+  paid_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, level)
+);
+CREATE INDEX IF NOT EXISTS level_ups_unpaid_idx ON level_ups(created_at) WHERE paid_at IS NULL AND reward > 0;
+CREATE TABLE IF NOT EXISTS xp_sync (
+  id INT PRIMARY KEY DEFAULT 1,
+  ledger_id BIGINT NOT NULL DEFAULT 0, -- last ledger row scanned for XP
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO xp_sync(id) VALUES(1) ON CONFLICT (id) DO NOTHING;
+
+-- Admin account merges (duplicate Telegram accounts)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into BIGINT;
+CREATE TABLE IF NOT EXISTS account_merges (
+  merge_id BIGSERIAL PRIMARY KEY,
+  from_id BIGINT NOT NULL,
+  to_id BIGINT NOT NULL,
+  admin_id BIGINT NOT NULL,
+  plan JSONB NOT NULL, -- MergePlan as confirmed
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS account_merges_from_idx ON account_merges(from_id);
+CREATE INDEX IF NOT EXISTS account_merges_to_idx ON account_merges(to_id);
+
+-- Transfer velocity limits (anti money-laundering)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS kyc_tier INT NOT NULL DEFAULT 0; -- 0 none, 1 basic, 2 full
+CREATE INDEX IF NOT EXISTS ledger_transfer_from_idx ON ledger(from_id, ts) WHERE kind='transfer';
+CREATE TABLE IF NOT EXISTS velocity_alerts (
+  alert_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  to_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL,
+  rule TEXT NOT NULL,
+  rule_limit BIGINT NOT NULL,
+  current BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS velocity_alerts_user_idx ON velocity_alerts(user_id, alert_id DESC);
+
+-- Suspicious ledger patterns (job ledger_patterns)
+CREATE TABLE IF NOT EXISTS ledger_scans (
+  day DATE PRIMARY KEY,
+  alerts INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS pattern_alerts (
+  alert_id BIGSERIAL PRIMARY KEY,
+  day DATE NOT NULL,
+  pattern TEXT NOT NULL, -- fan_in | cycle
+  score BIGINT NOT NULL,
+  user_ids BIGINT[] NOT NULL,
+  amount BIGINT NOT NULL,
+  transfers BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open', -- open | frozen | dismissed
+  resolved_by BIGINT,
+  resolved_at TIMESTAMPTZ,
+  details JSONB NOT NULL DEFAULT '{}'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS pattern_alerts_status_idx ON pattern_alerts(status, score DESC);
+
+-- User API keys for programmatic access (only the hash is stored)
+CREATE TABLE IF NOT EXISTS api_keys (
+  key_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  scope TEXT NOT NULL, -- read | trade
+  rate_per_minute BIGINT NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys(user_id);
+
+-- Developer webhooks: ledger rows relayed to signed HTTP deliveries (job webhooks)
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+  subscription_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  url TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  secret TEXT NOT NULL, -- HMAC-SHA256 signing key
+  disabled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_user_idx ON webhook_subscriptions(user_id) WHERE disabled_at IS NULL;
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  delivery_id BIGSERIAL PRIMARY KEY,
+  subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(subscription_id),
+  event_id TEXT NOT NULL,
+  event_type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | failed
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ,
+  last_status INT,
+  last_error TEXT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status='pending';
+CREATE TABLE IF NOT EXISTS webhook_relay (
+  id INT PRIMARY KEY DEFAULT 1,
+  ledger_id BIGINT NOT NULL DEFAULT 0, -- last ledger row relayed
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO webhook_relay(id, ledger_id) SELECT 1, COALESCE(MAX(id), 0) FROM ledger ON CONFLICT (id) DO NOTHING;
+
+-- Home screen read model (job home_views, see ProjectHomeViews)
+CREATE TABLE IF NOT EXISTS home_views (
+  user_id BIGINT PRIMARY KEY,
+  doc JSONB NOT NULL, -- HomeView
+  updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS home_dirty (
+  user_id BIGINT PRIMARY KEY,
+  marked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS home_projection (
+  id INT PRIMARY KEY DEFAULT 1,
+  ledger_id BIGINT NOT NULL DEFAULT 0,
+  event_id BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO home_projection(id, ledger_id, event_id)
+SELECT 1, (SELECT COALESCE(MAX(id), 0) FROM ledger), (SELECT COALESCE(MAX(event_id), 0) FROM user_events)
+ON CONFLICT (id) DO NOTHING;
+
+-- Analytics export cursors (job analytics_export)
+CREATE TABLE IF NOT EXISTS analytics_export (
+  stream TEXT PRIMARY KEY, -- ledger | user_events | client_events
+  last_id BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Admin alerts to Telegram (job alerts): one open alert per kind
+CREATE TABLE IF NOT EXISTS admin_alerts (
+  alert_id BIGSERIAL PRIMARY KEY,
+  kind TEXT NOT NULL,
+  message TEXT NOT NULL,
+  count BIGINT NOT NULL DEFAULT 1,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  sent_at TIMESTAMPTZ,
+  acked_at TIMESTAMPTZ,
+  acked_by BIGINT,
+  resolved_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS admin_alerts_open_idx ON admin_alerts(kind) WHERE resolved_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS job_heartbeats (
+  name TEXT PRIMARY KEY,
+  interval_seconds BIGINT NOT NULL,
+  first_run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_ok_at TIMESTAMPTZ,
+  last_error TEXT
+);
+
+CREATE TABLE IF NOT EXISTS payment_failures (
+  failure_id BIGSERIAL PRIMARY KEY,
+  source TEXT NOT NULL, -- cryptopay | ...
+  ref TEXT NOT NULL DEFAULT '',
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS payment_failures_created_idx ON payment_failures(created_at);
+
+-- Localized notification templates, versioned for audits (job notifications)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language TEXT; -- from Telegram language_code
+CREATE TABLE IF NOT EXISTS notification_templates (
+  key TEXT NOT NULL,
+  lang TEXT NOT NULL,
+  version BIGINT NOT NULL, -- 0 is the built-in text, never stored
+  subject TEXT NOT NULL DEFAULT '',
+  body TEXT NOT NULL,
+  created_by BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (key, lang, version)
+);
+CREATE TABLE IF NOT EXISTS notification_log (
+  notification_id BIGSERIAL PRIMARY KEY,
+  ledger_id BIGINT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  key TEXT NOT NULL,
+  vars JSONB NOT NULL DEFAULT '{}'::jsonb,
+  status TEXT NOT NULL DEFAULT 'pending', -- pending | sent | failed | muted
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ DEFAULT now(),
+  lang TEXT,               -- language and template version actually sent
+  template_version BIGINT,
+  last_error TEXT,
+  sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (ledger_id, key)
+);
+CREATE INDEX IF NOT EXISTS notification_log_due_idx ON notification_log(next_attempt_at) WHERE status='pending';
+CREATE INDEX IF NOT EXISTS notification_log_user_idx ON notification_log(user_id, notification_id DESC);
+CREATE TABLE IF NOT EXISTS notify_relay (
+  id INT PRIMARY KEY DEFAULT 1,
+  ledger_id BIGINT NOT NULL DEFAULT 0, -- last ledger row queued
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO notify_relay(id, ledger_id) SELECT 1, COALESCE(MAX(id), 0) FROM ledger ON CONFLICT (id) DO NOTHING;
+
+-- User preferences (/api/v1/settings); no row = defaults
+CREATE TABLE IF NOT EXISTS user_settings (
+  user_id BIGINT PRIMARY KEY REFERENCES users(user_id),
+  language TEXT,                 -- overrides users.language
+  notify_channels TEXT[] NOT NULL DEFAULT '{telegram}',
+  muted_notifications TEXT[] NOT NULL DEFAULT '{}', -- template keys
+  hide_from_leaderboards BOOLEAN NOT NULL DEFAULT false,
+  default_chain TEXT,            -- ton | ton_usdt | solana_usdt
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS user_settings_hidden_idx ON user_settings(user_id) WHERE hide_from_leaderboards;
+
+-- Shadow accounting of a candidate tap reward formula (ECONOMY_SHADOW, job tap_shadow)
+CREATE TABLE IF NOT EXISTS tap_shadow_daily (
+  engine TEXT NOT NULL, -- candidate formula name
+  user_id BIGINT NOT NULL,
+  day DATE NOT NULL,
+  taps BIGINT NOT NULL DEFAULT 0,
+  actual_coins BIGINT NOT NULL DEFAULT 0, -- paid by the current engine
+  shadow_coins BIGINT NOT NULL DEFAULT 0, -- would have been paid by the candidate
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (engine, user_id, day)
+);
+CREATE INDEX IF NOT EXISTS tap_shadow_daily_day_idx ON tap_shadow_daily(engine, day);
+
+-- Public token stats snapshot (job public_stats, /api/v1/public/stats)
+CREATE TABLE IF NOT EXISTS public_stats (
+  id INT PRIMARY KEY DEFAULT 1,
+  total_supply BIGINT NOT NULL,
+  circulating_supply BIGINT NOT NULL,
+  burned BIGINT NOT NULL,
+  holders BIGINT NOT NULL,
+  volume_24h BIGINT NOT NULL,
+  generated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Proof of reserves (job reserves, /api/v1/reserves)
+CREATE TABLE IF NOT EXISTS reserve_reports (
+  report_id BIGSERIAL PRIMARY KEY,
+  generated_at TIMESTAMPTZ NOT NULL,
+  report TEXT NOT NULL, -- the signed bytes, kept verbatim (JSONB would reformat them)
+  signature TEXT NOT NULL,
+  public_key TEXT NOT NULL,
+  merkle_root TEXT NOT NULL,
+  secret BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reserve_leaves (
+  report_id BIGINT NOT NULL REFERENCES reserve_reports(report_id),
+  user_id BIGINT NOT NULL,
+  idx BIGINT NOT NULL,
+  coins BIGINT NOT NULL,
+  PRIMARY KEY (report_id, user_id)
+);
+CREATE TABLE IF NOT EXISTS reserve_tree (
+  report_id BIGINT NOT NULL REFERENCES reserve_reports(report_id),
+  level INT NOT NULL,
+  hashes BYTEA NOT NULL,
+  PRIMARY KEY (report_id, level)
+);
+ALTER TABLE reserve_tree ALTER COLUMN hashes SET STORAGE EXTERNAL;
+
+-- Admins and their permissions (the configured ADMIN_ID is a super admin)
+CREATE TABLE IF NOT EXISTS admins (
+  user_id BIGINT PRIMARY KEY,
+  permissions TEXT[] NOT NULL DEFAULT '{}',
+  super BOOLEAN NOT NULL DEFAULT false,
+  granted_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Runtime kill switches per module (maintenance, games, withdrawals, marketplace)
+CREATE TABLE IF NOT EXISTS module_switches (
+  module TEXT PRIMARY KEY,
+  disabled BOOLEAN NOT NULL DEFAULT false,
+  messages JSONB NOT NULL DEFAULT '{}'::jsonb,
+  eta TIMESTAMPTZ,
+  updated_by BIGINT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Replay protection of webapp money requests (challenge secret + single-use client nonces)
+CREATE TABLE IF NOT EXISTS request_challenges (
+  challenge_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  secret BYTEA NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS request_challenges_expires_idx ON request_challenges(expires_at);
+CREATE TABLE IF NOT EXISTS request_nonces (
+  challenge_id BIGINT NOT NULL REFERENCES request_challenges(challenge_id) ON DELETE CASCADE,
+  nonce TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (challenge_id, nonce)
+);
+
+-- crash bet insurance (internal/games): premium to reserve, refund if the round crashes below multiplier
+CREATE TABLE IF NOT EXISTS crash_bet_insurance (
+  bet_id TEXT PRIMARY KEY,
+  game_id TEXT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  multiplier DOUBLE PRECISION NOT NULL,
+  refund_bp BIGINT NOT NULL,
+  premium BIGINT NOT NULL,
+  payout BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'active',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  settled_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS crash_bet_insurance_game_idx ON crash_bet_insurance(game_id, status);
+CREATE INDEX IF NOT EXISTS crash_bet_insurance_user_idx ON crash_bet_insurance(user_id, created_at DESC);
+
+-- game RTP monitor: stakes and wins by kind and time (db.GameBooks)
+CREATE INDEX IF NOT EXISTS ledger_kind_ts_idx ON ledger(kind, ts);
+
+-- crash client seeds (internal/games): mixed with the committed server seed when the round launches
+CREATE TABLE IF NOT EXISTS crash_seed_contributions (
+  game_id TEXT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  client_seed TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (game_id, user_id)
+);
+
+-- Bot likelihood of game players (ScoreGameBots) and the admin verdict
+CREATE TABLE IF NOT EXISTS bot_scores (
+  user_id BIGINT PRIMARY KEY REFERENCES users(user_id),
+  score BIGINT NOT NULL,
+  reasons TEXT[] NOT NULL DEFAULT '{}',
+  signals JSONB NOT NULL DEFAULT '{}'::jsonb,
+  action TEXT NOT NULL DEFAULT 'none', -- none | limit | captcha
+  captcha_required BOOLEAN NOT NULL DEFAULT false,
+  captcha_passed_at TIMESTAMPTZ,
+  status TEXT NOT NULL DEFAULT 'open', -- open | cleared | confirmed
+  reviewed_by BIGINT,
+  reviewed_at TIMESTAMPTZ,
+  scored_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS bot_scores_review_idx ON bot_scores(status, score DESC);
+
+-- Affiliates: campaign links, attributed players, monthly revenue-share statements
+CREATE TABLE IF NOT EXISTS affiliates (
+  user_id BIGINT PRIMARY KEY REFERENCES users(user_id),
+  share_bp BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'active', -- active | suspended
+  balance BIGINT NOT NULL DEFAULT 0, -- commission not yet paid out
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS affiliate_campaigns (
+  campaign_id BIGSERIAL PRIMARY KEY,
+  affiliate_id BIGINT NOT NULL REFERENCES affiliates(user_id),
+  code TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS affiliate_campaigns_affiliate_idx ON affiliate_campaigns(affiliate_id);
+CREATE TABLE IF NOT EXISTS affiliate_referrals (
+  referred_id BIGINT PRIMARY KEY REFERENCES users(user_id),
+  affiliate_id BIGINT NOT NULL REFERENCES affiliates(user_id),
+  campaign_id BIGINT NOT NULL REFERENCES affiliate_campaigns(campaign_id),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS affiliate_referrals_affiliate_idx ON affiliate_referrals(affiliate_id);
+CREATE INDEX IF NOT EXISTS affiliate_referrals_campaign_idx ON affiliate_referrals(campaign_id);
+CREATE TABLE IF NOT EXISTS affiliate_months (
+  month DATE PRIMARY KEY,
+  statements BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS affiliate_statements (
+  affiliate_id BIGINT NOT NULL REFERENCES affiliates(user_id),
+  month DATE NOT NULL,
+  players BIGINT NOT NULL,
+  game_rake BIGINT NOT NULL,
+  fees BIGINT NOT NULL,
+  share_bp BIGINT NOT NULL,
+  commission BIGINT NOT NULL,
+  campaigns JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (affiliate_id, month)
+);
+
+-- Bonus promos: deposit-match and free-bet campaigns, per-user bonus balances with wagering
+CREATE TABLE IF NOT EXISTS bonus_campaigns (
+  campaign_id BIGSERIAL PRIMARY KEY,
+  code TEXT NOT NULL UNIQUE,
+  kind TEXT NOT NULL, -- deposit_match | free_bet
+  match_bp BIGINT NOT NULL DEFAULT 0,
+  max_bonus BIGINT NOT NULL DEFAULT 0,
+  min_deposit BIGINT NOT NULL DEFAULT 0,
+  free_bet BIGINT NOT NULL DEFAULT 0,
+  wager_x BIGINT NOT NULL,
+  min_odds DOUBLE PRECISION NOT NULL DEFAULT 1,
+  max_bet BIGINT NOT NULL DEFAULT 0,
+  valid_days BIGINT NOT NULL,
+  max_claims BIGINT NOT NULL DEFAULT 0,
+  claims BIGINT NOT NULL DEFAULT 0,
+  active BOOLEAN NOT NULL DEFAULT true,
+  starts_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ends_at TIMESTAMPTZ,
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS user_bonuses (
+  bonus_id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(user_id),
+  campaign_id BIGINT NOT NULL REFERENCES bonus_campaigns(campaign_id),
+  kind TEXT NOT NULL,
+  granted BIGINT NOT NULL DEFAULT 0,
+  balance BIGINT NOT NULL DEFAULT 0, -- bonus coins, spendable only on bets
+  free_bet BIGINT NOT NULL DEFAULT 0, -- unused free bet stake
+  wager_required BIGINT NOT NULL DEFAULT 0,
+  wagered BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL, -- pending | active | review | completed | lost | expired | forfeited
+  flags TEXT[] NOT NULL DEFAULT '{}',
+  claim_ip TEXT NOT NULL DEFAULT '',
+  deposit_ref TEXT,
+  expires_at TIMESTAMPTZ NOT NULL,
+  reviewed_by BIGINT,
+  closed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (user_id, campaign_id)
+);
+CREATE INDEX IF NOT EXISTS user_bonuses_open_idx ON user_bonuses(user_id, created_at) WHERE status IN ('pending','active','review');
+CREATE INDEX IF NOT EXISTS user_bonuses_status_idx ON user_bonuses(status, expires_at);
+CREATE INDEX IF NOT EXISTS user_bonuses_claim_ip_idx ON user_bonuses(campaign_id, claim_ip);
+CREATE TABLE IF NOT EXISTS bonus_bets (
+  bet_ref TEXT PRIMARY KEY,
+  bonus_id BIGINT NOT NULL REFERENCES user_bonuses(bonus_id),
+  user_id BIGINT NOT NULL,
+  stake BIGINT NOT NULL,
+  bonus_stake BIGINT NOT NULL,
+  free_bet BOOLEAN NOT NULL DEFAULT false,
+  settled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS bonus_bets_open_idx ON bonus_bets(bonus_id) WHERE settled_at IS NULL;
+
+-- Game credits: a separate unit bought and sold for BKC at admin-set rates, staked only in games
+ALTER TABLE users ADD COLUMN IF NOT EXISTS game_credits BIGINT NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS game_credit_rates (
+  id INT PRIMARY KEY CHECK (id = 1),
+  buy_rate BIGINT NOT NULL, -- credits per BKC paid
+  sell_rate BIGINT NOT NULL, -- credits per BKC received
+  sell_daily_limit BIGINT NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT true,
+  updated_by BIGINT,
+  updated_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS credit_bets (
+  bet_ref TEXT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  stake BIGINT NOT NULL,
+  settled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Gifts: an amount locked by the sender until claimed with a one-time code or refunded on expiry
+CREATE TABLE IF NOT EXISTS gifts (
+  gift_id BIGSERIAL PRIMARY KEY,
+  code_hash TEXT NOT NULL UNIQUE, -- sha256
+  sender_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  message TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'pending', -- pending | claimed | refunded
+  claimed_by BIGINT,
+  expires_at TIMESTAMPTZ NOT NULL,
+  closed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS gifts_sender_idx ON gifts(sender_id, gift_id DESC);
+CREATE INDEX IF NOT EXISTS gifts_claimed_by_idx ON gifts(claimed_by, gift_id DESC) WHERE claimed_by IS NOT NULL;
+CREATE INDEX IF NOT EXISTS gifts_pending_idx ON gifts(expires_at) WHERE status = 'pending';
+
+-- Shared bills: shares paid by participants are held until the bill is funded (paid to the payee) or refunded at the deadline
+CREATE TABLE IF NOT EXISTS bills (
+  bill_id BIGSERIAL PRIMARY KEY,
+  creator_id BIGINT NOT NULL,
+  payee_id BIGINT NOT NULL,
+  title TEXT NOT NULL,
+  total BIGINT NOT NULL CHECK (total > 0),
+  funded BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'open', -- open | completed | refunded
+  deadline TIMESTAMPTZ NOT NULL,
+  closed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS bills_creator_idx ON bills(creator_id, bill_id DESC);
+CREATE INDEX IF NOT EXISTS bills_payee_idx ON bills(payee_id, bill_id DESC);
+CREATE INDEX IF NOT EXISTS bills_open_idx ON bills(deadline) WHERE status = 'open';
+CREATE TABLE IF NOT EXISTS bill_shares (
+  bill_id BIGINT NOT NULL REFERENCES bills(bill_id),
+  user_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  paid_at TIMESTAMPTZ,
+  PRIMARY KEY (bill_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS bill_shares_user_idx ON bill_shares(user_id);
+
+-- Merchants: registered shops taking BKC payments; orders holds their payment requests (order_type 'merchant')
+CREATE TABLE IF NOT EXISTS merchants (
+  user_id BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  fee_bp BIGINT NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'active', -- active | suspended
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS orders (
+  order_id BIGSERIAL PRIMARY KEY,
+  order_type TEXT NOT NULL, -- merchant
+  code TEXT NOT NULL UNIQUE,
+  merchant_id BIGINT NOT NULL,
+  payer_id BIGINT,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  fee BIGINT NOT NULL DEFAULT 0,
+  description TEXT NOT NULL DEFAULT '',
+  external_ref TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'pending', -- pending | paid | cancelled | expired
+  expires_at TIMESTAMPTZ NOT NULL,
+  paid_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS orders_merchant_idx ON orders(merchant_id, order_id DESC);
+CREATE INDEX IF NOT EXISTS orders_paid_idx ON orders(paid_at) WHERE status = 'paid';
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders(expires_at) WHERE status = 'pending';
+CREATE TABLE IF NOT EXISTS merchant_reports (
+  merchant_id BIGINT NOT NULL,
+  day DATE NOT NULL,
+  orders BIGINT NOT NULL,
+  gross BIGINT NOT NULL,
+  fees BIGINT NOT NULL,
+  net BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (merchant_id, day)
+);
+
+-- Tips: every tip is kept here; the ledger gets one 'tip' row per sender and recipient per booking run
+CREATE TABLE IF NOT EXISTS recurring_tips (
+  recurring_id BIGSERIAL PRIMARY KEY,
+  from_id BIGINT NOT NULL,
+  to_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  period TEXT NOT NULL, -- daily | weekly | monthly
+  next_at TIMESTAMPTZ NOT NULL,
+  failures BIGINT NOT NULL DEFAULT 0,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS recurring_tips_due_idx ON recurring_tips(next_at) WHERE active;
+CREATE INDEX IF NOT EXISTS recurring_tips_from_idx ON recurring_tips(from_id);
+CREATE TABLE IF NOT EXISTS tips (
+  tip_id BIGSERIAL PRIMARY KEY,
+  from_id BIGINT NOT NULL,
+  to_id BIGINT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  fee BIGINT NOT NULL DEFAULT 0,
+  message TEXT NOT NULL DEFAULT '',
+  recurring_id BIGINT REFERENCES recurring_tips(recurring_id),
+  ledger_id BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS tips_unbooked_idx ON tips(tip_id) WHERE ledger_id IS NULL;
+CREATE INDEX IF NOT EXISTS tips_from_idx ON tips(from_id, tip_id DESC);
+CREATE INDEX IF NOT EXISTS tips_to_idx ON tips(to_id, created_at);
+
+-- Creator profiles: public tip pages with links and a tip goal counted from goal_started_at
+CREATE TABLE IF NOT EXISTS creator_profiles (
+  user_id BIGINT PRIMARY KEY,
+  display_name TEXT NOT NULL,
+  bio TEXT NOT NULL DEFAULT '',
+  links JSONB NOT NULL DEFAULT '[]'::jsonb,
+  goal_title TEXT NOT NULL DEFAULT '',
+  goal_amount BIGINT NOT NULL DEFAULT 0,
+  goal_started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Disputes: escrow conflicts settled by an admin; one open dispute per escrow
+CREATE TABLE IF NOT EXISTS disputes (
+  dispute_id BIGSERIAL PRIMARY KEY,
+  kind TEXT NOT NULL,
+  ref_id BIGINT NOT NULL,
+  opened_by BIGINT NOT NULL,
+  reason TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open',
+  resolution TEXT,
+  seller_bp BIGINT NOT NULL DEFAULT 0,
+  note TEXT NOT NULL DEFAULT '',
+  resolved_by BIGINT,
+  resolved_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS disputes_open_ref_uidx ON disputes(kind, ref_id) WHERE status = 'open';
+
+-- Gigs: services listings hired on milestone contracts with escrowed funding
+CREATE TABLE IF NOT EXISTS gig_contracts (
+  contract_id BIGSERIAL PRIMARY KEY,
+  listing_id BIGINT NOT NULL REFERENCES market_listings(listing_id),
+  buyer_id BIGINT NOT NULL,
+  seller_id BIGINT NOT NULL,
+  title TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS gig_contracts_buyer_idx ON gig_contracts(buyer_id, contract_id DESC);
+CREATE INDEX IF NOT EXISTS gig_contracts_seller_idx ON gig_contracts(seller_id, contract_id DESC);
+CREATE TABLE IF NOT EXISTS gig_milestones (
+  milestone_id BIGSERIAL PRIMARY KEY,
+  contract_id BIGINT NOT NULL REFERENCES gig_contracts(contract_id),
+  seq INT NOT NULL,
+  title TEXT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  deadline TIMESTAMPTZ NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  funded_at TIMESTAMPTZ,
+  delivered_at TIMESTAMPTZ,
+  closed_at TIMESTAMPTZ,
+  UNIQUE (contract_id, seq)
+);
+CREATE INDEX IF NOT EXISTS gig_milestones_open_idx ON gig_milestones(deadline) WHERE status IN ('pending','funded','delivered');
+
+-- Listing moderation: market listings start pending; screening flags and moderator decisions
+CREATE TABLE IF NOT EXISTS listing_moderation (
+  listing_id BIGINT PRIMARY KEY REFERENCES market_listings(listing_id) ON DELETE CASCADE,
+  flags TEXT[] NOT NULL DEFAULT '{}',
+  details TEXT[] NOT NULL DEFAULT '{}',
+  nudity_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+  price_median BIGINT NOT NULL DEFAULT 0,
+  screened_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  decision TEXT, -- approved|rejected
+  reason TEXT NOT NULL DEFAULT '',
+  moderator_id BIGINT,
+  decided_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS listing_moderation_rejected_idx ON listing_moderation(decided_at) WHERE decision = 'rejected';
+
+-- Market categories: admin-managed tree with localized names, fee overrides and required listing attributes
+CREATE TABLE IF NOT EXISTS market_categories (
+  slug TEXT PRIMARY KEY,
+  parent_slug TEXT REFERENCES market_categories(slug),
+  names JSONB NOT NULL DEFAULT '{}'::jsonb, -- lang -> name
+  listing_fee BIGINT,                       -- NULL = MARKET_LISTING_FEE_COINS
+  required_attrs TEXT[] NOT NULL DEFAULT '{}',
+  sort_order BIGINT NOT NULL DEFAULT 0,
+  active BOOLEAN NOT NULL DEFAULT true,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS market_categories_parent_idx ON market_categories(parent_slug);
+INSERT INTO market_categories (slug, names, sort_order) VALUES
+  ('services', '{"ru": "Услуги", "en": "Services"}', 10),
+  ('exchange', '{"ru": "Обмен", "en": "Exchange"}', 20),
+  ('fiat', '{"ru": "За фиат", "en": "For fiat"}', 30),
+  ('other', '{"ru": "Другое", "en": "Other"}', 1000)
+ON CONFLICT (slug) DO NOTHING;
+ALTER TABLE market_listings ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX IF NOT EXISTS market_listings_category_idx ON market_listings(category, status);
+
+-- Price suggestions: recent sold prices per category (NFT sales use nft_sales_nft_idx)
+CREATE INDEX IF NOT EXISTS market_listings_sold_idx ON market_listings(category, sold_at DESC) WHERE status = 'sold';
+
+-- Bundles: several NFTs and market items sold together at one price
+CREATE TABLE IF NOT EXISTS bundle_listings (
+  bundle_id BIGSERIAL PRIMARY KEY,
+  seller_id BIGINT NOT NULL,
+  title TEXT NOT NULL,
+  price_coins BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'active', -- active|sold|cancelled
+  buyer_id BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  sold_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS bundle_listings_status_idx ON bundle_listings(status, bundle_id DESC);
+
+CREATE TABLE IF NOT EXISTS bundle_items (
+  bundle_id BIGINT NOT NULL REFERENCES bundle_listings(bundle_id),
+  seq INT NOT NULL,
+  kind TEXT NOT NULL, -- nft|item
+  nft_id BIGINT,
+  qty BIGINT NOT NULL DEFAULT 0,
+  listing_id BIGINT, -- market_listings, status 'bundled' while listed
+  title TEXT NOT NULL,
+  share BIGINT NOT NULL, -- part of the bundle price
+  PRIMARY KEY (bundle_id, seq)
+);
+
+-- NFT rentals: the renter gets the NFT's effects for a paid period
+CREATE TABLE IF NOT EXISTS nft_rental_offers (
+  offer_id BIGSERIAL PRIMARY KEY,
+  owner_id BIGINT NOT NULL,
+  nft_id BIGINT NOT NULL,
+  fee_per_day BIGINT NOT NULL,
+  min_days BIGINT NOT NULL DEFAULT 1,
+  max_days BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'active', -- active|cancelled; one listed copy while active
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS nft_rental_offers_nft_idx ON nft_rental_offers(nft_id, fee_per_day) WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS nft_rentals (
+  rental_id BIGSERIAL PRIMARY KEY,
+  offer_id BIGINT NOT NULL REFERENCES nft_rental_offers(offer_id),
+  owner_id BIGINT NOT NULL,
+  renter_id BIGINT NOT NULL,
+  nft_id BIGINT NOT NULL,
+  days BIGINT NOT NULL,
+  paid BIGINT NOT NULL,
+  fee BIGINT NOT NULL,
+  owner_income BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'active', -- active|returned
+  starts_at TIMESTAMPTZ NOT NULL,
+  ends_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS nft_rentals_active_idx ON nft_rentals(ends_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS nft_rentals_renter_idx ON nft_rentals(renter_id, nft_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS nft_rentals_owner_idx ON nft_rentals(owner_id, starts_at DESC);
+
+-- NFT wishlist: demand signal and restock/new drop notifications
+CREATE TABLE IF NOT EXISTS nft_wishes (
+  user_id BIGINT NOT NULL,
+  nft_id BIGINT NOT NULL,
+  last_supply_left BIGINT NOT NULL DEFAULT 0,
+  seen_nft_id BIGINT NOT NULL DEFAULT 0, -- newest NFT of the collection already reported
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, nft_id)
+);
+CREATE INDEX IF NOT EXISTS nft_wishes_nft_idx ON nft_wishes(nft_id, created_at);
+
+-- NFT drops: scheduled shop sales with allowlists, per-user caps and a FIFO order queue
+CREATE TABLE IF NOT EXISTS nft_drops (
+  drop_id BIGSERIAL PRIMARY KEY,
+  nft_id BIGINT NOT NULL,
+  starts_at TIMESTAMPTZ NOT NULL,
+  ends_at TIMESTAMPTZ,
+  per_user_cap BIGINT NOT NULL DEFAULT 0, -- 0 = no cap
+  allow_plan TEXT NOT NULL DEFAULT '', -- user_plans.plan allowed in; '' = none
+  allowlist BOOLEAN NOT NULL DEFAULT false, -- nft_drop_allowlist applies
+  closed BOOLEAN NOT NULL DEFAULT false, -- sold out, ended or cancelled
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS nft_drops_open_idx ON nft_drops(nft_id) WHERE NOT closed;
+
+CREATE TABLE IF NOT EXISTS nft_drop_allowlist (
+  drop_id BIGINT NOT NULL REFERENCES nft_drops(drop_id),
+  user_id BIGINT NOT NULL,
+  PRIMARY KEY (drop_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS nft_drop_orders (
+  order_id BIGSERIAL PRIMARY KEY,
+  drop_id BIGINT NOT NULL REFERENCES nft_drops(drop_id),
+  user_id BIGINT NOT NULL,
+  qty BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'queued', -- queued|filled|rejected
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  processed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS nft_drop_orders_queue_idx ON nft_drop_orders(drop_id, order_id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS nft_drop_orders_user_idx ON nft_drop_orders(drop_id, user_id);
+
+-- Sharded shop supply for contended NFTs; nfts.supply_left mirrors the shard sum (nft_supply_sync)
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS supply_shards BIGINT NOT NULL DEFAULT 0; -- 0 = not sharded
+CREATE TABLE IF NOT EXISTS nft_supply_shards (
+  nft_id BIGINT NOT NULL,
+  shard INT NOT NULL,
+  qty_left BIGINT NOT NULL CHECK (qty_left >= 0),
+  proceeds BIGINT NOT NULL DEFAULT 0, -- sale coins not yet moved to the reserve
+  PRIMARY KEY (nft_id, shard)
+);
+
+-- Checkout holds on single-copy NFT listings; a hold is over once reserved_until passes
+ALTER TABLE nft_listings ADD COLUMN IF NOT EXISTS reserved_by BIGINT;
+ALTER TABLE nft_listings ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS nft_listings_reserved_idx ON nft_listings(reserved_by, reserved_until) WHERE reserved_by IS NOT NULL;
+
+-- Webapp analytics events, buffered until analytics_export copies them to ClickHouse
+CREATE TABLE IF NOT EXISTS client_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  session_id TEXT NOT NULL DEFAULT '',
+  type TEXT NOT NULL, -- screen_view|button_tap|funnel_step
+  name TEXT NOT NULL,
+  screen TEXT NOT NULL DEFAULT '',
+  props JSONB NOT NULL DEFAULT '{}'::jsonb,
+  client_ts TIMESTAMPTZ NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- A/B experiments; variants are assigned by hash, exposures logged on first use
+CREATE TABLE IF NOT EXISTS experiments (
+  key TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'draft', -- draft|running|stopped
+  variants JSONB NOT NULL,
+  guardrails JSONB NOT NULL DEFAULT '[]'::jsonb,
+  stop_reason TEXT NOT NULL DEFAULT '',
+  created_by BIGINT NOT NULL,
+  started_at TIMESTAMPTZ,
+  stopped_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS experiment_exposures (
+  experiment_key TEXT NOT NULL REFERENCES experiments(key),
+  user_id BIGINT NOT NULL,
+  variant TEXT NOT NULL,
+  first_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (experiment_key, user_id)
+);
+ALTER TABLE nft_drop_orders ADD COLUMN IF NOT EXISTS price_coins BIGINT NOT NULL DEFAULT 0; -- per copy; 0 = the NFT price
+
+-- Periodic Telegram digests (job digests); queued into notification_log without a ledger row
+CREATE TABLE IF NOT EXISTS digest_subscriptions (
+  user_id BIGINT PRIMARY KEY REFERENCES users(user_id),
+  sections TEXT[] NOT NULL,           -- earnings | loans | listings
+  period_days INT NOT NULL DEFAULT 1, -- 1 = daily, 7 = weekly
+  hour_utc INT NOT NULL DEFAULT 9,
+  next_at TIMESTAMPTZ NOT NULL,
+  covered_until TIMESTAMPTZ,          -- end of the last digest's window
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS digest_subscriptions_due_idx ON digest_subscriptions(next_at);
+ALTER TABLE notification_log ALTER COLUMN ledger_id DROP NOT NULL;
+
+-- Signup cohort retention (job retention, /api/v1/admin/analytics/retention)
+CREATE TABLE IF NOT EXISTS cohort_retention (
+  cohort_day DATE PRIMARY KEY, -- signup day, UTC
+  users BIGINT NOT NULL,
+  d1 BIGINT,                   -- users active on cohort_day + 1; NULL until that day is over
+  d7 BIGINT,
+  d30 BIGINT,
+  computed_at TIMESTAMPTZ NOT NULL
+);
+
+-- Admin overrides of fees and limits (ParamDefs); a key without a row uses the config default
+CREATE TABLE IF NOT EXISTS params (
+  key TEXT PRIMARY KEY,
+  value BIGINT NOT NULL,
+  updated_by BIGINT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Jobs that must run on one instance at a time: the holder renews the lease every run
+CREATE TABLE IF NOT EXISTS job_leases (
+  name TEXT PRIMARY KEY,
+  holder TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Where a batched job stopped; the next run resumes there
+CREATE TABLE IF NOT EXISTS job_checkpoints (
+  name TEXT PRIMARY KEY,
+  cursor JSONB NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
 `
 	_, err := d.Pool.Exec(ctx, sql)
 	return err
@@ -442,13 +1741,17 @@ SELECT user_id, COALESCE(username,''), COALESCE(first_name,''), balance, frozen_
 }
 
 func (d *DB) GetSystem(ctx context.Context) (SystemState, error) {
+	return getSystem(ctx, d.Pool)
+}
+
+func getSystem(ctx context.Context, q rowQuerier) (SystemState, error) {
 	var s SystemState
-	row := d.Pool.QueryRow(ctx, `
-SELECT total_supply, reserve_supply, reserved_supply, initial_reserve, admin_user_id, admin_allocated, start_rate_coins_usd, min_rate_coins_usd, referral_step, referral_bonus, created_at, updated_at
+	row := q.QueryRow(ctx, `
+SELECT total_supply, reserve_supply, reserved_supply, initial_reserve, admin_user_id, admin_allocated, start_rate_coins_usd, min_rate_coins_usd, referral_step, referral_bonus, daily_emission_cap, created_at, updated_at
 FROM system_state
 WHERE id=1
 `)
-	if err := row.Scan(&s.TotalSupply, &s.ReserveSupply, &s.ReservedSupply, &s.InitialReserve, &s.AdminUserID, &s.AdminAllocated, &s.StartRateCoinsUSD, &s.MinRateCoinsUSD, &s.ReferralStep, &s.ReferralBonus, &s.CreatedAt, &s.UpdatedAt); err != nil {
+	if err := row.Scan(&s.TotalSupply, &s.ReserveSupply, &s.ReservedSupply, &s.InitialReserve, &s.AdminUserID, &s.AdminAllocated, &s.StartRateCoinsUSD, &s.MinRateCoinsUSD, &s.ReferralStep, &s.ReferralBonus, &s.DailyEmissionCap, &s.CreatedAt, &s.UpdatedAt); err != nil {
 		return SystemState{}, err
 	}
 	return s, nil
@@ -463,51 +1766,106 @@ func (d *DB) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
 	if err := fn(tx); err != nil {
 		return err
 	}
-	return tx.Commit(ctx)
+	if err := faults.Inject(ctx, faults.DBCommit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	return faults.InjectAfter(ctx, faults.DBCommit)
 }
 
 var ErrNotEnough = errors.New("not enough")
 var ErrAlreadyExists = errors.New("already exists")
 var ErrForbidden = errors.New("forbidden")
-
-func (d *DB) ApplyTapEvents(ctx context.Context, events []TapEvent) error {
-	if len(events) == 0 {
-		return nil
-	}
-	ids := make([]string, 0, len(events))
-	uids := make([]int64, 0, len(events))
-	coins := make([]int64, 0, len(events))
-	taps := make([]int64, 0, len(events))
-	days := make([]string, 0, len(events))
-	reqs := make([]int64, 0, len(events))
+var ErrLocked = errors.New("locked")
+
+// tapCopyThreshold is the batch size from which ApplyTapEvents streams events
+// with COPY into a staging table instead of binding them as UNNEST arrays.
+var tapCopyThreshold = 2000
+
+// ApplyTapEvents persists queued tap events idempotently. It is also the
+// server-side backstop for daily limits: events past a user's quota (plan
+// limit from user_plans, else baseDailyLimit, plus extra_quota, against
+// user_daily) are truncated; baseDailyLimit 0 means unlimited.
+func (d *DB) ApplyTapEvents(ctx context.Context, events []TapEvent, baseDailyLimit int64) error {
+	clean := make([]TapEvent, 0, len(events))
 	for _, ev := range events {
 		if strings.TrimSpace(ev.EventID) == "" || ev.UserID <= 0 || ev.Coins <= 0 || ev.Taps <= 0 || strings.TrimSpace(ev.Day) == "" {
 			continue
 		}
-		ids = append(ids, strings.TrimSpace(ev.EventID))
-		uids = append(uids, ev.UserID)
-		coins = append(coins, ev.Coins)
-		taps = append(taps, ev.Taps)
-		days = append(days, strings.TrimSpace(ev.Day))
-		reqs = append(reqs, ev.Req)
+		ev.EventID = strings.TrimSpace(ev.EventID)
+		ev.Day = strings.TrimSpace(ev.Day)
+		clean = append(clean, ev)
 	}
-	if len(ids) == 0 {
+	if len(clean) == 0 {
 		return nil
 	}
+	if baseDailyLimit < 0 {
+		baseDailyLimit = 0
+	}
+	if len(clean) >= tapCopyThreshold {
+		return d.applyTapEventsCopy(ctx, clean, baseDailyLimit)
+	}
+	return d.applyTapEventsUnnest(ctx, clean, baseDailyLimit)
+}
 
-	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		// Insert into ledger with idempotency (event_id unique).
-		// Then apply aggregates to users + daily counters + reserve.
-		_, err := tx.Exec(ctx, `
+// tapMergeSQL inserts tap events from source into the ledger with idempotency
+// (event_id unique), then applies the newly inserted ones to users, daily
+// counters and the reserve. source yields (event_id, user_id, coins, taps, day, req);
+// limitParam, leftParam and dayParam are the placeholders of the base daily
+// limit, the emission still allowed today (-1 = no cap, see lockEmissionTx)
+// and the emission day.
+//
+// New events are charged against the daily quota in event_id order; the part
+// of an event past the quota is dropped and recorded as "truncated" in meta.
+// Events past the global emission cap mint nothing ("emission_capped").
+func tapMergeSQL(source, limitParam, leftParam, dayParam string) string {
+	return `
 WITH data AS (
-  SELECT * FROM UNNEST($1::text[], $2::bigint[], $3::bigint[], $4::bigint[], $5::text[], $6::bigint[])
-  AS t(event_id, user_id, coins, taps, day, req)
+  ` + source + `
+),
+fresh AS (
+  -- Replays and in-batch duplicates take no quota.
+  SELECT DISTINCT ON (d.event_id) d.event_id, d.user_id, d.coins, d.taps, d.day, d.req
+  FROM data d
+  WHERE NOT EXISTS (SELECT 1 FROM ledger l WHERE l.event_id = d.event_id)
+  ORDER BY d.event_id
+),
+quota AS (
+  SELECT f.*,
+         SUM(f.taps) OVER (PARTITION BY f.user_id, f.day ORDER BY f.event_id) - f.taps AS before_taps,
+         COALESCE(ud.tapped, 0) AS tapped,
+         COALESCE(ud.extra_quota, 0) AS extra,
+         COALESCE(up.daily_tap_limit, ` + limitParam + `::bigint) AS lim
+  FROM fresh f
+  LEFT JOIN user_daily ud ON ud.user_id = f.user_id AND ud.day = f.day::date
+  LEFT JOIN user_plans up ON up.user_id = f.user_id AND (up.expires_at IS NULL OR up.expires_at > now())
+),
+capped AS (
+  SELECT event_id, user_id, day, req, coins, taps AS req_taps,
+         CASE WHEN lim <= 0 THEN taps
+              ELSE GREATEST(0, LEAST(taps, lim + extra - tapped - before_taps)) END AS taps
+  FROM quota
+),
+emitted AS (
+  SELECT event_id, user_id, day, req, coins, req_taps,
+         CASE WHEN over_cap THEN 0 ELSE taps END AS taps,
+         over_cap AND taps > 0 AS emission_capped
+  FROM (
+    SELECT c.*,
+           ` + leftParam + `::bigint >= 0
+             AND SUM(c.coins * c.taps / c.req_taps) OVER (ORDER BY c.event_id) > ` + leftParam + `::bigint AS over_cap
+    FROM capped c
+  ) s
 ),
 ins AS (
   INSERT INTO ledger(event_id, kind, from_id, to_id, amount, meta)
-  SELECT event_id, 'tap', NULL, user_id, coins,
-         jsonb_build_object('taps', taps, 'req', req, 'day', day)
-  FROM data
+  SELECT event_id, 'tap', NULL, user_id, coins * taps / req_taps,
+         jsonb_build_object('taps', taps, 'req', req, 'day', day) ||
+           CASE WHEN taps < req_taps AND NOT emission_capped THEN jsonb_build_object('truncated', req_taps - taps) ELSE '{}'::jsonb END ||
+           CASE WHEN emission_capped THEN jsonb_build_object('emission_capped', true) ELSE '{}'::jsonb END
+  FROM emitted
   ON CONFLICT (event_id) DO NOTHING
   RETURNING to_id AS user_id, amount AS coins, (meta->>'taps')::bigint AS taps, (meta->>'day')::date AS day
 ),
@@ -537,12 +1895,86 @@ up_daily AS (
     SET tapped = user_daily.tapped + EXCLUDED.tapped,
         updated_at = now()
   RETURNING 1
+),
+up_emission AS (
+  UPDATE daily_emission
+  SET minted = minted + (SELECT COALESCE(SUM(coins),0) FROM ins),
+      updated_at = now()
+  WHERE day = ` + dayParam + `::date
+  RETURNING 1
 )
 UPDATE system_state
 SET reserve_supply = reserve_supply - (SELECT COALESCE(SUM(coins),0) FROM ins),
     updated_at = now()
 WHERE id=1
-`, ids, uids, coins, taps, days, reqs)
+`
+}
+
+func (d *DB) applyTapEventsUnnest(ctx context.Context, events []TapEvent, baseDailyLimit int64) error {
+	ids := make([]string, 0, len(events))
+	uids := make([]int64, 0, len(events))
+	coins := make([]int64, 0, len(events))
+	taps := make([]int64, 0, len(events))
+	days := make([]string, 0, len(events))
+	reqs := make([]int64, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.EventID)
+		uids = append(uids, ev.UserID)
+		coins = append(coins, ev.Coins)
+		taps = append(taps, ev.Taps)
+		days = append(days, ev.Day)
+		reqs = append(reqs, ev.Req)
+	}
+
+	return d.RetryTx(ctx, func(tx pgx.Tx) error {
+		em, err := lockEmissionTx(ctx, tx, emissionDay(time.Now()))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, tapMergeSQL(`SELECT * FROM UNNEST($1::text[], $2::bigint[], $3::bigint[], $4::bigint[], $5::text[], $6::bigint[])
+  AS t(event_id, user_id, coins, taps, day, req)`, "$7", "$8", "$9"), ids, uids, coins, taps, days, reqs, baseDailyLimit, em.Remaining(), em.Day)
+		return err
+	})
+}
+
+// applyTapEventsCopy streams events into a per-connection temp table with
+// COPY and merges them with the same set-based statement as the UNNEST path.
+// Large batches avoid the cost of binding and unpacking huge array parameters.
+func (d *DB) applyTapEventsCopy(ctx context.Context, events []TapEvent, baseDailyLimit int64) error {
+	return d.RetryTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+CREATE TEMP TABLE IF NOT EXISTS tap_events_stage (
+  event_id TEXT NOT NULL,
+  user_id BIGINT NOT NULL,
+  coins BIGINT NOT NULL,
+  taps BIGINT NOT NULL,
+  day TEXT NOT NULL,
+  req BIGINT NOT NULL
+) ON COMMIT DELETE ROWS
+`); err != nil {
+			return err
+		}
+		i := 0
+		_, err := tx.CopyFrom(ctx,
+			pgx.Identifier{"tap_events_stage"},
+			[]string{"event_id", "user_id", "coins", "taps", "day", "req"},
+			pgx.CopyFromFunc(func() ([]any, error) {
+				if i >= len(events) {
+					return nil, nil
+				}
+				ev := events[i]
+				i++
+				return []any{ev.EventID, ev.UserID, ev.Coins, ev.Taps, ev.Day, ev.Req}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+		em, err := lockEmissionTx(ctx, tx, emissionDay(time.Now()))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, tapMergeSQL(`SELECT event_id, user_id, coins, taps, day, req FROM tap_events_stage`, "$1", "$2", "$3"), baseDailyLimit, em.Remaining(), em.Day)
 		return err
 	})
 }
@@ -553,7 +1985,54 @@ func (d *DB) ApplyTapAggregates(ctx context.Context, users []UserTapAggregate, d
 	if len(users) == 0 && len(daily) == 0 && reserveDelta == 0 {
 		return nil
 	}
+	return d.RetryTx(ctx, func(tx pgx.Tx) error {
+		return applyTapAggregatesTx(ctx, tx, users, daily, reserveDelta, source)
+	})
+}
 
+// ErrPartialReplay means some, but not all, of a batch's ids were already
+// applied: the deltas cannot be split, so the batch is refused.
+var ErrPartialReplay = errors.New("tap batch partially applied")
+
+// ApplyTapAggregatesOnce is ApplyTapAggregates guarded by ledger markers with
+// the given event_ids, for batches that may already have been applied (spill
+// files, WAL segments). applied is false when every id was seen before.
+func (d *DB) ApplyTapAggregatesOnce(ctx context.Context, batchIDs []string, users []UserTapAggregate, daily []DailyTapAggregate, reserveDelta int64, source string) (applied bool, err error) {
+	if len(batchIDs) == 0 {
+		return false, errors.New("bad batch id")
+	}
+	for _, id := range batchIDs {
+		if strings.TrimSpace(id) == "" {
+			return false, errors.New("bad batch id")
+		}
+	}
+	err = d.RetryTx(ctx, func(tx pgx.Tx) error {
+		applied = false
+		tag, err := tx.Exec(ctx, `
+INSERT INTO ledger(event_id, kind, from_id, to_id, amount, meta)
+SELECT id, 'tap_batch_replay', NULL, NULL, 0, $2::jsonb
+FROM UNNEST($1::text[]) AS t(id)
+ON CONFLICT (event_id) DO NOTHING
+`, batchIDs, toJSON(map[string]any{"source": source, "users": len(users), "daily": len(daily)}))
+		if err != nil {
+			return err
+		}
+		switch n := tag.RowsAffected(); {
+		case n == 0:
+			return nil
+		case n < int64(len(batchIDs)):
+			return ErrPartialReplay
+		}
+		applied = true
+		return applyTapAggregatesTx(ctx, tx, users, daily, reserveDelta, source)
+	})
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+func applyTapAggregatesTx(ctx context.Context, tx pgx.Tx, users []UserTapAggregate, daily []DailyTapAggregate, reserveDelta int64, source string) error {
 	userIDs := make([]int64, 0, len(users))
 	userBalance := make([]int64, 0, len(users))
 	userTaps := make([]int64, 0, len(users))
@@ -590,9 +2069,23 @@ func (d *DB) ApplyTapAggregates(ctx context.Context, users []UserTapAggregate, d
 		dailyTapped = append(dailyTapped, dly.TappedDelta)
 	}
 
-	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		if len(userIDs) > 0 {
-			_, err := tx.Exec(ctx, `
+	// The taps were accepted against a cached view of the cap while other
+	// mints kept counting against it: a batch that overshoots is cut down to
+	// what is left, as tapMergeSQL does, rather than refused and retried for
+	// the rest of the day. The coins not minted stay in the reserve.
+	minted, err := chargeEmissionUpToTx(ctx, tx, totalCoins)
+	if err != nil {
+		return err
+	}
+	capped := totalCoins - minted
+	if capped > 0 {
+		capTapCredits(userBalance, totalCoins, minted)
+		reserveDelta += capped
+		totalCoins = minted
+	}
+
+	if len(userIDs) > 0 {
+		_, err := tx.Exec(ctx, `
 WITH data AS (
   SELECT * FROM UNNEST($1::bigint[], $2::bigint[], $3::bigint[], $4::double precision[], $5::timestamptz[])
   AS t(user_id, balance_delta, taps_delta, energy, energy_updated_at)
@@ -605,13 +2098,13 @@ SET balance = users.balance + data.balance_delta,
 FROM data
 WHERE users.user_id = data.user_id
 `, userIDs, userBalance, userTaps, userEnergy, userEnergyAt)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
 		}
+	}
 
-		if len(dailyUserIDs) > 0 {
-			_, err := tx.Exec(ctx, `
+	if len(dailyUserIDs) > 0 {
+		_, err := tx.Exec(ctx, `
 WITH data AS (
   SELECT * FROM UNNEST($1::bigint[], $2::text[], $3::bigint[])
   AS t(user_id, day, tapped_delta)
@@ -623,40 +2116,40 @@ ON CONFLICT (user_id, day) DO UPDATE
 SET tapped = user_daily.tapped + EXCLUDED.tapped,
     updated_at = now()
 `, dailyUserIDs, dailyDays, dailyTapped)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
 		}
+	}
 
-		if reserveDelta != 0 {
-			_, err := tx.Exec(ctx, `
+	if reserveDelta != 0 {
+		_, err := tx.Exec(ctx, `
 UPDATE system_state
 SET reserve_supply = GREATEST(reserve_supply + $1, 0),
     updated_at = now()
 WHERE id=1
 `, reserveDelta)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
 		}
+	}
 
-		if totalCoins > 0 {
-			meta := toJSON(map[string]any{
-				"source": source,
-				"users":  len(userIDs),
-				"daily":  len(dailyUserIDs),
-			})
-			_, err := tx.Exec(ctx, `
+	if totalCoins > 0 || capped > 0 {
+		meta := toJSON(map[string]any{
+			"source": source,
+			"users":  len(userIDs),
+			"daily":  len(dailyUserIDs),
+			"capped": capped,
+		})
+		_, err := tx.Exec(ctx, `
 INSERT INTO ledger(kind, from_id, to_id, amount, meta)
 VALUES('tap_flush_batch', NULL, NULL, $1, $2::jsonb)
 `, totalCoins, meta)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
 func (d *DB) CreditFromReserve(ctx context.Context, userID int64, amount int64, kind string, meta any) error {
@@ -673,6 +2166,9 @@ func (d *DB) CreditFromReserve(ctx context.Context, userID int64, amount int64,
 		if available < amount {
 			return ErrNotEnough
 		}
+		if err := chargeEmissionTx(ctx, tx, amount); err != nil {
+			return err
+		}
 		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply - $1, updated_at=now() WHERE id=1`, amount); err != nil {
 			return err
 		}
@@ -689,14 +2185,7 @@ func (d *DB) DebitToReserve(ctx context.Context, userID int64, amount int64, kin
 		return nil
 	}
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		var bal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&bal); err != nil {
-			return err
-		}
-		if bal < amount {
-			return ErrNotEnough
-		}
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance - $1 WHERE user_id=$2`, amount, userID); err != nil {
+		if err := debitSpendableTx(ctx, tx, userID, amount); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at=now() WHERE id=1`, amount); err != nil {
@@ -707,24 +2196,21 @@ func (d *DB) DebitToReserve(ctx context.Context, userID int64, amount int64, kin
 	})
 }
 
-func (d *DB) Transfer(ctx context.Context, fromID, toID, amount int64) error {
+// Transfer moves coins between users. A transfer over a velocity limit in vp
+// fails with a *VelocityError and is logged to velocity_alerts.
+func (d *DB) Transfer(ctx context.Context, fromID, toID, amount int64, vp VelocityPolicy) error {
 	if amount <= 0 || fromID == toID {
 		return nil
 	}
-	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		var fromBal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, fromID).Scan(&fromBal); err != nil {
+	err := d.RetryTx(ctx, func(tx pgx.Tx) error {
+		// Ensure receiver exists; both rows locked in id order
+		if err := lockUsersTx(ctx, tx, fromID, toID); err != nil {
 			return err
 		}
-		if fromBal < amount {
-			return ErrNotEnough
-		}
-		// Ensure receiver exists and lock
-		var toBal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, toID).Scan(&toBal); err != nil {
+		if err := checkVelocityTx(ctx, tx, fromID, toID, amount, vp); err != nil {
 			return err
 		}
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance - $1 WHERE user_id=$2`, amount, fromID); err != nil {
+		if err := debitSpendableTx(ctx, tx, fromID, amount); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, amount, toID); err != nil {
@@ -733,6 +2219,13 @@ func (d *DB) Transfer(ctx context.Context, fromID, toID, amount int64) error {
 		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount) VALUES('transfer', $1, $2, $3)`, fromID, toID, amount)
 		return err
 	})
+	var ve *VelocityError
+	if errors.As(err, &ve) {
+		if aerr := d.recordVelocityAlert(ctx, fromID, toID, amount, ve); aerr != nil {
+			return aerr
+		}
+	}
+	return err
 }
 
 func toJSON(v any) string {
@@ -793,6 +2286,14 @@ func (d *DB) RegisterReferral(ctx context.Context, referrerID, referredID, step,
 		} else {
 			bonus = 0
 		}
+		// Past the daily emission cap the referral still counts, without the bonus.
+		if bonus > 0 {
+			if err := chargeEmissionTx(ctx, tx, bonus); errors.Is(err, ErrEmissionCap) {
+				bonus = 0
+			} else if err != nil {
+				return err
+			}
+		}
 
 		_, err := tx.Exec(ctx, `INSERT INTO referrals(referrer_id, referred_id, bonus) VALUES($1, $2, $3)`, referrerID, referredID, bonus)
 		if err != nil {
@@ -847,29 +2348,28 @@ func (d *DB) ListUserIDs(ctx context.Context) ([]int64, error) {
 	return ids, rows.Err()
 }
 
-func (d *DB) CreateCryptoPayInvoice(ctx context.Context, invoiceID, userID, amountUSD, coins int64, status string) error {
-	if invoiceID <= 0 || userID <= 0 || amountUSD <= 0 || coins <= 0 {
-		return errors.New("bad params")
+// CreateCryptoPayInvoice stores an invoice and reserves the coins it pays out,
+// priced on the rate curve (see QuoteCoins). Returns the reserved coins; for an
+// invoice that already exists, the coins stored with it.
+func (d *DB) CreateCryptoPayInvoice(ctx context.Context, invoiceID, userID, amountUSD int64, status string) (int64, error) {
+	if invoiceID <= 0 || userID <= 0 || amountUSD <= 0 {
+		return 0, errors.New("bad params")
 	}
 	status = strings.TrimSpace(status)
 	if status == "" {
 		status = "active"
 	}
 
-	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		var reserve int64
-		var reserved int64
-		if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+	var coins int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		if coins, err = issueCoinsTx(ctx, tx, float64(amountUSD)); err != nil {
 			return err
 		}
-		available := reserve - reserved
-		if available < coins {
-			return ErrNotEnough
-		}
 
 		// Insert invoice row once; reserve coins only if insertion succeeded.
 		var inserted int
-		err := tx.QueryRow(ctx, `
+		err = tx.QueryRow(ctx, `
 INSERT INTO cryptopay_invoices(invoice_id, user_id, amount_usd, coins, status)
 VALUES($1, $2, $3, $4, $5)
 ON CONFLICT (invoice_id) DO NOTHING
@@ -878,7 +2378,7 @@ RETURNING 1
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				// Already exists, nothing to do.
-				return nil
+				return tx.QueryRow(ctx, `SELECT coins FROM cryptopay_invoices WHERE invoice_id=$1`, invoiceID).Scan(&coins)
 			}
 			return err
 		}
@@ -893,6 +2393,10 @@ RETURNING 1
 		)
 		return err
 	})
+	if err != nil {
+		return 0, err
+	}
+	return coins, nil
 }
 
 func (d *DB) GetCryptoPayInvoice(ctx context.Context, invoiceID int64) (CryptoPayInvoice, error) {
@@ -984,10 +2488,12 @@ FOR UPDATE
 				return err
 			}
 			credited = coins
-			_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('cryptopay_deposit', NULL, $1, $2, $3::jsonb)`,
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('cryptopay_deposit', NULL, $1, $2, $3::jsonb)`,
 				userID, coins, toJSON(map[string]any{"invoice_id": invoiceID}),
-			)
-			return err
+			); err != nil {
+				return err
+			}
+			return grantDepositMatchTx(ctx, tx, userID, "invoice:"+strconv.FormatInt(invoiceID, 10), coins)
 		}
 
 		// Release reservation once on expiry/cancel if not credited.
@@ -1018,6 +2524,8 @@ FOR UPDATE
 		return nil
 	})
 	if err != nil {
+		// Counted by the payment_failures admin alert; the caller still sees err.
+		_ = d.RecordPaymentFailure(ctx, "cryptopay", strconv.FormatInt(invoiceID, 10), err)
 		return 0, "", err
 	}
 	return credited, finalStatus, nil
@@ -1025,7 +2533,7 @@ FOR UPDATE
 
 func (d *DB) ListNFTs(ctx context.Context) ([]NFT, error) {
 	rows, err := d.Pool.Query(ctx, `
-SELECT nft_id, title, image_url, price_coins, supply_left, created_at
+SELECT nft_id, title, image_url, price_coins, supply_left, created_at, collection, rarity_score, rarity_rank, rarity_tier
 FROM nfts
 ORDER BY nft_id DESC
 LIMIT 200
@@ -1037,7 +2545,7 @@ LIMIT 200
 	var items []NFT
 	for rows.Next() {
 		var n NFT
-		if err := rows.Scan(&n.NFTID, &n.Title, &n.ImageURL, &n.PriceCoins, &n.SupplyLeft, &n.CreatedAt); err != nil {
+		if err := rows.Scan(&n.NFTID, &n.Title, &n.ImageURL, &n.PriceCoins, &n.SupplyLeft, &n.CreatedAt, &n.Collection, &n.RarityScore, &n.RarityRank, &n.RarityTier); err != nil {
 			return nil, err
 		}
 		items = append(items, n)
@@ -1045,9 +2553,34 @@ LIMIT 200
 	return items, rows.Err()
 }
 
+func (d *DB) GetNFT(ctx context.Context, nftID int64) (NFT, error) {
+	var n NFT
+	row := d.Pool.QueryRow(ctx, `
+SELECT nft_id, title, image_url, price_coins, supply_left, created_at, collection, rarity_score, rarity_rank, rarity_tier, effects,
+       (SELECT COUNT(*) FROM nft_wishes w WHERE w.nft_id = nfts.nft_id)
+FROM nfts
+WHERE nft_id=$1
+`, nftID)
+	var effects []byte
+	if err := row.Scan(&n.NFTID, &n.Title, &n.ImageURL, &n.PriceCoins, &n.SupplyLeft, &n.CreatedAt, &n.Collection, &n.RarityScore, &n.RarityRank, &n.RarityTier, &effects, &n.Wishes); err != nil {
+		return NFT{}, err
+	}
+	eff, err := parseNFTEffects(effects)
+	if err != nil {
+		return NFT{}, err
+	}
+	n.Effects = eff
+	attrs, err := d.GetNFTAttributes(ctx, nftID)
+	if err != nil {
+		return NFT{}, err
+	}
+	n.Attributes = attrs
+	return n, nil
+}
+
 func (d *DB) ListUserNFTs(ctx context.Context, userID int64) ([]UserNFT, error) {
 	rows, err := d.Pool.Query(ctx, `
-SELECT o.nft_id, n.title, n.image_url, o.qty
+SELECT o.nft_id, n.title, n.image_url, o.qty, o.staked_qty, n.rarity_tier
 FROM nft_owns o
 JOIN nfts n ON n.nft_id = o.nft_id
 WHERE o.user_id=$1 AND o.qty > 0
@@ -1060,7 +2593,7 @@ ORDER BY o.qty DESC, o.nft_id DESC
 	var out []UserNFT
 	for rows.Next() {
 		var u UserNFT
-		if err := rows.Scan(&u.NFTID, &u.Title, &u.ImageURL, &u.Qty); err != nil {
+		if err := rows.Scan(&u.NFTID, &u.Title, &u.ImageURL, &u.Qty, &u.Staked, &u.RarityTier); err != nil {
 			return nil, err
 		}
 		out = append(out, u)
@@ -1095,34 +2628,21 @@ func (d *DB) BuyNFT(ctx context.Context, buyerID, nftID int64) error {
 		return errors.New("bad params")
 	}
 
-	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		var price int64
-		var left int64
-		if err := tx.QueryRow(ctx, `SELECT price_coins, supply_left FROM nfts WHERE nft_id=$1 FOR UPDATE`, nftID).Scan(&price, &left); err != nil {
+	return d.RetryTx(ctx, func(tx pgx.Tx) error {
+		// No lock on the nfts row: takeNFTSupplyTx takes the copy (see nft_supply.go).
+		var price, shards int64
+		if err := tx.QueryRow(ctx, `SELECT price_coins, supply_shards FROM nfts WHERE nft_id=$1`, nftID).Scan(&price, &shards); err != nil {
 			return err
 		}
-		if left <= 0 {
-			return ErrNotEnough
-		}
-
-		var bal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, buyerID).Scan(&bal); err != nil {
+		if err := dropOnlyTx(ctx, tx, nftID, time.Now().UTC()); err != nil {
 			return err
 		}
-		if bal < price {
-			return ErrNotEnough
-		}
 
 		// Debit buyer -> reserve
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance - $1 WHERE user_id=$2`, price, buyerID); err != nil {
-			return err
-		}
-		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at=now() WHERE id=1`, price); err != nil {
+		if err := debitSpendableTx(ctx, tx, buyerID, price); err != nil {
 			return err
 		}
-
-		// Decrement supply
-		if _, err := tx.Exec(ctx, `UPDATE nfts SET supply_left = supply_left - 1 WHERE nft_id=$1`, nftID); err != nil {
+		if err := takeNFTSupplyTx(ctx, tx, nftID, 1, price, shards); err != nil {
 			return err
 		}
 
@@ -1141,24 +2661,21 @@ ON CONFLICT (user_id, nft_id) DO UPDATE SET qty = nft_owns.qty + 1
 	})
 }
 
-func (d *DB) CreateDeposit(ctx context.Context, userID int64, txHash string, amountUSD int64, currency string, coins int64) (int64, error) {
+// CreateDeposit records a manual top-up and reserves its coins, priced on the
+// rate curve at creation time (see QuoteCoins). Returns the deposit id and coins.
+func (d *DB) CreateDeposit(ctx context.Context, userID int64, txHash string, amountUSD int64, currency string) (int64, int64, error) {
 	txHash = strings.TrimSpace(txHash)
 	currency = strings.ToUpper(strings.TrimSpace(currency))
-	if userID <= 0 || txHash == "" || amountUSD <= 0 || coins <= 0 || currency == "" {
-		return 0, errors.New("bad params")
+	if userID <= 0 || txHash == "" || amountUSD <= 0 || currency == "" {
+		return 0, 0, errors.New("bad params")
 	}
 
-	var id int64
+	var id, coins int64
 	err := d.WithTx(ctx, func(tx pgx.Tx) error {
-		var reserve int64
-		var reserved int64
-		if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+		var err error
+		if coins, err = issueCoinsTx(ctx, tx, float64(amountUSD)); err != nil {
 			return err
 		}
-		available := reserve - reserved
-		if available < coins {
-			return ErrNotEnough
-		}
 
 		if err := tx.QueryRow(ctx, `
 INSERT INTO deposits(user_id, tx_hash, amount_usd, currency, coins, status)
@@ -1172,16 +2689,16 @@ RETURNING deposit_id
 			return err
 		}
 
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('deposit_create', $1, NULL, 0, $2::jsonb)`,
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('deposit_create', $1, NULL, 0, $2::jsonb)`,
 			userID,
 			toJSON(map[string]any{"deposit_id": id, "tx_hash": txHash, "usd": amountUSD, "currency": currency, "coins": coins}),
 		)
 		return err
 	})
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-	return id, nil
+	return id, coins, nil
 }
 
 func (d *DB) ListDeposits(ctx context.Context, status string, limit int64) ([]Deposit, error) {
@@ -1278,10 +2795,12 @@ FOR UPDATE
 			if _, err := tx.Exec(ctx, `UPDATE deposits SET status='approved', approved_at=$1, approved_by=$2 WHERE deposit_id=$3`, now, adminID, depositID); err != nil {
 				return err
 			}
-			_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('deposit_approve', NULL, $1, $2, $3::jsonb)`,
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('deposit_approve', NULL, $1, $2, $3::jsonb)`,
 				userID, coins, toJSON(map[string]any{"deposit_id": depositID, "by": adminID}),
-			)
-			return err
+			); err != nil {
+				return err
+			}
+			return grantDepositMatchTx(ctx, tx, userID, "deposit:"+strconv.FormatInt(depositID, 10), coins)
 		}
 
 		// reject -> release reserved
@@ -1319,16 +2838,12 @@ func (d *DB) Burn(ctx context.Context, userID int64, amount int64, kind string,
 	if amount <= 0 {
 		return nil
 	}
+	// Burn reports pick up ledger kinds ending in "burn".
+	if !strings.HasSuffix(kind, "burn") {
+		kind += "_burn"
+	}
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		// Lock user
-		var bal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&bal); err != nil {
-			return err
-		}
-		if bal < amount {
-			return ErrNotEnough
-		}
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, amount, userID); err != nil {
+		if err := debitSpendableTx(ctx, tx, userID, amount); err != nil {
 			return err
 		}
 		// Reduce total supply (burn)
@@ -1347,11 +2862,21 @@ func (d *DB) CreateBankLoan(ctx context.Context, userID int64, principal int64,
 	}
 	interest := interestFromBP(principal, interestBP)
 	totalDue := principal + interest
-	now := time.Now().UTC()
+	now := d.now()
 	dueAt := now.Add(time.Duration(termDays) * 24 * time.Hour)
 
 	var out BankLoan
 	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		// Lock user first: it serializes concurrent requests of the same user
+		// before the check below, and matches the user-then-system order of
+		// RepayBankLoan.
+		{
+			var tmp int
+			if err := tx.QueryRow(ctx, `SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&tmp); err != nil {
+				return err
+			}
+		}
+
 		// Only one active bank loan per user.
 		var exists bool
 		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM bank_loans WHERE user_id=$1 AND status='active')`, userID).Scan(&exists); err != nil {
@@ -1372,14 +2897,6 @@ func (d *DB) CreateBankLoan(ctx context.Context, userID int64, principal int64,
 			return ErrNotEnough
 		}
 
-		// Lock user.
-		{
-			var tmp int
-			if err := tx.QueryRow(ctx, `SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&tmp); err != nil {
-				return err
-			}
-		}
-
 		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply-$1, updated_at=now() WHERE id=1`, principal); err != nil {
 			return err
 		}
@@ -1460,21 +2977,13 @@ FOR UPDATE
 			return nil
 		}
 
-		// Lock user
-		var bal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&bal); err != nil {
-			return err
-		}
-		if bal < totalDue {
-			return ErrNotEnough
-		}
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, totalDue, userID); err != nil {
+		if err := debitSpendableTx(ctx, tx, userID, totalDue); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, totalDue); err != nil {
 			return err
 		}
-		now := time.Now().UTC()
+		now := d.now()
 		if _, err := tx.Exec(ctx, `UPDATE bank_loans SET status='repaid', closed_at=$1 WHERE loan_id=$2`, now, loanID); err != nil {
 			return err
 		}
@@ -1486,73 +2995,10 @@ FOR UPDATE
 }
 
 // MarkOverdueBankLoans marks all expired active loans as "overdue" and applies a penalty to user balance (can go negative).
+// It returns how many loans RunOverdueBankLoans marked.
 func (d *DB) MarkOverdueBankLoans(ctx context.Context, now time.Time) (int64, error) {
-	if now.IsZero() {
-		now = time.Now().UTC()
-	}
-	rows, err := d.Pool.Query(ctx, `
-SELECT loan_id
-FROM bank_loans
-WHERE status='active' AND due_at <= $1
-ORDER BY due_at ASC
-LIMIT 500
-`, now)
-	if err != nil {
-		return 0, err
-	}
-	defer rows.Close()
-
-	var ids []int64
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return 0, err
-		}
-		ids = append(ids, id)
-	}
-	if err := rows.Err(); err != nil {
-		return 0, err
-	}
-
-	var processed int64
-	for _, loanID := range ids {
-		err := d.WithTx(ctx, func(tx pgx.Tx) error {
-			var userID int64
-			var totalDue int64
-			var status string
-			if err := tx.QueryRow(ctx, `
-SELECT user_id, total_due, status
-FROM bank_loans
-WHERE loan_id=$1
-FOR UPDATE
-`, loanID).Scan(&userID, &totalDue, &status); err != nil {
-				return err
-			}
-			if strings.ToLower(strings.TrimSpace(status)) != "active" {
-				return nil
-			}
-
-			// Apply penalty (balance can go negative)
-			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, totalDue, userID); err != nil {
-				return err
-			}
-			// Return the debt to reserve to keep reserve accounting consistent even if user goes negative.
-			if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, totalDue); err != nil {
-				return err
-			}
-			if _, err := tx.Exec(ctx, `UPDATE bank_loans SET status='overdue', closed_at=$1 WHERE loan_id=$2`, now, loanID); err != nil {
-				return err
-			}
-			_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('bank_loan_overdue', $1, NULL, $2, $3::jsonb)`,
-				userID, totalDue, toJSON(map[string]any{"loan_id": loanID, "ts": now.Unix()}),
-			)
-			return err
-		})
-		if err == nil {
-			processed++
-		}
-	}
-	return processed, nil
+	run, err := d.RunOverdueBankLoans(ctx, now, 0)
+	return run.Processed, err
 }
 
 func (d *DB) CreateP2PLoanRequest(ctx context.Context, borrowerID, lenderID int64, principal int64, interestBP int64, termDays int64) (P2PLoan, error) {
@@ -1561,13 +3007,18 @@ func (d *DB) CreateP2PLoanRequest(ctx context.Context, borrowerID, lenderID int6
 	}
 	interest := interestFromBP(principal, interestBP)
 	totalDue := principal + interest
-	now := time.Now().UTC()
+	now := d.now()
 	var out P2PLoan
-	err := d.Pool.QueryRow(ctx, `
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `
 INSERT INTO p2p_loans (lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at)
 VALUES ($1,$2,$3,$4,$5,$6,$7,'requested',$8)
 RETURNING loan_id
-`, lenderID, borrowerID, principal, interest, totalDue, interestBP, termDays, now).Scan(&out.LoanID)
+`, lenderID, borrowerID, principal, interest, totalDue, interestBP, termDays, now).Scan(&out.LoanID); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, lenderID, "p2p_loan_requested", map[string]any{"loan_id": out.LoanID, "borrower_id": borrowerID, "principal": principal, "total_due": totalDue, "term_days": termDays})
+	})
 	if err != nil {
 		return P2PLoan{}, err
 	}
@@ -1588,7 +3039,7 @@ func (d *DB) ListIncomingP2PRequests(ctx context.Context, lenderID int64, limit
 		limit = 50
 	}
 	rows, err := d.Pool.Query(ctx, `
-SELECT loan_id, lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at, accepted_at, due_at, closed_at
+SELECT loan_id, lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at, accepted_at, due_at, closed_at, offer_id, collateral, premium, coverage_bp, insurance_paid
 FROM p2p_loans
 WHERE lender_id=$1 AND status='requested'
 ORDER BY created_at ASC
@@ -1601,7 +3052,7 @@ LIMIT $2
 	var out []P2PLoan
 	for rows.Next() {
 		var l P2PLoan
-		if err := rows.Scan(&l.LoanID, &l.LenderID, &l.BorrowerID, &l.Principal, &l.Interest, &l.TotalDue, &l.InterestBP, &l.TermDays, &l.Status, &l.CreatedAt, &l.AcceptedAt, &l.DueAt, &l.ClosedAt); err != nil {
+		if err := rows.Scan(&l.LoanID, &l.LenderID, &l.BorrowerID, &l.Principal, &l.Interest, &l.TotalDue, &l.InterestBP, &l.TermDays, &l.Status, &l.CreatedAt, &l.AcceptedAt, &l.DueAt, &l.ClosedAt, &l.OfferID, &l.Collateral, &l.Premium, &l.CoverageBP, &l.InsurancePaid); err != nil {
 			return nil, err
 		}
 		out = append(out, l)
@@ -1614,7 +3065,7 @@ func (d *DB) ListP2PLoansByUser(ctx context.Context, userID int64, limit int64)
 		limit = 50
 	}
 	rows, err := d.Pool.Query(ctx, `
-SELECT loan_id, lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at, accepted_at, due_at, closed_at
+SELECT loan_id, lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at, accepted_at, due_at, closed_at, offer_id, collateral, premium, coverage_bp, insurance_paid
 FROM p2p_loans
 WHERE lender_id=$1 OR borrower_id=$1
 ORDER BY created_at DESC
@@ -1627,7 +3078,7 @@ LIMIT $2
 	var out []P2PLoan
 	for rows.Next() {
 		var l P2PLoan
-		if err := rows.Scan(&l.LoanID, &l.LenderID, &l.BorrowerID, &l.Principal, &l.Interest, &l.TotalDue, &l.InterestBP, &l.TermDays, &l.Status, &l.CreatedAt, &l.AcceptedAt, &l.DueAt, &l.ClosedAt); err != nil {
+		if err := rows.Scan(&l.LoanID, &l.LenderID, &l.BorrowerID, &l.Principal, &l.Interest, &l.TotalDue, &l.InterestBP, &l.TermDays, &l.Status, &l.CreatedAt, &l.AcceptedAt, &l.DueAt, &l.ClosedAt, &l.OfferID, &l.Collateral, &l.Premium, &l.CoverageBP, &l.InsurancePaid); err != nil {
 			return nil, err
 		}
 		out = append(out, l)
@@ -1639,7 +3090,7 @@ func (d *DB) AcceptP2PLoan(ctx context.Context, lenderID int64, loanID int64) er
 	if lenderID <= 0 || loanID <= 0 {
 		return errors.New("bad params")
 	}
-	now := time.Now().UTC()
+	now := d.now()
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
 		var lender int64
 		var borrower int64
@@ -1663,22 +3114,10 @@ FOR UPDATE
 			return nil
 		}
 
-		// Lock balances
-		var lenderBal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, lenderID).Scan(&lenderBal); err != nil {
+		if err := lockUsersTx(ctx, tx, lenderID, borrower); err != nil {
 			return err
 		}
-		if lenderBal < principal {
-			return ErrNotEnough
-		}
-		{
-			var tmp int
-			if err := tx.QueryRow(ctx, `SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE`, borrower).Scan(&tmp); err != nil {
-				return err
-			}
-		}
-
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, principal, lenderID); err != nil {
+		if err := debitSpendableTx(ctx, tx, lenderID, principal); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, principal, borrower); err != nil {
@@ -1688,10 +3127,12 @@ FOR UPDATE
 		if _, err := tx.Exec(ctx, `UPDATE p2p_loans SET status='active', accepted_at=$1, due_at=$2 WHERE loan_id=$3`, now, dueAt, loanID); err != nil {
 			return err
 		}
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_issue', $1, $2, $3, $4::jsonb)`,
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_issue', $1, $2, $3, $4::jsonb)`,
 			lenderID, borrower, principal, toJSON(map[string]any{"loan_id": loanID, "total_due": totalDue, "interest": interest, "due_at": dueAt.Unix()}),
-		)
-		return err
+		); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, borrower, "p2p_loan_accepted", map[string]any{"loan_id": loanID, "lender_id": lenderID, "total_due": totalDue, "due_at": dueAt})
 	})
 }
 
@@ -1699,31 +3140,42 @@ func (d *DB) RejectP2PLoan(ctx context.Context, lenderID int64, loanID int64) er
 	if lenderID <= 0 || loanID <= 0 {
 		return errors.New("bad params")
 	}
-	now := time.Now().UTC()
-	_, err := d.Pool.Exec(ctx, `
+	now := d.now()
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var borrower int64
+		err := tx.QueryRow(ctx, `
 UPDATE p2p_loans
 SET status='rejected', closed_at=$1
 WHERE loan_id=$2 AND lender_id=$3 AND status='requested'
-`, now, loanID, lenderID)
-	return err
+RETURNING borrower_id
+`, now, loanID, lenderID).Scan(&borrower)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, borrower, "p2p_loan_rejected", map[string]any{"loan_id": loanID, "lender_id": lenderID})
+	})
 }
 
 func (d *DB) RepayP2PLoan(ctx context.Context, borrowerID int64, loanID int64) error {
 	if borrowerID <= 0 || loanID <= 0 {
 		return errors.New("bad params")
 	}
-	now := time.Now().UTC()
+	now := d.now()
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
 		var lender int64
 		var borrower int64
 		var totalDue int64
+		var collateral int64
 		var status string
 		if err := tx.QueryRow(ctx, `
-SELECT lender_id, borrower_id, total_due, status
+SELECT lender_id, borrower_id, total_due, collateral, status
 FROM p2p_loans
 WHERE loan_id=$1
 FOR UPDATE
-`, loanID).Scan(&lender, &borrower, &totalDue, &status); err != nil {
+`, loanID).Scan(&lender, &borrower, &totalDue, &collateral, &status); err != nil {
 			return err
 		}
 		if borrower != borrowerID {
@@ -1733,21 +3185,13 @@ FOR UPDATE
 			return nil
 		}
 
-		var bal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, borrowerID).Scan(&bal); err != nil {
+		if err := lockUsersTx(ctx, tx, borrowerID, lender); err != nil {
 			return err
 		}
-		if bal < totalDue {
-			return ErrNotEnough
-		}
-		{
-			var tmp int
-			if err := tx.QueryRow(ctx, `SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE`, lender).Scan(&tmp); err != nil {
-				return err
-			}
+		if err := releaseLoanCollateralTx(ctx, tx, loanID, borrowerID, collateral); err != nil {
+			return err
 		}
-
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, totalDue, borrowerID); err != nil {
+		if err := debitSpendableTx(ctx, tx, borrowerID, totalDue); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, totalDue, lender); err != nil {
@@ -1756,10 +3200,12 @@ FOR UPDATE
 		if _, err := tx.Exec(ctx, `UPDATE p2p_loans SET status='repaid', closed_at=$1 WHERE loan_id=$2`, now, loanID); err != nil {
 			return err
 		}
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_repay', $1, $2, $3, $4::jsonb)`,
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_repay', $1, $2, $3, $4::jsonb)`,
 			borrowerID, lender, totalDue, toJSON(map[string]any{"loan_id": loanID}),
-		)
-		return err
+		); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, lender, "p2p_loan_repaid", map[string]any{"loan_id": loanID, "borrower_id": borrowerID, "amount": totalDue})
 	})
 }
 
@@ -1770,21 +3216,22 @@ func (d *DB) RecallP2PLoan(ctx context.Context, lenderID int64, loanID int64, mi
 	if minDays <= 0 {
 		minDays = 5
 	}
-	now := time.Now().UTC()
+	now := d.now()
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
 		var lender int64
 		var borrower int64
 		var totalDue int64
+		var collateral int64
 		var termDays int64
 		var status string
 		var acceptedAt time.Time
 		var dueAt time.Time
 		if err := tx.QueryRow(ctx, `
-SELECT lender_id, borrower_id, total_due, term_days, status, accepted_at, due_at
+SELECT lender_id, borrower_id, total_due, collateral, term_days, status, accepted_at, due_at
 FROM p2p_loans
 WHERE loan_id=$1
 FOR UPDATE
-`, loanID).Scan(&lender, &borrower, &totalDue, &termDays, &status, &acceptedAt, &dueAt); err != nil {
+`, loanID).Scan(&lender, &borrower, &totalDue, &collateral, &termDays, &status, &acceptedAt, &dueAt); err != nil {
 			return err
 		}
 		if lender != lenderID {
@@ -1804,22 +3251,14 @@ FOR UPDATE
 			}
 		}
 
-		// Collect only if borrower has enough positive balance.
-		var borrowerBal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, borrower).Scan(&borrowerBal); err != nil {
+		// Collect only if borrower has enough spendable balance; the collateral counts towards it.
+		if err := lockUsersTx(ctx, tx, borrower, lenderID); err != nil {
 			return err
 		}
-		if borrowerBal < totalDue {
-			return ErrNotEnough
-		}
-		{
-			var tmp int
-			if err := tx.QueryRow(ctx, `SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE`, lenderID).Scan(&tmp); err != nil {
-				return err
-			}
+		if err := releaseLoanCollateralTx(ctx, tx, loanID, borrower, collateral); err != nil {
+			return err
 		}
-
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, totalDue, borrower); err != nil {
+		if err := debitSpendableTx(ctx, tx, borrower, totalDue); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, totalDue, lenderID); err != nil {
@@ -1828,14 +3267,19 @@ FOR UPDATE
 		if _, err := tx.Exec(ctx, `UPDATE p2p_loans SET status='repaid', closed_at=$1 WHERE loan_id=$2`, now, loanID); err != nil {
 			return err
 		}
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_recall', $1, $2, $3, $4::jsonb)`,
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_recall', $1, $2, $3, $4::jsonb)`,
 			borrower, lenderID, totalDue, toJSON(map[string]any{"loan_id": loanID}),
-		)
-		return err
+		); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, borrower, "p2p_loan_recalled", map[string]any{"loan_id": loanID, "lender_id": lenderID, "amount": totalDue})
 	})
 }
 
-func (d *DB) CreateMarketListing(ctx context.Context, sellerID int64, title, description, category string, priceCoins int64, contact string, listingFee int64) (MarketListing, error) {
+// CreateMarketListing lists an item as pending; it goes live after
+// moderation (see moderation.go). The category must be an active one with
+// its required attributes present; its fee overrides listingFee.
+func (d *DB) CreateMarketListing(ctx context.Context, sellerID int64, title, description, category string, attrs map[string]string, priceCoins int64, contact string, listingFee int64, mp ModerationPolicy) (MarketListing, error) {
 	title = strings.TrimSpace(title)
 	description = strings.TrimSpace(description)
 	category = strings.ToLower(strings.TrimSpace(category))
@@ -1846,22 +3290,39 @@ func (d *DB) CreateMarketListing(ctx context.Context, sellerID int64, title, des
 	if category == "" {
 		category = "other"
 	}
+	attrs, err := normalizeListingAttrs(attrs)
+	if err != nil {
+		return MarketListing{}, err
+	}
 	if listingFee < 0 {
 		listingFee = 0
 	}
 	now := time.Now().UTC()
 	var out MarketListing
-	err := d.WithTx(ctx, func(tx pgx.Tx) error {
-		// fee burn
+	err = d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := lockUsersTx(ctx, tx, sellerID); err != nil {
+			return err
+		}
+		if err := listingThrottledTx(ctx, tx, sellerID, now, mp); err != nil {
+			return err
+		}
+		fee, err := listingCategoryTx(ctx, tx, category, attrs)
+		if err != nil {
+			return err
+		}
+		if fee != nil {
+			listingFee = *fee
+		}
 		if listingFee > 0 {
-			var bal int64
-			if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, sellerID).Scan(&bal); err != nil {
+			eff, err := resolveUserNFTEffects(ctx, tx, sellerID)
+			if err != nil {
 				return err
 			}
-			if bal < listingFee {
-				return ErrNotEnough
-			}
-			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, listingFee, sellerID); err != nil {
+			listingFee = ApplyFeeDiscount(listingFee, eff.FeeDiscountBP)
+		}
+		// fee burn
+		if listingFee > 0 {
+			if err := debitSpendableTx(ctx, tx, sellerID, listingFee); err != nil {
 				return err
 			}
 			if _, err := tx.Exec(ctx, `UPDATE system_state SET total_supply=GREATEST(total_supply-$1,0), updated_at=now() WHERE id=1`, listingFee); err != nil {
@@ -1875,10 +3336,10 @@ func (d *DB) CreateMarketListing(ctx context.Context, sellerID int64, title, des
 		}
 
 		if err := tx.QueryRow(ctx, `
-INSERT INTO market_listings (seller_id, title, description, category, price_coins, contact, status, created_at)
-VALUES ($1,$2,$3,$4,$5,$6,'active',$7)
+INSERT INTO market_listings (seller_id, title, description, category, price_coins, contact, status, created_at, attributes)
+VALUES ($1,$2,$3,$4,$5,$6,'pending',$7,$8::jsonb)
 RETURNING listing_id
-`, sellerID, title, description, category, priceCoins, contact, now).Scan(&out.ListingID); err != nil {
+`, sellerID, title, description, category, priceCoins, contact, now, toJSON(attrs)).Scan(&out.ListingID); err != nil {
 			return err
 		}
 		return nil
@@ -1890,24 +3351,37 @@ RETURNING listing_id
 	out.Title = title
 	out.Description = description
 	out.Category = category
+	out.Attributes = attrs
 	out.PriceCoins = priceCoins
 	out.Contact = contact
-	out.Status = "active"
+	out.Status = ListingPending
 	out.CreatedAt = now
 	return out, nil
 }
 
+// AddMarketListingImage attaches an image; a listing that is live or already
+// screened goes back to pending for screening.
 func (d *DB) AddMarketListingImage(ctx context.Context, listingID int64, mime string, data []byte) (int64, error) {
 	mime = strings.TrimSpace(mime)
 	if listingID <= 0 || mime == "" || len(data) == 0 {
 		return 0, errors.New("bad params")
 	}
 	var imageID int64
-	err := d.Pool.QueryRow(ctx, `
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `
 INSERT INTO market_listing_images (listing_id, mime, data)
 VALUES ($1,$2,$3)
 RETURNING image_id
-`, listingID, mime, data).Scan(&imageID)
+`, listingID, mime, data).Scan(&imageID); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `UPDATE market_listings SET status='pending' WHERE listing_id=$1 AND status IN ('active','pending')`, listingID)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM listing_moderation WHERE listing_id=$1`, listingID)
+		return err
+	})
 	return imageID, err
 }
 
@@ -1982,7 +3456,10 @@ LIMIT $2
 	return out, rows.Err()
 }
 
-func (d *DB) BuyMarketListing(ctx context.Context, buyerID int64, listingID int64) error {
+// BuyMarketListing settles a bazaar purchase. Exchange listings are coins
+// sold for off-platform money: they count against the seller's daily sale
+// limit and the sale tax is debited from the seller.
+func (d *DB) BuyMarketListing(ctx context.Context, buyerID int64, listingID int64, sp SalePolicy) error {
 	if buyerID <= 0 || listingID <= 0 {
 		return errors.New("bad params")
 	}
@@ -2008,9 +3485,17 @@ func (d *DB) BuyMarketListing(ctx context.Context, buyerID int64, listingID int6
 		}
 
 		cat := strings.ToLower(strings.TrimSpace(category))
+		if cat == ServicesCategory {
+			return errors.New("bad listing: services are hired through gigs")
+		}
 		isFiat := cat == "exchange" || cat == "fiat"
 		if isFiat {
 			// Fiat/exchange listing: mark as sold, no in-app coin transfer.
+			if cat == "exchange" {
+				if err := chargeExchangeSaleTx(ctx, tx, sellerID, listingID, price, sp); err != nil {
+					return err
+				}
+			}
 			if _, err := tx.Exec(ctx, `UPDATE market_listings SET status='sold', sold_at=$1, buyer_id=$2 WHERE listing_id=$3`, now, buyerID, listingID); err != nil {
 				return err
 			}
@@ -2020,21 +3505,10 @@ func (d *DB) BuyMarketListing(ctx context.Context, buyerID int64, listingID int6
 			return err
 		}
 
-		var buyerBal int64
-		if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, buyerID).Scan(&buyerBal); err != nil {
+		if err := lockUsersTx(ctx, tx, buyerID, sellerID); err != nil {
 			return err
 		}
-		if buyerBal < price {
-			return ErrNotEnough
-		}
-		{
-			var tmp int64
-			if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1 FOR UPDATE`, sellerID).Scan(&tmp); err != nil {
-				return err
-			}
-		}
-
-		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, price, buyerID); err != nil {
+		if err := debitSpendableTx(ctx, tx, buyerID, price); err != nil {
 			return err
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, price, sellerID); err != nil {
@@ -2055,18 +3529,17 @@ func (d *DB) FreezeBalance(ctx context.Context, userID int64, amount int64) erro
 		return errors.New("bad params")
 	}
 	return d.WithTx(ctx, func(tx pgx.Tx) error {
-		var bal int64
-		var frozen int64
-		if err := tx.QueryRow(ctx, `SELECT balance, frozen_balance FROM users WHERE user_id=$1 FOR UPDATE`, userID).Scan(&bal, &frozen); err != nil {
+		spendable, err := lockSpendableTx(ctx, tx, userID)
+		if err != nil {
 			return err
 		}
-		if bal < amount {
+		if spendable < amount {
 			return ErrNotEnough
 		}
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1, frozen_balance=frozen_balance+$1 WHERE user_id=$2`, amount, userID); err != nil {
 			return err
 		}
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('balance_freeze', $1, NULL, $2, $3::jsonb)`,
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('balance_freeze', $1, NULL, $2, $3::jsonb)`,
 			userID, amount, toJSON(map[string]any{"amount": amount}),
 		)
 		return err
@@ -2089,7 +3562,8 @@ func (d *DB) UnfreezeBalance(ctx context.Context, userID int64, amount int64) er
 		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1, frozen_balance=frozen_balance-$1 WHERE user_id=$2`, amount, userID); err != nil {
 			return err
 		}
-		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('balance_unfreeze', $1, NULL, $2, $3::jsonb)`,
+		// Booked to the user: the coins come back into their balance.
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('balance_unfreeze', NULL, $1, $2, $3::jsonb)`,
 			userID, amount, toJSON(map[string]any{"amount": amount}),
 		)
 		return err
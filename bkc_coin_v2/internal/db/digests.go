@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Digests are optional periodic Telegram summaries: what the user earned,
+// their active loans and their listing promotions about to end. A user
+// picks the sections, a period and the hour (UTC) to get it at; the digests
+// job builds each due digest from user_daily, the ledger and the loan and
+// promotion tables and queues it into notification_log under the "digest"
+// template, so language, muting, retries and the audit trail work as for
+// any notification. A digest with nothing to say is skipped, not sent.
+
+// Digest sections.
+const (
+	DigestEarnings = "earnings" // taps and income over the window
+	DigestLoans    = "loans"    // active bank and P2P loans as borrower
+	DigestListings = "listings" // listing promotions ending before the next digest
+)
+
+var DigestSections = []string{DigestEarnings, DigestLoans, DigestListings}
+
+const digestBatch = 500
+
+// digestIncomeKinds are the ledger kinds that count as income to their to_id.
+var digestIncomeKinds = []string{
+	"market_buy", "nft_market_buy", "nft_royalty", "nft_rent", "nft_stake_reward",
+	"tip", "ref_bonus", "level_reward", "gig_release", "bill_payout",
+}
+
+// DigestSubscription is a user's digest schedule.
+type DigestSubscription struct {
+	UserID       int64      `json:"user_id"`
+	Sections     []string   `json:"sections"`
+	PeriodDays   int64      `json:"period_days"` // 1 or 7
+	HourUTC      int64      `json:"hour_utc"`
+	NextAt       time.Time  `json:"next_at"`
+	CoveredUntil *time.Time `json:"covered_until,omitempty"`
+}
+
+func (s DigestSubscription) validate() error {
+	if len(s.Sections) == 0 {
+		return errors.New("bad sections: empty")
+	}
+	for _, sec := range s.Sections {
+		if !slices.Contains(DigestSections, sec) {
+			return errors.New("bad section " + sec)
+		}
+	}
+	if s.PeriodDays != 1 && s.PeriodDays != 7 {
+		return errors.New("bad period_days: 1 or 7")
+	}
+	if s.HourUTC < 0 || s.HourUTC > 23 {
+		return errors.New("bad hour_utc")
+	}
+	return nil
+}
+
+// nextDigestAt is the first hourUTC:00 strictly after after, periodDays-1
+// days later for a weekly digest.
+func nextDigestAt(after time.Time, hourUTC, periodDays int64) time.Time {
+	after = after.UTC()
+	next := time.Date(after.Year(), after.Month(), after.Day(), int(hourUTC), 0, 0, 0, time.UTC)
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next.AddDate(0, 0, int(periodDays-1))
+}
+
+// Digest is the content of one digest; sections not subscribed stay zero.
+type Digest struct {
+	Tapped   int64      `json:"tapped"`
+	Income   int64      `json:"income"`
+	Loans    int64      `json:"loans"`
+	LoanDue  int64      `json:"loan_due"`
+	NextDue  *time.Time `json:"next_due,omitempty"`
+	Listings int64      `json:"listings"`
+}
+
+func (g Digest) empty() bool {
+	return g.Tapped == 0 && g.Income == 0 && g.Loans == 0 && g.Listings == 0
+}
+
+// vars are the "digest" template variables.
+func (g Digest) vars(periodDays int64) map[string]any {
+	nextDue := ""
+	if g.NextDue != nil {
+		nextDue = g.NextDue.UTC().Format("2006-01-02")
+	}
+	return map[string]any{
+		"period_days": periodDays,
+		"earned":      g.Tapped + g.Income,
+		"tapped":      g.Tapped,
+		"income":      g.Income,
+		"loans":       g.Loans,
+		"loan_due":    g.LoanDue,
+		"next_due":    nextDue,
+		"listings":    g.Listings,
+	}
+}
+
+func (d *DB) GetDigestSubscription(ctx context.Context, userID int64) (DigestSubscription, error) {
+	s := DigestSubscription{UserID: userID}
+	err := d.Pool.QueryRow(ctx, `
+SELECT sections, period_days, hour_utc, next_at, covered_until
+FROM digest_subscriptions WHERE user_id=$1
+`, userID).Scan(&s.Sections, &s.PeriodDays, &s.HourUTC, &s.NextAt, &s.CoveredUntil)
+	return s, err
+}
+
+// SetDigestSubscription subscribes the user or changes their schedule; the
+// next digest goes out at the next matching hour. Earnings already covered
+// by a sent digest are not reported again.
+func (d *DB) SetDigestSubscription(ctx context.Context, s DigestSubscription) (DigestSubscription, error) {
+	if s.UserID <= 0 {
+		return DigestSubscription{}, errors.New("bad params")
+	}
+	if err := s.validate(); err != nil {
+		return DigestSubscription{}, err
+	}
+	slices.Sort(s.Sections)
+	s.Sections = slices.Compact(s.Sections)
+	s.NextAt = nextDigestAt(time.Now(), s.HourUTC, 1)
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO digest_subscriptions (user_id, sections, period_days, hour_utc, next_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET sections=EXCLUDED.sections, period_days=EXCLUDED.period_days, hour_utc=EXCLUDED.hour_utc,
+    next_at=EXCLUDED.next_at, updated_at=now()
+RETURNING covered_until
+`, s.UserID, s.Sections, s.PeriodDays, s.HourUTC, s.NextAt).Scan(&s.CoveredUntil)
+	if err != nil {
+		return DigestSubscription{}, err
+	}
+	return s, nil
+}
+
+// DeleteDigestSubscription unsubscribes the user; false if they were not
+// subscribed.
+func (d *DB) DeleteDigestSubscription(ctx context.Context, userID int64) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `DELETE FROM digest_subscriptions WHERE user_id=$1`, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// buildDigestTx gathers s's sections for the window [since, until); next is
+// when the following digest is due.
+func buildDigestTx(ctx context.Context, q rowQuerier, s DigestSubscription, since, until, next time.Time) (Digest, error) {
+	var g Digest
+	if slices.Contains(s.Sections, DigestEarnings) {
+		// user_daily is per day: the window counts the days it started on
+		// and ended before.
+		if err := q.QueryRow(ctx, `
+SELECT
+  (SELECT COALESCE(SUM(tapped), 0) FROM user_daily WHERE user_id=$1 AND day >= ($2 AT TIME ZONE 'UTC')::date AND day < ($3 AT TIME ZONE 'UTC')::date),
+  (SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE to_id=$1 AND kind = ANY($4) AND ts >= $2 AND ts < $3)
+`, s.UserID, since, until, digestIncomeKinds).Scan(&g.Tapped, &g.Income); err != nil {
+			return Digest{}, err
+		}
+	}
+	if slices.Contains(s.Sections, DigestLoans) {
+		if err := q.QueryRow(ctx, `
+SELECT COUNT(*), COALESCE(SUM(total_due), 0), MIN(due_at)
+FROM (
+  SELECT total_due, due_at FROM bank_loans WHERE user_id=$1 AND status='active'
+  UNION ALL
+  SELECT total_due, due_at FROM p2p_loans WHERE borrower_id=$1 AND status='active'
+) l
+`, s.UserID).Scan(&g.Loans, &g.LoanDue, &g.NextDue); err != nil {
+			return Digest{}, err
+		}
+	}
+	if slices.Contains(s.Sections, DigestListings) {
+		if err := q.QueryRow(ctx, `
+SELECT COUNT(*) FROM listing_promotions
+WHERE seller_id=$1 AND status='active' AND ends_at <= $2
+`, s.UserID, next).Scan(&g.Listings); err != nil {
+			return Digest{}, err
+		}
+	}
+	return g, nil
+}
+
+// QueueDigests builds the digests due at now and queues the non-empty ones
+// for the notifications job, then moves each subscription to its next slot
+// (scheduled job). Returns digests queued.
+func (d *DB) QueueDigests(ctx context.Context, now time.Time) (int64, error) {
+	now = now.UTC()
+	var queued int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT user_id, sections, period_days, hour_utc, next_at, covered_until
+FROM digest_subscriptions
+WHERE next_at <= $1
+ORDER BY next_at
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`, now, digestBatch)
+		if err != nil {
+			return err
+		}
+		var due []DigestSubscription
+		for rows.Next() {
+			var s DigestSubscription
+			if err := rows.Scan(&s.UserID, &s.Sections, &s.PeriodDays, &s.HourUTC, &s.NextAt, &s.CoveredUntil); err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, s := range due {
+			since := now.AddDate(0, 0, -int(s.PeriodDays))
+			if s.CoveredUntil != nil && s.CoveredUntil.After(since) {
+				since = *s.CoveredUntil
+			}
+			next := nextDigestAt(now, s.HourUTC, s.PeriodDays)
+			g, err := buildDigestTx(ctx, tx, s, since, now, next)
+			if err != nil {
+				return err
+			}
+			if !g.empty() {
+				n, err := queueNotificationTx(ctx, tx, nil, s.UserID, templates.Digest, g.vars(s.PeriodDays))
+				if err != nil {
+					return err
+				}
+				queued += n
+			}
+			if _, err := tx.Exec(ctx, `
+UPDATE digest_subscriptions SET next_at=$2, covered_until=$3 WHERE user_id=$1
+`, s.UserID, next, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return queued, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDigestAt(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	cases := []struct {
+		after      string
+		hour, days int64
+		want       string
+	}{
+		{"2025-01-10T08:30:00Z", 9, 1, "2025-01-10T09:00:00Z"},
+		{"2025-01-10T09:00:00Z", 9, 1, "2025-01-11T09:00:00Z"}, // strictly after
+		{"2025-01-10T22:00:00Z", 0, 1, "2025-01-11T00:00:00Z"},
+		{"2025-01-10T09:00:00Z", 9, 7, "2025-01-17T09:00:00Z"},
+		{"2025-01-10T11:00:00+03:00", 9, 1, "2025-01-10T09:00:00Z"}, // 08:00 UTC
+	}
+	for _, c := range cases {
+		if got := nextDigestAt(at(c.after), c.hour, c.days); !got.Equal(at(c.want)) {
+			t.Errorf("%s hour %d every %d: got %s, want %s", c.after, c.hour, c.days, got, c.want)
+		}
+	}
+}
+
+func TestDigestSubscriptionValidate(t *testing.T) {
+	ok := DigestSubscription{Sections: []string{DigestEarnings, DigestLoans}, PeriodDays: 1, HourUTC: 9}
+	if err := ok.validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []DigestSubscription{
+		{PeriodDays: 1},
+		{Sections: []string{"weather"}, PeriodDays: 1},
+		{Sections: []string{DigestLoans}, PeriodDays: 3},
+		{Sections: []string{DigestLoans}, PeriodDays: 7, HourUTC: 24},
+	}
+	for _, s := range bad {
+		if err := s.validate(); err == nil {
+			t.Errorf("%+v: accepted", s)
+		}
+	}
+}
+
+func TestDigestVars(t *testing.T) {
+	if !(Digest{}).empty() {
+		t.Fatal("zero digest is not empty")
+	}
+	due := time.Date(2025, 2, 1, 15, 0, 0, 0, time.UTC)
+	g := Digest{Tapped: 1200, Income: 300, Loans: 1, LoanDue: 1100, NextDue: &due}
+	if g.empty() {
+		t.Fatal("digest with earnings is empty")
+	}
+	v := g.vars(1)
+	if v["earned"] != int64(1500) || v["next_due"] != "2025-02-01" || v["listings"] != int64(0) {
+		t.Fatalf("vars %v", v)
+	}
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Disputes are escrow conflicts a buyer or seller hands to an admin with the
+// resolve_disputes permission. The escrowed amount stays locked while a
+// dispute is open; the verdict pays it to the seller (release), back to the
+// buyer (refund) or splits it (split, SellerBP to the seller). Each kind
+// settles its own escrow; gig milestones are the first kind.
+
+// Dispute kinds.
+const DisputeGigMilestone = "gig_milestone"
+
+// Dispute statuses and resolutions.
+const (
+	DisputeOpen     = "open"
+	DisputeResolved = "resolved"
+
+	DisputeRelease = "release"
+	DisputeRefund  = "refund"
+	DisputeSplit   = "split"
+)
+
+const maxDisputeReason = 1000
+
+// Dispute is an escrow conflict awaiting or past an admin verdict.
+type Dispute struct {
+	DisputeID  int64      `json:"dispute_id"`
+	Kind       string     `json:"kind"`
+	RefID      int64      `json:"ref_id"`
+	OpenedBy   int64      `json:"opened_by"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	SellerBP   int64      `json:"seller_bp"`
+	Note       string     `json:"note,omitempty"`
+	ResolvedBy *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const disputeCols = `dispute_id, kind, ref_id, opened_by, reason, status, COALESCE(resolution, ''), seller_bp, note, resolved_by, resolved_at, created_at`
+
+func scanDispute(row pgx.Row) (Dispute, error) {
+	var d Dispute
+	err := row.Scan(&d.DisputeID, &d.Kind, &d.RefID, &d.OpenedBy, &d.Reason, &d.Status, &d.Resolution, &d.SellerBP, &d.Note, &d.ResolvedBy, &d.ResolvedAt, &d.CreatedAt)
+	return d, err
+}
+
+// disputeSellerShare is the part of amount a verdict pays the seller.
+func disputeSellerShare(amount int64, resolution string, sellerBP int64) (int64, error) {
+	switch resolution {
+	case DisputeRelease:
+		return amount, nil
+	case DisputeRefund:
+		return 0, nil
+	case DisputeSplit:
+		if sellerBP <= 0 || sellerBP >= 10_000 {
+			return 0, errors.New("bad seller_bp")
+		}
+		return amount * sellerBP / 10_000, nil
+	}
+	return 0, errors.New("bad resolution")
+}
+
+// openDisputeTx records a dispute; ErrAlreadyExists if one is open on ref.
+func openDisputeTx(ctx context.Context, tx pgx.Tx, kind string, refID, userID int64, reason string) (Dispute, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxDisputeReason {
+		return Dispute{}, errors.New("bad reason")
+	}
+	out, err := scanDispute(tx.QueryRow(ctx, `
+INSERT INTO disputes(kind, ref_id, opened_by, reason) VALUES($1, $2, $3, $4)
+ON CONFLICT (kind, ref_id) WHERE status = 'open' DO NOTHING
+RETURNING `+disputeCols, kind, refID, userID, reason))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Dispute{}, ErrAlreadyExists
+	}
+	return out, err
+}
+
+// ListDisputes returns disputes, oldest open first; an empty status lists all.
+func (d *DB) ListDisputes(ctx context.Context, status string, limit int) ([]Dispute, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+disputeCols+` FROM disputes
+WHERE $1 = '' OR status = $1
+ORDER BY status = 'open' DESC, dispute_id
+LIMIT $2
+`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Dispute{}
+	for rows.Next() {
+		dp, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dp)
+	}
+	return out, rows.Err()
+}
+
+// ResolveDispute settles an open dispute with resolution; sellerBP is used
+// by split only.
+func (d *DB) ResolveDispute(ctx context.Context, adminID, disputeID int64, resolution string, sellerBP int64, note string) (Dispute, error) {
+	note = strings.TrimSpace(note)
+	if len(note) > maxDisputeReason {
+		return Dispute{}, errors.New("bad note")
+	}
+	if resolution != DisputeSplit {
+		sellerBP = 0
+	}
+	var out Dispute
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		dp, err := scanDispute(tx.QueryRow(ctx, `SELECT `+disputeCols+` FROM disputes WHERE dispute_id=$1 FOR UPDATE`, disputeID))
+		if err != nil {
+			return err
+		}
+		if dp.Status != DisputeOpen {
+			return ErrLocked
+		}
+		switch dp.Kind {
+		case DisputeGigMilestone:
+			err = resolveGigMilestoneTx(ctx, tx, dp, resolution, sellerBP)
+		default:
+			err = errors.New("bad dispute kind")
+		}
+		if err != nil {
+			return err
+		}
+		out, err = scanDispute(tx.QueryRow(ctx, `
+UPDATE disputes SET status='resolved', resolution=$2, seller_bp=$3, note=$4, resolved_by=$5, resolved_at=now()
+WHERE dispute_id=$1
+RETURNING `+disputeCols, disputeID, resolution, sellerBP, note, adminID))
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrEmissionCap means a mint would exceed today's global emission cap.
+var ErrEmissionCap = errors.New("daily emission cap reached")
+
+// Emission is today's mint counter against system_state.daily_emission_cap.
+// Only free mints count (taps, reserve rewards, referral and staking payouts);
+// deposits and loans are paid for and are not emission.
+type Emission struct {
+	Day    string `json:"day"`
+	Minted int64  `json:"minted"`
+	Cap    int64  `json:"cap"` // 0 = unlimited
+}
+
+// Remaining is what may still be minted today; -1 when there is no cap.
+func (e Emission) Remaining() int64 {
+	if e.Cap <= 0 {
+		return -1
+	}
+	if e.Minted >= e.Cap {
+		return 0
+	}
+	return e.Cap - e.Minted
+}
+
+// emissionDay is the UTC day emission is counted against.
+func emissionDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// GetEmission returns the emission counter for the UTC day of at.
+func (d *DB) GetEmission(ctx context.Context, at time.Time) (Emission, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	out := Emission{Day: emissionDay(at)}
+	err := d.Pool.QueryRow(ctx, `
+SELECT s.daily_emission_cap, COALESCE(e.minted, 0)
+FROM system_state s
+LEFT JOIN daily_emission e ON e.day = $1::date
+WHERE s.id=1
+`, out.Day).Scan(&out.Cap, &out.Minted)
+	if err != nil {
+		return Emission{}, err
+	}
+	return out, nil
+}
+
+// SetDailyEmissionCap sets the global cap (0 removes it).
+func (d *DB) SetDailyEmissionCap(ctx context.Context, limit int64) error {
+	if limit < 0 {
+		return errors.New("bad emission cap")
+	}
+	_, err := d.Pool.Exec(ctx, `UPDATE system_state SET daily_emission_cap=$1, updated_at=now() WHERE id=1`, limit)
+	return err
+}
+
+// lockEmissionTx locks system_state and then today's counter (always in that
+// order, like every reserve mutation) and returns the counter.
+func lockEmissionTx(ctx context.Context, tx pgx.Tx, day string) (Emission, error) {
+	out := Emission{Day: day}
+	if err := tx.QueryRow(ctx, `SELECT daily_emission_cap FROM system_state WHERE id=1 FOR UPDATE`).Scan(&out.Cap); err != nil {
+		return Emission{}, err
+	}
+	err := tx.QueryRow(ctx, `
+INSERT INTO daily_emission(day, minted)
+VALUES($1::date, 0)
+ON CONFLICT (day) DO UPDATE SET day = EXCLUDED.day
+RETURNING minted
+`, day).Scan(&out.Minted)
+	if err != nil {
+		return Emission{}, err
+	}
+	return out, nil
+}
+
+// chargeEmissionUpToTx counts as much of amount against today's cap as
+// still fits and returns that much.
+func chargeEmissionUpToTx(ctx context.Context, tx pgx.Tx, amount int64) (int64, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+	e, err := lockEmissionTx(ctx, tx, emissionDay(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	if left := e.Remaining(); left >= 0 {
+		amount = min(amount, left)
+	}
+	if amount == 0 {
+		return 0, nil
+	}
+	_, err = tx.Exec(ctx, `UPDATE daily_emission SET minted = minted + $2, updated_at=now() WHERE day=$1::date`, e.Day, amount)
+	return amount, err
+}
+
+// capTapCredits scales the positive deltas down so they sum to limit (total
+// is their sum, above limit): each keeps its share, rounded down, and the
+// rounding remainder goes a coin each to the first ones.
+func capTapCredits(deltas []int64, total, limit int64) {
+	scaled := make([]int64, len(deltas))
+	left := limit
+	for i, v := range deltas {
+		if v > 0 {
+			scaled[i] = v * limit / total
+			left -= scaled[i]
+		} else {
+			scaled[i] = v
+		}
+	}
+	for i, v := range deltas {
+		if left == 0 {
+			break
+		}
+		if v > scaled[i] {
+			scaled[i]++
+			left--
+		}
+	}
+	copy(deltas, scaled)
+}
+
+// chargeEmissionTx counts amount against today's cap in the caller's
+// transaction, failing with ErrEmissionCap if it does not fit.
+func chargeEmissionTx(ctx context.Context, tx pgx.Tx, amount int64) error {
+	if amount <= 0 {
+		return nil
+	}
+	e, err := lockEmissionTx(ctx, tx, emissionDay(time.Now()))
+	if err != nil {
+		return err
+	}
+	if left := e.Remaining(); left >= 0 && amount > left {
+		return ErrEmissionCap
+	}
+	_, err = tx.Exec(ctx, `UPDATE daily_emission SET minted = minted + $2, updated_at=now() WHERE day=$1::date`, e.Day, amount)
+	return err
+}
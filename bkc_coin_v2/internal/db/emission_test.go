@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmissionRemaining(t *testing.T) {
+	cases := []struct {
+		e    Emission
+		want int64
+	}{
+		{Emission{Minted: 500}, -1},
+		{Emission{Minted: 0, Cap: 1_000}, 1_000},
+		{Emission{Minted: 400, Cap: 1_000}, 600},
+		{Emission{Minted: 1_200, Cap: 1_000}, 0},
+	}
+	for _, c := range cases {
+		if got := c.e.Remaining(); got != c.want {
+			t.Fatalf("%+v: remaining %d, want %d", c.e, got, c.want)
+		}
+	}
+}
+
+func TestCreditFromReserveEmissionCap(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const userID = 9_200_200_000
+
+	if _, err := d.EnsureSystemState(ctx, 1_000_000_000, 1, 0, 1_000_000_000, 1_000, 500, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO users (user_id, username, first_name) VALUES ($1, 'cap', 'Cap') ON CONFLICT (user_id) DO NOTHING`, userID); err != nil {
+		t.Fatal(err)
+	}
+	em, err := d.GetEmission(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDailyEmissionCap(ctx, em.Minted+100); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.SetDailyEmissionCap(context.Background(), em.Cap) })
+
+	if err := d.CreditFromReserve(ctx, userID, 60, "test_emission", nil); err != nil {
+		t.Fatalf("first credit: %v", err)
+	}
+	if err := d.CreditFromReserve(ctx, userID, 60, "test_emission", nil); !errors.Is(err, ErrEmissionCap) {
+		t.Fatalf("second credit: %v, want ErrEmissionCap", err)
+	}
+	after, err := d.GetEmission(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Minted != em.Minted+60 {
+		t.Fatalf("minted %d, want %d", after.Minted, em.Minted+60)
+	}
+}
+
+func TestCapTapCredits(t *testing.T) {
+	deltas := []int64{50, -5, 30, 20}
+	capTapCredits(deltas, 100, 61)
+	// 30.5, 18.3 and 12.2 rounded down, plus one coin of remainder to the first.
+	if deltas[0] != 31 || deltas[1] != -5 || deltas[2] != 18 || deltas[3] != 12 {
+		t.Fatalf("capped %v", deltas)
+	}
+	zero := []int64{10, 20}
+	capTapCredits(zero, 30, 0)
+	if zero[0] != 0 || zero[1] != 0 {
+		t.Fatalf("capped to nothing: %v", zero)
+	}
+}
+
+func TestTapFlushOverEmissionCap(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const a, b = 9_200_200_011, 9_200_200_012
+	seedMoneyUsers(t, d, 0, a, b)
+	before := moneySystem(t, d)
+	em, err := d.GetEmission(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDailyEmissionCap(ctx, em.Minted+90); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.SetDailyEmissionCap(context.Background(), em.Cap) })
+
+	// 120 accepted against a stale view of the 90 left: the flush persists 90.
+	now := time.Now().UTC()
+	users := []UserTapAggregate{
+		{UserID: a, BalanceDelta: 80, TapsDelta: 80, EnergyUpdatedAt: now},
+		{UserID: b, BalanceDelta: 40, TapsDelta: 40, EnergyUpdatedAt: now},
+	}
+	if err := d.ApplyTapAggregates(ctx, users, nil, -120, "test"); err != nil {
+		t.Fatalf("flush over the cap: %v", err)
+	}
+	ua, err := d.GetUser(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ub, err := d.GetUser(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua.Balance != 60 || ub.Balance != 30 {
+		t.Fatalf("balances %d and %d, want 60 and 30", ua.Balance, ub.Balance)
+	}
+	after, err := d.GetEmission(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Minted != em.Minted+90 {
+		t.Fatalf("minted %d, want %d", after.Minted, em.Minted+90)
+	}
+	if s := moneySystem(t, d); s.ReserveSupply != before.ReserveSupply-90 {
+		t.Fatalf("reserve %d, want %d", s.ReserveSupply, before.ReserveSupply-90)
+	}
+
+	// With nothing left the batch still goes through: the taps count, no coins.
+	if err := d.ApplyTapAggregates(ctx, users, nil, -120, "test"); err != nil {
+		t.Fatalf("flush at the cap: %v", err)
+	}
+	if ua, err = d.GetUser(ctx, a); err != nil || ua.Balance != 60 || ua.TapsTotal != 160 {
+		t.Fatalf("at the cap: %+v, %v", ua, err)
+	}
+}
+
+func TestSellGameCreditsEmissionCap(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const userID = 9_200_200_021
+	seedMoneyUsers(t, d, 0, userID)
+	moneySystem(t, d)
+	if err := d.EnsureGameCreditRates(ctx, GameCreditRates{BuyRate: 10, SellRate: 10}); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := d.GetGameCreditRates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SetGameCreditRates(ctx, 1, GameCreditRates{BuyRate: 10, SellRate: 10, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = d.SetGameCreditRates(context.Background(), 1, rates) })
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET game_credits=1000 WHERE user_id=$1`, userID); err != nil {
+		t.Fatal(err)
+	}
+	em, err := d.GetEmission(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDailyEmissionCap(ctx, em.Minted+50); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.SetDailyEmissionCap(context.Background(), em.Cap) })
+
+	// Credits won in games come back as new coins from the reserve.
+	if _, err := d.SellGameCredits(ctx, userID, 400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SellGameCredits(ctx, userID, 400); !errors.Is(err, ErrEmissionCap) {
+		t.Fatalf("sale over the cap: %v", err)
+	}
+	if got := checkLedger(t, d, userID, 0); got != 40 {
+		t.Fatalf("balance %d", got)
+	}
+}
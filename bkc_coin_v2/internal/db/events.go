@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UserEvent is a notification for a user (offer received, listing sold, ...).
+// Events are written in the same transaction as the state change they describe.
+type UserEvent struct {
+	EventID   int64          `json:"event_id"`
+	UserID    int64          `json:"user_id"`
+	Kind      string         `json:"kind"`
+	Payload   map[string]any `json:"payload"`
+	ReadAt    *time.Time     `json:"read_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func addUserEventTx(ctx context.Context, tx pgx.Tx, userID int64, kind string, payload map[string]any) error {
+	if userID <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO user_events(user_id, kind, payload) VALUES($1, $2, $3::jsonb)`, userID, kind, toJSON(payload))
+	return err
+}
+
+// ListUserEvents returns events newer than afterID, newest first.
+func (d *DB) ListUserEvents(ctx context.Context, userID, afterID, limit int64) ([]UserEvent, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT event_id, user_id, kind, payload, read_at, created_at
+FROM user_events
+WHERE user_id=$1 AND event_id > $2
+ORDER BY event_id DESC
+LIMIT $3
+`, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UserEvent
+	for rows.Next() {
+		var e UserEvent
+		if err := rows.Scan(&e.EventID, &e.UserID, &e.Kind, &e.Payload, &e.ReadAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// MarkUserEventsRead marks every event up to and including upToID as read.
+func (d *DB) MarkUserEventsRead(ctx context.Context, userID, upToID int64) error {
+	_, err := d.Pool.Exec(ctx, `UPDATE user_events SET read_at=now() WHERE user_id=$1 AND event_id <= $2 AND read_at IS NULL`, userID, upToID)
+	return err
+}
@@ -0,0 +1,384 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A/B experiments. A user's variant is a hash of the experiment key and
+// user id, so it is stable without storing it; the first time a handler
+// branches on it the user is logged as exposed. Variants carry integer
+// params (a bonus amount, a price in basis points) that handlers read with a
+// default for when the experiment is not running. Guardrails compare each
+// variant's per-user metric with the control (the first variant) and stop
+// the experiment when one is harmed by more than the allowed percentage
+// (experiments job).
+
+const (
+	ExperimentDraft   = "draft"
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+// Experiments handlers branch on.
+const (
+	ExperimentDropPrice = "drop_price" // param price_bp: drop price in bp of the NFT price
+)
+
+// Guardrail metrics, per exposed user since exposure.
+const (
+	MetricSpend       = "spend"       // coins spent on purchases
+	MetricDeposits    = "deposits"    // coins deposited
+	MetricWithdrawals = "withdrawals" // coins requested out; higher is worse
+)
+
+// experimentMetrics maps a metric to its ledger filter on l for user e.user_id.
+var experimentMetrics = map[string]string{
+	MetricSpend:       `l.from_id = e.user_id AND l.kind IN ('nft_buy','nft_market_buy','market_buy','bundle_buy')`,
+	MetricDeposits:    `l.to_id = e.user_id AND l.kind IN ('deposit_approve','cryptopay_deposit')`,
+	MetricWithdrawals: `l.from_id = e.user_id AND l.kind = 'withdraw_request'`,
+}
+
+const maxExperimentVariants = 8
+
+var (
+	experimentKeyRe     = regexp.MustCompile(`^[a-z0-9_]{2,48}$`)
+	experimentVariantRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+type ExperimentVariant struct {
+	Name     string           `json:"name"`
+	WeightBP int64            `json:"weight_bp"` // share of users; variants sum to 10000
+	Params   map[string]int64 `json:"params,omitempty"`
+}
+
+type ExperimentGuardrail struct {
+	Metric     string `json:"metric"`
+	MaxHarmPct int64  `json:"max_harm_pct"` // stop when a variant is this much worse than control
+	MinUsers   int64  `json:"min_users"`    // per variant, before the guardrail is judged
+}
+
+type Experiment struct {
+	Key         string                `json:"key"`
+	Description string                `json:"description"`
+	Status      string                `json:"status"`
+	Variants    []ExperimentVariant   `json:"variants"`
+	Guardrails  []ExperimentGuardrail `json:"guardrails"`
+	StopReason  string                `json:"stop_reason,omitempty"`
+	CreatedBy   int64                 `json:"created_by"`
+	StartedAt   *time.Time            `json:"started_at,omitempty"`
+	StoppedAt   *time.Time            `json:"stopped_at,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+}
+
+// ExperimentVariantStats is one variant's exposure and guardrail metrics.
+type ExperimentVariantStats struct {
+	Variant string           `json:"variant"`
+	Users   int64            `json:"users"`
+	Metrics map[string]int64 `json:"metrics"` // totals over the variant's users
+}
+
+// ExperimentReport is an experiment with its per-variant stats and the
+// guardrails currently breached.
+type ExperimentReport struct {
+	Experiment
+	Stats    []ExperimentVariantStats `json:"stats"`
+	Breaches []string                 `json:"breaches"`
+}
+
+func (e Experiment) validate() error {
+	if !experimentKeyRe.MatchString(e.Key) {
+		return errors.New("bad key")
+	}
+	if len(e.Variants) < 2 || len(e.Variants) > maxExperimentVariants {
+		return errors.New("bad variants: 2..8 needed")
+	}
+	var sum int64
+	seen := map[string]bool{}
+	for _, v := range e.Variants {
+		if !experimentVariantRe.MatchString(v.Name) || seen[v.Name] || v.WeightBP <= 0 {
+			return fmt.Errorf("bad variant %q", v.Name)
+		}
+		seen[v.Name] = true
+		sum += v.WeightBP
+	}
+	if sum != 10_000 {
+		return errors.New("bad variants: weights must sum to 10000")
+	}
+	for _, g := range e.Guardrails {
+		if _, ok := experimentMetrics[g.Metric]; !ok || g.MaxHarmPct < 1 || g.MaxHarmPct > 100 || g.MinUsers < 0 {
+			return fmt.Errorf("bad guardrail %q", g.Metric)
+		}
+	}
+	return nil
+}
+
+// assignVariant picks userID's variant of the experiment key by weight.
+func assignVariant(key string, userID int64, variants []ExperimentVariant) ExperimentVariant {
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + strconv.FormatInt(userID, 10)))
+	bucket := int64(h.Sum32() % 10_000)
+	for _, v := range variants {
+		if bucket < v.WeightBP {
+			return v
+		}
+		bucket -= v.WeightBP
+	}
+	return variants[len(variants)-1]
+}
+
+// guardrailBreaches describes each guardrail a variant breaches against the
+// control, stats[0]. Both sides need MinUsers exposed users.
+func guardrailBreaches(guardrails []ExperimentGuardrail, stats []ExperimentVariantStats) []string {
+	out := []string{}
+	if len(stats) < 2 {
+		return out
+	}
+	control := stats[0]
+	for _, g := range guardrails {
+		if control.Users == 0 || control.Users < g.MinUsers {
+			continue
+		}
+		base := float64(control.Metrics[g.Metric]) / float64(control.Users)
+		if base == 0 {
+			continue
+		}
+		for _, s := range stats[1:] {
+			if s.Users == 0 || s.Users < g.MinUsers {
+				continue
+			}
+			change := (float64(s.Metrics[g.Metric])/float64(s.Users) - base) / base * 100
+			harm := -change
+			if g.Metric == MetricWithdrawals {
+				harm = change
+			}
+			if harm > float64(g.MaxHarmPct) {
+				out = append(out, fmt.Sprintf("%s: %s %+.1f%% per user vs %s", s.Variant, g.Metric, change, control.Variant))
+			}
+		}
+	}
+	return out
+}
+
+const experimentCols = `key, description, status, variants, guardrails, stop_reason, created_by, started_at, stopped_at, created_at`
+
+func scanExperiment(row pgx.Row) (Experiment, error) {
+	var e Experiment
+	err := row.Scan(&e.Key, &e.Description, &e.Status, &e.Variants, &e.Guardrails, &e.StopReason, &e.CreatedBy, &e.StartedAt, &e.StoppedAt, &e.CreatedAt)
+	return e, err
+}
+
+// CreateExperiment saves a draft experiment.
+func (d *DB) CreateExperiment(ctx context.Context, adminID int64, e Experiment) (Experiment, error) {
+	if e.Guardrails == nil {
+		e.Guardrails = []ExperimentGuardrail{}
+	}
+	if err := e.validate(); err != nil {
+		return Experiment{}, err
+	}
+	out, err := scanExperiment(d.Pool.QueryRow(ctx, `
+INSERT INTO experiments (key, description, status, variants, guardrails, created_by)
+VALUES ($1, $2, 'draft', $3::jsonb, $4::jsonb, $5)
+ON CONFLICT (key) DO NOTHING
+RETURNING `+experimentCols, e.Key, e.Description, toJSON(e.Variants), toJSON(e.Guardrails), adminID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Experiment{}, ErrAlreadyExists
+	}
+	return out, err
+}
+
+func (d *DB) GetExperiment(ctx context.Context, key string) (Experiment, error) {
+	return scanExperiment(d.Pool.QueryRow(ctx, `SELECT `+experimentCols+` FROM experiments WHERE key=$1`, key))
+}
+
+func (d *DB) ListExperiments(ctx context.Context) ([]Experiment, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT `+experimentCols+` FROM experiments ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Experiment{}
+	for rows.Next() {
+		e, err := scanExperiment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// StartExperiment runs a draft experiment.
+func (d *DB) StartExperiment(ctx context.Context, adminID int64, key string) error {
+	return d.setExperimentStatus(ctx, adminID, key, ExperimentDraft, ExperimentRunning, "")
+}
+
+// StopExperiment ends a running experiment for good; a stopped experiment
+// cannot be restarted, so its exposures stay comparable. adminID 0 is the
+// guardrail job.
+func (d *DB) StopExperiment(ctx context.Context, adminID int64, key, reason string) error {
+	return d.setExperimentStatus(ctx, adminID, key, ExperimentRunning, ExperimentStopped, reason)
+}
+
+func (d *DB) setExperimentStatus(ctx context.Context, adminID int64, key, from, to, reason string) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		if err := tx.QueryRow(ctx, `SELECT status FROM experiments WHERE key=$1 FOR UPDATE`, key).Scan(&status); err != nil {
+			return err
+		}
+		if status != from {
+			return ErrLocked
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE experiments
+SET status=$2, stop_reason=$3,
+    started_at=CASE WHEN $2='running' THEN now() ELSE started_at END,
+    stopped_at=CASE WHEN $2='stopped' THEN now() ELSE stopped_at END
+WHERE key=$1
+`, key, to, reason); err != nil {
+			return err
+		}
+		var by *int64
+		if adminID > 0 {
+			by = &adminID
+		}
+		kind := "experiment_start"
+		if to == ExperimentStopped {
+			kind = "experiment_stop"
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES($1, $2, NULL, 0, $3::jsonb)`,
+			kind, by, toJSON(map[string]any{"key": key, "reason": reason}))
+		return err
+	})
+}
+
+// ExposeExperiment returns userID's variant of a running experiment and logs
+// the exposure; ok is false when the experiment is not running.
+func (d *DB) ExposeExperiment(ctx context.Context, key string, userID int64) (v ExperimentVariant, ok bool, err error) {
+	e, err := scanExperiment(d.Pool.QueryRow(ctx, `SELECT `+experimentCols+` FROM experiments WHERE key=$1 AND status='running'`, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ExperimentVariant{}, false, nil
+	}
+	if err != nil {
+		return ExperimentVariant{}, false, err
+	}
+	v = assignVariant(e.Key, userID, e.Variants)
+	if _, err := d.Pool.Exec(ctx, `
+INSERT INTO experiment_exposures (experiment_key, user_id, variant) VALUES ($1, $2, $3)
+ON CONFLICT (experiment_key, user_id) DO NOTHING
+`, key, userID, v.Name); err != nil {
+		return ExperimentVariant{}, false, err
+	}
+	return v, true, nil
+}
+
+// ExperimentReport returns the experiment with its per-variant stats.
+func (d *DB) ExperimentReport(ctx context.Context, key string) (ExperimentReport, error) {
+	e, err := d.GetExperiment(ctx, key)
+	if err != nil {
+		return ExperimentReport{}, err
+	}
+	out := ExperimentReport{Experiment: e}
+	for _, v := range e.Variants {
+		out.Stats = append(out.Stats, ExperimentVariantStats{Variant: v.Name, Metrics: map[string]int64{}})
+	}
+	idx := map[string]int{}
+	for i, s := range out.Stats {
+		idx[s.Variant] = i
+	}
+	if err := d.experimentUsers(ctx, key, out.Stats, idx); err != nil {
+		return ExperimentReport{}, err
+	}
+	for _, metric := range []string{MetricSpend, MetricDeposits, MetricWithdrawals} {
+		rows, err := d.Pool.Query(ctx, `
+SELECT e.variant, COALESCE(SUM(m.total), 0)::bigint
+FROM experiment_exposures e
+CROSS JOIN LATERAL (SELECT SUM(l.amount) AS total FROM ledger l WHERE `+experimentMetrics[metric]+` AND l.ts >= e.first_at) m
+WHERE e.experiment_key=$1
+GROUP BY e.variant
+`, key)
+		if err != nil {
+			return ExperimentReport{}, err
+		}
+		for rows.Next() {
+			var variant string
+			var total int64
+			if err := rows.Scan(&variant, &total); err != nil {
+				rows.Close()
+				return ExperimentReport{}, err
+			}
+			if i, ok := idx[variant]; ok {
+				out.Stats[i].Metrics[metric] = total
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return ExperimentReport{}, err
+		}
+	}
+	out.Breaches = guardrailBreaches(e.Guardrails, out.Stats)
+	return out, nil
+}
+
+func (d *DB) experimentUsers(ctx context.Context, key string, stats []ExperimentVariantStats, idx map[string]int) error {
+	rows, err := d.Pool.Query(ctx, `SELECT variant, COUNT(*) FROM experiment_exposures WHERE experiment_key=$1 GROUP BY variant`, key)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant string
+		var n int64
+		if err := rows.Scan(&variant, &n); err != nil {
+			return err
+		}
+		if i, ok := idx[variant]; ok {
+			stats[i].Users = n
+		}
+	}
+	return rows.Err()
+}
+
+// CheckExperimentGuardrails stops running experiments that breach a
+// guardrail (scheduled job).
+func (d *DB) CheckExperimentGuardrails(ctx context.Context) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT key FROM experiments WHERE status='running' AND guardrails <> '[]'::jsonb ORDER BY key`)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var stopped int64
+	for _, k := range keys {
+		rep, err := d.ExperimentReport(ctx, k)
+		if err != nil {
+			return stopped, err
+		}
+		if len(rep.Breaches) == 0 {
+			continue
+		}
+		if err := d.StopExperiment(ctx, 0, k, "guardrail: "+rep.Breaches[0]); err != nil && !errors.Is(err, ErrLocked) {
+			return stopped, err
+		}
+		stopped++
+	}
+	return stopped, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import "testing"
+
+func TestAssignVariant(t *testing.T) {
+	variants := []ExperimentVariant{{Name: "control", WeightBP: 8_000}, {Name: "cheap", WeightBP: 2_000}}
+	counts := map[string]int{}
+	for id := int64(1); id <= 10_000; id++ {
+		v := assignVariant("drop_price", id, variants)
+		if assignVariant("drop_price", id, variants).Name != v.Name {
+			t.Fatal("assignment is not stable")
+		}
+		counts[v.Name]++
+	}
+	if counts["cheap"] < 1_700 || counts["cheap"] > 2_300 {
+		t.Fatalf("cheap got %d of 10000 at 20%%", counts["cheap"])
+	}
+}
+
+func TestExperimentValidate(t *testing.T) {
+	ok := Experiment{Key: "drop_price", Variants: []ExperimentVariant{{Name: "a", WeightBP: 5_000}, {Name: "b", WeightBP: 5_000}},
+		Guardrails: []ExperimentGuardrail{{Metric: MetricSpend, MaxHarmPct: 10}}}
+	if err := ok.validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []Experiment{
+		{Key: "Drop Price", Variants: ok.Variants},
+		{Key: "x1", Variants: ok.Variants[:1]},
+		{Key: "x1", Variants: []ExperimentVariant{{Name: "a", WeightBP: 5_000}, {Name: "a", WeightBP: 5_000}}},
+		{Key: "x1", Variants: []ExperimentVariant{{Name: "a", WeightBP: 5_000}, {Name: "b", WeightBP: 4_000}}},
+		{Key: "x1", Variants: ok.Variants, Guardrails: []ExperimentGuardrail{{Metric: "revenue", MaxHarmPct: 10}}},
+		{Key: "x1", Variants: ok.Variants, Guardrails: []ExperimentGuardrail{{Metric: MetricSpend}}},
+	}
+	for i, e := range bad {
+		if err := e.validate(); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestGuardrailBreaches(t *testing.T) {
+	stats := []ExperimentVariantStats{
+		{Variant: "control", Users: 100, Metrics: map[string]int64{MetricSpend: 10_000, MetricWithdrawals: 1_000}},
+		{Variant: "b", Users: 100, Metrics: map[string]int64{MetricSpend: 8_500, MetricWithdrawals: 1_050}},
+		{Variant: "c", Users: 10, Metrics: map[string]int64{MetricSpend: 0}},
+	}
+	g := []ExperimentGuardrail{
+		{Metric: MetricSpend, MaxHarmPct: 10, MinUsers: 50},
+		{Metric: MetricWithdrawals, MaxHarmPct: 10, MinUsers: 50},
+	}
+	got := guardrailBreaches(g, stats)
+	if len(got) != 1 || got[0] != "b: spend -15.0% per user vs control" {
+		t.Fatalf("breaches %q", got)
+	}
+	stats[1].Metrics[MetricWithdrawals] = 1_200
+	if got := guardrailBreaches(g, stats); len(got) != 2 {
+		t.Fatalf("breaches %q, want spend and withdrawals", got)
+	}
+}
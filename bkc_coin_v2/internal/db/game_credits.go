@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Game credits are a separate in-app unit, not part of the BKC supply (the
+// credits package is BKC loans, unrelated). Users buy credits with BKC at
+// BuyRate credits per BKC and sell them back at SellRate credits per BKC; a
+// SellRate above BuyRate is the house spread, so a round trip never gains.
+// Bought BKC go to the reserve and sold credits are paid from it, at most
+// SellDailyLimit BKC per user and UTC day. Credits cannot be transferred or
+// withdrawn: they are only staked in games, and a game bet in credits wins
+// credits.
+//
+// Ledger: credits_buy (from the user, amount in BKC) and credits_sell (to
+// the user, amount in BKC); credits_bet and credits_win carry no BKC. Every
+// row has the credits moved in meta.credits.
+
+// ErrCreditsSellLimit means a sale of credits would exceed the daily limit.
+var ErrCreditsSellLimit = errors.New("daily credits sell limit reached")
+
+// GameCreditRates are the conversion terms, set by an admin.
+type GameCreditRates struct {
+	BuyRate        int64      `json:"buy_rate"`         // credits per BKC paid
+	SellRate       int64      `json:"sell_rate"`        // credits per BKC received
+	SellDailyLimit int64      `json:"sell_daily_limit"` // BKC per user and UTC day, 0 = no limit
+	Enabled        bool       `json:"enabled"`
+	UpdatedBy      *int64     `json:"updated_by,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+func (r GameCreditRates) validate() error {
+	if r.BuyRate <= 0 || r.SellRate < r.BuyRate || r.SellDailyLimit < 0 {
+		return errors.New("bad rates: need buy_rate > 0, sell_rate >= buy_rate, sell_daily_limit >= 0")
+	}
+	return nil
+}
+
+// creditsForBKC is what bkc buy.
+func (r GameCreditRates) creditsForBKC(bkc int64) int64 {
+	return bkc * r.BuyRate
+}
+
+// bkcForCredits is what credits sell for, and the credits that takes: only
+// whole BKC are paid, the remainder stays on the credits balance.
+func (r GameCreditRates) bkcForCredits(credits int64) (bkc, used int64) {
+	bkc = credits / r.SellRate
+	return bkc, bkc * r.SellRate
+}
+
+// GameCredits is a user's credits balance and the current terms.
+type GameCredits struct {
+	Credits       int64           `json:"credits"`
+	SoldToday     int64           `json:"sold_today"` // BKC
+	Rates         GameCreditRates `json:"rates"`
+	SellableToday int64           `json:"sellable_today"` // BKC left of the daily limit, -1 = no limit
+}
+
+// GameCreditsConversion is the result of a buy or a sale.
+type GameCreditsConversion struct {
+	BKC     int64 `json:"bkc"`
+	Credits int64 `json:"credits"`
+	Rate    int64 `json:"rate"`
+	Balance int64 `json:"balance"` // BKC after the conversion
+	Total   int64 `json:"total"`   // credits after the conversion
+}
+
+// EnsureGameCreditRates seeds the terms from the config on first start;
+// afterwards they are changed only by SetGameCreditRates.
+func (d *DB) EnsureGameCreditRates(ctx context.Context, r GameCreditRates) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO game_credit_rates(id, buy_rate, sell_rate, sell_daily_limit) VALUES(1, $1, $2, $3)
+ON CONFLICT (id) DO NOTHING
+`, r.BuyRate, r.SellRate, r.SellDailyLimit)
+	return err
+}
+
+func getGameCreditRates(ctx context.Context, q rowQuerier) (GameCreditRates, error) {
+	var r GameCreditRates
+	err := q.QueryRow(ctx, `
+SELECT buy_rate, sell_rate, sell_daily_limit, enabled, updated_by, updated_at FROM game_credit_rates WHERE id = 1
+`).Scan(&r.BuyRate, &r.SellRate, &r.SellDailyLimit, &r.Enabled, &r.UpdatedBy, &r.UpdatedAt)
+	return r, err
+}
+
+// GetGameCreditRates returns the current terms.
+func (d *DB) GetGameCreditRates(ctx context.Context) (GameCreditRates, error) {
+	return getGameCreditRates(ctx, d.Pool)
+}
+
+// SetGameCreditRates changes the terms; Enabled false stops conversions but
+// not games.
+func (d *DB) SetGameCreditRates(ctx context.Context, adminID int64, r GameCreditRates) (GameCreditRates, error) {
+	if err := r.validate(); err != nil {
+		return GameCreditRates{}, err
+	}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		return setGameCreditRatesTx(ctx, tx, adminID, r)
+	})
+	if err != nil {
+		return GameCreditRates{}, err
+	}
+	return d.GetGameCreditRates(ctx)
+}
+
+func setGameCreditRatesTx(ctx context.Context, tx pgx.Tx, adminID int64, r GameCreditRates) error {
+	if _, err := tx.Exec(ctx, `
+UPDATE game_credit_rates SET buy_rate = $1, sell_rate = $2, sell_daily_limit = $3, enabled = $4, updated_by = $5, updated_at = now()
+WHERE id = 1
+`, r.BuyRate, r.SellRate, r.SellDailyLimit, r.Enabled, adminID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_credit_rates', $1, NULL, 0, $2::jsonb)`,
+		adminID, toJSON(map[string]any{"buy_rate": r.BuyRate, "sell_rate": r.SellRate, "sell_daily_limit": r.SellDailyLimit, "enabled": r.Enabled}))
+	return err
+}
+
+// creditsSoldTodayTx is the BKC userID got for credits since the start of
+// the UTC day.
+func creditsSoldTodayTx(ctx context.Context, q rowQuerier, userID int64, now time.Time) (int64, error) {
+	var sold int64
+	err := q.QueryRow(ctx, `
+SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE kind = 'credits_sell' AND to_id = $1 AND ts >= $2
+`, userID, dayUTC(now)).Scan(&sold)
+	return sold, err
+}
+
+// GetGameCredits returns the user's credits, the terms and what is left of
+// today's sell limit.
+func (d *DB) GetGameCredits(ctx context.Context, userID int64) (GameCredits, error) {
+	var out GameCredits
+	var err error
+	if out.Rates, err = d.GetGameCreditRates(ctx); err != nil {
+		return out, err
+	}
+	if err := d.Pool.QueryRow(ctx, `SELECT game_credits FROM users WHERE user_id = $1`, userID).Scan(&out.Credits); err != nil {
+		return out, err
+	}
+	if out.SoldToday, err = creditsSoldTodayTx(ctx, d.Pool, userID, time.Now()); err != nil {
+		return out, err
+	}
+	out.SellableToday = -1
+	if out.Rates.SellDailyLimit > 0 {
+		out.SellableToday = max(out.Rates.SellDailyLimit-out.SoldToday, 0)
+	}
+	return out, nil
+}
+
+// BuyGameCredits converts bkc of the user's spendable balance into credits;
+// the BKC go to the reserve.
+func (d *DB) BuyGameCredits(ctx context.Context, userID, bkc int64) (GameCreditsConversion, error) {
+	if bkc <= 0 {
+		return GameCreditsConversion{}, errors.New("bad amount")
+	}
+	var out GameCreditsConversion
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		r, err := getGameCreditRates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !r.Enabled {
+			return errors.New("bad request: credits conversion is paused")
+		}
+		if err := debitSpendableTx(ctx, tx, userID, bkc); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at = now() WHERE id=1`, bkc); err != nil {
+			return err
+		}
+		out = GameCreditsConversion{BKC: bkc, Credits: r.creditsForBKC(bkc), Rate: r.BuyRate}
+		if err := tx.QueryRow(ctx, `
+UPDATE users SET game_credits = game_credits + $1 WHERE user_id = $2 RETURNING balance, game_credits
+`, out.Credits, userID).Scan(&out.Balance, &out.Total); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('credits_buy', $1, NULL, $2, $3::jsonb)`,
+			userID, bkc, toJSON(map[string]any{"credits": out.Credits, "rate": r.BuyRate}))
+		return err
+	})
+	return out, err
+}
+
+// SellGameCredits converts credits back to BKC paid from the reserve, within
+// today's emission (ErrEmissionCap); only whole BKC are paid, the remainder
+// of credits stays.
+func (d *DB) SellGameCredits(ctx context.Context, userID, credits int64) (GameCreditsConversion, error) {
+	if credits <= 0 {
+		return GameCreditsConversion{}, errors.New("bad amount")
+	}
+	var out GameCreditsConversion
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		r, err := getGameCreditRates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !r.Enabled {
+			return errors.New("bad request: credits conversion is paused")
+		}
+		bkc, used := r.bkcForCredits(credits)
+		if bkc == 0 {
+			return errors.New("bad amount: below one BKC")
+		}
+
+		var have int64
+		if err := tx.QueryRow(ctx, `SELECT game_credits FROM users WHERE user_id = $1 FOR UPDATE`, userID).Scan(&have); err != nil {
+			return err
+		}
+		if have < used {
+			return ErrNotEnough
+		}
+		if r.SellDailyLimit > 0 {
+			sold, err := creditsSoldTodayTx(ctx, tx, userID, time.Now())
+			if err != nil {
+				return err
+			}
+			if sold+bkc > r.SellDailyLimit {
+				return ErrCreditsSellLimit
+			}
+		}
+
+		var reserve, reserved int64
+		if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+			return err
+		}
+		if reserve-reserved < bkc {
+			return ErrNotEnough
+		}
+		if err := chargeEmissionTx(ctx, tx, bkc); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply - $1, updated_at = now() WHERE id=1`, bkc); err != nil {
+			return err
+		}
+		out = GameCreditsConversion{BKC: bkc, Credits: used, Rate: r.SellRate}
+		if err := tx.QueryRow(ctx, `
+UPDATE users SET game_credits = game_credits - $1, balance = balance + $2 WHERE user_id = $3 RETURNING balance, game_credits
+`, used, bkc, userID).Scan(&out.Balance, &out.Total); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('credits_sell', NULL, $1, $2, $3::jsonb)`,
+			userID, bkc, toJSON(map[string]any{"credits": used, "rate": r.SellRate}))
+		return err
+	})
+	return out, err
+}
+
+// CreditsStakeTx pays a game bet of credits from the user's credits balance
+// (ErrNotEnough if short) and returns its BKC value at the sell rate, for the
+// BKC bet limits. The game settles it with SettleCreditsBetTx or
+// SettleLostCreditsBetsTx under the same betRef.
+func CreditsStakeTx(ctx context.Context, tx pgx.Tx, userID int64, betRef string, credits int64) (int64, error) {
+	r, err := getGameCreditRates(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `UPDATE users SET game_credits = game_credits - $1 WHERE user_id = $2 AND game_credits >= $1`, credits, userID)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, ErrNotEnough
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO credit_bets(bet_ref, user_id, stake) VALUES($1, $2, $3)`, betRef, userID, credits); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('credits_bet', $1, NULL, 0, $2::jsonb)`,
+		userID, toJSON(map[string]any{"credits": credits, "bet_ref": betRef})); err != nil {
+		return 0, err
+	}
+	return (credits + r.SellRate - 1) / r.SellRate, nil
+}
+
+// CreditsBetTx reports whether betRef was staked in credits.
+func CreditsBetTx(ctx context.Context, tx pgx.Tx, betRef string) (bool, error) {
+	var ok bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM credit_bets WHERE bet_ref = $1)`, betRef).Scan(&ok)
+	return ok, err
+}
+
+// SettleCreditsBetTx pays win credits for a winning credits bet. False for a
+// bet not staked in credits: the caller pays it in BKC.
+func SettleCreditsBetTx(ctx context.Context, tx pgx.Tx, betRef string, win int64) (bool, error) {
+	var userID int64
+	err := tx.QueryRow(ctx, `
+UPDATE credit_bets SET settled_at = now() WHERE bet_ref = $1 AND settled_at IS NULL RETURNING user_id
+`, betRef).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if win <= 0 {
+		return true, nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET game_credits = game_credits + $1 WHERE user_id = $2`, win, userID); err != nil {
+		return true, err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('credits_win', NULL, $1, 0, $2::jsonb)`,
+		userID, toJSON(map[string]any{"credits": win, "bet_ref": betRef}))
+	return true, err
+}
+
+// SettleLostCreditsBetsTx settles lost bets and returns the credits they
+// staked, so the game can keep them out of its BKC totals.
+func SettleLostCreditsBetsTx(ctx context.Context, tx pgx.Tx, betRefs []string) (int64, error) {
+	if len(betRefs) == 0 {
+		return 0, nil
+	}
+	var staked int64
+	err := tx.QueryRow(ctx, `
+WITH s AS (
+  UPDATE credit_bets SET settled_at = now() WHERE bet_ref = ANY($1) AND settled_at IS NULL RETURNING stake
+)
+SELECT COALESCE(SUM(stake), 0) FROM s
+`, betRefs).Scan(&staked)
+	return staked, err
+}
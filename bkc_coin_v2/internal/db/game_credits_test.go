@@ -0,0 +1,31 @@
+package db
+
+import "testing"
+
+func TestGameCreditRates(t *testing.T) {
+	r := GameCreditRates{BuyRate: 100, SellRate: 105}
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.creditsForBKC(50); got != 5_000 {
+		t.Fatalf("50 BKC buy %d credits, want 5000", got)
+	}
+	// A round trip loses the spread and keeps the remainder as credits.
+	bkc, used := r.bkcForCredits(5_000)
+	if bkc != 47 || used != 4_935 {
+		t.Fatalf("5000 credits sell for %d BKC using %d, want 47 using 4935", bkc, used)
+	}
+	if bkc, used := r.bkcForCredits(104); bkc != 0 || used != 0 {
+		t.Fatalf("104 credits sell for %d BKC using %d", bkc, used)
+	}
+
+	for _, bad := range []GameCreditRates{
+		{BuyRate: 0, SellRate: 100},
+		{BuyRate: 100, SellRate: 99}, // selling above the buy price would print BKC
+		{BuyRate: 100, SellRate: 100, SellDailyLimit: -1},
+	} {
+		if bad.validate() == nil {
+			t.Fatalf("%+v accepted", bad)
+		}
+	}
+}
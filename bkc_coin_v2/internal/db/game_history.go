@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MaxGameHistoryRange is the longest date range of one history request.
+const MaxGameHistoryRange = 366 * 24 * time.Hour
+
+// GameBet is one bet of a player as the ledger has it: what was staked on
+// it (insurance premiums included) and what came back.
+type GameBet struct {
+	Game      string    `json:"game"`
+	BetID     string    `json:"bet_id"`
+	RoundID   string    `json:"round_id,omitempty"`
+	Staked    int64     `json:"staked"`
+	Won       int64     `json:"won"`
+	Net       int64     `json:"net"` // won - staked
+	PlacedAt  time.Time `json:"placed_at"`
+	SettledAt time.Time `json:"settled_at"` // last ledger row of the bet
+}
+
+// GamePnL sums a player's bets in one game; Game "" is the total.
+type GamePnL struct {
+	Game       string `json:"game"`
+	Bets       int64  `json:"bets"`
+	Staked     int64  `json:"staked"`
+	Won        int64  `json:"won"`
+	Net        int64  `json:"net"`
+	BiggestWin int64  `json:"biggest_win"`
+}
+
+// gameBetsCTE groups the player's ledger rows of GameBooks kinds by bet.
+// $1 user, $2 from, $3 to, $4 kinds, $5 games, $6 stake flags, $7 game ("" = all).
+const gameBetsCTE = `
+WITH book AS (
+  SELECT * FROM unnest($4::text[], $5::text[], $6::bool[]) AS b(kind, game, stake)
+), bets AS (
+  SELECT b.game, COALESCE(l.meta->>'bet_id', l.id::text) AS bet_id, MAX(l.meta->>'game_id') AS round_id,
+         COALESCE(SUM(l.amount) FILTER (WHERE b.stake AND l.from_id = $1), 0) AS staked,
+         COALESCE(SUM(l.amount) FILTER (WHERE NOT b.stake AND l.to_id = $1), 0) AS won,
+         MIN(l.ts) AS placed_at, MAX(l.ts) AS settled_at
+  FROM ledger l JOIN book b ON b.kind = l.kind
+  WHERE (l.from_id = $1 OR l.to_id = $1) AND l.ts >= $2 AND l.ts < $3 AND ($7::text = '' OR b.game = $7)
+  GROUP BY b.game, COALESCE(l.meta->>'bet_id', l.id::text)
+)
+`
+
+func gameHistoryArgs(userID int64, game string, from, to time.Time) ([]any, error) {
+	if game != "" {
+		if _, ok := GameBookOf(game); !ok {
+			return nil, errors.New("bad game")
+		}
+	}
+	if !to.After(from) || to.Sub(from) > MaxGameHistoryRange {
+		return nil, errors.New("bad range")
+	}
+	var kinds, games []string
+	var stake []bool
+	for _, b := range GameBooks {
+		for _, k := range b.Stakes {
+			kinds, games, stake = append(kinds, k), append(games, b.Game), append(stake, true)
+		}
+		for _, k := range b.Wins {
+			kinds, games, stake = append(kinds, k), append(games, b.Game), append(stake, false)
+		}
+	}
+	return []any{userID, from, to, kinds, games, stake, game}, nil
+}
+
+// EachGameBet calls fn for the player's bets with ledger rows in [from, to),
+// newest first, at most limit of them (0 = all). A bet whose rows straddle a
+// bound counts only the rows inside.
+func (d *DB) EachGameBet(ctx context.Context, userID int64, game string, from, to time.Time, limit int, fn func(GameBet) error) error {
+	args, err := gameHistoryArgs(userID, game, from, to)
+	if err != nil {
+		return err
+	}
+	rows, err := d.Pool.Query(ctx, gameBetsCTE+`
+SELECT game, bet_id, COALESCE(round_id, ''), staked, won, placed_at, settled_at
+FROM bets
+ORDER BY placed_at DESC, bet_id
+LIMIT NULLIF($8::int, 0)
+`, append(args, limit)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b GameBet
+		if err := rows.Scan(&b.Game, &b.BetID, &b.RoundID, &b.Staked, &b.Won, &b.PlacedAt, &b.SettledAt); err != nil {
+			return err
+		}
+		b.Net = b.Won - b.Staked
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GamePnLSummary sums the player's bets in [from, to) per game, ordered as
+// GameBooks and followed by the total (Game "").
+func (d *DB) GamePnLSummary(ctx context.Context, userID int64, game string, from, to time.Time) ([]GamePnL, error) {
+	args, err := gameHistoryArgs(userID, game, from, to)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.Pool.Query(ctx, gameBetsCTE+`
+SELECT COALESCE(game, ''), COUNT(*), COALESCE(SUM(staked), 0), COALESCE(SUM(won), 0), COALESCE(MAX(won), 0)
+FROM bets
+GROUP BY ROLLUP(game)
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byGame := map[string]GamePnL{}
+	for rows.Next() {
+		var p GamePnL
+		if err := rows.Scan(&p.Game, &p.Bets, &p.Staked, &p.Won, &p.BiggestWin); err != nil {
+			return nil, err
+		}
+		p.Net = p.Won - p.Staked
+		byGame[p.Game] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var out []GamePnL
+	for _, b := range GameBooks {
+		if game != "" && b.Game != game {
+			continue
+		}
+		p, ok := byGame[b.Game]
+		if !ok {
+			p = GamePnL{Game: b.Game}
+		}
+		out = append(out, p)
+	}
+	return append(out, byGame[""]), nil
+}
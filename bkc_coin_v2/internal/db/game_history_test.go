@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGameHistory(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const user = int64(990_451)
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM ledger WHERE meta->>'test' = 'game_history'`)
+	})
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if _, err := d.GamePnLSummary(ctx, user, "roulette", from, to); err == nil {
+		t.Fatal("unknown game accepted")
+	}
+	if _, err := d.GamePnLSummary(ctx, user, "", to, from); err == nil {
+		t.Fatal("inverted range accepted")
+	}
+	if _, err := d.GamePnLSummary(ctx, user, "", from, from.Add(MaxGameHistoryRange+time.Hour)); err == nil {
+		t.Fatal("too long range accepted")
+	}
+
+	// Bet b1 lost with an insurance payout, b2 won; someone else's win is not counted.
+	u, other := user, user+1
+	for _, row := range []struct {
+		kind     string
+		from, to *int64
+		amount   int64
+		bet      string
+	}{
+		{"crash_bet", &u, nil, 500, "b1"},
+		{"crash_insurance_premium", &u, nil, 20, "b1"},
+		{"crash_insurance_payout", nil, &u, 250, "b1"},
+		{"crash_bet", &u, nil, 100, "b2"},
+		{"crash_win", nil, &u, 300, "b2"},
+		{"crash_win", nil, &other, 999, "b3"},
+	} {
+		if _, err := d.Pool.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES($1, $2, $3, $4, jsonb_build_object('test', 'game_history', 'bet_id', $5::text, 'game_id', 'g1'))`,
+			row.kind, row.from, row.to, row.amount, row.bet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bets := map[string]GameBet{}
+	if err := d.EachGameBet(ctx, user, "", from, to, 0, func(b GameBet) error {
+		bets[b.BetID] = b
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bets) != 2 {
+		t.Fatalf("bets %+v", bets)
+	}
+	if b := bets["b1"]; b.Game != "crash" || b.RoundID != "g1" || b.Staked != 520 || b.Won != 250 || b.Net != -270 {
+		t.Fatalf("b1 %+v", b)
+	}
+	if b := bets["b2"]; b.Staked != 100 || b.Won != 300 || b.Net != 200 {
+		t.Fatalf("b2 %+v", b)
+	}
+
+	summary, err := d.GamePnLSummary(ctx, user, "", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary) != len(GameBooks)+1 {
+		t.Fatalf("summary %+v", summary)
+	}
+	total := summary[len(summary)-1]
+	if total.Game != "" || total.Bets != 2 || total.Staked != 620 || total.Won != 550 || total.Net != -70 || total.BiggestWin != 300 {
+		t.Fatalf("total %+v", total)
+	}
+
+	// An empty range still has the per-game rows and a zero total.
+	summary, err = d.GamePnLSummary(ctx, user, "crash", to, to.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary) != 2 || summary[0].Game != "crash" || summary[0].Bets != 0 || summary[1].Bets != 0 {
+		t.Fatalf("empty summary %+v", summary)
+	}
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Gifts: the sender locks an amount (taken off the balance and held by the
+// gift row) with an optional message and gets a one-time claim code, shared
+// as a bot link (?start=gift_<code>). Whoever claims it first gets the
+// amount; a gift nobody claims before expiry goes back to the sender. Only
+// the hash of the code is stored.
+
+// GiftLinkPrefix starts a claim code in a bot start payload.
+const GiftLinkPrefix = "gift_"
+
+// Gift statuses.
+const (
+	GiftPending  = "pending"
+	GiftClaimed  = "claimed"
+	GiftRefunded = "refunded"
+)
+
+const (
+	MaxGiftMessage  = 280 // runes
+	giftExpireBatch = 1_000
+)
+
+// ErrGiftUnavailable is a claim of a gift already claimed, refunded or expired.
+var ErrGiftUnavailable = errors.New("gift already claimed or expired")
+
+// Gift is a locked amount waiting for (or given to) a recipient.
+type Gift struct {
+	GiftID    int64      `json:"gift_id"`
+	SenderID  int64      `json:"sender_id"`
+	Amount    int64      `json:"amount"`
+	Message   string     `json:"message"`
+	Status    string     `json:"status"`
+	ClaimedBy *int64     `json:"claimed_by,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const giftCols = `gift_id, sender_id, amount, message, status, claimed_by, expires_at, closed_at, created_at`
+
+func scanGift(row pgx.Row) (Gift, error) {
+	var g Gift
+	err := row.Scan(&g.GiftID, &g.SenderID, &g.Amount, &g.Message, &g.Status, &g.ClaimedBy, &g.ExpiresAt, &g.ClosedAt, &g.CreatedAt)
+	return g, err
+}
+
+func hashGiftCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeGiftCode accepts the bare code or the whole start payload.
+func normalizeGiftCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.TrimPrefix(code, GiftLinkPrefix)
+}
+
+// CreateGift locks amount of the sender's spendable balance for ttl. Returns
+// the gift and the plaintext claim code; the code is not shown again. The
+// gift is a transfer to whoever claims it, so vp applies as to Transfer.
+func (d *DB) CreateGift(ctx context.Context, senderID, amount int64, message string, ttl time.Duration, vp VelocityPolicy) (Gift, string, error) {
+	message = strings.TrimSpace(message)
+	if senderID <= 0 || amount <= 0 || ttl <= 0 {
+		return Gift{}, "", errors.New("bad params")
+	}
+	if !utf8.ValidString(message) || utf8.RuneCountInString(message) > MaxGiftMessage {
+		return Gift{}, "", errors.New("bad message")
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Gift{}, "", err
+	}
+	code := hex.EncodeToString(buf)
+	var out Gift
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := lockUsersTx(ctx, tx, senderID); err != nil {
+			return err
+		}
+		if err := checkVelocityTx(ctx, tx, senderID, 0, amount, vp); err != nil {
+			return err
+		}
+		if err := debitSpendableTx(ctx, tx, senderID, amount); err != nil {
+			return err
+		}
+		var err error
+		out, err = scanGift(tx.QueryRow(ctx, `
+INSERT INTO gifts(code_hash, sender_id, amount, message, expires_at)
+VALUES($1, $2, $3, $4, $5)
+RETURNING `+giftCols,
+			hashGiftCode(code), senderID, amount, message, time.Now().UTC().Add(ttl)))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gift_lock', $1, NULL, $2, $3::jsonb)`,
+			senderID, amount, toJSON(map[string]any{"gift_id": out.GiftID}))
+		return err
+	})
+	var ve *VelocityError
+	if errors.As(err, &ve) {
+		if aerr := d.recordVelocityAlert(ctx, senderID, 0, amount, ve); aerr != nil {
+			return Gift{}, "", aerr
+		}
+	}
+	if err != nil {
+		return Gift{}, "", err
+	}
+	return out, code, nil
+}
+
+// ClaimGift credits the gift of code to userID. pgx.ErrNoRows for an unknown
+// code, ErrGiftUnavailable once it is claimed, refunded or past expiry.
+func (d *DB) ClaimGift(ctx context.Context, userID int64, code string) (Gift, error) {
+	code = normalizeGiftCode(code)
+	if userID <= 0 || code == "" {
+		return Gift{}, errors.New("bad params")
+	}
+	var out Gift
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		g, err := scanGift(tx.QueryRow(ctx, `SELECT `+giftCols+` FROM gifts WHERE code_hash=$1 FOR UPDATE`, hashGiftCode(code)))
+		if err != nil {
+			return err
+		}
+		if g.Status != GiftPending || !time.Now().Before(g.ExpiresAt) {
+			return ErrGiftUnavailable
+		}
+		if g.SenderID == userID {
+			return errors.New("bad recipient: own gift")
+		}
+		tag, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, g.Amount, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		out, err = scanGift(tx.QueryRow(ctx, `
+UPDATE gifts SET status='claimed', claimed_by=$2, closed_at=now() WHERE gift_id=$1
+RETURNING `+giftCols, g.GiftID, userID))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gift_claim', $1, $2, $3, $4::jsonb)`,
+			g.SenderID, userID, g.Amount, toJSON(map[string]any{"gift_id": g.GiftID})); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, g.SenderID, "gift_claimed", map[string]any{"gift_id": g.GiftID, "amount": g.Amount, "claimed_by": userID})
+	})
+	return out, err
+}
+
+// ListGifts returns gifts the user sent or claimed, newest first.
+func (d *DB) ListGifts(ctx context.Context, userID int64, limit int) ([]Gift, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+giftCols+` FROM gifts
+WHERE sender_id=$1 OR claimed_by=$1
+ORDER BY gift_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Gift{}
+	for rows.Next() {
+		g, err := scanGift(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// ExpireGifts returns unclaimed gifts past their expiry to the senders.
+func (d *DB) ExpireGifts(ctx context.Context, now time.Time) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT gift_id, sender_id, amount
+FROM gifts
+WHERE status='pending' AND expires_at <= $1
+ORDER BY gift_id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`, now, giftExpireBatch)
+		if err != nil {
+			return err
+		}
+		type expired struct{ giftID, senderID, amount int64 }
+		var list []expired
+		for rows.Next() {
+			var e expired
+			if err := rows.Scan(&e.giftID, &e.senderID, &e.amount); err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, e := range list {
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, e.amount, e.senderID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE gifts SET status='refunded', closed_at=$2 WHERE gift_id=$1`, e.giftID, now); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gift_refund', NULL, $1, $2, $3::jsonb)`,
+				e.senderID, e.amount, toJSON(map[string]any{"gift_id": e.giftID})); err != nil {
+				return err
+			}
+			if err := addUserEventTx(ctx, tx, e.senderID, "gift_refunded", map[string]any{"gift_id": e.giftID, "amount": e.amount}); err != nil {
+				return err
+			}
+		}
+		n = int64(len(list))
+		return nil
+	})
+	return n, err
+}
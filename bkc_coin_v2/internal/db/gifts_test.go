@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeGiftCode(t *testing.T) {
+	const code = "0f3a9c"
+	for _, in := range []string{code, " 0F3A9C ", GiftLinkPrefix + code, "GIFT_0f3a9c"} {
+		if got := normalizeGiftCode(in); got != code {
+			t.Fatalf("normalize(%q) = %q, want %q", in, got, code)
+		}
+	}
+	// The stored hash matches whichever form the recipient used.
+	if hashGiftCode(normalizeGiftCode(GiftLinkPrefix+code)) != hashGiftCode(code) {
+		t.Fatal("link and bare code hash differently")
+	}
+}
+
+func TestGiftVelocity(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const sender, other = 9_301_300_001, 9_301_300_002
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM gifts WHERE sender_id=$1`, sender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, sender, other)
+	t.Cleanup(cleanup)
+
+	// Gifts and transfers share the daily volume.
+	vp := VelocityPolicy{DailyVolume: 150}
+	if _, _, err := d.CreateGift(ctx, sender, 100, "", time.Hour, vp); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.CreateGift(ctx, sender, 100, "", time.Hour, vp); !errors.Is(err, ErrVelocity) {
+		t.Fatalf("second gift: %v", err)
+	}
+	if err := d.Transfer(ctx, sender, other, 100, vp); !errors.Is(err, ErrVelocity) {
+		t.Fatalf("transfer after a gift: %v", err)
+	}
+	u, err := d.GetUser(ctx, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Balance != 900 {
+		t.Fatalf("sender balance %d", u.Balance)
+	}
+}
+
+func TestGiftClaimAndRefund(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const sender, claimer = 9_301_300_011, 9_301_300_012
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM gifts WHERE sender_id=$1`, sender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, sender, claimer)
+	t.Cleanup(cleanup)
+
+	_, code, err := d.CreateGift(ctx, sender, 100, "", time.Hour, VelocityPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ClaimGift(ctx, sender, code); err == nil {
+		t.Fatal("sender claimed own gift")
+	}
+	g, err := d.ClaimGift(ctx, claimer, GiftLinkPrefix+code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Status != GiftClaimed {
+		t.Fatalf("status %q", g.Status)
+	}
+	if _, err := d.ClaimGift(ctx, claimer, code); !errors.Is(err, ErrGiftUnavailable) {
+		t.Fatalf("second claim: %v", err)
+	}
+	if got := checkLedger(t, d, claimer, 1_000); got != 1_100 {
+		t.Fatalf("claimer balance %d", got)
+	}
+
+	// An unclaimed gift goes back to the sender once it expires.
+	_, code, err = d.CreateGift(ctx, sender, 200, "", time.Minute, VelocityPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExpireGifts(ctx, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ClaimGift(ctx, claimer, code); !errors.Is(err, ErrGiftUnavailable) {
+		t.Fatalf("claim of a refunded gift: %v", err)
+	}
+	// gift_claim books the sender as the payer again, so the sender is
+	// checked by balance rather than against the ledger.
+	u, err := d.GetUser(ctx, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Balance != 900 {
+		t.Fatalf("sender balance %d", u.Balance)
+	}
+}
@@ -0,0 +1,467 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Gigs are freelance services sold through market listings of the services
+// category. A buyer hires the seller of such a listing on a contract split
+// into milestones, each with an amount and a deadline. The buyer funds a
+// milestone into escrow, the seller marks it delivered and the buyer
+// releases it; either side can dispute a funded milestone instead (see
+// disputes.go). A funded milestone not delivered by its deadline is refunded
+// to the buyer, a delivered one the buyer leaves unanswered for the review
+// window is released to the seller, and an unfunded one past its deadline is
+// cancelled.
+
+// ServicesCategory is the market listing category of gigs; its listings are
+// hired through contracts, not bought outright.
+const ServicesCategory = "services"
+
+// Gig milestone statuses.
+const (
+	GigPending   = "pending" // not funded yet
+	GigFunded    = "funded"
+	GigDelivered = "delivered"
+	GigDisputed  = "disputed"
+	GigReleased  = "released"
+	GigRefunded  = "refunded" // refunded in full or split by a dispute
+	GigCancelled = "cancelled"
+)
+
+const gigExpireBatch = 500
+
+// ErrGigState is an action the milestone's status does not allow.
+var ErrGigState = errors.New("milestone not in a state for this action")
+
+// GigContract is a hired gig and its milestones.
+type GigContract struct {
+	ContractID int64          `json:"contract_id"`
+	ListingID  int64          `json:"listing_id"`
+	BuyerID    int64          `json:"buyer_id"`
+	SellerID   int64          `json:"seller_id"`
+	Title      string         `json:"title"`
+	Status     string         `json:"status"` // open | closed
+	Milestones []GigMilestone `json:"milestones"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// GigMilestone is one escrowed step of a contract.
+type GigMilestone struct {
+	MilestoneID int64      `json:"milestone_id"`
+	ContractID  int64      `json:"contract_id"`
+	Seq         int64      `json:"seq"`
+	Title       string     `json:"title"`
+	Amount      int64      `json:"amount"`
+	Deadline    time.Time  `json:"deadline"`
+	Status      string     `json:"status"`
+	FundedAt    *time.Time `json:"funded_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+}
+
+// GigMilestoneInput is a milestone the buyer proposes.
+type GigMilestoneInput struct {
+	Title    string    `json:"title"`
+	Amount   int64     `json:"amount"`
+	Deadline time.Time `json:"deadline"`
+}
+
+const gigMilestoneCols = `milestone_id, contract_id, seq, title, amount, deadline, status, funded_at, delivered_at, closed_at`
+
+func scanGigMilestone(row pgx.Row) (GigMilestone, error) {
+	var m GigMilestone
+	err := row.Scan(&m.MilestoneID, &m.ContractID, &m.Seq, &m.Title, &m.Amount, &m.Deadline, &m.Status, &m.FundedAt, &m.DeliveredAt, &m.ClosedAt)
+	return m, err
+}
+
+func validateGigMilestones(ms []GigMilestoneInput, maxMilestones int, now time.Time) error {
+	if len(ms) == 0 || len(ms) > maxMilestones {
+		return errors.New("bad milestones: count")
+	}
+	for i := range ms {
+		ms[i].Title = strings.TrimSpace(ms[i].Title)
+		if ms[i].Title == "" || len(ms[i].Title) > 120 || ms[i].Amount <= 0 || !ms[i].Deadline.After(now) {
+			return errors.New("bad milestones")
+		}
+	}
+	return nil
+}
+
+// CreateGig hires the seller of an active services listing on milestones.
+func (d *DB) CreateGig(ctx context.Context, buyerID, listingID int64, milestones []GigMilestoneInput, maxMilestones int) (GigContract, error) {
+	if err := validateGigMilestones(milestones, maxMilestones, d.now()); err != nil {
+		return GigContract{}, err
+	}
+	var out GigContract
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var category, status string
+		if err := tx.QueryRow(ctx, `SELECT seller_id, title, category, status FROM market_listings WHERE listing_id=$1`, listingID).
+			Scan(&out.SellerID, &out.Title, &category, &status); err != nil {
+			return err
+		}
+		if category != ServicesCategory || status != "active" {
+			return errors.New("bad listing: not an active service")
+		}
+		if out.SellerID == buyerID {
+			return errors.New("bad listing: own service")
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO gig_contracts(listing_id, buyer_id, seller_id, title) VALUES($1, $2, $3, $4)
+RETURNING contract_id, status, created_at
+`, listingID, buyerID, out.SellerID, out.Title).Scan(&out.ContractID, &out.Status, &out.CreatedAt); err != nil {
+			return err
+		}
+		out.ListingID, out.BuyerID = listingID, buyerID
+		for i, m := range milestones {
+			gm, err := scanGigMilestone(tx.QueryRow(ctx, `
+INSERT INTO gig_milestones(contract_id, seq, title, amount, deadline) VALUES($1, $2, $3, $4, $5)
+RETURNING `+gigMilestoneCols, out.ContractID, i+1, m.Title, m.Amount, m.Deadline))
+			if err != nil {
+				return err
+			}
+			out.Milestones = append(out.Milestones, gm)
+		}
+		return addUserEventTx(ctx, tx, out.SellerID, "gig_hired", map[string]any{"contract_id": out.ContractID, "listing_id": listingID, "buyer_id": buyerID})
+	})
+	return out, err
+}
+
+// GetGig returns a contract of which userID is the buyer or the seller.
+func (d *DB) GetGig(ctx context.Context, userID, contractID int64) (GigContract, error) {
+	var out GigContract
+	err := d.Pool.QueryRow(ctx, `
+SELECT contract_id, listing_id, buyer_id, seller_id, title, status, created_at
+FROM gig_contracts WHERE contract_id=$1 AND (buyer_id=$2 OR seller_id=$2)
+`, contractID, userID).Scan(&out.ContractID, &out.ListingID, &out.BuyerID, &out.SellerID, &out.Title, &out.Status, &out.CreatedAt)
+	if err != nil {
+		return GigContract{}, err
+	}
+	rows, err := d.Pool.Query(ctx, `SELECT `+gigMilestoneCols+` FROM gig_milestones WHERE contract_id=$1 ORDER BY seq`, contractID)
+	if err != nil {
+		return GigContract{}, err
+	}
+	defer rows.Close()
+	out.Milestones = []GigMilestone{}
+	for rows.Next() {
+		m, err := scanGigMilestone(rows)
+		if err != nil {
+			return GigContract{}, err
+		}
+		out.Milestones = append(out.Milestones, m)
+	}
+	return out, rows.Err()
+}
+
+// ListGigs returns the user's contracts as buyer or seller, newest first,
+// without milestones.
+func (d *DB) ListGigs(ctx context.Context, userID int64, limit int) ([]GigContract, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT contract_id, listing_id, buyer_id, seller_id, title, status, created_at
+FROM gig_contracts WHERE buyer_id=$1 OR seller_id=$1
+ORDER BY contract_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []GigContract{}
+	for rows.Next() {
+		var c GigContract
+		if err := rows.Scan(&c.ContractID, &c.ListingID, &c.BuyerID, &c.SellerID, &c.Title, &c.Status, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// ListServiceListings returns active listings of the services category.
+func (d *DB) ListServiceListings(ctx context.Context, limit int) ([]MarketListing, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT l.listing_id, l.seller_id, l.title, l.description, l.category, l.price_coins, l.contact, l.status, l.created_at, l.sold_at, l.buyer_id,
+       (SELECT image_id FROM market_listing_images WHERE listing_id=l.listing_id ORDER BY created_at ASC LIMIT 1) AS image_id
+FROM market_listings l
+WHERE l.status='active' AND l.category=$1
+ORDER BY l.created_at DESC
+LIMIT $2
+`, ServicesCategory, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MarketListing{}
+	for rows.Next() {
+		var l MarketListing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.Title, &l.Description, &l.Category, &l.PriceCoins, &l.Contact, &l.Status, &l.CreatedAt, &l.SoldAt, &l.BuyerID, &l.ImageID); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// lockGigMilestoneTx locks a milestone with the contract parties.
+func lockGigMilestoneTx(ctx context.Context, tx pgx.Tx, milestoneID int64) (GigMilestone, int64, int64, error) {
+	var buyerID, sellerID int64
+	m, err := scanGigMilestone(tx.QueryRow(ctx, `SELECT `+gigMilestoneCols+` FROM gig_milestones WHERE milestone_id=$1 FOR UPDATE`, milestoneID))
+	if err != nil {
+		return GigMilestone{}, 0, 0, err
+	}
+	if err := tx.QueryRow(ctx, `SELECT buyer_id, seller_id FROM gig_contracts WHERE contract_id=$1`, m.ContractID).Scan(&buyerID, &sellerID); err != nil {
+		return GigMilestone{}, 0, 0, err
+	}
+	return m, buyerID, sellerID, nil
+}
+
+// FundGigMilestone moves the milestone amount from the buyer into escrow.
+func (d *DB) FundGigMilestone(ctx context.Context, buyerID, milestoneID int64) (GigMilestone, error) {
+	var out GigMilestone
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		m, buyer, seller, err := lockGigMilestoneTx(ctx, tx, milestoneID)
+		if err != nil {
+			return err
+		}
+		if buyer != buyerID {
+			return pgx.ErrNoRows
+		}
+		now := d.now()
+		if m.Status != GigPending || !now.Before(m.Deadline) {
+			return ErrGigState
+		}
+		if err := debitSpendableTx(ctx, tx, buyerID, m.Amount); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gig_fund', $1, NULL, $2, $3::jsonb)`,
+			buyerID, m.Amount, toJSON(map[string]any{"milestone_id": m.MilestoneID, "contract_id": m.ContractID})); err != nil {
+			return err
+		}
+		out, err = scanGigMilestone(tx.QueryRow(ctx, `
+UPDATE gig_milestones SET status='funded', funded_at=$2 WHERE milestone_id=$1
+RETURNING `+gigMilestoneCols, milestoneID, now))
+		if err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, seller, "gig_funded", map[string]any{"milestone_id": m.MilestoneID, "contract_id": m.ContractID, "amount": m.Amount})
+	})
+	return out, err
+}
+
+// DeliverGigMilestone marks a funded milestone delivered by the seller.
+func (d *DB) DeliverGigMilestone(ctx context.Context, sellerID, milestoneID int64) (GigMilestone, error) {
+	var out GigMilestone
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		m, buyer, seller, err := lockGigMilestoneTx(ctx, tx, milestoneID)
+		if err != nil {
+			return err
+		}
+		if seller != sellerID {
+			return pgx.ErrNoRows
+		}
+		if m.Status != GigFunded {
+			return ErrGigState
+		}
+		out, err = scanGigMilestone(tx.QueryRow(ctx, `
+UPDATE gig_milestones SET status='delivered', delivered_at=$2 WHERE milestone_id=$1
+RETURNING `+gigMilestoneCols, milestoneID, d.now()))
+		if err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, buyer, "gig_delivered", map[string]any{"milestone_id": m.MilestoneID, "contract_id": m.ContractID})
+	})
+	return out, err
+}
+
+// ReleaseGigMilestone pays a funded or delivered milestone to the seller.
+func (d *DB) ReleaseGigMilestone(ctx context.Context, buyerID, milestoneID int64) (GigMilestone, error) {
+	var out GigMilestone
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		m, buyer, seller, err := lockGigMilestoneTx(ctx, tx, milestoneID)
+		if err != nil {
+			return err
+		}
+		if buyer != buyerID {
+			return pgx.ErrNoRows
+		}
+		if m.Status != GigFunded && m.Status != GigDelivered {
+			return ErrGigState
+		}
+		if err := settleGigMilestoneTx(ctx, tx, m, buyer, seller, m.Amount, "buyer"); err != nil {
+			return err
+		}
+		out, err = scanGigMilestone(tx.QueryRow(ctx, `SELECT `+gigMilestoneCols+` FROM gig_milestones WHERE milestone_id=$1`, milestoneID))
+		return err
+	})
+	return out, err
+}
+
+// DisputeGigMilestone hands a funded or delivered milestone to an admin.
+func (d *DB) DisputeGigMilestone(ctx context.Context, userID, milestoneID int64, reason string) (Dispute, error) {
+	var out Dispute
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		m, buyer, seller, err := lockGigMilestoneTx(ctx, tx, milestoneID)
+		if err != nil {
+			return err
+		}
+		if userID != buyer && userID != seller {
+			return pgx.ErrNoRows
+		}
+		if m.Status != GigFunded && m.Status != GigDelivered {
+			return ErrGigState
+		}
+		out, err = openDisputeTx(ctx, tx, DisputeGigMilestone, milestoneID, userID, reason)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE gig_milestones SET status='disputed' WHERE milestone_id=$1`, milestoneID); err != nil {
+			return err
+		}
+		other := buyer
+		if userID == buyer {
+			other = seller
+		}
+		return addUserEventTx(ctx, tx, other, "gig_disputed", map[string]any{"milestone_id": milestoneID, "contract_id": m.ContractID, "dispute_id": out.DisputeID})
+	})
+	return out, err
+}
+
+// resolveGigMilestoneTx settles a disputed milestone by the admin verdict.
+func resolveGigMilestoneTx(ctx context.Context, tx pgx.Tx, dp Dispute, resolution string, sellerBP int64) error {
+	m, buyer, seller, err := lockGigMilestoneTx(ctx, tx, dp.RefID)
+	if err != nil {
+		return err
+	}
+	if m.Status != GigDisputed {
+		return ErrGigState
+	}
+	share, err := disputeSellerShare(m.Amount, resolution, sellerBP)
+	if err != nil {
+		return err
+	}
+	if err := settleGigMilestoneTx(ctx, tx, m, buyer, seller, share, "dispute"); err != nil {
+		return err
+	}
+	for _, uid := range []int64{buyer, seller} {
+		if err := addUserEventTx(ctx, tx, uid, "dispute_resolved", map[string]any{"dispute_id": dp.DisputeID, "kind": dp.Kind, "ref_id": dp.RefID, "resolution": resolution, "to_seller": share}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleGigMilestoneTx pays toSeller of the escrow to the seller and the
+// rest back to the buyer, then closes the contract once no milestone is
+// open. by says who settled: buyer, dispute, deadline or review.
+func settleGigMilestoneTx(ctx context.Context, tx pgx.Tx, m GigMilestone, buyerID, sellerID, toSeller int64, by string) error {
+	if err := lockUsersTx(ctx, tx, buyerID, sellerID); err != nil {
+		return err
+	}
+	meta := toJSON(map[string]any{"milestone_id": m.MilestoneID, "contract_id": m.ContractID, "by": by})
+	if toSeller > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, toSeller, sellerID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gig_release', NULL, $1, $2, $3::jsonb)`, sellerID, toSeller, meta); err != nil {
+			return err
+		}
+	}
+	if refund := m.Amount - toSeller; refund > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, refund, buyerID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('gig_refund', NULL, $1, $2, $3::jsonb)`, buyerID, refund, meta); err != nil {
+			return err
+		}
+	}
+	status := GigReleased
+	if toSeller < m.Amount {
+		status = GigRefunded
+	}
+	if _, err := tx.Exec(ctx, `UPDATE gig_milestones SET status=$2, closed_at=now() WHERE milestone_id=$1`, m.MilestoneID, status); err != nil {
+		return err
+	}
+	for _, uid := range []int64{buyerID, sellerID} {
+		if err := addUserEventTx(ctx, tx, uid, "gig_settled", map[string]any{"milestone_id": m.MilestoneID, "contract_id": m.ContractID, "status": status, "to_seller": toSeller}); err != nil {
+			return err
+		}
+	}
+	return closeGigContractTx(ctx, tx, m.ContractID)
+}
+
+func closeGigContractTx(ctx context.Context, tx pgx.Tx, contractID int64) error {
+	_, err := tx.Exec(ctx, `
+UPDATE gig_contracts SET status='closed'
+WHERE contract_id=$1 AND status='open'
+  AND NOT EXISTS(SELECT 1 FROM gig_milestones WHERE contract_id=$1 AND status IN ('pending','funded','delivered','disputed'))
+`, contractID)
+	return err
+}
+
+// ExpireGigMilestones applies the deadlines at now: funded milestones past
+// the deadline are refunded, delivered ones left unanswered for review are
+// released and unfunded ones past the deadline are cancelled. Disputed ones
+// wait for the admin.
+func (d *DB) ExpireGigMilestones(ctx context.Context, now time.Time, review time.Duration) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT `+gigMilestoneCols+` FROM gig_milestones
+WHERE (status IN ('pending','funded') AND deadline <= $1)
+   OR (status = 'delivered' AND delivered_at <= $2)
+ORDER BY milestone_id
+LIMIT $3
+FOR UPDATE SKIP LOCKED
+`, now, now.Add(-review), gigExpireBatch)
+		if err != nil {
+			return err
+		}
+		var list []GigMilestone
+		for rows.Next() {
+			m, err := scanGigMilestone(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, m := range list {
+			var buyer, seller int64
+			if err := tx.QueryRow(ctx, `SELECT buyer_id, seller_id FROM gig_contracts WHERE contract_id=$1`, m.ContractID).Scan(&buyer, &seller); err != nil {
+				return err
+			}
+			switch m.Status {
+			case GigPending:
+				if _, err := tx.Exec(ctx, `UPDATE gig_milestones SET status='cancelled', closed_at=$2 WHERE milestone_id=$1`, m.MilestoneID, now); err != nil {
+					return err
+				}
+				err = closeGigContractTx(ctx, tx, m.ContractID)
+			case GigFunded:
+				err = settleGigMilestoneTx(ctx, tx, m, buyer, seller, 0, "deadline")
+			case GigDelivered:
+				err = settleGigMilestoneTx(ctx, tx, m, buyer, seller, m.Amount, "review")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		n = int64(len(list))
+		return nil
+	})
+	return n, err
+}
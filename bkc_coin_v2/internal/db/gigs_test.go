@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisputeSellerShare(t *testing.T) {
+	cases := []struct {
+		resolution string
+		bp, want   int64
+	}{
+		{DisputeRelease, 0, 1_000},
+		{DisputeRefund, 0, 0},
+		{DisputeSplit, 2_500, 250},
+		{DisputeSplit, 3_333, 333}, // rounds down to the buyer's favour
+	}
+	for _, c := range cases {
+		got, err := disputeSellerShare(1_000, c.resolution, c.bp)
+		if err != nil || got != c.want {
+			t.Fatalf("%s/%d: share %d, %v; want %d", c.resolution, c.bp, got, err, c.want)
+		}
+	}
+	for _, bad := range []struct {
+		resolution string
+		bp         int64
+	}{{DisputeSplit, 0}, {DisputeSplit, 10_000}, {"cancel", 0}} {
+		if _, err := disputeSellerShare(1_000, bad.resolution, bad.bp); err == nil {
+			t.Fatalf("%s/%d: want error", bad.resolution, bad.bp)
+		}
+	}
+}
+
+func TestValidateGigMilestones(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	ok := []GigMilestoneInput{{Title: " Design ", Amount: 500, Deadline: now.Add(48 * time.Hour)}}
+	if err := validateGigMilestones(ok, 3, now); err != nil {
+		t.Fatal(err)
+	}
+	if ok[0].Title != "Design" {
+		t.Fatalf("title not trimmed: %q", ok[0].Title)
+	}
+	for name, bad := range map[string][]GigMilestoneInput{
+		"none":        nil,
+		"too many":    {ok[0], ok[0], ok[0], ok[0]},
+		"no title":    {{Amount: 500, Deadline: now.Add(time.Hour)}},
+		"zero amount": {{Title: "a", Deadline: now.Add(time.Hour)}},
+		"past":        {{Title: "a", Amount: 500, Deadline: now}},
+	} {
+		if err := validateGigMilestones(bad, 3, now); err == nil {
+			t.Fatalf("%s: want error", name)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The webapp home screen is served from home_views, a per-user document
+// rebuilt by ProjectHomeViews whenever one of its sources changes: a ledger
+// row or user event naming the user, or an explicit mark in home_dirty.
+// Balance and energy are not projected: taps change them far too often, so
+// Home reads them from the users row in the same lookup.
+
+// HomeBoost is an active boost on the home screen.
+type HomeBoost struct {
+	Kind            string    `json:"kind"`
+	Until           time.Time `json:"until"`
+	RegenMultiplier float64   `json:"regen_multiplier,omitempty"`
+	MaxMultiplier   float64   `json:"max_multiplier,omitempty"`
+}
+
+// HomeSubscription is the user's plan (see user_plans).
+type HomeSubscription struct {
+	Plan          string     `json:"plan"`
+	DailyTapLimit int64      `json:"daily_tap_limit"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// HomeRewards are rewards earned but not paid yet.
+type HomeRewards struct {
+	Staking int64 `json:"staking"` // claimable NFT staking rewards
+	Levels  int64 `json:"levels"`  // level-up rewards waiting for the reserve
+}
+
+// HomeView is the projected part of the home screen.
+type HomeView struct {
+	UserID       int64             `json:"user_id"`
+	Username     string            `json:"username"`
+	FirstName    string            `json:"first_name"`
+	Level        int64             `json:"level"`
+	XP           int64             `json:"xp"`
+	Boosts       []HomeBoost       `json:"boosts"`
+	Subscription *HomeSubscription `json:"subscription,omitempty"`
+	Unclaimed    HomeRewards       `json:"unclaimed"`
+	UnreadEvents int64             `json:"unread_events"`
+	ProjectedAt  time.Time         `json:"projected_at"`
+}
+
+// Home is the full home screen: the projection plus live balance and energy.
+type Home struct {
+	HomeView
+	Balance         int64     `json:"balance"`
+	FrozenBalance   int64     `json:"frozen_balance"`
+	Energy          float64   `json:"energy"`
+	EnergyMax       float64   `json:"energy_max"`
+	EnergyUpdatedAt time.Time `json:"energy_updated_at"`
+}
+
+// active drops boosts and a plan that expired after the projection ran.
+func (v HomeView) active(now time.Time) HomeView {
+	boosts := make([]HomeBoost, 0, len(v.Boosts))
+	for _, b := range v.Boosts {
+		if b.Until.After(now) {
+			boosts = append(boosts, b)
+		}
+	}
+	v.Boosts = boosts
+	if v.Subscription != nil && v.Subscription.ExpiresAt != nil && !v.Subscription.ExpiresAt.After(now) {
+		v.Subscription = nil
+	}
+	return v
+}
+
+const homeProjectBatch = 1_000
+
+// buildHomeView reads every source of the projection for one user.
+func buildHomeView(ctx context.Context, q rowQuerier, userID int64) (HomeView, error) {
+	v := HomeView{UserID: userID, Boosts: []HomeBoost{}, ProjectedAt: time.Now().UTC()}
+	var boostUntil *time.Time
+	var regen, maxMul float64
+	if err := q.QueryRow(ctx, `
+SELECT COALESCE(username,''), COALESCE(first_name,''), level, xp, energy_boost_until, energy_boost_regen_multiplier, energy_boost_max_multiplier
+FROM users WHERE user_id=$1
+`, userID).Scan(&v.Username, &v.FirstName, &v.Level, &v.XP, &boostUntil, &regen, &maxMul); err != nil {
+		return HomeView{}, err
+	}
+	if boostUntil != nil && boostUntil.After(v.ProjectedAt) {
+		v.Boosts = append(v.Boosts, HomeBoost{Kind: "energy", Until: boostUntil.UTC(), RegenMultiplier: regen, MaxMultiplier: maxMul})
+	}
+
+	var sub HomeSubscription
+	err := q.QueryRow(ctx, `
+SELECT plan, daily_tap_limit, expires_at FROM user_plans
+WHERE user_id=$1 AND (expires_at IS NULL OR expires_at > now())
+`, userID).Scan(&sub.Plan, &sub.DailyTapLimit, &sub.ExpiresAt)
+	switch {
+	case err == nil:
+		v.Subscription = &sub
+	case !errors.Is(err, pgx.ErrNoRows):
+		return HomeView{}, err
+	}
+
+	if err := q.QueryRow(ctx, `
+SELECT
+  (SELECT COALESCE(SUM(accrued - claimed), 0) FROM nft_stakes WHERE user_id=$1),
+  (SELECT COALESCE(SUM(reward), 0) FROM level_ups WHERE user_id=$1 AND paid_at IS NULL),
+  (SELECT COUNT(*) FROM user_events WHERE user_id=$1 AND read_at IS NULL)
+`, userID).Scan(&v.Unclaimed.Staking, &v.Unclaimed.Levels, &v.UnreadEvents); err != nil {
+		return HomeView{}, err
+	}
+	return v, nil
+}
+
+// RefreshHomeView rebuilds and stores one user's projection.
+func (d *DB) RefreshHomeView(ctx context.Context, userID int64) (HomeView, error) {
+	v, err := buildHomeView(ctx, d.Pool, userID)
+	if err != nil {
+		return HomeView{}, err
+	}
+	_, err = d.Pool.Exec(ctx, `
+INSERT INTO home_views(user_id, doc, updated_at) VALUES($1, $2::jsonb, $3)
+ON CONFLICT (user_id) DO UPDATE SET doc=EXCLUDED.doc, updated_at=EXCLUDED.updated_at
+WHERE home_views.updated_at <= EXCLUDED.updated_at
+`, userID, toJSON(v), v.ProjectedAt)
+	return v, err
+}
+
+// markHomeDirty queues users whose projection must be rebuilt, for changes
+// that leave no ledger row or user event.
+func (d *DB) markHomeDirty(ctx context.Context, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	_, err := d.Pool.Exec(ctx, `INSERT INTO home_dirty(user_id) SELECT DISTINCT unnest($1::bigint[]) ON CONFLICT DO NOTHING`, userIDs)
+	return err
+}
+
+// ProjectHomeViews is the event handler of the read model: it collects users
+// named by new ledger rows and user events, adds the home_dirty queue, and
+// rebuilds their projections. Rows younger than a minute are collected again
+// on the next run (ids are assigned before commit, so a gap may still fill),
+// which keeps the view fresh without missing late commits. Run from the
+// home_views job; returns the number of views rebuilt.
+func (d *DB) ProjectHomeViews(ctx context.Context) (int64, error) {
+	var ids []int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var ledgerCursor, eventCursor int64
+		if err := tx.QueryRow(ctx, `SELECT ledger_id, event_id FROM home_projection WHERE id=1 FOR UPDATE`).Scan(&ledgerCursor, &eventCursor); err != nil {
+			return err
+		}
+		seen := map[int64]bool{}
+		collect := func(sql string, cursor int64) (int64, error) {
+			rows, err := tx.Query(ctx, sql, cursor, homeProjectBatch)
+			if err != nil {
+				return 0, err
+			}
+			defer rows.Close()
+			settled, stalled := cursor, false
+			for rows.Next() {
+				var id int64
+				var users []*int64
+				var old bool
+				if err := rows.Scan(&id, &users, &old); err != nil {
+					return 0, err
+				}
+				// The cursor only passes rows that are settled, in id order.
+				if !old {
+					stalled = true
+				} else if !stalled {
+					settled = id
+				}
+				for _, u := range users {
+					if u != nil && *u > 0 {
+						seen[*u] = true
+					}
+				}
+			}
+			return settled, rows.Err()
+		}
+		var err error
+		ledgerCursor, err = collect(`
+SELECT id, ARRAY[from_id, to_id], ts < now() - interval '1 minute'
+FROM ledger WHERE id > $1 ORDER BY id LIMIT $2
+`, ledgerCursor)
+		if err != nil {
+			return err
+		}
+		eventCursor, err = collect(`
+SELECT event_id, ARRAY[user_id], created_at < now() - interval '1 minute'
+FROM user_events WHERE event_id > $1 ORDER BY event_id LIMIT $2
+`, eventCursor)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, `
+DELETE FROM home_dirty WHERE user_id IN (
+  SELECT user_id FROM home_dirty ORDER BY marked_at LIMIT $1 FOR UPDATE SKIP LOCKED
+) RETURNING user_id
+`, homeProjectBatch)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			seen[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE home_projection SET ledger_id=$1, event_id=$2, updated_at=now() WHERE id=1`, ledgerCursor, eventCursor); err != nil {
+			return err
+		}
+		for id := range seen {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slices.Sort(ids)
+	var n int64
+	for _, id := range ids {
+		if _, err := d.RefreshHomeView(ctx, id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue // user deleted
+			}
+			// Queue it again rather than lose the change.
+			_ = d.markHomeDirty(ctx, id)
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Home returns the home screen in one indexed lookup; a user without a
+// projection yet gets one built on the spot.
+func (d *DB) Home(ctx context.Context, userID int64) (Home, error) {
+	var h Home
+	var doc []byte
+	err := d.Pool.QueryRow(ctx, `
+SELECT u.balance, u.frozen_balance, u.energy, u.energy_max, u.energy_updated_at, hv.doc
+FROM users u LEFT JOIN home_views hv ON hv.user_id = u.user_id
+WHERE u.user_id=$1
+`, userID).Scan(&h.Balance, &h.FrozenBalance, &h.Energy, &h.EnergyMax, &h.EnergyUpdatedAt, &doc)
+	if err != nil {
+		return Home{}, err
+	}
+	if doc == nil {
+		if h.HomeView, err = d.RefreshHomeView(ctx, userID); err != nil {
+			return Home{}, err
+		}
+	} else if err := json.Unmarshal(doc, &h.HomeView); err != nil {
+		return Home{}, err
+	}
+	h.HomeView = h.HomeView.active(time.Now())
+	return h, nil
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHomeViewActive(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	v := HomeView{
+		Boosts:       []HomeBoost{{Kind: "energy", Until: past}, {Kind: "energy", Until: future}},
+		Subscription: &HomeSubscription{Plan: "pro", ExpiresAt: &past},
+	}
+	got := v.active(now)
+	if len(got.Boosts) != 1 || !got.Boosts[0].Until.Equal(future) {
+		t.Fatalf("boosts: %+v", got.Boosts)
+	}
+	if got.Subscription != nil {
+		t.Fatal("expired plan kept")
+	}
+	if len(v.Boosts) != 2 {
+		t.Fatal("active modified the stored view")
+	}
+}
+
+func TestHomeProjection(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 994_001
+	if _, err := d.EnsureUser(ctx, userID, "", "", 100); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for _, q := range []string{
+			`DELETE FROM home_views WHERE user_id=$1`,
+			`DELETE FROM home_dirty WHERE user_id=$1`,
+			`DELETE FROM level_ups WHERE user_id=$1`,
+			`DELETE FROM user_plans WHERE user_id=$1`,
+			`DELETE FROM users WHERE user_id=$1`,
+		} {
+			_, _ = d.Pool.Exec(ctx, q, userID)
+		}
+	})
+
+	h, err := d.Home(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.UserID != userID || h.Subscription != nil || h.Unclaimed.Levels != 0 {
+		t.Fatalf("first home: %+v", h)
+	}
+
+	// A change without a ledger row or event shows up once marked and projected.
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO level_ups(user_id, level, reward) VALUES($1, 2, 2000)`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.markHomeDirty(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ProjectHomeViews(ctx); err != nil {
+		t.Fatal(err)
+	}
+	h, err = d.Home(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Unclaimed.Levels != 2000 {
+		t.Fatalf("level rewards %d, want 2000", h.Unclaimed.Levels)
+	}
+
+	// A plan change writes a ledger row, which the projection picks up.
+	if _, err := d.SetUserPlan(ctx, 1, userID, "pro", 50_000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ProjectHomeViews(ctx); err != nil {
+		t.Fatal(err)
+	}
+	h, err = d.Home(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Subscription == nil || h.Subscription.Plan != "pro" {
+		t.Fatalf("subscription: %+v", h.Subscription)
+	}
+}
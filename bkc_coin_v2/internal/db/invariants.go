@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Accounting invariants must hold on any consistent snapshot of the
+// database: a live one, a verified backup restored into a scratch database
+// or a point-in-time recovery. Each check counts offending rows and shows a
+// few of them; a zero count passes.
+
+// InvariantViolation is a failed check.
+type InvariantViolation struct {
+	Name   string `json:"name"`
+	Count  int64  `json:"count"`
+	Sample string `json:"sample"`
+}
+
+type invariant struct {
+	name string
+	sql  string // returns (count bigint, sample text)
+}
+
+var invariants = []invariant{
+	{"system_state_present", `
+SELECT (1 - COUNT(*))::bigint, '' FROM system_state WHERE id=1`},
+	{"reserve_bounds", `
+SELECT COUNT(*), COALESCE(string_agg(format('reserve=%s reserved=%s total=%s', reserve_supply, reserved_supply, total_supply), '; '), '')
+FROM system_state
+WHERE reserve_supply < 0 OR reserved_supply < 0 OR reserved_supply > reserve_supply OR total_supply < reserve_supply`},
+	{"frozen_balance_non_negative", `
+SELECT COUNT(*), COALESCE(string_agg(format('user %s frozen=%s', user_id, frozen_balance), '; ') FILTER (WHERE rn <= 5), '')
+FROM (SELECT user_id, frozen_balance, row_number() OVER (ORDER BY user_id) AS rn FROM users WHERE frozen_balance < 0) x`},
+	{"ledger_amount_non_negative", `
+SELECT COUNT(*), COALESCE(string_agg(format('ledger %s %s %s', id, kind, amount), '; ') FILTER (WHERE rn <= 5), '')
+FROM (SELECT id, kind, amount, row_number() OVER (ORDER BY id) AS rn FROM ledger WHERE amount < 0) x`},
+	{"ledger_sequence_ahead", `
+SELECT (COALESCE((SELECT MAX(id) FROM ledger), 0) > (SELECT last_value FROM ledger_id_seq))::int::bigint,
+  format('max id %s, sequence %s', (SELECT MAX(id) FROM ledger), (SELECT last_value FROM ledger_id_seq))`},
+	{"user_events_sequence_ahead", `
+SELECT (COALESCE((SELECT MAX(event_id) FROM user_events), 0) > (SELECT last_value FROM user_events_event_id_seq))::int::bigint,
+  format('max id %s, sequence %s', (SELECT MAX(event_id) FROM user_events), (SELECT last_value FROM user_events_event_id_seq))`},
+	{"ledger_users_exist", `
+SELECT COUNT(*), COALESCE(string_agg(format('ledger %s user %s', id, uid), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT l.id, u.uid, row_number() OVER (ORDER BY l.id) AS rn
+  FROM ledger l, LATERAL (VALUES (l.from_id), (l.to_id)) u(uid)
+  WHERE u.uid > 0 AND NOT EXISTS (SELECT 1 FROM users WHERE user_id=u.uid)
+) x`},
+	{"cryptopay_credit_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('invoice %s', invoice_id), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT i.invoice_id, row_number() OVER (ORDER BY i.invoice_id) AS rn
+  FROM cryptopay_invoices i
+  WHERE (i.credited_at IS NOT NULL) <> EXISTS (
+    SELECT 1 FROM ledger l WHERE l.kind='cryptopay_deposit' AND l.to_id=i.user_id AND l.meta->>'invoice_id' = i.invoice_id::text
+  )
+) x`},
+	// Same totals as StabilizationReport.
+	{"stabilization_fund_booked", `
+SELECT (a.balance <> l.net)::int::bigint, format('account %s, ledger %s', a.balance, l.net)
+FROM system_accounts a, (
+  SELECT COALESCE(SUM(amount) FILTER (WHERE kind='sale_tax_fund'), 0)
+       - COALESCE(SUM(amount) FILTER (WHERE kind IN ('stabilization_burn','stabilization_topup')), 0) AS net
+  FROM ledger WHERE kind IN ('sale_tax_fund','stabilization_burn','stabilization_topup')
+) l
+WHERE a.account='stabilization_fund'`},
+	// Premiums in minus payouts out, as LoanInsuranceStats reports them.
+	{"loan_insurance_pool_booked", `
+SELECT (a.balance <> l.net)::int::bigint, format('account %s, ledger %s', a.balance, l.net)
+FROM system_accounts a, (
+  SELECT COALESCE(SUM(amount) FILTER (WHERE kind='p2p_insurance_premium'), 0)
+       - COALESCE(SUM(amount) FILTER (WHERE kind='p2p_insurance_payout'), 0) AS net
+  FROM ledger WHERE kind IN ('p2p_insurance_premium','p2p_insurance_payout')
+) l
+WHERE a.account='loan_insurance_pool'`},
+	// Commission credited minus paid out is what affiliates still hold.
+	{"affiliate_balance_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('affiliate %s balance %s ledger %s', user_id, balance, net), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT a.user_id, a.balance, COALESCE(l.net, 0) AS net, row_number() OVER (ORDER BY a.user_id) AS rn
+  FROM affiliates a
+  LEFT JOIN (
+    SELECT to_id, SUM(CASE kind WHEN 'affiliate_commission' THEN amount ELSE -amount END) AS net
+    FROM ledger WHERE kind IN ('affiliate_commission','affiliate_payout')
+    GROUP BY to_id
+  ) l ON l.to_id = a.user_id
+  WHERE a.balance <> COALESCE(l.net, 0) OR a.balance < 0
+) x`},
+	// Granted and won minus staked, converted and returned is what a bonus holds.
+	{"bonus_balance_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('bonus %s balance %s ledger %s', bonus_id, held, net), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT b.bonus_id, b.balance + b.free_bet AS held, COALESCE(l.net, 0) AS net, row_number() OVER (ORDER BY b.bonus_id) AS rn
+  FROM user_bonuses b
+  LEFT JOIN (
+    SELECT (meta->>'bonus_id')::bigint AS bonus_id,
+           SUM(CASE WHEN kind IN ('bonus_grant','bonus_win') THEN amount ELSE -amount END) AS net
+    FROM ledger WHERE kind IN ('bonus_grant','bonus_win','bonus_stake','bonus_convert','bonus_expire','bonus_forfeit')
+    GROUP BY 1
+  ) l ON l.bonus_id = b.bonus_id
+  WHERE b.balance + b.free_bet <> COALESCE(l.net, 0) OR b.balance < 0 OR b.free_bet < 0
+) x`},
+	// Credits bought and won minus sold and staked is the credits balance.
+	{"game_credits_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('user %s credits %s ledger %s', user_id, game_credits, net), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT u.user_id, u.game_credits, COALESCE(l.net, 0) AS net, row_number() OVER (ORDER BY u.user_id) AS rn
+  FROM users u
+  LEFT JOIN (
+    SELECT COALESCE(to_id, from_id) AS user_id,
+           SUM(CASE WHEN kind IN ('credits_buy','credits_win') THEN 1 ELSE -1 END * (meta->>'credits')::bigint) AS net
+    FROM ledger WHERE kind IN ('credits_buy','credits_win','credits_sell','credits_bet')
+    GROUP BY 1
+  ) l ON l.user_id = u.user_id
+  WHERE u.game_credits <> COALESCE(l.net, 0) OR u.game_credits < 0
+) x`},
+	{"gifts_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('gift %s %s', gift_id, status), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT g.gift_id, g.status, row_number() OVER (ORDER BY g.gift_id) AS rn
+  FROM gifts g
+  WHERE NOT EXISTS (SELECT 1 FROM ledger l WHERE l.kind='gift_lock' AND l.from_id=g.sender_id AND l.meta->>'gift_id' = g.gift_id::text)
+     OR (g.status <> 'pending') <> EXISTS (
+       SELECT 1 FROM ledger l WHERE l.kind IN ('gift_claim','gift_refund') AND l.meta->>'gift_id' = g.gift_id::text
+     )
+) x`},
+	{"bills_funded", `
+SELECT COUNT(*), COALESCE(string_agg(format('bill %s %s total %s funded %s', bill_id, status, total, funded), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT b.bill_id, b.status, b.total, b.funded, row_number() OVER (ORDER BY b.bill_id) AS rn
+  FROM bills b
+  JOIN (
+    SELECT bill_id, SUM(amount) AS owed, COALESCE(SUM(amount) FILTER (WHERE paid_at IS NOT NULL), 0) AS paid
+    FROM bill_shares GROUP BY bill_id
+  ) s ON s.bill_id = b.bill_id
+  LEFT JOIN (
+    SELECT (meta->>'bill_id')::bigint AS bill_id, SUM(amount) AS paid
+    FROM ledger WHERE kind='bill_pay' GROUP BY 1
+  ) l ON l.bill_id = b.bill_id
+  WHERE b.total <> s.owed OR b.funded <> s.paid OR b.funded <> COALESCE(l.paid, 0)
+     OR (b.status = 'completed' AND b.funded <> b.total)
+) x`},
+	{"merchant_orders_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('order %s %s', order_id, status), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT o.order_id, o.status, row_number() OVER (ORDER BY o.order_id) AS rn
+  FROM orders o
+  WHERE o.order_type = 'merchant' AND (o.status = 'paid') <> EXISTS (
+    SELECT 1 FROM ledger l WHERE l.kind='merchant_payment' AND l.to_id=o.merchant_id AND l.meta->>'order_id' = o.order_id::text
+  )
+) x`},
+	{"tips_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('ledger %s tips %s ledger %s', ledger_id, tipped, amount), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT t.ledger_id, t.tipped, l.amount, row_number() OVER (ORDER BY t.ledger_id) AS rn
+  FROM (SELECT ledger_id, SUM(amount) AS tipped FROM tips WHERE ledger_id IS NOT NULL GROUP BY ledger_id) t
+  LEFT JOIN ledger l ON l.id = t.ledger_id AND l.kind = 'tip'
+  WHERE l.amount IS DISTINCT FROM t.tipped
+) x`},
+	{"gig_escrow_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('milestone %s %s amount %s funded %s paid %s', milestone_id, status, amount, funded, paid), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT m.milestone_id, m.status, m.amount, f.funded, p.paid, row_number() OVER (ORDER BY m.milestone_id) AS rn
+  FROM gig_milestones m
+  LEFT JOIN LATERAL (
+    SELECT COALESCE(SUM(amount), 0) AS funded FROM ledger WHERE kind='gig_fund' AND meta->>'milestone_id' = m.milestone_id::text
+  ) f ON true
+  LEFT JOIN LATERAL (
+    SELECT COALESCE(SUM(amount), 0) AS paid FROM ledger WHERE kind IN ('gig_release','gig_refund') AND meta->>'milestone_id' = m.milestone_id::text
+  ) p ON true
+  WHERE f.funded <> CASE WHEN m.status IN ('pending','cancelled') THEN 0 ELSE m.amount END
+     OR p.paid <> CASE WHEN m.status IN ('released','refunded') THEN m.amount ELSE 0 END
+) x`},
+	{"bundle_sales_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('bundle %s price %s booked %s', bundle_id, price_coins, booked), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT b.bundle_id, b.price_coins, l.booked, row_number() OVER (ORDER BY b.bundle_id) AS rn
+  FROM bundle_listings b
+  LEFT JOIN LATERAL (
+    SELECT COALESCE(SUM(amount), 0) AS booked FROM ledger
+    WHERE kind IN ('bundle_buy','nft_royalty','nft_market_fee') AND meta->>'bundle_id' = b.bundle_id::text
+  ) l ON true
+  WHERE l.booked <> CASE WHEN b.status = 'sold' THEN b.price_coins ELSE 0 END
+) x`},
+	{"nft_rentals_booked", `
+SELECT COUNT(*), COALESCE(string_agg(format('rental %s paid %s booked %s', rental_id, paid, booked), '; ') FILTER (WHERE rn <= 5), '')
+FROM (
+  SELECT r.rental_id, r.paid, l.booked, row_number() OVER (ORDER BY r.rental_id) AS rn
+  FROM nft_rentals r
+  LEFT JOIN LATERAL (
+    SELECT COALESCE(SUM(amount), 0) AS booked FROM ledger
+    WHERE kind IN ('nft_rent','nft_rent_fee') AND meta->>'rental_id' = r.rental_id::text
+  ) l ON true
+  WHERE l.booked <> r.paid
+) x`},
+}
+
+// CheckInvariants runs every accounting check and returns the failures.
+func (d *DB) CheckInvariants(ctx context.Context) ([]InvariantViolation, error) {
+	ctx = WithoutQueryTimeout(ctx) // full ledger scans
+	var out []InvariantViolation
+	for _, inv := range invariants {
+		var v InvariantViolation
+		if err := d.Pool.QueryRow(ctx, inv.sql).Scan(&v.Count, &v.Sample); err != nil {
+			return nil, err
+		}
+		if v.Count != 0 {
+			v.Name = inv.name
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// LedgerSummary fingerprints the ledger up to an id, so a restore can be
+// compared with the source: equal summaries mean the same rows.
+type LedgerSummary struct {
+	Rows     int64      `json:"rows"`
+	MaxID    int64      `json:"max_id"`
+	MaxTS    *time.Time `json:"max_ts,omitempty"`
+	Minted   int64      `json:"minted"` // sum of amounts credited from no one
+	Checksum string     `json:"checksum"`
+}
+
+// LedgerSummary summarises ledger rows with id <= upTo (0 = all). The
+// checksum is an order-independent sum of row hashes.
+func (d *DB) LedgerSummary(ctx context.Context, upTo int64) (LedgerSummary, error) {
+	return ledgerSummary(ctx, d.Pool, upTo)
+}
+
+// LedgerSummaryTx summarises the ledger as seen by tx, e.g. a snapshot
+// shared with pg_dump.
+func LedgerSummaryTx(ctx context.Context, tx pgx.Tx, upTo int64) (LedgerSummary, error) {
+	return ledgerSummary(ctx, tx, upTo)
+}
+
+func ledgerSummary(ctx context.Context, q rowQuerier, upTo int64) (LedgerSummary, error) {
+	var s LedgerSummary
+	err := q.QueryRow(ctx, `
+SELECT COUNT(*), COALESCE(MAX(id), 0), MAX(ts),
+  COALESCE(SUM(amount) FILTER (WHERE from_id IS NULL AND to_id IS NOT NULL), 0),
+  COALESCE(SUM(hashtextextended(concat_ws('|', id, event_id, kind, from_id, to_id, amount, meta::text), 0)::numeric), 0)::text
+FROM ledger
+WHERE $1 = 0 OR id <= $1
+`, upTo).Scan(&s.Rows, &s.MaxID, &s.MaxTS, &s.Minted, &s.Checksum)
+	return s, err
+}
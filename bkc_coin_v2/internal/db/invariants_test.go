@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckInvariantsRuns(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	// The test database holds leftovers of other tests, so only the queries
+	// themselves are checked here.
+	if _, err := d.CheckInvariants(ctx); err != nil {
+		t.Fatal(err)
+	}
+	a, err := d.LedgerSummary(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.LedgerSummary(ctx, a.MaxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MaxID != a.MaxID || b.Rows > a.Rows {
+		t.Fatalf("summary up to %d: %+v, all: %+v", a.MaxID, b, a)
+	}
+}
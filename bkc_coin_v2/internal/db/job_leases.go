@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AcquireJobLease makes holder the leader of job name for ttl. It succeeds
+// when the lease is free, expired or already holder's (which renews it), so
+// the leader keeps it as long as it runs more often than ttl.
+func (d *DB) AcquireJobLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	if name == "" || holder == "" || ttl <= 0 {
+		return false, errors.New("bad lease")
+	}
+	var got string
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO job_leases(name, holder, expires_at) VALUES($1, $2, now() + make_interval(secs => $3))
+ON CONFLICT (name) DO UPDATE SET holder=EXCLUDED.holder, expires_at=EXCLUDED.expires_at
+WHERE job_leases.holder=EXCLUDED.holder OR job_leases.expires_at <= now()
+RETURNING holder
+`, name, holder, ttl.Seconds()).Scan(&got)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return got == holder, nil
+}
+
+// jobCheckpoint loads the cursor of job name into v; false if there is none.
+func (d *DB) jobCheckpoint(ctx context.Context, name string, v any) (bool, error) {
+	var raw []byte
+	err := d.Pool.QueryRow(ctx, `SELECT cursor FROM job_checkpoints WHERE name=$1`, name).Scan(&raw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// saveJobCheckpoint stores the cursor of job name; a nil v clears it.
+func (d *DB) saveJobCheckpoint(ctx context.Context, name string, v any) error {
+	if v == nil {
+		_, err := d.Pool.Exec(ctx, `DELETE FROM job_checkpoints WHERE name=$1`, name)
+		return err
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO job_checkpoints(name, cursor) VALUES($1, $2::jsonb)
+ON CONFLICT (name) DO UPDATE SET cursor=EXCLUDED.cursor, updated_at=now()
+`, name, toJSON(v))
+	return err
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobLease(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const name = "lease test"
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM job_leases WHERE name=$1`, name)
+	})
+	_, _ = d.Pool.Exec(ctx, `DELETE FROM job_leases WHERE name=$1`, name)
+
+	lead := func(holder string, ttl time.Duration, want bool) {
+		t.Helper()
+		ok, err := d.AcquireJobLease(ctx, name, holder, ttl)
+		if err != nil || ok != want {
+			t.Fatalf("%s: %v, %v; want %v", holder, ok, err, want)
+		}
+	}
+	lead("a", time.Minute, true)
+	lead("b", time.Minute, false)
+	lead("a", time.Minute, true) // renewal
+
+	// An expired lease goes to whoever asks next.
+	if _, err := d.Pool.Exec(ctx, `UPDATE job_leases SET expires_at=now() - interval '1 second' WHERE name=$1`, name); err != nil {
+		t.Fatal(err)
+	}
+	lead("b", time.Minute, true)
+	lead("a", time.Minute, false)
+}
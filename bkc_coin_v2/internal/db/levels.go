@@ -0,0 +1,315 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LevelPolicy is the XP progression. Thresholds[i] is the total XP needed for
+// level i+2 (everyone starts at level 1); reaching level n pays n*RewardPerLevel
+// coins from the reserve.
+type LevelPolicy struct {
+	Thresholds     []int64
+	RewardPerLevel int64
+	XPPerTap       int64
+	XPPerPurchase  int64
+	XPPerGameWin   int64
+}
+
+// Level is a user's progression as shown in the profile.
+type Level struct {
+	Level       int64 `json:"level"`
+	XP          int64 `json:"xp"`
+	LevelXP     int64 `json:"level_xp"`      // XP at which the current level started
+	NextLevelXP int64 `json:"next_level_xp"` // -1 at the top level
+	MaxLevel    int64 `json:"max_level"`
+}
+
+// LevelFor is the level reached with xp.
+func (p LevelPolicy) LevelFor(xp int64) int64 {
+	level := int64(1)
+	for _, t := range p.Thresholds {
+		if xp < t {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// Reward is the coin reward for reaching level.
+func (p LevelPolicy) Reward(level int64) int64 {
+	if level <= 1 {
+		return 0
+	}
+	return level * p.RewardPerLevel
+}
+
+// Progress describes xp against the thresholds.
+func (p LevelPolicy) Progress(xp int64) Level {
+	out := Level{XP: xp, Level: p.LevelFor(xp), NextLevelXP: -1, MaxLevel: int64(len(p.Thresholds)) + 1}
+	if out.Level > 1 {
+		out.LevelXP = p.Thresholds[out.Level-2]
+	}
+	if out.Level < out.MaxLevel {
+		out.NextLevelXP = p.Thresholds[out.Level-1]
+	}
+	return out
+}
+
+// Ledger kinds that earn XP: purchases (credited to or paid by the user) and
+// game wins (any kind ending in "_win").
+var (
+	xpDepositKinds  = []string{"deposit_approve", "cryptopay_deposit"}
+	xpPurchaseKinds = []string{"nft_buy", "nft_market_buy", "market_buy"}
+)
+
+const xpSyncBatch = 1_000
+
+// GetLevel returns the user's level and XP.
+func (d *DB) GetLevel(ctx context.Context, userID int64, p LevelPolicy) (Level, error) {
+	var xp int64
+	if err := d.Pool.QueryRow(ctx, `SELECT xp FROM users WHERE user_id=$1`, userID).Scan(&xp); err != nil {
+		return Level{}, err
+	}
+	return p.Progress(xp), nil
+}
+
+// AddXP grants xp to userID outside the synced sources (e.g. quests).
+func (d *DB) AddXP(ctx context.Context, userID, xp int64, source string, p LevelPolicy) (Level, error) {
+	if userID <= 0 || xp <= 0 {
+		return Level{}, errors.New("bad params")
+	}
+	var out Level
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = addXPTx(ctx, tx, userID, xp, p, map[string]any{"source": source})
+		return err
+	})
+	return out, err
+}
+
+// addXPTx adds xp and records any level-ups; rewards are paid later by
+// PayLevelRewards so this never touches system_state.
+func addXPTx(ctx context.Context, tx pgx.Tx, userID, xp int64, p LevelPolicy, meta map[string]any) (Level, error) {
+	var total, level int64
+	if err := tx.QueryRow(ctx, `UPDATE users SET xp=xp+$2 WHERE user_id=$1 RETURNING xp, level`, userID, xp).Scan(&total, &level); err != nil {
+		return Level{}, err
+	}
+	out := p.Progress(total)
+	if out.Level <= level {
+		return out, nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET level=$2 WHERE user_id=$1`, userID, out.Level); err != nil {
+		return Level{}, err
+	}
+	for l := level + 1; l <= out.Level; l++ {
+		if _, err := tx.Exec(ctx, `INSERT INTO level_ups(user_id, level, reward) VALUES($1,$2,$3) ON CONFLICT DO NOTHING`, userID, l, p.Reward(l)); err != nil {
+			return Level{}, err
+		}
+	}
+	payload := map[string]any{"from": level, "to": out.Level, "xp": total}
+	for k, v := range meta {
+		payload[k] = v
+	}
+	return out, addUserEventTx(ctx, tx, userID, "level_up", payload)
+}
+
+// SyncXP converts new taps and XP-earning ledger rows into XP. Run from the
+// levels job; returns how many users gained XP.
+func (d *DB) SyncXP(ctx context.Context, p LevelPolicy) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		gains := map[int64]int64{}
+
+		rows, err := tx.Query(ctx, `
+UPDATE users u SET xp_taps=u.taps_total
+FROM (SELECT user_id, xp_taps FROM users WHERE taps_total > xp_taps ORDER BY user_id LIMIT $1 FOR UPDATE) s
+WHERE u.user_id = s.user_id
+RETURNING u.user_id, u.taps_total - s.xp_taps
+`, xpSyncBatch)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var userID, taps int64
+			if err := rows.Scan(&userID, &taps); err != nil {
+				rows.Close()
+				return err
+			}
+			gains[userID] += taps * p.XPPerTap
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var cursor int64
+		if err := tx.QueryRow(ctx, `SELECT ledger_id FROM xp_sync WHERE id=1 FOR UPDATE`).Scan(&cursor); err != nil {
+			return err
+		}
+		// Rows younger than a minute are left for the next run: ids are assigned
+		// before commit, so a recent gap may still be filled.
+		rows, err = tx.Query(ctx, `
+SELECT id,
+       CASE WHEN kind = ANY($2) OR kind LIKE '%\_win' THEN to_id ELSE from_id END,
+       CASE WHEN kind LIKE '%\_win' THEN 'win' ELSE 'purchase' END
+FROM ledger
+WHERE id > $1 AND ts < now() - interval '1 minute'
+  AND (kind = ANY($2) OR kind = ANY($3) OR kind LIKE '%\_win')
+ORDER BY id
+LIMIT $4
+`, cursor, xpDepositKinds, xpPurchaseKinds, xpSyncBatch)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var userID *int64
+			var src string
+			if err := rows.Scan(&id, &userID, &src); err != nil {
+				rows.Close()
+				return err
+			}
+			cursor = id
+			if userID == nil || *userID <= 0 {
+				continue
+			}
+			if src == "win" {
+				gains[*userID] += p.XPPerGameWin
+			} else {
+				gains[*userID] += p.XPPerPurchase
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE xp_sync SET ledger_id=$1, updated_at=now() WHERE id=1`, cursor); err != nil {
+			return err
+		}
+
+		// Lock users in id order.
+		ids := make([]int64, 0, len(gains))
+		for userID, xp := range gains {
+			if xp > 0 {
+				ids = append(ids, userID)
+			}
+		}
+		slices.Sort(ids)
+		for _, userID := range ids {
+			if _, err := addXPTx(ctx, tx, userID, gains[userID], p, map[string]any{"source": "sync"}); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// PayLevelRewards credits unpaid level-up rewards from the reserve. A reward
+// that does not fit the reserve or today's emission cap stays unpaid and is
+// retried on the next run.
+func (d *DB) PayLevelRewards(ctx context.Context) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT user_id, level, reward FROM level_ups
+WHERE paid_at IS NULL AND reward > 0
+ORDER BY created_at
+LIMIT $1
+`, xpSyncBatch)
+	if err != nil {
+		return 0, err
+	}
+	type due struct{ userID, level, reward int64 }
+	var list []due
+	for rows.Next() {
+		var u due
+		if err := rows.Scan(&u.userID, &u.level, &u.reward); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var paid int64
+	for _, u := range list {
+		err := d.WithTx(ctx, func(tx pgx.Tx) error {
+			var reserve, reserved int64
+			if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+				return err
+			}
+			if reserve-reserved < u.reward {
+				return ErrNotEnough
+			}
+			tag, err := tx.Exec(ctx, `UPDATE level_ups SET paid_at=now() WHERE user_id=$1 AND level=$2 AND paid_at IS NULL`, u.userID, u.level)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return nil
+			}
+			if err := chargeEmissionTx(ctx, tx, u.reward); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply-$1, updated_at=now() WHERE id=1`, u.reward); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, u.reward, u.userID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('level_reward', NULL, $1, $2, $3::jsonb)`,
+				u.userID, u.reward, toJSON(map[string]any{"level": u.level})); err != nil {
+				return err
+			}
+			return addUserEventTx(ctx, tx, u.userID, "level_reward", map[string]any{"level": u.level, "reward": u.reward})
+		})
+		if errors.Is(err, ErrNotEnough) || errors.Is(err, ErrEmissionCap) {
+			// Out of reserve or over today's cap: the rest would fail too.
+			return paid, nil
+		}
+		if err != nil {
+			return paid, err
+		}
+		paid++
+	}
+	return paid, nil
+}
+
+// LevelUp is one level reached and its reward.
+type LevelUp struct {
+	Level     int64      `json:"level"`
+	Reward    int64      `json:"reward"`
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ListLevelUps returns the user's level-ups, highest first.
+func (d *DB) ListLevelUps(ctx context.Context, userID int64) ([]LevelUp, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT level, reward, paid_at, created_at FROM level_ups
+WHERE user_id=$1
+ORDER BY level DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LevelUp
+	for rows.Next() {
+		var l LevelUp
+		if err := rows.Scan(&l.Level, &l.Reward, &l.PaidAt, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
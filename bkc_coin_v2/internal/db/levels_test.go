@@ -0,0 +1,25 @@
+package db
+
+import "testing"
+
+func TestLevelProgress(t *testing.T) {
+	p := LevelPolicy{Thresholds: []int64{100, 300, 700}, RewardPerLevel: 10}
+	cases := []struct {
+		xp   int64
+		want Level
+	}{
+		{0, Level{Level: 1, XP: 0, LevelXP: 0, NextLevelXP: 100, MaxLevel: 4}},
+		{99, Level{Level: 1, XP: 99, LevelXP: 0, NextLevelXP: 100, MaxLevel: 4}},
+		{100, Level{Level: 2, XP: 100, LevelXP: 100, NextLevelXP: 300, MaxLevel: 4}},
+		{699, Level{Level: 3, XP: 699, LevelXP: 300, NextLevelXP: 700, MaxLevel: 4}},
+		{5_000, Level{Level: 4, XP: 5_000, LevelXP: 700, NextLevelXP: -1, MaxLevel: 4}},
+	}
+	for _, c := range cases {
+		if got := p.Progress(c.xp); got != c.want {
+			t.Fatalf("xp %d: got %+v, want %+v", c.xp, got, c.want)
+		}
+	}
+	if p.Reward(1) != 0 || p.Reward(3) != 30 {
+		t.Fatalf("rewards: %d %d", p.Reward(1), p.Reward(3))
+	}
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Merchants are shops registered by an admin to accept BKC. A merchant
+// creates a payment request (an order of type OrderMerchant) and shows it as
+// a QR code of a Mini App link (startapp=pay_<code>); the payer pays it in
+// one step and the merchant is credited at once, minus the merchant fee
+// (FeeBP, fixed on the order when it is created) which goes to the reserve.
+// Paid orders are summed into daily settlement reports.
+
+// MerchantPayPrefix starts an order code in a Mini App start parameter.
+const MerchantPayPrefix = "pay_"
+
+// Order types of the orders table.
+const OrderMerchant = "merchant"
+
+// Merchant statuses.
+const (
+	MerchantActive    = "active"
+	MerchantSuspended = "suspended" // no new orders; pending ones can still be paid
+)
+
+// Order statuses.
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderCancelled = "cancelled"
+	OrderExpired   = "expired"
+)
+
+// MaxMerchantFeeBP caps the merchant fee at 10%.
+const MaxMerchantFeeBP = 1_000
+
+const maxOrderDescription = 200 // runes
+
+// ErrOrderClosed is a payment of an order that is paid, cancelled or expired.
+var ErrOrderClosed = errors.New("order closed")
+
+// Merchant is a registered shop.
+type Merchant struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	FeeBP     int64     `json:"fee_bp"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MerchantOrder is a payment request.
+type MerchantOrder struct {
+	OrderID     int64      `json:"order_id"`
+	Code        string     `json:"code"`
+	MerchantID  int64      `json:"merchant_id"`
+	PayerID     *int64     `json:"payer_id,omitempty"`
+	Amount      int64      `json:"amount"`
+	Fee         int64      `json:"fee"`
+	Description string     `json:"description"`
+	ExternalRef string     `json:"external_ref"` // the merchant's own order id
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// MerchantReport is one day of a merchant's settlements.
+type MerchantReport struct {
+	Day       time.Time `json:"day"`
+	Orders    int64     `json:"orders"`
+	Gross     int64     `json:"gross"`
+	Fees      int64     `json:"fees"`
+	Net       int64     `json:"net"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const merchantOrderCols = `order_id, code, merchant_id, payer_id, amount, fee, description, external_ref, status, expires_at, paid_at, created_at`
+
+func scanMerchantOrder(row pgx.Row) (MerchantOrder, error) {
+	var o MerchantOrder
+	err := row.Scan(&o.OrderID, &o.Code, &o.MerchantID, &o.PayerID, &o.Amount, &o.Fee, &o.Description, &o.ExternalRef, &o.Status, &o.ExpiresAt, &o.PaidAt, &o.CreatedAt)
+	return o, err
+}
+
+// merchantFee is the fee on amount, never all of it.
+func merchantFee(amount, feeBP int64) int64 {
+	return min(interestFromBP(amount, feeBP), amount)
+}
+
+// SetMerchant registers userID as a merchant or changes the terms.
+func (d *DB) SetMerchant(ctx context.Context, adminID, userID int64, name string, feeBP int64, status string) (Merchant, error) {
+	name = strings.TrimSpace(name)
+	if userID <= 0 || name == "" || utf8.RuneCountInString(name) > 64 {
+		return Merchant{}, errors.New("bad params")
+	}
+	if feeBP < 0 || feeBP > MaxMerchantFeeBP {
+		return Merchant{}, errors.New("bad fee_bp")
+	}
+	if status != MerchantActive && status != MerchantSuspended {
+		return Merchant{}, errors.New("bad status")
+	}
+	var m Merchant
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+INSERT INTO merchants(user_id, name, fee_bp, status, created_by) VALUES($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE SET name = EXCLUDED.name, fee_bp = EXCLUDED.fee_bp, status = EXCLUDED.status
+RETURNING user_id, name, fee_bp, status, created_at
+`, userID, name, feeBP, status, adminID).Scan(&m.UserID, &m.Name, &m.FeeBP, &m.Status, &m.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_merchant', $1, $2, 0, $3::jsonb)`,
+			adminID, userID, toJSON(map[string]any{"name": name, "fee_bp": feeBP, "status": status}))
+		return err
+	})
+	return m, err
+}
+
+// GetMerchant returns the merchant; pgx.ErrNoRows if userID is not one.
+func (d *DB) GetMerchant(ctx context.Context, userID int64) (Merchant, error) {
+	var m Merchant
+	err := d.Pool.QueryRow(ctx, `SELECT user_id, name, fee_bp, status, created_at FROM merchants WHERE user_id=$1`, userID).
+		Scan(&m.UserID, &m.Name, &m.FeeBP, &m.Status, &m.CreatedAt)
+	return m, err
+}
+
+// ListMerchants returns every merchant.
+func (d *DB) ListMerchants(ctx context.Context) ([]Merchant, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT user_id, name, fee_bp, status, created_at FROM merchants ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Merchant{}
+	for rows.Next() {
+		var m Merchant
+		if err := rows.Scan(&m.UserID, &m.Name, &m.FeeBP, &m.Status, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// CreateMerchantOrder opens a payment request valid for ttl. ErrForbidden if
+// merchantID is not an active merchant.
+func (d *DB) CreateMerchantOrder(ctx context.Context, merchantID, amount int64, description, externalRef string, ttl time.Duration) (MerchantOrder, error) {
+	description = strings.TrimSpace(description)
+	externalRef = strings.TrimSpace(externalRef)
+	if amount <= 0 || ttl <= 0 || utf8.RuneCountInString(description) > maxOrderDescription || len(externalRef) > 64 {
+		return MerchantOrder{}, errors.New("bad params")
+	}
+	m, err := d.GetMerchant(ctx, merchantID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && m.Status != MerchantActive) {
+		return MerchantOrder{}, ErrForbidden
+	}
+	if err != nil {
+		return MerchantOrder{}, err
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return MerchantOrder{}, err
+	}
+	return scanMerchantOrder(d.Pool.QueryRow(ctx, `
+INSERT INTO orders(order_type, code, merchant_id, amount, fee, description, external_ref, expires_at)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING `+merchantOrderCols,
+		OrderMerchant, hex.EncodeToString(buf), merchantID, amount, merchantFee(amount, m.FeeBP), description, externalRef, d.now().Add(ttl)))
+}
+
+// GetMerchantOrder returns the order of code (the pay_ prefix is accepted)
+// with its merchant's name, for the payer's confirmation screen.
+func (d *DB) GetMerchantOrder(ctx context.Context, code string) (MerchantOrder, string, error) {
+	code = strings.TrimPrefix(strings.TrimSpace(code), MerchantPayPrefix)
+	var name string
+	o, err := scanMerchantOrder(d.Pool.QueryRow(ctx, `SELECT `+merchantOrderCols+` FROM orders WHERE order_type=$1 AND code=$2`, OrderMerchant, code))
+	if err != nil {
+		return MerchantOrder{}, "", err
+	}
+	if err := d.Pool.QueryRow(ctx, `SELECT name FROM merchants WHERE user_id=$1`, o.MerchantID).Scan(&name); err != nil {
+		return MerchantOrder{}, "", err
+	}
+	return o, name, nil
+}
+
+// PayMerchantOrder pays the order of code from payerID's spendable balance and
+// settles it to the merchant at once.
+func (d *DB) PayMerchantOrder(ctx context.Context, payerID int64, code string) (MerchantOrder, error) {
+	code = strings.TrimPrefix(strings.TrimSpace(code), MerchantPayPrefix)
+	var out MerchantOrder
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := scanMerchantOrder(tx.QueryRow(ctx, `SELECT `+merchantOrderCols+` FROM orders WHERE order_type=$1 AND code=$2 FOR UPDATE`, OrderMerchant, code))
+		if err != nil {
+			return err
+		}
+		if o.Status != OrderPending || !d.now().Before(o.ExpiresAt) {
+			return ErrOrderClosed
+		}
+		if o.MerchantID == payerID {
+			return errors.New("bad payer: own order")
+		}
+		if err := lockUsersTx(ctx, tx, payerID, o.MerchantID); err != nil {
+			return err
+		}
+		if err := debitSpendableTx(ctx, tx, payerID, o.Amount); err != nil {
+			return err
+		}
+		net := o.Amount - o.Fee
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, net, o.MerchantID); err != nil {
+			return err
+		}
+		meta := map[string]any{"order_id": o.OrderID, "amount": o.Amount, "fee": o.Fee}
+		if o.ExternalRef != "" {
+			meta["external_ref"] = o.ExternalRef
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('merchant_payment', $1, $2, $3, $4::jsonb)`,
+			payerID, o.MerchantID, net, toJSON(meta)); err != nil {
+			return err
+		}
+		if o.Fee > 0 {
+			if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, o.Fee); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('merchant_fee', $1, NULL, $2, $3::jsonb)`,
+				payerID, o.Fee, toJSON(meta)); err != nil {
+				return err
+			}
+		}
+		out, err = scanMerchantOrder(tx.QueryRow(ctx, `
+UPDATE orders SET status='paid', payer_id=$2, paid_at=now() WHERE order_id=$1
+RETURNING `+merchantOrderCols, o.OrderID, payerID))
+		if err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, o.MerchantID, "merchant_paid", map[string]any{
+			"order_id": o.OrderID, "amount": o.Amount, "net": net, "external_ref": o.ExternalRef, "payer_id": payerID,
+		})
+	})
+	return out, err
+}
+
+// CancelMerchantOrder withdraws a pending order of the merchant.
+func (d *DB) CancelMerchantOrder(ctx context.Context, merchantID, orderID int64) error {
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE orders SET status='cancelled'
+WHERE order_id=$1 AND order_type=$2 AND merchant_id=$3 AND status='pending'
+`, orderID, OrderMerchant, merchantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListMerchantOrders returns the merchant's orders, newest first; an empty
+// status lists all.
+func (d *DB) ListMerchantOrders(ctx context.Context, merchantID int64, status string, limit int) ([]MerchantOrder, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+merchantOrderCols+` FROM orders
+WHERE order_type=$1 AND merchant_id=$2 AND ($3 = '' OR status = $3)
+ORDER BY order_id DESC
+LIMIT $4
+`, OrderMerchant, merchantID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MerchantOrder{}
+	for rows.Next() {
+		o, err := scanMerchantOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// ExpireMerchantOrders marks pending orders past their expiry; nothing is
+// held for them.
+func (d *DB) ExpireMerchantOrders(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := d.Pool.Exec(ctx, `UPDATE orders SET status='expired' WHERE order_type=$1 AND status='pending' AND expires_at <= $2`, OrderMerchant, now)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// BuildMerchantReports sums the orders paid on day (UTC) for every merchant
+// with any; a day is reported once. Merchants get a merchant_report event.
+func (d *DB) BuildMerchantReports(ctx context.Context, day time.Time) (int64, error) {
+	day = dayUTC(day)
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+INSERT INTO merchant_reports(merchant_id, day, orders, gross, fees, net)
+SELECT merchant_id, ($2::timestamptz AT TIME ZONE 'UTC')::date, COUNT(*), SUM(amount), SUM(fee), SUM(amount - fee)
+FROM orders
+WHERE order_type=$1 AND status='paid' AND paid_at >= $2 AND paid_at < $2 + interval '1 day'
+GROUP BY merchant_id
+ON CONFLICT (merchant_id, day) DO NOTHING
+RETURNING merchant_id, orders, gross, fees, net
+`, OrderMerchant, day)
+		if err != nil {
+			return err
+		}
+		type built struct{ merchantID, orders, gross, fees, net int64 }
+		var list []built
+		for rows.Next() {
+			var b built
+			if err := rows.Scan(&b.merchantID, &b.orders, &b.gross, &b.fees, &b.net); err != nil {
+				rows.Close()
+				return err
+			}
+			list = append(list, b)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, b := range list {
+			if err := addUserEventTx(ctx, tx, b.merchantID, "merchant_report", map[string]any{
+				"day": day.Format("2006-01-02"), "orders": b.orders, "gross": b.gross, "fees": b.fees, "net": b.net,
+			}); err != nil {
+				return err
+			}
+		}
+		n = int64(len(list))
+		return nil
+	})
+	return n, err
+}
+
+// ListMerchantReports returns the merchant's daily reports, newest first.
+func (d *DB) ListMerchantReports(ctx context.Context, merchantID int64, limit int) ([]MerchantReport, error) {
+	if limit <= 0 || limit > 366 {
+		limit = 30
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT day, orders, gross, fees, net, created_at FROM merchant_reports
+WHERE merchant_id=$1
+ORDER BY day DESC
+LIMIT $2
+`, merchantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MerchantReport{}
+	for rows.Next() {
+		var r MerchantReport
+		if err := rows.Scan(&r.Day, &r.Orders, &r.Gross, &r.Fees, &r.Net, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+func TestMerchantFee(t *testing.T) {
+	cases := []struct{ amount, feeBP, want int64 }{
+		{10_000, 100, 100},
+		{99, 100, 0}, // rounds down
+		{10_000, 0, 0},
+		{1_000, 1_000, 100},
+	}
+	for _, c := range cases {
+		if got := merchantFee(c.amount, c.feeBP); got != c.want {
+			t.Fatalf("fee(%d, %dbp) = %d, want %d", c.amount, c.feeBP, got, c.want)
+		}
+	}
+}
+
+func TestPayMerchantOrder(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const merchant, payer = 9_301_600_001, 9_301_600_002
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM orders WHERE merchant_id=$1`, merchant)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM merchants WHERE user_id=$1`, merchant)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, merchant, payer)
+	t.Cleanup(cleanup)
+	if _, err := d.SetMerchant(ctx, 1, merchant, "shop", 500, MerchantActive); err != nil {
+		t.Fatal(err)
+	}
+	before := moneySystem(t, d)
+
+	o, err := d.CreateMerchantOrder(ctx, merchant, 200, "coffee", "ref-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid, err := d.PayMerchantOrder(ctx, payer, MerchantPayPrefix+o.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.Status != OrderPaid || paid.Fee != 10 {
+		t.Fatalf("order: %+v", paid)
+	}
+	if _, err := d.PayMerchantOrder(ctx, payer, o.Code); !errors.Is(err, ErrOrderClosed) {
+		t.Fatalf("paid twice: %v", err)
+	}
+
+	// An expired order takes no money, before the expiry job and after.
+	o, err = d.CreateMerchantOrder(ctx, merchant, 300, "", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Minute)
+	if _, err := d.PayMerchantOrder(ctx, payer, o.Code); !errors.Is(err, ErrOrderClosed) {
+		t.Fatalf("paid a lapsed order: %v", err)
+	}
+	if _, err := d.ExpireMerchantOrders(ctx, clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayMerchantOrder(ctx, payer, o.Code); !errors.Is(err, ErrOrderClosed) {
+		t.Fatalf("paid an expired order: %v", err)
+	}
+
+	if got := checkLedger(t, d, payer, 1_000); got != 1_000-200 {
+		t.Fatalf("payer balance %d", got)
+	}
+	if got := checkLedger(t, d, merchant, 1_000); got != 1_000+190 {
+		t.Fatalf("merchant balance %d", got)
+	}
+	if s := moneySystem(t, d); s.ReserveSupply != before.ReserveSupply+10 {
+		t.Fatalf("reserve %d, want %d", s.ReserveSupply, before.ReserveSupply+10)
+	}
+}
@@ -0,0 +1,419 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrMergePlanChanged means the accounts changed between the dry run and the
+// merge; the admin has to review a fresh plan.
+var ErrMergePlanChanged = errors.New("merge plan changed")
+
+// mergeMoves are the user references re-pointed from the source to the target
+// account as-is. Tables with per-user keys are merged separately (mergeTx);
+// security data (sessions, 2FA, login geos, step-up challenges) and daily
+// quotas stay with the source.
+var mergeMoves = []struct{ Table, Column string }{
+	{"cryptopay_invoices", "user_id"},
+	{"deposits", "user_id"},
+	{"withdrawals", "user_id"},
+	{"bank_loans", "user_id"},
+	{"p2p_loans", "lender_id"},
+	{"p2p_loans", "borrower_id"},
+	{"p2p_loan_offers", "lender_id"},
+	{"market_listings", "seller_id"},
+	{"market_listings", "buyer_id"},
+	{"nfts", "creator_id"},
+	{"nft_stakes", "user_id"},
+	{"nft_listings", "seller_id"},
+	{"nft_listings", "reserved_by"},
+	{"nft_sales", "seller_id"},
+	{"nft_sales", "buyer_id"},
+	{"nft_offers", "buyer_id"},
+	{"nft_offers", "seller_id"},
+	{"bundle_listings", "seller_id"},
+	{"bundle_listings", "buyer_id"},
+	{"nft_rental_offers", "owner_id"},
+	{"nft_rentals", "owner_id"},
+	{"nft_rentals", "renter_id"},
+	{"nft_drop_orders", "user_id"},
+	{"listing_promotions", "seller_id"},
+	{"seller_reviews", "seller_id"},
+	{"saved_searches", "user_id"},
+	{"user_events", "user_id"},
+	{"admin_vesting", "beneficiary_id"},
+	{"user_bonuses", "user_id"},
+	{"gifts", "sender_id"},
+	{"gifts", "claimed_by"},
+	{"bills", "creator_id"},
+	{"bills", "payee_id"},
+	{"referrals", "referrer_id"},
+	{"gig_contracts", "buyer_id"},
+	{"gig_contracts", "seller_id"},
+	{"disputes", "opened_by"},
+	{"orders", "merchant_id"},
+	{"orders", "payer_id"},
+	{"tips", "from_id"},
+	{"tips", "to_id"},
+	{"recurring_tips", "from_id"},
+	{"recurring_tips", "to_id"},
+	{"ledger", "from_id"},
+	{"ledger", "to_id"},
+}
+
+// MergeAccount is the part of a user row a merge changes.
+type MergeAccount struct {
+	UserID         int64  `json:"user_id"`
+	Username       string `json:"username"`
+	Balance        int64  `json:"balance"`
+	FrozenBalance  int64  `json:"frozen_balance"`
+	TapsTotal      int64  `json:"taps_total"`
+	XP             int64  `json:"xp"`
+	Level          int64  `json:"level"`
+	ReferralsCount int64  `json:"referrals_count"`
+	NFTs           int64  `json:"nfts"` // copies owned
+	GameCredits    int64  `json:"game_credits"`
+}
+
+// MergeMove is how many rows of Table.Column point at the source account.
+type MergeMove struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Rows   int64  `json:"rows"`
+}
+
+// MergePlan is the dry-run diff of merging From into To. Conflicts block the
+// merge; Token must be passed back to MergeUsers to confirm exactly this plan.
+type MergePlan struct {
+	From      MergeAccount `json:"from"`
+	To        MergeAccount `json:"to"`
+	After     MergeAccount `json:"after"`
+	Moves     []MergeMove  `json:"moves"`
+	Conflicts []string     `json:"conflicts"`
+	Token     string       `json:"token"`
+}
+
+// AccountMerge is the audit record of a completed merge.
+type AccountMerge struct {
+	MergeID   int64     `json:"merge_id"`
+	FromID    int64     `json:"from_id"`
+	ToID      int64     `json:"to_id"`
+	AdminID   int64     `json:"admin_id"`
+	Plan      MergePlan `json:"plan"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func scanMergeAccount(ctx context.Context, tx pgx.Tx, userID int64, lock bool) (MergeAccount, *int64, error) {
+	q := `
+SELECT user_id, COALESCE(username,''), balance, frozen_balance, taps_total, xp, level, referrals_count, game_credits, merged_into
+FROM users WHERE user_id=$1`
+	if lock {
+		q += ` FOR UPDATE`
+	}
+	var a MergeAccount
+	var mergedInto *int64
+	err := tx.QueryRow(ctx, q, userID).Scan(&a.UserID, &a.Username, &a.Balance, &a.FrozenBalance, &a.TapsTotal, &a.XP, &a.Level, &a.ReferralsCount, &a.GameCredits, &mergedInto)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return MergeAccount{}, nil, fmt.Errorf("bad user %d", userID)
+	}
+	if err != nil {
+		return MergeAccount{}, nil, err
+	}
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(SUM(qty), 0) FROM nft_owns WHERE user_id=$1`, userID).Scan(&a.NFTs); err != nil {
+		return MergeAccount{}, nil, err
+	}
+	return a, mergedInto, nil
+}
+
+// planMergeTx builds the plan; lock=true locks both users (in id order).
+func planMergeTx(ctx context.Context, tx pgx.Tx, fromID, toID int64, lock bool) (MergePlan, error) {
+	if fromID <= 0 || toID <= 0 || fromID == toID {
+		return MergePlan{}, errors.New("bad params")
+	}
+	var p MergePlan
+	first, second := fromID, toID
+	if second < first {
+		first, second = second, first
+	}
+	accounts := map[int64]MergeAccount{}
+	for _, id := range []int64{first, second} {
+		a, mergedInto, err := scanMergeAccount(ctx, tx, id, lock)
+		if err != nil {
+			return MergePlan{}, err
+		}
+		if mergedInto != nil {
+			p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d was already merged into %d", id, *mergedInto))
+		}
+		accounts[id] = a
+	}
+	p.From, p.To = accounts[fromID], accounts[toID]
+	p.After = p.To
+	p.After.Balance += p.From.Balance
+	p.After.FrozenBalance += p.From.FrozenBalance
+	p.After.TapsTotal += p.From.TapsTotal
+	p.After.XP += p.From.XP
+	p.After.Level = max(p.To.Level, p.From.Level)
+	p.After.ReferralsCount += p.From.ReferralsCount
+	p.After.NFTs += p.From.NFTs
+	p.After.GameCredits += p.From.GameCredits
+
+	for _, m := range mergeMoves {
+		var n int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM `+m.Table+` WHERE `+m.Column+`=$1`, fromID).Scan(&n); err != nil {
+			return MergePlan{}, err
+		}
+		if n > 0 {
+			p.Moves = append(p.Moves, MergeMove{Table: m.Table, Column: m.Column, Rows: n})
+		}
+	}
+
+	var open int64
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM withdrawals WHERE user_id=$1 AND status IN ('held','pending')`, fromID).Scan(&open); err != nil {
+		return MergePlan{}, err
+	}
+	if open > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d has %d open withdrawals", fromID, open))
+	}
+	// Money still owed to or held for the source would be paid out to the
+	// emptied account: it has to settle first. An affiliate account is keyed
+	// by the user, so its referrals and commission cannot move.
+	var affiliate, bonuses, gifts, bills int64
+	if err := tx.QueryRow(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM affiliates WHERE user_id=$1),
+  (SELECT COUNT(*) FROM user_bonuses WHERE user_id=$1 AND status IN ('pending','active','review')),
+  (SELECT COUNT(*) FROM gifts WHERE sender_id=$1 AND status='pending'),
+  (SELECT COUNT(*) FROM bills b WHERE b.status='open' AND (b.creator_id=$1 OR b.payee_id=$1
+     OR EXISTS (SELECT 1 FROM bill_shares s WHERE s.bill_id=b.bill_id AND s.user_id=$1)))
+`, fromID).Scan(&affiliate, &bonuses, &gifts, &bills); err != nil {
+		return MergePlan{}, err
+	}
+	if affiliate > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d is an affiliate", fromID))
+	}
+	if bonuses > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d has %d open bonuses", fromID, bonuses))
+	}
+	if gifts > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d has %d unclaimed gifts", fromID, gifts))
+	}
+	if bills > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("user %d is in %d open bills", fromID, bills))
+	}
+	if err := tx.QueryRow(ctx, `
+SELECT COUNT(*) FROM p2p_loans
+WHERE status IN ('requested','active')
+  AND ((lender_id=$1 AND borrower_id=$2) OR (lender_id=$2 AND borrower_id=$1))
+`, fromID, toID).Scan(&open); err != nil {
+		return MergePlan{}, err
+	}
+	if open > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("%d open p2p loans between the accounts", open))
+	}
+	if err := tx.QueryRow(ctx, `
+SELECT COUNT(*) FROM recurring_tips
+WHERE active AND ((from_id=$1 AND to_id=$2) OR (from_id=$2 AND to_id=$1))
+`, fromID, toID).Scan(&open); err != nil {
+		return MergePlan{}, err
+	}
+	if open > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("%d recurring tips between the accounts", open))
+	}
+	// A shop and a creator page are keyed by their user; two cannot become
+	// one.
+	var merchants, creators int64
+	if err := tx.QueryRow(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM merchants WHERE user_id IN ($1, $2)),
+  (SELECT COUNT(*) FROM creator_profiles WHERE user_id IN ($1, $2))
+`, fromID, toID).Scan(&merchants, &creators); err != nil {
+		return MergePlan{}, err
+	}
+	if merchants > 1 {
+		p.Conflicts = append(p.Conflicts, "both accounts are merchants")
+	}
+	if creators > 1 {
+		p.Conflicts = append(p.Conflicts, "both accounts have creator profiles")
+	}
+	// Moved as-is, a contract between the two would have one party.
+	if err := tx.QueryRow(ctx, `
+SELECT COUNT(*) FROM gig_contracts
+WHERE status='open' AND ((buyer_id=$1 AND seller_id=$2) OR (buyer_id=$2 AND seller_id=$1))
+`, fromID, toID).Scan(&open); err != nil {
+		return MergePlan{}, err
+	}
+	if open > 0 {
+		p.Conflicts = append(p.Conflicts, fmt.Sprintf("%d open gig contracts between the accounts", open))
+	}
+	p.Token = p.token()
+	return p, nil
+}
+
+// token fingerprints everything the merge would change.
+func (p MergePlan) token() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%+v|%+v", p.From, p.To)
+	for _, m := range p.Moves {
+		fmt.Fprintf(&b, "|%s.%s=%d", m.Table, m.Column, m.Rows)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// PlanMerge is the dry run: what merging fromID into toID would move.
+func (d *DB) PlanMerge(ctx context.Context, fromID, toID int64) (MergePlan, error) {
+	var p MergePlan
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		p, err = planMergeTx(ctx, tx, fromID, toID, false)
+		return err
+	})
+	return p, err
+}
+
+// MergeUsers moves everything of fromID to toID in one transaction. token
+// must match a fresh plan, so nothing changed since the admin reviewed the
+// dry run. The source account is kept, empty, with merged_into set and its
+// sessions revoked. Irreversible.
+func (d *DB) MergeUsers(ctx context.Context, adminID, fromID, toID int64, token string) (AccountMerge, error) {
+	var out AccountMerge
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		p, err := planMergeTx(ctx, tx, fromID, toID, true)
+		if err != nil {
+			return err
+		}
+		if len(p.Conflicts) > 0 {
+			return fmt.Errorf("bad merge: %s", strings.Join(p.Conflicts, "; "))
+		}
+		if token == "" || token != p.Token {
+			return ErrMergePlanChanged
+		}
+		if err := mergeTx(ctx, tx, fromID, toID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('account_merge', $1, $2, $3, $4::jsonb)
+`, fromID, toID, p.From.Balance, toJSON(map[string]any{"by": adminID, "frozen": p.From.FrozenBalance, "nfts": p.From.NFTs})); err != nil {
+			return err
+		}
+		if err := addUserEventTx(ctx, tx, toID, "account_merged", map[string]any{"from_id": fromID, "balance": p.From.Balance}); err != nil {
+			return err
+		}
+		out = AccountMerge{FromID: fromID, ToID: toID, AdminID: adminID, Plan: p}
+		return tx.QueryRow(ctx, `
+INSERT INTO account_merges(from_id, to_id, admin_id, plan)
+VALUES($1,$2,$3,$4::jsonb)
+RETURNING merge_id, created_at
+`, fromID, toID, adminID, toJSON(p)).Scan(&out.MergeID, &out.CreatedAt)
+	})
+	if err != nil {
+		return AccountMerge{}, err
+	}
+	return out, nil
+}
+
+// mergeTx moves the rows; both users are locked by the caller.
+func mergeTx(ctx context.Context, tx pgx.Tx, fromID, toID int64) error {
+	steps := []string{
+		// Counters and balances.
+		`UPDATE users t SET
+   balance = t.balance + f.balance,
+   frozen_balance = t.frozen_balance + f.frozen_balance,
+   taps_total = t.taps_total + f.taps_total,
+   xp = t.xp + f.xp,
+   xp_taps = t.xp_taps + f.xp_taps,
+   level = GREATEST(t.level, f.level),
+   referrals_count = t.referrals_count + f.referrals_count,
+   game_credits = t.game_credits + f.game_credits
+ FROM users f
+ WHERE t.user_id = $2 AND f.user_id = $1`,
+		`UPDATE users SET balance=0, frozen_balance=0, taps_total=0, xp=0, xp_taps=0, referrals_count=0, game_credits=0,
+   merged_into=$2, sessions_revoked_before=now()
+ WHERE user_id=$1`,
+
+		// NFT copies: add to the target's rows for the same design.
+		// staked_qty follows the nft_stakes rows moved below.
+		`INSERT INTO nft_owns(user_id, nft_id, qty, listed_qty, staked_qty)
+ SELECT $2, nft_id, qty, listed_qty, staked_qty FROM nft_owns WHERE user_id=$1
+ ON CONFLICT (user_id, nft_id) DO UPDATE
+ SET qty = nft_owns.qty + EXCLUDED.qty, listed_qty = nft_owns.listed_qty + EXCLUDED.listed_qty,
+   staked_qty = nft_owns.staked_qty + EXCLUDED.staked_qty`,
+		`DELETE FROM nft_owns WHERE user_id=$1`,
+
+		// Per-user keys: keep the target's row where both have one.
+		`INSERT INTO watchlist(user_id, kind, target_id, last_price, created_at)
+ SELECT $2, kind, target_id, last_price, created_at FROM watchlist WHERE user_id=$1
+ ON CONFLICT DO NOTHING`,
+		`DELETE FROM watchlist WHERE user_id=$1`,
+		`INSERT INTO nft_wishes(user_id, nft_id, last_supply_left, seen_nft_id, created_at)
+ SELECT $2, nft_id, last_supply_left, seen_nft_id, created_at FROM nft_wishes WHERE user_id=$1
+ ON CONFLICT DO NOTHING`,
+		`DELETE FROM nft_wishes WHERE user_id=$1`,
+		`UPDATE digest_subscriptions SET user_id=$2
+ WHERE user_id=$1 AND NOT EXISTS (SELECT 1 FROM digest_subscriptions WHERE user_id=$2)`,
+		`DELETE FROM digest_subscriptions WHERE user_id=$1`,
+		// Variants are hashed from the user id: the target keeps its own.
+		`DELETE FROM experiment_exposures WHERE user_id=$1`,
+		`UPDATE level_ups f SET user_id=$2
+ WHERE f.user_id=$1 AND NOT EXISTS (SELECT 1 FROM level_ups t WHERE t.user_id=$2 AND t.level=f.level)`,
+		`UPDATE seller_reviews f SET buyer_id=$2
+ WHERE f.buyer_id=$1 AND NOT EXISTS (SELECT 1 FROM seller_reviews t WHERE t.buyer_id=$2 AND t.kind=f.kind AND t.ref_id=f.ref_id)`,
+		`UPDATE referrals SET referred_id=$2
+ WHERE referred_id=$1 AND referrer_id<>$2 AND NOT EXISTS (SELECT 1 FROM referrals WHERE referred_id=$2)`,
+		`UPDATE storefronts SET seller_id=$2 WHERE seller_id=$1 AND NOT EXISTS (SELECT 1 FROM storefronts WHERE seller_id=$2)`,
+		`UPDATE user_plans SET user_id=$2 WHERE user_id=$1 AND NOT EXISTS (SELECT 1 FROM user_plans WHERE user_id=$2)`,
+		`UPDATE affiliate_referrals SET referred_id=$2
+ WHERE referred_id=$1 AND affiliate_id<>$2 AND NOT EXISTS (SELECT 1 FROM affiliate_referrals WHERE referred_id=$2)`,
+		`UPDATE bill_shares f SET user_id=$2
+ WHERE f.user_id=$1 AND NOT EXISTS (SELECT 1 FROM bill_shares t WHERE t.bill_id=f.bill_id AND t.user_id=$2)`,
+		// At most one of the two is a merchant or a creator (a plan conflict
+		// otherwise). Recurring tips to the creator follow as mergeMoves.
+		`UPDATE merchants SET user_id=$2 WHERE user_id=$1 AND NOT EXISTS (SELECT 1 FROM merchants WHERE user_id=$2)`,
+		`UPDATE creator_profiles SET user_id=$2 WHERE user_id=$1 AND NOT EXISTS (SELECT 1 FROM creator_profiles WHERE user_id=$2)`,
+		`UPDATE merchant_reports f SET merchant_id=$2
+ WHERE f.merchant_id=$1 AND NOT EXISTS (SELECT 1 FROM merchant_reports t WHERE t.merchant_id=$2 AND t.day=f.day)`,
+	}
+	for _, m := range mergeMoves {
+		steps = append(steps, `UPDATE `+m.Table+` SET `+m.Column+`=$2 WHERE `+m.Column+`=$1`)
+	}
+	for _, q := range steps {
+		if _, err := tx.Exec(ctx, q, fromID, toID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAccountMerges returns completed merges, newest first.
+func (d *DB) ListAccountMerges(ctx context.Context, limit int) ([]AccountMerge, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT merge_id, from_id, to_id, admin_id, plan, created_at
+FROM account_merges
+ORDER BY merge_id DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AccountMerge
+	for rows.Next() {
+		var m AccountMerge
+		if err := rows.Scan(&m.MergeID, &m.FromID, &m.ToID, &m.AdminID, &m.Plan, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
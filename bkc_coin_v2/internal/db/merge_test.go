@@ -0,0 +1,330 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergePlanToken(t *testing.T) {
+	p := MergePlan{From: MergeAccount{UserID: 1, Balance: 10}, To: MergeAccount{UserID: 2}}
+	q := p
+	q.Moves = []MergeMove{{Table: "deposits", Column: "user_id", Rows: 1}}
+	if p.token() == q.token() {
+		t.Fatal("token ignores moves")
+	}
+	q = p
+	q.From.Balance = 11
+	if p.token() == q.token() {
+		t.Fatal("token ignores balances")
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const fromID, toID = 990_001, 990_002
+	for _, id := range []int64{fromID, toID} {
+		if _, err := d.EnsureUser(ctx, id, "", "", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM users WHERE user_id IN ($1,$2)`, fromID, toID)
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM account_merges WHERE from_id=$1`, fromID)
+	})
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=500, game_credits=30, merged_into=NULL WHERE user_id=$1`, fromID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=100, game_credits=0, merged_into=NULL WHERE user_id=$1`, toID); err != nil {
+		t.Fatal(err)
+	}
+	// One of the source's two copies is staked; the target owns one too.
+	nftID, err := d.CreateNFT(ctx, "merge", "https://example.com/merge.png", 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_stakes WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_owns WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nfts WHERE nft_id=$1`, nftID)
+	})
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $3, 2), ($2, $3, 1)`, fromID, toID, nftID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.StakeNFT(ctx, fromID, nftID, 1, 10, 7); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := d.CreateP2PLoanOffer(ctx, P2PLoanOffer{LenderID: fromID, MinAmount: 10, MaxAmount: 100, InterestBP: 500, TermDays: 7, MaxExposure: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM p2p_loan_offers WHERE offer_id=$1`, offer.OfferID)
+	})
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.After.Balance != 600 || plan.After.GameCredits != 30 || len(plan.Conflicts) != 0 {
+		t.Fatalf("plan: %+v", plan)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, "stale"); !errors.Is(err, ErrMergePlanChanged) {
+		t.Fatalf("stale token: %v", err)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	to, err := d.GetUser(ctx, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := d.GetUser(ctx, fromID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to.Balance != 600 || from.Balance != 0 {
+		t.Fatalf("balances after merge: to=%d from=%d", to.Balance, from.Balance)
+	}
+	var credits, left int64
+	if err := d.Pool.QueryRow(ctx, `SELECT (SELECT game_credits FROM users WHERE user_id=$1), (SELECT game_credits FROM users WHERE user_id=$2)`, toID, fromID).Scan(&credits, &left); err != nil {
+		t.Fatal(err)
+	}
+	if credits != 30 || left != 0 {
+		t.Fatalf("game credits after merge: to=%d from=%d", credits, left)
+	}
+	var qty, staked, stakes int64
+	if err := d.Pool.QueryRow(ctx, `SELECT qty, staked_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2`, toID, nftID).Scan(&qty, &staked); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM nft_stakes WHERE user_id=$1 AND nft_id=$2`, toID, nftID).Scan(&stakes); err != nil {
+		t.Fatal(err)
+	}
+	if qty != 3 || staked != 1 || stakes != 1 {
+		t.Fatalf("nft after merge: qty %d, staked %d, stakes %d", qty, staked, stakes)
+	}
+	var lender int64
+	if err := d.Pool.QueryRow(ctx, `SELECT lender_id FROM p2p_loan_offers WHERE offer_id=$1`, offer.OfferID).Scan(&lender); err != nil {
+		t.Fatal(err)
+	}
+	if lender != toID {
+		t.Fatalf("standing offer left with lender %d", lender)
+	}
+	// The emptied source cannot be merged again.
+	again, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Conflicts) == 0 {
+		t.Fatal("merged account has no conflict")
+	}
+}
+
+func TestMergeBlockedByPendingMoney(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const fromID, toID = 9_301_400_001, 9_301_400_002
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM gifts WHERE sender_id=$1`, fromID)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, fromID, toID)
+	t.Cleanup(cleanup)
+
+	// A gift refunded after the merge would land on the emptied account.
+	if _, _, err := d.CreateGift(ctx, fromID, 100, "", time.Hour, VelocityPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || !strings.Contains(plan.Conflicts[0], "unclaimed gifts") {
+		t.Fatalf("conflicts: %v", plan.Conflicts)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err == nil {
+		t.Fatal("merged with a pending gift")
+	}
+}
+
+func TestMergeMovesGigContracts(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const fromID, toID, seller = 9_301_400_011, 9_301_400_012, 9_301_400_013
+	seedMoneyUsers(t, d, 1_000, fromID, toID, seller)
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM account_merges WHERE from_id=$1`, fromID)
+	})
+
+	var listingID int64
+	if err := d.Pool.QueryRow(ctx, `
+INSERT INTO market_listings(seller_id, title, description, category, price_coins, contact)
+VALUES($1, 'merge gig', 'merge', $2, 100, '@merge')
+RETURNING listing_id
+`, seller, ServicesCategory).Scan(&listingID); err != nil {
+		t.Fatal(err)
+	}
+	gig, err := d.CreateGig(ctx, fromID, listingID, []GigMilestoneInput{{Title: "work", Amount: 100, Deadline: time.Now().Add(48 * time.Hour)}}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	milestone := gig.Milestones[0].MilestoneID
+	if _, err := d.FundGigMilestone(ctx, fromID, milestone); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	// The escrow now answers to the target account.
+	if _, err := d.ReleaseGigMilestone(ctx, fromID, milestone); err == nil {
+		t.Fatal("merged source released the escrow")
+	}
+	if _, err := d.ReleaseGigMilestone(ctx, toID, milestone); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkLedger(t, d, seller, 1_000); got != 1_100 {
+		t.Fatalf("seller balance %d", got)
+	}
+}
+
+func TestMergeMovesMerchant(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const fromID, toID, payer = 9_301_400_021, 9_301_400_022, 9_301_400_023
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM orders WHERE merchant_id IN ($1, $2)`, fromID, toID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM merchants WHERE user_id IN ($1, $2)`, fromID, toID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM account_merges WHERE from_id=$1`, fromID)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, fromID, toID, payer)
+	t.Cleanup(cleanup)
+
+	if _, err := d.SetMerchant(ctx, 1, fromID, "shop", 0, MerchantActive); err != nil {
+		t.Fatal(err)
+	}
+	o, err := d.CreateMerchantOrder(ctx, fromID, 100, "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PayMerchantOrder(ctx, payer, o.Code); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := d.GetMerchant(ctx, toID); err != nil || m.Name != "shop" {
+		t.Fatalf("merchant after merge: %+v, %v", m, err)
+	}
+	// The paid order and its ledger row point at the same account, as the
+	// merchant_orders_booked invariant expects.
+	var booked bool
+	if err := d.Pool.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM orders o JOIN ledger l ON l.kind='merchant_payment' AND l.to_id=o.merchant_id AND l.meta->>'order_id' = o.order_id::text
+               WHERE o.order_id=$1 AND o.merchant_id=$2)
+`, o.OrderID, toID).Scan(&booked); err != nil {
+		t.Fatal(err)
+	}
+	if !booked {
+		t.Fatal("order and its payment split by the merge")
+	}
+}
+
+func TestMergeMovesRecurringTips(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const fromID, toID, creator = 9_301_400_031, 9_301_400_032, 9_301_400_033
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM recurring_tips WHERE from_id IN ($1, $2)`, fromID, toID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM account_merges WHERE from_id=$1`, fromID)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, fromID, toID, creator)
+	t.Cleanup(cleanup)
+
+	between, err := d.CreateRecurringTip(ctx, toID, fromID, 10, TipDaily, TipPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateRecurringTip(ctx, fromID, creator, 10, TipDaily, TipPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	// Moved, a recurring tip between the two would tip itself.
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || !strings.Contains(plan.Conflicts[0], "recurring tips") {
+		t.Fatalf("conflicts: %v", plan.Conflicts)
+	}
+	if err := d.CancelRecurringTip(ctx, toID, between.RecurringID); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = d.PlanMerge(ctx, fromID, toID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	tips, err := d.ListRecurringTips(ctx, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Active first: the one to the creator, then the cancelled one.
+	if len(tips) != 2 || tips[0].ToID != creator || !tips[0].Active || tips[1].Active {
+		t.Fatalf("recurring tips of the target: %+v", tips)
+	}
+}
+
+func TestMergeMovesCreatorProfile(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const fromID, toID = 9_301_400_041, 9_301_400_042
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM creator_profiles WHERE user_id IN ($1, $2)`, fromID, toID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM account_merges WHERE from_id=$1`, fromID)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, fromID, toID)
+	t.Cleanup(cleanup)
+
+	for _, id := range []int64{fromID, toID} {
+		if _, err := d.SaveCreatorProfile(ctx, id, CreatorProfileUpdate{DisplayName: "creator"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := d.PlanMerge(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || !strings.Contains(plan.Conflicts[0], "creator profiles") {
+		t.Fatalf("conflicts: %v", plan.Conflicts)
+	}
+	if _, err := d.Pool.Exec(ctx, `DELETE FROM creator_profiles WHERE user_id=$1`, toID); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = d.PlanMerge(ctx, fromID, toID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MergeUsers(ctx, 1, fromID, toID, plan.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetCreatorProfile(ctx, toID); err != nil {
+		t.Fatalf("target profile: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Market listings are created pending and go live only after moderation.
+// The screening job runs the automated checks on each new listing: banned
+// keywords in the text, a nudity score of its images from a pluggable
+// classifier and a price far from the category median. A listing without
+// flags goes live at once; a flagged one waits in the queue for a moderator.
+// Sellers with several rejected listings can list only once per cooldown.
+
+// Listing statuses added by moderation.
+const (
+	ListingPending  = "pending"
+	ListingActive   = "active"
+	ListingRejected = "rejected"
+)
+
+// Moderation flags.
+const (
+	FlagBannedWord     = "banned_word"
+	FlagNudity         = "nudity"
+	FlagPriceAnomaly   = "price_anomaly"
+	FlagAboveMarket    = "above_market"    // over the recent sales median (prices.go)
+	FlagImageUnchecked = "image_unchecked" // the classifier failed
+)
+
+const (
+	screenBatch         = 50
+	priceMedianMinCount = 5 // active listings a category needs for a median
+)
+
+// ErrListingThrottled means a repeat offender listed again too soon.
+var ErrListingThrottled = errors.New("listing throttled")
+
+// ImageClassifier scores listing images; NudityScore is 0..1.
+type ImageClassifier interface {
+	NudityScore(ctx context.Context, mime string, data []byte) (float64, error)
+}
+
+// ModerationPolicy configures screening and offender throttling.
+type ModerationPolicy struct {
+	BannedWords      []string
+	NudityMax        float64 // images scoring above are flagged (0 = off)
+	PriceAnomalyX    int64   // prices over X times or under 1/X of the median are flagged (0 = off)
+	AboveMarketPct   int64   // prices this many percent over the sales median are flagged (0 = off)
+	PriceWindow      time.Duration
+	ScreenDelay      time.Duration
+	OffenderRejects  int64 // rejections within OffenderWindow that make a repeat offender (0 = off)
+	OffenderWindow   time.Duration
+	OffenderCooldown time.Duration // between listings of a repeat offender
+}
+
+// ListingModeration is a pending listing with its screening result.
+type ListingModeration struct {
+	Listing     MarketListing `json:"listing"`
+	Flags       []string      `json:"flags"`
+	Details     []string      `json:"details"`
+	NudityScore float64       `json:"nudity_score"`
+	PriceMedian int64         `json:"price_median"`
+	Rejections  int64         `json:"seller_rejections"` // within the offender window
+	ScreenedAt  time.Time     `json:"screened_at"`
+}
+
+// moderationTokens lowercases text into space-separated words with a space
+// at both ends, so phrases match on word boundaries.
+func moderationTokens(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+// bannedWordHits returns the banned words and phrases found in text.
+func bannedWordHits(text string, banned []string) []string {
+	tokens := moderationTokens(text)
+	var out []string
+	for _, w := range banned {
+		if t := moderationTokens(w); t != "  " && strings.Contains(tokens, t) {
+			out = append(out, strings.TrimSpace(t))
+		}
+	}
+	return out
+}
+
+// priceAnomalous reports whether price is over x times or under 1/x of the median.
+func priceAnomalous(price, median, x int64) bool {
+	if x <= 1 || median <= 0 {
+		return false
+	}
+	return price > median*x || price*x < median
+}
+
+// listingThrottledTx returns ErrListingThrottled when the seller is a repeat
+// offender who listed within the cooldown.
+func listingThrottledTx(ctx context.Context, tx pgx.Tx, sellerID int64, now time.Time, p ModerationPolicy) error {
+	if p.OffenderRejects <= 0 {
+		return nil
+	}
+	var rejects int64
+	var lastAt *time.Time
+	if err := tx.QueryRow(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM listing_moderation m JOIN market_listings l ON l.listing_id = m.listing_id
+   WHERE l.seller_id=$1 AND m.decision='rejected' AND m.decided_at >= $2),
+  (SELECT MAX(created_at) FROM market_listings WHERE seller_id=$1)
+`, sellerID, now.Add(-p.OffenderWindow)).Scan(&rejects, &lastAt); err != nil {
+		return err
+	}
+	if rejects >= p.OffenderRejects && lastAt != nil && now.Before(lastAt.Add(p.OffenderCooldown)) {
+		return ErrListingThrottled
+	}
+	return nil
+}
+
+// ScreenListings runs the automated checks on pending listings older than
+// the screen delay (time to upload images). The classifier may be nil;
+// images are then not scored.
+func (d *DB) ScreenListings(ctx context.Context, now time.Time, p ModerationPolicy, cls ImageClassifier) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT l.listing_id, l.title, l.description, l.category, l.price_coins
+FROM market_listings l
+LEFT JOIN listing_moderation m ON m.listing_id = l.listing_id
+WHERE l.status='pending' AND m.listing_id IS NULL AND l.created_at <= $1
+ORDER BY l.listing_id
+LIMIT $2
+`, now.Add(-p.ScreenDelay), screenBatch)
+	if err != nil {
+		return 0, err
+	}
+	var list []MarketListing
+	for rows.Next() {
+		var l MarketListing
+		if err := rows.Scan(&l.ListingID, &l.Title, &l.Description, &l.Category, &l.PriceCoins); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, l := range list {
+		if err := d.screenListing(ctx, l, p, cls); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// screenListing checks one listing and records the result; the classifier
+// is called outside the transaction.
+func (d *DB) screenListing(ctx context.Context, l MarketListing, p ModerationPolicy, cls ImageClassifier) error {
+	var flags, details []string
+	if hits := bannedWordHits(l.Title+"\n"+l.Description, p.BannedWords); len(hits) > 0 {
+		flags = append(flags, FlagBannedWord)
+		details = append(details, "keywords: "+strings.Join(hits, ", "))
+	}
+
+	var median int64
+	if p.PriceAnomalyX > 0 {
+		var count int64
+		if err := d.Pool.QueryRow(ctx, `
+SELECT COUNT(*), COALESCE(percentile_disc(0.5) WITHIN GROUP (ORDER BY price_coins), 0)
+FROM market_listings WHERE status='active' AND category=$1
+`, l.Category).Scan(&count, &median); err != nil {
+			return err
+		}
+		if count < priceMedianMinCount {
+			median = 0
+		}
+		if priceAnomalous(l.PriceCoins, median, p.PriceAnomalyX) {
+			flags = append(flags, FlagPriceAnomaly)
+		}
+	}
+	if p.AboveMarketPct > 0 {
+		market, err := d.SuggestPrice(ctx, PriceKindCategory, l.Category, time.Now().UTC().Add(-p.PriceWindow))
+		if err != nil {
+			return err
+		}
+		if market.Check(l.PriceCoins, p.AboveMarketPct); market.AboveMarket {
+			flags = append(flags, FlagAboveMarket)
+			details = append(details, fmt.Sprintf("%d%% above the sales median %d", market.AbovePct, market.Median))
+		}
+	}
+
+	var nudity float64
+	if cls != nil && p.NudityMax > 0 {
+		imgs, err := d.Pool.Query(ctx, `SELECT mime, data FROM market_listing_images WHERE listing_id=$1 ORDER BY image_id`, l.ListingID)
+		if err != nil {
+			return err
+		}
+		type image struct {
+			mime string
+			data []byte
+		}
+		var list []image
+		for imgs.Next() {
+			var img image
+			if err := imgs.Scan(&img.mime, &img.data); err != nil {
+				imgs.Close()
+				return err
+			}
+			list = append(list, img)
+		}
+		imgs.Close()
+		if err := imgs.Err(); err != nil {
+			return err
+		}
+		unchecked := false
+		for _, img := range list {
+			score, err := cls.NudityScore(ctx, img.mime, img.data)
+			if err != nil {
+				unchecked = true
+				continue
+			}
+			nudity = max(nudity, score)
+		}
+		if nudity > p.NudityMax {
+			flags = append(flags, FlagNudity)
+		}
+		if unchecked {
+			flags = append(flags, FlagImageUnchecked)
+		}
+	}
+	if flags == nil {
+		flags = []string{}
+	}
+	if details == nil {
+		details = []string{}
+	}
+
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+INSERT INTO listing_moderation (listing_id, flags, details, nudity_score, price_median)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (listing_id) DO NOTHING
+`, l.ListingID, flags, details, nudity, median)
+		if err != nil || tag.RowsAffected() == 0 || len(flags) > 0 {
+			return err
+		}
+		var sellerID int64
+		err = tx.QueryRow(ctx, `UPDATE market_listings SET status='active' WHERE listing_id=$1 AND status='pending' RETURNING seller_id`, l.ListingID).Scan(&sellerID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE listing_moderation SET decision='approved', decided_at=now() WHERE listing_id=$1`, l.ListingID); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, sellerID, "market_listing_approved", map[string]any{"listing_id": l.ListingID})
+	})
+}
+
+// ListModerationQueue returns screened pending listings, flagged ones and
+// then the oldest first.
+func (d *DB) ListModerationQueue(ctx context.Context, offenderWindow time.Duration, limit int64) ([]ListingModeration, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT l.listing_id, l.seller_id, l.title, l.description, l.category, l.price_coins, l.contact, l.status, l.created_at,
+       m.flags, m.details, m.nudity_score, m.price_median, m.screened_at,
+       (SELECT COUNT(*) FROM listing_moderation m2 JOIN market_listings l2 ON l2.listing_id = m2.listing_id
+        WHERE l2.seller_id = l.seller_id AND m2.decision='rejected' AND m2.decided_at >= $1)
+FROM market_listings l
+JOIN listing_moderation m ON m.listing_id = l.listing_id
+WHERE l.status='pending'
+ORDER BY cardinality(m.flags) DESC, l.created_at
+LIMIT $2
+`, time.Now().UTC().Add(-offenderWindow), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ListingModeration{}
+	for rows.Next() {
+		var it ListingModeration
+		l := &it.Listing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.Title, &l.Description, &l.Category, &l.PriceCoins, &l.Contact, &l.Status, &l.CreatedAt,
+			&it.Flags, &it.Details, &it.NudityScore, &it.PriceMedian, &it.ScreenedAt, &it.Rejections); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// ModerateListing approves or rejects a pending listing; a rejection counts
+// towards the seller's offender throttling.
+func (d *DB) ModerateListing(ctx context.Context, moderatorID, listingID int64, approve bool, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if listingID <= 0 || len(reason) > 500 || (!approve && reason == "") {
+		return errors.New("bad params")
+	}
+	status, decision := ListingRejected, "rejected"
+	if approve {
+		status, decision = ListingActive, "approved"
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var sellerID int64
+		err := tx.QueryRow(ctx, `UPDATE market_listings SET status=$2 WHERE listing_id=$1 AND status='pending' RETURNING seller_id`, listingID, status).Scan(&sellerID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrLocked
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+INSERT INTO listing_moderation (listing_id, decision, reason, moderator_id, decided_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (listing_id) DO UPDATE
+SET decision=EXCLUDED.decision, reason=EXCLUDED.reason, moderator_id=EXCLUDED.moderator_id, decided_at=EXCLUDED.decided_at
+`, listingID, decision, reason, moderatorID); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, sellerID, "market_listing_"+decision, map[string]any{"listing_id": listingID, "reason": reason})
+	})
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBannedWordHits(t *testing.T) {
+	banned := []string{"Fake Passport", "casino", "  "}
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"Selling a FAKE passport, DM me", []string{"fake passport"}},
+		{"Best casino-bonus codes", []string{"casino"}},
+		{"Classic casinos guide", nil}, // whole words only
+		{"fake documents and a passport", nil},
+	}
+	for _, c := range cases {
+		if got := bannedWordHits(c.text, banned); !slices.Equal(got, c.want) {
+			t.Fatalf("%q: hits %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestPriceAnomalous(t *testing.T) {
+	cases := []struct {
+		price, median, x int64
+		want             bool
+	}{
+		{1_000, 1_000, 10, false},
+		{10_000, 1_000, 10, false},
+		{10_001, 1_000, 10, true},
+		{99, 1_000, 10, true},
+		{100, 1_000, 10, false},
+		{1, 1_000, 0, false}, // off
+		{1, 0, 10, false},    // no median
+	}
+	for _, c := range cases {
+		if got := priceAnomalous(c.price, c.median, c.x); got != c.want {
+			t.Fatalf("price %d median %d x%d: %v, want %v", c.price, c.median, c.x, got, c.want)
+		}
+	}
+}
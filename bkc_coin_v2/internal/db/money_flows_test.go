@@ -0,0 +1,349 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Money-critical flows under concurrency. Each test fires the same operation
+// from many goroutines at once and then checks the accounting: every user's
+// balance moved exactly by their ledger rows, and the reserve by the coins
+// that left or entered it. A lost lock shows up as a double credit or debit,
+// a bad lock order as a deadlock error.
+
+// race runs fn(0..n-1) concurrently, released together, and returns the errors.
+func race(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// seedMoneyUsers creates users with the given balance (outside the ledger)
+// and removes them and everything they touched after the test.
+func seedMoneyUsers(t *testing.T, d *DB, balance int64, ids ...int64) {
+	t.Helper()
+	ctx := context.Background()
+	cleanup := func() {
+		for _, q := range []string{
+			`DELETE FROM ledger WHERE from_id = ANY($1) OR to_id = ANY($1)`,
+			`DELETE FROM user_daily WHERE user_id = ANY($1)`,
+			`DELETE FROM bank_loans WHERE user_id = ANY($1)`,
+			`DELETE FROM nft_owns WHERE user_id = ANY($1)`,
+			`DELETE FROM cryptopay_invoices WHERE user_id = ANY($1)`,
+			`DELETE FROM disputes WHERE opened_by = ANY($1)`,
+			`DELETE FROM gig_milestones WHERE contract_id IN (SELECT contract_id FROM gig_contracts WHERE buyer_id = ANY($1))`,
+			`DELETE FROM gig_contracts WHERE buyer_id = ANY($1)`,
+			`DELETE FROM market_listings WHERE seller_id = ANY($1)`,
+			`DELETE FROM user_events WHERE user_id = ANY($1)`,
+			`DELETE FROM users WHERE user_id = ANY($1)`,
+		} {
+			_, _ = d.Pool.Exec(context.Background(), q, ids)
+		}
+	}
+	cleanup() // leftovers of an aborted run
+	t.Cleanup(cleanup)
+	for _, id := range ids {
+		if _, err := d.EnsureUser(ctx, id, "", "", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=$2 WHERE user_id = ANY($1)`, ids, balance); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkLedger fails unless the user's balance is seed plus what the ledger
+// moved to them minus what it moved from them.
+func checkLedger(t *testing.T, d *DB, userID, seed int64) int64 {
+	t.Helper()
+	var balance, net int64
+	if err := d.Pool.QueryRow(context.Background(), `
+SELECT (SELECT balance FROM users WHERE user_id=$1),
+       COALESCE(SUM(CASE WHEN to_id=$1 THEN amount ELSE 0 END), 0) - COALESCE(SUM(CASE WHEN from_id=$1 THEN amount ELSE 0 END), 0)
+FROM ledger WHERE to_id=$1 OR from_id=$1
+`, userID).Scan(&balance, &net); err != nil {
+		t.Fatal(err)
+	}
+	if balance != seed+net {
+		t.Fatalf("user %d: balance %d, ledger says %d", userID, balance, seed+net)
+	}
+	return balance
+}
+
+func moneySystem(t *testing.T, d *DB) SystemState {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := d.EnsureSystemState(ctx, 1_000_000_000, 1, 0, 1_000_000_000, 1_000, 500, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	s, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestConcurrentTapReplay(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const base, users = 9_300_100_000, 3
+
+	ids := make([]int64, users)
+	for i := range ids {
+		ids[i] = base + int64(i)
+	}
+	seedMoneyUsers(t, d, 0, ids...)
+	before := moneySystem(t, d)
+	if err := d.SetDailyEmissionCap(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.SetDailyEmissionCap(context.Background(), before.DailyEmissionCap) })
+
+	// Workers that re-read the same stream messages deliver the same batch
+	// several times at once; it must be credited once.
+	events := tapEventsFixture(fmt.Sprintf("mf%d", time.Now().UnixNano()), base, users, 60)
+	for i, err := range race(8, func(int) error { return d.ApplyTapEvents(ctx, events, 0) }) {
+		if err != nil {
+			t.Fatalf("apply %d: %v", i, err)
+		}
+	}
+
+	want := map[int64]int64{}
+	seen := map[string]bool{}
+	for _, ev := range events {
+		if ev.Coins <= 0 || ev.EventID == " " || seen[ev.EventID] {
+			continue
+		}
+		seen[ev.EventID] = true
+		want[ev.UserID] += ev.Coins
+	}
+	var minted int64
+	for _, id := range ids {
+		got := checkLedger(t, d, id, 0)
+		if got != want[id] {
+			t.Fatalf("user %d: balance %d, want %d", id, got, want[id])
+		}
+		minted += got
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ReserveSupply-after.ReserveSupply != minted {
+		t.Fatalf("reserve moved %d, minted %d", before.ReserveSupply-after.ReserveSupply, minted)
+	}
+}
+
+func TestConcurrentBankLoans(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const userID, seed = 9_300_200_000, 1_000
+	const principal = 1_000
+
+	seedMoneyUsers(t, d, seed, userID)
+	before := moneySystem(t, d)
+
+	errs := race(8, func(int) error {
+		_, err := d.CreateBankLoan(ctx, userID, principal, 500, 7)
+		return err
+	})
+	var issued int
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			issued++
+		case !errors.Is(err, ErrAlreadyExists):
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+	if issued != 1 {
+		t.Fatalf("%d loans issued, want 1", issued)
+	}
+
+	// Repaying and re-borrowing at once: the loan is repaid once and never
+	// more than one loan is active. Create and repay lock the user and the
+	// reserve in the same order, so neither may fail with a deadlock.
+	for round := 0; round < 10; round++ {
+		var loanID int64
+		if err := d.Pool.QueryRow(ctx, `SELECT loan_id FROM bank_loans WHERE user_id=$1 AND status='active'`, userID).Scan(&loanID); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		errs := race(6, func(i int) error {
+			if i%2 == 0 {
+				return d.RepayBankLoan(ctx, userID, loanID)
+			}
+			_, err := d.CreateBankLoan(ctx, userID, principal, 500, 7)
+			return err
+		})
+		for i, err := range errs {
+			if err != nil && !errors.Is(err, ErrAlreadyExists) {
+				t.Fatalf("round %d op %d: %v", round, i, err)
+			}
+		}
+		if _, err := d.CreateBankLoan(ctx, userID, principal, 500, 7); err != nil && !errors.Is(err, ErrAlreadyExists) {
+			t.Fatal(err)
+		}
+	}
+
+	var active, repaid, lent, returned int64
+	if err := d.Pool.QueryRow(ctx, `
+SELECT COUNT(*) FILTER (WHERE status='active'), COUNT(*) FILTER (WHERE status='repaid'),
+       COALESCE(SUM(principal), 0), COALESCE(SUM(total_due) FILTER (WHERE status='repaid'), 0)
+FROM bank_loans WHERE user_id=$1
+`, userID).Scan(&active, &repaid, &lent, &returned); err != nil {
+		t.Fatal(err)
+	}
+	if active != 1 || repaid != 10 {
+		t.Fatalf("%d active and %d repaid loans, want 1 and 10", active, repaid)
+	}
+	balance := checkLedger(t, d, userID, seed)
+	if balance != seed+lent-returned {
+		t.Fatalf("balance %d, loans say %d", balance, seed+lent-returned)
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ReserveSupply-before.ReserveSupply != returned-lent {
+		t.Fatalf("reserve moved %d, loans say %d", after.ReserveSupply-before.ReserveSupply, returned-lent)
+	}
+}
+
+func TestConcurrentNFTBuys(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const base, buyers = 9_300_300_000, 12
+	const price, supply = 100, 5
+
+	ids := make([]int64, buyers)
+	for i := range ids {
+		ids[i] = base + int64(i)
+	}
+	// Each buyer can afford one copy and tries twice.
+	seedMoneyUsers(t, d, price, ids...)
+	before := moneySystem(t, d)
+	nftID, err := d.CreateNFT(ctx, "money flows", "https://example.com/mf.png", price, supply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM nft_owns WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM nfts WHERE nft_id=$1`, nftID)
+	})
+
+	errs := race(2*buyers, func(i int) error { return d.BuyNFT(ctx, ids[i%buyers], nftID) })
+	var sold int64
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case !errors.Is(err, ErrNotEnough):
+			t.Fatalf("buy %d: %v", i, err)
+		}
+	}
+	if sold != supply {
+		t.Fatalf("%d sold, supply %d", sold, supply)
+	}
+
+	var owned int64
+	for _, id := range ids {
+		var qty int64
+		err := d.Pool.QueryRow(ctx, `SELECT COALESCE(SUM(qty), 0) FROM nft_owns WHERE user_id=$1 AND nft_id=$2`, id, nftID).Scan(&qty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if qty > 1 {
+			t.Fatalf("buyer %d owns %d with coins for one", id, qty)
+		}
+		if balance := checkLedger(t, d, id, price); balance != price-qty*price {
+			t.Fatalf("buyer %d: balance %d with %d copies", id, balance, qty)
+		}
+		owned += qty
+	}
+	if owned != supply {
+		t.Fatalf("%d copies owned, supply %d", owned, supply)
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ReserveSupply-before.ReserveSupply != supply*price {
+		t.Fatalf("reserve moved %d, want %d", after.ReserveSupply-before.ReserveSupply, supply*price)
+	}
+}
+
+func TestConcurrentCryptoPayConfirmation(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const userID, invoiceID = 9_300_400_000, 9_300_400_001
+
+	seedMoneyUsers(t, d, 0, userID)
+	before := moneySystem(t, d)
+
+	// The create call is retried by the handler; the coins are reserved once.
+	coins := make([]int64, 4)
+	for i, err := range race(len(coins), func(i int) error {
+		var err error
+		coins[i], err = d.CreateCryptoPayInvoice(ctx, invoiceID, userID, 10, "active")
+		return err
+	}) {
+		if err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+		if coins[i] != coins[0] {
+			t.Fatalf("create %d: %d coins, first got %d", i, coins[i], coins[0])
+		}
+	}
+	reserved, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved.ReservedSupply-before.ReservedSupply != coins[0] {
+		t.Fatalf("reserved %d, want %d", reserved.ReservedSupply-before.ReservedSupply, coins[0])
+	}
+
+	// The webhook and the poller confirm the same payment at once.
+	credited := make([]int64, 8)
+	for i, err := range race(len(credited), func(i int) error {
+		var err error
+		credited[i], _, err = d.ProcessCryptoPayStatus(ctx, invoiceID, "paid", time.Now())
+		return err
+	}) {
+		if err != nil {
+			t.Fatalf("confirm %d: %v", i, err)
+		}
+	}
+	var total int64
+	for _, c := range credited {
+		total += c
+	}
+	if total != coins[0] {
+		t.Fatalf("credited %d, invoice is %d", total, coins[0])
+	}
+	if balance := checkLedger(t, d, userID, 0); balance != coins[0] {
+		t.Fatalf("balance %d, want %d", balance, coins[0])
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ReservedSupply != before.ReservedSupply || before.ReserveSupply-after.ReserveSupply != coins[0] {
+		t.Fatalf("reserve %d->%d, reserved %d->%d for %d coins",
+			before.ReserveSupply, after.ReserveSupply, before.ReservedSupply, after.ReservedSupply, coins[0])
+	}
+}
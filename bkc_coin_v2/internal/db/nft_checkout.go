@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Checkout holds. A buyer who opens checkout on a single-copy listing holds
+// it for checkoutHold: other buyers (and offers being accepted) get
+// ErrReserved instead of racing for the copy, and listings show
+// reserved_until meanwhile. Nothing releases a hold but the buyer, the sale
+// or the clock; an expired hold is simply ignored.
+
+const (
+	checkoutHold     = 2 * time.Minute
+	maxCheckoutHolds = 3 // live holds per buyer
+)
+
+var ErrReserved = errors.New("reserved by another buyer")
+
+// activeHoldCol selects nft_listings.reserved_until while the hold is live
+// at now, the query parameter holding d.now(); holds are checked against
+// the same clock.
+func activeHoldCol(now string) string {
+	return `CASE WHEN l.reserved_until > ` + now + ` THEN l.reserved_until END`
+}
+
+// heldByOther reports whether a live hold belongs to someone other than buyerID.
+func heldByOther(heldBy *int64, heldUntil *time.Time, buyerID int64, now time.Time) bool {
+	return heldBy != nil && *heldBy != buyerID && heldUntil != nil && heldUntil.After(now)
+}
+
+// ReserveNFTListing holds a single-copy listing for the buyer's checkout
+// and returns when the hold ends. Reserving again while the hold is live
+// returns the same end; it is not extended.
+func (d *DB) ReserveNFTListing(ctx context.Context, buyerID, listingID int64) (time.Time, error) {
+	if buyerID <= 0 || listingID <= 0 {
+		return time.Time{}, errors.New("bad params")
+	}
+	now := d.now()
+	var until time.Time
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var sellerID, left int64
+		var status string
+		var heldBy *int64
+		var heldUntil *time.Time
+		if err := tx.QueryRow(ctx, `
+SELECT seller_id, qty_left, status, reserved_by, reserved_until
+FROM nft_listings
+WHERE listing_id=$1
+FOR UPDATE
+`, listingID).Scan(&sellerID, &left, &status, &heldBy, &heldUntil); err != nil {
+			return err
+		}
+		if status != "active" || left <= 0 {
+			return ErrNotEnough
+		}
+		if left != 1 {
+			return errors.New("bad params: only single-copy listings are reserved")
+		}
+		if sellerID == buyerID {
+			return ErrForbidden
+		}
+		if heldByOther(heldBy, heldUntil, buyerID, now) {
+			return ErrReserved
+		}
+		if heldBy != nil && heldUntil != nil && heldUntil.After(now) {
+			until = *heldUntil
+			return nil
+		}
+		var holds int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM nft_listings WHERE reserved_by=$1 AND reserved_until > $2`, buyerID, now).Scan(&holds); err != nil {
+			return err
+		}
+		if holds >= maxCheckoutHolds {
+			return ErrLocked
+		}
+		until = now.Add(checkoutHold)
+		_, err := tx.Exec(ctx, `UPDATE nft_listings SET reserved_by=$1, reserved_until=$2 WHERE listing_id=$3`, buyerID, until, listingID)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// ReleaseNFTListing drops the buyer's live hold on a listing; pgx.ErrNoRows
+// if the buyer holds none.
+func (d *DB) ReleaseNFTListing(ctx context.Context, buyerID, listingID int64) error {
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE nft_listings SET reserved_by=NULL, reserved_until=NULL
+WHERE listing_id=$1 AND reserved_by=$2 AND reserved_until > $3
+`, listingID, buyerID, d.now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestHeldByOther(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	buyer, other := int64(7), int64(8)
+	live, gone := now.Add(time.Minute), now.Add(-time.Second)
+	cases := []struct {
+		by    *int64
+		until *time.Time
+		want  bool
+	}{
+		{nil, nil, false},
+		{&buyer, &live, false},
+		{&other, &live, true},
+		{&other, &gone, false},
+		{&other, nil, false},
+	}
+	for i, c := range cases {
+		if got := heldByOther(c.by, c.until, buyer, now); got != c.want {
+			t.Fatalf("case %d: heldByOther = %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestCheckoutHoldByClock(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const seller, buyer, other = 9_301_800_001, 9_301_800_002, 9_301_800_003
+	seedMoneyUsers(t, d, 1_000, seller, buyer, other)
+	nftID, err := d.CreateNFT(ctx, "hold", "https://example.com/hold.png", 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_listings WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_owns WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nfts WHERE nft_id=$1`, nftID)
+	})
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $2, 1)`, seller, nftID); err != nil {
+		t.Fatal(err)
+	}
+	l, err := d.CreateNFTListing(ctx, seller, nftID, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReserveNFTListing(ctx, buyer, l.ListingID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReserveNFTListing(ctx, other, l.ListingID); !errors.Is(err, ErrReserved) {
+		t.Fatalf("reserved over a live hold: %v", err)
+	}
+	if err := d.ReleaseNFTListing(ctx, other, l.ListingID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("released a hold of another buyer: %v", err)
+	}
+	listed := func() *time.Time {
+		t.Helper()
+		ls, err := d.ListNFTListings(ctx, nftID, 10)
+		if err != nil || len(ls) != 1 {
+			t.Fatalf("listings %+v, %v", ls, err)
+		}
+		return ls[0].ReservedUntil
+	}
+	if listed() == nil {
+		t.Fatal("live hold not shown")
+	}
+
+	// Past the hold the listing shows free and the other buyer gets it.
+	clk.Advance(checkoutHold + time.Second)
+	if until := listed(); until != nil {
+		t.Fatalf("lapsed hold shown until %v", until)
+	}
+	if err := d.ReleaseNFTListing(ctx, buyer, l.ListingID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("released a lapsed hold: %v", err)
+	}
+	if _, err := d.ReserveNFTListing(ctx, other, l.ListingID); err != nil {
+		t.Fatal(err)
+	}
+}
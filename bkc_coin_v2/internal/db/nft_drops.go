@@ -0,0 +1,497 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Drops put a shop NFT on sale at a set time. Until the drop ends the NFT
+// can only be bought through it. A drop may be limited to users on a plan
+// (user_plans, e.g. "gold") and/or an explicit allowlist the admin uploads
+// (e.g. quest completers); a user in either may join. Joining queues an
+// order instead of buying on the spot: the nft_drops job fills queued
+// orders in arrival order under one lock, so a burst of buyers is served
+// first come first served without fighting over the nfts row. Each user
+// may hold at most per_user_cap copies across queued and filled orders.
+
+// Drop phases.
+const (
+	DropScheduled = "scheduled"
+	DropLive      = "live"
+	DropEnded     = "ended"
+)
+
+// Drop order statuses.
+const (
+	DropOrderQueued   = "queued"
+	DropOrderFilled   = "filled"
+	DropOrderRejected = "rejected"
+)
+
+// ErrDropOnly means the NFT is sold through a drop that hasn't ended.
+var ErrDropOnly = errors.New("nft is sold through a drop")
+
+// ErrNotAllowlisted means the user may not join the drop.
+var ErrNotAllowlisted = errors.New("not on the drop allowlist")
+
+const dropQueueBatch = 100
+
+// NFTDrop is a scheduled sale of a shop NFT.
+type NFTDrop struct {
+	DropID     int64      `json:"drop_id"`
+	NFTID      int64      `json:"nft_id"`
+	Title      string     `json:"title"`
+	PriceCoins int64      `json:"price_coins"`
+	SupplyLeft int64      `json:"supply_left"`
+	StartsAt   time.Time  `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+	PerUserCap int64      `json:"per_user_cap"` // 0 = no cap
+	AllowPlan  string     `json:"allow_plan"`   // "" = no plan rule
+	Allowlist  bool       `json:"allowlist"`    // explicit user list applies
+	Closed     bool       `json:"closed"`       // cancelled or sold out
+	Phase      string     `json:"phase"`        // scheduled | live | ended
+	Queued     int64      `json:"queued"`       // copies waiting in the queue
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NFTDropOrder is a place in a drop's queue.
+type NFTDropOrder struct {
+	OrderID     int64      `json:"order_id"`
+	DropID      int64      `json:"drop_id"`
+	UserID      int64      `json:"user_id"`
+	Qty         int64      `json:"qty"`
+	PriceCoins  int64      `json:"price_coins,omitempty"` // per copy, when not the NFT price
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	Position    int64      `json:"position,omitempty"` // 1 = next; queued orders only
+	CreatedAt   time.Time  `json:"created_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// phase is where the drop is at now.
+func (d NFTDrop) phase(now time.Time) string {
+	switch {
+	case d.Closed || d.EndsAt != nil && !now.Before(*d.EndsAt):
+		return DropEnded
+	case now.Before(d.StartsAt):
+		return DropScheduled
+	default:
+		return DropLive
+	}
+}
+
+// restricted reports whether the drop has an allowlist rule.
+func (d NFTDrop) restricted() bool {
+	return d.AllowPlan != "" || d.Allowlist
+}
+
+const dropCols = `d.drop_id, d.nft_id, n.title, n.price_coins, n.supply_left, d.starts_at, d.ends_at, d.per_user_cap, d.allow_plan, d.allowlist, d.closed, d.created_at,
+       (SELECT COALESCE(SUM(o.qty), 0) FROM nft_drop_orders o WHERE o.drop_id = d.drop_id AND o.status = 'queued')`
+
+func scanNFTDrop(row pgx.Row, now time.Time) (NFTDrop, error) {
+	var d NFTDrop
+	if err := row.Scan(&d.DropID, &d.NFTID, &d.Title, &d.PriceCoins, &d.SupplyLeft, &d.StartsAt, &d.EndsAt, &d.PerUserCap, &d.AllowPlan, &d.Allowlist, &d.Closed, &d.CreatedAt, &d.Queued); err != nil {
+		return NFTDrop{}, err
+	}
+	d.Phase = d.phase(now)
+	return d, nil
+}
+
+// CreateNFTDrop schedules a drop of a shop NFT that is not in another
+// unfinished drop.
+func (d *DB) CreateNFTDrop(ctx context.Context, adminID int64, in NFTDrop) (NFTDrop, error) {
+	in.AllowPlan = strings.ToLower(strings.TrimSpace(in.AllowPlan))
+	if in.NFTID <= 0 || in.StartsAt.IsZero() || in.PerUserCap < 0 || len(in.AllowPlan) > 32 ||
+		(in.EndsAt != nil && !in.EndsAt.After(in.StartsAt)) {
+		return NFTDrop{}, errors.New("bad params")
+	}
+	var id int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		left, _, err := nftSupplyTx(ctx, tx, in.NFTID)
+		if err != nil {
+			return err
+		}
+		if left <= 0 {
+			return ErrNotEnough
+		}
+		if err := dropOnlyTx(ctx, tx, in.NFTID, time.Now().UTC()); errors.Is(err, ErrDropOnly) {
+			return ErrAlreadyExists
+		} else if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO nft_drops (nft_id, starts_at, ends_at, per_user_cap, allow_plan, allowlist, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING drop_id
+`, in.NFTID, in.StartsAt, in.EndsAt, in.PerUserCap, in.AllowPlan, in.Allowlist, adminID).Scan(&id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_drop_create', $1, NULL, 0, $2::jsonb)`,
+			adminID, toJSON(map[string]any{"drop_id": id, "nft_id": in.NFTID, "starts_at": in.StartsAt.Unix()}))
+		return err
+	})
+	if err != nil {
+		return NFTDrop{}, err
+	}
+	return d.GetNFTDrop(ctx, id)
+}
+
+// dropOnlyTx returns ErrDropOnly while the NFT has an unfinished drop.
+func dropOnlyTx(ctx context.Context, q rowQuerier, nftID int64, now time.Time) error {
+	var busy bool
+	if err := q.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM nft_drops WHERE nft_id=$1 AND NOT closed AND (ends_at IS NULL OR ends_at > $2))
+`, nftID, now).Scan(&busy); err != nil {
+		return err
+	}
+	if busy {
+		return ErrDropOnly
+	}
+	return nil
+}
+
+func (d *DB) GetNFTDrop(ctx context.Context, dropID int64) (NFTDrop, error) {
+	return scanNFTDrop(d.Pool.QueryRow(ctx, `SELECT `+dropCols+` FROM nft_drops d JOIN nfts n ON n.nft_id = d.nft_id WHERE d.drop_id=$1`, dropID), time.Now().UTC())
+}
+
+// ListNFTDrops returns upcoming and live drops, soonest first.
+func (d *DB) ListNFTDrops(ctx context.Context) ([]NFTDrop, error) {
+	now := time.Now().UTC()
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+dropCols+`
+FROM nft_drops d JOIN nfts n ON n.nft_id = d.nft_id
+WHERE NOT d.closed AND (d.ends_at IS NULL OR d.ends_at > $1)
+ORDER BY d.starts_at, d.drop_id
+LIMIT 100
+`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTDrop{}
+	for rows.Next() {
+		dr, err := scanNFTDrop(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dr)
+	}
+	return out, rows.Err()
+}
+
+// SetNFTDropAllowlist replaces the explicit allowlist of a drop and turns
+// it on (an empty list turns it off).
+func (d *DB) SetNFTDropAllowlist(ctx context.Context, dropID int64, userIDs []int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE nft_drops SET allowlist=$2 WHERE drop_id=$1`, dropID, len(userIDs) > 0)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM nft_drop_allowlist WHERE drop_id=$1`, dropID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+INSERT INTO nft_drop_allowlist (drop_id, user_id)
+SELECT $1, u FROM unnest($2::bigint[]) AS u WHERE u > 0
+ON CONFLICT DO NOTHING
+`, dropID, userIDs)
+		return err
+	})
+}
+
+// CancelNFTDrop closes a drop; queued orders are rejected, filled ones stay.
+func (d *DB) CancelNFTDrop(ctx context.Context, dropID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE nft_drops SET closed=true WHERE drop_id=$1 AND NOT closed`, dropID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrLocked
+		}
+		return rejectQueuedDropOrdersTx(ctx, tx, dropID, "cancelled")
+	})
+}
+
+// JoinNFTDrop queues an order for qty copies of a live drop at priceBP of
+// the NFT price (10000 = list price, fixed when the order is filled; any
+// other price is fixed now).
+func (d *DB) JoinNFTDrop(ctx context.Context, userID, dropID, qty, priceBP int64) (NFTDropOrder, error) {
+	if userID <= 0 || qty <= 0 || priceBP <= 0 {
+		return NFTDropOrder{}, errors.New("bad params")
+	}
+	out := NFTDropOrder{DropID: dropID, UserID: userID, Qty: qty, Status: DropOrderQueued}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		// The drop row is only read: joiners serialize per user, not per drop.
+		dr, err := scanNFTDrop(tx.QueryRow(ctx, `SELECT `+dropCols+` FROM nft_drops d JOIN nfts n ON n.nft_id = d.nft_id WHERE d.drop_id=$1`, dropID), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if dr.Phase != DropLive {
+			return ErrLocked
+		}
+		if dr.SupplyLeft <= 0 {
+			return ErrNotEnough
+		}
+		if err := lockUsersTx(ctx, tx, userID); err != nil {
+			return err
+		}
+		if dr.restricted() {
+			var allowed bool
+			if err := tx.QueryRow(ctx, `
+SELECT ($2 <> '' AND EXISTS(SELECT 1 FROM user_plans WHERE user_id=$1 AND plan=$2 AND (expires_at IS NULL OR expires_at > now())))
+    OR ($3 AND EXISTS(SELECT 1 FROM nft_drop_allowlist WHERE drop_id=$4 AND user_id=$1))
+`, userID, dr.AllowPlan, dr.Allowlist, dropID).Scan(&allowed); err != nil {
+				return err
+			}
+			if !allowed {
+				return ErrNotAllowlisted
+			}
+		}
+		if dr.PerUserCap > 0 {
+			var taken int64
+			if err := tx.QueryRow(ctx, `
+SELECT COALESCE(SUM(qty), 0) FROM nft_drop_orders WHERE drop_id=$1 AND user_id=$2 AND status IN ('queued','filled')
+`, dropID, userID).Scan(&taken); err != nil {
+				return err
+			}
+			if taken+qty > dr.PerUserCap {
+				return errors.New("bad qty: over the per-user cap")
+			}
+		}
+		if priceBP != 10_000 {
+			if out.PriceCoins = dr.PriceCoins * priceBP / 10_000; out.PriceCoins <= 0 {
+				return errors.New("bad price")
+			}
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO nft_drop_orders (drop_id, user_id, qty, price_coins) VALUES ($1, $2, $3, $4)
+RETURNING order_id, created_at
+`, dropID, userID, qty, out.PriceCoins).Scan(&out.OrderID, &out.CreatedAt)
+	})
+	if err != nil {
+		return NFTDropOrder{}, err
+	}
+	return out, nil
+}
+
+// ListNFTDropOrders returns the user's orders in a drop with queue positions.
+func (d *DB) ListNFTDropOrders(ctx context.Context, userID, dropID int64) ([]NFTDropOrder, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT o.order_id, o.drop_id, o.user_id, o.qty, o.price_coins, o.status, o.reason, o.created_at, o.processed_at,
+       CASE WHEN o.status = 'queued'
+            THEN (SELECT COUNT(*) FROM nft_drop_orders q WHERE q.drop_id = o.drop_id AND q.status = 'queued' AND q.order_id <= o.order_id)
+            ELSE 0 END
+FROM nft_drop_orders o
+WHERE o.drop_id=$1 AND o.user_id=$2
+ORDER BY o.order_id
+`, dropID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTDropOrder{}
+	for rows.Next() {
+		var o NFTDropOrder
+		if err := rows.Scan(&o.OrderID, &o.DropID, &o.UserID, &o.Qty, &o.PriceCoins, &o.Status, &o.Reason, &o.CreatedAt, &o.ProcessedAt, &o.Position); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func rejectQueuedDropOrdersTx(ctx context.Context, tx pgx.Tx, dropID int64, reason string) error {
+	rows, err := tx.Query(ctx, `
+UPDATE nft_drop_orders SET status='rejected', reason=$2, processed_at=now()
+WHERE drop_id=$1 AND status='queued'
+RETURNING order_id, user_id
+`, dropID, reason)
+	if err != nil {
+		return err
+	}
+	var rejected [][2]int64
+	for rows.Next() {
+		var r [2]int64
+		if err := rows.Scan(&r[0], &r[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		rejected = append(rejected, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range rejected {
+		if err := addUserEventTx(ctx, tx, r[1], "nft_drop_rejected", map[string]any{"drop_id": dropID, "order_id": r[0], "reason": reason}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProcessNFTDrops fills queued orders of live drops in arrival order and
+// closes drops that sold out or ended (scheduled job). A buyer who can't
+// pay is skipped; orders past the supply are rejected.
+func (d *DB) ProcessNFTDrops(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT drop_id FROM nft_drops
+WHERE NOT closed AND starts_at <= $1
+ORDER BY drop_id
+`, now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var filled int64
+	for _, id := range ids {
+		n, err := d.processNFTDrop(ctx, id, now)
+		filled += n
+		if err != nil {
+			return filled, err
+		}
+	}
+	return filled, nil
+}
+
+// processNFTDrop works through one batch of a drop's queue in one
+// transaction.
+func (d *DB) processNFTDrop(ctx context.Context, dropID int64, now time.Time) (int64, error) {
+	var filled int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var nftID, price int64
+		var endsAt *time.Time
+		if err := tx.QueryRow(ctx, `
+SELECT d.nft_id, d.ends_at, n.price_coins
+FROM nft_drops d JOIN nfts n ON n.nft_id = d.nft_id
+WHERE d.drop_id=$1 AND NOT d.closed
+FOR UPDATE OF d
+`, dropID).Scan(&nftID, &endsAt, &price); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		left, shards, err := nftSupplyTx(ctx, tx, nftID)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, `
+SELECT order_id, user_id, qty, price_coins FROM nft_drop_orders
+WHERE drop_id=$1 AND status='queued'
+ORDER BY order_id
+LIMIT $2
+`, dropID, dropQueueBatch)
+		if err != nil {
+			return err
+		}
+		var queue [][4]int64
+		for rows.Next() {
+			var o [4]int64
+			if err := rows.Scan(&o[0], &o[1], &o[2], &o[3]); err != nil {
+				rows.Close()
+				return err
+			}
+			queue = append(queue, o)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, o := range queue {
+			orderID, userID, qty, orderPrice := o[0], o[1], o[2], o[3]
+			if orderPrice == 0 {
+				orderPrice = price
+			}
+			status, reason := DropOrderFilled, ""
+			switch {
+			case left < qty:
+				status, reason = DropOrderRejected, "sold_out"
+			default:
+				err := fillDropOrderTx(ctx, tx, dropID, orderID, userID, nftID, qty, orderPrice, shards)
+				if errors.Is(err, ErrNotEnough) {
+					status, reason = DropOrderRejected, "insufficient_funds"
+				} else if err != nil {
+					return err
+				}
+			}
+			if status == DropOrderFilled {
+				left -= qty
+				filled++
+			}
+			if _, err := tx.Exec(ctx, `UPDATE nft_drop_orders SET status=$2, reason=$3, processed_at=$4 WHERE order_id=$1`, orderID, status, reason, now); err != nil {
+				return err
+			}
+			if err := addUserEventTx(ctx, tx, userID, "nft_drop_"+status, map[string]any{"drop_id": dropID, "order_id": orderID, "nft_id": nftID, "qty": qty, "reason": reason}); err != nil {
+				return err
+			}
+		}
+		ended := endsAt != nil && !now.Before(*endsAt)
+		if left <= 0 || ended {
+			reason := "sold_out"
+			if left > 0 {
+				reason = "ended"
+			}
+			if err := rejectQueuedDropOrdersTx(ctx, tx, dropID, reason); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE nft_drops SET closed=true WHERE drop_id=$1`, dropID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return filled, err
+}
+
+// fillDropOrderTx buys qty copies at price for the user, like BuyNFT.
+// ErrNotEnough means the user can't pay; debitSpendableTx fails before
+// writing anything, so the batch goes on.
+func fillDropOrderTx(ctx context.Context, tx pgx.Tx, dropID, orderID, userID, nftID, qty, price, shards int64) error {
+	total := price * qty
+	if err := lockUsersTx(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := debitSpendableTx(ctx, tx, userID, total); err != nil {
+		return err
+	}
+	// The queue is the only taker during a drop and checked the supply, so
+	// ErrNotEnough here aborts the batch rather than skipping the order.
+	if err := takeNFTSupplyTx(ctx, tx, nftID, qty, total, shards); errors.Is(err, ErrNotEnough) {
+		return errors.New("drop supply changed under the queue")
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $2, $3)
+ON CONFLICT (user_id, nft_id) DO UPDATE SET qty = nft_owns.qty + EXCLUDED.qty
+`, userID, nftID, qty); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_buy', $1, NULL, $2, $3::jsonb)`,
+		userID, total, toJSON(map[string]any{"nft_id": nftID, "qty": qty, "drop_id": dropID, "order_id": orderID}))
+	return err
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNFTDropPhase(t *testing.T) {
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	cases := []struct {
+		d    NFTDrop
+		at   time.Time
+		want string
+	}{
+		{NFTDrop{StartsAt: start}, start.Add(-time.Second), DropScheduled},
+		{NFTDrop{StartsAt: start}, start, DropLive},
+		{NFTDrop{StartsAt: start, EndsAt: &end}, end.Add(-time.Second), DropLive},
+		{NFTDrop{StartsAt: start, EndsAt: &end}, end, DropEnded},
+		{NFTDrop{StartsAt: start, Closed: true}, start, DropEnded},
+	}
+	for i, c := range cases {
+		if got := c.d.phase(c.at); got != c.want {
+			t.Fatalf("case %d: phase %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestNFTDropRestricted(t *testing.T) {
+	if (NFTDrop{}).restricted() {
+		t.Fatal("open drop reported restricted")
+	}
+	if !(NFTDrop{AllowPlan: "gold"}).restricted() || !(NFTDrop{Allowlist: true}).restricted() {
+		t.Fatal("plan or allowlist drop not restricted")
+	}
+}
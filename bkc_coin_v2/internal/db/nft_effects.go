@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NFTEffects is the structured effects schema stored in nfts.effects (JSONB).
+// Zero values mean "no effect":
+//
+//	{"tap_multiplier": 1.25, "energy_max_boost": 100, "fee_discount_bp": 500}
+type NFTEffects struct {
+	TapMultiplier  float64 `json:"tap_multiplier,omitempty"`   // coins per tap, >= 1
+	EnergyMaxBoost int64   `json:"energy_max_boost,omitempty"` // flat bonus to max energy
+	FeeDiscountBP  int64   `json:"fee_discount_bp,omitempty"`  // basis points off platform fees
+}
+
+// Caps on the combined effects of everything a user owns.
+const (
+	MaxNFTTapMultiplier  = 3.0
+	MaxNFTEnergyMaxBoost = 5_000
+	MaxNFTFeeDiscountBP  = 5_000
+)
+
+func (e NFTEffects) IsZero() bool {
+	return e.TapMultiplier == 0 && e.EnergyMaxBoost == 0 && e.FeeDiscountBP == 0
+}
+
+// Validate rejects effects a single NFT is not allowed to carry.
+func (e NFTEffects) Validate() error {
+	if math.IsNaN(e.TapMultiplier) || e.TapMultiplier < 0 || (e.TapMultiplier > 0 && e.TapMultiplier < 1) || e.TapMultiplier > MaxNFTTapMultiplier {
+		return errors.New("bad tap_multiplier")
+	}
+	if e.EnergyMaxBoost < 0 || e.EnergyMaxBoost > MaxNFTEnergyMaxBoost {
+		return errors.New("bad energy_max_boost")
+	}
+	if e.FeeDiscountBP < 0 || e.FeeDiscountBP > MaxNFTFeeDiscountBP {
+		return errors.New("bad fee_discount_bp")
+	}
+	return nil
+}
+
+// TapMul is the effective coins-per-tap multiplier (1 when unset).
+func (e NFTEffects) TapMul() float64 {
+	if e.TapMultiplier < 1 {
+		return 1
+	}
+	return e.TapMultiplier
+}
+
+// combineNFTEffects merges the effects of distinct owned designs.
+// Tap bonuses add up (1.2 and 1.3 give 1.5), energy boosts add up,
+// fee discounts add up; everything is capped. Holding several copies
+// of one design does not stack.
+func combineNFTEffects(list []NFTEffects) NFTEffects {
+	var out NFTEffects
+	var tapBonus float64
+	for _, e := range list {
+		if e.TapMultiplier > 1 {
+			tapBonus += e.TapMultiplier - 1
+		}
+		out.EnergyMaxBoost += e.EnergyMaxBoost
+		out.FeeDiscountBP += e.FeeDiscountBP
+	}
+	if tapBonus > 0 {
+		out.TapMultiplier = math.Min(1+tapBonus, MaxNFTTapMultiplier)
+	}
+	if out.EnergyMaxBoost > MaxNFTEnergyMaxBoost {
+		out.EnergyMaxBoost = MaxNFTEnergyMaxBoost
+	}
+	if out.FeeDiscountBP > MaxNFTFeeDiscountBP {
+		out.FeeDiscountBP = MaxNFTFeeDiscountBP
+	}
+	return out
+}
+
+// ApplyFeeDiscount returns fee reduced by discountBP basis points (rounded down, never negative).
+func ApplyFeeDiscount(fee, discountBP int64) int64 {
+	if fee <= 0 {
+		return 0
+	}
+	if discountBP <= 0 {
+		return fee
+	}
+	if discountBP >= 10_000 {
+		return 0
+	}
+	return fee - fee*discountBP/10_000
+}
+
+func parseNFTEffects(raw []byte) (NFTEffects, error) {
+	var e NFTEffects
+	if len(raw) == 0 {
+		return e, nil
+	}
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return NFTEffects{}, err
+	}
+	return e, nil
+}
+
+// SetNFTEffects replaces the effects of an NFT design.
+func (d *DB) SetNFTEffects(ctx context.Context, nftID int64, eff NFTEffects) error {
+	if nftID <= 0 {
+		return errors.New("bad nft_id")
+	}
+	if err := eff.Validate(); err != nil {
+		return err
+	}
+	tag, err := d.Pool.Exec(ctx, `UPDATE nfts SET effects=$1::jsonb WHERE nft_id=$2`, toJSON(eff), nftID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+type effectsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// ResolveUserNFTEffects combines the effects of every NFT the user currently owns.
+// Staked copies count: staking locks the NFT, it does not take it away.
+// Rented copies count for the renter until the rental ends, not for the owner.
+func (d *DB) ResolveUserNFTEffects(ctx context.Context, userID int64) (NFTEffects, error) {
+	return resolveUserNFTEffects(ctx, d.Pool, userID)
+}
+
+func resolveUserNFTEffects(ctx context.Context, q effectsQuerier, userID int64) (NFTEffects, error) {
+	if userID <= 0 {
+		return NFTEffects{}, nil
+	}
+	rows, err := q.Query(ctx, `
+SELECT n.effects
+FROM nfts n
+LEFT JOIN nft_owns o ON o.nft_id = n.nft_id AND o.user_id = $1
+WHERE n.effects <> '{}'::jsonb
+  AND (
+    COALESCE(o.qty, 0) > (SELECT COUNT(*) FROM nft_rentals r WHERE r.owner_id=$1 AND r.nft_id=n.nft_id AND r.status='active' AND r.ends_at > now())
+    OR EXISTS (SELECT 1 FROM nft_rentals r WHERE r.renter_id=$1 AND r.nft_id=n.nft_id AND r.status='active' AND r.ends_at > now())
+  )
+`, userID)
+	if err != nil {
+		return NFTEffects{}, err
+	}
+	defer rows.Close()
+	var list []NFTEffects
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return NFTEffects{}, err
+		}
+		e, err := parseNFTEffects(raw)
+		if err != nil {
+			return NFTEffects{}, err
+		}
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		return NFTEffects{}, err
+	}
+	return combineNFTEffects(list), nil
+}
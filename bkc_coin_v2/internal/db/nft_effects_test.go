@@ -0,0 +1,38 @@
+package db
+
+import "testing"
+
+func TestCombineNFTEffects(t *testing.T) {
+	got := combineNFTEffects([]NFTEffects{
+		{TapMultiplier: 1.2, FeeDiscountBP: 3_000},
+		{TapMultiplier: 1.3, EnergyMaxBoost: 100},
+		{FeeDiscountBP: 3_000},
+	})
+	if got.TapMultiplier != 1.5 {
+		t.Errorf("tap multiplier: got %v, want 1.5", got.TapMultiplier)
+	}
+	if got.EnergyMaxBoost != 100 {
+		t.Errorf("energy boost: got %d, want 100", got.EnergyMaxBoost)
+	}
+	if got.FeeDiscountBP != MaxNFTFeeDiscountBP {
+		t.Errorf("fee discount should be capped: got %d", got.FeeDiscountBP)
+	}
+	if m := combineNFTEffects(nil).TapMul(); m != 1 {
+		t.Errorf("no NFTs should mean multiplier 1, got %v", m)
+	}
+}
+
+func TestApplyFeeDiscount(t *testing.T) {
+	cases := []struct{ fee, bp, want int64 }{
+		{2_000, 0, 2_000},
+		{2_000, 500, 1_900},
+		{999, 5_000, 500},
+		{2_000, 10_000, 0},
+		{-5, 500, 0},
+	}
+	for _, c := range cases {
+		if got := ApplyFeeDiscount(c.fee, c.bp); got != c.want {
+			t.Errorf("ApplyFeeDiscount(%d, %d) = %d, want %d", c.fee, c.bp, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxNFTRoyaltyBP caps the creator royalty on secondary sales (10%).
+const MaxNFTRoyaltyBP = 1_000
+
+type NFTListing struct {
+	ListingID  int64     `json:"listing_id"`
+	SellerID   int64     `json:"seller_id"`
+	NFTID      int64     `json:"nft_id"`
+	Title      string    `json:"title"`
+	ImageURL   string    `json:"image_url"`
+	Qty        int64     `json:"qty"`
+	QtyLeft    int64     `json:"qty_left"`
+	PriceCoins int64     `json:"price_coins"` // per copy; indicative for USDT/TON listings
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	QuoteCurrency string `json:"quote_currency"`        // BKC|USDT|TON
+	QuotePrice    int64  `json:"quote_price,omitempty"` // per copy, in 1/QuoteScale units
+
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"` // a buyer is in checkout, see ReserveNFTListing
+}
+
+type NFTSale struct {
+	SaleID     int64     `json:"sale_id"`
+	ListingID  int64     `json:"listing_id"`
+	NFTID      int64     `json:"nft_id"`
+	SellerID   int64     `json:"seller_id"`
+	BuyerID    int64     `json:"buyer_id"`
+	Qty        int64     `json:"qty"`
+	PriceCoins int64     `json:"price_coins"` // per copy
+	Royalty    int64     `json:"royalty"`
+	Fee        int64     `json:"fee"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Set when the listing was priced in USDT/TON: the quoted price and the
+	// rate (BKC per unit) used to compute PriceCoins at checkout.
+	QuoteCurrency string  `json:"quote_currency,omitempty"`
+	QuotePrice    int64   `json:"quote_price,omitempty"`
+	QuoteRate     float64 `json:"quote_rate,omitempty"`
+}
+
+// splitNFTSale divides a sale total into creator royalty, platform fee and seller proceeds.
+// Both cuts round down, so the seller never receives less than total-royalty-fee.
+func splitNFTSale(total, royaltyBP, feeBP int64) (royalty, fee, seller int64) {
+	if total <= 0 {
+		return 0, 0, 0
+	}
+	if royaltyBP > 0 {
+		royalty = total * royaltyBP / 10_000
+	}
+	if feeBP > 0 {
+		fee = total * feeBP / 10_000
+	}
+	seller = total - royalty - fee
+	if seller < 0 {
+		seller = 0
+	}
+	return royalty, fee, seller
+}
+
+// SetNFTRoyalty sets the creator who receives royaltyBP of every secondary sale of an NFT.
+func (d *DB) SetNFTRoyalty(ctx context.Context, nftID, creatorID, royaltyBP int64) error {
+	if nftID <= 0 || creatorID < 0 || royaltyBP < 0 || royaltyBP > MaxNFTRoyaltyBP {
+		return errors.New("bad params")
+	}
+	var creator *int64
+	if creatorID > 0 {
+		creator = &creatorID
+	}
+	tag, err := d.Pool.Exec(ctx, `UPDATE nfts SET creator_id=$1, royalty_bp=$2 WHERE nft_id=$3`, creator, royaltyBP, nftID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CreateNFTListing puts qty owned copies up for sale at priceCoins each.
+// Listed copies cannot be staked or listed again until sold or cancelled.
+func (d *DB) CreateNFTListing(ctx context.Context, sellerID, nftID, qty, priceCoins int64) (NFTListing, error) {
+	if priceCoins <= 0 {
+		return NFTListing{}, errors.New("bad params")
+	}
+	return d.createNFTListing(ctx, sellerID, nftID, qty, priceCoins, QuoteBKC, 0, nil)
+}
+
+// CreateQuotedNFTListing lists copies priced in USDT or TON (quotePrice in 1/QuoteScale units).
+// The BKC amount is computed from the rates oracle when the listing is bought.
+func (d *DB) CreateQuotedNFTListing(ctx context.Context, sellerID, nftID, qty int64, currency string, quotePrice int64, rates QuoteRates) (NFTListing, error) {
+	currency, err := normalizeQuoteCurrency(currency)
+	if err != nil {
+		return NFTListing{}, err
+	}
+	if currency == QuoteBKC {
+		return d.CreateNFTListing(ctx, sellerID, nftID, qty, quotePrice)
+	}
+	if quotePrice <= 0 {
+		return NFTListing{}, errors.New("bad params")
+	}
+	return d.createNFTListing(ctx, sellerID, nftID, qty, 0, currency, quotePrice, rates)
+}
+
+func (d *DB) createNFTListing(ctx context.Context, sellerID, nftID, qty, priceCoins int64, currency string, quotePrice int64, rates QuoteRates) (NFTListing, error) {
+	if sellerID <= 0 || nftID <= 0 || qty <= 0 {
+		return NFTListing{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	out := NFTListing{
+		SellerID:      sellerID,
+		NFTID:         nftID,
+		Qty:           qty,
+		QtyLeft:       qty,
+		PriceCoins:    priceCoins,
+		Status:        "active",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		QuoteCurrency: currency,
+		QuotePrice:    quotePrice,
+	}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if currency != QuoteBKC {
+			// Indicative BKC price for sorting and search; the real amount is fixed at checkout.
+			rate, err := quoteRate(ctx, tx, rates, currency)
+			if err != nil {
+				return err
+			}
+			if out.PriceCoins = quoteToCoins(quotePrice, rate); out.PriceCoins <= 0 {
+				return errors.New("bad params")
+			}
+		}
+		var owned, staked, listed int64
+		if err := tx.QueryRow(ctx, `SELECT qty, staked_qty, listed_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2 FOR UPDATE`, sellerID, nftID).Scan(&owned, &staked, &listed); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotEnough
+			}
+			return err
+		}
+		if owned-staked-listed < qty {
+			return ErrNotEnough
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=listed_qty+$1 WHERE user_id=$2 AND nft_id=$3`, qty, sellerID, nftID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `SELECT title, image_url FROM nfts WHERE nft_id=$1`, nftID).Scan(&out.Title, &out.ImageURL); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO nft_listings (seller_id, nft_id, qty, qty_left, price_coins, status, created_at, updated_at, quote_currency, quote_price)
+VALUES ($1,$2,$3,$3,$4,'active',$5,$5,$6,$7)
+RETURNING listing_id
+`, sellerID, nftID, qty, out.PriceCoins, now, currency, quotePrice).Scan(&out.ListingID)
+	})
+	if err != nil {
+		return NFTListing{}, err
+	}
+	return out, nil
+}
+
+// CancelNFTListing withdraws the unsold remainder of a listing back to the seller.
+func (d *DB) CancelNFTListing(ctx context.Context, sellerID, listingID int64) error {
+	if sellerID <= 0 || listingID <= 0 {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var owner, nftID, left int64
+		var status string
+		if err := tx.QueryRow(ctx, `SELECT seller_id, nft_id, qty_left, status FROM nft_listings WHERE listing_id=$1 FOR UPDATE`, listingID).Scan(&owner, &nftID, &left, &status); err != nil {
+			return err
+		}
+		if owner != sellerID {
+			return ErrForbidden
+		}
+		if status != "active" {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_listings SET status='cancelled', updated_at=now() WHERE listing_id=$1`, listingID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=GREATEST(listed_qty-$1, 0) WHERE user_id=$2 AND nft_id=$3`, left, sellerID, nftID)
+		return err
+	})
+}
+
+// BuyNFTListing buys qty copies from a listing. The buyer pays price*qty; the creator
+// receives the royalty, the platform fee (reduced by the seller's NFT fee discount)
+// goes to reserve, and the seller receives the rest. USDT/TON prices are converted
+// to BKC with rates at the moment of purchase.
+func (d *DB) BuyNFTListing(ctx context.Context, buyerID, listingID, qty, feeBP int64, rates QuoteRates) (NFTSale, error) {
+	if buyerID <= 0 || listingID <= 0 || qty <= 0 || feeBP < 0 {
+		return NFTSale{}, errors.New("bad params")
+	}
+	var out NFTSale
+	err := d.RetryTx(ctx, func(tx pgx.Tx) error {
+		out = NFTSale{ListingID: listingID, BuyerID: buyerID, Qty: qty}
+		if err := takeFromNFTListingTx(ctx, tx, listingID, buyerID, qty, d.now(), &out.SellerID, &out.NFTID, &out.PriceCoins); err != nil {
+			return err
+		}
+		if out.SellerID == buyerID {
+			return ErrForbidden
+		}
+		var currency string
+		var quote int64
+		if err := tx.QueryRow(ctx, `SELECT quote_currency, quote_price FROM nft_listings WHERE listing_id=$1`, listingID).Scan(&currency, &quote); err != nil {
+			return err
+		}
+		var meta map[string]any
+		if currency != QuoteBKC {
+			rate, err := quoteRate(ctx, tx, rates, currency)
+			if err != nil {
+				return err
+			}
+			if out.PriceCoins = quoteToCoins(quote, rate); out.PriceCoins <= 0 {
+				return errors.New("bad rate")
+			}
+			out.QuoteCurrency, out.QuotePrice, out.QuoteRate = currency, quote, rate
+			meta = map[string]any{"quote_currency": currency, "quote_price": quote, "quote_rate": rate}
+		}
+		return settleNFTSaleTx(ctx, tx, &out, feeBP, false, meta)
+	})
+	if err != nil {
+		return NFTSale{}, err
+	}
+	return out, nil
+}
+
+// takeFromNFTListingTx locks an active listing and reduces its remainder by
+// qty. A listing held by another buyer's checkout fails with ErrReserved; the
+// hold ends with the sale.
+func takeFromNFTListingTx(ctx context.Context, tx pgx.Tx, listingID, buyerID, qty int64, now time.Time, sellerID, nftID, price *int64) error {
+	var left int64
+	var status string
+	var heldBy *int64
+	var heldUntil *time.Time
+	if err := tx.QueryRow(ctx, `
+SELECT seller_id, nft_id, qty_left, price_coins, status, reserved_by, reserved_until
+FROM nft_listings
+WHERE listing_id=$1
+FOR UPDATE
+`, listingID).Scan(sellerID, nftID, &left, price, &status, &heldBy, &heldUntil); err != nil {
+		return err
+	}
+	if status != "active" || left < qty {
+		return ErrNotEnough
+	}
+	if heldByOther(heldBy, heldUntil, buyerID, now) {
+		return ErrReserved
+	}
+	_, err := tx.Exec(ctx, `
+UPDATE nft_listings
+SET qty_left=qty_left-$1,
+    status=CASE WHEN qty_left-$1 <= 0 THEN 'sold' ELSE status END,
+    reserved_by=NULL, reserved_until=NULL,
+    updated_at=now()
+WHERE listing_id=$2
+`, qty, listingID)
+	return err
+}
+
+// settleNFTSaleTx moves coins and copies for a secondary sale described by s
+// (NFTID, SellerID, BuyerID, Qty, PriceCoins, optional ListingID) and records it.
+// With fromFrozen the buyer pays from frozen_balance (a locked offer), otherwise from balance.
+// Copies come out of the seller's listed_qty for listing sales, or out of free copies otherwise.
+func settleNFTSaleTx(ctx context.Context, tx pgx.Tx, s *NFTSale, feeBP int64, fromFrozen bool, extraMeta map[string]any) error {
+	s.CreatedAt = time.Now().UTC()
+
+	var creatorID *int64
+	var royaltyBP int64
+	if err := tx.QueryRow(ctx, `SELECT creator_id, royalty_bp FROM nfts WHERE nft_id=$1`, s.NFTID).Scan(&creatorID, &royaltyBP); err != nil {
+		return err
+	}
+	// No royalty without a creator, or when the creator is reselling their own copy.
+	if creatorID == nil || *creatorID == s.SellerID {
+		royaltyBP = 0
+	}
+	eff, err := resolveUserNFTEffects(ctx, tx, s.SellerID)
+	if err != nil {
+		return err
+	}
+	feeBP = ApplyFeeDiscount(feeBP, eff.FeeDiscountBP)
+
+	total := s.PriceCoins * s.Qty
+	royalty, fee, proceeds := splitNFTSale(total, royaltyBP, feeBP)
+	s.Royalty = royalty
+	s.Fee = fee
+
+	if fromFrozen {
+		var frozen int64
+		if err := tx.QueryRow(ctx, `SELECT frozen_balance FROM users WHERE user_id=$1 FOR UPDATE`, s.BuyerID).Scan(&frozen); err != nil {
+			return err
+		}
+		if frozen < total {
+			return ErrNotEnough
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET frozen_balance=frozen_balance-$1 WHERE user_id=$2`, total, s.BuyerID); err != nil {
+			return err
+		}
+	} else if err := debitSpendableTx(ctx, tx, s.BuyerID, total); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, proceeds, s.SellerID); err != nil {
+		return err
+	}
+	if royalty > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, royalty, *creatorID); err != nil {
+			return err
+		}
+	}
+	if fee > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, fee); err != nil {
+			return err
+		}
+	}
+
+	// Move the copies.
+	var owned, staked, listed int64
+	if err := tx.QueryRow(ctx, `SELECT qty, staked_qty, listed_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2 FOR UPDATE`, s.SellerID, s.NFTID).Scan(&owned, &staked, &listed); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotEnough
+		}
+		return err
+	}
+	if s.ListingID > 0 {
+		if listed < s.Qty {
+			return ErrNotEnough
+		}
+		listed -= s.Qty
+	} else if owned-staked-listed < s.Qty {
+		return ErrNotEnough
+	}
+	if _, err := tx.Exec(ctx, `UPDATE nft_owns SET qty=qty-$1, listed_qty=$2 WHERE user_id=$3 AND nft_id=$4`, s.Qty, listed, s.SellerID, s.NFTID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $2, $3)
+ON CONFLICT (user_id, nft_id) DO UPDATE SET qty = nft_owns.qty + EXCLUDED.qty
+`, s.BuyerID, s.NFTID, s.Qty); err != nil {
+		return err
+	}
+
+	if err := tx.QueryRow(ctx, `
+INSERT INTO nft_sales (listing_id, nft_id, seller_id, buyer_id, qty, price_coins, royalty, fee, created_at, quote_currency, quote_price, quote_rate)
+VALUES (NULLIF($1,0),$2,$3,$4,$5,$6,$7,$8,$9,NULLIF($10,''),NULLIF($11,0),NULLIF($12,0))
+RETURNING sale_id
+`, s.ListingID, s.NFTID, s.SellerID, s.BuyerID, s.Qty, s.PriceCoins, royalty, fee, s.CreatedAt, s.QuoteCurrency, s.QuotePrice, s.QuoteRate).Scan(&s.SaleID); err != nil {
+		return err
+	}
+
+	meta := map[string]any{"sale_id": s.SaleID, "nft_id": s.NFTID, "qty": s.Qty, "price": s.PriceCoins}
+	if s.ListingID > 0 {
+		meta["listing_id"] = s.ListingID
+	}
+	for k, v := range extraMeta {
+		meta[k] = v
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_market_buy', $1, $2, $3, $4::jsonb)`,
+		s.BuyerID, s.SellerID, proceeds, toJSON(meta),
+	); err != nil {
+		return err
+	}
+	if royalty > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_royalty', $1, $2, $3, $4::jsonb)`,
+			s.BuyerID, *creatorID, royalty, toJSON(meta),
+		); err != nil {
+			return err
+		}
+	}
+	if fee > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_market_fee', $1, NULL, $2, $3::jsonb)`,
+			s.BuyerID, fee, toJSON(meta),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListNFTListings returns active listings, cheapest first. nftID=0 lists every NFT.
+func (d *DB) ListNFTListings(ctx context.Context, nftID int64, limit int64) ([]NFTListing, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT l.listing_id, l.seller_id, l.nft_id, n.title, n.image_url, l.qty, l.qty_left, l.price_coins, l.status, l.created_at, l.updated_at, l.quote_currency, l.quote_price,
+       `+activeHoldCol("$3")+`
+FROM nft_listings l
+JOIN nfts n ON n.nft_id = l.nft_id
+WHERE l.status='active' AND ($1=0 OR l.nft_id=$1)
+ORDER BY l.price_coins ASC, l.listing_id ASC
+LIMIT $2
+`, nftID, limit, d.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NFTListing
+	for rows.Next() {
+		var l NFTListing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.NFTID, &l.Title, &l.ImageURL, &l.Qty, &l.QtyLeft, &l.PriceCoins, &l.Status, &l.CreatedAt, &l.UpdatedAt, &l.QuoteCurrency, &l.QuotePrice, &l.ReservedUntil); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// ListNFTSales is the secondary-market price history of an NFT, newest first.
+func (d *DB) ListNFTSales(ctx context.Context, nftID int64, limit int64) ([]NFTSale, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT sale_id, COALESCE(listing_id,0), nft_id, COALESCE(seller_id,0), buyer_id, qty, price_coins, royalty, fee, created_at,
+       COALESCE(quote_currency,''), COALESCE(quote_price,0), COALESCE(quote_rate,0)
+FROM nft_sales
+WHERE nft_id=$1
+ORDER BY created_at DESC, sale_id DESC
+LIMIT $2
+`, nftID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NFTSale
+	for rows.Next() {
+		var s NFTSale
+		if err := rows.Scan(&s.SaleID, &s.ListingID, &s.NFTID, &s.SellerID, &s.BuyerID, &s.Qty, &s.PriceCoins, &s.Royalty, &s.Fee, &s.CreatedAt, &s.QuoteCurrency, &s.QuotePrice, &s.QuoteRate); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,44 @@
+package db
+
+import "testing"
+
+func TestSplitNFTSale(t *testing.T) {
+	royalty, fee, seller := splitNFTSale(10_000, 500, 250)
+	if royalty != 500 || fee != 250 || seller != 9_250 {
+		t.Fatalf("got royalty=%d fee=%d seller=%d", royalty, fee, seller)
+	}
+	royalty, fee, seller = splitNFTSale(99, 500, 250)
+	if royalty+fee+seller != 99 {
+		t.Fatalf("split must add up to the total: %d+%d+%d", royalty, fee, seller)
+	}
+	if royalty, fee, seller = splitNFTSale(0, 500, 250); royalty != 0 || fee != 0 || seller != 0 {
+		t.Fatalf("zero total should split to zeros")
+	}
+}
+
+func TestQuoteToCoins(t *testing.T) {
+	// 1.5 USDT at 1000 BKC per USD.
+	if got := quoteToCoins(1_500_000, 1000); got != 1_500 {
+		t.Fatalf("got %d", got)
+	}
+	// Fractions round up in the seller's favour.
+	if got := quoteToCoins(1, 1000); got != 1 {
+		t.Fatalf("got %d", got)
+	}
+	if got := quoteToCoins(1_000_000, 0); got != 0 {
+		t.Fatalf("zero rate should convert to zero, got %d", got)
+	}
+}
+
+func TestCoinsPerUSD(t *testing.T) {
+	s := SystemState{InitialReserve: 1_000, StartRateCoinsUSD: 1_000, MinRateCoinsUSD: 500}
+	if s.ReserveSupply = 1_000; s.CoinsPerUSD() != 1_000 {
+		t.Fatalf("full reserve: got %d", s.CoinsPerUSD())
+	}
+	if s.ReserveSupply = 500; s.CoinsPerUSD() != 750 {
+		t.Fatalf("half reserve: got %d", s.CoinsPerUSD())
+	}
+	if s.ReserveSupply = -1; s.CoinsPerUSD() != 500 {
+		t.Fatalf("empty reserve: got %d", s.CoinsPerUSD())
+	}
+}
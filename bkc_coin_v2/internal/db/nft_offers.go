@@ -0,0 +1,446 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type NFTOffer struct {
+	OfferID      int64     `json:"offer_id"`
+	BuyerID      int64     `json:"buyer_id"`
+	SellerID     int64     `json:"seller_id"` // 0 = any holder may accept
+	NFTID        int64     `json:"nft_id"`
+	ListingID    int64     `json:"listing_id"`
+	Qty          int64     `json:"qty"`
+	PriceCoins   int64     `json:"price_coins"` // per copy
+	CounterPrice int64     `json:"counter_price"`
+	Status       string    `json:"status"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const maxNFTOfferTTL = 30 * 24 * time.Hour
+
+// CreateNFTOffer freezes price*qty of the buyer's balance behind an offer.
+// With listingID the offer goes to that listing's seller; otherwise any holder
+// of nftID may accept it.
+func (d *DB) CreateNFTOffer(ctx context.Context, buyerID, nftID, listingID, qty, priceCoins int64, ttl time.Duration) (NFTOffer, error) {
+	if buyerID <= 0 || (nftID <= 0 && listingID <= 0) || qty <= 0 || priceCoins <= 0 || ttl <= 0 {
+		return NFTOffer{}, errors.New("bad params")
+	}
+	if ttl > maxNFTOfferTTL {
+		ttl = maxNFTOfferTTL
+	}
+	now := time.Now().UTC()
+	out := NFTOffer{
+		BuyerID:    buyerID,
+		NFTID:      nftID,
+		ListingID:  listingID,
+		Qty:        qty,
+		PriceCoins: priceCoins,
+		Status:     "open",
+		ExpiresAt:  now.Add(ttl),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	total := priceCoins * qty
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if listingID > 0 {
+			var left int64
+			var status string
+			if err := tx.QueryRow(ctx, `SELECT seller_id, nft_id, qty_left, status FROM nft_listings WHERE listing_id=$1`, listingID).Scan(&out.SellerID, &out.NFTID, &left, &status); err != nil {
+				return err
+			}
+			if status != "active" || left < qty {
+				return ErrNotEnough
+			}
+			if out.SellerID == buyerID {
+				return ErrForbidden
+			}
+		} else {
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM nfts WHERE nft_id=$1)`, nftID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return pgx.ErrNoRows
+			}
+		}
+
+		spendable, err := lockSpendableTx(ctx, tx, buyerID)
+		if err != nil {
+			return err
+		}
+		if spendable < total {
+			return ErrNotEnough
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1, frozen_balance=frozen_balance+$1 WHERE user_id=$2`, total, buyerID); err != nil {
+			return err
+		}
+
+		var seller *int64
+		if out.SellerID > 0 {
+			seller = &out.SellerID
+		}
+		var listing *int64
+		if listingID > 0 {
+			listing = &listingID
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO nft_offers (buyer_id, seller_id, nft_id, listing_id, qty, price_coins, status, expires_at, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6,'open',$7,$8,$8)
+RETURNING offer_id
+`, buyerID, seller, out.NFTID, listing, qty, priceCoins, out.ExpiresAt, now).Scan(&out.OfferID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_offer_lock', $1, NULL, $2, $3::jsonb)`,
+			buyerID, total, toJSON(map[string]any{"offer_id": out.OfferID, "nft_id": out.NFTID}),
+		); err != nil {
+			return err
+		}
+
+		payload := offerEventPayload(out)
+		if out.SellerID > 0 {
+			return addUserEventTx(ctx, tx, out.SellerID, "nft_offer_received", payload)
+		}
+		// Open offer: notify every holder with a free copy.
+		_, err = tx.Exec(ctx, `
+INSERT INTO user_events(user_id, kind, payload)
+SELECT user_id, 'nft_offer_received', $3::jsonb
+FROM nft_owns
+WHERE nft_id=$1 AND user_id<>$2 AND qty-staked_qty-listed_qty > 0
+`, out.NFTID, buyerID, toJSON(payload))
+		return err
+	})
+	if err != nil {
+		return NFTOffer{}, err
+	}
+	return out, nil
+}
+
+func offerEventPayload(o NFTOffer) map[string]any {
+	return map[string]any{
+		"offer_id":      o.OfferID,
+		"nft_id":        o.NFTID,
+		"listing_id":    o.ListingID,
+		"buyer_id":      o.BuyerID,
+		"seller_id":     o.SellerID,
+		"qty":           o.Qty,
+		"price_coins":   o.PriceCoins,
+		"counter_price": o.CounterPrice,
+		"status":        o.Status,
+	}
+}
+
+func lockNFTOfferTx(ctx context.Context, tx pgx.Tx, offerID int64) (NFTOffer, error) {
+	var o NFTOffer
+	var seller, listing, counter *int64
+	err := tx.QueryRow(ctx, `
+SELECT offer_id, buyer_id, seller_id, nft_id, listing_id, qty, price_coins, counter_price, status, expires_at, created_at, updated_at
+FROM nft_offers
+WHERE offer_id=$1
+FOR UPDATE
+`, offerID).Scan(&o.OfferID, &o.BuyerID, &seller, &o.NFTID, &listing, &o.Qty, &o.PriceCoins, &counter, &o.Status, &o.ExpiresAt, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return NFTOffer{}, err
+	}
+	if seller != nil {
+		o.SellerID = *seller
+	}
+	if listing != nil {
+		o.ListingID = *listing
+	}
+	if counter != nil {
+		o.CounterPrice = *counter
+	}
+	return o, nil
+}
+
+// closeNFTOfferTx refunds the frozen funds of an open/countered offer and sets its final status.
+func closeNFTOfferTx(ctx context.Context, tx pgx.Tx, o *NFTOffer, status string) error {
+	locked := o.PriceCoins * o.Qty
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1, frozen_balance=GREATEST(frozen_balance-$1, 0) WHERE user_id=$2`, locked, o.BuyerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE nft_offers SET status=$1, updated_at=now() WHERE offer_id=$2`, status, o.OfferID); err != nil {
+		return err
+	}
+	o.Status = status
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_offer_refund', NULL, $1, $2, $3::jsonb)`,
+		o.BuyerID, locked, toJSON(map[string]any{"offer_id": o.OfferID, "status": status}),
+	)
+	return err
+}
+
+func offerIsLive(o NFTOffer, now time.Time) bool {
+	return (o.Status == "open" || o.Status == "countered") && now.Before(o.ExpiresAt)
+}
+
+// AcceptNFTOffer settles an offer. A seller (or, for open offers, any holder)
+// accepts an open offer at the offered price; the buyer accepts a countered
+// offer at the counter price.
+func (d *DB) AcceptNFTOffer(ctx context.Context, userID, offerID, feeBP int64) (NFTSale, error) {
+	if userID <= 0 || offerID <= 0 || feeBP < 0 {
+		return NFTSale{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	var sale NFTSale
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := lockNFTOfferTx(ctx, tx, offerID)
+		if err != nil {
+			return err
+		}
+		if !offerIsLive(o, now) {
+			return ErrNotEnough
+		}
+
+		switch o.Status {
+		case "open":
+			if userID == o.BuyerID || (o.SellerID > 0 && userID != o.SellerID) {
+				return ErrForbidden
+			}
+			o.SellerID = userID
+		case "countered":
+			if userID != o.BuyerID {
+				return ErrForbidden
+			}
+			// Re-size the lock to the counter price before settling from frozen funds.
+			delta := (o.CounterPrice - o.PriceCoins) * o.Qty
+			if delta > 0 {
+				spendable, err := lockSpendableTx(ctx, tx, o.BuyerID)
+				if err != nil {
+					return err
+				}
+				if spendable < delta {
+					return ErrNotEnough
+				}
+			}
+			if delta != 0 {
+				if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1, frozen_balance=frozen_balance+$1 WHERE user_id=$2`, delta, o.BuyerID); err != nil {
+					return err
+				}
+			}
+			o.PriceCoins = o.CounterPrice
+		}
+
+		sale = NFTSale{NFTID: o.NFTID, SellerID: o.SellerID, BuyerID: o.BuyerID, Qty: o.Qty, PriceCoins: o.PriceCoins}
+		if o.ListingID > 0 {
+			var listingSeller, listingNFT, listingPrice int64
+			if err := takeFromNFTListingTx(ctx, tx, o.ListingID, o.BuyerID, o.Qty, d.now(), &listingSeller, &listingNFT, &listingPrice); err != nil {
+				return err
+			}
+			if listingSeller != o.SellerID {
+				return ErrForbidden
+			}
+			sale.ListingID = o.ListingID
+		}
+		if err := settleNFTSaleTx(ctx, tx, &sale, feeBP, true, map[string]any{"offer_id": o.OfferID}); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_offers SET status='accepted', seller_id=$1, price_coins=$2, updated_at=now() WHERE offer_id=$3`, o.SellerID, o.PriceCoins, o.OfferID); err != nil {
+			return err
+		}
+		o.Status = "accepted"
+		payload := offerEventPayload(o)
+		payload["sale_id"] = sale.SaleID
+		if err := addUserEventTx(ctx, tx, o.BuyerID, "nft_offer_accepted", payload); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, o.SellerID, "nft_offer_accepted", payload)
+	})
+	if err != nil {
+		return NFTSale{}, err
+	}
+	return sale, nil
+}
+
+// CounterNFTOffer lets the seller (or any holder, for open offers) propose a new price.
+// The offer becomes bound to that seller and waits for the buyer.
+func (d *DB) CounterNFTOffer(ctx context.Context, sellerID, offerID, counterPrice int64) (NFTOffer, error) {
+	if sellerID <= 0 || offerID <= 0 || counterPrice <= 0 {
+		return NFTOffer{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	var out NFTOffer
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := lockNFTOfferTx(ctx, tx, offerID)
+		if err != nil {
+			return err
+		}
+		if o.Status != "open" || !offerIsLive(o, now) {
+			return ErrNotEnough
+		}
+		if sellerID == o.BuyerID || (o.SellerID > 0 && sellerID != o.SellerID) {
+			return ErrForbidden
+		}
+		if o.SellerID == 0 {
+			var free int64
+			if err := tx.QueryRow(ctx, `SELECT qty-staked_qty-listed_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2`, sellerID, o.NFTID).Scan(&free); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrForbidden
+				}
+				return err
+			}
+			if free < o.Qty {
+				return ErrNotEnough
+			}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_offers SET status='countered', seller_id=$1, counter_price=$2, updated_at=now() WHERE offer_id=$3`, sellerID, counterPrice, offerID); err != nil {
+			return err
+		}
+		o.Status = "countered"
+		o.SellerID = sellerID
+		o.CounterPrice = counterPrice
+		out = o
+		return addUserEventTx(ctx, tx, o.BuyerID, "nft_offer_countered", offerEventPayload(o))
+	})
+	if err != nil {
+		return NFTOffer{}, err
+	}
+	return out, nil
+}
+
+// DeclineNFTOffer refunds the buyer. The seller declines an offer made to them;
+// the buyer declines a counter-offer.
+func (d *DB) DeclineNFTOffer(ctx context.Context, userID, offerID int64) error {
+	if userID <= 0 || offerID <= 0 {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := lockNFTOfferTx(ctx, tx, offerID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case o.Status == "open" && o.SellerID > 0 && userID == o.SellerID:
+		case o.Status == "countered" && userID == o.BuyerID:
+		case o.Status == "open" || o.Status == "countered":
+			return ErrForbidden
+		default:
+			return nil
+		}
+		if err := closeNFTOfferTx(ctx, tx, &o, "declined"); err != nil {
+			return err
+		}
+		target := o.BuyerID
+		if userID == o.BuyerID {
+			target = o.SellerID
+		}
+		return addUserEventTx(ctx, tx, target, "nft_offer_declined", offerEventPayload(o))
+	})
+}
+
+// CancelNFTOffer lets the buyer withdraw an offer and get the frozen funds back.
+func (d *DB) CancelNFTOffer(ctx context.Context, buyerID, offerID int64) error {
+	if buyerID <= 0 || offerID <= 0 {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := lockNFTOfferTx(ctx, tx, offerID)
+		if err != nil {
+			return err
+		}
+		if o.BuyerID != buyerID {
+			return ErrForbidden
+		}
+		if o.Status != "open" && o.Status != "countered" {
+			return nil
+		}
+		if err := closeNFTOfferTx(ctx, tx, &o, "cancelled"); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, o.SellerID, "nft_offer_cancelled", offerEventPayload(o))
+	})
+}
+
+// ExpireNFTOffers refunds offers past their expiry (scheduled job).
+func (d *DB) ExpireNFTOffers(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT offer_id
+FROM nft_offers
+WHERE status IN ('open','countered') AND expires_at <= $1
+ORDER BY expires_at
+LIMIT 500
+`, now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, id := range ids {
+		err := d.WithTx(ctx, func(tx pgx.Tx) error {
+			o, err := lockNFTOfferTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if (o.Status != "open" && o.Status != "countered") || now.Before(o.ExpiresAt) {
+				return nil
+			}
+			if err := closeNFTOfferTx(ctx, tx, &o, "expired"); err != nil {
+				return err
+			}
+			n++
+			if err := addUserEventTx(ctx, tx, o.BuyerID, "nft_offer_expired", offerEventPayload(o)); err != nil {
+				return err
+			}
+			return addUserEventTx(ctx, tx, o.SellerID, "nft_offer_expired", offerEventPayload(o))
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ListNFTOffers returns offers the user made (outgoing) or can act on (incoming).
+func (d *DB) ListNFTOffers(ctx context.Context, userID int64, incoming bool, limit int64) ([]NFTOffer, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	where := `o.buyer_id=$1`
+	if incoming {
+		where = `(o.seller_id=$1 OR (o.seller_id IS NULL AND o.status='open' AND EXISTS(
+  SELECT 1 FROM nft_owns w WHERE w.user_id=$1 AND w.nft_id=o.nft_id AND w.qty > 0
+)))`
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT o.offer_id, o.buyer_id, COALESCE(o.seller_id,0), o.nft_id, COALESCE(o.listing_id,0), o.qty, o.price_coins, COALESCE(o.counter_price,0), o.status, o.expires_at, o.created_at, o.updated_at
+FROM nft_offers o
+WHERE `+where+`
+ORDER BY o.created_at DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NFTOffer
+	for rows.Next() {
+		var o NFTOffer
+		if err := rows.Scan(&o.OfferID, &o.BuyerID, &o.SellerID, &o.NFTID, &o.ListingID, &o.Qty, &o.PriceCoins, &o.CounterPrice, &o.Status, &o.ExpiresAt, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
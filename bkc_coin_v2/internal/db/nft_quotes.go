@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Currencies an NFT listing can be priced in. Non-BKC prices are converted
+// to BKC at checkout; the buyer always pays in BKC.
+const (
+	QuoteBKC  = "BKC"
+	QuoteUSDT = "USDT"
+	QuoteTON  = "TON"
+)
+
+// QuoteScale is the fixed-point scale of quoted prices: 1.5 USDT is stored as 1_500_000.
+const QuoteScale = 1_000_000
+
+// QuoteRates is the rates oracle: USD value of one unit of a quote currency.
+type QuoteRates interface {
+	USDPerUnit(ctx context.Context, currency string) (float64, error)
+}
+
+func normalizeQuoteCurrency(c string) (string, error) {
+	switch c = strings.ToUpper(strings.TrimSpace(c)); c {
+	case "":
+		return QuoteBKC, nil
+	case QuoteBKC, QuoteUSDT, QuoteTON:
+		return c, nil
+	}
+	return "", errors.New("bad currency")
+}
+
+// quoteToCoins converts a quoted price to BKC. It rounds up so the seller
+// never receives less than the quoted value.
+func quoteToCoins(quote int64, coinsPerUnit float64) int64 {
+	if quote <= 0 || coinsPerUnit <= 0 || math.IsNaN(coinsPerUnit) || math.IsInf(coinsPerUnit, 0) {
+		return 0
+	}
+	return int64(math.Ceil(float64(quote) * coinsPerUnit / QuoteScale))
+}
+
+// quoteRate returns how many BKC one unit of currency is worth right now.
+func quoteRate(ctx context.Context, tx pgx.Tx, rates QuoteRates, currency string) (float64, error) {
+	if rates == nil {
+		return 0, errors.New("rates unavailable")
+	}
+	usd, err := rates.USDPerUnit(ctx, currency)
+	if err != nil {
+		return 0, err
+	}
+	if usd <= 0 || math.IsNaN(usd) || math.IsInf(usd, 0) {
+		return 0, errors.New("bad rate")
+	}
+	var s SystemState
+	if err := tx.QueryRow(ctx, `
+SELECT reserve_supply, initial_reserve, start_rate_coins_usd, min_rate_coins_usd
+FROM system_state
+WHERE id=1
+`).Scan(&s.ReserveSupply, &s.InitialReserve, &s.StartRateCoinsUSD, &s.MinRateCoinsUSD); err != nil {
+		return 0, err
+	}
+	return usd * float64(s.CoinsPerUSD()), nil
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NFTAttribute is a single trait of an NFT design, e.g. background=gold.
+type NFTAttribute struct {
+	TraitType string `json:"trait_type"`
+	Value     string `json:"value"`
+}
+
+// NFTFilter narrows ListNFTsFiltered. Zero values mean "no filter".
+// Sort is one of: rarity, price_asc, price_desc, new (default).
+type NFTFilter struct {
+	Collection string
+	Tier       string
+	Traits     []NFTAttribute
+	Sort       string
+	Limit      int64
+}
+
+// Rarity tiers by rank percentile within a collection (top N%).
+var rarityTiers = []struct {
+	Name string
+	Pct  float64
+}{
+	{"legendary", 1},
+	{"epic", 5},
+	{"rare", 15},
+	{"uncommon", 40},
+}
+
+func IsRarityTier(tier string) bool {
+	if tier == "common" {
+		return true
+	}
+	for _, t := range rarityTiers {
+		if t.Name == tier {
+			return true
+		}
+	}
+	return false
+}
+
+func rarityTierForRank(rank, total int64) string {
+	if rank <= 0 || total <= 0 {
+		return "common"
+	}
+	pct := float64(rank) * 100 / float64(total)
+	for _, t := range rarityTiers {
+		if pct <= t.Pct {
+			return t.Name
+		}
+	}
+	return "common"
+}
+
+type rarityItem struct {
+	NFTID  int64
+	Weight int64 // supply_total: a design minted 10k times is common
+	Attrs  map[string]string
+}
+
+type rarityResult struct {
+	Score float64
+	Rank  int64
+	Tier  string
+}
+
+// computeRarity scores items with the "statistical rarity" sum:
+// score = sum over trait types of totalWeight / weight(items sharing that value).
+// A missing trait counts as its own value, so lacking a common trait is rare too.
+// Equal scores share a rank.
+func computeRarity(items []rarityItem) map[int64]rarityResult {
+	out := make(map[int64]rarityResult, len(items))
+	if len(items) == 0 {
+		return out
+	}
+
+	var total int64
+	traitTypes := map[string]struct{}{}
+	for i := range items {
+		if items[i].Weight <= 0 {
+			items[i].Weight = 1
+		}
+		total += items[i].Weight
+		for t := range items[i].Attrs {
+			traitTypes[t] = struct{}{}
+		}
+	}
+
+	counts := map[string]map[string]int64{}
+	for t := range traitTypes {
+		counts[t] = map[string]int64{}
+		for _, it := range items {
+			counts[t][it.Attrs[t]] += it.Weight
+		}
+	}
+
+	type scored struct {
+		id    int64
+		score float64
+	}
+	list := make([]scored, 0, len(items))
+	for _, it := range items {
+		var score float64
+		for t := range traitTypes {
+			score += float64(total) / float64(counts[t][it.Attrs[t]])
+		}
+		list = append(list, scored{id: it.NFTID, score: score})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].id < list[j].id
+	})
+
+	n := int64(len(list))
+	var rank int64
+	for i, s := range list {
+		if i == 0 || s.score != list[i-1].score {
+			rank = int64(i) + 1
+		}
+		out[s.id] = rarityResult{Score: s.score, Rank: rank, Tier: rarityTierForRank(rank, n)}
+	}
+	return out
+}
+
+func normalizeAttributes(attrs []NFTAttribute) ([]NFTAttribute, error) {
+	seen := map[string]struct{}{}
+	out := make([]NFTAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		t := strings.ToLower(strings.TrimSpace(a.TraitType))
+		v := strings.TrimSpace(a.Value)
+		if t == "" || v == "" {
+			return nil, errors.New("bad attribute")
+		}
+		if _, ok := seen[t]; ok {
+			return nil, errors.New("bad attribute: duplicate trait " + t)
+		}
+		seen[t] = struct{}{}
+		out = append(out, NFTAttribute{TraitType: t, Value: v})
+	}
+	return out, nil
+}
+
+// SetNFTAttributes replaces the attributes of an NFT (and optionally moves it into a collection),
+// then recomputes rarity for the whole collection in the same transaction.
+func (d *DB) SetNFTAttributes(ctx context.Context, nftID int64, collection string, attrs []NFTAttribute) error {
+	if nftID <= 0 {
+		return errors.New("bad nft_id")
+	}
+	attrs, err := normalizeAttributes(attrs)
+	if err != nil {
+		return err
+	}
+	collection = strings.ToLower(strings.TrimSpace(collection))
+
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var current string
+		if err := tx.QueryRow(ctx, `SELECT collection FROM nfts WHERE nft_id=$1 FOR UPDATE`, nftID).Scan(&current); err != nil {
+			return err
+		}
+		if collection != "" && collection != current {
+			if _, err := tx.Exec(ctx, `UPDATE nfts SET collection=$1 WHERE nft_id=$2`, collection, nftID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM nft_attributes WHERE nft_id=$1`, nftID); err != nil {
+			return err
+		}
+		for _, a := range attrs {
+			if _, err := tx.Exec(ctx, `INSERT INTO nft_attributes(nft_id, trait_type, value) VALUES($1,$2,$3)`, nftID, a.TraitType, a.Value); err != nil {
+				return err
+			}
+		}
+		if collection != "" && collection != current {
+			if err := recomputeRarityTx(ctx, tx, current); err != nil {
+				return err
+			}
+		} else {
+			collection = current
+		}
+		return recomputeRarityTx(ctx, tx, collection)
+	})
+}
+
+// RecomputeNFTRarity rescores every NFT in a collection.
+func (d *DB) RecomputeNFTRarity(ctx context.Context, collection string) error {
+	collection = strings.ToLower(strings.TrimSpace(collection))
+	if collection == "" {
+		collection = "default"
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		return recomputeRarityTx(ctx, tx, collection)
+	})
+}
+
+func recomputeRarityTx(ctx context.Context, tx pgx.Tx, collection string) error {
+	rows, err := tx.Query(ctx, `
+SELECT n.nft_id, n.supply_total, a.trait_type, a.value
+FROM nfts n
+LEFT JOIN nft_attributes a ON a.nft_id = n.nft_id
+WHERE n.collection=$1
+ORDER BY n.nft_id
+FOR UPDATE OF n
+`, collection)
+	if err != nil {
+		return err
+	}
+	byID := map[int64]*rarityItem{}
+	var order []int64
+	for rows.Next() {
+		var id, supply int64
+		var trait, value *string
+		if err := rows.Scan(&id, &supply, &trait, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		it := byID[id]
+		if it == nil {
+			it = &rarityItem{NFTID: id, Weight: supply, Attrs: map[string]string{}}
+			byID[id] = it
+			order = append(order, id)
+		}
+		if trait != nil && value != nil {
+			it.Attrs[*trait] = *value
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	items := make([]rarityItem, 0, len(order))
+	for _, id := range order {
+		items = append(items, *byID[id])
+	}
+	for id, res := range computeRarity(items) {
+		if _, err := tx.Exec(ctx, `UPDATE nfts SET rarity_score=$1, rarity_rank=$2, rarity_tier=$3 WHERE nft_id=$4`, res.Score, res.Rank, res.Tier, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) GetNFTAttributes(ctx context.Context, nftID int64) ([]NFTAttribute, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT trait_type, value FROM nft_attributes WHERE nft_id=$1 ORDER BY trait_type`, nftID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NFTAttribute
+	for rows.Next() {
+		var a NFTAttribute
+		if err := rows.Scan(&a.TraitType, &a.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ListNFTsFiltered lists shop NFTs with rarity data and attributes, filtered by collection,
+// rarity tier and exact trait matches (all traits must match).
+func (d *DB) ListNFTsFiltered(ctx context.Context, f NFTFilter) ([]NFT, error) {
+	if f.Limit <= 0 || f.Limit > 200 {
+		f.Limit = 50
+	}
+	where := []string{"TRUE"}
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if c := strings.ToLower(strings.TrimSpace(f.Collection)); c != "" {
+		where = append(where, "n.collection="+arg(c))
+	}
+	if t := strings.ToLower(strings.TrimSpace(f.Tier)); t != "" {
+		if !IsRarityTier(t) {
+			return nil, errors.New("bad tier")
+		}
+		where = append(where, "n.rarity_tier="+arg(t))
+	}
+	for _, tr := range f.Traits {
+		t := strings.ToLower(strings.TrimSpace(tr.TraitType))
+		v := strings.TrimSpace(tr.Value)
+		if t == "" || v == "" {
+			continue
+		}
+		where = append(where, fmt.Sprintf("EXISTS(SELECT 1 FROM nft_attributes a WHERE a.nft_id=n.nft_id AND a.trait_type=%s AND a.value=%s)", arg(t), arg(v)))
+	}
+
+	order := "n.nft_id DESC"
+	switch f.Sort {
+	case "rarity":
+		order = "n.rarity_score DESC, n.nft_id ASC"
+	case "price_asc":
+		order = "n.price_coins ASC, n.nft_id DESC"
+	case "price_desc":
+		order = "n.price_coins DESC, n.nft_id DESC"
+	}
+
+	rows, err := d.Pool.Query(ctx, `
+SELECT n.nft_id, n.title, n.image_url, n.price_coins, n.supply_left, n.created_at, n.collection, n.rarity_score, n.rarity_rank, n.rarity_tier, n.effects
+FROM nfts n
+WHERE `+strings.Join(where, " AND ")+`
+ORDER BY `+order+`
+LIMIT `+arg(f.Limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NFT
+	idx := map[int64]int{}
+	var ids []int64
+	for rows.Next() {
+		var n NFT
+		var effects []byte
+		if err := rows.Scan(&n.NFTID, &n.Title, &n.ImageURL, &n.PriceCoins, &n.SupplyLeft, &n.CreatedAt, &n.Collection, &n.RarityScore, &n.RarityRank, &n.RarityTier, &effects); err != nil {
+			return nil, err
+		}
+		if n.Effects, err = parseNFTEffects(effects); err != nil {
+			return nil, err
+		}
+		idx[n.NFTID] = len(items)
+		ids = append(ids, n.NFTID)
+		items = append(items, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return items, nil
+	}
+
+	arows, err := d.Pool.Query(ctx, `SELECT nft_id, trait_type, value FROM nft_attributes WHERE nft_id = ANY($1) ORDER BY trait_type`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer arows.Close()
+	for arows.Next() {
+		var id int64
+		var a NFTAttribute
+		if err := arows.Scan(&id, &a.TraitType, &a.Value); err != nil {
+			return nil, err
+		}
+		if i, ok := idx[id]; ok {
+			items[i].Attributes = append(items[i].Attributes, a)
+		}
+	}
+	return items, arows.Err()
+}
@@ -0,0 +1,44 @@
+package db
+
+import "testing"
+
+func TestComputeRarity(t *testing.T) {
+	items := []rarityItem{
+		{NFTID: 1, Weight: 1, Attrs: map[string]string{"background": "gold", "eyes": "laser"}},
+		{NFTID: 2, Weight: 10, Attrs: map[string]string{"background": "blue", "eyes": "normal"}},
+		{NFTID: 3, Weight: 10, Attrs: map[string]string{"background": "blue", "eyes": "normal"}},
+		{NFTID: 4, Weight: 5, Attrs: map[string]string{"background": "blue"}},
+	}
+	res := computeRarity(items)
+	if len(res) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(res))
+	}
+	if res[1].Rank != 1 {
+		t.Errorf("gold/laser should rank first, got rank %d", res[1].Rank)
+	}
+	if res[2].Rank != res[3].Rank || res[2].Score != res[3].Score {
+		t.Errorf("identical designs should share rank: %+v vs %+v", res[2], res[3])
+	}
+	if res[4].Score <= res[2].Score {
+		t.Errorf("missing trait should be rarer than the common value: %v <= %v", res[4].Score, res[2].Score)
+	}
+}
+
+func TestRarityTierForRank(t *testing.T) {
+	cases := []struct {
+		rank, total int64
+		want        string
+	}{
+		{1, 100, "legendary"},
+		{5, 100, "epic"},
+		{15, 100, "rare"},
+		{40, 100, "uncommon"},
+		{41, 100, "common"},
+		{0, 100, "common"},
+	}
+	for _, c := range cases {
+		if got := rarityTierForRank(c.rank, c.total); got != c.want {
+			t.Errorf("rank %d/%d: got %s, want %s", c.rank, c.total, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NFT rentals lend the utility effects of an NFT for a number of days
+// without moving ownership. An offer reserves one copy of the owner's NFT
+// (it counts as listed, so it can't be sold, staked or listed elsewhere);
+// a renter pays fee_per_day * days up front, the platform keeps its fee and
+// the owner the rest. While the rental runs the renter has the design's
+// effects and the owner, for that copy, does not. The rental ends by itself
+// at ends_at; the nft_rentals job only books the return.
+
+// NFTRentalOffer is a copy of an NFT up for rent.
+type NFTRentalOffer struct {
+	OfferID     int64      `json:"offer_id"`
+	OwnerID     int64      `json:"owner_id"`
+	NFTID       int64      `json:"nft_id"`
+	Title       string     `json:"title"`
+	FeePerDay   int64      `json:"fee_per_day"`
+	MinDays     int64      `json:"min_days"`
+	MaxDays     int64      `json:"max_days"`
+	Status      string     `json:"status"`                 // active | cancelled
+	RentedUntil *time.Time `json:"rented_until,omitempty"` // set while rented out
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// NFTRental is one paid rental period.
+type NFTRental struct {
+	RentalID    int64     `json:"rental_id"`
+	OfferID     int64     `json:"offer_id"`
+	OwnerID     int64     `json:"owner_id"`
+	RenterID    int64     `json:"renter_id"`
+	NFTID       int64     `json:"nft_id"`
+	Days        int64     `json:"days"`
+	Paid        int64     `json:"paid"`
+	Fee         int64     `json:"fee"`
+	OwnerIncome int64     `json:"owner_income"`
+	Status      string    `json:"status"` // active | returned
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+}
+
+// NFTRentalEarning is an owner's rental income from one NFT.
+type NFTRentalEarning struct {
+	NFTID   int64  `json:"nft_id"`
+	Title   string `json:"title"`
+	Rentals int64  `json:"rentals"`
+	Days    int64  `json:"days"`
+	Earned  int64  `json:"earned"`
+}
+
+// NFTRentalReport sums an owner's rental income since a date.
+type NFTRentalReport struct {
+	Since   time.Time          `json:"since"`
+	Rentals int64              `json:"rentals"`
+	Days    int64              `json:"days"`
+	Paid    int64              `json:"paid"` // by renters
+	Fees    int64              `json:"fees"`
+	Earned  int64              `json:"earned"`
+	Active  int64              `json:"active"` // rentals running now
+	ByNFT   []NFTRentalEarning `json:"by_nft"`
+}
+
+// splitRentalFee prices days of rent: the renter pays total, the platform
+// keeps fee (feeBP of it) and the owner gets the rest.
+func splitRentalFee(feePerDay, days, feeBP int64) (total, fee, owner int64) {
+	total = feePerDay * days
+	fee = total * feeBP / 10_000
+	return total, fee, total - fee
+}
+
+// CreateNFTRentalOffer puts one free copy of an owned NFT up for rent.
+func (d *DB) CreateNFTRentalOffer(ctx context.Context, ownerID, nftID, feePerDay, minDays, maxDays, maxDaysCap int64) (NFTRentalOffer, error) {
+	if minDays <= 0 {
+		minDays = 1
+	}
+	if maxDays <= 0 {
+		maxDays = maxDaysCap
+	}
+	if ownerID <= 0 || nftID <= 0 || feePerDay <= 0 || minDays > maxDays || maxDays > maxDaysCap {
+		return NFTRentalOffer{}, errors.New("bad params")
+	}
+	out := NFTRentalOffer{OwnerID: ownerID, NFTID: nftID, FeePerDay: feePerDay, MinDays: minDays, MaxDays: maxDays, Status: "active"}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var owned, staked, listed int64
+		if err := tx.QueryRow(ctx, `SELECT qty, staked_qty, listed_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2 FOR UPDATE`, ownerID, nftID).Scan(&owned, &staked, &listed); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotEnough
+			}
+			return err
+		}
+		if owned-staked-listed < 1 {
+			return ErrNotEnough
+		}
+		var raw []byte
+		if err := tx.QueryRow(ctx, `SELECT title, effects FROM nfts WHERE nft_id=$1`, nftID).Scan(&out.Title, &raw); err != nil {
+			return err
+		}
+		if eff, err := parseNFTEffects(raw); err != nil || eff.IsZero() {
+			return errors.New("bad nft_id: no effects to rent")
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=listed_qty+1 WHERE user_id=$1 AND nft_id=$2`, ownerID, nftID); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO nft_rental_offers (owner_id, nft_id, fee_per_day, min_days, max_days)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING offer_id, created_at
+`, ownerID, nftID, feePerDay, minDays, maxDays).Scan(&out.OfferID, &out.CreatedAt)
+	})
+	if err != nil {
+		return NFTRentalOffer{}, err
+	}
+	return out, nil
+}
+
+// ListNFTRentalOffers returns active offers, optionally for one NFT; rented
+// out offers carry rented_until.
+func (d *DB) ListNFTRentalOffers(ctx context.Context, nftID, limit int64) ([]NFTRentalOffer, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT o.offer_id, o.owner_id, o.nft_id, n.title, o.fee_per_day, o.min_days, o.max_days, o.status, r.ends_at, o.created_at
+FROM nft_rental_offers o
+JOIN nfts n ON n.nft_id = o.nft_id
+LEFT JOIN nft_rentals r ON r.offer_id = o.offer_id AND r.status = 'active' AND r.ends_at > now()
+WHERE o.status = 'active' AND ($1 = 0 OR o.nft_id = $1)
+ORDER BY (r.ends_at IS NOT NULL), o.fee_per_day, o.offer_id
+LIMIT $2
+`, nftID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTRentalOffer{}
+	for rows.Next() {
+		var o NFTRentalOffer
+		if err := rows.Scan(&o.OfferID, &o.OwnerID, &o.NFTID, &o.Title, &o.FeePerDay, &o.MinDays, &o.MaxDays, &o.Status, &o.RentedUntil, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// CancelNFTRentalOffer withdraws an offer and frees its copy. A running
+// rental has to end first.
+func (d *DB) CancelNFTRentalOffer(ctx context.Context, ownerID, offerID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var owner, nftID int64
+		var status string
+		if err := tx.QueryRow(ctx, `SELECT owner_id, nft_id, status FROM nft_rental_offers WHERE offer_id=$1 FOR UPDATE`, offerID).Scan(&owner, &nftID, &status); err != nil {
+			return err
+		}
+		if owner != ownerID {
+			return ErrForbidden
+		}
+		if status != "active" {
+			return nil
+		}
+		var rented bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM nft_rentals WHERE offer_id=$1 AND status='active' AND ends_at > now())`, offerID).Scan(&rented); err != nil {
+			return err
+		}
+		if rented {
+			return ErrLocked
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_rental_offers SET status='cancelled' WHERE offer_id=$1`, offerID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE nft_owns SET listed_qty=GREATEST(listed_qty-1, 0) WHERE user_id=$1 AND nft_id=$2`, ownerID, nftID)
+		return err
+	})
+}
+
+// RentNFT rents an offer for days, paying up front. feeBP is the platform
+// fee, reduced by the owner's NFT fee discount like a market sale.
+func (d *DB) RentNFT(ctx context.Context, renterID, offerID, days, feeBP int64) (NFTRental, error) {
+	if renterID <= 0 || days <= 0 {
+		return NFTRental{}, errors.New("bad params")
+	}
+	out := NFTRental{OfferID: offerID, RenterID: renterID, Days: days, Status: "active"}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var feePerDay, minDays, maxDays int64
+		var status string
+		if err := tx.QueryRow(ctx, `
+SELECT owner_id, nft_id, fee_per_day, min_days, max_days, status
+FROM nft_rental_offers WHERE offer_id=$1 FOR UPDATE
+`, offerID).Scan(&out.OwnerID, &out.NFTID, &feePerDay, &minDays, &maxDays, &status); err != nil {
+			return err
+		}
+		if status != "active" {
+			return pgx.ErrNoRows
+		}
+		if out.OwnerID == renterID {
+			return ErrForbidden
+		}
+		if days < minDays || days > maxDays {
+			return errors.New("bad days")
+		}
+		now := time.Now().UTC()
+		var rented bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM nft_rentals WHERE offer_id=$1 AND status='active' AND ends_at > $2)`, offerID, now).Scan(&rented); err != nil {
+			return err
+		}
+		if rented {
+			return ErrLocked
+		}
+		eff, err := resolveUserNFTEffects(ctx, tx, out.OwnerID)
+		if err != nil {
+			return err
+		}
+		out.Paid, out.Fee, out.OwnerIncome = splitRentalFee(feePerDay, days, ApplyFeeDiscount(feeBP, eff.FeeDiscountBP))
+		out.StartsAt, out.EndsAt = now, now.Add(time.Duration(days)*24*time.Hour)
+
+		if err := lockUsersTx(ctx, tx, renterID, out.OwnerID); err != nil {
+			return err
+		}
+		if err := debitSpendableTx(ctx, tx, renterID, out.Paid); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, out.OwnerIncome, out.OwnerID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO nft_rentals (offer_id, owner_id, renter_id, nft_id, days, paid, fee, owner_income, starts_at, ends_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING rental_id
+`, offerID, out.OwnerID, renterID, out.NFTID, days, out.Paid, out.Fee, out.OwnerIncome, out.StartsAt, out.EndsAt).Scan(&out.RentalID); err != nil {
+			return err
+		}
+		meta := map[string]any{"rental_id": out.RentalID, "offer_id": offerID, "nft_id": out.NFTID, "days": days, "ends_at": out.EndsAt.Unix()}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_rent', $1, $2, $3, $4::jsonb)`,
+			renterID, out.OwnerID, out.OwnerIncome, toJSON(meta)); err != nil {
+			return err
+		}
+		if out.Fee > 0 {
+			if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, out.Fee); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_rent_fee', $1, NULL, $2, $3::jsonb)`,
+				renterID, out.Fee, toJSON(meta)); err != nil {
+				return err
+			}
+		}
+		return addUserEventTx(ctx, tx, out.OwnerID, "nft_rented", map[string]any{"rental_id": out.RentalID, "nft_id": out.NFTID, "renter_id": renterID, "days": days, "income": out.OwnerIncome})
+	})
+	if err != nil {
+		return NFTRental{}, err
+	}
+	return out, nil
+}
+
+// ListNFTRentals returns the user's rentals as renter and as owner, newest
+// first.
+func (d *DB) ListNFTRentals(ctx context.Context, userID, limit int64) ([]NFTRental, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT rental_id, offer_id, owner_id, renter_id, nft_id, days, paid, fee, owner_income, status, starts_at, ends_at
+FROM nft_rentals
+WHERE renter_id=$1 OR owner_id=$1
+ORDER BY rental_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTRental{}
+	for rows.Next() {
+		var r NFTRental
+		if err := rows.Scan(&r.RentalID, &r.OfferID, &r.OwnerID, &r.RenterID, &r.NFTID, &r.Days, &r.Paid, &r.Fee, &r.OwnerIncome, &r.Status, &r.StartsAt, &r.EndsAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// NFTRentalEarnings reports the owner's rental income from rentals started
+// since since, per NFT.
+func (d *DB) NFTRentalEarnings(ctx context.Context, ownerID int64, since time.Time) (NFTRentalReport, error) {
+	out := NFTRentalReport{Since: since, ByNFT: []NFTRentalEarning{}}
+	rows, err := d.Pool.Query(ctx, `
+SELECT r.nft_id, n.title, COUNT(*), SUM(r.days), SUM(r.paid), SUM(r.fee), SUM(r.owner_income),
+       COUNT(*) FILTER (WHERE r.status = 'active' AND r.ends_at > now())
+FROM nft_rentals r
+JOIN nfts n ON n.nft_id = r.nft_id
+WHERE r.owner_id=$1 AND r.starts_at >= $2
+GROUP BY r.nft_id, n.title
+ORDER BY SUM(r.owner_income) DESC, r.nft_id
+`, ownerID, since)
+	if err != nil {
+		return NFTRentalReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e NFTRentalEarning
+		var paid, fees, active int64
+		if err := rows.Scan(&e.NFTID, &e.Title, &e.Rentals, &e.Days, &paid, &fees, &e.Earned, &active); err != nil {
+			return NFTRentalReport{}, err
+		}
+		out.Rentals += e.Rentals
+		out.Days += e.Days
+		out.Paid += paid
+		out.Fees += fees
+		out.Earned += e.Earned
+		out.Active += active
+		out.ByNFT = append(out.ByNFT, e)
+	}
+	return out, rows.Err()
+}
+
+// ExpireNFTRentals books the return of rentals that ended by now and tells
+// both sides. The effects already stopped at ends_at.
+func (d *DB) ExpireNFTRentals(ctx context.Context, now time.Time) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+UPDATE nft_rentals SET status='returned'
+WHERE status='active' AND ends_at <= $1
+RETURNING rental_id, owner_id, renter_id, nft_id
+`, now)
+		if err != nil {
+			return err
+		}
+		var ended [][4]int64
+		for rows.Next() {
+			var r [4]int64
+			if err := rows.Scan(&r[0], &r[1], &r[2], &r[3]); err != nil {
+				rows.Close()
+				return err
+			}
+			ended = append(ended, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, r := range ended {
+			payload := map[string]any{"rental_id": r[0], "nft_id": r[3]}
+			if err := addUserEventTx(ctx, tx, r[1], "nft_rental_returned", payload); err != nil {
+				return err
+			}
+			if err := addUserEventTx(ctx, tx, r[2], "nft_rental_ended", payload); err != nil {
+				return err
+			}
+		}
+		n = int64(len(ended))
+		return nil
+	})
+	return n, err
+}
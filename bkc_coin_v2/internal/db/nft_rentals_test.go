@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitRentalFee(t *testing.T) {
+	cases := []struct {
+		perDay, days, feeBP  int64
+		total, fee, ownerGot int64
+	}{
+		{100, 7, 1_000, 700, 70, 630},
+		{33, 3, 250, 99, 2, 97}, // fee rounds down
+		{100, 1, 0, 100, 0, 100},
+	}
+	for _, c := range cases {
+		total, fee, owner := splitRentalFee(c.perDay, c.days, c.feeBP)
+		if total != c.total || fee != c.fee || owner != c.ownerGot {
+			t.Fatalf("%d x %d at %d bp: got %d/%d/%d", c.perDay, c.days, c.feeBP, total, fee, owner)
+		}
+		if fee+owner != total {
+			t.Fatalf("split does not add up: %d + %d != %d", fee, owner, total)
+		}
+	}
+}
+
+func TestRentNFT(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const owner, renter = 9_301_700_001, 9_301_700_002
+	seedMoneyUsers(t, d, 1_000, owner, renter)
+	nftID, err := d.CreateNFT(ctx, "rental", "https://example.com/rental.png", 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_rentals WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_rental_offers WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_owns WHERE nft_id=$1`, nftID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nfts WHERE nft_id=$1`, nftID)
+	})
+	if err := d.SetNFTEffects(ctx, nftID, NFTEffects{TapMultiplier: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO nft_owns(user_id, nft_id, qty) VALUES($1, $2, 1)`, owner, nftID); err != nil {
+		t.Fatal(err)
+	}
+	o, err := d.CreateNFTRentalOffer(ctx, owner, nftID, 100, 1, 7, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := moneySystem(t, d)
+
+	r, err := d.RentNFT(ctx, renter, o.OfferID, 3, 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Paid != 300 || r.Fee != 30 || r.OwnerIncome != 270 {
+		t.Fatalf("rental: %+v", r)
+	}
+	if _, err := d.RentNFT(ctx, renter, o.OfferID, 1, 1_000); err == nil {
+		t.Fatal("rented out twice")
+	}
+	if got := checkLedger(t, d, renter, 1_000); got != 1_000-300 {
+		t.Fatalf("renter balance %d", got)
+	}
+	if got := checkLedger(t, d, owner, 1_000); got != 1_000+270 {
+		t.Fatalf("owner balance %d", got)
+	}
+	if s := moneySystem(t, d); s.ReserveSupply != before.ReserveSupply+30 {
+		t.Fatalf("reserve %d, want %d", s.ReserveSupply, before.ReserveSupply+30)
+	}
+}
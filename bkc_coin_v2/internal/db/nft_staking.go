@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type NFTStake struct {
+	StakeID      int64      `json:"stake_id"`
+	UserID       int64      `json:"user_id"`
+	NFTID        int64      `json:"nft_id"`
+	Qty          int64      `json:"qty"`
+	DailyReward  int64      `json:"daily_reward"`
+	Status       string     `json:"status"`
+	LockUntil    time.Time  `json:"lock_until"`
+	Accrued      int64      `json:"accrued"`
+	Claimed      int64      `json:"claimed"`
+	AccruedUntil time.Time  `json:"accrued_until"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+}
+
+// Claimable is accrued but not yet paid out.
+func (s NFTStake) Claimable() int64 {
+	return s.Accrued - s.Claimed
+}
+
+// stakeTierMultiplier scales the base daily staking reward by rarity tier.
+var stakeTierMultiplier = map[string]int64{
+	"common":    1,
+	"uncommon":  2,
+	"rare":      4,
+	"epic":      8,
+	"legendary": 16,
+}
+
+// StakeNFT locks qty copies of an owned NFT for at least lockDays.
+// Rewards accrue per full day staked: baseDaily * tier multiplier * qty.
+func (d *DB) StakeNFT(ctx context.Context, userID, nftID, qty, baseDaily, lockDays int64) (NFTStake, error) {
+	if userID <= 0 || nftID <= 0 || qty <= 0 || baseDaily < 0 || lockDays < 0 {
+		return NFTStake{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	out := NFTStake{
+		UserID:       userID,
+		NFTID:        nftID,
+		Qty:          qty,
+		Status:       "active",
+		LockUntil:    now.Add(time.Duration(lockDays) * 24 * time.Hour),
+		AccruedUntil: now,
+		CreatedAt:    now,
+	}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var owned, staked, listed int64
+		if err := tx.QueryRow(ctx, `SELECT qty, staked_qty, listed_qty FROM nft_owns WHERE user_id=$1 AND nft_id=$2 FOR UPDATE`, userID, nftID).Scan(&owned, &staked, &listed); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotEnough
+			}
+			return err
+		}
+		if owned-staked-listed < qty {
+			return ErrNotEnough
+		}
+		var tier string
+		if err := tx.QueryRow(ctx, `SELECT rarity_tier FROM nfts WHERE nft_id=$1`, nftID).Scan(&tier); err != nil {
+			return err
+		}
+		mult := stakeTierMultiplier[strings.ToLower(tier)]
+		if mult <= 0 {
+			mult = 1
+		}
+		out.DailyReward = baseDaily * mult * qty
+
+		if _, err := tx.Exec(ctx, `UPDATE nft_owns SET staked_qty=staked_qty+$1 WHERE user_id=$2 AND nft_id=$3`, qty, userID, nftID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO nft_stakes (user_id, nft_id, qty, daily_reward, status, lock_until, accrued_until, created_at)
+VALUES ($1,$2,$3,$4,'active',$5,$6,$6)
+RETURNING stake_id
+`, userID, nftID, qty, out.DailyReward, out.LockUntil, now).Scan(&out.StakeID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_stake', $1, NULL, 0, $2::jsonb)`,
+			userID, toJSON(map[string]any{"stake_id": out.StakeID, "nft_id": nftID, "qty": qty, "daily_reward": out.DailyReward, "lock_until": out.LockUntil.Unix()}),
+		)
+		return err
+	})
+	if err != nil {
+		return NFTStake{}, err
+	}
+	return out, nil
+}
+
+// AccrueNFTStakes credits whole elapsed days to every active stake's accrued counter.
+// Coins move only on claim; this is safe to run repeatedly (scheduled job).
+func (d *DB) AccrueNFTStakes(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	// Accrual moves no coins and writes no ledger row, so the home screen's
+	// unclaimed rewards are refreshed through home_dirty.
+	var n int64
+	err := d.Pool.QueryRow(ctx, `
+WITH acc AS (
+  UPDATE nft_stakes
+  SET accrued = accrued + daily_reward * floor(extract(epoch FROM ($1 - accrued_until)) / 86400)::bigint,
+      accrued_until = accrued_until + make_interval(days => floor(extract(epoch FROM ($1 - accrued_until)) / 86400)::int)
+  WHERE status='active' AND accrued_until <= $1 - interval '1 day'
+  RETURNING user_id
+), dirty AS (
+  INSERT INTO home_dirty(user_id) SELECT DISTINCT user_id FROM acc ON CONFLICT DO NOTHING
+)
+SELECT COUNT(*) FROM acc
+`, now).Scan(&n)
+	return n, err
+}
+
+// ClaimNFTStakeRewards pays out claimable rewards from reserve.
+// stakeID=0 claims across all of the user's stakes. Returns the amount paid.
+func (d *DB) ClaimNFTStakeRewards(ctx context.Context, userID, stakeID int64) (int64, error) {
+	if userID <= 0 || stakeID < 0 {
+		return 0, errors.New("bad params")
+	}
+	var paid int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT stake_id, accrued - claimed
+FROM nft_stakes
+WHERE user_id=$1 AND ($2=0 OR stake_id=$2) AND accrued > claimed
+FOR UPDATE
+`, userID, stakeID)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		var amounts []int64
+		for rows.Next() {
+			var id, amt int64
+			if err := rows.Scan(&id, &amt); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			amounts = append(amounts, amt)
+			paid += amt
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if paid <= 0 {
+			return nil
+		}
+
+		var reserve, reserved int64
+		if err := tx.QueryRow(ctx, `SELECT reserve_supply, reserved_supply FROM system_state WHERE id=1 FOR UPDATE`).Scan(&reserve, &reserved); err != nil {
+			return err
+		}
+		if reserve-reserved < paid {
+			return ErrNotEnough
+		}
+		if err := chargeEmissionTx(ctx, tx, paid); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply-$1, updated_at=now() WHERE id=1`, paid); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, paid, userID); err != nil {
+			return err
+		}
+		for i, id := range ids {
+			if _, err := tx.Exec(ctx, `UPDATE nft_stakes SET claimed=claimed+$1 WHERE stake_id=$2`, amounts[i], id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_stake_reward', NULL, $1, $2, $3::jsonb)`,
+			userID, paid, toJSON(map[string]any{"stake_ids": ids}),
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return paid, nil
+}
+
+// UnstakeNFT releases a stake after its lockup. Accrued-but-unclaimed rewards stay claimable.
+func (d *DB) UnstakeNFT(ctx context.Context, userID, stakeID int64) error {
+	if userID <= 0 || stakeID <= 0 {
+		return errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var owner, nftID, qty int64
+		var status string
+		var lockUntil time.Time
+		if err := tx.QueryRow(ctx, `
+SELECT user_id, nft_id, qty, status, lock_until
+FROM nft_stakes
+WHERE stake_id=$1
+FOR UPDATE
+`, stakeID).Scan(&owner, &nftID, &qty, &status, &lockUntil); err != nil {
+			return err
+		}
+		if owner != userID {
+			return ErrForbidden
+		}
+		if status != "active" {
+			return nil
+		}
+		if now.Before(lockUntil) {
+			return ErrLocked
+		}
+		if _, err := tx.Exec(ctx, `
+UPDATE nft_stakes
+SET accrued = accrued + daily_reward * floor(extract(epoch FROM ($1 - accrued_until)) / 86400)::bigint,
+    accrued_until = $1,
+    status = 'unstaked',
+    closed_at = $1
+WHERE stake_id=$2
+`, now, stakeID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE nft_owns SET staked_qty=GREATEST(staked_qty-$1, 0) WHERE user_id=$2 AND nft_id=$3`, qty, userID, nftID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_unstake', $1, NULL, 0, $2::jsonb)`,
+			userID, toJSON(map[string]any{"stake_id": stakeID, "nft_id": nftID, "qty": qty}),
+		)
+		return err
+	})
+}
+
+func (d *DB) ListNFTStakesByUser(ctx context.Context, userID int64, limit int64) ([]NFTStake, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT stake_id, user_id, nft_id, qty, daily_reward, status, lock_until, accrued, claimed, accrued_until, created_at, closed_at
+FROM nft_stakes
+WHERE user_id=$1
+ORDER BY created_at DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NFTStake
+	for rows.Next() {
+		var s NFTStake
+		if err := rows.Scan(&s.StakeID, &s.UserID, &s.NFTID, &s.Qty, &s.DailyReward, &s.Status, &s.LockUntil, &s.Accrued, &s.Claimed, &s.AccruedUntil, &s.CreatedAt, &s.ClosedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
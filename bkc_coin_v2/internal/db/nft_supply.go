@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Sharded shop supply. A plain BuyNFT takes a copy with an UPDATE of the
+// nfts row and credits system_state, so every buyer of a popular NFT queues
+// on the same two row locks until the previous buyer commits. An NFT with
+// supply_shards > 0 keeps its remaining copies spread over that many
+// nft_supply_shards rows instead: a buyer locks one random shard that still
+// has copies (SKIP LOCKED, so buyers don't wait on each other) and parks
+// the price on it. The nft_supply_sync job writes the totals behind: it
+// mirrors the shard sum into nfts.supply_left for readers and moves the
+// parked proceeds into the reserve. See BenchmarkBuyNFT.
+
+const maxNFTSupplyShards = 64
+
+// splitSupply spreads total copies over n shards; the first shards take the
+// remainder.
+func splitSupply(total int64, n int) []int64 {
+	out := make([]int64, n)
+	for i := range out {
+		out[i] = total / int64(n)
+		if int64(i) < total%int64(n) {
+			out[i]++
+		}
+	}
+	return out
+}
+
+// nftSupplyTx returns the exact copies left of an NFT and its shard count.
+func nftSupplyTx(ctx context.Context, q rowQuerier, nftID int64) (left, shards int64, err error) {
+	err = q.QueryRow(ctx, `
+SELECT CASE WHEN n.supply_shards > 0
+            THEN (SELECT COALESCE(SUM(s.qty_left), 0) FROM nft_supply_shards s WHERE s.nft_id = n.nft_id)
+            ELSE n.supply_left END,
+       n.supply_shards
+FROM nfts n WHERE n.nft_id=$1
+`, nftID).Scan(&left, &shards)
+	return left, shards, err
+}
+
+// ShardNFTSupply spreads the NFT's remaining copies over shards rows; 0
+// folds them back into nfts.supply_left. Buyers racing a reshard may see
+// the NFT as sold out, so reshard before a sale opens.
+func (d *DB) ShardNFTSupply(ctx context.Context, nftID, shards int64) error {
+	if nftID <= 0 || shards < 0 || shards > maxNFTSupplyShards {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var cur int64
+		if err := tx.QueryRow(ctx, `SELECT supply_shards FROM nfts WHERE nft_id=$1 FOR UPDATE`, nftID).Scan(&cur); err != nil {
+			return err
+		}
+		if err := flushNFTSupplyTx(ctx, tx, nftID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM nft_supply_shards WHERE nft_id=$1`, nftID); err != nil {
+			return err
+		}
+		var left int64
+		if err := tx.QueryRow(ctx, `UPDATE nfts SET supply_shards=$2 WHERE nft_id=$1 RETURNING supply_left`, nftID, shards).Scan(&left); err != nil {
+			return err
+		}
+		if shards == 0 {
+			return nil
+		}
+		_, err := tx.Exec(ctx, `
+INSERT INTO nft_supply_shards (nft_id, shard, qty_left)
+SELECT $1, s.i - 1, s.qty FROM unnest($2::bigint[]) WITH ORDINALITY AS s(qty, i)
+`, nftID, splitSupply(left, int(shards)))
+		return err
+	})
+}
+
+// takeNFTSupplyTx takes qty copies of an NFT for a sale of total coins.
+// Unsharded, it decrements nfts.supply_left and credits the reserve.
+// Sharded, it takes from shards it can lock without waiting, and only when
+// those fall short waits for the rest; the proceeds stay on the shards
+// until the next sync. ErrNotEnough is returned before anything is written.
+func takeNFTSupplyTx(ctx context.Context, tx pgx.Tx, nftID, qty, total, shards int64) error {
+	if shards == 0 {
+		tag, err := tx.Exec(ctx, `UPDATE nfts SET supply_left = supply_left - $1 WHERE nft_id=$2 AND supply_left >= $1`, qty, nftID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotEnough
+		}
+		_, err = tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at=now() WHERE id=1`, total)
+		return err
+	}
+	type take struct{ shard, qty int64 }
+	var plan []take
+	need := qty
+	for _, lock := range []string{`FOR UPDATE SKIP LOCKED`, `FOR UPDATE`} {
+		order := `random()`
+		if lock == `FOR UPDATE` {
+			order = `shard` // waiting: lock in a fixed order
+		}
+		// Each shard has a copy, so qty rows are enough; LIMIT keeps the rest unlocked.
+		rows, err := tx.Query(ctx, `SELECT shard, qty_left FROM nft_supply_shards WHERE nft_id=$1 AND qty_left > 0 ORDER BY `+order+` LIMIT $2 `+lock, nftID, qty)
+		if err != nil {
+			return err
+		}
+		plan, need = plan[:0], qty
+		for rows.Next() && need > 0 {
+			var t take
+			var left int64
+			if err := rows.Scan(&t.shard, &left); err != nil {
+				rows.Close()
+				return err
+			}
+			t.qty = min(left, need)
+			need -= t.qty
+			plan = append(plan, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if need == 0 {
+			break
+		}
+	}
+	if need > 0 {
+		return ErrNotEnough
+	}
+	// Proceeds go with the copies; the last shard takes the rounding.
+	rest := total
+	for i, t := range plan {
+		share := total * t.qty / qty
+		if i == len(plan)-1 {
+			share = rest
+		}
+		rest -= share
+		if _, err := tx.Exec(ctx, `
+UPDATE nft_supply_shards SET qty_left = qty_left - $3, proceeds = proceeds + $4
+WHERE nft_id=$1 AND shard=$2
+`, nftID, t.shard, t.qty, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNFTSupplyTx adds copies to an NFT, spread over its shards if it has any.
+func addNFTSupplyTx(ctx context.Context, tx pgx.Tx, nftID, add, shards int64) error {
+	if shards == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+UPDATE nft_supply_shards s SET qty_left = s.qty_left + a.qty
+FROM unnest($2::bigint[]) WITH ORDINALITY AS a(qty, i)
+WHERE s.nft_id=$1 AND s.shard = a.i - 1
+`, nftID, splitSupply(add, int(shards)))
+	return err
+}
+
+// flushNFTSupplyTx mirrors the shard total into nfts.supply_left and moves
+// the shards' proceeds into the reserve.
+func flushNFTSupplyTx(ctx context.Context, tx pgx.Tx, nftID int64) error {
+	var left, proceeds, n int64
+	if err := tx.QueryRow(ctx, `
+SELECT COUNT(*), COALESCE(SUM(qty_left), 0), COALESCE(SUM(proceeds), 0)
+FROM (SELECT qty_left, proceeds FROM nft_supply_shards WHERE nft_id=$1 ORDER BY shard FOR UPDATE) s
+`, nftID).Scan(&n, &left, &proceeds); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE nft_supply_shards SET proceeds=0 WHERE nft_id=$1 AND proceeds <> 0`, nftID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE nfts SET supply_left=$2 WHERE nft_id=$1 AND supply_left <> $2`, nftID, left); err != nil {
+		return err
+	}
+	if proceeds > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at=now() WHERE id=1`, proceeds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncNFTSupply runs the write-behind of every sharded NFT (scheduled job).
+func (d *DB) SyncNFTSupply(ctx context.Context) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT nft_id FROM nfts WHERE supply_shards > 0 ORDER BY nft_id`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := d.WithTx(ctx, func(tx pgx.Tx) error { return flushNFTSupplyTx(ctx, tx, id) }); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), nil
+}
+
+// NFTSupplyStatus is the sharding state of an NFT's supply.
+type NFTSupplyStatus struct {
+	NFTID      int64     `json:"nft_id"`
+	Shards     int64     `json:"shards"`
+	SupplyLeft int64     `json:"supply_left"` // exact
+	Mirrored   int64     `json:"mirrored"`    // nfts.supply_left as readers see it
+	Pending    int64     `json:"pending"`     // proceeds not yet in the reserve
+	At         time.Time `json:"at"`
+}
+
+func (d *DB) GetNFTSupplyStatus(ctx context.Context, nftID int64) (NFTSupplyStatus, error) {
+	out := NFTSupplyStatus{NFTID: nftID, At: time.Now().UTC()}
+	err := d.Pool.QueryRow(ctx, `
+SELECT n.supply_shards, n.supply_left,
+       CASE WHEN n.supply_shards > 0 THEN COALESCE(SUM(s.qty_left), 0) ELSE n.supply_left END,
+       COALESCE(SUM(s.proceeds), 0)
+FROM nfts n
+LEFT JOIN nft_supply_shards s ON s.nft_id = n.nft_id
+WHERE n.nft_id=$1
+GROUP BY n.nft_id
+`, nftID).Scan(&out.Shards, &out.Mirrored, &out.SupplyLeft, &out.Pending)
+	return out, err
+}
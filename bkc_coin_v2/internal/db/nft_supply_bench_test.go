@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkBuyNFT compares BuyNFT on one popular NFT with the supply on the
+// nfts row (shards=0, every buyer waits on the row lock) and spread over
+// shards:
+//
+//	BKC_BENCH_DATABASE_URL=postgres://... go test ./internal/db -run '^$' -bench BuyNFT -cpu 32
+//
+// Each parallel goroutine buys as a different user, so only the NFT is
+// contended.
+func BenchmarkBuyNFT(b *testing.B) {
+	d := benchDB(b)
+	const buyers = 256
+	seedBenchUsers(b, d, buyers)
+	ctx := context.Background()
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=1000000000 WHERE user_id BETWEEN $1 AND $2`,
+		int64(benchUserBase), int64(benchUserBase+buyers-1)); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_owns WHERE user_id BETWEEN $1 AND $2`, int64(benchUserBase), int64(benchUserBase+buyers-1))
+	})
+
+	for _, shards := range []int64{0, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			nftID, err := d.CreateNFT(ctx, "bench", "https://example.invalid/bench.png", 1, 1_000_000_000)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() {
+				_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_owns WHERE nft_id=$1`, nftID)
+				_, _ = d.Pool.Exec(ctx, `DELETE FROM nft_supply_shards WHERE nft_id=$1`, nftID)
+				_, _ = d.Pool.Exec(ctx, `DELETE FROM nfts WHERE nft_id=$1`, nftID)
+			})
+			if err := d.ShardNFTSupply(ctx, nftID, shards); err != nil {
+				b.Fatal(err)
+			}
+
+			var next atomic.Int64
+			var mu sync.Mutex
+			lat := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				user := int64(benchUserBase) + next.Add(1)%buyers
+				var mine []time.Duration
+				for pb.Next() {
+					start := time.Now()
+					if err := d.BuyNFT(ctx, user, nftID); err != nil {
+						b.Error(err)
+						return
+					}
+					mine = append(mine, time.Since(start))
+				}
+				mu.Lock()
+				lat = append(lat, mine...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+
+			if len(lat) == 0 {
+				return
+			}
+			sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+			b.ReportMetric(float64(lat[int(float64(len(lat)-1)*0.99)].Microseconds())/1000, "p99-ms")
+			b.ReportMetric(float64(len(lat))/b.Elapsed().Seconds(), "buys/s")
+		})
+	}
+}
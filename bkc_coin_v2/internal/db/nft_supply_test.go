@@ -0,0 +1,32 @@
+package db
+
+import "testing"
+
+func TestSplitSupply(t *testing.T) {
+	cases := []struct {
+		total int64
+		n     int
+		want  []int64
+	}{
+		{10, 1, []int64{10}},
+		{10, 4, []int64{3, 3, 2, 2}},
+		{2, 4, []int64{1, 1, 0, 0}},
+		{0, 3, []int64{0, 0, 0}},
+	}
+	for _, c := range cases {
+		got := splitSupply(c.total, c.n)
+		if len(got) != len(c.want) {
+			t.Fatalf("splitSupply(%d, %d) = %v, want %v", c.total, c.n, got, c.want)
+		}
+		var sum int64
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("splitSupply(%d, %d) = %v, want %v", c.total, c.n, got, c.want)
+			}
+			sum += got[i]
+		}
+		if sum != c.total {
+			t.Fatalf("splitSupply(%d, %d) sums to %d", c.total, c.n, sum)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Wishes mark shop NFTs a user wants. Wish counts are the demand signal
+// admins see next to supply; wishers are told when a sold out NFT is
+// restocked and when a new NFT drops in the collection of one they wished
+// for. Each wish remembers the supply it last saw and the newest NFT id of
+// its collection, so the nft_wishes job reports every change once.
+
+const maxWishesPerUser = 100
+
+// NFTWish is a shop NFT on a user's wishlist.
+type NFTWish struct {
+	NFTID      int64     `json:"nft_id"`
+	Title      string    `json:"title"`
+	ImageURL   string    `json:"image_url"`
+	PriceCoins int64     `json:"price_coins"`
+	SupplyLeft int64     `json:"supply_left"`
+	Collection string    `json:"collection"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// NFTDemand is the demand for one shop NFT.
+type NFTDemand struct {
+	NFTID       int64   `json:"nft_id"`
+	Title       string  `json:"title"`
+	Collection  string  `json:"collection"`
+	PriceCoins  int64   `json:"price_coins"`
+	SupplyTotal int64   `json:"supply_total"`
+	SupplyLeft  int64   `json:"supply_left"`
+	Sold        int64   `json:"sold"`
+	Wishes      int64   `json:"wishes"`
+	NewWishes   int64   `json:"new_wishes"` // since the report's since
+	WishRatio   float64 `json:"wish_ratio"` // wishes per copy left; wishes when sold out
+}
+
+// wishRatio is wishes per copy left; a sold out NFT counts as one copy so
+// the ratio still ranks by wishes.
+func wishRatio(wishes, left int64) float64 {
+	return float64(wishes) / float64(max(left, 1))
+}
+
+// AddNFTWish puts a shop NFT on the user's wishlist.
+func (d *DB) AddNFTWish(ctx context.Context, userID, nftID int64) error {
+	if userID <= 0 || nftID <= 0 {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var n int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM nft_wishes WHERE user_id=$1`, userID).Scan(&n); err != nil {
+			return err
+		}
+		if n >= maxWishesPerUser {
+			return errors.New("bad params: wishlist is full")
+		}
+		tag, err := tx.Exec(ctx, `
+INSERT INTO nft_wishes (user_id, nft_id, last_supply_left, seen_nft_id)
+SELECT $1, n.nft_id, n.supply_left, (SELECT MAX(c.nft_id) FROM nfts c WHERE c.collection = n.collection)
+FROM nfts n WHERE n.nft_id=$2
+ON CONFLICT (user_id, nft_id) DO NOTHING
+`, userID, nftID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM nfts WHERE nft_id=$1)`, nftID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return pgx.ErrNoRows
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) RemoveNFTWish(ctx context.Context, userID, nftID int64) error {
+	_, err := d.Pool.Exec(ctx, `DELETE FROM nft_wishes WHERE user_id=$1 AND nft_id=$2`, userID, nftID)
+	return err
+}
+
+func (d *DB) ListNFTWishes(ctx context.Context, userID int64) ([]NFTWish, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT n.nft_id, n.title, n.image_url, n.price_coins, n.supply_left, n.collection, w.created_at
+FROM nft_wishes w
+JOIN nfts n ON n.nft_id = w.nft_id
+WHERE w.user_id=$1
+ORDER BY w.created_at DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTWish{}
+	for rows.Next() {
+		var w NFTWish
+		if err := rows.Scan(&w.NFTID, &w.Title, &w.ImageURL, &w.PriceCoins, &w.SupplyLeft, &w.Collection, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// NFTDemandReport ranks wished-for shop NFTs by wishes per copy left.
+func (d *DB) NFTDemandReport(ctx context.Context, since time.Time, limit int64) ([]NFTDemand, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT n.nft_id, n.title, n.collection, n.price_coins, n.supply_total, n.supply_left,
+       COUNT(*), COUNT(*) FILTER (WHERE w.created_at >= $1)
+FROM nft_wishes w
+JOIN nfts n ON n.nft_id = w.nft_id
+GROUP BY n.nft_id
+ORDER BY COUNT(*)::float8 / GREATEST(n.supply_left, 1) DESC, n.nft_id
+LIMIT $2
+`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []NFTDemand{}
+	for rows.Next() {
+		var r NFTDemand
+		if err := rows.Scan(&r.NFTID, &r.Title, &r.Collection, &r.PriceCoins, &r.SupplyTotal, &r.SupplyLeft, &r.Wishes, &r.NewWishes); err != nil {
+			return nil, err
+		}
+		r.Sold = r.SupplyTotal - r.SupplyLeft
+		r.WishRatio = wishRatio(r.Wishes, r.SupplyLeft)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// RestockNFT adds copies to a shop NFT's supply. Wishers hear about it from
+// the next NotifyNFTWishes run.
+func (d *DB) RestockNFT(ctx context.Context, adminID, nftID, add int64) (int64, error) {
+	if nftID <= 0 || add <= 0 {
+		return 0, errors.New("bad params")
+	}
+	var left int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var shards int64
+		if err := tx.QueryRow(ctx, `
+UPDATE nfts SET supply_total=supply_total+$1, supply_left=supply_left+$1
+WHERE nft_id=$2
+RETURNING supply_left, supply_shards
+`, add, nftID).Scan(&left, &shards); err != nil {
+			return err
+		}
+		if err := addNFTSupplyTx(ctx, tx, nftID, add, shards); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('nft_restock', $1, NULL, 0, $2::jsonb)`,
+			adminID, toJSON(map[string]any{"nft_id": nftID, "added": add, "supply_left": left}))
+		return err
+	})
+	return left, err
+}
+
+// NotifyNFTWishes emits "nft_restock" when a wished NFT that was sold out
+// has supply again and "nft_new_drop" for new in-stock NFTs in the
+// collection of a wished one, then remembers what it saw (scheduled job).
+func (d *DB) NotifyNFTWishes(ctx context.Context) (int64, error) {
+	var sent int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+WITH cur AS (
+  SELECT w.user_id, w.nft_id, w.last_supply_left AS old_left, n.supply_left, n.title
+  FROM nft_wishes w
+  JOIN nfts n ON n.nft_id = w.nft_id
+  WHERE n.supply_left <> w.last_supply_left
+)
+UPDATE nft_wishes w
+SET last_supply_left = cur.supply_left
+FROM cur
+WHERE w.user_id=cur.user_id AND w.nft_id=cur.nft_id
+RETURNING w.user_id, w.nft_id, cur.title, cur.old_left = 0 AND cur.supply_left > 0
+`)
+		if err != nil {
+			return err
+		}
+		type restock struct {
+			userID, nftID int64
+			title         string
+		}
+		var restocks []restock
+		for rows.Next() {
+			var r restock
+			var back bool
+			if err := rows.Scan(&r.userID, &r.nftID, &r.title, &back); err != nil {
+				rows.Close()
+				return err
+			}
+			if back {
+				restocks = append(restocks, r)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, r := range restocks {
+			if err := addUserEventTx(ctx, tx, r.userID, "nft_restock", map[string]any{"nft_id": r.nftID, "title": r.title}); err != nil {
+				return err
+			}
+			sent++
+		}
+
+		// One drop event per user and new NFT, however many wishes share the collection.
+		rows, err = tx.Query(ctx, `
+SELECT DISTINCT w.user_id, c.nft_id, c.title, c.collection
+FROM nft_wishes w
+JOIN nfts n ON n.nft_id = w.nft_id
+JOIN nfts c ON c.collection = n.collection AND c.nft_id > w.seen_nft_id
+WHERE c.supply_left > 0
+ORDER BY w.user_id, c.nft_id
+`)
+		if err != nil {
+			return err
+		}
+		type drop struct {
+			userID, nftID     int64
+			title, collection string
+		}
+		var drops []drop
+		for rows.Next() {
+			var dr drop
+			if err := rows.Scan(&dr.userID, &dr.nftID, &dr.title, &dr.collection); err != nil {
+				rows.Close()
+				return err
+			}
+			drops = append(drops, dr)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, dr := range drops {
+			if err := addUserEventTx(ctx, tx, dr.userID, "nft_new_drop", map[string]any{"nft_id": dr.nftID, "title": dr.title, "collection": dr.collection}); err != nil {
+				return err
+			}
+			sent++
+		}
+		_, err = tx.Exec(ctx, `
+UPDATE nft_wishes w
+SET seen_nft_id = m.max_id
+FROM nfts n, LATERAL (SELECT MAX(c.nft_id) AS max_id FROM nfts c WHERE c.collection = n.collection) m
+WHERE n.nft_id = w.nft_id AND m.max_id > w.seen_nft_id
+`)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sent, nil
+}
@@ -0,0 +1,20 @@
+package db
+
+import "testing"
+
+func TestWishRatio(t *testing.T) {
+	cases := []struct {
+		wishes, left int64
+		want         float64
+	}{
+		{10, 5, 2},
+		{10, 0, 10}, // sold out counts as one copy
+		{0, 5, 0},
+		{3, 12, 0.25},
+	}
+	for _, c := range cases {
+		if got := wishRatio(c.wishes, c.left); got != c.want {
+			t.Fatalf("%d wishes, %d left: got %v, want %v", c.wishes, c.left, got, c.want)
+		}
+	}
+}
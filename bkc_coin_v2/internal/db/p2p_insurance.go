@@ -0,0 +1,350 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Loan insurance is optional and bought by the borrower when the loan
+// starts: the premium goes to the loan_insurance_pool system account. If the
+// borrower defaults, the lender first gets the collateral and the pool then
+// pays CoverageBP of the principal still uncovered, as far as its balance
+// goes. The premium rate depends on the borrower's credit score, built from
+// how they repaid earlier P2P loans. Only a borrower with MinRepaid loans
+// repaid on time can insure, and payouts are capped per borrower and per
+// lender and borrower pair, so a lender and borrower in collusion can't
+// drain the premiums of everyone else through staged defaults.
+
+// AccountLoanInsurance is the system account holding the insurance pool.
+const AccountLoanInsurance = "loan_insurance_pool"
+
+// insureWindow is how long after the loan starts insurance can be bought,
+// so nobody insures a loan they already know will default.
+const insureWindow = 24 * time.Hour
+
+// InsurancePolicy prices and sizes loan insurance.
+type InsurancePolicy struct {
+	PremiumBP  int64 // of the principal, for a borrower with a good score
+	CoverageBP int64 // of the uncollateralized principal paid on default
+	GraceDays  int64 // after the due date before the lender may declare a default
+
+	MinRepaid   int64 // loans repaid on time before the borrower can insure
+	BorrowerCap int64 // most the pool pays over one borrower's defaults; 0 = no cap
+	PairCap     int64 // most it pays one lender over one borrower's defaults; 0 = no cap
+}
+
+// CreditHistory is how a borrower handled earlier P2P loans.
+type CreditHistory struct {
+	Repaid    int64 `json:"repaid"`
+	Late      int64 `json:"late"` // repaid after the due date
+	Defaulted int64 `json:"defaulted"`
+	Overdue   int64 `json:"overdue"` // active and past due now
+}
+
+// CreditScore is a borrower's score, 0..100; 50 without history.
+type CreditScore struct {
+	Score   int64         `json:"score"`
+	History CreditHistory `json:"history"`
+}
+
+// creditScore scores a history: repaying on time earns points up to 100,
+// late repayments, defaults and loans overdue now cost them.
+func creditScore(h CreditHistory) int64 {
+	s := 50 + min(5*(h.Repaid-h.Late), 50) - 10*h.Late - 40*h.Defaulted - 20*h.Overdue
+	return max(0, min(s, 100))
+}
+
+// insurancePremium is the premium on principal for a score: the base rate
+// from 80 up, then 1.5, 2 and 3 times it.
+func insurancePremium(principal, baseBP, score int64) int64 {
+	mult := int64(300) // percent of the base rate
+	switch {
+	case score >= 80:
+		mult = 100
+	case score >= 50:
+		mult = 150
+	case score >= 20:
+		mult = 200
+	}
+	return principal * baseBP * mult / 1_000_000
+}
+
+// insurancePayout splits a default: the collateral goes to the lender, the
+// pool covers coverageBP of the principal left, capped by the pool balance.
+func insurancePayout(principal, collateral, coverageBP, pool int64) int64 {
+	if coverageBP <= 0 {
+		return 0
+	}
+	return max(0, min(max(principal-collateral, 0)*coverageBP/10_000, pool))
+}
+
+// capPayout cuts payout to what is left of limit after paid; 0 is no limit.
+func capPayout(payout, limit, paid int64) int64 {
+	if limit <= 0 {
+		return payout
+	}
+	return max(0, min(payout, limit-paid))
+}
+
+// BorrowerCreditScore scores the borrower's P2P history.
+func (d *DB) BorrowerCreditScore(ctx context.Context, borrowerID int64) (CreditScore, error) {
+	return borrowerCreditScore(ctx, d.Pool, borrowerID, d.now())
+}
+
+func borrowerCreditScore(ctx context.Context, q rowQuerier, borrowerID int64, now time.Time) (CreditScore, error) {
+	var h CreditHistory
+	if err := q.QueryRow(ctx, `
+SELECT
+  COUNT(*) FILTER (WHERE status='repaid'),
+  COUNT(*) FILTER (WHERE status='repaid' AND closed_at > due_at),
+  COUNT(*) FILTER (WHERE status='defaulted'),
+  COUNT(*) FILTER (WHERE status='active' AND due_at <= $2)
+FROM p2p_loans
+WHERE borrower_id=$1
+`, borrowerID, now).Scan(&h.Repaid, &h.Late, &h.Defaulted, &h.Overdue); err != nil {
+		return CreditScore{}, err
+	}
+	return CreditScore{Score: creditScore(h), History: h}, nil
+}
+
+// InsuranceQuote is what insuring a loan would cost and cover.
+type InsuranceQuote struct {
+	LoanID     int64       `json:"loan_id"`
+	Credit     CreditScore `json:"credit"`
+	Premium    int64       `json:"premium"`
+	CoverageBP int64       `json:"coverage_bp"`
+	Covered    int64       `json:"covered"` // the most the pool pays on default
+}
+
+func quoteInsurance(l P2PLoan, cs CreditScore, p InsurancePolicy) InsuranceQuote {
+	return InsuranceQuote{
+		LoanID: l.LoanID, Credit: cs, CoverageBP: p.CoverageBP,
+		Premium: insurancePremium(l.Principal, p.PremiumBP, cs.Score),
+		Covered: max(l.Principal-l.Collateral, 0) * p.CoverageBP / 10_000,
+	}
+}
+
+// lockInsurableLoanTx locks the borrower's active loan and checks it can
+// still be insured and the borrower may insure it.
+func lockInsurableLoanTx(ctx context.Context, tx pgx.Tx, borrowerID, loanID int64, now time.Time, p InsurancePolicy) (P2PLoan, CreditScore, error) {
+	var l P2PLoan
+	if err := tx.QueryRow(ctx, `
+SELECT loan_id, lender_id, borrower_id, principal, collateral, premium, status, accepted_at, due_at
+FROM p2p_loans WHERE loan_id=$1 FOR UPDATE
+`, loanID).Scan(&l.LoanID, &l.LenderID, &l.BorrowerID, &l.Principal, &l.Collateral, &l.Premium, &l.Status, &l.AcceptedAt, &l.DueAt); err != nil {
+		return P2PLoan{}, CreditScore{}, err
+	}
+	switch {
+	case l.BorrowerID != borrowerID:
+		return P2PLoan{}, CreditScore{}, ErrForbidden
+	case l.Status != "active" || l.AcceptedAt == nil:
+		return P2PLoan{}, CreditScore{}, errors.New("bad loan: not active")
+	case l.Premium > 0:
+		return P2PLoan{}, CreditScore{}, ErrAlreadyExists
+	case now.Sub(*l.AcceptedAt) > insureWindow:
+		return P2PLoan{}, CreditScore{}, errors.New("bad loan: insurance is bought within a day of the loan start")
+	}
+	cs, err := borrowerCreditScore(ctx, tx, borrowerID, now)
+	if err != nil {
+		return P2PLoan{}, CreditScore{}, err
+	}
+	if cs.History.Repaid-cs.History.Late < p.MinRepaid {
+		return P2PLoan{}, CreditScore{}, errors.New("bad borrower: not enough loans repaid on time to insure")
+	}
+	return l, cs, nil
+}
+
+// QuoteLoanInsurance prices insurance of the borrower's loan.
+func (d *DB) QuoteLoanInsurance(ctx context.Context, borrowerID, loanID int64, p InsurancePolicy) (InsuranceQuote, error) {
+	now := d.now()
+	var out InsuranceQuote
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		l, cs, err := lockInsurableLoanTx(ctx, tx, borrowerID, loanID, now, p)
+		if err != nil {
+			return err
+		}
+		out = quoteInsurance(l, cs, p)
+		return nil
+	})
+	return out, err
+}
+
+// InsureP2PLoan charges the borrower the premium into the pool and covers
+// the loan. maxPremium guards against a price change since the quote.
+func (d *DB) InsureP2PLoan(ctx context.Context, borrowerID, loanID, maxPremium int64, p InsurancePolicy) (InsuranceQuote, error) {
+	if p.PremiumBP <= 0 || p.CoverageBP <= 0 {
+		return InsuranceQuote{}, errors.New("bad insurance: disabled")
+	}
+	now := d.now()
+	var out InsuranceQuote
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		l, cs, err := lockInsurableLoanTx(ctx, tx, borrowerID, loanID, now, p)
+		if err != nil {
+			return err
+		}
+		out = quoteInsurance(l, cs, p)
+		if out.Premium <= 0 {
+			return errors.New("bad loan: too small to insure")
+		}
+		if maxPremium > 0 && out.Premium > maxPremium {
+			return errors.New("bad premium: the price went up, quote again")
+		}
+		if err := debitSpendableTx(ctx, tx, borrowerID, out.Premium); err != nil {
+			return err
+		}
+		if _, err := creditSystemAccountTx(ctx, tx, AccountLoanInsurance, out.Premium); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE p2p_loans SET premium=$2, coverage_bp=$3 WHERE loan_id=$1`, loanID, out.Premium, out.CoverageBP); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_insurance_premium', $1, NULL, $2, $3::jsonb)`,
+			borrowerID, out.Premium, toJSON(map[string]any{"loan_id": loanID, "score": cs.Score, "coverage_bp": out.CoverageBP}))
+		return err
+	})
+	return out, err
+}
+
+// LoanDefault is the settlement of a defaulted loan.
+type LoanDefault struct {
+	LoanID        int64 `json:"loan_id"`
+	Collateral    int64 `json:"collateral"`     // seized for the lender, at most the amount due
+	Released      int64 `json:"released"`       // collateral above the amount due, back to the borrower
+	InsurancePaid int64 `json:"insurance_paid"` // from the pool
+	Unrecovered   int64 `json:"unrecovered"`    // of the amount due
+}
+
+// DefaultP2PLoan lets the lender close an active loan the borrower left
+// unpaid GraceDays past due: the lender takes the collateral up to the
+// amount due and, on an insured loan, the pool's payout. The loan ends 'defaulted' and counts
+// against the borrower's credit score.
+func (d *DB) DefaultP2PLoan(ctx context.Context, lenderID, loanID int64, p InsurancePolicy) (LoanDefault, error) {
+	if lenderID <= 0 || loanID <= 0 {
+		return LoanDefault{}, errors.New("bad params")
+	}
+	now := d.now()
+	out := LoanDefault{LoanID: loanID}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var l P2PLoan
+		if err := tx.QueryRow(ctx, `
+SELECT lender_id, borrower_id, principal, total_due, collateral, coverage_bp, status, due_at
+FROM p2p_loans WHERE loan_id=$1 FOR UPDATE
+`, loanID).Scan(&l.LenderID, &l.BorrowerID, &l.Principal, &l.TotalDue, &l.Collateral, &l.CoverageBP, &l.Status, &l.DueAt); err != nil {
+			return err
+		}
+		if l.LenderID != lenderID {
+			return ErrForbidden
+		}
+		if l.Status != "active" || l.DueAt == nil {
+			return errors.New("bad loan: not active")
+		}
+		if now.Before(l.DueAt.Add(time.Duration(p.GraceDays) * 24 * time.Hour)) {
+			return errors.New("bad loan: still in the grace period")
+		}
+		if err := lockUsersTx(ctx, tx, l.BorrowerID, lenderID); err != nil {
+			return err
+		}
+		// The lender takes no more collateral than the debt; any excess goes
+		// back to the borrower as on a repayment.
+		if seized := min(l.Collateral, l.TotalDue); seized > 0 {
+			if _, err := tx.Exec(ctx, `UPDATE users SET frozen_balance=frozen_balance-$1 WHERE user_id=$2`, seized, l.BorrowerID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, seized, lenderID); err != nil {
+				return err
+			}
+			// The coins left the borrower's balance at p2p_collateral_lock.
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_collateral_seize', NULL, $1, $2, $3::jsonb)`,
+				lenderID, seized, toJSON(map[string]any{"loan_id": loanID, "borrower_id": l.BorrowerID})); err != nil {
+				return err
+			}
+			out.Collateral = seized
+		}
+		if err := releaseLoanCollateralTx(ctx, tx, loanID, l.BorrowerID, l.Collateral-out.Collateral); err != nil {
+			return err
+		}
+		out.Released = l.Collateral - out.Collateral
+		if l.CoverageBP > 0 {
+			var pool int64
+			if err := tx.QueryRow(ctx, `SELECT balance FROM system_accounts WHERE account=$1 FOR UPDATE`, AccountLoanInsurance).Scan(&pool); err != nil {
+				return err
+			}
+			var byBorrower, byPair int64
+			if err := tx.QueryRow(ctx, `
+SELECT COALESCE(SUM(insurance_paid), 0), COALESCE(SUM(insurance_paid) FILTER (WHERE lender_id=$2), 0)
+FROM p2p_loans WHERE borrower_id=$1 AND status='defaulted'
+`, l.BorrowerID, lenderID).Scan(&byBorrower, &byPair); err != nil {
+				return err
+			}
+			out.InsurancePaid = insurancePayout(l.Principal, out.Collateral, l.CoverageBP, pool)
+			out.InsurancePaid = capPayout(out.InsurancePaid, p.BorrowerCap, byBorrower)
+			out.InsurancePaid = capPayout(out.InsurancePaid, p.PairCap, byPair)
+		}
+		if out.InsurancePaid > 0 {
+			if _, err := creditSystemAccountTx(ctx, tx, AccountLoanInsurance, -out.InsurancePaid); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, out.InsurancePaid, lenderID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_insurance_payout', NULL, $1, $2, $3::jsonb)`,
+				lenderID, out.InsurancePaid, toJSON(map[string]any{"loan_id": loanID})); err != nil {
+				return err
+			}
+		}
+		out.Unrecovered = l.TotalDue - out.Collateral - out.InsurancePaid
+		if _, err := tx.Exec(ctx, `UPDATE p2p_loans SET status='defaulted', closed_at=$2, insurance_paid=$3 WHERE loan_id=$1`, loanID, now, out.InsurancePaid); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, l.BorrowerID, "p2p_loan_defaulted", map[string]any{"loan_id": loanID, "lender_id": lenderID, "collateral": out.Collateral, "released": out.Released, "unrecovered": out.Unrecovered})
+	})
+	if err != nil {
+		return LoanDefault{}, err
+	}
+	return out, nil
+}
+
+// InsurancePoolStats is the public state of the pool.
+type InsurancePoolStats struct {
+	Balance       int64 `json:"balance"`
+	Premiums      int64 `json:"premiums"` // collected, all time
+	Payouts       int64 `json:"payouts"`  // paid to lenders, all time
+	InsuredActive int64 `json:"insured_active"`
+	// Exposure is what the pool would pay if every insured active loan
+	// defaulted; SolvencyBP is Balance per Exposure (10000 = fully covered).
+	Exposure   int64 `json:"exposure"`
+	SolvencyBP int64 `json:"solvency_bp"`
+	Defaults   int64 `json:"defaults"` // insured loans defaulted, all time
+}
+
+// LoanInsuranceStats returns the pool balance, flows and solvency.
+func (d *DB) LoanInsuranceStats(ctx context.Context) (InsurancePoolStats, error) {
+	var s InsurancePoolStats
+	if err := d.Pool.QueryRow(ctx, `
+SELECT
+  (SELECT balance FROM system_accounts WHERE account=$1),
+  (SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE kind='p2p_insurance_premium'),
+  (SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE kind='p2p_insurance_payout'),
+  COUNT(*) FILTER (WHERE status='active'),
+  COALESCE(SUM(GREATEST(principal - collateral, 0) * coverage_bp / 10000) FILTER (WHERE status='active'), 0)::bigint,
+  COUNT(*) FILTER (WHERE status='defaulted')
+FROM p2p_loans
+WHERE premium > 0
+`, AccountLoanInsurance).Scan(&s.Balance, &s.Premiums, &s.Payouts, &s.InsuredActive, &s.Exposure, &s.Defaults); err != nil {
+		return InsurancePoolStats{}, err
+	}
+	s.SolvencyBP = solvencyBP(s.Balance, s.Exposure)
+	return s, nil
+}
+
+// solvencyBP is balance per exposure in basis points; nothing insured is
+// fully covered.
+func solvencyBP(balance, exposure int64) int64 {
+	if exposure <= 0 {
+		return 10_000
+	}
+	return balance * 10_000 / exposure
+}
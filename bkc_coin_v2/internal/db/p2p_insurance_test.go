@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+func TestCreditScore(t *testing.T) {
+	for _, c := range []struct {
+		h    CreditHistory
+		want int64
+	}{
+		{CreditHistory{}, 50},
+		{CreditHistory{Repaid: 4}, 70},
+		{CreditHistory{Repaid: 30}, 100},
+		{CreditHistory{Repaid: 4, Late: 2}, 40},
+		{CreditHistory{Repaid: 10, Defaulted: 1}, 60},
+		{CreditHistory{Overdue: 2, Defaulted: 1}, 0},
+	} {
+		if got := creditScore(c.h); got != c.want {
+			t.Errorf("%+v: score %d, want %d", c.h, got, c.want)
+		}
+	}
+}
+
+func TestInsurancePremium(t *testing.T) {
+	for _, c := range []struct {
+		score, want int64
+	}{
+		{100, 300}, {80, 300}, {79, 450}, {50, 450}, {49, 600}, {20, 600}, {19, 900}, {0, 900},
+	} {
+		if got := insurancePremium(10_000, 300, c.score); got != c.want {
+			t.Errorf("score %d: premium %d, want %d", c.score, got, c.want)
+		}
+	}
+	if got := insurancePayout(1_000, 400, 8_000, 10_000); got != 480 {
+		t.Fatalf("payout %d", got)
+	}
+	if got := insurancePayout(1_000, 400, 8_000, 100); got != 100 {
+		t.Fatalf("payout above the pool: %d", got)
+	}
+	if got := insurancePayout(1_000, 2_000, 8_000, 10_000); got != 0 {
+		t.Fatalf("payout of an overcollateralized loan: %d", got)
+	}
+	if capPayout(480, 0, 1_000) != 480 || capPayout(480, 1_000, 700) != 300 || capPayout(480, 1_000, 1_200) != 0 {
+		t.Fatal("payout cap")
+	}
+	if solvencyBP(50, 100) != 5_000 || solvencyBP(0, 0) != 10_000 {
+		t.Fatal("solvency")
+	}
+}
+
+func TestLoanInsuranceDefault(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const lender, borrower = 9_301_200_001, 9_301_200_002
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loans WHERE lender_id=$1`, lender)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loan_offers WHERE lender_id=$1`, lender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, lender, borrower)
+	t.Cleanup(cleanup)
+	p := InsurancePolicy{PremiumBP: 300, CoverageBP: 8_000, GraceDays: 3}
+
+	o, err := d.CreateP2PLoanOffer(ctx, P2PLoanOffer{LenderID: lender, MinAmount: 100, MaxAmount: 400, InterestBP: 1_000, TermDays: 7, CollateralBP: 5_000, MaxExposure: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loan, err := d.TakeP2PLoanOffer(ctx, borrower, o.OfferID, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InsureP2PLoan(ctx, lender, loan.LoanID, 0, p); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("lender insuring: %v", err)
+	}
+	strict := p
+	strict.MinRepaid = 1
+	if _, err := d.InsureP2PLoan(ctx, borrower, loan.LoanID, 0, strict); err == nil {
+		t.Fatal("insured without a repaid loan")
+	}
+	before, err := d.LoanInsuranceStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No history scores 50: 1.5 times the base rate, 80% of the 200 not
+	// covered by collateral.
+	q, err := d.InsureP2PLoan(ctx, borrower, loan.LoanID, 0, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Credit.Score != 50 || q.Premium != 18 || q.Covered != 160 {
+		t.Fatalf("quote: %+v", q)
+	}
+	if _, err := d.InsureP2PLoan(ctx, borrower, loan.LoanID, 0, p); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("insured twice: %v", err)
+	}
+
+	clk.Advance(8 * 24 * time.Hour)
+	if _, err := d.DefaultP2PLoan(ctx, lender, loan.LoanID, p); err == nil {
+		t.Fatal("default within the grace period")
+	}
+	clk.Advance(3 * 24 * time.Hour)
+	// The pair cap holds the payout under the 160 covered.
+	p.PairCap = 100
+	res, err := d.DefaultP2PLoan(ctx, lender, loan.LoanID, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Collateral != 200 || res.Unrecovered != 440-200-res.InsurancePaid {
+		t.Fatalf("default: %+v", res)
+	}
+	if before.Balance+18 >= 100 && res.InsurancePaid != 100 {
+		t.Fatalf("payout %d from a pool of %d", res.InsurancePaid, before.Balance+18)
+	}
+	if got := checkLedger(t, d, lender, 1_000); got != 1_000-400+200+res.InsurancePaid {
+		t.Fatalf("lender balance %d", got)
+	}
+	if got := checkLedger(t, d, borrower, 1_000); got != 1_000-200+400-18 {
+		t.Fatalf("borrower balance %d", got)
+	}
+	after, err := d.LoanInsuranceStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Balance != before.Balance+18-res.InsurancePaid || after.Defaults != before.Defaults+1 {
+		t.Fatalf("pool before %+v, after %+v", before, after)
+	}
+	cs, err := d.BorrowerCreditScore(ctx, borrower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.History.Defaulted != 1 || cs.Score != 10 {
+		t.Fatalf("score after default: %+v", cs)
+	}
+}
+
+func TestDefaultReleasesExcessCollateral(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const lender, borrower = 9_301_200_011, 9_301_200_012
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loans WHERE lender_id=$1`, lender)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loan_offers WHERE lender_id=$1`, lender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, lender, borrower)
+	t.Cleanup(cleanup)
+
+	// Collateral of twice the principal: 200 frozen against 110 due.
+	o, err := d.CreateP2PLoanOffer(ctx, P2PLoanOffer{LenderID: lender, MinAmount: 100, MaxAmount: 100, InterestBP: 1_000, TermDays: 7, CollateralBP: 20_000, MaxExposure: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loan, err := d.TakeP2PLoanOffer(ctx, borrower, o.OfferID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(8 * 24 * time.Hour)
+	res, err := d.DefaultP2PLoan(ctx, lender, loan.LoanID, InsurancePolicy{GraceDays: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Collateral != 110 || res.Released != 90 || res.Unrecovered != 0 || res.InsurancePaid != 0 {
+		t.Fatalf("default: %+v", res)
+	}
+	if got := checkLedger(t, d, lender, 1_000); got != 1_000-100+110 {
+		t.Fatalf("lender balance %d", got)
+	}
+	if got := checkLedger(t, d, borrower, 1_000); got != 1_000-200+100+90 {
+		t.Fatalf("borrower balance %d", got)
+	}
+	u, err := d.GetUser(ctx, borrower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.FrozenBalance != 0 {
+		t.Fatalf("borrower still has %d frozen", u.FrozenBalance)
+	}
+}
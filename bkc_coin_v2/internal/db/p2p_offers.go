@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A P2P loan offer is a lender's standing terms: any borrower may take an
+// amount between MinAmount and MaxAmount at the offer's rate and term, and
+// the loan is issued on the spot instead of waiting in 'requested' for the
+// lender. The lender caps the risk with MaxExposure (their active principal
+// on all P2P loans, this one included) and CollateralBP, the share of the
+// principal frozen from the borrower's own balance until the loan closes.
+
+// ErrLoanOfferClosed is taking an offer the lender has withdrawn.
+var ErrLoanOfferClosed = errors.New("loan offer closed")
+
+// ErrLenderExposure is taking more than the lender's exposure cap allows.
+var ErrLenderExposure = errors.New("lender exposure cap reached")
+
+const (
+	maxLoanOfferTermDays     = 365
+	maxLoanOfferInterestBP   = 10_000
+	maxLoanOfferCollateralBP = 20_000
+	maxLoanOffersPerLender   = 20
+)
+
+// P2PLoanOffer is a lender's standing offer.
+type P2PLoanOffer struct {
+	OfferID      int64     `json:"offer_id"`
+	LenderID     int64     `json:"lender_id"`
+	MinAmount    int64     `json:"min_amount"`
+	MaxAmount    int64     `json:"max_amount"`
+	InterestBP   int64     `json:"interest_bp"`
+	TermDays     int64     `json:"term_days"`
+	CollateralBP int64     `json:"collateral_bp"`
+	MaxExposure  int64     `json:"max_exposure"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (o *P2PLoanOffer) check() error {
+	switch {
+	case o.MinAmount <= 0 || o.MaxAmount < o.MinAmount:
+		return errors.New("bad amount range")
+	case o.InterestBP < 0 || o.InterestBP > maxLoanOfferInterestBP:
+		return errors.New("bad interest_bp")
+	case o.TermDays <= 0 || o.TermDays > maxLoanOfferTermDays:
+		return errors.New("bad term_days")
+	case o.CollateralBP < 0 || o.CollateralBP > maxLoanOfferCollateralBP:
+		return errors.New("bad collateral_bp")
+	case o.MaxExposure < o.MaxAmount:
+		return errors.New("bad max_exposure: below max_amount")
+	}
+	return nil
+}
+
+const loanOfferCols = `offer_id, lender_id, min_amount, max_amount, interest_bp, term_days, collateral_bp, max_exposure, active, created_at, updated_at`
+
+func scanLoanOffer(row pgx.Row) (P2PLoanOffer, error) {
+	var o P2PLoanOffer
+	err := row.Scan(&o.OfferID, &o.LenderID, &o.MinAmount, &o.MaxAmount, &o.InterestBP, &o.TermDays, &o.CollateralBP, &o.MaxExposure, &o.Active, &o.CreatedAt, &o.UpdatedAt)
+	return o, err
+}
+
+func scanLoanOffers(rows pgx.Rows) ([]P2PLoanOffer, error) {
+	defer rows.Close()
+	out := []P2PLoanOffer{}
+	for rows.Next() {
+		o, err := scanLoanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// CreateP2PLoanOffer publishes a standing offer of o.LenderID.
+func (d *DB) CreateP2PLoanOffer(ctx context.Context, o P2PLoanOffer) (P2PLoanOffer, error) {
+	if o.LenderID <= 0 {
+		return P2PLoanOffer{}, errors.New("bad params")
+	}
+	if err := o.check(); err != nil {
+		return P2PLoanOffer{}, err
+	}
+	var out P2PLoanOffer
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := lockUsersTx(ctx, tx, o.LenderID); err != nil {
+			return err
+		}
+		var n int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM p2p_loan_offers WHERE lender_id=$1 AND active`, o.LenderID).Scan(&n); err != nil {
+			return err
+		}
+		if n >= maxLoanOffersPerLender {
+			return errors.New("bad offer: too many active offers")
+		}
+		var err error
+		out, err = scanLoanOffer(tx.QueryRow(ctx, `
+INSERT INTO p2p_loan_offers(lender_id, min_amount, max_amount, interest_bp, term_days, collateral_bp, max_exposure)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+RETURNING `+loanOfferCols, o.LenderID, o.MinAmount, o.MaxAmount, o.InterestBP, o.TermDays, o.CollateralBP, o.MaxExposure))
+		return err
+	})
+	return out, err
+}
+
+// ListP2PLoanOffers returns active offers, cheapest first; amount > 0 keeps
+// those that lend that amount.
+func (d *DB) ListP2PLoanOffers(ctx context.Context, amount, limit int64) ([]P2PLoanOffer, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+loanOfferCols+` FROM p2p_loan_offers
+WHERE active AND ($1::bigint <= 0 OR $1 BETWEEN min_amount AND max_amount)
+ORDER BY interest_bp, offer_id
+LIMIT $2
+`, amount, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanLoanOffers(rows)
+}
+
+// ListLenderLoanOffers returns the lender's offers, newest first.
+func (d *DB) ListLenderLoanOffers(ctx context.Context, lenderID, limit int64) ([]P2PLoanOffer, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `SELECT `+loanOfferCols+` FROM p2p_loan_offers WHERE lender_id=$1 ORDER BY offer_id DESC LIMIT $2`, lenderID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanLoanOffers(rows)
+}
+
+// CloseP2PLoanOffer withdraws the lender's offer; loans already taken run on.
+func (d *DB) CloseP2PLoanOffer(ctx context.Context, lenderID, offerID int64) (P2PLoanOffer, error) {
+	return scanLoanOffer(d.Pool.QueryRow(ctx, `
+UPDATE p2p_loan_offers SET active=FALSE, updated_at=now()
+WHERE offer_id=$1 AND lender_id=$2
+RETURNING `+loanOfferCols, offerID, lenderID))
+}
+
+// TakeP2PLoanOffer lends amount to the borrower on the offer's terms: the
+// principal moves from the lender, the collateral is frozen from the
+// borrower's balance and the loan starts active.
+func (d *DB) TakeP2PLoanOffer(ctx context.Context, borrowerID, offerID, amount int64) (P2PLoan, error) {
+	if borrowerID <= 0 || offerID <= 0 || amount <= 0 {
+		return P2PLoan{}, errors.New("bad params")
+	}
+	now := d.now()
+	var out P2PLoan
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		o, err := scanLoanOffer(tx.QueryRow(ctx, `SELECT `+loanOfferCols+` FROM p2p_loan_offers WHERE offer_id=$1 FOR UPDATE`, offerID))
+		if err != nil {
+			return err
+		}
+		if !o.Active {
+			return ErrLoanOfferClosed
+		}
+		if o.LenderID == borrowerID {
+			return ErrForbidden
+		}
+		if amount < o.MinAmount || amount > o.MaxAmount {
+			return errors.New("bad amount: outside the offer range")
+		}
+		// The lender row lock also serializes takes of the lender's other offers,
+		// so the exposure sum below cannot be raced past the cap.
+		if err := lockUsersTx(ctx, tx, borrowerID, o.LenderID); err != nil {
+			return err
+		}
+		var exposure int64
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(SUM(principal), 0) FROM p2p_loans WHERE lender_id=$1 AND status='active'`, o.LenderID).Scan(&exposure); err != nil {
+			return err
+		}
+		if exposure+amount > o.MaxExposure {
+			return ErrLenderExposure
+		}
+		if err := debitSpendableTx(ctx, tx, o.LenderID, amount); err != nil {
+			return err
+		}
+
+		interest := interestFromBP(amount, o.InterestBP)
+		collateral := amount * o.CollateralBP / 10_000
+		dueAt := now.Add(time.Duration(o.TermDays) * 24 * time.Hour)
+		out = P2PLoan{
+			LenderID: o.LenderID, BorrowerID: borrowerID, Principal: amount, Interest: interest, TotalDue: amount + interest,
+			InterestBP: o.InterestBP, TermDays: o.TermDays, Status: "active", CreatedAt: now, AcceptedAt: &now, DueAt: &dueAt,
+			OfferID: &o.OfferID, Collateral: collateral,
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO p2p_loans (lender_id, borrower_id, principal, interest, total_due, interest_bp, term_days, status, created_at, accepted_at, due_at, offer_id, collateral)
+VALUES ($1,$2,$3,$4,$5,$6,$7,'active',$8,$8,$9,$10,$11)
+RETURNING loan_id
+`, out.LenderID, borrowerID, amount, interest, out.TotalDue, o.InterestBP, o.TermDays, now, dueAt, o.OfferID, collateral).Scan(&out.LoanID); err != nil {
+			return err
+		}
+
+		// The collateral comes from the borrower's own coins, not the principal.
+		if collateral > 0 {
+			if err := debitSpendableTx(ctx, tx, borrowerID, collateral); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET frozen_balance=frozen_balance+$1 WHERE user_id=$2`, collateral, borrowerID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_collateral_lock', $1, NULL, $2, $3::jsonb)`,
+				borrowerID, collateral, toJSON(map[string]any{"loan_id": out.LoanID, "offer_id": o.OfferID})); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, amount, borrowerID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_loan_issue', $1, $2, $3, $4::jsonb)`,
+			o.LenderID, borrowerID, amount, toJSON(map[string]any{"loan_id": out.LoanID, "offer_id": o.OfferID, "total_due": out.TotalDue, "interest": interest, "due_at": dueAt.Unix()}),
+		); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, o.LenderID, "p2p_loan_taken", map[string]any{"loan_id": out.LoanID, "offer_id": o.OfferID, "borrower_id": borrowerID, "principal": amount, "total_due": out.TotalDue, "due_at": dueAt})
+	})
+	if err != nil {
+		return P2PLoan{}, err
+	}
+	return out, nil
+}
+
+// releaseLoanCollateralTx returns a closing loan's collateral to the
+// borrower's balance; the borrower row must be locked.
+func releaseLoanCollateralTx(ctx context.Context, tx pgx.Tx, loanID, borrowerID, collateral int64) error {
+	if collateral <= 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1, frozen_balance=frozen_balance-$1 WHERE user_id=$2`, collateral, borrowerID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('p2p_collateral_release', NULL, $1, $2, $3::jsonb)`,
+		borrowerID, collateral, toJSON(map[string]any{"loan_id": loanID}))
+	return err
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestP2PLoanOfferCheck(t *testing.T) {
+	ok := P2PLoanOffer{MinAmount: 10, MaxAmount: 100, InterestBP: 500, TermDays: 7, CollateralBP: 5_000, MaxExposure: 1_000}
+	if err := ok.check(); err != nil {
+		t.Fatal(err)
+	}
+	for name, mut := range map[string]func(*P2PLoanOffer){
+		"range":      func(o *P2PLoanOffer) { o.MinAmount = 200 },
+		"zero":       func(o *P2PLoanOffer) { o.MinAmount = 0 },
+		"rate":       func(o *P2PLoanOffer) { o.InterestBP = 10_001 },
+		"term":       func(o *P2PLoanOffer) { o.TermDays = 0 },
+		"collateral": func(o *P2PLoanOffer) { o.CollateralBP = -1 },
+		"exposure":   func(o *P2PLoanOffer) { o.MaxExposure = 50 },
+	} {
+		o := ok
+		mut(&o)
+		if err := o.check(); err == nil {
+			t.Errorf("%s: accepted %+v", name, o)
+		}
+	}
+}
+
+func TestTakeP2PLoanOffer(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const lender, borrower, other = 9_301_000_001, 9_301_000_002, 9_301_000_003
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loans WHERE lender_id=$1`, lender)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM p2p_loan_offers WHERE lender_id=$1`, lender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, lender, borrower, other)
+	t.Cleanup(cleanup)
+
+	o, err := d.CreateP2PLoanOffer(ctx, P2PLoanOffer{LenderID: lender, MinAmount: 100, MaxAmount: 400, InterestBP: 1_000, TermDays: 7, CollateralBP: 5_000, MaxExposure: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.TakeP2PLoanOffer(ctx, lender, o.OfferID, 100); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("own offer: %v", err)
+	}
+	if _, err := d.TakeP2PLoanOffer(ctx, borrower, o.OfferID, 500); err == nil {
+		t.Fatal("amount above the offer accepted")
+	}
+
+	loan, err := d.TakeP2PLoanOffer(ctx, borrower, o.OfferID, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loan.Status != "active" || loan.TotalDue != 440 || loan.Collateral != 200 {
+		t.Fatalf("loan: %+v", loan)
+	}
+	u, err := d.GetUser(ctx, borrower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Balance != 1_000-200+400 || u.FrozenBalance != 200 {
+		t.Fatalf("borrower balance %d, frozen %d", u.Balance, u.FrozenBalance)
+	}
+	// 400 active + 200 > 500.
+	if _, err := d.TakeP2PLoanOffer(ctx, other, o.OfferID, 200); !errors.Is(err, ErrLenderExposure) {
+		t.Fatalf("over exposure: %v", err)
+	}
+
+	if err := d.RepayP2PLoan(ctx, borrower, loan.LoanID); err != nil {
+		t.Fatal(err)
+	}
+	if u, err = d.GetUser(ctx, borrower); err != nil || u.FrozenBalance != 0 || u.Balance != 1_000-40 {
+		t.Fatalf("after repay: %+v, %v", u, err)
+	}
+	if _, err := d.TakeP2PLoanOffer(ctx, other, o.OfferID, 200); err != nil {
+		t.Fatalf("after repay the exposure is free: %v", err)
+	}
+
+	if _, err := d.CloseP2PLoanOffer(ctx, lender, o.OfferID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.TakeP2PLoanOffer(ctx, borrower, o.OfferID, 100); !errors.Is(err, ErrLoanOfferClosed) {
+		t.Fatalf("closed offer: %v", err)
+	}
+	for _, id := range []int64{lender, borrower, other} {
+		checkLedger(t, d, id, 1_000)
+	}
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A lender's portfolio is computed from their p2p_loans on request: what is
+// lent out now, how past borrowers repaid and what the closed loans earned.
+
+// Portfolio warning kinds.
+const (
+	PortfolioConcentration = "concentration" // one borrower holds too much of the outstanding principal
+	PortfolioOverdue       = "overdue"       // active loans past their due date
+)
+
+// portfolioTopBorrowers is how many of the largest borrowers are listed.
+const portfolioTopBorrowers = 5
+
+// PortfolioBorrower is one borrower's share of the outstanding principal.
+type PortfolioBorrower struct {
+	BorrowerID  int64 `json:"borrower_id"`
+	Loans       int64 `json:"loans"`
+	Outstanding int64 `json:"outstanding"`
+	ShareBP     int64 `json:"share_bp"`
+}
+
+// PortfolioWarning flags a risk in the portfolio.
+type PortfolioWarning struct {
+	Kind       string `json:"kind"`
+	BorrowerID int64  `json:"borrower_id,omitempty"`
+	ShareBP    int64  `json:"share_bp"`
+	Message    string `json:"message"`
+}
+
+// LenderPortfolio sums up a lender's P2P loans.
+type LenderPortfolio struct {
+	LenderID int64 `json:"lender_id"`
+
+	ActiveLoans    int64 `json:"active_loans"`
+	Outstanding    int64 `json:"outstanding"`     // principal of active loans
+	OutstandingDue int64 `json:"outstanding_due"` // what their borrowers owe
+	WeightedRateBP int64 `json:"weighted_rate_bp"`
+	OverdueLoans   int64 `json:"overdue_loans"`
+	OverdueAmount  int64 `json:"overdue_amount"` // principal of the overdue ones
+
+	// Repaid loans (recalled ones included). A loan is on time when it closed
+	// by its due date; the overdue active ones count as late.
+	RepaidLoans      int64   `json:"repaid_loans"`
+	RepaidOnTime     int64   `json:"repaid_on_time"`
+	OnTimePct        float64 `json:"on_time_pct"`
+	RepaidPrincipal  int64   `json:"repaid_principal"`
+	RealizedInterest int64   `json:"realized_interest"`
+	RealizedYieldBP  int64   `json:"realized_yield_bp"` // interest earned per principal repaid
+
+	// Defaulted loans count as late; what came back of them is the seized
+	// collateral and the insurance payouts.
+	DefaultedLoans     int64 `json:"defaulted_loans"`
+	DefaultedPrincipal int64 `json:"defaulted_principal"`
+	CollateralSeized   int64 `json:"collateral_seized"`
+	InsuranceRecovered int64 `json:"insurance_recovered"`
+
+	TopBorrowers []PortfolioBorrower `json:"top_borrowers"`
+	Warnings     []PortfolioWarning  `json:"warnings"`
+	ComputedAt   time.Time           `json:"computed_at"`
+}
+
+// LenderPortfolio computes the lender's portfolio at now; warnBP is the
+// share of the outstanding principal one borrower may hold before it is
+// flagged as a concentration.
+func (d *DB) LenderPortfolio(ctx context.Context, lenderID, warnBP int64) (LenderPortfolio, error) {
+	now := d.now()
+	p := LenderPortfolio{LenderID: lenderID, TopBorrowers: []PortfolioBorrower{}, ComputedAt: now}
+	var weighted int64
+	if err := d.Pool.QueryRow(ctx, `
+SELECT
+  COUNT(*) FILTER (WHERE status='active'),
+  COALESCE(SUM(principal) FILTER (WHERE status='active'), 0),
+  COALESCE(SUM(total_due) FILTER (WHERE status='active'), 0),
+  COALESCE(SUM(principal * interest_bp) FILTER (WHERE status='active'), 0)::bigint,
+  COUNT(*) FILTER (WHERE status='active' AND due_at <= $2),
+  COALESCE(SUM(principal) FILTER (WHERE status='active' AND due_at <= $2), 0),
+  COUNT(*) FILTER (WHERE status='repaid'),
+  COUNT(*) FILTER (WHERE status='repaid' AND closed_at <= due_at),
+  COALESCE(SUM(principal) FILTER (WHERE status='repaid'), 0),
+  COALESCE(SUM(interest) FILTER (WHERE status='repaid'), 0),
+  COUNT(*) FILTER (WHERE status='defaulted'),
+  COALESCE(SUM(principal) FILTER (WHERE status='defaulted'), 0),
+  COALESCE(SUM(LEAST(collateral, total_due)) FILTER (WHERE status='defaulted'), 0),
+  COALESCE(SUM(insurance_paid) FILTER (WHERE status='defaulted'), 0)
+FROM p2p_loans
+WHERE lender_id=$1
+`, lenderID, now).Scan(&p.ActiveLoans, &p.Outstanding, &p.OutstandingDue, &weighted, &p.OverdueLoans, &p.OverdueAmount,
+		&p.RepaidLoans, &p.RepaidOnTime, &p.RepaidPrincipal, &p.RealizedInterest,
+		&p.DefaultedLoans, &p.DefaultedPrincipal, &p.CollateralSeized, &p.InsuranceRecovered); err != nil {
+		return LenderPortfolio{}, err
+	}
+	if p.Outstanding > 0 {
+		p.WeightedRateBP = weighted / p.Outstanding
+	}
+
+	rows, err := d.Pool.Query(ctx, `
+SELECT borrower_id, COUNT(*), SUM(principal)
+FROM p2p_loans
+WHERE lender_id=$1 AND status='active'
+GROUP BY borrower_id
+ORDER BY SUM(principal) DESC, borrower_id
+LIMIT $2
+`, lenderID, portfolioTopBorrowers)
+	if err != nil {
+		return LenderPortfolio{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b PortfolioBorrower
+		if err := rows.Scan(&b.BorrowerID, &b.Loans, &b.Outstanding); err != nil {
+			return LenderPortfolio{}, err
+		}
+		p.TopBorrowers = append(p.TopBorrowers, b)
+	}
+	if err := rows.Err(); err != nil {
+		return LenderPortfolio{}, err
+	}
+	p.finish(warnBP)
+	return p, nil
+}
+
+// finish derives the ratios and warnings from the sums.
+func (p *LenderPortfolio) finish(warnBP int64) {
+	if late := p.RepaidLoans - p.RepaidOnTime + p.OverdueLoans + p.DefaultedLoans; p.RepaidOnTime+late > 0 {
+		p.OnTimePct = float64(p.RepaidOnTime*1000/(p.RepaidOnTime+late)) / 10
+	}
+	if p.RepaidPrincipal > 0 {
+		p.RealizedYieldBP = p.RealizedInterest * 10_000 / p.RepaidPrincipal
+	}
+	p.Warnings = []PortfolioWarning{}
+	if p.Outstanding <= 0 {
+		return
+	}
+	for i, b := range p.TopBorrowers {
+		share := b.Outstanding * 10_000 / p.Outstanding
+		p.TopBorrowers[i].ShareBP = share
+		if warnBP > 0 && share > warnBP {
+			p.Warnings = append(p.Warnings, PortfolioWarning{
+				Kind: PortfolioConcentration, BorrowerID: b.BorrowerID, ShareBP: share,
+				Message: fmt.Sprintf("borrower %d holds %d.%02d%% of the outstanding principal", b.BorrowerID, share/100, share%100),
+			})
+		}
+	}
+	if p.OverdueLoans > 0 {
+		share := p.OverdueAmount * 10_000 / p.Outstanding
+		p.Warnings = append(p.Warnings, PortfolioWarning{
+			Kind: PortfolioOverdue, ShareBP: share,
+			Message: fmt.Sprintf("%d loans past due, %d.%02d%% of the outstanding principal", p.OverdueLoans, share/100, share%100),
+		})
+	}
+}
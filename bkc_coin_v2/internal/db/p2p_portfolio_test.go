@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+func TestLenderPortfolioFinish(t *testing.T) {
+	p := LenderPortfolio{
+		Outstanding:      1_000,
+		OverdueLoans:     1,
+		OverdueAmount:    100,
+		RepaidLoans:      4,
+		RepaidOnTime:     3,
+		DefaultedLoans:   1,
+		RepaidPrincipal:  2_000,
+		RealizedInterest: 150,
+		TopBorrowers:     []PortfolioBorrower{{BorrowerID: 1, Outstanding: 600}, {BorrowerID: 2, Outstanding: 300}, {BorrowerID: 3, Outstanding: 100}},
+	}
+	p.finish(2_500)
+	if p.OnTimePct != 50 {
+		t.Fatalf("on time %v%%", p.OnTimePct)
+	}
+	if p.RealizedYieldBP != 750 {
+		t.Fatalf("yield %d bp", p.RealizedYieldBP)
+	}
+	if p.TopBorrowers[0].ShareBP != 6_000 || p.TopBorrowers[1].ShareBP != 3_000 {
+		t.Fatalf("shares %+v", p.TopBorrowers)
+	}
+	var kinds []string
+	for _, w := range p.Warnings {
+		kinds = append(kinds, w.Kind)
+	}
+	if len(kinds) != 3 || kinds[0] != PortfolioConcentration || kinds[1] != PortfolioConcentration || kinds[2] != PortfolioOverdue {
+		t.Fatalf("warnings %+v", p.Warnings)
+	}
+
+	empty := LenderPortfolio{}
+	empty.finish(2_500)
+	if empty.OnTimePct != 0 || empty.RealizedYieldBP != 0 || len(empty.Warnings) != 0 {
+		t.Fatalf("empty portfolio: %+v", empty)
+	}
+}
+
+func TestLenderPortfolio(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const lender, a, b = 9_301_100_001, 9_301_100_002, 9_301_100_003
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM p2p_loans WHERE lender_id=$1`, lender)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 10_000, lender, a, b)
+	t.Cleanup(cleanup)
+
+	lend := func(borrower, amount, bp, days int64) P2PLoan {
+		t.Helper()
+		l, err := d.CreateP2PLoanRequest(ctx, borrower, lender, amount, bp, days)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.AcceptP2PLoan(ctx, lender, l.LoanID); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	repaid := lend(a, 1_000, 1_000, 7)
+	if err := d.RepayP2PLoan(ctx, a, repaid.LoanID); err != nil {
+		t.Fatal(err)
+	}
+	lend(a, 3_000, 1_000, 3)
+	lend(b, 1_000, 2_000, 30)
+	clk.Advance(4 * 24 * time.Hour) // a's second loan is past due
+
+	p, err := d.LenderPortfolio(ctx, lender, 5_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ActiveLoans != 2 || p.Outstanding != 4_000 || p.WeightedRateBP != 1_250 {
+		t.Fatalf("outstanding: %+v", p)
+	}
+	if p.RepaidLoans != 1 || p.RepaidOnTime != 1 || p.OverdueLoans != 1 || p.OnTimePct != 50 {
+		t.Fatalf("repayments: %+v", p)
+	}
+	if p.RealizedInterest != 100 || p.RealizedYieldBP != 1_000 {
+		t.Fatalf("yield: %+v", p)
+	}
+	if len(p.TopBorrowers) != 2 || p.TopBorrowers[0].BorrowerID != a || p.TopBorrowers[0].ShareBP != 7_500 {
+		t.Fatalf("borrowers: %+v", p.TopBorrowers)
+	}
+	if len(p.Warnings) != 2 {
+		t.Fatalf("warnings: %+v", p.Warnings)
+	}
+}
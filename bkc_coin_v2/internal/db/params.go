@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Params are the fees and limits admins tune at runtime. Each starts at its
+// config default (see the params package, which serves them cached); an admin
+// override is a row in params and takes effect on every instance within a
+// few seconds. Amounts are in BKC, rates in basis points.
+
+// Param keys.
+const (
+	ParamMarketListingFee    = "market.listing_fee"
+	ParamNFTMarketFeeBP      = "nft.market_fee_bp"
+	ParamNFTRentalFeeBP      = "nft.rental_fee_bp"
+	ParamNFTRentalMaxDays    = "nft.rental_max_days"
+	ParamNFTStakeDailyReward = "nft.stake_daily_reward"
+	ParamTipFeeFreeMax       = "tip.fee_free_max"
+	ParamTipFeeBP            = "tip.fee_bp"
+	ParamTipMaxAmount        = "tip.max_amount"
+	ParamGiftMinAmount       = "gift.min_amount"
+	ParamGiftMaxAmount       = "gift.max_amount"
+	ParamMerchantFeeBP       = "merchant.fee_bp"
+	ParamAffiliateMinPayout  = "affiliate.min_payout"
+	ParamSaleDailyLimit      = "sale.daily_limit"
+	ParamLevelUpReward       = "level.up_reward"
+	ParamBillMaxParticipants = "bill.max_participants"
+	ParamBillMaxDays         = "bill.max_days"
+	ParamGigMaxMilestones    = "gig.max_milestones"
+)
+
+// ParamDef is a tunable value and the range an override must stay in.
+type ParamDef struct {
+	Key         string `json:"key"`
+	Min         int64  `json:"min"`
+	Max         int64  `json:"max,omitempty"` // 0 = no upper bound
+	Description string `json:"description"`
+}
+
+// ParamDefs are every tunable value; the ranges match the config checks.
+var ParamDefs = []ParamDef{
+	{ParamMarketListingFee, 0, 0, "fee for a marketplace or gig listing"},
+	{ParamNFTMarketFeeBP, 0, 5_000, "platform fee on NFT sales, offers and bundles"},
+	{ParamNFTRentalFeeBP, 0, 5_000, "platform fee on NFT rentals"},
+	{ParamNFTRentalMaxDays, 1, 0, "longest NFT rental offer"},
+	{ParamNFTStakeDailyReward, 0, 0, "daily reward for one staked common NFT"},
+	{ParamTipFeeFreeMax, 0, 0, "tips up to this amount pay no fee"},
+	{ParamTipFeeBP, 0, 1_000, "fee on larger tips"},
+	{ParamTipMaxAmount, 1, 0, "largest tip"},
+	{ParamGiftMinAmount, 1, 0, "smallest gift"},
+	{ParamGiftMaxAmount, 1, 0, "largest gift"},
+	{ParamMerchantFeeBP, 0, 1_000, "fee of a new merchant"},
+	{ParamAffiliateMinPayout, 1, 0, "smallest affiliate commission withdrawal"},
+	{ParamSaleDailyLimit, 0, 0, "BKC a user may sell per UTC day, 0 = no limit"},
+	{ParamLevelUpReward, 0, 0, "level-up reward, times the level reached"},
+	{ParamBillMaxParticipants, 1, 0, "most participants of a split bill"},
+	{ParamBillMaxDays, 1, 0, "longest split bill collection"},
+	{ParamGigMaxMilestones, 1, 0, "most milestones of a gig"},
+}
+
+// ParamDefOf returns the definition of key.
+func ParamDefOf(key string) (ParamDef, bool) {
+	for _, p := range ParamDefs {
+		if p.Key == key {
+			return p, true
+		}
+	}
+	return ParamDef{}, false
+}
+
+// Check validates a value for the param.
+func (p ParamDef) Check(v int64) error {
+	if v < p.Min || (p.Max > 0 && v > p.Max) {
+		if p.Max > 0 {
+			return fmt.Errorf("bad value: %s must be between %d and %d", p.Key, p.Min, p.Max)
+		}
+		return fmt.Errorf("bad value: %s must be at least %d", p.Key, p.Min)
+	}
+	return nil
+}
+
+// ParamValue is an admin override.
+type ParamValue struct {
+	Key       string    `json:"key"`
+	Value     int64     `json:"value"`
+	UpdatedBy *int64    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListParams returns the overrides; keys without one use their default.
+// Rows of keys no longer in ParamDefs are skipped.
+func (d *DB) ListParams(ctx context.Context) ([]ParamValue, error) {
+	return listParams(ctx, d.Pool)
+}
+
+func listParams(ctx context.Context, q rowsQuerier) ([]ParamValue, error) {
+	rows, err := q.Query(ctx, `SELECT key, value, updated_by, updated_at FROM params ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ParamValue{}
+	for rows.Next() {
+		var p ParamValue
+		if err := rows.Scan(&p.Key, &p.Value, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if _, ok := ParamDefOf(p.Key); ok {
+			out = append(out, p)
+		}
+	}
+	return out, rows.Err()
+}
+
+// SetParam overrides key and records who did it in the ledger.
+func (d *DB) SetParam(ctx context.Context, adminID int64, key string, value int64) (ParamValue, error) {
+	def, ok := ParamDefOf(key)
+	if !ok {
+		return ParamValue{}, errors.New("bad param")
+	}
+	if err := def.Check(value); err != nil {
+		return ParamValue{}, err
+	}
+	var out ParamValue
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = setParamTx(ctx, tx, adminID, key, value)
+		return err
+	})
+	return out, err
+}
+
+func setParamTx(ctx context.Context, tx pgx.Tx, adminID int64, key string, value int64) (ParamValue, error) {
+	out := ParamValue{Key: key, Value: value}
+	if err := tx.QueryRow(ctx, `
+INSERT INTO params(key, value, updated_by) VALUES($1, $2, $3)
+ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_by=EXCLUDED.updated_by, updated_at=now()
+RETURNING updated_by, updated_at
+`, key, value, adminID).Scan(&out.UpdatedBy, &out.UpdatedAt); err != nil {
+		return ParamValue{}, err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_param', $1, NULL, 0, $2::jsonb)`,
+		adminID, toJSON(map[string]any{"key": key, "value": value}))
+	return out, err
+}
+
+// ResetParam drops the override of key, back to the default;
+// pgx.ErrNoRows if it had none.
+func (d *DB) ResetParam(ctx context.Context, adminID int64, key string) error {
+	if _, ok := ParamDefOf(key); !ok {
+		return errors.New("bad param")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		return resetParamTx(ctx, tx, adminID, key)
+	})
+}
+
+func resetParamTx(ctx context.Context, tx pgx.Tx, adminID int64, key string) error {
+	tag, err := tx.Exec(ctx, `DELETE FROM params WHERE key=$1`, key)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_param', $1, NULL, 0, $2::jsonb)`,
+		adminID, toJSON(map[string]any{"key": key, "reset": true}))
+	return err
+}
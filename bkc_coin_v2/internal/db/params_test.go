@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestParamDefCheck(t *testing.T) {
+	fee, _ := ParamDefOf(ParamNFTMarketFeeBP)
+	gift, _ := ParamDefOf(ParamGiftMinAmount)
+	for _, c := range []struct {
+		def ParamDef
+		v   int64
+		ok  bool
+	}{
+		{fee, 0, true}, {fee, 5_000, true}, {fee, 5_001, false}, {fee, -1, false},
+		{gift, 1, true}, {gift, 1 << 40, true}, {gift, 0, false},
+	} {
+		if err := c.def.Check(c.v); (err == nil) != c.ok {
+			t.Errorf("%s = %d: %v", c.def.Key, c.v, err)
+		}
+	}
+	if _, ok := ParamDefOf("casino.house_edge"); ok {
+		t.Fatal("unknown param found")
+	}
+}
+
+func TestSetParam(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM params WHERE key=$1`, ParamTipFeeBP)
+	})
+	if _, err := d.SetParam(ctx, 1, ParamTipFeeBP, 2_000); err == nil {
+		t.Fatal("out of range value accepted")
+	}
+	if _, err := d.SetParam(ctx, 1, "nope", 1); err == nil {
+		t.Fatal("unknown param accepted")
+	}
+	for _, v := range []int64{150, 200} {
+		if _, err := d.SetParam(ctx, 1, ParamTipFeeBP, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := d.ListParams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *ParamValue
+	for i := range list {
+		if list[i].Key == ParamTipFeeBP {
+			got = &list[i]
+		}
+	}
+	if got == nil || got.Value != 200 || got.UpdatedBy == nil || *got.UpdatedBy != 1 {
+		t.Fatalf("override %+v", got)
+	}
+	if err := d.ResetParam(ctx, 1, ParamTipFeeBP); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ResetParam(ctx, 1, ParamTipFeeBP); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("second reset: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Ledger patterns found by ScanLedgerPatterns.
+const (
+	PatternFanIn = "fan_in" // many small transfers into one account (structuring)
+	PatternCycle = "cycle"  // coins sent around a loop of 2 or 3 accounts
+)
+
+// Pattern alert statuses.
+const (
+	PatternOpen      = "open"
+	PatternFrozen    = "frozen"
+	PatternDismissed = "dismissed"
+)
+
+// PatternPolicy tunes the ledger scan. A transfer of at most SmallTransfer
+// counts towards structuring; FanInMin such transfers into one account in a
+// day raise an alert.
+type PatternPolicy struct {
+	SmallTransfer int64
+	FanInMin      int64
+}
+
+// fanInScore rates structuring 0..100: 50 at the threshold, growing with the
+// number of transfers, plus 20 when most of them come from different senders.
+func (p PatternPolicy) fanInScore(transfers, senders int64) int64 {
+	s := 50 * transfers / max(p.FanInMin, 1)
+	if senders*2 >= transfers {
+		s += 20
+	}
+	return min(s, 100)
+}
+
+// cycleScore rates a loop 0..100: longer loops are harder to explain, and a
+// loop moving more than a full day of structuring volume scores higher.
+func (p PatternPolicy) cycleScore(hops int, amount int64) int64 {
+	s := int64(40 + 20*(hops-1))
+	if amount >= p.SmallTransfer*p.FanInMin {
+		s += 20
+	}
+	return min(s, 100)
+}
+
+// PatternAlert is a scored finding in the admin queue.
+type PatternAlert struct {
+	AlertID    int64          `json:"alert_id"`
+	Day        time.Time      `json:"day"`
+	Pattern    string         `json:"pattern"`
+	Score      int64          `json:"score"`
+	UserIDs    []int64        `json:"user_ids"`
+	Amount     int64          `json:"amount"`
+	Transfers  int64          `json:"transfers"`
+	Status     string         `json:"status"`
+	ResolvedBy *int64         `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+const patternScanLimit = 1_000
+
+// patternKinds are the ledger kinds of coins moving from one user to
+// another; a claimed gift is booked sender to recipient, tips once per pair
+// and BookTips run.
+var patternKinds = []string{"transfer", "gift_claim", "tip"}
+
+// patternTransfers returns the user-to-user transfers the scan mines: the
+// ledger rows of patternKinds (kinds is their parameter) and the shares of
+// completed bills, whose bill_pay and bill_payout rows go through no one.
+func patternTransfers(kinds string) string {
+	return `(
+  SELECT from_id, to_id, amount, ts FROM ledger WHERE kind = ANY(` + kinds + `)
+  UNION ALL
+  SELECT s.user_id, b.payee_id, s.amount, s.paid_at FROM bill_shares s JOIN bills b ON b.bill_id = s.bill_id
+  WHERE b.status = 'completed'
+) t`
+}
+
+// fanInSenderLimit caps the senders recorded (and frozen) per fan-in alert.
+const fanInSenderLimit = 50
+
+// ScanLedgerPatterns mines the transfers of one UTC day for structuring and
+// circular transfers. A day is scanned once; returns the number of alerts
+// raised (0 if the day was already scanned).
+func (d *DB) ScanLedgerPatterns(ctx context.Context, day time.Time, p PatternPolicy) (int64, error) {
+	from := dayUTC(day)
+	to := from.AddDate(0, 0, 1)
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO ledger_scans(day) VALUES($1) ON CONFLICT DO NOTHING`, from)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+
+		var alerts []PatternAlert
+		if p.SmallTransfer > 0 && p.FanInMin > 0 {
+			rows, err := tx.Query(ctx, `
+SELECT to_id, COUNT(*), COUNT(DISTINCT from_id), SUM(amount),
+       (array_agg(DISTINCT from_id))[1:$5]
+FROM `+patternTransfers("$7")+`
+WHERE ts >= $1 AND ts < $2 AND amount <= $3
+GROUP BY to_id
+HAVING COUNT(*) >= $4
+ORDER BY COUNT(*) DESC
+LIMIT $6
+`, from, to, p.SmallTransfer, p.FanInMin, fanInSenderLimit, patternScanLimit, patternKinds)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var toID, transfers, senders, amount int64
+				var senderIDs []int64
+				if err := rows.Scan(&toID, &transfers, &senders, &amount, &senderIDs); err != nil {
+					rows.Close()
+					return err
+				}
+				alerts = append(alerts, PatternAlert{
+					Pattern:   PatternFanIn,
+					Score:     p.fanInScore(transfers, senders),
+					UserIDs:   append([]int64{toID}, senderIDs...),
+					Amount:    amount,
+					Transfers: transfers,
+					Details:   map[string]any{"receiver": toID, "senders": senders},
+				})
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		// Loops start at their lowest id so each is found once.
+		rows, err := tx.Query(ctx, `
+WITH e AS (
+  SELECT from_id AS a, to_id AS b, SUM(amount) AS amt, COUNT(*) AS n
+  FROM `+patternTransfers("$4")+`
+  WHERE ts >= $1 AND ts < $2
+  GROUP BY from_id, to_id
+)
+SELECT ARRAY[e1.a, e1.b], LEAST(e1.amt, e2.amt), e1.n + e2.n
+FROM e e1 JOIN e e2 ON e2.a = e1.b AND e2.b = e1.a
+WHERE e1.a < e1.b
+UNION ALL
+SELECT ARRAY[e1.a, e1.b, e2.b], LEAST(e1.amt, e2.amt, e3.amt), e1.n + e2.n + e3.n
+FROM e e1
+JOIN e e2 ON e2.a = e1.b
+JOIN e e3 ON e3.a = e2.b AND e3.b = e1.a
+WHERE e1.a < e1.b AND e1.a < e2.b
+LIMIT $3
+`, from, to, patternScanLimit, patternKinds)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var ids []int64
+			var amount, transfers int64
+			if err := rows.Scan(&ids, &amount, &transfers); err != nil {
+				rows.Close()
+				return err
+			}
+			alerts = append(alerts, PatternAlert{
+				Pattern:   PatternCycle,
+				Score:     p.cycleScore(len(ids), amount),
+				UserIDs:   ids,
+				Amount:    amount,
+				Transfers: transfers,
+				Details:   map[string]any{"path": ids},
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, a := range alerts {
+			if _, err := tx.Exec(ctx, `
+INSERT INTO pattern_alerts(day, pattern, score, user_ids, amount, transfers, details)
+VALUES($1,$2,$3,$4,$5,$6,$7::jsonb)
+`, from, a.Pattern, a.Score, a.UserIDs, a.Amount, a.Transfers, toJSON(a.Details)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(ctx, `UPDATE ledger_scans SET alerts=$2 WHERE day=$1`, from, len(alerts))
+		n = int64(len(alerts))
+		return err
+	})
+	return n, err
+}
+
+// ListPatternAlerts returns alerts with the given status ("" = any),
+// highest score first.
+func (d *DB) ListPatternAlerts(ctx context.Context, status string, limit int) ([]PatternAlert, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT alert_id, day, pattern, score, user_ids, amount, transfers, status, resolved_by, resolved_at, details, created_at
+FROM pattern_alerts
+WHERE $1 = '' OR status = $1
+ORDER BY score DESC, alert_id DESC
+LIMIT $2
+`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PatternAlert
+	for rows.Next() {
+		var a PatternAlert
+		if err := rows.Scan(&a.AlertID, &a.Day, &a.Pattern, &a.Score, &a.UserIDs, &a.Amount, &a.Transfers, &a.Status, &a.ResolvedBy, &a.ResolvedAt, &a.Details, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// lockOpenPatternAlertTx locks an open alert and returns its users.
+func lockOpenPatternAlertTx(ctx context.Context, tx pgx.Tx, alertID int64) ([]int64, error) {
+	var ids []int64
+	var status string
+	if err := tx.QueryRow(ctx, `SELECT user_ids, status FROM pattern_alerts WHERE alert_id=$1 FOR UPDATE`, alertID).Scan(&ids, &status); err != nil {
+		return nil, err
+	}
+	if status != PatternOpen {
+		return nil, errors.New("bad alert status: " + status)
+	}
+	return ids, nil
+}
+
+// FreezePatternAlert moves the whole balance of every account in the alert to
+// frozen_balance and closes the alert. Returns the total frozen.
+func (d *DB) FreezePatternAlert(ctx context.Context, adminID, alertID int64) (int64, error) {
+	var total int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		ids, err := lockOpenPatternAlertTx(ctx, tx, alertID)
+		if err != nil {
+			return err
+		}
+		if err := lockUsersTx(ctx, tx, ids...); err != nil {
+			return err
+		}
+		for _, userID := range ids {
+			var bal int64
+			if err := tx.QueryRow(ctx, `SELECT balance FROM users WHERE user_id=$1`, userID).Scan(&bal); err != nil {
+				return err
+			}
+			if bal <= 0 {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance=0, frozen_balance=frozen_balance+$1 WHERE user_id=$2`, bal, userID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('balance_freeze', $1, NULL, $2, $3::jsonb)`,
+				userID, bal, toJSON(map[string]any{"amount": bal, "pattern_alert_id": alertID, "admin_id": adminID})); err != nil {
+				return err
+			}
+			total += bal
+		}
+		_, err = tx.Exec(ctx, `UPDATE pattern_alerts SET status=$2, resolved_by=$3, resolved_at=now() WHERE alert_id=$1`, alertID, PatternFrozen, adminID)
+		return err
+	})
+	return total, err
+}
+
+// DismissPatternAlert closes an alert as a false positive.
+func (d *DB) DismissPatternAlert(ctx context.Context, adminID, alertID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockOpenPatternAlertTx(ctx, tx, alertID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE pattern_alerts SET status=$2, resolved_by=$3, resolved_at=now() WHERE alert_id=$1`, alertID, PatternDismissed, adminID)
+		return err
+	})
+}
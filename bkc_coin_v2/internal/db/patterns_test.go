@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPatternScores(t *testing.T) {
+	p := PatternPolicy{SmallTransfer: 1_000, FanInMin: 20}
+	if got := p.fanInScore(20, 2); got != 50 {
+		t.Fatalf("fan-in at threshold, one sender mostly: %d", got)
+	}
+	if got := p.fanInScore(20, 20); got != 70 {
+		t.Fatalf("fan-in from distinct senders: %d", got)
+	}
+	if got := p.fanInScore(200, 200); got != 100 {
+		t.Fatalf("fan-in capped: %d", got)
+	}
+	if got := p.cycleScore(2, 10); got != 60 {
+		t.Fatalf("2-cycle: %d", got)
+	}
+	if got := p.cycleScore(3, 20_000); got != 100 {
+		t.Fatalf("large 3-cycle: %d", got)
+	}
+}
+
+func TestScanLedgerPatterns(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	const a, b, c = 991_001, 991_002, 991_003
+	for _, id := range []int64{a, b, c} {
+		if _, err := d.EnsureUser(ctx, id, "", "", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger WHERE kind='transfer' AND ts >= $1 AND ts < $2`, day, day.AddDate(0, 0, 1))
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger_scans WHERE day=$1`, day)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM pattern_alerts WHERE day=$1`, day)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger WHERE kind='balance_freeze' AND from_id = ANY($1)`, []int64{a, b, c})
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id = ANY($1)`, []int64{a, b, c})
+	})
+	transfer := func(from, to, amount int64) {
+		if _, err := d.Pool.Exec(ctx, `INSERT INTO ledger(ts, kind, from_id, to_id, amount) VALUES($1,'transfer',$2,$3,$4)`,
+			day.Add(time.Hour), from, to, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a -> b -> c -> a, plus three small transfers b -> c.
+	transfer(a, b, 5_000)
+	transfer(b, c, 5_000)
+	transfer(c, a, 5_000)
+	for range 3 {
+		transfer(b, c, 10)
+	}
+
+	p := PatternPolicy{SmallTransfer: 100, FanInMin: 3}
+	n, err := d.ScanLedgerPatterns(ctx, day, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("alerts: %d, want fan-in and cycle", n)
+	}
+	if again, err := d.ScanLedgerPatterns(ctx, day, p); err != nil || again != 0 {
+		t.Fatalf("rescan: %d %v", again, err)
+	}
+
+	alerts, err := d.ListPatternAlerts(ctx, PatternOpen, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cycle *PatternAlert
+	for i := range alerts {
+		if alerts[i].Day.Equal(day) && alerts[i].Pattern == PatternCycle {
+			cycle = &alerts[i]
+		}
+	}
+	if cycle == nil || len(cycle.UserIDs) != 3 || cycle.UserIDs[0] != a {
+		t.Fatalf("cycle alert: %+v", cycle)
+	}
+
+	if _, err := d.Pool.Exec(ctx, `UPDATE users SET balance=300, frozen_balance=0 WHERE user_id = ANY($1)`, []int64{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+	frozen, err := d.FreezePatternAlert(ctx, 1, cycle.AlertID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frozen != 900 {
+		t.Fatalf("frozen %d, want 900", frozen)
+	}
+	if _, err := d.FreezePatternAlert(ctx, 1, cycle.AlertID); err == nil {
+		t.Fatal("closed alert frozen twice")
+	}
+}
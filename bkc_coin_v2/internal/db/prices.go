@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Fair prices come from recent comparable sales: sold market listings of
+// the same category, or secondary sales of the same NFT. The suggestion is
+// their median; a price more than the configured percentage above it is
+// flagged as above market. Too few sales give no suggestion.
+
+// Price suggestion kinds.
+const (
+	PriceKindCategory = "category"
+	PriceKindNFT      = "nft"
+)
+
+const minComparableSales = 3
+
+// PricePoint is one day of comparable sales.
+type PricePoint struct {
+	Day    time.Time `json:"day"`
+	Sales  int64     `json:"sales"`
+	Median int64     `json:"median"`
+}
+
+// PriceSuggestion is the market price of a category or NFT; Median, P25 and
+// P75 are 0 when there were too few sales. Price and the above fields are
+// set by Check.
+type PriceSuggestion struct {
+	Kind        string       `json:"kind"`
+	Ref         string       `json:"ref"`
+	Sales       int64        `json:"sales"`
+	Median      int64        `json:"median"` // the suggested price
+	P25         int64        `json:"p25"`
+	P75         int64        `json:"p75"`
+	Since       time.Time    `json:"since"`
+	History     []PricePoint `json:"history"`
+	Price       int64        `json:"price,omitempty"`
+	AbovePct    int64        `json:"above_pct,omitempty"`
+	AboveMarket bool         `json:"above_market"`
+}
+
+// abovePct is how many percent price is above median; negative below.
+func abovePct(price, median int64) int64 {
+	if median <= 0 {
+		return 0
+	}
+	return (price - median) * 100 / median
+}
+
+// Check compares price with the market and flags it when it is more than
+// maxAbovePct above the median (0 = never).
+func (s *PriceSuggestion) Check(price, maxAbovePct int64) {
+	s.Price = price
+	s.AbovePct = abovePct(price, s.Median)
+	s.AboveMarket = maxAbovePct > 0 && s.Median > 0 && s.AbovePct > maxAbovePct
+}
+
+// priceSalesSQL selects (price, at) of the comparable sales of a kind; $1 is
+// the ref, $2 the start of the window.
+var priceSalesSQL = map[string]string{
+	PriceKindCategory: `SELECT price_coins AS price, sold_at AS at FROM market_listings WHERE status='sold' AND category=$1 AND sold_at >= $2`,
+	PriceKindNFT:      `SELECT price_coins AS price, created_at AS at FROM nft_sales WHERE nft_id=$1::bigint AND created_at >= $2`,
+}
+
+// SuggestPrice returns the market price of a category or NFT from the
+// sales since since, with the daily history.
+func (d *DB) SuggestPrice(ctx context.Context, kind, ref string, since time.Time) (PriceSuggestion, error) {
+	sales, ok := priceSalesSQL[kind]
+	if !ok || ref == "" {
+		return PriceSuggestion{}, errors.New("bad kind")
+	}
+	if kind == PriceKindNFT {
+		if id, err := strconv.ParseInt(ref, 10, 64); err != nil || id <= 0 {
+			return PriceSuggestion{}, errors.New("bad nft_id")
+		}
+	}
+	out := PriceSuggestion{Kind: kind, Ref: ref, Since: since, History: []PricePoint{}}
+	if err := d.Pool.QueryRow(ctx, `
+SELECT COUNT(*),
+       COALESCE(percentile_disc(0.5) WITHIN GROUP (ORDER BY price), 0),
+       COALESCE(percentile_disc(0.25) WITHIN GROUP (ORDER BY price), 0),
+       COALESCE(percentile_disc(0.75) WITHIN GROUP (ORDER BY price), 0)
+FROM (`+sales+`) s
+`, ref, since).Scan(&out.Sales, &out.Median, &out.P25, &out.P75); err != nil {
+		return PriceSuggestion{}, err
+	}
+	if out.Sales < minComparableSales {
+		out.Median, out.P25, out.P75 = 0, 0, 0
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT date_trunc('day', at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', COUNT(*), percentile_disc(0.5) WITHIN GROUP (ORDER BY price)
+FROM (`+sales+`) s
+GROUP BY 1
+ORDER BY 1
+`, ref, since)
+	if err != nil {
+		return PriceSuggestion{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p PricePoint
+		if err := rows.Scan(&p.Day, &p.Sales, &p.Median); err != nil {
+			return PriceSuggestion{}, err
+		}
+		out.History = append(out.History, p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,25 @@
+package db
+
+import "testing"
+
+func TestPriceSuggestionCheck(t *testing.T) {
+	cases := []struct {
+		median, price, maxPct int64
+		pct                   int64
+		above                 bool
+	}{
+		{1_000, 1_500, 50, 50, false}, // exactly the limit
+		{1_000, 1_501, 50, 50, false}, // rounds down
+		{1_000, 1_510, 50, 51, true},
+		{1_000, 800, 50, -20, false},
+		{1_000, 5_000, 0, 400, false}, // flagging off
+		{0, 5_000, 50, 0, false},      // too few sales
+	}
+	for _, c := range cases {
+		s := PriceSuggestion{Median: c.median}
+		s.Check(c.price, c.maxPct)
+		if s.AbovePct != c.pct || s.AboveMarket != c.above || s.Price != c.price {
+			t.Fatalf("median %d price %d max %d%%: %+v", c.median, c.price, c.maxPct, s)
+		}
+	}
+}
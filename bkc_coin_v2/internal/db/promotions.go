@@ -0,0 +1,394 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Promotion targets: a bazaar listing or a secondary NFT listing.
+const (
+	PromoMarket = "market"
+	PromoNFT    = "nft"
+)
+
+const maxPromotionDays = 30
+
+var errListingInactive = errors.New("bad listing status")
+
+// ListingPromotion is a paid boost of a listing into the featured rotation.
+// The budget is frozen on the seller's account when the promotion is bought;
+// every featured impression spends CostPerImpression of it, at most DailyBudget
+// per UTC day. Spent coins go to reserve, the unspent rest is refunded at the end.
+type ListingPromotion struct {
+	PromoID           int64     `json:"promo_id"`
+	SellerID          int64     `json:"seller_id"`
+	Kind              string    `json:"kind"`
+	ListingID         int64     `json:"listing_id"`
+	Budget            int64     `json:"budget"`
+	DailyBudget       int64     `json:"daily_budget"`
+	CostPerImpression int64     `json:"cost_per_impression"`
+	Spent             int64     `json:"spent"`
+	Settled           int64     `json:"settled"` // part of Spent already moved to reserve
+	Impressions       int64     `json:"impressions"`
+	Clicks            int64     `json:"clicks"`
+	Status            string    `json:"status"` // active|ended|cancelled
+	StartsAt          time.Time `json:"starts_at"`
+	EndsAt            time.Time `json:"ends_at"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// FeaturedListing is one slot of the featured rotation.
+type FeaturedListing struct {
+	PromoID    int64  `json:"promo_id"`
+	Kind       string `json:"kind"`
+	ListingID  int64  `json:"listing_id"`
+	Title      string `json:"title"`
+	PriceCoins int64  `json:"price_coins"`
+}
+
+// promotedListingTx returns the seller of an active listing of the given kind.
+func promotedListingTx(ctx context.Context, tx pgx.Tx, kind string, listingID int64) (int64, error) {
+	var seller int64
+	var status string
+	var err error
+	switch kind {
+	case PromoMarket:
+		err = tx.QueryRow(ctx, `SELECT seller_id, status FROM market_listings WHERE listing_id=$1`, listingID).Scan(&seller, &status)
+	case PromoNFT:
+		err = tx.QueryRow(ctx, `SELECT seller_id, status FROM nft_listings WHERE listing_id=$1`, listingID).Scan(&seller, &status)
+	default:
+		return 0, errors.New("bad kind")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status != "active" {
+		return 0, errListingInactive
+	}
+	return seller, nil
+}
+
+// CreateListingPromotion boosts an active listing for days days. budget is
+// frozen now and paced evenly over the promotion period.
+func (d *DB) CreateListingPromotion(ctx context.Context, sellerID int64, kind string, listingID, days, budget, costPerImpression int64) (ListingPromotion, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if sellerID <= 0 || listingID <= 0 || days <= 0 || days > maxPromotionDays || costPerImpression <= 0 || budget < days*costPerImpression {
+		return ListingPromotion{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	out := ListingPromotion{
+		SellerID:          sellerID,
+		Kind:              kind,
+		ListingID:         listingID,
+		Budget:            budget,
+		DailyBudget:       (budget + days - 1) / days,
+		CostPerImpression: costPerImpression,
+		Status:            "active",
+		StartsAt:          now,
+		EndsAt:            now.Add(time.Duration(days) * 24 * time.Hour),
+		CreatedAt:         now,
+	}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		owner, err := promotedListingTx(ctx, tx, kind, listingID)
+		if err != nil {
+			return err
+		}
+		if owner != sellerID {
+			return ErrForbidden
+		}
+		var busy bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM listing_promotions WHERE kind=$1 AND listing_id=$2 AND status='active')`, kind, listingID).Scan(&busy); err != nil {
+			return err
+		}
+		if busy {
+			return ErrAlreadyExists
+		}
+
+		spendable, err := lockSpendableTx(ctx, tx, sellerID)
+		if err != nil {
+			return err
+		}
+		if spendable < budget {
+			return ErrNotEnough
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1, frozen_balance=frozen_balance+$1 WHERE user_id=$2`, budget, sellerID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+INSERT INTO listing_promotions (seller_id, kind, listing_id, budget, daily_budget, cost_per_impression, status, starts_at, ends_at, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,'active',$7,$8,$7)
+RETURNING promo_id
+`, sellerID, kind, listingID, budget, out.DailyBudget, costPerImpression, now, out.EndsAt).Scan(&out.PromoID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('promo_budget_lock', $1, NULL, $2, $3::jsonb)`,
+			sellerID, budget, toJSON(map[string]any{"promo_id": out.PromoID, "kind": kind, "listing_id": listingID, "days": days}),
+		)
+		return err
+	})
+	if err != nil {
+		return ListingPromotion{}, err
+	}
+	return out, nil
+}
+
+// FeaturedListings picks up to limit promoted listings for the featured rotation
+// and charges each one impression. Promotions that used up today's budget sit out
+// until the next UTC day; the rest are drawn at random weighted by daily budget.
+func (d *DB) FeaturedListings(ctx context.Context, kind string, limit int64) ([]FeaturedListing, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind != PromoMarket && kind != PromoNFT {
+		return nil, errors.New("bad kind")
+	}
+	if limit <= 0 || limit > 20 {
+		limit = 5
+	}
+	// Lock the drawn promotions so concurrent requests charge each impression once.
+	sql := `
+SELECT p.promo_id, p.listing_id, %s, l.price_coins
+FROM (
+  SELECT promo_id, listing_id
+  FROM listing_promotions
+  WHERE kind=$1 AND status='active' AND now() < ends_at AND spent < budget
+    AND (spend_day IS DISTINCT FROM $2 OR day_spent < daily_budget)
+  ORDER BY -ln(1 - random()) / daily_budget
+  LIMIT $3
+  FOR UPDATE SKIP LOCKED
+) p
+`
+	if kind == PromoNFT {
+		sql = fmt.Sprintf(sql, "n.title") + `JOIN nft_listings l ON l.listing_id = p.listing_id AND l.status='active'
+JOIN nfts n ON n.nft_id = l.nft_id`
+	} else {
+		sql = fmt.Sprintf(sql, "l.title") + `JOIN market_listings l ON l.listing_id = p.listing_id AND l.status='active'`
+	}
+	today := dayUTC(time.Now())
+	var out []FeaturedListing
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, sql, kind, today, limit)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			f := FeaturedListing{Kind: kind}
+			if err := rows.Scan(&f.PromoID, &f.ListingID, &f.Title, &f.PriceCoins); err != nil {
+				rows.Close()
+				return err
+			}
+			out = append(out, f)
+			ids = append(ids, f.PromoID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `
+UPDATE listing_promotions
+SET impressions=impressions+1,
+    day_spent=CASE WHEN spend_day = $2 THEN day_spent ELSE 0 END + LEAST(cost_per_impression, budget-spent),
+    spend_day=$2,
+    spent=spent+LEAST(cost_per_impression, budget-spent)
+WHERE promo_id = ANY($1)
+`, ids, today)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RecordPromotionClick counts a click on a featured slot.
+func (d *DB) RecordPromotionClick(ctx context.Context, promoID int64) error {
+	if promoID <= 0 {
+		return errors.New("bad params")
+	}
+	tag, err := d.Pool.Exec(ctx, `UPDATE listing_promotions SET clicks=clicks+1 WHERE promo_id=$1 AND status='active'`, promoID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func lockListingPromotionTx(ctx context.Context, tx pgx.Tx, promoID int64) (ListingPromotion, error) {
+	var p ListingPromotion
+	err := tx.QueryRow(ctx, `
+SELECT promo_id, seller_id, kind, listing_id, budget, daily_budget, cost_per_impression, spent, settled, impressions, clicks, status, starts_at, ends_at, created_at
+FROM listing_promotions
+WHERE promo_id=$1
+FOR UPDATE
+`, promoID).Scan(&p.PromoID, &p.SellerID, &p.Kind, &p.ListingID, &p.Budget, &p.DailyBudget, &p.CostPerImpression, &p.Spent, &p.Settled, &p.Impressions, &p.Clicks, &p.Status, &p.StartsAt, &p.EndsAt, &p.CreatedAt)
+	return p, err
+}
+
+// settleListingPromotionTx moves spent-but-unsettled budget from the seller's
+// frozen balance to reserve. With closeStatus set it also ends the promotion
+// and refunds the unspent budget.
+func settleListingPromotionTx(ctx context.Context, tx pgx.Tx, p *ListingPromotion, closeStatus string) error {
+	if due := p.Spent - p.Settled; due > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET frozen_balance=GREATEST(frozen_balance-$1, 0) WHERE user_id=$2`, due, p.SellerID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, due); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('promo_revenue', $1, NULL, $2, $3::jsonb)`,
+			p.SellerID, due, toJSON(map[string]any{"promo_id": p.PromoID, "impressions": p.Impressions, "clicks": p.Clicks}),
+		); err != nil {
+			return err
+		}
+		p.Settled = p.Spent
+	}
+	if closeStatus == "" {
+		_, err := tx.Exec(ctx, `UPDATE listing_promotions SET settled=$1 WHERE promo_id=$2`, p.Settled, p.PromoID)
+		return err
+	}
+	if refund := p.Budget - p.Spent; refund > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1, frozen_balance=GREATEST(frozen_balance-$1, 0) WHERE user_id=$2`, refund, p.SellerID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('promo_refund', NULL, $1, $2, $3::jsonb)`,
+			p.SellerID, refund, toJSON(map[string]any{"promo_id": p.PromoID, "status": closeStatus}),
+		); err != nil {
+			return err
+		}
+	}
+	p.Status = closeStatus
+	_, err := tx.Exec(ctx, `UPDATE listing_promotions SET settled=$1, status=$2 WHERE promo_id=$3`, p.Settled, closeStatus, p.PromoID)
+	return err
+}
+
+// CancelListingPromotion stops a promotion early; the unspent budget is refunded.
+func (d *DB) CancelListingPromotion(ctx context.Context, sellerID, promoID int64) error {
+	if sellerID <= 0 || promoID <= 0 {
+		return errors.New("bad params")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		p, err := lockListingPromotionTx(ctx, tx, promoID)
+		if err != nil {
+			return err
+		}
+		if p.SellerID != sellerID {
+			return ErrForbidden
+		}
+		if p.Status != "active" {
+			return nil
+		}
+		return settleListingPromotionTx(ctx, tx, &p, "cancelled")
+	})
+}
+
+// SettleListingPromotions routes spent promotion budgets to reserve and ends
+// promotions that expired, ran out of budget or whose listing is no longer active.
+// Run periodically.
+func (d *DB) SettleListingPromotions(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT promo_id
+FROM listing_promotions
+WHERE status='active'
+ORDER BY promo_id
+LIMIT 1000
+`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var ended int64
+	for _, id := range ids {
+		err := d.WithTx(ctx, func(tx pgx.Tx) error {
+			p, err := lockListingPromotionTx(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if p.Status != "active" {
+				return nil
+			}
+			closeStatus := ""
+			if !now.Before(p.EndsAt) || p.Spent >= p.Budget {
+				closeStatus = "ended"
+			} else if _, err := promotedListingTx(ctx, tx, p.Kind, p.ListingID); err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) && !errors.Is(err, errListingInactive) {
+					return err
+				}
+				closeStatus = "ended"
+			}
+			if closeStatus == "" && p.Spent == p.Settled {
+				return nil
+			}
+			if err := settleListingPromotionTx(ctx, tx, &p, closeStatus); err != nil {
+				return err
+			}
+			if closeStatus == "" {
+				return nil
+			}
+			ended++
+			return addUserEventTx(ctx, tx, p.SellerID, "promotion_ended", map[string]any{
+				"promo_id":    p.PromoID,
+				"kind":        p.Kind,
+				"listing_id":  p.ListingID,
+				"spent":       p.Spent,
+				"impressions": p.Impressions,
+				"clicks":      p.Clicks,
+			})
+		})
+		if err != nil {
+			return ended, err
+		}
+	}
+	return ended, nil
+}
+
+// ListMyPromotions returns the seller's promotions with their stats, newest first.
+func (d *DB) ListMyPromotions(ctx context.Context, sellerID int64, limit int64) ([]ListingPromotion, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT promo_id, seller_id, kind, listing_id, budget, daily_budget, cost_per_impression, spent, settled, impressions, clicks, status, starts_at, ends_at, created_at
+FROM listing_promotions
+WHERE seller_id=$1
+ORDER BY created_at DESC
+LIMIT $2
+`, sellerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ListingPromotion
+	for rows.Next() {
+		var p ListingPromotion
+		if err := rows.Scan(&p.PromoID, &p.SellerID, &p.Kind, &p.ListingID, &p.Budget, &p.DailyBudget, &p.CostPerImpression, &p.Spent, &p.Settled, &p.Impressions, &p.Clicks, &p.Status, &p.StartsAt, &p.EndsAt, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
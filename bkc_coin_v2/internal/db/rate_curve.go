@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RateCurve is the reserve-bound pricing curve: the coins-per-USD rate falls
+// linearly from StartRate (full reserve) to MinRate (empty reserve).
+//
+// Coins sold for USD walk down the curve as they leave the reserve, so a
+// large purchase gets a slightly worse average rate than the spot rate.
+type RateCurve struct {
+	StartRate      int64
+	MinRate        int64
+	InitialReserve int64
+}
+
+// Curve returns the pricing curve configured in system_state.
+func (s SystemState) Curve() RateCurve {
+	return RateCurve{StartRate: s.StartRateCoinsUSD, MinRate: s.MinRateCoinsUSD, InitialReserve: s.InitialReserve}
+}
+
+// CoinsPerUSD is the current BKC rate. It falls linearly from StartRateCoinsUSD
+// to MinRateCoinsUSD as the reserve drains.
+func (s SystemState) CoinsPerUSD() int64 {
+	return s.Curve().RateAt(s.ReserveSupply)
+}
+
+// RateAt is the spot rate (coins per USD) at the given reserve.
+func (c RateCurve) RateAt(reserve int64) int64 {
+	if c.InitialReserve <= 0 {
+		return c.StartRate
+	}
+	reserve = c.clamp(reserve)
+	span := c.StartRate - c.MinRate
+	return c.MinRate + (span*reserve)/c.InitialReserve
+}
+
+// CoinsForUSD integrates the curve from reserve downwards: dcoins = rate(R)*dusd,
+// dR = -dcoins. Rounds down; the result may exceed reserve, callers check.
+func (c RateCurve) CoinsForUSD(reserve int64, usd float64) int64 {
+	if usd <= 0 || math.IsNaN(usd) || math.IsInf(usd, 0) {
+		return 0
+	}
+	r0, k := c.spot(reserve)
+	if r0 <= 0 {
+		return 0
+	}
+	coins := r0 * usd
+	if k > 0 {
+		coins = r0 * -math.Expm1(-k*usd) / k
+	}
+	if coins >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(math.Floor(coins))
+}
+
+// USDForCoins is the inverse of CoinsForUSD: the USD price of buying coins
+// at reserve. +Inf if the reserve cannot cover them.
+func (c RateCurve) USDForCoins(reserve, coins int64) float64 {
+	if coins <= 0 {
+		return 0
+	}
+	if c.InitialReserve > 0 && coins > c.clamp(reserve) {
+		return math.Inf(1)
+	}
+	r0, k := c.spot(reserve)
+	if r0 <= 0 {
+		return math.Inf(1)
+	}
+	if k == 0 {
+		return float64(coins) / r0
+	}
+	return -math.Log1p(-k*float64(coins)/r0) / k
+}
+
+// spot returns the unrounded rate at reserve and the curve slope (rate per coin).
+func (c RateCurve) spot(reserve int64) (float64, float64) {
+	if c.InitialReserve <= 0 {
+		return float64(c.StartRate), 0
+	}
+	k := float64(c.StartRate-c.MinRate) / float64(c.InitialReserve)
+	if k < 0 {
+		k = 0
+	}
+	return float64(c.MinRate) + k*float64(c.clamp(reserve)), k
+}
+
+func (c RateCurve) clamp(reserve int64) int64 {
+	if reserve < 0 {
+		return 0
+	}
+	if reserve > c.InitialReserve {
+		return c.InitialReserve
+	}
+	return reserve
+}
+
+// RateQuote previews a purchase of BKC on the curve.
+type RateQuote struct {
+	Currency  string  `json:"currency"`
+	Amount    float64 `json:"amount"`
+	USD       float64 `json:"usd"`
+	Coins     int64   `json:"coins"`
+	SpotRate  int64   `json:"spot_rate"`  // coins per USD before the purchase
+	AvgRate   float64 `json:"avg_rate"`   // coins per USD actually received
+	RateAfter int64   `json:"rate_after"` // spot rate once the coins are issued
+	Available int64   `json:"available"`  // unreserved reserve the quote was priced at
+}
+
+// QuoteCoins prices amount of currency (USD, USDT or TON) in BKC. Issuance is
+// priced at the unreserved reserve (reserve_supply - reserved_supply), the same
+// position CreateDeposit and CreateCryptoPayInvoice use.
+func (d *DB) QuoteCoins(ctx context.Context, currency string, amount float64, rates QuoteRates) (RateQuote, error) {
+	usd, currency, err := paymentUSD(ctx, rates, currency, amount)
+	if err != nil {
+		return RateQuote{}, err
+	}
+	var s SystemState
+	if err := d.Pool.QueryRow(ctx, `
+SELECT reserve_supply, reserved_supply, initial_reserve, start_rate_coins_usd, min_rate_coins_usd
+FROM system_state
+WHERE id=1
+`).Scan(&s.ReserveSupply, &s.ReservedSupply, &s.InitialReserve, &s.StartRateCoinsUSD, &s.MinRateCoinsUSD); err != nil {
+		return RateQuote{}, err
+	}
+	available := s.ReserveSupply - s.ReservedSupply
+	curve := s.Curve()
+	q := RateQuote{
+		Currency:  currency,
+		Amount:    amount,
+		USD:       usd,
+		Coins:     curve.CoinsForUSD(available, usd),
+		SpotRate:  curve.RateAt(available),
+		Available: available,
+	}
+	if q.Coins <= 0 || q.Coins > available {
+		return RateQuote{}, ErrNotEnough
+	}
+	q.AvgRate = float64(q.Coins) / usd
+	q.RateAfter = curve.RateAt(available - q.Coins)
+	return q, nil
+}
+
+// CurveQuoter converts external payments to BKC on the curve (payments use it
+// for order conversion).
+type CurveQuoter struct {
+	db    *DB
+	rates QuoteRates
+}
+
+func (d *DB) CurveQuoter(rates QuoteRates) CurveQuoter {
+	return CurveQuoter{db: d, rates: rates}
+}
+
+func (q CurveQuoter) CoinsFor(ctx context.Context, currency string, amount float64) (int64, error) {
+	out, err := q.db.QuoteCoins(ctx, currency, amount, q.rates)
+	return out.Coins, err
+}
+
+// paymentUSD converts a payment amount to USD. USD needs no oracle.
+func paymentUSD(ctx context.Context, rates QuoteRates, currency string, amount float64) (float64, string, error) {
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, "", errors.New("bad amount")
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	switch currency {
+	case "", "USD":
+		return amount, "USD", nil
+	case QuoteUSDT, QuoteTON:
+		if rates == nil {
+			return 0, "", errors.New("rates unavailable")
+		}
+		per, err := rates.USDPerUnit(ctx, currency)
+		if err != nil {
+			return 0, "", err
+		}
+		if per <= 0 || math.IsNaN(per) || math.IsInf(per, 0) {
+			return 0, "", errors.New("bad rate")
+		}
+		return amount * per, currency, nil
+	}
+	return 0, "", errors.New("bad currency")
+}
+
+// issueCoinsTx locks system_state and prices usd on the curve at the unreserved
+// reserve. Callers reserve the returned coins in the same transaction.
+func issueCoinsTx(ctx context.Context, tx pgx.Tx, usd float64) (int64, error) {
+	var s SystemState
+	if err := tx.QueryRow(ctx, `
+SELECT reserve_supply, reserved_supply, initial_reserve, start_rate_coins_usd, min_rate_coins_usd
+FROM system_state
+WHERE id=1
+FOR UPDATE
+`).Scan(&s.ReserveSupply, &s.ReservedSupply, &s.InitialReserve, &s.StartRateCoinsUSD, &s.MinRateCoinsUSD); err != nil {
+		return 0, err
+	}
+	available := s.ReserveSupply - s.ReservedSupply
+	coins := s.Curve().CoinsForUSD(available, usd)
+	if coins <= 0 || coins > available {
+		return 0, ErrNotEnough
+	}
+	return coins, nil
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRateCurveCoinsForUSD(t *testing.T) {
+	flat := RateCurve{StartRate: 1_000, MinRate: 1_000, InitialReserve: 1_000_000}
+	if got := flat.CoinsForUSD(500_000, 12); got != 12_000 {
+		t.Fatalf("flat curve: got %d", got)
+	}
+
+	c := RateCurve{StartRate: 1_000, MinRate: 500, InitialReserve: 1_000_000}
+	// Small purchases get (almost) the spot rate, rounded down.
+	if got := c.CoinsForUSD(1_000_000, 1); got != 999 {
+		t.Fatalf("$1 at full reserve: got %d", got)
+	}
+	// A large purchase walks down the curve: fewer coins than spot * usd.
+	spot := c.RateAt(1_000_000)
+	got := c.CoinsForUSD(1_000_000, 500)
+	if got >= spot*500 || got < c.RateAt(1_000_000-got)*500 {
+		t.Fatalf("$500 at full reserve: got %d (spot %d)", got, spot)
+	}
+	// Buying in two steps costs the same as buying at once.
+	first := c.CoinsForUSD(1_000_000, 250)
+	second := c.CoinsForUSD(1_000_000-first, 250)
+	if d := first + second - got; d < -2 || d > 2 {
+		t.Fatalf("split purchase: %d+%d vs %d", first, second, got)
+	}
+	if usd := c.USDForCoins(1_000_000, got); math.Abs(usd-500) > 0.01 {
+		t.Fatalf("inverse: %d coins cost %f", got, usd)
+	}
+	if !math.IsInf(c.USDForCoins(100, 101), 1) {
+		t.Fatalf("coins beyond the reserve must be unpriceable")
+	}
+	if c.CoinsForUSD(1_000_000, 0) != 0 || c.CoinsForUSD(1_000_000, math.NaN()) != 0 {
+		t.Fatalf("bad usd must give 0 coins")
+	}
+}
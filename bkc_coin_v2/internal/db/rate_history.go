@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RateSample is the effective internal rate (SystemState.CoinsPerUSD) at a point in time.
+type RateSample struct {
+	At            time.Time `json:"at"`
+	CoinsPerUSD   int64     `json:"coins_per_usd"`
+	ReserveSupply int64     `json:"reserve_supply"`
+}
+
+// RateCandle is an OHLC bucket of coins-per-USD samples. The rate only falls
+// as the reserve drains, but admin reserve top-ups can push it back up.
+type RateCandle struct {
+	Start   time.Time `json:"start"`
+	Open    int64     `json:"open"`
+	High    int64     `json:"high"`
+	Low     int64     `json:"low"`
+	Close   int64     `json:"close"`
+	Samples int64     `json:"samples"`
+}
+
+// Candle intervals served by RateCandles.
+var RateIntervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"1d":  24 * time.Hour,
+}
+
+// RecordRateSample stores the current rate. Run periodically (job rate_sample).
+func (d *DB) RecordRateSample(ctx context.Context) (RateSample, error) {
+	s, err := d.GetSystem(ctx)
+	if err != nil {
+		return RateSample{}, err
+	}
+	out := RateSample{CoinsPerUSD: s.CoinsPerUSD(), ReserveSupply: s.ReserveSupply}
+	err = d.Pool.QueryRow(ctx, `
+INSERT INTO rate_samples(coins_per_usd, reserve_supply)
+VALUES($1,$2)
+RETURNING ts
+`, out.CoinsPerUSD, out.ReserveSupply).Scan(&out.At)
+	if err != nil {
+		return RateSample{}, err
+	}
+	return out, nil
+}
+
+// RateAt returns the last sample taken at or before at (ok=false if none).
+func (d *DB) RateAt(ctx context.Context, at time.Time) (RateSample, bool, error) {
+	var out RateSample
+	err := d.Pool.QueryRow(ctx, `
+SELECT ts, coins_per_usd, reserve_supply
+FROM rate_samples
+WHERE ts <= $1
+ORDER BY ts DESC
+LIMIT 1
+`, at).Scan(&out.At, &out.CoinsPerUSD, &out.ReserveSupply)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return RateSample{}, false, nil
+		}
+		return RateSample{}, false, err
+	}
+	return out, true, nil
+}
+
+// RateCandles buckets samples into candles of the given interval, oldest
+// first, ending at to (zero = now). Buckets without samples are omitted.
+func (d *DB) RateCandles(ctx context.Context, interval time.Duration, to time.Time, limit int) ([]RateCandle, error) {
+	if interval < time.Minute || interval%time.Minute != 0 {
+		return nil, errors.New("bad interval")
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	secs := int64(interval / time.Second)
+	from := to.Add(-time.Duration(limit) * interval)
+
+	rows, err := d.Pool.Query(ctx, `
+SELECT to_timestamp(floor(extract(epoch FROM ts) / $1) * $1) AS bucket,
+       (array_agg(coins_per_usd ORDER BY ts ASC))[1],
+       MAX(coins_per_usd),
+       MIN(coins_per_usd),
+       (array_agg(coins_per_usd ORDER BY ts DESC))[1],
+       COUNT(*)
+FROM rate_samples
+WHERE ts > $2 AND ts <= $3
+GROUP BY bucket
+ORDER BY bucket ASC
+`, secs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]RateCandle, 0, limit)
+	for rows.Next() {
+		var c RateCandle
+		if err := rows.Scan(&c.Start, &c.Open, &c.High, &c.Low, &c.Close, &c.Samples); err != nil {
+			return nil, err
+		}
+		c.Start = c.Start.UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateCandles(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	// A window far in the past so concurrent real samples don't interfere.
+	base := time.Date(2001, 1, 1, 10, 0, 0, 0, time.UTC)
+	_, err := d.Pool.Exec(ctx, `DELETE FROM rate_samples WHERE ts >= $1 AND ts < $2`, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rate := range []int64{100, 98, 99, 97, 95, 96} {
+		// two 5m buckets with three samples each
+		ts := base.Add(time.Duration(i/3)*5*time.Minute + time.Duration(i%3+1)*time.Minute)
+		if _, err := d.Pool.Exec(ctx, `INSERT INTO rate_samples(ts, coins_per_usd, reserve_supply) VALUES($1,$2,0)`, ts, rate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	candles, err := d.RateCandles(ctx, 5*time.Minute, base.Add(10*time.Minute), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RateCandle{
+		{Start: base, Open: 100, High: 100, Low: 98, Close: 99, Samples: 3},
+		{Start: base.Add(5 * time.Minute), Open: 97, High: 97, Low: 95, Close: 96, Samples: 3},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("candles = %+v", candles)
+	}
+	for i := range want {
+		if !candles[i].Start.Equal(want[i].Start) || candles[i].Open != want[i].Open || candles[i].High != want[i].High ||
+			candles[i].Low != want[i].Low || candles[i].Close != want[i].Close || candles[i].Samples != want[i].Samples {
+			t.Fatalf("candle %d = %+v, want %+v", i, candles[i], want[i])
+		}
+	}
+
+	at, ok, err := d.RateAt(ctx, base.Add(4*time.Minute))
+	if err != nil || !ok || at.CoinsPerUSD != 99 {
+		t.Fatalf("RateAt = %+v %v %v", at, ok, err)
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"time"
+)
+
+// RequestChallenge is a short-lived server secret the webapp signs money
+// requests with; each client nonce can be used once per challenge, so a
+// captured request cannot be sent again (see api.ReplayGuard).
+type RequestChallenge struct {
+	ChallengeID int64     `json:"challenge_id"`
+	Secret      []byte    `json:"secret"` // base64 in JSON
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (d *DB) CreateRequestChallenge(ctx context.Context, userID int64, ttl time.Duration) (RequestChallenge, error) {
+	if userID <= 0 || ttl <= 0 {
+		return RequestChallenge{}, errors.New("bad params")
+	}
+	c := RequestChallenge{Secret: make([]byte, 32), ExpiresAt: time.Now().UTC().Add(ttl)}
+	if _, err := rand.Read(c.Secret); err != nil {
+		return RequestChallenge{}, err
+	}
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO request_challenges(user_id, secret, expires_at) VALUES($1, $2, $3)
+RETURNING challenge_id
+`, userID, c.Secret, c.ExpiresAt).Scan(&c.ChallengeID)
+	return c, err
+}
+
+// RequestChallengeSecret returns the secret of the user's unexpired
+// challenge, pgx.ErrNoRows otherwise.
+func (d *DB) RequestChallengeSecret(ctx context.Context, userID, challengeID int64) ([]byte, error) {
+	var secret []byte
+	err := d.Pool.QueryRow(ctx, `
+SELECT secret FROM request_challenges WHERE challenge_id=$1 AND user_id=$2 AND expires_at > now()
+`, challengeID, userID).Scan(&secret)
+	return secret, err
+}
+
+// UseRequestNonce records nonce under the challenge; false means it was
+// already used.
+func (d *DB) UseRequestNonce(ctx context.Context, challengeID int64, nonce string) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `
+INSERT INTO request_nonces(challenge_id, nonce) VALUES($1, $2) ON CONFLICT DO NOTHING
+`, challengeID, nonce)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PruneRequestChallenges deletes expired challenges with their nonces.
+func (d *DB) PruneRequestChallenges(ctx context.Context) (int64, error) {
+	tag, err := d.Pool.Exec(ctx, `DELETE FROM request_challenges WHERE expires_at < now() - interval '1 hour'`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
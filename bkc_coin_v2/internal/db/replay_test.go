@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestRequestNonceSingleUse(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 993_301
+	c, err := d.CreateRequestChallenge(ctx, userID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM request_challenges WHERE user_id=$1`, userID)
+	})
+	if len(c.Secret) != 32 {
+		t.Fatalf("secret of %d bytes", len(c.Secret))
+	}
+	secret, err := d.RequestChallengeSecret(ctx, userID, c.ChallengeID)
+	if err != nil || string(secret) != string(c.Secret) {
+		t.Fatalf("secret %x, %v", secret, err)
+	}
+	if _, err := d.RequestChallengeSecret(ctx, userID+1, c.ChallengeID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("other user's challenge: %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		fresh, err := d.UseRequestNonce(ctx, c.ChallengeID, "nonce-0123456789abcdef")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh != want {
+			t.Fatalf("use %d: fresh=%v, want %v", i, fresh, want)
+		}
+	}
+
+	if _, err := d.Pool.Exec(ctx, `UPDATE request_challenges SET expires_at = now() - interval '1 second' WHERE challenge_id=$1`, c.ChallengeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RequestChallengeSecret(ctx, userID, c.ChallengeID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("expired challenge: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ReserveForecast projects when the unreserved reserve runs out at the
+// recent pace. NetPerDay is the observed reserve change per day (negative
+// while draining); when no rate samples cover the window it falls back to
+// -(EmittedPerDay + BurnedPerDay).
+type ReserveForecast struct {
+	At            time.Time  `json:"at"`
+	WindowDays    int64      `json:"window_days"`
+	Available     int64      `json:"available"`
+	EmittedPerDay int64      `json:"emitted_per_day"`
+	BurnedPerDay  int64      `json:"burned_per_day"` // scheduled fee burns out of the reserve
+	NetPerDay     int64      `json:"net_per_day"`
+	Basis         string     `json:"basis"`       // samples|emission
+	RunwayDays    float64    `json:"runway_days"` // -1 = not draining
+	ExhaustsAt    *time.Time `json:"exhausts_at,omitempty"`
+	Alerted       bool       `json:"alerted,omitempty"`
+}
+
+// runwayDays is how many days available lasts at netPerDay; -1 if it never runs out.
+func runwayDays(available, netPerDay int64) float64 {
+	if netPerDay >= 0 {
+		return -1
+	}
+	if available <= 0 {
+		return 0
+	}
+	return float64(available) / float64(-netPerDay)
+}
+
+// ForecastReserve computes the runway from the last windowDays of emission,
+// burns and rate samples (job rate_sample records the reserve every minute).
+func (d *DB) ForecastReserve(ctx context.Context, windowDays int64, now time.Time) (ReserveForecast, error) {
+	if windowDays <= 0 || windowDays > 90 {
+		return ReserveForecast{}, errors.New("bad window")
+	}
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	s, err := d.GetSystem(ctx)
+	if err != nil {
+		return ReserveForecast{}, err
+	}
+	out := ReserveForecast{At: now, WindowDays: windowDays, Available: s.ReserveSupply - s.ReservedSupply}
+	from := now.Add(-time.Duration(windowDays) * 24 * time.Hour)
+
+	// Emission is counted per UTC day: average the full days of the window.
+	var emitted int64
+	if err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(SUM(minted), 0)
+FROM daily_emission
+WHERE day >= $1::date AND day < $2::date
+`, emissionDay(from), emissionDay(now)).Scan(&emitted); err != nil {
+		return ReserveForecast{}, err
+	}
+	out.EmittedPerDay = emitted / windowDays
+
+	var burned int64
+	if err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(SUM(amount), 0)
+FROM ledger
+WHERE kind='scheduled_fee_burn' AND ts >= $1 AND ts < $2
+`, from, now).Scan(&burned); err != nil {
+		return ReserveForecast{}, err
+	}
+	out.BurnedPerDay = burned / windowDays
+
+	var sampleAt time.Time
+	var sampleReserve int64
+	err = d.Pool.QueryRow(ctx, `
+SELECT ts, reserve_supply
+FROM rate_samples
+WHERE ts >= $1
+ORDER BY ts ASC
+LIMIT 1
+`, from).Scan(&sampleAt, &sampleReserve)
+	switch {
+	case err == nil && now.Sub(sampleAt) >= time.Hour:
+		days := now.Sub(sampleAt).Hours() / 24
+		out.NetPerDay = int64(math.Round(float64(s.ReserveSupply-sampleReserve) / days))
+		out.Basis = "samples"
+	case err == nil || errors.Is(err, pgx.ErrNoRows):
+		out.NetPerDay = -(out.EmittedPerDay + out.BurnedPerDay)
+		out.Basis = "emission"
+	default:
+		return ReserveForecast{}, err
+	}
+
+	out.RunwayDays = runwayDays(out.Available, out.NetPerDay)
+	if out.RunwayDays >= 0 {
+		at := now.Add(time.Duration(out.RunwayDays * float64(24*time.Hour)))
+		out.ExhaustsAt = &at
+	}
+	return out, nil
+}
+
+// RecordReserveForecast stores a forecast for the admin history.
+func (d *DB) RecordReserveForecast(ctx context.Context, f ReserveForecast) error {
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO reserve_forecasts(ts, available, emitted_per_day, burned_per_day, net_per_day, runway_days, alerted)
+VALUES($1,$2,$3,$4,$5,$6,$7)
+`, f.At, f.Available, f.EmittedPerDay, f.BurnedPerDay, f.NetPerDay, f.RunwayDays, f.Alerted)
+	return err
+}
+
+// LastReserveAlert returns when a low-runway alert was last sent (ok=false if never).
+func (d *DB) LastReserveAlert(ctx context.Context) (time.Time, bool, error) {
+	var at *time.Time
+	if err := d.Pool.QueryRow(ctx, `SELECT MAX(ts) FROM reserve_forecasts WHERE alerted`).Scan(&at); err != nil {
+		return time.Time{}, false, err
+	}
+	if at == nil {
+		return time.Time{}, false, nil
+	}
+	return *at, true, nil
+}
+
+// ListReserveForecasts returns recorded forecasts, newest first.
+func (d *DB) ListReserveForecasts(ctx context.Context, limit int) ([]ReserveForecast, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 48
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT ts, available, emitted_per_day, burned_per_day, net_per_day, runway_days, alerted
+FROM reserve_forecasts
+ORDER BY ts DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ReserveForecast, 0, limit)
+	for rows.Next() {
+		var f ReserveForecast
+		if err := rows.Scan(&f.At, &f.Available, &f.EmittedPerDay, &f.BurnedPerDay, &f.NetPerDay, &f.RunwayDays, &f.Alerted); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,21 @@
+package db
+
+import "testing"
+
+func TestRunwayDays(t *testing.T) {
+	cases := []struct {
+		available, net int64
+		want           float64
+	}{
+		{1_000_000, 0, -1},
+		{1_000_000, 5_000, -1},
+		{1_000_000, -10_000, 100},
+		{0, -10_000, 0},
+		{-50, -10_000, 0},
+	}
+	for _, c := range cases {
+		if got := runwayDays(c.available, c.net); got != c.want {
+			t.Fatalf("runwayDays(%d, %d) = %v, want %v", c.available, c.net, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Proof-of-reserves reports (see package reserves). The signed report is
+// stored verbatim; the Merkle tree of user balances is kept one row per
+// level so a user's proof reads a handful of 32-byte slices.
+
+// ReserveReport is a stored, signed report.
+type ReserveReport struct {
+	ReportID    int64           `json:"report_id"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Report      json.RawMessage `json:"report"`     // the exact signed bytes
+	Signature   string          `json:"signature"`  // base64 ed25519 over Report
+	PublicKey   string          `json:"public_key"` // hex
+	MerkleRoot  string          `json:"merkle_root"`
+}
+
+// ReserveLeaf is one user's entry in a report's tree.
+type ReserveLeaf struct {
+	UserID int64
+	Coins  int64
+}
+
+// LiabilityTotals sum the users included in a snapshot.
+type LiabilityTotals struct {
+	Users       int64
+	Coins       int64
+	Frozen      int64
+	CoinsPerUSD int64
+}
+
+// ReserveLiabilities streams every user owed coins (available plus frozen),
+// ordered by user id, from one consistent snapshot.
+func (d *DB) ReserveLiabilities(ctx context.Context, fn func(l ReserveLeaf) error) (LiabilityTotals, error) {
+	var t LiabilityTotals
+	tx, err := d.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback(ctx)
+
+	s, err := getSystem(ctx, tx)
+	if err != nil {
+		return t, err
+	}
+	t.CoinsPerUSD = s.CoinsPerUSD()
+	rows, err := tx.Query(ctx, `
+SELECT user_id, balance + frozen_balance, frozen_balance
+FROM users
+WHERE balance + frozen_balance > 0 AND merged_into IS NULL
+ORDER BY user_id
+`)
+	if err != nil {
+		return t, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l ReserveLeaf
+		var frozen int64
+		if err := rows.Scan(&l.UserID, &l.Coins, &frozen); err != nil {
+			return t, err
+		}
+		t.Users++
+		t.Coins += l.Coins
+		t.Frozen += frozen
+		if err := fn(l); err != nil {
+			return t, err
+		}
+	}
+	return t, rows.Err()
+}
+
+// SaveReserveReport stores a report with its leaves (in tree order) and tree
+// levels, and drops the trees of all but the newest keep reports; the signed
+// reports themselves are kept.
+func (d *DB) SaveReserveReport(ctx context.Context, r ReserveReport, secret []byte, leaves []ReserveLeaf, levels [][]byte, keep int) (int64, error) {
+	var id int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `
+INSERT INTO reserve_reports(generated_at, report, signature, public_key, merkle_root, secret)
+VALUES($1, $2, $3, $4, $5, $6)
+RETURNING report_id
+`, r.GeneratedAt, string(r.Report), r.Signature, r.PublicKey, r.MerkleRoot, secret).Scan(&id); err != nil {
+			return err
+		}
+		i := 0
+		if _, err := tx.CopyFrom(ctx,
+			pgx.Identifier{"reserve_leaves"},
+			[]string{"report_id", "user_id", "idx", "coins"},
+			pgx.CopyFromFunc(func() ([]any, error) {
+				if i >= len(leaves) {
+					return nil, nil
+				}
+				l := leaves[i]
+				i++
+				return []any{id, l.UserID, int64(i - 1), l.Coins}, nil
+			}),
+		); err != nil {
+			return err
+		}
+		for lvl, hashes := range levels {
+			if _, err := tx.Exec(ctx, `INSERT INTO reserve_tree(report_id, level, hashes) VALUES($1, $2, $3)`, id, lvl, hashes); err != nil {
+				return err
+			}
+		}
+		if keep > 0 {
+			if _, err := tx.Exec(ctx, `
+WITH old AS (SELECT report_id FROM reserve_reports ORDER BY report_id DESC OFFSET $1)
+, l AS (DELETE FROM reserve_leaves WHERE report_id IN (SELECT report_id FROM old))
+DELETE FROM reserve_tree WHERE report_id IN (SELECT report_id FROM old)
+`, keep); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+const reserveReportCols = `report_id, generated_at, report, signature, public_key, merkle_root`
+
+func scanReserveReport(row pgx.Row) (ReserveReport, error) {
+	var r ReserveReport
+	var report string
+	err := row.Scan(&r.ReportID, &r.GeneratedAt, &report, &r.Signature, &r.PublicKey, &r.MerkleRoot)
+	r.Report = json.RawMessage(report)
+	return r, err
+}
+
+// GetReserveReport loads a report; id 0 is the latest.
+func (d *DB) GetReserveReport(ctx context.Context, id int64) (ReserveReport, error) {
+	return scanReserveReport(d.Pool.QueryRow(ctx, `
+SELECT `+reserveReportCols+` FROM reserve_reports
+WHERE $1 = 0 OR report_id = $1
+ORDER BY report_id DESC LIMIT 1
+`, id))
+}
+
+// ListReserveReports returns the newest reports first.
+func (d *DB) ListReserveReports(ctx context.Context, limit int) ([]ReserveReport, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+	rows, err := d.Pool.Query(ctx, `SELECT `+reserveReportCols+` FROM reserve_reports ORDER BY report_id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ReserveReport
+	for rows.Next() {
+		r, err := scanReserveReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ReserveLeafOf finds the user's leaf in a report and the report's secret
+// (to derive the user's salt). pgx.ErrNoRows means the user is not in the
+// report or its tree was pruned.
+func (d *DB) ReserveLeafOf(ctx context.Context, reportID, userID int64) (idx, coins int64, secret []byte, err error) {
+	err = d.Pool.QueryRow(ctx, `
+SELECT l.idx, l.coins, r.secret FROM reserve_leaves l JOIN reserve_reports r ON r.report_id = l.report_id
+WHERE l.report_id=$1 AND l.user_id=$2
+`, reportID, userID).Scan(&idx, &coins, &secret)
+	return idx, coins, secret, err
+}
+
+// ReserveSiblings returns, for each level below the root, the hash next to
+// the path of leaf idx, or nil where there is none.
+func (d *DB) ReserveSiblings(ctx context.Context, reportID, idx int64) ([][]byte, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT level, length(hashes) / 32 FROM reserve_tree WHERE report_id=$1 ORDER BY level
+`, reportID)
+	if err != nil {
+		return nil, err
+	}
+	var sizes []int64
+	for rows.Next() {
+		var level int
+		var n int64
+		if err := rows.Scan(&level, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sizes = append(sizes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for level := 0; level < len(sizes)-1; level++ {
+		var sib []byte
+		if s := idx ^ 1; s < sizes[level] {
+			// hashes is stored uncompressed, so substring reads only this slice.
+			if err := d.Pool.QueryRow(ctx, `
+SELECT substring(hashes FROM $3::int * 32 + 1 FOR 32) FROM reserve_tree WHERE report_id=$1 AND level=$2
+`, reportID, level, s).Scan(&sib); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, sib)
+		idx /= 2
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReserveReportProof(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	var leaves []ReserveLeaf
+	totals, err := d.ReserveLiabilities(ctx, func(l ReserveLeaf) error {
+		if n := len(leaves); n > 0 && leaves[n-1].UserID >= l.UserID {
+			t.Fatalf("leaves out of order: %d after %d", l.UserID, leaves[n-1].UserID)
+		}
+		leaves = append(leaves, l)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Users != int64(len(leaves)) || totals.Frozen > totals.Coins {
+		t.Fatalf("totals %+v for %d leaves", totals, len(leaves))
+	}
+
+	// Three fake levels: 3 leaves -> 2 -> root.
+	leaves = []ReserveLeaf{{UserID: 1, Coins: 10}, {UserID: 2, Coins: 20}, {UserID: 3, Coins: 30}}
+	hash := func(b byte) []byte {
+		h := make([]byte, 32)
+		h[0] = b
+		return h
+	}
+	levels := [][]byte{
+		append(append(hash(1), hash(2)...), hash(3)...),
+		append(hash(4), hash(3)...),
+		hash(5),
+	}
+	id, err := d.SaveReserveReport(ctx, ReserveReport{
+		GeneratedAt: time.Now(),
+		Report:      []byte(`{"b":1,"a":2}`),
+		Signature:   "sig",
+		PublicKey:   "key",
+		MerkleRoot:  "root",
+	}, []byte("secret"), leaves, levels, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := d.GetReserveReport(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rep.Report) != `{"b":1,"a":2}` {
+		t.Fatalf("signed bytes changed: %s", rep.Report)
+	}
+	idx, coins, secret, err := d.ReserveLeafOf(ctx, id, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 2 || coins != 30 || string(secret) != "secret" {
+		t.Fatalf("leaf idx=%d coins=%d secret=%q", idx, coins, secret)
+	}
+	sib, err := d.ReserveSiblings(ctx, id, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sib) != 2 || sib[0] != nil || sib[1][0] != 4 {
+		t.Fatalf("siblings %x", sib)
+	}
+	if sib, err = d.ReserveSiblings(ctx, id, 0); err != nil || sib[0][0] != 2 || sib[1][0] != 3 {
+		t.Fatalf("siblings %x, %v", sib, err)
+	}
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Cohort retention. Users are grouped by signup day (UTC); a user is
+// retained on day N if they were active on cohort day + N, where active
+// means they tapped (user_daily) or started a ledger movement (from_id).
+// The retention job recomputes cohorts whose D30 window is still open and
+// backfills missing ones, so a cohort stops changing once D30 has passed.
+
+// RetentionDays are the days retention is measured on.
+var RetentionDays = []int{1, 7, 30}
+
+const retentionBatch = 62 // cohort days per run: the open month plus backfill
+
+// CohortRetention is one signup cohort. A day is nil until it is over.
+type CohortRetention struct {
+	Cohort     time.Time `json:"cohort"`
+	Users      int64     `json:"users"`
+	D1         *int64    `json:"d1"`
+	D7         *int64    `json:"d7"`
+	D30        *int64    `json:"d30"`
+	D1Pct      *float64  `json:"d1_pct"`
+	D7Pct      *float64  `json:"d7_pct"`
+	D30Pct     *float64  `json:"d30_pct"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// retentionClosed reports whether day n of cohort is over at now.
+func retentionClosed(cohort time.Time, n int, now time.Time) bool {
+	return !cohort.AddDate(0, 0, n+1).After(now)
+}
+
+// retentionPct is kept*100/users, nil while the day is open.
+func retentionPct(kept *int64, users int64) *float64 {
+	if kept == nil || users == 0 {
+		return nil
+	}
+	pct := float64(*kept) * 100 / float64(users)
+	return &pct
+}
+
+func (c *CohortRetention) fill() {
+	c.D1Pct = retentionPct(c.D1, c.Users)
+	c.D7Pct = retentionPct(c.D7, c.Users)
+	c.D30Pct = retentionPct(c.D30, c.Users)
+}
+
+// ComputeRetention recomputes the open and missing cohorts as of now
+// (scheduled job). Returns cohorts written.
+func (d *DB) ComputeRetention(ctx context.Context, now time.Time) (int64, error) {
+	now = now.UTC()
+	rows, err := d.Pool.Query(ctx, `
+SELECT c.day FROM (
+  SELECT DISTINCT (created_at AT TIME ZONE 'UTC')::date AS day FROM users WHERE merged_into IS NULL
+) c
+LEFT JOIN cohort_retention r ON r.cohort_day = c.day
+WHERE r.cohort_day IS NULL OR r.d30 IS NULL
+ORDER BY c.day DESC
+LIMIT $1
+`, retentionBatch)
+	if err != nil {
+		return 0, err
+	}
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, day := range days {
+		c := CohortRetention{Cohort: day}
+		var kept [3]int64
+		err := d.Pool.QueryRow(ctx, `
+WITH c AS (
+  SELECT user_id FROM users
+  WHERE merged_into IS NULL AND created_at >= $1 AND created_at < $1 + interval '1 day'
+), act AS (
+  SELECT user_id, day FROM user_daily
+  WHERE tapped > 0 AND user_id IN (SELECT user_id FROM c) AND day = ANY($2::date[])
+  UNION
+  SELECT l.from_id, (l.ts AT TIME ZONE 'UTC')::date FROM ledger l
+  WHERE l.from_id IN (SELECT user_id FROM c) AND (l.ts AT TIME ZONE 'UTC')::date = ANY($2::date[])
+)
+SELECT (SELECT COUNT(*) FROM c),
+  (SELECT COUNT(*) FROM act WHERE day = $2[1]),
+  (SELECT COUNT(*) FROM act WHERE day = $2[2]),
+  (SELECT COUNT(*) FROM act WHERE day = $2[3])
+`, day, []time.Time{day.AddDate(0, 0, 1), day.AddDate(0, 0, 7), day.AddDate(0, 0, 30)}).Scan(&c.Users, &kept[0], &kept[1], &kept[2])
+		if err != nil {
+			return n, err
+		}
+		for i, p := range []**int64{&c.D1, &c.D7, &c.D30} {
+			if retentionClosed(day, RetentionDays[i], now) {
+				*p = &kept[i]
+			}
+		}
+		if _, err := d.Pool.Exec(ctx, `
+INSERT INTO cohort_retention (cohort_day, users, d1, d7, d30, computed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (cohort_day) DO UPDATE
+SET users=EXCLUDED.users, d1=EXCLUDED.d1, d7=EXCLUDED.d7, d30=EXCLUDED.d30, computed_at=EXCLUDED.computed_at
+`, day, c.Users, c.D1, c.D7, c.D30, now); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ListRetention returns the cohorts from from to to (inclusive), oldest first.
+func (d *DB) ListRetention(ctx context.Context, from, to time.Time) ([]CohortRetention, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT cohort_day, users, d1, d7, d30, computed_at
+FROM cohort_retention
+WHERE cohort_day BETWEEN $1::date AND $2::date
+ORDER BY cohort_day
+`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CohortRetention{}
+	for rows.Next() {
+		var c CohortRetention
+		if err := rows.Scan(&c.Cohort, &c.Users, &c.D1, &c.D7, &c.D30, &c.ComputedAt); err != nil {
+			return nil, err
+		}
+		c.fill()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// RetentionSummary is retention over many cohorts, weighted by cohort size
+// and counting only cohorts whose day is over.
+type RetentionSummary struct {
+	Cohorts int64    `json:"cohorts"`
+	Users   int64    `json:"users"`
+	D1Pct   *float64 `json:"d1_pct"`
+	D7Pct   *float64 `json:"d7_pct"`
+	D30Pct  *float64 `json:"d30_pct"`
+}
+
+// SummarizeRetention weights each day's retention by the users of the
+// cohorts where it is known.
+func SummarizeRetention(cohorts []CohortRetention) RetentionSummary {
+	s := RetentionSummary{Cohorts: int64(len(cohorts))}
+	var kept, users [3]int64
+	for _, c := range cohorts {
+		s.Users += c.Users
+		for i, v := range []*int64{c.D1, c.D7, c.D30} {
+			if v != nil {
+				kept[i] += *v
+				users[i] += c.Users
+			}
+		}
+	}
+	for i, p := range []**float64{&s.D1Pct, &s.D7Pct, &s.D30Pct} {
+		if users[i] > 0 {
+			*p = retentionPct(&kept[i], users[i])
+		}
+	}
+	return s
+}
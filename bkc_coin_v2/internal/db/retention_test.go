@@ -0,0 +1,42 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionClosed(t *testing.T) {
+	cohort := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		n    int
+		now  time.Time
+		want bool
+	}{
+		{1, time.Date(2025, 3, 2, 23, 59, 0, 0, time.UTC), false}, // day 1 still running
+		{1, time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), true},
+		{7, time.Date(2025, 3, 8, 12, 0, 0, 0, time.UTC), false},
+		{30, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, c := range cases {
+		if got := retentionClosed(cohort, c.n, c.now); got != c.want {
+			t.Errorf("D%d at %s: %v, want %v", c.n, c.now, got, c.want)
+		}
+	}
+}
+
+func TestSummarizeRetention(t *testing.T) {
+	n := func(v int64) *int64 { return &v }
+	s := SummarizeRetention([]CohortRetention{
+		{Users: 100, D1: n(40), D7: n(20), D30: n(10)},
+		{Users: 300, D1: n(60), D7: n(30)}, // D30 still open
+	})
+	if s.Cohorts != 2 || s.Users != 400 {
+		t.Fatalf("%+v", s)
+	}
+	if *s.D1Pct != 25 || *s.D7Pct != 12.5 || *s.D30Pct != 10 {
+		t.Fatalf("d1 %v d7 %v d30 %v", *s.D1Pct, *s.D7Pct, *s.D30Pct)
+	}
+	if s := SummarizeRetention(nil); s.D1Pct != nil {
+		t.Fatalf("empty summary has d1 %v", *s.D1Pct)
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Transaction retry bounds for RetryTx.
+const (
+	txMaxAttempts = 5
+	txRetryBase   = 10 * time.Millisecond
+	txRetryCap    = 250 * time.Millisecond
+)
+
+// IsRetryable reports whether err means Postgres aborted the transaction
+// because of concurrent ones: a serialization failure (40001) or a deadlock
+// (40P01). The transaction was rolled back and is safe to run again. Errors
+// after a commit may have succeeded (a dropped connection) never are.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
+
+// RetryTx is WithTx that runs fn again in a new transaction when it loses to
+// a concurrent one (see IsRetryable), up to txMaxAttempts times with jittered
+// exponential backoff. fn may run more than once: it must reset anything it
+// writes outside tx at its start.
+func (d *DB) RetryTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := d.WithTx(ctx, fn)
+		if err == nil || attempt == txMaxAttempts || !IsRetryable(err) {
+			return err
+		}
+		t := time.NewTimer(retryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// retryDelay is a random wait in [0, min(cap, base·2^(attempt-1))]: full
+// jitter, so transactions that collided once do not collide again in step.
+func retryDelay(attempt int) time.Duration {
+	max := txRetryBase << (attempt - 1)
+	if max > txRetryCap || max <= 0 {
+		max = txRetryCap
+	}
+	return rand.N(max + 1)
+}
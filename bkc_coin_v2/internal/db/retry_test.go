@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for err, want := range map[error]bool{
+		&pgconn.PgError{Code: "40001"}:                          true,
+		&pgconn.PgError{Code: "40P01"}:                          true,
+		fmt.Errorf("flush: %w", &pgconn.PgError{Code: "40001"}): true,
+		&pgconn.PgError{Code: "23505"}:                          false,
+		ErrNotEnough:                                            false,
+		context.DeadlineExceeded:                                false,
+	} {
+		if got := IsRetryable(err); got != want {
+			t.Errorf("%v: got %v", err, got)
+		}
+	}
+	for attempt := 1; attempt <= 40; attempt++ {
+		if d := retryDelay(attempt); d < 0 || d > txRetryCap {
+			t.Fatalf("attempt %d: delay %v", attempt, d)
+		}
+	}
+}
+
+// Two transfers that lock the same users in opposite order deadlock; Postgres
+// aborts one of them and RetryTx runs it again.
+func TestRetryTxDeadlock(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const a, b = 9_300_900_000, 9_300_900_001
+	seedMoneyUsers(t, d, 1_000, a, b)
+
+	var calls atomic.Int64
+	var locked sync.WaitGroup
+	locked.Add(2)
+	move := func(from, to int64) error {
+		first := true
+		return d.RetryTx(ctx, func(tx pgx.Tx) error {
+			calls.Add(1)
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance - 10 WHERE user_id=$1`, from); err != nil {
+				return err
+			}
+			if first {
+				first = false
+				locked.Done()
+				locked.Wait()
+			}
+			if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + 10 WHERE user_id=$1`, to); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount) VALUES('transfer', $1, $2, 10)`, from, to)
+			return err
+		})
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- move(a, b) }()
+	go func() { errs <- move(b, a) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() < 3 {
+		t.Fatalf("%d attempts: no deadlock was retried", calls.Load())
+	}
+	for _, id := range []int64{a, b} {
+		u, err := d.GetUser(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Balance != 1_000 {
+			t.Fatalf("user %d: balance %d", id, u.Balance)
+		}
+		checkLedger(t, d, id, 1_000)
+	}
+}
+
+func TestRetryTxGivesUp(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	conflict := &pgconn.PgError{Code: "40001"}
+	var calls int
+	err := d.RetryTx(ctx, func(pgx.Tx) error {
+		calls++
+		return conflict
+	})
+	if !errors.Is(err, conflict) || calls != txMaxAttempts {
+		t.Fatalf("%d calls, %v", calls, err)
+	}
+
+	calls = 0
+	err = d.RetryTx(ctx, func(pgx.Tx) error {
+		calls++
+		return ErrNotEnough
+	})
+	if !errors.Is(err, ErrNotEnough) || calls != 1 {
+		t.Fatalf("non-retryable: %d calls, %v", calls, err)
+	}
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LoginGeo is a country/ASN pair a user has logged in from.
+type LoginGeo struct {
+	Country   string    `json:"country"`
+	ASN       int64     `json:"asn"`
+	Logins    int64     `json:"logins"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// RiskPolicy tunes withdrawal risk scoring.
+type RiskPolicy struct {
+	HoldScore   int   // withdrawals scoring at least this are held
+	LargeAmount int64 // amounts from this size add to the score
+}
+
+// WithdrawalRisk is the outcome of scoring a withdrawal request.
+type WithdrawalRisk struct {
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+	Hold    bool     `json:"hold"`
+}
+
+// riskSignals are the facts a withdrawal is scored on.
+type riskSignals struct {
+	HasHistory  bool // the user has logged in from at least one known location
+	GeoUnknown  bool // the request's location could not be resolved
+	NewCountry  bool
+	NewASN      bool
+	LargeAmount bool
+	FreshSess   bool // the session was opened less than an hour ago
+}
+
+const freshSessionAge = time.Hour
+
+// scoreWithdrawalRisk weighs the signals. A new country alone is enough to
+// hold with the default policy; a new ASN needs another signal on top.
+func scoreWithdrawalRisk(s riskSignals, holdScore int) WithdrawalRisk {
+	r := WithdrawalRisk{Reasons: []string{}}
+	add := func(points int, reason string) {
+		r.Score += points
+		r.Reasons = append(r.Reasons, reason)
+	}
+	if s.HasHistory {
+		switch {
+		case s.GeoUnknown:
+			add(20, "geo_unknown")
+		case s.NewCountry:
+			add(60, "new_country")
+		case s.NewASN:
+			add(30, "new_asn")
+		}
+	}
+	if s.LargeAmount {
+		add(20, "large_amount")
+	}
+	if s.FreshSess {
+		add(15, "fresh_session")
+	}
+	r.Hold = holdScore > 0 && r.Score >= holdScore
+	return r
+}
+
+const upsertLoginGeoSQL = `
+INSERT INTO login_geos (user_id, country, asn)
+VALUES ($1,$2,$3)
+ON CONFLICT (user_id, country, asn) DO UPDATE SET logins=login_geos.logins+1, last_seen=now()
+`
+
+// RecordLoginGeo remembers that the user logged in from country/asn.
+// Unknown locations are not recorded.
+func (d *DB) RecordLoginGeo(ctx context.Context, userID int64, country string, asn int64) error {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if userID <= 0 || (country == "" && asn == 0) {
+		return nil
+	}
+	_, err := d.Pool.Exec(ctx, upsertLoginGeoSQL, userID, country, asn)
+	return err
+}
+
+// ListLoginGeos returns the locations the user has logged in from, most recent first.
+func (d *DB) ListLoginGeos(ctx context.Context, userID int64) ([]LoginGeo, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT country, asn, logins, first_seen, last_seen
+FROM login_geos
+WHERE user_id=$1
+ORDER BY last_seen DESC
+LIMIT 100
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LoginGeo
+	for rows.Next() {
+		var g LoginGeo
+		if err := rows.Scan(&g.Country, &g.ASN, &g.Logins, &g.FirstSeen, &g.LastSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// assessWithdrawalRiskTx scores a withdrawal of amount made from country/asn
+// against the user's login history.
+func assessWithdrawalRiskTx(ctx context.Context, tx pgx.Tx, userID, amount int64, country string, asn int64, sessionAge time.Duration, p RiskPolicy) (WithdrawalRisk, error) {
+	s := riskSignals{
+		GeoUnknown:  country == "" && asn == 0,
+		LargeAmount: p.LargeAmount > 0 && amount >= p.LargeAmount,
+		FreshSess:   sessionAge >= 0 && sessionAge < freshSessionAge,
+	}
+	var known, sameCountry, sameASN bool
+	err := tx.QueryRow(ctx, `
+SELECT COUNT(*) > 0,
+       COALESCE(bool_or(country=$2), false),
+       COALESCE(bool_or(asn=$3), false)
+FROM login_geos
+WHERE user_id=$1
+`, userID, country, asn).Scan(&known, &sameCountry, &sameASN)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return WithdrawalRisk{}, err
+	}
+	s.HasHistory = known
+	s.NewCountry = country != "" && !sameCountry
+	s.NewASN = asn != 0 && !sameASN
+	return scoreWithdrawalRisk(s, p.HoldScore), nil
+}
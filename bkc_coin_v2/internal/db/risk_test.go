@@ -0,0 +1,25 @@
+package db
+
+import "testing"
+
+func TestScoreWithdrawalRisk(t *testing.T) {
+	cases := []struct {
+		name string
+		s    riskSignals
+		hold bool
+	}{
+		{"first login ever", riskSignals{NewCountry: true, NewASN: true}, false},
+		{"known location", riskSignals{HasHistory: true}, false},
+		{"new country", riskSignals{HasHistory: true, NewCountry: true, NewASN: true}, true},
+		{"new asn only", riskSignals{HasHistory: true, NewASN: true}, false},
+		{"new asn, large amount", riskSignals{HasHistory: true, NewASN: true, LargeAmount: true}, true},
+		{"unknown geo, fresh session", riskSignals{HasHistory: true, GeoUnknown: true, FreshSess: true}, false},
+		{"unknown geo, fresh session, large", riskSignals{HasHistory: true, GeoUnknown: true, FreshSess: true, LargeAmount: true}, true},
+	}
+	for _, c := range cases {
+		r := scoreWithdrawalRisk(c.s, 50)
+		if r.Hold != c.hold {
+			t.Fatalf("%s: hold=%v score=%d reasons=%v; want hold=%v", c.name, r.Hold, r.Score, r.Reasons, c.hold)
+		}
+	}
+}
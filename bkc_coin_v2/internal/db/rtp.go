@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GameBook names the ledger kinds of a game: what players stake and what
+// they get back. RTP = paid / wagered.
+type GameBook struct {
+	Game   string   `json:"game"`
+	Module string   `json:"module"` // kill switch of the game
+	Stakes []string `json:"stakes"`
+	Wins   []string `json:"wins"`
+}
+
+// GameBooks are the games the RTP monitor watches.
+var GameBooks = []GameBook{
+	{
+		Game:   "crash",
+		Module: ModuleGameCrash,
+		Stakes: []string{"crash_bet", "crash_insurance_premium"},
+		Wins:   []string{"crash_win", "crash_insurance_payout"},
+	},
+}
+
+// GameBookOf returns the book of game.
+func GameBookOf(game string) (GameBook, bool) {
+	for _, b := range GameBooks {
+		if b.Game == game {
+			return b, true
+		}
+	}
+	return GameBook{}, false
+}
+
+// RTPWindows are the rolling windows RTP is measured over.
+var RTPWindows = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// GameRTP is the realized RTP of a game over one window. Since is the start
+// of the window, later than WindowHours ago if the counting was reset.
+// HouseEdge is 1 - RTP.
+type GameRTP struct {
+	Game        string    `json:"game"`
+	WindowHours int64     `json:"window_hours"`
+	Since       time.Time `json:"since"`
+	Wagered     int64     `json:"wagered"`
+	Paid        int64     `json:"paid"`
+	Bets        int64     `json:"bets"`
+	RTP         float64   `json:"rtp"` // 0 when nothing was wagered
+	HouseEdge   float64   `json:"house_edge"`
+}
+
+// GameRTPWindows measures game over every RTPWindows window ending at now.
+// Ledger rows before floor are not counted (a zero floor counts everything),
+// so re-enabling a halted game starts its windows afresh.
+func (d *DB) GameRTPWindows(ctx context.Context, game string, now, floor time.Time) ([]GameRTP, error) {
+	b, ok := GameBookOf(game)
+	if !ok {
+		return nil, errors.New("bad game")
+	}
+	out := make([]GameRTP, 0, len(RTPWindows))
+	for _, w := range RTPWindows {
+		r := GameRTP{Game: game, WindowHours: int64(w / time.Hour), Since: now.Add(-w)}
+		if floor.After(r.Since) {
+			r.Since = floor
+		}
+		if err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(SUM(amount) FILTER (WHERE kind = ANY($1::text[])), 0),
+       COALESCE(SUM(amount) FILTER (WHERE kind = ANY($2::text[])), 0),
+       COUNT(*) FILTER (WHERE kind = ANY($1::text[]))
+FROM ledger
+WHERE kind = ANY($1::text[] || $2::text[]) AND ts >= $3 AND ts < $4
+`, b.Stakes, b.Wins, r.Since, now).Scan(&r.Wagered, &r.Paid, &r.Bets); err != nil {
+			return nil, err
+		}
+		if r.Wagered > 0 {
+			r.RTP = float64(r.Paid) / float64(r.Wagered)
+			r.HouseEdge = 1 - r.RTP
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// GameRTPPoint is one bucket of the RTP chart.
+type GameRTPPoint struct {
+	At      time.Time `json:"at"`
+	Wagered int64     `json:"wagered"`
+	Paid    int64     `json:"paid"`
+	RTP     float64   `json:"rtp"`
+}
+
+// GameRTPSeries returns the RTP of game per bucket since since, oldest
+// first; empty buckets are skipped.
+func (d *DB) GameRTPSeries(ctx context.Context, game string, since time.Time, bucket time.Duration) ([]GameRTPPoint, error) {
+	b, ok := GameBookOf(game)
+	if !ok {
+		return nil, errors.New("bad game")
+	}
+	if bucket < time.Minute {
+		return nil, errors.New("bad bucket")
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT to_timestamp(floor(extract(epoch FROM ts) / $4) * $4) AS at,
+       COALESCE(SUM(amount) FILTER (WHERE kind = ANY($1::text[])), 0),
+       COALESCE(SUM(amount) FILTER (WHERE kind = ANY($2::text[])), 0)
+FROM ledger
+WHERE kind = ANY($1::text[] || $2::text[]) AND ts >= $3
+GROUP BY at
+ORDER BY at
+`, b.Stakes, b.Wins, since, int64(bucket/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []GameRTPPoint
+	for rows.Next() {
+		var p GameRTPPoint
+		if err := rows.Scan(&p.At, &p.Wagered, &p.Paid); err != nil {
+			return nil, err
+		}
+		if p.Wagered > 0 {
+			p.RTP = float64(p.Paid) / float64(p.Wagered)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
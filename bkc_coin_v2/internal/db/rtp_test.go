@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGameRTPWindows(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM ledger WHERE meta->>'test' = 'rtp'`)
+	})
+
+	if _, err := d.GameRTPWindows(ctx, "roulette", time.Now(), time.Time{}); err == nil {
+		t.Fatal("unknown game accepted")
+	}
+
+	floor := time.Now().Add(-time.Second)
+	for _, row := range []struct {
+		kind   string
+		amount int64
+	}{{"crash_bet", 600}, {"crash_bet", 400}, {"crash_insurance_premium", 100}, {"crash_win", 880}, {"crash_insurance_payout", 110}} {
+		if _, err := d.Pool.Exec(ctx, `INSERT INTO ledger(kind, amount, meta) VALUES($1, $2, '{"test":"rtp"}'::jsonb)`, row.kind, row.amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	windows, err := d.GameRTPWindows(ctx, "crash", time.Now().Add(time.Second), floor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != len(RTPWindows) {
+		t.Fatalf("%d windows, want %d", len(windows), len(RTPWindows))
+	}
+	for _, w := range windows {
+		if !w.Since.Equal(floor) {
+			t.Fatalf("%dh window since %v, want the floor %v", w.WindowHours, w.Since, floor)
+		}
+		if w.Wagered != 1_100 || w.Paid != 990 || w.Bets != 3 || w.RTP != 0.9 {
+			t.Fatalf("%dh window %+v", w.WindowHours, w)
+		}
+	}
+
+	// Counting restarts at the floor.
+	windows, err = d.GameRTPWindows(ctx, "crash", time.Now().Add(time.Second), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows[0].Wagered != 0 || windows[0].RTP != 0 {
+		t.Fatalf("window after the floor %+v", windows[0])
+	}
+}
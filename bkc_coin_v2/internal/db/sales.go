@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrSaleLimit means a sale would exceed the user's daily sale limit.
+var ErrSaleLimit = errors.New("daily sale limit reached")
+
+// SalePolicy applies to liquidity exits: withdrawals and exchange listings
+// (coins sold for off-platform money). Sales are counted per user and UTC day
+// in user_daily.sold.
+type SalePolicy struct {
+	DailyLimit int64 // coins a user may sell per UTC day; 0 = unlimited
+	TaxBP      int64 // sale tax, basis points of the amount sold
+	TaxBurnBP  int64 // share of the tax burned; the rest goes to the stabilization fund
+}
+
+// SaleTax is the tax on one sale, split into its burned and fund parts.
+type SaleTax struct {
+	Tax  int64 `json:"tax"`
+	Burn int64 `json:"burn"`
+	Fund int64 `json:"fund"`
+}
+
+// Tax computes the tax on amount. Rounds down in the seller's favour.
+func (p SalePolicy) Tax(amount int64) SaleTax {
+	t := SaleTax{Tax: interestFromBP(amount, p.TaxBP)}
+	t.Burn = interestFromBP(t.Tax, p.TaxBurnBP)
+	t.Fund = t.Tax - t.Burn
+	return t
+}
+
+// SaleQuota is the user's daily sale counter.
+type SaleQuota struct {
+	Day       string `json:"day"`
+	Sold      int64  `json:"sold"`
+	Limit     int64  `json:"limit"`     // 0 = unlimited
+	Remaining int64  `json:"remaining"` // -1 = unlimited
+}
+
+func (p SalePolicy) quota(day string, sold int64) SaleQuota {
+	q := SaleQuota{Day: day, Sold: sold, Limit: p.DailyLimit, Remaining: -1}
+	if p.DailyLimit > 0 {
+		q.Remaining = max(p.DailyLimit-sold, 0)
+	}
+	return q
+}
+
+// GetSaleQuota returns today's sale counter for userID.
+func (d *DB) GetSaleQuota(ctx context.Context, userID int64, p SalePolicy) (SaleQuota, error) {
+	day := emissionDay(time.Now())
+	var sold int64
+	err := d.Pool.QueryRow(ctx, `SELECT sold FROM user_daily WHERE user_id=$1 AND day=$2::date`, userID, day).Scan(&sold)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return SaleQuota{}, err
+	}
+	return p.quota(day, sold), nil
+}
+
+// countSaleTx adds amount to the user's sales for today, failing with
+// ErrSaleLimit if the daily limit would be exceeded. Returns the day charged.
+func countSaleTx(ctx context.Context, tx pgx.Tx, userID, amount int64, p SalePolicy) (string, error) {
+	day := emissionDay(time.Now())
+	var sold int64
+	err := tx.QueryRow(ctx, `
+INSERT INTO user_daily(user_id, day)
+VALUES($1, $2::date)
+ON CONFLICT (user_id, day) DO UPDATE SET updated_at = user_daily.updated_at
+RETURNING sold
+`, userID, day).Scan(&sold)
+	if err != nil {
+		return "", err
+	}
+	if p.DailyLimit > 0 && sold+amount > p.DailyLimit {
+		return "", ErrSaleLimit
+	}
+	_, err = tx.Exec(ctx, `UPDATE user_daily SET sold=sold+$3, updated_at=now() WHERE user_id=$1 AND day=$2::date`, userID, day, amount)
+	return day, err
+}
+
+// uncountSaleTx gives back a sale that did not happen (refunded withdrawal).
+func uncountSaleTx(ctx context.Context, tx pgx.Tx, userID int64, day string, amount int64) error {
+	if day == "" || amount <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `UPDATE user_daily SET sold=GREATEST(sold-$3, 0), updated_at=now() WHERE user_id=$1 AND day=$2::date`, userID, day, amount)
+	return err
+}
+
+// settleSaleTaxTx books a collected tax: the burned part leaves total_supply,
+// the fund part is credited to the stabilization fund. Locks system_state.
+func settleSaleTaxTx(ctx context.Context, tx pgx.Tx, userID int64, t SaleTax, meta map[string]any) error {
+	if t.Tax <= 0 {
+		return nil
+	}
+	if t.Burn > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET total_supply=GREATEST(total_supply-$1, 0), updated_at=now() WHERE id=1`, t.Burn); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('sale_tax_burn', $1, NULL, $2, $3::jsonb)`,
+			userID, t.Burn, toJSON(meta)); err != nil {
+			return err
+		}
+	}
+	if t.Fund > 0 {
+		if _, err := creditSystemAccountTx(ctx, tx, AccountStabilizationFund, t.Fund); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('sale_tax_fund', $1, NULL, $2, $3::jsonb)`,
+			userID, t.Fund, toJSON(meta)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chargeExchangeSaleTx counts an exchange listing sale and debits the sale
+// tax from the seller.
+func chargeExchangeSaleTx(ctx context.Context, tx pgx.Tx, sellerID, listingID, price int64, sp SalePolicy) error {
+	if price <= 0 {
+		return nil
+	}
+	spendable, err := lockSpendableTx(ctx, tx, sellerID)
+	if err != nil {
+		return err
+	}
+	if _, err := countSaleTx(ctx, tx, sellerID, price, sp); err != nil {
+		return err
+	}
+	tax := sp.Tax(price)
+	if tax.Tax <= 0 {
+		return nil
+	}
+	if spendable < tax.Tax {
+		return ErrNotEnough
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, tax.Tax, sellerID); err != nil {
+		return err
+	}
+	return settleSaleTaxTx(ctx, tx, sellerID, tax, map[string]any{"listing_id": listingID, "price_coins": price})
+}
@@ -0,0 +1,32 @@
+package db
+
+import "testing"
+
+func TestSalePolicyTax(t *testing.T) {
+	p := SalePolicy{TaxBP: 500, TaxBurnBP: 5_000}
+	if got := p.Tax(10_000); got != (SaleTax{Tax: 500, Burn: 250, Fund: 250}) {
+		t.Fatalf("5%% tax on 10000: %+v", got)
+	}
+	if got := p.Tax(19); got != (SaleTax{}) {
+		t.Fatalf("tiny sale is rounded down to no tax: %+v", got)
+	}
+	p.TaxBurnBP = 3_333
+	if got := p.Tax(1_000); got.Tax != 50 || got.Burn+got.Fund != got.Tax {
+		t.Fatalf("split does not add up: %+v", got)
+	}
+	if got := (SalePolicy{}).Tax(1_000_000); got.Tax != 0 {
+		t.Fatalf("no tax configured: %+v", got)
+	}
+}
+
+func TestSaleQuota(t *testing.T) {
+	if q := (SalePolicy{}).quota("2026-01-01", 500); q.Remaining != -1 {
+		t.Fatalf("unlimited: %+v", q)
+	}
+	if q := (SalePolicy{DailyLimit: 1_000}).quota("2026-01-01", 400); q.Remaining != 600 {
+		t.Fatalf("limited: %+v", q)
+	}
+	if q := (SalePolicy{DailyLimit: 1_000}).quota("2026-01-01", 1_400); q.Remaining != 0 {
+		t.Fatalf("over limit: %+v", q)
+	}
+}
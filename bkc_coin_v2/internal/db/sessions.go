@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A session is one Telegram WebApp launch: every launch signs a new initData,
+// and the session is keyed by its hash. Sessions can be revoked one by one;
+// "log out everywhere" also rejects any initData issued before that moment.
+type Session struct {
+	SessionID  int64     `json:"session_id"`
+	UserID     int64     `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// sessionTouchEvery limits last-seen writes to one per session per minute.
+const sessionTouchEvery = time.Minute
+
+// sessionIdleTTL hides sessions that were not used for this long.
+const sessionIdleTTL = 30 * 24 * time.Hour
+
+// TouchSession records a request made with the session identified by key and
+// reports whether the session may be used and whether this request opened it.
+// authDate is when the initData was issued.
+func (d *DB) TouchSession(ctx context.Context, userID int64, key, ip, userAgent string, authDate time.Time) (live, created bool, err error) {
+	if userID <= 0 || key == "" {
+		return false, false, errors.New("bad params")
+	}
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	var revoked *time.Time
+	var lastSeen time.Time
+	err = d.Pool.QueryRow(ctx, `SELECT revoked_at, last_seen_at FROM sessions WHERE session_key=$1 AND user_id=$2`, key, userID).Scan(&revoked, &lastSeen)
+	switch {
+	case err == nil:
+		if revoked != nil {
+			return false, false, nil
+		}
+		if time.Since(lastSeen) >= sessionTouchEvery {
+			_, err = d.Pool.Exec(ctx, `UPDATE sessions SET last_seen_at=now(), ip=$1, user_agent=$2 WHERE session_key=$3`, ip, userAgent, key)
+		}
+		return true, false, err
+	case !errors.Is(err, pgx.ErrNoRows):
+		return false, false, err
+	}
+
+	// New session: initData issued before "log out everywhere" is refused.
+	var cutoff *time.Time
+	if err := d.Pool.QueryRow(ctx, `SELECT sessions_revoked_before FROM users WHERE user_id=$1`, userID).Scan(&cutoff); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, false, err
+	}
+	if cutoff != nil && !authDate.After(*cutoff) {
+		return false, false, nil
+	}
+	tag, err := d.Pool.Exec(ctx, `
+INSERT INTO sessions (session_key, user_id, ip, user_agent)
+VALUES ($1,$2,$3,$4)
+ON CONFLICT (session_key) DO NOTHING
+`, key, userID, ip, userAgent)
+	if err != nil {
+		return false, false, err
+	}
+	return true, tag.RowsAffected() > 0, nil
+}
+
+// ListSessions returns the user's live sessions, most recently used first.
+// currentKey marks the session making the request.
+func (d *DB) ListSessions(ctx context.Context, userID int64, currentKey string) ([]Session, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT session_id, user_id, ip, user_agent, created_at, last_seen_at, session_key=$2
+FROM sessions
+WHERE user_id=$1 AND revoked_at IS NULL AND last_seen_at > $3
+ORDER BY last_seen_at DESC
+LIMIT 100
+`, userID, currentKey, time.Now().Add(-sessionIdleTTL))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.SessionID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.Current); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// RevokeSession ends one of the user's sessions.
+func (d *DB) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	if userID <= 0 || sessionID <= 0 {
+		return errors.New("bad params")
+	}
+	tag, err := d.Pool.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE session_id=$1 AND user_id=$2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeAllSessions logs the user out everywhere except the session keepKey
+// (empty keeps none). Returns the number of revoked sessions.
+func (d *DB) RevokeAllSessions(ctx context.Context, userID int64, keepKey string) (int64, error) {
+	if userID <= 0 {
+		return 0, errors.New("bad params")
+	}
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `UPDATE users SET sessions_revoked_before=now() WHERE user_id=$1`, userID); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL AND session_key<>$2`, userID, keepKey)
+		if err != nil {
+			return err
+		}
+		n = tag.RowsAffected()
+		return nil
+	})
+	return n, err
+}
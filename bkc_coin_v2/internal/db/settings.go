@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const ChannelTelegram = "telegram"
+
+// NotifyChannels are the channels a user can receive notifications on.
+var NotifyChannels = []string{ChannelTelegram}
+
+// PaymentChains a user can pick as default; the names match
+// payments.PaymentRequest.Chain.
+var PaymentChains = []string{"ton", "ton_usdt", "solana_usdt"}
+
+// UserSettings are the user's preferences. A user without a row gets the
+// defaults: Telegram language, every notification on Telegram, listed on
+// leaderboards and no default chain.
+type UserSettings struct {
+	UserID               int64      `json:"user_id"`
+	Language             string     `json:"language"` // "" = from Telegram
+	NotifyChannels       []string   `json:"notify_channels"`
+	MutedNotifications   []string   `json:"muted_notifications"`
+	HideFromLeaderboards bool       `json:"hide_from_leaderboards"`
+	DefaultChain         string     `json:"default_chain"` // "" = none
+	UpdatedAt            *time.Time `json:"updated_at,omitempty"`
+}
+
+// SettingsUpdate changes the non-nil fields.
+type SettingsUpdate struct {
+	Language             *string   `json:"language"`
+	NotifyChannels       *[]string `json:"notify_channels"`
+	MutedNotifications   *[]string `json:"muted_notifications"`
+	HideFromLeaderboards *bool     `json:"hide_from_leaderboards"`
+	DefaultChain         *string   `json:"default_chain"`
+}
+
+func (u SettingsUpdate) validate() error {
+	if u.Language != nil && *u.Language != "" && !slices.Contains(templates.Languages, *u.Language) {
+		return errors.New("bad language")
+	}
+	if u.NotifyChannels != nil {
+		for _, c := range *u.NotifyChannels {
+			if !slices.Contains(NotifyChannels, c) {
+				return errors.New("bad notify channel")
+			}
+		}
+	}
+	if u.MutedNotifications != nil {
+		for _, k := range *u.MutedNotifications {
+			if !slices.Contains(templates.Keys, k) {
+				return errors.New("bad notification key")
+			}
+		}
+	}
+	if u.DefaultChain != nil && *u.DefaultChain != "" && !slices.Contains(PaymentChains, *u.DefaultChain) {
+		return errors.New("bad default chain")
+	}
+	return nil
+}
+
+// GetUserSettings returns the user's settings, defaults if never saved.
+func (d *DB) GetUserSettings(ctx context.Context, userID int64) (UserSettings, error) {
+	out := UserSettings{UserID: userID}
+	err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(s.language, ''), COALESCE(s.notify_channels, $2), COALESCE(s.muted_notifications, '{}'),
+  COALESCE(s.hide_from_leaderboards, false), COALESCE(s.default_chain, ''), s.updated_at
+FROM users u LEFT JOIN user_settings s ON s.user_id = u.user_id
+WHERE u.user_id=$1
+`, userID, NotifyChannels).Scan(&out.Language, &out.NotifyChannels, &out.MutedNotifications, &out.HideFromLeaderboards, &out.DefaultChain, &out.UpdatedAt)
+	return out, err
+}
+
+// UpdateUserSettings applies u and returns the resulting settings.
+func (d *DB) UpdateUserSettings(ctx context.Context, userID int64, u SettingsUpdate) (UserSettings, error) {
+	if err := u.validate(); err != nil {
+		return UserSettings{}, err
+	}
+	out := UserSettings{UserID: userID}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `INSERT INTO user_settings(user_id, notify_channels) VALUES($1, $2) ON CONFLICT (user_id) DO NOTHING`, userID, NotifyChannels); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `
+SELECT COALESCE(language, ''), notify_channels, muted_notifications, hide_from_leaderboards, COALESCE(default_chain, '')
+FROM user_settings WHERE user_id=$1 FOR UPDATE
+`, userID).Scan(&out.Language, &out.NotifyChannels, &out.MutedNotifications, &out.HideFromLeaderboards, &out.DefaultChain); err != nil {
+			return err
+		}
+		if u.Language != nil {
+			out.Language = *u.Language
+		}
+		if u.NotifyChannels != nil {
+			out.NotifyChannels = dedupe(*u.NotifyChannels)
+		}
+		if u.MutedNotifications != nil {
+			out.MutedNotifications = dedupe(*u.MutedNotifications)
+		}
+		if u.HideFromLeaderboards != nil {
+			out.HideFromLeaderboards = *u.HideFromLeaderboards
+		}
+		if u.DefaultChain != nil {
+			out.DefaultChain = *u.DefaultChain
+		}
+		// "" is stored as NULL, i.e. back to the default.
+		return tx.QueryRow(ctx, `
+UPDATE user_settings SET language=NULLIF($2, ''), notify_channels=$3, muted_notifications=$4,
+  hide_from_leaderboards=$5, default_chain=NULLIF($6, ''), updated_at=now()
+WHERE user_id=$1
+RETURNING updated_at
+`, userID, out.Language, out.NotifyChannels, out.MutedNotifications, out.HideFromLeaderboards, out.DefaultChain).Scan(&out.UpdatedAt)
+	})
+	if err != nil {
+		return UserSettings{}, err
+	}
+	return out, nil
+}
+
+func dedupe(in []string) []string {
+	out := []string{}
+	for _, s := range in {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// LeaderboardEntry is one row of the XP leaderboard.
+type LeaderboardEntry struct {
+	Rank      int64  `json:"rank"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	Level     int64  `json:"level"`
+	XP        int64  `json:"xp"`
+}
+
+// Leaderboard is the top users by XP, leaving out users who hid themselves
+// and merged accounts.
+func (d *DB) Leaderboard(ctx context.Context, limit int) ([]LeaderboardEntry, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT u.user_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), u.level, u.xp
+FROM users u LEFT JOIN user_settings s ON s.user_id = u.user_id
+WHERE u.merged_into IS NULL AND NOT COALESCE(s.hide_from_leaderboards, false)
+ORDER BY u.xp DESC, u.user_id
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LeaderboardEntry
+	for rows.Next() {
+		e := LeaderboardEntry{Rank: int64(len(out)) + 1}
+		if err := rows.Scan(&e.UserID, &e.Username, &e.FirstName, &e.Level, &e.XP); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// LeaderboardRank is the user's own place among listed users; 0 while the
+// user is hidden.
+func (d *DB) LeaderboardRank(ctx context.Context, userID int64) (int64, error) {
+	var rank int64
+	err := d.Pool.QueryRow(ctx, `
+SELECT CASE WHEN COALESCE(s.hide_from_leaderboards, false) THEN 0 ELSE (
+  SELECT COUNT(*) + 1 FROM users o LEFT JOIN user_settings os ON os.user_id = o.user_id
+  WHERE o.merged_into IS NULL AND NOT COALESCE(os.hide_from_leaderboards, false)
+    AND (o.xp > u.xp OR (o.xp = u.xp AND o.user_id < u.user_id))
+) END
+FROM users u LEFT JOIN user_settings s ON s.user_id = u.user_id
+WHERE u.user_id=$1
+`, userID).Scan(&rank)
+	return rank, err
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSettingsUpdateValidate(t *testing.T) {
+	str := func(s string) *string { return &s }
+	list := func(s ...string) *[]string { return &s }
+	cases := []struct {
+		name string
+		u    SettingsUpdate
+		ok   bool
+	}{
+		{"empty", SettingsUpdate{}, true},
+		{"language", SettingsUpdate{Language: str("en")}, true},
+		{"reset language", SettingsUpdate{Language: str("")}, true},
+		{"unknown language", SettingsUpdate{Language: str("de")}, false},
+		{"no channels", SettingsUpdate{NotifyChannels: list()}, true},
+		{"unknown channel", SettingsUpdate{NotifyChannels: list("sms")}, false},
+		{"mute", SettingsUpdate{MutedNotifications: list("loan_overdue")}, true},
+		{"unknown key", SettingsUpdate{MutedNotifications: list("promo")}, false},
+		{"chain", SettingsUpdate{DefaultChain: str("solana_usdt")}, true},
+		{"unknown chain", SettingsUpdate{DefaultChain: str("eth")}, false},
+	}
+	for _, c := range cases {
+		if err := c.u.validate(); (err == nil) != c.ok {
+			t.Errorf("%s: err=%v", c.name, err)
+		}
+	}
+}
+
+func TestUserSettings(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 993_101
+	if _, err := d.EnsureUser(ctx, userID, "", "", 100); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM user_settings WHERE user_id=$1`, userID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id=$1`, userID)
+	})
+
+	s, err := d.GetUserSettings(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Language != "" || len(s.NotifyChannels) != 1 || s.HideFromLeaderboards {
+		t.Fatalf("defaults %+v", s)
+	}
+
+	hide, lang := true, "en"
+	if _, err := d.UpdateUserSettings(ctx, userID, SettingsUpdate{HideFromLeaderboards: &hide, Language: &lang}); err != nil {
+		t.Fatal(err)
+	}
+	chain := "ton"
+	s, err = d.UpdateUserSettings(ctx, userID, SettingsUpdate{DefaultChain: &chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.HideFromLeaderboards || s.Language != "en" || s.DefaultChain != "ton" {
+		t.Fatalf("partial update lost fields: %+v", s)
+	}
+	if rank, err := d.LeaderboardRank(ctx, userID); err != nil || rank != 0 {
+		t.Fatalf("hidden user rank %d, %v", rank, err)
+	}
+}
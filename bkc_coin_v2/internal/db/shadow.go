@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Shadow accounting: what a candidate reward formula would have paid for
+// real taps, per user and day, next to what was actually paid. Written by
+// shadow.Recorder; nothing here moves balances.
+
+// TapShadowDelta is an increment to one user's day.
+type TapShadowDelta struct {
+	UserID int64
+	Day    string // YYYY-MM-DD
+	Taps   int64
+	Actual int64
+	Shadow int64
+}
+
+// AddTapShadow adds deltas to the engine's per user/day totals.
+func (d *DB) AddTapShadow(ctx context.Context, engine string, rows []TapShadowDelta) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	uids := make([]int64, len(rows))
+	days := make([]string, len(rows))
+	taps := make([]int64, len(rows))
+	actual := make([]int64, len(rows))
+	shadow := make([]int64, len(rows))
+	for i, r := range rows {
+		uids[i], days[i], taps[i], actual[i], shadow[i] = r.UserID, r.Day, r.Taps, r.Actual, r.Shadow
+	}
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO tap_shadow_daily(engine, user_id, day, taps, actual_coins, shadow_coins)
+SELECT $1, t.user_id, t.day::date, t.taps, t.actual, t.shadow
+FROM UNNEST($2::bigint[], $3::text[], $4::bigint[], $5::bigint[], $6::bigint[]) AS t(user_id, day, taps, actual, shadow)
+ON CONFLICT (engine, user_id, day) DO UPDATE SET
+  taps = tap_shadow_daily.taps + EXCLUDED.taps,
+  actual_coins = tap_shadow_daily.actual_coins + EXCLUDED.actual_coins,
+  shadow_coins = tap_shadow_daily.shadow_coins + EXCLUDED.shadow_coins,
+  updated_at = now()
+`, engine, uids, days, taps, actual, shadow)
+	return err
+}
+
+// TapShadowTotals compares the engines over a day or a user.
+type TapShadowTotals struct {
+	Day      string  `json:"day,omitempty"`
+	UserID   int64   `json:"user_id,omitempty"`
+	Users    int64   `json:"users,omitempty"`
+	Taps     int64   `json:"taps"`
+	Actual   int64   `json:"actual_coins"`
+	Shadow   int64   `json:"shadow_coins"`
+	Delta    int64   `json:"delta"`     // shadow - actual
+	DeltaPct float64 `json:"delta_pct"` // of actual; 0 when nothing was paid
+}
+
+func (t *TapShadowTotals) fill() {
+	t.Delta = t.Shadow - t.Actual
+	if t.Actual > 0 {
+		t.DeltaPct = float64(t.Delta) / float64(t.Actual) * 100
+	}
+}
+
+// TapShadowReport is the comparison since a day: per-day totals, the overall
+// total and the users whose pay would change most.
+type TapShadowReport struct {
+	Engine string            `json:"engine"`
+	Since  string            `json:"since"`
+	Total  TapShadowTotals   `json:"total"`
+	Days   []TapShadowTotals `json:"days"`
+	Users  []TapShadowTotals `json:"top_users"` // by |delta|
+}
+
+// TapShadowReport reads the engine's totals from since (a day) on.
+func (d *DB) TapShadowReport(ctx context.Context, engine string, since time.Time, top int) (TapShadowReport, error) {
+	if top <= 0 || top > 100 {
+		top = 20
+	}
+	out := TapShadowReport{Engine: engine, Since: since.UTC().Format("2006-01-02")}
+	rows, err := d.Pool.Query(ctx, `
+SELECT day::text, COUNT(*), SUM(taps)::bigint, SUM(actual_coins)::bigint, SUM(shadow_coins)::bigint
+FROM tap_shadow_daily
+WHERE engine=$1 AND day >= $2::date
+GROUP BY day
+ORDER BY day
+`, engine, out.Since)
+	if err != nil {
+		return out, err
+	}
+	for rows.Next() {
+		var t TapShadowTotals
+		if err := rows.Scan(&t.Day, &t.Users, &t.Taps, &t.Actual, &t.Shadow); err != nil {
+			rows.Close()
+			return out, err
+		}
+		t.fill()
+		out.Days = append(out.Days, t)
+		out.Total.Taps += t.Taps
+		out.Total.Actual += t.Actual
+		out.Total.Shadow += t.Shadow
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+	out.Total.fill()
+
+	rows, err = d.Pool.Query(ctx, `
+SELECT user_id, SUM(taps)::bigint, SUM(actual_coins)::bigint, SUM(shadow_coins)::bigint
+FROM tap_shadow_daily
+WHERE engine=$1 AND day >= $2::date
+GROUP BY user_id
+ORDER BY abs(SUM(shadow_coins) - SUM(actual_coins)) DESC, user_id
+LIMIT $3
+`, engine, out.Since, top)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TapShadowTotals
+		if err := rows.Scan(&t.UserID, &t.Taps, &t.Actual, &t.Shadow); err != nil {
+			return out, err
+		}
+		t.fill()
+		out.Users = append(out.Users, t)
+	}
+	if err := d.Pool.QueryRow(ctx, `SELECT COUNT(DISTINCT user_id) FROM tap_shadow_daily WHERE engine=$1 AND day >= $2::date`, engine, out.Since).Scan(&out.Total.Users); err != nil {
+		return out, err
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AccountStabilizationFund is the system account fed by the fund share of the
+// sale tax and spent on interventions.
+const AccountStabilizationFund = "stabilization_fund"
+
+// Intervention kinds.
+const (
+	// InterventionBuySupport retires fund coins to offset sell pressure:
+	// they leave total_supply (ledger 'stabilization_burn').
+	InterventionBuySupport = "buy_support"
+	// InterventionReserveTopUp moves fund coins into the reserve, raising the
+	// runway and moving the curve back towards StartRate.
+	InterventionReserveTopUp = "reserve_topup"
+)
+
+// Intervention is one spend of the stabilization fund.
+type Intervention struct {
+	InterventionID int64     `json:"intervention_id"`
+	Kind           string    `json:"kind"`
+	Amount         int64     `json:"amount"`
+	Reason         string    `json:"reason"`
+	AdminID        int64     `json:"admin_id"`
+	RateBefore     int64     `json:"rate_before"` // coins per USD
+	RateAfter      int64     `json:"rate_after"`
+	FundAfter      int64     `json:"fund_after"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// StabilizationReport is the fund balance with its ledger totals.
+type StabilizationReport struct {
+	Balance       int64          `json:"balance"`
+	Inflows       int64          `json:"inflows"`  // sale tax fund share
+	Outflows      int64          `json:"outflows"` // interventions
+	Interventions []Intervention `json:"interventions"`
+}
+
+// creditSystemAccountTx adds amount (negative to debit) to a system account and
+// returns the new balance. A debit below zero fails with ErrNotEnough.
+func creditSystemAccountTx(ctx context.Context, tx pgx.Tx, account string, amount int64) (int64, error) {
+	var bal int64
+	err := tx.QueryRow(ctx, `
+UPDATE system_accounts SET balance=balance+$2, updated_at=now()
+WHERE account=$1 AND balance+$2 >= 0
+RETURNING balance
+`, account, amount).Scan(&bal)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotEnough
+	}
+	return bal, err
+}
+
+// StabilizationFund returns the fund balance.
+func (d *DB) StabilizationFund(ctx context.Context) (int64, error) {
+	var bal int64
+	err := d.Pool.QueryRow(ctx, `SELECT balance FROM system_accounts WHERE account=$1`, AccountStabilizationFund).Scan(&bal)
+	return bal, err
+}
+
+// Intervene spends amount of the stabilization fund. Locks system_state, then
+// the fund account.
+func (d *DB) Intervene(ctx context.Context, adminID int64, kind string, amount int64, reason string) (Intervention, error) {
+	reason = strings.TrimSpace(reason)
+	if amount <= 0 || len(reason) > 500 {
+		return Intervention{}, errors.New("bad params")
+	}
+	if kind != InterventionBuySupport && kind != InterventionReserveTopUp {
+		return Intervention{}, errors.New("bad kind")
+	}
+	out := Intervention{Kind: kind, Amount: amount, Reason: reason, AdminID: adminID}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var st SystemState
+		if err := tx.QueryRow(ctx, `
+SELECT reserve_supply, initial_reserve, start_rate_coins_usd, min_rate_coins_usd
+FROM system_state WHERE id=1 FOR UPDATE
+`).Scan(&st.ReserveSupply, &st.InitialReserve, &st.StartRateCoinsUSD, &st.MinRateCoinsUSD); err != nil {
+			return err
+		}
+		out.RateBefore = st.CoinsPerUSD()
+		var err error
+		if out.FundAfter, err = creditSystemAccountTx(ctx, tx, AccountStabilizationFund, -amount); err != nil {
+			return err
+		}
+		ledgerKind := "stabilization_burn"
+		if kind == InterventionBuySupport {
+			_, err = tx.Exec(ctx, `UPDATE system_state SET total_supply=GREATEST(total_supply-$1, 0), updated_at=now() WHERE id=1`, amount)
+		} else {
+			ledgerKind = "stabilization_topup"
+			st.ReserveSupply += amount
+			_, err = tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, amount)
+		}
+		if err != nil {
+			return err
+		}
+		out.RateAfter = st.CoinsPerUSD()
+		if err := tx.QueryRow(ctx, `
+INSERT INTO stabilization_interventions(kind, amount, reason, admin_id, rate_before, rate_after, fund_after)
+VALUES($1,$2,$3,$4,$5,$6,$7)
+RETURNING intervention_id, created_at
+`, kind, amount, reason, adminID, out.RateBefore, out.RateAfter, out.FundAfter).Scan(&out.InterventionID, &out.CreatedAt); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES($1, NULL, NULL, $2, $3::jsonb)`,
+			ledgerKind, amount, toJSON(map[string]any{"intervention_id": out.InterventionID, "by": adminID, "reason": reason}))
+		return err
+	})
+	if err != nil {
+		return Intervention{}, err
+	}
+	return out, nil
+}
+
+// StabilizationReport returns the fund balance, inflow/outflow totals from the
+// ledger and the last limit interventions.
+func (d *DB) StabilizationReport(ctx context.Context, limit int) (StabilizationReport, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	var out StabilizationReport
+	var err error
+	if out.Balance, err = d.StabilizationFund(ctx); err != nil {
+		return StabilizationReport{}, err
+	}
+	if err := d.Pool.QueryRow(ctx, `
+SELECT
+  COALESCE(SUM(amount) FILTER (WHERE kind='sale_tax_fund'), 0),
+  COALESCE(SUM(amount) FILTER (WHERE kind IN ('stabilization_burn','stabilization_topup')), 0)
+FROM ledger
+WHERE kind IN ('sale_tax_fund','stabilization_burn','stabilization_topup')
+`).Scan(&out.Inflows, &out.Outflows); err != nil {
+		return StabilizationReport{}, err
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT intervention_id, kind, amount, reason, COALESCE(admin_id, 0), rate_before, rate_after, fund_after, created_at
+FROM stabilization_interventions
+ORDER BY intervention_id DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return StabilizationReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it Intervention
+		if err := rows.Scan(&it.InterventionID, &it.Kind, &it.Amount, &it.Reason, &it.AdminID, &it.RateBefore, &it.RateAfter, &it.FundAfter, &it.CreatedAt); err != nil {
+			return StabilizationReport{}, err
+		}
+		out.Interventions = append(out.Interventions, it)
+	}
+	return out, rows.Err()
+}
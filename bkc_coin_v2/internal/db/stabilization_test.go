@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestIntervene(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	if _, err := d.EnsureSystemState(ctx, 1_000_000_000, 1, 0, 1_000_000_000, 1_000, 500, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		_, err := creditSystemAccountTx(ctx, tx, AccountStabilizationFund, 1_000)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fund, err := d.StabilizationFund(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Intervene(ctx, 1, InterventionBuySupport, fund+1, ""); !errors.Is(err, ErrNotEnough) {
+		t.Fatalf("overspend: %v", err)
+	}
+	before, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := d.Intervene(ctx, 1, InterventionReserveTopUp, 600, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.FundAfter != fund-600 {
+		t.Fatalf("fund after %d, want %d", it.FundAfter, fund-600)
+	}
+	if _, err := d.Intervene(ctx, 1, InterventionBuySupport, 400, "test"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := d.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ReserveSupply-before.ReserveSupply != 600 || before.TotalSupply-after.TotalSupply != 400 {
+		t.Fatalf("reserve +%d supply -%d", after.ReserveSupply-before.ReserveSupply, before.TotalSupply-after.TotalSupply)
+	}
+}
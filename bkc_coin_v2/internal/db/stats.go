@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// PublicStats are the token figures published for trackers and the website.
+// They are computed by the public_stats job and served from the stored
+// snapshot, so public traffic never runs the aggregates.
+type PublicStats struct {
+	TotalSupply       int64     `json:"total_supply"`
+	CirculatingSupply int64     `json:"circulating_supply"` // total minus reserve and system accounts
+	Burned            int64     `json:"burned"`
+	Holders           int64     `json:"holders"`
+	Volume24h         int64     `json:"volume_24h"`
+	GeneratedAt       time.Time `json:"generated_at"`
+}
+
+// publicVolumeKinds are the ledger kinds counted as trading volume: coins
+// moving between users.
+var publicVolumeKinds = []string{"transfer", "market_buy", "nft_buy", "nft_market_buy"}
+
+// RefreshPublicStats computes the figures and stores them as the current
+// snapshot.
+func (d *DB) RefreshPublicStats(ctx context.Context) (PublicStats, error) {
+	var s PublicStats
+	err := d.Pool.QueryRow(ctx, `
+SELECT st.total_supply,
+  GREATEST(st.total_supply - st.reserve_supply - (SELECT COALESCE(SUM(balance), 0) FROM system_accounts), 0)::bigint,
+  (SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE kind LIKE '%burn')::bigint,
+  (SELECT COUNT(*) FROM users WHERE balance + frozen_balance > 0 AND merged_into IS NULL),
+  (SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE kind = ANY($1) AND ts > now() - interval '24 hours')::bigint
+FROM system_state st WHERE st.id=1
+`, publicVolumeKinds).Scan(&s.TotalSupply, &s.CirculatingSupply, &s.Burned, &s.Holders, &s.Volume24h)
+	if err != nil {
+		return s, err
+	}
+	err = d.Pool.QueryRow(ctx, `
+INSERT INTO public_stats(id, total_supply, circulating_supply, burned, holders, volume_24h, generated_at)
+VALUES(1, $1, $2, $3, $4, $5, now())
+ON CONFLICT (id) DO UPDATE SET
+  total_supply=EXCLUDED.total_supply, circulating_supply=EXCLUDED.circulating_supply, burned=EXCLUDED.burned,
+  holders=EXCLUDED.holders, volume_24h=EXCLUDED.volume_24h, generated_at=EXCLUDED.generated_at
+RETURNING generated_at
+`, s.TotalSupply, s.CirculatingSupply, s.Burned, s.Holders, s.Volume24h).Scan(&s.GeneratedAt)
+	return s, err
+}
+
+// GetPublicStats returns the stored snapshot; pgx.ErrNoRows before the first
+// refresh.
+func (d *DB) GetPublicStats(ctx context.Context) (PublicStats, error) {
+	var s PublicStats
+	err := d.Pool.QueryRow(ctx, `
+SELECT total_supply, circulating_supply, burned, holders, volume_24h, generated_at FROM public_stats WHERE id=1
+`).Scan(&s.TotalSupply, &s.CirculatingSupply, &s.Burned, &s.Holders, &s.Volume24h, &s.GeneratedAt)
+	return s, err
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefreshPublicStats(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	s, err := d.RefreshPublicStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CirculatingSupply < 0 || s.CirculatingSupply > s.TotalSupply {
+		t.Fatalf("circulating %d of total %d", s.CirculatingSupply, s.TotalSupply)
+	}
+	got, err := d.GetPublicStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Holders != s.Holders || got.Volume24h != s.Volume24h || !got.GeneratedAt.Equal(s.GeneratedAt) {
+		t.Fatalf("stored %+v, computed %+v", got, s)
+	}
+}
@@ -0,0 +1,350 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Handle moderation states. A storefront is addressable by its handle only once approved.
+const (
+	HandlePending  = "pending"
+	HandleApproved = "approved"
+	HandleRejected = "rejected"
+)
+
+// Storefront is a seller's public page: profile, badges, rating, sales stats and active listings.
+type Storefront struct {
+	SellerID     int64     `json:"seller_id"`
+	Handle       string    `json:"handle"`
+	HandleStatus string    `json:"handle_status"`
+	DisplayName  string    `json:"display_name"`
+	Bio          string    `json:"bio"`
+	Verified     bool      `json:"verified"`
+	Premium      bool      `json:"premium"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	Rating      float64 `json:"rating"` // average stars, 0 without reviews
+	RatingCount int64   `json:"rating_count"`
+
+	MarketSold  int64           `json:"market_sold"` // bazaar listings sold
+	NFTSold     int64           `json:"nft_sold"`    // NFT copies sold on the secondary market
+	SalesVolume int64           `json:"sales_volume"`
+	ActiveCount int64           `json:"active_count"`
+	Listings    []MarketListing `json:"listings"`
+	NFTListings []NFTListing    `json:"nft_listings"`
+}
+
+type SellerReview struct {
+	ReviewID  int64     `json:"review_id"`
+	SellerID  int64     `json:"seller_id"`
+	BuyerID   int64     `json:"buyer_id"`
+	Kind      string    `json:"kind"`   // market|nft
+	RefID     int64     `json:"ref_id"` // bazaar listing_id or nft sale_id
+	Stars     int64     `json:"stars"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const storefrontListingsLimit = 50
+
+// normalizeHandle lowercases a handle and checks it is 3..32 of [a-z0-9_],
+// starting with a letter.
+func normalizeHandle(h string) (string, error) {
+	h = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(h), "@"))
+	if len(h) < 3 || len(h) > 32 || h[0] < 'a' || h[0] > 'z' {
+		return "", errors.New("bad handle")
+	}
+	for i := 0; i < len(h); i++ {
+		c := h[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return "", errors.New("bad handle")
+		}
+	}
+	return h, nil
+}
+
+// SaveStorefront creates or updates the seller's storefront. A new or changed
+// handle goes back to moderation; handles reserved by admins or taken by
+// another seller are refused.
+func (d *DB) SaveStorefront(ctx context.Context, sellerID int64, handle, displayName, bio string) (Storefront, error) {
+	handle, err := normalizeHandle(handle)
+	if err != nil {
+		return Storefront{}, err
+	}
+	displayName = strings.TrimSpace(displayName)
+	bio = strings.TrimSpace(bio)
+	if sellerID <= 0 || len(displayName) > 64 || len(bio) > 1000 {
+		return Storefront{}, errors.New("bad params")
+	}
+	err = d.WithTx(ctx, func(tx pgx.Tx) error {
+		var reserved bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM reserved_handles WHERE handle=$1)`, handle).Scan(&reserved); err != nil {
+			return err
+		}
+		if reserved {
+			return ErrAlreadyExists
+		}
+		var taken bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM storefronts WHERE handle=$1 AND seller_id<>$2 AND handle_status<>'rejected')`, handle, sellerID).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrAlreadyExists
+		}
+		_, err := tx.Exec(ctx, `
+INSERT INTO storefronts (seller_id, handle, handle_status, display_name, bio)
+VALUES ($1,$2,'pending',$3,$4)
+ON CONFLICT (seller_id) DO UPDATE
+SET handle_status=CASE WHEN storefronts.handle = EXCLUDED.handle THEN storefronts.handle_status ELSE 'pending' END,
+    handle=EXCLUDED.handle,
+    display_name=EXCLUDED.display_name,
+    bio=EXCLUDED.bio,
+    updated_at=now()
+`, sellerID, handle, displayName, bio)
+		return err
+	})
+	if err != nil {
+		return Storefront{}, err
+	}
+	return d.getStorefront(ctx, `s.seller_id=$1`, sellerID, false)
+}
+
+// GetStorefrontByHandle resolves an approved handle to the full storefront.
+func (d *DB) GetStorefrontByHandle(ctx context.Context, handle string) (Storefront, error) {
+	handle, err := normalizeHandle(handle)
+	if err != nil {
+		return Storefront{}, pgx.ErrNoRows
+	}
+	return d.getStorefront(ctx, `s.handle=$1 AND s.handle_status='approved'`, handle, true)
+}
+
+// GetStorefront returns the storefront of a seller. Sellers without a saved
+// storefront still get one with stats and listings but no handle.
+func (d *DB) GetStorefront(ctx context.Context, sellerID int64) (Storefront, error) {
+	if sellerID <= 0 {
+		return Storefront{}, errors.New("bad params")
+	}
+	sf, err := d.getStorefront(ctx, `s.seller_id=$1`, sellerID, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sf = Storefront{SellerID: sellerID}
+		if err := d.Pool.QueryRow(ctx, `SELECT COALESCE(first_name,''), created_at FROM users WHERE user_id=$1`, sellerID).Scan(&sf.DisplayName, &sf.CreatedAt); err != nil {
+			return Storefront{}, err
+		}
+		return sf, d.fillStorefront(ctx, &sf)
+	}
+	return sf, err
+}
+
+func (d *DB) getStorefront(ctx context.Context, where string, arg any, full bool) (Storefront, error) {
+	var sf Storefront
+	if err := d.Pool.QueryRow(ctx, `
+SELECT s.seller_id, s.handle, s.handle_status, s.display_name, s.bio, s.verified, s.premium, s.created_at, s.updated_at
+FROM storefronts s
+WHERE `+where, arg).Scan(&sf.SellerID, &sf.Handle, &sf.HandleStatus, &sf.DisplayName, &sf.Bio, &sf.Verified, &sf.Premium, &sf.CreatedAt, &sf.UpdatedAt); err != nil {
+		return Storefront{}, err
+	}
+	if sf.HandleStatus != HandleApproved && full {
+		// Unmoderated handles are not shown publicly.
+		sf.Handle = ""
+	}
+	if !full {
+		return sf, nil
+	}
+	return sf, d.fillStorefront(ctx, &sf)
+}
+
+// fillStorefront adds rating, sales stats and active listings.
+func (d *DB) fillStorefront(ctx context.Context, sf *Storefront) error {
+	if err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE(AVG(stars),0)::float8, COUNT(*)
+FROM seller_reviews
+WHERE seller_id=$1
+`, sf.SellerID).Scan(&sf.Rating, &sf.RatingCount); err != nil {
+		return err
+	}
+	if err := d.Pool.QueryRow(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM market_listings WHERE seller_id=$1 AND status='sold'),
+  (SELECT COALESCE(SUM(qty),0) FROM nft_sales WHERE seller_id=$1),
+  (SELECT COALESCE(SUM(price_coins),0) FROM market_listings WHERE seller_id=$1 AND status='sold')
+    + (SELECT COALESCE(SUM(price_coins*qty),0) FROM nft_sales WHERE seller_id=$1)
+`, sf.SellerID).Scan(&sf.MarketSold, &sf.NFTSold, &sf.SalesVolume); err != nil {
+		return err
+	}
+
+	rows, err := d.Pool.Query(ctx, `
+SELECT l.listing_id, l.seller_id, l.title, l.description, l.category, l.price_coins, l.contact, l.status, l.created_at, l.sold_at, l.buyer_id,
+       (SELECT image_id FROM market_listing_images WHERE listing_id=l.listing_id ORDER BY created_at ASC LIMIT 1) AS image_id
+FROM market_listings l
+WHERE l.seller_id=$1 AND l.status='active'
+ORDER BY l.created_at DESC
+LIMIT $2
+`, sf.SellerID, storefrontListingsLimit)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l MarketListing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.Title, &l.Description, &l.Category, &l.PriceCoins, &l.Contact, &l.Status, &l.CreatedAt, &l.SoldAt, &l.BuyerID, &l.ImageID); err != nil {
+			rows.Close()
+			return err
+		}
+		sf.Listings = append(sf.Listings, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = d.Pool.Query(ctx, `
+SELECT l.listing_id, l.seller_id, l.nft_id, n.title, n.image_url, l.qty, l.qty_left, l.price_coins, l.status, l.created_at, l.updated_at, l.quote_currency, l.quote_price,
+       `+activeHoldCol("$3")+`
+FROM nft_listings l
+JOIN nfts n ON n.nft_id = l.nft_id
+WHERE l.seller_id=$1 AND l.status='active'
+ORDER BY l.created_at DESC
+LIMIT $2
+`, sf.SellerID, storefrontListingsLimit, d.now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l NFTListing
+		if err := rows.Scan(&l.ListingID, &l.SellerID, &l.NFTID, &l.Title, &l.ImageURL, &l.Qty, &l.QtyLeft, &l.PriceCoins, &l.Status, &l.CreatedAt, &l.UpdatedAt, &l.QuoteCurrency, &l.QuotePrice, &l.ReservedUntil); err != nil {
+			return err
+		}
+		sf.NFTListings = append(sf.NFTListings, l)
+	}
+	sf.ActiveCount = int64(len(sf.Listings) + len(sf.NFTListings))
+	return rows.Err()
+}
+
+// ReviewSeller lets a buyer rate a completed purchase once: a sold bazaar
+// listing (kind "market", refID = listing_id) or an NFT sale (kind "nft", refID = sale_id).
+func (d *DB) ReviewSeller(ctx context.Context, buyerID int64, kind string, refID, stars int64, comment string) (SellerReview, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	comment = strings.TrimSpace(comment)
+	if buyerID <= 0 || refID <= 0 || stars < 1 || stars > 5 || len(comment) > 500 {
+		return SellerReview{}, errors.New("bad params")
+	}
+	out := SellerReview{BuyerID: buyerID, Kind: kind, RefID: refID, Stars: stars, Comment: comment, CreatedAt: time.Now().UTC()}
+	var err error
+	switch kind {
+	case "market":
+		err = d.Pool.QueryRow(ctx, `SELECT seller_id FROM market_listings WHERE listing_id=$1 AND buyer_id=$2 AND status='sold'`, refID, buyerID).Scan(&out.SellerID)
+	case "nft":
+		err = d.Pool.QueryRow(ctx, `SELECT COALESCE(seller_id,0) FROM nft_sales WHERE sale_id=$1 AND buyer_id=$2`, refID, buyerID).Scan(&out.SellerID)
+	default:
+		return SellerReview{}, errors.New("bad kind")
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SellerReview{}, ErrForbidden
+	}
+	if err != nil {
+		return SellerReview{}, err
+	}
+	if out.SellerID <= 0 || out.SellerID == buyerID {
+		return SellerReview{}, ErrForbidden
+	}
+	tag, err := d.Pool.Exec(ctx, `
+INSERT INTO seller_reviews (seller_id, buyer_id, kind, ref_id, stars, comment, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+ON CONFLICT (buyer_id, kind, ref_id) DO NOTHING
+`, out.SellerID, buyerID, kind, refID, stars, comment, out.CreatedAt)
+	if err != nil {
+		return SellerReview{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return SellerReview{}, ErrAlreadyExists
+	}
+	return out, nil
+}
+
+// ListStorefrontsForModeration returns storefronts whose handle awaits review, oldest first.
+func (d *DB) ListStorefrontsForModeration(ctx context.Context, limit int64) ([]Storefront, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT seller_id, handle, handle_status, display_name, bio, verified, premium, created_at, updated_at
+FROM storefronts
+WHERE handle_status='pending'
+ORDER BY updated_at ASC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Storefront
+	for rows.Next() {
+		var sf Storefront
+		if err := rows.Scan(&sf.SellerID, &sf.Handle, &sf.HandleStatus, &sf.DisplayName, &sf.Bio, &sf.Verified, &sf.Premium, &sf.CreatedAt, &sf.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, sf)
+	}
+	return out, rows.Err()
+}
+
+// ModerateStorefrontHandle approves or rejects a seller's handle. A rejected
+// handle is released and the seller is notified.
+func (d *DB) ModerateStorefrontHandle(ctx context.Context, sellerID int64, approve bool, reason string) error {
+	if sellerID <= 0 {
+		return errors.New("bad params")
+	}
+	status := HandleRejected
+	if approve {
+		status = HandleApproved
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var handle string
+		if err := tx.QueryRow(ctx, `UPDATE storefronts SET handle_status=$1, updated_at=now() WHERE seller_id=$2 RETURNING handle`, status, sellerID).Scan(&handle); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, sellerID, "storefront_handle_"+status, map[string]any{"handle": handle, "reason": strings.TrimSpace(reason)})
+	})
+}
+
+// SetStorefrontBadges sets the verified/premium badges shown on a storefront.
+func (d *DB) SetStorefrontBadges(ctx context.Context, sellerID int64, verified, premium bool) error {
+	if sellerID <= 0 {
+		return errors.New("bad params")
+	}
+	tag, err := d.Pool.Exec(ctx, `UPDATE storefronts SET verified=$1, premium=$2, updated_at=now() WHERE seller_id=$3`, verified, premium, sellerID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ReserveHandle blocks a handle (brand names, staff, offensive words) from being claimed.
+func (d *DB) ReserveHandle(ctx context.Context, handle, reason string) error {
+	handle, err := normalizeHandle(handle)
+	if err != nil {
+		return err
+	}
+	_, err = d.Pool.Exec(ctx, `
+INSERT INTO reserved_handles (handle, reason) VALUES ($1,$2)
+ON CONFLICT (handle) DO UPDATE SET reason=EXCLUDED.reason
+`, handle, strings.TrimSpace(reason))
+	return err
+}
+
+func (d *DB) ReleaseHandle(ctx context.Context, handle string) error {
+	handle, err := normalizeHandle(handle)
+	if err != nil {
+		return err
+	}
+	_, err = d.Pool.Exec(ctx, `DELETE FROM reserved_handles WHERE handle=$1`, handle)
+	return err
+}
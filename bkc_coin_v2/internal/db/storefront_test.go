@@ -0,0 +1,22 @@
+package db
+
+import "testing"
+
+func TestNormalizeHandle(t *testing.T) {
+	ok := map[string]string{
+		"@Best_Shop": "best_shop",
+		" abc ":      "abc",
+		"shop2024":   "shop2024",
+	}
+	for in, want := range ok {
+		got, err := normalizeHandle(in)
+		if err != nil || got != want {
+			t.Fatalf("normalizeHandle(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "ab", "1shop", "my-shop", "магазин", "a23456789012345678901234567890123"} {
+		if _, err := normalizeHandle(in); err == nil {
+			t.Fatalf("normalizeHandle(%q) should fail", in)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Modules that can be switched off at runtime. Maintenance switches off the
+// whole user API, games all games, game_* a single game (see GameBooks).
+const (
+	ModuleMaintenance = "maintenance"
+	ModuleGames       = "games"
+	ModuleGameCrash   = "game_crash"
+	ModuleWithdrawals = "withdrawals"
+	ModuleMarketplace = "marketplace"
+)
+
+var Modules = []string{ModuleMaintenance, ModuleGames, ModuleGameCrash, ModuleWithdrawals, ModuleMarketplace}
+
+// ModuleSwitch is the state of one module. Messages are per language
+// (templates.Languages); a missing language gets the built-in text.
+type ModuleSwitch struct {
+	Module    string            `json:"module"`
+	Disabled  bool              `json:"disabled"`
+	Messages  map[string]string `json:"messages"`
+	ETA       *time.Time        `json:"eta,omitempty"`
+	UpdatedBy *int64            `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// ListModuleSwitches returns every module, enabled ones included.
+func (d *DB) ListModuleSwitches(ctx context.Context) ([]ModuleSwitch, error) {
+	return listModuleSwitches(ctx, d.Pool)
+}
+
+func listModuleSwitches(ctx context.Context, q rowsQuerier) ([]ModuleSwitch, error) {
+	rows, err := q.Query(ctx, `
+SELECT m, COALESCE(s.disabled, false), COALESCE(s.messages, '{}'::jsonb)::text, s.eta, s.updated_by, s.updated_at
+FROM unnest($1::text[]) WITH ORDINALITY AS m(m, ord)
+LEFT JOIN module_switches s ON s.module = m.m
+ORDER BY ord
+`, Modules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ModuleSwitch
+	for rows.Next() {
+		var s ModuleSwitch
+		var messages string
+		if err := rows.Scan(&s.Module, &s.Disabled, &messages, &s.ETA, &s.UpdatedBy, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(messages), &s.Messages); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// SetModuleSwitch turns a module off (with an optional message and ETA) or
+// back on, and records who did it in the ledger. byID 0 is the system (the
+// RTP monitor); it is stored as NULL.
+func (d *DB) SetModuleSwitch(ctx context.Context, byID int64, s ModuleSwitch) (ModuleSwitch, error) {
+	if err := s.normalize(); err != nil {
+		return ModuleSwitch{}, err
+	}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		return setModuleSwitchTx(ctx, tx, byID, &s)
+	})
+	if err != nil {
+		return ModuleSwitch{}, err
+	}
+	return s, nil
+}
+
+// normalize validates the module and message languages; an enabled module
+// keeps no message or ETA.
+func (s *ModuleSwitch) normalize() error {
+	if !slices.Contains(Modules, s.Module) {
+		return errors.New("bad module")
+	}
+	for lang := range s.Messages {
+		if !slices.Contains(templates.Languages, lang) {
+			return errors.New("bad message language")
+		}
+	}
+	if s.Messages == nil {
+		s.Messages = map[string]string{}
+	}
+	if !s.Disabled {
+		s.Messages, s.ETA = map[string]string{}, nil
+	}
+	return nil
+}
+
+func setModuleSwitchTx(ctx context.Context, tx pgx.Tx, byID int64, s *ModuleSwitch) error {
+	if err := tx.QueryRow(ctx, `
+INSERT INTO module_switches(module, disabled, messages, eta, updated_by) VALUES($1, $2, $3::jsonb, $4, NULLIF($5::bigint, 0))
+ON CONFLICT (module) DO UPDATE SET disabled=EXCLUDED.disabled, messages=EXCLUDED.messages, eta=EXCLUDED.eta,
+  updated_by=EXCLUDED.updated_by, updated_at=now()
+RETURNING updated_by, updated_at
+`, s.Module, s.Disabled, toJSON(s.Messages), s.ETA, byID).Scan(&s.UpdatedBy, &s.UpdatedAt); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('module_switch', NULLIF($1::bigint, 0), NULL, 0, $2::jsonb)`,
+		byID, toJSON(map[string]any{"module": s.Module, "disabled": s.Disabled, "eta": s.ETA}))
+	return err
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestModuleSwitches(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM module_switches WHERE module=$1`, ModuleGames)
+	})
+
+	if _, err := d.SetModuleSwitch(ctx, 1, ModuleSwitch{Module: "casino", Disabled: true}); err == nil {
+		t.Fatal("unknown module accepted")
+	}
+	if _, err := d.SetModuleSwitch(ctx, 1, ModuleSwitch{Module: ModuleGames, Disabled: true, Messages: map[string]string{"de": "x"}}); err == nil {
+		t.Fatal("unknown language accepted")
+	}
+	eta := time.Now().Add(time.Hour).Truncate(time.Second)
+	if _, err := d.SetModuleSwitch(ctx, 1, ModuleSwitch{Module: ModuleGames, Disabled: true, Messages: map[string]string{"en": "Games are down"}, ETA: &eta}); err != nil {
+		t.Fatal(err)
+	}
+	find := func() ModuleSwitch {
+		all, err := d.ListModuleSwitches(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(Modules) {
+			t.Fatalf("%d switches, want %d", len(all), len(Modules))
+		}
+		for _, s := range all {
+			if s.Module == ModuleGames {
+				return s
+			}
+		}
+		t.Fatal("games switch missing")
+		return ModuleSwitch{}
+	}
+	s := find()
+	if !s.Disabled || s.Messages["en"] != "Games are down" || s.ETA == nil || !s.ETA.Equal(eta) {
+		t.Fatalf("disabled switch %+v", s)
+	}
+
+	// Turning a module back on clears its message and ETA.
+	if _, err := d.SetModuleSwitch(ctx, 1, ModuleSwitch{Module: ModuleGames, Messages: map[string]string{"en": "x"}, ETA: &eta}); err != nil {
+		t.Fatal(err)
+	}
+	if s := find(); s.Disabled || len(s.Messages) != 0 || s.ETA != nil {
+		t.Fatalf("enabled switch %+v", s)
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Benchmarks for ApplyTapAggregates need a disposable Postgres:
+//
+//	BKC_BENCH_DATABASE_URL=postgres://... go test ./internal/db -run '^$' -bench ApplyTapAggregates
+//
+// Each batch size reports p99 flush latency and write amplification
+// (Postgres row writes and WAL bytes per user aggregate) to guide the choice
+// of the memtap/fasttap flush batch size.
+
+const benchUserBase = 9_100_000_000
+
+func benchDB(b *testing.B) *DB {
+	url := os.Getenv("BKC_BENCH_DATABASE_URL")
+	if url == "" {
+		b.Skip("BKC_BENCH_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	d, err := Connect(ctx, url)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(d.Close)
+	if err := d.Migrate(ctx); err != nil {
+		b.Fatal(err)
+	}
+	return d
+}
+
+func seedBenchUsers(b *testing.B, d *DB, n int) {
+	ctx := context.Background()
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO users (user_id, username, first_name)
+SELECT g, 'bench'||g, 'Bench' FROM generate_series($1::bigint, $2::bigint) g
+ON CONFLICT (user_id) DO NOTHING
+`, int64(benchUserBase), int64(benchUserBase+n-1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM user_daily WHERE user_id BETWEEN $1 AND $2`, int64(benchUserBase), int64(benchUserBase+n-1))
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id BETWEEN $1 AND $2`, int64(benchUserBase), int64(benchUserBase+n-1))
+	})
+}
+
+func benchWriteStats(b *testing.B, d *DB) (rows, wal int64) {
+	ctx := context.Background()
+	// Counters are reported by backends at transaction end; force a fresh snapshot.
+	_, _ = d.Pool.Exec(ctx, `SELECT pg_stat_clear_snapshot()`)
+	if err := d.Pool.QueryRow(ctx, `
+SELECT tup_inserted + tup_updated + tup_deleted
+FROM pg_stat_database
+WHERE datname = current_database()
+`).Scan(&rows); err != nil {
+		b.Fatal(err)
+	}
+	_ = d.Pool.QueryRow(ctx, `SELECT wal_bytes::bigint FROM pg_stat_wal`).Scan(&wal) // PG14+
+	return rows, wal
+}
+
+func BenchmarkApplyTapAggregates(b *testing.B) {
+	d := benchDB(b)
+	const maxBatch = 5000
+	seedBenchUsers(b, d, maxBatch)
+	day := time.Now().UTC().Format("2006-01-02")
+
+	for _, size := range []int{1, 10, 100, 500, 1000, maxBatch} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			users := make([]UserTapAggregate, size)
+			daily := make([]DailyTapAggregate, size)
+			for i := range users {
+				id := int64(benchUserBase + i)
+				users[i] = UserTapAggregate{UserID: id, BalanceDelta: 3, TapsDelta: 3, Energy: 100}
+				daily[i] = DailyTapAggregate{UserID: id, Day: day, TappedDelta: 3}
+			}
+			ctx := context.Background()
+			lat := make([]time.Duration, 0, b.N)
+			rows0, wal0 := benchWriteStats(b, d)
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				now := time.Now().UTC()
+				for i := range users {
+					users[i].EnergyUpdatedAt = now
+				}
+				start := time.Now()
+				if err := d.ApplyTapAggregates(ctx, users, daily, -int64(3*size), "bench"); err != nil {
+					b.Fatal(err)
+				}
+				lat = append(lat, time.Since(start))
+			}
+			b.StopTimer()
+
+			rows1, wal1 := benchWriteStats(b, d)
+			sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+			p99 := lat[int(float64(len(lat)-1)*0.99)]
+			aggregates := float64(b.N * size)
+			b.ReportMetric(float64(p99.Microseconds())/1000, "p99-ms")
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/aggregates, "ns/user")
+			b.ReportMetric(float64(rows1-rows0)/aggregates, "rows/user")
+			if wal1 > wal0 {
+				b.ReportMetric(float64(wal1-wal0)/aggregates, "walB/user")
+			}
+		})
+	}
+}
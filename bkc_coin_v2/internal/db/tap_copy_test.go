@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"bkc_coin_v2/internal/testenv"
+)
+
+// Database tests need a disposable Postgres (see testenv):
+//
+//	BKC_TEST_DATABASE_URL=postgres://... go test ./internal/db
+//	BKC_TEST_DOCKER=1 go test ./internal/db
+
+func TestMain(m *testing.M) { os.Exit(testenv.Run(m)) }
+
+func testDB(t *testing.T) *DB {
+	url := testenv.Postgres(t)
+	ctx := context.Background()
+	d, err := Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+type tapState struct {
+	balance, taps, tapped, ledgerRows, ledgerSum int64
+}
+
+// tapEventsFixture builds a batch over users base..base+users-1 with in-batch
+// duplicates, invalid events and two days; prefix keeps event ids disjoint.
+func tapEventsFixture(prefix string, base int64, users, n int) []TapEvent {
+	days := []string{"2024-01-01", "2024-01-02"}
+	out := make([]TapEvent, 0, n+n/10+2)
+	for i := 0; i < n; i++ {
+		ev := TapEvent{
+			EventID: fmt.Sprintf("%s-%d", prefix, i),
+			UserID:  base + int64(i%users),
+			Coins:   int64(i%7 + 1),
+			Taps:    int64(i%3 + 1),
+			Day:     days[i%len(days)],
+			Req:     int64(i),
+		}
+		out = append(out, ev)
+		if i%10 == 0 {
+			out = append(out, ev) // duplicate within the batch
+		}
+	}
+	out = append(out,
+		TapEvent{EventID: prefix + "-bad", UserID: base, Coins: 0, Taps: 1, Day: days[0]},
+		TapEvent{EventID: " ", UserID: base, Coins: 1, Taps: 1, Day: days[0]},
+	)
+	return out
+}
+
+func readTapState(t *testing.T, d *DB, base int64, users int, prefix string) ([]tapState, int64) {
+	ctx := context.Background()
+	st := make([]tapState, users)
+	rows, err := d.Pool.Query(ctx, `
+SELECT u.user_id - $1, u.balance, u.taps_total, COALESCE((SELECT SUM(tapped) FROM user_daily WHERE user_id=u.user_id),0)
+FROM users u
+WHERE u.user_id BETWEEN $1 AND $2
+`, base, base+int64(users)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var i int64
+		var s tapState
+		if err := rows.Scan(&i, &s.balance, &s.taps, &s.tapped); err != nil {
+			t.Fatal(err)
+		}
+		st[i] = s
+	}
+	rows.Close()
+	rows, err = d.Pool.Query(ctx, `
+SELECT to_id - $1, COUNT(*), SUM(amount)
+FROM ledger
+WHERE kind='tap' AND event_id LIKE $2 || '-%'
+GROUP BY to_id
+`, base, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var i, n, sum int64
+		if err := rows.Scan(&i, &n, &sum); err != nil {
+			t.Fatal(err)
+		}
+		st[i].ledgerRows, st[i].ledgerSum = n, sum
+	}
+	rows.Close()
+	var reserve int64
+	if err := d.Pool.QueryRow(ctx, `SELECT reserve_supply FROM system_state WHERE id=1`).Scan(&reserve); err != nil {
+		t.Fatal(err)
+	}
+	return st, reserve
+}
+
+func TestApplyTapEventsCopyMatchesUnnest(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const users, n = 50, 3000
+	const baseUnnest, baseCopy = 9_200_000_000, 9_200_100_000
+
+	for _, base := range []int64{baseUnnest, baseCopy} {
+		base := base
+		if _, err := d.Pool.Exec(ctx, `
+INSERT INTO users (user_id, username, first_name)
+SELECT g, 'eq'||g, 'Eq' FROM generate_series($1::bigint, $2::bigint) g
+ON CONFLICT (user_id) DO NOTHING
+`, base, base+users-1); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger WHERE kind='tap' AND to_id BETWEEN $1 AND $2`, base, base+users-1)
+			_, _ = d.Pool.Exec(ctx, `DELETE FROM user_daily WHERE user_id BETWEEN $1 AND $2`, base, base+users-1)
+			_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id BETWEEN $1 AND $2`, base, base+users-1)
+		})
+	}
+
+	prevThreshold := tapCopyThreshold
+	t.Cleanup(func() { tapCopyThreshold = prevThreshold })
+
+	apply := func(threshold int, prefix string, base int64) ([]tapState, int64) {
+		tapCopyThreshold = threshold
+		_, before := readTapState(t, d, base, users, prefix)
+		events := tapEventsFixture(prefix, base, users, n)
+		if err := d.ApplyTapEvents(ctx, events, 0); err != nil {
+			t.Fatal(err)
+		}
+		// Replaying the same batch (worker retry) must be a no-op.
+		if err := d.ApplyTapEvents(ctx, events[:n/2], 0); err != nil {
+			t.Fatal(err)
+		}
+		st, after := readTapState(t, d, base, users, prefix)
+		return st, before - after
+	}
+
+	unnestPrefix := fmt.Sprintf("eq-unnest-%d", baseUnnest)
+	copyPrefix := fmt.Sprintf("eq-copy-%d", baseCopy)
+	gotUnnest, spentUnnest := apply(1<<30, unnestPrefix, baseUnnest)
+	gotCopy, spentCopy := apply(1, copyPrefix, baseCopy)
+
+	if spentUnnest != spentCopy {
+		t.Fatalf("reserve delta: unnest=%d copy=%d", spentUnnest, spentCopy)
+	}
+	for i := range gotUnnest {
+		if gotUnnest[i] != gotCopy[i] {
+			t.Fatalf("user +%d: unnest=%+v copy=%+v", i, gotUnnest[i], gotCopy[i])
+		}
+		if gotUnnest[i].ledgerRows == 0 {
+			t.Fatalf("user +%d: no tap ledger rows", i)
+		}
+	}
+}
+
+func TestApplyTapEventsTruncatesOverDailyLimit(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const base = 9_200_200_000
+	if _, err := d.Pool.Exec(ctx, `
+INSERT INTO users (user_id, username, first_name) VALUES ($1,'lim','Lim'), ($2,'plan','Plan')
+ON CONFLICT (user_id) DO NOTHING
+`, int64(base), int64(base+1)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger WHERE kind IN ('tap','admin_user_plan') AND to_id BETWEEN $1 AND $2`, int64(base), int64(base+1))
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM user_plans WHERE user_id BETWEEN $1 AND $2`, int64(base), int64(base+1))
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM user_daily WHERE user_id BETWEEN $1 AND $2`, int64(base), int64(base+1))
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id BETWEEN $1 AND $2`, int64(base), int64(base+1))
+	})
+	if _, err := d.SetUserPlan(ctx, 1, base+1, "gold", 40, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []TapEvent
+	for i := 0; i < 5; i++ {
+		for _, uid := range []int64{base, base + 1} {
+			events = append(events, TapEvent{EventID: fmt.Sprintf("lim-%d-%d", uid, i), UserID: uid, Coins: 10, Taps: 10, Day: "2024-01-01", Req: 10})
+		}
+	}
+	// Base limit 25: the first user gets 10+10+5; the second has a 40-tap plan.
+	if err := d.ApplyTapEvents(ctx, events, 25); err != nil {
+		t.Fatal(err)
+	}
+	for uid, want := range map[int64]int64{base: 25, base + 1: 40} {
+		var balance, tapped int64
+		if err := d.Pool.QueryRow(ctx, `
+SELECT u.balance, ud.tapped FROM users u JOIN user_daily ud ON ud.user_id=u.user_id AND ud.day='2024-01-01'
+WHERE u.user_id=$1`, uid).Scan(&balance, &tapped); err != nil {
+			t.Fatal(err)
+		}
+		if balance != want || tapped != want {
+			t.Fatalf("user %d: balance=%d tapped=%d, want %d", uid, balance, tapped, want)
+		}
+	}
+}
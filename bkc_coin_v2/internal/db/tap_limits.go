@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TapLimit is a user's effective daily tap limit. Limit 0 means unlimited;
+// Until is the plan expiry (zero for the base limit or a plan without expiry).
+type TapLimit struct {
+	Limit int64     `json:"daily_tap_limit"`
+	Plan  string    `json:"plan"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+// UserPlan is the row behind a plan-based limit.
+type UserPlan struct {
+	UserID        int64      `json:"user_id"`
+	Plan          string     `json:"plan"`
+	DailyTapLimit int64      `json:"daily_tap_limit"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// GetUserTapLimit returns the active plan limit, or base when the user has no
+// plan or it has expired at at.
+func (d *DB) GetUserTapLimit(ctx context.Context, userID int64, base int64, at time.Time) (TapLimit, error) {
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	var out TapLimit
+	var until *time.Time
+	err := d.Pool.QueryRow(ctx, `
+SELECT plan, daily_tap_limit, expires_at
+FROM user_plans
+WHERE user_id=$1 AND (expires_at IS NULL OR expires_at > $2)
+`, userID, at).Scan(&out.Plan, &out.Limit, &until)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return TapLimit{Limit: base, Plan: "base"}, nil
+		}
+		return TapLimit{}, err
+	}
+	if until != nil {
+		out.Until = until.UTC()
+	}
+	return out, nil
+}
+
+// SetUserPlan assigns (or replaces) a user's plan limit. A nil expiresAt
+// keeps the plan until it is changed; an empty plan removes it.
+func (d *DB) SetUserPlan(ctx context.Context, adminID, userID int64, plan string, dailyTapLimit int64, expiresAt *time.Time) (UserPlan, error) {
+	plan = strings.ToLower(strings.TrimSpace(plan))
+	if userID <= 0 || dailyTapLimit < 0 || len(plan) > 32 {
+		return UserPlan{}, errors.New("bad params")
+	}
+	if plan == "" {
+		if _, err := d.Pool.Exec(ctx, `DELETE FROM user_plans WHERE user_id=$1`, userID); err != nil {
+			return UserPlan{}, err
+		}
+		return UserPlan{UserID: userID}, d.markHomeDirty(ctx, userID)
+	}
+	out := UserPlan{UserID: userID}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+INSERT INTO user_plans(user_id, plan, daily_tap_limit, expires_at, updated_by)
+VALUES($1,$2,$3,$4,$5)
+ON CONFLICT (user_id) DO UPDATE
+SET plan=EXCLUDED.plan,
+    daily_tap_limit=EXCLUDED.daily_tap_limit,
+    expires_at=EXCLUDED.expires_at,
+    updated_by=EXCLUDED.updated_by,
+    updated_at=now()
+RETURNING plan, daily_tap_limit, expires_at, updated_at
+`, userID, plan, dailyTapLimit, expiresAt, adminID).Scan(&out.Plan, &out.DailyTapLimit, &out.ExpiresAt, &out.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('admin_user_plan', $1, $2, 0, $3::jsonb)
+`, adminID, userID, toJSON(map[string]any{"plan": plan, "daily_tap_limit": dailyTapLimit, "expires_at": expiresAt}))
+		return err
+	})
+	if err != nil {
+		return UserPlan{}, err
+	}
+	return out, nil
+}
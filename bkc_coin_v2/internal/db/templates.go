@@ -0,0 +1,388 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"bkc_coin_v2/internal/templates"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Notification templates are versioned: a save inserts a new version and
+// never edits an old one, and every sent notification records the version
+// it was rendered with, so an audit can show exactly what a user was told.
+
+// StoredTemplate is a template version saved by an admin.
+type StoredTemplate struct {
+	templates.Template
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveNotificationTemplate validates t and stores it as the next version of
+// its key and language.
+func (d *DB) SaveNotificationTemplate(ctx context.Context, t templates.Template, adminID int64) (StoredTemplate, error) {
+	if err := templates.Validate(t); err != nil {
+		return StoredTemplate{}, err
+	}
+	out := StoredTemplate{Template: t, CreatedBy: adminID}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		// Serialise saves of the same template so versions stay dense.
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('notification_templates:' || $1 || ':' || $2))`, t.Key, t.Lang); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO notification_templates(key, lang, version, subject, body, created_by)
+SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5
+FROM notification_templates WHERE key=$1 AND lang=$2
+RETURNING version, created_at
+`, t.Key, t.Lang, t.Subject, t.Body, adminID).Scan(&out.Version, &out.CreatedAt)
+	})
+	return out, err
+}
+
+// NotificationTemplateVersion loads one version; version 0 is the built-in
+// text and pgx.ErrNoRows means no such version.
+func (d *DB) NotificationTemplateVersion(ctx context.Context, key, lang string, version int64) (StoredTemplate, error) {
+	if version == 0 {
+		t, ok := templates.Builtin(key, lang)
+		if !ok {
+			return StoredTemplate{}, pgx.ErrNoRows
+		}
+		return StoredTemplate{Template: t}, nil
+	}
+	out := StoredTemplate{Template: templates.Template{Key: key, Lang: lang, Version: version}}
+	err := d.Pool.QueryRow(ctx, `
+SELECT subject, body, created_by, created_at FROM notification_templates WHERE key=$1 AND lang=$2 AND version=$3
+`, key, lang, version).Scan(&out.Subject, &out.Body, &out.CreatedBy, &out.CreatedAt)
+	return out, err
+}
+
+// NotificationTemplateVersions is the history of a template, newest first,
+// ending with the built-in version 0.
+func (d *DB) NotificationTemplateVersions(ctx context.Context, key, lang string) ([]StoredTemplate, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT version, subject, body, created_by, created_at FROM notification_templates
+WHERE key=$1 AND lang=$2
+ORDER BY version DESC
+`, key, lang)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StoredTemplate
+	for rows.Next() {
+		s := StoredTemplate{Template: templates.Template{Key: key, Lang: lang}}
+		if err := rows.Scan(&s.Version, &s.Subject, &s.Body, &s.CreatedBy, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if t, ok := templates.Builtin(key, lang); ok {
+		out = append(out, StoredTemplate{Template: t})
+	}
+	return out, nil
+}
+
+// ResolveNotificationTemplate picks what a user with lang is sent: the
+// latest saved version in lang, else the built-in one, falling back to
+// templates.DefaultLang the same way.
+func (d *DB) ResolveNotificationTemplate(ctx context.Context, key, lang string) (templates.Template, error) {
+	langs := []string{lang}
+	if lang != templates.DefaultLang {
+		langs = append(langs, templates.DefaultLang)
+	}
+	for _, l := range langs {
+		t := templates.Template{Key: key, Lang: l}
+		err := d.Pool.QueryRow(ctx, `
+SELECT version, subject, body FROM notification_templates WHERE key=$1 AND lang=$2 ORDER BY version DESC LIMIT 1
+`, key, l).Scan(&t.Version, &t.Subject, &t.Body)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return templates.Template{}, err
+		}
+		if t, ok := templates.Builtin(key, l); ok {
+			return t, nil
+		}
+	}
+	return templates.Template{}, pgx.ErrNoRows
+}
+
+// SetUserLanguage stores the user's notification language, normalised to a
+// supported one.
+func (d *DB) SetUserLanguage(ctx context.Context, userID int64, code string) error {
+	_, err := d.Pool.Exec(ctx, `UPDATE users SET language=$2 WHERE user_id=$1`, userID, templates.NormalizeLang(code))
+	return err
+}
+
+// notifySource maps a ledger kind to the notification it sends and the
+// column holding the recipient, like webhookSources.
+type notifySource struct {
+	key    string
+	toUser bool
+}
+
+var notifySources = map[string]notifySource{
+	"deposit_approve":   {templates.DepositApproved, true},
+	"cryptopay_deposit": {templates.DepositApproved, true},
+	"bank_loan_overdue": {templates.LoanOverdue, false},
+	"nft_market_buy":    {templates.EscrowReleased, true}, // only sales settled from an offer
+}
+
+const (
+	notifyRelayBatch = 1_000
+	notifyLease      = 5 * time.Minute
+)
+
+// Notification statuses.
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+	NotificationMuted   = "muted" // turned off in the user's settings
+)
+
+// Notification is one queued or sent message with the variables it is
+// rendered with.
+type Notification struct {
+	NotificationID  int64          `json:"notification_id"`
+	LedgerID        int64          `json:"ledger_id"` // 0 for digests
+	UserID          int64          `json:"user_id"`
+	Key             string         `json:"key"`
+	Vars            map[string]any `json:"vars"`
+	Lang            string         `json:"lang"`
+	TemplateVersion *int64         `json:"template_version,omitempty"`
+	Status          string         `json:"status"`
+	Attempts        int64          `json:"attempts"`
+	LastError       *string        `json:"last_error,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	SentAt          *time.Time     `json:"sent_at,omitempty"`
+}
+
+// QueueNotifications turns new ledger rows into pending notifications. Run
+// from the notifications job; returns notifications queued.
+func (d *DB) QueueNotifications(ctx context.Context) (int64, error) {
+	kinds := make([]string, 0, len(notifySources))
+	for k := range notifySources {
+		kinds = append(kinds, k)
+	}
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var cursor int64
+		if err := tx.QueryRow(ctx, `SELECT ledger_id FROM notify_relay WHERE id=1 FOR UPDATE`).Scan(&cursor); err != nil {
+			return err
+		}
+		// Same one-minute margin as RelayWebhookEvents.
+		rows, err := tx.Query(ctx, `
+SELECT id, kind, from_id, to_id, amount, meta
+FROM ledger
+WHERE id > $1 AND ts < now() - interval '1 minute' AND kind = ANY($2)
+ORDER BY id
+LIMIT $3
+`, cursor, kinds, notifyRelayBatch)
+		if err != nil {
+			return err
+		}
+		var queued []Notification
+		for rows.Next() {
+			var id, amount int64
+			var kind string
+			var fromID, toID *int64
+			var meta map[string]any
+			if err := rows.Scan(&id, &kind, &fromID, &toID, &amount, &meta); err != nil {
+				rows.Close()
+				return err
+			}
+			cursor = id
+			src := notifySources[kind]
+			userID := fromID
+			if src.toUser {
+				userID = toID
+			}
+			if userID == nil || *userID <= 0 {
+				continue
+			}
+			if src.key == templates.EscrowReleased && meta["offer_id"] == nil {
+				continue
+			}
+			// Every variable a template may use is present, so a template valid
+			// for one source (deposit_id) still renders for another (invoice_id).
+			vars := templates.Sample(src.key)
+			for k := range vars {
+				if v, ok := meta[k]; ok {
+					vars[k] = v
+				} else {
+					vars[k] = int64(0)
+				}
+			}
+			vars["amount"] = amount
+			queued = append(queued, Notification{LedgerID: id, UserID: *userID, Key: src.key, Vars: vars})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, q := range queued {
+			added, err := queueNotificationTx(ctx, tx, &q.LedgerID, q.UserID, q.Key, q.Vars)
+			if err != nil {
+				return err
+			}
+			n += added
+		}
+		_, err = tx.Exec(ctx, `UPDATE notify_relay SET ledger_id=$1, updated_at=now() WHERE id=1`, cursor)
+		return err
+	})
+	return n, err
+}
+
+// queueNotificationTx adds a pending notification, once per ledger row and
+// key; ledgerID is nil for notifications without one (digests).
+// Notifications the user opted out of are logged as muted, not sent.
+func queueNotificationTx(ctx context.Context, tx pgx.Tx, ledgerID *int64, userID int64, key string, vars map[string]any) (int64, error) {
+	tag, err := tx.Exec(ctx, `
+INSERT INTO notification_log(ledger_id, user_id, key, vars, status, next_attempt_at)
+SELECT $1, $2, $3, $4::jsonb,
+  CASE WHEN muted THEN 'muted' ELSE 'pending' END,
+  CASE WHEN muted THEN NULL ELSE now() END
+FROM (
+  SELECT COALESCE((SELECT NOT ($5 = ANY(notify_channels)) OR $3 = ANY(muted_notifications) FROM user_settings WHERE user_id=$2), false) AS muted
+) s
+ON CONFLICT (ledger_id, key) DO NOTHING
+`, ledgerID, userID, key, toJSON(vars), ChannelTelegram)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ClaimNotifications leases up to limit pending notifications, filling in
+// the recipient's first name and language (the settings override, else the
+// Telegram one).
+func (d *DB) ClaimNotifications(ctx context.Context, limit int) ([]Notification, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+UPDATE notification_log n SET next_attempt_at = now() + $2 * interval '1 second'
+FROM users u LEFT JOIN user_settings s ON s.user_id = u.user_id
+WHERE n.notification_id IN (
+  SELECT notification_id FROM notification_log
+  WHERE status='pending' AND next_attempt_at <= now()
+  ORDER BY notification_id
+  LIMIT $1
+  FOR UPDATE SKIP LOCKED
+) AND u.user_id = n.user_id
+RETURNING n.notification_id, COALESCE(n.ledger_id, 0), n.user_id, n.key, n.vars, COALESCE(s.language, u.language, ''), COALESCE(u.first_name, ''), n.attempts, n.created_at
+`, limit, int64(notifyLease/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Notification
+	for rows.Next() {
+		n := Notification{Status: NotificationPending}
+		var raw []byte
+		var firstName string
+		if err := rows.Scan(&n.NotificationID, &n.LedgerID, &n.UserID, &n.Key, &raw, &n.Lang, &firstName, &n.Attempts, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		if n.Vars, err = decodeVars(raw); err != nil {
+			return nil, err
+		}
+		n.Vars["user_id"] = n.UserID
+		n.Vars["first_name"] = firstName
+		n.Lang = templates.NormalizeLang(n.Lang)
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// decodeVars reads stored variables keeping whole numbers integral, so an
+// amount renders as 1200000 rather than 1.2e+06.
+func decodeVars(raw []byte) (map[string]any, error) {
+	vars := map[string]any{}
+	if len(raw) == 0 {
+		return vars, nil
+	}
+	if err := json.Unmarshal(raw, &vars); err != nil {
+		return nil, err
+	}
+	for k, v := range vars {
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			vars[k] = int64(f)
+		}
+	}
+	return vars, nil
+}
+
+// RecordNotification stores the outcome of one send with the language and
+// template version used. A failure is retried with WebhookBackoff until
+// maxAttempts.
+func (d *DB) RecordNotification(ctx context.Context, notificationID int64, lang string, version int64, sendErr string, maxAttempts int64) error {
+	var lastErr *string
+	if sendErr != "" {
+		if len(sendErr) > 500 {
+			sendErr = sendErr[:500]
+		}
+		lastErr = &sendErr
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var attempts int64
+		if err := tx.QueryRow(ctx, `
+UPDATE notification_log SET attempts=attempts+1, lang=$2, template_version=$3, last_error=$4 WHERE notification_id=$1 RETURNING attempts
+`, notificationID, lang, version, lastErr).Scan(&attempts); err != nil {
+			return err
+		}
+		var err error
+		switch {
+		case lastErr == nil:
+			_, err = tx.Exec(ctx, `UPDATE notification_log SET status=$2, sent_at=now(), next_attempt_at=NULL WHERE notification_id=$1`, notificationID, NotificationSent)
+		case attempts >= maxAttempts:
+			_, err = tx.Exec(ctx, `UPDATE notification_log SET status=$2, next_attempt_at=NULL WHERE notification_id=$1`, notificationID, NotificationFailed)
+		default:
+			_, err = tx.Exec(ctx, `UPDATE notification_log SET next_attempt_at=now() + $2 * interval '1 second' WHERE notification_id=$1`, notificationID, int64(WebhookBackoff(attempts)/time.Second))
+		}
+		return err
+	})
+}
+
+// ListNotifications is the notification log, newest first, optionally for
+// one user.
+func (d *DB) ListNotifications(ctx context.Context, userID int64, limit int) ([]Notification, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT notification_id, COALESCE(ledger_id, 0), user_id, key, vars, COALESCE(lang, ''), template_version, status, attempts, last_error, created_at, sent_at
+FROM notification_log
+WHERE $1 = 0 OR user_id = $1
+ORDER BY notification_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Notification
+	for rows.Next() {
+		var n Notification
+		var raw []byte
+		if err := rows.Scan(&n.NotificationID, &n.LedgerID, &n.UserID, &n.Key, &raw, &n.Lang, &n.TemplateVersion, &n.Status, &n.Attempts, &n.LastError, &n.CreatedAt, &n.SentAt); err != nil {
+			return nil, err
+		}
+		if n.Vars, err = decodeVars(raw); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"bkc_coin_v2/internal/templates"
+)
+
+func TestDecodeVars(t *testing.T) {
+	vars, err := decodeVars([]byte(`{"amount": 1200000, "rate": 0.5, "name": "x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["amount"] != int64(1_200_000) || vars["rate"] != 0.5 || vars["name"] != "x" {
+		t.Fatalf("vars %#v", vars)
+	}
+}
+
+func TestNotificationTemplateVersions(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM notification_templates WHERE key=$1 AND lang='en'`, templates.LoanOverdue)
+	})
+
+	if _, err := d.SaveNotificationTemplate(ctx, templates.Template{Key: templates.LoanOverdue, Lang: "en", Body: "{{.nope}}"}, 1); err == nil {
+		t.Fatal("invalid template saved")
+	}
+	for i := 0; i < 2; i++ {
+		s, err := d.SaveNotificationTemplate(ctx, templates.Template{Key: templates.LoanOverdue, Lang: "en", Subject: "Overdue", Body: "Loan {{.loan_id}}"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Version != int64(i+1) {
+			t.Fatalf("version %d, want %d", s.Version, i+1)
+		}
+	}
+	got, err := d.ResolveNotificationTemplate(ctx, templates.LoanOverdue, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 || got.Body != "Loan {{.loan_id}}" {
+		t.Fatalf("resolved %+v", got)
+	}
+	versions, err := d.NotificationTemplateVersions(ctx, templates.LoanOverdue, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[2].Version != 0 {
+		t.Fatalf("versions %+v", versions)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+// Deadlines reached by moving d.Clock instead of waiting for them.
+
+func TestBankLoanOverdueByClock(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const userID = 9_300_700_000
+	seedMoneyUsers(t, d, 1_000, userID)
+
+	loan, err := d.CreateBankLoan(ctx, userID, 500, 500, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loan.DueAt.Equal(loan.CreatedAt.Add(7 * 24 * time.Hour)) {
+		t.Fatalf("created %v, due %v", loan.CreatedAt, loan.DueAt)
+	}
+	status := func() string {
+		t.Helper()
+		if _, err := d.MarkOverdueBankLoans(ctx, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		loans, err := d.ListBankLoansByUser(ctx, userID, 10)
+		if err != nil || len(loans) != 1 {
+			t.Fatalf("loans %+v, %v", loans, err)
+		}
+		return loans[0].Status
+	}
+
+	clk.Advance(7*24*time.Hour - time.Minute)
+	if s := status(); s != "active" {
+		t.Fatalf("a minute before due: %s", s)
+	}
+	clk.Advance(2 * time.Minute)
+	if s := status(); s != "overdue" {
+		t.Fatalf("a minute after due: %s", s)
+	}
+	u, err := d.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1_000 + loan.Principal - loan.TotalDue; u.Balance != want {
+		t.Fatalf("balance %d, want %d", u.Balance, want)
+	}
+	checkLedger(t, d, userID, 1_000)
+}
+
+func TestGigDeadlinesByClock(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	d.Clock = clk
+	const buyer, seller = 9_300_700_010, 9_300_700_011
+	seedMoneyUsers(t, d, 1_000, buyer, seller)
+
+	var listingID int64
+	if err := d.Pool.QueryRow(ctx, `
+INSERT INTO market_listings(seller_id, title, description, category, price_coins, contact)
+VALUES($1, 'clock gig', 'clock', $2, 100, '@clock')
+RETURNING listing_id
+`, seller, ServicesCategory).Scan(&listingID); err != nil {
+		t.Fatal(err)
+	}
+	deadline := clk.Now().Add(48 * time.Hour)
+	gig, err := d.CreateGig(ctx, buyer, listingID, []GigMilestoneInput{
+		{Title: "refunded", Amount: 100, Deadline: deadline},
+		{Title: "never funded", Amount: 200, Deadline: deadline},
+		{Title: "reviewed", Amount: 300, Deadline: deadline.Add(24 * time.Hour)},
+	}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refunded, unfunded, reviewed := gig.Milestones[0].MilestoneID, gig.Milestones[1].MilestoneID, gig.Milestones[2].MilestoneID
+	for _, m := range []int64{refunded, reviewed} {
+		if _, err := d.FundGigMilestone(ctx, buyer, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.DeliverGigMilestone(ctx, seller, reviewed); err != nil {
+		t.Fatal(err)
+	}
+
+	const review = 72 * time.Hour
+	clk.Advance(49 * time.Hour)
+	if _, err := d.FundGigMilestone(ctx, buyer, unfunded); !errors.Is(err, ErrGigState) {
+		t.Fatalf("funding past the deadline: %v", err)
+	}
+	if _, err := d.ExpireGigMilestones(ctx, clk.Now(), review); err != nil {
+		t.Fatal(err)
+	}
+	states := func() map[int64]string {
+		t.Helper()
+		g, err := d.GetGig(ctx, buyer, gig.ContractID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[int64]string{}
+		for _, m := range g.Milestones {
+			out[m.MilestoneID] = m.Status
+		}
+		return out
+	}
+	if s := states(); s[refunded] != GigRefunded || s[unfunded] != GigCancelled || s[reviewed] != GigDelivered {
+		t.Fatalf("after the deadline: %v", s)
+	}
+
+	// Delivered at the start; the review window ends 72h later.
+	clk.Advance(review - 49*time.Hour + time.Minute)
+	if _, err := d.ExpireGigMilestones(ctx, clk.Now(), review); err != nil {
+		t.Fatal(err)
+	}
+	if s := states(); s[reviewed] != GigReleased {
+		t.Fatalf("after review: %v", s)
+	}
+	for id, want := range map[int64]int64{buyer: 1_000 - 300, seller: 1_000 + 300} {
+		u, err := d.GetUser(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Balance != want {
+			t.Fatalf("user %d: balance %d, want %d", id, u.Balance, want)
+		}
+		checkLedger(t, d, id, 1_000)
+	}
+}
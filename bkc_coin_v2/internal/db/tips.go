@@ -0,0 +1,397 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Tips are small transfers to creators. Balances move at once, but the
+// ledger gets one 'tip' row per sender and recipient for each booking run
+// (BookTips) instead of a row per tip; the tips table keeps every tip and
+// the ledger row it was booked into. Tips up to TipPolicy.FeeFreeMax are
+// free, larger ones pay FeeBP to the reserve. Recurring tips are paid by the
+// recurring_tips job until cancelled or until they fail for lack of funds
+// maxRecurringTipFailures times in a row.
+
+// Recurring tip periods.
+const (
+	TipDaily   = "daily"
+	TipWeekly  = "weekly"
+	TipMonthly = "monthly"
+)
+
+const (
+	maxTipMessage           = 140 // runes
+	maxRecurringTipFailures = 3
+	tipBookBatch            = 5_000
+	recurringTipBatch       = 200
+)
+
+// TipPolicy is the tip fee and size limit. Tips count toward the sender's
+// transfer velocity like transfers do.
+type TipPolicy struct {
+	FeeFreeMax int64 // tips up to this are fee-free
+	FeeBP      int64
+	MaxAmount  int64
+	Velocity   VelocityPolicy
+}
+
+// fee is the fee on a tip of amount.
+func (p TipPolicy) fee(amount int64) int64 {
+	if amount <= p.FeeFreeMax {
+		return 0
+	}
+	return interestFromBP(amount, p.FeeBP)
+}
+
+// Tip is one tip, booked into the ledger row LedgerID once BookTips ran.
+type Tip struct {
+	TipID     int64     `json:"tip_id"`
+	FromID    int64     `json:"from_id"`
+	ToID      int64     `json:"to_id"`
+	Amount    int64     `json:"amount"`
+	Fee       int64     `json:"fee"`
+	Message   string    `json:"message"`
+	Recurring *int64    `json:"recurring_id,omitempty"`
+	LedgerID  *int64    `json:"ledger_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecurringTip is a standing tip paid every period.
+type RecurringTip struct {
+	RecurringID int64     `json:"recurring_id"`
+	FromID      int64     `json:"from_id"`
+	ToID        int64     `json:"to_id"`
+	Amount      int64     `json:"amount"`
+	Period      string    `json:"period"`
+	NextAt      time.Time `json:"next_at"`
+	Failures    int64     `json:"failures"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TipperEntry is a supporter on a creator's tip leaderboard.
+type TipperEntry struct {
+	Rank      int64  `json:"rank"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	Total     int64  `json:"total"`
+	Tips      int64  `json:"tips"`
+}
+
+const tipCols = `tip_id, from_id, to_id, amount, fee, message, recurring_id, ledger_id, created_at`
+
+func scanTip(row pgx.Row) (Tip, error) {
+	var t Tip
+	err := row.Scan(&t.TipID, &t.FromID, &t.ToID, &t.Amount, &t.Fee, &t.Message, &t.Recurring, &t.LedgerID, &t.CreatedAt)
+	return t, err
+}
+
+const recurringTipCols = `recurring_id, from_id, to_id, amount, period, next_at, failures, active, created_at`
+
+func scanRecurringTip(row pgx.Row) (RecurringTip, error) {
+	var r RecurringTip
+	err := row.Scan(&r.RecurringID, &r.FromID, &r.ToID, &r.Amount, &r.Period, &r.NextAt, &r.Failures, &r.Active, &r.CreatedAt)
+	return r, err
+}
+
+// nextTipAt is the first run of period after at that is later than now;
+// periods missed while the job was down are skipped, not paid in a burst.
+func nextTipAt(at time.Time, period string, now time.Time) time.Time {
+	for !at.After(now) {
+		switch period {
+		case TipDaily:
+			at = at.AddDate(0, 0, 1)
+		case TipWeekly:
+			at = at.AddDate(0, 0, 7)
+		default:
+			at = at.AddDate(0, 1, 0)
+		}
+	}
+	return at
+}
+
+// SendTip tips amount plus the fee from fromID to toID.
+func (d *DB) SendTip(ctx context.Context, fromID, toID, amount int64, message string, p TipPolicy) (Tip, error) {
+	var out Tip
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = sendTipTx(ctx, tx, fromID, toID, amount, message, nil, p)
+		return err
+	})
+	var ve *VelocityError
+	if errors.As(err, &ve) {
+		if aerr := d.recordVelocityAlert(ctx, fromID, toID, amount, ve); aerr != nil {
+			return Tip{}, aerr
+		}
+	}
+	return out, err
+}
+
+func sendTipTx(ctx context.Context, tx pgx.Tx, fromID, toID, amount int64, message string, recurringID *int64, p TipPolicy) (Tip, error) {
+	message = strings.TrimSpace(message)
+	if fromID <= 0 || toID <= 0 || fromID == toID || amount <= 0 || (p.MaxAmount > 0 && amount > p.MaxAmount) {
+		return Tip{}, errors.New("bad params")
+	}
+	if !utf8.ValidString(message) || utf8.RuneCountInString(message) > maxTipMessage {
+		return Tip{}, errors.New("bad message")
+	}
+	fee := p.fee(amount)
+	if err := lockUsersTx(ctx, tx, fromID, toID); err != nil {
+		return Tip{}, err
+	}
+	if err := checkVelocityTx(ctx, tx, fromID, toID, amount, p.Velocity); err != nil {
+		return Tip{}, err
+	}
+	if err := debitSpendableTx(ctx, tx, fromID, amount+fee); err != nil {
+		return Tip{}, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance = balance + $1 WHERE user_id=$2`, amount, toID); err != nil {
+		return Tip{}, err
+	}
+	t, err := scanTip(tx.QueryRow(ctx, `
+INSERT INTO tips(from_id, to_id, amount, fee, message, recurring_id) VALUES($1, $2, $3, $4, $5, $6)
+RETURNING `+tipCols, fromID, toID, amount, fee, message, recurringID))
+	if err != nil {
+		return Tip{}, err
+	}
+	if fee > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, fee); err != nil {
+			return Tip{}, err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('tip_fee', $1, NULL, $2, $3::jsonb)`,
+			fromID, fee, toJSON(map[string]any{"tip_id": t.TipID})); err != nil {
+			return Tip{}, err
+		}
+	}
+	if err := addUserEventTx(ctx, tx, toID, "tip_received", map[string]any{"tip_id": t.TipID, "from_id": fromID, "amount": amount, "message": message}); err != nil {
+		return Tip{}, err
+	}
+	return t, nil
+}
+
+// ListTips returns the user's recent tips, sent and received, newest first.
+func (d *DB) ListTips(ctx context.Context, userID int64, limit int) ([]Tip, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+tipCols+` FROM tips
+WHERE from_id=$1 OR to_id=$1
+ORDER BY tip_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Tip{}
+	for rows.Next() {
+		t, err := scanTip(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// BookTips writes unbooked tips to the ledger, one 'tip' row per sender and
+// recipient. Returns the number of tips booked.
+func (d *DB) BookTips(ctx context.Context) (int64, error) {
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+SELECT tip_id, from_id, to_id, amount FROM tips
+WHERE ledger_id IS NULL
+ORDER BY tip_id
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`, tipBookBatch)
+		if err != nil {
+			return err
+		}
+		type pair struct{ from, to int64 }
+		type batch struct {
+			amount int64
+			ids    []int64
+		}
+		batches := map[pair]*batch{}
+		var order []pair
+		for rows.Next() {
+			var id, amount int64
+			var k pair
+			if err := rows.Scan(&id, &k.from, &k.to, &amount); err != nil {
+				rows.Close()
+				return err
+			}
+			b := batches[k]
+			if b == nil {
+				b = &batch{}
+				batches[k] = b
+				order = append(order, k)
+			}
+			b.amount += amount
+			b.ids = append(b.ids, id)
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, k := range order {
+			b := batches[k]
+			var ledgerID int64
+			if err := tx.QueryRow(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('tip', $1, $2, $3, $4::jsonb) RETURNING id`,
+				k.from, k.to, b.amount, toJSON(map[string]any{"tips": len(b.ids), "first_tip_id": b.ids[0], "last_tip_id": b.ids[len(b.ids)-1]}),
+			).Scan(&ledgerID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE tips SET ledger_id=$1 WHERE tip_id = ANY($2)`, ledgerID, b.ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// CreateRecurringTip starts tipping amount to toID every period, first now.
+func (d *DB) CreateRecurringTip(ctx context.Context, fromID, toID, amount int64, period string, p TipPolicy) (RecurringTip, error) {
+	if period != TipDaily && period != TipWeekly && period != TipMonthly {
+		return RecurringTip{}, errors.New("bad period")
+	}
+	if fromID <= 0 || toID <= 0 || fromID == toID || amount <= 0 || (p.MaxAmount > 0 && amount > p.MaxAmount) {
+		return RecurringTip{}, errors.New("bad params")
+	}
+	out, err := scanRecurringTip(d.Pool.QueryRow(ctx, `
+INSERT INTO recurring_tips(from_id, to_id, amount, period, next_at)
+SELECT $1, $2, $3, $4, now() WHERE EXISTS(SELECT 1 FROM users WHERE user_id=$2)
+RETURNING `+recurringTipCols, fromID, toID, amount, period))
+	return out, err
+}
+
+// ListRecurringTips returns the user's recurring tips, active first.
+func (d *DB) ListRecurringTips(ctx context.Context, fromID int64) ([]RecurringTip, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT `+recurringTipCols+` FROM recurring_tips WHERE from_id=$1 ORDER BY active DESC, recurring_id DESC`, fromID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []RecurringTip{}
+	for rows.Next() {
+		r, err := scanRecurringTip(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// CancelRecurringTip stops the user's recurring tip.
+func (d *DB) CancelRecurringTip(ctx context.Context, fromID, recurringID int64) error {
+	tag, err := d.Pool.Exec(ctx, `UPDATE recurring_tips SET active=false WHERE recurring_id=$1 AND from_id=$2 AND active`, recurringID, fromID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RunRecurringTips pays recurring tips due at now, each in its own
+// transaction. A tip the sender cannot afford (or to a recipient that is
+// gone) counts a failure and is retried next period; maxRecurringTipFailures in a row stop it. Returns the
+// number of tips paid.
+func (d *DB) RunRecurringTips(ctx context.Context, now time.Time, p TipPolicy) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT recurring_id FROM recurring_tips WHERE active AND next_at <= $1 ORDER BY next_at LIMIT $2`, now, recurringTipBatch)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var paid int64
+	for _, id := range ids {
+		err := d.WithTx(ctx, func(tx pgx.Tx) error {
+			r, err := scanRecurringTip(tx.QueryRow(ctx, `
+SELECT `+recurringTipCols+` FROM recurring_tips WHERE recurring_id=$1 AND active AND next_at <= $2 FOR UPDATE SKIP LOCKED
+`, id, now))
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			next := nextTipAt(r.NextAt, r.Period, now)
+			_, err = sendTipTx(ctx, tx, r.FromID, r.ToID, r.Amount, "", &r.RecurringID, p)
+			if errors.Is(err, ErrNotEnough) || errors.Is(err, ErrVelocity) || errors.Is(err, pgx.ErrNoRows) {
+				failures := r.Failures + 1
+				active := failures < maxRecurringTipFailures
+				if _, err := tx.Exec(ctx, `UPDATE recurring_tips SET failures=$2, active=$3, next_at=$4 WHERE recurring_id=$1`, id, failures, active, next); err != nil {
+					return err
+				}
+				return addUserEventTx(ctx, tx, r.FromID, "recurring_tip_failed", map[string]any{"recurring_id": id, "to_id": r.ToID, "amount": r.Amount, "stopped": !active})
+			}
+			if err != nil {
+				return err
+			}
+			paid++
+			_, err = tx.Exec(ctx, `UPDATE recurring_tips SET failures=0, next_at=$2 WHERE recurring_id=$1`, id, next)
+			return err
+		})
+		if err != nil {
+			return paid, err
+		}
+	}
+	return paid, nil
+}
+
+// TipLeaderboard ranks the creator's supporters by what they tipped since
+// since; users hidden from leaderboards are left out.
+func (d *DB) TipLeaderboard(ctx context.Context, creatorID int64, since time.Time, limit int) ([]TipperEntry, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT t.from_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), SUM(t.amount), COUNT(*)
+FROM tips t
+JOIN users u ON u.user_id = t.from_id
+LEFT JOIN user_settings s ON s.user_id = t.from_id
+WHERE t.to_id=$1 AND t.created_at >= $2 AND NOT COALESCE(s.hide_from_leaderboards, false)
+GROUP BY t.from_id, u.username, u.first_name
+ORDER BY SUM(t.amount) DESC, t.from_id
+LIMIT $3
+`, creatorID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TipperEntry{}
+	for rows.Next() {
+		e := TipperEntry{Rank: int64(len(out)) + 1}
+		if err := rows.Scan(&e.UserID, &e.Username, &e.FirstName, &e.Total, &e.Tips); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
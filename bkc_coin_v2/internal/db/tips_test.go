@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTipFee(t *testing.T) {
+	p := TipPolicy{FeeFreeMax: 1_000, FeeBP: 100}
+	cases := []struct{ amount, want int64 }{
+		{1, 0},
+		{1_000, 0}, // at the threshold still free
+		{1_001, 10},
+		{50_000, 500},
+	}
+	for _, c := range cases {
+		if got := p.fee(c.amount); got != c.want {
+			t.Fatalf("fee(%d) = %d, want %d", c.amount, got, c.want)
+		}
+	}
+}
+
+func TestNextTipAt(t *testing.T) {
+	at := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	if got := nextTipAt(at, TipDaily, at); !got.Equal(at.AddDate(0, 0, 1)) {
+		t.Fatalf("daily next %v", got)
+	}
+	// Missed periods are skipped, not paid in a burst.
+	now := at.AddDate(0, 0, 20)
+	if got := nextTipAt(at, TipWeekly, now); !got.Equal(at.AddDate(0, 0, 21)) {
+		t.Fatalf("weekly next %v", got)
+	}
+	if got := nextTipAt(at, TipMonthly, at); !got.After(at) {
+		t.Fatalf("monthly next %v", got)
+	}
+}
+
+func TestTipVelocity(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+	const from, creator, other = 9_301_300_011, 9_301_300_012, 9_301_300_013
+	cleanup := func() {
+		_, _ = d.Pool.Exec(context.Background(), `DELETE FROM tips WHERE from_id=$1`, from)
+	}
+	cleanup()
+	seedMoneyUsers(t, d, 1_000, from, creator, other)
+	t.Cleanup(cleanup)
+
+	// The tip is not in the ledger until BookTips, yet it uses up the volume.
+	p := TipPolicy{Velocity: VelocityPolicy{DailyVolume: 150}}
+	if _, err := d.SendTip(ctx, from, creator, 100, "", p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SendTip(ctx, from, creator, 100, "", p); !errors.Is(err, ErrVelocity) {
+		t.Fatalf("second tip: %v", err)
+	}
+	if err := d.Transfer(ctx, from, other, 100, p.Velocity); !errors.Is(err, ErrVelocity) {
+		t.Fatalf("transfer after a tip: %v", err)
+	}
+}
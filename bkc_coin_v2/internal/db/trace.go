@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryConfig bounds and observes every query sent through DB.Pool. The zero
+// value leaves queries untouched.
+type QueryConfig struct {
+	// Timeout caps each query; a caller deadline that is sooner wins. 0 = none.
+	Timeout time.Duration
+	// SlowThreshold logs queries that take longer, with arguments redacted.
+	// 0 = off.
+	SlowThreshold time.Duration
+	// Registerer receives bkc_db_query_seconds and bkc_db_query_timeouts_total,
+	// labelled by the DB method that ran the query. nil = not exported.
+	Registerer prometheus.Registerer
+	// Logf prints slow queries; nil is log.Printf.
+	Logf func(format string, args ...any)
+}
+
+func (c QueryConfig) enabled() bool {
+	return c.Timeout > 0 || c.SlowThreshold > 0 || c.Registerer != nil
+}
+
+// ConnectWith is Connect with per-query timeouts, slow-query logging and
+// latency metrics.
+func ConnectWith(ctx context.Context, databaseURL string, qc QueryConfig) (*DB, error) {
+	cfg, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxConns = 5
+	if qc.enabled() {
+		cfg.ConnConfig.Tracer = newQueryTracer(qc)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{Pool: pool}, nil
+}
+
+type noTimeoutKey struct{}
+
+// WithoutQueryTimeout exempts queries run with ctx from QueryConfig.Timeout:
+// migrations and full scans that are slow by design.
+func WithoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noTimeoutKey{}, true)
+}
+
+// queryTracer implements pgx.QueryTracer. pgx runs the query on the context
+// TraceQueryStart returns and hands it back to TraceQueryEnd, once the result
+// is read or the rows are closed, so the timeout covers the whole query.
+// Batches and COPY are not traced.
+type queryTracer struct {
+	cfg      QueryConfig
+	latency  *prometheus.HistogramVec
+	timeouts *prometheus.CounterVec
+}
+
+func newQueryTracer(cfg QueryConfig) *queryTracer {
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	t := &queryTracer{
+		cfg: cfg,
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bkc_db_query_seconds",
+			Help:    "Postgres query latency by DB method.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"method"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bkc_db_query_timeouts_total",
+			Help: "Postgres queries cancelled by the per-query timeout, by DB method.",
+		}, []string{"method"}),
+	}
+	if cfg.Registerer != nil {
+		cfg.Registerer.MustRegister(t.latency, t.timeouts)
+	}
+	return t
+}
+
+type traceKey struct{}
+
+type queryTrace struct {
+	method string
+	sql    string
+	args   []any
+	start  time.Time
+	cancel context.CancelFunc
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	q := &queryTrace{method: callerMethod(), sql: data.SQL, args: data.Args, start: time.Now()}
+	if t.cfg.Timeout > 0 && ctx.Value(noTimeoutKey{}) == nil {
+		ctx, q.cancel = context.WithTimeout(ctx, t.cfg.Timeout)
+	}
+	return context.WithValue(ctx, traceKey{}, q)
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(traceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	took := time.Since(q.start)
+	// Read before cancel: afterwards ctx.Err is always set.
+	timedOut := q.cancel != nil && data.Err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if q.cancel != nil {
+		q.cancel()
+	}
+	t.latency.WithLabelValues(q.method).Observe(took.Seconds())
+	if timedOut {
+		t.timeouts.WithLabelValues(q.method).Inc()
+	}
+	if t.cfg.SlowThreshold > 0 && took >= t.cfg.SlowThreshold {
+		t.cfg.Logf("db: slow query in %s took %v (err=%v): %s args=%s",
+			q.method, took.Round(time.Millisecond), data.Err, compactSQL(q.sql), redactArgs(q.args))
+	}
+}
+
+// methodPrefix is "<import path>.(*DB).".
+var methodPrefix = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf((*DB).Close).Pointer()).Name(), "Close")
+
+// callerMethod names the outermost DB method on the stack, so queries from
+// shared helpers count towards the method that called them. Queries that do
+// not come from a DB method are "other".
+func callerMethod() string {
+	var pcs [64]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	method := "other"
+	for {
+		f, more := frames.Next()
+		if name, ok := strings.CutPrefix(f.Function, methodPrefix); ok {
+			// Closures are "Method.func1".
+			method, _, _ = strings.Cut(name, ".")
+		}
+		if !more {
+			return method
+		}
+	}
+}
+
+const maxLoggedSQL = 300
+
+// compactSQL folds whitespace and truncates sql for one log line.
+func compactSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedSQL {
+		sql = sql[:maxLoggedSQL] + "…"
+	}
+	return sql
+}
+
+// redactArgs describes query arguments by type (and length for strings and
+// bytes) only: they carry user ids, balances, addresses and tokens.
+func redactArgs(args []any) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case nil:
+			parts[i] = "nil"
+		case string:
+			parts[i] = fmt.Sprintf("string(%d)", len(v))
+		case []byte:
+			parts[i] = fmt.Sprintf("[]byte(%d)", len(v))
+		default:
+			parts[i] = fmt.Sprintf("%T", a)
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/testenv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRedactArgs(t *testing.T) {
+	got := redactArgs([]any{int64(7), "secret-token", []byte("abc"), nil, time.Time{}})
+	if want := "[int64 string(12) []byte(3) nil time.Time]"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got := compactSQL("\nSELECT 1\n  FROM   users\n"); got != "SELECT 1 FROM users" {
+		t.Fatalf("compact: %q", got)
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	url := testenv.Postgres(t)
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	var mu sync.Mutex
+	var logged []string
+	d, err := ConnectWith(ctx, url, QueryConfig{
+		Timeout:       200 * time.Millisecond,
+		SlowThreshold: 100 * time.Millisecond,
+		Registerer:    reg,
+		Logf: func(format string, args ...any) {
+			mu.Lock()
+			logged = append(logged, fmt.Sprintf(format, args...))
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Slow but within the timeout: logged without the argument value.
+	if _, err := d.Pool.Exec(ctx, `SELECT pg_sleep(0.15), $1::text`, "secret-token"); err != nil {
+		t.Fatal(err)
+	}
+	// Over the timeout: cancelled unless exempt.
+	if _, err := d.Pool.Exec(ctx, `SELECT pg_sleep(1)`); err == nil {
+		t.Fatal("query outlived the timeout")
+	}
+	if _, err := d.Pool.Exec(WithoutQueryTimeout(ctx), `SELECT pg_sleep(0.3)`); err != nil {
+		t.Fatalf("exempt query: %v", err)
+	}
+	if _, err := d.GetUser(ctx, 9_300_800_000); err == nil {
+		t.Fatal("unknown user found")
+	}
+
+	mu.Lock()
+	joined := strings.Join(logged, "\n")
+	mu.Unlock()
+	if !strings.Contains(joined, "pg_sleep(0.15)") || !strings.Contains(joined, "string(12)") || strings.Contains(joined, "secret-token") {
+		t.Fatalf("slow log:\n%s", joined)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]uint64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			method := m.GetLabel()[0].GetValue()
+			switch f.GetName() {
+			case "bkc_db_query_seconds":
+				counts[method] += m.GetHistogram().GetSampleCount()
+			case "bkc_db_query_timeouts_total":
+				counts["timeouts:"+method] += uint64(m.GetCounter().GetValue())
+			}
+		}
+	}
+	if counts["GetUser"] == 0 || counts["Migrate"] == 0 || counts["timeouts:other"] != 1 {
+		t.Fatalf("metrics %v", counts)
+	}
+}
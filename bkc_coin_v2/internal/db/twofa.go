@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/totp"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Step-up actions that require a second factor.
+const (
+	StepUpWithdraw     = "withdraw"
+	StepUpWalletChange = "wallet_change"
+	StepUpTransfer     = "transfer"
+	StepUpVesting      = "vesting_withdraw"
+	StepUpAPIKey       = "api_key"
+)
+
+const (
+	backupCodesCount = 10
+	stepUpTTL        = 5 * time.Minute
+)
+
+type TwoFactorStatus struct {
+	TOTPEnabled     bool `json:"totp_enabled"`
+	BackupCodesLeft int  `json:"backup_codes_left"`
+}
+
+// StepUpChallenge is a Telegram confirmation of one sensitive action:
+// pending until the user taps confirm/decline in the bot, then used once.
+type StepUpChallenge struct {
+	ChallengeID int64     `json:"challenge_id"`
+	UserID      int64     `json:"user_id"`
+	Action      string    `json:"action"`
+	Amount      int64     `json:"amount"`
+	Status      string    `json:"status"` // pending|approved|denied|used
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func hashBackupCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func newBackupCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	c := base32.StdEncoding.EncodeToString(buf) // 8 chars
+	return c[:4] + "-" + c[4:], nil
+}
+
+func (d *DB) GetTwoFactorStatus(ctx context.Context, userID int64) (TwoFactorStatus, error) {
+	var st TwoFactorStatus
+	err := d.Pool.QueryRow(ctx, `
+SELECT COALESCE((SELECT enabled FROM user_2fa WHERE user_id=$1), false),
+       (SELECT COUNT(*) FROM user_backup_codes WHERE user_id=$1 AND used_at IS NULL)
+`, userID).Scan(&st.TOTPEnabled, &st.BackupCodesLeft)
+	return st, err
+}
+
+// BeginTOTPEnrollment stores a new, not yet enabled secret and returns it.
+// Enrollment completes with ConfirmTOTPEnrollment.
+func (d *DB) BeginTOTPEnrollment(ctx context.Context, userID int64) (string, error) {
+	if userID <= 0 {
+		return "", errors.New("bad params")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	tag, err := d.Pool.Exec(ctx, `
+INSERT INTO user_2fa (user_id, secret, enabled)
+VALUES ($1,$2,false)
+ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, last_step=0
+WHERE user_2fa.enabled=false
+`, userID, secret)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", ErrAlreadyExists
+	}
+	return secret, nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves the app is set up,
+// and issues a fresh set of one-time backup codes.
+func (d *DB) ConfirmTOTPEnrollment(ctx context.Context, userID int64, code string) ([]string, error) {
+	var codes []string
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var secret string
+		var enabled bool
+		if err := tx.QueryRow(ctx, `SELECT secret, enabled FROM user_2fa WHERE user_id=$1 FOR UPDATE`, userID).Scan(&secret, &enabled); err != nil {
+			return err
+		}
+		if enabled {
+			return ErrAlreadyExists
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return errors.New("bad code")
+		}
+		if _, err := tx.Exec(ctx, `UPDATE user_2fa SET enabled=true, last_step=$1, enabled_at=now() WHERE user_id=$2`, step, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM user_backup_codes WHERE user_id=$1`, userID); err != nil {
+			return err
+		}
+		for i := 0; i < backupCodesCount; i++ {
+			c, err := newBackupCode()
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO user_backup_codes (user_id, code_hash) VALUES ($1,$2)`, userID, hashBackupCode(c)); err != nil {
+				return err
+			}
+			codes = append(codes, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns TOTP off after checking a current code or backup code.
+func (d *DB) DisableTOTP(ctx context.Context, userID int64, code string) error {
+	ok, err := d.VerifySecondFactor(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("bad code")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM user_2fa WHERE user_id=$1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM user_backup_codes WHERE user_id=$1`, userID)
+		return err
+	})
+}
+
+// VerifySecondFactor accepts a TOTP code (each time step only once) or an
+// unused backup code, which is burned.
+func (d *DB) VerifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if userID <= 0 || code == "" {
+		return false, nil
+	}
+	ok := false
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var secret string
+		var lastStep int64
+		err := tx.QueryRow(ctx, `SELECT secret, last_step FROM user_2fa WHERE user_id=$1 AND enabled FOR UPDATE`, userID).Scan(&secret, &lastStep)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if step, valid := totp.Validate(secret, code, time.Now()); valid {
+			if step <= lastStep {
+				return nil // replayed code
+			}
+			ok = true
+			_, err := tx.Exec(ctx, `UPDATE user_2fa SET last_step=$1 WHERE user_id=$2`, step, userID)
+			return err
+		}
+		tag, err := tx.Exec(ctx, `UPDATE user_backup_codes SET used_at=now() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, hashBackupCode(code))
+		if err != nil {
+			return err
+		}
+		ok = tag.RowsAffected() > 0
+		return nil
+	})
+	return ok, err
+}
+
+// CreateStepUpChallenge opens a Telegram confirmation for an action.
+func (d *DB) CreateStepUpChallenge(ctx context.Context, userID int64, action string, amount int64) (StepUpChallenge, error) {
+	action = strings.TrimSpace(action)
+	if userID <= 0 || action == "" || amount < 0 {
+		return StepUpChallenge{}, errors.New("bad params")
+	}
+	now := time.Now().UTC()
+	c := StepUpChallenge{UserID: userID, Action: action, Amount: amount, Status: "pending", ExpiresAt: now.Add(stepUpTTL), CreatedAt: now}
+	err := d.Pool.QueryRow(ctx, `
+INSERT INTO stepup_challenges (user_id, action, amount, status, expires_at, created_at)
+VALUES ($1,$2,$3,'pending',$4,$5)
+RETURNING challenge_id
+`, userID, action, amount, c.ExpiresAt, now).Scan(&c.ChallengeID)
+	if err != nil {
+		return StepUpChallenge{}, err
+	}
+	return c, nil
+}
+
+func (d *DB) GetStepUpChallenge(ctx context.Context, userID, challengeID int64) (StepUpChallenge, error) {
+	var c StepUpChallenge
+	err := d.Pool.QueryRow(ctx, `
+SELECT challenge_id, user_id, action, amount, status, expires_at, created_at
+FROM stepup_challenges
+WHERE challenge_id=$1 AND user_id=$2
+`, challengeID, userID).Scan(&c.ChallengeID, &c.UserID, &c.Action, &c.Amount, &c.Status, &c.ExpiresAt, &c.CreatedAt)
+	return c, err
+}
+
+// ResolveStepUpChallenge records the user's answer from the bot.
+func (d *DB) ResolveStepUpChallenge(ctx context.Context, userID, challengeID int64, approve bool) (StepUpChallenge, error) {
+	status := "denied"
+	if approve {
+		status = "approved"
+	}
+	var c StepUpChallenge
+	err := d.Pool.QueryRow(ctx, `
+UPDATE stepup_challenges
+SET status=$1
+WHERE challenge_id=$2 AND user_id=$3 AND status='pending' AND expires_at > now()
+RETURNING challenge_id, user_id, action, amount, status, expires_at, created_at
+`, status, challengeID, userID).Scan(&c.ChallengeID, &c.UserID, &c.Action, &c.Amount, &c.Status, &c.ExpiresAt, &c.CreatedAt)
+	return c, err
+}
+
+// ConsumeStepUpChallenge uses an approved, unexpired challenge for action.
+// An amount-bound challenge covers amounts up to the confirmed one.
+func (d *DB) ConsumeStepUpChallenge(ctx context.Context, userID, challengeID int64, action string, amount int64) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `
+UPDATE stepup_challenges
+SET status='used'
+WHERE challenge_id=$1 AND user_id=$2 AND action=$3 AND amount >= $4 AND status='approved' AND expires_at > now()
+`, challengeID, userID, action, amount)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrVelocity matches every *VelocityError.
+var ErrVelocity = errors.New("velocity limit")
+
+// Velocity rules (VelocityError.Rule).
+const (
+	VelocityDailyVolume    = "daily_volume"
+	VelocityCounterparties = "counterparties"
+	VelocityNewAccount     = "new_account"
+)
+
+// KYC tiers (users.kyc_tier).
+const (
+	KYCNone  = 0
+	KYCBasic = 1 // limits multiplied by VelocityPolicy.KYCMultiplier, no cooldown
+	KYCFull  = 2 // exempt from velocity rules
+)
+
+// VelocityPolicy limits outgoing transfers. Zero disables a rule.
+type VelocityPolicy struct {
+	DailyVolume         int64 // coins sent in the last 24h
+	DailyCounterparties int64 // distinct recipients per UTC day
+	NewAccountCooldown  time.Duration
+	KYCMultiplier       int64
+}
+
+// VelocityError is a transfer refused by a velocity rule.
+type VelocityError struct {
+	Rule    string
+	Limit   int64
+	Current int64 // usage before the refused transfer
+}
+
+func (e *VelocityError) Error() string {
+	return fmt.Sprintf("velocity limit %s: %d of %d used", e.Rule, e.Current, e.Limit)
+}
+
+func (e *VelocityError) Is(target error) bool { return target == ErrVelocity }
+
+// VelocityUsage is a user's standing against the policy.
+type VelocityUsage struct {
+	KYCTier        int64      `json:"kyc_tier"`
+	Volume24h      int64      `json:"volume_24h"`
+	VolumeLimit    int64      `json:"volume_limit"` // 0 = unlimited
+	Counterparties int64      `json:"counterparties"`
+	CounterLimit   int64      `json:"counterparties_limit"` // 0 = unlimited
+	CooldownUntil  *time.Time `json:"cooldown_until,omitempty"`
+}
+
+// VelocityAlert is a logged violation.
+type VelocityAlert struct {
+	AlertID   int64     `json:"alert_id"`
+	UserID    int64     `json:"user_id"`
+	ToID      int64     `json:"to_id"`
+	Amount    int64     `json:"amount"`
+	Rule      string    `json:"rule"`
+	Limit     int64     `json:"limit"`
+	Current   int64     `json:"current"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// forTier applies the KYC override.
+func (p VelocityPolicy) forTier(tier int64) VelocityPolicy {
+	switch {
+	case tier >= KYCFull:
+		return VelocityPolicy{}
+	case tier == KYCBasic:
+		m := max(p.KYCMultiplier, 1)
+		p.DailyVolume *= m
+		p.DailyCounterparties *= m
+		p.NewAccountCooldown = 0
+	}
+	return p
+}
+
+// check evaluates a transfer of amount to a recipient; newRecipient says
+// whether toID is not among today's counterparties yet.
+func (p VelocityPolicy) check(u VelocityUsage, createdAt, now time.Time, amount int64, newRecipient bool) error {
+	if p.NewAccountCooldown > 0 && now.Sub(createdAt) < p.NewAccountCooldown {
+		return &VelocityError{Rule: VelocityNewAccount, Limit: int64(p.NewAccountCooldown / time.Hour), Current: int64(now.Sub(createdAt) / time.Hour)}
+	}
+	if p.DailyVolume > 0 && u.Volume24h+amount > p.DailyVolume {
+		return &VelocityError{Rule: VelocityDailyVolume, Limit: p.DailyVolume, Current: u.Volume24h}
+	}
+	if p.DailyCounterparties > 0 && newRecipient && u.Counterparties+1 > p.DailyCounterparties {
+		return &VelocityError{Rule: VelocityCounterparties, Limit: p.DailyCounterparties, Current: u.Counterparties}
+	}
+	return nil
+}
+
+// rowQuerier is a pool or a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// rowsQuerier is a pool or a transaction, for reads that run in either.
+type rowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// velocityUsage reads the user's tier, age and transfer activity; toID > 0
+// also reports whether it would be a new counterparty today. A gift counts
+// toward the volume when it is created and, its recipient unknown, as a
+// counterparty of its own. Tips are read from the tips table: their ledger
+// rows are only written by BookTips. Bill shares count toward the payee,
+// whom their ledger rows don't name.
+func velocityUsage(ctx context.Context, q rowQuerier, userID, toID int64, now time.Time) (VelocityUsage, time.Time, bool, error) {
+	var u VelocityUsage
+	var createdAt time.Time
+	if err := q.QueryRow(ctx, `SELECT kyc_tier, created_at FROM users WHERE user_id=$1`, userID).Scan(&u.KYCTier, &createdAt); err != nil {
+		return VelocityUsage{}, time.Time{}, false, err
+	}
+	var known bool
+	err := q.QueryRow(ctx, `
+SELECT
+  COALESCE(SUM(amount) FILTER (WHERE ts > $2 - interval '24 hours'), 0),
+  COUNT(DISTINCT to_id) FILTER (WHERE ts >= $3) + COUNT(*) FILTER (WHERE to_id IS NULL AND ts >= $3),
+  COALESCE(bool_or(to_id = $4 AND ts >= $3), false)
+FROM (
+  SELECT to_id, amount, ts FROM ledger
+  WHERE kind IN ('transfer','gift_lock') AND from_id=$1 AND ts > LEAST($2 - interval '24 hours', $3)
+  UNION ALL
+  SELECT to_id, amount, created_at FROM tips
+  WHERE from_id=$1 AND created_at > LEAST($2 - interval '24 hours', $3)
+  UNION ALL
+  SELECT b.payee_id, s.amount, s.paid_at FROM bill_shares s JOIN bills b ON b.bill_id = s.bill_id
+  WHERE s.user_id=$1 AND s.paid_at > LEAST($2 - interval '24 hours', $3)
+) t
+`, userID, now, dayUTC(now), toID).Scan(&u.Volume24h, &u.Counterparties, &known)
+	if err != nil {
+		return VelocityUsage{}, time.Time{}, false, err
+	}
+	return u, createdAt, !known, nil
+}
+
+// checkVelocityTx enforces p on a transfer; the sender row must be locked.
+func checkVelocityTx(ctx context.Context, tx pgx.Tx, fromID, toID, amount int64, p VelocityPolicy) error {
+	now := time.Now().UTC()
+	u, createdAt, newRecipient, err := velocityUsage(ctx, tx, fromID, toID, now)
+	if err != nil {
+		return err
+	}
+	return p.forTier(u.KYCTier).check(u, createdAt, now, amount, newRecipient)
+}
+
+// recordVelocityAlert logs a refused transfer for the admin feed.
+func (d *DB) recordVelocityAlert(ctx context.Context, fromID, toID, amount int64, ve *VelocityError) error {
+	_, err := d.Pool.Exec(ctx, `
+INSERT INTO velocity_alerts(user_id, to_id, amount, rule, rule_limit, current)
+VALUES($1,$2,$3,$4,$5,$6)
+`, fromID, toID, amount, ve.Rule, ve.Limit, ve.Current)
+	return err
+}
+
+// GetVelocityUsage returns the user's transfer usage against p.
+func (d *DB) GetVelocityUsage(ctx context.Context, userID int64, p VelocityPolicy) (VelocityUsage, error) {
+	now := time.Now().UTC()
+	u, createdAt, _, err := velocityUsage(ctx, d.Pool, userID, 0, now)
+	if err != nil {
+		return VelocityUsage{}, err
+	}
+	p = p.forTier(u.KYCTier)
+	u.VolumeLimit = p.DailyVolume
+	u.CounterLimit = p.DailyCounterparties
+	if until := createdAt.Add(p.NewAccountCooldown); p.NewAccountCooldown > 0 && until.After(now) {
+		u.CooldownUntil = &until
+	}
+	return u, nil
+}
+
+// SetKYCTier sets the user's KYC tier (0..2).
+func (d *DB) SetKYCTier(ctx context.Context, adminID, userID, tier int64) error {
+	if tier < KYCNone || tier > KYCFull {
+		return errors.New("bad kyc tier")
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE users SET kyc_tier=$2 WHERE user_id=$1`, userID, tier)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('admin_kyc_tier', $1, $2, 0, $3::jsonb)`,
+			adminID, userID, toJSON(map[string]any{"tier": tier}))
+		return err
+	})
+}
+
+// ListVelocityAlerts returns violations, newest first; userID 0 lists all.
+func (d *DB) ListVelocityAlerts(ctx context.Context, userID int64, limit int) ([]VelocityAlert, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT alert_id, user_id, to_id, amount, rule, rule_limit, current, created_at
+FROM velocity_alerts
+WHERE $1 = 0 OR user_id = $1
+ORDER BY alert_id DESC
+LIMIT $2
+`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []VelocityAlert
+	for rows.Next() {
+		var a VelocityAlert
+		if err := rows.Scan(&a.AlertID, &a.UserID, &a.ToID, &a.Amount, &a.Rule, &a.Limit, &a.Current, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVelocityCheck(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+	p := VelocityPolicy{DailyVolume: 1_000, DailyCounterparties: 3, NewAccountCooldown: 48 * time.Hour, KYCMultiplier: 10}
+
+	cases := []struct {
+		name      string
+		tier      int64
+		u         VelocityUsage
+		createdAt time.Time
+		amount    int64
+		newTo     bool
+		rule      string
+	}{
+		{"ok", KYCNone, VelocityUsage{Volume24h: 500, Counterparties: 2}, old, 500, true, ""},
+		{"volume", KYCNone, VelocityUsage{Volume24h: 500}, old, 501, false, VelocityDailyVolume},
+		{"counterparties", KYCNone, VelocityUsage{Counterparties: 3}, old, 1, true, VelocityCounterparties},
+		{"known counterparty", KYCNone, VelocityUsage{Counterparties: 3}, old, 1, false, ""},
+		{"new account", KYCNone, VelocityUsage{}, now.Add(-time.Hour), 1, true, VelocityNewAccount},
+		{"basic kyc no cooldown", KYCBasic, VelocityUsage{}, now.Add(-time.Hour), 1, true, ""},
+		{"basic kyc volume", KYCBasic, VelocityUsage{Volume24h: 9_000}, old, 1_000, false, ""},
+		{"basic kyc over", KYCBasic, VelocityUsage{Volume24h: 9_000}, old, 1_001, false, VelocityDailyVolume},
+		{"full kyc", KYCFull, VelocityUsage{Volume24h: 1_000_000, Counterparties: 100}, now, 1_000_000, true, ""},
+	}
+	for _, c := range cases {
+		err := p.forTier(c.tier).check(c.u, c.createdAt, now, c.amount, c.newTo)
+		if c.rule == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected %v", c.name, err)
+			}
+			continue
+		}
+		var ve *VelocityError
+		if !errors.As(err, &ve) || ve.Rule != c.rule || !errors.Is(err, ErrVelocity) {
+			t.Fatalf("%s: got %v, want rule %s", c.name, err, c.rule)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const maxVestingMonths = 120
+
+// Vesting locks part of system_state.admin_allocated for a beneficiary. After
+// CliffMonths, Total unlocks in PeriodMonths equal monthly tranches: tranche i
+// (1..PeriodMonths) unlocks at StartAt + CliffMonths + i months. Vested coins
+// are credited to the beneficiary's balance only when withdrawn.
+type Vesting struct {
+	VestingID     int64      `json:"vesting_id"`
+	BeneficiaryID int64      `json:"beneficiary_id"`
+	Total         int64      `json:"total"`
+	Withdrawn     int64      `json:"withdrawn"`
+	StartAt       time.Time  `json:"start_at"`
+	CliffMonths   int64      `json:"cliff_months"`
+	PeriodMonths  int64      `json:"period_months"`
+	Note          string     `json:"note"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Vested        int64      `json:"vested"`
+	Available     int64      `json:"available"`
+	NextUnlockAt  *time.Time `json:"next_unlock_at,omitempty"`
+}
+
+// VestingSummary is the public view of the admin allocation.
+type VestingSummary struct {
+	Allocated   int64 `json:"allocated"`   // system_state.admin_allocated
+	Scheduled   int64 `json:"scheduled"`   // put under vesting schedules
+	Unscheduled int64 `json:"unscheduled"` // not yet scheduled (cannot be withdrawn)
+	Vested      int64 `json:"vested"`
+	Withdrawn   int64 `json:"withdrawn"`
+	Locked      int64 `json:"locked"` // scheduled but not vested yet
+}
+
+// VestedAt is the cumulative amount unlocked at at.
+func (v Vesting) VestedAt(at time.Time) int64 {
+	if v.PeriodMonths <= 0 {
+		return 0
+	}
+	var tranches int64
+	for i := int64(1); i <= v.PeriodMonths; i++ {
+		if at.Before(v.unlockAt(i)) {
+			break
+		}
+		tranches = i
+	}
+	return v.Total * tranches / v.PeriodMonths
+}
+
+func (v Vesting) unlockAt(tranche int64) time.Time {
+	return v.StartAt.AddDate(0, int(v.CliffMonths+tranche), 0)
+}
+
+// withState fills the derived fields for at.
+func (v Vesting) withState(at time.Time) Vesting {
+	v.Vested = v.VestedAt(at)
+	v.Available = max(v.Vested-v.Withdrawn, 0)
+	v.NextUnlockAt = nil
+	for i := int64(1); i <= v.PeriodMonths; i++ {
+		if t := v.unlockAt(i); t.After(at) {
+			v.NextUnlockAt = &t
+			break
+		}
+	}
+	return v
+}
+
+const vestingColumns = `vesting_id, beneficiary_id, total, withdrawn, start_at, cliff_months, period_months, note, created_at`
+
+func scanVesting(row pgx.Row) (Vesting, error) {
+	var v Vesting
+	err := row.Scan(&v.VestingID, &v.BeneficiaryID, &v.Total, &v.Withdrawn, &v.StartAt, &v.CliffMonths, &v.PeriodMonths, &v.Note, &v.CreatedAt)
+	return v, err
+}
+
+// CreateVesting locks total coins of the admin allocation for beneficiaryID.
+// Schedules can never add up to more than admin_allocated.
+func (d *DB) CreateVesting(ctx context.Context, adminID, beneficiaryID, total int64, startAt time.Time, cliffMonths, periodMonths int64, note string) (Vesting, error) {
+	note = strings.TrimSpace(note)
+	if beneficiaryID <= 0 || total <= 0 || cliffMonths < 0 || periodMonths <= 0 || cliffMonths+periodMonths > maxVestingMonths || len(note) > 200 {
+		return Vesting{}, errors.New("bad params")
+	}
+	if startAt.IsZero() {
+		startAt = time.Now().UTC()
+	}
+	var out Vesting
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var allocated int64
+		if err := tx.QueryRow(ctx, `SELECT admin_allocated FROM system_state WHERE id=1 FOR UPDATE`).Scan(&allocated); err != nil {
+			return err
+		}
+		var scheduled int64
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(SUM(total), 0) FROM admin_vesting`).Scan(&scheduled); err != nil {
+			return err
+		}
+		if scheduled+total > allocated {
+			return ErrNotEnough
+		}
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE user_id=$1)`, beneficiaryID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New("bad beneficiary")
+		}
+		var err error
+		out, err = scanVesting(tx.QueryRow(ctx, `
+INSERT INTO admin_vesting(beneficiary_id, total, start_at, cliff_months, period_months, note, created_by)
+VALUES($1,$2,$3,$4,$5,$6,$7)
+RETURNING `+vestingColumns, beneficiaryID, total, startAt, cliffMonths, periodMonths, note, adminID))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('admin_vesting_create', $1, $2, 0, $3::jsonb)
+`, adminID, beneficiaryID, toJSON(map[string]any{
+			"vesting_id":    out.VestingID,
+			"total":         total,
+			"start_at":      startAt,
+			"cliff_months":  cliffMonths,
+			"period_months": periodMonths,
+		}))
+		return err
+	})
+	if err != nil {
+		return Vesting{}, err
+	}
+	return out.withState(time.Now()), nil
+}
+
+// WithdrawVesting credits amount of vested coins to the beneficiary. The
+// schedule row is locked, so concurrent withdrawals cannot exceed what has vested.
+func (d *DB) WithdrawVesting(ctx context.Context, userID, vestingID, amount int64) (Vesting, error) {
+	if amount <= 0 {
+		return Vesting{}, errors.New("bad amount")
+	}
+	var out Vesting
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		v, err := scanVesting(tx.QueryRow(ctx, `SELECT `+vestingColumns+` FROM admin_vesting WHERE vesting_id=$1 FOR UPDATE`, vestingID))
+		if err != nil {
+			return err
+		}
+		if v.BeneficiaryID != userID {
+			return ErrForbidden
+		}
+		v = v.withState(time.Now())
+		if amount > v.Available {
+			return ErrNotEnough
+		}
+		if _, err := tx.Exec(ctx, `UPDATE admin_vesting SET withdrawn=withdrawn+$2, updated_at=now() WHERE vesting_id=$1`, vestingID, amount); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$2 WHERE user_id=$1`, userID, amount); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+VALUES('admin_vesting_release', NULL, $1, $2, $3::jsonb)
+`, userID, amount, toJSON(map[string]any{"vesting_id": vestingID, "vested": v.Vested})); err != nil {
+			return err
+		}
+		v.Withdrawn += amount
+		out = v.withState(time.Now())
+		return nil
+	})
+	if err != nil {
+		return Vesting{}, err
+	}
+	return out, nil
+}
+
+// ListVestings returns schedules with their current state; beneficiaryID 0
+// lists all of them.
+func (d *DB) ListVestings(ctx context.Context, beneficiaryID int64) ([]Vesting, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT `+vestingColumns+`
+FROM admin_vesting
+WHERE $1 = 0 OR beneficiary_id = $1
+ORDER BY vesting_id
+`, beneficiaryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var out []Vesting
+	for rows.Next() {
+		v, err := scanVesting(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v.withState(now))
+	}
+	return out, rows.Err()
+}
+
+// VestingTotals summarizes the admin allocation and its schedules.
+func (d *DB) VestingTotals(ctx context.Context) (VestingSummary, []Vesting, error) {
+	var out VestingSummary
+	if err := d.Pool.QueryRow(ctx, `SELECT admin_allocated FROM system_state WHERE id=1`).Scan(&out.Allocated); err != nil {
+		return VestingSummary{}, nil, err
+	}
+	list, err := d.ListVestings(ctx, 0)
+	if err != nil {
+		return VestingSummary{}, nil, err
+	}
+	for _, v := range list {
+		out.Scheduled += v.Total
+		out.Vested += v.Vested
+		out.Withdrawn += v.Withdrawn
+	}
+	out.Unscheduled = max(out.Allocated-out.Scheduled, 0)
+	out.Locked = out.Scheduled - out.Vested
+	return out, list, nil
+}
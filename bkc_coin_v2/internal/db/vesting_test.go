@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVestingSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	v := Vesting{Total: 1_000, Withdrawn: 100, StartAt: start, CliffMonths: 3, PeriodMonths: 3}
+	cases := []struct {
+		at   time.Time
+		want int64
+	}{
+		{start, 0},
+		{start.AddDate(0, 3, 0), 0}, // cliff passed, first tranche a month later
+		{start.AddDate(0, 4, 0).Add(-time.Second), 0},
+		{start.AddDate(0, 4, 0), 333},
+		{start.AddDate(0, 5, 10), 666},
+		{start.AddDate(0, 6, 0), 1_000},
+		{start.AddDate(5, 0, 0), 1_000},
+	}
+	for _, c := range cases {
+		if got := v.VestedAt(c.at); got != c.want {
+			t.Fatalf("vested at %s = %d, want %d", c.at, got, c.want)
+		}
+	}
+
+	s := v.withState(start.AddDate(0, 4, 1))
+	if s.Available != 233 || s.NextUnlockAt == nil || !s.NextUnlockAt.Equal(start.AddDate(0, 5, 0)) {
+		t.Fatalf("state: available %d next %v", s.Available, s.NextUnlockAt)
+	}
+	if s := v.withState(start.AddDate(1, 0, 0)); s.NextUnlockAt != nil || s.Available != 900 {
+		t.Fatalf("done: available %d next %v", s.Available, s.NextUnlockAt)
+	}
+}
@@ -0,0 +1,395 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Watch targets: an NFT design (tracks its secondary-market floor price)
+// or a bazaar listing (tracks its price).
+const (
+	WatchNFT     = "nft"
+	WatchListing = "listing"
+)
+
+const maxSavedSearchesPerUser = 20
+
+type WatchItem struct {
+	UserID    int64     `json:"user_id"`
+	Kind      string    `json:"kind"`
+	TargetID  int64     `json:"target_id"`
+	LastPrice int64     `json:"last_price"` // 0 = no price seen yet
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavedSearch is a stored marketplace query. Scope "market" matches bazaar
+// listings, scope "nft" matches secondary NFT listings. Zero values mean "any".
+type SavedSearch struct {
+	SearchID   int64     `json:"search_id"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	Query      string    `json:"query"`
+	Category   string    `json:"category"`
+	Collection string    `json:"collection"`
+	Tier       string    `json:"tier"`
+	NFTID      int64     `json:"nft_id"`
+	MinPrice   int64     `json:"min_price"`
+	MaxPrice   int64     `json:"max_price"`
+	LastSeenID int64     `json:"last_seen_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// currentWatchPrice is the price a watch compares against: NFT floor or bazaar listing price.
+// Returns 0 when nothing is for sale.
+func currentWatchPrice(ctx context.Context, tx pgx.Tx, kind string, targetID int64) (int64, error) {
+	var price int64
+	var err error
+	switch kind {
+	case WatchNFT:
+		err = tx.QueryRow(ctx, `SELECT COALESCE(MIN(price_coins),0) FROM nft_listings WHERE nft_id=$1 AND status='active'`, targetID).Scan(&price)
+	case WatchListing:
+		err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(price_coins) FILTER (WHERE status='active'),0) FROM market_listings WHERE listing_id=$1`, targetID).Scan(&price)
+	default:
+		return 0, errors.New("bad kind")
+	}
+	return price, err
+}
+
+// AddWatch adds an NFT or bazaar listing to the user's watchlist.
+func (d *DB) AddWatch(ctx context.Context, userID int64, kind string, targetID int64) (WatchItem, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if userID <= 0 || targetID <= 0 || (kind != WatchNFT && kind != WatchListing) {
+		return WatchItem{}, errors.New("bad params")
+	}
+	out := WatchItem{UserID: userID, Kind: kind, TargetID: targetID, CreatedAt: time.Now().UTC()}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var exists bool
+		table := "nfts WHERE nft_id=$1"
+		if kind == WatchListing {
+			table = "market_listings WHERE listing_id=$1"
+		}
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM `+table+`)`, targetID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return pgx.ErrNoRows
+		}
+		price, err := currentWatchPrice(ctx, tx, kind, targetID)
+		if err != nil {
+			return err
+		}
+		out.LastPrice = price
+		_, err = tx.Exec(ctx, `
+INSERT INTO watchlist(user_id, kind, target_id, last_price, created_at)
+VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (user_id, kind, target_id) DO NOTHING
+`, userID, kind, targetID, price, out.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return WatchItem{}, err
+	}
+	return out, nil
+}
+
+func (d *DB) RemoveWatch(ctx context.Context, userID int64, kind string, targetID int64) error {
+	_, err := d.Pool.Exec(ctx, `DELETE FROM watchlist WHERE user_id=$1 AND kind=$2 AND target_id=$3`, userID, strings.ToLower(strings.TrimSpace(kind)), targetID)
+	return err
+}
+
+func (d *DB) ListWatchlist(ctx context.Context, userID int64) ([]WatchItem, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT user_id, kind, target_id, last_price, created_at
+FROM watchlist
+WHERE user_id=$1
+ORDER BY created_at DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WatchItem
+	for rows.Next() {
+		var w WatchItem
+		if err := rows.Scan(&w.UserID, &w.Kind, &w.TargetID, &w.LastPrice, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// CreateSavedSearch stores search criteria. Only listings created after this
+// moment will be reported as matches.
+func (d *DB) CreateSavedSearch(ctx context.Context, s SavedSearch) (SavedSearch, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Scope = strings.ToLower(strings.TrimSpace(s.Scope))
+	s.Query = strings.TrimSpace(s.Query)
+	s.Category = strings.ToLower(strings.TrimSpace(s.Category))
+	s.Collection = strings.ToLower(strings.TrimSpace(s.Collection))
+	s.Tier = strings.ToLower(strings.TrimSpace(s.Tier))
+	if s.UserID <= 0 || (s.Scope != "market" && s.Scope != "nft") || s.MinPrice < 0 || s.MaxPrice < 0 || (s.MaxPrice > 0 && s.MinPrice > s.MaxPrice) {
+		return SavedSearch{}, errors.New("bad params")
+	}
+	if s.Tier != "" && !IsRarityTier(s.Tier) {
+		return SavedSearch{}, errors.New("bad tier")
+	}
+	if s.Name == "" {
+		s.Name = s.Scope
+	}
+	s.CreatedAt = time.Now().UTC()
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var n int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM saved_searches WHERE user_id=$1`, s.UserID).Scan(&n); err != nil {
+			return err
+		}
+		if n >= maxSavedSearchesPerUser {
+			return ErrNotEnough
+		}
+		table := "market_listings"
+		if s.Scope == "nft" {
+			table = "nft_listings"
+		}
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(listing_id),0) FROM `+table).Scan(&s.LastSeenID); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO saved_searches (user_id, name, scope, query, category, collection, tier, nft_id, min_price, max_price, last_seen_id, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+RETURNING search_id
+`, s.UserID, s.Name, s.Scope, s.Query, s.Category, s.Collection, s.Tier, s.NFTID, s.MinPrice, s.MaxPrice, s.LastSeenID, s.CreatedAt).Scan(&s.SearchID)
+	})
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	return s, nil
+}
+
+func (d *DB) DeleteSavedSearch(ctx context.Context, userID, searchID int64) error {
+	_, err := d.Pool.Exec(ctx, `DELETE FROM saved_searches WHERE user_id=$1 AND search_id=$2`, userID, searchID)
+	return err
+}
+
+const savedSearchColumns = `search_id, user_id, name, scope, query, category, collection, tier, nft_id, min_price, max_price, last_seen_id, created_at`
+
+func scanSavedSearch(row pgx.Row) (SavedSearch, error) {
+	var s SavedSearch
+	err := row.Scan(&s.SearchID, &s.UserID, &s.Name, &s.Scope, &s.Query, &s.Category, &s.Collection, &s.Tier, &s.NFTID, &s.MinPrice, &s.MaxPrice, &s.LastSeenID, &s.CreatedAt)
+	return s, err
+}
+
+func (d *DB) ListSavedSearches(ctx context.Context, userID int64) ([]SavedSearch, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT `+savedSearchColumns+` FROM saved_searches WHERE user_id=$1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// savedSearchSQL builds the query returning (listing_id, title, price_coins)
+// of listings in (s.LastSeenID, upTo] that match the search's criteria.
+func savedSearchSQL(s SavedSearch, upTo int64) (string, []any) {
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var sql string
+	where := []string{}
+	if s.Scope == "nft" {
+		sql = `SELECT l.listing_id, n.title, l.price_coins FROM nft_listings l JOIN nfts n ON n.nft_id = l.nft_id`
+		where = append(where, "l.status='active'", "l.listing_id > "+arg(s.LastSeenID), "l.listing_id <= "+arg(upTo), "l.seller_id <> "+arg(s.UserID))
+		if s.NFTID > 0 {
+			where = append(where, "l.nft_id="+arg(s.NFTID))
+		}
+		if s.Collection != "" {
+			where = append(where, "n.collection="+arg(s.Collection))
+		}
+		if s.Tier != "" {
+			where = append(where, "n.rarity_tier="+arg(s.Tier))
+		}
+		if s.Query != "" {
+			where = append(where, "n.title ILIKE "+arg("%"+s.Query+"%"))
+		}
+	} else {
+		sql = `SELECT l.listing_id, l.title, l.price_coins FROM market_listings l`
+		where = append(where, "l.status='active'", "l.listing_id > "+arg(s.LastSeenID), "l.listing_id <= "+arg(upTo), "l.seller_id <> "+arg(s.UserID))
+		if s.Category != "" {
+			where = append(where, "l.category="+arg(s.Category))
+		}
+		if s.Query != "" {
+			p := arg("%" + s.Query + "%")
+			where = append(where, "(l.title ILIKE "+p+" OR l.description ILIKE "+p+")")
+		}
+	}
+	if s.MinPrice > 0 {
+		where = append(where, "l.price_coins >= "+arg(s.MinPrice))
+	}
+	if s.MaxPrice > 0 {
+		where = append(where, "l.price_coins <= "+arg(s.MaxPrice))
+	}
+	return sql + " WHERE " + strings.Join(where, " AND ") + " ORDER BY l.listing_id LIMIT 20", args
+}
+
+// NotifySavedSearchMatches emits a "saved_search_match" event for every new
+// listing matching a saved search and advances each search's cursor (scheduled job).
+func (d *DB) NotifySavedSearchMatches(ctx context.Context) (int64, error) {
+	rows, err := d.Pool.Query(ctx, `SELECT `+savedSearchColumns+` FROM saved_searches ORDER BY search_id`)
+	if err != nil {
+		return 0, err
+	}
+	var searches []SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		searches = append(searches, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var sent int64
+	for _, s := range searches {
+		err := d.WithTx(ctx, func(tx pgx.Tx) error {
+			// Cursor first, so a concurrent run cannot notify twice.
+			table := "market_listings"
+			if s.Scope == "nft" {
+				table = "nft_listings"
+			}
+			var maxID int64
+			if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(listing_id),0) FROM `+table).Scan(&maxID); err != nil {
+				return err
+			}
+			tag, err := tx.Exec(ctx, `UPDATE saved_searches SET last_seen_id=$1 WHERE search_id=$2 AND last_seen_id=$3`, maxID, s.SearchID, s.LastSeenID)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 || maxID <= s.LastSeenID {
+				return nil
+			}
+
+			sql, args := savedSearchSQL(s, maxID)
+			mrows, err := tx.Query(ctx, sql, args...)
+			if err != nil {
+				return err
+			}
+			type match struct {
+				id    int64
+				title string
+				price int64
+			}
+			var matches []match
+			for mrows.Next() {
+				var m match
+				if err := mrows.Scan(&m.id, &m.title, &m.price); err != nil {
+					mrows.Close()
+					return err
+				}
+				matches = append(matches, m)
+			}
+			mrows.Close()
+			if err := mrows.Err(); err != nil {
+				return err
+			}
+			for _, m := range matches {
+				if err := addUserEventTx(ctx, tx, s.UserID, "saved_search_match", map[string]any{
+					"search_id":   s.SearchID,
+					"name":        s.Name,
+					"scope":       s.Scope,
+					"listing_id":  m.id,
+					"title":       m.title,
+					"price_coins": m.price,
+				}); err != nil {
+					return err
+				}
+				sent++
+			}
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// NotifyWatchPriceDrops emits a "watch_price_drop" event when a watched item
+// becomes cheaper than the last price seen, then remembers the new price (scheduled job).
+func (d *DB) NotifyWatchPriceDrops(ctx context.Context) (int64, error) {
+	var sent int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+WITH cur AS (
+  SELECT w.user_id, w.kind, w.target_id, w.last_price,
+         CASE w.kind
+           WHEN 'nft' THEN (SELECT COALESCE(MIN(l.price_coins),0) FROM nft_listings l WHERE l.nft_id=w.target_id AND l.status='active')
+           ELSE (SELECT COALESCE(MAX(m.price_coins) FILTER (WHERE m.status='active'),0) FROM market_listings m WHERE m.listing_id=w.target_id)
+         END AS price
+  FROM watchlist w
+)
+UPDATE watchlist w
+SET last_price = cur.price
+FROM cur
+WHERE w.user_id=cur.user_id AND w.kind=cur.kind AND w.target_id=cur.target_id AND cur.price <> cur.last_price
+RETURNING w.user_id, w.kind, w.target_id, cur.last_price, cur.price
+`)
+		if err != nil {
+			return err
+		}
+		type drop struct {
+			userID, targetID, oldPrice, newPrice int64
+			kind                                 string
+		}
+		var drops []drop
+		for rows.Next() {
+			var dr drop
+			if err := rows.Scan(&dr.userID, &dr.kind, &dr.targetID, &dr.oldPrice, &dr.newPrice); err != nil {
+				rows.Close()
+				return err
+			}
+			// A listing appearing after nothing was for sale is not a drop.
+			if dr.oldPrice > 0 && dr.newPrice > 0 && dr.newPrice < dr.oldPrice {
+				drops = append(drops, dr)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, dr := range drops {
+			if err := addUserEventTx(ctx, tx, dr.userID, "watch_price_drop", map[string]any{
+				"kind":      dr.kind,
+				"target_id": dr.targetID,
+				"old_price": dr.oldPrice,
+				"new_price": dr.newPrice,
+			}); err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sent, nil
+}
@@ -0,0 +1,361 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Webhook event types a developer can subscribe to.
+const (
+	WebhookPaymentConfirmed = "payment.confirmed"
+	WebhookListingSold      = "listing.sold"
+	WebhookLoanOverdue      = "loan.overdue"
+)
+
+// WebhookEventTypes lists every event type.
+var WebhookEventTypes = []string{WebhookPaymentConfirmed, WebhookListingSold, WebhookLoanOverdue}
+
+// Webhook delivery statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // gave up after the last attempt
+)
+
+// webhookSource maps a ledger kind to the event it raises and the ledger
+// column holding the user the event is about. The ledger is the outbox:
+// rows are only visible once their transaction committed.
+type webhookSource struct {
+	event  string
+	toUser bool // user is to_id, else from_id
+}
+
+var webhookSources = map[string]webhookSource{
+	"deposit_approve":   {WebhookPaymentConfirmed, true},
+	"cryptopay_deposit": {WebhookPaymentConfirmed, true},
+	"market_buy":        {WebhookListingSold, true},
+	"nft_market_buy":    {WebhookListingSold, true},
+	"bank_loan_overdue": {WebhookLoanOverdue, false},
+}
+
+const (
+	webhookRelayBatch = 1_000
+	webhookLease      = 5 * time.Minute // a claimed delivery is retried after this if the sender died
+)
+
+// WebhookBackoff is the wait after the n-th failed attempt: 30s doubling up
+// to 6h.
+func WebhookBackoff(attempt int64) time.Duration {
+	d := 30 * time.Second
+	for i := int64(1); i < attempt && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, 6*time.Hour)
+}
+
+// WebhookSubscription is a developer's endpoint for a set of event types.
+type WebhookSubscription struct {
+	SubscriptionID int64     `json:"subscription_id"`
+	UserID         int64     `json:"user_id"`
+	URL            string    `json:"url"`
+	Events         []string  `json:"events"`
+	Secret         string    `json:"secret,omitempty"` // returned on creation only
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent (or to be sent) to one subscription.
+type WebhookDelivery struct {
+	DeliveryID     int64          `json:"delivery_id"`
+	SubscriptionID int64          `json:"subscription_id"`
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	Payload        map[string]any `json:"payload"`
+	Status         string         `json:"status"`
+	Attempts       int64          `json:"attempts"`
+	NextAttemptAt  *time.Time     `json:"next_attempt_at,omitempty"`
+	LastStatus     *int64         `json:"last_status,omitempty"`
+	LastError      *string        `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time     `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+
+	// Set by ClaimWebhookDeliveries for the sender.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// CreateWebhook registers url for events; maxPerUser caps the user's active
+// subscriptions. The returned subscription carries the signing secret.
+func (d *DB) CreateWebhook(ctx context.Context, userID int64, url string, events []string, maxPerUser int64) (WebhookSubscription, error) {
+	if userID <= 0 || url == "" || len(url) > 2048 || len(events) == 0 {
+		return WebhookSubscription{}, errors.New("bad params")
+	}
+	events = slices.Clone(events)
+	slices.Sort(events)
+	events = slices.Compact(events)
+	for _, e := range events {
+		if !slices.Contains(WebhookEventTypes, e) {
+			return WebhookSubscription{}, errors.New("bad event type: " + e)
+		}
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return WebhookSubscription{}, err
+	}
+	out := WebhookSubscription{UserID: userID, URL: url, Events: events, Secret: "whsec_" + hex.EncodeToString(buf)}
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := lockSpendableTx(ctx, tx, userID); err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_subscriptions WHERE user_id=$1 AND disabled_at IS NULL`, userID).Scan(&active); err != nil {
+			return err
+		}
+		if maxPerUser > 0 && active >= maxPerUser {
+			return errors.New("bad params: too many webhooks")
+		}
+		return tx.QueryRow(ctx, `
+INSERT INTO webhook_subscriptions(user_id, url, events, secret)
+VALUES($1,$2,$3,$4)
+RETURNING subscription_id, created_at
+`, userID, url, events, out.Secret).Scan(&out.SubscriptionID, &out.CreatedAt)
+	})
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	return out, nil
+}
+
+// ListWebhooks returns the user's active subscriptions without secrets.
+func (d *DB) ListWebhooks(ctx context.Context, userID int64) ([]WebhookSubscription, error) {
+	rows, err := d.Pool.Query(ctx, `
+SELECT subscription_id, user_id, url, events, created_at
+FROM webhook_subscriptions
+WHERE user_id=$1 AND disabled_at IS NULL
+ORDER BY subscription_id DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebhookSubscription
+	for rows.Next() {
+		var s WebhookSubscription
+		if err := rows.Scan(&s.SubscriptionID, &s.UserID, &s.URL, &s.Events, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// DeleteWebhook disables a subscription; its pending deliveries are dropped.
+func (d *DB) DeleteWebhook(ctx context.Context, userID, subscriptionID int64) error {
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE webhook_subscriptions SET disabled_at=now() WHERE subscription_id=$1 AND user_id=$2 AND disabled_at IS NULL`, subscriptionID, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		_, err = tx.Exec(ctx, `UPDATE webhook_deliveries SET status=$2, next_attempt_at=NULL, last_error='subscription deleted' WHERE subscription_id=$1 AND status=$3`,
+			subscriptionID, WebhookFailed, WebhookPending)
+		return err
+	})
+}
+
+// RelayWebhookEvents turns new ledger rows into pending deliveries for every
+// matching subscription. Run from the webhooks job; returns deliveries queued.
+func (d *DB) RelayWebhookEvents(ctx context.Context) (int64, error) {
+	kinds := make([]string, 0, len(webhookSources))
+	for k := range webhookSources {
+		kinds = append(kinds, k)
+	}
+	var n int64
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		var cursor int64
+		if err := tx.QueryRow(ctx, `SELECT ledger_id FROM webhook_relay WHERE id=1 FOR UPDATE`).Scan(&cursor); err != nil {
+			return err
+		}
+		// Rows younger than a minute are left for the next run: ids are assigned
+		// before commit, so a recent gap may still be filled.
+		rows, err := tx.Query(ctx, `
+SELECT id, ts, kind, from_id, to_id, amount, meta
+FROM ledger
+WHERE id > $1 AND ts < now() - interval '1 minute' AND kind = ANY($2)
+ORDER BY id
+LIMIT $3
+`, cursor, kinds, webhookRelayBatch)
+		if err != nil {
+			return err
+		}
+		type event struct {
+			userID  int64
+			typ     string
+			id      string
+			payload map[string]any
+		}
+		var events []event
+		for rows.Next() {
+			var id, amount int64
+			var ts time.Time
+			var kind string
+			var fromID, toID *int64
+			var meta map[string]any
+			if err := rows.Scan(&id, &ts, &kind, &fromID, &toID, &amount, &meta); err != nil {
+				rows.Close()
+				return err
+			}
+			cursor = id
+			src := webhookSources[kind]
+			userID := fromID
+			if src.toUser {
+				userID = toID
+			}
+			if userID == nil || *userID <= 0 {
+				continue
+			}
+			eventID := fmt.Sprintf("evt_ledger_%d", id)
+			events = append(events, event{userID: *userID, typ: src.event, id: eventID, payload: map[string]any{
+				"id":         eventID,
+				"type":       src.event,
+				"created_at": ts.UTC().Format(time.RFC3339),
+				"data": map[string]any{
+					"user_id": *userID,
+					"kind":    kind,
+					"amount":  amount,
+					"meta":    meta,
+				},
+			}})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, e := range events {
+			tag, err := tx.Exec(ctx, `
+INSERT INTO webhook_deliveries(subscription_id, event_id, event_type, payload, next_attempt_at)
+SELECT subscription_id, $3, $2, $4::jsonb, now()
+FROM webhook_subscriptions
+WHERE user_id=$1 AND disabled_at IS NULL AND $2 = ANY(events)
+ON CONFLICT (subscription_id, event_id) DO NOTHING
+`, e.userID, e.typ, e.id, toJSON(e.payload))
+			if err != nil {
+				return err
+			}
+			n += tag.RowsAffected()
+		}
+		_, err = tx.Exec(ctx, `UPDATE webhook_relay SET ledger_id=$1, updated_at=now() WHERE id=1`, cursor)
+		return err
+	})
+	return n, err
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries to the caller;
+// a claimed delivery is not handed out again for webhookLease.
+func (d *DB) ClaimWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := d.Pool.Query(ctx, `
+UPDATE webhook_deliveries w SET next_attempt_at = now() + $2 * interval '1 second'
+FROM webhook_subscriptions s
+WHERE w.delivery_id IN (
+  SELECT delivery_id FROM webhook_deliveries
+  WHERE status='pending' AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT $1
+  FOR UPDATE SKIP LOCKED
+) AND s.subscription_id = w.subscription_id
+RETURNING w.delivery_id, w.subscription_id, w.event_id, w.event_type, w.payload, w.attempts, w.created_at, s.url, s.secret
+`, limit, int64(webhookLease/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		w := WebhookDelivery{Status: WebhookPending}
+		if err := rows.Scan(&w.DeliveryID, &w.SubscriptionID, &w.EventID, &w.EventType, &w.Payload, &w.Attempts, &w.CreatedAt, &w.URL, &w.Secret); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// RecordWebhookAttempt stores the outcome of one send. A failure is retried
+// with WebhookBackoff until maxAttempts, then the delivery is failed.
+func (d *DB) RecordWebhookAttempt(ctx context.Context, deliveryID int64, statusCode int, sendErr string, ok bool, maxAttempts int64) error {
+	var status *int64
+	if statusCode > 0 {
+		s := int64(statusCode)
+		status = &s
+	}
+	var lastErr *string
+	if sendErr != "" {
+		if len(sendErr) > 500 {
+			sendErr = sendErr[:500]
+		}
+		lastErr = &sendErr
+	}
+	return d.WithTx(ctx, func(tx pgx.Tx) error {
+		var attempts int64
+		if err := tx.QueryRow(ctx, `UPDATE webhook_deliveries SET attempts=attempts+1, last_status=$2, last_error=$3 WHERE delivery_id=$1 RETURNING attempts`,
+			deliveryID, status, lastErr).Scan(&attempts); err != nil {
+			return err
+		}
+		var err error
+		switch {
+		case ok:
+			_, err = tx.Exec(ctx, `UPDATE webhook_deliveries SET status=$2, delivered_at=now(), next_attempt_at=NULL WHERE delivery_id=$1`, deliveryID, WebhookDelivered)
+		case attempts >= maxAttempts:
+			_, err = tx.Exec(ctx, `UPDATE webhook_deliveries SET status=$2, next_attempt_at=NULL WHERE delivery_id=$1`, deliveryID, WebhookFailed)
+		default:
+			_, err = tx.Exec(ctx, `UPDATE webhook_deliveries SET next_attempt_at=now() + $2 * interval '1 second' WHERE delivery_id=$1`, deliveryID, int64(WebhookBackoff(attempts)/time.Second))
+		}
+		return err
+	})
+}
+
+// ListWebhookDeliveries is the delivery log of one of the user's
+// subscriptions, newest first.
+func (d *DB) ListWebhookDeliveries(ctx context.Context, userID, subscriptionID int64, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	var owner int64
+	if err := d.Pool.QueryRow(ctx, `SELECT user_id FROM webhook_subscriptions WHERE subscription_id=$1`, subscriptionID).Scan(&owner); err != nil {
+		return nil, err
+	}
+	if owner != userID {
+		return nil, pgx.ErrNoRows
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT delivery_id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status, last_error, delivered_at, created_at
+FROM webhook_deliveries
+WHERE subscription_id=$1
+ORDER BY delivery_id DESC
+LIMIT $2
+`, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		var w WebhookDelivery
+		if err := rows.Scan(&w.DeliveryID, &w.SubscriptionID, &w.EventID, &w.EventType, &w.Payload, &w.Status, &w.Attempts, &w.NextAttemptAt, &w.LastStatus, &w.LastError, &w.DeliveredAt, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
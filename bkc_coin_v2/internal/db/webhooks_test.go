@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int64
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{5, 8 * time.Minute},
+		{30, 6 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := WebhookBackoff(c.attempt); got != c.want {
+			t.Fatalf("attempt %d: %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestWebhookRelay(t *testing.T) {
+	d := testDB(t)
+	ctx := context.Background()
+
+	const userID = 993_001
+	if _, err := d.EnsureUser(ctx, userID, "", "", 100); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM webhook_deliveries WHERE subscription_id IN (SELECT subscription_id FROM webhook_subscriptions WHERE user_id=$1)`, userID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE user_id=$1`, userID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM ledger WHERE to_id=$1`, userID)
+		_, _ = d.Pool.Exec(ctx, `DELETE FROM users WHERE user_id=$1`, userID)
+	})
+
+	if _, err := d.CreateWebhook(ctx, userID, "https://example.com/hook", []string{"payment.failed"}, 5); err == nil {
+		t.Fatal("unknown event type accepted")
+	}
+	sub, err := d.CreateWebhook(ctx, userID, "https://example.com/hook", []string{WebhookPaymentConfirmed}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Secret == "" {
+		t.Fatal("no signing secret")
+	}
+
+	// Drain older rows, then add a committed deposit old enough to relay.
+	for {
+		n, err := d.RelayWebhookEvents(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if _, err := d.Pool.Exec(ctx, `INSERT INTO ledger(ts, kind, from_id, to_id, amount) VALUES(now() - interval '2 minutes', 'deposit_approve', NULL, $1, 700)`, userID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.RelayWebhookEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("queued %d, want 1", n)
+	}
+
+	due, err := d.ClaimWebhookDeliveries(ctx, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mine *WebhookDelivery
+	for i := range due {
+		if due[i].SubscriptionID == sub.SubscriptionID {
+			mine = &due[i]
+		}
+	}
+	if mine == nil || mine.Secret != sub.Secret || mine.EventType != WebhookPaymentConfirmed {
+		t.Fatalf("claimed: %+v", mine)
+	}
+	if err := d.RecordWebhookAttempt(ctx, mine.DeliveryID, 500, "http 500", false, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RecordWebhookAttempt(ctx, mine.DeliveryID, 500, "http 500", false, 2); err != nil {
+		t.Fatal(err)
+	}
+	log, err := d.ListWebhookDeliveries(ctx, userID, sub.SubscriptionID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].Status != WebhookFailed || log[0].Attempts != 2 {
+		t.Fatalf("log: %+v", log)
+	}
+	if _, err := d.ListWebhookDeliveries(ctx, userID+1, sub.SubscriptionID, 10); err == nil {
+		t.Fatal("another user read the delivery log")
+	}
+}
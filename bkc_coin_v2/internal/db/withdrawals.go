@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A withdrawal pays BKC out to an external wallet. The coins leave the
+// balance when it is requested. Risky requests start "held" until the user
+// confirms them in Telegram or an admin overrides the hold; then they are
+// "pending" until an admin pays out (approved) or rejects (refunded).
+type Withdrawal struct {
+	WithdrawalID int64      `json:"withdrawal_id"`
+	UserID       int64      `json:"user_id"`
+	Amount       int64      `json:"amount"`
+	Address      string     `json:"address"`
+	Status       string     `json:"status"` // held|pending|approved|rejected|cancelled
+	IP           string     `json:"ip,omitempty"`
+	Country      string     `json:"country,omitempty"`
+	ASN          int64      `json:"asn,omitempty"`
+	RiskScore    int        `json:"risk_score"`
+	RiskReasons  []string   `json:"risk_reasons"`
+	SaleTax      int64      `json:"sale_tax"` // withheld from Amount on payout
+	TaxBurn      int64      `json:"-"`
+	SaleDay      *time.Time `json:"-"` // day the amount was counted against the sale limit
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	ReviewedBy   *int64     `json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// WithdrawalRequest is what the API knows about a withdrawal attempt.
+type WithdrawalRequest struct {
+	UserID     int64
+	Amount     int64
+	Address    string
+	IP         string
+	Country    string
+	ASN        int64
+	SessionKey string
+	Affiliate  bool // pay out affiliate commission (affiliates.go) instead of the balance
+}
+
+const withdrawalCols = `withdrawal_id, user_id, amount, address, status, ip, country, asn, risk_score, risk_reasons, sale_tax, tax_burn, sale_day, confirmed_at, reviewed_by, reviewed_at, created_at`
+
+func scanWithdrawal(row pgx.Row) (Withdrawal, error) {
+	var w Withdrawal
+	err := row.Scan(&w.WithdrawalID, &w.UserID, &w.Amount, &w.Address, &w.Status, &w.IP, &w.Country, &w.ASN,
+		&w.RiskScore, &w.RiskReasons, &w.SaleTax, &w.TaxBurn, &w.SaleDay, &w.ConfirmedAt, &w.ReviewedBy, &w.ReviewedAt, &w.CreatedAt)
+	return w, err
+}
+
+// RequestWithdrawal debits the balance and queues the withdrawal. Requests
+// from a new country/ASN (see scoreWithdrawalRisk) are held for confirmation.
+// The amount counts against the daily sale limit now; the sale tax is fixed
+// now and collected on payout.
+func (d *DB) RequestWithdrawal(ctx context.Context, req WithdrawalRequest, p RiskPolicy, sp SalePolicy) (Withdrawal, error) {
+	req.Address = strings.TrimSpace(req.Address)
+	req.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	if req.UserID <= 0 || req.Amount <= 0 || req.Address == "" || len(req.Address) > 128 {
+		return Withdrawal{}, errors.New("bad params")
+	}
+
+	var out Withdrawal
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		if req.Affiliate {
+			if err := releaseAffiliateBalanceTx(ctx, tx, req.UserID, req.Amount); err != nil {
+				return err
+			}
+		}
+		spendable, err := lockSpendableTx(ctx, tx, req.UserID)
+		if err != nil {
+			return err
+		}
+		if spendable < req.Amount {
+			return ErrNotEnough
+		}
+
+		sessionAge := time.Duration(-1)
+		var sessCreated time.Time
+		err = tx.QueryRow(ctx, `SELECT created_at FROM sessions WHERE session_key=$1 AND user_id=$2`, req.SessionKey, req.UserID).Scan(&sessCreated)
+		switch {
+		case err == nil:
+			sessionAge = time.Since(sessCreated)
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		risk, err := assessWithdrawalRiskTx(ctx, tx, req.UserID, req.Amount, req.Country, req.ASN, sessionAge, p)
+		if err != nil {
+			return err
+		}
+		status := "pending"
+		if risk.Hold {
+			status = "held"
+		}
+
+		saleDay, err := countSaleTx(ctx, tx, req.UserID, req.Amount, sp)
+		if err != nil {
+			return err
+		}
+		tax := sp.Tax(req.Amount)
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance-$1 WHERE user_id=$2`, req.Amount, req.UserID); err != nil {
+			return err
+		}
+		out, err = scanWithdrawal(tx.QueryRow(ctx, `
+INSERT INTO withdrawals (user_id, amount, address, status, ip, country, asn, risk_score, risk_reasons, sale_tax, tax_burn, sale_day)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12::date)
+RETURNING `+withdrawalCols, req.UserID, req.Amount, req.Address, status, req.IP, req.Country, req.ASN, risk.Score, risk.Reasons, tax.Tax, tax.Burn, saleDay))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('withdraw_request', $1, NULL, $2, $3::jsonb)`,
+			req.UserID, req.Amount, toJSON(map[string]any{"withdrawal_id": out.WithdrawalID, "status": status, "risk_score": risk.Score, "risk_reasons": risk.Reasons, "sale_tax": tax.Tax, "affiliate": req.Affiliate}),
+		); err != nil {
+			return err
+		}
+		if risk.Hold {
+			return addUserEventTx(ctx, tx, req.UserID, "withdrawal_held", map[string]any{
+				"withdrawal_id": out.WithdrawalID, "amount": req.Amount, "country": req.Country, "reasons": risk.Reasons,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	return out, nil
+}
+
+func (d *DB) GetWithdrawal(ctx context.Context, withdrawalID int64) (Withdrawal, error) {
+	return scanWithdrawal(d.Pool.QueryRow(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE withdrawal_id=$1`, withdrawalID))
+}
+
+func (d *DB) ListMyWithdrawals(ctx context.Context, userID, limit int64) ([]Withdrawal, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return d.queryWithdrawals(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE user_id=$1 ORDER BY created_at DESC LIMIT $2`, userID, limit)
+}
+
+// ListWithdrawals is the admin queue: "held" is the risk override queue,
+// "pending" the payout queue.
+func (d *DB) ListWithdrawals(ctx context.Context, status string, limit int64) ([]Withdrawal, error) {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if status == "" {
+		status = "held"
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return d.queryWithdrawals(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE status=$1 ORDER BY created_at ASC LIMIT $2`, status, limit)
+}
+
+func (d *DB) queryWithdrawals(ctx context.Context, sql string, args ...any) ([]Withdrawal, error) {
+	rows, err := d.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Withdrawal
+	for rows.Next() {
+		w, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// ConfirmHeldWithdrawal is the user's answer to a hold, given in Telegram.
+// Confirming releases it to the payout queue and trusts the new location;
+// declining cancels it and refunds the coins.
+func (d *DB) ConfirmHeldWithdrawal(ctx context.Context, userID, withdrawalID int64, confirm bool) (Withdrawal, error) {
+	var out Withdrawal
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		w, err := scanWithdrawal(tx.QueryRow(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE withdrawal_id=$1 AND user_id=$2 FOR UPDATE`, withdrawalID, userID))
+		if err != nil {
+			return err
+		}
+		if w.Status != "held" {
+			return ErrForbidden
+		}
+		if !confirm {
+			out, err = closeWithdrawalTx(ctx, tx, w, "cancelled", nil)
+			return err
+		}
+		if out, err = scanWithdrawal(tx.QueryRow(ctx, `
+UPDATE withdrawals SET status='pending', confirmed_at=now()
+WHERE withdrawal_id=$1
+RETURNING `+withdrawalCols, withdrawalID)); err != nil {
+			return err
+		}
+		if w.Country != "" || w.ASN != 0 {
+			if _, err := tx.Exec(ctx, upsertLoginGeoSQL, userID, w.Country, w.ASN); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	return out, nil
+}
+
+// OverrideWithdrawalHold lets an admin release a held withdrawal to the
+// payout queue or reject it with a refund.
+func (d *DB) OverrideWithdrawalHold(ctx context.Context, adminID, withdrawalID int64, release bool) (Withdrawal, error) {
+	if adminID <= 0 || withdrawalID <= 0 {
+		return Withdrawal{}, errors.New("bad params")
+	}
+	var out Withdrawal
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		w, err := scanWithdrawal(tx.QueryRow(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE withdrawal_id=$1 FOR UPDATE`, withdrawalID))
+		if err != nil {
+			return err
+		}
+		if w.Status != "held" {
+			return ErrForbidden
+		}
+		if !release {
+			out, err = closeWithdrawalTx(ctx, tx, w, "rejected", &adminID)
+			return err
+		}
+		if out, err = scanWithdrawal(tx.QueryRow(ctx, `
+UPDATE withdrawals SET status='pending', reviewed_by=$2, reviewed_at=now()
+WHERE withdrawal_id=$1
+RETURNING `+withdrawalCols, withdrawalID, adminID)); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('withdraw_hold_override', NULL, $1, 0, $2::jsonb)`,
+			w.UserID, toJSON(map[string]any{"withdrawal_id": withdrawalID, "by": adminID}),
+		)
+		return err
+	})
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	return out, nil
+}
+
+// ProcessWithdrawal settles a pending withdrawal: approved coins return to
+// the reserve (the payout of Amount-SaleTax is made off-chain) minus the burned
+// part of the tax; rejected ones are refunded.
+func (d *DB) ProcessWithdrawal(ctx context.Context, adminID, withdrawalID int64, approve bool) (Withdrawal, error) {
+	if adminID <= 0 || withdrawalID <= 0 {
+		return Withdrawal{}, errors.New("bad params")
+	}
+	var out Withdrawal
+	err := d.WithTx(ctx, func(tx pgx.Tx) error {
+		w, err := scanWithdrawal(tx.QueryRow(ctx, `SELECT `+withdrawalCols+` FROM withdrawals WHERE withdrawal_id=$1 FOR UPDATE`, withdrawalID))
+		if err != nil {
+			return err
+		}
+		if w.Status != "pending" {
+			return ErrForbidden
+		}
+		if !approve {
+			out, err = closeWithdrawalTx(ctx, tx, w, "rejected", &adminID)
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE system_state SET reserve_supply=reserve_supply+$1, updated_at=now() WHERE id=1`, w.Amount-w.SaleTax); err != nil {
+			return err
+		}
+		if out, err = scanWithdrawal(tx.QueryRow(ctx, `
+UPDATE withdrawals SET status='approved', reviewed_by=$2, reviewed_at=now()
+WHERE withdrawal_id=$1
+RETURNING `+withdrawalCols, withdrawalID, adminID)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('withdraw_approve', $1, NULL, $2, $3::jsonb)`,
+			w.UserID, w.Amount, toJSON(map[string]any{"withdrawal_id": withdrawalID, "address": w.Address, "by": adminID, "net": w.Amount - w.SaleTax}),
+		); err != nil {
+			return err
+		}
+		tax := SaleTax{Tax: w.SaleTax, Burn: w.TaxBurn, Fund: w.SaleTax - w.TaxBurn}
+		if err := settleSaleTaxTx(ctx, tx, w.UserID, tax, map[string]any{"withdrawal_id": withdrawalID}); err != nil {
+			return err
+		}
+		return addUserEventTx(ctx, tx, w.UserID, "withdrawal_approved", map[string]any{"withdrawal_id": withdrawalID, "amount": w.Amount})
+	})
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	return out, nil
+}
+
+// closeWithdrawalTx ends a held or pending withdrawal and refunds the coins.
+func closeWithdrawalTx(ctx context.Context, tx pgx.Tx, w Withdrawal, status string, adminID *int64) (Withdrawal, error) {
+	if _, err := tx.Exec(ctx, `UPDATE users SET balance=balance+$1 WHERE user_id=$2`, w.Amount, w.UserID); err != nil {
+		return Withdrawal{}, err
+	}
+	if w.SaleDay != nil {
+		if err := uncountSaleTx(ctx, tx, w.UserID, w.SaleDay.Format("2006-01-02"), w.Amount); err != nil {
+			return Withdrawal{}, err
+		}
+	}
+	out, err := scanWithdrawal(tx.QueryRow(ctx, `
+UPDATE withdrawals SET status=$2, reviewed_by=$3, reviewed_at=CASE WHEN $3::BIGINT IS NULL THEN NULL ELSE now() END
+WHERE withdrawal_id=$1
+RETURNING `+withdrawalCols, w.WithdrawalID, status, adminID))
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO ledger(kind, from_id, to_id, amount, meta) VALUES('withdraw_refund', NULL, $1, $2, $3::jsonb)`,
+		w.UserID, w.Amount, toJSON(map[string]any{"withdrawal_id": w.WithdrawalID, "status": status, "by": adminID}),
+	); err != nil {
+		return Withdrawal{}, err
+	}
+	return out, addUserEventTx(ctx, tx, w.UserID, "withdrawal_"+status, map[string]any{"withdrawal_id": w.WithdrawalID, "amount": w.Amount})
+}
@@ -0,0 +1,196 @@
+// Package devdb runs a private Postgres server for local development, so the
+// whole stack starts with DB_DRIVER=embedded and no database to provision or
+// configure. The server is the regular Postgres the db package is written
+// for, so migrations, queries and locking behave as in production; there is
+// no second SQL dialect to keep in step.
+//
+// It needs the Postgres server binaries (initdb and postgres), found in
+// PG_BIN, on PATH or under /usr/lib/postgresql/*/bin. The data directory is
+// initialised on first start and kept between runs; delete it to start over.
+// Postgres refuses to run as root.
+package devdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	user     = "bkc"
+	database = "bkc"
+	logName  = "devdb.log"
+
+	startTimeout = 30 * time.Second
+	stopTimeout  = 10 * time.Second
+)
+
+// ErrNoBinaries means initdb or postgres could not be found.
+var ErrNoBinaries = errors.New("devdb: postgres server binaries not found (install postgresql or set PG_BIN)")
+
+// Server is a running Postgres owned by this process.
+type Server struct {
+	// URL connects to the bkc database as the bkc superuser.
+	URL string
+
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// Start starts Postgres on a free loopback port with its data in dir,
+// creating the cluster and the bkc database if needed. binDir may be empty.
+func Start(ctx context.Context, dir, binDir string) (*Server, error) {
+	initdb, err := binary(binDir, "initdb")
+	if err != nil {
+		return nil, err
+	}
+	postgres, err := binary(binDir, "postgres")
+	if err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "PG_VERSION")); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		out, err := exec.CommandContext(ctx, initdb, "-D", dir, "-U", user, "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("devdb: initdb: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, logName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	// Clients use TCP; the socket still has to go somewhere writable, and the
+	// packaged default (/var/run/postgresql) often is not.
+	cmd := exec.Command(postgres, "-D", dir, "-p", strconv.Itoa(port),
+		"-c", "listen_addresses=127.0.0.1", "-c", "unix_socket_directories="+os.TempDir(), "-c", "fsync=off")
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	stopWithParent(cmd)
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("devdb: postgres: %w", err)
+	}
+	s := &Server{cmd: cmd, done: make(chan error, 1)}
+	go func() {
+		s.done <- cmd.Wait()
+		logFile.Close()
+	}()
+
+	base := fmt.Sprintf("postgres://%s@127.0.0.1:%d/", user, port)
+	if err := s.waitReady(ctx, base+"postgres?sslmode=disable", filepath.Join(dir, logName)); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	if err := createDatabase(ctx, base+"postgres?sslmode=disable"); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	s.URL = base + database + "?sslmode=disable"
+	return s, nil
+}
+
+// Stop shuts the server down (fast shutdown: open sessions are cancelled).
+func (s *Server) Stop() error {
+	if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+		return nil // already gone
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(stopTimeout):
+		_ = s.cmd.Process.Kill()
+		<-s.done
+		return errors.New("devdb: postgres did not stop in time and was killed")
+	}
+}
+
+func (s *Server) waitReady(ctx context.Context, url, logPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	for {
+		conn, err := pgx.Connect(ctx, url)
+		if err == nil {
+			return conn.Close(ctx)
+		}
+		select {
+		case werr := <-s.done:
+			s.done <- werr // for Stop
+			return fmt.Errorf("devdb: postgres exited: %v; see %s", werr, logPath)
+		case <-ctx.Done():
+			return fmt.Errorf("devdb: postgres not ready after %s: %w; see %s", startTimeout, err, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func createDatabase(ctx context.Context, url string) error {
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname=$1)`, database).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = conn.Exec(ctx, `CREATE DATABASE `+pgx.Identifier{database}.Sanitize())
+	return err
+}
+
+// binary finds a server binary in binDir, on PATH, or in the newest
+// /usr/lib/postgresql/<version>/bin (Debian keeps them off PATH).
+func binary(binDir, name string) (string, error) {
+	if binDir != "" {
+		p := filepath.Join(binDir, name)
+		if _, err := os.Stat(p); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrNoBinaries, err)
+		}
+		return p, nil
+	}
+	if p, err := exec.LookPath(name); err == nil {
+		return p, nil
+	}
+	found, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql", "*", "bin", name))
+	if len(found) == 0 {
+		return "", ErrNoBinaries
+	}
+	sort.Slice(found, func(i, j int) bool { return pgMajor(found[i]) > pgMajor(found[j]) })
+	return found[0], nil
+}
+
+// pgMajor is the <version> of /usr/lib/postgresql/<version>/bin/<name>.
+func pgMajor(path string) int {
+	n, _ := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(path))))
+	return n
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,13 @@
+package devdb
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// stopWithParent has the kernel send postgres a fast shutdown when this
+// process dies without calling Stop (log.Fatal, a crash), so the next start
+// does not find the data directory locked by an orphan.
+func stopWithParent(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGINT}
+}
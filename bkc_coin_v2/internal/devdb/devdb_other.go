@@ -0,0 +1,8 @@
+//go:build !linux
+
+package devdb
+
+import "os/exec"
+
+// stopWithParent is a no-op off Linux: stop an orphaned server by hand.
+func stopWithParent(*exec.Cmd) {}
@@ -0,0 +1,129 @@
+package devdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/testenv"
+)
+
+func TestMain(m *testing.M) { os.Exit(testenv.Run(m)) }
+
+func startTest(t *testing.T) *Server {
+	t.Helper()
+	if os.Geteuid() == 0 {
+		t.Skip("postgres does not run as root")
+	}
+	s, err := Start(context.Background(), t.TempDir(), os.Getenv("PG_BIN"))
+	if errors.Is(err, ErrNoBinaries) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := s.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	return s
+}
+
+// schema lists the public tables' columns and indexes after migrations.
+func schema(t *testing.T, url string) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	d, err := db.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := d.Pool.Query(ctx, `
+SELECT 'column ' || table_name || '.' || column_name, data_type || CASE WHEN is_nullable = 'YES' THEN ' null' ELSE ' not null' END
+FROM information_schema.columns WHERE table_schema = 'public'
+UNION ALL
+SELECT 'index ' || tablename || '.' || indexname, ''
+FROM pg_indexes WHERE schemaname = 'public'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			t.Fatal(err)
+		}
+		out[k] = v
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// The embedded server must end up with the schema the Postgres migrations
+// build on a regular server.
+func TestSchemaParity(t *testing.T) {
+	want := schema(t, testenv.Postgres(t))
+	got := schema(t, startTest(t).URL)
+	if len(got) == 0 {
+		t.Fatal("no schema")
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok {
+			t.Errorf("embedded: missing %s", k)
+		} else if g != v {
+			t.Errorf("embedded: %s is %q, want %q", k, g, v)
+		}
+	}
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			t.Errorf("embedded: extra %s", k)
+		}
+	}
+}
+
+// A second start reuses the data directory and the database in it.
+func TestRestartKeepsData(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("postgres does not run as root")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	for i, q := range []string{`CREATE TABLE kept (n INT); INSERT INTO kept VALUES (7)`, `SELECT n FROM kept`} {
+		s, err := Start(ctx, dir, os.Getenv("PG_BIN"))
+		if errors.Is(err, ErrNoBinaries) {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := db.Connect(ctx, s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			_, err = d.Pool.Exec(ctx, q)
+		} else {
+			var n int
+			if err = d.Pool.QueryRow(ctx, q).Scan(&n); err == nil && n != 7 {
+				t.Errorf("kept %d", n)
+			}
+		}
+		d.Close()
+		if serr := s.Stop(); serr != nil {
+			t.Error(serr)
+		}
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"bkc_coin_v2/internal/config"
@@ -48,6 +49,10 @@ type Engine struct {
 	HealthPendingScan int64
 
 	scriptTap *redis.Script
+
+	// Overload signals for admission control.
+	applyStartedNano atomic.Int64
+	lastApplyNanos   atomic.Int64
 }
 
 type TapResult struct {
@@ -55,6 +60,7 @@ type TapResult struct {
 	Reason         string
 	Energy         int64
 	EnergyMax      int64
+	DailyLimit     int64 // 0 = unlimited
 	DailyTapped    int64
 	DailyExtra     int64
 	DailyRemaining int64
@@ -270,6 +276,10 @@ func (e *Engine) EnsureUserCached(ctx context.Context, userID int64, username, f
 	if now.IsZero() {
 		now = time.Now().UTC()
 	}
+	limit, err := e.DB.GetUserTapLimit(ctx, userID, e.Cfg.TapDailyLimit, now)
+	if err != nil {
+		return err
+	}
 
 	// Cache energy state.
 	if err := e.Rdb.HSet(ctx, key,
@@ -279,12 +289,14 @@ func (e *Engine) EnsureUserCached(ctx context.Context, userID int64, username, f
 		"boost_until", u.EnergyBoostUntil.UTC().Unix(),
 		"boost_regen_mult", u.EnergyBoostRegenMultiplier,
 		"boost_max_mult", u.EnergyBoostMaxMultiplier,
+		"daily_limit", limit.Limit,
+		"daily_limit_until", unixOrZero(limit.Until),
 	).Err(); err != nil {
 		return err
 	}
 
 	// Cache daily counters for today (prevents easy bypass if Redis restarts mid-day).
-	if e.Cfg.TapDailyLimit > 0 {
+	if e.Cfg.TapDailyLimit > 0 || limit.Limit > 0 {
 		ud, err := e.DB.GetUserDaily(ctx, userID, now)
 		if err != nil {
 			return err
@@ -384,6 +396,7 @@ func (e *Engine) Tap(ctx context.Context, userID int64, requested int64, now tim
 		DailyTapped:    getI64(4),
 		DailyExtra:     getI64(5),
 		DailyRemaining: getI64(6),
+		DailyLimit:     getI64(7),
 	}
 	if res.Gained < 0 {
 		res.Gained = 0
@@ -434,6 +447,27 @@ func (e *Engine) UpdateEnergyBoost(ctx context.Context, userID int64, until time
 	).Err()
 }
 
+// SetDailyLimit updates a cached user's limit after a plan change; users not
+// cached pick it up from the DB on their next tap.
+func (e *Engine) SetDailyLimit(ctx context.Context, userID int64, limit db.TapLimit) error {
+	if !e.Enabled() || userID <= 0 {
+		return nil
+	}
+	key := e.userKey(userID)
+	exists, err := e.Rdb.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+	return e.Rdb.HSet(ctx, key, "daily_limit", limit.Limit, "daily_limit_until", unixOrZero(limit.Until)).Err()
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UTC().Unix()
+}
+
 func (e *Engine) AddDailyExtraQuota(ctx context.Context, userID int64, day time.Time, extra int64) error {
 	if !e.Enabled() || extra == 0 || userID <= 0 {
 		return nil
@@ -491,6 +525,39 @@ func (e *Engine) QueueStats(ctx context.Context) map[string]any {
 	return out
 }
 
+// QueueDepth is the number of stream entries not yet persisted: undelivered
+// (group lag) plus delivered but unacknowledged.
+func (e *Engine) QueueDepth(ctx context.Context) (int64, error) {
+	if !e.Enabled() {
+		return 0, nil
+	}
+	groups, err := e.Rdb.XInfoGroups(ctx, e.StreamKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range groups {
+		if g.Name == e.StreamGroup {
+			return g.Lag + g.Pending, nil
+		}
+	}
+	return 0, nil
+}
+
+// DBLatency is the duration of the last ApplyTapEvents batch, or the age of
+// the batch in flight when that is longer.
+func (e *Engine) DBLatency() time.Duration {
+	if !e.Enabled() {
+		return 0
+	}
+	d := time.Duration(e.lastApplyNanos.Load())
+	if started := e.applyStartedNano.Load(); started > 0 {
+		if inFlight := time.Since(time.Unix(0, started)); inFlight > d {
+			d = inFlight
+		}
+	}
+	return d
+}
+
 func envInt64(key string, def int64) int64 {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
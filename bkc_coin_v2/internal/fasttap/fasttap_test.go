@@ -0,0 +1,170 @@
+package fasttap
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/testenv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Integration tests need Redis and Postgres (see testenv):
+//
+//	BKC_TEST_DOCKER=1 go test ./internal/fasttap
+
+func TestMain(m *testing.M) { os.Exit(testenv.Run(m)) }
+
+// testEngine returns an engine on its own Redis keys with no energy regen.
+func testEngine(t *testing.T, energyMax int64) *Engine {
+	ctx := context.Background()
+	d, err := db.Connect(ctx, testenv.Postgres(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.EnsureSystemState(ctx, 1_000_000_000, 1, 0, 1_000_000_000, 1_000, 500, 3, 100); err != nil {
+		t.Fatal(err)
+	}
+	rdb, err := Connect(ctx, testenv.Redis(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	e := New(config.Config{EnergyMax: energyMax, TapMaxPerRequest: 10}, d, rdb)
+	suffix := randomHex(4)
+	e.SysKey = "bkc:test:sys:" + suffix
+	e.StreamKey = "bkc:test:taps:" + suffix
+	e.StreamGroup = "test"
+	t.Cleanup(func() { _ = rdb.Del(context.Background(), e.SysKey, e.StreamKey).Err() })
+	if err := rdb.XGroupCreateMkStream(ctx, e.StreamKey, e.StreamGroup, "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EnsureSystemCached(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// Concurrent taps share one energy pool in Redis, and a stream batch that
+// two workers both apply is credited to Postgres once.
+func TestTapStreamCreditsOnce(t *testing.T) {
+	e := testEngine(t, 100)
+	ctx := context.Background()
+	const userID = 9_300_500_000
+	now := time.Now().UTC()
+
+	cleanup := func() {
+		bg := context.Background()
+		_ = e.Rdb.Del(bg, e.userKey(userID), e.dailyKey(userID, now)).Err()
+		_, _ = e.DB.Pool.Exec(bg, `DELETE FROM ledger WHERE to_id=$1`, userID)
+		_, _ = e.DB.Pool.Exec(bg, `DELETE FROM user_daily WHERE user_id=$1`, userID)
+		_, _ = e.DB.Pool.Exec(bg, `DELETE FROM users WHERE user_id=$1`, userID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+	sys, err := e.DB.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DB.SetDailyEmissionCap(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = e.DB.SetDailyEmissionCap(context.Background(), sys.DailyEmissionCap) })
+	if err := e.EnsureUserCached(ctx, userID, "", "", now); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var gained int64
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := e.Tap(ctx, userID, 10, now)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			gained += res.Gained
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if gained != 100 {
+		t.Fatalf("gained %d with 100 energy", gained)
+	}
+
+	streams, err := e.Rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: e.StreamGroup, Consumer: "test", Streams: []string{e.StreamKey, ">"}, Count: 1000,
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := streams[0].Messages
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !e.processMessages(ctx, msgs) {
+				t.Error("apply failed")
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	u, err := e.DB.GetUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ledgerSum int64
+	if err := e.DB.Pool.QueryRow(ctx, `SELECT COALESCE(SUM(amount), 0) FROM ledger WHERE to_id=$1 AND kind='tap'`, userID).Scan(&ledgerSum); err != nil {
+		t.Fatal(err)
+	}
+	if u.Balance != gained || ledgerSum != gained {
+		t.Fatalf("balance %d, ledger %d, gained %d", u.Balance, ledgerSum, gained)
+	}
+	after, err := e.DB.GetSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys.ReserveSupply-after.ReserveSupply != gained {
+		t.Fatalf("reserve moved %d, gained %d", sys.ReserveSupply-after.ReserveSupply, gained)
+	}
+	cached, err := e.Rdb.HGet(ctx, e.SysKey, "reserve_supply").Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sys.ReserveSupply - gained; cached != want {
+		t.Fatalf("cached reserve %d, want %d", cached, want)
+	}
+}
+
+// Stream workers return once their context is cancelled, also while blocked
+// in XREADGROUP.
+func TestWorkersStopWithContext(t *testing.T) {
+	testenv.NoLeaks(t)
+	e := testEngine(t, 100)
+	e.WorkerCount = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	e.StartWorker(ctx)
+	time.Sleep(100 * time.Millisecond) // let them block in XREADGROUP
+	cancel()
+}
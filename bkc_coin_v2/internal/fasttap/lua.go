@@ -66,6 +66,13 @@ if energy < 0 then energy = 0 end
 local mintable = math.floor(energy)
 if mintable < 0 then mintable = 0 end
 
+-- Plan limit cached with the user (until 0 = no expiry); expired plans fall back to ARGV.
+local userLimit = tonumber(redis.call('HGET', userKey, 'daily_limit') or '')
+local userLimitUntil = tonumber(redis.call('HGET', userKey, 'daily_limit_until') or '0')
+if userLimit ~= nil and (userLimitUntil == nil or userLimitUntil == 0 or now < userLimitUntil) then
+  dailyLimit = userLimit
+end
+
 -- Daily quota.
 local tapped = tonumber(redis.call('HGET', dailyKey, 'tapped') or '0')
 local extraQuota = tonumber(redis.call('HGET', dailyKey, 'extra_quota') or '0')
@@ -145,5 +152,5 @@ else
   outRemaining = 9223372036854775807
 end
 
-return {tostring(gained), reason, tostring(outEnergy), tostring(outEnergyMax), tostring(outTapped), tostring(outExtra), tostring(outRemaining)}
+return {tostring(gained), reason, tostring(outEnergy), tostring(outEnergyMax), tostring(outTapped), tostring(outExtra), tostring(outRemaining), tostring(dailyLimit)}
 `
@@ -54,9 +54,14 @@ func (e *Engine) workerLoop(ctx context.Context, consumer string, enableClaim bo
 				}
 				continue
 			}
+			if ctx.Err() != nil {
+				return
+			}
 			// transient
 			log.Printf("fasttap: XREADGROUP error: %v", err)
-			time.Sleep(750 * time.Millisecond)
+			if !sleep(ctx, 750*time.Millisecond) {
+				return
+			}
 			if enableClaim && time.Now().After(nextClaimAt) {
 				e.claimPending(ctx, consumer)
 				nextClaimAt = time.Now().Add(e.ClaimEvery)
@@ -77,7 +82,9 @@ func (e *Engine) workerLoop(ctx context.Context, consumer string, enableClaim bo
 			}
 			if ok := e.processMessages(ctx, st.Messages); !ok {
 				// Keep unacked entries to be retried by this or claimed by another consumer.
-				time.Sleep(250 * time.Millisecond)
+				if !sleep(ctx, 250*time.Millisecond) {
+					return
+				}
 			}
 		}
 
@@ -88,6 +95,18 @@ func (e *Engine) workerLoop(ctx context.Context, consumer string, enableClaim bo
 	}
 }
 
+// sleep waits d; false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
 func (e *Engine) claimPending(ctx context.Context, consumer string) {
 	start := "0-0"
 	for round := 0; round < e.ClaimMaxRounds; round++ {
@@ -180,7 +199,12 @@ func (e *Engine) processMessages(ctx context.Context, msgs []redis.XMessage) boo
 			ackIDs = append(ackIDs, x.id)
 		}
 
-		if err := e.DB.ApplyTapEvents(ctx, events); err != nil {
+		started := time.Now()
+		e.applyStartedNano.Store(started.UnixNano())
+		err := e.DB.ApplyTapEvents(ctx, events, e.Cfg.TapDailyLimit)
+		e.lastApplyNanos.Store(int64(time.Since(started)))
+		e.applyStartedNano.Store(0)
+		if err != nil {
 			log.Printf("fasttap: apply events error: %v", err)
 			return false
 		}
@@ -0,0 +1,205 @@
+// Package faults delays or fails payment RPC calls, database commits and
+// webhook deliveries on demand, so recovery logic (idempotency keys, retries,
+// sweepers) can be exercised against realistic failures. Nothing is injected
+// until Enable is called, which servers only do outside production.
+//
+// Rules are written as "point=option,option; point=option", e.g.
+//
+//	db.commit=fail_after:0.5; webhook.deliver=delay:3s,fail
+//
+// Options: delay:<duration>, fail[:rate] (fail before the operation) and
+// fail_after[:rate] (the operation takes effect, then the caller sees an
+// error — a lost response). A rate is a probability in 0..1, default 1.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Injection points.
+const (
+	DBCommit       = "db.commit"
+	SolanaRPC      = "solana.rpc"
+	WebhookDeliver = "webhook.deliver"
+)
+
+var points = map[string]bool{DBCommit: true, SolanaRPC: true, WebhookDeliver: true}
+
+// Header carries per-request rules; they override the process-wide ones.
+const Header = "X-BKC-Fault"
+
+// ErrInjected is returned by an injected failure.
+var ErrInjected = errors.New("faults: injected failure")
+
+// Rule is what happens at one point.
+type Rule struct {
+	Delay     time.Duration `json:"delay,omitempty"`
+	Fail      float64       `json:"fail,omitempty"`
+	FailAfter float64       `json:"fail_after,omitempty"`
+}
+
+// Rules maps injection points to rules.
+type Rules map[string]Rule
+
+// Parse reads rules in the package syntax. An empty string is no rules.
+func Parse(s string) (Rules, error) {
+	out := Rules{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		point, opts, ok := strings.Cut(part, "=")
+		point = strings.TrimSpace(point)
+		if !ok || !points[point] {
+			return nil, fmt.Errorf("faults: bad point in %q", part)
+		}
+		var r Rule
+		for _, opt := range strings.Split(opts, ",") {
+			name, val, hasVal := strings.Cut(strings.TrimSpace(opt), ":")
+			switch name {
+			case "delay":
+				d, err := time.ParseDuration(val)
+				if err != nil || d < 0 || d > time.Minute {
+					return nil, fmt.Errorf("faults: bad delay in %q", part)
+				}
+				r.Delay = d
+			case "fail", "fail_after":
+				rate := 1.0
+				if hasVal {
+					var err error
+					if rate, err = strconv.ParseFloat(val, 64); err != nil || rate < 0 || rate > 1 {
+						return nil, fmt.Errorf("faults: bad rate in %q", part)
+					}
+				}
+				if name == "fail" {
+					r.Fail = rate
+				} else {
+					r.FailAfter = rate
+				}
+			default:
+				return nil, fmt.Errorf("faults: bad option %q", opt)
+			}
+		}
+		out[point] = r
+	}
+	return out, nil
+}
+
+var (
+	enabled atomic.Bool
+	mu      sync.RWMutex
+	global  = Rules{}
+)
+
+// Enable turns injection on for the process.
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether injection is on.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// SetGlobal replaces the process-wide rules, used by background jobs and
+// requests without the header.
+func SetGlobal(r Rules) {
+	mu.Lock()
+	global = r
+	mu.Unlock()
+}
+
+// Global returns the process-wide rules.
+func Global() Rules {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make(Rules, len(global))
+	for k, v := range global {
+		out[k] = v
+	}
+	return out
+}
+
+type ctxKey struct{}
+
+// WithRules attaches per-request rules to ctx.
+func WithRules(ctx context.Context, r Rules) context.Context {
+	return context.WithValue(ctx, ctxKey{}, r)
+}
+
+func ruleFor(ctx context.Context, point string) (Rule, bool) {
+	if r, ok := ctx.Value(ctxKey{}).(Rules); ok {
+		rule, ok := r[point]
+		return rule, ok
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	rule, ok := global[point]
+	return rule, ok
+}
+
+// Inject runs before the operation at point: it sleeps for the rule's delay
+// and may fail with ErrInjected.
+func Inject(ctx context.Context, point string) error {
+	if !enabled.Load() {
+		return nil
+	}
+	r, ok := ruleFor(ctx, point)
+	if !ok {
+		return nil
+	}
+	if r.Delay > 0 {
+		t := time.NewTimer(r.Delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if r.Fail > 0 && rand.Float64() < r.Fail {
+		return fmt.Errorf("%w at %s", ErrInjected, point)
+	}
+	return nil
+}
+
+// InjectAfter runs after the operation at point took effect and may fail
+// with ErrInjected, as if the response was lost.
+func InjectAfter(ctx context.Context, point string) error {
+	if !enabled.Load() {
+		return nil
+	}
+	r, ok := ruleFor(ctx, point)
+	if ok && r.FailAfter > 0 && rand.Float64() < r.FailAfter {
+		return fmt.Errorf("%w after %s", ErrInjected, point)
+	}
+	return nil
+}
+
+// Middleware attaches rules from the X-BKC-Fault header to the request
+// context. A malformed header is rejected rather than silently ignored.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := r.Header.Get(Header)
+		if h == "" || !enabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rules, err := Parse(h)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithRules(r.Context(), rules)))
+	})
+}
@@ -0,0 +1,87 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("db.commit=fail_after:0.5; webhook.deliver=delay:20ms,fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r[DBCommit] != (Rule{FailAfter: 0.5}) {
+		t.Fatalf("db.commit: %+v", r[DBCommit])
+	}
+	if r[WebhookDeliver] != (Rule{Delay: 20 * time.Millisecond, Fail: 1}) {
+		t.Fatalf("webhook.deliver: %+v", r[WebhookDeliver])
+	}
+	for _, bad := range []string{"nope=fail", "db.commit=fail:2", "db.commit=delay:x", "db.commit=explode"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	ctx := WithRules(context.Background(), Rules{DBCommit: {Fail: 1}, WebhookDeliver: {FailAfter: 1}})
+	if err := Inject(ctx, DBCommit); err != nil {
+		t.Fatalf("disabled: %v", err)
+	}
+	Enable()
+	t.Cleanup(func() { enabled.Store(false); SetGlobal(Rules{}) })
+
+	if err := Inject(ctx, DBCommit); !errors.Is(err, ErrInjected) {
+		t.Fatalf("fail: %v", err)
+	}
+	if err := Inject(ctx, WebhookDeliver); err != nil {
+		t.Fatalf("before a fail_after rule: %v", err)
+	}
+	if err := InjectAfter(ctx, WebhookDeliver); !errors.Is(err, ErrInjected) {
+		t.Fatalf("fail_after: %v", err)
+	}
+
+	// Without per-request rules the process-wide ones apply.
+	SetGlobal(Rules{SolanaRPC: {Fail: 1}})
+	if err := Inject(context.Background(), SolanaRPC); !errors.Is(err, ErrInjected) {
+		t.Fatalf("global: %v", err)
+	}
+	if err := Inject(ctx, SolanaRPC); err != nil {
+		t.Fatalf("request rules should override global: %v", err)
+	}
+
+	// A delay gives way to a cancelled context.
+	slow := WithRules(context.Background(), Rules{DBCommit: {Delay: time.Minute}})
+	cctx, cancel := context.WithTimeout(slow, 10*time.Millisecond)
+	defer cancel()
+	if err := Inject(cctx, DBCommit); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("delay: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	Enable()
+	t.Cleanup(func() { enabled.Store(false) })
+	var got Rules
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(ctxKey{}).(Rules)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(Header, "db.commit=fail")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got[DBCommit].Fail != 1 {
+		t.Fatalf("rules from header: %+v", got)
+	}
+
+	req.Header.Set(Header, "db.commit=oops")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad header: status %d", rec.Code)
+	}
+}
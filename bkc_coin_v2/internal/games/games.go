@@ -2,13 +2,10 @@ package games
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	mathrand "math/rand"
 	"time"
 
 	"bkc_coin_v2/internal/db"
@@ -16,12 +13,13 @@ import (
 
 // GamesManager управляет играми и биржей
 type GamesManager struct {
-	db *db.DB
+	db        *db.DB
+	betLimits db.BetLimitPolicy
 }
 
-// NewGamesManager создает новый менеджер игр
-func NewGamesManager(database *db.DB) *GamesManager {
-	return &GamesManager{db: database}
+// NewGamesManager создает новый менеджер игр; betLimits ограничивает размер одной ставки
+func NewGamesManager(database *db.DB, betLimits db.BetLimitPolicy) *GamesManager {
+	return &GamesManager{db: database, betLimits: betLimits}
 }
 
 // CrashGame игра "Ракетка"
@@ -81,59 +79,33 @@ type Trade struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// ProvablyFair данные для честной игры
+// ProvablyFair данные для честной игры (commit-reveal, см. seeding.go)
 type ProvablyFair struct {
-	GameID     string `json:"game_id"`
-	ServerSeed string `json:"server_seed"`
-	ClientSeed string `json:"client_seed"`
-	Nonce      int    `json:"nonce"`
-	Hash       string `json:"hash"`
-	Salt       string `json:"salt"`
-}
-
-// GenerateCrashHash генерирует хеш для игры Ракетка
-func GenerateCrashHash() (string, string, float64, error) {
-	// Генерируем случайную соль
-	saltBytes := make([]byte, 16)
-	if _, err := rand.Read(saltBytes); err != nil {
-		return "", "", 0, err
-	}
-	salt := hex.EncodeToString(saltBytes)
-
-	// Генерируем точку краха (1.00 - 10.00)
-	// 3% раундов взрываются на 1.00x
-	crashPoint := 1.00
-	r := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
-	if r.Float64() > 0.03 { // 97% шанс на точку выше 1.00
-		// Генерируем точку от 1.01 до 10.00
-		crashPoint = 1.01 + (r.Float64() * 8.99)      // 1.01 - 10.00
-		crashPoint = math.Round(crashPoint*100) / 100 // Округляем до 2 знаков
-	}
-
-	// Создаем хеш
-	combined := fmt.Sprintf("%s-%.2f-%d", salt, crashPoint, time.Now().Unix())
-	hash := sha256.Sum256([]byte(combined))
-	hashStr := hex.EncodeToString(hash[:])
-
-	return hashStr, salt, crashPoint, nil
+	GameID      string      `json:"game_id"`
+	Hash        string      `json:"hash"`                  // sha256(server_seed), опубликован до ставок
+	ServerSeed  string      `json:"server_seed,omitempty"` // после взрыва
+	ClientSeeds []CrashSeed `json:"client_seeds"`
+	SeedsDigest string      `json:"seeds_digest"`
+	CrashPoint  float64     `json:"crash_point,omitempty"` // после взрыва
+	Revealed    bool        `json:"revealed"`
 }
 
 // StartCrashGame начинает новую игру Ракетка
 func (gm *GamesManager) StartCrashGame(ctx context.Context) (*CrashGame, error) {
-	hash, salt, crashPoint, err := GenerateCrashHash()
+	// Коммит: публикуем только hash, точку считает LaunchCrashGame (см. seeding.go)
+	salt, hash, err := newServerSeed()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate crash hash: %w", err)
+		return nil, fmt.Errorf("failed to generate server seed: %w", err)
 	}
 
 	gameID := fmt.Sprintf("crash_%d", time.Now().Unix())
 
 	game := &CrashGame{
-		GameID:     gameID,
-		Hash:       hash,
-		Salt:       salt,
-		CrashPoint: crashPoint,
-		Status:     "waiting",
-		StartedAt:  time.Now(),
+		GameID:    gameID,
+		Hash:      hash,
+		Salt:      salt,
+		Status:    "waiting",
+		StartedAt: time.Now(),
 	}
 
 	// Сохраняем игру в базу
@@ -148,13 +120,23 @@ func (gm *GamesManager) StartCrashGame(ctx context.Context) (*CrashGame, error)
 		return nil, fmt.Errorf("failed to create crash game: %w", err)
 	}
 
-	log.Printf("Crash game %s started with crash point %.2f", gameID, crashPoint)
+	log.Printf("Crash game %s started, commit %s", gameID, hash)
 
+	game.Salt = ""
 	return game, nil
 }
 
-// PlaceCrashBet делает ставку в игре Ракетка
-func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID string, amount int64, autoCashout float64) (*CrashBet, error) {
+// BetSource чем оплачивается ставка
+type BetSource string
+
+const (
+	BetFromBalance BetSource = "balance"  // реальный баланс, нехватку покрывает бонусный
+	BetFromFreeBet BetSource = "free_bet" // фрибет пользователя, ставка равна фрибету
+	BetFromCredits BetSource = "credits"  // игровые кредиты; выигрыш тоже в кредитах
+)
+
+// PlaceCrashBet делает ставку в игре Ракетка; source — чем она оплачена
+func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID string, amount int64, autoCashout float64, source BetSource) (*CrashBet, error) {
 	if amount <= 0 {
 		return nil, fmt.Errorf("bet amount must be positive")
 	}
@@ -176,10 +158,6 @@ func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID
 		return nil, fmt.Errorf("failed to get user balance: %w", err)
 	}
 
-	if userBalance < amount {
-		return nil, fmt.Errorf("insufficient balance: need %d, have %d", amount, userBalance)
-	}
-
 	// Проверяем статус игры
 	var gameStatus string
 	err = tx.QueryRow(ctx, "SELECT status FROM crash_games WHERE game_id = $1 FOR UPDATE", gameID).Scan(&gameStatus)
@@ -187,18 +165,49 @@ func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID
 		return nil, fmt.Errorf("game not found: %w", err)
 	}
 
-	if gameStatus != "waiting" && gameStatus != "active" {
+	// Ставки только в окне приема ставок, до LaunchCrashGame
+	if gameStatus != "waiting" {
 		return nil, fmt.Errorf("game is not accepting bets")
 	}
 
-	// Списываем монеты
-	_, err = tx.Exec(ctx, "UPDATE users SET balance = balance - $1 WHERE user_id = $2", amount, userID)
+	betID := fmt.Sprintf("bet_%d_%d", userID, time.Now().Unix())
+
+	betValue := amount
+	var stake db.BonusStake
+	switch source {
+	case BetFromCredits:
+		// Ставка в кредитах; лимиты считаются по ее стоимости в BKC
+		betValue, err = db.CreditsStakeTx(ctx, tx, userID, betID, amount)
+		if errors.Is(err, db.ErrNotEnough) {
+			return nil, fmt.Errorf("insufficient credits: need %d", amount)
+		}
+	case BetFromBalance, BetFromFreeBet:
+		// Нехватку реального баланса покрывает бонусный, фрибет — всю ставку; ставка отыгрывает бонус
+		stake, err = db.BonusStakeTx(ctx, tx, userID, betID, amount, autoCashout, source == BetFromFreeBet, userBalance)
+		if errors.Is(err, db.ErrNotEnough) {
+			return nil, fmt.Errorf("insufficient balance: need %d, have %d", amount, userBalance)
+		}
+	default:
+		return nil, fmt.Errorf("bad source: %q", source)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to deduct balance: %w", err)
+		return nil, err
+	}
+
+	// Лимит ставки: min(конфиг, % баланса, тариф), для новых аккаунтов меньше
+	if err := db.CheckBetLimit(ctx, tx, userID, betValue, gm.betLimits); err != nil {
+		return nil, err
+	}
+
+	// Списываем монеты
+	if source != BetFromCredits {
+		_, err = tx.Exec(ctx, "UPDATE users SET balance = balance - $1 WHERE user_id = $2", amount-stake.Bonus, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deduct balance: %w", err)
+		}
 	}
 
 	// Создаем ставку
-	betID := fmt.Sprintf("bet_%d_%d", userID, time.Now().Unix())
 	now := time.Now()
 
 	var newBetID int64
@@ -213,23 +222,28 @@ func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID
 		return nil, fmt.Errorf("failed to create bet: %w", err)
 	}
 
-	// Обновляем общую сумму ставок в игре
-	_, err = tx.Exec(ctx, "UPDATE crash_games SET total_bets = total_bets + $1 WHERE game_id = $2", amount, gameID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update game totals: %w", err)
-	}
+	// Ставки в кредитах не входят в суммы игры и в леджер BKC (там credits_bet)
+	if source != BetFromCredits {
+		// Обновляем общую сумму ставок в игре
+		_, err = tx.Exec(ctx, "UPDATE crash_games SET total_bets = total_bets + $1 WHERE game_id = $2", amount, gameID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update game totals: %w", err)
+		}
 
-	// Записываем в ledger
-	_, err = tx.Exec(ctx, `
-		INSERT INTO ledger(kind, from_id, to_id, amount, meta)
-		VALUES('crash_bet', $1, NULL, $2, $3::jsonb)
-	`, userID, amount, fmt.Sprintf(`{
-		"game_id": "%s",
-		"bet_id": "%s",
-		"auto_cashout": %.2f
-	}`, gameID, betID, autoCashout))
-	if err != nil {
-		return nil, fmt.Errorf("failed to record bet: %w", err)
+		// Записываем в ledger
+		_, err = tx.Exec(ctx, `
+			INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+			VALUES('crash_bet', $1, NULL, $2, $3::jsonb)
+		`, userID, amount, fmt.Sprintf(`{
+			"game_id": "%s",
+			"bet_id": "%s",
+			"auto_cashout": %.2f,
+			"bonus": %d,
+			"free_bet": %t
+		}`, gameID, betID, autoCashout, stake.Bonus, stake.FreeBet))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record bet: %w", err)
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -247,8 +261,8 @@ func (gm *GamesManager) PlaceCrashBet(ctx context.Context, userID int64, gameID
 		UpdatedAt:   now,
 	}
 
-	log.Printf("User %d placed bet %d BKC in crash game %s (auto cashout: %.2f)",
-		userID, amount, gameID, autoCashout)
+	log.Printf("User %d placed bet %d (%s) in crash game %s (auto cashout: %.2f)",
+		userID, amount, source, gameID, autoCashout)
 
 	return bet, nil
 }
@@ -278,11 +292,19 @@ func (gm *GamesManager) CashoutBet(ctx context.Context, betID string, currentMul
 		return nil, fmt.Errorf("bet not found or not active: %w", err)
 	}
 
+	// Задержка вывода от старта полета — сигнал проверки на ботов (db.ScoreGameBots)
+	var startedAt time.Time
+	err = tx.QueryRow(ctx, "SELECT started_at FROM crash_games WHERE game_id = $1", bet.GameID).Scan(&startedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game: %w", err)
+	}
+
 	// Рассчитываем выигрыш
 	winAmount := int64(math.Floor(float64(bet.Amount) * currentMultiplier))
 
 	// Обновляем ставку
 	now := time.Now()
+	latencyMs := now.Sub(startedAt).Milliseconds()
 	_, err = tx.Exec(ctx, `
 		UPDATE crash_bets 
 		SET cashed_out_at = $1, win_amount = $2, status = 'cashed_out', updated_at = $3
@@ -292,10 +314,23 @@ func (gm *GamesManager) CashoutBet(ctx context.Context, betID string, currentMul
 		return nil, fmt.Errorf("failed to update bet: %w", err)
 	}
 
-	// Выдаем выигрыш пользователю
-	_, err = tx.Exec(ctx, "UPDATE users SET balance = balance + $1 WHERE user_id = $2", winAmount, bet.UserID)
+	// Ставка в кредитах выигрывает кредиты
+	inCredits, err := db.SettleCreditsBetTx(ctx, tx, betID, winAmount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to credit winnings: %w", err)
+		return nil, fmt.Errorf("failed to settle credits: %w", err)
+	}
+	if !inCredits {
+		// Доля выигрыша бонусной ставки возвращается на бонусный баланс
+		toBonus, err := db.SettleBonusBetTx(ctx, tx, betID, winAmount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to settle bonus: %w", err)
+		}
+
+		// Выдаем выигрыш пользователю
+		_, err = tx.Exec(ctx, "UPDATE users SET balance = balance + $1 WHERE user_id = $2", winAmount-toBonus, bet.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to credit winnings: %w", err)
+		}
 	}
 
 	// Обновляем статистику игры
@@ -304,18 +339,21 @@ func (gm *GamesManager) CashoutBet(ctx context.Context, betID string, currentMul
 		return nil, fmt.Errorf("failed to update game stats: %w", err)
 	}
 
-	// Записываем в ledger
-	_, err = tx.Exec(ctx, `
-		INSERT INTO ledger(kind, from_id, to_id, amount, meta)
-		VALUES('crash_win', NULL, $1, $2, $3::jsonb)
-	`, bet.UserID, winAmount, fmt.Sprintf(`{
-		"game_id": "%s",
-		"bet_id": "%s",
-		"multiplier": %.2f,
-		"bet_amount": %d
-	}`, bet.GameID, betID, currentMultiplier, bet.Amount))
-	if err != nil {
-		return nil, fmt.Errorf("failed to record win: %w", err)
+	if !inCredits {
+		// Записываем в ledger
+		_, err = tx.Exec(ctx, `
+			INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+			VALUES('crash_win', NULL, $1, $2, $3::jsonb)
+		`, bet.UserID, winAmount, fmt.Sprintf(`{
+			"game_id": "%s",
+			"bet_id": "%s",
+			"multiplier": %.2f,
+			"bet_amount": %d,
+			"latency_ms": %d
+		}`, bet.GameID, betID, currentMultiplier, bet.Amount, latencyMs))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record win: %w", err)
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -327,8 +365,12 @@ func (gm *GamesManager) CashoutBet(ctx context.Context, betID string, currentMul
 	bet.Status = "cashed_out"
 	bet.UpdatedAt = now
 
-	log.Printf("User %d cashed out bet %s at %.2fx: won %d BKC",
-		bet.UserID, betID, currentMultiplier, winAmount)
+	unit := "BKC"
+	if inCredits {
+		unit = "credits"
+	}
+	log.Printf("User %d cashed out bet %s at %.2fx: won %d %s",
+		bet.UserID, betID, currentMultiplier, winAmount, unit)
 
 	return &bet, nil
 }
@@ -377,6 +419,7 @@ func (gm *GamesManager) CrashGame(ctx context.Context, gameID string) error {
 	defer rows.Close()
 
 	var totalLost int64
+	var lostBets []string
 	for rows.Next() {
 		var betID string
 		var userID int64
@@ -397,8 +440,22 @@ func (gm *GamesManager) CrashGame(ctx context.Context, gameID string) error {
 		}
 
 		totalLost += amount
+		lostBets = append(lostBets, betID)
+	}
+	rows.Close()
+
+	// Проигранные бонусные ставки: сыгранный бонус закрывается
+	if err := db.SettleLostBonusBetsTx(ctx, tx, lostBets); err != nil {
+		return fmt.Errorf("failed to settle bonus bets: %w", err)
 	}
 
+	// Проигранные ставки в кредитах не входят в прибыль в BKC
+	lostCredits, err := db.SettleLostCreditsBetsTx(ctx, tx, lostBets)
+	if err != nil {
+		return fmt.Errorf("failed to settle credits bets: %w", err)
+	}
+	totalLost -= lostCredits
+
 	// Рассчитываем прибыль системы (5% от проигравших ставок)
 	systemProfit := int64(math.Floor(float64(totalLost) * 0.05))
 
@@ -424,6 +481,15 @@ func (gm *GamesManager) CrashGame(ctx context.Context, gameID string) error {
 		}
 	}
 
+	// Выплачиваем страховки ставок, проигравших раньше своего множителя
+	insured, err := settleCrashInsurance(ctx, tx, gameID, game.CrashPoint, now)
+	if err != nil {
+		return fmt.Errorf("failed to settle insurance: %w", err)
+	}
+	if insured > 0 {
+		log.Printf("Crash game %s: paid %d BKC insurance", gameID, insured)
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit crash: %w", err)
 	}
@@ -451,6 +517,8 @@ func (gm *GamesManager) GetActiveCrashGame(ctx context.Context) (*CrashGame, err
 		return nil, fmt.Errorf("no active crash game: %w", err)
 	}
 
+	// До взрыва сид и точка скрыты от игроков
+	game.Salt, game.CrashPoint = "", 0
 	return &game, nil
 }
 
@@ -640,33 +708,34 @@ func (gm *GamesManager) GetGamesStats(ctx context.Context) (map[string]interface
 	return stats, nil
 }
 
-// GetProvablyFairData получает данные для проверки честности игры
+// GetProvablyFairData получает данные для проверки честности игры. Сид
+// сервера и точка взрыва раскрываются только после взрыва.
 func (gm *GamesManager) GetProvablyFairData(ctx context.Context, gameID string) (*ProvablyFair, error) {
 	var pf ProvablyFair
+	var status string
 
 	err := gm.db.Pool.QueryRow(ctx, `
-		SELECT game_id, hash, salt
+		SELECT game_id, hash, salt, crash_point, status
 		FROM crash_games 
 		WHERE game_id = $1
-	`, gameID).Scan(&pf.GameID, &pf.Hash, &pf.Salt)
+	`, gameID).Scan(&pf.GameID, &pf.Hash, &pf.ServerSeed, &pf.CrashPoint, &status)
 
 	if err != nil {
 		return nil, fmt.Errorf("game not found: %w", err)
 	}
 
-	pf.ServerSeed = pf.Salt
-	pf.ClientSeed = gameID
-	pf.Nonce = 1
-	pf.Hash = pf.Hash
+	pf.ClientSeeds, err = crashSeeds(ctx, gm.db.Pool, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load seeds: %w", err)
+	}
+	if pf.ClientSeeds == nil {
+		pf.ClientSeeds = []CrashSeed{}
+	}
+	pf.SeedsDigest = crashSeedsDigest(gameID, pf.ClientSeeds)
+	pf.Revealed = status == "crashed"
+	if !pf.Revealed {
+		pf.ServerSeed, pf.CrashPoint = "", 0
+	}
 
 	return &pf, nil
 }
-
-// VerifyProvablyFair проверяет честность игры
-func VerifyProvablyFair(serverSeed, clientSeed string, nonce int, expectedHash string) bool {
-	combined := fmt.Sprintf("%s-%s-%d", serverSeed, clientSeed, nonce)
-	hash := sha256.Sum256([]byte(combined))
-	hashStr := hex.EncodeToString(hash[:])
-
-	return hashStr == expectedHash
-}
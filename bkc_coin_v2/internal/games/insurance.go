@@ -0,0 +1,298 @@
+package games
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Страховка ставки в Ракетке: игрок платит премию (она уходит в резерв) и,
+// если раунд взрывается раньше выбранного множителя, а ставка проиграла,
+// получает refund_bp от ставки из резерва. Премия считается по истории
+// точек взрыва: P(взрыв < множитель) * выплата * (1 + маржа).
+const (
+	InsuranceMinMultiplier = 1.10
+	InsuranceMaxMultiplier = 5.00
+	InsuranceMinRefundBP   = 1_000 // 10% ставки
+	InsuranceMaxRefundBP   = 8_000 // 80% ставки
+	insuranceMarginBP      = 1_000 // маржа резерва сверх ожидаемой выплаты
+	insuranceHistory       = 1_000 // раундов истории для оценки вероятности
+	insuranceMinSamples    = 200   // меньше — берем распределение crashPointFromSeeds
+)
+
+// CrashInsuranceQuote цена страховки ставки
+type CrashInsuranceQuote struct {
+	Amount     int64   `json:"amount"`     // ставка
+	Multiplier float64 `json:"multiplier"` // выплата, если взрыв раньше
+	RefundBP   int64   `json:"refund_bp"`
+	Coverage   int64   `json:"coverage"`   // сколько вернется
+	CrashProb  float64 `json:"crash_prob"` // P(взрыв < Multiplier)
+	Samples    int     `json:"samples"`    // раундов в оценке; 0 = модель
+	Premium    int64   `json:"premium"`
+}
+
+// CrashInsurance страховка ставки
+type CrashInsurance struct {
+	BetID      string     `json:"bet_id"`
+	GameID     string     `json:"game_id"`
+	UserID     int64      `json:"user_id"`
+	Multiplier float64    `json:"multiplier"`
+	RefundBP   int64      `json:"refund_bp"`
+	Premium    int64      `json:"premium"`
+	Payout     int64      `json:"payout"`
+	Status     string     `json:"status"` // active, paid, expired
+	CreatedAt  time.Time  `json:"created_at"`
+	SettledAt  *time.Time `json:"settled_at,omitempty"`
+}
+
+// modelCrashProbBelow вероятность взрыва раньше m по распределению
+// crashPointFromSeeds: 3% на 1.00x, остальное равномерно на 1.01..10.00.
+func modelCrashProbBelow(m float64) float64 {
+	if m <= 1.00 {
+		return 0
+	}
+	return 0.03 + 0.97*math.Min(math.Max((m-1.01)/8.99, 0), 1)
+}
+
+// crashProbBelow оценивает P(взрыв < m) по истории точек взрыва (со
+// сглаживанием Лапласа); при короткой истории — по модели.
+func crashProbBelow(points []float64, m float64) float64 {
+	if len(points) < insuranceMinSamples {
+		return modelCrashProbBelow(m)
+	}
+	var below int
+	for _, p := range points {
+		if p < m {
+			below++
+		}
+	}
+	return float64(below+1) / float64(len(points)+2)
+}
+
+// priceCrashInsurance считает премию; ошибки — неверные параметры.
+func priceCrashInsurance(points []float64, amount int64, multiplier float64, refundBP int64) (CrashInsuranceQuote, error) {
+	if amount <= 0 {
+		return CrashInsuranceQuote{}, errors.New("bad amount")
+	}
+	if multiplier < InsuranceMinMultiplier || multiplier > InsuranceMaxMultiplier {
+		return CrashInsuranceQuote{}, fmt.Errorf("bad multiplier: must be between %.2f and %.2f", InsuranceMinMultiplier, InsuranceMaxMultiplier)
+	}
+	if refundBP < InsuranceMinRefundBP || refundBP > InsuranceMaxRefundBP {
+		return CrashInsuranceQuote{}, fmt.Errorf("bad refund_bp: must be between %d and %d", InsuranceMinRefundBP, InsuranceMaxRefundBP)
+	}
+	q := CrashInsuranceQuote{
+		Amount:     amount,
+		Multiplier: math.Round(multiplier*100) / 100,
+		RefundBP:   refundBP,
+		Coverage:   amount * refundBP / 10_000,
+	}
+	if len(points) >= insuranceMinSamples {
+		q.Samples = len(points)
+	}
+	q.CrashProb = crashProbBelow(points, q.Multiplier)
+	q.Premium = int64(math.Ceil(float64(q.Coverage) * q.CrashProb * float64(10_000+insuranceMarginBP) / 10_000))
+	if q.Premium < 1 {
+		q.Premium = 1
+	}
+	return q, nil
+}
+
+// recentCrashPoints точки взрыва последних раундов
+func recentCrashPoints(ctx context.Context, q rowsQuerier) ([]float64, error) {
+	rows, err := q.Query(ctx, `
+		SELECT crash_point FROM crash_games
+		WHERE status = 'crashed'
+		ORDER BY crashed_at DESC
+		LIMIT $1
+	`, insuranceHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []float64
+	for rows.Next() {
+		var p float64
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// QuoteCrashInsurance цена страховки ставки amount
+func (gm *GamesManager) QuoteCrashInsurance(ctx context.Context, amount int64, multiplier float64, refundBP int64) (CrashInsuranceQuote, error) {
+	points, err := recentCrashPoints(ctx, gm.db.Pool)
+	if err != nil {
+		return CrashInsuranceQuote{}, fmt.Errorf("failed to load crash history: %w", err)
+	}
+	return priceCrashInsurance(points, amount, multiplier, refundBP)
+}
+
+// InsureCrashBet страхует ставку до старта раунда: списывает премию в резерв.
+// Множитель страховки не выше автокэшаута — выше ставка уже выиграла бы.
+func (gm *GamesManager) InsureCrashBet(ctx context.Context, userID int64, betID string, multiplier float64, refundBP int64) (*CrashInsurance, error) {
+	tx, err := gm.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var gameID, betStatus, gameStatus string
+	var amount int64
+	var autoCashout float64
+	err = tx.QueryRow(ctx, `
+		SELECT b.game_id, b.amount, b.auto_cashout, b.status, g.status
+		FROM crash_bets b JOIN crash_games g ON g.game_id = b.game_id
+		WHERE b.bet_id = $1 AND b.user_id = $2
+		FOR UPDATE OF b, g
+	`, betID, userID).Scan(&gameID, &amount, &autoCashout, &betStatus, &gameStatus)
+	if err != nil {
+		return nil, fmt.Errorf("bet not found: %w", err)
+	}
+	// Пока раунд идет, игрок видит множитель — страховать уже поздно
+	if gameStatus != "waiting" || betStatus != "active" {
+		return nil, fmt.Errorf("insurance is only sold before the round starts")
+	}
+	// Премия и выплата в BKC — ставку в кредитах не страхуем
+	credits, err := db.CreditsBetTx(ctx, tx, betID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bet: %w", err)
+	}
+	if credits {
+		return nil, fmt.Errorf("bad bet: credits bets cannot be insured")
+	}
+	if autoCashout > 0 && multiplier > autoCashout {
+		return nil, fmt.Errorf("bad multiplier: above auto cashout %.2f", autoCashout)
+	}
+
+	points, err := recentCrashPoints(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crash history: %w", err)
+	}
+	q, err := priceCrashInsurance(points, amount, multiplier, refundBP)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx, "UPDATE users SET balance = balance - $1 WHERE user_id = $2 AND balance >= $1", q.Premium, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deduct premium: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("insufficient balance for premium %d", q.Premium)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE system_state SET reserve_supply = reserve_supply + $1, updated_at = now() WHERE id = 1", q.Premium); err != nil {
+		return nil, fmt.Errorf("failed to credit reserve: %w", err)
+	}
+
+	now := time.Now()
+	ins := &CrashInsurance{
+		BetID:      betID,
+		GameID:     gameID,
+		UserID:     userID,
+		Multiplier: q.Multiplier,
+		RefundBP:   q.RefundBP,
+		Premium:    q.Premium,
+		Status:     "active",
+		CreatedAt:  now,
+	}
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO crash_bet_insurance(bet_id, game_id, user_id, multiplier, refund_bp, premium, status, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, 'active', $7)
+		ON CONFLICT (bet_id) DO NOTHING
+	`, betID, gameID, userID, ins.Multiplier, ins.RefundBP, ins.Premium, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create insurance: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("bet is already insured")
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+		VALUES('crash_insurance_premium', $1, NULL, $2, jsonb_build_object(
+			'game_id', $3::text, 'bet_id', $4::text, 'multiplier', $5::float8,
+			'refund_bp', $6::bigint, 'crash_prob', $7::float8, 'samples', $8::int))
+	`, userID, q.Premium, gameID, betID, q.Multiplier, q.RefundBP, q.CrashProb, q.Samples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record premium: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit insurance: %w", err)
+	}
+
+	log.Printf("User %d insured bet %s below %.2fx for %d BKC (p=%.3f)",
+		userID, betID, q.Multiplier, q.Premium, q.CrashProb)
+
+	return ins, nil
+}
+
+// settleCrashInsurance выплачивает страховки проигравших ставок раунда,
+// взорвавшегося раньше их множителя, остальные закрывает. Вызывается из
+// CrashGame в той же транзакции, после пометки проигравших ставок.
+func settleCrashInsurance(ctx context.Context, tx pgx.Tx, gameID string, crashPoint float64, now time.Time) (int64, error) {
+	var paid int64
+	err := tx.QueryRow(ctx, `
+		WITH due AS (
+			UPDATE crash_bet_insurance i
+			SET status = 'paid', payout = b.amount * i.refund_bp / 10000, settled_at = $3
+			FROM crash_bets b
+			WHERE i.game_id = $1 AND i.status = 'active' AND b.bet_id = i.bet_id
+				AND b.status = 'lost' AND $2 < i.multiplier
+			RETURNING i.bet_id, i.user_id, i.payout, i.multiplier
+		), credit AS (
+			UPDATE users u SET balance = u.balance + s.total
+			FROM (SELECT user_id, SUM(payout) AS total FROM due GROUP BY user_id) s
+			WHERE u.user_id = s.user_id
+		), reserve AS (
+			UPDATE system_state SET reserve_supply = reserve_supply - (SELECT COALESCE(SUM(payout), 0) FROM due), updated_at = now()
+			WHERE id = 1
+		), ledger_rows AS (
+			INSERT INTO ledger(kind, from_id, to_id, amount, meta)
+			SELECT 'crash_insurance_payout', NULL, user_id, payout, jsonb_build_object(
+				'game_id', $1::text, 'bet_id', bet_id, 'multiplier', multiplier, 'crash_point', $2::float8)
+			FROM due
+		)
+		SELECT COALESCE(SUM(payout), 0) FROM due
+	`, gameID, crashPoint, now).Scan(&paid)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE crash_bet_insurance SET status = 'expired', settled_at = $2
+		WHERE game_id = $1 AND status = 'active'
+	`, gameID, now)
+	return paid, err
+}
+
+// GetCrashInsurance страховки ставок пользователя в раунде
+func (gm *GamesManager) GetCrashInsurance(ctx context.Context, userID int64, gameID string) ([]CrashInsurance, error) {
+	rows, err := gm.db.Pool.Query(ctx, `
+		SELECT bet_id, game_id, user_id, multiplier, refund_bp, premium, payout, status, created_at, settled_at
+		FROM crash_bet_insurance
+		WHERE user_id = $1 AND game_id = $2
+		ORDER BY created_at
+	`, userID, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get insurance: %w", err)
+	}
+	defer rows.Close()
+	var out []CrashInsurance
+	for rows.Next() {
+		var i CrashInsurance
+		if err := rows.Scan(&i.BetID, &i.GameID, &i.UserID, &i.Multiplier, &i.RefundBP, &i.Premium, &i.Payout, &i.Status, &i.CreatedAt, &i.SettledAt); err != nil {
+			return nil, err
+		}
+		out = append(out, i)
+	}
+	return out, rows.Err()
+}
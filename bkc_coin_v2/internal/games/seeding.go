@@ -0,0 +1,194 @@
+package games
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Честность Ракетки по схеме commit-reveal:
+//  1. StartCrashGame публикует hash = sha256(server_seed), сам сид скрыт;
+//  2. пока раунд ждет ставок, игроки присылают свои client seed (SubmitCrashSeed);
+//  3. LaunchCrashGame закрывает прием и считает точку взрыва из server_seed
+//     и всех присланных сидов (crashPointFromSeeds);
+//  4. после взрыва GetProvablyFairData раскрывает server_seed и сиды, и любой
+//     может пересчитать точку (VerifyCrashRound).
+// Сервер не знает сидов игроков при коммите, игроки не знают server_seed.
+
+// rowsQuerier пул или транзакция
+type rowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+var clientSeedRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// CrashSeed сид игрока в раунде
+type CrashSeed struct {
+	UserID    int64     `json:"user_id"`
+	Seed      string    `json:"seed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newServerSeed случайный сид раунда и его коммит
+func newServerSeed() (seed, commit string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	seed = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(seed))
+	return seed, hex.EncodeToString(sum[:]), nil
+}
+
+// crashSeedsDigest sha256 от "game_id\n" и строк "user_id:seed\n" по
+// возрастанию user_id — то, что подмешивается к server_seed.
+func crashSeedsDigest(gameID string, seeds []CrashSeed) string {
+	var b strings.Builder
+	b.WriteString(gameID + "\n")
+	for _, s := range seeds {
+		b.WriteString(strconv.FormatInt(s.UserID, 10) + ":" + s.Seed + "\n")
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// crashPointFromSeeds точка взрыва из HMAC-SHA256(server_seed, digest):
+// 3% раундов взрываются на 1.00x, остальные равномерно на 1.01..10.00.
+func crashPointFromSeeds(serverSeed, digest string) float64 {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	mac.Write([]byte(digest))
+	u := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11) / (1 << 53) // [0, 1)
+	if u < 0.03 {
+		return 1.00
+	}
+	return math.Round((1.01+(u-0.03)/0.97*8.99)*100) / 100
+}
+
+// VerifyCrashRound проверяет раунд: server_seed соответствует коммиту, а
+// точка взрыва получается из него и сидов игроков (seeds по user_id).
+func VerifyCrashRound(gameID, serverSeed, commit string, seeds []CrashSeed, crashPoint float64) bool {
+	sum := sha256.Sum256([]byte(serverSeed))
+	if hex.EncodeToString(sum[:]) != commit {
+		return false
+	}
+	return crashPointFromSeeds(serverSeed, crashSeedsDigest(gameID, seeds)) == crashPoint
+}
+
+// SubmitCrashSeed принимает сид игрока, пока раунд ждет ставок; повторная
+// отправка заменяет сид.
+func (gm *GamesManager) SubmitCrashSeed(ctx context.Context, userID int64, gameID, seed string) (*CrashSeed, error) {
+	if !clientSeedRe.MatchString(seed) {
+		return nil, fmt.Errorf("bad seed: 1-64 characters of A-Z, a-z, 0-9, _ and -")
+	}
+
+	tx, err := gm.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// FOR SHARE: LaunchCrashGame берет FOR UPDATE и ждет отправленные сиды
+	var status string
+	err = tx.QueryRow(ctx, "SELECT status FROM crash_games WHERE game_id = $1 FOR SHARE", gameID).Scan(&status)
+	if err != nil {
+		return nil, fmt.Errorf("game not found: %w", err)
+	}
+	if status != "waiting" {
+		return nil, fmt.Errorf("seeds are only accepted before the round starts")
+	}
+
+	s := &CrashSeed{UserID: userID, Seed: seed}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO crash_seed_contributions(game_id, user_id, client_seed)
+		VALUES($1, $2, $3)
+		ON CONFLICT (game_id, user_id) DO UPDATE SET client_seed = EXCLUDED.client_seed, created_at = now()
+		RETURNING created_at
+	`, gameID, userID, seed).Scan(&s.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save seed: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit seed: %w", err)
+	}
+	return s, nil
+}
+
+// crashSeeds сиды раунда по возрастанию user_id
+func crashSeeds(ctx context.Context, q rowsQuerier, gameID string) ([]CrashSeed, error) {
+	rows, err := q.Query(ctx, `
+		SELECT user_id, client_seed, created_at
+		FROM crash_seed_contributions
+		WHERE game_id = $1
+		ORDER BY user_id
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []CrashSeed
+	for rows.Next() {
+		var s CrashSeed
+		if err := rows.Scan(&s.UserID, &s.Seed, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// LaunchCrashGame закрывает прием сидов, считает точку
+// взрыва из server_seed и сидов игроков и запускает раунд.
+func (gm *GamesManager) LaunchCrashGame(ctx context.Context, gameID string) (*CrashGame, error) {
+	tx, err := gm.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var game CrashGame
+	err = tx.QueryRow(ctx, `
+		SELECT game_id, hash, salt, status, started_at, total_bets
+		FROM crash_games
+		WHERE game_id = $1 AND status = 'waiting' FOR UPDATE
+	`, gameID).Scan(&game.GameID, &game.Hash, &game.Salt, &game.Status, &game.StartedAt, &game.TotalBets)
+	if err != nil {
+		return nil, fmt.Errorf("game not found or already started: %w", err)
+	}
+
+	seeds, err := crashSeeds(ctx, tx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load seeds: %w", err)
+	}
+	game.CrashPoint = crashPointFromSeeds(game.Salt, crashSeedsDigest(gameID, seeds))
+	game.Status = "active"
+
+	// started_at — старт полета: от него считается задержка вывода
+	game.StartedAt = time.Now()
+	_, err = tx.Exec(ctx, "UPDATE crash_games SET crash_point = $1, status = 'active', started_at = $2 WHERE game_id = $3", game.CrashPoint, game.StartedAt, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start game: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit start: %w", err)
+	}
+
+	log.Printf("Crash game %s launched with %d client seeds", gameID, len(seeds))
+
+	// До взрыва сид и точка скрыты от игроков
+	game.Salt, game.CrashPoint = "", 0
+	return &game, nil
+}
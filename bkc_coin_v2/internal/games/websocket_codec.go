@@ -0,0 +1,142 @@
+package games
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Бинарный протокол кадров игр. Клиент просит его подпротоколом
+// Sec-WebSocket-Protocol: bkc.bin.v1; без него (старые клиенты) все идет
+// текстовым JSON. В бинарном режиме бинарными кадрами идут только
+// crash_update и chart_update, остальное — тем же JSON текстовыми кадрами.
+//
+// Кадр начинается с байта типа; числа — varint (encoding/binary), строки —
+// uvarint длины и байты, float — 8 байт IEEE 754 big-endian, время — мс Unix.
+//
+//	1 crash key:  ts, game_id, status, mult, crash_point, players, total_bets, hash
+//	2 crash tick: dts, dmult (varint), players, total_bets
+//	3 chart:      ts, symbol, price, change24h, n, n × (dts varint, price, volume)
+//
+// mult и crash_point в сотых (1.23x = 123). Tick — разница с предыдущим
+// crash-кадром, отправленным этому клиенту; ключевой кадр идет при смене
+// раунда или статуса. Кодирование идет в writePump, поэтому выброшенные из
+// очереди тики (enqueue) дельты не ломают. Статус: 0 waiting, 1 starting,
+// 2 active, 3 crashed, 4 finished, 255 неизвестный. В chart первая точка
+// хранит dts от 0, остальные — от предыдущей (секунды, как в ChartPoint).
+
+const (
+	SubprotocolJSON   = "bkc.json.v1"
+	SubprotocolBinary = "bkc.bin.v1"
+)
+
+const (
+	frameCrashKey  byte = 1
+	frameCrashTick byte = 2
+	frameChart     byte = 3
+)
+
+var gameStatusCodes = []GameStatus{
+	GameStatusWaiting,
+	GameStatusStarting,
+	GameStatusActive,
+	GameStatusCrashed,
+	GameStatusFinished,
+}
+
+func gameStatusCode(s GameStatus) byte {
+	for i, v := range gameStatusCodes {
+		if v == s {
+			return byte(i)
+		}
+	}
+	return 255
+}
+
+// hasBinaryForm кадры, которые бинарный клиент получает бинарными
+func hasBinaryForm(m WebSocketMessage) bool {
+	switch m.Data.(type) {
+	case CrashGameData:
+		return m.Type == "crash_update"
+	case ChartData:
+		return m.Type == "chart_update"
+	}
+	return false
+}
+
+// binaryEncoder состояние дельт одного клиента; только из writePump
+type binaryEncoder struct {
+	game   string
+	status GameStatus
+	mult   int64
+	ts     int64
+}
+
+// encode кодирует кадр с бинарной формой (hasBinaryForm)
+func (e *binaryEncoder) encode(m WebSocketMessage) []byte {
+	switch d := m.Data.(type) {
+	case CrashGameData:
+		return e.crash(m.Timestamp, d)
+	case ChartData:
+		return encodeChart(m.Timestamp, d)
+	}
+	return nil
+}
+
+func (e *binaryEncoder) crash(at time.Time, d CrashGameData) []byte {
+	ts := at.UnixMilli()
+	mult := hundredths(d.Multiplier)
+	var b []byte
+	if e.game == d.GameID && e.status == d.Status && ts >= e.ts {
+		b = append(b, frameCrashTick)
+		b = binary.AppendUvarint(b, uint64(ts-e.ts))
+		b = binary.AppendVarint(b, mult-e.mult)
+	} else {
+		b = append(b, frameCrashKey)
+		b = binary.AppendUvarint(b, uint64(ts))
+		b = appendString(b, d.GameID)
+		b = append(b, gameStatusCode(d.Status))
+		b = binary.AppendUvarint(b, uint64(mult))
+		b = binary.AppendUvarint(b, uint64(hundredths(d.CrashPoint)))
+	}
+	b = binary.AppendUvarint(b, uint64(d.PlayerCount))
+	b = binary.AppendUvarint(b, uint64(max(d.TotalBets, 0)))
+	if b[0] == frameCrashKey {
+		b = appendString(b, d.Hash)
+	}
+	e.game, e.status, e.mult, e.ts = d.GameID, d.Status, mult, ts
+	return b
+}
+
+func encodeChart(at time.Time, d ChartData) []byte {
+	b := []byte{frameChart}
+	b = binary.AppendUvarint(b, uint64(at.UnixMilli()))
+	b = appendString(b, d.Symbol)
+	b = appendFloat(b, d.Price)
+	b = appendFloat(b, d.Change24h)
+	b = binary.AppendUvarint(b, uint64(len(d.Points)))
+	var prev int64
+	for _, p := range d.Points {
+		b = binary.AppendVarint(b, p.Timestamp-prev)
+		b = appendFloat(b, p.Price)
+		b = appendFloat(b, p.Volume)
+		prev = p.Timestamp
+	}
+	return b
+}
+
+func hundredths(v float64) int64 {
+	if v <= 0 {
+		return 0
+	}
+	return int64(math.Round(v * 100))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+}
@@ -12,7 +12,11 @@ import (
 	"sync/atomic"
 	"time"
 
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/wsauth"
+
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
 )
 
 // GameType тип игры
@@ -29,10 +33,17 @@ type WebSocketEngine struct {
 	clients map[*Client]bool
 	mu      sync.RWMutex
 	
+	// Подключения всего и по пользователям (reserveConn), под mu
+	conns     int
+	userConns map[int64]int
+	
 	// Игры
 	games map[string]*Game
 	gameMu sync.RWMutex
 	
+	// Текущий раунд Ракетки (runCrashRound), под gameMu
+	currentCrash *Game
+	
 	// Конфигурация
 	config WebSocketConfig
 	
@@ -48,8 +59,29 @@ type WebSocketEngine struct {
 	
 	// Provably Fair
 	fairGenerator *ProvablyFairGenerator
+	
+	// Аутентификация апгрейда (wsauth) и доступ по тарифу
+	tokenSecret []byte
+	privileges  PrivilegeChecker
+	
+	// Состояние игроков по раундам для переподключения (nil — только память)
+	rdb *redis.Client
+	
+	// Внутренний курс BKC (PushRate); пока точек нет, график на тестовых данных
+	rateMu     sync.RWMutex
+	ratePoints []ChartPoint
+	rateChange float64
 }
 
+// PrivilegeChecker проверяет привилегии тарифа пользователя
+// (subscription.SubscriptionManager)
+type PrivilegeChecker interface {
+	CheckPrivilege(ctx context.Context, userID int64, privilege string) bool
+}
+
+// PrivilegeRealTimeChart дает доступ к потоку графика в реальном времени
+const PrivilegeRealTimeChart = "real_time_chart"
+
 // Client WebSocket клиент
 type Client struct {
 	ID        int64               `json:"id"`
@@ -57,11 +89,25 @@ type Client struct {
 	UserID    int64               `json:"user_id"`
 	GameType  GameType            `json:"game_type"`
 	Conn      *websocket.Conn    `json:"-"`
-	Send      chan []byte         `json:"-"`
 	LastPing  time.Time           `json:"last_ping"`
 	IsPremium bool                `json:"is_premium"`
 	Lang      string              `json:"lang"`
 	mu        sync.RWMutex
+	
+	// Очередь исходящих кадров (enqueue/dequeue), writePump ждет wake
+	outMu   sync.Mutex
+	out     []outFrame
+	wake    chan struct{}
+	closed  bool
+	dropped int64
+	
+	// Бинарный протокол (SubprotocolBinary) и его дельты, только writePump
+	binary bool
+	enc    binaryEncoder
+	
+	// Окно лимита входящих сообщений (allowMessage)
+	msgWindow time.Time
+	msgCount  int
 }
 
 // Game игра
@@ -71,6 +117,7 @@ type Game struct {
 	Status       GameStatus      `json:"status"`
 	Players      map[int64]*Player `json:"players"`
 	StartedAt    time.Time       `json:"started_at"`
+	BetsCloseAt  time.Time       `json:"bets_close_at"`
 	EndedAt      time.Time       `json:"ended_at"`
 	CrashPoint   float64         `json:"crash_point"`
 	CurrentMult  float64         `json:"current_mult"`
@@ -114,6 +161,19 @@ type WebSocketConfig struct {
 	EnableCompression bool          `json:"enable_compression"`
 	CrashGameSettings CrashGameSettings `json:"crash_settings"`
 	ChartSettings    ChartSettings    `json:"chart_settings"`
+	
+	// Бинарный протокол кадров (websocket_codec.go)
+	EnableBinary bool `json:"enable_binary"`
+	
+	// Лимиты: 0 отключает
+	MaxConnections        int `json:"max_connections"`
+	MaxConnectionsPerUser int `json:"max_connections_per_user"`
+	MessagesPerSecond     int `json:"messages_per_second"`
+	SendQueueSize         int `json:"send_queue_size"`
+	
+	// Часы фаз раунда и окна ставок (nil — системные; в тестах clock.Manual).
+	// Таймеры и дедлайны сокетов идут по реальному времени.
+	Clock clock.Clock `json:"-"`
 }
 
 // CrashGameSettings настройки игры Ракетка
@@ -126,6 +186,12 @@ type CrashGameSettings struct {
 	MaxBet           int64         `json:"max_bet"`
 	HouseEdge        float64       `json:"house_edge"`
 	InstantCrashProb float64       `json:"instant_crash_prob"`
+	
+	// Фазы раунда (runCrashRound)
+	BettingWindow     time.Duration `json:"betting_window"`
+	CountdownInterval time.Duration `json:"countdown_interval"`
+	LockDuration      time.Duration `json:"lock_duration"`
+	Intermission      time.Duration `json:"intermission"`
 }
 
 // ChartSettings настройки графика
@@ -144,6 +210,10 @@ type GameMetrics struct {
 	TotalWins         int64     `json:"total_wins"`
 	HouseProfit       int64     `json:"house_profit"`
 	AvgGameDuration   time.Duration `json:"avg_game_duration"`
+	DroppedFrames     int64     `json:"dropped_frames"`
+	RejectedConns     int64     `json:"rejected_conns"`
+	RateLimited       int64     `json:"rate_limited"`
+	SlowClients       int64     `json:"slow_clients"`
 	LastUpdated       time.Time `json:"last_updated"`
 	mu                sync.RWMutex
 }
@@ -202,6 +272,11 @@ func DefaultWebSocketConfig() WebSocketConfig {
 		WriteWait:         10 * time.Second,
 		MaxMessageSize:    512,
 		EnableCompression: true,
+		EnableBinary:      true,
+		MaxConnections:        10000,
+		MaxConnectionsPerUser: 3,
+		MessagesPerSecond:     10,
+		SendQueueSize:         256,
 		CrashGameSettings: CrashGameSettings{
 			MinMultiplier:    1.00,
 			MaxMultiplier:    100.0,
@@ -211,6 +286,10 @@ func DefaultWebSocketConfig() WebSocketConfig {
 			MaxBet:           1000000,
 			HouseEdge:        0.03,
 			InstantCrashProb: 0.03,
+			BettingWindow:     10 * time.Second,
+			CountdownInterval: time.Second,
+			LockDuration:      time.Second,
+			Intermission:      5 * time.Second,
 		},
 		ChartSettings: ChartSettings{
 			UpdateInterval: 5 * time.Second,
@@ -221,18 +300,24 @@ func DefaultWebSocketConfig() WebSocketConfig {
 	}
 }
 
-// NewWebSocketEngine создает новый WebSocket движок
-func NewWebSocketEngine(config WebSocketConfig) *WebSocketEngine {
+// NewWebSocketEngine создает новый WebSocket движок; tokenSecret проверяет
+// токены подключения (wsauth), privileges — доступ к графику по тарифу,
+// rdb хранит состояние игроков в раунде для переподключения (может быть nil)
+func NewWebSocketEngine(config WebSocketConfig, tokenSecret []byte, privileges PrivilegeChecker, rdb *redis.Client) *WebSocketEngine {
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	wse := &WebSocketEngine{
 		clients:      make(map[*Client]bool),
+		userConns:    make(map[int64]int),
 		games:        make(map[string]*Game),
 		config:       config,
 		ctx:          ctx,
 		cancel:       cancel,
 		metrics:      &GameMetrics{},
 		fairGenerator: NewProvablyFairGenerator(),
+		tokenSecret:  tokenSecret,
+		privileges:   privileges,
+		rdb:          rdb,
 	}
 	
 	// Настройка upgrader
@@ -244,6 +329,10 @@ func NewWebSocketEngine(config WebSocketConfig) *WebSocketEngine {
 			return true
 		},
 		EnableCompression: config.EnableCompression,
+		Subprotocols:      []string{SubprotocolJSON},
+	}
+	if config.EnableBinary {
+		wse.upgrader.Subprotocols = []string{SubprotocolBinary, SubprotocolJSON}
 	}
 	
 	return wse
@@ -273,24 +362,52 @@ func (wse *WebSocketEngine) Stop() {
 	wse.mu.Unlock()
 }
 
-// HandleWebSocket обрабатывает WebSocket соединение
-func (wse *WebSocketEngine) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID int64, username string, gameType GameType, isPremium bool, lang string) {
+// HandleWebSocket обрабатывает WebSocket соединение. Пользователь берется
+// только из подписанного токена (wsauth), поток графика — только тарифам с
+// RealTimeChart; отказ отдается обычным HTTP-ответом до апгрейда.
+func (wse *WebSocketEngine) HandleWebSocket(w http.ResponseWriter, r *http.Request, gameType GameType) {
+	if gameType != GameTypeCrash && gameType != GameTypeChart {
+		http.Error(w, "unknown game type", http.StatusBadRequest)
+		return
+	}
+	claims, err := wsauth.FromRequest(wse.tokenSecret, r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	isPremium := wse.privileges != nil && wse.privileges.CheckPrivilege(r.Context(), claims.UserID, PrivilegeRealTimeChart)
+	if gameType == GameTypeChart && !isPremium {
+		http.Error(w, "real-time chart is not included in your plan", http.StatusForbidden)
+		return
+	}
+	if err := wse.reserveConn(claims.UserID); err != nil {
+		atomic.AddInt64(&wse.metrics.RejectedConns, 1)
+		status := http.StatusServiceUnavailable
+		if err == errTooManyUserConns {
+			status = http.StatusTooManyRequests
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	
 	conn, err := wse.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		wse.releaseConn(claims.UserID)
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		return
 	}
 	
 	client := &Client{
 		ID:        time.Now().UnixNano(),
-		UserID:    userID,
-		Username:  username,
+		UserID:    claims.UserID,
+		Username:  claims.Username,
 		GameType:  gameType,
 		Conn:      conn,
-		Send:      make(chan []byte, 256),
+		wake:      make(chan struct{}, 1),
+		binary:    conn.Subprotocol() == SubprotocolBinary,
 		LastPing:  time.Now(),
 		IsPremium: isPremium,
-		Lang:      lang,
+		Lang:      claims.Lang,
 	}
 	
 	// Добавление клиента
@@ -322,7 +439,8 @@ func (wse *WebSocketEngine) removeClient(client *Client) {
 	
 	if _, ok := wse.clients[client]; ok {
 		delete(wse.clients, client)
-		close(client.Send)
+		wse.releaseConnLocked(client.UserID)
+		client.closeQueue()
 		atomic.AddInt64(&wse.metrics.ActivePlayers, -1)
 	}
 }
@@ -332,6 +450,7 @@ func (wse *WebSocketEngine) sendInitialData(client *Client) {
 	switch client.GameType {
 	case GameTypeCrash:
 		wse.sendCrashGameInfo(client)
+		wse.sendCrashSnapshot(client)
 	case GameTypeChart:
 		wse.sendChartData(client)
 	}
@@ -350,6 +469,9 @@ func (wse *WebSocketEngine) sendCrashGameInfo(client *Client) {
 		TotalBets:   game.TotalBets,
 		Hash:        game.Hash,
 	}
+	if game.Status == GameStatusWaiting {
+		data.TimeLeft = bettingTimeLeft(game.BetsCloseAt, wse.now())
+	}
 	
 	message := WebSocketMessage{
 		Type:      "crash_update",
@@ -363,6 +485,15 @@ func (wse *WebSocketEngine) sendCrashGameInfo(client *Client) {
 
 // sendChartData отправляет данные графика
 func (wse *WebSocketEngine) sendChartData(client *Client) {
+	if data, ok := wse.rateChartData(); ok {
+		wse.sendToClient(client, WebSocketMessage{
+			Type:      "chart_update",
+			Data:      data,
+			Timestamp: time.Now(),
+		})
+		return
+	}
+	
 	// Генерация тестовых данных (в реальном приложении здесь будет реальный источник)
 	points := make([]ChartPoint, 50)
 	basePrice := 60000.0
@@ -393,19 +524,27 @@ func (wse *WebSocketEngine) sendChartData(client *Client) {
 }
 
 // getCurrentCrashGame получает текущую игру Ракетка
+// (в перерыве между раундами — только что взорвавшуюся)
 func (wse *WebSocketEngine) getCurrentCrashGame() *Game {
 	wse.gameMu.RLock()
-	defer wse.gameMu.RUnlock()
-	
-	// Поиск активной игры
-	for _, game := range wse.games {
-		if game.Type == GameTypeCrash && (game.Status == GameStatusWaiting || game.Status == GameStatusStarting || game.Status == GameStatusActive) {
-			return game
-		}
+	game := wse.currentCrash
+	wse.gameMu.RUnlock()
+	if game != nil {
+		return game
 	}
 	
-	// Создание новой игры
-	return wse.createNewCrashGame()
+	// Первый раунд; дальше раунды создает runCrashRound
+	wse.gameMu.Lock()
+	defer wse.gameMu.Unlock()
+	if wse.currentCrash == nil {
+		wse.newCrashGameLocked()
+	}
+	return wse.currentCrash
+}
+
+// now текущее время по часам из конфигурации
+func (wse *WebSocketEngine) now() time.Time {
+	return clock.Or(wse.config.Clock).Now()
 }
 
 // createNewCrashGame создает новую игру Ракетка
@@ -413,6 +552,11 @@ func (wse *WebSocketEngine) createNewCrashGame() *Game {
 	wse.gameMu.Lock()
 	defer wse.gameMu.Unlock()
 	
+	return wse.newCrashGameLocked()
+}
+
+// newCrashGameLocked создает раунд и запускает его фазы; под gameMu
+func (wse *WebSocketEngine) newCrashGameLocked() *Game {
 	gameID := fmt.Sprintf("crash_%d", time.Now().UnixNano())
 	
 	// Генерация честной игры
@@ -421,12 +565,14 @@ func (wse *WebSocketEngine) createNewCrashGame() *Game {
 	// Генерация точки взрыва
 	crashPoint := wse.generateCrashPoint(hash)
 	
+	now := wse.now()
 	game := &Game{
 		ID:          gameID,
 		Type:        GameTypeCrash,
 		Status:      GameStatusWaiting,
 		Players:     make(map[int64]*Player),
-		StartedAt:   time.Now(),
+		StartedAt:   now,
+		BetsCloseAt: now.Add(wse.config.CrashGameSettings.BettingWindow),
 		CrashPoint:  crashPoint,
 		CurrentMult: 1.00,
 		Hash:        hash,
@@ -434,12 +580,10 @@ func (wse *WebSocketEngine) createNewCrashGame() *Game {
 	}
 	
 	wse.games[gameID] = game
+	wse.currentCrash = game
 	
-	// Запуск игры через 3 секунды
-	go func() {
-		time.Sleep(3 * time.Second)
-		wse.startCrashGame(game)
-	}()
+	// Прием ставок, блокировка, полет, взрыв, перерыв
+	go wse.runCrashRound(game)
 	
 	return game
 }
@@ -471,10 +615,10 @@ func (wse *WebSocketEngine) generateCrashPoint(hash string) float64 {
 
 // startCrashGame запускает игру Ракетка
 func (wse *WebSocketEngine) startCrashGame(game *Game) {
-	wse.gameMu.Lock()
+	game.mu.Lock()
 	game.Status = GameStatusActive
-	game.StartedAt = time.Now()
-	wse.gameMu.Unlock()
+	game.StartedAt = wse.now()
+	game.mu.Unlock()
 	
 	atomic.AddInt64(&wse.metrics.TotalGames, 1)
 	
@@ -495,7 +639,7 @@ func (wse *WebSocketEngine) startCrashGame(game *Game) {
 			// Проверка взрыва
 			if game.CurrentMult >= game.CrashPoint {
 				game.Status = GameStatusCrashed
-				game.EndedAt = time.Now()
+				game.EndedAt = wse.now()
 				game.mu.Unlock()
 				
 				// Обработка взрыва
@@ -524,14 +668,8 @@ func (wse *WebSocketEngine) handleCrash(game *Game) {
 		}
 	}
 	
-	// Отправка финального обновления
+	// Отправка финального обновления; следующий раунд запустит runCrashRound
 	wse.broadcastCrashUpdate(game)
-	
-	// Создание новой игры через 5 секунд
-	go func() {
-		time.Sleep(5 * time.Second)
-		wse.createNewCrashGame()
-	}()
 }
 
 // broadcastCrashUpdate рассылает обновление игры Ракетка
@@ -545,6 +683,9 @@ func (wse *WebSocketEngine) broadcastCrashUpdate(game *Game) {
 		TotalBets:   game.TotalBets,
 		Hash:        game.Hash,
 	}
+	if game.Status == GameStatusWaiting {
+		data.TimeLeft = bettingTimeLeft(game.BetsCloseAt, wse.now())
+	}
 	
 	message := WebSocketMessage{
 		Type:      "crash_update",
@@ -562,7 +703,8 @@ func (wse *WebSocketEngine) broadcastToGameType(gameType GameType, message WebSo
 	defer wse.mu.RUnlock()
 	
 	for client := range wse.clients {
-		if client.GameType == gameType {
+		// График только тарифам с RealTimeChart (проверено при подключении)
+		if client.GameType == gameType && (gameType != GameTypeChart || client.IsPremium) {
 			wse.sendToClient(client, message)
 		}
 	}
@@ -570,17 +712,34 @@ func (wse *WebSocketEngine) broadcastToGameType(gameType GameType, message WebSo
 
 // sendToClient отправляет сообщение клиенту
 func (wse *WebSocketEngine) sendToClient(client *Client, message WebSocketMessage) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return
+	frame := outFrame{critical: isCriticalMessage(message)}
+	if client.binary && hasBinaryForm(message) {
+		// Кодируется в writePump: дельта считается от реально отправленного
+		frame.msg = &message
+	} else {
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Failed to marshal message: %v", err)
+			return
+		}
+		frame.data = data
 	}
 	
-	select {
-	case client.Send <- data:
-	default:
-		// Канал переполнен
-		wse.removeClient(client)
+	limit := wse.config.SendQueueSize
+	if limit <= 0 {
+		limit = 256
+	}
+	before := atomic.LoadInt64(&client.dropped)
+	if !client.enqueue(frame, limit) {
+		// Очередь забита критичными кадрами: клиент безнадежно отстал.
+		// Закрываем соединение, readPump удалит клиента (здесь может
+		// держаться wse.mu).
+		atomic.AddInt64(&wse.metrics.SlowClients, 1)
+		client.Conn.Close()
+		return
+	}
+	if n := atomic.LoadInt64(&client.dropped) - before; n > 0 {
+		atomic.AddInt64(&wse.metrics.DroppedFrames, n)
 	}
 }
 
@@ -654,6 +813,11 @@ func (wse *WebSocketEngine) chartUpdater() {
 
 // updateChart обновляет данные графика
 func (wse *WebSocketEngine) updateChart() {
+	// Реальный курс приходит через PushRate
+	if _, ok := wse.rateChartData(); ok {
+		return
+	}
+	
 	// Генерация новой точки графика
 	newPoint := ChartPoint{
 		Timestamp: time.Now().Unix(),
@@ -678,6 +842,57 @@ func (wse *WebSocketEngine) updateChart() {
 	wse.broadcastToGameType(GameTypeChart, message)
 }
 
+// PushRate добавляет точку внутреннего курса BKC и рассылает её клиентам графика.
+// price — USD за 1 BKC, change24h — изменение за сутки в процентах.
+func (wse *WebSocketEngine) PushRate(at time.Time, price float64, change24h float64) {
+	point := ChartPoint{
+		Timestamp: at.Unix(),
+		Price:     price,
+	}
+	
+	wse.rateMu.Lock()
+	wse.ratePoints = append(wse.ratePoints, point)
+	if limit := wse.config.ChartSettings.MaxDataPoints; limit > 0 && len(wse.ratePoints) > limit {
+		wse.ratePoints = append([]ChartPoint(nil), wse.ratePoints[len(wse.ratePoints)-limit:]...)
+	}
+	wse.rateChange = change24h
+	wse.rateMu.Unlock()
+	
+	data := ChartData{
+		Symbol:     "BKC/USD",
+		Points:     []ChartPoint{point},
+		LastUpdate: time.Now(),
+		Price:      price,
+		Change24h:  change24h,
+	}
+	
+	message := WebSocketMessage{
+		Type:      "chart_update",
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+	
+	wse.broadcastToGameType(GameTypeChart, message)
+}
+
+// rateChartData возвращает накопленную историю курса (ok=false, если PushRate не вызывался)
+func (wse *WebSocketEngine) rateChartData() (ChartData, bool) {
+	wse.rateMu.RLock()
+	defer wse.rateMu.RUnlock()
+	
+	if len(wse.ratePoints) == 0 {
+		return ChartData{}, false
+	}
+	last := wse.ratePoints[len(wse.ratePoints)-1]
+	return ChartData{
+		Symbol:     "BKC/USD",
+		Points:     append([]ChartPoint(nil), wse.ratePoints...),
+		LastUpdate: time.Unix(last.Timestamp, 0),
+		Price:      last.Price,
+		Change24h:  wse.rateChange,
+	}, true
+}
+
 // updateMetrics обновляет метрики
 func (wse *WebSocketEngine) updateMetrics() {
 	wse.metrics.mu.Lock()
@@ -742,6 +957,20 @@ func (c *Client) readPump(wse *WebSocketEngine) {
 			break
 		}
 		
+		// Лимит частоты: лишние сообщения выбрасываются, о первом в окне
+		// клиент узнает
+		if !c.allowMessage(wse.config.MessagesPerSecond, time.Now()) {
+			atomic.AddInt64(&wse.metrics.RateLimited, 1)
+			if c.msgCount == wse.config.MessagesPerSecond+1 {
+				wse.sendToClient(c, WebSocketMessage{
+					Type:      "rate_limited",
+					Data:      map[string]int{"messages_per_second": wse.config.MessagesPerSecond},
+					Timestamp: time.Now(),
+				})
+			}
+			continue
+		}
+		
 		// Обработка сообщения от клиента
 		wse.handleClientMessage(c, message)
 	}
@@ -756,14 +985,21 @@ func (c *Client) writePump(wse *WebSocketEngine) {
 	
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(wse.config.WriteWait))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.wake:
+			frames, closed := c.dequeue()
+			for _, f := range frames {
+				kind, data := websocket.TextMessage, f.data
+				if f.msg != nil {
+					kind, data = websocket.BinaryMessage, c.enc.encode(*f.msg)
+				}
+				c.Conn.SetWriteDeadline(time.Now().Add(wse.config.WriteWait))
+				if err := c.Conn.WriteMessage(kind, data); err != nil {
+					return
+				}
 			}
-			
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if closed {
+				c.Conn.SetWriteDeadline(time.Now().Add(wse.config.WriteWait))
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 			
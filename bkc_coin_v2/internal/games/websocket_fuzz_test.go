@@ -0,0 +1,41 @@
+package games
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+)
+
+// Run with: go test ./internal/games -fuzz FuzzHandleClientMessage
+func FuzzHandleClientMessage(f *testing.F) {
+	for _, s := range []string{
+		`{"type":"ping"}`,
+		`{"type":"place_bet","data":{"amount":100,"auto_cash_out":2.5}}`,
+		`{"type":"cash_out","data":null,"game_id":"x"}`,
+		`{"type":"ping","timestamp":"not a time"}`,
+		`{"type":7}`,
+		`[]`,
+		`nul`,
+	} {
+		f.Add([]byte(s))
+	}
+	log.SetOutput(io.Discard)
+	wse := NewWebSocketEngine(DefaultWebSocketConfig(), []byte("k"), nil, nil)
+	f.Fuzz(func(t *testing.T, message []byte) {
+		client := &Client{UserID: 1, wake: make(chan struct{}, 1)}
+		wse.handleClientMessage(client, message)
+
+		// Одно входящее сообщение дает не больше одного ответа, и это pong
+		frames, _ := client.dequeue()
+		if len(frames) > 1 {
+			t.Fatalf("%q: %d frames", message, len(frames))
+		}
+		for _, fr := range frames {
+			var reply WebSocketMessage
+			if err := json.Unmarshal(fr.data, &reply); err != nil || reply.Type != "pong" {
+				t.Fatalf("%q: reply %s (%v)", message, fr.data, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package games
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Лимиты WebSocket движка: подключения на пользователя и всего, частота
+// входящих сообщений и очередь исходящих кадров клиента. Медленному клиенту
+// не рвем соединение, а выбрасываем самые старые некритичные кадры (тики
+// множителя и графика устаревают сами), пока очередь не забита критичными.
+
+var (
+	errTooManyConns     = errors.New("too many connections")
+	errTooManyUserConns = errors.New("too many connections for this user")
+)
+
+// outFrame кадр в очереди клиента: JSON в data или сообщение для
+// бинарного кодирования в msg
+type outFrame struct {
+	data     []byte
+	msg      *WebSocketMessage
+	critical bool
+}
+
+// ClientQueueStat глубина очереди клиента
+type ClientQueueStat struct {
+	ClientID int64    `json:"client_id"`
+	UserID   int64    `json:"user_id"`
+	GameType GameType `json:"game_type"`
+	Depth    int      `json:"depth"`
+	Dropped  int64    `json:"dropped"`
+}
+
+// isCriticalMessage кадры, которые нельзя выбросить: исход раунда, ответы
+// на действия игрока, ошибки. Тики множителя, график и pong некритичны.
+func isCriticalMessage(m WebSocketMessage) bool {
+	switch m.Type {
+	case "chart_update", "pong":
+		return false
+	case "crash_update":
+		d, ok := m.Data.(CrashGameData)
+		return ok && (d.Status == GameStatusCrashed || d.Status == GameStatusFinished)
+	}
+	return true
+}
+
+// reserveConn занимает место под подключение userID до апгрейда;
+// освобождает releaseConn (removeClient или неудачный апгрейд).
+func (wse *WebSocketEngine) reserveConn(userID int64) error {
+	wse.mu.Lock()
+	defer wse.mu.Unlock()
+	if max := wse.config.MaxConnections; max > 0 && wse.conns >= max {
+		return errTooManyConns
+	}
+	if max := wse.config.MaxConnectionsPerUser; max > 0 && wse.userConns[userID] >= max {
+		return errTooManyUserConns
+	}
+	wse.conns++
+	wse.userConns[userID]++
+	return nil
+}
+
+func (wse *WebSocketEngine) releaseConn(userID int64) {
+	wse.mu.Lock()
+	defer wse.mu.Unlock()
+	wse.releaseConnLocked(userID)
+}
+
+func (wse *WebSocketEngine) releaseConnLocked(userID int64) {
+	wse.conns--
+	if wse.userConns[userID]--; wse.userConns[userID] <= 0 {
+		delete(wse.userConns, userID)
+	}
+}
+
+// enqueue ставит кадр в очередь клиента. При полной очереди выбрасывает
+// самый старый некритичный кадр (или сам новый, если он некритичный, а
+// очередь забита критичными); false — клиент не успевает и критичным.
+func (c *Client) enqueue(f outFrame, limit int) bool {
+	c.outMu.Lock()
+	if c.closed {
+		c.outMu.Unlock()
+		return true
+	}
+	if len(c.out) >= limit {
+		i := 0
+		for i < len(c.out) && c.out[i].critical {
+			i++
+		}
+		switch {
+		case i < len(c.out):
+			c.out = append(c.out[:i], c.out[i+1:]...)
+		case !f.critical:
+			c.outMu.Unlock()
+			atomic.AddInt64(&c.dropped, 1)
+			return true
+		default:
+			c.outMu.Unlock()
+			return false
+		}
+		atomic.AddInt64(&c.dropped, 1)
+	}
+	c.out = append(c.out, f)
+	c.outMu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dequeue забирает все кадры очереди; closed — клиент удален.
+func (c *Client) dequeue() (frames []outFrame, closed bool) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	frames, c.out = c.out, nil
+	return frames, c.closed
+}
+
+// closeQueue помечает клиента удаленным и будит writePump.
+func (c *Client) closeQueue() {
+	c.outMu.Lock()
+	c.closed = true
+	c.outMu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// allowMessage лимит входящих сообщений: не больше MessagesPerSecond за
+// секундное окно. Вызывается только из readPump клиента.
+func (c *Client) allowMessage(perSecond int, now time.Time) bool {
+	if perSecond <= 0 {
+		return true
+	}
+	if now.Sub(c.msgWindow) >= time.Second {
+		c.msgWindow, c.msgCount = now, 0
+	}
+	c.msgCount++
+	return c.msgCount <= perSecond
+}
+
+// QueueStats глубина очереди каждого клиента, для метрик
+func (wse *WebSocketEngine) QueueStats() []ClientQueueStat {
+	wse.mu.RLock()
+	defer wse.mu.RUnlock()
+	out := make([]ClientQueueStat, 0, len(wse.clients))
+	for c := range wse.clients {
+		c.outMu.Lock()
+		depth := len(c.out)
+		c.outMu.Unlock()
+		out = append(out, ClientQueueStat{
+			ClientID: c.ID,
+			UserID:   c.UserID,
+			GameType: c.GameType,
+			Depth:    depth,
+			Dropped:  atomic.LoadInt64(&c.dropped),
+		})
+	}
+	return out
+}
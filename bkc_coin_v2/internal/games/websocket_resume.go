@@ -0,0 +1,151 @@
+package games
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Возобновление раунда при переподключении. Состояние игрока в раунде
+// (ставка, вывод) лежит в Redis по ключу раунда и пользователя, поэтому
+// переживает обрыв соединения и переподключение к другому инстансу. При
+// подключении к Ракетке клиент получает crash_snapshot: текущий множитель,
+// свою ставку и сколько прошло с начала раунда, и продолжает без рывка.
+
+// roundPlayerTTL сколько хранится состояние игрока после последнего изменения
+const roundPlayerTTL = time.Hour
+
+// CrashSnapshot снимок раунда для переподключившегося клиента
+type CrashSnapshot struct {
+	GameID     string     `json:"game_id"`
+	Status     GameStatus `json:"status"`
+	TimeLeft   float64    `json:"time_left,omitempty"` // до закрытия ставок, с
+	Multiplier float64    `json:"multiplier"`
+	CrashPoint float64    `json:"crash_point,omitempty"` // только после взрыва
+	Hash       string     `json:"hash"`
+	StartedAt  time.Time  `json:"started_at"`
+	ElapsedMs  int64      `json:"elapsed_ms"`  // с начала раунда по часам сервера
+	ServerTime int64      `json:"server_time"` // мс Unix, для поправки часов клиента
+	TickMs     int64      `json:"tick_ms"`
+	GrowthRate float64    `json:"growth_rate"` // прирост множителя за тик
+	Player     *Player    `json:"player,omitempty"`
+	Potential  int64      `json:"potential_win,omitempty"` // выигрыш при выводе сейчас
+}
+
+func roundPlayerKey(gameID string, userID int64) string {
+	return fmt.Sprintf("ws:crash:%s:player:%d", gameID, userID)
+}
+
+// SaveCrashPlayer сохраняет состояние игрока в раунде: в памяти раунда и в
+// Redis. Вызывается при ставке и выводе; новый игрок входит в раунд только
+// в фазе приема ставок (ErrBettingClosed).
+func (wse *WebSocketEngine) SaveCrashPlayer(ctx context.Context, gameID string, p Player) error {
+	wse.gameMu.RLock()
+	game := wse.games[gameID]
+	wse.gameMu.RUnlock()
+	if game != nil {
+		game.mu.Lock()
+		if _, ok := game.Players[p.UserID]; !ok && (game.Status != GameStatusWaiting || !wse.now().Before(game.BetsCloseAt)) {
+			game.mu.Unlock()
+			return ErrBettingClosed
+		}
+		if game.Players == nil {
+			game.Players = make(map[int64]*Player)
+		}
+		cp := p
+		game.Players[p.UserID] = &cp
+		game.mu.Unlock()
+	}
+
+	if wse.rdb == nil {
+		return nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal player state: %w", err)
+	}
+	if err := wse.rdb.Set(ctx, roundPlayerKey(gameID, p.UserID), data, roundPlayerTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save player state: %w", err)
+	}
+	return nil
+}
+
+// loadCrashPlayer состояние игрока в раунде: Redis, затем память раунда;
+// nil — игрок в раунде не участвует.
+func (wse *WebSocketEngine) loadCrashPlayer(ctx context.Context, game *Game, userID int64) *Player {
+	if wse.rdb != nil {
+		data, err := wse.rdb.Get(ctx, roundPlayerKey(game.ID, userID)).Bytes()
+		switch {
+		case err == nil:
+			var p Player
+			if err := json.Unmarshal(data, &p); err == nil {
+				return &p
+			}
+			log.Printf("Bad player state for %s/%d", game.ID, userID)
+		case err != redis.Nil:
+			log.Printf("Failed to load player state for %s/%d: %v", game.ID, userID, err)
+		}
+	}
+
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+	if p, ok := game.Players[userID]; ok {
+		cp := *p
+		return &cp
+	}
+	return nil
+}
+
+// crashSnapshot снимок раунда game глазами userID
+func (wse *WebSocketEngine) crashSnapshot(ctx context.Context, game *Game, userID int64, now time.Time) CrashSnapshot {
+	player := wse.loadCrashPlayer(ctx, game, userID)
+
+	game.mu.RLock()
+	s := CrashSnapshot{
+		GameID:     game.ID,
+		Status:     game.Status,
+		Multiplier: game.CurrentMult,
+		Hash:       game.Hash,
+		StartedAt:  game.StartedAt,
+		ServerTime: now.UnixMilli(),
+		TickMs:     wse.config.CrashGameSettings.UpdateInterval.Milliseconds(),
+		GrowthRate: wse.config.CrashGameSettings.GrowthRate,
+		Player:     player,
+	}
+	if game.Status == GameStatusCrashed || game.Status == GameStatusFinished {
+		s.CrashPoint = game.CrashPoint
+	}
+	if game.Status == GameStatusWaiting {
+		s.TimeLeft = bettingTimeLeft(game.BetsCloseAt, now)
+	}
+	game.mu.RUnlock()
+
+	if s.Status == GameStatusActive && !s.StartedAt.IsZero() {
+		s.ElapsedMs = now.Sub(s.StartedAt).Milliseconds()
+		if player != nil && !player.CashedOut {
+			s.Potential = int64(float64(player.Bet) * s.Multiplier)
+		}
+	}
+	return s
+}
+
+// sendCrashSnapshot отправляет клиенту снимок текущего раунда
+func (wse *WebSocketEngine) sendCrashSnapshot(client *Client) {
+	game := wse.getCurrentCrashGame()
+	if game == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(wse.ctx, 2*time.Second)
+	defer cancel()
+	now := wse.now()
+	wse.sendToClient(client, WebSocketMessage{
+		Type:      "crash_snapshot",
+		Data:      wse.crashSnapshot(ctx, game, client.UserID, now),
+		Timestamp: now,
+		GameID:    game.ID,
+	})
+}
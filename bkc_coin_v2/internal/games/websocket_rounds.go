@@ -0,0 +1,119 @@
+package games
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Фазы раунда Ракетки:
+//
+//	waiting  — прием ставок, раз в CountdownInterval рассылается обратный отсчет;
+//	starting — ставки закрыты (LockDuration), клиенты готовят полет;
+//	active   — полет, множитель растет до точки взрыва;
+//	crashed  — взрыв, итоги раунда;
+//	finished — перерыв (Intermission), затем следующий раунд.
+//
+// Ставки принимаются только в фазе waiting.
+
+// ErrBettingClosed ставка вне окна приема ставок
+var ErrBettingClosed = errors.New("betting is closed for this round")
+
+// bettingTimeLeft секунд до закрытия ставок, с точностью до десятых
+func bettingTimeLeft(closeAt, now time.Time) float64 {
+	left := closeAt.Sub(now).Seconds()
+	if left <= 0 {
+		return 0
+	}
+	return math.Ceil(left*10) / 10
+}
+
+// CheckCrashBetting nil, если раунд gameID принимает ставки
+func (wse *WebSocketEngine) CheckCrashBetting(gameID string) error {
+	wse.gameMu.RLock()
+	game := wse.games[gameID]
+	wse.gameMu.RUnlock()
+	if game == nil {
+		return ErrBettingClosed
+	}
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+	if game.Status != GameStatusWaiting || !wse.now().Before(game.BetsCloseAt) {
+		return ErrBettingClosed
+	}
+	return nil
+}
+
+// runCrashRound ведет раунд по фазам и запускает следующий
+func (wse *WebSocketEngine) runCrashRound(game *Game) {
+	s := wse.config.CrashGameSettings
+
+	// Прием ставок с обратным отсчетом
+	tick := s.CountdownInterval
+	if tick <= 0 {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	closed := time.NewTimer(game.BetsCloseAt.Sub(wse.now()))
+	wse.broadcastCrashUpdate(game)
+betting:
+	for {
+		select {
+		case <-wse.ctx.Done():
+			ticker.Stop()
+			closed.Stop()
+			return
+		case <-ticker.C:
+			wse.broadcastCrashUpdate(game)
+		case <-closed.C:
+			break betting
+		}
+	}
+	ticker.Stop()
+
+	// Ставки закрыты
+	wse.setCrashPhase(game, GameStatusStarting)
+	if !wse.sleep(s.LockDuration) {
+		return
+	}
+
+	// Полет до взрыва
+	wse.startCrashGame(game)
+	if wse.ctx.Err() != nil {
+		return
+	}
+
+	// Перерыв
+	if !wse.sleep(s.Intermission) {
+		return
+	}
+	wse.setCrashPhase(game, GameStatusFinished)
+
+	wse.gameMu.Lock()
+	delete(wse.games, game.ID)
+	wse.newCrashGameLocked()
+	wse.gameMu.Unlock()
+}
+
+// setCrashPhase меняет фазу раунда и рассылает ее клиентам
+func (wse *WebSocketEngine) setCrashPhase(game *Game, status GameStatus) {
+	game.mu.Lock()
+	game.Status = status
+	game.mu.Unlock()
+	wse.broadcastCrashUpdate(game)
+}
+
+// sleep ждет d; false — движок остановлен
+func (wse *WebSocketEngine) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return wse.ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-wse.ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
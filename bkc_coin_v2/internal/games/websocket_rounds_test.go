@@ -0,0 +1,39 @@
+package games
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/testenv"
+)
+
+func TestCrashBettingWindowFollowsClock(t *testing.T) {
+	clk := clock.NewManual(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	cfg := DefaultWebSocketConfig()
+	cfg.Clock = clk
+	wse := NewWebSocketEngine(cfg, []byte("k"), nil, nil)
+	game := &Game{ID: "crash_1", Type: GameTypeCrash, Status: GameStatusWaiting, BetsCloseAt: clk.Now().Add(10 * time.Second)}
+	wse.games[game.ID] = game
+
+	if err := wse.CheckCrashBetting(game.ID); err != nil {
+		t.Fatalf("open round: %v", err)
+	}
+	clk.Advance(10 * time.Second)
+	if err := wse.CheckCrashBetting(game.ID); !errors.Is(err, ErrBettingClosed) {
+		t.Fatalf("at close: %v", err)
+	}
+	if err := wse.SaveCrashPlayer(context.Background(), game.ID, Player{UserID: 1}); !errors.Is(err, ErrBettingClosed) {
+		t.Fatalf("late player: %v", err)
+	}
+}
+
+func TestEngineStopEndsLoops(t *testing.T) {
+	testenv.NoLeaks(t)
+	cfg := DefaultWebSocketConfig()
+	wse := NewWebSocketEngine(cfg, []byte("k"), nil, nil)
+	wse.Start()
+	wse.Stop()
+}
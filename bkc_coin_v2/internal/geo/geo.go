@@ -0,0 +1,124 @@
+// Package geo resolves client IPs to a country and autonomous system (ASN)
+// for login tracking and withdrawal risk checks.
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Info is where an IP is located. Zero fields mean unknown.
+type Info struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	ASN     int64  `json:"asn,omitempty"`
+}
+
+// Known reports whether the lookup produced anything usable.
+func (i Info) Known() bool {
+	return i.Country != "" || i.ASN != 0
+}
+
+// Resolver looks up the location of an IP.
+type Resolver interface {
+	Lookup(ctx context.Context, ip string) (Info, error)
+}
+
+const cacheTTL = 12 * time.Hour
+
+type cached struct {
+	info Info
+	at   time.Time
+}
+
+// HTTPResolver queries an ip-api.com compatible endpoint and caches answers.
+// The URL template contains %s for the IP, e.g.
+// "http://ip-api.com/json/%s?fields=status,countryCode,as".
+// With an empty template every lookup returns an unknown Info.
+type HTTPResolver struct {
+	url    string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+func NewHTTPResolver(urlTemplate string) *HTTPResolver {
+	return &HTTPResolver{
+		url:    strings.TrimSpace(urlTemplate),
+		client: &http.Client{Timeout: 3 * time.Second},
+		cache:  make(map[string]cached),
+	}
+}
+
+func (g *HTTPResolver) Lookup(ctx context.Context, ip string) (Info, error) {
+	ip = strings.TrimSpace(ip)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	parsed := net.ParseIP(ip)
+	if g.url == "" || parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsUnspecified() {
+		return Info{}, nil
+	}
+	key := parsed.String()
+
+	g.mu.Lock()
+	c, ok := g.cache[key]
+	g.mu.Unlock()
+	if ok && time.Since(c.at) < cacheTTL {
+		return c.info, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(g.url, key), nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("geo: status %d", resp.StatusCode)
+	}
+	var body struct {
+		Status      string `json:"status"`
+		CountryCode string `json:"countryCode"`
+		AS          string `json:"as"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Info{}, err
+	}
+	if body.Status != "" && body.Status != "success" {
+		return Info{}, fmt.Errorf("geo: lookup %s failed", key)
+	}
+	info := Info{Country: strings.ToUpper(strings.TrimSpace(body.CountryCode)), ASN: ParseASN(body.AS)}
+
+	g.mu.Lock()
+	if len(g.cache) > 100_000 {
+		g.cache = make(map[string]cached)
+	}
+	g.cache[key] = cached{info: info, at: time.Now()}
+	g.mu.Unlock()
+	return info, nil
+}
+
+// ParseASN extracts the number from "AS13335 Cloudflare, Inc." or "13335".
+func ParseASN(s string) int64 {
+	s = strings.TrimSpace(s)
+	if f := strings.Fields(s); len(f) > 0 {
+		s = f[0]
+	}
+	s = strings.TrimPrefix(strings.ToUpper(s), "AS")
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
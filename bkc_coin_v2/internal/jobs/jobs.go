@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Func is one run of a scheduled job.
+type Func func(ctx context.Context) error
+
+// Heartbeat is told the outcome of every run (err is nil on success).
+type Heartbeat func(ctx context.Context, name string, interval time.Duration, err error)
+
+// Lease reports whether this instance leads job name for the next ttl,
+// taking the lease if it is free or renewing it if already held.
+type Lease func(ctx context.Context, name string, ttl time.Duration) (bool, error)
+
+var (
+	heartbeat Heartbeat
+	lease     Lease
+)
+
+// errFollower ends the run of a leader job on an instance without the lease.
+var errFollower = errors.New("not the leader")
+
+// SetHeartbeat reports runs of jobs started afterwards to h. Call it before
+// the first Start.
+func SetHeartbeat(h Heartbeat) {
+	heartbeat = h
+}
+
+// SetLease elects the leaders of jobs started afterwards with StartLeader.
+// Call it before the first StartLeader.
+func SetLease(l Lease) {
+	lease = l
+}
+
+// StartLeader is Start for a job that must not run on two instances at
+// once. Every tick each instance asks for the lease, valid for three
+// intervals; only the holder runs fn and reports a heartbeat. If it dies,
+// another instance takes over once the lease expires. Without SetLease the
+// job runs on every instance.
+func StartLeader(ctx context.Context, name string, interval time.Duration, fn Func) {
+	l := lease
+	if l == nil {
+		Start(ctx, name, interval, fn)
+		return
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	Start(ctx, name, interval, func(ctx context.Context) error {
+		ok, err := l(ctx, name, 3*interval)
+		if err != nil {
+			return fmt.Errorf("lease: %w", err)
+		}
+		if !ok {
+			return errFollower
+		}
+		return fn(ctx)
+	})
+}
+
+// Start runs fn every interval in a background goroutine until ctx is done.
+// A failed run is logged and retried on the next tick; runs never overlap.
+func Start(ctx context.Context, name string, interval time.Duration, fn Func) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	hb := heartbeat
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			runCtx, cancel := context.WithTimeout(ctx, interval)
+			err := fn(runCtx)
+			cancel()
+			if errors.Is(err, errFollower) {
+				continue
+			}
+			if err != nil {
+				log.Printf("jobs: %s: %v", name, err)
+			}
+			if hb != nil {
+				hb(ctx, name, interval, err)
+			}
+		}
+	}()
+}
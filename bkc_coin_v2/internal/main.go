@@ -1,27 +1,388 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"bkc_coin_v2/internal/admission"
+	"bkc_coin_v2/internal/analytics"
 	"bkc_coin_v2/internal/api"
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/devdb"
+	"bkc_coin_v2/internal/fasttap"
+	"bkc_coin_v2/internal/faults"
+	"bkc_coin_v2/internal/geo"
+	"bkc_coin_v2/internal/jobs"
+	"bkc_coin_v2/internal/memtap"
+	"bkc_coin_v2/internal/params"
+	"bkc_coin_v2/internal/shadow"
+	"bkc_coin_v2/internal/tgbot"
 	"bkc_coin_v2/internal/ton"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// SIGTERM/SIGINT отменяют ctx: фоновые задачи останавливаются, сервер закрывается
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg := config.Load()
+
+	// Подключение к базе данных; DB_DRIVER=embedded поднимает свой Postgres (локальная разработка)
+	if cfg.DBDriver == "embedded" {
+		pg, err := devdb.Start(ctx, cfg.DBEmbeddedDir, cfg.PGBinDir)
+		if err != nil {
+			log.Fatalf("embedded db: %v", err)
+		}
+		defer pg.Stop()
+		cfg.DatabaseURL = pg.URL
+		log.Printf("embedded postgres: %s (data in %s)", pg.URL, cfg.DBEmbeddedDir)
+	}
+	database, err := db.ConnectWith(ctx, cfg.DatabaseURL, db.QueryConfig{
+		Timeout:       time.Duration(cfg.DBQueryTimeoutMs) * time.Millisecond,
+		SlowThreshold: time.Duration(cfg.DBSlowQueryMs) * time.Millisecond,
+		Registerer:    prometheus.DefaultRegisterer,
+	})
+	if err != nil {
+		log.Fatalf("db connect: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(ctx); err != nil {
+		log.Fatalf("db migrate: %v", err)
+	}
+	// Лимит эмиссии хранится в system_state: его соблюдают все процессы и пути начисления
+	if err := database.SetDailyEmissionCap(ctx, cfg.DailyEmissionCap); err != nil {
+		log.Fatalf("db emission cap: %v", err)
+	}
+	// Курсы игровых кредитов: конфиг задает только начальные
+	if err := database.EnsureGameCreditRates(ctx, db.GameCreditRates{
+		BuyRate:        cfg.GameCreditsBuyRate,
+		SellRate:       cfg.GameCreditsSellRate,
+		SellDailyLimit: cfg.GameCreditsSellDailyLimit,
+	}); err != nil {
+		log.Fatalf("db game credit rates: %v", err)
+	}
+	// ADMIN_ID всегда супер-админ; остальных админов и их права назначает он через /api/v1/admin/admins
+	if err := database.EnsureSuperAdmin(ctx, cfg.AdminID); err != nil {
+		log.Fatalf("db super admin: %v", err)
+	}
+
+	// Инъекция сбоев для проверки идемпотентности и повторов (только вне production)
+	if cfg.FaultInjection {
+		rules, err := faults.Parse(cfg.FaultRules)
+		if err != nil {
+			log.Fatalf("FAULTS: %v", err)
+		}
+		faults.SetGlobal(rules)
+		faults.Enable()
+		log.Printf("⚠️ fault injection enabled (%s): %q", cfg.Environment, cfg.FaultRules)
+	}
+
+	// Фоновые задачи; каждый запуск отмечается в job_heartbeats (алерт о пропавшем воркере)
+	jobs.SetHeartbeat(func(ctx context.Context, name string, interval time.Duration, runErr error) {
+		if err := database.RecordJobRun(ctx, name, interval, runErr); err != nil {
+			log.Printf("jobs: %s: heartbeat: %v", name, err)
+		}
+	})
+	// Задачи, которые нельзя запускать на двух инстансах сразу, идут только у держателя аренды в job_leases
+	host, _ := os.Hostname()
+	jobHolder := fmt.Sprintf("%s/%d", host, os.Getpid())
+	jobs.SetLease(func(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+		return database.AcquireJobLease(ctx, name, jobHolder, ttl)
+	})
+	if cfg.RunJobs {
+		jobs.Start(ctx, "nft_stake_accrual", time.Hour, func(ctx context.Context) error {
+			_, err := database.AccrueNFTStakes(ctx, time.Time{})
+			return err
+		})
+		jobs.Start(ctx, "nft_offer_expiry", time.Minute, func(ctx context.Context) error {
+			_, err := database.ExpireNFTOffers(ctx, time.Time{})
+			return err
+		})
+		jobs.Start(ctx, "watchlist_notify", 5*time.Minute, func(ctx context.Context) error {
+			if _, err := database.NotifySavedSearchMatches(ctx); err != nil {
+				return err
+			}
+			_, err := database.NotifyWatchPriceDrops(ctx)
+			return err
+		})
+		jobs.Start(ctx, "promotion_settlement", 10*time.Minute, func(ctx context.Context) error {
+			_, err := database.SettleListingPromotions(ctx, time.Time{})
+			return err
+		})
+		// Сжигание доли комиссий по расписаниям (/api/v1/admin/burn-schedules)
+		jobs.Start(ctx, "burn_schedule", 10*time.Minute, func(ctx context.Context) error {
+			_, err := database.RunDueBurnSchedules(ctx, time.Time{})
+			return err
+		})
+	}
+
+	// Бот нужен для подтверждения опасных операций (step-up)
+	var notifier api.StepUpNotifier
+	var holdNotifier api.WithdrawalHoldNotifier
+	var adminAlerts api.AdminAlertNotifier
+	var userNotifier api.UserNotifier
+	if cfg.RunBot {
+		bot, err := tgbot.New(cfg, database)
+		if err != nil {
+			log.Printf("Telegram bot disabled: %v", err)
+		} else {
+			bot.StartPolling(ctx)
+			notifier = bot
+			holdNotifier = bot
+			adminAlerts = bot
+			userNotifier = bot
+		}
+	}
+	stepUp := api.NewStepUp(cfg, database, notifier)
+	alertsHandler := api.NewAlertsHandler(cfg, database, adminAlerts)
+	// Алерты админу: резерв, платежи, здоровье экономики, пропавшие воркеры.
+	// Работает и в API-процессе, чтобы заметить остановку воркера с RUN_JOBS
+	if cfg.RunJobs || adminAlerts != nil {
+		jobs.Start(ctx, "alerts", time.Minute, alertsHandler.CheckAlerts)
+	}
+	templatesHandler := api.NewTemplatesHandler(cfg, database, userNotifier)
+	// Уведомления по шаблонам отправляет процесс с ботом
+	if userNotifier != nil {
+		jobs.Start(ctx, "notifications", 30*time.Second, templatesHandler.SendNotifications)
+	}
+
+	// Тап: memtap (MEMTAP_ENABLED=1) или fasttap (REDIS_URL) под защитой от перегрузки
+	memEngine := memtap.New(cfg, database)
+	// Теневая формула награды считается по тапам memtap и пишется раз в минуту
+	var shadowRecorder *shadow.Recorder
+	if cfg.EconomyShadow && memEngine != nil {
+		shadowRecorder = shadow.NewRecorder(database)
+		memEngine.SetObserver(shadowRecorder)
+		jobs.Start(ctx, "tap_shadow", time.Minute, shadowRecorder.Flush)
+	}
+	memEngine.Start(ctx)
+	var fastEngine *fasttap.Engine
+	if memEngine == nil && cfg.RedisURL != "" {
+		rdb, err := fasttap.Connect(ctx, cfg.RedisURL)
+		if err != nil {
+			log.Printf("fasttap disabled: %v", err)
+		} else if fastEngine = fasttap.New(cfg, database, rdb); fastEngine != nil {
+			if err := fastEngine.EnsureSystemCached(ctx); err != nil {
+				log.Printf("fasttap: seed system: %v", err)
+			}
+			if cfg.RunFasttap {
+				fastEngine.StartWorker(ctx)
+			}
+		}
+	}
+	tapAdmission := admission.New(admission.Config{
+		DegradeDepth:   cfg.TapDegradeQueueDepth,
+		ShedDepth:      cfg.TapShedQueueDepth,
+		DegradeLatency: time.Duration(cfg.TapDegradeDBLatencyMs) * time.Millisecond,
+		ShedLatency:    time.Duration(cfg.TapShedDBLatencyMs) * time.Millisecond,
+		RetryAfter:     time.Duration(cfg.TapShedRetryAfterMs) * time.Millisecond,
+		BatchWindow:    time.Duration(cfg.TapDegradeBatchMs) * time.Millisecond,
+		OnChange: func(_, to admission.Level) {
+			// Деградация: сбрасываем memtap реже, агрегаты по пользователю крупнее
+			if to == admission.LevelNormal {
+				memEngine.SetFlushStretch(1)
+			} else {
+				memEngine.SetFlushStretch(4)
+			}
+		},
+	}, func(ctx context.Context) (admission.Signals, error) {
+		if fastEngine.Enabled() {
+			depth, err := fastEngine.QueueDepth(ctx)
+			return admission.Signals{QueueDepth: depth, DBLatency: fastEngine.DBLatency()}, err
+		}
+		return admission.Signals{QueueDepth: memEngine.QueueDepth(), DBLatency: memEngine.DBLatency()}, nil
+	}, prometheus.DefaultRegisterer)
+	tapAdmission.Run(ctx)
+	geoResolver := geo.NewHTTPResolver(cfg.GeoIPURL)
+
 	// Инициализация handlers
+	// Комиссии и лимиты: значения из конфига, переопределения админа из таблицы params
+	runtimeParams := params.New(cfg, database)
 	p2pHandler := api.NewP2PHandler()
-	nftHandler := api.NewNFTHandler()
+	rateManager := ton.NewRateManager()
+	nftHandler := api.NewNFTHandler(cfg, database, runtimeParams, rateManager)
+	eventsHandler := api.NewEventsHandler(cfg, database)
+	activityHandler := api.NewActivityHandler(cfg, database)
+	loanOffersHandler := api.NewLoanOffersHandler(cfg, database)
+	loanInsuranceHandler := api.NewLoanInsuranceHandler(cfg, database)
+	watchlistHandler := api.NewWatchlistHandler(cfg, database)
+	promotionsHandler := api.NewPromotionsHandler(cfg, database)
+	storefrontHandler := api.NewStorefrontHandler(cfg, database)
+	sessionsHandler := api.NewSessionsHandler(cfg, database)
+	twoFAHandler := api.NewTwoFAHandler(cfg, database, stepUp)
+	walletHandler := api.NewWalletHandler(cfg, database, stepUp)
+	withdrawalsHandler := api.NewWithdrawalsHandler(cfg, database, runtimeParams, stepUp, geoResolver, holdNotifier)
+	tapHandler := api.NewTapHandler(cfg, database, memEngine, fastEngine, tapAdmission)
+	// TODO: передать games.WebSocketEngine, чтобы график получал курс в реальном времени
+	economyHandler := api.NewEconomyHandler(cfg, database, rateManager, nil, alertsHandler, prometheus.DefaultRegisterer)
+	vestingHandler := api.NewVestingHandler(cfg, database, stepUp)
+	profileHandler := api.NewProfileHandler(cfg, database, runtimeParams)
+	settingsHandler := api.NewSettingsHandler(cfg, database)
+	statsHandler := api.NewStatsHandler(cfg, database)
+	analyticsHandler := api.NewAnalyticsHandler(cfg, database)
+	reservesHandler := api.NewReservesHandler(cfg, database, rateManager)
+	mergeHandler := api.NewMergeHandler(cfg, database)
+	configProfileHandler := api.NewConfigProfileHandler(cfg, database)
+	paramsHandler := api.NewParamsHandler(cfg, database, runtimeParams)
+	patternsHandler := api.NewPatternsHandler(cfg, database)
+	apiKeysHandler := api.NewAPIKeysHandler(cfg, database, stepUp)
+	webhooksHandler := api.NewWebhooksHandler(cfg, database)
+	adminsHandler := api.NewAdminsHandler(cfg, database)
+	switchesHandler := api.NewSwitchesHandler(cfg, database)
+	replayGuard := api.NewReplayGuard(cfg, database)
+	gameRTPHandler := api.NewGameRTPHandler(cfg, database)
+	gameHistoryHandler := api.NewGameHistoryHandler(cfg, database)
+	betLimitsHandler := api.NewBetLimitsHandler(cfg, database)
+	wsTokenHandler := api.NewWSTokenHandler(cfg)
+	botsHandler := api.NewBotsHandler(cfg, database)
+	affiliatesHandler := api.NewAffiliatesHandler(cfg, database, runtimeParams)
+	bonusesHandler := api.NewBonusesHandler(cfg, database)
+	gameCreditsHandler := api.NewGameCreditsHandler(cfg, database)
+	giftsHandler := api.NewGiftsHandler(cfg, database, runtimeParams, stepUp)
+	billsHandler := api.NewBillsHandler(cfg, database, runtimeParams)
+	merchantsHandler := api.NewMerchantsHandler(cfg, database, runtimeParams)
+	tipsHandler := api.NewTipsHandler(cfg, database, runtimeParams)
+	creatorsHandler := api.NewCreatorsHandler(cfg, database)
+	gigsHandler := api.NewGigsHandler(cfg, database, runtimeParams)
+	moderationHandler := api.NewModerationHandler(cfg, database)
+	marketHandler := api.NewMarketHandler(cfg, database, runtimeParams)
+	experimentsHandler := api.NewExperimentsHandler(cfg, database)
+	homeHandler := api.NewHomeHandler(cfg, database, prometheus.DefaultRegisterer)
+	faultsHandler := api.NewFaultsHandler(cfg)
 	gamesHandler := api.NewGamesHandler(nil) // TODO: передать gamesManager
 	exchangeHandler := api.NewExchangeHandler()
 	creditsHandler := api.NewCreditsHandler(nil)           // TODO: передать creditsManager
 	subscriptionHandler := api.NewSubscriptionHandler(nil) // TODO: передать subscriptionManager
 
+	// История внутреннего курса (свечи /api/v1/economy/rate/history)
+	if cfg.RunJobs {
+		jobs.Start(ctx, "rate_sample", time.Minute, economyHandler.SampleRate)
+		// Прогноз исчерпания резерва и алерт админу
+		jobs.Start(ctx, "reserve_forecast", time.Hour, economyHandler.ForecastReserve)
+		// Просроченные банковские кредиты: пачками до конца, с продолжением с чекпойнта
+		jobs.StartLeader(ctx, db.OverdueLoansJob, 5*time.Minute, economyHandler.MarkOverdueLoans)
+		// XP за тапы, покупки и выигрыши; награды за уровни
+		jobs.Start(ctx, "levels", time.Minute, profileHandler.SyncLevels)
+		// Дробление и круговые переводы за вчера (день сканируется один раз)
+		jobs.Start(ctx, "ledger_patterns", time.Hour, patternsHandler.ScanLedger)
+		// Вебхуки разработчиков: события из леджера, подписанная доставка с повторами
+		jobs.Start(ctx, "webhooks", 15*time.Second, webhooksHandler.DeliverWebhooks)
+		// Витрина главного экрана: пересборка по новым событиям
+		jobs.Start(ctx, "home_views", 5*time.Second, homeHandler.ProjectHomeViews)
+		// Публичная статистика токена (supply, сжигание, держатели, объем за 24ч)
+		jobs.Start(ctx, "public_stats", 5*time.Minute, statsHandler.RefreshStats)
+		// Удержание когорт по дню регистрации: D1/D7/D30
+		jobs.Start(ctx, "retention", time.Hour, analyticsHandler.ComputeRetention)
+		// Очистка истекших challenge защиты от повтора запросов
+		jobs.Start(ctx, "request_challenges", time.Hour, replayGuard.PruneChallenges)
+		// Проверка игроков на ботов: оценка, урезание ставок и капча
+		jobs.Start(ctx, "game_bots", 15*time.Minute, botsHandler.ScoreBots)
+		// Партнерские отчеты за прошлый месяц (месяц закрывается один раз)
+		jobs.Start(ctx, "affiliate_statements", 6*time.Hour, affiliatesHandler.BuildStatements)
+		// Истекшие бонусы возвращаются в резерв
+		jobs.Start(ctx, "bonus_expiry", 15*time.Minute, bonusesHandler.ExpireBonuses)
+		// Невостребованные подарки возвращаются отправителям
+		jobs.Start(ctx, "gift_expiry", 5*time.Minute, giftsHandler.ExpireGifts)
+		// Несобранные к сроку совместные счета возвращают оплаченные доли
+		jobs.Start(ctx, "bill_expiry", 5*time.Minute, billsHandler.ExpireBills)
+		// Счета магазинов: просроченные заказы и отчеты за вчера
+		jobs.Start(ctx, "merchant_reports", time.Hour, merchantsHandler.SettleMerchants)
+		// Чаевые: запись в леджер пачками и регулярные чаевые
+		jobs.Start(ctx, "tip_ledger", 30*time.Second, tipsHandler.BookTips)
+		jobs.Start(ctx, "recurring_tips", 5*time.Minute, tipsHandler.RunRecurringTips)
+		// Этапы фриланс-услуг: возврат после дедлайна, оплата после срока проверки
+		jobs.Start(ctx, "gig_deadlines", 5*time.Minute, gigsHandler.ExpireGigMilestones)
+		// Автопроверка новых объявлений; помеченные ждут модератора
+		jobs.Start(ctx, "listing_screening", time.Minute, moderationHandler.ScreenListings)
+		// Возврат арендованных NFT по окончании срока
+		jobs.Start(ctx, "nft_rentals", time.Minute, nftHandler.ExpireRentals)
+		// Уведомления по вишлистам NFT: пополнение и новые дропы
+		jobs.Start(ctx, "nft_wishes", 5*time.Minute, nftHandler.NotifyWishes)
+		// Очередь заказов на дропы NFT: исполнение в порядке поступления
+		jobs.Start(ctx, "nft_drops", 5*time.Second, nftHandler.ProcessDrops)
+		// Сведение шардированного остатка NFT и выручки в резерв
+		jobs.Start(ctx, "nft_supply_sync", 5*time.Second, nftHandler.SyncSupply)
+		// Остановка A/B-экспериментов, нарушивших guardrail-метрики
+		jobs.Start(ctx, "experiments", 15*time.Minute, experimentsHandler.CheckGuardrails)
+		// Периодические сводки в Telegram: заработок, кредиты, истекающие продвижения
+		jobs.Start(ctx, "digests", 5*time.Minute, settingsHandler.QueueDigests)
+		// Подписанный отчет proof of reserves (если задан RESERVES_SIGNING_KEY)
+		if reservesHandler.Enabled() {
+			jobs.Start(ctx, "reserves", time.Hour, reservesHandler.GenerateReport)
+		}
+		// Выгрузка леджера и событий в ClickHouse (если задан CLICKHOUSE_URL)
+		if cfg.ClickHouseURL != "" {
+			exporter := analytics.NewExporter(database, analytics.NewClickHouse(cfg.ClickHouseURL, cfg.ClickHouseDatabase, cfg.ClickHouseUser, cfg.ClickHousePassword), int(cfg.AnalyticsBatch))
+			if err := exporter.EnsureSchema(ctx); err != nil {
+				log.Printf("analytics: schema: %v", err)
+			}
+			jobs.Start(ctx, "analytics_export", 10*time.Second, exporter.Export)
+		}
+	}
+
 	// Регистрация роутов
 	mux := http.NewServeMux()
 	p2pHandler.RegisterRoutes(mux)
 	nftHandler.RegisterRoutes(mux)
+	eventsHandler.RegisterRoutes(mux)
+	activityHandler.RegisterRoutes(mux)
+	loanOffersHandler.RegisterRoutes(mux)
+	loanInsuranceHandler.RegisterRoutes(mux)
+	watchlistHandler.RegisterRoutes(mux)
+	promotionsHandler.RegisterRoutes(mux)
+	storefrontHandler.RegisterRoutes(mux)
+	sessionsHandler.RegisterRoutes(mux)
+	twoFAHandler.RegisterRoutes(mux)
+	walletHandler.RegisterRoutes(mux)
+	withdrawalsHandler.RegisterRoutes(mux)
+	tapHandler.RegisterRoutes(mux)
+	economyHandler.RegisterRoutes(mux)
+	vestingHandler.RegisterRoutes(mux)
+	profileHandler.RegisterRoutes(mux)
+	settingsHandler.RegisterRoutes(mux)
+	statsHandler.RegisterRoutes(mux)
+	analyticsHandler.RegisterRoutes(mux)
+	reservesHandler.RegisterRoutes(mux)
+	mergeHandler.RegisterRoutes(mux)
+	configProfileHandler.RegisterRoutes(mux)
+	paramsHandler.RegisterRoutes(mux)
+	patternsHandler.RegisterRoutes(mux)
+	apiKeysHandler.RegisterRoutes(mux)
+	webhooksHandler.RegisterRoutes(mux)
+	adminsHandler.RegisterRoutes(mux)
+	switchesHandler.RegisterRoutes(mux)
+	replayGuard.RegisterRoutes(mux)
+	gameRTPHandler.RegisterRoutes(mux)
+	gameHistoryHandler.RegisterRoutes(mux)
+	betLimitsHandler.RegisterRoutes(mux)
+	wsTokenHandler.RegisterRoutes(mux)
+	botsHandler.RegisterRoutes(mux)
+	affiliatesHandler.RegisterRoutes(mux)
+	bonusesHandler.RegisterRoutes(mux)
+	gameCreditsHandler.RegisterRoutes(mux)
+	giftsHandler.RegisterRoutes(mux)
+	billsHandler.RegisterRoutes(mux)
+	merchantsHandler.RegisterRoutes(mux)
+	tipsHandler.RegisterRoutes(mux)
+	creatorsHandler.RegisterRoutes(mux)
+	gigsHandler.RegisterRoutes(mux)
+	moderationHandler.RegisterRoutes(mux)
+	marketHandler.RegisterRoutes(mux)
+	experimentsHandler.RegisterRoutes(mux)
+	homeHandler.RegisterRoutes(mux)
+	alertsHandler.RegisterRoutes(mux)
+	templatesHandler.RegisterRoutes(mux)
+	faultsHandler.RegisterRoutes(mux)
 	gamesHandler.RegisterRoutes(mux)
 	exchangeHandler.RegisterRoutes(mux)
 	creditsHandler.RegisterRoutes(mux)
@@ -32,6 +393,7 @@ func main() {
 		w.WriteHeader(200)
 		fmt.Fprintf(w, "BKC Coin API is running")
 	})
+	mux.Handle("GET /metrics", promhttp.Handler())
 
 	// Запуск сервера
 	port := ":8080"
@@ -45,5 +407,28 @@ func main() {
 	fmt.Printf("🪙 TON Webhooks: /webhook/*\n")
 	fmt.Printf("💰 Your TON Wallet: %s\n", ton.COMMISSION_WALLET)
 
-	log.Fatal(http.ListenAndServe(port, mux))
+	srv := &http.Server{Addr: port, Handler: faults.Middleware(switchesHandler.Middleware(api.SessionMiddleware(cfg, database, geoResolver)(api.APIKeyMiddleware(database)(replayGuard.Middleware(api.AdminMiddleware(cfg, database)(mux))))))}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("http: %v", err)
+		}
+	}()
+	<-ctx.Done()
+
+	// Завершение: сначала перестаем принимать запросы (и тапы), затем сбрасываем
+	// накопленные в памяти агрегаты; остаток уходит в spill-файл до следующего старта
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if err := memEngine.Drain(context.Background()); err != nil {
+		log.Printf("memtap drain: %v", err)
+	}
+	if shadowRecorder != nil {
+		if err := shadowRecorder.Flush(context.Background()); err != nil {
+			log.Printf("tap shadow flush: %v", err)
+		}
+	}
 }
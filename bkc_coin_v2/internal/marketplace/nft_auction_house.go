@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"bkc_coin_v2/internal/database"
+	"bkc_coin_v2/internal/jobs"
 	"bkc_coin_v2/internal/nft"
 )
 
@@ -58,9 +59,6 @@ func NewAuctionManager(db *database.UnifiedDB, nftManager *nft.DynamicNFTManager
 		activeAuctions: make(map[int64]*NFTAuction),
 	}
 	
-	// Запускаем обработку аукционов
-	go am.processAuctions()
-	
 	return am
 }
 
@@ -283,14 +281,13 @@ func (am *AuctionManager) transferNFT(ctx context.Context, nftID int64, fromID i
 	return nil
 }
 
-// processAuctions - обработка активных аукционов
-func (am *AuctionManager) processAuctions() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
+// StartAuctionProcessing - завершение истекших аукционов раз в минуту, пока
+// ctx не отменен
+func (am *AuctionManager) StartAuctionProcessing(ctx context.Context) {
+	jobs.Start(ctx, "auction_expiry", time.Minute, func(ctx context.Context) error {
 		am.checkExpiredAuctions()
-	}
+		return nil
+	})
 }
 
 // checkExpiredAuctions - проверка истекших аукционов
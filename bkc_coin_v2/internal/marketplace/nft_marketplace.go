@@ -64,9 +64,12 @@ type NFTMarketplace struct {
 	// Метрики
 	metrics *MarketplaceMetrics
 
-	// Кэш
-	cache   map[string]interface{}
+	// Кэш; просроченные записи вычищает StartCacheJanitor
+	cache   map[string]cacheEntry
 	cacheMu sync.RWMutex
+
+	// Уведомление админов о зависших спорах
+	onDisputeEscalated func(escrow EscrowTransaction)
 }
 
 // Listing объявление на маркетплейсе
@@ -113,6 +116,22 @@ type EscrowTransaction struct {
 	SellerConfirmed bool         `json:"seller_confirmed"`
 	DisputeReason   string       `json:"dispute_reason,omitempty"`
 	AdminNotes      string       `json:"admin_notes,omitempty"`
+
+	// Доставка (только для физических товаров)
+	ItemType        ItemType        `json:"item_type"`
+	Shipment        ShipmentStatus  `json:"shipment_status,omitempty"`
+	Carrier         string          `json:"carrier,omitempty"`
+	TrackingNumber  string          `json:"tracking_number,omitempty"`
+	ShipBy          time.Time       `json:"ship_by,omitempty"`
+	ShippedAt       time.Time       `json:"shipped_at,omitempty"`
+	DeliveredAt     time.Time       `json:"delivered_at,omitempty"`
+	ConfirmDeadline time.Time       `json:"confirm_deadline,omitempty"`
+	TrackingEvents  []TrackingEvent `json:"tracking_events,omitempty"`
+	DisputeEvidence []TrackingEvent `json:"dispute_evidence,omitempty"`
+
+	// Спор и эскалация
+	DisputedAt  time.Time `json:"disputed_at,omitempty"`
+	EscalatedAt time.Time `json:"escalated_at,omitempty"`
 }
 
 // MarketUser пользователь маркетплейса
@@ -134,18 +153,20 @@ type MarketUser struct {
 
 // MarketplaceConfig конфигурация маркетплейса
 type MarketplaceConfig struct {
-	ListingFee           int64         `json:"listing_fee"`
-	EscrowFee            float64       `json:"escrow_fee"`
-	MaxListingDuration   time.Duration `json:"max_listing_duration"`
-	MaxImagesPerListing  int           `json:"max_images_per_listing"`
-	MaxTitleLength       int           `json:"max_title_length"`
-	MaxDescriptionLength int           `json:"max_description_length"`
-	VerificationCost     int64         `json:"verification_cost"`
-	PremiumCost          int64         `json:"premium_cost"`
-	MinRating            float64       `json:"min_rating"`
-	MaxActiveListings    int           `json:"max_active_listings"`
-	EscrowTimeout        time.Duration `json:"escrow_timeout"`
-	DisputeTimeout       time.Duration `json:"dispute_timeout"`
+	ListingFee            int64         `json:"listing_fee"`
+	EscrowFee             float64       `json:"escrow_fee"`
+	MaxListingDuration    time.Duration `json:"max_listing_duration"`
+	MaxImagesPerListing   int           `json:"max_images_per_listing"`
+	MaxTitleLength        int           `json:"max_title_length"`
+	MaxDescriptionLength  int           `json:"max_description_length"`
+	VerificationCost      int64         `json:"verification_cost"`
+	PremiumCost           int64         `json:"premium_cost"`
+	MinRating             float64       `json:"min_rating"`
+	MaxActiveListings     int           `json:"max_active_listings"`
+	EscrowTimeout         time.Duration `json:"escrow_timeout"`
+	DisputeTimeout        time.Duration `json:"dispute_timeout"`
+	ShippingWindow        time.Duration `json:"shipping_window"`         // срок отправки физического товара
+	DeliveryConfirmWindow time.Duration `json:"delivery_confirm_window"` // срок подтверждения получения покупателем
 }
 
 // MarketplaceMetrics метрики маркетплейса
@@ -190,18 +211,20 @@ type PurchaseRequest struct {
 // DefaultMarketplaceConfig конфигурация по умолчанию
 func DefaultMarketplaceConfig() MarketplaceConfig {
 	return MarketplaceConfig{
-		ListingFee:           2000,                // 2000 BKC
-		EscrowFee:            0.02,                // 2%
-		MaxListingDuration:   30 * 24 * time.Hour, // 30 дней
-		MaxImagesPerListing:  10,
-		MaxTitleLength:       100,
-		MaxDescriptionLength: 2000,
-		VerificationCost:     100000, // 100k BKC
-		PremiumCost:          50000,  // 50k BKC
-		MinRating:            3.0,
-		MaxActiveListings:    50,
-		EscrowTimeout:        24 * time.Hour,
-		DisputeTimeout:       7 * 24 * time.Hour,
+		ListingFee:            2000,                // 2000 BKC
+		EscrowFee:             0.02,                // 2%
+		MaxListingDuration:    30 * 24 * time.Hour, // 30 дней
+		MaxImagesPerListing:   10,
+		MaxTitleLength:        100,
+		MaxDescriptionLength:  2000,
+		VerificationCost:      100000, // 100k BKC
+		PremiumCost:           50000,  // 50k BKC
+		MinRating:             3.0,
+		MaxActiveListings:     50,
+		EscrowTimeout:         24 * time.Hour,
+		DisputeTimeout:        7 * 24 * time.Hour,
+		ShippingWindow:        5 * 24 * time.Hour,
+		DeliveryConfirmWindow: 3 * 24 * time.Hour,
 	}
 }
 
@@ -213,7 +236,7 @@ func NewNFTMarketplace(config MarketplaceConfig) *NFTMarketplace {
 		users:    make(map[int64]*MarketUser),
 		config:   config,
 		metrics:  &MarketplaceMetrics{},
-		cache:    make(map[string]interface{}),
+		cache:    make(map[string]cacheEntry),
 	}
 }
 
@@ -342,6 +365,11 @@ func (nm *NFTMarketplace) PurchaseListing(ctx context.Context, req *PurchaseRequ
 		Hash:            nm.generateEscrowHash(listing.ID, req.BuyerID, listing.SellerID),
 		BuyerConfirmed:  false,
 		SellerConfirmed: false,
+		ItemType:        listing.Type,
+	}
+	if listing.Type == ItemTypePhysical {
+		escrow.Shipment = ShipmentAwaiting
+		escrow.ShipBy = escrow.CreatedAt.Add(nm.config.ShippingWindow)
 	}
 
 	// Списание средств с покупателя
@@ -374,6 +402,13 @@ func (nm *NFTMarketplace) ConfirmDelivery(ctx context.Context, escrowID string,
 		return fmt.Errorf(i18n.T(lang, "error_escrow_not_found"))
 	}
 
+	// Физический товар: покупатель подтверждает только после отправки,
+	// продавец подтверждает отправкой (MarkShipped)
+	if escrow.ItemType == ItemTypePhysical && isBuyer && escrow.Shipment == ShipmentAwaiting {
+		nm.escrowMu.Unlock()
+		return fmt.Errorf(i18n.T(lang, "error_not_shipped"))
+	}
+
 	if isBuyer {
 		escrow.BuyerConfirmed = true
 	} else {
@@ -381,42 +416,47 @@ func (nm *NFTMarketplace) ConfirmDelivery(ctx context.Context, escrowID string,
 	}
 
 	// Если обе стороны подтвердили,释放 средства
-	if escrow.BuyerConfirmed && escrow.SellerConfirmed {
-		escrow.Status = EscrowReleased
-		escrow.ReleasedAt = time.Now()
-		escrow.UpdatedAt = time.Now()
+	if escrow.BuyerConfirmed && escrow.SellerConfirmed && escrow.Status == EscrowPending {
+		nm.releaseEscrow(escrow)
+	}
 
-		// Выплата продавцу за вычетом комиссии
-		sellerFee := int64(float64(escrow.Amount) * nm.config.EscrowFee)
-		sellerAmount := escrow.Amount - sellerFee
+	nm.escrowMu.Unlock()
+	return nil
+}
 
-		seller, _ := nm.getOrCreateUser(escrow.SellerID, "")
-		seller.Balance += sellerAmount
-		seller.FrozenBalance -= escrow.Amount
-		seller.TotalSales++
-		nm.updateUser(seller)
+// releaseEscrow выплачивает средства продавцу. Вызывается под escrowMu.
+func (nm *NFTMarketplace) releaseEscrow(escrow *EscrowTransaction) {
+	escrow.Status = EscrowReleased
+	escrow.ReleasedAt = time.Now()
+	escrow.UpdatedAt = time.Now()
 
-		// Разморозка средств покупателя
-		buyer, _ := nm.getOrCreateUser(escrow.BuyerID, "")
-		buyer.FrozenBalance -= escrow.Amount
-		buyer.TotalPurchases++
-		nm.updateUser(buyer)
+	// Выплата продавцу за вычетом комиссии
+	sellerFee := int64(float64(escrow.Amount) * nm.config.EscrowFee)
+	sellerAmount := escrow.Amount - sellerFee
 
-		// Обновление объявления
-		nm.mu.RLock()
-		listing, exists := nm.listings[escrow.ListingID]
-		nm.mu.RUnlock()
-		if exists {
-			listing.Status = StatusSold
-			listing.UpdatedAt = time.Now()
-			nm.updateListing(listing)
-		}
+	seller, _ := nm.getOrCreateUser(escrow.SellerID, "")
+	seller.Balance += sellerAmount
+	seller.FrozenBalance -= escrow.Amount
+	seller.TotalSales++
+	nm.updateUser(seller)
 
-		nm.incrementTotalRevenue(sellerFee)
+	// Разморозка средств покупателя
+	buyer, _ := nm.getOrCreateUser(escrow.BuyerID, "")
+	buyer.FrozenBalance -= escrow.Amount
+	buyer.TotalPurchases++
+	nm.updateUser(buyer)
+
+	// Обновление объявления
+	nm.mu.RLock()
+	listing, exists := nm.listings[escrow.ListingID]
+	nm.mu.RUnlock()
+	if exists {
+		listing.Status = StatusSold
+		listing.UpdatedAt = time.Now()
+		nm.updateListing(listing)
 	}
 
-	nm.escrowMu.Unlock()
-	return nil
+	nm.incrementTotalRevenue(sellerFee)
 }
 
 // CreateDispute создание спора
@@ -441,6 +481,7 @@ func (nm *NFTMarketplace) CreateDispute(ctx context.Context, escrowID string, us
 	escrow.Status = EscrowDisputed
 	escrow.DisputeReason = reason
 	escrow.UpdatedAt = time.Now()
+	escrow.DisputedAt = escrow.UpdatedAt
 
 	nm.incrementDisputedTransactions()
 	return nil
@@ -688,27 +729,44 @@ func (nm *NFTMarketplace) hashFilters(filters ListingFilters) string {
 	return hex.EncodeToString(hash[:8])
 }
 
+// cacheEntry запись кэша со сроком жизни
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
 // Кэш методы
 func (nm *NFTMarketplace) getFromCache(key string) interface{} {
 	nm.cacheMu.RLock()
 	defer nm.cacheMu.RUnlock()
 
-	return nm.cache[key]
+	e, ok := nm.cache[key]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		return nil
+	}
+	return e.value
 }
 
 func (nm *NFTMarketplace) setToCache(key string, value interface{}, ttl time.Duration) {
 	nm.cacheMu.Lock()
 	defer nm.cacheMu.Unlock()
 
-	nm.cache[key] = value
+	nm.cache[key] = cacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+}
+
+// evictExpired удаляет записи кэша, просроченные к now
+func (nm *NFTMarketplace) evictExpired(now time.Time) int {
+	nm.cacheMu.Lock()
+	defer nm.cacheMu.Unlock()
 
-	// Удаление из кэша через TTL
-	go func() {
-		time.Sleep(ttl)
-		nm.cacheMu.Lock()
-		delete(nm.cache, key)
-		nm.cacheMu.Unlock()
-	}()
+	n := 0
+	for key, e := range nm.cache {
+		if !now.Before(e.expiresAt) {
+			delete(nm.cache, key)
+			n++
+		}
+	}
+	return n
 }
 
 func (nm *NFTMarketplace) clearCache(prefix string) {
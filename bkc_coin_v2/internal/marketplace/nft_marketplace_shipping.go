@@ -0,0 +1,174 @@
+package marketplace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/i18n"
+)
+
+// ShipmentStatus этап доставки физического товара
+type ShipmentStatus string
+
+const (
+	ShipmentAwaiting  ShipmentStatus = "awaiting_shipment"
+	ShipmentShipped   ShipmentStatus = "shipped"
+	ShipmentDelivered ShipmentStatus = "delivered"
+)
+
+// TrackingEvent событие отслеживания (от перевозчика, продавца или приложенное к спору)
+type TrackingEvent struct {
+	Status      string    `json:"status"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Source      string    `json:"source"` // carrier|seller|buyer|admin
+	EvidenceURL string    `json:"evidence_url,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// MarkShipped продавец отправил товар и указал трек-номер.
+// Отправка считается подтверждением продавца.
+func (nm *NFTMarketplace) MarkShipped(ctx context.Context, escrowID string, sellerID int64, carrier, trackingNumber string, lang i18n.Language) error {
+	carrier = strings.TrimSpace(carrier)
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if trackingNumber == "" {
+		return fmt.Errorf(i18n.T(lang, "error_tracking_required"))
+	}
+
+	nm.escrowMu.Lock()
+	defer nm.escrowMu.Unlock()
+
+	escrow, err := nm.physicalEscrow(escrowID, lang)
+	if err != nil {
+		return err
+	}
+	if escrow.SellerID != sellerID {
+		return fmt.Errorf(i18n.T(lang, "error_not_participant"))
+	}
+	if escrow.Status != EscrowPending || escrow.Shipment != ShipmentAwaiting {
+		return fmt.Errorf(i18n.T(lang, "error_invalid_shipment_state"))
+	}
+
+	now := time.Now()
+	escrow.Shipment = ShipmentShipped
+	escrow.Carrier = carrier
+	escrow.TrackingNumber = trackingNumber
+	escrow.ShippedAt = now
+	escrow.SellerConfirmed = true
+	escrow.UpdatedAt = now
+	escrow.TrackingEvents = append(escrow.TrackingEvents, TrackingEvent{
+		Status: string(ShipmentShipped),
+		Source: "seller",
+		At:     now,
+	})
+	return nil
+}
+
+// AddTrackingEvent добавляет событие отслеживания. Событие "delivered"
+// переводит сделку в delivered и запускает окно подтверждения покупателем.
+func (nm *NFTMarketplace) AddTrackingEvent(ctx context.Context, escrowID string, ev TrackingEvent, lang i18n.Language) error {
+	ev.Status = strings.ToLower(strings.TrimSpace(ev.Status))
+	if ev.Status == "" {
+		return fmt.Errorf(i18n.T(lang, "error_invalid_tracking_event"))
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+
+	nm.escrowMu.Lock()
+	defer nm.escrowMu.Unlock()
+
+	escrow, err := nm.physicalEscrow(escrowID, lang)
+	if err != nil {
+		return err
+	}
+	if escrow.Shipment == ShipmentAwaiting {
+		return fmt.Errorf(i18n.T(lang, "error_not_shipped"))
+	}
+
+	escrow.TrackingEvents = append(escrow.TrackingEvents, ev)
+	escrow.UpdatedAt = time.Now()
+	if ev.Status == string(ShipmentDelivered) && escrow.Shipment == ShipmentShipped {
+		escrow.Shipment = ShipmentDelivered
+		escrow.DeliveredAt = ev.At
+		escrow.ConfirmDeadline = ev.At.Add(nm.config.DeliveryConfirmWindow)
+	}
+	return nil
+}
+
+// CreateShipmentDispute открывает спор по физическому товару с приложенными
+// доказательствами (события трекинга, фото). Доступно до выплаты продавцу.
+func (nm *NFTMarketplace) CreateShipmentDispute(ctx context.Context, escrowID string, userID int64, reason string, evidence []TrackingEvent, lang i18n.Language) error {
+	nm.escrowMu.Lock()
+	defer nm.escrowMu.Unlock()
+
+	escrow, err := nm.physicalEscrow(escrowID, lang)
+	if err != nil {
+		return err
+	}
+	if escrow.BuyerID != userID && escrow.SellerID != userID {
+		return fmt.Errorf(i18n.T(lang, "error_not_participant"))
+	}
+	if escrow.Status != EscrowPending && escrow.Status != EscrowConfirmed {
+		return fmt.Errorf(i18n.T(lang, "error_cannot_dispute"))
+	}
+
+	source := "buyer"
+	if userID == escrow.SellerID {
+		source = "seller"
+	}
+	now := time.Now()
+	for _, ev := range evidence {
+		if ev.At.IsZero() {
+			ev.At = now
+		}
+		ev.Source = source
+		escrow.DisputeEvidence = append(escrow.DisputeEvidence, ev)
+	}
+
+	escrow.Status = EscrowDisputed
+	escrow.DisputeReason = reason
+	escrow.UpdatedAt = now
+	escrow.DisputedAt = now
+
+	nm.incrementDisputedTransactions()
+	return nil
+}
+
+// ReleaseUnconfirmedDeliveries выплачивает продавцу сделки, где товар доставлен,
+// а покупатель не подтвердил и не открыл спор до ConfirmDeadline.
+func (nm *NFTMarketplace) ReleaseUnconfirmedDeliveries(ctx context.Context, now time.Time) int {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	nm.escrowMu.Lock()
+	defer nm.escrowMu.Unlock()
+
+	released := 0
+	for _, escrow := range nm.escrows {
+		if escrow.ItemType != ItemTypePhysical || escrow.Status != EscrowPending || escrow.Shipment != ShipmentDelivered {
+			continue
+		}
+		if escrow.ConfirmDeadline.IsZero() || now.Before(escrow.ConfirmDeadline) {
+			continue
+		}
+		escrow.BuyerConfirmed = true
+		nm.releaseEscrow(escrow)
+		released++
+	}
+	return released
+}
+
+// physicalEscrow находит escrow физического товара. Вызывается под escrowMu.
+func (nm *NFTMarketplace) physicalEscrow(escrowID string, lang i18n.Language) (*EscrowTransaction, error) {
+	escrow, exists := nm.escrows[escrowID]
+	if !exists {
+		return nil, fmt.Errorf(i18n.T(lang, "error_escrow_not_found"))
+	}
+	if escrow.ItemType != ItemTypePhysical {
+		return nil, fmt.Errorf(i18n.T(lang, "error_not_physical_item"))
+	}
+	return escrow, nil
+}
@@ -0,0 +1,135 @@
+package marketplace
+
+import (
+	"context"
+	"time"
+
+	"bkc_coin_v2/internal/jobs"
+)
+
+// SetDisputeEscalationHandler задает обработчик эскалации споров (например, сообщение админам в бот).
+func (nm *NFTMarketplace) SetDisputeEscalationHandler(fn func(escrow EscrowTransaction)) {
+	nm.escrowMu.Lock()
+	nm.onDisputeEscalated = fn
+	nm.escrowMu.Unlock()
+}
+
+// StartEscrowScheduler периодически применяет EscrowTimeout, окна подтверждения
+// доставки и DisputeTimeout, пока ctx не отменен.
+func (nm *NFTMarketplace) StartEscrowScheduler(ctx context.Context, interval time.Duration) {
+	jobs.Start(ctx, "marketplace_escrow_timeouts", interval, func(ctx context.Context) error {
+		nm.RunEscrowTimeouts(ctx, time.Now())
+		return nil
+	})
+}
+
+// StartCacheJanitor раз в interval удаляет просроченные записи кэша, пока
+// ctx не отменен.
+func (nm *NFTMarketplace) StartCacheJanitor(ctx context.Context, interval time.Duration) {
+	jobs.Start(ctx, "marketplace_cache", interval, func(ctx context.Context) error {
+		nm.evictExpired(time.Now())
+		return nil
+	})
+}
+
+// EscrowTimeoutResult итог одного прохода планировщика.
+type EscrowTimeoutResult struct {
+	Cancelled int `json:"cancelled"`
+	Released  int `json:"released"`
+	Escalated int `json:"escalated"`
+}
+
+// RunEscrowTimeouts выполняет один проход: отмена неподтвержденных сделок
+// с возвратом покупателю, авто-выплата после окна подтверждения доставки
+// и эскалация зависших споров.
+func (nm *NFTMarketplace) RunEscrowTimeouts(ctx context.Context, now time.Time) EscrowTimeoutResult {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return EscrowTimeoutResult{
+		Cancelled: nm.CancelExpiredEscrows(ctx, now),
+		Released:  nm.ReleaseUnconfirmedDeliveries(ctx, now),
+		Escalated: nm.EscalateStaleDisputes(ctx, now),
+	}
+}
+
+// CancelExpiredEscrows отменяет сделки, не подтвержденные за EscrowTimeout
+// (физический товар - не отправленный до ShipBy), и возвращает средства покупателю.
+func (nm *NFTMarketplace) CancelExpiredEscrows(ctx context.Context, now time.Time) int {
+	nm.escrowMu.Lock()
+	defer nm.escrowMu.Unlock()
+
+	cancelled := 0
+	for _, escrow := range nm.escrows {
+		if escrow.Status != EscrowPending {
+			continue
+		}
+		var deadline time.Time
+		if escrow.ItemType == ItemTypePhysical {
+			// Отправленный товар в пути: ждем доставку или спор
+			if escrow.Shipment != ShipmentAwaiting {
+				continue
+			}
+			deadline = escrow.ShipBy
+		} else if nm.config.EscrowTimeout > 0 {
+			deadline = escrow.CreatedAt.Add(nm.config.EscrowTimeout)
+		}
+		if deadline.IsZero() || now.Before(deadline) {
+			continue
+		}
+		nm.refundEscrow(escrow)
+		cancelled++
+	}
+	return cancelled
+}
+
+// EscalateStaleDisputes передает админам споры, не решенные за DisputeTimeout.
+// Каждый спор эскалируется один раз.
+func (nm *NFTMarketplace) EscalateStaleDisputes(ctx context.Context, now time.Time) int {
+	nm.escrowMu.Lock()
+	var stale []EscrowTransaction
+	for _, escrow := range nm.escrows {
+		if escrow.Status != EscrowDisputed || !escrow.EscalatedAt.IsZero() || nm.config.DisputeTimeout <= 0 {
+			continue
+		}
+		openedAt := escrow.DisputedAt
+		if openedAt.IsZero() {
+			openedAt = escrow.UpdatedAt
+		}
+		if now.Before(openedAt.Add(nm.config.DisputeTimeout)) {
+			continue
+		}
+		escrow.EscalatedAt = now
+		escrow.UpdatedAt = now
+		stale = append(stale, *escrow)
+	}
+	notify := nm.onDisputeEscalated
+	nm.escrowMu.Unlock()
+
+	if notify != nil {
+		for _, escrow := range stale {
+			notify(escrow)
+		}
+	}
+	return len(stale)
+}
+
+// refundEscrow возвращает средства покупателю и снова открывает объявление. Вызывается под escrowMu.
+func (nm *NFTMarketplace) refundEscrow(escrow *EscrowTransaction) {
+	escrow.Status = EscrowRefunded
+	escrow.UpdatedAt = time.Now()
+
+	buyer, _ := nm.getOrCreateUser(escrow.BuyerID, "")
+	buyer.Balance += escrow.Amount
+	buyer.FrozenBalance -= escrow.Amount
+	nm.updateUser(buyer)
+
+	nm.mu.RLock()
+	listing, exists := nm.listings[escrow.ListingID]
+	nm.mu.RUnlock()
+	if exists && listing.Status == StatusEscrow {
+		listing.Status = StatusActive
+		listing.UpdatedAt = time.Now()
+		nm.updateListing(listing)
+	}
+}
@@ -3,6 +3,7 @@ package memtap
 import (
 	"context"
 	"errors"
+	"log"
 	"math"
 	"os"
 	"strconv"
@@ -24,6 +25,10 @@ type Engine struct {
 	flushInterval time.Duration
 	systemRefresh time.Duration
 	cacheTTL      time.Duration
+	drainTimeout  time.Duration
+	spillDir      string
+	walDir        string
+	wal           *tapWAL
 
 	startOnce sync.Once
 
@@ -35,16 +40,30 @@ type Engine struct {
 	startRate      int64
 	minRate        int64
 
+	// Global daily emission cap (db.Emission): what may still be minted today,
+	// net of pending deltas; -1 = no cap. The flush is the hard check.
+	emissionDay  string
+	emissionLeft int64
+
 	users map[int64]*userState
 
 	pendingUsers   map[int64]pendingUserDelta
 	pendingDaily   map[dailyKey]int64
 	pendingReserve int64
+	draining       bool
 
 	flushInFlight atomic.Bool
 	lastFlushUnix atomic.Int64
 	flushErrors   atomic.Int64
 	flushCount    atomic.Int64
+
+	// observer sees every credited tap (shadow accounting); set before Start.
+	observer TapObserver
+
+	// Overload signals for admission control.
+	flushStartedNano atomic.Int64
+	lastFlushNanos   atomic.Int64
+	flushStretch     atomic.Int32
 }
 
 type userState struct {
@@ -63,9 +82,14 @@ type userState struct {
 	BoostRegenMul float64
 	BoostMaxMul   float64
 
+	// Utility NFT effects, resolved when the user is loaded into the cache.
+	NFTTapMul      float64
+	NFTEnergyBoost float64
+
 	Day         string
 	DailyTapped int64
 	DailyExtra  int64
+	DailyLimit  db.TapLimit // plan limit, falls back to TAP_DAILY_LIMIT when expired
 
 	LastTouched time.Time
 }
@@ -87,16 +111,35 @@ type TapResult struct {
 	Reason         string
 	Energy         int64
 	EnergyMax      int64
+	DailyLimit     int64 // 0 = unlimited
 	DailyTapped    int64
 	DailyExtra     int64
 	DailyRemaining int64
 }
 
+// ObservedTap is a credited tap with the inputs the reward was computed
+// from.
+type ObservedTap struct {
+	UserID         int64
+	Day            string
+	Taps           int64
+	TapMul         float64 // NFT tap multiplier, >= 1
+	Gained         int64
+	Reserve        int64 // reserve before the mint
+	InitialReserve int64
+}
+
+// TapObserver is called under the engine lock and must not block.
+type TapObserver interface {
+	ObserveTap(t ObservedTap)
+}
+
 type UserSnapshot struct {
 	Balance        int64
 	TapsTotal      int64
 	Energy         int64
 	EnergyMax      int64
+	DailyLimit     int64
 	DailyTapped    int64
 	DailyExtra     int64
 	DailyRemaining int64
@@ -133,6 +176,18 @@ func New(cfg config.Config, database *db.DB) *Engine {
 		cacheTTLSec = 86_400
 	}
 
+	drainTimeoutSec := envInt64("MEMTAP_DRAIN_TIMEOUT_SEC", 8)
+	if drainTimeoutSec < 1 {
+		drainTimeoutSec = 1
+	}
+	if drainTimeoutSec > 60 {
+		drainTimeoutSec = 60
+	}
+	spillDir := strings.TrimSpace(os.Getenv("MEMTAP_SPILL_DIR"))
+	if spillDir == "" {
+		spillDir = "spill"
+	}
+
 	return &Engine{
 		cfg: cfg,
 		db:  database,
@@ -142,6 +197,9 @@ func New(cfg config.Config, database *db.DB) *Engine {
 		flushInterval: time.Duration(flushIntervalMs) * time.Millisecond,
 		systemRefresh: time.Duration(systemRefreshSec) * time.Second,
 		cacheTTL:      time.Duration(cacheTTLSec) * time.Second,
+		drainTimeout:  time.Duration(drainTimeoutSec) * time.Second,
+		spillDir:      spillDir,
+		walDir:        strings.TrimSpace(os.Getenv("MEMTAP_WAL_DIR")),
 
 		users:        map[int64]*userState{},
 		pendingUsers: map[int64]pendingUserDelta{},
@@ -158,6 +216,19 @@ func (e *Engine) Start(ctx context.Context) {
 		return
 	}
 	e.startOnce.Do(func() {
+		// Replay before serving: spilled deltas must land before users are loaded from DB.
+		replayCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		e.replaySpills(replayCtx)
+		if e.walDir != "" {
+			e.replayWAL(replayCtx)
+			w, err := openWAL(e.walDir)
+			if err != nil {
+				log.Printf("memtap: wal disabled: %v", err)
+			} else {
+				e.wal = w
+			}
+		}
+		cancel()
 		go e.loop(ctx)
 	})
 }
@@ -165,18 +236,31 @@ func (e *Engine) Start(ctx context.Context) {
 func (e *Engine) loop(ctx context.Context) {
 	flushTicker := time.NewTicker(e.flushInterval)
 	cleanupTicker := time.NewTicker(60 * time.Second)
+	walSyncTicker := time.NewTicker(walSyncEvery)
 	defer flushTicker.Stop()
 	defer cleanupTicker.Stop()
+	defer walSyncTicker.Stop()
 
+	ticks := int32(0)
 	for {
 		select {
 		case <-ctx.Done():
-			_ = e.Flush(context.Background())
+			// The final flush belongs to Drain, which bounds it and spills the rest.
 			return
 		case <-flushTicker.C:
+			// Under overload flush every N ticks: bigger per-user aggregates, fewer DB writes.
+			ticks++
+			if ticks < e.flushStretch.Load() {
+				continue
+			}
+			ticks = 0
 			_ = e.Flush(ctx)
 		case <-cleanupTicker.C:
 			e.cleanupStaleUsers()
+		case <-walSyncTicker.C:
+			if e.wal != nil {
+				e.wal.sync()
+			}
 		}
 	}
 }
@@ -186,8 +270,9 @@ func (e *Engine) ensureSystem(ctx context.Context) error {
 		return errors.New("memtap disabled")
 	}
 
+	now := time.Now().UTC()
 	e.mu.RLock()
-	loaded := !e.systemLoadedAt.IsZero() && time.Since(e.systemLoadedAt) < e.systemRefresh
+	loaded := !e.systemLoadedAt.IsZero() && time.Since(e.systemLoadedAt) < e.systemRefresh && e.emissionDay == now.Format("2006-01-02")
 	e.mu.RUnlock()
 	if loaded {
 		return nil
@@ -197,6 +282,10 @@ func (e *Engine) ensureSystem(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	em, err := e.db.GetEmission(ctx, now)
+	if err != nil {
+		return err
+	}
 
 	e.mu.Lock()
 	// Keep pending in-memory delta on top of DB reserve value.
@@ -204,6 +293,11 @@ func (e *Engine) ensureSystem(ctx context.Context) error {
 	e.initialReserve = sys.InitialReserve
 	e.startRate = sys.StartRateCoinsUSD
 	e.minRate = sys.MinRateCoinsUSD
+	e.emissionDay = em.Day
+	e.emissionLeft = em.Remaining()
+	if e.emissionLeft > 0 {
+		e.emissionLeft = max(0, e.emissionLeft+e.pendingReserve)
+	}
 	e.systemLoadedAt = time.Now().UTC()
 	e.mu.Unlock()
 	return nil
@@ -225,6 +319,14 @@ func (e *Engine) ensureUser(ctx context.Context, userID int64, username, firstNa
 	if err != nil {
 		return nil, err
 	}
+	eff, err := e.db.ResolveUserNFTEffects(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := e.db.GetUserTapLimit(ctx, userID, e.cfg.TapDailyLimit, now)
+	if err != nil {
+		return nil, err
+	}
 
 	day := now.UTC().Format("2006-01-02")
 	loaded := &userState{
@@ -243,9 +345,13 @@ func (e *Engine) ensureUser(ctx context.Context, userID int64, username, firstNa
 		BoostRegenMul: dbUser.EnergyBoostRegenMultiplier,
 		BoostMaxMul:   dbUser.EnergyBoostMaxMultiplier,
 
+		NFTTapMul:      eff.TapMul(),
+		NFTEnergyBoost: float64(eff.EnergyMaxBoost),
+
 		Day:         day,
 		DailyTapped: ud.Tapped,
 		DailyExtra:  ud.ExtraQuota,
+		DailyLimit:  limit,
 
 		LastTouched: now.UTC(),
 	}
@@ -306,6 +412,10 @@ func (e *Engine) Tap(ctx context.Context, userID int64, username, firstName stri
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// Checked under the lock so no tap lands after Drain snapshots pending deltas.
+	if e.draining {
+		return TapResult{}, errDraining
+	}
 	u := e.users[userID]
 	if u == nil {
 		return TapResult{}, errors.New("user not cached")
@@ -326,9 +436,10 @@ func (e *Engine) Tap(ctx context.Context, userID int64, username, firstName stri
 		mintable = 0
 	}
 
-	dailyMax := e.cfg.TapDailyLimit + u.DailyExtra
+	dailyLimit := e.dailyLimit(u, now)
+	dailyMax := dailyLimit + u.DailyExtra
 	dailyRemaining := int64(1 << 60)
-	if e.cfg.TapDailyLimit > 0 {
+	if dailyLimit > 0 {
 		dailyRemaining = dailyMax - u.DailyTapped
 		if dailyRemaining < 0 {
 			dailyRemaining = 0
@@ -340,18 +451,31 @@ func (e *Engine) Tap(ctx context.Context, userID int64, username, firstName stri
 		availableReserve = 0
 	}
 
-	gained := min4(requested, mintable, dailyRemaining, availableReserve)
-	if gained < 0 {
-		gained = 0
+	// One tap costs one energy and one daily unit; NFT tap multiplier scales coins only.
+	tapMul := u.NFTTapMul
+	if tapMul < 1 {
+		tapMul = 1
+	}
+	tapsByReserve := int64(math.Floor(float64(availableReserve) / tapMul))
+	tapsByEmission := int64(1 << 60)
+	if e.emissionLeft >= 0 {
+		tapsByEmission = int64(math.Floor(float64(e.emissionLeft) / tapMul))
+	}
+	taps := min(min4(requested, mintable, dailyRemaining, tapsByReserve), tapsByEmission)
+	if taps < 0 {
+		taps = 0
 	}
+	gained := int64(math.Floor(float64(taps) * tapMul))
 
 	reason := "ok"
 	if gained == 0 {
 		switch {
-		case e.cfg.TapDailyLimit > 0 && dailyRemaining == 0 && mintable > 0:
+		case dailyLimit > 0 && dailyRemaining == 0 && mintable > 0:
 			reason = "daily_limit"
-		case availableReserve == 0 && mintable > 0:
+		case tapsByReserve == 0 && mintable > 0:
 			reason = "reserve_empty"
+		case tapsByEmission == 0 && mintable > 0:
+			reason = "emission_cap"
 		case mintable <= 0:
 			reason = "no_energy"
 		default:
@@ -360,39 +484,49 @@ func (e *Engine) Tap(ctx context.Context, userID int64, username, firstName stri
 	}
 
 	if gained > 0 {
-		u.Energy -= float64(gained)
-		if u.Energy < 0 {
-			u.Energy = 0
+		energyAfter := math.Max(0, u.Energy-float64(taps))
+		if e.wal != nil {
+			// Durable before it counts: a crash after this point delays the coins, never loses them.
+			if err := e.wal.append(walRecord{UserID: userID, Coins: gained, Taps: taps, Energy: energyAfter, EnergyAt: now.UnixNano(), Day: day}); err != nil {
+				return TapResult{}, err
+			}
 		}
+		u.Energy = energyAfter
 		u.Balance += gained
-		u.TapsTotal += gained
-		u.DailyTapped += gained
+		u.TapsTotal += taps
+		u.DailyTapped += taps
 		u.LastTouched = now
 
 		e.reserve -= gained
 		e.pendingReserve -= gained
+		if e.emissionLeft >= 0 {
+			e.emissionLeft = max(0, e.emissionLeft-gained)
+		}
 
 		pu := e.pendingUsers[userID]
 		pu.BalanceDelta += gained
-		pu.TapsDelta += gained
+		pu.TapsDelta += taps
 		pu.Energy = u.Energy
 		pu.EnergyAt = now
 		e.pendingUsers[userID] = pu
 
 		dk := dailyKey{UserID: userID, Day: day}
-		e.pendingDaily[dk] += gained
-	}
+		e.pendingDaily[dk] += taps
 
-	dailyRemainingOut := dailyMax - u.DailyTapped
-	if dailyRemainingOut < 0 {
-		dailyRemainingOut = 0
+		if e.observer != nil {
+			e.observer.ObserveTap(ObservedTap{UserID: userID, Day: day, Taps: taps, TapMul: tapMul, Gained: gained,
+				Reserve: availableReserve, InitialReserve: e.initialReserve})
+		}
 	}
 
+	dailyRemainingOut := remainingQuota(dailyLimit, u.DailyExtra, u.DailyTapped)
+
 	return TapResult{
 		Gained:         gained,
 		Reason:         reason,
 		Energy:         int64(math.Floor(u.Energy)),
 		EnergyMax:      int64(math.Floor(eMax)),
+		DailyLimit:     dailyLimit,
 		DailyTapped:    u.DailyTapped,
 		DailyExtra:     u.DailyExtra,
 		DailyRemaining: dailyRemainingOut,
@@ -430,17 +564,15 @@ func (e *Engine) SnapshotIfPending(userID int64, now time.Time) (UserSnapshot, b
 	u.Energy = regenEnergy(u.Energy, eMax, regen, u.EnergyUpdatedAt, now)
 	u.EnergyUpdatedAt = now
 
-	dailyMax := e.cfg.TapDailyLimit + u.DailyExtra
-	dailyRemaining := dailyMax - u.DailyTapped
-	if dailyRemaining < 0 {
-		dailyRemaining = 0
-	}
+	dailyLimit := e.dailyLimit(u, now)
+	dailyRemaining := remainingQuota(dailyLimit, u.DailyExtra, u.DailyTapped)
 
 	return UserSnapshot{
 		Balance:        u.Balance,
 		TapsTotal:      u.TapsTotal,
 		Energy:         int64(math.Floor(u.Energy)),
 		EnergyMax:      int64(math.Floor(eMax)),
+		DailyLimit:     dailyLimit,
 		DailyTapped:    u.DailyTapped,
 		DailyExtra:     u.DailyExtra,
 		DailyRemaining: dailyRemaining,
@@ -470,8 +602,15 @@ func (e *Engine) Flush(ctx context.Context) error {
 	}
 	defer e.flushInFlight.Store(false)
 
-	users, daily, reserveDelta := e.snapshotPending()
+	users, daily, reserveDelta, segments, err := e.snapshotPending()
+	if err != nil {
+		e.flushErrors.Add(1)
+		return err
+	}
 	if len(users) == 0 && len(daily) == 0 && reserveDelta == 0 {
+		if e.wal != nil {
+			e.wal.release(segments) // nothing pending: the closed segments hold no taps
+		}
 		return nil
 	}
 
@@ -481,21 +620,48 @@ func (e *Engine) Flush(ctx context.Context) error {
 	ctxTimeout, cancel := context.WithTimeout(ctx, 8*time.Second)
 	defer cancel()
 
-	if err := e.db.ApplyTapAggregates(ctxTimeout, users, daily, reserveDelta, "memtap"); err != nil {
+	started := time.Now()
+	e.flushStartedNano.Store(started.UnixNano())
+	if e.wal != nil {
+		_, err = e.db.ApplyTapAggregatesOnce(ctxTimeout, walBatchIDs(segments), users, daily, reserveDelta, "memtap")
+		if errors.Is(err, db.ErrPartialReplay) {
+			// A previous flush committed but reported failure, so its deltas were merged
+			// back. Only a restart (per-segment WAL replay) can separate them again.
+			log.Printf("memtap: wal segments partially applied, restart to recover: %v", segments)
+		}
+	} else {
+		err = e.db.ApplyTapAggregates(ctxTimeout, users, daily, reserveDelta, "memtap")
+	}
+	e.lastFlushNanos.Store(int64(time.Since(started)))
+	e.flushStartedNano.Store(0)
+	if err != nil {
 		e.mergePending(users, daily, reserveDelta)
 		e.flushErrors.Add(1)
 		return err
 	}
 
+	if e.wal != nil {
+		e.wal.release(segments)
+	}
 	e.lastFlushUnix.Store(time.Now().UTC().Unix())
 	e.flushCount.Add(1)
 	return nil
 }
 
-func (e *Engine) snapshotPending() ([]db.UserTapAggregate, []db.DailyTapAggregate, int64) {
+// snapshotPending takes the pending deltas and, with the WAL on, the closed
+// segments that hold exactly those deltas.
+func (e *Engine) snapshotPending() ([]db.UserTapAggregate, []db.DailyTapAggregate, int64, []string, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	var segments []string
+	if e.wal != nil {
+		var err error
+		if segments, err = e.wal.rotate(); err != nil {
+			return nil, nil, 0, nil, err
+		}
+	}
+
 	users := make([]db.UserTapAggregate, 0, len(e.pendingUsers))
 	for userID, delta := range e.pendingUsers {
 		if userID <= 0 {
@@ -527,7 +693,7 @@ func (e *Engine) snapshotPending() ([]db.UserTapAggregate, []db.DailyTapAggregat
 	e.pendingDaily = map[dailyKey]int64{}
 	e.pendingReserve = 0
 
-	return users, daily, reserveDelta
+	return users, daily, reserveDelta, segments, nil
 }
 
 func (e *Engine) mergePending(users []db.UserTapAggregate, daily []db.DailyTapAggregate, reserveDelta int64) {
@@ -559,6 +725,50 @@ func (e *Engine) mergePending(users []db.UserTapAggregate, daily []db.DailyTapAg
 	e.pendingReserve += reserveDelta
 }
 
+// QueueDepth is the number of users with unflushed tap deltas.
+func (e *Engine) QueueDepth() int64 {
+	if !e.Enabled() {
+		return 0
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return int64(len(e.pendingUsers))
+}
+
+// DBLatency is the duration of the last flush, or the age of the flush in
+// flight when that is longer (a stuck flush must count before it finishes).
+func (e *Engine) DBLatency() time.Duration {
+	if !e.Enabled() {
+		return 0
+	}
+	d := time.Duration(e.lastFlushNanos.Load())
+	if started := e.flushStartedNano.Load(); started > 0 {
+		if inFlight := time.Since(time.Unix(0, started)); inFlight > d {
+			d = inFlight
+		}
+	}
+	return d
+}
+
+// SetObserver registers o to see every credited tap. Call before Start.
+func (e *Engine) SetObserver(o TapObserver) {
+	if !e.Enabled() {
+		return
+	}
+	e.observer = o
+}
+
+// SetFlushStretch makes the background loop flush every n ticks (n <= 1 restores normal).
+func (e *Engine) SetFlushStretch(n int) {
+	if !e.Enabled() {
+		return
+	}
+	if n < 1 {
+		n = 1
+	}
+	e.flushStretch.Store(int32(n))
+}
+
 func (e *Engine) InvalidateUser(userID int64) {
 	if !e.Enabled() || userID <= 0 {
 		return
@@ -618,6 +828,7 @@ func (e *Engine) Stats() map[string]any {
 	out["pending_daily"] = len(e.pendingDaily)
 	out["pending_reserve_delta"] = e.pendingReserve
 	out["reserve_cached"] = e.reserve
+	out["emission_left"] = e.emissionLeft
 	out["system_loaded"] = !e.systemLoadedAt.IsZero()
 	if !e.systemLoadedAt.IsZero() {
 		out["system_loaded_at"] = e.systemLoadedAt.Unix()
@@ -628,11 +839,44 @@ func (e *Engine) Stats() map[string]any {
 	out["last_flush_ts"] = e.lastFlushUnix.Load()
 	out["flush_count"] = e.flushCount.Load()
 	out["flush_errors"] = e.flushErrors.Load()
+	out["last_flush_ms"] = time.Duration(e.lastFlushNanos.Load()).Milliseconds()
+	out["flush_stretch"] = e.flushStretch.Load()
 	return out
 }
 
+// dailyLimit is the user's plan limit while the plan is active, else the base limit.
+func (e *Engine) dailyLimit(u *userState, now time.Time) int64 {
+	if u.DailyLimit.Until.IsZero() || now.Before(u.DailyLimit.Until) {
+		return u.DailyLimit.Limit
+	}
+	return e.cfg.TapDailyLimit
+}
+
+// SetDailyLimit updates a cached user's limit after a plan change.
+func (e *Engine) SetDailyLimit(userID int64, limit db.TapLimit) {
+	if !e.Enabled() || userID <= 0 {
+		return
+	}
+	e.mu.Lock()
+	if u := e.users[userID]; u != nil {
+		u.DailyLimit = limit
+	}
+	e.mu.Unlock()
+}
+
+// remainingQuota is what is left of limit+extra today; unlimited when limit <= 0.
+func remainingQuota(limit, extra, tapped int64) int64 {
+	if limit <= 0 {
+		return math.MaxInt64
+	}
+	if r := limit + extra - tapped; r > 0 {
+		return r
+	}
+	return 0
+}
+
 func energyParams(u *userState, now time.Time, baseRegen float64) (regen float64, eMax float64) {
-	eMax = u.EnergyMax
+	eMax = u.EnergyMax + u.NFTEnergyBoost
 	if eMax <= 0 {
 		eMax = 0
 	}
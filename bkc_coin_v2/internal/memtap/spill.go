@@ -0,0 +1,165 @@
+package memtap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+var errDraining = errors.New("memtap draining")
+
+// spillBatch is a set of pending aggregates that could not be flushed before
+// shutdown. ID makes the replay idempotent (see db.ApplyTapAggregatesOnce).
+type spillBatch struct {
+	ID           string                 `json:"id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Users        []db.UserTapAggregate  `json:"users"`
+	Daily        []db.DailyTapAggregate `json:"daily"`
+	ReserveDelta int64                  `json:"reserve_delta"`
+}
+
+// Drain stops intake, flushes pending aggregates within the drain timeout
+// (or ctx's deadline, whichever is sooner) and spills whatever is left to
+// the spill directory, to be replayed on the next Start.
+// The background loop must already be stopped (its ctx cancelled).
+func (e *Engine) Drain(ctx context.Context) error {
+	if !e.Enabled() {
+		return nil
+	}
+	e.mu.Lock()
+	e.draining = true
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, e.drainTimeout)
+	defer cancel()
+
+	// A periodic flush cancelled by shutdown merges its batch back; wait for it.
+	for e.flushInFlight.Load() && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+	flushErr := ctx.Err()
+	if flushErr == nil {
+		flushErr = e.Flush(ctx)
+	}
+	if e.wal != nil {
+		e.wal.close()
+	}
+	if flushErr == nil {
+		log.Printf("memtap: drained")
+		return nil
+	}
+	if e.wal != nil {
+		// Unflushed deltas are already durable in the WAL and replayed on start.
+		log.Printf("memtap: flush failed (%v), deltas left in wal %s", flushErr, e.walDir)
+		return nil
+	}
+
+	users, daily, reserveDelta, _, err := e.snapshotPending()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 && len(daily) == 0 && reserveDelta == 0 {
+		return flushErr
+	}
+	b := spillBatch{
+		ID:           "memtap_spill:" + time.Now().UTC().Format("20060102T150405") + ":" + randomID(),
+		CreatedAt:    time.Now().UTC(),
+		Users:        users,
+		Daily:        daily,
+		ReserveDelta: reserveDelta,
+	}
+	path, err := writeSpill(e.spillDir, b)
+	if err != nil {
+		e.mergePending(users, daily, reserveDelta)
+		return fmt.Errorf("memtap: flush: %v; spill: %w", flushErr, err)
+	}
+	log.Printf("memtap: flush failed (%v), spilled %d users to %s", flushErr, len(users), path)
+	return nil
+}
+
+// replaySpills applies spill files left by a previous shutdown. Files are
+// removed only after their batch is applied (or found already applied).
+func (e *Engine) replaySpills(ctx context.Context) {
+	paths, err := filepath.Glob(filepath.Join(e.spillDir, "memtap-*.json"))
+	if err != nil || len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		b, err := readSpill(path)
+		if err != nil {
+			log.Printf("memtap: spill %s: %v", path, err)
+			continue
+		}
+		applied, err := e.db.ApplyTapAggregatesOnce(ctx, []string{b.ID}, b.Users, b.Daily, b.ReserveDelta, "memtap_spill")
+		if err != nil {
+			log.Printf("memtap: replay %s: %v (kept for next start)", path, err)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("memtap: remove %s: %v", path, err)
+		}
+		log.Printf("memtap: replayed %s (users=%d applied=%v)", path, len(b.Users), applied)
+	}
+}
+
+func writeSpill(dir string, b spillBatch) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	name := "memtap-" + strings.NewReplacer(":", "-").Replace(strings.TrimPrefix(b.ID, "memtap_spill:")) + ".json"
+	path := filepath.Join(dir, name)
+	tmp, err := os.CreateTemp(dir, ".spill-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+func readSpill(path string) (spillBatch, error) {
+	var b spillBatch
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return b, err
+	}
+	if b.ID == "" {
+		return b, errors.New("spill without id")
+	}
+	return b, nil
+}
+
+func randomID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,42 @@
+package memtap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+func TestSpillRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	in := spillBatch{
+		ID:           "memtap_spill:20240501T120000:abc123",
+		CreatedAt:    at,
+		Users:        []db.UserTapAggregate{{UserID: 7, BalanceDelta: 30, TapsDelta: 30, Energy: 12.5, EnergyUpdatedAt: at}},
+		Daily:        []db.DailyTapAggregate{{UserID: 7, Day: "2024-05-01", TappedDelta: 30}},
+		ReserveDelta: -30,
+	}
+	path, err := writeSpill(dir, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "memtap-20240501T120000-abc123.json" {
+		t.Fatalf("path = %s", path)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, ".spill-*")); len(tmp) != 0 {
+		t.Fatalf("temp files left: %v", tmp)
+	}
+
+	out, err := readSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != in.ID || out.ReserveDelta != in.ReserveDelta || len(out.Users) != 1 || len(out.Daily) != 1 {
+		t.Fatalf("got %+v", out)
+	}
+	if out.Users[0] != in.Users[0] || out.Daily[0] != in.Daily[0] {
+		t.Fatalf("aggregates changed: %+v %+v", out.Users[0], out.Daily[0])
+	}
+}
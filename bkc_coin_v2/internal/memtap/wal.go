@@ -0,0 +1,248 @@
+package memtap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"bkc_coin_v2/internal/db"
+)
+
+// Optional write-ahead log (MEMTAP_WAL_DIR). Every minted tap is appended to
+// the active segment before it touches the in-memory aggregates, so a crash
+// delays coins instead of losing them. Each flush snapshot rotates the
+// segment; closed segments are deleted once a flush that covers them commits.
+// Segment names double as ledger event_ids, which makes replay exactly-once
+// per segment even when the crash happened between commit and delete.
+//
+// Records are written with one write(2) each: that survives a process crash.
+// Surviving a power loss as well needs fsync, done every walSyncEvery.
+
+const walSyncEvery = 200 * time.Millisecond
+
+type walRecord struct {
+	UserID   int64   `json:"u"`
+	Coins    int64   `json:"c"`
+	Taps     int64   `json:"t"`
+	Energy   float64 `json:"e"`
+	EnergyAt int64   `json:"a"` // unix nanos
+	Day      string  `json:"d"`
+}
+
+type tapWAL struct {
+	dir  string
+	boot string
+
+	mu     sync.Mutex
+	seq    int
+	active *os.File
+	name   string
+	closed []string // rotated, not yet covered by a committed flush
+	dirty  bool
+}
+
+func openWAL(dir string) (*tapWAL, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	w := &tapWAL{dir: dir, boot: time.Now().UTC().Format("20060102T150405.000")}
+	if err := w.openSegment(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *tapWAL) openSegment() error {
+	w.seq++
+	name := fmt.Sprintf("wal-%s-%06d.log", w.boot, w.seq)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w.active, w.name = f, name
+	return nil
+}
+
+func (w *tapWAL) append(r walRecord) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active == nil {
+		return errors.New("memtap wal closed")
+	}
+	if _, err := w.active.Write(raw); err != nil {
+		return err
+	}
+	w.dirty = true
+	return nil
+}
+
+// rotate closes the active segment and returns every closed segment that is
+// not yet covered by a committed flush. Called under Engine.mu together with
+// the pending snapshot, so the returned segments hold exactly those deltas.
+func (w *tapWAL) rotate() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active == nil {
+		return append([]string(nil), w.closed...), nil
+	}
+	if err := w.active.Sync(); err != nil {
+		return nil, err
+	}
+	if err := w.active.Close(); err != nil {
+		return nil, err
+	}
+	w.closed = append(w.closed, w.name)
+	w.active, w.dirty = nil, false
+	if err := w.openSegment(); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), w.closed...), nil
+}
+
+// release deletes segments covered by a committed flush.
+func (w *tapWAL) release(names []string) {
+	drop := make(map[string]bool, len(names))
+	for _, n := range names {
+		drop[n] = true
+		if err := os.Remove(filepath.Join(w.dir, n)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("memtap: wal remove %s: %v", n, err)
+		}
+	}
+	w.mu.Lock()
+	kept := w.closed[:0]
+	for _, n := range w.closed {
+		if !drop[n] {
+			kept = append(kept, n)
+		}
+	}
+	w.closed = kept
+	w.mu.Unlock()
+}
+
+func (w *tapWAL) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active == nil || !w.dirty {
+		return
+	}
+	if err := w.active.Sync(); err != nil {
+		log.Printf("memtap: wal sync: %v", err)
+		return
+	}
+	w.dirty = false
+}
+
+// close syncs and closes the active segment; all segments stay on disk.
+func (w *tapWAL) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active == nil {
+		return
+	}
+	_ = w.active.Sync()
+	_ = w.active.Close()
+	w.active = nil
+}
+
+// walBatchIDs maps segment names to their ledger event_ids.
+func walBatchIDs(names []string) []string {
+	ids := make([]string, len(names))
+	for i, n := range names {
+		ids[i] = "memtap_wal:" + n
+	}
+	return ids
+}
+
+// readWALSegment folds a segment into aggregates. A torn final line (crash
+// mid-write) is skipped; energy keeps the last value per user.
+func readWALSegment(path string) ([]db.UserTapAggregate, []db.DailyTapAggregate, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+
+	users := map[int64]*db.UserTapAggregate{}
+	var order []int64
+	daily := map[dailyKey]int64{}
+	var reserve int64
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var r walRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.UserID <= 0 || r.Coins <= 0 {
+			continue
+		}
+		u := users[r.UserID]
+		if u == nil {
+			u = &db.UserTapAggregate{UserID: r.UserID}
+			users[r.UserID] = u
+			order = append(order, r.UserID)
+		}
+		u.BalanceDelta += r.Coins
+		u.TapsDelta += r.Taps
+		u.Energy = r.Energy
+		u.EnergyUpdatedAt = time.Unix(0, r.EnergyAt).UTC()
+		daily[dailyKey{UserID: r.UserID, Day: r.Day}] += r.Taps
+		reserve -= r.Coins
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	outUsers := make([]db.UserTapAggregate, 0, len(order))
+	for _, id := range order {
+		outUsers = append(outUsers, *users[id])
+	}
+	outDaily := make([]db.DailyTapAggregate, 0, len(daily))
+	for k, taps := range daily {
+		outDaily = append(outDaily, db.DailyTapAggregate{UserID: k.UserID, Day: k.Day, TappedDelta: taps})
+	}
+	return outUsers, outDaily, reserve, nil
+}
+
+// replayWAL applies segments left by a previous process, oldest first. It
+// stops at the first failure so per-user energy is never replayed out of order.
+func (e *Engine) replayWAL(ctx context.Context) {
+	paths, err := filepath.Glob(filepath.Join(e.walDir, "wal-*.log"))
+	if err != nil || len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		users, daily, reserve, err := readWALSegment(path)
+		if err != nil {
+			log.Printf("memtap: wal read %s: %v (kept)", name, err)
+			return
+		}
+		applied := false
+		if len(users) > 0 {
+			applied, err = e.db.ApplyTapAggregatesOnce(ctx, walBatchIDs([]string{name}), users, daily, reserve, "memtap_wal")
+			if err != nil {
+				log.Printf("memtap: wal replay %s: %v (kept for next start)", name, err)
+				return
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("memtap: wal remove %s: %v", name, err)
+		}
+		if applied {
+			log.Printf("memtap: wal replayed %s (users=%d reserve_delta=%d)", name, len(users), reserve)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package memtap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWALRotateAndFold(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recs := []walRecord{
+		{UserID: 1, Coins: 5, Taps: 5, Energy: 95, EnergyAt: at.UnixNano(), Day: "2024-05-01"},
+		{UserID: 2, Coins: 3, Taps: 3, Energy: 10, EnergyAt: at.UnixNano(), Day: "2024-05-01"},
+		{UserID: 1, Coins: 4, Taps: 2, Energy: 93, EnergyAt: at.Add(time.Second).UnixNano(), Day: "2024-05-01"},
+	}
+	for _, r := range recs {
+		if err := w.append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := w.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 {
+		t.Fatalf("closed = %v", first)
+	}
+	// A crash mid-write leaves a torn last line; it must not break the fold.
+	f, err := os.OpenFile(filepath.Join(dir, first[0]), os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"u":1,"c":7`)
+	f.Close()
+
+	users, daily, reserve, err := readWALSegment(filepath.Join(dir, first[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserve != -12 || len(users) != 2 || len(daily) != 2 {
+		t.Fatalf("users=%+v daily=%+v reserve=%d", users, daily, reserve)
+	}
+	u1 := users[0]
+	if u1.UserID != 1 || u1.BalanceDelta != 9 || u1.TapsDelta != 7 || u1.Energy != 93 || !u1.EnergyUpdatedAt.Equal(at.Add(time.Second)) {
+		t.Fatalf("user 1 = %+v", u1)
+	}
+
+	// An unreleased segment stays in the next snapshot until a flush commits.
+	second, err := w.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 2 || second[0] != first[0] {
+		t.Fatalf("closed = %v", second)
+	}
+	w.release(second)
+	w.close()
+	left, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(left) != 1 {
+		t.Fatalf("left = %v, want only the (empty) active segment", left)
+	}
+	if ids := walBatchIDs(second); ids[0] != "memtap_wal:"+second[0] {
+		t.Fatalf("ids = %v", ids)
+	}
+}
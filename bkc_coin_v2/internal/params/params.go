@@ -0,0 +1,154 @@
+// Package params serves the runtime fees and limits (db.ParamDefs): the
+// admin override when there is one, else the config default. Overrides are
+// cached per instance, so a lookup on a request path costs no query, and a
+// change made on another instance shows up within TTL.
+package params
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+// TTL is how stale the overrides of one instance may be.
+const TTL = 5 * time.Second
+
+// Store reads the overrides; *db.DB is one.
+type Store interface {
+	ListParams(ctx context.Context) ([]db.ParamValue, error)
+}
+
+// Service looks params up. It is safe for concurrent use.
+type Service struct {
+	store    Store
+	clock    clock.Clock
+	defaults map[string]int64
+
+	mu        sync.Mutex
+	overrides map[string]int64 // nil = not loaded
+	loadedAt  time.Time
+	loading   bool
+}
+
+// Defaults are the config values the params start at.
+func Defaults(cfg config.Config) map[string]int64 {
+	return map[string]int64{
+		db.ParamMarketListingFee:    cfg.MarketListingFeeCoins,
+		db.ParamNFTMarketFeeBP:      cfg.NFTMarketFeeBP,
+		db.ParamNFTRentalFeeBP:      cfg.NFTRentalFeeBP,
+		db.ParamNFTRentalMaxDays:    cfg.NFTRentalMaxDays,
+		db.ParamNFTStakeDailyReward: cfg.NFTStakeDailyReward,
+		db.ParamTipFeeFreeMax:       cfg.TipFeeFreeMax,
+		db.ParamTipFeeBP:            cfg.TipFeeBP,
+		db.ParamTipMaxAmount:        cfg.TipMaxAmount,
+		db.ParamGiftMinAmount:       cfg.GiftMinAmount,
+		db.ParamGiftMaxAmount:       cfg.GiftMaxAmount,
+		db.ParamMerchantFeeBP:       cfg.MerchantFeeBP,
+		db.ParamAffiliateMinPayout:  cfg.AffiliateMinPayout,
+		db.ParamSaleDailyLimit:      cfg.SaleDailyLimit,
+		db.ParamLevelUpReward:       cfg.LevelUpReward,
+		db.ParamBillMaxParticipants: cfg.BillMaxParticipants,
+		db.ParamBillMaxDays:         cfg.BillMaxDays,
+		db.ParamGigMaxMilestones:    cfg.GigMaxMilestones,
+	}
+}
+
+// New serves the params with the defaults of cfg and the overrides in store.
+// It panics if a param of db.ParamDefs has no default.
+func New(cfg config.Config, store Store) *Service {
+	defaults := Defaults(cfg)
+	for _, p := range db.ParamDefs {
+		if _, ok := defaults[p.Key]; !ok {
+			panic("params: no default for " + p.Key)
+		}
+	}
+	return &Service{store: store, clock: clock.System, defaults: defaults}
+}
+
+// Int64 returns the value of key. key must be one of db.ParamDefs.
+func (s *Service) Int64(ctx context.Context, key string) int64 {
+	def, ok := s.defaults[key]
+	if !ok {
+		panic("params: unknown key " + key)
+	}
+	if v, ok := s.current(ctx)[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Invalidate makes the next lookup reload the overrides, so the instance
+// that changed one serves it at once.
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// current returns the overrides. One caller at a time reloads stale ones
+// while the others use the last known; a failed reload keeps them too, and
+// before the first successful load there are none (every param at its
+// default).
+func (s *Service) current(ctx context.Context) map[string]int64 {
+	s.mu.Lock()
+	cached := s.overrides
+	if cached != nil && (s.loading || s.clock.Now().Sub(s.loadedAt) < TTL) {
+		s.mu.Unlock()
+		return cached
+	}
+	if s.loading {
+		s.mu.Unlock()
+		return nil
+	}
+	s.loading = true
+	s.mu.Unlock()
+
+	list, err := s.store.ListParams(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = false
+	if err != nil {
+		log.Printf("params: %v", err)
+		return s.overrides
+	}
+	s.overrides = make(map[string]int64, len(list))
+	for _, p := range list {
+		s.overrides[p.Key] = p.Value
+	}
+	s.loadedAt = s.clock.Now()
+	return s.overrides
+}
+
+// Param is a param as admins see it.
+type Param struct {
+	db.ParamDef
+	Default  int64          `json:"default"`
+	Value    int64          `json:"value"`
+	Override *db.ParamValue `json:"override,omitempty"`
+}
+
+// List returns every param with its current value, read from the store.
+func (s *Service) List(ctx context.Context) ([]Param, error) {
+	list, err := s.store.ListParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]db.ParamValue, len(list))
+	for _, p := range list {
+		overrides[p.Key] = p
+	}
+	out := make([]Param, 0, len(db.ParamDefs))
+	for _, def := range db.ParamDefs {
+		p := Param{ParamDef: def, Default: s.defaults[def.Key], Value: s.defaults[def.Key]}
+		if o, ok := overrides[def.Key]; ok {
+			p.Value, p.Override = o.Value, &o
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
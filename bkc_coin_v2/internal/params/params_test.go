@@ -0,0 +1,90 @@
+package params
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/config"
+	"bkc_coin_v2/internal/db"
+)
+
+type fakeStore struct {
+	values []db.ParamValue
+	err    error
+	calls  int
+}
+
+func (f *fakeStore) ListParams(context.Context) ([]db.ParamValue, error) {
+	f.calls++
+	return f.values, f.err
+}
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{MarketListingFeeCoins: 2_000, TipFeeBP: 100}
+	store := &fakeStore{err: errors.New("down")}
+	s := New(cfg, store)
+	clk := clock.NewManual(time.Now())
+	s.clock = clk
+
+	// The database is down from the start: defaults.
+	if v := s.Int64(ctx, db.ParamMarketListingFee); v != 2_000 {
+		t.Fatalf("default %d", v)
+	}
+
+	store.values, store.err = []db.ParamValue{{Key: db.ParamMarketListingFee, Value: 500}}, nil
+	if v := s.Int64(ctx, db.ParamMarketListingFee); v != 500 {
+		t.Fatalf("override %d", v)
+	}
+	if v := s.Int64(ctx, db.ParamTipFeeBP); v != 100 || store.calls != 2 {
+		t.Fatalf("tip fee %d after %d loads", v, store.calls)
+	}
+
+	// Stale overrides reload; a failed reload keeps the last ones.
+	store.values = nil
+	if v := s.Int64(ctx, db.ParamMarketListingFee); v != 500 {
+		t.Fatalf("cached %d", v)
+	}
+	clk.Advance(TTL)
+	store.err = errors.New("down")
+	if v := s.Int64(ctx, db.ParamMarketListingFee); v != 500 || store.calls != 3 {
+		t.Fatalf("after a failed reload %d (%d loads)", v, store.calls)
+	}
+	store.err = nil
+	s.Invalidate()
+	if v := s.Int64(ctx, db.ParamMarketListingFee); v != 2_000 {
+		t.Fatalf("after the override was reset %d", v)
+	}
+
+	store.values = []db.ParamValue{{Key: db.ParamTipFeeBP, Value: 250}}
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(db.ParamDefs) {
+		t.Fatalf("%d params", len(list))
+	}
+	for _, p := range list {
+		switch {
+		case p.Key == db.ParamTipFeeBP && (p.Value != 250 || p.Default != 100 || p.Override == nil):
+			t.Fatalf("tip fee %+v", p)
+		case p.Key == db.ParamMarketListingFee && (p.Value != 2_000 || p.Override != nil):
+			t.Fatalf("listing fee %+v", p)
+		}
+	}
+}
+
+func TestDefaultsCoverDefs(t *testing.T) {
+	defaults := Defaults(config.Config{})
+	if len(defaults) != len(db.ParamDefs) {
+		t.Fatalf("%d defaults for %d params", len(defaults), len(db.ParamDefs))
+	}
+	for key := range defaults {
+		if _, ok := db.ParamDefOf(key); !ok {
+			t.Errorf("default for unknown param %s", key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package payments
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/faults"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// ChainClient - поиск входящего платежа по заказу в конкретной сети.
+// found=false без ошибки означает, что платеж пока не пришел.
+type ChainClient interface {
+	FindPayment(ctx context.Context, order *PaymentOrder) (txHash string, found bool, err error)
+}
+
+// tonChainClient - проверка платежей в TON
+type tonChainClient struct {
+	clock clock.Clock
+}
+
+func (t tonChainClient) FindPayment(ctx context.Context, order *PaymentOrder) (string, bool, error) {
+	// В реальном приложении здесь будет проверка через TON API
+	// Для примера симулируем проверку
+	if t.clock.Now().Sub(order.CreatedAt) > 30*time.Second {
+		return "simulated_ton_hash", true, nil
+	}
+	return "", false, nil
+}
+
+// solanaChainClient - поиск платежа в Solana по мемо в логах транзакций
+type solanaChainClient struct {
+	client *rpc.Client
+	wallet string
+}
+
+func (s *solanaChainClient) FindPayment(ctx context.Context, order *PaymentOrder) (string, bool, error) {
+	if err := faults.Inject(ctx, faults.SolanaRPC); err != nil {
+		return "", false, err
+	}
+	pubKey, err := solana.PublicKeyFromBase58(s.wallet)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Получаем последние подписи
+	sigs, err := s.client.GetSignaturesForAddress(ctx, pubKey, &rpc.GetSignaturesForAddressOpts{
+		Limit: 10,
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, sig := range sigs {
+		// Проверяем, не слишком ли старая транзакция
+		if sig.BlockTime != nil && time.Now().Unix()-int64(*sig.BlockTime) > 300 {
+			continue
+		}
+
+		// Получаем детальную информацию о транзакции
+		tx, err := s.client.GetTransaction(ctx, sig.Signature, &rpc.GetTransactionOpts{
+			Encoding: solana.EncodingJSON,
+		})
+		if err != nil {
+			continue
+		}
+
+		// Ищем мемо с нашим OrderID
+		if tx != nil && tx.Meta != nil {
+			for _, logMsg := range tx.Meta.LogMessages {
+				if strings.Contains(logMsg, order.Memo) {
+					return sig.Signature.String(), true, nil
+				}
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// newChainClients - клиенты сетей: настоящие или детерминированные заглушки (--mock-chains)
+func newChainClients(config PaymentConfig, network NetworkSettings) map[string]ChainClient {
+	clients := make(map[string]ChainClient)
+	if config.MockChains != nil {
+		mockConfig := *config.MockChains
+		if mockConfig.Clock == nil {
+			mockConfig.Clock = config.Clock
+		}
+		mock := NewMockChainClient(mockConfig)
+		for _, chain := range []string{"ton", "ton_usdt", "solana_usdt"} {
+			clients[chain] = mock
+		}
+		return clients
+	}
+
+	ton := tonChainClient{clock: clock.Or(config.Clock)}
+	clients["ton"] = ton
+	clients["ton_usdt"] = ton
+	if contains(config.EnabledChains, "solana_usdt") {
+		clients["solana_usdt"] = &solanaChainClient{client: rpc.New(network.SolanaRPCURL), wallet: network.SolanaMasterAddress}
+	}
+	return clients
+}
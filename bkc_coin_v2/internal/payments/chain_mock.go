@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+)
+
+// ErrInjectedFailure - сбой RPC, подстроенный заглушкой
+var ErrInjectedFailure = errors.New("mock chain: injected failure")
+
+// MockChainConfig - поведение заглушек сетей в режиме --mock-chains.
+// Все решения детерминированы: одинаковые Seed и OrderID дают одинаковый исход.
+type MockChainConfig struct {
+	ConfirmDelay time.Duration            `json:"confirm_delay"` // через сколько после создания заказа "приходит" платеж
+	ChainDelays  map[string]time.Duration `json:"chain_delays"`  // переопределение задержки для сети
+	FailureRate  float64                  `json:"failure_rate"`  // доля проверок, завершающихся ошибкой RPC
+	DropRate     float64                  `json:"drop_rate"`     // доля заказов, платеж по которым не придет никогда
+	Seed         int64                    `json:"seed"`
+
+	// Clock - часы для ConfirmDelay (nil — системные)
+	Clock clock.Clock `json:"-"`
+}
+
+// MockChainClient - детерминированная заглушка TON/Solana/Helius без ключей и сети.
+type MockChainClient struct {
+	config MockChainConfig
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func NewMockChainClient(config MockChainConfig) *MockChainClient {
+	return &MockChainClient{config: config, attempts: make(map[string]int)}
+}
+
+func (m *MockChainClient) FindPayment(ctx context.Context, order *PaymentOrder) (string, bool, error) {
+	m.mu.Lock()
+	attempt := m.attempts[order.OrderID]
+	m.attempts[order.OrderID] = attempt + 1
+	m.mu.Unlock()
+
+	if m.roll(order.OrderID, fmt.Sprintf("fail:%d", attempt)) < m.config.FailureRate {
+		return "", false, ErrInjectedFailure
+	}
+	if m.roll(order.OrderID, "drop") < m.config.DropRate {
+		return "", false, nil
+	}
+	if clock.Or(m.config.Clock).Now().Sub(order.CreatedAt) < m.delay(order.Chain) {
+		return "", false, nil
+	}
+
+	m.mu.Lock()
+	delete(m.attempts, order.OrderID)
+	m.mu.Unlock()
+	return m.TxHash(order), true, nil
+}
+
+// TxHash - хеш "транзакции", которым заглушка подтверждает заказ
+func (m *MockChainClient) TxHash(order *PaymentOrder) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", m.config.Seed, order.Chain, order.OrderID)))
+	return "mock_" + order.Chain + "_" + hex.EncodeToString(sum[:16])
+}
+
+func (m *MockChainClient) delay(chain string) time.Duration {
+	if d, ok := m.config.ChainDelays[chain]; ok {
+		return d
+	}
+	return m.config.ConfirmDelay
+}
+
+// roll - псевдослучайное число в [0,1) от Seed, заказа и события
+func (m *MockChainClient) roll(orderID, event string) float64 {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", m.config.Seed, orderID, event)))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / float64(1<<53)
+}
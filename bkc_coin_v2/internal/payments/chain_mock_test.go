@@ -0,0 +1,135 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/testenv"
+)
+
+func TestMockChainClientConfirmsAfterDelay(t *testing.T) {
+	m := NewMockChainClient(MockChainConfig{
+		ConfirmDelay: time.Minute,
+		ChainDelays:  map[string]time.Duration{"ton": 0},
+		Seed:         1,
+	})
+	ctx := context.Background()
+
+	fresh := &PaymentOrder{OrderID: "a", Chain: "solana_usdt", CreatedAt: time.Now()}
+	if _, found, err := m.FindPayment(ctx, fresh); found || err != nil {
+		t.Fatalf("fresh order: found=%v err=%v", found, err)
+	}
+	old := &PaymentOrder{OrderID: "b", Chain: "solana_usdt", CreatedAt: time.Now().Add(-2 * time.Minute)}
+	hash, found, err := m.FindPayment(ctx, old)
+	if !found || err != nil || hash != m.TxHash(old) {
+		t.Fatalf("old order: hash=%q found=%v err=%v", hash, found, err)
+	}
+	ton := &PaymentOrder{OrderID: "c", Chain: "ton", CreatedAt: time.Now()}
+	if _, found, _ := m.FindPayment(ctx, ton); !found {
+		t.Fatal("ton order with zero chain delay should confirm at once")
+	}
+}
+
+func TestMockChainClientFailureInjectionIsDeterministic(t *testing.T) {
+	cfg := MockChainConfig{FailureRate: 0.5, DropRate: 0.3, Seed: 42}
+	run := func() []string {
+		m := NewMockChainClient(cfg)
+		var out []string
+		for i := 0; i < 50; i++ {
+			order := &PaymentOrder{OrderID: fmt.Sprintf("o%d", i), Chain: "ton", CreatedAt: time.Now().Add(-time.Hour)}
+			_, found, err := m.FindPayment(context.Background(), order)
+			out = append(out, fmt.Sprintf("%v/%v", found, errors.Is(err, ErrInjectedFailure)))
+		}
+		return out
+	}
+	a, b := run(), run()
+	var failed, confirmed int
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("order %d: %s vs %s", i, a[i], b[i])
+		}
+		switch a[i] {
+		case "false/true":
+			failed++
+		case "true/false":
+			confirmed++
+		}
+	}
+	if failed == 0 || confirmed == 0 || failed+confirmed == len(a) {
+		t.Fatalf("expected a mix of failures, drops and confirmations: failed=%d confirmed=%d", failed, confirmed)
+	}
+}
+
+// Заказы истекают и подтверждаются по часам из конфигурации, без ожидания
+func TestOrdersFollowConfigClock(t *testing.T) {
+	clk := clock.NewManual(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	config := PaymentConfig{OrderTimeout: 15, Clock: clk, MockChains: &MockChainConfig{ConfirmDelay: time.Minute, Seed: 1}}
+	mpm := &MultiChainPaymentManager{config: config, activeOrders: make(map[string]*PaymentOrder)}
+	mock := newChainClients(config, NetworkSettings{})["ton"]
+
+	order := &PaymentOrder{OrderID: "a", Chain: "ton", Status: "pending", CreatedAt: mpm.now(), ExpiresAt: mpm.now().Add(15 * time.Minute)}
+	mpm.activeOrders[order.OrderID] = order
+	if _, found, _ := mock.FindPayment(context.Background(), order); found {
+		t.Fatal("confirmed before ConfirmDelay")
+	}
+	clk.Advance(time.Minute)
+	if _, found, _ := mock.FindPayment(context.Background(), order); !found {
+		t.Fatal("not confirmed after ConfirmDelay")
+	}
+
+	clk.Advance(14 * time.Minute)
+	mpm.checkPendingPayments(context.Background())
+	if order.Status != "pending" {
+		t.Fatalf("at the expiry: %s", order.Status)
+	}
+	clk.Advance(time.Second)
+	mpm.checkPendingPayments(context.Background())
+	if _, active := mpm.activeOrders["a"]; active || order.Status != "expired" {
+		t.Fatalf("after the expiry: %s, active=%v", order.Status, active)
+	}
+}
+
+// blockingChain - сеть, которая не отвечает, пока проверку не отменят
+type blockingChain struct{ calls chan struct{} }
+
+func (b blockingChain) FindPayment(ctx context.Context, order *PaymentOrder) (string, bool, error) {
+	b.calls <- struct{}{}
+	<-ctx.Done()
+	return "", false, ctx.Err()
+}
+
+// Мониторинг и зависшие проверки заканчиваются вместе с ctx
+func TestMonitoringStopsWithContext(t *testing.T) {
+	testenv.NoLeaks(t)
+	chain := blockingChain{calls: make(chan struct{}, 2)}
+	mpm := &MultiChainPaymentManager{
+		activeOrders: map[string]*PaymentOrder{
+			"a": {OrderID: "a", Chain: "ton", Status: "pending", ExpiresAt: time.Now().Add(time.Hour)},
+			"b": {OrderID: "b", Chain: "ton", Status: "pending", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+		chains: map[string]ChainClient{"ton": chain},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mpm.checkPendingPayments(ctx)
+		close(done)
+	}()
+	<-chain.calls
+	<-chain.calls
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkPendingPayments did not return after cancel")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	mpm.StartMonitoring(ctx)
+	cancel()
+}
@@ -14,6 +14,7 @@ type HeliusConfigManager struct {
 	USDTMint     string `json:"usdt_mint"`
 	WebhookURL   string `json:"webhook_url"`
 	ServerURL    string `json:"server_url"`
+	Network      string `json:"network"` // mainnet | sandbox (devnet)
 }
 
 // NewHeliusConfigManager - создание менеджера конфигурации
@@ -61,14 +62,22 @@ func (hcm *HeliusConfigManager) SaveToFile(filename string) error {
 	return nil
 }
 
+// host - хост Helius для выбранной сети
+func (hcm *HeliusConfigManager) host() string {
+	if hcm.Network == NetworkSandbox {
+		return "devnet.helius-rpc.com"
+	}
+	return "mainnet.helius-rpc.com"
+}
+
 // GetRPCURL - получение RPC URL
 func (hcm *HeliusConfigManager) GetRPCURL() string {
-	return fmt.Sprintf("https://mainnet.helius-rpc.com/?api-key=%s", hcm.APIKey)
+	return fmt.Sprintf("https://%s/?api-key=%s", hcm.host(), hcm.APIKey)
 }
 
 // GetWebSocketURL - получение WebSocket URL
 func (hcm *HeliusConfigManager) GetWebSocketURL() string {
-	return fmt.Sprintf("wss://mainnet.helius-rpc.com/?api-key=%s", hcm.APIKey)
+	return fmt.Sprintf("wss://%s/?api-key=%s", hcm.host(), hcm.APIKey)
 }
 
 // GetWebhookURL - получение URL для вебхука
@@ -97,6 +106,10 @@ func (hcm *HeliusConfigManager) Validate() error {
 		return fmt.Errorf("server URL is required")
 	}
 
+	if hcm.Network == NetworkSandbox && hcm.USDTMint == mainnetUSDTMintSolana {
+		return fmt.Errorf("sandbox: mainnet USDT mint configured")
+	}
+
 	return nil
 }
 
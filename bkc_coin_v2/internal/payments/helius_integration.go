@@ -26,6 +26,7 @@ type HeliusIntegration struct {
 type HeliusConfig struct {
 	APIKey      string `json:"api_key"`
 	AdminWallet string `json:"admin_wallet"`
+	Host        string `json:"host"` // mainnet.helius-rpc.com (по умолчанию) или devnet.helius-rpc.com
 }
 
 // HeliusTransaction - структура транзакции от Helius
@@ -47,9 +48,12 @@ type HeliusLogEntry struct {
 
 // NewHeliusIntegration - создание интеграции с Helius
 func NewHeliusIntegration(config HeliusConfig, paymentManager *MultiChainPaymentManager) (*HeliusIntegration, error) {
+	if config.Host == "" {
+		config.Host = "mainnet.helius-rpc.com"
+	}
 	h := &HeliusIntegration{
 		apiKey:         config.APIKey,
-		wsURL:          fmt.Sprintf("wss://mainnet.helius-rpc.com/?api-key=%s", config.APIKey),
+		wsURL:          fmt.Sprintf("wss://%s/?api-key=%s", config.Host, config.APIKey),
 		paymentManager: paymentManager,
 	}
 
@@ -61,7 +65,7 @@ func NewHeliusIntegration(config HeliusConfig, paymentManager *MultiChainPayment
 	h.adminWallet = adminWallet
 
 	// Создаем RPC клиент
-	rpcURL := fmt.Sprintf("https://mainnet.helius-rpc.com/?api-key=%s", config.APIKey)
+	rpcURL := fmt.Sprintf("https://%s/?api-key=%s", config.Host, config.APIKey)
 	h.rpcClient = rpc.New(rpcURL)
 
 	// Проверяем соединение
@@ -89,13 +93,14 @@ func (h *HeliusIntegration) testConnection() error {
 	return nil
 }
 
-// StartWebSocketListener - запуск WebSocket слушателя
-func (h *HeliusIntegration) StartWebSocketListener() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// StartWebSocketListener - запуск WebSocket слушателя; слушает, пока ctx
+// не отменен
+func (h *HeliusIntegration) StartWebSocketListener(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Подключаемся к WebSocket
-	client, err := ws.Connect(ctx, h.wsURL)
+	client, err := ws.Connect(dialCtx, h.wsURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
@@ -110,28 +115,36 @@ func (h *HeliusIntegration) StartWebSocketListener() error {
 	}
 
 	// Запускаем обработчик в отдельной горутине
-	go h.handleWebSocketMessages(sub)
+	go h.handleWebSocketMessages(ctx, sub)
 
 	return nil
 }
 
 // handleWebSocketMessages - обработка WebSocket сообщений
-func (h *HeliusIntegration) handleWebSocketMessages(sub *ws.LogSubscription) {
+func (h *HeliusIntegration) handleWebSocketMessages(ctx context.Context, sub *ws.LogSubscription) {
+	defer sub.Unsubscribe()
 	for {
-		msg, err := sub.Recv()
+		msg, err := sub.Recv(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			log.Printf("WebSocket error: %v", err)
-			time.Sleep(5 * time.Second)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
 
 		// Обрабатываем полученное сообщение
-		go h.processTransactionMessage(msg)
+		go h.processTransactionMessage(ctx, msg)
 	}
 }
 
 // processTransactionMessage - обработка сообщения о транзакции
-func (h *HeliusIntegration) processTransactionMessage(msg interface{}) {
+func (h *HeliusIntegration) processTransactionMessage(ctx context.Context, msg interface{}) {
 	// Конвертируем сообщение в JSON
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
@@ -149,7 +162,7 @@ func (h *HeliusIntegration) processTransactionMessage(msg interface{}) {
 	log.Printf("Received transaction: %s", transaction.Signature)
 
 	// Получаем детальную информацию о транзакции
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	tx, err := h.rpcClient.GetTransaction(ctx, solana.MustSignatureFromBase58(transaction.Signature), &rpc.GetTransactionOpts{
@@ -174,7 +187,7 @@ func (h *HeliusIntegration) processTransactionMessage(msg interface{}) {
 	}
 
 	// Проверяем и подтверждаем платеж
-	err = h.paymentManager.confirmPayment(context.Background(), orderID, transaction.Signature)
+	err = h.paymentManager.confirmPayment(ctx, orderID, transaction.Signature)
 	if err != nil {
 		log.Printf("Failed to confirm payment for order %s: %v", orderID, err)
 		return
@@ -0,0 +1,51 @@
+package payments
+
+import (
+	"strings"
+	"testing"
+)
+
+// Run with: go test ./internal/payments -fuzz FuzzParseHeliusWebhook
+func FuzzParseHeliusWebhook(f *testing.F) {
+	f.Add([]byte(`{"signature":"5sig","slot":1,"blockTime":1700000000,"meta":{},"transaction":{"message":{"instructions":[` +
+		`{"programId":"Memo1UhkJRfHyvLMcVucJwxXeuDx28UQ","parsed":{"type":"memo","info":"BKC_abcdEFGH12+/_1700000000"}}]}}}`))
+	f.Add([]byte(`{"transaction":{"message":{"instructions":[{"programId":"Memo1UhkJRfHyvLMcVucJwxXeuDx28UQ","parsed":{"type":"memo","info":{"x":1}}}]}}}`))
+	f.Add([]byte(`{"transaction":{"message":{"instructions":"nope"}}}`))
+	f.Add([]byte(`{"transaction":[1,2,3],"timestamp":"not a time"}`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		data, err := parseHeliusWebhook(body)
+		if err != nil {
+			return
+		}
+		orderID, err := heliusMemoOrderID(data.Transaction)
+		if err != nil || orderID == "" {
+			return
+		}
+		// Only an order id of ours may reach confirmPayment.
+		if _, ok := orderIDFromMemo("BKC_" + orderID + "_1"); !ok {
+			t.Fatalf("order id %q from %s", orderID, body)
+		}
+	})
+}
+
+func FuzzOrderIDFromMemo(f *testing.F) {
+	mpm := &MultiChainPaymentManager{}
+	f.Add(mpm.generateMemo(mpm.generateOrderID()))
+	f.Add("BKC_abcdEFGH12+/_1700000000")
+	f.Add("BKC_abcdEFGH12+/_")
+	f.Add("BKC__1")
+	f.Add("BKC_a_b_c_1")
+	f.Fuzz(func(t *testing.T, memo string) {
+		orderID, ok := orderIDFromMemo(memo)
+		if !ok {
+			return
+		}
+		if len(orderID) != 12 || strings.ContainsAny(orderID, "_ \n") {
+			t.Fatalf("%q: order id %q", memo, orderID)
+		}
+		if !strings.HasPrefix(memo, "BKC_"+orderID+"_") {
+			t.Fatalf("%q: order id %q not from the memo", memo, orderID)
+		}
+	})
+}
@@ -1,8 +1,10 @@
 package payments
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -34,6 +36,13 @@ type HeliusWebhookResponse struct {
 	Message string `json:"message"`
 }
 
+// maxHeliusWebhookBody - предел тела вебхука; enhanced-транзакции Helius
+// занимают единицы килобайт
+const maxHeliusWebhookBody = 1 << 20
+
+// heliusMemoProgram - программа Memo, в которой приходит мемо заказа
+const heliusMemoProgram = "Memo1UhkJRfHyvLMcVucJwxXeuDx28UQ"
+
 // NewHeliusWebhookHandler - создание обработчика вебхуков
 func NewHeliusWebhookHandler(paymentManager *MultiChainPaymentManager, helius *HeliusIntegration) *HeliusWebhookHandler {
 	return &HeliusWebhookHandler{
@@ -44,10 +53,17 @@ func NewHeliusWebhookHandler(paymentManager *MultiChainPaymentManager, helius *H
 
 // HandleWebhook - обработка входящего вебхука от Helius
 func (hwh *HeliusWebhookHandler) HandleWebhook(c *gin.Context) {
-	var webhookData HeliusWebhookData
-
 	// Декодируем JSON из тела запроса
-	if err := c.ShouldBindJSON(&webhookData); err != nil {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxHeliusWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, HeliusWebhookResponse{
+			Status:  "error",
+			Message: "Body too large",
+		})
+		return
+	}
+	webhookData, err := parseHeliusWebhook(body)
+	if err != nil {
 		log.Printf("Failed to decode webhook data: %v", err)
 		c.JSON(http.StatusBadRequest, HeliusWebhookResponse{
 			Status:  "error",
@@ -59,7 +75,7 @@ func (hwh *HeliusWebhookHandler) HandleWebhook(c *gin.Context) {
 	log.Printf("Received Helius webhook: %s", webhookData.Signature)
 
 	// Обрабатываем транзакцию
-	err := hwh.processWebhookTransaction(webhookData)
+	err = hwh.processWebhookTransaction(c.Request.Context(), webhookData)
 	if err != nil {
 		log.Printf("Failed to process webhook transaction: %v", err)
 		c.JSON(http.StatusInternalServerError, HeliusWebhookResponse{
@@ -77,7 +93,7 @@ func (hwh *HeliusWebhookHandler) HandleWebhook(c *gin.Context) {
 }
 
 // processWebhookTransaction - обработка транзакции из вебхука
-func (hwh *HeliusWebhookHandler) processWebhookTransaction(webhookData HeliusWebhookData) error {
+func (hwh *HeliusWebhookHandler) processWebhookTransaction(ctx context.Context, webhookData HeliusWebhookData) error {
 	// Извлекаем OrderID из транзакции
 	orderID, err := hwh.extractOrderIDFromWebhookData(webhookData)
 	if err != nil {
@@ -101,7 +117,7 @@ func (hwh *HeliusWebhookHandler) processWebhookTransaction(webhookData HeliusWeb
 	}
 
 	// Подтверждаем платеж
-	err = hwh.paymentManager.confirmPayment(c.Request.Context(), orderID, webhookData.Signature)
+	err = hwh.paymentManager.confirmPayment(ctx, orderID, webhookData.Signature)
 	if err != nil {
 		return fmt.Errorf("failed to confirm payment: %w", err)
 	}
@@ -110,10 +126,26 @@ func (hwh *HeliusWebhookHandler) processWebhookTransaction(webhookData HeliusWeb
 	return nil
 }
 
+// parseHeliusWebhook - разбор тела вебхука; тело приходит извне, поэтому
+// любой мусор должен давать ошибку, а не панику
+func parseHeliusWebhook(body []byte) (HeliusWebhookData, error) {
+	var webhookData HeliusWebhookData
+	if err := json.Unmarshal(body, &webhookData); err != nil {
+		return HeliusWebhookData{}, err
+	}
+	return webhookData, nil
+}
+
 // extractOrderIDFromWebhookData - извлечение OrderID из данных вебхука
 func (hwh *HeliusWebhookHandler) extractOrderIDFromWebhookData(webhookData HeliusWebhookData) (string, error) {
+	return heliusMemoOrderID(webhookData.Transaction)
+}
+
+// heliusMemoOrderID - OrderID из мемо транзакции ("" если мемо нет или оно
+// не наше)
+func heliusMemoOrderID(transaction interface{}) (string, error) {
 	// Конвертируем транзакцию в JSON для анализа
-	txData, err := json.Marshal(webhookData.Transaction)
+	txData, err := json.Marshal(transaction)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal transaction: %w", err)
 	}
@@ -145,8 +177,10 @@ func (hwh *HeliusWebhookHandler) extractOrderIDFromWebhookData(webhookData Heliu
 		}
 
 		// Проверяем что это Memo программ
-		if memoInstruction.ProgramID == "Memo1UhkJRfHyvLMcVucJwxXeuDx28UQ" && memoInstruction.Parsed.Type == "memo" {
-			return memoInstruction.Parsed.Info, nil
+		if memoInstruction.ProgramID == heliusMemoProgram && memoInstruction.Parsed.Type == "memo" {
+			if orderID, ok := orderIDFromMemo(memoInstruction.Parsed.Info); ok {
+				return orderID, nil
+			}
 		}
 	}
 
@@ -201,7 +235,7 @@ func (hwh *HeliusWebhookHandler) HandleTestWebhook(c *gin.Context) {
 	}
 
 	// Обрабатываем тестовые данные
-	err := hwh.processWebhookTransaction(testData)
+	err := hwh.processWebhookTransaction(c.Request.Context(), testData)
 	if err != nil {
 		log.Printf("Test webhook processing failed: %v", err)
 		c.JSON(http.StatusInternalServerError, HeliusWebhookResponse{
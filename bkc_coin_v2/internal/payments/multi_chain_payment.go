@@ -11,22 +11,30 @@ import (
 	"sync"
 	"time"
 
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/currency"
 	"bkc_coin_v2/internal/database"
-
-	"github.com/gagliardetto/solana-go"
-	"github.com/gagliardetto/solana-go/rpc"
+	"bkc_coin_v2/internal/jobs"
 )
 
 // MultiChainPaymentManager - менеджер мультицепочечных платежей
 type MultiChainPaymentManager struct {
 	db              *database.UnifiedDB
 	config          PaymentConfig
+	network         NetworkSettings
 	tonWallet       string
 	solanaWallet    string
 	activeOrders    map[string]*PaymentOrder
 	orderMutex      sync.RWMutex
-	solanaClient    *rpc.Client
+	chains          map[string]ChainClient
 	commissionRates CommissionConfig
+	quoter          BKCQuoter
+}
+
+// BKCQuoter - конвертация оплаты в BKC по кривой курса резерва
+// (db.CurveQuoter; тот же курс, что у депозитов и CryptoPay)
+type BKCQuoter interface {
+	CoinsFor(ctx context.Context, currency string, amount float64) (int64, error)
 }
 
 // PaymentConfig - конфигурация платежей (только TON и USDT)
@@ -39,6 +47,18 @@ type PaymentConfig struct {
 	MinAmount           float64  `json:"min_amount"`
 	MaxAmount           float64  `json:"max_amount"`
 	OrderTimeout        int      `json:"order_timeout"` // в минутах
+	HeliusAPIKey        string   `json:"helius_api_key"`
+
+	// Network выбирает сеть: mainnet (поля выше) или sandbox (Solana devnet + TON testnet)
+	Network string          `json:"network"`
+	Sandbox NetworkSettings `json:"sandbox"`
+
+	// MockChains включает детерминированные заглушки вместо TON/Solana/Helius
+	// (staging и интеграционные тесты, ключи не нужны)
+	MockChains *MockChainConfig `json:"mock_chains,omitempty"`
+
+	// Clock - часы сроков заказов (nil — системные; в тестах clock.Manual)
+	Clock clock.Clock `json:"-"`
 }
 
 // CommissionConfig - конфигурация комиссий
@@ -54,8 +74,9 @@ type CommissionConfig struct {
 type PaymentOrder struct {
 	OrderID         string                 `json:"order_id"`
 	UserID          int64                  `json:"user_id"`
-	Type            string                 `json:"type"`  // purchase, withdrawal, nft, market
-	Chain           string                 `json:"chain"` // ton, ton_usdt, solana_usdt
+	Type            string                 `json:"type"`    // purchase, withdrawal, nft, market
+	Chain           string                 `json:"chain"`   // ton, ton_usdt, solana_usdt
+	Network         string                 `json:"network"` // mainnet, sandbox
 	Amount          float64                `json:"amount"`
 	Currency        string                 `json:"currency"`
 	BKCAmount       int64                  `json:"bkc_amount"`
@@ -93,32 +114,38 @@ type PaymentResponse struct {
 
 // NewMultiChainPaymentManager - создание менеджера платежей
 func NewMultiChainPaymentManager(db *database.UnifiedDB, config PaymentConfig) *MultiChainPaymentManager {
-	mpm := &MultiChainPaymentManager{
-		db:           db,
-		config:       config,
-		tonWallet:    config.TONMasterAddress,
-		solanaWallet: config.SolanaMasterAddress,
-		activeOrders: make(map[string]*PaymentOrder),
-		commissionRates: CommissionConfig{
-			PlatformCommission: 2.5,  // 2.5% комиссии платформы
-			NFTCommission:      5.0,  // 5% за NFT транзакции
-			MarketCommission:   3.0,  // 3% за маркетплейс
-			ReferralCommission: 10.0, // 10% от комиссии идет рефералам
-			MinCommission:      100,  // минимальная комиссия 100 BKC
-		},
+	network, err := config.ActiveNetwork()
+	if err != nil {
+		// Не запускаемся с реквизитами основной сети в sandbox
+		panic(err)
 	}
 
-	// Инициализируем Solana клиент
-	if contains(config.EnabledChains, "solana_usdt") {
-		mpm.solanaClient = rpc.New(rpc.MainNetBeta_RPC)
+	mpm := &MultiChainPaymentManager{
+		db:              db,
+		config:          config,
+		network:         network,
+		tonWallet:       network.TONMasterAddress,
+		solanaWallet:    network.SolanaMasterAddress,
+		activeOrders:    make(map[string]*PaymentOrder),
+		commissionRates: network.Commission,
 	}
 
-	// Запускаем мониторинг платежей
-	go mpm.startPaymentMonitoring()
+	// Инициализируем клиенты сетей; мониторинг запускает StartMonitoring
+	mpm.chains = newChainClients(config, network)
 
 	return mpm
 }
 
+// now - текущее время по часам из конфигурации
+func (mpm *MultiChainPaymentManager) now() time.Time {
+	return clock.Or(mpm.config.Clock).Now()
+}
+
+// SetQuoter - подключение курса BKC (без него конвертация недоступна)
+func (mpm *MultiChainPaymentManager) SetQuoter(q BKCQuoter) {
+	mpm.quoter = q
+}
+
 // CreatePaymentOrder - создание заказа на оплату
 func (mpm *MultiChainPaymentManager) CreatePaymentOrder(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
 	// Валидация запроса
@@ -130,7 +157,7 @@ func (mpm *MultiChainPaymentManager) CreatePaymentOrder(ctx context.Context, req
 	orderID := mpm.generateOrderID()
 
 	// Конвертируем в BKC
-	bkcAmount, err := mpm.convertToBKC(req.Amount, req.Currency, req.Chain)
+	bkcAmount, err := mpm.convertToBKC(ctx, req.Amount, req.Currency, req.Chain)
 	if err != nil {
 		return nil, fmt.Errorf("conversion failed: %w", err)
 	}
@@ -146,11 +173,13 @@ func (mpm *MultiChainPaymentManager) CreatePaymentOrder(ctx context.Context, req
 	}
 
 	// Создаем заказ
+	now := mpm.now()
 	order := &PaymentOrder{
 		OrderID:    orderID,
 		UserID:     req.UserID,
 		Type:       req.Type,
 		Chain:      req.Chain,
+		Network:    mpm.network.Name,
 		Amount:     req.Amount,
 		Currency:   req.Currency,
 		BKCAmount:  bkcAmount,
@@ -159,11 +188,18 @@ func (mpm *MultiChainPaymentManager) CreatePaymentOrder(ctx context.Context, req
 		Status:     "pending",
 		Commission: commission,
 		NetAmount:  netAmount,
-		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(time.Duration(mpm.config.OrderTimeout) * time.Minute),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Duration(mpm.config.OrderTimeout) * time.Minute),
 		Metadata:   req.Metadata,
 	}
 
+	// Генерируем URL для оплаты до сохранения: сумма, которую нельзя
+	// выразить в единицах сети, не должна оставить заказ
+	paymentURL, qrCode, instructions, err := mpm.generatePaymentURL(order)
+	if err != nil {
+		return nil, fmt.Errorf("payment url: %w", err)
+	}
+
 	// Сохраняем заказ
 	err = mpm.savePaymentOrder(ctx, order)
 	if err != nil {
@@ -175,9 +211,6 @@ func (mpm *MultiChainPaymentManager) CreatePaymentOrder(ctx context.Context, req
 	mpm.activeOrders[orderID] = order
 	mpm.orderMutex.Unlock()
 
-	// Генерируем URL для оплаты
-	paymentURL, qrCode, instructions := mpm.generatePaymentURL(order)
-
 	response := &PaymentResponse{
 		OrderID:      orderID,
 		PaymentURL:   paymentURL,
@@ -216,27 +249,21 @@ func (mpm *MultiChainPaymentManager) validatePaymentRequest(req PaymentRequest)
 	return nil
 }
 
-// convertToBKC - конвертация валюты в BKC
-func (mpm *MultiChainPaymentManager) convertToBKC(amount float64, currency, chain string) (int64, error) {
-	// Курсы конвертации (в реальном приложении берутся из API)
-	var rate float64
-
+// convertToBKC - конвертация валюты в BKC по текущей точке кривой резерва
+func (mpm *MultiChainPaymentManager) convertToBKC(ctx context.Context, amount float64, currency, chain string) (int64, error) {
 	switch chain {
 	case "ton":
-		// TON -> BKC (1 TON = 1000 BKC)
-		rate = 1000.0
-	case "ton_usdt":
-		// USDT -> BKC (1 USDT = 1000 BKC)
-		rate = 1000.0
-	case "solana_usdt":
-		// USDT -> BKC (1 USDT = 1000 BKC)
-		rate = 1000.0
+		currency = "TON"
+	case "ton_usdt", "solana_usdt":
+		currency = "USDT"
 	default:
 		return 0, fmt.Errorf("unsupported chain for conversion: %s", chain)
 	}
+	if mpm.quoter == nil {
+		return 0, fmt.Errorf("bkc rate unavailable")
+	}
 
-	bkcAmount := int64(amount * rate)
-	return bkcAmount, nil
+	return mpm.quoter.CoinsFor(ctx, currency, amount)
 }
 
 // calculateCommission - расчет комиссии
@@ -283,33 +310,64 @@ func (mpm *MultiChainPaymentManager) generateOrderID() string {
 
 // generateMemo - генерация мемо для транзакции
 func (mpm *MultiChainPaymentManager) generateMemo(orderID string) string {
-	return fmt.Sprintf("BKC_%s_%d", orderID, time.Now().Unix())
+	return fmt.Sprintf("BKC_%s_%d", orderID, mpm.now().Unix())
 }
 
-// generatePaymentURL - генерация URL для оплаты
-func (mpm *MultiChainPaymentManager) generatePaymentURL(order *PaymentOrder) (string, string, map[string]string) {
-	var paymentURL, qrCode string
-	instructions := make(map[string]string)
+// orderIDFromMemo - OrderID из мемо generateMemo; false для чужого или
+// испорченного мемо
+func orderIDFromMemo(memo string) (string, bool) {
+	rest, ok := strings.CutPrefix(memo, "BKC_")
+	if !ok {
+		return "", false
+	}
+	i := strings.LastIndexByte(rest, '_')
+	if i < 0 {
+		return "", false
+	}
+	orderID, ts := rest[:i], rest[i+1:]
+	if len(orderID) != 12 || strings.Trim(orderID, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/") != "" {
+		return "", false
+	}
+	if ts == "" || strings.Trim(ts, "0123456789") != "" {
+		return "", false
+	}
+	return orderID, true
+}
+
+// generatePaymentURL - генерация URL для оплаты; сумма переводится в
+// единицы сети (нанотоны, микро-USDT) через currency
+func (mpm *MultiChainPaymentManager) generatePaymentURL(order *PaymentOrder) (string, string, map[string]string, error) {
+	asset, ok := currency.ForChain(order.Chain)
+	if !ok {
+		return "", "", nil, fmt.Errorf("unsupported chain: %s", order.Chain)
+	}
+	units, err := asset.FromFloat(order.Amount)
+	if err != nil {
+		return "", "", nil, err
+	}
 
+	var paymentURL, qrCode string
+	var instructions map[string]string
 	switch order.Chain {
 	case "ton":
-		paymentURL, qrCode, instructions = mpm.generateTONPaymentURL(order)
+		paymentURL, qrCode, instructions = mpm.generateTONPaymentURL(order, units)
 	case "ton_usdt":
-		paymentURL, qrCode, instructions = mpm.generateTONUSDTURL(order)
+		paymentURL, qrCode, instructions = mpm.generateTONUSDTURL(order, units)
 	case "solana_usdt":
-		paymentURL, qrCode, instructions = mpm.generateSolanaUSDTURL(order)
+		paymentURL, qrCode, instructions = mpm.generateSolanaUSDTURL(order, units)
+	}
+	if mpm.network.IsSandbox() {
+		instructions["network"] = fmt.Sprintf("Sandbox: Solana %s / TON %s, test funds only", mpm.network.SolanaCluster, mpm.network.TONNetwork)
 	}
 
-	return paymentURL, qrCode, instructions
+	return paymentURL, qrCode, instructions, nil
 }
 
-// generateTONPaymentURL - генерация URL для оплаты в TON
-func (mpm *MultiChainPaymentManager) generateTONPaymentURL(order *PaymentOrder) (string, string, map[string]string) {
-	amount := int64(order.Amount * 1000000000) // конвертация в нанотоны
-
+// generateTONPaymentURL - генерация URL для оплаты в TON; nano - сумма в нанотонах
+func (mpm *MultiChainPaymentManager) generateTONPaymentURL(order *PaymentOrder, nano int64) (string, string, map[string]string) {
 	// Формируем deep link для TON
 	paymentURL := fmt.Sprintf("ton://transfer/%s?amount=%d&text=%s",
-		order.Recipient, amount, order.Memo)
+		order.Recipient, nano, order.Memo)
 
 	qrCode := paymentURL
 
@@ -317,20 +375,17 @@ func (mpm *MultiChainPaymentManager) generateTONPaymentURL(order *PaymentOrder)
 		"step1": "Click the payment button or scan QR code",
 		"step2": "Confirm transaction in your TON wallet",
 		"step3": "Wait for confirmation (usually 10-30 seconds)",
-		"note":  fmt.Sprintf("Amount: %.2f TON", order.Amount),
+		"note":  "Amount: " + currency.TON.Format(nano) + " TON",
 	}
 
 	return paymentURL, qrCode, instructions
 }
 
-// generateTONUSDTURL - генерация URL для оплаты в USDT (TON)
-func (mpm *MultiChainPaymentManager) generateTONUSDTURL(order *PaymentOrder) (string, string, map[string]string) {
-	// Для USDT в TON используем jetton transfer
-	amount := int64(order.Amount * 1000000) // USDT имеет 6 decimals
-
-	// Формируем deep link для TON с jetton transfer
+// generateTONUSDTURL - генерация URL для оплаты в USDT (TON); micro - сумма в микро-USDT
+func (mpm *MultiChainPaymentManager) generateTONUSDTURL(order *PaymentOrder, micro int64) (string, string, map[string]string) {
+	// Для USDT в TON используем jetton transfer: amount в единицах jetton
 	paymentURL := fmt.Sprintf("ton://transfer/%s?amount=%d&text=%s&jetton=%s",
-		order.Recipient, amount, order.Memo, mpm.config.USDTContractTON)
+		order.Recipient, micro, order.Memo, mpm.network.USDTContractTON)
 
 	qrCode := paymentURL
 
@@ -338,20 +393,21 @@ func (mpm *MultiChainPaymentManager) generateTONUSDTURL(order *PaymentOrder) (st
 		"step1": "Click the payment button or scan QR code",
 		"step2": "Confirm USDT transfer in your TON wallet",
 		"step3": "Wait for confirmation (usually 10-30 seconds)",
-		"note":  fmt.Sprintf("Amount: %.2f USDT", order.Amount),
+		"note":  "Amount: " + currency.USDT.Format(micro) + " USDT",
 	}
 
 	return paymentURL, qrCode, instructions
 }
 
-// generateSolanaUSDTURL - генерация URL для оплаты в USDT (Solana)
-func (mpm *MultiChainPaymentManager) generateSolanaUSDTURL(order *PaymentOrder) (string, string, map[string]string) {
+// generateSolanaUSDTURL - генерация URL для оплаты в USDT (Solana); micro - сумма в микро-USDT
+func (mpm *MultiChainPaymentManager) generateSolanaUSDTURL(order *PaymentOrder, micro int64) (string, string, map[string]string) {
 	recipient := order.Recipient
-	usdtMint := mpm.config.USDTMintSolana
-	amount := order.Amount // USDT в Solana имеет 6 decimals, но для Solana Pay используем прямое значение
+	usdtMint := mpm.network.USDTMintSolana
+	// Solana Pay принимает amount в отображаемых единицах токена, не в микро-USDT
+	amount := currency.USDT.Format(micro)
 
 	// Формируем Solana Pay URL
-	paymentURL := fmt.Sprintf("solana:%s?amount=%.6f&spl-token=%s&memo=%s&label=BKC%%20Purchase&reference=%s",
+	paymentURL := fmt.Sprintf("solana:%s?amount=%s&spl-token=%s&memo=%s&label=BKC%%20Purchase&reference=%s",
 		recipient, amount, usdtMint, order.Memo, order.OrderID)
 
 	qrCode := paymentURL
@@ -361,101 +417,63 @@ func (mpm *MultiChainPaymentManager) generateSolanaUSDTURL(order *PaymentOrder)
 		"step2": "Choose your wallet (Phantom, Trust, MetaMask, etc.)",
 		"step3": "Confirm USDT transfer in your Solana wallet",
 		"step4": "Wait for confirmation (usually 2-5 seconds)",
-		"note":  fmt.Sprintf("Amount: %.2f USDT", order.Amount),
+		"note":  "Amount: " + amount + " USDT",
 	}
 
 	return paymentURL, qrCode, instructions
 }
 
-// startPaymentMonitoring - запуск мониторинга платежей
-func (mpm *MultiChainPaymentManager) startPaymentMonitoring() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		mpm.checkPendingPayments()
-	}
+// StartMonitoring - мониторинг ожидающих платежей раз в 10 секунд, пока ctx
+// не отменен
+func (mpm *MultiChainPaymentManager) StartMonitoring(ctx context.Context) {
+	jobs.Start(ctx, "payment_monitoring", 10*time.Second, func(ctx context.Context) error {
+		mpm.checkPendingPayments(ctx)
+		return nil
+	})
 }
 
-// checkPendingPayments - проверка ожидающих платежей
-func (mpm *MultiChainPaymentManager) checkPendingPayments() {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	mpm.orderMutex.RLock()
+// checkPendingPayments - проверка ожидающих платежей; ждет проверки по
+// всем заказам (не дольше ctx)
+func (mpm *MultiChainPaymentManager) checkPendingPayments(ctx context.Context) {
+	now := mpm.now()
+	mpm.orderMutex.Lock()
 	pendingOrders := make([]*PaymentOrder, 0)
-	for _, order := range mpm.activeOrders {
-		if order.Status == "pending" && time.Now().Before(order.ExpiresAt) {
-			pendingOrders = append(pendingOrders, order)
+	for orderID, order := range mpm.activeOrders {
+		if order.Status != "pending" {
+			continue
+		}
+		if now.After(order.ExpiresAt) {
+			order.Status = "expired"
+			delete(mpm.activeOrders, orderID)
+			log.Printf("Payment order expired: %s", orderID)
+			continue
 		}
+		pendingOrders = append(pendingOrders, order)
 	}
-	mpm.orderMutex.RUnlock()
+	mpm.orderMutex.Unlock()
 
+	var wg sync.WaitGroup
 	for _, order := range pendingOrders {
-		switch order.Chain {
-		case "ton", "ton_usdt":
-			go mpm.checkTONPayment(ctx, order)
-		case "solana_usdt":
-			go mpm.checkSolanaPayment(ctx, order)
+		if client, ok := mpm.chains[order.Chain]; ok {
+			wg.Add(1)
+			go func(order *PaymentOrder) {
+				defer wg.Done()
+				mpm.checkPayment(ctx, client, order)
+			}(order)
 		}
 	}
+	wg.Wait()
 }
 
-// checkTONPayment - проверка платежа в TON
-func (mpm *MultiChainPaymentManager) checkTONPayment(ctx context.Context, order *PaymentOrder) {
-	// В реальном приложении здесь будет проверка через TON API
-	// Для примера симулируем проверку
-
-	// Симуляция: с вероятностью 10% платеж найден
-	if time.Now().Sub(order.CreatedAt) > 30*time.Second {
-		// Подтверждаем платеж
-		mpm.confirmPayment(ctx, order.OrderID, "simulated_ton_hash")
-	}
-}
-
-// checkSolanaPayment - проверка платежа в Solana
-func (mpm *MultiChainPaymentManager) checkSolanaPayment(ctx context.Context, order *PaymentOrder) {
-	if mpm.solanaClient == nil {
-		return
-	}
-
-	pubKey := solana.MustPublicKeyFromBase58(mpm.solanaWallet)
-
-	// Получаем последние подписи
-	sigs, err := mpm.solanaClient.GetSignaturesForAddress(ctx, pubKey, &rpc.GetSignaturesForAddressOpts{
-		Limit: 10,
-	})
-
+// checkPayment - проверка платежа по заказу в его сети
+func (mpm *MultiChainPaymentManager) checkPayment(ctx context.Context, client ChainClient, order *PaymentOrder) {
+	txHash, found, err := client.FindPayment(ctx, order)
 	if err != nil {
-		log.Printf("Failed to get Solana signatures: %v", err)
+		log.Printf("Failed to check %s payment %s: %v", order.Chain, order.OrderID, err)
 		return
 	}
-
-	for _, sig := range sigs {
-		// Проверяем, не слишком ли старая транзакция
-		if sig.BlockTime != nil && time.Now().Unix()-*sig.BlockTime > 300 {
-			continue
-		}
-
-		// Получаем детальную информацию о транзакции
-		tx, err := mpm.solanaClient.GetTransaction(ctx, sig.Signature, &rpc.GetTransactionOpts{
-			Encoding: solana.EncodingJSON,
-		})
-
-		if err != nil {
-			continue
-		}
-
-		// Ищем мемо с нашим OrderID
-		if tx != nil && tx.Meta != nil {
-			for _, logMsg := range tx.Meta.LogMessages {
-				if strings.Contains(logMsg, order.Memo) {
-					// Подтверждаем платеж
-					mpm.confirmPayment(ctx, order.OrderID, sig.Signature.String())
-					return
-				}
-			}
-		}
+	if found {
+		mpm.confirmPayment(ctx, order.OrderID, txHash)
 	}
 }
 
@@ -476,7 +494,7 @@ func (mpm *MultiChainPaymentManager) confirmPayment(ctx context.Context, orderID
 	// Обновляем статус заказа
 	order.Status = "confirmed"
 	order.TransactionHash = transactionHash
-	confirmedAt := time.Now()
+	confirmedAt := mpm.now()
 	order.ConfirmedAt = &confirmedAt
 
 	// Начисляем BKC пользователю (временное решение)
@@ -608,6 +626,7 @@ func (mpm *MultiChainPaymentManager) GetPaymentStats(ctx context.Context) (map[s
 	stats["pending_orders"] = pendingCount
 	stats["total_volume_bkc"] = totalVolume
 	stats["supported_chains"] = mpm.config.EnabledChains
+	stats["network"] = mpm.network.Name
 	stats["commission_rates"] = mpm.commissionRates
 
 	return stats, nil
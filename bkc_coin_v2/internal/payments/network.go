@@ -0,0 +1,183 @@
+package payments
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Сети, в которых работают платежи
+const (
+	NetworkMainnet = "mainnet"
+	NetworkSandbox = "sandbox" // Solana devnet + TON testnet
+)
+
+// Известные адреса основной сети: в sandbox их появление означает ошибку конфигурации
+const (
+	mainnetUSDTMintSolana = "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB"
+	mainnetUSDTJettonTON  = "EQCxE6mUtQJKFnGfaROTKOt1lZbDiiX1kCixRv7Nw2Id_sDs"
+)
+
+// NetworkSettings - параметры платежей в одной сети: RPC, кошельки, токены, комиссии
+type NetworkSettings struct {
+	Name                string           `json:"name"`
+	SolanaCluster       string           `json:"solana_cluster"` // mainnet-beta | devnet
+	SolanaRPCURL        string           `json:"solana_rpc_url"`
+	HeliusAPIKey        string           `json:"helius_api_key"`
+	HeliusHost          string           `json:"helius_host"` // mainnet.helius-rpc.com | devnet.helius-rpc.com
+	TONNetwork          string           `json:"ton_network"` // mainnet | testnet
+	TONAPIURL           string           `json:"ton_api_url"`
+	TONMasterAddress    string           `json:"ton_master_address"`
+	SolanaMasterAddress string           `json:"solana_master_address"`
+	USDTContractTON     string           `json:"usdt_contract_ton"`
+	USDTMintSolana      string           `json:"usdt_mint_solana"`
+	Commission          CommissionConfig `json:"commission"`
+}
+
+// IsSandbox - тестовая ли сеть
+func (n NetworkSettings) IsSandbox() bool {
+	return n.Name == NetworkSandbox
+}
+
+// HeliusRPCURL - RPC Helius для сети
+func (n NetworkSettings) HeliusRPCURL() string {
+	return fmt.Sprintf("https://%s/?api-key=%s", n.HeliusHost, n.HeliusAPIKey)
+}
+
+// HeliusWSURL - WebSocket Helius для сети
+func (n NetworkSettings) HeliusWSURL() string {
+	return fmt.Sprintf("wss://%s/?api-key=%s", n.HeliusHost, n.HeliusAPIKey)
+}
+
+// defaultCommission - комиссии по умолчанию
+func defaultCommission() CommissionConfig {
+	return CommissionConfig{
+		PlatformCommission: 2.5,  // 2.5% комиссии платформы
+		NFTCommission:      5.0,  // 5% за NFT транзакции
+		MarketCommission:   3.0,  // 3% за маркетплейс
+		ReferralCommission: 10.0, // 10% от комиссии идет рефералам
+		MinCommission:      100,  // минимальная комиссия 100 BKC
+	}
+}
+
+// ActiveNetwork - настройки выбранной сети. Основная сеть собирается из
+// полей PaymentConfig, sandbox - из PaymentConfig.Sandbox с проверкой,
+// что в нее не попали реквизиты основной сети.
+func (c PaymentConfig) ActiveNetwork() (NetworkSettings, error) {
+	switch strings.ToLower(strings.TrimSpace(c.Network)) {
+	case "", NetworkMainnet:
+		return c.mainnet(), nil
+	case NetworkSandbox:
+		n := c.Sandbox
+		n.Name = NetworkSandbox
+		setDefault(&n.SolanaCluster, "devnet")
+		setDefault(&n.SolanaRPCURL, "https://api.devnet.solana.com")
+		setDefault(&n.HeliusHost, "devnet.helius-rpc.com")
+		setDefault(&n.TONNetwork, "testnet")
+		setDefault(&n.TONAPIURL, "https://testnet.tonapi.io/v2")
+		if n.Commission == (CommissionConfig{}) {
+			n.Commission = defaultCommission()
+		}
+		if err := c.checkSandbox(n); err != nil {
+			return NetworkSettings{}, err
+		}
+		return n, nil
+	}
+	return NetworkSettings{}, fmt.Errorf("unknown payments network: %q", c.Network)
+}
+
+func (c PaymentConfig) mainnet() NetworkSettings {
+	return NetworkSettings{
+		Name:                NetworkMainnet,
+		SolanaCluster:       "mainnet-beta",
+		SolanaRPCURL:        "https://api.mainnet-beta.solana.com",
+		HeliusAPIKey:        c.HeliusAPIKey,
+		HeliusHost:          "mainnet.helius-rpc.com",
+		TONNetwork:          "mainnet",
+		TONAPIURL:           "https://tonapi.io/v2",
+		TONMasterAddress:    c.TONMasterAddress,
+		SolanaMasterAddress: c.SolanaMasterAddress,
+		USDTContractTON:     c.USDTContractTON,
+		USDTMintSolana:      c.USDTMintSolana,
+		Commission:          defaultCommission(),
+	}
+}
+
+// checkSandbox - защита от реквизитов основной сети в sandbox
+func (c PaymentConfig) checkSandbox(n NetworkSettings) error {
+	main := c.mainnet()
+	if n.TONMasterAddress == "" || n.SolanaMasterAddress == "" {
+		return fmt.Errorf("sandbox: separate TON and Solana wallets are required")
+	}
+	if !isTONTestnetAddress(n.TONMasterAddress) {
+		return fmt.Errorf("sandbox: TON wallet %s is not a testnet address (expected kQ.../0Q...)", n.TONMasterAddress)
+	}
+	same := map[string][2]string{
+		"TON wallet":        {n.TONMasterAddress, main.TONMasterAddress},
+		"Solana wallet":     {n.SolanaMasterAddress, main.SolanaMasterAddress},
+		"USDT jetton (TON)": {n.USDTContractTON, main.USDTContractTON},
+		"USDT mint":         {n.USDTMintSolana, main.USDTMintSolana},
+		"Helius API key":    {n.HeliusAPIKey, main.HeliusAPIKey},
+	}
+	for what, pair := range same {
+		if pair[0] != "" && pair[0] == pair[1] {
+			return fmt.Errorf("sandbox: %s is the mainnet one", what)
+		}
+	}
+	if n.USDTMintSolana == mainnetUSDTMintSolana || n.USDTContractTON == mainnetUSDTJettonTON {
+		return fmt.Errorf("sandbox: mainnet USDT token configured")
+	}
+	for _, url := range []string{n.SolanaRPCURL, n.HeliusHost} {
+		if strings.Contains(strings.ToLower(url), "mainnet") {
+			return fmt.Errorf("sandbox: mainnet endpoint %s", url)
+		}
+	}
+	if !strings.Contains(strings.ToLower(n.TONAPIURL), "testnet") {
+		return fmt.Errorf("sandbox: TON API %s is not a testnet endpoint", n.TONAPIURL)
+	}
+	if n.SolanaCluster != "devnet" && n.SolanaCluster != "testnet" {
+		return fmt.Errorf("sandbox: Solana cluster must be devnet or testnet, got %s", n.SolanaCluster)
+	}
+	if n.TONNetwork != "testnet" {
+		return fmt.Errorf("sandbox: TON network must be testnet, got %s", n.TONNetwork)
+	}
+	return nil
+}
+
+// isTONTestnetAddress - user-friendly адрес TON с флагом testnet (kQ/0Q)
+func isTONTestnetAddress(addr string) bool {
+	return strings.HasPrefix(addr, "kQ") || strings.HasPrefix(addr, "0Q")
+}
+
+func setDefault(v *string, def string) {
+	if strings.TrimSpace(*v) == "" {
+		*v = def
+	}
+}
+
+// SandboxSettingsFromEnv - настройки sandbox из переменных SANDBOX_*
+func SandboxSettingsFromEnv() NetworkSettings {
+	env := func(key string) string { return strings.TrimSpace(os.Getenv(key)) }
+	n := NetworkSettings{
+		SolanaCluster:       env("SANDBOX_SOLANA_CLUSTER"),
+		SolanaRPCURL:        env("SANDBOX_SOLANA_RPC_URL"),
+		HeliusAPIKey:        env("SANDBOX_HELIUS_API_KEY"),
+		TONAPIURL:           env("SANDBOX_TON_API_URL"),
+		TONMasterAddress:    env("SANDBOX_TON_WALLET"),
+		SolanaMasterAddress: env("SANDBOX_SOLANA_WALLET"),
+		USDTContractTON:     env("SANDBOX_USDT_CONTRACT_TON"),
+		USDTMintSolana:      env("SANDBOX_USDT_MINT_SOLANA"),
+	}
+	if v, err := strconv.ParseFloat(env("SANDBOX_PLATFORM_COMMISSION"), 64); err == nil {
+		n.Commission = defaultCommission()
+		n.Commission.PlatformCommission = v
+	}
+	if v, err := strconv.ParseInt(env("SANDBOX_MIN_COMMISSION"), 10, 64); err == nil {
+		if n.Commission == (CommissionConfig{}) {
+			n.Commission = defaultCommission()
+		}
+		n.Commission.MinCommission = v
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package payments
+
+import "testing"
+
+func TestActiveNetworkSandboxGuardrails(t *testing.T) {
+	base := PaymentConfig{
+		TONMasterAddress:    "UQmainTonWallet",
+		SolanaMasterAddress: "MainSolanaWallet",
+		USDTMintSolana:      mainnetUSDTMintSolana,
+		HeliusAPIKey:        "main-key",
+		Network:             NetworkSandbox,
+		Sandbox: NetworkSettings{
+			TONMasterAddress:    "kQtestTonWallet",
+			SolanaMasterAddress: "DevSolanaWallet",
+			USDTMintSolana:      "DevMint",
+			HeliusAPIKey:        "dev-key",
+		},
+	}
+	n, err := base.ActiveNetwork()
+	if err != nil {
+		t.Fatalf("valid sandbox rejected: %v", err)
+	}
+	if n.SolanaCluster != "devnet" || n.TONNetwork != "testnet" || n.HeliusHost != "devnet.helius-rpc.com" {
+		t.Fatalf("sandbox defaults not applied: %+v", n)
+	}
+
+	bad := map[string]func(c *PaymentConfig){
+		"mainnet TON wallet":   func(c *PaymentConfig) { c.Sandbox.TONMasterAddress = "UQmainTonWallet" },
+		"same Solana wallet":   func(c *PaymentConfig) { c.Sandbox.SolanaMasterAddress = "MainSolanaWallet" },
+		"mainnet USDT mint":    func(c *PaymentConfig) { c.Sandbox.USDTMintSolana = mainnetUSDTMintSolana },
+		"mainnet Helius key":   func(c *PaymentConfig) { c.Sandbox.HeliusAPIKey = "main-key" },
+		"mainnet RPC":          func(c *PaymentConfig) { c.Sandbox.SolanaRPCURL = "https://api.mainnet-beta.solana.com" },
+		"mainnet TON API":      func(c *PaymentConfig) { c.Sandbox.TONAPIURL = "https://tonapi.io/v2" },
+		"missing wallets":      func(c *PaymentConfig) { c.Sandbox.SolanaMasterAddress = "" },
+		"unknown network name": func(c *PaymentConfig) { c.Network = "devnet" },
+	}
+	for name, mutate := range bad {
+		c := base
+		mutate(&c)
+		if _, err := c.ActiveNetwork(); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
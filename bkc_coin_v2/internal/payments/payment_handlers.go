@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"time"
 
+	"bkc_coin_v2/internal/currency"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -146,13 +148,21 @@ func (ph *PaymentHandlers) CancelPayment(c *gin.Context) {
 
 // GetSupportedChains - получение поддерживаемых цепочек (только TON и USDT)
 func (ph *PaymentHandlers) GetSupportedChains(c *gin.Context) {
+	// BKC за 1 единицу по текущей точке кривой резерва
+	rates := map[string]float64{}
+	for key, chain := range map[string]string{"TON": "ton", "USDT_TON": "ton_usdt", "USDT_SOLANA": "solana_usdt"} {
+		if coins, err := ph.paymentManager.convertToBKC(c.Request.Context(), 1, "", chain); err == nil {
+			rates[key] = float64(coins)
+		}
+	}
+
 	chains := map[string]interface{}{
 		"chains": []map[string]interface{}{
 			{
 				"id":          "ton",
 				"name":        "TON",
 				"currency":    "TON",
-				"decimals":    9,
+				"decimals":    currency.TON.Decimals,
 				"description": "Native TON cryptocurrency",
 				"icon":        "/icons/ton.png",
 			},
@@ -160,7 +170,7 @@ func (ph *PaymentHandlers) GetSupportedChains(c *gin.Context) {
 				"id":          "ton_usdt",
 				"name":        "USDT (TON)",
 				"currency":    "USDT",
-				"decimals":    6,
+				"decimals":    currency.USDT.Decimals,
 				"description": "USDT on TON blockchain",
 				"icon":        "/icons/usdt-ton.png",
 			},
@@ -168,16 +178,12 @@ func (ph *PaymentHandlers) GetSupportedChains(c *gin.Context) {
 				"id":          "solana_usdt",
 				"name":        "USDT (Solana)",
 				"currency":    "USDT",
-				"decimals":    6,
+				"decimals":    currency.USDT.Decimals,
 				"description": "USDT on Solana blockchain",
 				"icon":        "/icons/usdt-sol.png",
 			},
 		},
-		"rates": map[string]float64{
-			"TON":         1000.0, // 1 TON = 1000 BKC
-			"USDT_TON":    1000.0, // 1 USDT = 1000 BKC
-			"USDT_SOLANA": 1000.0, // 1 USDT = 1000 BKC
-		},
+		"rates":  rates,
 		"notice": "Only TON and USDT are supported. No fiat currencies are available.",
 	}
 
@@ -309,7 +315,7 @@ func (ph *PaymentHandlers) ValidatePayment(c *gin.Context) {
 	}
 
 	// Конвертация и расчет комиссии
-	bkcAmount, err := ph.paymentManager.convertToBKC(req.Amount, req.Currency, req.Chain)
+	bkcAmount, err := ph.paymentManager.convertToBKC(c.Request.Context(), req.Amount, req.Currency, req.Chain)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"valid": false,
@@ -326,7 +332,7 @@ func (ph *PaymentHandlers) ValidatePayment(c *gin.Context) {
 		"bkc_amount":    bkcAmount,
 		"commission":    commission,
 		"net_amount":    netAmount,
-		"exchange_rate": 1000.0, // 1 USDT/TON = 1000 BKC
+		"exchange_rate": float64(bkcAmount) / req.Amount, // средний курс по кривой резерва
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -375,7 +381,7 @@ func (ph *PaymentHandlers) EstimatePayment(c *gin.Context) {
 	}
 
 	// Расчет
-	bkcAmount, err := ph.paymentManager.convertToBKC(amount, currency, chain)
+	bkcAmount, err := ph.paymentManager.convertToBKC(c.Request.Context(), amount, currency, chain)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Conversion failed"})
 		return
@@ -0,0 +1,128 @@
+package reserves
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Source reads treasury balances from one chain. USD is filled in by the
+// caller.
+type Source interface {
+	Balances(ctx context.Context) ([]Asset, error)
+}
+
+// TON reads the native TON balance and, if Jetton is set, the USDT jetton
+// balance of Address through tonapi.
+type TON struct {
+	APIURL  string // e.g. https://tonapi.io/v2
+	APIKey  string
+	Address string
+	Jetton  string // USDT jetton master; "" = skip
+	HTTP    *http.Client
+}
+
+func (t TON) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(t.APIURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if t.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.APIKey)
+	}
+	client := t.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reserves: tonapi %s: http %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (t TON) Balances(ctx context.Context) ([]Asset, error) {
+	var acc struct {
+		Balance json.Number `json:"balance"`
+	}
+	if err := t.get(ctx, "/accounts/"+url.PathEscape(t.Address), &acc); err != nil {
+		return nil, err
+	}
+	out := []Asset{asset("ton", "TON", t.Address, acc.Balance.String(), 9)}
+	if t.Jetton != "" {
+		var jb struct {
+			Balance string `json:"balance"`
+			Jetton  struct {
+				Decimals int `json:"decimals"`
+			} `json:"jetton"`
+		}
+		if err := t.get(ctx, "/accounts/"+url.PathEscape(t.Address)+"/jettons/"+url.PathEscape(t.Jetton), &jb); err != nil {
+			return nil, err
+		}
+		out = append(out, asset("ton", "USDT", t.Address, jb.Balance, jb.Jetton.Decimals))
+	}
+	return out, nil
+}
+
+// Solana reads the USDT (SPL token Mint) balance held by Owner across its
+// token accounts.
+type Solana struct {
+	RPCURL string
+	Owner  string
+	Mint   string
+}
+
+func (s Solana) Balances(ctx context.Context) ([]Asset, error) {
+	owner, err := solana.PublicKeyFromBase58(s.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("reserves: solana owner: %w", err)
+	}
+	mint, err := solana.PublicKeyFromBase58(s.Mint)
+	if err != nil {
+		return nil, fmt.Errorf("reserves: solana mint: %w", err)
+	}
+	client := rpc.New(s.RPCURL)
+	accounts, err := client.GetTokenAccountsByOwner(ctx, owner, &rpc.GetTokenAccountsConfig{Mint: &mint}, &rpc.GetTokenAccountsOpts{Commitment: rpc.CommitmentFinalized})
+	if err != nil {
+		return nil, err
+	}
+	total := new(big.Int)
+	decimals := 6
+	for _, a := range accounts.Value {
+		bal, err := client.GetTokenAccountBalance(ctx, a.Pubkey, rpc.CommitmentFinalized)
+		if err != nil {
+			return nil, err
+		}
+		if bal == nil || bal.Value == nil {
+			continue
+		}
+		n, ok := new(big.Int).SetString(bal.Value.Amount, 10)
+		if !ok {
+			return nil, fmt.Errorf("reserves: bad token amount %q", bal.Value.Amount)
+		}
+		total.Add(total, n)
+		decimals = int(bal.Value.Decimals)
+	}
+	return []Asset{asset("solana", "USDT", s.Owner, total.String(), decimals)}, nil
+}
+
+func asset(chain, currency, address, raw string, decimals int) Asset {
+	a := Asset{Chain: chain, Currency: currency, Address: address, Raw: raw, Decimals: decimals}
+	if n, ok := new(big.Float).SetString(raw); ok {
+		scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+		a.Amount, _ = new(big.Float).Quo(n, scale).Float64()
+	}
+	return a
+}
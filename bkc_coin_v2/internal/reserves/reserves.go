@@ -0,0 +1,175 @@
+// Package reserves builds proof-of-reserves reports: treasury balances read
+// from TON and Solana next to what the platform owes its users, signed with
+// an ed25519 key so a published report cannot be altered afterwards.
+//
+// User liabilities are committed to with a Merkle tree. A user checks their
+// own inclusion by hashing their leaf and walking the proof path up to the
+// report's root:
+//
+//	leaf = sha256(0x00 || salt || be64(user_id) || be64(coins))
+//	node = sha256(0x01 || left || right)
+//
+// The per-user salt is only given to that user, so sibling hashes in a
+// proof do not reveal other users' balances. A node without a sibling is
+// carried up unchanged.
+package reserves
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Asset is one treasury balance.
+type Asset struct {
+	Chain    string  `json:"chain"`    // ton | solana
+	Currency string  `json:"currency"` // TON | USDT
+	Address  string  `json:"address"`
+	Raw      string  `json:"raw"` // integer amount in the smallest unit
+	Decimals int     `json:"decimals"`
+	Amount   float64 `json:"amount"`
+	USD      float64 `json:"usd"`
+}
+
+// Liabilities are user balances (available plus frozen) at the snapshot.
+type Liabilities struct {
+	Users       int64   `json:"users"`
+	Coins       int64   `json:"coins"`
+	Frozen      int64   `json:"frozen"` // part of Coins held for withdrawals and orders
+	CoinsPerUSD int64   `json:"coins_per_usd"`
+	USD         float64 `json:"usd"`
+	MerkleRoot  string  `json:"merkle_root"`
+}
+
+// Report is the signed content.
+type Report struct {
+	GeneratedAt time.Time   `json:"generated_at"`
+	Assets      []Asset     `json:"assets"`
+	AssetsUSD   float64     `json:"assets_usd"`
+	Liabilities Liabilities `json:"liabilities"`
+	Ratio       float64     `json:"ratio"` // assets / liabilities in USD; 0 without liabilities
+}
+
+// Fill computes the totals from Assets and Liabilities.
+func (r *Report) Fill() {
+	r.AssetsUSD = 0
+	for _, a := range r.Assets {
+		r.AssetsUSD += a.USD
+	}
+	if r.Liabilities.CoinsPerUSD > 0 {
+		r.Liabilities.USD = float64(r.Liabilities.Coins) / float64(r.Liabilities.CoinsPerUSD)
+	}
+	r.Ratio = 0
+	if r.Liabilities.USD > 0 {
+		r.Ratio = r.AssetsUSD / r.Liabilities.USD
+	}
+}
+
+// KeyFromHex reads an ed25519 key from a hex-encoded 32-byte seed.
+func KeyFromHex(s string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("reserves: signing key must be a hex-encoded 32-byte seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// UserSalt derives the salt of userID's leaf from the report's secret.
+func UserSalt(secret []byte, userID int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(userID))
+	mac.Write(b[:])
+	return mac.Sum(nil)[:16]
+}
+
+// Leaf hashes one user's balance.
+func Leaf(salt []byte, userID, coins int64) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(salt)
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(userID))
+	binary.BigEndian.PutUint64(b[8:], uint64(coins))
+	h.Write(b[:])
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+func node(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Levels builds the tree from leaf hashes (concatenated, 32 bytes each).
+// Levels[0] are the leaves and the last level is the 32-byte root; an empty
+// tree has a zero root.
+func Levels(leaves []byte) [][]byte {
+	if len(leaves) == 0 {
+		return [][]byte{make([]byte, 32)}
+	}
+	levels := [][]byte{leaves}
+	for cur := leaves; len(cur) > 32; {
+		n := len(cur) / 32
+		next := make([]byte, 0, (n+1)/2*32)
+		for i := 0; i < n; i += 2 {
+			if i+1 == n {
+				next = append(next, cur[i*32:(i+1)*32]...)
+				continue
+			}
+			next = append(next, node(cur[i*32:(i+1)*32], cur[(i+1)*32:(i+2)*32])...)
+		}
+		levels = append(levels, next)
+		cur = next
+	}
+	return levels
+}
+
+// Step is one proof step: the sibling hash and whether it is on the left.
+type Step struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// SiblingIndex is the position of the sibling of node index at one level.
+func SiblingIndex(index int64) int64 {
+	return index ^ 1
+}
+
+// Path builds the proof of the leaf at index from the sibling found at each
+// level, bottom up (nil where the node was carried up without one).
+func Path(index int64, siblings [][]byte) []Step {
+	var path []Step
+	for _, sib := range siblings {
+		if sib != nil {
+			path = append(path, Step{Hash: hex.EncodeToString(sib), Left: index%2 == 1})
+		}
+		index /= 2
+	}
+	return path
+}
+
+// Verify walks path up from leaf and reports whether it reaches root.
+func Verify(leaf [32]byte, path []Step, root string) bool {
+	cur := leaf[:]
+	for _, s := range path {
+		sib, err := hex.DecodeString(s.Hash)
+		if err != nil || len(sib) != 32 {
+			return false
+		}
+		if s.Left {
+			cur = node(sib, cur)
+		} else {
+			cur = node(cur, sib)
+		}
+	}
+	return hex.EncodeToString(cur) == root
+}
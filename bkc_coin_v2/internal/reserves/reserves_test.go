@@ -0,0 +1,89 @@
+package reserves
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProofRoundTrip(t *testing.T) {
+	secret := []byte("report secret")
+	for n := 1; n <= 9; n++ {
+		var hashes []byte
+		for i := 0; i < n; i++ {
+			leaf := Leaf(UserSalt(secret, int64(i+1)), int64(i+1), int64(100*(i+1)))
+			hashes = append(hashes, leaf[:]...)
+		}
+		levels := Levels(hashes)
+		root := hex.EncodeToString(levels[len(levels)-1])
+		for i := 0; i < n; i++ {
+			// What the DB does: the sibling at each level below the root.
+			var siblings [][]byte
+			idx := i
+			for _, lvl := range levels[:len(levels)-1] {
+				var sib []byte
+				if s := int(SiblingIndex(int64(idx))); s < len(lvl)/32 {
+					sib = lvl[s*32 : (s+1)*32]
+				}
+				siblings = append(siblings, sib)
+				idx /= 2
+			}
+			path := Path(int64(i), siblings)
+			leaf := Leaf(UserSalt(secret, int64(i+1)), int64(i+1), int64(100*(i+1)))
+			if !Verify(leaf, path, root) {
+				t.Fatalf("n=%d: proof of leaf %d does not reach the root", n, i)
+			}
+			forged := Leaf(UserSalt(secret, int64(i+1)), int64(i+1), int64(100*(i+1))+1)
+			if Verify(forged, path, root) {
+				t.Fatalf("n=%d: forged balance of leaf %d verifies", n, i)
+			}
+		}
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	levels := Levels(nil)
+	if len(levels) != 1 || len(levels[0]) != 32 {
+		t.Fatalf("empty tree levels: %v", levels)
+	}
+}
+
+func TestSaltIsPerUser(t *testing.T) {
+	a, b := UserSalt([]byte("s"), 1), UserSalt([]byte("s"), 2)
+	if len(a) != 16 || hex.EncodeToString(a) == hex.EncodeToString(b) {
+		t.Fatalf("salts %x, %x", a, b)
+	}
+}
+
+func TestSignedReport(t *testing.T) {
+	key, err := KeyFromHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KeyFromHex("abcd"); err == nil {
+		t.Fatal("short key accepted")
+	}
+	r := Report{
+		GeneratedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Assets:      []Asset{asset("ton", "TON", "EQ..", "2500000000", 9), asset("solana", "USDT", "So..", "1500000", 6)},
+		Liabilities: Liabilities{Coins: 2_000_000, CoinsPerUSD: 1_000_000},
+	}
+	if r.Assets[0].Amount != 2.5 || r.Assets[1].Amount != 1.5 {
+		t.Fatalf("amounts %v, %v", r.Assets[0].Amount, r.Assets[1].Amount)
+	}
+	r.Assets[0].USD, r.Assets[1].USD = 5, 1.5
+	r.Fill()
+	if r.AssetsUSD != 6.5 || r.Liabilities.USD != 2 || r.Ratio != 3.25 {
+		t.Fatalf("totals %+v", r)
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(key, body)
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), body, sig) {
+		t.Fatal("signature does not verify")
+	}
+}
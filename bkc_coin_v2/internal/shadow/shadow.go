@@ -0,0 +1,106 @@
+// Package shadow dark-launches a candidate tap reward formula: it computes
+// what the candidate would have paid for every tap memtap credits and stores
+// the per user/day totals next to what was actually paid. Balances are never
+// touched; the comparison is read from /api/v1/admin/economy/shadow.
+package shadow
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"bkc_coin_v2/internal/db"
+	"bkc_coin_v2/internal/memtap"
+)
+
+// Engine names the candidate formula in stored rows, so reports of
+// different candidates are never mixed.
+const Engine = "supply_v2"
+
+// maxTapMul caps the NFT multiplier in the candidate formula.
+const maxTapMul = 3
+
+// Candidate is the formula under evaluation: the NFT multiplier (capped at
+// 3x) scaled by how much of the initial reserve is already issued — more
+// generous early, tighter as the reserve drains.
+func Candidate(t memtap.ObservedTap) int64 {
+	mul := math.Min(math.Max(t.TapMul, 1), maxTapMul)
+	return int64(math.Floor(float64(t.Taps) * mul * supplyFactor(t.Reserve, t.InitialReserve)))
+}
+
+func supplyFactor(reserve, initial int64) float64 {
+	if initial <= 0 {
+		return 1
+	}
+	issued := 1 - float64(reserve)/float64(initial)
+	switch {
+	case issued < 0.3:
+		return 1.2
+	case issued < 0.5:
+		return 1.0
+	case issued < 0.7:
+		return 0.8
+	default:
+		return 0.6
+	}
+}
+
+type key struct {
+	userID int64
+	day    string
+}
+
+// Recorder aggregates observed taps in memory; Flush writes them out.
+type Recorder struct {
+	db      *db.DB
+	mu      sync.Mutex
+	pending map[key]db.TapShadowDelta
+}
+
+func NewRecorder(d *db.DB) *Recorder {
+	return &Recorder{db: d, pending: map[key]db.TapShadowDelta{}}
+}
+
+// ObserveTap implements memtap.TapObserver.
+func (r *Recorder) ObserveTap(t memtap.ObservedTap) {
+	shadow := Candidate(t)
+	r.mu.Lock()
+	k := key{t.UserID, t.Day}
+	p := r.pending[k]
+	p.UserID, p.Day = t.UserID, t.Day
+	p.Taps += t.Taps
+	p.Actual += t.Gained
+	p.Shadow += shadow
+	r.pending[k] = p
+	r.mu.Unlock()
+}
+
+// Flush stores the pending totals. On failure they are kept for the next
+// flush. Run from the tap_shadow job and on shutdown.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = map[key]db.TapShadowDelta{}
+	r.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	rows := make([]db.TapShadowDelta, 0, len(batch))
+	for _, p := range batch {
+		rows = append(rows, p)
+	}
+	if err := r.db.AddTapShadow(ctx, Engine, rows); err != nil {
+		r.mu.Lock()
+		for k, p := range batch {
+			q := r.pending[k]
+			q.UserID, q.Day = p.UserID, p.Day
+			q.Taps += p.Taps
+			q.Actual += p.Actual
+			q.Shadow += p.Shadow
+			r.pending[k] = q
+		}
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package shadow
+
+import (
+	"testing"
+
+	"bkc_coin_v2/internal/memtap"
+)
+
+func TestCandidate(t *testing.T) {
+	cases := []struct {
+		name string
+		tap  memtap.ObservedTap
+		want int64
+	}{
+		{"early reserve", memtap.ObservedTap{Taps: 100, TapMul: 1, Reserve: 900, InitialReserve: 1_000}, 120},
+		{"half issued", memtap.ObservedTap{Taps: 100, TapMul: 1, Reserve: 450, InitialReserve: 1_000}, 80},
+		{"drained", memtap.ObservedTap{Taps: 100, TapMul: 1, Reserve: 100, InitialReserve: 1_000}, 60},
+		{"nft capped", memtap.ObservedTap{Taps: 10, TapMul: 5, Reserve: 600, InitialReserve: 1_000}, 30},
+		{"no initial reserve", memtap.ObservedTap{Taps: 7, TapMul: 1.5}, 10},
+	}
+	for _, c := range cases {
+		if got := Candidate(c.tap); got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRecorderAggregates(t *testing.T) {
+	r := NewRecorder(nil)
+	tap := memtap.ObservedTap{UserID: 1, Day: "2025-06-01", Taps: 10, TapMul: 1, Gained: 10, Reserve: 900, InitialReserve: 1_000}
+	r.ObserveTap(tap)
+	r.ObserveTap(tap)
+	tap.Day = "2025-06-02"
+	r.ObserveTap(tap)
+
+	if len(r.pending) != 2 {
+		t.Fatalf("%d pending rows, want 2", len(r.pending))
+	}
+	p := r.pending[key{1, "2025-06-01"}]
+	if p.Taps != 20 || p.Actual != 20 || p.Shadow != 24 {
+		t.Fatalf("pending %+v", p)
+	}
+}
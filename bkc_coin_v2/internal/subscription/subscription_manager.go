@@ -6,7 +6,9 @@ import (
 	"sync"
 	"time"
 
+	"bkc_coin_v2/internal/clock"
 	"bkc_coin_v2/internal/i18n"
+	"bkc_coin_v2/internal/jobs"
 )
 
 // SubscriptionType тип подписки
@@ -43,8 +45,8 @@ type SubscriptionManager struct {
 	// Метрики
 	metrics *SubscriptionMetrics
 
-	// Кэш
-	cache   map[string]interface{}
+	// Кэш; просроченные записи вычищает StartCacheJanitor
+	cache   map[string]cacheEntry
 	cacheMu sync.RWMutex
 }
 
@@ -112,6 +114,9 @@ type SubscriptionConfig struct {
 	TaxBasic          float64       `json:"tax_basic"`
 	TaxSilver         float64       `json:"tax_silver"`
 	TaxGold           float64       `json:"tax_gold"`
+
+	// Часы сроков и продлений (nil — системные; в тестах clock.Manual)
+	Clock clock.Clock `json:"-"`
 }
 
 // SubscriptionMetrics метрики подписок
@@ -268,7 +273,7 @@ func NewSubscriptionManager(config SubscriptionConfig) *SubscriptionManager {
 		plans:         DefaultSubscriptionPlans(),
 		config:        config,
 		metrics:       &SubscriptionMetrics{},
-		cache:         make(map[string]interface{}),
+		cache:         make(map[string]cacheEntry),
 	}
 }
 
@@ -295,7 +300,7 @@ func (sm *SubscriptionManager) CreateSubscription(ctx context.Context, req *Crea
 	}
 
 	// Создание подписки
-	now := time.Now()
+	now := sm.now()
 	subscription := &Subscription{
 		ID:        sm.generateID("sub"),
 		UserID:    req.UserID,
@@ -370,17 +375,17 @@ func (sm *SubscriptionManager) UpgradeSubscription(ctx context.Context, req *Upg
 	var upgradeCost int64
 	if req.Immediate {
 		// Немедленный апгрейд с пропорциональной доплатой
-		remainingDays := time.Until(subscription.EndsAt).Hours() / 24
+		remainingDays := subscription.EndsAt.Sub(sm.now()).Hours() / 24
 		dailyPriceDiff := float64(newPlan.Price-oldPlan.Price) / 30.0
 		upgradeCost = int64(dailyPriceDiff * remainingDays)
 	}
 
 	// Обновление подписки
 	subscription.Type = req.NewType
-	subscription.UpdatedAt = time.Now()
+	subscription.UpdatedAt = sm.now()
 
 	if req.Immediate {
-		subscription.NextPaymentAt = time.Now().Add(newPlan.Duration)
+		subscription.NextPaymentAt = sm.now().Add(newPlan.Duration)
 	}
 
 	// Обновление метрик
@@ -410,7 +415,7 @@ func (sm *SubscriptionManager) CancelSubscription(ctx context.Context, userID in
 		return fmt.Errorf(i18n.T(lang, "error_subscription_not_active"))
 	}
 
-	now := time.Now()
+	now := sm.now()
 	subscription.Status = StatusCancelled
 	subscription.AutoRenew = false
 	subscription.CancelledAt = &now
@@ -438,9 +443,9 @@ func (sm *SubscriptionManager) GetUserSubscription(ctx context.Context, userID i
 	}
 
 	// Проверка истечения подписки
-	if subscription.Status == StatusActive && time.Now().After(subscription.EndsAt) {
+	if subscription.Status == StatusActive && sm.now().After(subscription.EndsAt) {
 		subscription.Status = StatusExpired
-		subscription.UpdatedAt = time.Now()
+		subscription.UpdatedAt = sm.now()
 
 		// Обновление метрик
 		sm.decrementActiveSubscriptions()
@@ -529,7 +534,7 @@ func (sm *SubscriptionManager) ProcessRenewals(ctx context.Context) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	now := time.Now()
+	now := sm.now()
 	var renewedSubscriptions []*Subscription
 
 	for _, subscription := range sm.subscriptions {
@@ -588,7 +593,7 @@ func (sm *SubscriptionManager) GetSubscriptionStats(ctx context.Context) map[str
 // Вспомогательные методы
 
 func (sm *SubscriptionManager) createDefaultSubscription(userID int64) *Subscription {
-	now := time.Now()
+	now := sm.now()
 	return &Subscription{
 		ID:        sm.generateID("sub_basic"),
 		UserID:    userID,
@@ -613,31 +618,63 @@ func (sm *SubscriptionManager) isUpgrade(oldType, newType SubscriptionType) bool
 	return upgradeOrder[newType] > upgradeOrder[oldType]
 }
 
+// now текущее время по часам из конфигурации
+func (sm *SubscriptionManager) now() time.Time {
+	return clock.Or(sm.config.Clock).Now()
+}
+
 func (sm *SubscriptionManager) generateID(prefix string) string {
 	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
 }
 
+// cacheEntry запись кэша со сроком жизни
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
 // Кэш методы
 func (sm *SubscriptionManager) getFromCache(key string) interface{} {
 	sm.cacheMu.RLock()
 	defer sm.cacheMu.RUnlock()
 
-	return sm.cache[key]
+	e, ok := sm.cache[key]
+	if !ok || !sm.now().Before(e.expiresAt) {
+		return nil
+	}
+	return e.value
 }
 
 func (sm *SubscriptionManager) setToCache(key string, value interface{}, ttl time.Duration) {
 	sm.cacheMu.Lock()
 	defer sm.cacheMu.Unlock()
 
-	sm.cache[key] = value
+	sm.cache[key] = cacheEntry{value: value, expiresAt: sm.now().Add(ttl)}
+}
 
-	// Удаление из кэша через TTL
-	go func() {
-		time.Sleep(ttl)
-		sm.cacheMu.Lock()
-		delete(sm.cache, key)
-		sm.cacheMu.Unlock()
-	}()
+// StartCacheJanitor раз в interval удаляет просроченные записи кэша, пока
+// ctx не отменен
+func (sm *SubscriptionManager) StartCacheJanitor(ctx context.Context, interval time.Duration) {
+	jobs.Start(ctx, "subscription_cache", interval, func(ctx context.Context) error {
+		sm.evictExpired()
+		return nil
+	})
+}
+
+// evictExpired удаляет просроченные записи кэша
+func (sm *SubscriptionManager) evictExpired() int {
+	now := sm.now()
+	sm.cacheMu.Lock()
+	defer sm.cacheMu.Unlock()
+
+	n := 0
+	for key, e := range sm.cache {
+		if !now.Before(e.expiresAt) {
+			delete(sm.cache, key)
+			n++
+		}
+	}
+	return n
 }
 
 func (sm *SubscriptionManager) clearCache(prefix string) {
@@ -656,14 +693,14 @@ func (sm *SubscriptionManager) incrementTotalSubscriptions() {
 	sm.metrics.mu.Lock()
 	defer sm.metrics.mu.Unlock()
 	sm.metrics.TotalSubscriptions++
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 func (sm *SubscriptionManager) incrementActiveSubscriptions() {
 	sm.metrics.mu.Lock()
 	defer sm.metrics.mu.Unlock()
 	sm.metrics.ActiveSubscriptions++
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 func (sm *SubscriptionManager) decrementActiveSubscriptions() {
@@ -672,7 +709,7 @@ func (sm *SubscriptionManager) decrementActiveSubscriptions() {
 	if sm.metrics.ActiveSubscriptions > 0 {
 		sm.metrics.ActiveSubscriptions--
 	}
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 func (sm *SubscriptionManager) incrementTypeSubscription(subType SubscriptionType) {
@@ -687,7 +724,7 @@ func (sm *SubscriptionManager) incrementTypeSubscription(subType SubscriptionTyp
 	case SubscriptionGold:
 		sm.metrics.GoldSubscriptions++
 	}
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 func (sm *SubscriptionManager) decrementTypeSubscription(subType SubscriptionType) {
@@ -708,7 +745,7 @@ func (sm *SubscriptionManager) decrementTypeSubscription(subType SubscriptionTyp
 			sm.metrics.GoldSubscriptions--
 		}
 	}
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 func (sm *SubscriptionManager) incrementTotalRevenue(amount int64) {
@@ -716,7 +753,7 @@ func (sm *SubscriptionManager) incrementTotalRevenue(amount int64) {
 	defer sm.metrics.mu.Unlock()
 	sm.metrics.TotalRevenue += amount
 	sm.metrics.MonthlyRevenue += amount
-	sm.metrics.LastUpdated = time.Now()
+	sm.metrics.LastUpdated = sm.now()
 }
 
 // GetMetrics возвращает метрики
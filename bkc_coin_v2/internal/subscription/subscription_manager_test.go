@@ -0,0 +1,95 @@
+package subscription
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bkc_coin_v2/internal/clock"
+	"bkc_coin_v2/internal/i18n"
+	"bkc_coin_v2/internal/testenv"
+)
+
+func testManager(t *testing.T) (*SubscriptionManager, *clock.Manual) {
+	t.Helper()
+	clk := clock.NewManual(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	cfg := DefaultSubscriptionConfig()
+	cfg.Clock = clk
+	return NewSubscriptionManager(cfg), clk
+}
+
+func TestRenewalBeforeEnd(t *testing.T) {
+	sm, clk := testManager(t)
+	ctx := context.Background()
+	sub, err := sm.CreateSubscription(ctx, &CreateSubscriptionRequest{UserID: 1, Type: SubscriptionSilver, AutoRenew: true}, i18n.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ends := sub.EndsAt
+	if !ends.Equal(clk.Now().Add(30 * 24 * time.Hour)) {
+		t.Fatalf("ends at %v", ends)
+	}
+
+	// Renewal happens within RenewalReminder (7 days) of the end.
+	clk.Advance(22 * 24 * time.Hour)
+	if err := sm.ProcessRenewals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sub.EndsAt.Equal(ends) {
+		t.Fatalf("renewed too early: ends at %v", sub.EndsAt)
+	}
+	clk.Advance(25 * time.Hour)
+	if err := sm.ProcessRenewals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want := ends.Add(30 * 24 * time.Hour); !sub.EndsAt.Equal(want) || !sub.LastPaymentAt.Equal(clk.Now()) {
+		t.Fatalf("ends at %v, paid at %v; want %v, %v", sub.EndsAt, sub.LastPaymentAt, want, clk.Now())
+	}
+	if m := sm.GetMetrics(); m.TotalRevenue != 2*50000 {
+		t.Fatalf("revenue %d", m.TotalRevenue)
+	}
+}
+
+func TestExpiryWithoutRenewal(t *testing.T) {
+	sm, clk := testManager(t)
+	ctx := context.Background()
+	sub, err := sm.CreateSubscription(ctx, &CreateSubscriptionRequest{UserID: 2, Type: SubscriptionGold, UseTrial: true}, i18n.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Set(sub.EndsAt)
+	if got, _ := sm.GetUserSubscription(ctx, 2); got.Status != StatusActive {
+		t.Fatalf("at the end of the trial: %s", got.Status)
+	}
+	clk.Advance(time.Second)
+	if err := sm.ProcessRenewals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := sm.GetUserSubscription(ctx, 2); got.Status != StatusExpired {
+		t.Fatalf("after the trial: %s", got.Status)
+	}
+	if sm.CheckPrivilege(ctx, 2, "real_time_chart") {
+		t.Fatal("expired trial keeps gold privileges")
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	testenv.NoLeaks(t)
+	sm, clk := testManager(t)
+	sm.setToCache("subscriptions_a", 1, time.Minute)
+	if sm.getFromCache("subscriptions_a") != 1 {
+		t.Fatal("fresh entry missing")
+	}
+	clk.Advance(time.Minute)
+	if sm.getFromCache("subscriptions_a") != nil {
+		t.Fatal("expired entry returned")
+	}
+	if n := sm.evictExpired(); n != 1 {
+		t.Fatalf("evicted %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.StartCacheJanitor(ctx, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+}
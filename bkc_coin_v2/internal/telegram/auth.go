@@ -7,13 +7,17 @@ import (
 	"encoding/json"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type AuthUser struct {
 	ID        int64  `json:"id"`
 	Username  string `json:"username"`
 	FirstName string `json:"first_name"`
+
+	AuthDate time.Time `json:"-"` // when Telegram issued the initData
 }
 
 // VerifyWebAppInitData verifies Telegram WebApp initData using bot token.
@@ -75,5 +79,8 @@ func VerifyWebAppInitData(initData string, botToken string) (AuthUser, bool) {
 	if strings.TrimSpace(user.FirstName) == "" {
 		user.FirstName = "User"
 	}
+	if ts, err := strconv.ParseInt(vals.Get("auth_date"), 10, 64); err == nil && ts > 0 {
+		user.AuthDate = time.Unix(ts, 0).UTC()
+	}
 	return user, true
-}
+}
@@ -0,0 +1,19 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignWebAppInitDataVerifies(t *testing.T) {
+	at := time.Unix(1_700_000_000, 0).UTC()
+	initData := SignWebAppInitData(AuthUser{ID: 42, Username: "load", FirstName: "Load"}, "123:token", at)
+
+	u, ok := VerifyWebAppInitData(initData, "123:token")
+	if !ok || u.ID != 42 || !u.AuthDate.Equal(at) {
+		t.Fatalf("verify = %+v, %v", u, ok)
+	}
+	if _, ok := VerifyWebAppInitData(initData, "123:other"); ok {
+		t.Fatal("initData verified with the wrong bot token")
+	}
+}
@@ -0,0 +1,155 @@
+// Package templates renders transactional notifications (deposit approved,
+// loan overdue, escrow released) and the periodic digest from localized
+// text/template bodies. The
+// built-in texts below are version 0; admins publish newer versions, stored
+// in the database, without a deploy.
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Notification keys.
+const (
+	DepositApproved = "deposit_approved"
+	LoanOverdue     = "loan_overdue"
+	EscrowReleased  = "escrow_released"
+	Digest          = "digest"
+)
+
+// Keys lists every notification key.
+var Keys = []string{DepositApproved, LoanOverdue, EscrowReleased, Digest}
+
+// Languages a template can be written in; DefaultLang is the fallback for
+// users whose language has no template.
+var Languages = []string{"ru", "en"}
+
+const DefaultLang = "ru"
+
+// Template is one localized version of a notification.
+type Template struct {
+	Key     string `json:"key"`
+	Lang    string `json:"lang"`
+	Version int64  `json:"version"` // 0 = built-in
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+var builtin = map[string]map[string]Template{
+	DepositApproved: {
+		"ru": {Subject: "Пополнение зачислено", Body: "{{.first_name}}, на ваш баланс зачислено {{.amount}} BKC."},
+		"en": {Subject: "Deposit approved", Body: "{{.first_name}}, {{.amount}} BKC has been credited to your balance."},
+	},
+	LoanOverdue: {
+		"ru": {Subject: "Кредит просрочен", Body: "Кредит #{{.loan_id}} просрочен: с баланса списано {{.amount}} BKC. Баланс может стать отрицательным."},
+		"en": {Subject: "Loan overdue", Body: "Loan #{{.loan_id}} is overdue: {{.amount}} BKC has been charged to your balance, which may go negative."},
+	},
+	EscrowReleased: {
+		"ru": {Subject: "Сделка завершена", Body: "Предложение #{{.offer_id}} по NFT #{{.nft_id}} принято: {{.amount}} BKC переведены вам из эскроу."},
+		"en": {Subject: "Escrow released", Body: "Offer #{{.offer_id}} for NFT #{{.nft_id}} was accepted: {{.amount}} BKC has been released to you from escrow."},
+	},
+	Digest: {
+		"ru": {Subject: "Сводка BKC", Body: "{{.first_name}}, ваша сводка." +
+			"{{if .earned}}\nЗаработано: {{.earned}} BKC (тапы {{.tapped}}, доходы {{.income}}).{{end}}" +
+			"{{if .loans}}\nАктивных кредитов: {{.loans}}, к погашению {{.loan_due}} BKC, ближайший срок {{.next_due}}.{{end}}" +
+			"{{if .listings}}\nСкоро закончится продвижение объявлений: {{.listings}}.{{end}}" +
+			"\n\nОтписаться: /digest_off"},
+		"en": {Subject: "BKC digest", Body: "{{.first_name}}, here is your digest." +
+			"{{if .earned}}\nEarned: {{.earned}} BKC ({{.tapped}} from taps, {{.income}} income).{{end}}" +
+			"{{if .loans}}\nActive loans: {{.loans}}, {{.loan_due}} BKC due, next on {{.next_due}}.{{end}}" +
+			"{{if .listings}}\nListing promotions ending soon: {{.listings}}.{{end}}" +
+			"\n\nUnsubscribe: /digest_off"},
+	},
+}
+
+// samples are the variables each key is rendered with; a template may only
+// use these.
+var samples = map[string]map[string]any{
+	DepositApproved: {"user_id": int64(1), "first_name": "Alex", "amount": int64(5000), "deposit_id": int64(42), "invoice_id": int64(0)},
+	LoanOverdue:     {"user_id": int64(1), "first_name": "Alex", "amount": int64(1200), "loan_id": int64(7)},
+	EscrowReleased:  {"user_id": int64(1), "first_name": "Alex", "amount": int64(950), "offer_id": int64(3), "nft_id": int64(12), "sale_id": int64(99)},
+	Digest: {"user_id": int64(1), "first_name": "Alex", "period_days": int64(1), "earned": int64(1500), "tapped": int64(1200), "income": int64(300),
+		"loans": int64(1), "loan_due": int64(1100), "next_due": "2025-01-31", "listings": int64(2)},
+}
+
+// Builtin returns the built-in template for key in lang.
+func Builtin(key, lang string) (Template, bool) {
+	t, ok := builtin[key][lang]
+	if ok {
+		t.Key, t.Lang = key, lang
+	}
+	return t, ok
+}
+
+// Sample returns example variables for key, for previews.
+func Sample(key string) map[string]any {
+	out := map[string]any{}
+	for k, v := range samples[key] {
+		out[k] = v
+	}
+	return out
+}
+
+// NormalizeLang maps a Telegram language_code ("en-US") to a supported
+// language, or DefaultLang.
+func NormalizeLang(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	for _, l := range Languages {
+		if l == code {
+			return l
+		}
+	}
+	return DefaultLang
+}
+
+// Render fills subject and body with vars. A variable the template uses but
+// vars lacks is an error.
+func Render(t Template, vars map[string]any) (subject, body string, err error) {
+	if subject, err = execute(t.Key+".subject", t.Subject, vars); err != nil {
+		return "", "", err
+	}
+	if body, err = execute(t.Key+".body", t.Body, vars); err != nil {
+		return "", "", err
+	}
+	return subject, body, nil
+}
+
+func execute(name, text string, vars map[string]any) (string, error) {
+	tpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Validate checks a template before it is published: known key and
+// language, a non-empty body and only known variables.
+func Validate(t Template) error {
+	if _, ok := samples[t.Key]; !ok {
+		return fmt.Errorf("bad key %q", t.Key)
+	}
+	if NormalizeLang(t.Lang) != t.Lang {
+		return fmt.Errorf("bad lang %q", t.Lang)
+	}
+	if strings.TrimSpace(t.Body) == "" {
+		return errors.New("bad body: empty")
+	}
+	if len(t.Subject) > 200 || len(t.Body) > 4000 {
+		return errors.New("bad template: too long")
+	}
+	if _, _, err := Render(t, Sample(t.Key)); err != nil {
+		return fmt.Errorf("bad template: %w", err)
+	}
+	return nil
+}
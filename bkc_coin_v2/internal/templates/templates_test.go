@@ -0,0 +1,66 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinsValid(t *testing.T) {
+	for _, key := range Keys {
+		for _, lang := range Languages {
+			tpl, ok := Builtin(key, lang)
+			if !ok {
+				t.Fatalf("%s/%s: no built-in template", key, lang)
+			}
+			if err := Validate(tpl); err != nil {
+				t.Fatalf("%s/%s: %v", key, lang, err)
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tpl, _ := Builtin(DepositApproved, "en")
+	vars := Sample(DepositApproved)
+	vars["amount"] = int64(1_200_000)
+	subject, body, err := Render(tpl, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Deposit approved" || !strings.Contains(body, "1200000 BKC") {
+		t.Fatalf("got %q / %q", subject, body)
+	}
+	delete(vars, "amount")
+	if _, _, err := Render(tpl, vars); err == nil {
+		t.Fatal("missing variable rendered")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		t    Template
+		ok   bool
+	}{
+		{"ok", Template{Key: LoanOverdue, Lang: "en", Body: "Loan {{.loan_id}}: {{.amount}}"}, true},
+		{"unknown key", Template{Key: "promo", Lang: "en", Body: "hi"}, false},
+		{"unknown lang", Template{Key: LoanOverdue, Lang: "de", Body: "hi"}, false},
+		{"empty body", Template{Key: LoanOverdue, Lang: "en", Body: "  "}, false},
+		{"unknown variable", Template{Key: LoanOverdue, Lang: "en", Body: "{{.offer_id}}"}, false},
+		{"syntax error", Template{Key: LoanOverdue, Lang: "en", Body: "{{.loan_id"}, false},
+	}
+	for _, c := range cases {
+		if err := Validate(c.t); (err == nil) != c.ok {
+			t.Errorf("%s: err=%v", c.name, err)
+		}
+	}
+}
+
+func TestNormalizeLang(t *testing.T) {
+	cases := map[string]string{"en": "en", "en-US": "en", "RU": "ru", "uk": DefaultLang, "": DefaultLang}
+	for in, want := range cases {
+		if got := NormalizeLang(in); got != want {
+			t.Errorf("%q: %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package testenv
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakWait is how long goroutines get to notice a cancelled context.
+const leakWait = 2 * time.Second
+
+// NoLeaks fails tb if goroutines started during the test are still running
+// once it has been cleaned up. Call it first in the test: its check then runs
+// after every other cleanup (cancel funcs, Close, Stop).
+func NoLeaks(tb testing.TB) {
+	tb.Helper()
+	before := goroutines()
+	tb.Cleanup(func() {
+		deadline := time.Now().Add(leakWait)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				tb.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+// goroutines returns the stacks of all goroutines but the caller's, by id.
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	out := make(map[string]string)
+	for i, stack := range strings.Split(string(buf), "\n\n") {
+		if i == 0 {
+			continue // the caller
+		}
+		// "goroutine 7 [chan receive]:"
+		head, _, _ := strings.Cut(stack, " [")
+		out[head] = stack
+	}
+	return out
+}
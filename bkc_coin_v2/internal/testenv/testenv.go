@@ -0,0 +1,192 @@
+// Package testenv provides the disposable Postgres and Redis that
+// integration tests run against. A URL in BKC_TEST_DATABASE_URL or
+// BKC_TEST_REDIS_URL is used as is; otherwise, with BKC_TEST_DOCKER=1, the
+// service is started once per test binary in a throwaway docker container
+// (the docker CLI must be on PATH); otherwise the test is skipped, so a
+// plain go test stays hermetic.
+//
+//	BKC_TEST_DOCKER=1 go test ./internal/db ./internal/fasttap
+//
+// A package using it stops its containers from TestMain:
+//
+//	func TestMain(m *testing.M) { os.Exit(testenv.Run(m)) }
+//
+// NoLeaks checks that background loops stop with their context.
+package testenv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	postgresImage = "postgres:16-alpine"
+	redisImage    = "redis:7-alpine"
+	startTimeout  = 60 * time.Second
+)
+
+type service struct {
+	once sync.Once
+	id   string // container, "" when not started by us
+	url  string
+	err  error
+}
+
+var (
+	postgres service
+	redis    service
+
+	mu         sync.Mutex
+	containers []string
+)
+
+// Postgres returns the URL of an empty-enough Postgres database, or skips tb.
+// Tests share it: keep ids disjoint and clean up after yourself.
+func Postgres(tb testing.TB) string {
+	tb.Helper()
+	return postgres.get(tb, "BKC_TEST_DATABASE_URL", startPostgres)
+}
+
+// Redis returns the URL of a Redis server, or skips tb. Tests share it: use
+// distinct keys.
+func Redis(tb testing.TB) string {
+	tb.Helper()
+	return redis.get(tb, "BKC_TEST_REDIS_URL", startRedis)
+}
+
+func (s *service) get(tb testing.TB, env string, start func() (string, string, error)) string {
+	tb.Helper()
+	if url := os.Getenv(env); url != "" {
+		return url
+	}
+	if os.Getenv("BKC_TEST_DOCKER") != "1" {
+		tb.Skipf("%s not set (or set BKC_TEST_DOCKER=1)", env)
+	}
+	s.once.Do(func() {
+		s.id, s.url, s.err = start()
+		if s.id != "" {
+			mu.Lock()
+			containers = append(containers, s.id)
+			mu.Unlock()
+		}
+	})
+	if s.err != nil {
+		tb.Fatalf("testenv: %v", s.err)
+	}
+	return s.url
+}
+
+// Run runs the tests and removes the containers they started.
+func Run(m *testing.M) int {
+	code := m.Run()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range containers {
+		_ = exec.Command("docker", "rm", "-f", id).Run()
+	}
+	containers = nil
+	return code
+}
+
+// runContainer starts image detached with port published on a random
+// loopback port and returns the container id and host:port.
+func runContainer(image, port string, args ...string) (string, string, error) {
+	cmd := append([]string{"run", "-d", "--rm", "-p", "127.0.0.1::" + port}, args...)
+	out, err := exec.Command("docker", append(cmd, image)...).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("docker run %s: %w", image, exitErr(err))
+	}
+	id := strings.TrimSpace(string(out))
+	out, err = exec.Command("docker", "port", id, port+"/tcp").Output()
+	if err != nil {
+		return id, "", fmt.Errorf("docker port %s: %w", image, exitErr(err))
+	}
+	// One line per address family; the first is the IPv4 one we asked for.
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return id, addr, nil
+}
+
+func exitErr(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return err
+}
+
+// waitFor retries ready until it succeeds or startTimeout passes.
+func waitFor(what string, ready func(context.Context) error) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := ready(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not ready after %s: %w", what, startTimeout, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func startPostgres() (string, string, error) {
+	id, addr, err := runContainer(postgresImage, "5432",
+		"-e", "POSTGRES_USER=bkc", "-e", "POSTGRES_PASSWORD=bkc", "-e", "POSTGRES_DB=bkc_test")
+	if err != nil {
+		return id, "", err
+	}
+	url := "postgres://bkc:bkc@" + addr + "/bkc_test?sslmode=disable"
+	// The image restarts the server once after init, so a single successful
+	// connection is not enough: wait for a query to succeed.
+	err = waitFor("postgres", func(ctx context.Context) error {
+		conn, err := pgx.Connect(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(ctx)
+		var n int
+		return conn.QueryRow(ctx, `SELECT 1 FROM pg_database WHERE datname='bkc_test'`).Scan(&n)
+	})
+	return id, url, err
+}
+
+func startRedis() (string, string, error) {
+	id, addr, err := runContainer(redisImage, "6379")
+	if err != nil {
+		return id, "", err
+	}
+	err = waitFor("redis", func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if dl, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(dl)
+		}
+		if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+			return err
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+PONG") {
+			return fmt.Errorf("redis answered %q", line)
+		}
+		return nil
+	})
+	return id, "redis://" + addr + "/0", err
+}
@@ -15,6 +15,7 @@ import (
 	"bkc_coin_v2/internal/db"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 type Bot struct {
@@ -89,8 +90,10 @@ func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 	case "start":
 		payload := strings.TrimSpace(msg.CommandArguments())
 		_ = b.onStart(ctx, msg, payload)
+	case "digest_off":
+		_ = b.sendMessage(msg.Chat.ID, b.digestOff(ctx, int64(msg.From.ID)), "")
 	case "reserve_send":
-		if int64(msg.From.ID) != b.Cfg.AdminID {
+		if !b.adminCan(ctx, int64(msg.From.ID), db.PermMint) {
 			return
 		}
 		parts := strings.Fields(msg.CommandArguments())
@@ -104,9 +107,9 @@ func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 			_ = b.sendMessage(msg.Chat.ID, "Неверные параметры", "")
 			return
 		}
-		_ = b.reserveSend(ctx, msg.Chat.ID, toID, amount)
+		_ = b.reserveSend(ctx, msg.Chat.ID, int64(msg.From.ID), toID, amount)
 	case "broadcast":
-		if int64(msg.From.ID) != b.Cfg.AdminID {
+		if !b.adminCan(ctx, int64(msg.From.ID), "") {
 			return
 		}
 		text := strings.TrimSpace(msg.CommandArguments())
@@ -115,6 +118,21 @@ func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 			return
 		}
 		go b.broadcast(ctx, msg.Chat.ID, text)
+	case "ack":
+		if !b.isAdmin(ctx, int64(msg.From.ID)) {
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+		if err != nil || id <= 0 {
+			_ = b.sendMessage(msg.Chat.ID, "Формат: /ack <id>", "")
+			return
+		}
+		_ = b.sendMessage(msg.Chat.ID, b.ackAlert(ctx, int64(msg.From.ID), id), "")
+	case "alerts":
+		if !b.isAdmin(ctx, int64(msg.From.ID)) {
+			return
+		}
+		b.listAlerts(ctx, msg.Chat.ID)
 	default:
 		return
 	}
@@ -139,6 +157,19 @@ func (b *Bot) onStart(ctx context.Context, msg *tgbotapi.Message, payload string
 	if err != nil {
 		return err
 	}
+	if err := b.DB.SetUserLanguage(ctx, int64(user.ID), user.LanguageCode); err != nil {
+		log.Printf("set language: %v", err)
+	}
+
+	if !existed && strings.HasPrefix(payload, db.AffiliateLinkPrefix) {
+		if _, err := b.DB.AttributeAffiliate(ctx, int64(user.ID), payload); err != nil {
+			log.Printf("affiliate attribution: %v", err)
+		}
+	}
+
+	if strings.HasPrefix(payload, db.GiftLinkPrefix) {
+		_ = b.sendMessage(msg.Chat.ID, b.claimGift(ctx, int64(user.ID), payload), "")
+	}
 
 	refID := parseRef(payload)
 	if !existed && refID > 0 && refID != int64(user.ID) {
@@ -157,7 +188,7 @@ func (b *Bot) onStart(ctx context.Context, msg *tgbotapi.Message, payload string
 	}
 
 	u, _ := b.DB.GetUser(ctx, int64(user.ID))
-	rate := coinsPerUSD(sys.ReserveSupply, sys.InitialReserve, sys.StartRateCoinsUSD, sys.MinRateCoinsUSD)
+	rate := sys.CoinsPerUSD()
 	refLink := fmt.Sprintf("https://t.me/%s?start=%d", b.Bot.Self.UserName, user.ID)
 
 	uname := strings.TrimSpace(user.UserName)
@@ -179,7 +210,7 @@ func (b *Bot) onStart(ctx context.Context, msg *tgbotapi.Message, payload string
 		refLink,
 	)
 
-	return b.sendMessage(msg.Chat.ID, text, b.mainKeyboardJSON(int64(user.ID) == b.Cfg.AdminID))
+	return b.sendMessage(msg.Chat.ID, text, b.mainKeyboardJSON(b.isAdmin(ctx, int64(user.ID))))
 }
 
 func (b *Bot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) {
@@ -189,7 +220,20 @@ func (b *Bot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) {
 		return
 	}
 
-	isAdmin := int64(user.ID) == b.Cfg.AdminID
+	if strings.HasPrefix(q.Data, "stepup:") {
+		b.handleStepUp(ctx, q)
+		return
+	}
+	if strings.HasPrefix(q.Data, "wdhold:") {
+		b.handleWithdrawalHold(ctx, q)
+		return
+	}
+	if strings.HasPrefix(q.Data, "alertack:") {
+		b.handleAlertAck(ctx, q)
+		return
+	}
+
+	isAdmin := b.isAdmin(ctx, int64(user.ID))
 	kb := b.mainKeyboardJSON(isAdmin)
 
 	switch q.Data {
@@ -199,7 +243,7 @@ func (b *Bot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) {
 			return
 		}
 		sys, _ := b.DB.GetSystem(ctx)
-		rate := coinsPerUSD(sys.ReserveSupply, sys.InitialReserve, sys.StartRateCoinsUSD, sys.MinRateCoinsUSD)
+		rate := sys.CoinsPerUSD()
 		text := fmt.Sprintf("💰 Кошелек\n\nБаланс: %.1f BKC\nАдрес: %s\nКурс: %d BKC = $1", u.Balance, fmtAddress(int64(user.ID)), rate)
 		_ = b.editMessageText(q.Message.Chat.ID, q.Message.MessageID, text, kb)
 	case "invite":
@@ -213,7 +257,7 @@ func (b *Bot) handleCallback(ctx context.Context, q *tgbotapi.CallbackQuery) {
 		if !isAdmin {
 			return
 		}
-		text := "👑 Админ\n\n/reserve_send <user_id> <amount>\n/broadcast <text>"
+		text := "👑 Админ\n\n/reserve_send <user_id> <amount>\n/broadcast <text>\n/alerts\n/ack <id>"
 		_ = b.editMessageText(q.Message.Chat.ID, q.Message.MessageID, text, kb)
 	default:
 		return
@@ -309,6 +353,27 @@ func fmtAddress(userID int64) string {
 	return "BKC" + strconv.FormatInt(userID, 10)
 }
 
+// claimGift claims a gift link for userID and returns the reply.
+func (b *Bot) claimGift(ctx context.Context, userID int64, payload string) string {
+	g, err := b.DB.ClaimGift(ctx, userID, payload)
+	switch {
+	case err == nil:
+		text := fmt.Sprintf("🎁 Подарок получен: +%d BKC", g.Amount)
+		if g.Message != "" {
+			text += "\n\n💬 " + g.Message
+		}
+		_ = b.sendMessage(g.SenderID, fmt.Sprintf("🎁 Ваш подарок %d BKC получен.", g.Amount), "")
+		return text
+	case errors.Is(err, pgx.ErrNoRows):
+		return "Подарок не найден."
+	case errors.Is(err, db.ErrGiftUnavailable):
+		return "Подарок уже получен или истек."
+	default:
+		log.Printf("gift claim: %v", err)
+		return "Не удалось получить подарок."
+	}
+}
+
 func parseRef(payload string) int64 {
 	payload = strings.TrimSpace(payload)
 	if payload == "" {
@@ -322,26 +387,12 @@ func parseRef(payload string) int64 {
 	return id
 }
 
-func coinsPerUSD(reserve, initialReserve, startRate, minRate int64) int64 {
-	if initialReserve <= 0 {
-		return startRate
-	}
-	if reserve < 0 {
-		reserve = 0
-	}
-	if reserve > initialReserve {
-		reserve = initialReserve
-	}
-	span := startRate - minRate
-	return minRate + (span*reserve)/initialReserve
-}
-
-func (b *Bot) reserveSend(ctx context.Context, adminChatID int64, toID int64, amount int64) error {
+func (b *Bot) reserveSend(ctx context.Context, adminChatID, adminID, toID, amount int64) error {
 	if _, err := b.DB.GetUser(ctx, toID); err != nil {
 		_ = b.sendMessage(adminChatID, "Получатель не найден в БД", "")
 		return err
 	}
-	err := b.DB.CreditFromReserve(ctx, toID, amount, "admin_reserve_send", map[string]any{"by": b.Cfg.AdminID})
+	err := b.DB.CreditFromReserve(ctx, toID, amount, "admin_reserve_send", map[string]any{"by": adminID})
 	if err != nil {
 		if errors.Is(err, db.ErrNotEnough) {
 			_ = b.sendMessage(adminChatID, "В резерве недостаточно", "")
@@ -385,3 +436,203 @@ func (b *Bot) answerCallback(callbackQueryID string) error {
 	_, err := b.Bot.MakeRequest("answerCallbackQuery", params)
 	return err
 }
+
+var stepUpActionNames = map[string]string{
+	db.StepUpWithdraw:     "Вывод средств",
+	db.StepUpWalletChange: "Смена кошелька",
+	db.StepUpTransfer:     "Перевод",
+	db.StepUpVesting:      "Вывод из вестинга",
+	db.StepUpAPIKey:       "Создание API-ключа для торговли",
+}
+
+// SendStepUpConfirmation просит пользователя подтвердить опасное действие кнопкой в боте.
+func (b *Bot) SendStepUpConfirmation(ctx context.Context, userID, challengeID int64, action string, amount int64) error {
+	name := stepUpActionNames[action]
+	if name == "" {
+		name = action
+	}
+	text := fmt.Sprintf("🔐 Подтверждение\n\n%s", name)
+	if amount > 0 {
+		text += fmt.Sprintf(": %d BKC", amount)
+	}
+	text += "\n\nЕсли это не вы — нажмите «Отклонить» и завершите все сеансы в настройках."
+	kb := map[string]any{
+		"inline_keyboard": [][]map[string]string{{
+			{"text": "✅ Подтвердить", "callback_data": fmt.Sprintf("stepup:ok:%d", challengeID)},
+			{"text": "❌ Отклонить", "callback_data": fmt.Sprintf("stepup:no:%d", challengeID)},
+		}},
+	}
+	raw, _ := json.Marshal(kb)
+	return b.sendMessage(userID, text, string(raw))
+}
+
+func (b *Bot) handleStepUp(ctx context.Context, q *tgbotapi.CallbackQuery) {
+	parts := strings.Split(q.Data, ":")
+	if len(parts) != 3 {
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+	approve := parts[1] == "ok"
+	text := "✅ Действие подтверждено. Вернитесь в приложение."
+	if !approve {
+		text = "❌ Действие отклонено."
+	}
+	if _, err := b.DB.ResolveStepUpChallenge(ctx, int64(q.From.ID), id, approve); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("stepup resolve: %v", err)
+		}
+		text = "Запрос устарел или уже обработан."
+	}
+	_ = b.editMessageText(q.Message.Chat.ID, q.Message.MessageID, text, "")
+}
+
+// SendWithdrawalHold просит подтвердить вывод, задержанный из-за входа из новой страны/сети.
+func (b *Bot) SendWithdrawalHold(ctx context.Context, userID int64, w db.Withdrawal) error {
+	where := w.Country
+	if where == "" {
+		where = "неизвестного места"
+	}
+	text := fmt.Sprintf("⚠️ Вывод %d BKC на %s запрошен из %s (IP %s).\n\nЕсли это вы — подтвердите. Если нет — отклоните и завершите все сеансы.",
+		w.Amount, w.Address, where, w.IP)
+	kb := map[string]any{
+		"inline_keyboard": [][]map[string]string{{
+			{"text": "✅ Это я", "callback_data": fmt.Sprintf("wdhold:ok:%d", w.WithdrawalID)},
+			{"text": "❌ Не я", "callback_data": fmt.Sprintf("wdhold:no:%d", w.WithdrawalID)},
+		}},
+	}
+	raw, _ := json.Marshal(kb)
+	return b.sendMessage(userID, text, string(raw))
+}
+
+// SendAdminAlert публикует алерт в админ-канал с кнопкой подтверждения.
+func (b *Bot) SendAdminAlert(ctx context.Context, a db.AdminAlert) error {
+	text := fmt.Sprintf("🚨 #%d %s\n\n%s", a.AlertID, a.Kind, a.Message)
+	if a.Count > 1 {
+		text += fmt.Sprintf("\n\nПовторов: %d", a.Count)
+	}
+	text += fmt.Sprintf("\n\n/ack %d — принять", a.AlertID)
+	kb := map[string]any{
+		"inline_keyboard": [][]map[string]string{{
+			{"text": "✅ Принято", "callback_data": fmt.Sprintf("alertack:%d", a.AlertID)},
+		}},
+	}
+	raw, _ := json.Marshal(kb)
+	return b.sendMessage(b.Cfg.AlertChatID, text, string(raw))
+}
+
+// SendNotification отправляет пользователю уведомление, собранное из шаблона.
+func (b *Bot) SendNotification(ctx context.Context, userID int64, subject, body string) error {
+	text := body
+	if subject != "" {
+		text = subject + "\n\n" + body
+	}
+	return b.sendMessage(userID, text, "")
+}
+
+// digestOff отписывает пользователя от периодических сводок (ссылка в конце каждой сводки).
+func (b *Bot) digestOff(ctx context.Context, userID int64) string {
+	ok, err := b.DB.DeleteDigestSubscription(ctx, userID)
+	switch {
+	case err != nil:
+		return "Не удалось отписаться, попробуйте позже"
+	case !ok:
+		return "Вы не подписаны на сводки"
+	default:
+		return "Сводки отключены. Включить снова можно в настройках ⚡ MINI APP."
+	}
+}
+
+// isAdmin: пользователь есть в таблице admins (любые права).
+func (b *Bot) isAdmin(ctx context.Context, userID int64) bool {
+	_, err := b.DB.GetAdmin(ctx, userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("admin lookup %d: %v", userID, err)
+	}
+	return err == nil
+}
+
+// adminCan: у админа есть право perm ("" = только супер-админ).
+func (b *Bot) adminCan(ctx context.Context, userID int64, perm string) bool {
+	ok, err := b.DB.AdminCan(ctx, userID, perm)
+	if err != nil {
+		log.Printf("admin lookup %d: %v", userID, err)
+	}
+	return ok
+}
+
+// ackAlert подтверждает алерт от имени админа и возвращает текст ответа.
+func (b *Bot) ackAlert(ctx context.Context, fromID, alertID int64) string {
+	if !b.isAdmin(ctx, fromID) {
+		return "Только админ может принять алерт."
+	}
+	a, err := b.DB.AckAdminAlert(ctx, fromID, alertID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("alert ack: %v", err)
+		}
+		return "Алерт не найден или уже закрыт."
+	}
+	return fmt.Sprintf("✅ #%d %s принят, повтор не раньше чем через %d ч.", a.AlertID, a.Kind, b.Cfg.AlertAckMuteHours)
+}
+
+func (b *Bot) handleAlertAck(ctx context.Context, q *tgbotapi.CallbackQuery) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(q.Data, "alertack:"), 10, 64)
+	if err != nil {
+		return
+	}
+	text := b.ackAlert(ctx, int64(q.From.ID), id)
+	if !b.isAdmin(ctx, int64(q.From.ID)) {
+		_ = b.sendMessage(q.Message.Chat.ID, text, "")
+		return
+	}
+	_ = b.editMessageText(q.Message.Chat.ID, q.Message.MessageID, q.Message.Text+"\n\n"+text, "")
+}
+
+// listAlerts отвечает списком открытых алертов.
+func (b *Bot) listAlerts(ctx context.Context, chatID int64) {
+	alerts, err := b.DB.ListAdminAlerts(ctx, true, 20)
+	if err != nil {
+		log.Printf("alerts list: %v", err)
+		return
+	}
+	if len(alerts) == 0 {
+		_ = b.sendMessage(chatID, "Открытых алертов нет.", "")
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("🚨 Открытые алерты\n")
+	for _, a := range alerts {
+		state := "ждёт подтверждения"
+		if a.AckedAt != nil {
+			state = "принят"
+		}
+		fmt.Fprintf(&sb, "\n#%d %s (×%d, %s)", a.AlertID, a.Kind, a.Count, state)
+	}
+	_ = b.sendMessage(chatID, sb.String(), "")
+}
+
+func (b *Bot) handleWithdrawalHold(ctx context.Context, q *tgbotapi.CallbackQuery) {
+	parts := strings.Split(q.Data, ":")
+	if len(parts) != 3 {
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+	confirm := parts[1] == "ok"
+	text := "✅ Вывод подтверждён и передан на выплату."
+	if !confirm {
+		text = "❌ Вывод отменён, средства возвращены на баланс."
+	}
+	if _, err := b.DB.ConfirmHeldWithdrawal(ctx, int64(q.From.ID), id, confirm); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) && !errors.Is(err, db.ErrForbidden) {
+			log.Printf("withdrawal hold confirm: %v", err)
+		}
+		text = "Запрос устарел или уже обработан."
+	}
+	_ = b.editMessageText(q.Message.Chat.ID, q.Message.MessageID, text, "")
+}
@@ -1,6 +1,7 @@
 package ton
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -102,6 +103,22 @@ func (rm *RateManager) updateTONRate() error {
 	return nil
 }
 
+// USDPerUnit возвращает курс валюты к USD для пересчета цен объявлений (USDT, TON).
+// При сбое CoinGecko используется последний курс, пока он не старше 30 минут.
+func (rm *RateManager) USDPerUnit(ctx context.Context, currency string) (float64, error) {
+	switch currency {
+	case "USDT":
+		return 1, nil
+	case "TON":
+		rate, err := rm.GetTONRate()
+		if err != nil && time.Since(rm.lastUpdate) > 30*time.Minute {
+			return 0, err
+		}
+		return rate, nil
+	}
+	return 0, fmt.Errorf("неизвестная валюта: %s", currency)
+}
+
 // ConvertTONtoBKC конвертирует TON в BKC
 func (rm *RateManager) ConvertTONtoBKC(tonAmount float64) float64 {
 	// 1 BKC = $0.001
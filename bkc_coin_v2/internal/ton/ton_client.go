@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"bkc_coin_v2/internal/currency"
 )
 
 // TON API конфигурация
@@ -128,18 +130,26 @@ func (tc *TonClient) CreateBuyPayload(order *P2POrder, buyerID int64, amountBKC
 	sellerReceives := amountBKC * order.PricePerBKCTON
 	commissionTON := sellerReceives * 0.05
 	_ = sellerReceives + commissionTON // totalPay calculation
+	sellerNano, err := currency.TON.FromFloat(sellerReceives)
+	if err != nil {
+		return nil, err
+	}
+	commissionNano, err := currency.TON.FromFloat(commissionTON)
+	if err != nil {
+		return nil, err
+	}
 
 	// Создание payload
 	payload := &TonConnectPayload{
 		Messages: []TonMessage{
 			{
 				Address: order.SellerWallet,
-				Amount:  fmt.Sprintf("%.0f", sellerReceives*1e9), // В нанотонах
+				Amount:  strconv.FormatInt(sellerNano, 10), // В нанотонах
 				Payload: fmt.Sprintf("ORDER_%s_BUYER_%d", order.ID, buyerID),
 			},
 			{
 				Address: COMMISSION_WALLET,
-				Amount:  fmt.Sprintf("%.0f", commissionTON*1e9),
+				Amount:  strconv.FormatInt(commissionNano, 10),
 				Payload: fmt.Sprintf("FEE_%s_BUYER_%d", order.ID, buyerID),
 			},
 		},
@@ -191,7 +201,7 @@ func (tc *TonClient) GetBalance(address string) (float64, error) {
 
 	json.Unmarshal(body, &result)
 
-	return float64(result.Balance) / 1e9, nil // Конвертация из нанотонов
+	return currency.TON.ToFloat(result.Balance), nil // Конвертация из нанотонов
 }
 
 // Валидация TON адреса
@@ -228,7 +238,8 @@ func FormatTON(amount float64) string {
 	return fmt.Sprintf("%.6f", amount)
 }
 
-// Форматирование суммы в нанотонах
+// Форматирование суммы в нанотонах (с округлением; неверная сумма дает "0")
 func FormatNanoTON(amount float64) string {
-	return fmt.Sprintf("%.0f", amount*1e9)
+	nano, _ := currency.TON.FromFloat(amount)
+	return strconv.FormatInt(nano, 10)
 }
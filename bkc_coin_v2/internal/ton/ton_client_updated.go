@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"bkc_coin_v2/internal/currency"
 )
 
 // Обновленный TON клиент с управлением курсами
@@ -73,7 +75,7 @@ func (tc *TonClientUpdated) GetBalance() (float64, error) {
 
 	json.Unmarshal(body, &result)
 
-	return float64(result.Balance) / 1e9, nil // Конвертация из нанотонов
+	return currency.TON.ToFloat(result.Balance), nil // Конвертация из нанотонов
 }
 
 // ValidateTONAddress валидирует TON адрес
@@ -97,7 +99,10 @@ func (tc *TonClientUpdated) ValidateTONAddress(address string) bool {
 // CreateTransaction создает транзакцию для пополнения
 func (tc *TonClientUpdated) CreateTransaction(amountTON float64, userID int64) (map[string]interface{}, error) {
 	// Конвертация в нанотоны
-	amountNano := int64(amountTON * 1e9)
+	amountNano, err := currency.TON.FromFloat(amountTON)
+	if err != nil {
+		return nil, err
+	}
 
 	// Создание payload
 	payload := fmt.Sprintf("BKC_TOPUP_USER_%d_%d", userID, time.Now().Unix())
@@ -0,0 +1,46 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors (SHA-1), truncated to 6 digits.
+func TestCodeAtRFCVectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		got, err := CodeAt(secret, Step(time.Unix(ts, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("t=%d: got %s want %s", ts, got, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	prev, _ := CodeAt(secret, Step(now)-1)
+	if step, ok := Validate(secret, prev, now); !ok || step != Step(now)-1 {
+		t.Fatalf("previous step should be accepted")
+	}
+	old, _ := CodeAt(secret, Step(now)-3)
+	if _, ok := Validate(secret, old, now); ok {
+		t.Fatalf("code three steps old should be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Fatalf("short code should be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+// Package wsauth signs the short-lived tokens that authenticate WebSocket
+// upgrades. Browsers cannot send the initData header on an upgrade, so the
+// webapp trades its initData for a token (POST /api/v1/ws-token) and passes
+// it as ?token= when it connects.
+package wsauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissing = errors.New("ws token missing")
+	ErrInvalid = errors.New("ws token invalid")
+	ErrExpired = errors.New("ws token expired")
+)
+
+// Claims is who the token was issued to.
+type Claims struct {
+	UserID    int64  `json:"uid"`
+	Username  string `json:"un,omitempty"`
+	Lang      string `json:"lang,omitempty"`
+	ExpiresAt int64  `json:"exp"` // unix seconds
+}
+
+// Secret derives the signing key from the bot token when no dedicated
+// secret is configured.
+func Secret(configured, botToken string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+	sum := sha256.Sum256([]byte("ws-token:" + botToken))
+	return sum[:]
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the token for c: base64url(json) "." base64url(HMAC-SHA256).
+func Sign(secret []byte, c Claims) string {
+	raw, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + sign(secret, payload)
+}
+
+// Parse verifies token and returns its claims.
+func Parse(secret []byte, token string, now time.Time) (Claims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(sign(secret, payload))) {
+		return Claims{}, ErrInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	var c Claims
+	if err := json.Unmarshal(raw, &c); err != nil || c.UserID <= 0 {
+		return Claims{}, ErrInvalid
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+	return c, nil
+}
+
+// FromRequest verifies the token of an upgrade request, taken from ?token=
+// or an "Authorization: Bearer" header.
+func FromRequest(secret []byte, r *http.Request, now time.Time) (Claims, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return Claims{}, ErrMissing
+	}
+	return Parse(secret, strings.TrimSpace(token), now)
+}
@@ -0,0 +1,42 @@
+package wsauth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	secret := Secret("", "123:bot")
+	now := time.Unix(1_800_000_000, 0)
+	token := Sign(secret, Claims{UserID: 42, Username: "alice", Lang: "en", ExpiresAt: now.Add(time.Minute).Unix()})
+
+	c, err := Parse(secret, token, now)
+	if err != nil || c.UserID != 42 || c.Username != "alice" || c.Lang != "en" {
+		t.Fatalf("parse: %+v %v", c, err)
+	}
+	if _, err := Parse(secret, token, now.Add(time.Minute)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired token: %v", err)
+	}
+	if _, err := Parse(Secret("", "456:bot"), token, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("other secret: %v", err)
+	}
+	forged := Sign([]byte("guess"), Claims{UserID: 1, ExpiresAt: now.Add(time.Hour).Unix()})
+	if _, err := Parse(secret, forged, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("forged token: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/ws/chart?token="+token, nil)
+	if c, err := FromRequest(secret, r, now); err != nil || c.UserID != 42 {
+		t.Fatalf("query token: %+v %v", c, err)
+	}
+	r = httptest.NewRequest("GET", "/ws/chart", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := FromRequest(secret, r, now); err != nil {
+		t.Fatalf("bearer token: %v", err)
+	}
+	if _, err := FromRequest(secret, httptest.NewRequest("GET", "/ws/chart", nil), now); !errors.Is(err, ErrMissing) {
+		t.Fatalf("no token: %v", err)
+	}
+}